@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/fritzpay/paymentd/pkg/apidef"
+)
+
+func main() {
+	var lang, out string
+	flag.StringVar(&lang, "lang", "", "client language ("+strings.Join(apidef.Languages(), ", ")+")")
+	flag.StringVar(&out, "o", "", "output file name")
+	flag.Parse()
+
+	buf := &bytes.Buffer{}
+	err := apidef.Generate(buf, apidef.PaymentAPI, lang)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error generating client: %v\n", err)
+		os.Exit(1)
+	}
+	if out == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+	err = ioutil.WriteFile(out, buf.Bytes(), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %v\n", out, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,36 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+The apidefgen command generates client libraries from the payment API
+definition in the package apidef.
+
+Usage:
+
+	apidefgen -lang go|php|js [-o file]
+
+	Flags understood by apidefgen:
+	  -lang       The language of the generated client.
+	  -o          The output file name. If omitted, the client is written to
+	              stdout.
+
+	Example:
+	  apidefgen -lang go -o pkg/client/client.go
+
+The generated clients in pkg/client and resources/client are kept up to date
+with go generate ./pkg/apidef.
+*/
+package main
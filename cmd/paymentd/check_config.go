@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/job"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/archive"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+	"golang.org/x/net/context"
+)
+
+const (
+	cmdCheckConfig = "check-config"
+)
+
+// configCheck reports the results of the config checks
+type configCheck struct {
+	w        io.Writer
+	problems int
+}
+
+func (c *configCheck) ok(subject string) {
+	fmt.Fprintf(c.w, "ok    %s\n", subject)
+}
+
+func (c *configCheck) fail(subject string, format string, args ...interface{}) {
+	c.problems++
+	fmt.Fprintf(c.w, "FAIL  %s: %s\n", subject, fmt.Sprintf(format, args...))
+}
+
+func (c *configCheck) check(subject string, err error) bool {
+	if err != nil {
+		c.fail(subject, "%v", err)
+		return false
+	}
+	c.ok(subject)
+	return true
+}
+
+// checkConfig validates the config file and the environment the daemon will
+// run in
+//
+// It writes the result of every check to w and returns the number of problems
+// found. Checks which depend on failed checks will be skipped.
+func checkConfig(w io.Writer) int {
+	c := &configCheck{w: w}
+
+	cfg = config.DefaultConfig()
+	if cfgFileName == "" {
+		cfgFileName = os.Getenv(envVarConfigFileName)
+	}
+	if cfgFileName == "" {
+		c.ok("no config file given. checking default config")
+	} else if !c.check("config file "+cfgFileName, readConfigFile(cfgFileName, &cfg)) {
+		return c.problems
+	}
+
+	c.check("Payment.PaymentIDEncPrime", checkIDEncoder(cfg))
+	serviceCtx, err := service.NewContext(context.Background(), cfg, log)
+	if !c.check("service context", err) {
+		return c.problems
+	}
+	_, err = paymentService.NewService(serviceCtx)
+	c.check("payment service", err)
+
+	if cfg.API.Active {
+		c.check("API.Timeout", checkDuration(cfg.API.Timeout))
+	}
+	if cfg.Web.Active {
+		c.check("Web.Timeout", checkDuration(cfg.Web.Timeout))
+		c.check("Web.TemplateDir", checkDir(cfg.Web.TemplateDir))
+		c.check("Web.PubWWWDir", checkDir(cfg.Web.PubWWWDir))
+		if cfg.Web.TLS.CertFile != "" {
+			_, err = tls.LoadX509KeyPair(cfg.Web.TLS.CertFile, cfg.Web.TLS.KeyFile)
+			c.check("Web.TLS", err)
+		}
+	}
+
+	if cfg.Jobs.Active {
+		if cfg.Jobs.Workers < 1 {
+			c.fail("Jobs.Workers", "at least 1 worker required")
+		}
+		for name, spec := range cfg.Jobs.Schedules {
+			if spec == "" {
+				continue
+			}
+			_, err = job.ParseSchedule(spec)
+			c.check("Jobs.Schedules."+name, err)
+		}
+	}
+
+	if cfg.Archive.Target != "" {
+		_, err = archive.NewArchiver(serviceCtx)
+		c.check("Archive", err)
+	}
+
+	if !c.check("database config", connectDB(serviceCtx)) {
+		return c.problems
+	}
+	dbOK := c.check("Database.Principal.Write", serviceCtx.PrincipalDB().Ping())
+	if cfg.Database.Principal.ReadOnly != nil {
+		dbOK = c.check("Database.Principal.ReadOnly", serviceCtx.PrincipalDB(service.ReadOnly).Ping()) && dbOK
+	}
+	dbOK = c.check("Database.Payment.Write", serviceCtx.PaymentDB().Ping()) && dbOK
+	if cfg.Database.Payment.ReadOnly != nil {
+		dbOK = c.check("Database.Payment.ReadOnly", serviceCtx.PaymentDB(service.ReadOnly).Ping()) && dbOK
+	}
+	for _, sh := range serviceCtx.PaymentShardDBs()[1:] {
+		dbOK = c.check("Database.Payment.Shards."+sh.Shard, sh.DB.Ping()) && dbOK
+	}
+	if !dbOK {
+		return c.problems
+	}
+	if cfg.Database.Payment.Write.Type() == "mysql" {
+		c.check("Database.Payment indexes", checkIndexes(serviceCtx.PaymentDB()))
+	}
+
+	providerService, err := provider.NewService(serviceCtx)
+	if !c.check("provider service", err) {
+		return c.problems
+	}
+	problems, err := providerService.CheckConfig()
+	if !c.check("provider configs readable", err) {
+		return c.problems
+	}
+	for _, p := range problems {
+		c.fail("provider config", "%v", p)
+	}
+	if len(problems) == 0 {
+		c.ok("provider configs")
+	}
+	return c.problems
+}
+
+func readConfigFile(fileName string, cfg *config.Config) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = cfg.ReadConfig(f)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return nil
+}
+
+func checkIDEncoder(cfg config.Config) error {
+	_, err := payment.NewIDEncoder(cfg.Payment.PaymentIDEncPrime, cfg.Payment.PaymentIDEncXOR)
+	if err != nil {
+		return fmt.Errorf("%d cannot be used to encode payment IDs. use an odd prime, e.g. 982450871", cfg.Payment.PaymentIDEncPrime)
+	}
+	return nil
+}
+
+func checkDuration(d config.Duration) error {
+	_, err := d.Duration()
+	if err != nil {
+		return fmt.Errorf("invalid duration %q. use e.g. \"5s\"", string(d))
+	}
+	return nil
+}
+
+func checkDir(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("not set")
+	}
+	inf, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !inf.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
@@ -18,14 +18,21 @@
 The paymentd daemon serves payment related services for the FritzPay stack.
 
 Usage:
-  paymentd
+  paymentd [command]
 
   Flags understood by paymentd:
     -c          Path to config file name.
                 Alternatively the environment var $PAYMENTDCFG can be used to set
                 the configuration file name.
 
+  Commands:
+    check-config
+                Validate the config file, the database connections, the template
+                directories and the configs of the providers used by active payment
+                methods. Exits with status 1 if problems were found.
+
   Example:
     paymentd -c /etc/paymentd/paymentd.config.json
+    paymentd -c /etc/paymentd/paymentd.config.json check-config
 */
 package main
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/fritzpay/paymentd/pkg/sqltrace"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// paymentIndexes are the indexes of the payment database the listing and
+// search queries rely on
+//
+// They are part of the schema in resources/mysql/paymentd.sql. Databases
+// created from older schemas should be migrated accordingly.
+var paymentIndexes = []sqltrace.Index{
+	{Table: "payment", Name: "ident", Columns: []string{"project_id", "ident"}},
+	{Table: "payment", Name: "payment_id", Columns: []string{"project_id", "id"}},
+	{Table: "payment", Name: "project_created", Columns: []string{"project_id", "created"}},
+	{Table: "payment_metadata", Name: "timestamp", Columns: []string{"project_id", "payment_id", "timestamp"}},
+	{Table: "payment_metadata", Name: "value_fulltext", Columns: []string{"value"}},
+	{Table: "payment_note", Name: "note_fulltext", Columns: []string{"note"}},
+	{Table: "payment_change", Name: "project_sequence", Columns: []string{"project_id", "sequence"}},
+	{Table: "payment_review", Name: "status", Columns: []string{"project_id", "status"}},
+	{Table: "payment_overview", Name: "project_status", Columns: []string{"project_id", "status", "payment_id"}},
+	{Table: "payment_overview", Name: "project_created", Columns: []string{"project_id", "created"}},
+	{Table: "notification_outbox", Name: "payment_transaction", Columns: []string{"project_id", "payment_id", "transaction_timestamp"}},
+	{Table: "notification_outbox_status", Name: "status", Columns: []string{"status", "next_attempt"}},
+}
+
+// checkIndexes returns an error listing the indexes missing in the payment
+// database
+func checkIndexes(db *sql.DB) error {
+	missing, err := sqltrace.MissingIndexes(db, paymentIndexes)
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	names := make([]string, len(missing))
+	for i, idx := range missing {
+		names[i] = idx.String()
+	}
+	return fmt.Errorf("missing indexes %s", strings.Join(names, ", "))
+}
+
+// adviseIndexes logs the indexes missing in the payment database
+func adviseIndexes(db *sql.DB) {
+	missing, err := sqltrace.MissingIndexes(db, paymentIndexes)
+	if err != nil {
+		log.Error("error verifying indexes", log15.Ctx{"err": err})
+		return
+	}
+	for _, idx := range missing {
+		log.Warn("missing index", log15.Ctx{"index": idx.String()})
+	}
+}
@@ -4,14 +4,21 @@ import (
 	"database/sql"
 	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"time"
 
+	"github.com/fritzpay/paymentd/pkg/chaos"
 	"github.com/fritzpay/paymentd/pkg/config"
 	"github.com/fritzpay/paymentd/pkg/env"
 	"github.com/fritzpay/paymentd/pkg/server"
 	"github.com/fritzpay/paymentd/pkg/service"
 	"github.com/fritzpay/paymentd/pkg/service/api"
+	"github.com/fritzpay/paymentd/pkg/service/archive"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"github.com/fritzpay/paymentd/pkg/service/web"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"github.com/fritzpay/paymentd/pkg/sqltrace"
 	_ "github.com/go-sql-driver/mysql"
 	"golang.org/x/net/context"
 	"gopkg.in/inconshreveable/log15.v2"
@@ -54,6 +61,17 @@ func main() {
 		"AppVersion": AppVersion,
 		"PID":        os.Getpid(),
 	})
+
+	if flag.Arg(0) == cmdCheckConfig {
+		problems := checkConfig(os.Stdout)
+		if problems > 0 {
+			fmt.Printf("%d problem(s) found\n", problems)
+			os.Exit(1)
+		}
+		fmt.Println("config OK")
+		return
+	}
+
 	log.Info("starting daemon...")
 
 	log.Info("loading config...")
@@ -75,6 +93,9 @@ func main() {
 		log.Info("exiting...")
 		os.Exit(1)
 	}
+	if cfg.Chaos.Enabled {
+		log.Warn("chaos mode enabled. faults will be injected")
+	}
 	// database
 	log.Info("connecting databases...")
 	err = connectDB(serviceCtx)
@@ -83,6 +104,13 @@ func main() {
 		log.Info("exiting...")
 		os.Exit(1)
 	}
+	if cfg.Database.IndexAdvisor {
+		log.Warn("index advisor enabled. queries will be explained")
+		adviseIndexes(serviceCtx.PaymentDB())
+	}
+	serviceCtx.DBMonitor().Start()
+	serviceCtx.Usage().Start()
+	serviceCtx.WriteBatcher().Start()
 
 	log.Info("setting payment defaults...")
 	err = setDefaults(serviceCtx)
@@ -116,7 +144,17 @@ func main() {
 			log.Info("exiting...")
 			os.Exit(1)
 		}
-		err = srv.RegisterService(cfg.Web.Service, webHandler)
+		if cfg.Web.TLS.CertFile != "" {
+			tlsConfig, err := webHandler.TLSConfig()
+			if err != nil {
+				log.Crit("error initializing Web service TLS", log15.Ctx{"err": err})
+				log.Info("exiting...")
+				os.Exit(1)
+			}
+			err = srv.RegisterTLSService(cfg.Web.Service, webHandler, tlsConfig)
+		} else {
+			err = srv.RegisterService(cfg.Web.Service, webHandler)
+		}
 		if err != nil {
 			log.Crit("error registering Web service", log15.Ctx{"err": err})
 			log.Info("exiting...")
@@ -124,6 +162,19 @@ func main() {
 		}
 	}
 
+	if cfg.Jobs.Active {
+		log.Info("starting background jobs...")
+		err = startJobs(serviceCtx)
+		if err != nil {
+			log.Crit("error starting background jobs", log15.Ctx{"err": err})
+			log.Info("exiting...")
+			os.Exit(1)
+		}
+	}
+
+	log.Info("warming cache...")
+	go serviceCtx.CacheWarmer().Warm()
+
 	log.Info("serving...")
 	err = srv.Serve()
 	if err != nil {
@@ -133,6 +184,34 @@ func main() {
 	}
 }
 
+// startJobs registers the background jobs of the services and starts the job
+// runner
+func startJobs(ctx *service.Context) error {
+	paymentSvc, err := paymentService.NewService(ctx)
+	if err != nil {
+		return err
+	}
+	err = paymentSvc.RegisterJobs(ctx.Jobs())
+	if err != nil {
+		return err
+	}
+	err = ctx.Usage().RegisterJobs(ctx.Jobs())
+	if err != nil {
+		return err
+	}
+	archiver, err := archive.NewArchiver(ctx)
+	if err != nil {
+		return err
+	}
+	if archiver != nil {
+		err = archiver.RegisterJobs(ctx.Jobs())
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Jobs().Start(cfg.Jobs.Workers)
+}
+
 func loadConfig() {
 	cfg = config.DefaultConfig()
 	if cfgFileName == "" && os.Getenv(envVarConfigFileName) != "" {
@@ -167,11 +246,85 @@ func loadConfig() {
 	}
 }
 
+// openDB opens the configured database
+//
+// If a slow query threshold is configured or the index advisor is enabled, the
+// connection will use an instrumented driver reporting to the query stats of
+// the context. In chaos mode, the driver will inject the configured database
+// faults. Only MySQL databases are accepted, see sqldialect.ByName.
+func openDB(ctx *service.Context, dbCfg config.DatabaseConfig) (*sql.DB, error) {
+	dialect, err := sqldialect.ByName(dbCfg.Type())
+	if err != nil {
+		return nil, err
+	}
+	if dialect != sqldialect.Default() {
+		return nil, fmt.Errorf("database type %s differs from %s, all databases must be of the same type", dialect.Name(), sqldialect.Default().Name())
+	}
+	var threshold time.Duration
+	if cfg.Database.SlowQueryThreshold != "" {
+		threshold, err = cfg.Database.SlowQueryThreshold.Duration()
+		if err != nil {
+			return nil, fmt.Errorf("invalid slow query threshold: %v", err)
+		}
+	}
+	faults := ctx.Chaos().Database
+	advise := cfg.Database.IndexAdvisor && dialect == sqldialect.MySQL
+	if threshold <= 0 && faults == nil && !advise {
+		return sql.Open(dbCfg.Type(), dbCfg.DSN())
+	}
+	driverName := dbCfg.Type()
+	if threshold > 0 || advise {
+		driverName += "-sqltrace"
+	}
+	if faults != nil {
+		driverName += "-chaos"
+	}
+	registered := false
+	for _, d := range sql.Drivers() {
+		if d == driverName {
+			registered = true
+		}
+	}
+	if !registered {
+		// sql.Open does not connect, it is only used to look up the driver
+		parent, err := sql.Open(dbCfg.Type(), "")
+		if err != nil {
+			return nil, err
+		}
+		dr := parent.Driver()
+		parent.Close()
+		// inject below the instrumentation, so injected faults will be counted
+		dr = chaos.Wrap(dr, faults, dbFault(dialect))
+		if threshold > 0 || advise {
+			trace := sqltrace.Wrap(dr, threshold, ctx.Log(), ctx.QueryStats())
+			if advise {
+				trace.EnableAdvisor()
+			}
+			dr = trace
+		}
+		sql.Register(driverName, dr)
+	}
+	return sql.Open(driverName, dbCfg.DSN())
+}
+
+// dbFault returns the error of failed operations in chaos mode
+//
+// Operations fail with a deadlock of the database, which lets transactions be
+// retried.
+func dbFault(d sqldialect.Dialect) error {
+	return d.DeadlockError("Deadlock found when trying to get lock; injected by chaos mode")
+}
+
 func connectDB(ctx *service.Context) error {
 	if cfg.Database.Principal.Write == nil {
 		return errors.New("principal write DB config error")
 	}
-	principalDBW, err := sql.Open(cfg.Database.Principal.Write.Type(), cfg.Database.Principal.Write.DSN())
+	dialect, err := sqldialect.ByName(cfg.Database.Principal.Write.Type())
+	if err != nil {
+		return err
+	}
+	sqldialect.SetDefault(dialect)
+	principalDBW, err := openDB(ctx, cfg.Database.Principal.Write)
 	if err != nil {
 		return err
 	}
@@ -179,7 +332,7 @@ func connectDB(ctx *service.Context) error {
 	principalDBW.SetMaxIdleConns(cfg.Database.MaxIdleConns)
 	var principalDBRO *sql.DB
 	if cfg.Database.Principal.ReadOnly != nil {
-		principalDBRO, err = sql.Open(cfg.Database.Principal.ReadOnly.Type(), cfg.Database.Principal.ReadOnly.DSN())
+		principalDBRO, err = openDB(ctx, cfg.Database.Principal.ReadOnly)
 		if err != nil {
 			return err
 		}
@@ -191,7 +344,7 @@ func connectDB(ctx *service.Context) error {
 	if cfg.Database.Payment.Write == nil {
 		return errors.New("payment write DB config error")
 	}
-	paymentDBW, err := sql.Open(cfg.Database.Payment.Write.Type(), cfg.Database.Payment.Write.DSN())
+	paymentDBW, err := openDB(ctx, cfg.Database.Payment.Write)
 	if err != nil {
 		return err
 	}
@@ -199,7 +352,7 @@ func connectDB(ctx *service.Context) error {
 	paymentDBW.SetMaxIdleConns(cfg.Database.MaxIdleConns)
 	var paymentDBRO *sql.DB
 	if cfg.Database.Payment.ReadOnly != nil {
-		paymentDBRO, err = sql.Open(cfg.Database.Payment.ReadOnly.Type(), cfg.Database.Payment.ReadOnly.DSN())
+		paymentDBRO, err = openDB(ctx, cfg.Database.Payment.ReadOnly)
 		if err != nil {
 			return err
 		}
@@ -208,5 +361,24 @@ func connectDB(ctx *service.Context) error {
 	}
 	ctx.SetPaymentDB(paymentDBW, paymentDBRO)
 
+	for _, shard := range cfg.Database.Payment.Shards {
+		shardDBW, err := openDB(ctx, shard.Write)
+		if err != nil {
+			return fmt.Errorf("error connecting shard %s: %v", shard.Name, err)
+		}
+		shardDBW.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+		shardDBW.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+		var shardDBRO *sql.DB
+		if shard.ReadOnly != nil {
+			shardDBRO, err = openDB(ctx, shard.ReadOnly)
+			if err != nil {
+				return fmt.Errorf("error connecting shard %s: %v", shard.Name, err)
+			}
+			shardDBRO.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+			shardDBRO.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+		}
+		ctx.SetPaymentShardDB(shard.Name, shardDBW, shardDBRO)
+	}
+
 	return nil
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	_ "github.com/go-sql-driver/mysql"
+	"golang.org/x/net/context"
+)
+
+const auditCommandDescription = `This command allows you to audit the payment event log. The event log
+is written if Payment.EventLog is enabled in the configuration.`
+
+var auditCommand = cli.Command{
+	Name:        "audit",
+	ShortName:   "a",
+	Usage:       "Audit related tools.",
+	Description: auditCommandDescription,
+	Subcommands: []cli.Command{
+		verifyChainCommand,
+	},
+}
+
+var verifyChainCommand = cli.Command{
+	Name:      "verify",
+	ShortName: "v",
+	Usage:     "Verify the integrity of the event chains. Exits with status 1 on violations.",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "project, p",
+			Usage: "ID of the project to verify. All projects will be verified if omitted.",
+		},
+	},
+	Action: verifyChainAction,
+}
+
+func verifyChainAction(c *cli.Context) {
+	if !readConfig(c) {
+		return
+	}
+	principalDB, paymentDB, err := openDBs(0)
+	if err != nil {
+		fmt.Printf("error opening databases: %v\n", err)
+		return
+	}
+	defer principalDB.Close()
+	defer paymentDB.Close()
+
+	valid := true
+	for _, shard := range paymentShards() {
+		var projectIDs []int64
+		if c.Int("project") != 0 {
+			if projectShard(int64(c.Int("project"))) != shard {
+				continue
+			}
+			projectIDs = []int64{int64(c.Int("project"))}
+		}
+		db := paymentDB
+		if shard != service.DefaultShard {
+			db, err = openShardDB(shard)
+			if err != nil {
+				fmt.Printf("error opening shard %s: %v\n", shard, err)
+				return
+			}
+			defer db.Close()
+		}
+		if projectIDs == nil {
+			projectIDs, err = payment.EventProjectIDsDB(context.Background(), db)
+			if err != nil {
+				fmt.Printf("error retrieving projects of shard %s: %v\n", shard, err)
+				return
+			}
+		}
+		for _, projectID := range projectIDs {
+			v, err := payment.VerifyEventChainDB(context.Background(), db, projectID)
+			if err != nil {
+				fmt.Printf("error verifying event chain of project %d: %v\n", projectID, err)
+				return
+			}
+			if v.Valid() {
+				fmt.Printf("project %d: %d events verified. head %s\n", projectID, v.Events, v.Head())
+				continue
+			}
+			valid = false
+			fmt.Printf("project %d: %d events, %d violations\n", projectID, v.Events, len(v.Violations))
+			for _, viol := range v.Violations {
+				fmt.Printf("\tevent %d, payment %d: %s\n", viol.EventID, viol.PaymentID, viol.Reason)
+			}
+		}
+	}
+	if !valid {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/doctor"
+	_ "github.com/go-sql-driver/mysql"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const doctorCommandDescription = `This command examines the payments of a project for inconsistencies,
+e.g. open payments which the provider reported as paid, payment transactions
+for which the project was not notified or expired authorizations.
+
+The doctor only reports the inconsistencies. Repairs are applied by the running
+paymentd through the admin API, so that the intents are executed and the
+project is notified like for any other change of the payments.`
+
+var doctorCommand = cli.Command{
+	Name:        "doctor",
+	ShortName:   "d",
+	Usage:       "Find inconsistent payments. Exits with status 1 if inconsistencies are found.",
+	Description: doctorCommandDescription,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "project, p",
+			Usage: "ID of the project to examine.",
+		},
+		cli.StringFlag{
+			Name:  "since, s",
+			Value: "24h",
+			Usage: "Search missing notifications of the payment transactions in this period.",
+		},
+	},
+	Action: doctorAction,
+}
+
+func doctorAction(c *cli.Context) {
+	if c.Int("project") == 0 {
+		fmt.Println("project required")
+		return
+	}
+	period, err := time.ParseDuration(c.String("since"))
+	if err != nil {
+		fmt.Printf("invalid period %s: %v\n", c.String("since"), err)
+		return
+	}
+	if !readConfig(c) {
+		return
+	}
+	projectID := int64(c.Int("project"))
+	principalDB, paymentDB, err := openDBs(0)
+	if err != nil {
+		fmt.Printf("error opening databases: %v\n", err)
+		return
+	}
+	defer principalDB.Close()
+	defer paymentDB.Close()
+
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	ctx, err := service.NewContext(context.Background(), cfg, log)
+	if err != nil {
+		fmt.Printf("error creating service context: %v\n", err)
+		return
+	}
+	ctx.SetPrincipalDB(principalDB, principalDB)
+	ctx.SetPaymentDB(paymentDB, paymentDB)
+	if shard := projectShard(projectID); shard != service.DefaultShard {
+		shardDB, err := openPaymentDB(projectID)
+		if err != nil {
+			fmt.Printf("error opening shard %s: %v\n", shard, err)
+			return
+		}
+		defer shardDB.Close()
+		ctx.SetPaymentShardDB(shard, shardDB, shardDB)
+	}
+	d, err := doctor.NewDoctor(ctx)
+	if err != nil {
+		fmt.Printf("error creating doctor: %v\n", err)
+		return
+	}
+
+	enc, err := payment.NewIDEncoder(cfg.Payment.PaymentIDEncPrime, cfg.Payment.PaymentIDEncXOR)
+	if err != nil {
+		fmt.Printf("error initializing payment ID encoder: %v\n", err)
+		return
+	}
+
+	findings, err := d.Examine(projectID, time.Now().Add(-period))
+	if err != nil {
+		fmt.Printf("error examining project %d: %v\n", projectID, err)
+		return
+	}
+	fmt.Printf("project %d: %d inconsistencies found\n", projectID, len(findings))
+	for _, f := range findings {
+		fmt.Printf("\tpayment %s (%s), %s: %s\n", f.PaymentID.Encoded(enc), f.Status, f.Check, f.Detail)
+		if f.Repair == "" {
+			fmt.Printf("\t\tno repair available\n")
+			continue
+		}
+		fmt.Printf("\t\trepair with %s: PUT /v1/project/%d/doctor/repair %s\n", f.Repair, projectID, repairBody(f, enc))
+	}
+	if len(findings) > 0 {
+		os.Exit(1)
+	}
+}
+
+// repairBody returns the admin API request body for repairing the finding
+func repairBody(f *doctor.Finding, enc *payment.IDEncoder) string {
+	body := `{"Check":"` + f.Check + `","PaymentId":"` + f.PaymentID.Encoded(enc).String() + `"`
+	if !f.TransactionTimestamp.IsZero() {
+		body += `,"TransactionTimestamp":"` + strconv.FormatInt(f.TransactionTimestamp.UnixNano(), 10) + `"`
+	}
+	return body + "}"
+}
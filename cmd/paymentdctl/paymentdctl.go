@@ -23,6 +23,9 @@ func main() {
 
 	app.Commands = []cli.Command{
 		configCommand,
+		projectCommand,
+		auditCommand,
+		doctorCommand,
 	}
 
 	app.Flags = []cli.Flag{
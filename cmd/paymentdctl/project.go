@@ -0,0 +1,381 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/fritzpay/paymentd/pkg/paymentd/principal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/bundle"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	_ "github.com/go-sql-driver/mysql"
+	"golang.org/x/net/context"
+)
+
+const projectCommandDescription = `This command allows you to export and import project configurations
+as signed bundles. The bundles are signed with the API.BundleKeys of the
+configuration.
+
+Payment methods can be onboarded with method bundles. Method bundles are not
+signed.`
+
+const bundleCreatedBy = "paymentdctl"
+
+var projectCommand = cli.Command{
+	Name:        "project",
+	ShortName:   "p",
+	Usage:       "Project related tools.",
+	Description: projectCommandDescription,
+	Subcommands: []cli.Command{
+		exportProjectCommand,
+		importProjectCommand,
+		onboardProjectCommand,
+	},
+}
+
+var exportProjectCommand = cli.Command{
+	Name:      "export",
+	ShortName: "e",
+	Usage:     "Export the configuration of a project to a signed bundle.",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "project, p",
+			Usage: "ID of the project to export.",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "Output file to write to.",
+		},
+	},
+	Action: exportProjectAction,
+}
+
+var importProjectCommand = cli.Command{
+	Name:      "import",
+	ShortName: "i",
+	Usage:     "Import a signed bundle into a project of the given principal.",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "principal, p",
+			Usage: "Name of the principal.",
+		},
+		cli.StringFlag{
+			Name:  "input, i",
+			Usage: "Bundle file to read from.",
+		},
+	},
+	Action: importProjectAction,
+}
+
+var onboardProjectCommand = cli.Command{
+	Name:      "onboard",
+	ShortName: "o",
+	Usage:     "Apply a method bundle to a project.",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "project, p",
+			Usage: "ID of the project.",
+		},
+		cli.StringFlag{
+			Name:  "input, i",
+			Usage: "Method bundle file to read from.",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run, n",
+			Usage: "Validate the bundle and report the changes without saving them.",
+		},
+	},
+	Action: onboardProjectAction,
+}
+
+// openDBs opens the principal database and the payment database shard of the
+// given project
+func openDBs(projectID int64) (principalDB, paymentDB *sql.DB, err error) {
+	if cfg.Database.Principal.Write == nil || cfg.Database.Payment.Write == nil {
+		return nil, nil, fmt.Errorf("write DB config error")
+	}
+	dialect, err := sqldialect.ByName(cfg.Database.Principal.Write.Type())
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Database.Payment.Write.Type() != dialect.Name() {
+		return nil, nil, fmt.Errorf("database types differ, all databases must be of the same type")
+	}
+	sqldialect.SetDefault(dialect)
+	principalDB, err = sqldialect.Open(dialect, cfg.Database.Principal.Write.DSN())
+	if err != nil {
+		return nil, nil, err
+	}
+	paymentDB, err = openPaymentDB(projectID)
+	if err != nil {
+		principalDB.Close()
+		return nil, nil, err
+	}
+	return principalDB, paymentDB, nil
+}
+
+// paymentShards returns the names of all shards of the payment database,
+// starting with the default shard
+func paymentShards() []string {
+	shards := []string{service.DefaultShard}
+	for _, sh := range cfg.Database.Payment.Shards {
+		shards = append(shards, sh.Name)
+	}
+	return shards
+}
+
+// projectShard returns the name of the payment database shard of the given
+// project
+func projectShard(projectID int64) string {
+	for _, sh := range cfg.Database.Payment.Shards {
+		for _, id := range sh.Projects {
+			if id == projectID {
+				return sh.Name
+			}
+		}
+	}
+	return service.DefaultShard
+}
+
+// openShardDB opens the named shard of the payment database
+func openShardDB(shard string) (*sql.DB, error) {
+	dbCfg := cfg.Database.Payment.Write
+	for _, sh := range cfg.Database.Payment.Shards {
+		if sh.Name == shard {
+			dbCfg = sh.Write
+		}
+	}
+	if dbCfg == nil {
+		return nil, fmt.Errorf("write DB config error")
+	}
+	dialect, err := sqldialect.ByName(dbCfg.Type())
+	if err != nil {
+		return nil, err
+	}
+	return sqldialect.Open(dialect, dbCfg.DSN())
+}
+
+// openPaymentDB opens the payment database shard of the given project
+func openPaymentDB(projectID int64) (*sql.DB, error) {
+	return openShardDB(projectShard(projectID))
+}
+
+func exportProjectAction(c *cli.Context) {
+	projectID := c.Int("project")
+	fileName := c.String("output")
+	if projectID == 0 || fileName == "" {
+		fmt.Print("project ID and output file name required\n\n")
+		cli.ShowCommandHelp(c, "e")
+		return
+	}
+	if !readConfig(c) {
+		return
+	}
+	keys, err := bundle.DecodeKeys(cfg.API.BundleKeys)
+	if err != nil || len(keys) == 0 {
+		fmt.Printf("no valid bundle key configured: %v\n", err)
+		return
+	}
+	principalDB, paymentDB, err := openDBs(int64(projectID))
+	if err != nil {
+		fmt.Printf("error opening databases: %v\n", err)
+		return
+	}
+	defer principalDB.Close()
+	defer paymentDB.Close()
+
+	b, err := bundle.Export(context.Background(), principalDB, paymentDB, int64(projectID), bundleCreatedBy)
+	if err != nil {
+		fmt.Printf("error exporting project %d: %v\n", projectID, err)
+		return
+	}
+	err = b.Sign(keys[0])
+	if err != nil {
+		fmt.Printf("error signing bundle: %v\n", err)
+		return
+	}
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		fmt.Printf("error opening file %s for writing: %v\n", fileName, err)
+		return
+	}
+	defer f.Close()
+	enc, err := json.MarshalIndent(b, "", "\t")
+	if err != nil {
+		fmt.Printf("error encoding bundle: %v\n", err)
+		return
+	}
+	_, err = f.Write(enc)
+	if err != nil {
+		fmt.Printf("error writing file %s: %v\n", fileName, err)
+		return
+	}
+	fmt.Printf("project %s written to %s.\n", b.Project.Name, fileName)
+}
+
+func importProjectAction(c *cli.Context) {
+	principalName := c.String("principal")
+	fileName := c.String("input")
+	if principalName == "" || fileName == "" {
+		fmt.Print("principal name and input file name required\n\n")
+		cli.ShowCommandHelp(c, "i")
+		return
+	}
+	if !readConfig(c) {
+		return
+	}
+	keys, err := bundle.DecodeKeys(cfg.API.BundleKeys)
+	if err != nil {
+		fmt.Printf("invalid bundle keys: %v\n", err)
+		return
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		fmt.Printf("error opening file %s: %v\n", fileName, err)
+		return
+	}
+	b := &bundle.Bundle{}
+	err = json.NewDecoder(f).Decode(b)
+	f.Close()
+	if err != nil {
+		fmt.Printf("error reading bundle %s: %v\n", fileName, err)
+		return
+	}
+	err = b.Verify(keys)
+	if err != nil {
+		fmt.Printf("error verifying bundle: %v\n", err)
+		return
+	}
+	principalDB, paymentDB, err := openDBs(0)
+	if err != nil {
+		fmt.Printf("error opening databases: %v\n", err)
+		return
+	}
+	defer principalDB.Close()
+	defer paymentDB.Close()
+
+	res, err := importBundle(principalDB, principalName, b)
+	if err != nil {
+		fmt.Printf("error importing bundle: %v\n", err)
+		return
+	}
+	for _, w := range res.Warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+	fmt.Printf("project %s imported with ID %d.\n", res.Project.Name, res.Project.ID)
+}
+
+// importBundle imports the project of the bundle and its payment methods, which
+// are saved in the payment database shard of the imported project
+func importBundle(principalDB *sql.DB, principalName string, b *bundle.Bundle) (*bundle.Result, error) {
+	tx, err := principalDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	pr, err := principal.PrincipalByNameTx(tx, principalName)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	res, err := bundle.ImportProject(context.Background(), tx, pr.ID, b, bundleCreatedBy, cfg.Web.TLS.DomainCertDir)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	paymentDB, err := openPaymentDB(res.Project.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer paymentDB.Close()
+	tx, err = paymentDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	err = bundle.ImportMethods(context.Background(), tx, res, b, bundleCreatedBy)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func onboardProjectAction(c *cli.Context) {
+	projectID := c.Int("project")
+	fileName := c.String("input")
+	if projectID == 0 || fileName == "" {
+		fmt.Print("project ID and input file name required\n\n")
+		cli.ShowCommandHelp(c, "o")
+		return
+	}
+	if !readConfig(c) {
+		return
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		fmt.Printf("error opening file %s: %v\n", fileName, err)
+		return
+	}
+	b := &bundle.MethodBundle{}
+	err = json.NewDecoder(f).Decode(b)
+	f.Close()
+	if err != nil {
+		fmt.Printf("error reading method bundle %s: %v\n", fileName, err)
+		return
+	}
+	principalDB, paymentDB, err := openDBs(int64(projectID))
+	if err != nil {
+		fmt.Printf("error opening databases: %v\n", err)
+		return
+	}
+	defer principalDB.Close()
+	defer paymentDB.Close()
+
+	_, err = project.ProjectByIDDB(context.Background(), principalDB, int64(projectID))
+	if err != nil {
+		fmt.Printf("error retrieving project %d: %v\n", projectID, err)
+		return
+	}
+	tx, err := paymentDB.Begin()
+	if err != nil {
+		fmt.Printf("error on begin: %v\n", err)
+		return
+	}
+	res, err := bundle.ApplyMethod(context.Background(), tx, int64(projectID), b, bundleCreatedBy)
+	if err != nil {
+		tx.Rollback()
+		fmt.Printf("error applying method bundle: %v\n", err)
+		return
+	}
+	if c.Bool("dry-run") {
+		err = tx.Rollback()
+	} else {
+		err = tx.Commit()
+	}
+	if err != nil {
+		fmt.Printf("error on commit: %v\n", err)
+		return
+	}
+	for _, ch := range res.Changes {
+		fmt.Printf("changed: %s\n", ch)
+	}
+	if len(res.Changes) == 0 {
+		fmt.Print("no changes.\n")
+	}
+	if c.Bool("dry-run") {
+		fmt.Printf("payment method %s/%s validated, nothing saved.\n", b.Provider, b.MethodKey)
+		return
+	}
+	fmt.Printf("payment method %s/%s applied with ID %d.\n", b.Provider, b.MethodKey, res.Method.ID)
+}
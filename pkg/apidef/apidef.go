@@ -0,0 +1,210 @@
+package apidef
+
+import (
+	"sort"
+	"strings"
+)
+
+// Type is the type of a message field
+type Type string
+
+// Field types
+const (
+	// String fields are JSON strings
+	String Type = "string"
+	// Int fields are integers, which are serialized as JSON strings
+	Int Type = "int"
+	// Map fields are JSON objects with string values
+	Map Type = "map"
+	// Object fields are JSON objects with the fields given in Field.Fields
+	Object Type = "object"
+	// List fields are JSON arrays of the signed messages given in
+	// Field.Message. Each message is verified with its own signature
+	List Type = "list"
+)
+
+// Field is a field of a message
+type Field struct {
+	// Name is the JSON member name, which is also used as the field name in
+	// generated code
+	Name string
+	Type Type
+	// Optional fields will be omitted if empty
+	Optional bool
+	// Fields are the fields of Object fields
+	Fields []Field
+	// Message is the item message of List fields
+	Message *Message
+	Doc     string
+}
+
+// SignaturePart is a part of a signature base string
+type SignaturePart struct {
+	// Field is the (dot-separated) path of the field, e.g. "Confirmation.Ident"
+	Field string
+	// If is the path of a field, which must not be empty for this part to be
+	// written. If set to the path of the field itself, the part is written only
+	// if the field is not empty.
+	If string
+}
+
+// Message is a signed message of the API
+type Message struct {
+	Name   string
+	Doc    string
+	Fields []Field
+	// Signature is the composition of the signature base string. Map fields
+	// are written as the concatenation of their keys and values, sorted by key.
+	// List fields are written as the concatenation of the signatures of their
+	// items.
+	Signature []SignaturePart
+}
+
+// Endpoint is an endpoint of the API
+type Endpoint struct {
+	Name   string
+	Doc    string
+	Method string
+	// Path is the path relative to the API base URL. Path parameters are
+	// given as {FieldName}. Request fields of GET requests which are not path
+	// parameters are sent as query parameters.
+	Path     string
+	Request  *Message
+	Response *Message
+}
+
+// PathParams returns the names of the path parameters of the endpoint
+func (e *Endpoint) PathParams() []string {
+	params := make([]string, 0)
+	for _, part := range strings.Split(e.Path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			params = append(params, part[1:len(part)-1])
+		}
+	}
+	return params
+}
+
+// API is the definition of an API
+type API struct {
+	Name      string
+	Version   string
+	Endpoints []*Endpoint
+	// Notifications are messages sent by paymentd to connected systems
+	Notifications []*Message
+}
+
+// Messages returns all messages of the API, in the order of their definition
+func (a *API) Messages() []*Message {
+	msgs := make([]*Message, 0, len(a.Endpoints)*2+len(a.Notifications))
+	seen := make(map[*Message]bool)
+	add := func(m *Message) {
+		if m != nil && !seen[m] {
+			seen[m] = true
+			msgs = append(msgs, m)
+		}
+	}
+	for _, e := range a.Endpoints {
+		add(e.Request)
+		add(e.Response)
+		for _, f := range e.Response.Fields {
+			if f.Type == List {
+				add(f.Message)
+			}
+		}
+	}
+	for _, m := range a.Notifications {
+		add(m)
+	}
+	return msgs
+}
+
+// FieldByPath returns the field with the given (dot-separated) path
+func (m *Message) FieldByPath(path string) (Field, bool) {
+	fields := m.Fields
+	parts := strings.Split(path, ".")
+	for i, name := range parts {
+		var found bool
+		for _, f := range fields {
+			if f.Name != name {
+				continue
+			}
+			if i == len(parts)-1 {
+				return f, true
+			}
+			fields, found = f.Fields, true
+			break
+		}
+		if !found {
+			return Field{}, false
+		}
+	}
+	return Field{}, false
+}
+
+// SignatureBase returns the signature base string of the given JSON document
+// of the message
+//
+// The document is expected to be decoded into generic values, i.e. objects
+// decoded as map[string]interface{}.
+func (m *Message) SignatureBase(doc map[string]interface{}) string {
+	buf := make([]string, 0, len(m.Signature))
+	for _, part := range m.Signature {
+		if part.If != "" && m.isEmpty(part.If, lookup(doc, part.If)) {
+			continue
+		}
+		switch v := lookup(doc, part.Field).(type) {
+		case string:
+			buf = append(buf, v)
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				s, _ := v[k].(string)
+				buf = append(buf, k, s)
+			}
+		case []interface{}:
+			for _, item := range v {
+				obj, _ := item.(map[string]interface{})
+				s, _ := obj["Signature"].(string)
+				buf = append(buf, s)
+			}
+		}
+	}
+	return strings.Join(buf, "")
+}
+
+func lookup(doc map[string]interface{}, path string) interface{} {
+	var v interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[name]
+	}
+	return v
+}
+
+// isEmpty returns true if the value of the field is considered empty
+//
+// Int fields with a value of 0 are empty.
+func (m *Message) isEmpty(path string, v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		if f, ok := m.FieldByPath(path); ok && f.Type == Int && t == "0" {
+			return true
+		}
+		return t == ""
+	case map[string]interface{}:
+		return len(t) == 0
+	case []interface{}:
+		return len(t) == 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,254 @@
+package apidef_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/apidef"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service/api/v1"
+	notification "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func genericDoc(v interface{}) map[string]interface{} {
+	b, err := json.Marshal(v)
+	So(err, ShouldBeNil)
+	doc := make(map[string]interface{})
+	So(json.Unmarshal(b, &doc), ShouldBeNil)
+	return doc
+}
+
+func TestSignatureBaseMatchesServer(t *testing.T) {
+	Convey("Given an init payment request", t, func() {
+		raw := `{
+			"ProjectKey": "testkey",
+			"Ident": "order-1",
+			"Amount": "1234",
+			"Subunits": "2",
+			"Currency": "EUR",
+			"Country": "DE",
+			"PaymentMethodId": "3",
+			"Locale": "de_DE",
+			"CallbackURL": "https://example.com/callback",
+			"ReturnURL": "https://example.com/return",
+			"Session": "0a1b2c",
+			"Note": "2 x Widget",
+			"StatementDescriptor": "ACME SHOP",
+			"Metadata": {"b": "2", "a": "1", "B": "3"},
+			"Timestamp": "1418135200",
+			"Nonce": "abc"
+		}`
+		req := &v1.InitPaymentRequest{}
+		So(req.ReadJSON(strings.NewReader(raw)), ShouldBeNil)
+		doc := make(map[string]interface{})
+		So(json.Unmarshal([]byte(raw), &doc), ShouldBeNil)
+
+		Convey("The definition should compute the server's signature base string", func() {
+			msg, err := req.Message()
+			So(err, ShouldBeNil)
+			So(apidef.InitPaymentRequest.SignatureBase(doc), ShouldEqual, string(msg))
+		})
+
+		Convey("When optional fields are omitted", func() {
+			delete(doc, "PaymentMethodId")
+			delete(doc, "Locale")
+			req.PaymentMethodID = 0
+			req.Locale = ""
+
+			Convey("The signature base strings should match", func() {
+				msg, err := req.Message()
+				So(err, ShouldBeNil)
+				So(apidef.InitPaymentRequest.SignatureBase(doc), ShouldEqual, string(msg))
+			})
+		})
+	})
+
+	Convey("Given an init payment response", t, func() {
+		resp := &v1.InitPaymentResponse{}
+		resp.Confirmation.Ident = "order-1"
+		resp.Confirmation.Amount = 1234
+		resp.Confirmation.Subunits = 2
+		resp.Confirmation.Currency = "EUR"
+		resp.Confirmation.Country = "DE"
+		resp.Confirmation.Locale = "de_DE"
+		resp.Confirmation.ParentPaymentId = "12345"
+		resp.Confirmation.Relation = "refund"
+		resp.Confirmation.Metadata = map[string]string{"key": "value"}
+		resp.Payment.PaymentId = payment.PaymentID{ProjectID: 1, PaymentID: 2}
+		resp.Payment.Created = time.Unix(1418135200, 0).UTC().Format(time.RFC3339)
+		resp.Payment.Token = "token"
+		resp.Payment.RedirectURL = "https://example.com/redirect"
+		resp.Timestamp = 1418135200
+		resp.Nonce = "abc"
+
+		Convey("The definition should compute the server's signature base string", func() {
+			msg, err := resp.Message()
+			So(err, ShouldBeNil)
+			So(apidef.InitPaymentResponse.SignatureBase(genericDoc(resp)), ShouldEqual, string(msg))
+		})
+	})
+
+	Convey("Given a payment notification", t, func() {
+		parentID := payment.PaymentID{ProjectID: 1, PaymentID: 1}
+		n := &notification.Notification{
+			Version:                 notification.PaymentNotificationVersion,
+			PaymentId:               payment.PaymentID{ProjectID: 1, PaymentID: 2},
+			Ident:                   "order-1",
+			Amount:                  1234,
+			Subunits:                2,
+			DecimalAmount:           "12.34",
+			Currency:                "EUR",
+			Country:                 "DE",
+			PaymentMethodId:         3,
+			ParentPaymentId:         &parentID,
+			Relation:                "refund",
+			AuthorizedAmount:        1500,
+			DecimalAuthorizedAmount: "15.00",
+			Status:                  "paid",
+			TransactionTimestamp:    time.Unix(1418135200, 0).UnixNano(),
+			Metadata:                map[string]string{"key": "value"},
+			Timestamp:               1418135200,
+			Nonce:                   "abc",
+		}
+		So(json.Unmarshal([]byte(`{"EUR":"12.34","USD":"1.00"}`), &n.Balance), ShouldBeNil)
+
+		Convey("The definition should compute the server's signature base string", func() {
+			msg, err := n.Message()
+			So(err, ShouldBeNil)
+			So(apidef.PaymentNotification.SignatureBase(genericDoc(n)), ShouldEqual, string(msg))
+		})
+	})
+
+	Convey("Given an update session request", t, func() {
+		raw := `{
+			"ProjectKey": "testkey",
+			"Token": "0a1b2c",
+			"CartReference": "cart-1",
+			"Amount": "1234",
+			"Subunits": "2",
+			"Currency": "EUR",
+			"Country": "DE",
+			"Customer": {"Email": "customer@example.com"},
+			"Metadata": {"b": "2", "a": "1"},
+			"Timestamp": "1418135200",
+			"Nonce": "abc"
+		}`
+		req := &v1.SessionRequest{}
+		So(req.ReadJSONLimited(strings.NewReader(raw), 0), ShouldBeNil)
+		doc := make(map[string]interface{})
+		So(json.Unmarshal([]byte(raw), &doc), ShouldBeNil)
+
+		Convey("The definition should compute the server's signature base string", func() {
+			msg, err := req.Message()
+			So(err, ShouldBeNil)
+			So(apidef.UpdateSessionRequest.SignatureBase(doc), ShouldEqual, string(msg))
+		})
+
+		Convey("When the session is created", func() {
+			delete(doc, "Token")
+			req.Token = ""
+
+			Convey("The signature base strings should match", func() {
+				msg, err := req.Message()
+				So(err, ShouldBeNil)
+				So(apidef.CreateSessionRequest.SignatureBase(doc), ShouldEqual, string(msg))
+			})
+		})
+	})
+
+	Convey("Given a session response", t, func() {
+		resp := &v1.SessionResponse{}
+		resp.Session.Token = "0a1b2c"
+		resp.Session.Created = time.Unix(1418135200, 0).UTC().Format(time.RFC3339)
+		resp.Session.Expires = time.Unix(1418137000, 0).UTC().Format(time.RFC3339)
+		resp.Session.Amount = 1234
+		resp.Session.Subunits = 2
+		resp.Session.Currency = "EUR"
+		resp.Session.Country = "DE"
+		resp.Session.Locale = "de_DE"
+		resp.Session.Customer = map[string]string{"Email": "customer@example.com"}
+		resp.Session.PaymentId = &payment.PaymentID{ProjectID: 1, PaymentID: 2}
+		resp.Timestamp = 1418135200
+		resp.Nonce = "abc"
+
+		Convey("The definition should compute the server's signature base string", func() {
+			msg, err := resp.Message()
+			So(err, ShouldBeNil)
+			So(apidef.SessionResponse.SignatureBase(genericDoc(resp)), ShouldEqual, string(msg))
+		})
+	})
+
+	Convey("Given a list payments request", t, func() {
+		req := &v1.ListPaymentsRequest{
+			ProjectKey:  "testkey",
+			Currency:    "EUR",
+			CreatedFrom: 1418135200,
+			Limit:       10,
+			Timestamp:   1418135200,
+			Nonce:       "abc",
+		}
+
+		Convey("The definition should compute the server's signature base string", func() {
+			msg, err := req.Message()
+			So(err, ShouldBeNil)
+			So(apidef.ListPaymentsRequest.SignatureBase(genericDoc(req)), ShouldEqual, string(msg))
+		})
+	})
+
+	Convey("Given a payment list response", t, func() {
+		resp := &v1.PaymentListResponse{
+			Payments: []*notification.Notification{
+				{Signature: "0a1b"},
+				{Signature: "2c3d"},
+			},
+			NextCursor: "eyJzIjoiIiwiayI6IjQyIn0=",
+			Timestamp:  1418135200,
+			Nonce:      "abc",
+		}
+
+		Convey("The definition should compute the server's signature base string", func() {
+			msg, err := resp.Message()
+			So(err, ShouldBeNil)
+			So(string(msg), ShouldStartWith, "0a1b2c3d")
+			So(apidef.PaymentListResponse.SignatureBase(genericDoc(resp)), ShouldEqual, string(msg))
+		})
+	})
+
+	Convey("Given an event notification", t, func() {
+		e := notification.NewEvent("funds.matched", 1, map[string]string{"PaymentId": "12345", "Amount": "12.34"})
+		e.Timestamp = 1418135200
+		e.Nonce = "abc"
+
+		Convey("The definition should compute the server's signature base string", func() {
+			msg, err := e.Message()
+			So(err, ShouldBeNil)
+			So(apidef.EventNotification.SignatureBase(genericDoc(e)), ShouldEqual, string(msg))
+		})
+	})
+}
+
+func TestGeneratedClientsUpToDate(t *testing.T) {
+	Convey("Given the in-tree generated clients", t, func() {
+		files := map[string]string{
+			"go":  "../client/client.go",
+			"php": "../../resources/client/php/Client.php",
+			"js":  "../../resources/client/js/paymentd-client.js",
+		}
+		So(len(files), ShouldEqual, len(apidef.Languages()))
+		for lang, fileName := range files {
+			Convey("The "+lang+" client should match the API definition", func() {
+				buf := &bytes.Buffer{}
+				So(apidef.Generate(buf, apidef.PaymentAPI, lang), ShouldBeNil)
+				committed, err := ioutil.ReadFile(fileName)
+				So(err, ShouldBeNil)
+				// run go generate ./pkg/apidef if this fails
+				So(string(committed), ShouldEqual, buf.String())
+			})
+		}
+	})
+}
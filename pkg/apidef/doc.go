@@ -0,0 +1,30 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package apidef provides a machine-readable definition of the payment API
+
+The definitions describe the requests, responses and notifications of the
+payment API, including the composition of their signature base strings. They
+are used to generate client libraries (see cmd/apidefgen) and are tested
+against the server implementation, so that generated clients compute correct
+signatures.
+*/
+package apidef
+
+//go:generate go run ../../cmd/apidefgen/apidefgen.go -lang go -o ../client/client.go
+//go:generate go run ../../cmd/apidefgen/apidefgen.go -lang php -o ../../resources/client/php/Client.php
+//go:generate go run ../../cmd/apidefgen/apidefgen.go -lang js -o ../../resources/client/js/paymentd-client.js
@@ -0,0 +1,120 @@
+package apidef
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GeneratedHeader is the first line of generated files
+const GeneratedHeader = "Code generated by apidefgen from the paymentd API definition. DO NOT EDIT."
+
+// Generator writes client code for the API
+type Generator func(w io.Writer, api *API) error
+
+var generators = map[string]Generator{
+	"go":  GenerateGo,
+	"php": GeneratePHP,
+	"js":  GenerateJS,
+}
+
+// Languages returns the languages for which clients can be generated
+func Languages() []string {
+	langs := make([]string, 0, len(generators))
+	for lang := range generators {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Generate writes the client code in the given language
+func Generate(w io.Writer, api *API, lang string) error {
+	gen, ok := generators[lang]
+	if !ok {
+		return fmt.Errorf("unsupported language %s", lang)
+	}
+	err := api.validate()
+	if err != nil {
+		return err
+	}
+	return gen(w, api)
+}
+
+// validate checks the assumptions of the generators on the definition
+func (a *API) validate() error {
+	for _, e := range a.Endpoints {
+		if e.Request == nil || e.Response == nil {
+			return fmt.Errorf("endpoint %s: request and response required", e.Name)
+		}
+		for _, name := range []string{"ProjectKey", "Timestamp", "Nonce", "Signature"} {
+			if _, ok := e.Request.FieldByPath(name); !ok {
+				return fmt.Errorf("endpoint %s: request without %s", e.Name, name)
+			}
+		}
+		if e.Method == "GET" {
+			for _, f := range e.Request.Fields {
+				if f.Type == Map || f.Type == Object {
+					return fmt.Errorf("endpoint %s: GET request with %s field %s", e.Name, f.Type, f.Name)
+				}
+			}
+		}
+		for _, param := range e.PathParams() {
+			if _, ok := e.Request.FieldByPath(param); !ok {
+				return fmt.Errorf("endpoint %s: unknown path parameter %s", e.Name, param)
+			}
+		}
+	}
+	for _, m := range a.Messages() {
+		if _, ok := m.FieldByPath("Signature"); !ok {
+			return fmt.Errorf("message %s: no signature field", m.Name)
+		}
+		for _, part := range m.Signature {
+			if _, ok := m.FieldByPath(part.Field); !ok {
+				return fmt.Errorf("message %s: unknown signature field %s", m.Name, part.Field)
+			}
+			if _, ok := m.FieldByPath(part.If); part.If != "" && !ok {
+				return fmt.Errorf("message %s: unknown signature condition %s", m.Name, part.If)
+			}
+		}
+	}
+	return nil
+}
+
+// lowerFirst returns the name with a lower case first letter, e.g. for method
+// names in PHP and JavaScript
+func lowerFirst(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// codeWriter writes indented lines of code
+type codeWriter struct {
+	buf    bytes.Buffer
+	indent string
+	depth  int
+}
+
+func (c *codeWriter) line(format string, args ...interface{}) {
+	if format == "" {
+		c.buf.WriteByte('\n')
+		return
+	}
+	c.buf.WriteString(strings.Repeat(c.indent, c.depth))
+	fmt.Fprintf(&c.buf, format, args...)
+	c.buf.WriteByte('\n')
+}
+
+func (c *codeWriter) open(format string, args ...interface{}) {
+	c.line(format, args...)
+	c.depth++
+}
+
+func (c *codeWriter) close(format string, args ...interface{}) {
+	c.depth--
+	c.line(format, args...)
+}
@@ -0,0 +1,318 @@
+package apidef
+
+import (
+	"fmt"
+	"go/format"
+	"io"
+	"strings"
+)
+
+// GenerateGo writes a Go client package for the API
+func GenerateGo(w io.Writer, api *API) error {
+	c := &codeWriter{indent: "\t"}
+	c.line("// " + GeneratedHeader)
+	c.line("")
+	c.line("// Package client is a client of the %s payment API in the version %s", api.Name, api.Version)
+	c.line("package client")
+	c.line("")
+	c.open("import (")
+	for _, imp := range []string{"bytes", "crypto/hmac", "crypto/rand", "crypto/sha256", "encoding/hex", "encoding/json", "errors", "fmt", "net/http", "net/url", "sort", "strconv", "strings", "time"} {
+		c.line("%q", imp)
+	}
+	c.close(")")
+	c.line("")
+	c.line("%s", goRuntime)
+
+	for _, m := range api.Messages() {
+		goMessage(c, m)
+	}
+	for _, e := range api.Endpoints {
+		goEndpoint(c, e)
+	}
+	src, err := format.Source(c.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("error formatting generated code: %v", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+const goRuntime = `// ErrInvalidSignature is returned when a response has an invalid signature
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Client is a client of the payment API
+type Client struct {
+	// URL is the base URL of the API, e.g. https://paymentd.example.com
+	URL        string
+	ProjectKey string
+	// Secret is the binary secret of the project key
+	Secret     []byte
+	HTTPClient *http.Client
+}
+
+// NewClient creates a new client with the given project key and its hex
+// encoded secret
+func NewClient(baseURL, projectKey, hexSecret string) (*Client, error) {
+	secret, err := hex.DecodeString(hexSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %v", err)
+	}
+	return &Client{
+		URL:        strings.TrimRight(baseURL, "/"),
+		ProjectKey: projectKey,
+		Secret:     secret,
+		HTTPClient: http.DefaultClient,
+	}, nil
+}
+
+// Error is an error response of the API
+type Error struct {
+	HTTPStatus int
+	Status     string
+	Info       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("paymentd: %s (HTTP %d): %s", e.Status, e.HTTPStatus, e.Info)
+}
+
+type serviceResponse struct {
+	Status   string
+	Info     string
+	Response json.RawMessage
+}
+
+func (c *Client) do(method, path string, query url.Values, body []byte, v interface{}) error {
+	u := c.URL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	resp := &serviceResponse{}
+	err = json.NewDecoder(res.Body).Decode(resp)
+	if err != nil {
+		return &Error{HTTPStatus: res.StatusCode, Status: "error", Info: err.Error()}
+	}
+	if resp.Status != "success" {
+		return &Error{HTTPStatus: res.StatusCode, Status: resp.Status, Info: resp.Info}
+	}
+	return json.Unmarshal(resp.Response, v)
+}
+
+// newNonce returns a random nonce
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func sign(msg, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(msg)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verify(msg, secret []byte, hexSignature string) bool {
+	sig, err := hex.DecodeString(hexSignature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(msg)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+func writeSortedMap(buf *bytes.Buffer, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteString(k)
+		buf.WriteString(m[k])
+	}
+}
+`
+
+func goFieldType(f Field) string {
+	switch f.Type {
+	case Int:
+		return "int64"
+	case Map:
+		return "map[string]string"
+	case List:
+		return "[]*" + f.Message.Name
+	case Object:
+		c := &codeWriter{indent: "\t", depth: 1}
+		c.line("struct {")
+		for _, sub := range f.Fields {
+			goField(c, sub)
+		}
+		c.line("}")
+		return strings.TrimSpace(c.buf.String())
+	default:
+		return "string"
+	}
+}
+
+func goField(c *codeWriter, f Field) {
+	if f.Doc != "" {
+		c.line("// %s", f.Doc)
+	}
+	opts := make([]string, 0, 2)
+	if f.Type == Int {
+		opts = append(opts, "string")
+	}
+	if f.Optional {
+		opts = append(opts, "omitempty")
+	}
+	if len(opts) > 0 {
+		c.line("%s %s `json:\",%s\"`", f.Name, goFieldType(f), strings.Join(opts, ","))
+	} else {
+		c.line("%s %s", f.Name, goFieldType(f))
+	}
+}
+
+func goMessage(c *codeWriter, m *Message) {
+	c.line("// %s", m.Doc)
+	c.open("type %s struct {", m.Name)
+	for _, f := range m.Fields {
+		goField(c, f)
+	}
+	c.close("}")
+	c.line("")
+	c.line("// Message returns the signature base string")
+	c.open("func (m *%s) Message() []byte {", m.Name)
+	c.line("buf := &bytes.Buffer{}")
+	for _, part := range m.Signature {
+		f, _ := m.FieldByPath(part.Field)
+		if part.If != "" {
+			cond, _ := m.FieldByPath(part.If)
+			zero := `""`
+			if cond.Type == Int {
+				zero = "0"
+			}
+			if cond.Type == Map {
+				c.open("if len(m.%s) > 0 {", part.If)
+			} else {
+				c.open("if m.%s != %s {", part.If, zero)
+			}
+		}
+		switch f.Type {
+		case Int:
+			c.line("buf.WriteString(strconv.FormatInt(m.%s, 10))", part.Field)
+		case Map:
+			c.line("writeSortedMap(buf, m.%s)", part.Field)
+		case List:
+			c.open("for _, item := range m.%s {", part.Field)
+			c.line("buf.WriteString(item.Signature)")
+			c.close("}")
+		default:
+			c.line("buf.WriteString(m.%s)", part.Field)
+		}
+		if part.If != "" {
+			c.close("}")
+		}
+	}
+	c.line("return buf.Bytes()")
+	c.close("}")
+	c.line("")
+	c.line("// Sign signs the message with the given secret")
+	c.open("func (m *%s) Sign(secret []byte) {", m.Name)
+	c.line("m.Signature = sign(m.Message(), secret)")
+	c.close("}")
+	c.line("")
+	c.line("// Verify returns true if the message has a valid signature")
+	c.open("func (m *%s) Verify(secret []byte) bool {", m.Name)
+	c.line("return verify(m.Message(), secret, m.Signature)")
+	c.close("}")
+	c.line("")
+}
+
+func goEndpoint(c *codeWriter, e *Endpoint) {
+	c.line("// %s", e.Doc)
+	c.line("//")
+	c.line("// The project key, timestamp, nonce and signature of the request will be set")
+	c.line("// by the client.")
+	c.open("func (c *Client) %s(req *%s) (*%s, error) {", e.Name, e.Request.Name, e.Response.Name)
+	c.line("req.ProjectKey = c.ProjectKey")
+	c.line("req.Timestamp = time.Now().Unix()")
+	c.line("var err error")
+	c.line("req.Nonce, err = newNonce()")
+	c.open("if err != nil {")
+	c.line("return nil, err")
+	c.close("}")
+	c.line("req.Sign(c.Secret)")
+	path := fmt.Sprintf("%q", e.Path)
+	params := e.PathParams()
+	for _, param := range params {
+		path = strings.Replace(path, "{"+param+"}", `" + url.PathEscape(req.`+param+`) + "`, 1)
+	}
+	path = strings.TrimSuffix(path, ` + ""`)
+	c.line("path := %s", path)
+	c.line("resp := &%s{}", e.Response.Name)
+	if e.Method == "GET" {
+		c.line("query := url.Values{}")
+	fields:
+		for _, f := range e.Request.Fields {
+			for _, param := range params {
+				if param == f.Name {
+					continue fields
+				}
+			}
+			value := "req." + f.Name
+			if f.Type == Int {
+				value = "strconv.FormatInt(req." + f.Name + ", 10)"
+			}
+			if f.Optional {
+				c.open("if req.%s != %s {", f.Name, map[bool]string{true: "0", false: `""`}[f.Type == Int])
+				c.line("query.Set(%q, %s)", f.Name, value)
+				c.close("}")
+			} else {
+				c.line("query.Set(%q, %s)", f.Name, value)
+			}
+		}
+		c.line("err = c.do(%q, path, query, nil, resp)", e.Method)
+	} else {
+		c.line("body, err := json.Marshal(req)")
+		c.open("if err != nil {")
+		c.line("return nil, err")
+		c.close("}")
+		c.line("err = c.do(%q, path, nil, body, resp)", e.Method)
+	}
+	c.open("if err != nil {")
+	c.line("return nil, err")
+	c.close("}")
+	c.open("if !resp.Verify(c.Secret) {")
+	c.line("return nil, ErrInvalidSignature")
+	c.close("}")
+	for _, f := range e.Response.Fields {
+		if f.Type != List {
+			continue
+		}
+		c.open("for _, item := range resp.%s {", f.Name)
+		c.open("if !item.Verify(c.Secret) {")
+		c.line("return nil, ErrInvalidSignature")
+		c.close("}")
+		c.close("}")
+	}
+	c.line("return resp, nil")
+	c.close("}")
+	c.line("")
+}
@@ -0,0 +1,266 @@
+package apidef
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GenerateJS writes a Node.js (CommonJS) client module for the API
+//
+// Messages are represented as plain objects with the structure of their JSON
+// documents. Endpoint methods take a callback function(err, resp).
+func GenerateJS(w io.Writer, api *API) error {
+	c := &codeWriter{indent: "  "}
+	c.line("// " + GeneratedHeader)
+	c.line("//")
+	c.line("// Client of the %s payment API in the version %s", api.Name, api.Version)
+	c.line("'use strict';")
+	c.line("")
+	c.line("%s", jsRuntime)
+	c.line("var messages = {};")
+	c.line("")
+	for _, m := range api.Messages() {
+		jsMessage(c, m)
+	}
+	for _, e := range api.Endpoints {
+		jsEndpoint(c, e)
+	}
+	c.line("Client.messages = messages;")
+	c.line("")
+	c.open("Client.verify = function (name, m, hexSecret) {")
+	c.line("return verify(messages[name](m), Buffer.from(hexSecret, 'hex'), m);")
+	c.close("};")
+	c.line("")
+	c.line("module.exports = Client;")
+	_, err := w.Write(c.buf.Bytes())
+	return err
+}
+
+const jsRuntime = `var crypto = require('crypto');
+var http = require('http');
+var https = require('https');
+var url = require('url');
+
+/**
+ * @param {string} baseURL the base URL of the API, e.g. https://paymentd.example.com
+ * @param {string} projectKey
+ * @param {string} hexSecret the hex encoded secret of the project key
+ */
+function Client(baseURL, projectKey, hexSecret) {
+  this.url = baseURL.replace(/\/+$/, '');
+  this.projectKey = projectKey;
+  this.secret = Buffer.from(hexSecret, 'hex');
+}
+
+function value(m, path) {
+  var v = m;
+  var names = path.split('.');
+  for (var i = 0; i < names.length; i++) {
+    if (v === null || typeof v !== 'object' || v[names[i]] === undefined || v[names[i]] === null) {
+      return '';
+    }
+    v = v[names[i]];
+  }
+  return v;
+}
+
+function present(m, path, isInt) {
+  var v = value(m, path);
+  if (typeof v === 'object') {
+    return Object.keys(v).length > 0;
+  }
+  return String(v) !== '' && !(isInt && String(v) === '0');
+}
+
+function sortedMap(map) {
+  if (map === null || typeof map !== 'object') {
+    return '';
+  }
+  var keys = Object.keys(map).sort(function (a, b) {
+    return Buffer.compare(Buffer.from(a), Buffer.from(b));
+  });
+  var s = '';
+  for (var i = 0; i < keys.length; i++) {
+    s += keys[i] + map[keys[i]];
+  }
+  return s;
+}
+
+function signatures(list) {
+  if (!Array.isArray(list)) {
+    return '';
+  }
+  var s = '';
+  for (var i = 0; i < list.length; i++) {
+    s += value(list[i], 'Signature');
+  }
+  return s;
+}
+
+function sign(msg, secret) {
+  return crypto.createHmac('sha256', secret).update(msg, 'utf8').digest('hex');
+}
+
+function verify(msg, secret, m) {
+  if (typeof m.Signature !== 'string') {
+    return false;
+  }
+  var expected = Buffer.from(sign(msg, secret), 'hex');
+  var actual = Buffer.from(m.Signature, 'hex');
+  return actual.length === expected.length && crypto.timingSafeEqual(actual, expected);
+}
+
+Client.prototype.prepare = function (req, ints) {
+  var r = {};
+  Object.keys(req).forEach(function (k) {
+    r[k] = req[k];
+  });
+  r.ProjectKey = this.projectKey;
+  r.Timestamp = String(Math.floor(Date.now() / 1000));
+  r.Nonce = crypto.randomBytes(16).toString('hex');
+  ints.forEach(function (k) {
+    if (r[k] !== undefined && r[k] !== null) {
+      r[k] = String(r[k]);
+    }
+  });
+  return r;
+};
+
+Client.prototype.request = function (method, path, query, body, cb) {
+  var u = url.parse(this.url + path);
+  if (query) {
+    var qs = Object.keys(query).map(function (k) {
+      return encodeURIComponent(k) + '=' + encodeURIComponent(query[k]);
+    }).join('&');
+    u.path += (u.path.indexOf('?') === -1 ? '?' : '&') + qs;
+  }
+  var headers = {'Accept': 'application/json'};
+  if (body !== null) {
+    headers['Content-Type'] = 'application/json';
+    headers['Content-Length'] = Buffer.byteLength(body);
+  }
+  var req = (u.protocol === 'https:' ? https : http).request({
+    method: method,
+    protocol: u.protocol,
+    hostname: u.hostname,
+    port: u.port,
+    path: u.path,
+    headers: headers
+  }, function (res) {
+    var chunks = [];
+    res.on('data', function (chunk) {
+      chunks.push(chunk);
+    });
+    res.on('end', function () {
+      var resp;
+      try {
+        resp = JSON.parse(Buffer.concat(chunks).toString('utf8'));
+      } catch (e) {
+        return cb(new Error('paymentd: HTTP ' + res.statusCode + ': ' + e.message));
+      }
+      if (resp.Status !== 'success') {
+        return cb(new Error('paymentd: ' + resp.Status + ' (HTTP ' + res.statusCode + '): ' + resp.Info));
+      }
+      cb(null, resp.Response);
+    });
+  });
+  req.on('error', cb);
+  if (body !== null) {
+    req.write(body);
+  }
+  req.end();
+};
+`
+
+func jsMessage(c *codeWriter, m *Message) {
+	c.line("// %s", m.Doc)
+	c.open("messages.%s = function (m) {", m.Name)
+	c.line("var s = '';")
+	for _, part := range m.Signature {
+		f, _ := m.FieldByPath(part.Field)
+		value := fmt.Sprintf("value(m, '%s')", part.Field)
+		switch f.Type {
+		case Map:
+			value = fmt.Sprintf("sortedMap(value(m, '%s'))", part.Field)
+		case List:
+			value = fmt.Sprintf("signatures(value(m, '%s'))", part.Field)
+		}
+		if part.If != "" {
+			cond, _ := m.FieldByPath(part.If)
+			c.open("if (present(m, '%s', %t)) {", part.If, cond.Type == Int)
+			c.line("s += %s;", value)
+			c.close("}")
+		} else {
+			c.line("s += %s;", value)
+		}
+	}
+	c.line("return s;")
+	c.close("};")
+	c.line("")
+}
+
+func jsEndpoint(c *codeWriter, e *Endpoint) {
+	c.line("/**")
+	c.line(" * %s", e.Doc)
+	c.line(" *")
+	c.line(" * @param {Object} req the %s, without ProjectKey, Timestamp, Nonce and Signature", e.Request.Name)
+	c.line(" * @param {function(Error, Object)} cb called with the verified %s", e.Response.Name)
+	c.line(" */")
+	c.open("Client.prototype.%s = function (req, cb) {", lowerFirst(e.Name))
+	ints := make([]string, 0)
+	for _, f := range e.Request.Fields {
+		if f.Type == Int {
+			ints = append(ints, "'"+f.Name+"'")
+		}
+	}
+	c.line("var secret = this.secret;")
+	c.line("req = this.prepare(req, [%s]);", strings.Join(ints, ", "))
+	c.line("req.Signature = sign(messages.%s(req), secret);", e.Request.Name)
+	path := "'" + e.Path + "'"
+	params := e.PathParams()
+	for _, param := range params {
+		path = strings.Replace(path, "{"+param+"}", "' + encodeURIComponent(req."+param+") + '", 1)
+	}
+	path = strings.TrimSuffix(path, " + ''")
+	if e.Method == "GET" {
+		c.line("var query = {};")
+		c.open("Object.keys(req).forEach(function (k) {")
+		if len(params) > 0 {
+			quoted := make([]string, len(params))
+			for i, param := range params {
+				quoted[i] = "'" + param + "'"
+			}
+			c.open("if ([%s].indexOf(k) === -1) {", strings.Join(quoted, ", "))
+			c.line("query[k] = req[k];")
+			c.close("}")
+		} else {
+			c.line("query[k] = req[k];")
+		}
+		c.close("});")
+		c.open("this.request('%s', %s, query, null, function (err, resp) {", e.Method, path)
+	} else {
+		c.open("this.request('%s', %s, null, JSON.stringify(req), function (err, resp) {", e.Method, path)
+	}
+	c.open("if (err) {")
+	c.line("return cb(err);")
+	c.close("}")
+	c.open("if (!verify(messages.%s(resp), secret, resp)) {", e.Response.Name)
+	c.line("return cb(new Error('paymentd: invalid signature'));")
+	c.close("}")
+	for _, f := range e.Response.Fields {
+		if f.Type != List {
+			continue
+		}
+		c.line("var %s = Array.isArray(resp.%s) ? resp.%s : [];", lowerFirst(f.Name), f.Name, f.Name)
+		c.open("for (var i = 0; i < %s.length; i++) {", lowerFirst(f.Name))
+		c.open("if (!verify(messages.%s(%s[i]), secret, %s[i])) {", f.Message.Name, lowerFirst(f.Name), lowerFirst(f.Name))
+		c.line("return cb(new Error('paymentd: invalid signature'));")
+		c.close("}")
+		c.close("}")
+	}
+	c.line("cb(null, resp);")
+	c.close("});")
+	c.close("};")
+	c.line("")
+}
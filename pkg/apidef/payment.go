@@ -0,0 +1,488 @@
+package apidef
+
+// PaymentAPI is the definition of the payment API in the version 1.x
+var PaymentAPI = &API{
+	Name:    "paymentd",
+	Version: "1",
+	Endpoints: []*Endpoint{
+		{
+			Name:     "InitPayment",
+			Doc:      "InitPayment initializes a new payment",
+			Method:   "POST",
+			Path:     "/v1/payment",
+			Request:  InitPaymentRequest,
+			Response: InitPaymentResponse,
+		},
+		{
+			Name:     "GetPaymentByID",
+			Doc:      "GetPaymentByID retrieves the payment with the given payment id",
+			Method:   "GET",
+			Path:     "/v1/payment/paymentId/{PaymentId}",
+			Request:  GetPaymentByIDRequest,
+			Response: PaymentNotification,
+		},
+		{
+			Name:     "GetPaymentByIdent",
+			Doc:      "GetPaymentByIdent retrieves the payment with the given ident",
+			Method:   "GET",
+			Path:     "/v1/payment/ident/{Ident}",
+			Request:  GetPaymentByIdentRequest,
+			Response: PaymentNotification,
+		},
+		{
+			Name:     "ListPayments",
+			Doc:      "ListPayments retrieves a page of the payments of the project, newest first",
+			Method:   "GET",
+			Path:     "/v1/payments",
+			Request:  ListPaymentsRequest,
+			Response: PaymentListResponse,
+		},
+		{
+			Name:     "CreateSession",
+			Doc:      "CreateSession creates a checkout session which can be converted into a payment",
+			Method:   "POST",
+			Path:     "/v1/session",
+			Request:  CreateSessionRequest,
+			Response: SessionResponse,
+		},
+		{
+			Name:     "UpdateSession",
+			Doc:      "UpdateSession replaces the values of the checkout session with the given token",
+			Method:   "PUT",
+			Path:     "/v1/session/{Token}",
+			Request:  UpdateSessionRequest,
+			Response: SessionResponse,
+		},
+		{
+			Name:     "GetSession",
+			Doc:      "GetSession retrieves the checkout session with the given token",
+			Method:   "GET",
+			Path:     "/v1/session/{Token}",
+			Request:  GetSessionRequest,
+			Response: SessionResponse,
+		},
+	},
+	Notifications: []*Message{
+		PaymentNotification,
+		EventNotification,
+	},
+}
+
+// InitPaymentRequest is the request to initialize a payment
+var InitPaymentRequest = &Message{
+	Name: "InitPaymentRequest",
+	Doc:  "InitPaymentRequest is the request to initialize a payment",
+	Fields: []Field{
+		{Name: "ProjectKey", Type: String},
+		{Name: "Ident", Type: String, Doc: "Ident is the unique identifier of the payment in the project"},
+		{Name: "Amount", Type: Int},
+		{Name: "Subunits", Type: Int},
+		{Name: "Currency", Type: String},
+		{Name: "Country", Type: String},
+		{Name: "PaymentMethodId", Type: Int, Optional: true},
+		{Name: "Locale", Type: String, Optional: true},
+		{Name: "CallbackURL", Type: String, Optional: true},
+		{Name: "CallbackAPIVersion", Type: String, Optional: true},
+		{Name: "CallbackProjectKey", Type: String, Optional: true},
+		{Name: "ReturnURL", Type: String, Optional: true},
+		{Name: "Expires", Type: Int, Optional: true, Doc: "Expires is the unix timestamp after which the payment expires"},
+		{Name: "ParentPaymentId", Type: String, Optional: true},
+		{Name: "Relation", Type: String, Optional: true},
+		{Name: "Session", Type: String, Optional: true, Doc: "Session is the token of the checkout session converted into the payment"},
+		{Name: "Note", Type: String, Optional: true, Doc: "Note is the free-text note of the payment, e.g. an order description"},
+		{Name: "StatementDescriptor", Type: String, Optional: true, Doc: "StatementDescriptor overrides the statement descriptor of the project"},
+		{Name: "Metadata", Type: Map, Optional: true},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String},
+		{Name: "Signature", Type: String},
+	},
+	Signature: []SignaturePart{
+		{Field: "ProjectKey"},
+		{Field: "Ident"},
+		{Field: "Amount"},
+		{Field: "Subunits"},
+		{Field: "Currency"},
+		{Field: "Country"},
+		{Field: "PaymentMethodId", If: "PaymentMethodId"},
+		{Field: "Locale", If: "Locale"},
+		{Field: "CallbackURL", If: "CallbackURL"},
+		{Field: "CallbackAPIVersion", If: "CallbackAPIVersion"},
+		{Field: "CallbackProjectKey", If: "CallbackProjectKey"},
+		{Field: "ReturnURL", If: "ReturnURL"},
+		{Field: "Expires", If: "Expires"},
+		{Field: "ParentPaymentId", If: "ParentPaymentId"},
+		{Field: "Relation", If: "Relation"},
+		{Field: "Session", If: "Session"},
+		{Field: "Note", If: "Note"},
+		{Field: "StatementDescriptor", If: "StatementDescriptor"},
+		{Field: "Metadata"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// InitPaymentResponse is the response to an initialized payment
+var InitPaymentResponse = &Message{
+	Name: "InitPaymentResponse",
+	Doc:  "InitPaymentResponse is the response to an initialized payment",
+	Fields: []Field{
+		{Name: "Confirmation", Type: Object, Fields: []Field{
+			{Name: "Ident", Type: String},
+			{Name: "Amount", Type: Int},
+			{Name: "Subunits", Type: Int},
+			{Name: "Currency", Type: String},
+			{Name: "Country", Type: String},
+			{Name: "PaymentMethodId", Type: Int, Optional: true},
+			{Name: "Locale", Type: String, Optional: true},
+			{Name: "CallbackURL", Type: String, Optional: true},
+			{Name: "CallbackAPIVersion", Type: String, Optional: true},
+			{Name: "CallbackProjectKey", Type: String, Optional: true},
+			{Name: "ReturnURL", Type: String, Optional: true},
+			{Name: "Expires", Type: Int, Optional: true},
+			{Name: "ParentPaymentId", Type: String, Optional: true},
+			{Name: "Relation", Type: String, Optional: true},
+			{Name: "Metadata", Type: Map, Optional: true},
+		}},
+		{Name: "Payment", Type: Object, Fields: []Field{
+			{Name: "PaymentId", Type: String},
+			{Name: "Created", Type: String, Doc: "Created is the RFC3339 date/time of the payment creation"},
+			{Name: "Token", Type: String, Doc: "Token is the payment token to be used in the checkout"},
+			{Name: "RedirectURL", Type: String, Optional: true},
+			{Name: "Reference", Type: String, Optional: true, Doc: "Reference is the reference number of the payment, if the project has a reference scheme. It is not part of the signature"},
+		}},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String},
+		{Name: "Signature", Type: String},
+	},
+	Signature: []SignaturePart{
+		{Field: "Confirmation.Ident"},
+		{Field: "Confirmation.Amount"},
+		{Field: "Confirmation.Subunits"},
+		{Field: "Confirmation.Currency"},
+		{Field: "Confirmation.Country"},
+		{Field: "Confirmation.PaymentMethodId", If: "Confirmation.PaymentMethodId"},
+		{Field: "Confirmation.Locale", If: "Confirmation.Locale"},
+		{Field: "Confirmation.CallbackURL", If: "Confirmation.CallbackURL"},
+		{Field: "Confirmation.CallbackAPIVersion", If: "Confirmation.CallbackAPIVersion"},
+		{Field: "Confirmation.CallbackProjectKey", If: "Confirmation.CallbackProjectKey"},
+		{Field: "Confirmation.ReturnURL", If: "Confirmation.ReturnURL"},
+		{Field: "Confirmation.ParentPaymentId", If: "Confirmation.ParentPaymentId"},
+		{Field: "Confirmation.Relation", If: "Confirmation.ParentPaymentId"},
+		{Field: "Confirmation.Metadata"},
+		{Field: "Payment.PaymentId"},
+		{Field: "Payment.Created"},
+		{Field: "Payment.Token"},
+		{Field: "Payment.RedirectURL"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// GetPaymentByIDRequest is the request to retrieve a payment by its payment id
+var GetPaymentByIDRequest = &Message{
+	Name: "GetPaymentByIDRequest",
+	Doc:  "GetPaymentByIDRequest is the request to retrieve a payment by its payment id",
+	Fields: []Field{
+		{Name: "ProjectKey", Type: String},
+		{Name: "PaymentId", Type: String},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String},
+		{Name: "Signature", Type: String},
+	},
+	Signature: []SignaturePart{
+		{Field: "ProjectKey"},
+		{Field: "PaymentId"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// GetPaymentByIdentRequest is the request to retrieve a payment by its ident
+var GetPaymentByIdentRequest = &Message{
+	Name: "GetPaymentByIdentRequest",
+	Doc:  "GetPaymentByIdentRequest is the request to retrieve a payment by its ident",
+	Fields: []Field{
+		{Name: "ProjectKey", Type: String},
+		{Name: "Ident", Type: String},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String},
+		{Name: "Signature", Type: String},
+	},
+	Signature: []SignaturePart{
+		{Field: "ProjectKey"},
+		{Field: "Ident"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// ListPaymentsRequest is the request to list the payments of a project
+var ListPaymentsRequest = &Message{
+	Name: "ListPaymentsRequest",
+	Doc:  "ListPaymentsRequest is the request to list the payments of a project",
+	Fields: []Field{
+		{Name: "ProjectKey", Type: String},
+		{Name: "Status", Type: String, Optional: true, Doc: "Status is the current status of the payments"},
+		{Name: "Currency", Type: String, Optional: true},
+		{Name: "CreatedFrom", Type: Int, Optional: true, Doc: "CreatedFrom is the unix timestamp from which on the payments were created"},
+		{Name: "CreatedUntil", Type: Int, Optional: true, Doc: "CreatedUntil is the unix timestamp before which the payments were created"},
+		{Name: "IdentPrefix", Type: String, Optional: true},
+		{Name: "Limit", Type: Int, Optional: true, Doc: "Limit is the maximum number of payments on the page"},
+		{Name: "Cursor", Type: String, Optional: true, Doc: "Cursor is the NextCursor of the previous page"},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String},
+		{Name: "Signature", Type: String},
+	},
+	Signature: []SignaturePart{
+		{Field: "ProjectKey"},
+		{Field: "Status", If: "Status"},
+		{Field: "Currency", If: "Currency"},
+		{Field: "CreatedFrom", If: "CreatedFrom"},
+		{Field: "CreatedUntil", If: "CreatedUntil"},
+		{Field: "IdentPrefix", If: "IdentPrefix"},
+		{Field: "Limit", If: "Limit"},
+		{Field: "Cursor", If: "Cursor"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// PaymentListResponse is a page of payments
+var PaymentListResponse = &Message{
+	Name: "PaymentListResponse",
+	Doc:  "PaymentListResponse is a page of payments",
+	Fields: []Field{
+		{Name: "Payments", Type: List, Message: PaymentNotification},
+		{Name: "NextCursor", Type: String, Optional: true, Doc: "NextCursor is the cursor of the next page. It is empty on the last page"},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String},
+		{Name: "Signature", Type: String},
+	},
+	Signature: []SignaturePart{
+		{Field: "Payments"},
+		{Field: "NextCursor", If: "NextCursor"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// CreateSessionRequest is the request to create a checkout session
+var CreateSessionRequest = &Message{
+	Name: "CreateSessionRequest",
+	Doc:  "CreateSessionRequest is the request to create a checkout session",
+	Fields: []Field{
+		{Name: "ProjectKey", Type: String},
+		{Name: "CartReference", Type: String, Optional: true},
+		{Name: "Amount", Type: Int},
+		{Name: "Subunits", Type: Int},
+		{Name: "Currency", Type: String},
+		{Name: "Country", Type: String},
+		{Name: "Locale", Type: String, Optional: true},
+		{Name: "Customer", Type: Map, Optional: true, Doc: "Customer holds hints about the customer, e.g. the e-mail address"},
+		{Name: "Metadata", Type: Map, Optional: true},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String},
+		{Name: "Signature", Type: String},
+	},
+	Signature: []SignaturePart{
+		{Field: "ProjectKey"},
+		{Field: "CartReference", If: "CartReference"},
+		{Field: "Amount"},
+		{Field: "Subunits"},
+		{Field: "Currency"},
+		{Field: "Country"},
+		{Field: "Locale", If: "Locale"},
+		{Field: "Customer"},
+		{Field: "Metadata"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// UpdateSessionRequest is the request to update a checkout session
+var UpdateSessionRequest = &Message{
+	Name: "UpdateSessionRequest",
+	Doc:  "UpdateSessionRequest is the request to update a checkout session",
+	Fields: []Field{
+		{Name: "ProjectKey", Type: String},
+		{Name: "Token", Type: String},
+		{Name: "CartReference", Type: String, Optional: true},
+		{Name: "Amount", Type: Int},
+		{Name: "Subunits", Type: Int},
+		{Name: "Currency", Type: String},
+		{Name: "Country", Type: String},
+		{Name: "Locale", Type: String, Optional: true},
+		{Name: "Customer", Type: Map, Optional: true},
+		{Name: "Metadata", Type: Map, Optional: true},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String},
+		{Name: "Signature", Type: String},
+	},
+	Signature: []SignaturePart{
+		{Field: "ProjectKey"},
+		{Field: "Token"},
+		{Field: "CartReference", If: "CartReference"},
+		{Field: "Amount"},
+		{Field: "Subunits"},
+		{Field: "Currency"},
+		{Field: "Country"},
+		{Field: "Locale", If: "Locale"},
+		{Field: "Customer"},
+		{Field: "Metadata"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// GetSessionRequest is the request to retrieve a checkout session
+var GetSessionRequest = &Message{
+	Name: "GetSessionRequest",
+	Doc:  "GetSessionRequest is the request to retrieve a checkout session",
+	Fields: []Field{
+		{Name: "ProjectKey", Type: String},
+		{Name: "Token", Type: String},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String},
+		{Name: "Signature", Type: String},
+	},
+	Signature: []SignaturePart{
+		{Field: "ProjectKey"},
+		{Field: "Token"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// SessionResponse is the state of a checkout session
+var SessionResponse = &Message{
+	Name: "SessionResponse",
+	Doc:  "SessionResponse is the state of a checkout session",
+	Fields: []Field{
+		{Name: "Session", Type: Object, Fields: []Field{
+			{Name: "Token", Type: String},
+			{Name: "Created", Type: String, Doc: "Created is the RFC3339 date/time of the session creation"},
+			{Name: "Expires", Type: String, Doc: "Expires is the RFC3339 date/time after which the session expires"},
+			{Name: "CartReference", Type: String, Optional: true},
+			{Name: "Amount", Type: Int},
+			{Name: "Subunits", Type: Int},
+			{Name: "Currency", Type: String},
+			{Name: "Country", Type: String},
+			{Name: "Locale", Type: String, Optional: true},
+			{Name: "Customer", Type: Map, Optional: true},
+			{Name: "Metadata", Type: Map, Optional: true},
+			{Name: "PaymentId", Type: String, Optional: true, Doc: "PaymentId is the payment the session was converted into"},
+		}},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String},
+		{Name: "Signature", Type: String},
+	},
+	Signature: []SignaturePart{
+		{Field: "Session.Token"},
+		{Field: "Session.Created"},
+		{Field: "Session.Expires"},
+		{Field: "Session.CartReference", If: "Session.CartReference"},
+		{Field: "Session.Amount"},
+		{Field: "Session.Subunits"},
+		{Field: "Session.Currency"},
+		{Field: "Session.Country"},
+		{Field: "Session.Locale", If: "Session.Locale"},
+		{Field: "Session.Customer"},
+		{Field: "Session.Metadata"},
+		{Field: "Session.PaymentId", If: "Session.PaymentId"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// PaymentNotification is the state of a payment, as sent with callbacks and
+// returned when retrieving a payment
+var PaymentNotification = &Message{
+	Name: "PaymentNotification",
+	Doc:  "PaymentNotification is the state of a payment, as sent with callbacks and returned when retrieving a payment",
+	Fields: []Field{
+		{Name: "Version", Type: String},
+		{Name: "PaymentId", Type: String},
+		{Name: "Ident", Type: String},
+		{Name: "Amount", Type: Int},
+		{Name: "Subunits", Type: Int},
+		{Name: "DecimalAmount", Type: String},
+		{Name: "Currency", Type: String},
+		{Name: "Country", Type: String, Optional: true},
+		{Name: "PaymentMethodId", Type: Int, Optional: true},
+		{Name: "Locale", Type: String, Optional: true},
+		{Name: "ParentPaymentId", Type: String, Optional: true},
+		{Name: "Relation", Type: String, Optional: true},
+		{Name: "Balance", Type: Map, Optional: true, Doc: "Balance is the decimal balance of the payment per currency"},
+		{Name: "AuthorizedAmount", Type: Int, Optional: true, Doc: "AuthorizedAmount is the authorized amount of authorized payments in subunits. It is not part of the signature"},
+		{Name: "DecimalAuthorizedAmount", Type: String, Optional: true, Doc: "DecimalAuthorizedAmount is the decimal authorized amount. It is not part of the signature"},
+		{Name: "Status", Type: String, Optional: true},
+		{Name: "TransactionTimestamp", Type: Int, Optional: true},
+		{Name: "Metadata", Type: Map, Optional: true},
+		{Name: "Note", Type: String, Optional: true, Doc: "Note is the free-text note of the payment. It is not part of the signature"},
+		{Name: "Reference", Type: String, Optional: true, Doc: "Reference is the reference number of the payment, to be quoted with bank transfers. It is not part of the signature"},
+		{Name: "Billing", Type: Object, Optional: true, Doc: "Billing is the billing data collected in the checkout. It is not part of the signature", Fields: []Field{
+			{Name: "VATID", Type: String, Optional: true},
+			{Name: "CPF", Type: String, Optional: true},
+			{Name: "Street", Type: String, Optional: true},
+			{Name: "PostalCode", Type: String, Optional: true},
+			{Name: "City", Type: String, Optional: true},
+			{Name: "Country", Type: String, Optional: true},
+		}},
+		{Name: "PaymentMethodName", Type: String, Optional: true, Doc: "PaymentMethodName is the display name of the payment method in the locale of the payment. It is not part of the signature"},
+		{Name: "Ledger", Type: Object, Optional: true, Doc: "Ledger holds the decimal amounts of the payment by their state. It is not part of the signature", Fields: []Field{
+			{Name: "Open", Type: String},
+			{Name: "Authorized", Type: String},
+			{Name: "Captured", Type: String},
+			{Name: "Refunded", Type: String},
+			{Name: "ChargedBack", Type: String},
+			{Name: "Net", Type: String},
+		}},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String, Optional: true},
+		{Name: "Signature", Type: String, Optional: true},
+	},
+	Signature: []SignaturePart{
+		{Field: "Version"},
+		{Field: "PaymentId"},
+		{Field: "Ident"},
+		{Field: "Amount"},
+		{Field: "Subunits"},
+		{Field: "DecimalAmount"},
+		{Field: "Currency"},
+		{Field: "Country"},
+		{Field: "PaymentMethodId", If: "PaymentMethodId"},
+		{Field: "Locale", If: "Locale"},
+		{Field: "ParentPaymentId", If: "ParentPaymentId"},
+		{Field: "Relation", If: "ParentPaymentId"},
+		{Field: "Balance"},
+		{Field: "Status", If: "Status"},
+		{Field: "TransactionTimestamp", If: "TransactionTimestamp"},
+		{Field: "Metadata"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
+
+// EventNotification is the notification about a change of an entity other
+// than a payment
+var EventNotification = &Message{
+	Name: "EventNotification",
+	Doc:  "EventNotification is the notification about a change of an entity other than a payment",
+	Fields: []Field{
+		{Name: "Version", Type: String},
+		{Name: "Event", Type: String, Doc: "Event is the event type, e.g. funds.matched"},
+		{Name: "ProjectId", Type: Int},
+		{Name: "Data", Type: Map, Optional: true},
+		{Name: "Timestamp", Type: Int},
+		{Name: "Nonce", Type: String, Optional: true},
+		{Name: "Signature", Type: String, Optional: true},
+	},
+	Signature: []SignaturePart{
+		{Field: "Version"},
+		{Field: "Event"},
+		{Field: "ProjectId"},
+		{Field: "Data"},
+		{Field: "Timestamp"},
+		{Field: "Nonce"},
+	},
+}
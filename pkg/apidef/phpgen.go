@@ -0,0 +1,251 @@
+package apidef
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GeneratePHP writes a PHP client class for the API
+//
+// Messages are represented as associative arrays with the structure of their
+// JSON documents.
+func GeneratePHP(w io.Writer, api *API) error {
+	c := &codeWriter{indent: "    "}
+	c.line("<?php")
+	c.line("// " + GeneratedHeader)
+	c.line("")
+	c.line("namespace Paymentd;")
+	c.line("")
+	c.line("/**")
+	c.line(" * Client of the %s payment API in the version %s", api.Name, api.Version)
+	c.line(" */")
+	c.open("class Client")
+	c.line("{")
+	c.line("%s", phpRuntime)
+	for _, e := range api.Endpoints {
+		phpEndpoint(c, e)
+	}
+	for _, m := range api.Messages() {
+		phpMessage(c, m)
+	}
+	c.close("}")
+	_, err := w.Write(c.buf.Bytes())
+	return err
+}
+
+const phpRuntime = `    private $url;
+    private $projectKey;
+    private $secret;
+
+    /**
+     * @param string $url the base URL of the API, e.g. https://paymentd.example.com
+     * @param string $projectKey
+     * @param string $hexSecret the hex encoded secret of the project key
+     */
+    public function __construct($url, $projectKey, $hexSecret)
+    {
+        $this->url = rtrim($url, '/');
+        $this->projectKey = $projectKey;
+        $this->secret = hex2bin($hexSecret);
+    }
+
+    private function request($method, $path, array $query, $body)
+    {
+        $url = $this->url . $path;
+        if ($query) {
+            $url .= '?' . http_build_query($query);
+        }
+        $headers = "Accept: application/json\r\n";
+        if ($body !== null) {
+            $headers .= "Content-Type: application/json\r\n";
+        }
+        $context = stream_context_create(array('http' => array(
+            'method' => $method,
+            'header' => $headers,
+            'content' => $body === null ? '' : $body,
+            'ignore_errors' => true,
+        )));
+        $raw = file_get_contents($url, false, $context);
+        if ($raw === false) {
+            throw new \RuntimeException('paymentd: request failed');
+        }
+        $resp = json_decode($raw, true);
+        if (!is_array($resp) || !isset($resp['Status']) || $resp['Status'] !== 'success') {
+            $info = is_array($resp) && isset($resp['Info']) ? $resp['Info'] : $raw;
+            throw new \RuntimeException('paymentd: ' . $info);
+        }
+        return $resp['Response'];
+    }
+
+    private function prepare(array $req)
+    {
+        $req['ProjectKey'] = $this->projectKey;
+        $req['Timestamp'] = (string) time();
+        $req['Nonce'] = bin2hex(openssl_random_pseudo_bytes(16));
+        return $req;
+    }
+
+    private static function sign($msg, $secret)
+    {
+        return hash_hmac('sha256', $msg, $secret);
+    }
+
+    private static function verify($msg, $secret, array $m)
+    {
+        if (!isset($m['Signature'])) {
+            return false;
+        }
+        return hash_equals(self::sign($msg, $secret), strtolower($m['Signature']));
+    }
+
+    private static function value(array $m, $path)
+    {
+        $v = $m;
+        foreach (explode('.', $path) as $name) {
+            if (!is_array($v) || !isset($v[$name])) {
+                return '';
+            }
+            $v = $v[$name];
+        }
+        return $v;
+    }
+
+    private static function present(array $m, $path, $int)
+    {
+        $v = self::value($m, $path);
+        if (is_array($v)) {
+            return count($v) > 0;
+        }
+        return (string) $v !== '' && !($int && (string) $v === '0');
+    }
+
+    private static function sortedMap($map)
+    {
+        if (!is_array($map)) {
+            return '';
+        }
+        ksort($map, SORT_STRING);
+        $s = '';
+        foreach ($map as $k => $v) {
+            $s .= $k . $v;
+        }
+        return $s;
+    }
+
+    private static function signatures($list)
+    {
+        if (!is_array($list)) {
+            return '';
+        }
+        $s = '';
+        foreach ($list as $item) {
+            $s .= self::value($item, 'Signature');
+        }
+        return $s;
+    }
+
+    private static function stringifyInts(array $m, array $fields)
+    {
+        foreach ($fields as $f) {
+            if (isset($m[$f])) {
+                $m[$f] = (string) $m[$f];
+            }
+        }
+        return $m;
+    }
+`
+
+func phpEndpoint(c *codeWriter, e *Endpoint) {
+	name := lowerFirst(e.Name)
+	c.line("/**")
+	c.line(" * %s", e.Doc)
+	c.line(" *")
+	c.line(" * @param array $req the %s, without ProjectKey, Timestamp, Nonce and Signature", e.Request.Name)
+	c.line(" * @return array the verified %s", e.Response.Name)
+	c.line(" */")
+	c.open("public function %s(array $req)", name)
+	c.line("{")
+	c.line("$req = $this->prepare($req);")
+	ints := make([]string, 0)
+	for _, f := range e.Request.Fields {
+		if f.Type == Int {
+			ints = append(ints, "'"+f.Name+"'")
+		}
+	}
+	c.line("$req = self::stringifyInts($req, array(%s));", strings.Join(ints, ", "))
+	c.line("$req['Signature'] = self::sign(self::%sMessage($req), $this->secret);", lowerFirst(e.Request.Name))
+	path := "'" + e.Path + "'"
+	params := e.PathParams()
+	for _, param := range params {
+		path = strings.Replace(path, "{"+param+"}", "' . rawurlencode($req['"+param+"']) . '", 1)
+	}
+	path = strings.TrimSuffix(path, " . ''")
+	if e.Method == "GET" {
+		c.line("$query = $req;")
+		for _, param := range params {
+			c.line("unset($query['%s']);", param)
+		}
+		c.line("$resp = $this->request('%s', %s, $query, null);", e.Method, path)
+	} else {
+		c.line("$resp = $this->request('%s', %s, array(), json_encode($req));", e.Method, path)
+	}
+	c.open("if (!self::verify(self::%sMessage($resp), $this->secret, $resp)) {", lowerFirst(e.Response.Name))
+	c.line("throw new \\RuntimeException('paymentd: invalid signature');")
+	c.close("}")
+	for _, f := range e.Response.Fields {
+		if f.Type != List {
+			continue
+		}
+		c.open("foreach ((array) self::value($resp, '%s') as $item) {", f.Name)
+		c.open("if (!self::verify(self::%sMessage($item), $this->secret, $item)) {", lowerFirst(f.Message.Name))
+		c.line("throw new \\RuntimeException('paymentd: invalid signature');")
+		c.close("}")
+		c.close("}")
+	}
+	c.line("return $resp;")
+	c.close("}")
+	c.line("")
+}
+
+func phpMessage(c *codeWriter, m *Message) {
+	name := lowerFirst(m.Name)
+	c.line("/**")
+	c.line(" * Returns the signature base string of the %s", m.Name)
+	c.line(" */")
+	c.open("public static function %sMessage(array $m)", name)
+	c.line("{")
+	c.line("$s = '';")
+	for _, part := range m.Signature {
+		f, _ := m.FieldByPath(part.Field)
+		value := fmt.Sprintf("self::value($m, '%s')", part.Field)
+		switch f.Type {
+		case Map:
+			value = fmt.Sprintf("self::sortedMap(self::value($m, '%s'))", part.Field)
+		case List:
+			value = fmt.Sprintf("self::signatures(self::value($m, '%s'))", part.Field)
+		}
+		if part.If != "" {
+			cond, _ := m.FieldByPath(part.If)
+			c.open("if (self::present($m, '%s', %t)) {", part.If, cond.Type == Int)
+			c.line("$s .= %s;", value)
+			c.close("}")
+		} else {
+			c.line("$s .= %s;", value)
+		}
+	}
+	c.line("return $s;")
+	c.close("}")
+	c.line("")
+	c.line("/**")
+	c.line(" * Returns true if the %s has a valid signature", m.Name)
+	c.line(" *")
+	c.line(" * @param array $m the decoded JSON document")
+	c.line(" * @param string $hexSecret the hex encoded secret of the project key")
+	c.line(" */")
+	c.open("public static function verify%s(array $m, $hexSecret)", m.Name)
+	c.line("{")
+	c.line("return self::verify(self::%sMessage($m), hex2bin($hexSecret), $m);", name)
+	c.close("}")
+	c.line("")
+}
@@ -0,0 +1,187 @@
+package asset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// hex characters of the content hash used in hashed asset names
+	hashLength = 16
+
+	// CacheControlHashed is the Cache-Control header value for assets requested
+	// by their hashed name. Those will never change.
+	CacheControlHashed = "public, max-age=31536000"
+	// CacheControlDefault is the Cache-Control header value for assets requested
+	// by their plain name
+	CacheControlDefault = "public, max-age=300"
+)
+
+var (
+	ErrAssetNotFound = errors.New("asset not found")
+)
+
+type asset struct {
+	file   string
+	name   string
+	hash   string
+	hashed string
+}
+
+// Pipeline serves the assets of a directory
+//
+// It implements http.Handler. Requests should be stripped of the prefix before
+// reaching the pipeline.
+type Pipeline struct {
+	dir    string
+	prefix string
+
+	m      sync.RWMutex
+	byName map[string]*asset
+	// hashed names
+	byHash map[string]*asset
+}
+
+// NewPipeline creates a new asset pipeline for the given directory
+//
+// The prefix is the URL path under which the pipeline will be served.
+func NewPipeline(dir, prefix string) (*Pipeline, error) {
+	p := &Pipeline{
+		dir:    dir,
+		prefix: strings.TrimSuffix(prefix, "/"),
+	}
+	err := p.Load()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Load (re-)loads the assets
+//
+// The content hashes of all files will be calculated. A non-existing directory
+// will result in an empty pipeline.
+func (p *Pipeline) Load() error {
+	byName := make(map[string]*asset)
+	byHash := make(map[string]*asset)
+	err := filepath.Walk(p.dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if file == p.dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(p.dir, file)
+		if err != nil {
+			return err
+		}
+		a := &asset{
+			file: file,
+			name: filepath.ToSlash(rel),
+		}
+		a.hash, err = hashFile(file)
+		if err != nil {
+			return fmt.Errorf("error hashing asset %s: %v", a.name, err)
+		}
+		a.hashed = HashedName(a.name, a.hash)
+		byName[a.name] = a
+		byHash[a.hashed] = a
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.m.Lock()
+	p.byName, p.byHash = byName, byHash
+	p.m.Unlock()
+	return nil
+}
+
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:hashLength], nil
+}
+
+// HashedName returns the hashed name for the given asset name and content hash
+//
+// The hash will be inserted before the file extension, e.g. "css/style.css"
+// becomes "css/style.0123456789abcdef.css".
+func HashedName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// Path returns the URL path of the hashed asset
+//
+// This method is intended to be used as a template function.
+func (p *Pipeline) Path(name string) (string, error) {
+	p.m.RLock()
+	a, ok := p.byName[strings.TrimPrefix(name, "/")]
+	p.m.RUnlock()
+	if !ok {
+		return "", ErrAssetNotFound
+	}
+	return p.prefix + "/" + a.hashed, nil
+}
+
+func (p *Pipeline) lookup(name string) (a *asset, hashed bool) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	p.m.RLock()
+	defer p.m.RUnlock()
+	if a, ok := p.byHash[name]; ok {
+		return a, true
+	}
+	return p.byName[name], false
+}
+
+func (p *Pipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a, hashed := p.lookup(r.URL.Path)
+	if a == nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(a.file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	inf, err := f.Stat()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if hashed {
+		w.Header().Set("Cache-Control", CacheControlHashed)
+	} else {
+		w.Header().Set("Cache-Control", CacheControlDefault)
+	}
+	// the asset might be served compressed, so the ETag can only be weak
+	w.Header().Set("ETag", `W/"`+a.hash+`"`)
+	http.ServeContent(w, r, a.name, inf.ModTime(), f)
+}
@@ -0,0 +1,98 @@
+package asset
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPipeline(t *testing.T) {
+	Convey("Given an asset directory", t, func() {
+		dir, err := ioutil.TempDir("", "asset")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		So(os.Mkdir(filepath.Join(dir, "css"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "css", "style.css"), []byte("body { color: red; }"), 0644), ShouldBeNil)
+
+		Convey("When creating a pipeline", func() {
+			p, err := NewPipeline(dir, "/paypal/static/")
+			So(err, ShouldBeNil)
+
+			Convey("The asset path should contain the content hash", func() {
+				assetPath, err := p.Path("css/style.css")
+				So(err, ShouldBeNil)
+				So(assetPath, ShouldStartWith, "/paypal/static/css/style.")
+				So(assetPath, ShouldEndWith, ".css")
+				So(len(assetPath), ShouldEqual, len("/paypal/static/css/style.css")+hashLength+1)
+			})
+
+			Convey("Unknown assets should not have a path", func() {
+				_, err := p.Path("css/unknown.css")
+				So(err, ShouldEqual, ErrAssetNotFound)
+			})
+
+			Convey("When requesting the hashed asset", func() {
+				assetPath, err := p.Path("css/style.css")
+				So(err, ShouldBeNil)
+				r, err := http.NewRequest("GET", strings.TrimPrefix(assetPath, "/paypal/static"), nil)
+				So(err, ShouldBeNil)
+				w := httptest.NewRecorder()
+				p.ServeHTTP(w, r)
+
+				Convey("It should be served with a long cache lifetime", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(w.Body.String(), ShouldEqual, "body { color: red; }")
+					So(w.Header().Get("Cache-Control"), ShouldEqual, CacheControlHashed)
+					So(w.Header().Get("ETag"), ShouldStartWith, `W/"`)
+				})
+
+				Convey("It should be revalidated with its weak ETag", func() {
+					r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+					w = httptest.NewRecorder()
+					p.ServeHTTP(w, r)
+					So(w.Code, ShouldEqual, http.StatusNotModified)
+				})
+			})
+
+			Convey("When requesting the plain asset", func() {
+				r, err := http.NewRequest("GET", "/css/style.css", nil)
+				So(err, ShouldBeNil)
+				w := httptest.NewRecorder()
+				p.ServeHTTP(w, r)
+
+				Convey("It should be served with the default cache lifetime", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(w.Header().Get("Cache-Control"), ShouldEqual, CacheControlDefault)
+				})
+			})
+
+			Convey("When requesting a path outside of the directory", func() {
+				r, err := http.NewRequest("GET", "/../../etc/passwd", nil)
+				So(err, ShouldBeNil)
+				w := httptest.NewRecorder()
+				p.ServeHTTP(w, r)
+
+				Convey("It should not be found", func() {
+					So(w.Code, ShouldEqual, http.StatusNotFound)
+				})
+			})
+		})
+	})
+
+	Convey("Given a non-existing asset directory", t, func() {
+		Convey("The pipeline should be empty", func() {
+			p, err := NewPipeline(filepath.Join(os.TempDir(), "asset-does-not-exist"), "/static")
+			So(err, ShouldBeNil)
+			_, err = p.Path("style.css")
+			So(err, ShouldEqual, ErrAssetNotFound)
+		})
+	})
+}
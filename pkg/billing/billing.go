@@ -0,0 +1,329 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/fritzpay/paymentd/pkg/validate"
+)
+
+// Checkout fields
+const (
+	// FieldVATID is the VAT identification number of the customer
+	FieldVATID = "VATID"
+	// FieldCPF is the brazilian individual taxpayer number (Cadastro de Pessoas
+	// Físicas)
+	FieldCPF = "CPF"
+	// FieldAddress is the full billing address of the customer
+	FieldAddress = "Address"
+)
+
+// Form parameters of the billing address
+const (
+	ParamStreet     = "Street"
+	ParamPostalCode = "PostalCode"
+	ParamCity       = "City"
+)
+
+const (
+	streetMaxLen = 255
+	cityMaxLen   = 128
+)
+
+var (
+	ErrUnknownField = errors.New("unknown checkout field")
+)
+
+// Field is the configuration of a checkout field
+type Field struct {
+	Name string
+	// Countries are the ISO 3166-1 alpha-2 codes of the payment countries for
+	// which the field is collected. An empty list applies to all countries.
+	Countries []string `json:",omitempty"`
+	// Optional fields may be left empty by the customer
+	Optional bool `json:",omitempty"`
+}
+
+// Applies returns true if the field is collected for payments in the country
+func (f Field) Applies(country string) bool {
+	if len(f.Countries) == 0 {
+		return true
+	}
+	for _, c := range f.Countries {
+		if c == country {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields are the checkout fields of a project
+type Fields []Field
+
+// Check returns an error if a field is unknown
+func (fs Fields) Check() error {
+	for _, f := range fs {
+		switch f.Name {
+		case FieldVATID, FieldCPF, FieldAddress:
+		default:
+			return fmt.Errorf("%w: %s", ErrUnknownField, f.Name)
+		}
+	}
+	return nil
+}
+
+// For returns the fields collected for payments in the country
+func (fs Fields) For(country string) Fields {
+	var applied Fields
+	for _, f := range fs {
+		if f.Applies(country) {
+			applied = append(applied, f)
+		}
+	}
+	return applied
+}
+
+// Require adds the named fields as required fields, e.g. fields required by the
+// provider
+func (fs Fields) Require(names ...string) Fields {
+	req := make(Fields, len(fs), len(fs)+len(names))
+	copy(req, fs)
+nextName:
+	for _, name := range names {
+		for i := range req {
+			if req[i].Name == name {
+				req[i].Optional = false
+				continue nextName
+			}
+		}
+		req = append(req, Field{Name: name})
+	}
+	return req
+}
+
+// Data are the checkout field values of a payment
+type Data struct {
+	VATID      string `json:",omitempty"`
+	CPF        string `json:",omitempty"`
+	Street     string `json:",omitempty"`
+	PostalCode string `json:",omitempty"`
+	City       string `json:",omitempty"`
+	// Country is the country of the billing address
+	Country string `json:",omitempty"`
+}
+
+// Complete returns true if the data contains all required fields
+func (fs Fields) Complete(d *Data) bool {
+	if d == nil {
+		for _, f := range fs {
+			if !f.Optional {
+				return false
+			}
+		}
+		return true
+	}
+	for _, f := range fs {
+		if f.Optional {
+			continue
+		}
+		switch f.Name {
+		case FieldVATID:
+			if d.VATID == "" {
+				return false
+			}
+		case FieldCPF:
+			if d.CPF == "" {
+				return false
+			}
+		case FieldAddress:
+			if d.Street == "" || d.PostalCode == "" || d.City == "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Parse reads the values of the fields from the submitted checkout form
+//
+// The values are validated by the rules of the payment country. It returns
+// validate.Errors if values are missing or invalid. Values are normalized,
+// e.g. VAT IDs are upper case without spaces and CPFs contain only digits.
+func (fs Fields) Parse(country string, form url.Values) (*Data, error) {
+	d := &Data{}
+	var rules []validate.Rule
+	for _, f := range fs {
+		switch f.Name {
+		case FieldVATID:
+			d.VATID = NormalizeVATID(form.Get(FieldVATID))
+			rules = append(rules, validate.Field(FieldVATID,
+				required(f, d.VATID),
+				validate.Assert(d.VATID == "" || ValidVATID(country, d.VATID), validate.CodeInvalid)))
+		case FieldCPF:
+			d.CPF = NormalizeCPF(form.Get(FieldCPF))
+			rules = append(rules, validate.Field(FieldCPF,
+				required(f, d.CPF),
+				validate.Assert(d.CPF == "" || ValidCPF(d.CPF), validate.CodeInvalid)))
+		case FieldAddress:
+			d.Street = strings.TrimSpace(form.Get(ParamStreet))
+			d.PostalCode = strings.ToUpper(strings.TrimSpace(form.Get(ParamPostalCode)))
+			d.City = strings.TrimSpace(form.Get(ParamCity))
+			rules = append(rules,
+				validate.Field(ParamStreet, required(f, d.Street), validate.MaxLen(d.Street, streetMaxLen)),
+				validate.Field(ParamPostalCode,
+					required(f, d.PostalCode),
+					validate.Assert(d.PostalCode == "" || ValidPostalCode(country, d.PostalCode), validate.CodeInvalid)),
+				validate.Field(ParamCity, required(f, d.City), validate.MaxLen(d.City, cityMaxLen)))
+			if d.Street != "" {
+				d.Country = country
+			}
+		}
+	}
+	err := validate.Validate(rules...)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func required(f Field, v string) validate.Check {
+	if f.Optional {
+		return ""
+	}
+	return validate.Required(v)
+}
+
+// vatIDFormats are the formats of VAT IDs by country, without the country
+// prefix
+var vatIDFormats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^U[0-9]{8}$`),
+	"BE": regexp.MustCompile(`^[01][0-9]{9}$`),
+	"CH": regexp.MustCompile(`^E[0-9]{9}(MWST|TVA|IVA)?$`),
+	"DE": regexp.MustCompile(`^[0-9]{9}$`),
+	"DK": regexp.MustCompile(`^[0-9]{8}$`),
+	"ES": regexp.MustCompile(`^[0-9A-Z][0-9]{7}[0-9A-Z]$`),
+	"FR": regexp.MustCompile(`^[0-9A-Z]{2}[0-9]{9}$`),
+	"GB": regexp.MustCompile(`^([0-9]{9}|[0-9]{12}|GD[0-9]{3}|HA[0-9]{3})$`),
+	"IT": regexp.MustCompile(`^[0-9]{11}$`),
+	"LU": regexp.MustCompile(`^[0-9]{8}$`),
+	"NL": regexp.MustCompile(`^[0-9]{9}B[0-9]{2}$`),
+	"PL": regexp.MustCompile(`^[0-9]{10}$`),
+	"SE": regexp.MustCompile(`^[0-9]{12}$`),
+}
+
+// vatIDFallback is the format of VAT IDs of other countries
+var vatIDFallback = regexp.MustCompile(`^[0-9A-Z]{2,13}$`)
+
+// vatIDPrefix returns the prefix of VAT IDs of the country
+//
+// Greek VAT IDs are prefixed with EL.
+func vatIDPrefix(country string) string {
+	if country == "GR" {
+		return "EL"
+	}
+	return country
+}
+
+// NormalizeVATID returns the VAT ID in upper case without separators
+func NormalizeVATID(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '.', '-':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(id)))
+}
+
+// ValidVATID returns true if the normalized VAT ID has the format of VAT IDs
+// of the country
+//
+// The VAT ID must be prefixed with the country code.
+func ValidVATID(country, id string) bool {
+	prefix := vatIDPrefix(country)
+	if prefix == "" || !strings.HasPrefix(id, prefix) {
+		return false
+	}
+	format, ok := vatIDFormats[country]
+	if !ok {
+		format = vatIDFallback
+	}
+	return format.MatchString(id[len(prefix):])
+}
+
+// NormalizeCPF returns the digits of the CPF
+func NormalizeCPF(cpf string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '.', '-':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(cpf))
+}
+
+// ValidCPF returns true if the normalized CPF has 11 digits with valid check
+// digits
+func ValidCPF(cpf string) bool {
+	if len(cpf) != 11 {
+		return false
+	}
+	digits := make([]int, 11)
+	same := true
+	for i := 0; i < len(cpf); i++ {
+		if cpf[i] < '0' || cpf[i] > '9' {
+			return false
+		}
+		digits[i] = int(cpf[i] - '0')
+		if digits[i] != digits[0] {
+			same = false
+		}
+	}
+	// sequences of the same digit pass the checksum but are not issued
+	if same {
+		return false
+	}
+	for n := 9; n <= 10; n++ {
+		var sum int
+		for i := 0; i < n; i++ {
+			sum += digits[i] * (n + 1 - i)
+		}
+		check := sum * 10 % 11 % 10
+		if check != digits[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// postalCodeFormats are the formats of postal codes by country
+var postalCodeFormats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^[0-9]{4}$`),
+	"BE": regexp.MustCompile(`^[0-9]{4}$`),
+	"BR": regexp.MustCompile(`^[0-9]{5}-?[0-9]{3}$`),
+	"CH": regexp.MustCompile(`^[0-9]{4}$`),
+	"DE": regexp.MustCompile(`^[0-9]{5}$`),
+	"ES": regexp.MustCompile(`^[0-9]{5}$`),
+	"FR": regexp.MustCompile(`^[0-9]{5}$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}[0-9][0-9A-Z]? ?[0-9][A-Z]{2}$`),
+	"IT": regexp.MustCompile(`^[0-9]{5}$`),
+	"NL": regexp.MustCompile(`^[0-9]{4} ?[A-Z]{2}$`),
+	"PL": regexp.MustCompile(`^[0-9]{2}-[0-9]{3}$`),
+	"US": regexp.MustCompile(`^[0-9]{5}(-[0-9]{4})?$`),
+}
+
+// postalCodeFallback is the format of postal codes of other countries
+var postalCodeFallback = regexp.MustCompile(`^[0-9A-Z][0-9A-Z -]{0,14}$`)
+
+// ValidPostalCode returns true if the upper case postal code has the format of
+// postal codes of the country
+func ValidPostalCode(country, code string) bool {
+	format, ok := postalCodeFormats[country]
+	if !ok {
+		format = postalCodeFallback
+	}
+	return format.MatchString(code)
+}
@@ -0,0 +1,93 @@
+package billing
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/validate"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidation(t *testing.T) {
+	Convey("Given VAT IDs", t, func() {
+		Convey("VAT IDs in the format of the country should pass", func() {
+			So(ValidVATID("DE", NormalizeVATID("de 123 456 789")), ShouldBeTrue)
+			So(ValidVATID("AT", "ATU12345678"), ShouldBeTrue)
+			So(ValidVATID("GR", "EL123456789"), ShouldBeTrue)
+		})
+		Convey("VAT IDs of other countries or formats should fail", func() {
+			So(ValidVATID("DE", "ATU12345678"), ShouldBeFalse)
+			So(ValidVATID("DE", "DE12345678"), ShouldBeFalse)
+		})
+	})
+	Convey("Given CPFs", t, func() {
+		Convey("CPFs with valid check digits should pass", func() {
+			So(ValidCPF(NormalizeCPF("529.982.247-25")), ShouldBeTrue)
+		})
+		Convey("CPFs with invalid check digits should fail", func() {
+			So(ValidCPF("52998224724"), ShouldBeFalse)
+			So(ValidCPF("11111111111"), ShouldBeFalse)
+			So(ValidCPF("5299822472"), ShouldBeFalse)
+		})
+	})
+	Convey("Given postal codes", t, func() {
+		So(ValidPostalCode("DE", "10115"), ShouldBeTrue)
+		So(ValidPostalCode("BR", "01310-100"), ShouldBeTrue)
+		So(ValidPostalCode("DE", "1011"), ShouldBeFalse)
+	})
+}
+
+func TestFields(t *testing.T) {
+	Convey("Given checkout fields", t, func() {
+		fields := Fields{
+			{Name: FieldVATID, Countries: []string{"DE"}, Optional: true},
+			{Name: FieldAddress},
+		}
+		So(fields.Check(), ShouldBeNil)
+
+		Convey("It should select the fields of the country", func() {
+			So(len(fields.For("DE")), ShouldEqual, 2)
+			So(len(fields.For("BR")), ShouldEqual, 1)
+		})
+		Convey("When fields are required by the provider", func() {
+			req := fields.For("BR").Require(FieldCPF)
+
+			Convey("They should be added as required fields", func() {
+				So(len(req), ShouldEqual, 2)
+				So(req[1], ShouldResemble, Field{Name: FieldCPF})
+				So(req.Complete(&Data{Street: "Av. Paulista 1000", PostalCode: "01310-100", City: "São Paulo"}), ShouldBeFalse)
+			})
+		})
+		Convey("When a valid form is parsed", func() {
+			d, err := fields.Parse("DE", url.Values{
+				ParamStreet:     {"Unter den Linden 1"},
+				ParamPostalCode: {"10117"},
+				ParamCity:       {"Berlin"},
+			})
+
+			Convey("It should return the normalized data", func() {
+				So(err, ShouldBeNil)
+				So(*d, ShouldResemble, Data{Street: "Unter den Linden 1", PostalCode: "10117", City: "Berlin", Country: "DE"})
+				So(fields.Complete(d), ShouldBeTrue)
+			})
+		})
+		Convey("When an invalid form is parsed", func() {
+			_, err := fields.Parse("DE", url.Values{
+				FieldVATID:      {"DE123"},
+				ParamPostalCode: {"1011"},
+			})
+
+			Convey("It should report the invalid fields", func() {
+				errs, ok := err.(validate.Errors)
+				So(ok, ShouldBeTrue)
+				So(len(errs), ShouldEqual, 4)
+				So(*errs[0], ShouldResemble, validate.FieldError{Field: FieldVATID, Code: validate.CodeInvalid})
+				So(*errs[1], ShouldResemble, validate.FieldError{Field: ParamStreet, Code: validate.CodeMissing})
+				So(*errs[2], ShouldResemble, validate.FieldError{Field: ParamPostalCode, Code: validate.CodeInvalid})
+			})
+		})
+	})
+	Convey("Given unknown checkout fields", t, func() {
+		So(Fields{{Name: "IBAN"}}.Check(), ShouldNotBeNil)
+	})
+}
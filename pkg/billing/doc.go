@@ -0,0 +1,25 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package billing provides the checkout fields collected for invoicing.
+
+Projects configure the fields which will be collected on the hosted payment
+pages, e.g. the VAT ID of business customers or the CPF of brazilian customers.
+Fields can be limited to payment countries. The values are validated by the
+rules of the payment country.
+*/
+package billing
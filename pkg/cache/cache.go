@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned if a key does not exist or is expired
+	ErrNotFound = errors.New("key not found")
+)
+
+// Store is a key-value store with expiring keys
+//
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value of the key
+	//
+	// ErrNotFound will be returned if the key does not exist.
+	Get(key string) ([]byte, error)
+	// Set sets the value of the key with the given time to live
+	Set(key string, value []byte, ttl time.Duration) error
+	// SetNX sets the value of the key only if it does not exist
+	//
+	// It returns true if the value was set.
+	SetNX(key string, value []byte, ttl time.Duration) (bool, error)
+	// Incr increments the counter of the key and returns the new value
+	//
+	// The time to live is applied when the counter is created.
+	Incr(key string, ttl time.Duration) (int64, error)
+	// Delete removes the key
+	Delete(key string) error
+}
@@ -0,0 +1,24 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package cache provides a shared key-value store for state which should be shared
+between multiple instances of paymentd
+
+The store is backed by Redis if configured. Otherwise an in-memory store will be
+used, which only shares the state within a single process.
+*/
+package cache
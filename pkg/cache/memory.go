@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// number of writes after which expired entries will be purged
+const memoryPurgeInterval = 1024
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// MemoryStore is an in-memory Store
+type MemoryStore struct {
+	m       sync.Mutex
+	entries map[string]memoryEntry
+	writes  int
+}
+
+// NewMemoryStore creates a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// get returns the entry for the key. The mutex must be held.
+func (s *MemoryStore) get(key string, now time.Time) (memoryEntry, bool) {
+	e, ok := s.entries[key]
+	if !ok {
+		return e, false
+	}
+	if e.expired(now) {
+		delete(s.entries, key)
+		return e, false
+	}
+	return e, true
+}
+
+// set sets the entry for the key. The mutex must be held.
+func (s *MemoryStore) set(key string, e memoryEntry, now time.Time) {
+	s.entries[key] = e
+	s.writes++
+	if s.writes < memoryPurgeInterval {
+		return
+	}
+	s.writes = 0
+	for k, e := range s.entries {
+		if e.expired(now) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	e, ok := s.get(key, time.Now())
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e.value, nil
+}
+
+func (s *MemoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	s.m.Lock()
+	s.set(key, memoryEntry{value: value, expires: expiry(now, ttl)}, now)
+	s.m.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.get(key, now); ok {
+		return false, nil
+	}
+	s.set(key, memoryEntry{value: value, expires: expiry(now, ttl)}, now)
+	return true, nil
+}
+
+func (s *MemoryStore) Incr(key string, ttl time.Duration) (int64, error) {
+	now := time.Now()
+	s.m.Lock()
+	defer s.m.Unlock()
+	e, ok := s.get(key, now)
+	var n int64
+	if ok {
+		var err error
+		n, err = strconv.ParseInt(string(e.value), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		e.expires = expiry(now, ttl)
+	}
+	n++
+	e.value = []byte(strconv.FormatInt(n, 10))
+	s.set(key, e, now)
+	return n, nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.m.Lock()
+	delete(s.entries, key)
+	s.m.Unlock()
+	return nil
+}
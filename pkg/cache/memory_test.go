@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryStore(t *testing.T) {
+	Convey("Given a memory store", t, func() {
+		s := NewMemoryStore()
+
+		Convey("When getting a non-existing key", func() {
+			_, err := s.Get("key")
+			Convey("It should return ErrNotFound", func() {
+				So(err, ShouldEqual, ErrNotFound)
+			})
+		})
+
+		Convey("When setting a key", func() {
+			err := s.Set("key", []byte("value"), time.Minute)
+			So(err, ShouldBeNil)
+			Convey("It should return the value", func() {
+				v, err := s.Get("key")
+				So(err, ShouldBeNil)
+				So(string(v), ShouldEqual, "value")
+			})
+			Convey("SetNX should not overwrite the value", func() {
+				set, err := s.SetNX("key", []byte("other"), time.Minute)
+				So(err, ShouldBeNil)
+				So(set, ShouldBeFalse)
+				v, err := s.Get("key")
+				So(err, ShouldBeNil)
+				So(string(v), ShouldEqual, "value")
+			})
+			Convey("When deleting the key", func() {
+				err = s.Delete("key")
+				So(err, ShouldBeNil)
+				Convey("It should not be found", func() {
+					_, err = s.Get("key")
+					So(err, ShouldEqual, ErrNotFound)
+				})
+			})
+		})
+
+		Convey("When a key expires", func() {
+			err := s.Set("key", []byte("value"), time.Millisecond)
+			So(err, ShouldBeNil)
+			time.Sleep(2 * time.Millisecond)
+			Convey("It should not be found", func() {
+				_, err = s.Get("key")
+				So(err, ShouldEqual, ErrNotFound)
+			})
+			Convey("SetNX should set the value", func() {
+				set, err := s.SetNX("key", []byte("other"), time.Minute)
+				So(err, ShouldBeNil)
+				So(set, ShouldBeTrue)
+			})
+		})
+
+		Convey("When incrementing a counter", func() {
+			n, err := s.Incr("counter", time.Minute)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			n, err = s.Incr("counter", time.Minute)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+		})
+	})
+}
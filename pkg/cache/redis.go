@@ -0,0 +1,311 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	redisDialTimeout = 5 * time.Second
+	redisIOTimeout   = 5 * time.Second
+)
+
+var (
+	// ErrRedisProtocol is returned on unexpected replies of the Redis server
+	ErrRedisProtocol = errors.New("redis protocol error")
+)
+
+// RedisError is an error reply of the Redis server
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// RedisConfig is the configuration of a Redis connection
+type RedisConfig struct {
+	// Address (host:port) of the Redis server
+	Address string
+	// Password for the AUTH command, if any
+	Password string
+	// Database number
+	Database int
+	// Maximum number of idle connections
+	MaxIdle int
+	// KeyPrefix is prepended to all keys
+	KeyPrefix string
+}
+
+// RedisStore is a Store backed by a Redis server
+//
+// Connections are established lazily and pooled.
+type RedisStore struct {
+	cfg RedisConfig
+
+	m    sync.Mutex
+	idle []*redisConn
+}
+
+// NewRedisStore creates a new Redis-backed store
+func NewRedisStore(cfg RedisConfig) *RedisStore {
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = 1
+	}
+	return &RedisStore{cfg: cfg}
+}
+
+type redisConn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (s *RedisStore) dial() (*redisConn, error) {
+	c, err := net.DialTimeout("tcp", s.cfg.Address, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{
+		c: c,
+		r: bufio.NewReader(c),
+		w: bufio.NewWriter(c),
+	}
+	if s.cfg.Password != "" {
+		if _, err = conn.do("AUTH", s.cfg.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if s.cfg.Database != 0 {
+		if _, err = conn.do("SELECT", strconv.Itoa(s.cfg.Database)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (s *RedisStore) get() (*redisConn, error) {
+	s.m.Lock()
+	if n := len(s.idle); n > 0 {
+		conn := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.m.Unlock()
+		return conn, nil
+	}
+	s.m.Unlock()
+	return s.dial()
+}
+
+func (s *RedisStore) put(conn *redisConn) {
+	s.m.Lock()
+	if len(s.idle) < s.cfg.MaxIdle {
+		s.idle = append(s.idle, conn)
+		conn = nil
+	}
+	s.m.Unlock()
+	if conn != nil {
+		conn.c.Close()
+	}
+}
+
+// do executes a command on a pooled connection
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	conn, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(args...)
+	if _, ok := err.(RedisError); err != nil && !ok {
+		// the connection state is unknown
+		conn.c.Close()
+		return nil, err
+	}
+	s.put(conn)
+	return reply, err
+}
+
+// Close closes the idle connections
+func (s *RedisStore) Close() error {
+	s.m.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.m.Unlock()
+	for _, conn := range idle {
+		conn.c.Close()
+	}
+	return nil
+}
+
+func (s *RedisStore) key(key string) string {
+	return s.cfg.KeyPrefix + key
+}
+
+func milliseconds(d time.Duration) string {
+	ms := int64(d / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+func (s *RedisStore) Get(key string) ([]byte, error) {
+	reply, err := s.do("GET", s.key(key))
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrNotFound
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return nil, ErrRedisProtocol
+	}
+	return b, nil
+}
+
+func (s *RedisStore) Set(key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", s.key(key), string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", milliseconds(ttl))
+	}
+	_, err := s.do(args...)
+	return err
+}
+
+func (s *RedisStore) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	args := []string{"SET", s.key(key), string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", milliseconds(ttl))
+	}
+	args = append(args, "NX")
+	reply, err := s.do(args...)
+	if err != nil {
+		return false, err
+	}
+	// a nil reply signals an existing key
+	return reply != nil, nil
+}
+
+func (s *RedisStore) Incr(key string, ttl time.Duration) (int64, error) {
+	reply, err := s.do("INCR", s.key(key))
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, ErrRedisProtocol
+	}
+	if n == 1 && ttl > 0 {
+		_, err = s.do("PEXPIRE", s.key(key), milliseconds(ttl))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (s *RedisStore) Delete(key string) error {
+	_, err := s.do("DEL", s.key(key))
+	return err
+}
+
+// do writes the command and reads the reply
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.c.SetDeadline(time.Now().Add(redisIOTimeout))
+	err := writeCommand(c.w, args)
+	if err != nil {
+		return nil, err
+	}
+	err = c.w.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+func writeCommand(w *bufio.Writer, args []string) error {
+	_, err := fmt.Fprintf(w, "*%d\r\n", len(args))
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		_, err = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", ErrRedisProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// readReply reads a reply
+//
+// Status replies are returned as strings, integers as int64, bulk strings as
+// []byte and arrays as []interface{}. Nil replies are returned as nil.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, ErrRedisProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, ErrRedisProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, ErrRedisProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			arr[i], err = readReply(r)
+			if _, ok := err.(RedisError); err != nil && !ok {
+				return nil, err
+			}
+		}
+		return arr, nil
+	default:
+		return nil, ErrRedisProtocol
+	}
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedisProtocol(t *testing.T) {
+	Convey("Given a command", t, func() {
+		buf := &bytes.Buffer{}
+		w := bufio.NewWriter(buf)
+		err := writeCommand(w, []string{"SET", "key", "value"})
+		So(err, ShouldBeNil)
+		So(w.Flush(), ShouldBeNil)
+		Convey("It should be encoded as an array of bulk strings", func() {
+			So(buf.String(), ShouldEqual, "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n")
+		})
+	})
+
+	Convey("Given replies", t, func() {
+		read := func(s string) (interface{}, error) {
+			return readReply(bufio.NewReader(strings.NewReader(s)))
+		}
+		Convey("Status replies should be read", func() {
+			r, err := read("+OK\r\n")
+			So(err, ShouldBeNil)
+			So(r, ShouldEqual, "OK")
+		})
+		Convey("Error replies should be returned as errors", func() {
+			_, err := read("-ERR unknown command\r\n")
+			So(err, ShouldResemble, RedisError("ERR unknown command"))
+		})
+		Convey("Integer replies should be read", func() {
+			r, err := read(":42\r\n")
+			So(err, ShouldBeNil)
+			So(r, ShouldEqual, int64(42))
+		})
+		Convey("Bulk string replies should be read", func() {
+			r, err := read("$5\r\nva\r\nl\r\n")
+			So(err, ShouldBeNil)
+			So(string(r.([]byte)), ShouldEqual, "va\r\nl")
+		})
+		Convey("Nil replies should be read", func() {
+			r, err := read("$-1\r\n")
+			So(err, ShouldBeNil)
+			So(r, ShouldBeNil)
+		})
+		Convey("Array replies should be read", func() {
+			r, err := read("*2\r\n:1\r\n$1\r\na\r\n")
+			So(err, ShouldBeNil)
+			arr := r.([]interface{})
+			So(len(arr), ShouldEqual, 2)
+			So(arr[0], ShouldEqual, int64(1))
+			So(string(arr[1].([]byte)), ShouldEqual, "a")
+		})
+		Convey("Invalid replies should fail", func() {
+			_, err := read("?\r\n")
+			So(err, ShouldEqual, ErrRedisProtocol)
+		})
+	})
+}
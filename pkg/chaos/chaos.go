@@ -0,0 +1,91 @@
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned by operations failed by an Injector
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Injector delays or fails a percentage of operations
+//
+// It is safe for concurrent use. A nil Injector never injects faults.
+type Injector struct {
+	failPercentage  int
+	delayPercentage int
+	delay           time.Duration
+
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	delayed int64
+	failed  int64
+}
+
+// NewInjector creates an injector failing and delaying the given percentages of
+// operations
+//
+// Delayed operations will be delayed by the given delay before they are
+// performed. An operation can be delayed and failed.
+func NewInjector(failPercentage, delayPercentage int, delay time.Duration) (*Injector, error) {
+	if failPercentage < 0 || failPercentage > 100 {
+		return nil, fmt.Errorf("invalid fail percentage %d", failPercentage)
+	}
+	if delayPercentage < 0 || delayPercentage > 100 {
+		return nil, fmt.Errorf("invalid delay percentage %d", delayPercentage)
+	}
+	if delayPercentage > 0 && delay <= 0 {
+		return nil, fmt.Errorf("invalid delay %s", delay)
+	}
+	return &Injector{
+		failPercentage:  failPercentage,
+		delayPercentage: delayPercentage,
+		delay:           delay,
+		rnd:             rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Inject injects a fault into an operation
+//
+// It should be called before the operation is performed. It sleeps if the
+// operation is delayed and returns ErrInjected if the operation should fail.
+func (i *Injector) Inject() error {
+	if i == nil {
+		return nil
+	}
+	delay, fail := i.roll()
+	if delay {
+		time.Sleep(i.delay)
+	}
+	if fail {
+		return ErrInjected
+	}
+	return nil
+}
+
+func (i *Injector) roll() (delay, fail bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delay = i.rnd.Intn(100) < i.delayPercentage
+	fail = i.rnd.Intn(100) < i.failPercentage
+	if delay {
+		i.delayed++
+	}
+	if fail {
+		i.failed++
+	}
+	return
+}
+
+// Stats returns the numbers of delayed and failed operations
+func (i *Injector) Stats() (delayed, failed int64) {
+	if i == nil {
+		return 0, 0
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.delayed, i.failed
+}
@@ -0,0 +1,149 @@
+package chaos
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return &fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (*fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (*fakeConn) Close() error                              { return nil }
+func (*fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                                    { return nil }
+func (fakeStmt) NumInput() int                                   { return -1 }
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error)  { return fakeRows{}, nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return []string{"a"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+func TestInjector(t *testing.T) {
+	Convey("Given an injector failing all operations", t, func() {
+		i, err := NewInjector(100, 0, 0)
+		So(err, ShouldBeNil)
+
+		Convey("Operations should fail", func() {
+			So(i.Inject(), ShouldEqual, ErrInjected)
+			So(i.Inject(), ShouldEqual, ErrInjected)
+			delayed, failed := i.Stats()
+			So(delayed, ShouldEqual, 0)
+			So(failed, ShouldEqual, 2)
+		})
+	})
+	Convey("Given an injector delaying all operations", t, func() {
+		i, err := NewInjector(0, 100, 10*time.Millisecond)
+		So(err, ShouldBeNil)
+
+		Convey("Operations should be delayed", func() {
+			start := time.Now()
+			So(i.Inject(), ShouldBeNil)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 10*time.Millisecond)
+			delayed, failed := i.Stats()
+			So(delayed, ShouldEqual, 1)
+			So(failed, ShouldEqual, 0)
+		})
+	})
+	Convey("Given a nil injector", t, func() {
+		var i *Injector
+
+		Convey("It should not inject faults", func() {
+			So(i.Inject(), ShouldBeNil)
+		})
+	})
+	Convey("Given invalid percentages", t, func() {
+		_, err := NewInjector(101, 0, 0)
+		So(err, ShouldNotBeNil)
+		_, err = NewInjector(0, -1, time.Second)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Given a delay percentage without a delay", t, func() {
+		_, err := NewInjector(0, 10, 0)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestWrap(t *testing.T) {
+	deadlock := errors.New("deadlock")
+	i, err := NewInjector(100, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql.Register("chaos-test", Wrap(fakeDriver{}, i, deadlock))
+
+	Convey("Given a driver failing all statements", t, func() {
+		db, err := sql.Open("chaos-test", "")
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		Convey("Statements should fail with the fault error", func() {
+			_, err = db.Exec("UPDATE a SET b = ?", 1)
+			So(err, ShouldEqual, deadlock)
+			_, err = db.Query("SELECT a FROM a")
+			So(err, ShouldEqual, deadlock)
+		})
+		Convey("Commits should fail with the fault error", func() {
+			tx, err := db.Begin()
+			So(err, ShouldBeNil)
+			So(tx.Commit(), ShouldEqual, deadlock)
+		})
+	})
+	Convey("Given a nil injector", t, func() {
+		Convey("The parent driver should be returned", func() {
+			So(Wrap(fakeDriver{}, nil, deadlock), ShouldResemble, fakeDriver{})
+		})
+	})
+}
+
+func TestTransport(t *testing.T) {
+	Convey("Given a server", t, func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer srv.Close()
+
+		Convey("Given a transport failing all requests", func() {
+			i, err := NewInjector(100, 0, 0)
+			So(err, ShouldBeNil)
+			cl := &http.Client{Transport: WrapTransport(nil, i)}
+
+			Convey("Requests should fail", func() {
+				_, err := cl.Get(srv.URL)
+				So(err, ShouldNotBeNil)
+			})
+		})
+		Convey("Given a transport without an injector", func() {
+			cl := &http.Client{Transport: WrapTransport(nil, nil)}
+
+			Convey("Requests should succeed", func() {
+				resp, err := cl.Get(srv.URL)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
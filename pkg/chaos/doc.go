@@ -0,0 +1,25 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package chaos provides fault injection for resilience testing
+
+An Injector delays or fails a percentage of operations. Database drivers and HTTP
+transports can be wrapped to inject faults into database statements, provider calls
+and notification deliveries, so that retries and circuit breakers can be validated
+under realistic failure. Faults must never be injected in production.
+*/
+package chaos
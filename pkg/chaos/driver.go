@@ -0,0 +1,128 @@
+package chaos
+
+import (
+	"database/sql/driver"
+)
+
+// Driver is a database/sql driver injecting faults into the statements and
+// commits of another driver
+type Driver struct {
+	parent driver.Driver
+	i      *Injector
+	fault  error
+}
+
+// Wrap returns a driver injecting faults into the given driver
+//
+// Failed statements and commits return the fault error, which should be an error
+// the application is expected to handle, e.g. a deadlock error of the database.
+// Failed statements will not be executed. Failed commits roll back the
+// transaction. If the injector is nil, the parent driver will be returned.
+func Wrap(parent driver.Driver, i *Injector, fault error) driver.Driver {
+	if i == nil {
+		return parent
+	}
+	if fault == nil {
+		fault = ErrInjected
+	}
+	return &Driver{
+		parent: parent,
+		i:      i,
+		fault:  fault,
+	}
+}
+
+// Open implements driver.Driver
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.parent.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, d: d}, nil
+}
+
+// inject returns the fault error if the operation should fail
+func (d *Driver) inject() error {
+	if d.i.Inject() != nil {
+		return d.fault
+	}
+	return nil
+}
+
+type conn struct {
+	driver.Conn
+	d *Driver
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, d: c.d}, nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	t, err := c.Conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &tx{Tx: t, d: c.d}, nil
+}
+
+// Exec implements driver.Execer if the wrapped connection does
+func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	ex, ok := c.Conn.(driver.Execer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.d.inject(); err != nil {
+		return nil, err
+	}
+	return ex.Exec(query, args)
+}
+
+// Query implements driver.Queryer if the wrapped connection does
+func (c *conn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.Queryer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.d.inject(); err != nil {
+		return nil, err
+	}
+	return q.Query(query, args)
+}
+
+type stmt struct {
+	driver.Stmt
+	d *Driver
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := s.d.inject(); err != nil {
+		return nil, err
+	}
+	return s.Stmt.Exec(args)
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	if err := s.d.inject(); err != nil {
+		return nil, err
+	}
+	return s.Stmt.Query(args)
+}
+
+type tx struct {
+	driver.Tx
+	d *Driver
+}
+
+func (t *tx) Commit() error {
+	if err := t.d.inject(); err != nil {
+		// the transaction must not stay open on the connection
+		t.Tx.Rollback()
+		return err
+	}
+	return t.Tx.Commit()
+}
@@ -0,0 +1,59 @@
+package chaos
+
+import (
+	"net/http"
+)
+
+// Transport is an http.RoundTripper injecting faults into the requests of
+// another transport
+//
+// Failed requests will not be sent.
+type Transport struct {
+	// Transport performs the requests. If nil, the http.DefaultTransport will
+	// be used
+	Transport http.RoundTripper
+	Injector  *Injector
+}
+
+// WrapTransport returns a transport injecting faults into the requests of the
+// given transport
+//
+// If the injector is nil, the parent transport will be returned.
+func WrapTransport(parent http.RoundTripper, i *Injector) http.RoundTripper {
+	if i == nil {
+		return parent
+	}
+	return &Transport{
+		Transport: parent,
+		Injector:  i,
+	}
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip implements the http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Injector.Inject(); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.transport().RoundTrip(req)
+}
+
+// CancelRequest cancels an in-flight request
+func (t *Transport) CancelRequest(req *http.Request) {
+	canceler, ok := t.transport().(interface {
+		CancelRequest(*http.Request)
+	})
+	if !ok {
+		return
+	}
+	canceler.CancelRequest(req)
+}
@@ -0,0 +1,234 @@
+package callback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/client"
+	jsonutil "github.com/fritzpay/paymentd/pkg/json"
+)
+
+// SignatureHeader is the header of callback requests, which holds the ID of the
+// signing key and the signature of the notification
+const SignatureHeader = "X-Paymentd-Signature"
+
+// DefaultMaxAge is the default maximum age of notifications
+const DefaultMaxAge = 5 * time.Minute
+
+// maxBodySize is the maximum size of notifications read from requests
+const maxBodySize = 1 << 20
+
+var (
+	// ErrNoSignature is returned if the notification is not signed with a
+	// known key
+	ErrNoSignature = errors.New("notification not signed with a known key")
+	// ErrInvalidSignature is returned if the signature of the notification is
+	// invalid
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrExpired is returned if the timestamp of the notification is not
+	// within the maximum age
+	ErrExpired = errors.New("notification expired")
+	// ErrReplayed is returned if the nonce of the notification was seen before
+	ErrReplayed = errors.New("notification replayed")
+)
+
+// Key is a callback project key
+type Key struct {
+	// Secret is the binary secret of the project key
+	Secret []byte
+	// CanonicalJSON must be set if the project key uses the canonical_json
+	// signature mode
+	CanonicalJSON bool
+}
+
+// NonceStore remembers the nonces of verified notifications
+type NonceStore interface {
+	// Seen records the nonce until it expires and returns true if it was
+	// recorded before
+	Seen(nonce string, expires time.Time) bool
+}
+
+// MemoryNonceStore is a NonceStore for a single process
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewMemoryNonceStore creates a new in-memory nonce store
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+// Seen implements the NonceStore
+//
+// Expired nonces are removed on every call.
+func (s *MemoryNonceStore) Seen(nonce string, expires time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for n, exp := range s.nonces {
+		if now.After(exp) {
+			delete(s.nonces, n)
+		}
+	}
+	if _, ok := s.nonces[nonce]; ok {
+		return true
+	}
+	s.nonces[nonce] = expires
+	return false
+}
+
+// Verifier verifies payment notifications
+type Verifier struct {
+	// Keys are the callback project keys by their ID
+	Keys map[string]Key
+	// MaxAge is the maximum difference between the timestamp of a
+	// notification and the time it is verified
+	MaxAge time.Duration
+	// Nonces remembers the nonces of verified notifications
+	Nonces NonceStore
+
+	now func() time.Time
+}
+
+// NewVerifier creates a verifier for the given callback project keys and their
+// hex encoded secrets
+//
+// The keys are expected to use the base_string signature mode. The verifier
+// remembers the nonces in memory.
+func NewVerifier(hexSecrets map[string]string) (*Verifier, error) {
+	v := &Verifier{
+		Keys:   make(map[string]Key, len(hexSecrets)),
+		MaxAge: DefaultMaxAge,
+		Nonces: NewMemoryNonceStore(),
+	}
+	for id, hexSecret := range hexSecrets {
+		secret, err := hex.DecodeString(hexSecret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret of key %s: %v", id, err)
+		}
+		v.Keys[id] = Key{Secret: secret}
+	}
+	return v, nil
+}
+
+// VerifyRequest reads and verifies the payment notification of a callback
+// request
+func (v *Verifier) VerifyRequest(r *http.Request) (*client.PaymentNotification, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return v.Verify(r.Header, body)
+}
+
+// Verify verifies a payment notification with the headers of its callback
+// request
+//
+// The notification must be signed with one of the keys of the verifier, its
+// timestamp must be within the maximum age and its nonce may not have been
+// seen before.
+func (v *Verifier) Verify(header http.Header, body []byte) (*client.PaymentNotification, error) {
+	n := &client.PaymentNotification{}
+	err := json.Unmarshal(body, n)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification: %v", err)
+	}
+	sigs := signatures(header)
+	if len(sigs) == 0 {
+		return nil, ErrNoSignature
+	}
+	var signed bool
+	for id, sig := range sigs {
+		k, ok := v.Keys[id]
+		if !ok {
+			continue
+		}
+		signed = true
+		ok, err = k.verify(n, body, sig)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return n, v.checkReplay(n)
+		}
+	}
+	if !signed {
+		return nil, ErrNoSignature
+	}
+	return nil, ErrInvalidSignature
+}
+
+func (v *Verifier) checkReplay(n *client.PaymentNotification) error {
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	maxAge := v.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultMaxAge
+	}
+	ts := time.Unix(n.Timestamp, 0)
+	if ts.Before(now.Add(-maxAge)) || ts.After(now.Add(maxAge)) {
+		return ErrExpired
+	}
+	if n.Nonce == "" {
+		return ErrReplayed
+	}
+	if v.Nonces != nil && v.Nonces.Seen(n.Nonce, ts.Add(maxAge)) {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// signatures returns the hex encoded signatures of the signature headers by
+// key ID
+func signatures(header http.Header) map[string]string {
+	sigs := make(map[string]string)
+	for _, h := range header[http.CanonicalHeaderKey(SignatureHeader)] {
+		var id, sig string
+		for _, part := range strings.Split(h, ",") {
+			kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "keyId":
+				id = kv[1]
+			case "signature":
+				sig = kv[1]
+			}
+		}
+		if id != "" && sig != "" {
+			sigs[id] = sig
+		}
+	}
+	return sigs
+}
+
+func (k Key) verify(n *client.PaymentNotification, body []byte, hexSignature string) (bool, error) {
+	sig, err := hex.DecodeString(hexSignature)
+	if err != nil {
+		return false, nil
+	}
+	msg := n.Message()
+	if k.CanonicalJSON {
+		msg, err = jsonutil.Canonical(body, "Signature")
+		if err != nil {
+			return false, fmt.Errorf("invalid notification: %v", err)
+		}
+	}
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write(msg)
+	return hmac.Equal(sig, mac.Sum(nil)), nil
+}
@@ -0,0 +1,202 @@
+package callback
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	notification "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testKey      = "testkey"
+	testSecret   = "aabbccddeeff00112233445566778899"
+	testPrevKey  = "prevkey"
+	testPrevHex  = "00112233445566778899aabbccddeeff"
+	testNonceStr = "servernonce"
+)
+
+// testNotification returns a notification signed by the server implementation,
+// its body and its callback request headers
+func testNotification(ts time.Time, nonce string, canonical bool) (*notification.Notification, []byte, http.Header) {
+	secret, _ := hex.DecodeString(testSecret)
+	n := &notification.Notification{
+		Version:       notification.PaymentNotificationVersion,
+		PaymentId:     payment.PaymentID{ProjectID: 1, PaymentID: 2},
+		Ident:         "order-1",
+		Amount:        1234,
+		Subunits:      2,
+		DecimalAmount: "12.34",
+		Currency:      "EUR",
+		Country:       "DE",
+		Status:        "paid",
+		Metadata:      map[string]string{"order": "1"},
+	}
+	if canonical {
+		n.UseCanonicalJSON()
+	}
+	So(n.Sign(ts, nonce, secret), ShouldBeNil)
+	r := n.Reader()
+	body, err := ioutil.ReadAll(r)
+	So(err, ShouldBeNil)
+	r.Close()
+	h := http.Header{}
+	h.Add(SignatureHeader, "keyId="+testKey+",signature="+n.Signature)
+	return n, body, h
+}
+
+func TestVerifier(t *testing.T) {
+	Convey("Given a verifier", t, func() {
+		v, err := NewVerifier(map[string]string{testKey: testSecret})
+		So(err, ShouldBeNil)
+		now := time.Now()
+		v.now = func() time.Time { return now }
+
+		Convey("When verifying a valid notification", func() {
+			_, body, h := testNotification(now, testNonceStr, false)
+			n, err := v.Verify(h, body)
+			Convey("It should return the notification", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldNotBeNil)
+				So(n.Ident, ShouldEqual, "order-1")
+				So(n.Status, ShouldEqual, "paid")
+				So(n.Nonce, ShouldEqual, testNonceStr)
+			})
+			Convey("When verifying the notification again", func() {
+				_, err = v.Verify(h, body)
+				Convey("It should be rejected as replayed", func() {
+					So(err, ShouldEqual, ErrReplayed)
+				})
+			})
+		})
+
+		Convey("When verifying a valid request", func() {
+			_, body, h := testNotification(now, testNonceStr, false)
+			r, err := http.NewRequest("POST", "http://localhost/callback", bytes.NewReader(body))
+			So(err, ShouldBeNil)
+			r.Header = h
+			n, err := v.VerifyRequest(r)
+			Convey("It should return the notification", func() {
+				So(err, ShouldBeNil)
+				So(n.Ident, ShouldEqual, "order-1")
+			})
+		})
+
+		Convey("When verifying a tampered notification", func() {
+			_, body, h := testNotification(now, testNonceStr, false)
+			body = bytes.Replace(body, []byte(`"12.34"`), []byte(`"99.99"`), 1)
+			_, err := v.Verify(h, body)
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, ErrInvalidSignature)
+			})
+		})
+
+		Convey("When verifying an expired notification", func() {
+			_, body, h := testNotification(now.Add(-2*DefaultMaxAge), testNonceStr, false)
+			_, err := v.Verify(h, body)
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, ErrExpired)
+			})
+		})
+
+		Convey("When verifying a notification from the future", func() {
+			_, body, h := testNotification(now.Add(2*DefaultMaxAge), testNonceStr, false)
+			_, err := v.Verify(h, body)
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, ErrExpired)
+			})
+		})
+
+		Convey("When verifying a notification without a nonce", func() {
+			_, body, h := testNotification(now, "", false)
+			_, err := v.Verify(h, body)
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, ErrReplayed)
+			})
+		})
+
+		Convey("When verifying a notification without signature header", func() {
+			_, body, _ := testNotification(now, testNonceStr, false)
+			_, err := v.Verify(http.Header{}, body)
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, ErrNoSignature)
+			})
+		})
+
+		Convey("When verifying a notification signed with an unknown key", func() {
+			_, body, h := testNotification(now, testNonceStr, false)
+			h.Set(SignatureHeader, "keyId=otherkey,signature="+h.Get(SignatureHeader))
+			_, err := v.Verify(h, body)
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, ErrNoSignature)
+			})
+		})
+
+		Convey("Given the verifier only knows the previous key", func() {
+			v, err = NewVerifier(map[string]string{testPrevKey: testPrevHex})
+			So(err, ShouldBeNil)
+			v.now = func() time.Time { return now }
+
+			Convey("When verifying a notification signed during a key overlap", func() {
+				n, body, h := testNotification(now, testNonceStr, false)
+				prev, _ := hex.DecodeString(testPrevHex)
+				sig, err := n.KeySignature(prev, false)
+				So(err, ShouldBeNil)
+				h.Add(SignatureHeader, "keyId="+testPrevKey+",signature="+sig)
+				_, err = v.Verify(h, body)
+				Convey("It should accept the signature of the previous key", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("Given a canonical JSON key", func() {
+			k := v.Keys[testKey]
+			k.CanonicalJSON = true
+			v.Keys[testKey] = k
+
+			Convey("When verifying a notification in canonical mode", func() {
+				_, body, h := testNotification(now, testNonceStr, true)
+				_, err := v.Verify(h, body)
+				Convey("It should be accepted", func() {
+					So(err, ShouldBeNil)
+				})
+			})
+			Convey("When verifying a notification in base string mode", func() {
+				_, body, h := testNotification(now, testNonceStr, false)
+				_, err := v.Verify(h, body)
+				Convey("It should be rejected", func() {
+					So(err, ShouldEqual, ErrInvalidSignature)
+				})
+			})
+		})
+	})
+
+	Convey("When creating a verifier with an invalid secret", t, func() {
+		_, err := NewVerifier(map[string]string{testKey: "xyz"})
+		Convey("It should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	Convey("Given a memory nonce store", t, func() {
+		s := NewMemoryNonceStore()
+		Convey("A new nonce should not be seen", func() {
+			So(s.Seen("a", time.Now().Add(time.Minute)), ShouldBeFalse)
+			Convey("A recorded nonce should be seen", func() {
+				So(s.Seen("a", time.Now().Add(time.Minute)), ShouldBeTrue)
+			})
+		})
+		Convey("An expired nonce should be forgotten", func() {
+			So(s.Seen("b", time.Now().Add(-time.Minute)), ShouldBeFalse)
+			So(s.Seen("b", time.Now().Add(time.Minute)), ShouldBeFalse)
+		})
+	})
+}
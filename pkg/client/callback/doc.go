@@ -0,0 +1,46 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package callback verifies the callback notifications of paymentd on the side of
+the receiving system
+
+The verifier checks the signatures of payment notifications with the secrets of
+the callback project keys, in both signature modes. It supports the rotation of
+callback keys, i.e. it accepts any of the signatures in the X-Paymentd-Signature
+headers made with a known key.
+
+Notifications are protected against replays: notifications with a timestamp
+outside of the maximum age are rejected, as are notifications with a nonce which
+was seen before. The nonces are remembered by a NonceStore. Systems with more
+than one receiving process have to share the nonces, e.g. in their database.
+
+	v, err := callback.NewVerifier(map[string]string{"projectkey": "<hex secret>"})
+	...
+	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		n, err := v.VerifyRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// process the notification
+	})
+
+Notifications are delivered at least once. A repeated delivery of a
+notification carries a new timestamp and nonce, so receivers still have to
+process notifications idempotently.
+*/
+package callback
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/api/v1"
+	notification "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testSecret = "aabbccddeeff00112233445566778899"
+
+// testServer authenticates requests with the server implementation of the
+// payment API messages and responds with messages signed by the server
+// implementation
+func testServer(secret []byte) http.Handler {
+	fail := func(w http.ResponseWriter, err error) {
+		resp := v1.ErrUnauthorized
+		if err != nil {
+			resp = v1.ErrInval
+			resp.Info = err.Error()
+		}
+		resp.Write(w)
+	}
+	paymentID := payment.PaymentID{ProjectID: 1, PaymentID: 2}
+	r := mux.NewRouter()
+	r.HandleFunc(v1.ServicePath+"/payment", func(w http.ResponseWriter, r *http.Request) {
+		req := &v1.InitPaymentRequest{}
+		err := req.ReadJSON(r.Body)
+		if err == nil {
+			err = req.Validate()
+		}
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		if ok, err := service.IsAuthentic(req, secret); !ok || err != nil {
+			fail(w, err)
+			return
+		}
+		resp := &v1.InitPaymentResponse{}
+		resp.Confirmation.Ident = req.Ident
+		resp.Confirmation.Amount = req.Amount.Int64
+		resp.Confirmation.Subunits = req.Subunits.Int8
+		resp.Confirmation.Currency = req.Currency
+		resp.Confirmation.Country = req.Country
+		resp.Confirmation.Locale = req.Locale
+		resp.Confirmation.Metadata = req.Metadata
+		resp.Payment.PaymentId = paymentID
+		resp.Payment.Created = time.Now().Format(time.RFC3339)
+		resp.Payment.Token = "token"
+		resp.Timestamp = time.Now().Unix()
+		resp.Nonce = "servernonce"
+		sig, err := service.Sign(resp, secret)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		resp.Signature = hex.EncodeToString(sig)
+		(&v1.ServiceResponse{
+			HttpStatus: http.StatusOK,
+			Status:     v1.StatusSuccess,
+			Response:   resp,
+		}).Write(w)
+	}).Methods("POST")
+	r.HandleFunc(v1.ServicePath+"/payment/paymentId/{paymentId}", func(w http.ResponseWriter, r *http.Request) {
+		req := &v1.GetPaymentRequest{}
+		err := req.ReadFromRequest(r)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		if ok, err := service.IsAuthentic(req, secret); !ok || err != nil {
+			fail(w, err)
+			return
+		}
+		n := &notification.Notification{
+			Version:       notification.PaymentNotificationVersion,
+			PaymentId:     paymentID,
+			Ident:         "order-1",
+			Amount:        1234,
+			Subunits:      2,
+			DecimalAmount: "12.34",
+			Currency:      "EUR",
+			Country:       "DE",
+			Status:        "paid",
+		}
+		err = n.Sign(time.Now(), "servernonce", secret)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		(&v1.ServiceResponse{
+			HttpStatus: http.StatusOK,
+			Status:     v1.StatusSuccess,
+			Response:   n,
+		}).Write(w)
+	}).Methods("GET")
+	return r
+}
+
+func TestClient(t *testing.T) {
+	Convey("Given a payment API server", t, func() {
+		secret, err := hex.DecodeString(testSecret)
+		So(err, ShouldBeNil)
+		srv := httptest.NewServer(testServer(secret))
+		Reset(srv.Close)
+
+		Convey("Given a client with the correct secret", func() {
+			c, err := NewClient(srv.URL, "testkey", testSecret)
+			So(err, ShouldBeNil)
+
+			Convey("When initializing a payment", func() {
+				resp, err := c.InitPayment(&InitPaymentRequest{
+					Ident:    "order-1",
+					Amount:   1234,
+					Subunits: 2,
+					Currency: "EUR",
+					Country:  "DE",
+					Locale:   "de_DE",
+					Metadata: map[string]string{"b": "2", "a": "1"},
+				})
+
+				Convey("The server should accept the request", func() {
+					So(err, ShouldBeNil)
+					Convey("The client should verify the response", func() {
+						So(resp.Confirmation.Ident, ShouldEqual, "order-1")
+						So(resp.Confirmation.Amount, ShouldEqual, 1234)
+						So(resp.Payment.PaymentId, ShouldEqual, "1-2")
+					})
+				})
+			})
+
+			Convey("When retrieving a payment", func() {
+				resp, err := c.GetPaymentByID(&GetPaymentByIDRequest{PaymentId: "1-2"})
+
+				Convey("The server should accept the request", func() {
+					So(err, ShouldBeNil)
+					Convey("The client should verify the response", func() {
+						So(resp.PaymentId, ShouldEqual, "1-2")
+						So(resp.Status, ShouldEqual, "paid")
+					})
+				})
+			})
+		})
+
+		Convey("Given a client with a wrong secret", func() {
+			c, err := NewClient(srv.URL, "testkey", "00112233")
+			So(err, ShouldBeNil)
+
+			Convey("When initializing a payment", func() {
+				_, err := c.InitPayment(&InitPaymentRequest{
+					Ident:    "order-1",
+					Amount:   1234,
+					Subunits: 2,
+					Currency: "EUR",
+					Country:  "DE",
+				})
+
+				Convey("The server should reject the request", func() {
+					So(err, ShouldNotBeNil)
+					apiErr, ok := err.(*Error)
+					So(ok, ShouldBeTrue)
+					So(apiErr.HTTPStatus, ShouldEqual, http.StatusUnauthorized)
+				})
+			})
+		})
+	})
+}
+
+func TestMessageVerify(t *testing.T) {
+	Convey("Given a signed payment notification", t, func() {
+		secret := []byte("secret")
+		n := &PaymentNotification{
+			Version:   "2.0.0-alpha",
+			PaymentId: "1-2",
+			Ident:     "order-1",
+			Amount:    1234,
+			Subunits:  2,
+			Currency:  "EUR",
+			Timestamp: 1418135200,
+			Nonce:     "abc",
+		}
+		n.Sign(secret)
+
+		Convey("It should verify with the secret", func() {
+			So(n.Verify(secret), ShouldBeTrue)
+		})
+		Convey("When the notification is modified", func() {
+			n.Amount = 1
+
+			Convey("It should not verify", func() {
+				So(n.Verify(secret), ShouldBeFalse)
+			})
+		})
+	})
+}
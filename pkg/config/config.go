@@ -36,6 +36,17 @@ func (d DatabaseConfig) DSN() string {
 	panic("invalid database config")
 }
 
+// DatabaseShard is a shard of the payment database holding the data of the
+// listed projects
+type DatabaseShard struct {
+	// Name of the shard
+	Name string
+	// IDs of the projects routed to the shard
+	Projects []int64
+	Write    DatabaseConfig
+	ReadOnly DatabaseConfig
+}
+
 type Duration string
 
 func (d Duration) Duration() (time.Duration, error) {
@@ -50,6 +61,64 @@ type ServiceConfig struct {
 	MaxHeaderBytes int
 }
 
+// FeatureFlag represents the default state of a feature flag
+type FeatureFlag struct {
+	// Whether the feature is enabled for all projects
+	Enabled bool
+	// Percentage of projects for which the feature is enabled
+	Percentage int
+	// IDs of projects for which the feature is enabled
+	Projects []int64
+}
+
+// AlertSink is a chat channel receiving alerts
+type AlertSink struct {
+	// Type is either "slack" or "teams"
+	Type string
+	// URL of the incoming webhook
+	URL string
+	// Minimum severity of the alerts posted to the sink. Either "info",
+	// "warning" or "critical"
+	Severity string
+}
+
+// EndpointPool represents equivalent regional endpoints of a provider
+type EndpointPool struct {
+	// URLs of the endpoints. Requests to any of the endpoints will be routed to
+	// the healthy endpoint with the lowest latency
+	URLs []string
+	// Path requested to probe the health of the endpoints
+	ProbePath     string
+	ProbeInterval Duration
+}
+
+// Egress represents the outgoing connection settings of a provider
+type Egress struct {
+	// Local IP address the connections will be bound to
+	LocalAddress string
+	// URL of an HTTP or SOCKS5 proxy, e.g. "socks5://10.0.0.1:1080"
+	Proxy string
+}
+
+// Rounding represents a rounding policy for amounts sent to a provider
+type Rounding struct {
+	// Mode is one of "halfUp", "halfEven" (banker's rounding) or "down"
+	Mode string
+	// Decimal places by currency code overriding the ISO 4217 decimal places
+	Exponents map[string]int32
+}
+
+// Fault represents the faults injected into one kind of operations in chaos
+// mode
+type Fault struct {
+	// Percentage of operations which will fail
+	FailPercentage int
+	// Percentage of operations which will be delayed
+	DelayPercentage int
+	// Delay of delayed operations
+	Delay Duration
+}
+
 // Config represents a full configuration for any paymentd related applications
 type Config struct {
 	// Payment config
@@ -58,6 +127,65 @@ type Config struct {
 		PaymentIDEncPrime int64
 		// XOR value to be applied to obfuscated primes
 		PaymentIDEncXOR int64
+		// Payment token mode, either "database" for random tokens stored in the
+		// database or "encrypted" for stateless encrypted tokens
+		TokenMode string
+		// Hex-encoded keys for encrypted payment tokens. The first key will be
+		// used for encrypting tokens
+		TokenKeys []string
+		// Tolerated underpayment of transfer-based payment methods in percent of
+		// the payment amount, e.g. "0.5"
+		UnderpaymentTolerance string
+		// Handling of overpayments of transfer-based payment methods, one of
+		// "accept", "refund" or "credit"
+		OverpaymentPolicy string
+		// Handling of intents committed after the commit timeout, either
+		// "reject" or "execute"
+		LateCommitPolicy string
+		// Time within which payments held for manual review should be
+		// reviewed
+		ReviewSLA Duration
+		// In test mode, payments with well-known test card or account numbers
+		// will not be processed by the provider but short-circuited to the
+		// outcome of the test number. Must not be enabled in production
+		TestMode bool
+		// Record every payment transaction in a hash-chained event log for
+		// tamper-evidence audits
+		EventLog bool
+		// Time to live of checkout sessions. It will be renewed with every
+		// update of a session
+		SessionTTL Duration
+		// Project the change feed to the denormalized payment overview, which
+		// backs the payment overview listing
+		Projection bool
+		// Delivery of callback notifications
+		Notification struct {
+			// Maximum number of concurrent deliveries of the instance
+			Concurrency int
+			// Timeout of a delivery
+			Timeout Duration
+			// Number of consecutive timeouts of a callback endpoint, after
+			// which its notifications will be delivered in digest mode. 0
+			// disables the digest mode on timeouts
+			DigestTimeouts int
+			// Number of notifications queued for a callback endpoint, beyond
+			// which its notifications will be delivered in digest mode. 0
+			// disables the digest mode on queue depth
+			DigestQueueDepth int
+			// Maximum number of delivery attempts of a notification, after
+			// which it will be marked as failed
+			MaxAttempts int
+			// Delay before the first retry of a failed delivery. It will be
+			// doubled for every further retry
+			RetryBackoff Duration
+		}
+		// Dunning of failed recurring charges of subscriptions
+		Dunning struct {
+			// Delays of the retries after consecutive failed charges of a
+			// cycle, e.g. ["24h", "72h"]. Subscriptions are suspended once
+			// the last retry failed. Empty suspends them on the first failure
+			RetrySchedule []Duration
+		}
 	}
 	// Database config
 	Database struct {
@@ -67,6 +195,45 @@ type Config struct {
 		MaxOpenConns int
 		// Maximum number of idle connections in the connection pool
 		MaxIdleConns int
+		// Queries taking longer will be logged. Empty disables slow query
+		// logging
+		SlowQueryThreshold Duration
+		// Development mode: explain the SELECT statements, log their query
+		// plans and warn about missing indexes. Must not be enabled in
+		// production
+		IndexAdvisor bool
+		// Health checks of the database connections
+		HealthCheck struct {
+			// Interval of the checks. Empty disables the checks
+			Interval Duration
+			// Checks taking longer fail
+			Timeout Duration
+			// Requests shed while reconnecting should be retried after this
+			// duration
+			RetryAfter Duration
+		}
+		// Queue of provider notifications and shopper returns received while
+		// a write connection is reconnecting
+		OutageQueue struct {
+			// Directory in which the requests will be queued. Empty disables
+			// the queue
+			Dir string
+			// Maximum number of queued requests. Further requests will be shed
+			MaxRequests int
+		}
+		// Ingestion mode for high payment throughput. Payment inserts of
+		// concurrent API requests are committed in shared transactions
+		WriteBatch struct {
+			// Enables the batching of payment inserts
+			Enabled bool
+			// Maximum time a payment insert waits for further inserts of its
+			// batch
+			MaxDelay Duration
+			// Maximum number of payment inserts in a batch
+			MaxSize int
+			// Number of batches committed concurrently
+			Workers int
+		}
 		// Principal database
 		Principal struct {
 			Write    DatabaseConfig
@@ -76,8 +243,28 @@ type Config struct {
 		Payment struct {
 			Write    DatabaseConfig
 			ReadOnly DatabaseConfig
+			// Shards of the payment database. Projects not routed to a shard
+			// use the payment database
+			Shards []DatabaseShard
 		}
 	}
+	// Shared cache config
+	Cache struct {
+		// Address (host:port) of the Redis server. In-memory caches will be
+		// used if empty
+		RedisAddress string
+		// Password for the Redis server
+		RedisPassword string
+		// Redis database number
+		RedisDatabase int
+		// Maximum number of idle Redis connections
+		RedisMaxIdle int
+		// Prefix for all keys
+		RedisKeyPrefix string
+		// Pre-load the cache on startup. The instance will only report ready
+		// once the cache is warm
+		Warm bool
+	}
 	// API server config
 	API struct {
 		// Should the API server be activated?
@@ -102,6 +289,90 @@ type Config struct {
 		AdminGUIPubWWWDir string
 
 		AuthKeys []string
+		// Maximum number of payment API requests per client address and minute.
+		// 0 disables the limit
+		RequestRateLimit int
+		// Hex-encoded keys for signing project configuration bundles. The
+		// first key will be used for signing. All keys will be accepted when
+		// importing bundles
+		BundleKeys []string
+		// Lockout after repeated authentication failures of project keys,
+		// admin users and client addresses
+		Lockout struct {
+			// Number of failures within the window after which the subject will
+			// be locked out. 0 disables lockouts
+			MaxFailures int
+			Window      Duration
+			// Duration of a lockout
+			Duration Duration
+			// Attempts will be refused for this delay after a failure. The delay
+			// doubles with each further failure up to MaxDelay
+			Delay    Duration
+			MaxDelay Duration
+		}
+		// Limits of request bodies, protecting against oversized requests
+		Body struct {
+			// Default maximum body size in bytes. 0 disables the limit
+			MaxSize int64
+			// Maximum body sizes by endpoint, e.g. "POST /v1/payment"
+			Sizes map[string]int64
+			// Maximum number of entries of a JSON object or array, e.g. of
+			// the payment metadata. 0 disables the limit
+			MaxEntries int
+		}
+		// Latency objectives of the API endpoints
+		SLO struct {
+			// Default latency target of an endpoint
+			Latency Duration
+			// Percentage of requests which should complete successfully within
+			// the latency target, e.g. 99.5
+			Objective float64
+			// Latency targets by endpoint, e.g. "POST /v1/payment"
+			Targets map[string]Duration
+		}
+		// Usage analytics of the projects
+		Usage struct {
+			// Interval in which the request counts will be recorded. Empty
+			// disables the usage analytics
+			FlushInterval Duration
+			// Number of endpoints listed in usage summaries
+			TopEndpoints int
+		}
+		// OpenID Connect login for admin users
+		OIDC struct {
+			// Should admin users be able to log in with the identity provider?
+			Active bool
+			// Issuer URL of the identity provider
+			Issuer       string
+			ClientID     string
+			ClientSecret string
+			// The callback URL as registered with the identity provider
+			RedirectURL string
+			// Scopes requested in addition to "openid"
+			Scopes []string
+			// The ID token claim holding the user name
+			UserClaim string
+			// The ID token claim holding the groups of the user
+			GroupsClaim string
+			// Role grants by group
+			GroupRoles map[string][]string
+			// URL to redirect to after logging in, e.g. the admin GUI
+			ReturnURL string
+		}
+		// Self-service onboarding of merchants
+		Onboarding struct {
+			// Should prospective merchants be able to submit applications?
+			Active bool
+			// Maximum number of applications per client address and hour. 0
+			// disables the limit
+			RequestRateLimit int
+			// Addresses of the operators, which will be notified of new
+			// applications
+			NotifyAddresses []string
+			// Project config of the projects of approved applications, e.g.
+			// {"PaymentExpiry": 86400}
+			ProjectConfig json.RawMessage
+		}
 	}
 	// Web server config
 	Web struct {
@@ -118,9 +389,27 @@ type Config struct {
 		PubWWWDir string
 		// Template (base-)directory
 		TemplateDir string
+		// Interval in which the web and provider template directories will be
+		// checked for changes. Changed templates will be reloaded. Empty
+		// disables watching
+		TemplateWatchInterval Duration
 
 		// Whether the WWW-service is served securely
 		Secure bool
+		// Proxies (IP addresses or CIDR networks) which are trusted to set the
+		// X-Forwarded-Proto and X-Forwarded-Host headers
+		TrustedProxies []string
+		// TLS config, if the WWW-service should serve HTTPS itself
+		//
+		// Certificates for project checkout domains will be selected by SNI.
+		// The default certificate will be used for all other hosts.
+		TLS struct {
+			CertFile string
+			KeyFile  string
+			// Directory of the certificate and key files of the checkout
+			// domains. Domains can only be assigned files in this directory.
+			DomainCertDir string
+		}
 
 		// Cookie config
 		Cookie struct {
@@ -129,11 +418,129 @@ type Config struct {
 		}
 		// Web auth keys for encrypting cookie auth containers
 		AuthKeys []string
+
+		// Limits of the provider init attempts of the hosted checkout, protecting
+		// against card testing. Exceeding attempts are answered with a
+		// throttling page
+		CheckoutLimit struct {
+			// Maximum attempts per payment within the window. 0 disables the
+			// limit
+			PaymentAttempts int
+			// Maximum attempts per client address within the window. 0
+			// disables the limit
+			AddressAttempts int
+			// Empty disables the limits
+			Window Duration
+		}
+
+		// Customer portal config
+		//
+		// The customer portal lists the payments of a customer. It can only be
+		// accessed with a link signed by the merchant.
+		CustomerPortal struct {
+			Active bool
+			// Name of the payment metadata value which identifies the customer
+			MetadataKey string
+			// Maximum number of payments to list
+			MaxPayments int
+		}
 	}
 	Provider struct {
 		URL string
 
 		ProviderTemplateDir string
+
+		// Rounding policies by provider name
+		Rounding map[string]Rounding
+		// Pools of regional endpoints by provider name
+		EndpointPools map[string][]EndpointPool
+		// Outgoing connection settings by provider name
+		Egress map[string]Egress
+		// Path of the local BIN dataset (CSV file), which will be imported by
+		// the bin.refresh job. Empty disables the imports
+		BINDataset string
+	}
+	// Background job config
+	Jobs struct {
+		// Should this instance run the background jobs? Jobs can be run on all
+		// instances, but running them on one instance only avoids redundant work
+		Active bool
+		// Maximum number of jobs running at the same time
+		Workers int
+		// Schedules overriding the default job schedules by job name. An empty
+		// schedule disables the scheduled runs of a job
+		Schedules map[string]string
+	}
+	// Compliance archive config
+	Archive struct {
+		// Target of the archives, either "dir" or "s3". Empty disables the
+		// archives
+		Target string
+		// Directory of the "dir" target
+		Dir string
+		// S3 target. Object lock must be enabled on the bucket
+		S3 struct {
+			// Base URL of the S3 service, e.g.
+			// "https://s3.eu-central-1.amazonaws.com"
+			Endpoint        string
+			Region          string
+			Bucket          string
+			Prefix          string
+			AccessKeyID     string
+			SecretAccessKey string
+			// Objects will be locked in compliance mode for this number of
+			// days. 0 disables object lock
+			RetentionDays int
+		}
+		// Hex-encoded keys for signing the archive manifests. The first key
+		// will be used for signing
+		Keys []string
+	}
+	// Operational alerts config
+	Alerts struct {
+		// Alerts on the same anomaly will not be repeated within this duration
+		Cooldown Duration
+		// Sinks receiving the alerts of all principals
+		Sinks []AlertSink
+		// Alert if the share of declined payments of a project exceeds the
+		// percentage within the window
+		DeclineSpike struct {
+			Window Duration
+			// Minimum number of declines within the window. 0 disables the
+			// alert
+			MinDeclines int
+			Percentage  int
+		}
+		// Alert if this many provider webhooks are pending in the dead-letter
+		// queue. 0 disables the alert
+		DeadLetters struct {
+			MaxPending int
+		}
+	}
+	// Outgoing mail config
+	Mail struct {
+		// Address of the SMTP server, e.g. "smtp.example.com:587". Empty
+		// disables sending mails, they will be logged instead
+		SMTPAddress string
+		// Credentials for PLAIN authentication. Empty disables authentication
+		Username string
+		Password string
+		// Sender address of the mails
+		From string
+	}
+	// Default feature flags by name. Flags stored in the database take
+	// precedence
+	Features map[string]FeatureFlag
+	// Fault injection for resilience testing. Requires Payment.TestMode and
+	// must not be enabled in production
+	Chaos struct {
+		Enabled bool
+		// Faults of database statements and commits
+		Database Fault
+		// Faults of provider calls
+		Provider Fault
+		// Faults of notification deliveries
+		Notification Fault
 	}
 }
 
@@ -142,10 +549,32 @@ func DefaultConfig() Config {
 	cfg := Config{}
 	cfg.Payment.PaymentIDEncPrime = 982450871
 	cfg.Payment.PaymentIDEncXOR = 123456789
+	cfg.Payment.TokenMode = "database"
+	cfg.Payment.TokenKeys = make([]string, 0)
+	cfg.Payment.UnderpaymentTolerance = "0"
+	cfg.Payment.OverpaymentPolicy = "accept"
+	cfg.Payment.LateCommitPolicy = "reject"
+	cfg.Payment.ReviewSLA = Duration("24h")
+	cfg.Payment.SessionTTL = Duration("30m")
+	cfg.Payment.Notification.Concurrency = 64
+	cfg.Payment.Notification.Timeout = Duration("30s")
+	cfg.Payment.Notification.DigestTimeouts = 3
+	cfg.Payment.Notification.DigestQueueDepth = 1000
+	cfg.Payment.Notification.MaxAttempts = 10
+	cfg.Payment.Notification.RetryBackoff = Duration("1m")
+	cfg.Payment.Dunning.RetrySchedule = []Duration{"24h", "72h", "168h"}
 
 	cfg.Database.TransactionMaxRetries = 5
 	cfg.Database.MaxOpenConns = 10
 	cfg.Database.MaxIdleConns = 5
+	cfg.Database.SlowQueryThreshold = Duration("500ms")
+	cfg.Database.HealthCheck.Interval = Duration("5s")
+	cfg.Database.HealthCheck.Timeout = Duration("2s")
+	cfg.Database.HealthCheck.RetryAfter = Duration("5s")
+	cfg.Database.OutageQueue.MaxRequests = 10000
+	cfg.Database.WriteBatch.MaxDelay = Duration("5ms")
+	cfg.Database.WriteBatch.MaxSize = 50
+	cfg.Database.WriteBatch.Workers = 1
 
 	cfg.Database.Principal.Write = NewDatabaseConfig()
 	cfg.Database.Principal.Write["mysql"] = "paymentd@tcp(localhost:3306)/fritzpay_principal?charset=utf8mb4&parseTime=true&loc=UTC&timeout=1m&wait_timeout=30&interactive_timeout=30&time_zone=%22%2B00%3A00%22"
@@ -155,6 +584,10 @@ func DefaultConfig() Config {
 
 	cfg.Database.Principal.ReadOnly = nil
 
+	cfg.Cache.RedisMaxIdle = 5
+	cfg.Cache.RedisKeyPrefix = "paymentd:"
+	cfg.Cache.Warm = true
+
 	cfg.API.Active = true
 	cfg.API.Service.Address = ":8080"
 	cfg.API.Service.ReadTimeout = Duration("10s")
@@ -162,6 +595,27 @@ func DefaultConfig() Config {
 	cfg.API.Timeout = Duration("5s")
 	cfg.API.ServeAdmin = false
 	cfg.API.AuthKeys = make([]string, 0)
+	cfg.API.BundleKeys = make([]string, 0)
+	cfg.API.Lockout.MaxFailures = 10
+	cfg.API.Lockout.Window = Duration("15m")
+	cfg.API.Lockout.Duration = Duration("15m")
+	cfg.API.Lockout.Delay = Duration("1s")
+	cfg.API.Lockout.MaxDelay = Duration("30s")
+	cfg.API.Body.MaxSize = 1 << 20
+	cfg.API.Body.Sizes = make(map[string]int64)
+	cfg.API.Body.MaxEntries = 1000
+	cfg.API.SLO.Latency = Duration("500ms")
+	cfg.API.SLO.Objective = 99
+	cfg.API.SLO.Targets = make(map[string]Duration)
+	cfg.API.Usage.FlushInterval = Duration("1m")
+	cfg.API.Usage.TopEndpoints = 10
+	cfg.API.OIDC.Scopes = []string{"email", "groups"}
+	cfg.API.OIDC.UserClaim = "email"
+	cfg.API.OIDC.GroupsClaim = "groups"
+	cfg.API.OIDC.GroupRoles = make(map[string][]string)
+	cfg.API.Onboarding.RequestRateLimit = 5
+	cfg.API.Onboarding.NotifyAddresses = make([]string, 0)
+	cfg.API.Onboarding.ProjectConfig = json.RawMessage("{}")
 
 	cfg.API.Cookie.HTTPOnly = true
 
@@ -171,10 +625,45 @@ func DefaultConfig() Config {
 	cfg.Web.Service.WriteTimeout = Duration("10s")
 	cfg.Web.Timeout = Duration("5s")
 	cfg.Web.AuthKeys = make([]string, 0)
+	cfg.Web.TrustedProxies = make([]string, 0)
 
 	cfg.Web.Cookie.HTTPOnly = true
+	cfg.Web.CheckoutLimit.PaymentAttempts = 10
+	cfg.Web.CheckoutLimit.AddressAttempts = 30
+	cfg.Web.CheckoutLimit.Window = Duration("1h")
+
+	cfg.Web.CustomerPortal.MetadataKey = "CustomerID"
+	cfg.Web.CustomerPortal.MaxPayments = 50
 
 	cfg.Provider.URL = "http://localhost:8443"
+	cfg.Provider.Rounding = map[string]Rounding{
+		"paypal_rest": {
+			Mode: "halfUp",
+			// PayPal does not support decimals for these currencies
+			Exponents: map[string]int32{
+				"HUF": 0,
+				"TWD": 0,
+			},
+		},
+	}
+	cfg.Provider.EndpointPools = make(map[string][]EndpointPool)
+	cfg.Provider.Egress = make(map[string]Egress)
+
+	cfg.Jobs.Active = true
+	cfg.Jobs.Workers = 2
+	cfg.Jobs.Schedules = make(map[string]string)
+
+	cfg.Archive.S3.RetentionDays = 3650
+	cfg.Archive.Keys = make([]string, 0)
+
+	cfg.Alerts.Cooldown = "30m"
+	cfg.Alerts.Sinks = []AlertSink{}
+	cfg.Alerts.DeclineSpike.Window = "10m"
+	cfg.Alerts.DeclineSpike.MinDeclines = 20
+	cfg.Alerts.DeclineSpike.Percentage = 50
+	cfg.Alerts.DeadLetters.MaxPending = 10
+
+	cfg.Features = make(map[string]FeatureFlag)
 
 	return cfg
 }
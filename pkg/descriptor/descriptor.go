@@ -0,0 +1,54 @@
+package descriptor
+
+import (
+	"errors"
+	"strings"
+)
+
+// MaxLen is the maximum length of statement descriptors
+const MaxLen = 22
+
+var (
+	ErrLength   = errors.New("invalid statement descriptor length")
+	ErrCharset  = errors.New("statement descriptor contains invalid characters")
+	ErrNoLetter = errors.New("statement descriptor contains no letter")
+)
+
+// Rules are the restrictions of statement descriptors
+//
+// Descriptors are limited to printable ASCII characters.
+type Rules struct {
+	MinLen int
+	MaxLen int
+	// Forbidden holds the characters which are not allowed
+	Forbidden string
+	// Letter requires at least one letter
+	Letter bool
+}
+
+// Default are the rules of statement descriptors accepted by paymentd
+var Default = Rules{
+	MaxLen:    MaxLen,
+	Forbidden: `<>\'"*`,
+}
+
+// Check returns an error if the descriptor violates the rules
+func (r Rules) Check(descriptor string) error {
+	if len(descriptor) < r.MinLen || len(descriptor) > r.MaxLen {
+		return ErrLength
+	}
+	var letter bool
+	for i := 0; i < len(descriptor); i++ {
+		c := descriptor[i]
+		if c < ' ' || c > '~' || strings.IndexByte(r.Forbidden, c) != -1 {
+			return ErrCharset
+		}
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') {
+			letter = true
+		}
+	}
+	if r.Letter && !letter {
+		return ErrNoLetter
+	}
+	return nil
+}
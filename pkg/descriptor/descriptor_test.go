@@ -0,0 +1,33 @@
+package descriptor
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRules(t *testing.T) {
+	Convey("Given the default rules", t, func() {
+		Convey("A descriptor within the rules should pass", func() {
+			So(Default.Check("ACME SHOP 1234"), ShouldBeNil)
+		})
+		Convey("A descriptor exceeding the maximum length should fail", func() {
+			So(Default.Check("ACME SHOP ONLINE STORE 1"), ShouldEqual, ErrLength)
+		})
+		Convey("Forbidden and non-ASCII characters should fail", func() {
+			So(Default.Check("ACME <SHOP>"), ShouldEqual, ErrCharset)
+			So(Default.Check("MÜLLER SHOP"), ShouldEqual, ErrCharset)
+			So(Default.Check("ACME\nSHOP"), ShouldEqual, ErrCharset)
+		})
+	})
+	Convey("Given rules requiring a minimum length and a letter", t, func() {
+		r := Rules{MinLen: 5, MaxLen: MaxLen, Letter: true}
+
+		Convey("Short descriptors should fail", func() {
+			So(r.Check("ACME"), ShouldEqual, ErrLength)
+		})
+		Convey("Descriptors without letters should fail", func() {
+			So(r.Check("12345"), ShouldEqual, ErrNoLetter)
+		})
+	})
+}
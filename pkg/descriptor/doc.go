@@ -0,0 +1,26 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package descriptor provides the validation of statement descriptors.
+
+The statement descriptor is the text shown on the bank or card statement of the
+customer, so that the customer recognizes the charge. Payment providers restrict
+the length and the characters of descriptors. paymentd accepts descriptors
+within the Default rules. Drivers check descriptors against the rules of their
+provider before forwarding them.
+*/
+package descriptor
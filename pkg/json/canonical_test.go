@@ -0,0 +1,46 @@
+package json
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCanonical(t *testing.T) {
+	Convey("Given a JSON document", t, func() {
+		doc := []byte(`{
+			"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001, -0],
+			"string": "€$\u000F\u000aA'B\u0022\u005c\\\u0022\/",
+			"literals": [null, true, false],
+			"é": 1,
+			"😀": 2,
+			"\u20ac": 3
+		}`)
+
+		Convey("When canonicalizing the document", func() {
+			c, err := Canonical(doc)
+
+			Convey("It should be serialized according to RFC 8785", func() {
+				So(err, ShouldBeNil)
+				So(string(c), ShouldEqual, `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27,0],"string":"€$\u000f\nA'B\"\\\\\"/","é":1,"€":3,"😀":2}`)
+			})
+		})
+
+		Convey("When canonicalizing without a member", func() {
+			c, err := Canonical([]byte(`{"B":"2","Signature":"abc","A":"1"}`), "Signature")
+
+			Convey("The member should be removed", func() {
+				So(err, ShouldBeNil)
+				So(string(c), ShouldEqual, `{"A":"1","B":"2"}`)
+			})
+		})
+
+		Convey("When canonicalizing an invalid document", func() {
+			_, err := Canonical([]byte(`{"A":1} {}`))
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ErrTooManyEntries is returned if a JSON object or array has more entries than
+// allowed
+var ErrTooManyEntries = errors.New("too many entries")
+
+type container struct {
+	object bool
+	// whether the next token of an object is a key
+	key     bool
+	entries int
+}
+
+// ReadLimited reads a JSON document and returns it
+//
+// The document is scanned while it is read. Reading fails with
+// ErrTooManyEntries as soon as an object or array has more than maxEntries
+// entries, without reading the remainder of the document. A maxEntries <= 0
+// disables the limit.
+//
+// The document will not be decoded. Input following the document will be
+// returned as well.
+func ReadLimited(r io.Reader, maxEntries int) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if maxEntries > 0 {
+		err := scanEntries(json.NewDecoder(io.TeeReader(r, buf)), maxEntries)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err := buf.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeLimited reads a JSON document with ReadLimited and decodes it into v
+func DecodeLimited(r io.Reader, v interface{}, maxEntries int) error {
+	p, err := ReadLimited(r, maxEntries)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+func scanEntries(dec *json.Decoder, maxEntries int) error {
+	var open []*container
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if d, ok := t.(json.Delim); ok && (d == '}' || d == ']') {
+			open = open[:len(open)-1]
+			if len(open) == 0 {
+				return nil
+			}
+			continue
+		}
+		if len(open) > 0 {
+			c := open[len(open)-1]
+			if !c.object || c.key {
+				c.entries++
+				if c.entries > maxEntries {
+					return ErrTooManyEntries
+				}
+			}
+			if c.object {
+				c.key = !c.key
+				// object keys do not start values
+				if !c.key {
+					continue
+				}
+			}
+		}
+		switch t {
+		case json.Delim('{'):
+			open = append(open, &container{object: true, key: true})
+		case json.Delim('['):
+			open = append(open, &container{})
+		default:
+			if len(open) == 0 {
+				return nil
+			}
+		}
+	}
+}
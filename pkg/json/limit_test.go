@@ -0,0 +1,77 @@
+package json
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// errReader fails if it is read after the given input
+type errReader struct {
+	r io.Reader
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		return n, io.ErrClosedPipe
+	}
+	return n, err
+}
+
+func TestReadLimited(t *testing.T) {
+	Convey("Given a JSON document with nested entries", t, func() {
+		doc := `{"A":"1","Metadata":{"a":"1","b":"2","c":{"d":[1,2,3]}},"List":[{"x":1},{"y":2}]}`
+
+		Convey("When the document is read within the limit", func() {
+			p, err := ReadLimited(strings.NewReader(doc), 3)
+
+			Convey("It should return the document", func() {
+				So(err, ShouldBeNil)
+				So(string(p), ShouldEqual, doc)
+			})
+		})
+		Convey("When an object exceeds the limit", func() {
+			_, err := ReadLimited(strings.NewReader(doc), 2)
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrTooManyEntries)
+			})
+		})
+		Convey("When the limit is disabled", func() {
+			v := struct {
+				Metadata map[string]interface{}
+			}{}
+			err := DecodeLimited(strings.NewReader(doc), &v, 0)
+
+			Convey("It should decode the document", func() {
+				So(err, ShouldBeNil)
+				So(len(v.Metadata), ShouldEqual, 3)
+			})
+		})
+	})
+	Convey("Given an array with too many entries", t, func() {
+		doc := `{"PaymentIDs":["1","2","3","4"`
+
+		Convey("When it is read", func() {
+			_, err := ReadLimited(&errReader{strings.NewReader(doc)}, 3)
+
+			Convey("It should fail before reading the remainder", func() {
+				So(err, ShouldEqual, ErrTooManyEntries)
+			})
+		})
+	})
+	Convey("Given a truncated document", t, func() {
+		doc := `{"A":["1"`
+
+		Convey("When it is read", func() {
+			_, err := ReadLimited(strings.NewReader(doc), 3)
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, io.ErrUnexpectedEOF)
+			})
+		})
+	})
+}
@@ -0,0 +1,120 @@
+package metadata
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Limits restrict the size of metadata
+//
+// Zero values are replaced by the default limits.
+type Limits struct {
+	// MaxKeys is the maximum number of entries
+	MaxKeys int `json:",omitempty"`
+	// MaxKeyLength is the maximum length of the names in bytes
+	MaxKeyLength int `json:",omitempty"`
+	// MaxValueSize is the maximum size of the values in bytes
+	MaxValueSize int `json:",omitempty"`
+	// MaxTotalSize is the maximum size of all names and values in bytes
+	MaxTotalSize int `json:",omitempty"`
+}
+
+var (
+	// DefaultLimits are the limits of metadata if no limits are configured
+	DefaultLimits = Limits{
+		MaxKeys:      50,
+		MaxKeyLength: 125,
+		MaxValueSize: 4096,
+		MaxTotalSize: 32768,
+	}
+	// MaxLimits are the highest configurable limits
+	//
+	// The name length and value size are given by the columns of the metadata
+	// tables. The limits are enforced when writing metadata.
+	MaxLimits = Limits{
+		MaxKeys:      250,
+		MaxKeyLength: 125,
+		MaxValueSize: 65535,
+		MaxTotalSize: 262144,
+	}
+)
+
+// LimitError is returned if metadata exceeds its limits
+type LimitError struct {
+	// Field is the name of the entry exceeding the limits. It is empty if
+	// the metadata as a whole exceeds the limits.
+	Field  string
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	if e.Field == "" {
+		return e.Reason
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+// Check checks whether the limits are within the maximum limits
+func (l Limits) Check() error {
+	for _, c := range []struct {
+		name     string
+		val, max int
+	}{
+		{"MaxKeys", l.MaxKeys, MaxLimits.MaxKeys},
+		{"MaxKeyLength", l.MaxKeyLength, MaxLimits.MaxKeyLength},
+		{"MaxValueSize", l.MaxValueSize, MaxLimits.MaxValueSize},
+		{"MaxTotalSize", l.MaxTotalSize, MaxLimits.MaxTotalSize},
+	} {
+		if c.val < 0 || c.val > c.max {
+			return fmt.Errorf("metadata limit %s must be between 0 and %d", c.name, c.max)
+		}
+	}
+	return nil
+}
+
+// Effective returns the limits with zero values replaced by the default limits
+func (l Limits) Effective() Limits {
+	if l.MaxKeys == 0 {
+		l.MaxKeys = DefaultLimits.MaxKeys
+	}
+	if l.MaxKeyLength == 0 {
+		l.MaxKeyLength = DefaultLimits.MaxKeyLength
+	}
+	if l.MaxValueSize == 0 {
+		l.MaxValueSize = DefaultLimits.MaxValueSize
+	}
+	if l.MaxTotalSize == 0 {
+		l.MaxTotalSize = DefaultLimits.MaxTotalSize
+	}
+	return l
+}
+
+// Validate validates the metadata values against the limits
+//
+// It returns a *LimitError for the first entry, in the order of the names,
+// which exceeds the limits. Zero limits are replaced by the default limits.
+func (l Limits) Validate(values map[string]string) error {
+	l = l.Effective()
+	if len(values) > l.MaxKeys {
+		return &LimitError{Reason: fmt.Sprintf("more than %d entries", l.MaxKeys)}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var total int
+	for _, name := range names {
+		if len(name) > l.MaxKeyLength {
+			return &LimitError{Field: name, Reason: fmt.Sprintf("name longer than %d bytes", l.MaxKeyLength)}
+		}
+		if len(values[name]) > l.MaxValueSize {
+			return &LimitError{Field: name, Reason: fmt.Sprintf("value larger than %d bytes", l.MaxValueSize)}
+		}
+		total += len(name) + len(values[name])
+	}
+	if total > l.MaxTotalSize {
+		return &LimitError{Reason: fmt.Sprintf("larger than %d bytes", l.MaxTotalSize)}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package metadata
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLimits(t *testing.T) {
+	Convey("Given metadata limits", t, func() {
+		l := Limits{MaxKeys: 2, MaxKeyLength: 4, MaxValueSize: 8, MaxTotalSize: 14}
+
+		Convey("When validating metadata within the limits", func() {
+			err := l.Validate(map[string]string{"a": "12345678", "b": "1234"})
+			Convey("It should succeed", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+		Convey("When validating metadata with too many entries", func() {
+			err := l.Validate(map[string]string{"a": "", "b": "", "c": ""})
+			Convey("It should fail with a limit error", func() {
+				limitErr, ok := err.(*LimitError)
+				So(ok, ShouldBeTrue)
+				So(limitErr.Field, ShouldEqual, "")
+			})
+		})
+		Convey("When validating metadata with a long name", func() {
+			err := l.Validate(map[string]string{"toolong": ""})
+			Convey("It should fail for the entry", func() {
+				limitErr, ok := err.(*LimitError)
+				So(ok, ShouldBeTrue)
+				So(limitErr.Field, ShouldEqual, "toolong")
+			})
+		})
+		Convey("When validating metadata with a large value", func() {
+			err := l.Validate(map[string]string{"a": "123456789"})
+			Convey("It should fail for the entry", func() {
+				limitErr, ok := err.(*LimitError)
+				So(ok, ShouldBeTrue)
+				So(limitErr.Field, ShouldEqual, "a")
+			})
+		})
+		Convey("When validating metadata exceeding the total size", func() {
+			err := l.Validate(map[string]string{"a": "12345678", "b": "123456"})
+			Convey("It should fail with a limit error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "larger than 14 bytes")
+			})
+		})
+	})
+
+	Convey("Given empty limits", t, func() {
+		l := Limits{}
+
+		Convey("The default limits should apply", func() {
+			So(l.Effective(), ShouldResemble, DefaultLimits)
+			err := l.Validate(map[string]string{"a": strings.Repeat("x", DefaultLimits.MaxValueSize+1)})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given limits beyond the maximum limits", t, func() {
+		l := Limits{MaxValueSize: MaxLimits.MaxValueSize + 1}
+
+		Convey("Checking them should fail", func() {
+			So(l.Check(), ShouldNotBeNil)
+		})
+	})
+}
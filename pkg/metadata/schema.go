@@ -0,0 +1,173 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"code.google.com/p/godec/dec"
+)
+
+// Schema field types
+const (
+	FieldTypeString  = "string"
+	FieldTypeInt     = "int"
+	FieldTypeDecimal = "decimal"
+	FieldTypeBool    = "bool"
+)
+
+// SchemaField describes a metadata entry
+type SchemaField struct {
+	Name string
+	// Type is one of the field types. It defaults to string.
+	Type     string `json:",omitempty"`
+	Required bool   `json:",omitempty"`
+	// Pattern is a regular expression the value has to match
+	Pattern string `json:",omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Schema describes the metadata entries of a resource, e.g. the metadata of
+// the payments of a project
+type Schema struct {
+	Fields []*SchemaField
+	// Strict rejects metadata entries which are not described by the schema
+	Strict bool `json:",omitempty"`
+}
+
+// SchemaError is returned if metadata does not match the schema
+type SchemaError struct {
+	Field  string
+	Reason string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+// ParseSchema parses and compiles a JSON encoded schema
+func ParseSchema(p []byte) (*Schema, error) {
+	s := &Schema{}
+	err := json.Unmarshal(p, s)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Compile checks the schema and compiles the field patterns
+func (s *Schema) Compile() error {
+	names := make(map[string]struct{}, len(s.Fields))
+	for _, f := range s.Fields {
+		if f == nil || f.Name == "" {
+			return fmt.Errorf("schema field without name")
+		}
+		if _, ok := names[f.Name]; ok {
+			return fmt.Errorf("duplicate schema field %s", f.Name)
+		}
+		names[f.Name] = struct{}{}
+		switch f.Type {
+		case "":
+			f.Type = FieldTypeString
+		case FieldTypeString, FieldTypeInt, FieldTypeDecimal, FieldTypeBool:
+		default:
+			return fmt.Errorf("schema field %s: unknown type %s", f.Name, f.Type)
+		}
+		if f.Pattern != "" {
+			var err error
+			f.pattern, err = regexp.Compile(f.Pattern)
+			if err != nil {
+				return fmt.Errorf("schema field %s: invalid pattern: %v", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Field returns the field with the given name
+func (s *Schema) Field(name string) (*SchemaField, bool) {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// Validate validates the metadata values against the schema
+//
+// It returns a *SchemaError for the first entry which does not match. The
+// schema must be compiled.
+func (s *Schema) Validate(values map[string]string) error {
+	for _, f := range s.Fields {
+		v, ok := values[f.Name]
+		if !ok {
+			if f.Required {
+				return &SchemaError{Field: f.Name, Reason: "required"}
+			}
+			continue
+		}
+		if _, err := f.value(v); err != nil {
+			return &SchemaError{Field: f.Name, Reason: "not a valid " + f.Type}
+		}
+		if f.pattern != nil && !f.pattern.MatchString(v) {
+			return &SchemaError{Field: f.Name, Reason: "does not match pattern"}
+		}
+	}
+	if s.Strict {
+		for name := range values {
+			if _, ok := s.Field(name); !ok {
+				return &SchemaError{Field: name, Reason: "not in schema"}
+			}
+		}
+	}
+	return nil
+}
+
+// Typed returns the metadata values described by the schema as typed values
+//
+// Int and decimal values are returned as JSON numbers, bool values as bools.
+// Entries which are not described by the schema or which do not match their
+// type are omitted.
+func (s *Schema) Typed(values map[string]string) map[string]interface{} {
+	typed := make(map[string]interface{})
+	for _, f := range s.Fields {
+		v, ok := values[f.Name]
+		if !ok {
+			continue
+		}
+		tv, err := f.value(v)
+		if err != nil {
+			continue
+		}
+		typed[f.Name] = tv
+	}
+	return typed
+}
+
+func (f *SchemaField) value(v string) (interface{}, error) {
+	switch f.Type {
+	case FieldTypeInt:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatInt(i, 10)), nil
+	case FieldTypeDecimal:
+		d := &dec.Dec{}
+		if _, ok := d.SetString(v); !ok {
+			return nil, fmt.Errorf("invalid decimal %s", v)
+		}
+		return json.Number(d.String()), nil
+	case FieldTypeBool:
+		return strconv.ParseBool(v)
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,87 @@
+package metadata
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSchema(t *testing.T) {
+	Convey("Given a schema", t, func() {
+		s, err := ParseSchema([]byte(`{"Fields":[
+			{"Name":"customerNumber","Required":true,"Pattern":"^C[0-9]+$"},
+			{"Name":"quantity","Type":"int"},
+			{"Name":"weight","Type":"decimal"},
+			{"Name":"gift","Type":"bool"}
+		]}`))
+		So(err, ShouldBeNil)
+
+		Convey("When validating matching metadata", func() {
+			err = s.Validate(map[string]string{
+				"customerNumber": "C123",
+				"quantity":       "3",
+				"other":          "value",
+			})
+			Convey("It should succeed", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+		Convey("When a required field is missing", func() {
+			err = s.Validate(map[string]string{"quantity": "3"})
+			Convey("It should fail with a schema error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "customerNumber: required")
+			})
+		})
+		Convey("When a field does not match its pattern", func() {
+			err = s.Validate(map[string]string{"customerNumber": "123"})
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(err.(*SchemaError).Field, ShouldEqual, "customerNumber")
+			})
+		})
+		Convey("When a field does not match its type", func() {
+			err = s.Validate(map[string]string{"customerNumber": "C1", "gift": "maybe"})
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "gift: not a valid bool")
+			})
+		})
+		Convey("When the schema is strict", func() {
+			s.Strict = true
+			err = s.Validate(map[string]string{"customerNumber": "C1", "other": "value"})
+			Convey("Undescribed fields should be rejected", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "other: not in schema")
+			})
+		})
+		Convey("When converting metadata to typed fields", func() {
+			typed := s.Typed(map[string]string{
+				"customerNumber": "C123",
+				"quantity":       "3",
+				"weight":         "1.50",
+				"gift":           "true",
+				"other":          "value",
+			})
+			enc, err := json.Marshal(typed)
+			Convey("It should contain the typed values of the schema fields", func() {
+				So(err, ShouldBeNil)
+				So(string(enc), ShouldEqual, `{"customerNumber":"C123","gift":true,"quantity":3,"weight":1.50}`)
+			})
+		})
+	})
+
+	Convey("Given a schema with an unknown field type", t, func() {
+		_, err := ParseSchema([]byte(`{"Fields":[{"Name":"a","Type":"date"}]}`))
+		Convey("It should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Given a schema with an invalid pattern", t, func() {
+		_, err := ParseSchema([]byte(`{"Fields":[{"Name":"a","Pattern":"("}]}`))
+		Convey("It should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,183 @@
+package oidc
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+var (
+	ErrTokenExchange = errors.New("token exchange failed")
+)
+
+// discovery is the relevant part of the provider configuration
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect identity provider
+//
+// The provider configuration will be discovered on first use.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL as registered with the provider
+	RedirectURL string
+	// Scopes are requested in addition to the "openid" scope
+	Scopes []string
+
+	client *http.Client
+
+	mu        sync.RWMutex
+	discovery *discovery
+	keys      keySet
+}
+
+// NewProvider creates a new provider
+func NewProvider(issuer, clientID, clientSecret, redirectURL string, scopes []string) *Provider {
+	return &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) getJSON(u string, v interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *Provider) config() (*discovery, error) {
+	p.mu.RLock()
+	d := p.discovery
+	p.mu.RUnlock()
+	if d != nil {
+		return d, nil
+	}
+	d = &discovery{}
+	err := p.getJSON(p.Issuer+discoveryPath, d)
+	if err != nil {
+		return nil, fmt.Errorf("error on discovery: %v", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovered issuer %s does not match %s", d.Issuer, p.Issuer)
+	}
+	p.mu.Lock()
+	p.discovery = d
+	p.mu.Unlock()
+	return d, nil
+}
+
+// AuthCodeURL returns the URL of the provider's login page
+//
+// The state must be verified in the callback. The nonce must be passed to
+// Verify.
+func (p *Provider) AuthCodeURL(state, nonce string) (string, error) {
+	d, err := p.config()
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(append([]string{"openid"}, p.Scopes...), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// Exchange exchanges the authorization code for an ID token
+//
+// The returned ID token is not verified.
+func (p *Provider) Exchange(code string) (string, error) {
+	d, err := p.config()
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("client_id", p.ClientID)
+	v.Set("client_secret", p.ClientSecret)
+	resp, err := p.client.PostForm(d.TokenEndpoint, v)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	tok := tokenResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&tok)
+	if err != nil {
+		return "", fmt.Errorf("error decoding token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || tok.Error != "" || tok.IDToken == "" {
+		return "", ErrTokenExchange
+	}
+	return tok.IDToken, nil
+}
+
+// key returns the signing key with the given ID
+//
+// The key set will be reloaded if the key is unknown, so keys rotated by the
+// provider will be picked up.
+func (p *Provider) key(kid string) (*rsa.PublicKey, error) {
+	p.mu.RLock()
+	k, ok := p.keys[kid]
+	p.mu.RUnlock()
+	if ok {
+		return k, nil
+	}
+	d, err := p.config()
+	if err != nil {
+		return nil, err
+	}
+	set := jwks{}
+	err = p.getJSON(d.JWKSURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving keys: %v", err)
+	}
+	keys, err := set.keySet()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	k, ok = keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return k, nil
+}
@@ -0,0 +1,140 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func signToken(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	h, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestProvider(t *testing.T) {
+	Convey("Given an identity provider", t, func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+
+		var idToken string
+		mux := http.NewServeMux()
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+		mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(discovery{
+				Issuer:                srv.URL,
+				AuthorizationEndpoint: srv.URL + "/authorize",
+				TokenEndpoint:         srv.URL + "/token",
+				JWKSURI:               srv.URL + "/keys",
+			})
+		})
+		mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(jwks{Keys: []jwk{{
+				Kty: "RSA",
+				Kid: "k1",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		})
+		mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+			if r.PostFormValue("code") != "code" || r.PostFormValue("client_secret") != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+				return
+			}
+			json.NewEncoder(w).Encode(tokenResponse{IDToken: idToken})
+		})
+
+		p := NewProvider(srv.URL+"/", "paymentd", "secret", "https://example.com/callback", []string{"email"})
+		claims := map[string]interface{}{
+			"iss":    srv.URL,
+			"aud":    "paymentd",
+			"exp":    time.Now().Add(time.Minute).Unix(),
+			"nonce":  "nonce",
+			"email":  "jane.doe@example.com",
+			"groups": []string{"payments", "finance"},
+		}
+
+		Convey("The login URL should point to the provider", func() {
+			u, err := p.AuthCodeURL("state", "nonce")
+			So(err, ShouldBeNil)
+			So(strings.HasPrefix(u, srv.URL+"/authorize?"), ShouldBeTrue)
+			parsed, err := url.Parse(u)
+			So(err, ShouldBeNil)
+			So(parsed.Query().Get("scope"), ShouldEqual, "openid email")
+			So(parsed.Query().Get("state"), ShouldEqual, "state")
+		})
+
+		Convey("When a valid ID token is issued", func() {
+			idToken = signToken(key, "k1", claims)
+			raw, err := p.Exchange("code")
+			So(err, ShouldBeNil)
+			c, err := p.Verify(raw, "nonce")
+			Convey("It should verify", func() {
+				So(err, ShouldBeNil)
+				So(c.String("email"), ShouldEqual, "jane.doe@example.com")
+				So(c.Strings("groups"), ShouldResemble, []string{"payments", "finance"})
+			})
+		})
+		Convey("When the code is invalid", func() {
+			_, err := p.Exchange("other")
+			Convey("The exchange should fail", func() {
+				So(err, ShouldEqual, ErrTokenExchange)
+			})
+		})
+		Convey("When the nonce does not match", func() {
+			_, err := p.Verify(signToken(key, "k1", claims), "other")
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrInvalidClaims)
+			})
+		})
+		Convey("When the audience does not match", func() {
+			claims["aud"] = []string{"other"}
+			_, err := p.Verify(signToken(key, "k1", claims), "nonce")
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrInvalidClaims)
+			})
+		})
+		Convey("When the token is expired", func() {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			_, err := p.Verify(signToken(key, "k1", claims), "nonce")
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrTokenExpired)
+			})
+		})
+		Convey("When the token is signed with an unknown key", func() {
+			_, err := p.Verify(signToken(key, "k2", claims), "nonce")
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrUnknownKey)
+			})
+		})
+		Convey("When the token was tampered with", func() {
+			parts := strings.Split(signToken(key, "k1", claims), ".")
+			claims["email"] = "root@example.com"
+			forged := strings.Split(signToken(key, "k1", claims), ".")
+			_, err := p.Verify(parts[0]+"."+forged[1]+"."+parts[2], "nonce")
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrInvalidSignature)
+			})
+		})
+	})
+}
@@ -0,0 +1,165 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// allowed clock skew between paymentd and the provider
+const clockSkew = time.Minute
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrInvalidClaims    = errors.New("invalid claims")
+	ErrTokenExpired     = errors.New("token expired")
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type keySet map[string]*rsa.PublicKey
+
+// keySet returns the RSA signing keys of the set
+func (s jwks) keySet() (keySet, error) {
+	keys := make(keySet)
+	for _, k := range s.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// Claims are the claims of a verified ID token
+type Claims map[string]interface{}
+
+// String returns the string claim with the given name
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the claim with the given name as a list of strings
+//
+// Single string claims will be returned as a list with one entry.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify verifies the ID token and returns its claims
+//
+// The signature, issuer, audience, expiry and nonce will be verified.
+func (p *Provider) Verify(rawToken, nonce string) (Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	h := header{}
+	err = json.Unmarshal(hb, &h)
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	if h.Alg != "RS256" {
+		return nil, ErrUnsupportedAlg
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	key, err := p.key(h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	c := Claims{}
+	err = json.Unmarshal(cb, &c)
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	if strings.TrimSuffix(c.String("iss"), "/") != p.Issuer {
+		return nil, ErrInvalidClaims
+	}
+	aud := false
+	for _, a := range c.Strings("aud") {
+		if a == p.ClientID {
+			aud = true
+		}
+	}
+	if !aud {
+		return nil, ErrInvalidClaims
+	}
+	if subtle.ConstantTimeCompare([]byte(c.String("nonce")), []byte(nonce)) != 1 {
+		return nil, ErrInvalidClaims
+	}
+	exp, ok := c["exp"].(float64)
+	if !ok {
+		return nil, ErrInvalidClaims
+	}
+	if time.Unix(int64(exp), 0).Add(clockSkew).Before(time.Now()) {
+		return nil, ErrTokenExpired
+	}
+	return c, nil
+}
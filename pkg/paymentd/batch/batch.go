@@ -0,0 +1,58 @@
+package batch
+
+import (
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+)
+
+// Batch applies an intent to many payments
+type Batch struct {
+	ID        int64
+	Created   time.Time
+	Intent    string
+	Comment   string
+	CreatedBy string
+	// Total is the number of payments of the batch
+	Total int
+	// Finished is the zero time while the batch is running
+	Finished time.Time
+
+	// Processed is the number of processed payments
+	Processed int
+	// Failed is the number of processed payments which could not be changed
+	Failed int
+}
+
+// Running returns true if the batch did not finish yet
+func (b *Batch) Running() bool {
+	return b.Finished.IsZero()
+}
+
+// Result is the result of applying the batch intent to a payment
+type Result struct {
+	// Seq is the position of the payment in the batch
+	Seq       int
+	PaymentID payment.PaymentID
+	// Processed is the zero time while the payment is not processed
+	Processed time.Time
+	// Status is the status of the payment after the intent was applied. If the
+	// intent was not allowed, it is the unchanged status of the payment
+	Status payment.PaymentTransactionStatus
+	Error  string
+}
+
+// Failed returns true if the intent could not be applied to the payment
+func (r *Result) Failed() bool {
+	return r.Error != ""
+}
+
+// SetResult sets the result of the processed payment
+func (r *Result) SetResult(status payment.PaymentTransactionStatus, err error) {
+	r.Processed = time.Now()
+	r.Status = status
+	r.Error = ""
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
@@ -0,0 +1,52 @@
+package batch
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatch(t *testing.T) {
+	Convey("Given a new batch", t, func() {
+		b := &Batch{Created: time.Now()}
+
+		Convey("It should be running", func() {
+			So(b.Running(), ShouldBeTrue)
+		})
+
+		Convey("When it is finished", func() {
+			b.Finished = time.Now()
+
+			Convey("It should not be running", func() {
+				So(b.Running(), ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given an unprocessed payment of a batch", t, func() {
+		r := &Result{Seq: 1, PaymentID: payment.PaymentID{ProjectID: 1, PaymentID: 2}}
+
+		Convey("When the intent was applied", func() {
+			r.SetResult(payment.PaymentStatusCancelled, nil)
+
+			Convey("It should be processed without error", func() {
+				So(r.Processed.IsZero(), ShouldBeFalse)
+				So(r.Status, ShouldEqual, payment.PaymentStatusCancelled)
+				So(r.Failed(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the intent was not allowed", func() {
+			r.SetResult(payment.PaymentStatusPaid, errors.New("intent not allowed"))
+
+			Convey("It should be failed with the unchanged status", func() {
+				So(r.Failed(), ShouldBeTrue)
+				So(r.Error, ShouldEqual, "intent not allowed")
+				So(r.Status, ShouldEqual, payment.PaymentStatusPaid)
+			})
+		})
+	})
+}
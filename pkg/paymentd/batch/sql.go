@@ -0,0 +1,272 @@
+package batch
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+)
+
+var (
+	ErrBatchNotFound = errors.New("batch not found")
+	// ErrNoPayment is returned when all payments of a batch were processed
+	ErrNoPayment = errors.New("no unprocessed payment")
+)
+
+const insertBatch = `
+INSERT INTO payment_batch
+(created, intent, comment, created_by, total)
+VALUES
+(?, ?, ?, ?, ?)
+`
+
+const insertBatchPayment = `
+INSERT INTO payment_batch_payment
+(batch_id, seq, project_id, payment_id)
+VALUES
+(?, ?, ?, ?)
+`
+
+// InsertBatchTx saves a new batch with the given payments
+func InsertBatchTx(db *sql.Tx, b *Batch, ids []payment.PaymentID) error {
+	b.Total = len(ids)
+	res, err := db.Exec(
+		insertBatch,
+		b.Created.UnixNano(),
+		b.Intent,
+		sql.NullString{String: b.Comment, Valid: b.Comment != ""},
+		b.CreatedBy,
+		b.Total,
+	)
+	if err != nil {
+		return err
+	}
+	b.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	stmt, err := db.Prepare(insertBatchPayment)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, id := range ids {
+		_, err = stmt.Exec(b.ID, i, id.ProjectID, id.PaymentID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const selectBatch = `
+SELECT
+	b.id,
+	b.created,
+	b.intent,
+	b.comment,
+	b.created_by,
+	b.total,
+	b.finished,
+	(
+		SELECT COUNT(*) FROM payment_batch_payment AS p
+		WHERE p.batch_id = b.id AND p.processed IS NOT NULL
+	),
+	(
+		SELECT COUNT(*) FROM payment_batch_payment AS p
+		WHERE p.batch_id = b.id AND p.error IS NOT NULL
+	)
+FROM payment_batch AS b
+WHERE
+	b.id = ?
+`
+
+// BatchByIDDB selects the batch with the given ID including its progress
+func BatchByIDDB(db *sql.DB, id int64) (*Batch, error) {
+	b := &Batch{}
+	var created int64
+	var comment sql.NullString
+	var finished sql.NullInt64
+	err := db.QueryRow(selectBatch, id).Scan(
+		&b.ID,
+		&created,
+		&b.Intent,
+		&comment,
+		&b.CreatedBy,
+		&b.Total,
+		&finished,
+		&b.Processed,
+		&b.Failed,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrBatchNotFound
+		}
+		return nil, err
+	}
+	b.Created = time.Unix(0, created)
+	b.Comment = comment.String
+	if finished.Valid {
+		b.Finished = time.Unix(0, finished.Int64)
+	}
+	return b, nil
+}
+
+const selectUnfinishedBatchIDs = `
+SELECT id FROM payment_batch
+WHERE
+	finished IS NULL
+ORDER BY id ASC
+`
+
+// UnfinishedBatchIDsDB selects the IDs of the running batches, oldest first
+func UnfinishedBatchIDsDB(db *sql.DB) ([]int64, error) {
+	rows, err := db.Query(selectUnfinishedBatchIDs)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, 8)
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	return ids, err
+}
+
+const selectResultFields = `
+SELECT
+	seq,
+	project_id,
+	payment_id,
+	processed,
+	status,
+	error
+FROM payment_batch_payment
+`
+
+type resultScanner interface {
+	Scan(...interface{}) error
+}
+
+func scanResult(row resultScanner) (*Result, error) {
+	r := &Result{}
+	var processed sql.NullInt64
+	var status, resErr sql.NullString
+	err := row.Scan(
+		&r.Seq,
+		&r.PaymentID.ProjectID,
+		&r.PaymentID.PaymentID,
+		&processed,
+		&status,
+		&resErr,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if processed.Valid {
+		r.Processed = time.Unix(0, processed.Int64)
+	}
+	r.Status = payment.PaymentTransactionStatus(status.String)
+	r.Error = resErr.String
+	return r, nil
+}
+
+const selectProcessedResults = selectResultFields + `
+WHERE
+	batch_id = ?
+	AND
+	processed IS NOT NULL
+ORDER BY seq ASC
+`
+
+// ResultsDB selects the results of the processed payments of the batch in
+// processing order
+func ResultsDB(db *sql.DB, batchID int64) ([]*Result, error) {
+	rows, err := db.Query(selectProcessedResults, batchID)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*Result, 0, 16)
+	for rows.Next() {
+		r, err := scanResult(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	return results, err
+}
+
+const selectNextPayment = selectResultFields + `
+WHERE
+	batch_id = ?
+	AND
+	processed IS NULL
+ORDER BY seq ASC
+LIMIT 1
+FOR UPDATE
+`
+
+// NextPaymentTx selects and locks the next unprocessed payment of the batch
+//
+// The lock ensures that a payment is processed once if the batch is processed
+// by several instances.
+func NextPaymentTx(db *sql.Tx, batchID int64) (*Result, error) {
+	r, err := scanResult(db.QueryRow(selectNextPayment, batchID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoPayment
+	}
+	return r, err
+}
+
+const updateResult = `
+UPDATE payment_batch_payment
+SET
+	processed = ?,
+	status = ?,
+	error = ?
+WHERE
+	batch_id = ?
+	AND
+	seq = ?
+`
+
+// SetResultTx saves the result of a processed payment
+func SetResultTx(db *sql.Tx, batchID int64, r *Result) error {
+	_, err := db.Exec(
+		updateResult,
+		r.Processed.UnixNano(),
+		sql.NullString{String: string(r.Status), Valid: r.Status != ""},
+		sql.NullString{String: r.Error, Valid: r.Error != ""},
+		batchID,
+		r.Seq,
+	)
+	return err
+}
+
+const updateBatchFinished = `
+UPDATE payment_batch
+SET
+	finished = ?
+WHERE
+	id = ?
+	AND
+	finished IS NULL
+`
+
+// FinishBatchTx marks the batch finished
+func FinishBatchTx(db *sql.Tx, batchID int64, t time.Time) error {
+	_, err := db.Exec(updateBatchFinished, t.UnixNano(), batchID)
+	return err
+}
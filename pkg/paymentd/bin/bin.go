@@ -0,0 +1,146 @@
+package bin
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MinPrefixLen is the minimum length of a BIN
+	MinPrefixLen = 6
+	// MaxPrefixLen is the maximum length of a BIN
+	MaxPrefixLen = 8
+	// BrandMaxLen is the maximum length of the card brand
+	BrandMaxLen = 32
+)
+
+var (
+	ErrInvalidNumber   = errors.New("invalid card number")
+	ErrRangeNotFound   = errors.New("BIN range not found")
+	ErrDatasetNotFound = errors.New("BIN dataset not found")
+)
+
+// Range is a BIN range of a card issuer
+//
+// All card numbers starting with the prefix belong to the range.
+type Range struct {
+	Prefix string
+	// Country is the ISO 3166-1 alpha-2 code of the issuer country
+	Country string
+	Brand   string
+	Debit   bool
+	Prepaid bool
+}
+
+// Valid returns true if the range can be imported
+func (r *Range) Valid() bool {
+	if len(r.Prefix) < MinPrefixLen || len(r.Prefix) > MaxPrefixLen || !digits(r.Prefix) {
+		return false
+	}
+	if len(r.Country) != 2 {
+		return false
+	}
+	return len(r.Brand) <= BrandMaxLen
+}
+
+// Dataset is an import of BIN ranges
+type Dataset struct {
+	Created time.Time
+	// Checksum is the SHA-256 checksum of the imported file
+	Checksum  string
+	Source    string
+	Ranges    int
+	CreatedBy string
+}
+
+// Checksum returns the checksum of the dataset file contents
+func Checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Prefixes returns the BIN prefixes of the card number, longest first
+//
+// The number can be a full card number or its BIN. Spaces and dashes will be
+// ignored. Only the leading digits of the number are used.
+func Prefixes(number string) ([]string, error) {
+	number = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, number)
+	if len(number) < MinPrefixLen || !digits(number) {
+		return nil, ErrInvalidNumber
+	}
+	if len(number) > MaxPrefixLen {
+		number = number[:MaxPrefixLen]
+	}
+	prefixes := make([]string, 0, MaxPrefixLen-MinPrefixLen+1)
+	for l := len(number); l >= MinPrefixLen; l-- {
+		prefixes = append(prefixes, number[:l])
+	}
+	return prefixes, nil
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseCSV reads the ranges of a dataset file
+//
+// The file contains one range per line with the columns prefix, country,
+// brand, debit and prepaid. The flags are parsed as booleans. Lines starting
+// with # will be ignored.
+func ParseCSV(r io.Reader) ([]*Range, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 5
+	cr.TrimLeadingSpace = true
+	ranges := make([]*Range, 0, 1024)
+	seen := make(map[string]bool)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line := len(ranges) + 1
+		rng := &Range{
+			Prefix:  rec[0],
+			Country: strings.ToUpper(rec[1]),
+			Brand:   strings.ToLower(rec[2]),
+		}
+		rng.Debit, err = strconv.ParseBool(rec[3])
+		if err != nil {
+			return nil, fmt.Errorf("range %d: invalid debit flag %s", line, rec[3])
+		}
+		rng.Prepaid, err = strconv.ParseBool(rec[4])
+		if err != nil {
+			return nil, fmt.Errorf("range %d: invalid prepaid flag %s", line, rec[4])
+		}
+		if !rng.Valid() {
+			return nil, fmt.Errorf("range %d: invalid range %s", line, rec[0])
+		}
+		if seen[rng.Prefix] {
+			return nil, fmt.Errorf("range %d: duplicate prefix %s", line, rng.Prefix)
+		}
+		seen[rng.Prefix] = true
+		ranges = append(ranges, rng)
+	}
+	return ranges, nil
+}
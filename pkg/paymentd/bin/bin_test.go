@@ -0,0 +1,67 @@
+package bin
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPrefixes(t *testing.T) {
+	Convey("Given a card number", t, func() {
+		number := "4111 1111 1111 1111"
+
+		Convey("It should return the prefixes longest first", func() {
+			prefixes, err := Prefixes(number)
+			So(err, ShouldBeNil)
+			So(prefixes, ShouldResemble, []string{"41111111", "4111111", "411111"})
+		})
+	})
+	Convey("Given a BIN", t, func() {
+		Convey("It should return the BIN as the only prefix", func() {
+			prefixes, err := Prefixes("411111")
+			So(err, ShouldBeNil)
+			So(prefixes, ShouldResemble, []string{"411111"})
+		})
+	})
+	Convey("Given invalid numbers", t, func() {
+		Convey("They should be rejected", func() {
+			_, err := Prefixes("41111")
+			So(err, ShouldEqual, ErrInvalidNumber)
+			_, err = Prefixes("4111x11111")
+			So(err, ShouldEqual, ErrInvalidNumber)
+		})
+	})
+}
+
+func TestParseCSV(t *testing.T) {
+	Convey("Given a dataset file", t, func() {
+		data := `# prefix,country,brand,debit,prepaid
+411111,de,Visa,true,false
+41111122,AT,visa,false,true
+`
+		Convey("It should parse the ranges", func() {
+			ranges, err := ParseCSV(strings.NewReader(data))
+			So(err, ShouldBeNil)
+			So(len(ranges), ShouldEqual, 2)
+			So(ranges[0], ShouldResemble, &Range{Prefix: "411111", Country: "DE", Brand: "visa", Debit: true})
+			So(ranges[1], ShouldResemble, &Range{Prefix: "41111122", Country: "AT", Brand: "visa", Prepaid: true})
+		})
+		Convey("When a prefix is duplicated", func() {
+			data += "411111,DE,visa,false,false\n"
+
+			Convey("It should fail", func() {
+				_, err := ParseCSV(strings.NewReader(data))
+				So(err, ShouldNotBeNil)
+			})
+		})
+		Convey("When a range is invalid", func() {
+			data += "4111,DE,visa,false,false\n"
+
+			Convey("It should fail", func() {
+				_, err := ParseCSV(strings.NewReader(data))
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,144 @@
+package bin
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const insertRange = `
+INSERT INTO bin_range
+(dataset, prefix, country, brand, debit, prepaid)
+VALUES
+(?, ?, ?, ?, ?, ?)
+`
+
+const insertDataset = `
+INSERT INTO bin_dataset
+(created, checksum, source, ranges, created_by)
+VALUES
+(?, ?, ?, ?, ?)
+`
+
+// InsertDatasetTx saves a new dataset with its ranges
+//
+// The dataset will be used for lookups once the transaction is committed.
+func InsertDatasetTx(db *sql.Tx, ds *Dataset, ranges []*Range) error {
+	stmt, err := db.Prepare(insertRange)
+	if err != nil {
+		return err
+	}
+	created := ds.Created.UnixNano()
+	for _, r := range ranges {
+		_, err = stmt.Exec(
+			created,
+			r.Prefix,
+			r.Country,
+			r.Brand,
+			r.Debit,
+			r.Prepaid,
+		)
+		if err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	stmt.Close()
+	ds.Ranges = len(ranges)
+	stmt, err = db.Prepare(insertDataset)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		created,
+		ds.Checksum,
+		ds.Source,
+		ds.Ranges,
+		ds.CreatedBy,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectLatestDataset = `
+SELECT
+	created,
+	checksum,
+	source,
+	ranges,
+	created_by
+FROM bin_dataset
+ORDER BY created DESC
+LIMIT 1
+`
+
+// LatestDatasetDB selects the dataset which is used for lookups
+//
+// It returns ErrDatasetNotFound if no dataset was imported.
+func LatestDatasetDB(db *sql.DB) (*Dataset, error) {
+	ds := &Dataset{}
+	var created int64
+	err := db.QueryRow(selectLatestDataset).Scan(
+		&created,
+		&ds.Checksum,
+		&ds.Source,
+		&ds.Ranges,
+		&ds.CreatedBy,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDatasetNotFound
+		}
+		return nil, err
+	}
+	ds.Created = time.Unix(0, created)
+	return ds, nil
+}
+
+const selectRangeByPrefixes = `
+SELECT
+	r.prefix,
+	r.country,
+	r.brand,
+	r.debit,
+	r.prepaid
+FROM bin_range AS r
+WHERE
+	r.dataset = (SELECT MAX(created) FROM bin_dataset)
+	AND
+	r.prefix IN (%s)
+ORDER BY LENGTH(r.prefix) DESC
+LIMIT 1
+`
+
+// RangeByNumberDB selects the most specific range of the card number from the
+// latest dataset
+//
+// It returns ErrRangeNotFound if the number does not belong to any range.
+func RangeByNumberDB(db *sql.DB, number string) (*Range, error) {
+	prefixes, err := Prefixes(number)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, len(prefixes))
+	for i, p := range prefixes {
+		args[i] = p
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(prefixes)), ",")
+	query := strings.Replace(selectRangeByPrefixes, "%s", placeholders, 1)
+	r := &Range{}
+	err = db.QueryRow(query, args...).Scan(
+		&r.Prefix,
+		&r.Country,
+		&r.Brand,
+		&r.Debit,
+		&r.Prepaid,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRangeNotFound
+		}
+		return nil, err
+	}
+	return r, nil
+}
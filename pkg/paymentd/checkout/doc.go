@@ -0,0 +1,29 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package checkout provides checkout sessions which precede payments
+
+A checkout session is created while the shopper is still browsing, before the
+payment exists. It holds the cart reference, the amount and hints about the
+customer and can be updated until the shopper commits to the checkout. The
+session will then be converted into a payment. Each session can be converted
+into one payment only.
+
+Sessions are identified by a random token and expire after a configurable time
+to live, which will be renewed with every update.
+*/
+package checkout
@@ -0,0 +1,109 @@
+package checkout
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+)
+
+const (
+	tokenBytes = 32
+	// CartReferenceMaxLen is the maximum allowed length of a cart reference
+	CartReferenceMaxLen = payment.IdentMaxLen
+)
+
+var (
+	ErrSessionNotFound = errors.New("checkout session not found")
+	// ErrSessionConverted is returned if a session was already converted into
+	// a payment
+	ErrSessionConverted = errors.New("checkout session already converted")
+)
+
+// Session is a checkout session
+type Session struct {
+	ID        int64
+	ProjectID int64
+	Token     string
+	Created   time.Time
+
+	// Timestamp of the current session data
+	Timestamp     time.Time
+	Expires       time.Time
+	CartReference sql.NullString
+	Amount        int64
+	Subunits      int8
+	Currency      string
+	Country       string
+	Locale        sql.NullString
+	// Customer holds hints about the customer, e.g. the e-mail address
+	Customer map[string]string
+	Metadata map[string]string
+
+	// PaymentID of the payment the session was converted into
+	PaymentID sql.NullInt64
+}
+
+// NewSession creates a new session for the project with a random token
+func NewSession(projectID int64) (*Session, error) {
+	if projectID == 0 {
+		return nil, errors.New("session without project id")
+	}
+	now := time.Now()
+	s := &Session{
+		ProjectID: projectID,
+		Created:   now,
+		Timestamp: now,
+	}
+	err := s.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GenerateToken generates a new random token
+func (s *Session) GenerateToken() error {
+	bin := make([]byte, tokenBytes)
+	_, err := rand.Read(bin)
+	if err != nil {
+		return err
+	}
+	s.Token = hex.EncodeToString(bin)
+	return nil
+}
+
+// Valid returns true if the session data can be saved
+func (s *Session) Valid() bool {
+	return s.ProjectID != 0 && s.Token != "" && s.Amount >= 0 && s.Subunits >= 0 &&
+		len(s.Currency) == 3 && len(s.Country) == 2 && !s.Expires.IsZero()
+}
+
+// Expired returns true if the session cannot be used anymore
+func (s *Session) Expired() bool {
+	return !time.Now().Before(s.Expires)
+}
+
+// Converted returns true if the session was converted into a payment
+func (s *Session) Converted() bool {
+	return s.PaymentID.Valid
+}
+
+// Renew sets the timestamp of new session data and extends the expiry by the
+// given time to live
+func (s *Session) Renew(ttl time.Duration) {
+	s.Timestamp = time.Now()
+	s.Expires = s.Timestamp.Add(ttl)
+}
+
+// Payment returns the payment ID of the payment the session was converted
+// into
+func (s *Session) Payment() (payment.PaymentID, bool) {
+	if !s.Converted() {
+		return payment.PaymentID{}, false
+	}
+	return payment.PaymentID{ProjectID: s.ProjectID, PaymentID: s.PaymentID.Int64}, true
+}
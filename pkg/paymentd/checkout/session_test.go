@@ -0,0 +1,70 @@
+package checkout
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSession(t *testing.T) {
+	Convey("Given a new session", t, func() {
+		s, err := NewSession(1)
+		So(err, ShouldBeNil)
+		s.Amount = 1990
+		s.Subunits = 2
+		s.Currency = "EUR"
+		s.Country = "DE"
+
+		Convey("It should have a random token", func() {
+			So(len(s.Token), ShouldEqual, tokenBytes*2)
+			other, err := NewSession(1)
+			So(err, ShouldBeNil)
+			So(other.Token, ShouldNotEqual, s.Token)
+		})
+		Convey("It should be invalid without an expiry", func() {
+			So(s.Valid(), ShouldBeFalse)
+		})
+		Convey("When it is renewed", func() {
+			s.Renew(30 * time.Minute)
+
+			Convey("It should be valid", func() {
+				So(s.Valid(), ShouldBeTrue)
+			})
+			Convey("It should not be expired", func() {
+				So(s.Expired(), ShouldBeFalse)
+				So(s.Expires.Sub(s.Timestamp), ShouldEqual, 30*time.Minute)
+			})
+		})
+		Convey("When the expiry passed", func() {
+			s.Expires = time.Now().Add(-time.Second)
+
+			Convey("It should be expired", func() {
+				So(s.Expired(), ShouldBeTrue)
+			})
+		})
+		Convey("It should not be converted", func() {
+			So(s.Converted(), ShouldBeFalse)
+			_, ok := s.Payment()
+			So(ok, ShouldBeFalse)
+		})
+		Convey("When it was converted", func() {
+			s.PaymentID = sql.NullInt64{Int64: 2, Valid: true}
+
+			Convey("It should return the payment ID", func() {
+				id, ok := s.Payment()
+				So(ok, ShouldBeTrue)
+				So(id, ShouldResemble, payment.PaymentID{ProjectID: 1, PaymentID: 2})
+			})
+		})
+	})
+	Convey("Given a session without project", t, func() {
+		_, err := NewSession(0)
+
+		Convey("It should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
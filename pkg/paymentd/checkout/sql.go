@@ -0,0 +1,219 @@
+package checkout
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+)
+
+const selectSessionByToken = `
+SELECT
+	s.id,
+	s.project_id,
+	s.token,
+	s.created,
+	d.timestamp,
+	d.expires,
+	d.cart_reference,
+	d.amount,
+	d.subunits,
+	d.currency,
+	d.country,
+	d.locale,
+	d.customer,
+	d.metadata,
+	p.payment_id
+FROM checkout_session AS s
+INNER JOIN checkout_session_data AS d ON
+	d.checkout_session_id = s.id
+	AND
+	d.timestamp = (
+		SELECT MAX(timestamp) FROM checkout_session_data
+		WHERE
+			checkout_session_id = d.checkout_session_id
+	)
+LEFT JOIN checkout_session_payment AS p ON
+	p.checkout_session_id = s.id
+WHERE
+	s.token = ?
+`
+
+func scanSession(row *sql.Row) (*Session, error) {
+	s := &Session{}
+	var created, ts, expires int64
+	var customer, metadata sql.NullString
+	err := row.Scan(
+		&s.ID,
+		&s.ProjectID,
+		&s.Token,
+		&created,
+		&ts,
+		&expires,
+		&s.CartReference,
+		&s.Amount,
+		&s.Subunits,
+		&s.Currency,
+		&s.Country,
+		&s.Locale,
+		&customer,
+		&metadata,
+		&s.PaymentID,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	s.Created = time.Unix(0, created)
+	s.Timestamp = time.Unix(0, ts)
+	s.Expires = time.Unix(0, expires)
+	if customer.Valid {
+		err = json.Unmarshal([]byte(customer.String), &s.Customer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if metadata.Valid {
+		err = json.Unmarshal([]byte(metadata.String), &s.Metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SessionByTokenTx selects the session with the given token
+//
+// The session will be locked for the transaction.
+func SessionByTokenTx(db *sql.Tx, token string) (*Session, error) {
+	return scanSession(db.QueryRow(selectSessionByToken+" FOR UPDATE", token))
+}
+
+// SessionByTokenDB selects the session with the given token
+func SessionByTokenDB(db *sql.DB, token string) (*Session, error) {
+	return scanSession(db.QueryRow(selectSessionByToken, token))
+}
+
+const insertSession = `
+INSERT INTO checkout_session
+(project_id, token, created)
+VALUES
+(?, ?, ?)
+`
+
+// InsertSessionTx saves a new session with its data
+//
+// The token will be regenerated if it is already in use.
+func InsertSessionTx(db *sql.Tx, s *Session) error {
+	stmt, err := db.Prepare(insertSession)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(
+		s.ProjectID,
+		s.Token,
+		s.Created.UnixNano(),
+	)
+	stmt.Close()
+	if err != nil {
+		if sqldialect.IsDuplicate(err) {
+			err = s.GenerateToken()
+			if err != nil {
+				return err
+			}
+			return InsertSessionTx(db, s)
+		}
+		return err
+	}
+	s.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return InsertSessionDataTx(db, s)
+}
+
+const insertSessionData = `
+INSERT INTO checkout_session_data
+(checkout_session_id, timestamp, expires, cart_reference, amount, subunits, currency, country, locale, customer, metadata)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// nullJSON encodes the map, empty maps will be stored as NULL
+func nullJSON(m map[string]string) (sql.NullString, error) {
+	if len(m) == 0 {
+		return sql.NullString{}, nil
+	}
+	p, err := json.Marshal(m)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(p), Valid: true}, nil
+}
+
+// InsertSessionDataTx saves the current data of the session
+func InsertSessionDataTx(db *sql.Tx, s *Session) error {
+	customer, err := nullJSON(s.Customer)
+	if err != nil {
+		return err
+	}
+	metadata, err := nullJSON(s.Metadata)
+	if err != nil {
+		return err
+	}
+	stmt, err := db.Prepare(insertSessionData)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		s.ID,
+		s.Timestamp.UnixNano(),
+		s.Expires.UnixNano(),
+		s.CartReference,
+		s.Amount,
+		s.Subunits,
+		s.Currency,
+		s.Country,
+		s.Locale,
+		customer,
+		metadata,
+	)
+	stmt.Close()
+	return err
+}
+
+const insertSessionPayment = `
+INSERT INTO checkout_session_payment
+(checkout_session_id, timestamp, project_id, payment_id)
+VALUES
+(?, ?, ?, ?)
+`
+
+// InsertSessionPaymentTx marks the session as converted into the given payment
+//
+// It returns an ErrSessionConverted if the session was already converted.
+func InsertSessionPaymentTx(db *sql.Tx, s *Session, id payment.PaymentID) error {
+	stmt, err := db.Prepare(insertSessionPayment)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		s.ID,
+		time.Now().UnixNano(),
+		id.ProjectID,
+		id.PaymentID,
+	)
+	stmt.Close()
+	if err != nil {
+		if sqldialect.IsDuplicate(err) {
+			return ErrSessionConverted
+		}
+		return err
+	}
+	s.PaymentID = sql.NullInt64{Int64: id.PaymentID, Valid: true}
+	return nil
+}
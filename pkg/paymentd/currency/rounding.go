@@ -0,0 +1,135 @@
+package currency
+
+import (
+	"fmt"
+	"strings"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+)
+
+// DefaultExponent is the number of decimal places of currencies which are not
+// listed in the exponent table
+const DefaultExponent = 2
+
+// decimal places of currencies which do not use two decimal places
+var exponents = map[string]int32{
+	"BHD": 3,
+	"BIF": 0,
+	"CLP": 0,
+	"DJF": 0,
+	"GNF": 0,
+	"IQD": 3,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KMF": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"LYD": 3,
+	"OMR": 3,
+	"PYG": 0,
+	"RWF": 0,
+	"TND": 3,
+	"UGX": 0,
+	"VND": 0,
+	"VUV": 0,
+	"XAF": 0,
+	"XOF": 0,
+	"XPF": 0,
+}
+
+// Exponent returns the number of decimal places (minor units) of the currency
+// as defined in ISO 4217
+func Exponent(code string) int32 {
+	if e, ok := exponents[strings.ToUpper(code)]; ok {
+		return e
+	}
+	return DefaultExponent
+}
+
+// RoundingMode determines how amounts will be rounded
+type RoundingMode string
+
+const (
+	// RoundHalfUp rounds half away from zero
+	RoundHalfUp RoundingMode = "halfUp"
+	// RoundHalfEven rounds half to the nearest even digit (banker's rounding)
+	RoundHalfEven RoundingMode = "halfEven"
+	// RoundDown truncates
+	RoundDown RoundingMode = "down"
+)
+
+func (m RoundingMode) rounder() (dec.Rounder, bool) {
+	switch m {
+	case RoundHalfUp, "":
+		return dec.RoundHalfUp, true
+	case RoundHalfEven:
+		return dec.RoundHalfEven, true
+	case RoundDown:
+		return dec.RoundDown, true
+	default:
+		return nil, false
+	}
+}
+
+// RoundingPolicy determines how amounts will be rounded before they are sent
+// to a provider
+//
+// The zero value rounds half up to the ISO 4217 decimal places of the currency.
+type RoundingPolicy struct {
+	Mode RoundingMode
+	// Exponents overrides the decimal places by currency code, e.g. providers
+	// which do not support decimal places for some currencies
+	Exponents map[string]int32
+}
+
+// NewRoundingPolicy creates a new rounding policy
+//
+// It will return an error if the mode is not recognized.
+func NewRoundingPolicy(mode string, exp map[string]int32) (RoundingPolicy, error) {
+	pol := RoundingPolicy{
+		Mode:      RoundingMode(mode),
+		Exponents: make(map[string]int32, len(exp)),
+	}
+	if _, ok := pol.Mode.rounder(); !ok {
+		return pol, fmt.Errorf("invalid rounding mode %s", mode)
+	}
+	for code, e := range exp {
+		if e < 0 {
+			return pol, fmt.Errorf("invalid exponent %d for currency %s", e, code)
+		}
+		pol.Exponents[strings.ToUpper(code)] = e
+	}
+	return pol, nil
+}
+
+// Exponent returns the decimal places the policy uses for the currency
+func (p RoundingPolicy) Exponent(code string) int32 {
+	if e, ok := p.Exponents[strings.ToUpper(code)]; ok {
+		return e
+	}
+	return Exponent(code)
+}
+
+// Round rounds the amount in the given currency according to the policy
+//
+// If the rounded amount differs from the original amount, exact will be false.
+// Callers should report those discrepancies, since the provider will process a
+// different amount than the one recorded for the payment.
+func (p RoundingPolicy) Round(d *decimal.Decimal, code string) (rounded *decimal.Decimal, exact bool) {
+	r, ok := p.Mode.rounder()
+	if !ok {
+		r = dec.RoundHalfUp
+	}
+	rounded = &decimal.Decimal{}
+	rounded.Round(&d.Dec, dec.Scale(p.Exponent(code)), r)
+	return rounded, rounded.Cmp(&d.Dec) == 0
+}
+
+// MinorUnits returns the rounded amount in the smallest unit of the currency,
+// e.g. cents
+func (p RoundingPolicy) MinorUnits(d *decimal.Decimal, code string) (units int64, exact bool) {
+	rounded, exact := p.Round(d, code)
+	return rounded.Unscaled().Int64(), exact
+}
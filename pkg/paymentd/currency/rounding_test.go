@@ -0,0 +1,71 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func decimalString(s string) *decimal.Decimal {
+	d := &decimal.Decimal{}
+	d.SetString(s)
+	return d
+}
+
+func TestRoundingPolicy(t *testing.T) {
+	Convey("Given the default rounding policy", t, func() {
+		pol := RoundingPolicy{}
+
+		Convey("When rounding an amount with the currency decimal places", func() {
+			r, exact := pol.Round(decimalString("12.30"), "EUR")
+			Convey("It should be exact", func() {
+				So(exact, ShouldBeTrue)
+				So(r.String(), ShouldEqual, "12.30")
+			})
+		})
+		Convey("When rounding an amount in a zero-decimal currency", func() {
+			r, exact := pol.Round(decimalString("1234.50"), "jpy")
+			Convey("It should be rounded half up", func() {
+				So(exact, ShouldBeFalse)
+				So(r.String(), ShouldEqual, "1235")
+			})
+			Convey("The minor units should match", func() {
+				units, _ := pol.MinorUnits(decimalString("1234.50"), "JPY")
+				So(units, ShouldEqual, 1235)
+			})
+		})
+		Convey("When rounding an amount in a three-decimal currency", func() {
+			units, exact := pol.MinorUnits(decimalString("1.5"), "KWD")
+			Convey("It should be converted to minor units", func() {
+				So(exact, ShouldBeTrue)
+				So(units, ShouldEqual, 1500)
+			})
+		})
+	})
+
+	Convey("Given a banker's rounding policy with exponent overrides", t, func() {
+		pol, err := NewRoundingPolicy("halfEven", map[string]int32{"huf": 0})
+		So(err, ShouldBeNil)
+
+		Convey("Amounts should be rounded half to even", func() {
+			r, exact := pol.Round(decimalString("0.125"), "EUR")
+			So(exact, ShouldBeFalse)
+			So(r.String(), ShouldEqual, "0.12")
+			r, _ = pol.Round(decimalString("0.135"), "EUR")
+			So(r.String(), ShouldEqual, "0.14")
+		})
+		Convey("The exponent override should be used", func() {
+			So(pol.Exponent("HUF"), ShouldEqual, 0)
+			r, _ := pol.Round(decimalString("100.50"), "HUF")
+			So(r.String(), ShouldEqual, "100")
+		})
+	})
+
+	Convey("Given an invalid rounding mode", t, func() {
+		_, err := NewRoundingPolicy("up", nil)
+		Convey("Creating the policy should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
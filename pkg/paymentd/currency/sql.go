@@ -3,6 +3,8 @@ package currency
 import (
 	"database/sql"
 	"errors"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
 )
 
 var (
@@ -64,3 +66,44 @@ func CurrencyAllDB(db *sql.DB) ([]Currency, error) {
 	}
 	return d, err
 }
+
+// CurrencyListing is the listing of currencies
+var CurrencyListing = listing.Builder{
+	Select:      selectCurrency,
+	Key:         "CodeISO4217",
+	DefaultSort: "CodeISO4217",
+	Columns: map[string]string{
+		"CodeISO4217": "code_iso_4217",
+	},
+}
+
+// CurrencyListDB selects a page of currencies
+func CurrencyListDB(db *sql.DB, q *listing.Query) ([]Currency, listing.Page, error) {
+	query, args, err := CurrencyListing.Build(q, "")
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	d := make([]Currency, 0, q.Limit+1)
+	for rows.Next() {
+		c := Currency{}
+		err = rows.Scan(&c.CodeISO4217)
+		if err != nil {
+			rows.Close()
+			return nil, listing.Page{}, err
+		}
+		d = append(d, c)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(d), func(i int) listing.Cursor {
+		return listing.Cursor{Key: d[i].CodeISO4217}
+	})
+	return d[:n], page, nil
+}
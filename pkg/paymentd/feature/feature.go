@@ -0,0 +1,72 @@
+package feature
+
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// Flag represents a feature flag
+type Flag struct {
+	Name string
+	// Enabled enables the feature for all projects
+	Enabled bool
+	// Percentage of projects for which the feature is enabled
+	//
+	// The selection of projects is stable for a flag name.
+	Percentage int
+	// Projects lists the IDs of projects for which the feature is enabled
+	Projects []int64
+
+	Created   time.Time
+	CreatedBy string
+}
+
+// Valid returns true if the flag values are valid
+func (f Flag) Valid() bool {
+	return f.Name != "" && len(f.Name) <= 64 && f.Percentage >= 0 && f.Percentage <= 100
+}
+
+// EnabledFor returns true if the feature is enabled for the given project
+//
+// A project ID of 0 will only match globally enabled flags.
+func (f Flag) EnabledFor(projectID int64) bool {
+	if f.Enabled {
+		return true
+	}
+	if projectID == 0 {
+		return false
+	}
+	for _, id := range f.Projects {
+		if id == projectID {
+			return true
+		}
+	}
+	if f.Percentage <= 0 {
+		return false
+	}
+	return bucket(f.Name, projectID) < f.Percentage
+}
+
+// bucket assigns the project to one of 100 buckets per flag name
+func bucket(name string, projectID int64) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{':'})
+	h.Write([]byte(strconv.FormatInt(projectID, 10)))
+	return int(h.Sum32() % 100)
+}
+
+// Flags is a set of flags by name
+type Flags map[string]Flag
+
+// Enabled returns true if the named feature is enabled for the given project
+//
+// Unknown features are disabled.
+func (f Flags) Enabled(name string, projectID int64) bool {
+	flag, ok := f[name]
+	if !ok {
+		return false
+	}
+	return flag.EnabledFor(projectID)
+}
@@ -0,0 +1,87 @@
+package feature
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFlag(t *testing.T) {
+	Convey("Given a globally enabled flag", t, func() {
+		f := Flag{Name: "test", Enabled: true}
+		Convey("It should be enabled for all projects", func() {
+			So(f.EnabledFor(0), ShouldBeTrue)
+			So(f.EnabledFor(1), ShouldBeTrue)
+		})
+	})
+	Convey("Given a flag enabled for projects", t, func() {
+		f := Flag{Name: "test", Projects: []int64{2, 3}}
+		Convey("It should only be enabled for those projects", func() {
+			So(f.EnabledFor(0), ShouldBeFalse)
+			So(f.EnabledFor(1), ShouldBeFalse)
+			So(f.EnabledFor(2), ShouldBeTrue)
+			So(f.EnabledFor(3), ShouldBeTrue)
+		})
+	})
+	Convey("Given a percentage rollout", t, func() {
+		f := Flag{Name: "test", Percentage: 30}
+		Convey("It should be enabled for roughly the percentage of projects", func() {
+			var enabled int
+			for id := int64(1); id <= 1000; id++ {
+				if f.EnabledFor(id) {
+					enabled++
+				}
+			}
+			So(enabled, ShouldBeBetween, 200, 400)
+		})
+		Convey("It should be stable", func() {
+			for id := int64(1); id <= 100; id++ {
+				So(f.EnabledFor(id), ShouldEqual, f.EnabledFor(id))
+			}
+		})
+		Convey("Raising the percentage should keep enabled projects", func() {
+			more := f
+			more.Percentage = 60
+			for id := int64(1); id <= 100; id++ {
+				if f.EnabledFor(id) {
+					So(more.EnabledFor(id), ShouldBeTrue)
+				}
+			}
+		})
+	})
+	Convey("Given invalid flags", t, func() {
+		Convey("They should not be valid", func() {
+			So(Flag{}.Valid(), ShouldBeFalse)
+			So(Flag{Name: "test", Percentage: 101}.Valid(), ShouldBeFalse)
+			So(Flag{Name: "test", Percentage: -1}.Valid(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	Convey("Given a registry with defaults", t, func() {
+		r := NewRegistry(Flags{
+			"a": Flag{Name: "a", Enabled: true},
+			"b": Flag{Name: "b", Enabled: true},
+		})
+		Convey("It should be stale before loading", func() {
+			So(r.Stale(0), ShouldBeTrue)
+		})
+		Convey("Unknown features should be disabled", func() {
+			So(r.Enabled("c", 1), ShouldBeFalse)
+		})
+		Convey("When setting stored flags", func() {
+			r.Set(Flags{
+				"b": Flag{Name: "b", Enabled: false},
+			})
+			Convey("Stored flags should take precedence", func() {
+				So(r.Enabled("a", 1), ShouldBeTrue)
+				So(r.Enabled("b", 1), ShouldBeFalse)
+			})
+			Convey("It should not be stale", func() {
+				So(r.Stale(time.Minute), ShouldBeFalse)
+			})
+		})
+	})
+}
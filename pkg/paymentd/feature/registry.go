@@ -0,0 +1,89 @@
+package feature
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Registry holds the current feature flags
+//
+// It is safe for concurrent use.
+type Registry struct {
+	defaults Flags
+
+	m      sync.RWMutex
+	flags  Flags
+	loaded time.Time
+}
+
+// NewRegistry creates a new registry with the given default flags
+func NewRegistry(defaults Flags) *Registry {
+	if defaults == nil {
+		defaults = make(Flags)
+	}
+	r := &Registry{
+		defaults: defaults,
+		flags:    make(Flags, len(defaults)),
+	}
+	for name, f := range defaults {
+		r.flags[name] = f
+	}
+	return r
+}
+
+// Load (re-)loads the flags from the database
+//
+// Flags in the database take precedence over the default flags. On errors the
+// current flags will be kept until the next reload.
+func (r *Registry) Load(db *sql.DB) error {
+	stored, err := FlagsDB(db)
+	if err != nil {
+		r.m.Lock()
+		r.loaded = time.Now()
+		r.m.Unlock()
+		return err
+	}
+	r.Set(stored)
+	return nil
+}
+
+// Set replaces the stored flags of the registry
+func (r *Registry) Set(stored Flags) {
+	flags := make(Flags, len(r.defaults)+len(stored))
+	for name, f := range r.defaults {
+		flags[name] = f
+	}
+	for name, f := range stored {
+		flags[name] = f
+	}
+	r.m.Lock()
+	r.flags = flags
+	r.loaded = time.Now()
+	r.m.Unlock()
+}
+
+// Stale returns true if the flags were not loaded within the given duration
+func (r *Registry) Stale(d time.Duration) bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return time.Since(r.loaded) > d
+}
+
+// Enabled returns true if the named feature is enabled for the given project
+func (r *Registry) Enabled(name string, projectID int64) bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.flags.Enabled(name, projectID)
+}
+
+// Flags returns a copy of the current flags
+func (r *Registry) Flags() Flags {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	flags := make(Flags, len(r.flags))
+	for name, f := range r.flags {
+		flags[name] = f
+	}
+	return flags
+}
@@ -0,0 +1,119 @@
+package feature
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const selectFlags = `
+SELECT
+	f.name,
+	f.timestamp,
+	f.enabled,
+	f.percentage,
+	f.projects,
+	f.created_by
+FROM feature_flag AS f
+WHERE
+	f.timestamp = (
+		SELECT MAX(timestamp) FROM feature_flag
+		WHERE
+			name = f.name
+	)
+`
+
+func readFlags(rows *sql.Rows) (Flags, error) {
+	flags := make(Flags)
+	var err error
+	for rows.Next() {
+		f := Flag{}
+		var ts int64
+		var projects sql.NullString
+		err = rows.Scan(&f.Name, &ts, &f.Enabled, &f.Percentage, &projects, &f.CreatedBy)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		f.Created = time.Unix(0, ts)
+		f.Projects, err = parseProjects(projects.String)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		flags[f.Name] = f
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+func parseProjects(s string) ([]int64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	ids := make([]int64, len(parts))
+	for i, p := range parts {
+		var err error
+		ids[i], err = strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+func formatProjects(ids []int64) sql.NullString {
+	if len(ids) == 0 {
+		return sql.NullString{}
+	}
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return sql.NullString{String: strings.Join(parts, ","), Valid: true}
+}
+
+// FlagsDB selects the current feature flags
+func FlagsDB(db *sql.DB) (Flags, error) {
+	rows, err := db.Query(selectFlags)
+	if err != nil {
+		return nil, err
+	}
+	return readFlags(rows)
+}
+
+// FlagsTx selects the current feature flags
+func FlagsTx(db *sql.Tx) (Flags, error) {
+	rows, err := db.Query(selectFlags)
+	if err != nil {
+		return nil, err
+	}
+	return readFlags(rows)
+}
+
+const insertFlag = `
+INSERT INTO feature_flag
+(name, timestamp, enabled, percentage, projects, created_by)
+VALUES
+(?, ?, ?, ?, ?, ?)
+`
+
+// InsertFlagTx saves a new version of the given flag
+func InsertFlagTx(db *sql.Tx, f *Flag) error {
+	stmt, err := db.Prepare(insertFlag)
+	if err != nil {
+		return err
+	}
+	if f.Created.IsZero() {
+		f.Created = time.Now()
+	}
+	_, err = stmt.Exec(f.Name, f.Created.UnixNano(), f.Enabled, f.Percentage, formatProjects(f.Projects), f.CreatedBy)
+	stmt.Close()
+	return err
+}
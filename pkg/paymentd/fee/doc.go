@@ -0,0 +1,25 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package fee provides the fee schedules of the providers and the processing costs
+of payments
+
+The processing cost of a payment is estimated with the fee schedule of its
+provider when the payment is authorized. The actual cost is recorded when the
+settlement of the provider is imported.
+*/
+package fee
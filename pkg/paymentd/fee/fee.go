@@ -0,0 +1,233 @@
+package fee
+
+import (
+	"errors"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+)
+
+// Card fundings of fee rules
+const (
+	FundingCredit  = "credit"
+	FundingDebit   = "debit"
+	FundingPrepaid = "prepaid"
+)
+
+// Card regions of fee rules
+const (
+	// RegionDomestic cards are issued in the payment country
+	RegionDomestic = "domestic"
+	// RegionInternational cards are issued in another country
+	RegionInternational = "international"
+)
+
+// Cost types
+const (
+	// CostEstimated costs are estimated with the fee schedule
+	CostEstimated = "estimated"
+	// CostActual costs are reported in the settlement of the provider
+	CostActual = "actual"
+)
+
+var (
+	ErrScheduleNotFound = errors.New("fee schedule not found")
+	ErrCostNotFound     = errors.New("payment cost not found")
+)
+
+// Rule is a fee rule of a fee schedule
+//
+// Empty criteria match all payments. Rules with card criteria only match
+// payments with a recorded card.
+type Rule struct {
+	// Brand is the card brand, e.g. "visa"
+	Brand string `json:",omitempty"`
+	// Funding is "credit", "debit" or "prepaid"
+	Funding string `json:",omitempty"`
+	// Region is "domestic" or "international"
+	Region string `json:",omitempty"`
+	// Percent is the fee in percent of the payment amount, e.g. "1.4"
+	Percent string
+	// Fixed is the fixed fee in the payment currency, e.g. "0.25"
+	Fixed string
+}
+
+// Valid returns true if the rule can be used for estimates
+func (r *Rule) Valid() bool {
+	switch r.Funding {
+	case "", FundingCredit, FundingDebit, FundingPrepaid:
+	default:
+		return false
+	}
+	switch r.Region {
+	case "", RegionDomestic, RegionInternational:
+	default:
+		return false
+	}
+	percent, ok := parseFee(r.Percent)
+	if !ok || percent.Cmp(dec.NewDecInt64(100)) > 0 {
+		return false
+	}
+	_, ok = parseFee(r.Fixed)
+	return ok
+}
+
+// parseFee parses a non-negative decimal fee value. Empty values are zero
+func parseFee(s string) (*dec.Dec, bool) {
+	d := dec.NewDecInt64(0)
+	if s == "" {
+		return d, true
+	}
+	if _, ok := d.SetString(s); !ok || d.Sign() < 0 || d.Scale() < 0 || d.Scale() > 8 {
+		return nil, false
+	}
+	return d, true
+}
+
+// Matches returns true if the rule applies to the payment
+func (r *Rule) Matches(p *payment.Payment) bool {
+	if r.Brand == "" && r.Funding == "" && r.Region == "" {
+		return true
+	}
+	if p.Card == nil {
+		return false
+	}
+	if r.Brand != "" && r.Brand != p.Card.Brand {
+		return false
+	}
+	if r.Funding != "" && r.Funding != CardFunding(p.Card) {
+		return false
+	}
+	if r.Region != "" {
+		domestic := p.Card.Domestic(p.Config.Country.String)
+		if domestic != (r.Region == RegionDomestic) {
+			return false
+		}
+	}
+	return true
+}
+
+// CardFunding returns the funding of the card
+func CardFunding(c *payment.Card) string {
+	if c.Prepaid {
+		return FundingPrepaid
+	}
+	if c.Debit {
+		return FundingDebit
+	}
+	return FundingCredit
+}
+
+// Fee returns the fee for the payment in the subunits of the payment
+//
+// The fee will be rounded half up.
+func (r *Rule) Fee(p *payment.Payment) int64 {
+	percent, _ := parseFee(r.Percent)
+	fixed, _ := parseFee(r.Fixed)
+	share := &dec.Dec{}
+	share.Mul(&p.Decimal().Dec, percent)
+	fee := &dec.Dec{}
+	fee.QuoExact(share, dec.NewDecInt64(100))
+	fee.Add(fee, fixed)
+	fee.Round(fee, dec.Scale(p.Subunits), dec.RoundHalfUp)
+	return fee.Unscaled().Int64()
+}
+
+// Schedule is the fee schedule of a provider for a project
+type Schedule struct {
+	ProjectID int64
+	Provider  string
+	Created   time.Time
+	CreatedBy string
+	// Rules are applied in order. The first matching rule is used
+	Rules []Rule
+}
+
+// Valid returns true if all rules of the schedule are valid
+func (s *Schedule) Valid() bool {
+	if s.ProjectID == 0 || s.Provider == "" {
+		return false
+	}
+	for i := range s.Rules {
+		if !s.Rules[i].Valid() {
+			return false
+		}
+	}
+	return true
+}
+
+// Estimate returns the estimated cost of the payment
+//
+// It returns false if no rule matches the payment.
+func (s *Schedule) Estimate(p *payment.Payment) (*Cost, bool) {
+	for i := range s.Rules {
+		if !s.Rules[i].Matches(p) {
+			continue
+		}
+		c := NewCost(p, CostEstimated, s.Provider)
+		c.Amount = s.Rules[i].Fee(p)
+		return c, true
+	}
+	return nil, false
+}
+
+// Cost is a processing cost of a payment
+type Cost struct {
+	Timestamp time.Time
+	Type      string
+	Provider  string
+	// Brand is the card brand of the payment, if known
+	Brand    string
+	Amount   int64
+	Subunits int8
+	Currency string
+	// Source is the origin of the cost, e.g. the settlement reference
+	Source string
+}
+
+// NewCost creates a new cost of the payment in the payment currency
+func NewCost(p *payment.Payment, typ, provider string) *Cost {
+	c := &Cost{
+		Timestamp: time.Now(),
+		Type:      typ,
+		Provider:  provider,
+		Subunits:  p.Subunits,
+		Currency:  p.Currency,
+	}
+	if p.Card != nil {
+		c.Brand = p.Card.Brand
+	}
+	return c
+}
+
+// Decimal returns the amount of the cost as a decimal
+func (c *Cost) Decimal() *decimal.Decimal {
+	d := dec.NewDecInt64(c.Amount)
+	d.SetScale(dec.Scale(c.Subunits))
+	return &decimal.Decimal{Dec: *d}
+}
+
+// ReportEntry are the costs of the payments of a provider, card brand and
+// currency
+type ReportEntry struct {
+	Provider string
+	Brand    string
+	Currency string
+	Subunits int8
+	// Payments is the number of payments with an estimated cost
+	Payments  int64
+	Estimated int64
+	// Settled is the number of payments with an actual cost
+	Settled int64
+	Actual  int64
+	// EstimatedSettled is the estimated cost of the settled payments
+	EstimatedSettled int64
+}
+
+// Difference returns the difference of the actual and the estimated cost of
+// the settled payments
+func (e *ReportEntry) Difference() int64 {
+	return e.Actual - e.EstimatedSettled
+}
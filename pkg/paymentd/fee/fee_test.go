@@ -0,0 +1,95 @@
+package fee
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRule(t *testing.T) {
+	Convey("Given a fee rule", t, func() {
+		r := Rule{Percent: "1.4", Fixed: "0.25"}
+		So(r.Valid(), ShouldBeTrue)
+
+		Convey("Given a payment of 19.99 EUR", func() {
+			p := &payment.Payment{Amount: 1999, Subunits: 2, Currency: "EUR"}
+
+			Convey("The fee should be rounded half up", func() {
+				// 0.27986 + 0.25
+				So(r.Fee(p), ShouldEqual, 53)
+			})
+		})
+		Convey("Given a payment in a currency without subunits", func() {
+			p := &payment.Payment{Amount: 1000, Subunits: 0, Currency: "JPY"}
+			r.Fixed = "30"
+
+			Convey("The fee should be in whole units", func() {
+				So(r.Fee(p), ShouldEqual, 44)
+			})
+		})
+	})
+	Convey("Given invalid fee rules", t, func() {
+		Convey("They should not be valid", func() {
+			So((&Rule{Percent: "-1"}).Valid(), ShouldBeFalse)
+			So((&Rule{Percent: "101"}).Valid(), ShouldBeFalse)
+			So((&Rule{Fixed: "abc"}).Valid(), ShouldBeFalse)
+			So((&Rule{Funding: "charge"}).Valid(), ShouldBeFalse)
+			So((&Rule{Region: "eea"}).Valid(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestScheduleEstimate(t *testing.T) {
+	Convey("Given a fee schedule with card rules", t, func() {
+		s := &Schedule{
+			ProjectID: 1,
+			Provider:  "stripe",
+			Rules: []Rule{
+				{Funding: FundingDebit, Region: RegionDomestic, Percent: "0.2"},
+				{Brand: "amex", Percent: "3"},
+				{Percent: "1.4", Fixed: "0.25"},
+			},
+		}
+		So(s.Valid(), ShouldBeTrue)
+		p := &payment.Payment{Amount: 10000, Subunits: 2, Currency: "EUR"}
+		p.Config.SetCountry("DE")
+
+		Convey("When the payment has no card", func() {
+			Convey("The catch-all rule should be used", func() {
+				c, ok := s.Estimate(p)
+				So(ok, ShouldBeTrue)
+				So(c.Type, ShouldEqual, CostEstimated)
+				So(c.Amount, ShouldEqual, 165)
+				So(c.Decimal().String(), ShouldEqual, "1.65")
+			})
+		})
+		Convey("When the payment has a domestic debit card", func() {
+			p.Card = &payment.Card{Country: "DE", Brand: "visa", Debit: true}
+
+			Convey("The domestic debit rule should be used", func() {
+				c, ok := s.Estimate(p)
+				So(ok, ShouldBeTrue)
+				So(c.Amount, ShouldEqual, 20)
+				So(c.Brand, ShouldEqual, "visa")
+			})
+		})
+		Convey("When the payment has a foreign debit card", func() {
+			p.Card = &payment.Card{Country: "US", Brand: "amex", Debit: true}
+
+			Convey("The brand rule should be used", func() {
+				c, ok := s.Estimate(p)
+				So(ok, ShouldBeTrue)
+				So(c.Amount, ShouldEqual, 300)
+			})
+		})
+		Convey("When no rule matches", func() {
+			s.Rules = s.Rules[:2]
+
+			Convey("There should be no estimate", func() {
+				_, ok := s.Estimate(p)
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,271 @@
+package fee
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const insertSchedule = `
+INSERT INTO fee_schedule
+(project_id, provider, created, created_by, rules)
+VALUES
+(?, ?, ?, ?, ?)
+`
+
+// InsertScheduleTx saves a new version of the fee schedule
+func InsertScheduleTx(db *sql.Tx, s *Schedule) error {
+	rules, err := json.Marshal(s.Rules)
+	if err != nil {
+		return err
+	}
+	stmt, err := db.Prepare(insertSchedule)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		s.ProjectID,
+		s.Provider,
+		s.Created.UnixNano(),
+		s.CreatedBy,
+		rules,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectScheduleByProjectAndProvider = `
+SELECT
+	s.project_id,
+	s.provider,
+	s.created,
+	s.created_by,
+	s.rules
+FROM fee_schedule AS s
+WHERE
+	s.project_id = ?
+	AND
+	s.provider = ?
+	AND
+	s.created = (
+		SELECT MAX(created) FROM fee_schedule
+		WHERE
+			project_id = s.project_id
+			AND
+			provider = s.provider
+	)
+`
+
+func scanSchedule(row *sql.Row) (*Schedule, error) {
+	s := &Schedule{}
+	var created int64
+	var rules []byte
+	err := row.Scan(
+		&s.ProjectID,
+		&s.Provider,
+		&created,
+		&s.CreatedBy,
+		&rules,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrScheduleNotFound
+		}
+		return nil, err
+	}
+	s.Created = time.Unix(0, created)
+	err = json.Unmarshal(rules, &s.Rules)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ScheduleDB selects the current fee schedule of the provider for the project
+//
+// It returns ErrScheduleNotFound if no schedule was configured.
+func ScheduleDB(db *sql.DB, projectID int64, provider string) (*Schedule, error) {
+	return scanSchedule(db.QueryRow(selectScheduleByProjectAndProvider, projectID, provider))
+}
+
+// ScheduleTx selects the current fee schedule of the provider for the project
+//
+// It returns ErrScheduleNotFound if no schedule was configured.
+func ScheduleTx(db *sql.Tx, projectID int64, provider string) (*Schedule, error) {
+	return scanSchedule(db.QueryRow(selectScheduleByProjectAndProvider, projectID, provider))
+}
+
+const insertCost = `
+INSERT INTO payment_cost
+(project_id, payment_id, type, timestamp, provider, brand, amount, subunits, currency, source)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertCostTx saves a cost of the payment
+func InsertCostTx(db *sql.Tx, projectID, paymentID int64, c *Cost) error {
+	stmt, err := db.Prepare(insertCost)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		projectID,
+		paymentID,
+		c.Type,
+		c.Timestamp.UnixNano(),
+		c.Provider,
+		c.Brand,
+		c.Amount,
+		c.Subunits,
+		c.Currency,
+		c.Source,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectCost = `
+SELECT
+	c.type,
+	c.timestamp,
+	c.provider,
+	c.brand,
+	c.amount,
+	c.subunits,
+	c.currency,
+	c.source
+FROM payment_cost AS c
+WHERE
+	c.project_id = ?
+	AND
+	c.payment_id = ?
+	AND
+	c.type = ?
+	AND
+	c.timestamp = (
+		SELECT MAX(timestamp) FROM payment_cost
+		WHERE
+			project_id = c.project_id
+			AND
+			payment_id = c.payment_id
+			AND
+			type = c.type
+	)
+`
+
+func scanCost(row *sql.Row) (*Cost, error) {
+	c := &Cost{}
+	var ts int64
+	err := row.Scan(
+		&c.Type,
+		&ts,
+		&c.Provider,
+		&c.Brand,
+		&c.Amount,
+		&c.Subunits,
+		&c.Currency,
+		&c.Source,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrCostNotFound
+		}
+		return nil, err
+	}
+	c.Timestamp = time.Unix(0, ts)
+	return c, nil
+}
+
+// CostDB selects the current cost of the given type of the payment
+//
+// It returns ErrCostNotFound if no such cost was recorded.
+func CostDB(db *sql.DB, projectID, paymentID int64, typ string) (*Cost, error) {
+	return scanCost(db.QueryRow(selectCost, projectID, paymentID, typ))
+}
+
+// CostTx selects the current cost of the given type of the payment
+//
+// It returns ErrCostNotFound if no such cost was recorded.
+func CostTx(db *sql.Tx, projectID, paymentID int64, typ string) (*Cost, error) {
+	return scanCost(db.QueryRow(selectCost, projectID, paymentID, typ))
+}
+
+const selectReport = `
+SELECT
+	e.provider,
+	e.brand,
+	e.currency,
+	e.subunits,
+	COUNT(*),
+	SUM(e.amount),
+	COUNT(a.amount),
+	COALESCE(SUM(a.amount), 0),
+	COALESCE(SUM(IF(a.amount IS NULL, 0, e.amount)), 0)
+FROM payment_cost AS e
+LEFT JOIN payment_cost AS a ON
+	a.project_id = e.project_id
+	AND
+	a.payment_id = e.payment_id
+	AND
+	a.type = 'actual'
+	AND
+	a.timestamp = (
+		SELECT MAX(timestamp) FROM payment_cost
+		WHERE
+			project_id = a.project_id
+			AND
+			payment_id = a.payment_id
+			AND
+			type = a.type
+	)
+WHERE
+	e.project_id = ?
+	AND
+	e.type = 'estimated'
+	AND
+	e.timestamp >= ?
+	AND
+	e.timestamp < ?
+	AND
+	e.timestamp = (
+		SELECT MAX(timestamp) FROM payment_cost
+		WHERE
+			project_id = e.project_id
+			AND
+			payment_id = e.payment_id
+			AND
+			type = e.type
+	)
+GROUP BY e.provider, e.brand, e.currency, e.subunits
+ORDER BY e.provider, e.brand, e.currency
+`
+
+// ReportDB returns the costs of the payments of the project, which were
+// estimated within the given time range
+func ReportDB(db *sql.DB, projectID int64, from, to time.Time) ([]*ReportEntry, error) {
+	rows, err := db.Query(selectReport, projectID, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]*ReportEntry, 0)
+	for rows.Next() {
+		e := &ReportEntry{}
+		err = rows.Scan(
+			&e.Provider,
+			&e.Brand,
+			&e.Currency,
+			&e.Subunits,
+			&e.Payments,
+			&e.Estimated,
+			&e.Settled,
+			&e.Actual,
+			&e.EstimatedSettled,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,25 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package funds provides incoming funds which could not be matched to a payment
+
+Imports of bank statements or provider reports add funds which could not be
+reconciled automatically, e.g. because of a missing or mistyped reference. Those
+funds will be queued as unmatched until they are manually assigned to a payment or
+marked to be returned to the sender.
+*/
+package funds
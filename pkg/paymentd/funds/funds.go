@@ -0,0 +1,75 @@
+package funds
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+)
+
+// Statuses of incoming funds
+const (
+	// StatusUnmatched funds are waiting to be matched
+	StatusUnmatched = "unmatched"
+	// StatusMatched funds were assigned to a payment
+	StatusMatched = "matched"
+	// StatusReturn funds are to be returned to the sender
+	StatusReturn = "return"
+)
+
+var (
+	ErrFundsNotFound = errors.New("funds not found")
+)
+
+// Funds represents incoming funds, e.g. a bank transfer
+type Funds struct {
+	ID      int64
+	Created time.Time
+	// Source of the funds, e.g. the bank account or provider which reported them
+	Source string
+	// Reference given by the sender, e.g. the remittance information of a
+	// transfer
+	Reference string
+	Sender    sql.NullString
+	Amount    int64
+	Subunits  int8
+	Currency  string
+	CreatedBy string
+
+	Status Status
+}
+
+// Status represents a status change on incoming funds
+type Status struct {
+	Timestamp time.Time
+	Status    string
+	// ProjectID and PaymentID of the payment the funds were matched with
+	ProjectID sql.NullInt64
+	PaymentID sql.NullInt64
+	CreatedBy string
+	Comment   sql.NullString
+}
+
+// Valid returns true if the funds can be saved
+func (f *Funds) Valid() bool {
+	return f.Source != "" && f.Amount > 0 && f.Subunits >= 0 && len(f.Currency) == 3 && f.CreatedBy != ""
+}
+
+// Decimal returns the amount as a decimal
+func (f *Funds) Decimal() *decimal.Decimal {
+	d := dec.NewDecInt64(f.Amount)
+	d.SetScale(dec.Scale(f.Subunits))
+	return &decimal.Decimal{Dec: *d}
+}
+
+// NewStatus creates a new status change for the funds
+func (f *Funds) NewStatus(status, createdBy string) *Status {
+	f.Status = Status{
+		Timestamp: time.Now(),
+		Status:    status,
+		CreatedBy: createdBy,
+	}
+	return &f.Status
+}
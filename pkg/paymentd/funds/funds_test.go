@@ -0,0 +1,40 @@
+package funds
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFunds(t *testing.T) {
+	Convey("Given incoming funds", t, func() {
+		f := &Funds{
+			Source:    "bank",
+			Reference: "Order 1234",
+			Amount:    1990,
+			Subunits:  2,
+			Currency:  "EUR",
+			CreatedBy: "import",
+		}
+		Convey("They should be valid", func() {
+			So(f.Valid(), ShouldBeTrue)
+		})
+		Convey("The amount should be a decimal", func() {
+			So(f.Decimal().String(), ShouldEqual, "19.90")
+		})
+		Convey("When the amount is not positive", func() {
+			f.Amount = 0
+			Convey("They should be invalid", func() {
+				So(f.Valid(), ShouldBeFalse)
+			})
+		})
+		Convey("When setting a new status", func() {
+			st := f.NewStatus(StatusReturn, "admin")
+			Convey("It should be the current status", func() {
+				So(f.Status.Status, ShouldEqual, StatusReturn)
+				So(st.CreatedBy, ShouldEqual, "admin")
+				So(st.Timestamp.IsZero(), ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,204 @@
+package funds
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+)
+
+const selectFunds = `
+SELECT
+	f.id,
+	f.created,
+	f.source,
+	f.reference,
+	f.sender,
+	f.amount,
+	f.subunits,
+	f.currency,
+	f.created_by,
+	s.timestamp,
+	s.status,
+	s.project_id,
+	s.payment_id,
+	s.created_by,
+	s.comment
+FROM incoming_funds AS f
+INNER JOIN incoming_funds_status AS s ON
+	s.incoming_funds_id = f.id
+	AND
+	s.timestamp = (
+		SELECT MAX(timestamp) FROM incoming_funds_status
+		WHERE
+			incoming_funds_id = s.incoming_funds_id
+	)
+`
+
+const selectFundsByID = selectFunds + `
+WHERE
+	f.id = ?
+`
+
+// FundsListing is the listing of incoming funds
+var FundsListing = listing.Builder{
+	Select:      selectFunds,
+	Key:         "ID",
+	DefaultSort: "ID",
+	Columns: map[string]string{
+		"ID":      "f.id",
+		"Created": "f.created",
+	},
+}
+
+func fundsCursor(sortField string, f *Funds) listing.Cursor {
+	c := listing.Cursor{Key: strconv.FormatInt(f.ID, 10)}
+	if sortField == "Created" {
+		c.Sort = strconv.FormatInt(f.Created.UnixNano(), 10)
+	}
+	return c
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFunds(row scanner) (*Funds, error) {
+	f := &Funds{}
+	var created, ts int64
+	err := row.Scan(
+		&f.ID,
+		&created,
+		&f.Source,
+		&f.Reference,
+		&f.Sender,
+		&f.Amount,
+		&f.Subunits,
+		&f.Currency,
+		&f.CreatedBy,
+		&ts,
+		&f.Status.Status,
+		&f.Status.ProjectID,
+		&f.Status.PaymentID,
+		&f.Status.CreatedBy,
+		&f.Status.Comment,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrFundsNotFound
+		}
+		return nil, err
+	}
+	f.Created = time.Unix(0, created)
+	f.Status.Timestamp = time.Unix(0, ts)
+	return f, nil
+}
+
+// FundsByIDTx selects the funds with the given ID
+//
+// The row will be locked for the transaction.
+func FundsByIDTx(db *sql.Tx, id int64) (*Funds, error) {
+	return scanFunds(db.QueryRow(selectFundsByID+" FOR UPDATE", id))
+}
+
+// FundsByIDDB selects the funds with the given ID
+func FundsByIDDB(db *sql.DB, id int64) (*Funds, error) {
+	return scanFunds(db.QueryRow(selectFundsByID, id))
+}
+
+// FundsByStatusDB selects a page of the funds with the given current status
+func FundsByStatusDB(db *sql.DB, status string, q *listing.Query) ([]*Funds, listing.Page, error) {
+	sortField, err := FundsListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	query, args, err := FundsListing.Build(q, "s.status = ?", status)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	defer rows.Close()
+	list := make([]*Funds, 0, q.Limit+1)
+	for rows.Next() {
+		f, err := scanFunds(rows)
+		if err != nil {
+			return nil, listing.Page{}, err
+		}
+		list = append(list, f)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(list), func(i int) listing.Cursor {
+		return fundsCursor(sortField, list[i])
+	})
+	return list[:n], page, nil
+}
+
+const insertFunds = `
+INSERT INTO incoming_funds
+(created, source, reference, sender, amount, subunits, currency, created_by)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertFundsTx saves new incoming funds with an unmatched status
+func InsertFundsTx(db *sql.Tx, f *Funds) error {
+	stmt, err := db.Prepare(insertFunds)
+	if err != nil {
+		return err
+	}
+	if f.Created.IsZero() {
+		f.Created = time.Now()
+	}
+	res, err := stmt.Exec(
+		f.Created.UnixNano(),
+		f.Source,
+		f.Reference,
+		f.Sender,
+		f.Amount,
+		f.Subunits,
+		f.Currency,
+		f.CreatedBy,
+	)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	f.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return InsertStatusTx(db, f, f.NewStatus(StatusUnmatched, f.CreatedBy))
+}
+
+const insertStatus = `
+INSERT INTO incoming_funds_status
+(incoming_funds_id, timestamp, status, project_id, payment_id, created_by, comment)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertStatusTx saves a new status of the given funds
+func InsertStatusTx(db *sql.Tx, f *Funds, s *Status) error {
+	stmt, err := db.Prepare(insertStatus)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		f.ID,
+		s.Timestamp.UnixNano(),
+		s.Status,
+		s.ProjectID,
+		s.PaymentID,
+		s.CreatedBy,
+		s.Comment,
+	)
+	stmt.Close()
+	return err
+}
@@ -0,0 +1,74 @@
+package job
+
+import (
+	"time"
+)
+
+// Run statuses
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	// StatusAborted marks runs which were still running when their instance
+	// stopped
+	StatusAborted = "aborted"
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+	TriggerRetry    = "retry"
+)
+
+// Run is a record of a job run
+//
+// Every attempt of a run will be recorded separately.
+type Run struct {
+	ID      int64
+	Job     string
+	Attempt int
+	Trigger string
+	// Host is the name of the host which executed the run
+	Host     string
+	Started  time.Time
+	Finished time.Time
+	Status   string
+	Error    string
+}
+
+// Finish sets the status of the run according to the given error
+func (r *Run) Finish(err error) {
+	r.Finished = time.Now()
+	if err != nil {
+		r.Status = StatusFailed
+		r.Error = err.Error()
+		return
+	}
+	r.Status = StatusSucceeded
+}
+
+// RetryPolicy determines whether and when failed runs should be retried
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a run. Runs will not be
+	// retried if it is less than 2
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It will be doubled for
+	// every further retry
+	Backoff time.Duration
+}
+
+// Retry returns the delay after which a run which failed in the given attempt
+// should be retried
+//
+// The second return value is false if the run should not be retried.
+func (p RetryPolicy) Retry(attempt int) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	delay := p.Backoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+	}
+	return delay, true
+}
@@ -0,0 +1,209 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSchedule = errors.New("invalid schedule")
+)
+
+// Schedule determines when a job should run
+type Schedule interface {
+	// Next returns the next time after t the job should run. It returns the
+	// zero time if the job should not run anymore
+	Next(t time.Time) time.Time
+	// String returns the schedule specification
+	String() string
+}
+
+// ParseSchedule parses a schedule specification
+//
+// Supported are cron expressions ("*/5 * * * *"), intervals ("@every 1h30m")
+// and the shorthands "@hourly", "@daily", "@weekly" and "@monthly".
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "@hourly":
+		return parseCron(spec, "0 * * * *")
+	case "@daily":
+		return parseCron(spec, "0 0 * * *")
+	case "@weekly":
+		return parseCron(spec, "0 0 * * 0")
+	case "@monthly":
+		return parseCron(spec, "0 0 1 * *")
+	}
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("%v: %s", ErrInvalidSchedule, spec)
+		}
+		return Interval(d), nil
+	}
+	return parseCron(spec, spec)
+}
+
+// Interval is a schedule with a fixed interval between runs
+type Interval time.Duration
+
+// Next implements the Schedule
+func (i Interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+func (i Interval) String() string {
+	return "@every " + time.Duration(i).String()
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	// minute
+	{0, 59},
+	// hour
+	{0, 23},
+	// day of month
+	{1, 31},
+	// month
+	{1, 12},
+	// day of week
+	{0, 6},
+}
+
+// cron is a schedule given by a cron expression
+//
+// The fields are stored as bit sets.
+type cron struct {
+	spec   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// whether day of month or day of week are restricted
+	domStar, dowStar bool
+}
+
+func parseCron(spec, expr string) (Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("%v: %s: expecting 5 fields", ErrInvalidSchedule, spec)
+	}
+	c := &cron{spec: spec}
+	var sets [5]uint64
+	for i, f := range fields {
+		var err error
+		// Sunday can be given as 7
+		max := cronFields[i].max
+		if i == 4 {
+			max = 7
+		}
+		sets[i], err = parseCronField(f, cronFields[i].min, max)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s: %v", ErrInvalidSchedule, spec, err)
+		}
+	}
+	c.minute, c.hour, c.dom, c.month, c.dow = sets[0], sets[1], sets[2], sets[3], sets[4]
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = fields[2] == "*"
+	c.dowStar = fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps
+func parseCronField(f string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			part = part[:i]
+		}
+		from, to := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			from, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+			to, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			from, to = v, v
+			if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	// like cron, if both day fields are restricted, either has to match
+	if !c.domStar && !c.dowStar {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// cronMaxYears limits the search for the next time, e.g. for February 30th
+const cronMaxYears = 5
+
+// Next implements the Schedule
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronMaxYears, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *cron) String() string {
+	return c.spec
+}
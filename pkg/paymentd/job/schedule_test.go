@@ -0,0 +1,134 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseSchedule(t *testing.T) {
+	Convey("Given a reference time", t, func() {
+		ref := time.Date(2015, time.March, 14, 10, 17, 30, 0, time.UTC)
+
+		Convey("When parsing an interval", func() {
+			s, err := ParseSchedule("@every 1h30m")
+			So(err, ShouldBeNil)
+
+			Convey("It should run after the interval", func() {
+				So(s.Next(ref), ShouldResemble, ref.Add(90*time.Minute))
+				So(s.String(), ShouldEqual, "@every 1h30m0s")
+			})
+		})
+
+		Convey("When parsing a too short interval", func() {
+			_, err := ParseSchedule("@every 10ms")
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When parsing @hourly", func() {
+			s, err := ParseSchedule("@hourly")
+			So(err, ShouldBeNil)
+
+			Convey("It should run at the next full hour", func() {
+				So(s.Next(ref), ShouldResemble, time.Date(2015, time.March, 14, 11, 0, 0, 0, time.UTC))
+				So(s.String(), ShouldEqual, "@hourly")
+			})
+		})
+
+		Convey("When parsing a cron expression with steps", func() {
+			s, err := ParseSchedule("*/15 * * * *")
+			So(err, ShouldBeNil)
+
+			Convey("It should run at the next step", func() {
+				So(s.Next(ref), ShouldResemble, time.Date(2015, time.March, 14, 10, 30, 0, 0, time.UTC))
+			})
+		})
+
+		Convey("When parsing a cron expression with ranges and lists", func() {
+			s, err := ParseSchedule("0 9-17 * * 1-5")
+			So(err, ShouldBeNil)
+
+			Convey("It should skip the weekend", func() {
+				// March 14th 2015 is a Saturday
+				So(s.Next(ref), ShouldResemble, time.Date(2015, time.March, 16, 9, 0, 0, 0, time.UTC))
+			})
+		})
+
+		Convey("When parsing a cron expression with Sunday given as 7", func() {
+			s, err := ParseSchedule("30 2 * * 7")
+			So(err, ShouldBeNil)
+
+			Convey("It should run on Sunday", func() {
+				So(s.Next(ref), ShouldResemble, time.Date(2015, time.March, 15, 2, 30, 0, 0, time.UTC))
+			})
+		})
+
+		Convey("When parsing a cron expression with both day fields restricted", func() {
+			s, err := ParseSchedule("0 0 20 * 1")
+			So(err, ShouldBeNil)
+
+			Convey("It should run when either day matches", func() {
+				So(s.Next(ref), ShouldResemble, time.Date(2015, time.March, 16, 0, 0, 0, 0, time.UTC))
+			})
+		})
+
+		Convey("When parsing a cron expression which never matches", func() {
+			s, err := ParseSchedule("0 0 30 2 *")
+			So(err, ShouldBeNil)
+
+			Convey("It should not run", func() {
+				So(s.Next(ref).IsZero(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When parsing invalid cron expressions", func() {
+			for _, spec := range []string{
+				"",
+				"* * * *",
+				"60 * * * *",
+				"* 5-1 * * *",
+				"*/0 * * * *",
+				"a * * * *",
+			} {
+				_, err := ParseSchedule(spec)
+
+				Convey("It should fail for "+spec, func() {
+					So(err, ShouldNotBeNil)
+				})
+			}
+		})
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	Convey("Given a retry policy", t, func() {
+		p := RetryPolicy{MaxAttempts: 3, Backoff: time.Minute}
+
+		Convey("The backoff should double on every attempt", func() {
+			d, ok := p.Retry(1)
+			So(ok, ShouldBeTrue)
+			So(d, ShouldEqual, time.Minute)
+			d, ok = p.Retry(2)
+			So(ok, ShouldBeTrue)
+			So(d, ShouldEqual, 2*time.Minute)
+		})
+
+		Convey("It should not retry after the max attempts", func() {
+			_, ok := p.Retry(3)
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given an empty retry policy", t, func() {
+		p := RetryPolicy{}
+
+		Convey("It should not retry", func() {
+			_, ok := p.Retry(1)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
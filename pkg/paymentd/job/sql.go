@@ -0,0 +1,209 @@
+package job
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+)
+
+var (
+	ErrRunNotFound = errors.New("job run not found")
+)
+
+const insertRun = `
+INSERT INTO job_run
+(job, attempt, trigger_type, host, started, status)
+VALUES
+(?, ?, ?, ?, ?, ?)
+`
+
+// InsertRunDB records a started run
+func InsertRunDB(db *sql.DB, r *Run) error {
+	stmt, err := db.Prepare(insertRun)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(
+		r.Job,
+		r.Attempt,
+		r.Trigger,
+		r.Host,
+		r.Started.UnixNano(),
+		r.Status,
+	)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	r.ID, err = res.LastInsertId()
+	return err
+}
+
+const updateRunFinished = `
+UPDATE job_run
+SET
+	finished = ?,
+	status = ?,
+	error = ?
+WHERE
+	id = ?
+`
+
+// FinishRunDB records the result of a finished run
+func FinishRunDB(db *sql.DB, r *Run) error {
+	stmt, err := db.Prepare(updateRunFinished)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		r.Finished.UnixNano(),
+		r.Status,
+		sql.NullString{String: r.Error, Valid: r.Error != ""},
+		r.ID,
+	)
+	stmt.Close()
+	return err
+}
+
+const updateRunsAborted = `
+UPDATE job_run
+SET
+	status = ?
+WHERE
+	host = ?
+	AND
+	status = ?
+`
+
+// AbortRunsDB marks the runs which are still running on the given host as
+// aborted
+//
+// It should be called on startup, since runs of a previous process on the same
+// host cannot be running anymore.
+func AbortRunsDB(db *sql.DB, host string) (int64, error) {
+	res, err := db.Exec(updateRunsAborted, StatusAborted, host, StatusRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+const selectRunFields = `
+SELECT
+	r.id,
+	r.job,
+	r.attempt,
+	r.trigger_type,
+	r.host,
+	r.started,
+	r.finished,
+	r.status,
+	r.error
+FROM job_run AS r
+`
+
+type resultScanner interface {
+	Scan(...interface{}) error
+}
+
+func scanRun(row resultScanner) (*Run, error) {
+	r := &Run{}
+	var started int64
+	var finished sql.NullInt64
+	var runErr sql.NullString
+	err := row.Scan(
+		&r.ID,
+		&r.Job,
+		&r.Attempt,
+		&r.Trigger,
+		&r.Host,
+		&started,
+		&finished,
+		&r.Status,
+		&runErr,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRunNotFound
+		}
+		return nil, err
+	}
+	r.Started = time.Unix(0, started)
+	if finished.Valid {
+		r.Finished = time.Unix(0, finished.Int64)
+	}
+	r.Error = runErr.String
+	return r, nil
+}
+
+const selectRunByID = selectRunFields + `
+WHERE
+	r.id = ?
+`
+
+// RunByIDDB selects the run with the given ID
+func RunByIDDB(db *sql.DB, id int64) (*Run, error) {
+	return scanRun(db.QueryRow(selectRunByID, id))
+}
+
+// RunListing is the listing of job runs
+var RunListing = listing.Builder{
+	Select:      selectRunFields,
+	Key:         "ID",
+	DefaultSort: "ID",
+	Columns: map[string]string{
+		"ID": "r.id",
+	},
+}
+
+// RunFilter filters runs
+type RunFilter struct {
+	// Job filters by job name if not empty
+	Job string
+	// Status filters by run status if not empty
+	Status string
+}
+
+// RunsDB selects a page of the runs matching the filter
+func RunsDB(db *sql.DB, f RunFilter, q *listing.Query) ([]*Run, listing.Page, error) {
+	conds := make([]string, 0, 2)
+	args := make([]interface{}, 0, 2)
+	if f.Job != "" {
+		conds = append(conds, "r.job = ?")
+		args = append(args, f.Job)
+	}
+	if f.Status != "" {
+		conds = append(conds, "r.status = ?")
+		args = append(args, f.Status)
+	}
+	query, args, err := RunListing.Build(q, strings.Join(conds, " AND "), args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	runs := make([]*Run, 0, q.Limit+1)
+	for rows.Next() {
+		r, err := scanRun(rows)
+		if err != nil {
+			rows.Close()
+			return nil, listing.Page{}, err
+		}
+		runs = append(runs, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(runs), func(i int) listing.Cursor {
+		return listing.Cursor{Key: strconv.FormatInt(runs[i].ID, 10)}
+	})
+	return runs[:n], page, nil
+}
@@ -0,0 +1,105 @@
+package listing
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Builder builds bounded, keyset paginated list statements
+type Builder struct {
+	// Select is the select statement without WHERE, ORDER BY and LIMIT clauses
+	Select string
+	// Where holds conditions (without the WHERE keyword) which apply to all
+	// queries. Can be empty
+	Where string
+	// Key is the (API) name of the field which uniquely identifies an item
+	Key string
+	// DefaultSort is the (API) name of the field used if no sort field was
+	// requested
+	DefaultSort string
+	// Columns maps the (API) names of the sortable fields to their SQL
+	// expressions. It must contain the key field
+	Columns map[string]string
+}
+
+// SortField returns the sort field for the given query
+func (b Builder) SortField(q *Query) (string, error) {
+	if q.Sort == "" {
+		return b.DefaultSort, nil
+	}
+	if _, ok := b.Columns[q.Sort]; !ok {
+		return "", ErrInvalidSort
+	}
+	return q.Sort, nil
+}
+
+// Build returns the statement and its arguments for the given query
+//
+// The where parameter holds additional conditions (without the WHERE keyword)
+// and can be empty. The statement will select one item more than the requested
+// limit to determine whether there is a next page. See Page.
+func (b Builder) Build(q *Query, where string, args ...interface{}) (string, []interface{}, error) {
+	sortField, err := b.SortField(q)
+	if err != nil {
+		return "", nil, err
+	}
+	sortCol := b.Columns[sortField]
+	keyCol := b.Columns[b.Key]
+	cmp, dir := ">", "ASC"
+	if q.Desc {
+		cmp, dir = "<", "DESC"
+	}
+	buf := bytes.NewBufferString(b.Select)
+	conds := make([]string, 0, 3)
+	if b.Where != "" {
+		conds = append(conds, "("+b.Where+")")
+	}
+	if where != "" {
+		conds = append(conds, "("+where+")")
+	}
+	if q.Cursor != nil {
+		if sortField == b.Key {
+			conds = append(conds, keyCol+" "+cmp+" ?")
+			args = append(args, q.Cursor.Key)
+		} else {
+			conds = append(conds, "("+sortCol+" "+cmp+" ? OR ("+sortCol+" = ? AND "+keyCol+" "+cmp+" ?))")
+			args = append(args, q.Cursor.Sort, q.Cursor.Sort, q.Cursor.Key)
+		}
+	}
+	for i, cond := range conds {
+		if i == 0 {
+			buf.WriteString("\nWHERE\n\t")
+		} else {
+			buf.WriteString("\n\tAND\n\t")
+		}
+		buf.WriteString(cond)
+	}
+	buf.WriteString("\nORDER BY " + sortCol + " " + dir)
+	if sortField != b.Key {
+		buf.WriteString(", " + keyCol + " " + dir)
+	}
+	buf.WriteString("\nLIMIT " + strconv.Itoa(q.Limit+1) + "\n")
+	return buf.String(), args, nil
+}
+
+// Page holds the pagination state of a listing result
+type Page struct {
+	// NextCursor is the cursor for the next page. It is empty if there are no
+	// more items
+	NextCursor string `json:",omitempty"`
+	HasMore    bool
+}
+
+// Paginate determines the page of a result with n selected items
+//
+// The cursor function must return the cursor of the item at the given index.
+// It returns the number of items which belong to the page.
+func Paginate(q *Query, n int, cursor func(i int) Cursor) (Page, int) {
+	if n <= q.Limit {
+		return Page{}, n
+	}
+	return Page{
+		NextCursor: cursor(q.Limit - 1).Encode(),
+		HasMore:    true,
+	}, q.Limit
+}
@@ -0,0 +1,175 @@
+package listing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultLimit is the number of items returned if no limit was requested
+	DefaultLimit = 50
+	// MaxLimit is the maximum number of items which can be requested
+	MaxLimit = 500
+)
+
+// query parameters
+const (
+	ParamLimit  = "limit"
+	ParamCursor = "cursor"
+	ParamSort   = "sort"
+	ParamFields = "fields"
+)
+
+var (
+	ErrInvalidLimit  = errors.New("invalid limit")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort field")
+	ErrInvalidField  = errors.New("invalid field")
+)
+
+// Cursor points to the last item of a page
+//
+// It holds the values of the sort field and the key field of the last item.
+type Cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+}
+
+// Encode returns the opaque string representation of the cursor
+func (c Cursor) Encode() string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		// cannot happen with string fields
+		panic("error encoding cursor: " + err.Error())
+	}
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor decodes a cursor which was encoded using Cursor.Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &Cursor{}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Query represents the listing parameters of a request
+type Query struct {
+	Limit  int
+	Cursor *Cursor
+	// Sort is the (API) name of the sort field. An empty value denotes the
+	// default sort field
+	Sort string
+	Desc bool
+	// Fields holds the selected fields. If empty, all fields will be returned
+	Fields []string
+}
+
+// NewQuery creates a new query with default values
+func NewQuery() *Query {
+	return &Query{
+		Limit: DefaultLimit,
+	}
+}
+
+// ParseQuery parses the listing parameters
+//
+// The sort parameter is a field name. A leading "-" denotes a descending order.
+// The fields parameter is a comma-separated list of field names.
+func ParseQuery(v url.Values) (*Query, error) {
+	q := NewQuery()
+	if l := v.Get(ParamLimit); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return nil, ErrInvalidLimit
+		}
+		q.Limit = limit
+	}
+	if c := v.Get(ParamCursor); c != "" {
+		var err error
+		q.Cursor, err = DecodeCursor(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s := v.Get(ParamSort); s != "" {
+		if strings.HasPrefix(s, "-") {
+			q.Desc = true
+			s = s[1:]
+		}
+		q.Sort = s
+	}
+	if f := v.Get(ParamFields); f != "" {
+		for _, field := range strings.Split(f, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			q.Fields = append(q.Fields, field)
+		}
+	}
+	return q, nil
+}
+
+// Values returns the query parameters representing the query
+func (q *Query) Values() url.Values {
+	v := url.Values{}
+	v.Set(ParamLimit, strconv.Itoa(q.Limit))
+	if q.Cursor != nil {
+		v.Set(ParamCursor, q.Cursor.Encode())
+	}
+	if q.Sort != "" {
+		if q.Desc {
+			v.Set(ParamSort, "-"+q.Sort)
+		} else {
+			v.Set(ParamSort, q.Sort)
+		}
+	}
+	if len(q.Fields) > 0 {
+		v.Set(ParamFields, strings.Join(q.Fields, ","))
+	}
+	return v
+}
+
+// SelectFields reduces the JSON representation of the given items to the
+// selected fields
+//
+// The items must marshal to a JSON array of objects. If no fields are selected,
+// the items will be returned unmodified.
+func SelectFields(items interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return items, nil
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var objs []map[string]json.RawMessage
+	err = json.Unmarshal(b, &objs)
+	if err != nil {
+		// items are not objects
+		return nil, ErrInvalidField
+	}
+	sel := make([]map[string]json.RawMessage, len(objs))
+	for i, obj := range objs {
+		sel[i] = make(map[string]json.RawMessage, len(fields))
+		for _, f := range fields {
+			v, ok := obj[f]
+			if !ok {
+				return nil, ErrInvalidField
+			}
+			sel[i][f] = v
+		}
+	}
+	return sel, nil
+}
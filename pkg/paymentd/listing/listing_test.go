@@ -0,0 +1,173 @@
+package listing
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseQuery(t *testing.T) {
+	Convey("Given empty listing parameters", t, func() {
+		q, err := ParseQuery(url.Values{})
+		So(err, ShouldBeNil)
+
+		Convey("The default limit should be used", func() {
+			So(q.Limit, ShouldEqual, DefaultLimit)
+			So(q.Cursor, ShouldBeNil)
+			So(q.Sort, ShouldBeEmpty)
+			So(q.Fields, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given listing parameters", t, func() {
+		c := Cursor{Sort: "1", Key: "a"}
+		v := url.Values{}
+		v.Set(ParamLimit, "10")
+		v.Set(ParamCursor, c.Encode())
+		v.Set(ParamSort, "-Timestamp")
+		v.Set(ParamFields, "Domain, HasTLS")
+
+		Convey("They should be parsed", func() {
+			q, err := ParseQuery(v)
+			So(err, ShouldBeNil)
+			So(q.Limit, ShouldEqual, 10)
+			So(*q.Cursor, ShouldResemble, c)
+			So(q.Sort, ShouldEqual, "Timestamp")
+			So(q.Desc, ShouldBeTrue)
+			So(q.Fields, ShouldResemble, []string{"Domain", "HasTLS"})
+
+			Convey("The query should be represented by the same values", func() {
+				So(q.Values().Encode(), ShouldEqual, (url.Values{
+					ParamLimit:  []string{"10"},
+					ParamCursor: []string{c.Encode()},
+					ParamSort:   []string{"-Timestamp"},
+					ParamFields: []string{"Domain,HasTLS"},
+				}).Encode())
+			})
+		})
+	})
+
+	Convey("Given invalid listing parameters", t, func() {
+		Convey("Parsing should fail", func() {
+			for _, l := range []string{"0", "-1", "x", "501"} {
+				_, err := ParseQuery(url.Values{ParamLimit: []string{l}})
+				So(err, ShouldEqual, ErrInvalidLimit)
+			}
+			_, err := ParseQuery(url.Values{ParamCursor: []string{"not a cursor"}})
+			So(err, ShouldEqual, ErrInvalidCursor)
+		})
+	})
+}
+
+func TestBuilder(t *testing.T) {
+	Convey("Given a listing builder", t, func() {
+		b := Builder{
+			Select:      "SELECT name, created FROM item",
+			Where:       "deleted = 0",
+			Key:         "Name",
+			DefaultSort: "Name",
+			Columns: map[string]string{
+				"Name":    "name",
+				"Created": "created",
+			},
+		}
+		q := NewQuery()
+		q.Limit = 10
+
+		Convey("When building a default query", func() {
+			query, args, err := b.Build(q, "")
+			So(err, ShouldBeNil)
+
+			Convey("It should be sorted by the default field and bounded", func() {
+				So(query, ShouldEqual, "SELECT name, created FROM item\nWHERE\n\t(deleted = 0)\nORDER BY name ASC\nLIMIT 11\n")
+				So(args, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When building a query with a cursor on a non-key field", func() {
+			q.Sort, q.Desc = "Created", true
+			q.Cursor = &Cursor{Sort: "100", Key: "b"}
+			query, args, err := b.Build(q, "owner = ?", 1)
+			So(err, ShouldBeNil)
+
+			Convey("It should continue after the cursor", func() {
+				So(query, ShouldEqual, "SELECT name, created FROM item\nWHERE\n\t(deleted = 0)\n\tAND\n\t(owner = ?)\n\tAND\n\t(created < ? OR (created = ? AND name < ?))\nORDER BY created DESC, name DESC\nLIMIT 11\n")
+				So(args, ShouldResemble, []interface{}{1, "100", "100", "b"})
+			})
+		})
+
+		Convey("When sorting by a field which is not sortable", func() {
+			q.Sort = "Owner"
+			_, _, err := b.Build(q, "")
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrInvalidSort)
+			})
+		})
+	})
+}
+
+func TestPaginate(t *testing.T) {
+	Convey("Given a query", t, func() {
+		q := NewQuery()
+		q.Limit = 2
+		keys := []string{"a", "b", "c"}
+		cursor := func(i int) Cursor {
+			return Cursor{Key: keys[i]}
+		}
+
+		Convey("When more items than the limit were selected", func() {
+			page, n := Paginate(q, 3, cursor)
+
+			Convey("There should be a next page", func() {
+				So(n, ShouldEqual, 2)
+				So(page.HasMore, ShouldBeTrue)
+				c, err := DecodeCursor(page.NextCursor)
+				So(err, ShouldBeNil)
+				So(c.Key, ShouldEqual, "b")
+			})
+		})
+
+		Convey("When no more items than the limit were selected", func() {
+			page, n := Paginate(q, 2, cursor)
+
+			Convey("There should be no next page", func() {
+				So(n, ShouldEqual, 2)
+				So(page.HasMore, ShouldBeFalse)
+				So(page.NextCursor, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestSelectFields(t *testing.T) {
+	type item struct {
+		Name    string
+		Created int
+	}
+	Convey("Given a list of items", t, func() {
+		items := []item{{"a", 1}, {"b", 2}}
+
+		Convey("When selecting fields", func() {
+			sel, err := SelectFields(items, []string{"Name"})
+			So(err, ShouldBeNil)
+
+			Convey("Only the selected fields should be present", func() {
+				objs := sel.([]map[string]json.RawMessage)
+				So(len(objs), ShouldEqual, 2)
+				So(len(objs[0]), ShouldEqual, 1)
+				So(string(objs[0]["Name"]), ShouldEqual, `"a"`)
+			})
+		})
+
+		Convey("When selecting an unknown field", func() {
+			_, err := SelectFields(items, []string{"Owner"})
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrInvalidField)
+			})
+		})
+	})
+}
@@ -0,0 +1,27 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package method_preference provides the order of the payment methods on the
+payment method selection page
+
+Customers choose more readily from the payment methods which are popular in
+their country. Projects configure the order of their payment methods and the
+preselected payment method per payment country. Projects without a
+configuration use built-in defaults, which order the payment methods by their
+provider.
+*/
+package method_preference
@@ -0,0 +1,143 @@
+package method_preference
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxPreferenceEntries is the maximum number of payment methods in the order
+// of a preference
+const maxPreferenceEntries = 50
+
+var (
+	ErrInvalidPreference = errors.New("invalid payment method preference")
+)
+
+// Preference is the order of the payment methods for payments in a set of
+// countries
+//
+// The entries of the order and the default are either provider names, which
+// match all payment methods of the provider, or a provider name and a method
+// key separated by a slash, e.g. "paypal_rest/paypal".
+type Preference struct {
+	// Countries are the ISO 3166-1 alpha-2 codes of the payment countries for
+	// which the preference applies. An empty list applies to all countries
+	// not listed in another preference.
+	Countries []string `json:",omitempty"`
+	// Order lists the payment methods, the most preferred first. Payment
+	// methods not listed follow in their original order.
+	Order []string `json:",omitempty"`
+	// Default is the payment method which will be preselected
+	Default string `json:",omitempty"`
+}
+
+// Preferences are the payment method preferences of a project
+type Preferences []Preference
+
+// DefaultPreferences are the preferences of projects which do not configure
+// payment method preferences
+var DefaultPreferences = Preferences{
+	{
+		Countries: []string{"AT", "BE", "CH", "DE", "ES", "FR", "GB", "IT", "NL", "PL"},
+		Order:     []string{"paypal_rest", "stripe", "fritzpay"},
+	},
+	{
+		Order: []string{"stripe", "paypal_rest", "fritzpay"},
+	},
+}
+
+// Check returns an error if a preference is invalid
+func (ps Preferences) Check() error {
+	var fallback bool
+	countries := make(map[string]bool)
+	for _, p := range ps {
+		if len(p.Countries) == 0 {
+			if fallback {
+				return fmt.Errorf("%w: more than one preference for all countries", ErrInvalidPreference)
+			}
+			fallback = true
+		}
+		for _, c := range p.Countries {
+			if len(c) != 2 || strings.ToUpper(c) != c {
+				return fmt.Errorf("%w: invalid country %s", ErrInvalidPreference, c)
+			}
+			if countries[c] {
+				return fmt.Errorf("%w: more than one preference for country %s", ErrInvalidPreference, c)
+			}
+			countries[c] = true
+		}
+		if len(p.Order) > maxPreferenceEntries {
+			return fmt.Errorf("%w: more than %d payment methods", ErrInvalidPreference, maxPreferenceEntries)
+		}
+		for _, e := range p.Order {
+			if !validPreferenceEntry(e) {
+				return fmt.Errorf("%w: invalid payment method %s", ErrInvalidPreference, e)
+			}
+		}
+		if p.Default != "" && !validPreferenceEntry(p.Default) {
+			return fmt.Errorf("%w: invalid default payment method %s", ErrInvalidPreference, p.Default)
+		}
+	}
+	return nil
+}
+
+func validPreferenceEntry(e string) bool {
+	parts := strings.Split(e, "/")
+	if len(parts) > 2 {
+		return false
+	}
+	for _, p := range parts {
+		if p == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// For returns the preference for payments in the country
+//
+// If no preference lists the country, the preference for all countries is
+// returned. If there is none, the returned preference is empty.
+func (ps Preferences) For(country string) Preference {
+	var fallback Preference
+	for _, p := range ps {
+		if len(p.Countries) == 0 {
+			fallback = p
+			continue
+		}
+		for _, c := range p.Countries {
+			if c == country {
+				return p
+			}
+		}
+	}
+	return fallback
+}
+
+// Rank returns the position of the payment method with the given provider and
+// method key in the order or the length of the order if the payment method is
+// not listed
+//
+// Entries with a method key take precedence over the provider entries.
+func (p Preference) Rank(providerName, methodKey string) int {
+	rank := len(p.Order)
+	for i, e := range p.Order {
+		if e == providerName+"/"+methodKey {
+			return i
+		}
+		if e == providerName && i < rank {
+			rank = i
+		}
+	}
+	return rank
+}
+
+// Preselects returns true if the payment method with the given provider and
+// method key is the default of the preference
+func (p Preference) Preselects(providerName, methodKey string) bool {
+	if p.Default == "" {
+		return false
+	}
+	return p.Default == providerName || p.Default == providerName+"/"+methodKey
+}
@@ -0,0 +1,92 @@
+package method_preference
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPreferences(t *testing.T) {
+	Convey("Given payment method preferences", t, func() {
+		prefs := Preferences{
+			{
+				Countries: []string{"DE", "AT"},
+				Order:     []string{"paypal_rest", "stripe/sofort", "stripe"},
+				Default:   "paypal_rest",
+			},
+			{
+				Order: []string{"stripe/card"},
+			},
+		}
+
+		Convey("They should be valid", func() {
+			So(prefs.Check(), ShouldBeNil)
+		})
+
+		Convey("When a country is listed twice", func() {
+			prefs = append(prefs, Preference{Countries: []string{"DE"}})
+			Convey("They should be invalid", func() {
+				So(prefs.Check(), ShouldNotBeNil)
+			})
+		})
+		Convey("When there is a second preference for all countries", func() {
+			prefs = append(prefs, Preference{Order: []string{"fritzpay"}})
+			Convey("They should be invalid", func() {
+				So(prefs.Check(), ShouldNotBeNil)
+			})
+		})
+		Convey("When a country is invalid", func() {
+			prefs[0].Countries = []string{"de"}
+			Convey("They should be invalid", func() {
+				So(prefs.Check(), ShouldNotBeNil)
+			})
+		})
+		Convey("When a payment method is invalid", func() {
+			prefs[1].Order = []string{"stripe/card/visa"}
+			Convey("They should be invalid", func() {
+				So(prefs.Check(), ShouldNotBeNil)
+			})
+		})
+
+		Convey("Given the preference of a listed country", func() {
+			pref := prefs.For("AT")
+
+			Convey("The payment methods should be ranked by the order", func() {
+				So(pref.Rank("paypal_rest", "paypal"), ShouldEqual, 0)
+				So(pref.Rank("stripe", "sofort"), ShouldEqual, 1)
+				So(pref.Rank("stripe", "card"), ShouldEqual, 2)
+				So(pref.Rank("fritzpay", "demo"), ShouldEqual, 3)
+			})
+			Convey("The default should be preselected", func() {
+				So(pref.Preselects("paypal_rest", "paypal"), ShouldBeTrue)
+				So(pref.Preselects("stripe", "card"), ShouldBeFalse)
+			})
+		})
+
+		Convey("Given the preference of another country", func() {
+			pref := prefs.For("US")
+
+			Convey("It should be the preference for all countries", func() {
+				So(pref.Rank("stripe", "card"), ShouldEqual, 0)
+				So(pref.Rank("paypal_rest", "paypal"), ShouldEqual, 1)
+			})
+			Convey("No payment method should be preselected", func() {
+				So(pref.Preselects("stripe", "card"), ShouldBeFalse)
+			})
+		})
+
+		Convey("Given preferences without a preference for all countries", func() {
+			prefs = prefs[:1]
+
+			Convey("The preference of another country should be empty", func() {
+				pref := prefs.For("US")
+				So(pref.Order, ShouldBeEmpty)
+				So(pref.Rank("stripe", "card"), ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("The default preferences should be valid", t, func() {
+		So(DefaultPreferences.Check(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,144 @@
+package onboarding
+
+import (
+	"database/sql"
+	"errors"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Statuses of applications
+const (
+	// StatusSubmitted applications are waiting for a review
+	StatusSubmitted = "submitted"
+	// StatusApproved applications were provisioned
+	StatusApproved = "approved"
+	// StatusRejected applications were declined by an operator
+	StatusRejected = "rejected"
+)
+
+const (
+	// limited by the name columns of the principal and project tables
+	nameMaxLength   = 64
+	textMaxLength   = 128
+	emailMaxLength  = 254
+	webURLMaxLength = 2048
+)
+
+// SubmittedBy is the creator of submitted applications
+const SubmittedBy = "onboarding"
+
+var (
+	// ErrApplicationNotFound will be returned by select functions when the
+	// requested application was not found
+	ErrApplicationNotFound = errors.New("application not found")
+
+	ErrInvalidPrincipalName = errors.New("invalid principal name")
+	ErrInvalidProjectName   = errors.New("invalid project name")
+	ErrInvalidCompany       = errors.New("invalid company")
+	ErrInvalidContactName   = errors.New("invalid contact name")
+	ErrInvalidEmail         = errors.New("invalid email address")
+	ErrInvalidWebURL        = errors.New("invalid web URL")
+)
+
+var nameRegexp = regexp.MustCompile("^[-A-Za-z0-9_]+$")
+
+// Application represents the application of a prospective merchant for a
+// principal and a project
+type Application struct {
+	ID            int64
+	Created       time.Time
+	PrincipalName string
+	ProjectName   string
+	Company       string
+	ContactName   string
+	Email         string
+	// WebURL is the website of the merchant
+	WebURL sql.NullString
+
+	Status Status
+}
+
+// Status represents a status change of an application
+type Status struct {
+	Timestamp time.Time
+	Status    string
+	// PrincipalID and ProjectID of the principal and project provisioned for an
+	// approved application
+	PrincipalID sql.NullInt64
+	ProjectID   sql.NullInt64
+	CreatedBy   string
+	// Comment of the reviewing operator, which will be sent to the applicant
+	Comment sql.NullString
+}
+
+// Validate returns an error if the application cannot be submitted
+//
+// It normalizes the email address.
+func (a *Application) Validate() error {
+	if !validName(a.PrincipalName) {
+		return ErrInvalidPrincipalName
+	}
+	if !validName(a.ProjectName) {
+		return ErrInvalidProjectName
+	}
+	if !validText(a.Company) {
+		return ErrInvalidCompany
+	}
+	if !validText(a.ContactName) {
+		return ErrInvalidContactName
+	}
+	if len(a.Email) > emailMaxLength {
+		return ErrInvalidEmail
+	}
+	addr, err := mail.ParseAddress(a.Email)
+	if err != nil || addr.Name != "" {
+		return ErrInvalidEmail
+	}
+	a.Email = addr.Address
+	if a.WebURL.Valid {
+		if len(a.WebURL.String) > webURLMaxLength {
+			return ErrInvalidWebURL
+		}
+		u, err := url.Parse(a.WebURL.String)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidWebURL
+		}
+	}
+	return nil
+}
+
+func validName(name string) bool {
+	return len(name) <= nameMaxLength && nameRegexp.MatchString(name)
+}
+
+func validText(text string) bool {
+	if strings.TrimSpace(text) == "" || len(text) > textMaxLength {
+		return false
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Submitted returns true if the application is waiting for a review
+func (a *Application) Submitted() bool {
+	return a.Status.Status == StatusSubmitted
+}
+
+// NewStatus creates a new status change for the application
+func (a *Application) NewStatus(status, createdBy string) *Status {
+	a.Status = Status{
+		Timestamp: time.Now(),
+		Status:    status,
+		CreatedBy: createdBy,
+	}
+	return &a.Status
+}
@@ -0,0 +1,82 @@
+package onboarding
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApplication(t *testing.T) {
+	Convey("Given an application", t, func() {
+		a := &Application{
+			PrincipalName: "acme",
+			ProjectName:   "shop",
+			Company:       "ACME Corp.",
+			ContactName:   "Jane Doe",
+			Email:         "jane@example.com",
+		}
+		a.WebURL.String, a.WebURL.Valid = "https://shop.example.com", true
+
+		Convey("It should be valid", func() {
+			So(a.Validate(), ShouldBeNil)
+		})
+		Convey("When the principal name contains invalid characters", func() {
+			a.PrincipalName = "acme corp"
+			Convey("It should be invalid", func() {
+				So(a.Validate(), ShouldEqual, ErrInvalidPrincipalName)
+			})
+		})
+		Convey("When the project name is too long", func() {
+			a.ProjectName = strings.Repeat("a", nameMaxLength+1)
+			Convey("It should be invalid", func() {
+				So(a.Validate(), ShouldEqual, ErrInvalidProjectName)
+			})
+		})
+		Convey("When the company is empty", func() {
+			a.Company = " "
+			Convey("It should be invalid", func() {
+				So(a.Validate(), ShouldEqual, ErrInvalidCompany)
+			})
+		})
+		Convey("When the contact name contains a line break", func() {
+			a.ContactName = "Jane\nBcc: spam@example.com"
+			Convey("It should be invalid", func() {
+				So(a.Validate(), ShouldEqual, ErrInvalidContactName)
+			})
+		})
+		Convey("When the email address is invalid", func() {
+			a.Email = "jane"
+			Convey("It should be invalid", func() {
+				So(a.Validate(), ShouldEqual, ErrInvalidEmail)
+			})
+		})
+		Convey("When the email address has a display name", func() {
+			a.Email = "Jane <jane@example.com>"
+			Convey("It should be invalid", func() {
+				So(a.Validate(), ShouldEqual, ErrInvalidEmail)
+			})
+		})
+		Convey("When the web URL is not an HTTP URL", func() {
+			a.WebURL.String = "ftp://shop.example.com"
+			Convey("It should be invalid", func() {
+				So(a.Validate(), ShouldEqual, ErrInvalidWebURL)
+			})
+		})
+		Convey("When the web URL is not set", func() {
+			a.WebURL.Valid = false
+			Convey("It should be valid", func() {
+				So(a.Validate(), ShouldBeNil)
+			})
+		})
+		Convey("When setting a new status", func() {
+			st := a.NewStatus(StatusApproved, "admin")
+			Convey("It should be the current status", func() {
+				So(a.Status.Status, ShouldEqual, StatusApproved)
+				So(a.Submitted(), ShouldBeFalse)
+				So(st.CreatedBy, ShouldEqual, "admin")
+				So(st.Timestamp.IsZero(), ShouldBeFalse)
+			})
+		})
+	})
+}
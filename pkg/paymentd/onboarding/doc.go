@@ -0,0 +1,27 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package onboarding provides applications of prospective merchants
+
+Prospective merchants apply for a principal and a project. Applications are
+reviewed by the operators. Approving an application provisions the principal,
+the project with the default project configuration and a project key.
+
+Applications are append-only: Every review adds a new status, the current status
+of an application is the most recent one.
+*/
+package onboarding
@@ -0,0 +1,227 @@
+package onboarding
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"golang.org/x/net/context"
+)
+
+const selectApplication = `
+SELECT
+	a.id,
+	a.created,
+	a.principal_name,
+	a.project_name,
+	a.company,
+	a.contact_name,
+	a.email,
+	a.web_url,
+	s.timestamp,
+	s.status,
+	s.principal_id,
+	s.project_id,
+	s.created_by,
+	s.comment
+FROM onboarding_application AS a
+INNER JOIN onboarding_application_status AS s ON
+	s.onboarding_application_id = a.id
+	AND
+	s.timestamp = (
+		SELECT MAX(timestamp) FROM onboarding_application_status
+		WHERE
+			onboarding_application_id = s.onboarding_application_id
+	)
+`
+
+const selectApplicationByID = selectApplication + `
+WHERE
+	a.id = ?
+`
+
+// ApplicationListing is the listing of onboarding applications
+var ApplicationListing = listing.Builder{
+	Select:      selectApplication,
+	Key:         "ID",
+	DefaultSort: "ID",
+	Columns: map[string]string{
+		"ID":      "a.id",
+		"Created": "a.created",
+	},
+}
+
+func applicationCursor(sortField string, a *Application) listing.Cursor {
+	c := listing.Cursor{Key: strconv.FormatInt(a.ID, 10)}
+	if sortField == "Created" {
+		c.Sort = strconv.FormatInt(a.Created.UnixNano(), 10)
+	}
+	return c
+}
+
+const selectSubmittedCountByPrincipalName = `
+SELECT COUNT(*) FROM onboarding_application AS a
+INNER JOIN onboarding_application_status AS s ON
+	s.onboarding_application_id = a.id
+	AND
+	s.timestamp = (
+		SELECT MAX(timestamp) FROM onboarding_application_status
+		WHERE
+			onboarding_application_id = s.onboarding_application_id
+	)
+WHERE
+	a.principal_name = ?
+	AND
+	s.status = '` + StatusSubmitted + `'
+`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanApplication(row scanner) (*Application, error) {
+	a := &Application{}
+	var created, ts int64
+	err := row.Scan(
+		&a.ID,
+		&created,
+		&a.PrincipalName,
+		&a.ProjectName,
+		&a.Company,
+		&a.ContactName,
+		&a.Email,
+		&a.WebURL,
+		&ts,
+		&a.Status.Status,
+		&a.Status.PrincipalID,
+		&a.Status.ProjectID,
+		&a.Status.CreatedBy,
+		&a.Status.Comment,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrApplicationNotFound
+		}
+		return nil, err
+	}
+	a.Created = time.Unix(0, created)
+	a.Status.Timestamp = time.Unix(0, ts)
+	return a, nil
+}
+
+// ApplicationByIDTx selects the application with the given ID
+//
+// The row will be locked for the transaction.
+func ApplicationByIDTx(ctx context.Context, db *sql.Tx, id int64) (*Application, error) {
+	return scanApplication(db.QueryRowContext(ctx, selectApplicationByID+" FOR UPDATE", id))
+}
+
+// ApplicationByIDDB selects the application with the given ID
+func ApplicationByIDDB(ctx context.Context, db *sql.DB, id int64) (*Application, error) {
+	return scanApplication(db.QueryRowContext(ctx, selectApplicationByID, id))
+}
+
+// ApplicationsByStatusDB selects a page of the applications with the given
+// current status
+func ApplicationsByStatusDB(ctx context.Context, db *sql.DB, status string, q *listing.Query) ([]*Application, listing.Page, error) {
+	sortField, err := ApplicationListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	query, args, err := ApplicationListing.Build(q, "s.status = ?", status)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	defer rows.Close()
+	list := make([]*Application, 0, q.Limit+1)
+	for rows.Next() {
+		a, err := scanApplication(rows)
+		if err != nil {
+			return nil, listing.Page{}, err
+		}
+		list = append(list, a)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(list), func(i int) listing.Cursor {
+		return applicationCursor(sortField, list[i])
+	})
+	return list[:n], page, nil
+}
+
+// SubmittedByPrincipalNameTx returns true if an application for the given
+// principal name is waiting for a review
+func SubmittedByPrincipalNameTx(ctx context.Context, db *sql.Tx, principalName string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, selectSubmittedCountByPrincipalName, principalName).Scan(&n)
+	return n > 0, err
+}
+
+const insertApplication = `
+INSERT INTO onboarding_application
+(created, principal_name, project_name, company, contact_name, email, web_url)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertApplicationTx saves a new application with a submitted status
+func InsertApplicationTx(ctx context.Context, db *sql.Tx, a *Application) error {
+	stmt, err := db.PrepareContext(ctx, insertApplication)
+	if err != nil {
+		return err
+	}
+	if a.Created.IsZero() {
+		a.Created = time.Now()
+	}
+	res, err := stmt.ExecContext(ctx,
+		a.Created.UnixNano(),
+		a.PrincipalName,
+		a.ProjectName,
+		a.Company,
+		a.ContactName,
+		a.Email,
+		a.WebURL,
+	)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	a.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return InsertStatusTx(ctx, db, a, a.NewStatus(StatusSubmitted, SubmittedBy))
+}
+
+const insertStatus = `
+INSERT INTO onboarding_application_status
+(onboarding_application_id, timestamp, status, principal_id, project_id, created_by, comment)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertStatusTx saves a new status of the given application
+func InsertStatusTx(ctx context.Context, db *sql.Tx, a *Application, s *Status) error {
+	stmt, err := db.PrepareContext(ctx, insertStatus)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		a.ID,
+		s.Timestamp.UnixNano(),
+		s.Status,
+		s.PrincipalID,
+		s.ProjectID,
+		s.CreatedBy,
+		s.Comment,
+	)
+	stmt.Close()
+	return err
+}
@@ -0,0 +1,96 @@
+package outbox
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Statuses of notifications
+const (
+	// StatusPending notifications are waiting to be delivered
+	StatusPending = "pending"
+	// StatusDelivered notifications were delivered
+	StatusDelivered = "delivered"
+	// StatusSuperseded notifications were merged into a later notification
+	// of the same payment and will not be delivered
+	StatusSuperseded = "superseded"
+	// StatusFailed notifications could not be delivered within the maximum
+	// number of attempts
+	StatusFailed = "failed"
+)
+
+// ErrorMaxLen is the maximum length of the delivery errors
+const ErrorMaxLen = 255
+
+var (
+	ErrNotificationNotFound = errors.New("notification not found")
+)
+
+// Notification is a callback notification in the outbox
+type Notification struct {
+	ID        int64
+	Created   time.Time
+	ProjectID int64
+	// Event is the event type of the notification
+	Event string
+	// PaymentID and TransactionTimestamp identify the payment transaction of
+	// payment transaction notifications
+	PaymentID            sql.NullInt64
+	TransactionTimestamp sql.NullInt64
+	// Data is the data of event notifications
+	Data map[string]string
+
+	Status Status
+}
+
+// Status represents a status change on a notification
+type Status struct {
+	Timestamp time.Time
+	Status    string
+	// Attempts is the number of delivery attempts
+	Attempts int
+	// NextAttempt is the time at which a pending notification will be
+	// retried
+	NextAttempt time.Time
+	CreatedBy   string
+	// Error is the error of the last delivery attempt
+	Error sql.NullString
+}
+
+// Pending returns true if the notification is waiting to be delivered
+func (n *Notification) Pending() bool {
+	return n.Status.Status == StatusPending
+}
+
+// Due returns true if the pending notification should be retried at the given
+// time
+func (n *Notification) Due(t time.Time) bool {
+	return n.Pending() && !n.Status.NextAttempt.After(t)
+}
+
+// NewStatus creates a new status change for the notification
+//
+// The number of attempts of the current status is kept.
+func (n *Notification) NewStatus(status, createdBy string) *Status {
+	n.Status = Status{
+		Timestamp: time.Now(),
+		Status:    status,
+		Attempts:  n.Status.Attempts,
+		CreatedBy: createdBy,
+	}
+	return &n.Status
+}
+
+// SetError sets the error of the last delivery attempt
+func (s *Status) SetError(err error) {
+	if err == nil {
+		s.Error.String, s.Error.Valid = "", false
+		return
+	}
+	msg := err.Error()
+	if len(msg) > ErrorMaxLen {
+		msg = msg[:ErrorMaxLen]
+	}
+	s.Error.String, s.Error.Valid = msg, true
+}
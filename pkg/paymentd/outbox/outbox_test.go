@@ -0,0 +1,48 @@
+package outbox
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNotification(t *testing.T) {
+	Convey("Given a pending notification", t, func() {
+		n := &Notification{ProjectID: 1, Event: "payment.transaction"}
+		st := n.NewStatus(StatusPending, "notification.dispatcher")
+		st.Attempts = 2
+		st.NextAttempt = st.Timestamp.Add(time.Minute)
+
+		Convey("It should be pending", func() {
+			So(n.Pending(), ShouldBeTrue)
+		})
+		Convey("It should be due after its next attempt", func() {
+			So(n.Due(time.Now()), ShouldBeFalse)
+			So(n.Due(time.Now().Add(2*time.Minute)), ShouldBeTrue)
+		})
+		Convey("When the status changes", func() {
+			n.NewStatus(StatusFailed, "notification.dispatcher")
+
+			Convey("It should keep the attempts", func() {
+				So(n.Pending(), ShouldBeFalse)
+				So(n.Due(time.Now().Add(2*time.Minute)), ShouldBeFalse)
+				So(n.Status.Attempts, ShouldEqual, 2)
+			})
+		})
+		Convey("When a delivery error is set", func() {
+			st.SetError(errors.New(strings.Repeat("x", 300)))
+
+			Convey("It should be truncated", func() {
+				So(st.Error.Valid, ShouldBeTrue)
+				So(len(st.Error.String), ShouldEqual, ErrorMaxLen)
+			})
+			Convey("It should be removable", func() {
+				st.SetError(nil)
+				So(st.Error.Valid, ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,314 @@
+package outbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+)
+
+const selectNotification = `
+SELECT
+	n.id,
+	n.created,
+	n.project_id,
+	n.event,
+	n.payment_id,
+	n.transaction_timestamp,
+	n.data,
+	s.timestamp,
+	s.status,
+	s.attempts,
+	s.next_attempt,
+	s.created_by,
+	s.error
+FROM notification_outbox AS n
+INNER JOIN notification_outbox_status AS s ON
+	s.notification_id = n.id
+	AND
+	s.timestamp = (
+		SELECT MAX(timestamp) FROM notification_outbox_status
+		WHERE
+			notification_id = s.notification_id
+	)
+`
+
+const selectNotificationByID = selectNotification + `
+WHERE
+	n.id = ?
+`
+
+// NotificationListing is the listing of notifications
+var NotificationListing = listing.Builder{
+	Select:      selectNotification,
+	Key:         "ID",
+	DefaultSort: "ID",
+	Columns: map[string]string{
+		"ID":      "n.id",
+		"Created": "n.created",
+	},
+}
+
+const whereNotificationsByProjectIDAndStatus = `
+n.project_id = ?
+AND
+s.status = ?
+`
+
+func notificationCursor(sortField string, n *Notification) listing.Cursor {
+	c := listing.Cursor{Key: strconv.FormatInt(n.ID, 10)}
+	if sortField == "Created" {
+		c.Sort = strconv.FormatInt(n.Created.UnixNano(), 10)
+	}
+	return c
+}
+
+const selectNotificationByTransaction = selectNotification + `
+WHERE
+	n.project_id = ?
+	AND
+	n.payment_id = ?
+	AND
+	n.transaction_timestamp = ?
+ORDER BY n.id DESC
+LIMIT 1
+`
+
+const selectDueNotifications = selectNotification + `
+WHERE
+	s.status = ?
+	AND
+	s.next_attempt <= ?
+ORDER BY s.next_attempt
+LIMIT ?
+`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanNotification(row scanner) (*Notification, error) {
+	n := &Notification{}
+	var created, ts, nextAttempt int64
+	var data []byte
+	err := row.Scan(
+		&n.ID,
+		&created,
+		&n.ProjectID,
+		&n.Event,
+		&n.PaymentID,
+		&n.TransactionTimestamp,
+		&data,
+		&ts,
+		&n.Status.Status,
+		&n.Status.Attempts,
+		&nextAttempt,
+		&n.Status.CreatedBy,
+		&n.Status.Error,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotificationNotFound
+		}
+		return nil, err
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &n.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	n.Created = time.Unix(0, created)
+	n.Status.Timestamp = time.Unix(0, ts)
+	n.Status.NextAttempt = time.Unix(0, nextAttempt)
+	return n, nil
+}
+
+func scanNotifications(rows *sql.Rows) ([]*Notification, error) {
+	defer rows.Close()
+	list := make([]*Notification, 0)
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+// NotificationByIDTx selects the notification with the given ID
+//
+// The row will be locked for the transaction.
+func NotificationByIDTx(db *sql.Tx, id int64) (*Notification, error) {
+	return scanNotification(db.QueryRow(selectNotificationByID+" FOR UPDATE", id))
+}
+
+// NotificationByIDDB selects the notification with the given ID
+func NotificationByIDDB(db *sql.DB, id int64) (*Notification, error) {
+	return scanNotification(db.QueryRow(selectNotificationByID, id))
+}
+
+// NotificationsByProjectIDAndStatusDB selects a page of the notifications of
+// the project with the given current status
+func NotificationsByProjectIDAndStatusDB(db *sql.DB, projectID int64, status string, q *listing.Query) ([]*Notification, listing.Page, error) {
+	sortField, err := NotificationListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	query, args, err := NotificationListing.Build(q, whereNotificationsByProjectIDAndStatus, projectID, status)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	list, err := scanNotifications(rows)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(list), func(i int) listing.Cursor {
+		return notificationCursor(sortField, list[i])
+	})
+	return list[:n], page, nil
+}
+
+// NotificationByTransactionDB selects the latest notification of the payment
+// transaction with the given timestamp
+func NotificationByTransactionDB(db *sql.DB, projectID, paymentID int64, ts time.Time) (*Notification, error) {
+	return scanNotification(db.QueryRow(selectNotificationByTransaction, projectID, paymentID, ts.UnixNano()))
+}
+
+// DueNotificationsDB selects the pending notifications which should be
+// retried at the given time, the longest overdue first
+func DueNotificationsDB(db *sql.DB, t time.Time, limit int) ([]*Notification, error) {
+	rows, err := db.Query(selectDueNotifications, StatusPending, t.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanNotifications(rows)
+}
+
+const insertNotification = `
+INSERT INTO notification_outbox
+(created, project_id, event, payment_id, transaction_timestamp, data)
+VALUES
+(?, ?, ?, ?, ?, ?)
+`
+
+// InsertNotificationTx saves a new notification with its status
+func InsertNotificationTx(db *sql.Tx, n *Notification) error {
+	var data []byte
+	if n.Data != nil {
+		var err error
+		data, err = json.Marshal(n.Data)
+		if err != nil {
+			return err
+		}
+	}
+	stmt, err := db.Prepare(insertNotification)
+	if err != nil {
+		return err
+	}
+	if n.Created.IsZero() {
+		n.Created = time.Now()
+	}
+	res, err := stmt.Exec(
+		n.Created.UnixNano(),
+		n.ProjectID,
+		n.Event,
+		n.PaymentID,
+		n.TransactionTimestamp,
+		data,
+	)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	n.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return InsertStatusTx(db, n, &n.Status)
+}
+
+const insertStatus = `
+INSERT INTO notification_outbox_status
+(notification_id, timestamp, status, attempts, next_attempt, created_by, error)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertStatusTx saves a new status of the given notification
+func InsertStatusTx(db *sql.Tx, n *Notification, s *Status) error {
+	stmt, err := db.Prepare(insertStatus)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		n.ID,
+		s.Timestamp.UnixNano(),
+		s.Status,
+		s.Attempts,
+		s.NextAttempt.UnixNano(),
+		s.CreatedBy,
+		s.Error,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectTransactionsWithoutNotification = `
+SELECT
+	t.payment_id,
+	t.timestamp
+FROM payment_transaction AS t
+LEFT JOIN notification_outbox AS n ON
+	n.project_id = t.project_id
+	AND
+	n.payment_id = t.payment_id
+	AND
+	n.transaction_timestamp = t.timestamp
+WHERE
+	t.project_id = ?
+	AND
+	t.timestamp >= ?
+	AND
+	t.timestamp < ?
+	AND
+	n.id IS NULL
+ORDER BY t.timestamp
+LIMIT ?
+`
+
+// Transaction identifies a payment transaction
+type Transaction struct {
+	PaymentID int64
+	Timestamp time.Time
+}
+
+// TransactionsWithoutNotificationDB selects the payment transactions of the
+// project in the given period, for which no notification was saved in the
+// outbox, the earliest first
+func TransactionsWithoutNotificationDB(db *sql.DB, projectID int64, from, until time.Time, limit int) ([]Transaction, error) {
+	rows, err := db.Query(selectTransactionsWithoutNotification, projectID, from.UnixNano(), until.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]Transaction, 0)
+	for rows.Next() {
+		var t Transaction
+		var ts int64
+		err = rows.Scan(&t.PaymentID, &ts)
+		if err != nil {
+			return nil, err
+		}
+		t.Timestamp = time.Unix(0, ts)
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
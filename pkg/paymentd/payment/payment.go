@@ -22,6 +22,9 @@ const (
 	MetadataKeyAcceptLanguage = "_fAcceptLanguage"
 	MetadataKeyBrowserLocale  = "_fBrowserLocale"
 	MetadataKeyRemoteAddress  = "_fRemoteAddress"
+	// MetadataKeyTestAccount is the key for a test card or account number
+	// which should be used in test mode
+	MetadataKeyTestAccount = "_fTestAccount"
 )
 
 // Payment represents a payment
@@ -40,6 +43,28 @@ type Payment struct {
 	Status               PaymentTransactionStatus
 
 	Metadata map[string]string
+
+	// Parent is the relation to the parent payment. It is nil if the payment
+	// has no parent or the relation was not loaded
+	Parent *Relation
+	// Authorization is the current authorization of the payment. It is nil if
+	// no authorization was recorded or it was not loaded
+	Authorization *Authorization
+	// Card is the current card of the payment. It is nil if no card was
+	// recorded or it was not loaded
+	Card *Card
+	// Note is the current note of the payment. It is nil if no note was set or
+	// it was not loaded
+	Note *Note
+	// Risk is the current risk assessment of the payment. It is nil if the
+	// payment was not assessed or it was not loaded
+	Risk *Risk
+	// Billing is the current billing data collected in the checkout. It is nil
+	// if no data was collected or it was not loaded
+	Billing *Billing
+	// Reference is the reference number of the payment. It is empty if the
+	// project has no reference scheme or it was not loaded
+	Reference string
 }
 
 func (p *Payment) Valid() bool {
@@ -81,6 +106,15 @@ func (p *Payment) DecimalRound(scale int32) *decimal.Decimal {
 	return &decimal.Decimal{Dec: *d}
 }
 
+// IsVerification returns true if the payment is a verification payment
+//
+// Verification payments have a zero amount. They only verify (and possibly
+// store) the payment instrument of the customer, e.g. with a zero-amount card
+// authorization or a direct debit mandate setup.
+func (p *Payment) IsVerification() bool {
+	return p.Amount == 0
+}
+
 // HasTransaction returns true if the payment has a payment transaction entry
 func (p *Payment) HasTransaction() bool {
 	if p.TransactionTimestamp.IsZero() {
@@ -122,6 +156,8 @@ type Config struct {
 	CallbackProjectKey sql.NullString
 	ReturnURL          sql.NullString
 	Expires            *time.Time
+	// StatementDescriptor overrides the statement descriptor of the project
+	StatementDescriptor sql.NullString
 }
 
 func (cfg *Config) IsConfigured() bool {
@@ -152,6 +188,10 @@ func (cfg *Config) SetCallbackProjectKey(key string) {
 	cfg.CallbackProjectKey.String, cfg.CallbackProjectKey.Valid = key, true
 }
 
+func (cfg *Config) SetStatementDescriptor(d string) {
+	cfg.StatementDescriptor.String, cfg.StatementDescriptor.Valid = d, true
+}
+
 func (cfg *Config) SetReturnURL(url string) {
 	cfg.ReturnURL.String, cfg.ReturnURL.Valid = url, true
 }
@@ -0,0 +1,73 @@
+package payment
+
+import (
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+)
+
+// Authorization represents the amount authorized for a payment
+//
+// The authorized amount can exceed the (intended) payment amount by a buffer,
+// e.g. for incidentals in hotel and rental use cases. Authorized payments can be
+// captured up to the authorized amount.
+type Authorization struct {
+	Timestamp time.Time
+	Amount    int64
+	Subunits  int8
+	Currency  string
+}
+
+// Decimal returns the decimal representation of the authorized amount
+func (a *Authorization) Decimal() *decimal.Decimal {
+	d := dec.NewDecInt64(a.Amount)
+	d.SetScale(dec.Scale(a.Subunits))
+	return &decimal.Decimal{Dec: *d}
+}
+
+// NewAuthorization creates an authorization of the given amount (in the
+// subunits of the payment) for the payment
+func (p *Payment) NewAuthorization(amount int64) *Authorization {
+	p.Authorization = &Authorization{
+		Timestamp: time.Now(),
+		Amount:    amount,
+		Subunits:  p.Subunits,
+		Currency:  p.Currency,
+	}
+	return p.Authorization
+}
+
+// Increment returns the amount by which the authorization increased the
+// previous authorization of the payment
+//
+// The first authorization of a payment has no previous authorization.
+func (a *Authorization) Increment(prev *Authorization) int64 {
+	if prev == nil {
+		return 0
+	}
+	return a.Amount - prev.Amount
+}
+
+// AuthorizedAmount returns the amount which can be captured on the payment
+//
+// Payments without a recorded authorization are authorized for their amount.
+func (p *Payment) AuthorizedAmount() int64 {
+	if p.Authorization == nil {
+		return p.Amount
+	}
+	return p.Authorization.Amount
+}
+
+// BufferedAmount returns the payment amount increased by the given buffer
+// percentage
+//
+// The buffered amount is rounded up to the subunits of the payment.
+func (p *Payment) BufferedAmount(percent int64) int64 {
+	if percent <= 0 {
+		return p.Amount
+	}
+	d := dec.NewDecInt64(p.Amount * (100 + percent))
+	d.Quo(d, dec.NewDecInt64(100), dec.Scale(0), dec.RoundCeil)
+	return d.Unscaled().Int64()
+}
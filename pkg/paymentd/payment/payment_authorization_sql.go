@@ -0,0 +1,137 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const insertPaymentAuthorization = `
+INSERT INTO payment_authorization
+(project_id, payment_id, timestamp, amount, subunits, currency)
+VALUES
+(?, ?, ?, ?, ?, ?)
+`
+
+// InsertPaymentAuthorizationTx saves the authorization of the payment
+//
+// It is a no-op if the payment has no authorization.
+func InsertPaymentAuthorizationTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	if p.Authorization == nil {
+		return nil
+	}
+	stmt, err := db.PrepareContext(ctx, insertPaymentAuthorization)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		p.ProjectID(),
+		p.ID(),
+		p.Authorization.Timestamp.UnixNano(),
+		p.Authorization.Amount,
+		p.Authorization.Subunits,
+		p.Authorization.Currency,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentAuthorization = `
+SELECT
+	a.timestamp,
+	a.amount,
+	a.subunits,
+	a.currency
+FROM payment_authorization AS a
+WHERE
+	a.project_id = ?
+	AND
+	a.payment_id = ?
+	AND
+	a.timestamp = (
+		SELECT MAX(timestamp) FROM payment_authorization
+		WHERE
+			project_id = a.project_id
+			AND
+			payment_id = a.payment_id
+	)
+`
+
+func scanPaymentAuthorization(row *sql.Row, p *Payment) error {
+	a := &Authorization{}
+	var ts int64
+	err := row.Scan(
+		&ts,
+		&a.Amount,
+		&a.Subunits,
+		&a.Currency,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			p.Authorization = nil
+			return nil
+		}
+		return err
+	}
+	a.Timestamp = time.Unix(0, ts)
+	p.Authorization = a
+	return nil
+}
+
+// PaymentAuthorizationDB loads the current authorization of the payment
+//
+// The authorization of payments without a recorded authorization will be nil.
+func PaymentAuthorizationDB(ctx context.Context, db *sql.DB, p *Payment) error {
+	return scanPaymentAuthorization(db.QueryRowContext(ctx, selectPaymentAuthorization, p.ProjectID(), p.ID()), p)
+}
+
+// PaymentAuthorizationTx loads the current authorization of the payment
+//
+// The authorization of payments without a recorded authorization will be nil.
+func PaymentAuthorizationTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	return scanPaymentAuthorization(db.QueryRowContext(ctx, selectPaymentAuthorization, p.ProjectID(), p.ID()), p)
+}
+
+const selectPaymentAuthorizations = `
+SELECT
+	a.timestamp,
+	a.amount,
+	a.subunits,
+	a.currency
+FROM payment_authorization AS a
+WHERE
+	a.project_id = ?
+	AND
+	a.payment_id = ?
+ORDER BY a.timestamp
+`
+
+// PaymentAuthorizationsDB returns the authorization history of the payment
+//
+// The authorizations are ordered by their timestamp. The last authorization is
+// the current one.
+func PaymentAuthorizationsDB(ctx context.Context, db *sql.DB, p *Payment) ([]*Authorization, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentAuthorizations, p.ProjectID(), p.ID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	auths := make([]*Authorization, 0)
+	for rows.Next() {
+		a := &Authorization{}
+		var ts int64
+		err = rows.Scan(
+			&ts,
+			&a.Amount,
+			&a.Subunits,
+			&a.Currency,
+		)
+		if err != nil {
+			return nil, err
+		}
+		a.Timestamp = time.Unix(0, ts)
+		auths = append(auths, a)
+	}
+	return auths, rows.Err()
+}
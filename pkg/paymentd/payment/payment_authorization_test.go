@@ -0,0 +1,51 @@
+package payment
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPaymentAuthorization(t *testing.T) {
+	Convey("Given a payment of 100.01 EUR", t, func() {
+		p := &Payment{
+			Amount:   10001,
+			Subunits: 2,
+			Currency: "EUR",
+		}
+
+		Convey("Without an authorization", func() {
+			Convey("It should be authorized for the payment amount", func() {
+				So(p.AuthorizedAmount(), ShouldEqual, 10001)
+			})
+		})
+		Convey("When calculating the amount with a buffer of 15 percent", func() {
+			amount := p.BufferedAmount(15)
+			Convey("It should be rounded up to the subunits", func() {
+				So(amount, ShouldEqual, 11502)
+			})
+		})
+		Convey("When calculating the amount without a buffer", func() {
+			Convey("It should be the payment amount", func() {
+				So(p.BufferedAmount(0), ShouldEqual, 10001)
+			})
+		})
+		Convey("When an authorization of the buffered amount is created", func() {
+			p.NewAuthorization(p.BufferedAmount(15))
+			Convey("It should be authorized for the buffered amount", func() {
+				So(p.AuthorizedAmount(), ShouldEqual, 11502)
+				So(p.Authorization.Currency, ShouldEqual, "EUR")
+				So(p.Authorization.Decimal().String(), ShouldEqual, "115.02")
+			})
+
+			Convey("When the authorization is incremented", func() {
+				prev := p.Authorization
+				p.NewAuthorization(prev.Amount + 2000)
+				Convey("It should be authorized for the incremented amount", func() {
+					So(p.AuthorizedAmount(), ShouldEqual, 13502)
+					So(p.Authorization.Increment(prev), ShouldEqual, 2000)
+				})
+			})
+		})
+	})
+}
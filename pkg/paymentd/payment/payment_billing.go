@@ -0,0 +1,22 @@
+package payment
+
+import (
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/billing"
+)
+
+// Billing is the billing data collected in the checkout of a payment, e.g. the
+// VAT ID of the customer
+type Billing struct {
+	Timestamp time.Time
+	billing.Data
+}
+
+// NewBilling creates a new billing record for the payment
+func (p *Payment) NewBilling(data billing.Data) *Billing {
+	return &Billing{
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+}
@@ -0,0 +1,115 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const insertPaymentBilling = `
+INSERT INTO payment_billing
+(project_id, payment_id, timestamp, vat_id, cpf, street, postal_code, city, country)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// nullString returns a NULL string for empty values
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// InsertPaymentBillingTx saves the billing data of the payment
+//
+// It is a no-op if the payment has no billing data.
+func InsertPaymentBillingTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	if p.Billing == nil {
+		return nil
+	}
+	stmt, err := db.PrepareContext(ctx, insertPaymentBilling)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		p.ProjectID(),
+		p.ID(),
+		p.Billing.Timestamp.UnixNano(),
+		nullString(p.Billing.VATID),
+		nullString(p.Billing.CPF),
+		nullString(p.Billing.Street),
+		nullString(p.Billing.PostalCode),
+		nullString(p.Billing.City),
+		nullString(p.Billing.Country),
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentBilling = `
+SELECT
+	b.timestamp,
+	b.vat_id,
+	b.cpf,
+	b.street,
+	b.postal_code,
+	b.city,
+	b.country
+FROM payment_billing AS b
+WHERE
+	b.project_id = ?
+	AND
+	b.payment_id = ?
+	AND
+	b.timestamp = (
+		SELECT MAX(timestamp) FROM payment_billing
+		WHERE
+			project_id = b.project_id
+			AND
+			payment_id = b.payment_id
+	)
+`
+
+func scanPaymentBilling(row *sql.Row, p *Payment) error {
+	b := &Billing{}
+	var ts int64
+	var vatID, cpf, street, postalCode, city, country sql.NullString
+	err := row.Scan(
+		&ts,
+		&vatID,
+		&cpf,
+		&street,
+		&postalCode,
+		&city,
+		&country,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			p.Billing = nil
+			return nil
+		}
+		return err
+	}
+	b.Timestamp = time.Unix(0, ts)
+	b.VATID = vatID.String
+	b.CPF = cpf.String
+	b.Street = street.String
+	b.PostalCode = postalCode.String
+	b.City = city.String
+	b.Country = country.String
+	p.Billing = b
+	return nil
+}
+
+// PaymentBillingDB loads the current billing data of the payment
+//
+// The billing data of payments without collected data will be nil.
+func PaymentBillingDB(ctx context.Context, db *sql.DB, p *Payment) error {
+	return scanPaymentBilling(db.QueryRowContext(ctx, selectPaymentBilling, p.ProjectID(), p.ID()), p)
+}
+
+// PaymentBillingTx loads the current billing data of the payment
+//
+// The billing data of payments without collected data will be nil.
+func PaymentBillingTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	return scanPaymentBilling(db.QueryRowContext(ctx, selectPaymentBilling, p.ProjectID(), p.ID()), p)
+}
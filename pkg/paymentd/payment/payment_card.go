@@ -0,0 +1,32 @@
+package payment
+
+import (
+	"time"
+)
+
+// Card is the payment card used for a payment
+//
+// The issuer data is resolved from the BIN of the card. It is empty if the BIN
+// is not known.
+type Card struct {
+	Timestamp time.Time
+	BIN       string
+	// Country is the ISO 3166-1 alpha-2 code of the issuer country
+	Country string
+	Brand   string
+	Debit   bool
+	Prepaid bool
+}
+
+// Domestic returns true if the card was issued in the given country
+func (c *Card) Domestic(country string) bool {
+	return c.Country != "" && c.Country == country
+}
+
+// NewCard creates a new card record for the payment
+func (p *Payment) NewCard(bin string) *Card {
+	return &Card{
+		Timestamp: time.Now(),
+		BIN:       bin,
+	}
+}
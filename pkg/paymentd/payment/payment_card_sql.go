@@ -0,0 +1,100 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const insertPaymentCard = `
+INSERT INTO payment_card
+(project_id, payment_id, timestamp, bin, country, brand, debit, prepaid)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertPaymentCardTx saves the card of the payment
+//
+// It is a no-op if the payment has no card.
+func InsertPaymentCardTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	if p.Card == nil {
+		return nil
+	}
+	stmt, err := db.PrepareContext(ctx, insertPaymentCard)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		p.ProjectID(),
+		p.ID(),
+		p.Card.Timestamp.UnixNano(),
+		p.Card.BIN,
+		p.Card.Country,
+		p.Card.Brand,
+		p.Card.Debit,
+		p.Card.Prepaid,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentCard = `
+SELECT
+	c.timestamp,
+	c.bin,
+	c.country,
+	c.brand,
+	c.debit,
+	c.prepaid
+FROM payment_card AS c
+WHERE
+	c.project_id = ?
+	AND
+	c.payment_id = ?
+	AND
+	c.timestamp = (
+		SELECT MAX(timestamp) FROM payment_card
+		WHERE
+			project_id = c.project_id
+			AND
+			payment_id = c.payment_id
+	)
+`
+
+func scanPaymentCard(row *sql.Row, p *Payment) error {
+	c := &Card{}
+	var ts int64
+	err := row.Scan(
+		&ts,
+		&c.BIN,
+		&c.Country,
+		&c.Brand,
+		&c.Debit,
+		&c.Prepaid,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			p.Card = nil
+			return nil
+		}
+		return err
+	}
+	c.Timestamp = time.Unix(0, ts)
+	p.Card = c
+	return nil
+}
+
+// PaymentCardDB loads the current card of the payment
+//
+// The card of payments without a recorded card will be nil.
+func PaymentCardDB(ctx context.Context, db *sql.DB, p *Payment) error {
+	return scanPaymentCard(db.QueryRowContext(ctx, selectPaymentCard, p.ProjectID(), p.ID()), p)
+}
+
+// PaymentCardTx loads the current card of the payment
+//
+// The card of payments without a recorded card will be nil.
+func PaymentCardTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	return scanPaymentCard(db.QueryRowContext(ctx, selectPaymentCard, p.ProjectID(), p.ID()), p)
+}
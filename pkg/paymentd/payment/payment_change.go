@@ -0,0 +1,169 @@
+package payment
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Change types
+const (
+	ChangeTypeCreated     = "payment.created"
+	ChangeTypeConfig      = "payment.config"
+	ChangeTypeMetadata    = "payment.metadata"
+	ChangeTypeTransaction = "payment.transaction"
+	ChangeTypeRelation    = "payment.relation"
+	ChangeTypeNote        = "payment.note"
+	ChangeTypeEscrow      = "payment.escrow"
+)
+
+// Change represents a mutation of a payment
+//
+// Changes are written to the outbox in the same database transaction as the
+// mutation itself. A change will be assigned a sequence number once it is
+// published to the change feed. The sequence numbers determine the order of the
+// change feed.
+type Change struct {
+	id        int64
+	ProjectID int64
+	PaymentID int64
+	// Sequence is the position in the change feed. It is zero for unpublished
+	// changes
+	Sequence  int64
+	Timestamp time.Time
+	Type      string
+	// Data holds the JSON representation of the mutated state
+	Data json.RawMessage
+}
+
+// ID returns the outbox ID of the change
+func (c *Change) ID() int64 {
+	return c.id
+}
+
+type changePayment struct {
+	Ident    string
+	Created  time.Time
+	Amount   int64 `json:",string"`
+	Subunits int8  `json:",string"`
+	Currency string
+}
+
+type changeConfig struct {
+	PaymentMethodId int64      `json:",string,omitempty"`
+	Country         string     `json:",omitempty"`
+	Locale          string     `json:",omitempty"`
+	Expires         *time.Time `json:",omitempty"`
+}
+
+type changeTransaction struct {
+	Timestamp      int64 `json:",string"`
+	Amount         int64 `json:",string"`
+	Subunits       int8  `json:",string"`
+	Currency       string
+	Status         string
+	Comment        string `json:",omitempty"`
+	Authentication string `json:",omitempty"`
+}
+
+type changeRelation struct {
+	ParentPaymentId PaymentID
+	Relation        string
+}
+
+type changeNote struct {
+	Note      string
+	CreatedBy string `json:",omitempty"`
+}
+
+type changeEscrow struct {
+	Status       string
+	Amount       int64      `json:",string"`
+	ReleaseAfter *time.Time `json:",omitempty"`
+	CreatedBy    string     `json:",omitempty"`
+}
+
+func newChange(projectID, paymentID int64, typ string, data interface{}) (*Change, error) {
+	c := &Change{
+		ProjectID: projectID,
+		PaymentID: paymentID,
+		Timestamp: time.Now(),
+		Type:      typ,
+	}
+	var err error
+	c.Data, err = json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewCreatedChange returns the change for a newly created payment
+func NewCreatedChange(p *Payment) (*Change, error) {
+	return newChange(p.ProjectID(), p.ID(), ChangeTypeCreated, changePayment{
+		Ident:    p.Ident,
+		Created:  p.Created,
+		Amount:   p.Amount,
+		Subunits: p.Subunits,
+		Currency: p.Currency,
+	})
+}
+
+// NewConfigChange returns the change for an updated payment config
+func NewConfigChange(p *Payment) (*Change, error) {
+	return newChange(p.ProjectID(), p.ID(), ChangeTypeConfig, changeConfig{
+		PaymentMethodId: p.Config.PaymentMethodID.Int64,
+		Country:         p.Config.Country.String,
+		Locale:          p.Config.Locale.String,
+		Expires:         p.Config.Expires,
+	})
+}
+
+// NewMetadataChange returns the change for updated payment metadata
+func NewMetadataChange(p *Payment) (*Change, error) {
+	return newChange(p.ProjectID(), p.ID(), ChangeTypeMetadata, p.Metadata)
+}
+
+// NewNoteChange returns the change for an updated payment note
+func NewNoteChange(p *Payment) (*Change, error) {
+	return newChange(p.ProjectID(), p.ID(), ChangeTypeNote, changeNote{
+		Note:      p.Note.Text,
+		CreatedBy: p.Note.CreatedBy,
+	})
+}
+
+// NewTransactionChange returns the change for a new payment transaction
+func NewTransactionChange(paymentTx *PaymentTransaction) (*Change, error) {
+	return newChange(paymentTx.Payment.ProjectID(), paymentTx.Payment.ID(), ChangeTypeTransaction, changeTransaction{
+		Timestamp:      paymentTx.Timestamp.UnixNano(),
+		Amount:         paymentTx.Amount,
+		Subunits:       paymentTx.Subunits,
+		Currency:       paymentTx.Currency,
+		Status:         paymentTx.Status.String(),
+		Comment:        paymentTx.Comment.String,
+		Authentication: paymentTx.Authentication.String,
+	})
+}
+
+// NewRelationChange returns the change for a payment linked to its parent
+//
+// The parent payment ID should be encoded, since it will be exposed in the
+// change feed.
+func NewRelationChange(p *Payment, encodedParentID PaymentID) (*Change, error) {
+	return newChange(p.ProjectID(), p.ID(), ChangeTypeRelation, changeRelation{
+		ParentPaymentId: encodedParentID,
+		Relation:        p.Parent.Type,
+	})
+}
+
+// NewEscrowChange returns the change for a new escrow status of the payment
+func NewEscrowChange(p *Payment, e *Escrow) (*Change, error) {
+	data := changeEscrow{
+		Status:    e.Status,
+		Amount:    e.Amount,
+		CreatedBy: e.CreatedBy,
+	}
+	if !e.ReleaseAfter.IsZero() {
+		data.ReleaseAfter = &e.ReleaseAfter
+	}
+	return newChange(p.ProjectID(), p.ID(), ChangeTypeEscrow, data)
+}
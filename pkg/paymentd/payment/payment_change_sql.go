@@ -0,0 +1,193 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const insertChange = `
+INSERT INTO payment_change
+(project_id, payment_id, timestamp, type, data)
+VALUES
+(?, ?, ?, ?, ?)
+`
+
+// InsertChangeTx writes the change to the outbox
+func InsertChangeTx(ctx context.Context, db *sql.Tx, c *Change) error {
+	stmt, err := db.PrepareContext(ctx, insertChange)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.ExecContext(ctx,
+		c.ProjectID,
+		c.PaymentID,
+		c.Timestamp.UnixNano(),
+		c.Type,
+		string(c.Data),
+	)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	c.id, err = res.LastInsertId()
+	return err
+}
+
+const selectUnsequencedChangeIDs = `
+SELECT
+	id
+FROM payment_change
+WHERE
+	sequence IS NULL
+ORDER BY id
+LIMIT ?
+FOR UPDATE
+`
+
+// UnsequencedChangeIDsTx selects the IDs of the unpublished changes in outbox
+// order
+//
+// The selected changes will be locked.
+func UnsequencedChangeIDsTx(ctx context.Context, db *sql.Tx, limit int) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, selectUnsequencedChangeIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, limit)
+	var id int64
+	for rows.Next() {
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	return ids, err
+}
+
+const selectMaxChangeSequence = `
+SELECT
+	COALESCE(MAX(sequence), 0)
+FROM payment_change
+`
+
+// MaxChangeSequenceTx selects the highest assigned sequence number
+//
+// Concurrent publishers will be detected by the unique index on the sequence.
+func MaxChangeSequenceTx(ctx context.Context, db *sql.Tx) (int64, error) {
+	var seq int64
+	err := db.QueryRowContext(ctx, selectMaxChangeSequence).Scan(&seq)
+	return seq, err
+}
+
+const updateChangeSequence = `
+UPDATE payment_change
+SET
+	sequence = ?
+WHERE
+	id = ?
+	AND
+	sequence IS NULL
+`
+
+// SetChangeSequenceTx publishes the change with the given ID by assigning the
+// sequence number
+func SetChangeSequenceTx(ctx context.Context, db *sql.Tx, id, sequence int64) error {
+	stmt, err := db.PrepareContext(ctx, updateChangeSequence)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx, sequence, id)
+	stmt.Close()
+	return err
+}
+
+const selectChangesByProjectIDAfterSequence = `
+SELECT
+	id,
+	project_id,
+	payment_id,
+	sequence,
+	timestamp,
+	type,
+	data
+FROM payment_change
+WHERE
+	project_id = ?
+	AND
+	sequence > ?
+ORDER BY sequence
+LIMIT ?
+`
+
+// ChangesByProjectIDAfterSequenceDB selects the published changes of the given
+// project which follow the given sequence number
+func ChangesByProjectIDAfterSequenceDB(ctx context.Context, db *sql.DB, projectID, sequence int64, limit int) ([]*Change, error) {
+	rows, err := db.QueryContext(ctx, selectChangesByProjectIDAfterSequence, projectID, sequence, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanChanges(rows, limit)
+}
+
+const selectChangesAfterSequence = `
+SELECT
+	id,
+	project_id,
+	payment_id,
+	sequence,
+	timestamp,
+	type,
+	data
+FROM payment_change
+WHERE
+	sequence > ?
+ORDER BY sequence
+LIMIT ?
+`
+
+// ChangesAfterSequenceDB selects the published changes of all projects which
+// follow the given sequence number
+func ChangesAfterSequenceDB(ctx context.Context, db *sql.DB, sequence int64, limit int) ([]*Change, error) {
+	rows, err := db.QueryContext(ctx, selectChangesAfterSequence, sequence, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanChanges(rows, limit)
+}
+
+func scanChanges(rows *sql.Rows, limit int) ([]*Change, error) {
+	changes := make([]*Change, 0, limit)
+	var ts int64
+	var data sql.NullString
+	var err error
+	for rows.Next() {
+		c := &Change{}
+		err = rows.Scan(
+			&c.id,
+			&c.ProjectID,
+			&c.PaymentID,
+			&c.Sequence,
+			&ts,
+			&c.Type,
+			&data,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c.Timestamp = time.Unix(0, ts)
+		if data.Valid {
+			c.Data = []byte(data.String)
+		}
+		changes = append(changes, c)
+	}
+	err = rows.Err()
+	rows.Close()
+	return changes, err
+}
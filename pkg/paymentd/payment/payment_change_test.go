@@ -0,0 +1,63 @@
+package payment_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChange(t *testing.T) {
+	Convey("Given a payment transaction", t, func() {
+		p := &payment.Payment{
+			Ident:    "order-1",
+			Amount:   1234,
+			Subunits: 2,
+			Currency: "EUR",
+		}
+		paymentTx := p.NewTransaction(payment.PaymentStatusPaid)
+		paymentTx.Timestamp = time.Unix(0, 1000)
+
+		Convey("When creating a transaction change", func() {
+			c, err := payment.NewTransactionChange(paymentTx)
+			So(err, ShouldBeNil)
+
+			Convey("It should have the transaction type", func() {
+				So(c.Type, ShouldEqual, payment.ChangeTypeTransaction)
+				So(c.Sequence, ShouldEqual, 0)
+			})
+			Convey("It should contain the transaction state", func() {
+				data := make(map[string]string)
+				err = json.Unmarshal(c.Data, &data)
+				So(err, ShouldBeNil)
+				So(data["Status"], ShouldEqual, "paid")
+				So(data["Amount"], ShouldEqual, "1234")
+				So(data["Currency"], ShouldEqual, "EUR")
+				So(data["Timestamp"], ShouldEqual, "1000")
+			})
+		})
+
+		Convey("When creating a metadata change", func() {
+			p.Metadata = map[string]string{"email": "customer@example.com"}
+			c, err := payment.NewMetadataChange(p)
+			So(err, ShouldBeNil)
+
+			Convey("It should contain the metadata", func() {
+				So(string(c.Data), ShouldEqual, `{"email":"customer@example.com"}`)
+			})
+		})
+
+		Convey("When creating a note change", func() {
+			p.Note = p.NewNote("2 x Widget", "admin")
+			c, err := payment.NewNoteChange(p)
+			So(err, ShouldBeNil)
+
+			Convey("It should contain the note", func() {
+				So(c.Type, ShouldEqual, payment.ChangeTypeNote)
+				So(string(c.Data), ShouldEqual, `{"Note":"2 x Widget","CreatedBy":"admin"}`)
+			})
+		})
+	})
+}
@@ -0,0 +1,65 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+)
+
+// Chargeback statuses
+const (
+	// ChargebackReceived disputes were opened by the customer. The disputed
+	// amount is withdrawn until the dispute is resolved
+	ChargebackReceived = "received"
+	// ChargebackReversed disputes were resolved in favor of the merchant. The
+	// disputed amount is returned
+	ChargebackReversed = "reversed"
+	// ChargebackLost disputes were resolved in favor of the customer
+	ChargebackLost = "lost"
+)
+
+// Chargeback represents a status change of a dispute (chargeback) of a payment
+//
+// A payment can be disputed more than once. The disputes are identified by the
+// ID of the dispute at the provider.
+type Chargeback struct {
+	Timestamp time.Time
+	// DisputeID identifies the dispute at the provider
+	DisputeID string
+	Status    string
+	// Amount is the disputed amount in the subunits of the payment
+	Amount   int64
+	Subunits int8
+	// Reason is the reason of the dispute or the outcome given by the provider
+	Reason    sql.NullString
+	CreatedBy string
+}
+
+// Decimal returns the disputed amount
+func (c *Chargeback) Decimal() *decimal.Decimal {
+	d := dec.NewDecInt64(c.Amount)
+	d.SetScale(dec.Scale(c.Subunits))
+	return &decimal.Decimal{Dec: *d}
+}
+
+// Open returns true if the dispute was not resolved yet
+func (c *Chargeback) Open() bool {
+	return c.Status == ChargebackReceived
+}
+
+// NewChargeback creates a new status of the dispute of the payment
+//
+// A zero amount will be replaced by the disputable amount when the chargeback
+// is received.
+func (p *Payment) NewChargeback(disputeID, status string, amount int64, createdBy string) *Chargeback {
+	return &Chargeback{
+		Timestamp: time.Now(),
+		DisputeID: disputeID,
+		Status:    status,
+		Amount:    amount,
+		Subunits:  p.Subunits,
+		CreatedBy: createdBy,
+	}
+}
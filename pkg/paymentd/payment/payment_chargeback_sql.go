@@ -0,0 +1,130 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrChargebackNotFound = errors.New("payment chargeback not found")
+)
+
+const insertPaymentChargeback = `
+INSERT INTO payment_chargeback
+(project_id, payment_id, dispute_id, timestamp, status, amount, reason, created_by)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertPaymentChargebackTx saves a new status of a dispute of the payment
+func InsertPaymentChargebackTx(ctx context.Context, db *sql.Tx, p *Payment, c *Chargeback) error {
+	stmt, err := db.PrepareContext(ctx, insertPaymentChargeback)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		p.ProjectID(),
+		p.ID(),
+		c.DisputeID,
+		c.Timestamp.UnixNano(),
+		c.Status,
+		c.Amount,
+		c.Reason,
+		c.CreatedBy,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentChargeback = `
+SELECT
+	c.dispute_id,
+	c.timestamp,
+	c.status,
+	c.amount,
+	c.reason,
+	c.created_by
+FROM payment_chargeback AS c
+WHERE
+	c.project_id = ?
+	AND
+	c.payment_id = ?
+`
+
+const selectPaymentChargebackCurrent = selectPaymentChargeback + `
+	AND
+	c.dispute_id = ?
+	AND
+	c.timestamp = (
+		SELECT MAX(timestamp) FROM payment_chargeback
+		WHERE
+			project_id = c.project_id
+			AND
+			payment_id = c.payment_id
+			AND
+			dispute_id = c.dispute_id
+	)
+`
+
+const selectPaymentChargebacks = selectPaymentChargeback + `
+ORDER BY c.timestamp
+`
+
+func scanChargeback(row resultScanner, p *Payment) (*Chargeback, error) {
+	c := &Chargeback{Subunits: p.Subunits}
+	var ts int64
+	err := row.Scan(
+		&c.DisputeID,
+		&ts,
+		&c.Status,
+		&c.Amount,
+		&c.Reason,
+		&c.CreatedBy,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrChargebackNotFound
+		}
+		return nil, err
+	}
+	c.Timestamp = time.Unix(0, ts)
+	return c, nil
+}
+
+// PaymentChargebackCurrentDB selects the current status of the dispute of the
+// payment
+//
+// It returns an ErrChargebackNotFound if there is no such dispute.
+func PaymentChargebackCurrentDB(ctx context.Context, db *sql.DB, p *Payment, disputeID string) (*Chargeback, error) {
+	return scanChargeback(db.QueryRowContext(ctx, selectPaymentChargebackCurrent, p.ProjectID(), p.ID(), disputeID), p)
+}
+
+// PaymentChargebackCurrentTx selects the current status of the dispute of the
+// payment
+//
+// It returns an ErrChargebackNotFound if there is no such dispute.
+func PaymentChargebackCurrentTx(ctx context.Context, db *sql.Tx, p *Payment, disputeID string) (*Chargeback, error) {
+	return scanChargeback(db.QueryRowContext(ctx, selectPaymentChargebackCurrent, p.ProjectID(), p.ID(), disputeID), p)
+}
+
+// PaymentChargebacksDB selects all status changes of the disputes of the
+// payment, oldest first
+func PaymentChargebacksDB(ctx context.Context, db *sql.DB, p *Payment) ([]*Chargeback, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentChargebacks, p.ProjectID(), p.ID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chargebacks := make([]*Chargeback, 0)
+	for rows.Next() {
+		c, err := scanChargeback(rows, p)
+		if err != nil {
+			return nil, err
+		}
+		chargebacks = append(chargebacks, c)
+	}
+	return chargebacks, rows.Err()
+}
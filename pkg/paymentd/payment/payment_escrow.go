@@ -0,0 +1,85 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+)
+
+// Escrow statuses
+const (
+	// EscrowHeld funds are captured but not eligible for payout
+	EscrowHeld = "held"
+	// EscrowReleased funds were released for payout, either by the project or
+	// after the hold expired
+	EscrowReleased = "released"
+	// EscrowReturned funds were returned to the customer, e.g. by a refund,
+	// while being held
+	EscrowReturned = "returned"
+)
+
+// Escrow represents a status change of the escrow of a payment
+//
+// Payments of projects in escrow mode are held in escrow when they are paid.
+// The captured funds are not eligible for payout until they are released,
+// e.g. when the project confirms the delivery.
+type Escrow struct {
+	Timestamp time.Time
+	Status    string
+	// Amount is the amount held in escrow in the currency of the payment
+	Amount   int64
+	Subunits int8
+	// ReleaseAfter is the time after which held funds will be released
+	// automatically
+	ReleaseAfter time.Time
+	CreatedBy    string
+	Comment      sql.NullString
+}
+
+// Decimal returns the amount held in escrow
+func (e *Escrow) Decimal() *decimal.Decimal {
+	d := dec.NewDecInt64(e.Amount)
+	d.SetScale(dec.Scale(e.Subunits))
+	return &decimal.Decimal{Dec: *d}
+}
+
+// IsHeld returns true if the funds are held in escrow
+func (e *Escrow) IsHeld() bool {
+	return e.Status == EscrowHeld
+}
+
+// Due returns true if held funds are due for the automatic release
+func (e *Escrow) Due(now time.Time) bool {
+	return e.IsHeld() && !e.ReleaseAfter.IsZero() && !e.ReleaseAfter.After(now)
+}
+
+// NewEscrow creates a new escrow status for the payment
+func (p *Payment) NewEscrow(status, createdBy string) *Escrow {
+	return &Escrow{
+		Timestamp: time.Now(),
+		Status:    status,
+		Subunits:  p.Subunits,
+		CreatedBy: createdBy,
+	}
+}
+
+// EscrowBalance is the amount held in escrow for the payments of a project in a
+// currency
+type EscrowBalance struct {
+	Currency string
+	Subunits int8
+	// Held is the sum of the amounts held in escrow
+	Held     int64
+	Payments int64
+	// Due is the number of payments due for the automatic release
+	Due int64
+}
+
+// HeldDecimal returns the amount held in escrow
+func (b *EscrowBalance) HeldDecimal() *decimal.Decimal {
+	d := dec.NewDecInt64(b.Held)
+	d.SetScale(dec.Scale(b.Subunits))
+	return &decimal.Decimal{Dec: *d}
+}
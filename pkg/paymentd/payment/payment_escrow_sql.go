@@ -0,0 +1,200 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrEscrowNotFound = errors.New("payment escrow not found")
+)
+
+const insertPaymentEscrow = `
+INSERT INTO payment_escrow
+(project_id, payment_id, timestamp, status, amount, release_after, created_by, comment)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertPaymentEscrowTx saves a new escrow status of the payment
+func InsertPaymentEscrowTx(ctx context.Context, db *sql.Tx, p *Payment, e *Escrow) error {
+	stmt, err := db.PrepareContext(ctx, insertPaymentEscrow)
+	if err != nil {
+		return err
+	}
+	var releaseAfter sql.NullInt64
+	if !e.ReleaseAfter.IsZero() {
+		releaseAfter.Int64, releaseAfter.Valid = e.ReleaseAfter.UnixNano(), true
+	}
+	_, err = stmt.ExecContext(ctx,
+		p.ProjectID(),
+		p.ID(),
+		e.Timestamp.UnixNano(),
+		e.Status,
+		e.Amount,
+		releaseAfter,
+		e.CreatedBy,
+		e.Comment,
+	)
+	stmt.Close()
+	return err
+}
+
+const whereEscrowCurrent = `
+	e.timestamp = (
+		SELECT MAX(timestamp) FROM payment_escrow
+		WHERE
+			project_id = e.project_id
+			AND
+			payment_id = e.payment_id
+	)
+`
+
+const selectPaymentEscrow = `
+SELECT
+	e.timestamp,
+	e.status,
+	e.amount,
+	e.release_after,
+	e.created_by,
+	e.comment
+FROM payment_escrow AS e
+WHERE
+	e.project_id = ?
+	AND
+	e.payment_id = ?
+	AND
+` + whereEscrowCurrent
+
+func scanEscrow(row resultScanner, p *Payment) (*Escrow, error) {
+	e := &Escrow{Subunits: p.Subunits}
+	var ts int64
+	var releaseAfter sql.NullInt64
+	err := row.Scan(
+		&ts,
+		&e.Status,
+		&e.Amount,
+		&releaseAfter,
+		&e.CreatedBy,
+		&e.Comment,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrEscrowNotFound
+		}
+		return nil, err
+	}
+	e.Timestamp = time.Unix(0, ts)
+	if releaseAfter.Valid {
+		e.ReleaseAfter = time.Unix(0, releaseAfter.Int64)
+	}
+	return e, nil
+}
+
+// PaymentEscrowCurrentDB selects the current escrow status of the payment
+//
+// It returns an ErrEscrowNotFound if the payment was never held in escrow.
+func PaymentEscrowCurrentDB(ctx context.Context, db *sql.DB, p *Payment) (*Escrow, error) {
+	return scanEscrow(db.QueryRowContext(ctx, selectPaymentEscrow, p.ProjectID(), p.ID()), p)
+}
+
+// PaymentEscrowCurrentTx selects the current escrow status of the payment
+//
+// It returns an ErrEscrowNotFound if the payment was never held in escrow.
+func PaymentEscrowCurrentTx(ctx context.Context, db *sql.Tx, p *Payment) (*Escrow, error) {
+	return scanEscrow(db.QueryRowContext(ctx, selectPaymentEscrow, p.ProjectID(), p.ID()), p)
+}
+
+const selectEscrowDue = `
+SELECT
+	e.project_id,
+	e.payment_id
+FROM payment_escrow AS e
+WHERE
+	e.status = ?
+	AND
+	e.release_after <= ?
+	AND
+` + whereEscrowCurrent + `
+ORDER BY e.release_after
+LIMIT ?
+`
+
+// EscrowDueDB selects the IDs of the payments held in escrow, which are due for
+// the automatic release at the given time
+//
+// The payments are ordered by their release time.
+func EscrowDueDB(ctx context.Context, db *sql.DB, now time.Time, limit int) ([]PaymentID, error) {
+	rows, err := db.QueryContext(ctx, selectEscrowDue, EscrowHeld, now.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]PaymentID, 0, limit)
+	for rows.Next() {
+		var id PaymentID
+		err = rows.Scan(&id.ProjectID, &id.PaymentID)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+const selectEscrowBalance = `
+SELECT
+	p.currency,
+	p.subunits,
+	SUM(e.amount),
+	COUNT(*),
+	SUM(e.release_after <= ?)
+FROM payment_escrow AS e
+INNER JOIN payment AS p ON
+	p.project_id = e.project_id
+	AND
+	p.id = e.payment_id
+WHERE
+	e.project_id = ?
+	AND
+	e.status = ?
+	AND
+` + whereEscrowCurrent + `
+GROUP BY p.currency, p.subunits
+ORDER BY p.currency
+`
+
+// EscrowBalancesDB selects the amounts held in escrow for the payments of the
+// project by currency
+func EscrowBalancesDB(ctx context.Context, db *sql.DB, projectID int64, now time.Time) ([]*EscrowBalance, error) {
+	rows, err := db.QueryContext(ctx, selectEscrowBalance, now.UnixNano(), projectID, EscrowHeld)
+	if err != nil {
+		return nil, err
+	}
+	balances := make([]*EscrowBalance, 0)
+	for rows.Next() {
+		b := &EscrowBalance{}
+		var due sql.NullInt64
+		err = rows.Scan(&b.Currency, &b.Subunits, &b.Held, &b.Payments, &due)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		b.Due = due.Int64
+		balances = append(balances, b)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
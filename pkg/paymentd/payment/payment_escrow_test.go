@@ -0,0 +1,80 @@
+package payment_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEscrow(t *testing.T) {
+	Convey("Given a paid payment", t, func() {
+		p := &payment.Payment{
+			Ident:    "order-1",
+			Created:  time.Unix(1400000000, 0),
+			Amount:   1234,
+			Subunits: 2,
+			Currency: "EUR",
+		}
+		now := time.Unix(1400000000, 0)
+
+		Convey("When the funds are held in escrow", func() {
+			e := p.NewEscrow(payment.EscrowHeld, "paymentd")
+			e.Amount = 1234
+			e.ReleaseAfter = now.Add(time.Hour)
+
+			Convey("It should be held", func() {
+				So(e.IsHeld(), ShouldBeTrue)
+				So(e.Decimal().String(), ShouldEqual, "12.34")
+			})
+			Convey("It should not be due before the release time", func() {
+				So(e.Due(now), ShouldBeFalse)
+			})
+			Convey("It should be due at the release time", func() {
+				So(e.Due(now.Add(time.Hour)), ShouldBeTrue)
+			})
+
+			Convey("When the escrow changes are applied to the overview", func() {
+				created, err := payment.NewCreatedChange(p)
+				So(err, ShouldBeNil)
+				created.Sequence = 1
+				held, err := payment.NewEscrowChange(p, e)
+				So(err, ShouldBeNil)
+				held.Sequence = 2
+				o := payment.NewOverview(created)
+				_, err = o.Apply(created)
+				So(err, ShouldBeNil)
+				_, err = o.Apply(held)
+				So(err, ShouldBeNil)
+
+				Convey("The overview should hold the amount", func() {
+					So(o.HeldDecimal().String(), ShouldEqual, "12.34")
+				})
+
+				Convey("When the funds are released", func() {
+					released := p.NewEscrow(payment.EscrowReleased, "user")
+					released.Amount = e.Amount
+					c, err := payment.NewEscrowChange(p, released)
+					So(err, ShouldBeNil)
+					c.Sequence = 3
+					_, err = o.Apply(c)
+					So(err, ShouldBeNil)
+
+					Convey("The overview should not hold any funds", func() {
+						So(o.Held, ShouldEqual, 0)
+					})
+				})
+			})
+		})
+
+		Convey("When the funds were released", func() {
+			e := p.NewEscrow(payment.EscrowReleased, "user")
+
+			Convey("It should not be due", func() {
+				So(e.IsHeld(), ShouldBeFalse)
+				So(e.Due(now), ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,175 @@
+package payment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// GenesisHash is the previous hash of the first event of a chain
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Event is an immutable record of a payment transaction
+//
+// The events of a project form a hash chain. Each event contains the hash of
+// its predecessor, so that modified, inserted or removed events can be
+// detected.
+type Event struct {
+	ID        int64
+	ProjectID int64
+	PaymentID int64
+	Timestamp time.Time
+	Amount    int64
+	Subunits  int8
+	Currency  string
+	Status    PaymentTransactionStatus
+	Comment   string
+	// PrevHash is the hash of the preceding event of the project
+	PrevHash string
+	Hash     string
+}
+
+// NewEvent creates an (unchained) event of the payment transaction
+func NewEvent(paymentTx *PaymentTransaction) *Event {
+	return &Event{
+		ProjectID: paymentTx.Payment.ProjectID(),
+		PaymentID: paymentTx.Payment.ID(),
+		Timestamp: paymentTx.Timestamp,
+		Amount:    paymentTx.Amount,
+		Subunits:  paymentTx.Subunits,
+		Currency:  paymentTx.Currency,
+		Status:    paymentTx.Status,
+		Comment:   paymentTx.Comment.String,
+	}
+}
+
+// eventHashData is the hashed representation of an event
+type eventHashData struct {
+	ProjectID int64
+	PaymentID int64
+	Timestamp int64
+	Amount    int64
+	Subunits  int8
+	Currency  string
+	Status    string
+	Comment   string
+}
+
+// ComputeHash returns the hash of the event chained to its previous hash
+func (e *Event) ComputeHash() string {
+	data, err := json.Marshal(eventHashData{
+		ProjectID: e.ProjectID,
+		PaymentID: e.PaymentID,
+		Timestamp: e.Timestamp.UnixNano(),
+		Amount:    e.Amount,
+		Subunits:  e.Subunits,
+		Currency:  e.Currency,
+		Status:    string(e.Status),
+		Comment:   e.Comment,
+	})
+	if err != nil {
+		// cannot fail on the basic types
+		panic("error encoding event: " + err.Error())
+	}
+	h := sha256.New()
+	h.Write([]byte(e.PrevHash))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Chain links the event to its predecessor with the given hash
+func (e *Event) Chain(prevHash string) {
+	e.PrevHash = prevHash
+	e.Hash = e.ComputeHash()
+}
+
+// Matches returns true if the event records the given transaction
+func (e *Event) Matches(paymentTx *PaymentTransaction) bool {
+	return e.Timestamp.Equal(paymentTx.Timestamp) &&
+		e.Amount == paymentTx.Amount &&
+		e.Subunits == paymentTx.Subunits &&
+		e.Currency == paymentTx.Currency &&
+		e.Status == paymentTx.Status &&
+		e.Comment == paymentTx.Comment.String
+}
+
+// ChainViolation describes an event which breaks the integrity of the chain
+type ChainViolation struct {
+	EventID   int64
+	PaymentID int64
+	Reason    string
+}
+
+// Chain violation reasons
+const (
+	ChainViolationHash        = "event hash does not match its contents"
+	ChainViolationLink        = "previous hash does not match the preceding event"
+	ChainViolationTransaction = "payment transaction does not match the event"
+	ChainViolationMissing     = "payment transaction without event"
+	ChainViolationRemoved     = "event without payment transaction"
+)
+
+// EventChainVerifier checks the integrity of the event chain of a project
+//
+// The events must be passed in chain order.
+type EventChainVerifier struct {
+	prevHash   string
+	Events     int
+	Violations []ChainViolation
+}
+
+// NewEventChainVerifier creates a verifier for a chain starting with the
+// genesis hash
+func NewEventChainVerifier() *EventChainVerifier {
+	return &EventChainVerifier{prevHash: GenesisHash}
+}
+
+// Verify checks the next event of the chain
+//
+// The transaction recorded by the event is nil if it does not exist.
+func (v *EventChainVerifier) Verify(e *Event, paymentTx *PaymentTransaction) {
+	v.Events++
+	if e.PrevHash != v.prevHash {
+		v.violation(e, ChainViolationLink)
+	}
+	if e.ComputeHash() != e.Hash {
+		v.violation(e, ChainViolationHash)
+	}
+	if paymentTx == nil {
+		v.violation(e, ChainViolationRemoved)
+	} else if !e.Matches(paymentTx) {
+		v.violation(e, ChainViolationTransaction)
+	}
+	v.prevHash = e.Hash
+}
+
+// Missing records a payment transaction without event
+func (v *EventChainVerifier) Missing(paymentID int64) {
+	v.Violations = append(v.Violations, ChainViolation{
+		PaymentID: paymentID,
+		Reason:    ChainViolationMissing,
+	})
+}
+
+func (v *EventChainVerifier) violation(e *Event, reason string) {
+	v.Violations = append(v.Violations, ChainViolation{
+		EventID:   e.ID,
+		PaymentID: e.PaymentID,
+		Reason:    reason,
+	})
+}
+
+// Head returns the hash of the last verified event
+//
+// Recording the head allows detecting the removal of events from the end of
+// the chain in later audits.
+func (v *EventChainVerifier) Head() string {
+	return v.prevHash
+}
+
+// Valid returns true if no violations were found
+func (v *EventChainVerifier) Valid() bool {
+	return len(v.Violations) == 0
+}
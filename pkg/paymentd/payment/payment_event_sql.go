@@ -0,0 +1,247 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const selectLastEventHash = `
+SELECT
+	hash
+FROM payment_event
+WHERE
+	project_id = ?
+ORDER BY id DESC
+LIMIT 1
+FOR UPDATE
+`
+
+const insertEvent = `
+INSERT INTO payment_event
+(project_id, payment_id, timestamp, amount, subunits, currency, status, comment, prev_hash, hash)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// AppendEventTx chains the event to the last event of its project and saves it
+//
+// The last event of the project will be locked until the transaction ends, so
+// that appends to the chain of a project are serialized.
+func AppendEventTx(ctx context.Context, db *sql.Tx, e *Event) error {
+	prevHash := GenesisHash
+	err := db.QueryRowContext(ctx, selectLastEventHash, e.ProjectID).Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	e.Chain(prevHash)
+	stmt, err := db.PrepareContext(ctx, insertEvent)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.ExecContext(ctx,
+		e.ProjectID,
+		e.PaymentID,
+		e.Timestamp.UnixNano(),
+		e.Amount,
+		e.Subunits,
+		e.Currency,
+		e.Status,
+		e.Comment,
+		e.PrevHash,
+		e.Hash,
+	)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	e.ID, err = res.LastInsertId()
+	return err
+}
+
+const selectEventProjectIDs = `
+SELECT DISTINCT
+	project_id
+FROM payment_event
+ORDER BY project_id
+`
+
+// EventProjectIDsDB selects the IDs of the projects with events
+func EventProjectIDsDB(ctx context.Context, db *sql.DB) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, selectEventProjectIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int64, 0)
+	var id int64
+	for rows.Next() {
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+const selectEventChain = `
+SELECT
+	e.id,
+	e.project_id,
+	e.payment_id,
+	e.timestamp,
+	e.amount,
+	e.subunits,
+	e.currency,
+	e.status,
+	e.comment,
+	e.prev_hash,
+	e.hash,
+	tx.timestamp,
+	tx.amount,
+	tx.subunits,
+	tx.currency,
+	tx.status,
+	tx.comment
+FROM payment_event AS e
+LEFT JOIN payment_transaction AS tx ON
+	tx.project_id = e.project_id
+	AND
+	tx.payment_id = e.payment_id
+	AND
+	tx.timestamp = e.timestamp
+WHERE
+	e.project_id = ?
+ORDER BY e.id
+`
+
+// EventChainDB reads the event chain of the project in chain order
+//
+// The function will be called for every event with the payment transaction
+// recorded by the event. The transaction will be nil if it does not exist.
+func EventChainDB(ctx context.Context, db *sql.DB, projectID int64, f func(e *Event, paymentTx *PaymentTransaction) error) error {
+	rows, err := db.QueryContext(ctx, selectEventChain, projectID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &Event{}
+		var ts int64
+		var comment sql.NullString
+		var txTs, txAmount, txSubunits sql.NullInt64
+		var txCurrency, txStatus, txComment sql.NullString
+		err = rows.Scan(
+			&e.ID,
+			&e.ProjectID,
+			&e.PaymentID,
+			&ts,
+			&e.Amount,
+			&e.Subunits,
+			&e.Currency,
+			&e.Status,
+			&comment,
+			&e.PrevHash,
+			&e.Hash,
+			&txTs,
+			&txAmount,
+			&txSubunits,
+			&txCurrency,
+			&txStatus,
+			&txComment,
+		)
+		if err != nil {
+			return err
+		}
+		e.Timestamp = time.Unix(0, ts)
+		e.Comment = comment.String
+		var paymentTx *PaymentTransaction
+		if txTs.Valid {
+			paymentTx = &PaymentTransaction{
+				Timestamp: time.Unix(0, txTs.Int64),
+				Amount:    txAmount.Int64,
+				Subunits:  int8(txSubunits.Int64),
+				Currency:  txCurrency.String,
+				Status:    PaymentTransactionStatus(txStatus.String),
+				Comment:   txComment,
+			}
+		}
+		err = f(e, paymentTx)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+const selectUnchainedTransactions = `
+SELECT
+	tx.payment_id
+FROM payment_transaction AS tx
+LEFT JOIN payment_event AS e ON
+	e.project_id = tx.project_id
+	AND
+	e.payment_id = tx.payment_id
+	AND
+	e.timestamp = tx.timestamp
+WHERE
+	tx.project_id = ?
+	AND
+	tx.timestamp >= ?
+	AND
+	e.id IS NULL
+ORDER BY tx.timestamp
+`
+
+// UnchainedTransactionsDB selects the payment IDs of transactions of the
+// project since the given time which are not recorded by an event
+func UnchainedTransactionsDB(ctx context.Context, db *sql.DB, projectID int64, since time.Time) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, selectUnchainedTransactions, projectID, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int64, 0)
+	var id int64
+	for rows.Next() {
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// VerifyEventChainDB checks the integrity of the event chain of the project
+//
+// Besides the chain itself, the recorded payment transactions are compared to
+// the events. Transactions since the first event without an event are
+// reported as missing.
+func VerifyEventChainDB(ctx context.Context, db *sql.DB, projectID int64) (*EventChainVerifier, error) {
+	v := NewEventChainVerifier()
+	var first time.Time
+	err := EventChainDB(ctx, db, projectID, func(e *Event, paymentTx *PaymentTransaction) error {
+		if first.IsZero() {
+			first = e.Timestamp
+		}
+		v.Verify(e, paymentTx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if v.Events == 0 {
+		return v, nil
+	}
+	ids, err := UnchainedTransactionsDB(ctx, db, projectID, first)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		v.Missing(id)
+	}
+	return v, nil
+}
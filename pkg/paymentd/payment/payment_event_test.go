@@ -0,0 +1,101 @@
+package payment
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEventChain(t *testing.T) {
+	Convey("Given a chain of payment events", t, func() {
+		p := &Payment{projectID: 1, id: 42}
+		txs := make([]*PaymentTransaction, 3)
+		events := make([]*Event, 3)
+		prevHash := GenesisHash
+		for i := range txs {
+			txs[i] = &PaymentTransaction{
+				Payment:   p,
+				Timestamp: time.Unix(0, int64(1425000000000000000+i)),
+				Amount:    1234,
+				Subunits:  2,
+				Currency:  "EUR",
+				Status:    PaymentStatusOpen,
+				Comment:   sql.NullString{String: "comment", Valid: true},
+			}
+			events[i] = NewEvent(txs[i])
+			events[i].ID = int64(i + 1)
+			events[i].Chain(prevHash)
+			prevHash = events[i].Hash
+		}
+		v := NewEventChainVerifier()
+
+		Convey("The first event should be linked to the genesis hash", func() {
+			So(events[0].PrevHash, ShouldEqual, GenesisHash)
+			So(len(events[0].Hash), ShouldEqual, 64)
+		})
+
+		Convey("When verifying the unmodified chain", func() {
+			for i := range events {
+				v.Verify(events[i], txs[i])
+			}
+
+			Convey("It should be valid", func() {
+				So(v.Valid(), ShouldBeTrue)
+				So(v.Events, ShouldEqual, 3)
+				So(v.Head(), ShouldEqual, events[2].Hash)
+			})
+		})
+
+		Convey("When an event was modified", func() {
+			events[1].Amount = 1
+			for i := range events {
+				v.Verify(events[i], txs[i])
+			}
+
+			Convey("It should report the modified event", func() {
+				So(v.Valid(), ShouldBeFalse)
+				So(v.Violations[0].EventID, ShouldEqual, 2)
+				So(v.Violations[0].Reason, ShouldEqual, ChainViolationHash)
+			})
+		})
+
+		Convey("When an event was removed", func() {
+			v.Verify(events[0], txs[0])
+			v.Verify(events[2], txs[2])
+
+			Convey("It should report the broken link", func() {
+				So(v.Valid(), ShouldBeFalse)
+				So(len(v.Violations), ShouldEqual, 1)
+				So(v.Violations[0].EventID, ShouldEqual, 3)
+				So(v.Violations[0].Reason, ShouldEqual, ChainViolationLink)
+			})
+		})
+
+		Convey("When a transaction was modified", func() {
+			txs[2].Status = PaymentStatusPaid
+			for i := range events {
+				v.Verify(events[i], txs[i])
+			}
+
+			Convey("It should report the event of the transaction", func() {
+				So(len(v.Violations), ShouldEqual, 1)
+				So(v.Violations[0].EventID, ShouldEqual, 3)
+				So(v.Violations[0].Reason, ShouldEqual, ChainViolationTransaction)
+			})
+		})
+
+		Convey("When a transaction was removed", func() {
+			v.Verify(events[0], txs[0])
+			v.Verify(events[1], nil)
+			v.Verify(events[2], txs[2])
+
+			Convey("It should report the event of the transaction", func() {
+				So(len(v.Violations), ShouldEqual, 1)
+				So(v.Violations[0].EventID, ShouldEqual, 2)
+				So(v.Violations[0].Reason, ShouldEqual, ChainViolationRemoved)
+			})
+		})
+	})
+}
@@ -0,0 +1,147 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Configs, metadata and transactions of a payment are append-only. The state of
+// a payment at a point in time is therefore given by the latest entries with a
+// timestamp not after that point in time.
+
+const selectPaymentAt = selectPaymentFields + `
+FROM payment AS p
+LEFT JOIN payment_config AS c ON
+	c.project_id = p.project_id
+	AND
+	c.payment_id = p.id
+	AND
+	c.timestamp = (
+		SELECT MAX(timestamp) FROM payment_config
+		WHERE
+			project_id = c.project_id
+			AND
+			payment_id = c.payment_id
+			AND
+			timestamp <= ?
+	)
+LEFT JOIN payment_transaction AS tx ON
+	tx.project_id = p.project_id
+	AND
+	tx.payment_id = p.id
+	AND
+	tx.timestamp = (
+		SELECT MAX(timestamp) FROM payment_transaction
+		WHERE
+			project_id = tx.project_id
+			AND
+			payment_id = tx.payment_id
+			AND
+			timestamp <= ?
+	)
+WHERE
+	p.project_id = ?
+	AND
+	p.id = ?
+`
+
+func scanPaymentAt(row *sql.Row, at time.Time) (*Payment, error) {
+	p, err := scanSingleRow(row)
+	if err != nil {
+		return p, err
+	}
+	if p.Created.After(at) {
+		return nil, ErrPaymentNotFound
+	}
+	return p, nil
+}
+
+// PaymentByIDAtDB returns the state of the payment at the given time
+//
+// The config and the status of the returned payment are the ones which were in
+// effect at the given time. If the payment was created after the given time,
+// ErrPaymentNotFound will be returned.
+func PaymentByIDAtDB(ctx context.Context, db *sql.DB, id PaymentID, at time.Time) (*Payment, error) {
+	ts := at.UnixNano()
+	row := db.QueryRowContext(ctx, selectPaymentAt, ts, ts, id.ProjectID, id.PaymentID)
+	return scanPaymentAt(row, at)
+}
+
+// PaymentByIDAtTx returns the state of the payment at the given time
+//
+// See PaymentByIDAtDB
+func PaymentByIDAtTx(ctx context.Context, db *sql.Tx, id PaymentID, at time.Time) (*Payment, error) {
+	ts := at.UnixNano()
+	row := db.QueryRowContext(ctx, selectPaymentAt, ts, ts, id.ProjectID, id.PaymentID)
+	return scanPaymentAt(row, at)
+}
+
+const selectPaymentMetadataAt = `
+SELECT
+	m.name,
+	m.value,
+	m.timestamp
+FROM payment_metadata AS m
+WHERE
+	m.project_id = ?
+	AND
+	m.payment_id = ?
+	AND
+	m.timestamp = (
+		SELECT MAX(timestamp) FROM payment_metadata
+		WHERE
+			project_id = m.project_id
+			AND
+			payment_id = m.payment_id
+			AND
+			timestamp <= ?
+	)
+`
+
+func scanPaymentMetadataAt(rows *sql.Rows, p *Payment) (time.Time, error) {
+	var err error
+	var version time.Time
+	meta := make(map[string]string)
+	var k, v string
+	var ts int64
+	for rows.Next() {
+		err = rows.Scan(&k, &v, &ts)
+		if err != nil {
+			rows.Close()
+			return version, err
+		}
+		meta[k] = v
+		version = time.Unix(0, ts)
+	}
+	p.Metadata = meta
+	err = rows.Err()
+	rows.Close()
+	return version, err
+}
+
+// PaymentMetadataAtDB sets the metadata of the payment, which was in effect at
+// the given time
+//
+// It returns the time the metadata was written. The returned time is zero if
+// the payment had no metadata at the given time.
+func PaymentMetadataAtDB(ctx context.Context, db *sql.DB, p *Payment, at time.Time) (time.Time, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentMetadataAt, p.ProjectID(), p.ID(), at.UnixNano())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return scanPaymentMetadataAt(rows, p)
+}
+
+// PaymentMetadataAtTx sets the metadata of the payment, which was in effect at
+// the given time
+//
+// See PaymentMetadataAtDB
+func PaymentMetadataAtTx(ctx context.Context, db *sql.Tx, p *Payment, at time.Time) (time.Time, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentMetadataAt, p.ProjectID(), p.ID(), at.UnixNano())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return scanPaymentMetadataAt(rows, p)
+}
@@ -0,0 +1,33 @@
+package payment
+
+import (
+	"time"
+)
+
+// IntentRejection is the record of a rejected intent
+//
+// Intents are rejected if the payment is not in a status allowing the intended
+// status change or if its payment method cannot be used. Rejections are kept
+// as an audit trail of the attempted changes.
+type IntentRejection struct {
+	Timestamp time.Time
+	// Intent is the status the payment should have been changed to
+	Intent PaymentTransactionStatus
+	// Status is the status of the payment at the time of the rejection
+	Status PaymentTransactionStatus
+	// Reason is the error the intent was rejected with
+	Reason string
+	// CreatedBy identifies the component which requested the intent
+	CreatedBy string
+}
+
+// NewIntentRejection creates a new record of a rejected intent of the payment
+func (p *Payment) NewIntentRejection(intent PaymentTransactionStatus, reason, createdBy string) *IntentRejection {
+	return &IntentRejection{
+		Timestamp: time.Now(),
+		Intent:    intent,
+		Status:    p.Status,
+		Reason:    reason,
+		CreatedBy: createdBy,
+	}
+}
@@ -0,0 +1,75 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const insertPaymentIntentRejection = `
+INSERT INTO payment_intent_rejection
+(project_id, payment_id, timestamp, intent, status, reason, created_by)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertPaymentIntentRejectionDB saves the record of a rejected intent of the
+// payment
+func InsertPaymentIntentRejectionDB(ctx context.Context, db *sql.DB, p *Payment, r *IntentRejection) error {
+	var status sql.NullString
+	if r.Status != PaymentStatusNone {
+		status.String, status.Valid = r.Status.String(), true
+	}
+	_, err := db.ExecContext(ctx, insertPaymentIntentRejection,
+		p.ProjectID(),
+		p.ID(),
+		r.Timestamp.UnixNano(),
+		r.Intent.String(),
+		status,
+		r.Reason,
+		r.CreatedBy,
+	)
+	return err
+}
+
+const selectPaymentIntentRejections = `
+SELECT
+	timestamp,
+	intent,
+	status,
+	reason,
+	created_by
+FROM payment_intent_rejection
+WHERE
+	project_id = ?
+	AND
+	payment_id = ?
+ORDER BY timestamp
+`
+
+// PaymentIntentRejectionsDB selects the rejected intents of the payment, oldest
+// first
+func PaymentIntentRejectionsDB(ctx context.Context, db *sql.DB, p *Payment) ([]*IntentRejection, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentIntentRejections, p.ProjectID(), p.ID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rejections := make([]*IntentRejection, 0)
+	for rows.Next() {
+		r := &IntentRejection{}
+		var ts int64
+		var intent string
+		var status sql.NullString
+		err = rows.Scan(&ts, &intent, &status, &r.Reason, &r.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		r.Timestamp = time.Unix(0, ts)
+		r.Intent = PaymentTransactionStatus(intent)
+		r.Status = PaymentTransactionStatus(status.String)
+		rejections = append(rejections, r)
+	}
+	return rejections, rows.Err()
+}
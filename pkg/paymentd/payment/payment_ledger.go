@@ -0,0 +1,89 @@
+package payment
+
+import (
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+)
+
+// Ledger aggregates the transactions of a payment by the state of the amounts
+//
+// All amounts are positive and in the subunits of the payment. Only
+// transactions in the currency of the payment are taken into account.
+type Ledger struct {
+	Currency string
+	Subunits int8
+	// Open is the part of the payment amount which is neither authorized nor
+	// captured yet
+	Open int64
+	// Authorized is the part of the authorized amount which can still be
+	// captured
+	Authorized int64
+	// Captured is the amount captured or received
+	Captured int64
+	// Refunded is the amount refunded, less reversed refunds
+	Refunded int64
+	// ChargedBack is the amount withdrawn by chargebacks, less reversed
+	// chargebacks
+	ChargedBack int64
+}
+
+// NewLedger aggregates the given transactions of the payment
+//
+// The authorization of the payment should be loaded.
+func NewLedger(p *Payment, txs PaymentTransactionList) *Ledger {
+	l := &Ledger{
+		Currency: p.Currency,
+		Subunits: p.Subunits,
+	}
+	for _, tx := range txs {
+		if tx.Currency != p.Currency {
+			continue
+		}
+		switch tx.Status {
+		case PaymentStatusPaid,
+			PaymentStatusPartiallyPaid,
+			PaymentStatusPartiallyCaptured:
+			if tx.Amount > 0 {
+				l.Captured += tx.Amount
+			}
+		case PaymentStatusRefunded,
+			PaymentStatusPartiallyRefunded,
+			PaymentStatusRefundReversed:
+			l.Refunded -= tx.Amount
+		case PaymentStatusChargebackReceived,
+			PaymentStatusChargebackReversed,
+			PaymentStatusChargebackLost:
+			l.ChargedBack -= tx.Amount
+		}
+	}
+	switch p.Status {
+	case PaymentStatusOpen,
+		PaymentStatusPending,
+		PaymentStatusHeld,
+		PaymentStatusPartiallyPaid:
+		l.Open = positive(p.Amount - l.Captured)
+	case PaymentStatusAuthorized,
+		PaymentStatusPartiallyCaptured:
+		l.Authorized = positive(p.AuthorizedAmount() - l.Captured)
+	}
+	return l
+}
+
+func positive(units int64) int64 {
+	if units < 0 {
+		return 0
+	}
+	return units
+}
+
+// Net returns the captured amount less refunds and chargebacks
+func (l *Ledger) Net() int64 {
+	return l.Captured - l.Refunded - l.ChargedBack
+}
+
+// Decimal returns the decimal representation of an amount of the ledger
+func (l *Ledger) Decimal(units int64) *decimal.Decimal {
+	d := dec.NewDecInt64(units)
+	d.SetScale(dec.Scale(l.Subunits))
+	return &decimal.Decimal{Dec: *d}
+}
@@ -0,0 +1,78 @@
+package payment
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLedger(t *testing.T) {
+	Convey("Given a payment of 100.00 EUR", t, func() {
+		p := &Payment{
+			Amount:   10000,
+			Subunits: 2,
+			Currency: "EUR",
+			Status:   PaymentStatusOpen,
+		}
+		txs := PaymentTransactionList{
+			{Amount: -10000, Subunits: 2, Currency: "EUR", Status: PaymentStatusOpen},
+		}
+		add := func(status PaymentTransactionStatus, amount int64) {
+			txs = append(txs, &PaymentTransaction{Amount: amount, Subunits: 2, Currency: "EUR", Status: status})
+			p.Status = status
+		}
+
+		Convey("When the payment is open", func() {
+			l := NewLedger(p, txs)
+			Convey("The payment amount should be open", func() {
+				So(l.Open, ShouldEqual, 10000)
+				So(l.Authorized, ShouldEqual, 0)
+				So(l.Captured, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the payment is authorized for 115.00 EUR and captured in part", func() {
+			p.NewAuthorization(11500)
+			add(PaymentStatusAuthorized, 0)
+			add(PaymentStatusPartiallyCaptured, 4000)
+			l := NewLedger(p, txs)
+			Convey("The rest of the authorization should be authorized", func() {
+				So(l.Open, ShouldEqual, 0)
+				So(l.Authorized, ShouldEqual, 7500)
+				So(l.Captured, ShouldEqual, 4000)
+			})
+		})
+
+		Convey("When the payment is paid, refunded in part and charged back", func() {
+			add(PaymentStatusPaid, 10000)
+			add(PaymentStatusPartiallyRefunded, -2000)
+			add(PaymentStatusChargebackReceived, -3000)
+			l := NewLedger(p, txs)
+			Convey("It should aggregate the amounts", func() {
+				So(l.Open, ShouldEqual, 0)
+				So(l.Captured, ShouldEqual, 10000)
+				So(l.Refunded, ShouldEqual, 2000)
+				So(l.ChargedBack, ShouldEqual, 3000)
+				So(l.Net(), ShouldEqual, 5000)
+				So(l.Decimal(l.Net()).String(), ShouldEqual, "50.00")
+			})
+
+			Convey("When the chargeback is reversed", func() {
+				add(PaymentStatusChargebackReversed, 3000)
+				l := NewLedger(p, txs)
+				Convey("Nothing should be charged back", func() {
+					So(l.ChargedBack, ShouldEqual, 0)
+					So(l.Net(), ShouldEqual, 8000)
+				})
+			})
+		})
+
+		Convey("When a transaction is in another currency", func() {
+			txs = append(txs, &PaymentTransaction{Amount: 5000, Subunits: 2, Currency: "USD", Status: PaymentStatusPaid})
+			l := NewLedger(p, txs)
+			Convey("It should be ignored", func() {
+				So(l.Captured, ShouldEqual, 0)
+			})
+		})
+	})
+}
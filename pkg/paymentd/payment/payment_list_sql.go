@@ -0,0 +1,112 @@
+package payment
+
+import (
+	stdcontext "context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"golang.org/x/net/context"
+)
+
+// PaymentFilter holds the conditions of a payment list
+//
+// Empty fields do not restrict the list.
+type PaymentFilter struct {
+	ProjectID int64
+	// Status is the current status of the payments. Payments without
+	// transactions have the status PaymentStatusNone
+	Status   PaymentTransactionStatus
+	Currency string
+	// CreatedFrom is the inclusive lower bound of the creation time
+	CreatedFrom time.Time
+	// CreatedUntil is the exclusive upper bound of the creation time
+	CreatedUntil time.Time
+	IdentPrefix  string
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// where returns the conditions of the filter and their arguments
+func (f PaymentFilter) where() (string, []interface{}) {
+	conds := []string{"p.project_id = ?"}
+	args := []interface{}{f.ProjectID}
+	if f.Status == PaymentStatusNone {
+		conds = append(conds, "tx.status IS NULL")
+	} else if f.Status != "" {
+		conds = append(conds, "tx.status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Currency != "" {
+		conds = append(conds, "p.currency = ?")
+		args = append(args, f.Currency)
+	}
+	if !f.CreatedFrom.IsZero() {
+		conds = append(conds, "p.created >= ?")
+		args = append(args, f.CreatedFrom)
+	}
+	if !f.CreatedUntil.IsZero() {
+		conds = append(conds, "p.created < ?")
+		args = append(args, f.CreatedUntil)
+	}
+	if f.IdentPrefix != "" {
+		conds = append(conds, "p.ident LIKE ?")
+		args = append(args, likeEscaper.Replace(f.IdentPrefix)+"%")
+	}
+	return strings.Join(conds, "\n\tAND\n\t"), args
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx stdcontext.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// PaymentListDB selects a page of the payments matching the filter
+//
+// See PaymentListing for the sortable fields.
+func PaymentListDB(ctx context.Context, db *sql.DB, f PaymentFilter, q *listing.Query) ([]*Payment, listing.Page, error) {
+	return paymentList(ctx, db, f, q)
+}
+
+// PaymentListTx selects a page of the payments matching the filter
+//
+// See PaymentListing for the sortable fields.
+func PaymentListTx(ctx context.Context, db *sql.Tx, f PaymentFilter, q *listing.Query) ([]*Payment, listing.Page, error) {
+	return paymentList(ctx, db, f, q)
+}
+
+func paymentList(ctx context.Context, db queryer, f PaymentFilter, q *listing.Query) ([]*Payment, listing.Page, error) {
+	sortField, err := PaymentListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	where, args := f.where()
+	query, args, err := PaymentListing.Build(q, where, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	payments := make([]*Payment, 0, q.Limit+1)
+	for rows.Next() {
+		p, err := scanSingleRow(rows)
+		if err != nil {
+			rows.Close()
+			return nil, listing.Page{}, err
+		}
+		payments = append(payments, p)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(payments), func(i int) listing.Cursor {
+		return paymentCursor(sortField, payments[i])
+	})
+	return payments[:n], page, nil
+}
@@ -0,0 +1,48 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPaymentFilter(t *testing.T) {
+	Convey("Given an empty payment filter", t, func() {
+		f := PaymentFilter{ProjectID: 1}
+
+		Convey("It should only restrict the project", func() {
+			where, args := f.where()
+			So(where, ShouldEqual, "p.project_id = ?")
+			So(args, ShouldResemble, []interface{}{int64(1)})
+		})
+
+		Convey("When all conditions are set", func() {
+			from := time.Unix(1418135200, 0)
+			until := from.Add(24 * time.Hour)
+			f.Status = PaymentStatusPaid
+			f.Currency = "EUR"
+			f.CreatedFrom = from
+			f.CreatedUntil = until
+			f.IdentPrefix = "order_1%"
+
+			Convey("They should be joined", func() {
+				where, args := f.where()
+				So(where, ShouldContainSubstring, "tx.status = ?")
+				So(where, ShouldContainSubstring, "p.created >= ?")
+				So(where, ShouldContainSubstring, "p.created < ?")
+				So(args, ShouldResemble, []interface{}{int64(1), PaymentTransactionStatus(PaymentStatusPaid), "EUR", from, until, `order\_1\%%`})
+			})
+		})
+
+		Convey("When filtering uninitialized payments", func() {
+			f.Status = PaymentStatusNone
+
+			Convey("Payments without transactions should match", func() {
+				where, args := f.where()
+				So(where, ShouldContainSubstring, "tx.status IS NULL")
+				So(len(args), ShouldEqual, 1)
+			})
+		})
+	})
+}
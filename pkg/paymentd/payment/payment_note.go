@@ -0,0 +1,35 @@
+package payment
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+// NoteMaxLen is the maximum length of a payment note in characters
+const NoteMaxLen = 1000
+
+// Note is the free-text note of a payment
+//
+// Unlike metadata, the note is meant to be shown to humans, like an order
+// description on receipts and statements.
+type Note struct {
+	Timestamp time.Time
+	Text      string
+	// CreatedBy is the user who set the note. It is empty for notes which were
+	// set when the payment was initialized
+	CreatedBy string
+}
+
+// ValidNote returns true if the given text can be used as a payment note
+func ValidNote(text string) bool {
+	return utf8.ValidString(text) && utf8.RuneCountInString(text) <= NoteMaxLen
+}
+
+// NewNote creates a new note for the payment
+func (p *Payment) NewNote(text, createdBy string) *Note {
+	return &Note{
+		Timestamp: time.Now(),
+		Text:      text,
+		CreatedBy: createdBy,
+	}
+}
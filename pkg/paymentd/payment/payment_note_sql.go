@@ -0,0 +1,91 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const insertPaymentNote = `
+INSERT INTO payment_note
+(project_id, payment_id, timestamp, note, created_by)
+VALUES
+(?, ?, ?, ?, ?)
+`
+
+// InsertPaymentNoteTx saves the note of the payment
+//
+// It is a no-op if the payment has no note.
+func InsertPaymentNoteTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	if p.Note == nil {
+		return nil
+	}
+	stmt, err := db.PrepareContext(ctx, insertPaymentNote)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		p.ProjectID(),
+		p.ID(),
+		p.Note.Timestamp.UnixNano(),
+		p.Note.Text,
+		p.Note.CreatedBy,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentNote = `
+SELECT
+	n.timestamp,
+	n.note,
+	n.created_by
+FROM payment_note AS n
+WHERE
+	n.project_id = ?
+	AND
+	n.payment_id = ?
+	AND
+	n.timestamp = (
+		SELECT MAX(timestamp) FROM payment_note
+		WHERE
+			project_id = n.project_id
+			AND
+			payment_id = n.payment_id
+	)
+`
+
+func scanPaymentNote(row *sql.Row, p *Payment) error {
+	n := &Note{}
+	var ts int64
+	err := row.Scan(
+		&ts,
+		&n.Text,
+		&n.CreatedBy,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			p.Note = nil
+			return nil
+		}
+		return err
+	}
+	n.Timestamp = time.Unix(0, ts)
+	p.Note = n
+	return nil
+}
+
+// PaymentNoteDB loads the current note of the payment
+//
+// The note of payments without a note will be nil.
+func PaymentNoteDB(ctx context.Context, db *sql.DB, p *Payment) error {
+	return scanPaymentNote(db.QueryRowContext(ctx, selectPaymentNote, p.ProjectID(), p.ID()), p)
+}
+
+// PaymentNoteTx loads the current note of the payment
+//
+// The note of payments without a note will be nil.
+func PaymentNoteTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	return scanPaymentNote(db.QueryRowContext(ctx, selectPaymentNote, p.ProjectID(), p.ID()), p)
+}
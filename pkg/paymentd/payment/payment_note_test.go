@@ -0,0 +1,24 @@
+package payment_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidNote(t *testing.T) {
+	Convey("Given payment notes", t, func() {
+		Convey("An empty note should be valid", func() {
+			So(payment.ValidNote(""), ShouldBeTrue)
+		})
+		Convey("The maximum length should be counted in characters", func() {
+			So(payment.ValidNote(strings.Repeat("ä", payment.NoteMaxLen)), ShouldBeTrue)
+			So(payment.ValidNote(strings.Repeat("a", payment.NoteMaxLen+1)), ShouldBeFalse)
+		})
+		Convey("Invalid UTF-8 should not be valid", func() {
+			So(payment.ValidNote("order \xff"), ShouldBeFalse)
+		})
+	})
+}
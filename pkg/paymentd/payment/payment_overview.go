@@ -0,0 +1,129 @@
+package payment
+
+import (
+	"encoding/json"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+)
+
+// Overview is the denormalized read model of a payment
+//
+// Overviews are projected from the change feed. They hold the latest status and
+// the amounts of a payment in a single row, so that listings and reports do not
+// have to join the transactional tables.
+type Overview struct {
+	ProjectID int64
+	PaymentID int64
+	Ident     string
+	Created   time.Time
+	Amount    int64
+	Subunits  int8
+	Currency  string
+	// Status is the status of the latest transaction. It is empty for payments
+	// without a transaction
+	Status          PaymentTransactionStatus
+	StatusTimestamp time.Time
+	// Balance is the sum of the transaction amounts in the currency of the
+	// payment. It is negative while the payment is not fully paid
+	Balance int64
+	// Held is the amount held in escrow. Held funds are not eligible for
+	// payout
+	Held            int64
+	PaymentMethodID int64
+	// Provider is the name of the provider of the payment method
+	Provider string
+	Country  string
+	// Sequence is the sequence number of the latest change in the overview
+	Sequence int64
+}
+
+// NewOverview creates an empty overview of the payment of the change
+func NewOverview(c *Change) *Overview {
+	return &Overview{
+		ProjectID: c.ProjectID,
+		PaymentID: c.PaymentID,
+	}
+}
+
+// Decimal returns the amount of the payment
+func (o *Overview) Decimal() *decimal.Decimal {
+	d := dec.NewDecInt64(o.Amount)
+	d.SetScale(dec.Scale(o.Subunits))
+	return &decimal.Decimal{Dec: *d}
+}
+
+// BalanceDecimal returns the balance of the payment
+func (o *Overview) BalanceDecimal() *decimal.Decimal {
+	d := dec.NewDecInt64(o.Balance)
+	d.SetScale(dec.Scale(o.Subunits))
+	return &decimal.Decimal{Dec: *d}
+}
+
+// HeldDecimal returns the amount held in escrow
+func (o *Overview) HeldDecimal() *decimal.Decimal {
+	d := dec.NewDecInt64(o.Held)
+	d.SetScale(dec.Scale(o.Subunits))
+	return &decimal.Decimal{Dec: *d}
+}
+
+// Apply applies the change to the overview
+//
+// Changes which are already part of the overview, i.e. whose sequence number
+// is not higher than the sequence of the overview, will be ignored. The
+// returned bool reports whether the payment method changed, in which case the
+// provider should be resolved again.
+func (o *Overview) Apply(c *Change) (methodChanged bool, err error) {
+	if c.Sequence != 0 && c.Sequence <= o.Sequence {
+		return false, nil
+	}
+	switch c.Type {
+	case ChangeTypeCreated:
+		var data changePayment
+		err = json.Unmarshal(c.Data, &data)
+		if err != nil {
+			return false, err
+		}
+		o.Ident = data.Ident
+		o.Created = data.Created
+		o.Amount = data.Amount
+		o.Subunits = data.Subunits
+		o.Currency = data.Currency
+	case ChangeTypeConfig:
+		var data changeConfig
+		err = json.Unmarshal(c.Data, &data)
+		if err != nil {
+			return false, err
+		}
+		methodChanged = data.PaymentMethodId != o.PaymentMethodID
+		o.PaymentMethodID = data.PaymentMethodId
+		o.Country = data.Country
+	case ChangeTypeTransaction:
+		var data changeTransaction
+		err = json.Unmarshal(c.Data, &data)
+		if err != nil {
+			return false, err
+		}
+		ts := time.Unix(0, data.Timestamp)
+		if !ts.Before(o.StatusTimestamp) {
+			o.Status = PaymentTransactionStatus(data.Status)
+			o.StatusTimestamp = ts
+		}
+		if data.Currency == o.Currency {
+			o.Balance += data.Amount
+		}
+	case ChangeTypeEscrow:
+		var data changeEscrow
+		err = json.Unmarshal(c.Data, &data)
+		if err != nil {
+			return false, err
+		}
+		o.Held = 0
+		if data.Status == EscrowHeld {
+			o.Held = data.Amount
+		}
+	}
+	o.Sequence = c.Sequence
+	return methodChanged, nil
+}
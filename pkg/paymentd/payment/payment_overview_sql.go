@@ -0,0 +1,242 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrOverviewNotFound = errors.New("payment overview not found")
+)
+
+// OverviewListing is the listing of payment overviews
+var OverviewListing = listing.Builder{
+	Select:      selectOverview,
+	Key:         "ID",
+	DefaultSort: "ID",
+	Columns: map[string]string{
+		"ID":      "o.payment_id",
+		"Created": "o.created",
+	},
+}
+
+const selectOverview = `
+SELECT
+	o.project_id,
+	o.payment_id,
+	o.ident,
+	o.created,
+	o.amount,
+	o.subunits,
+	o.currency,
+	o.status,
+	o.status_timestamp,
+	o.balance,
+	o.held,
+	o.payment_method_id,
+	o.provider,
+	o.country,
+	o.sequence
+FROM payment_overview AS o
+`
+
+func scanOverview(row resultScanner) (*Overview, error) {
+	o := &Overview{}
+	var created, statusTs int64
+	var status, provider, country sql.NullString
+	var methodID sql.NullInt64
+	err := row.Scan(
+		&o.ProjectID,
+		&o.PaymentID,
+		&o.Ident,
+		&created,
+		&o.Amount,
+		&o.Subunits,
+		&o.Currency,
+		&status,
+		&statusTs,
+		&o.Balance,
+		&o.Held,
+		&methodID,
+		&provider,
+		&country,
+		&o.Sequence,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOverviewNotFound
+		}
+		return nil, err
+	}
+	o.Created = time.Unix(0, created)
+	if statusTs != 0 {
+		o.StatusTimestamp = time.Unix(0, statusTs)
+	}
+	o.Status = PaymentTransactionStatus(status.String)
+	o.PaymentMethodID = methodID.Int64
+	o.Provider = provider.String
+	o.Country = country.String
+	return o, nil
+}
+
+const selectOverviewByPaymentID = selectOverview + `
+WHERE
+	o.project_id = ?
+	AND
+	o.payment_id = ?
+`
+
+// PaymentOverviewTx selects the overview of the payment
+//
+// It returns an ErrOverviewNotFound if the payment was not projected yet.
+func PaymentOverviewTx(ctx context.Context, db *sql.Tx, projectID, paymentID int64) (*Overview, error) {
+	return scanOverview(db.QueryRowContext(ctx, selectOverviewByPaymentID, projectID, paymentID))
+}
+
+const insertOverview = `
+INSERT INTO payment_overview
+(project_id, payment_id, ident, created, amount, subunits, currency, status, status_timestamp, balance, held, payment_method_id, provider, country, sequence)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// overview columns replaced when the overview is saved again
+var overviewUpdateColumns = []string{
+	"ident",
+	"created",
+	"amount",
+	"subunits",
+	"currency",
+	"status",
+	"status_timestamp",
+	"balance",
+	"held",
+	"payment_method_id",
+	"provider",
+	"country",
+	"sequence",
+}
+
+// SavePaymentOverviewTx inserts or replaces the overview of the payment
+func SavePaymentOverviewTx(ctx context.Context, db *sql.Tx, o *Overview) error {
+	upsert := sqldialect.Default().Upsert([]string{"project_id", "payment_id"}, overviewUpdateColumns)
+	stmt, err := db.PrepareContext(ctx, insertOverview+upsert)
+	if err != nil {
+		return err
+	}
+	var status, provider, country sql.NullString
+	var methodID sql.NullInt64
+	var statusTs int64
+	if o.Status != "" {
+		status.String, status.Valid = o.Status.String(), true
+		statusTs = o.StatusTimestamp.UnixNano()
+	}
+	if o.PaymentMethodID != 0 {
+		methodID.Int64, methodID.Valid = o.PaymentMethodID, true
+	}
+	if o.Provider != "" {
+		provider.String, provider.Valid = o.Provider, true
+	}
+	if o.Country != "" {
+		country.String, country.Valid = o.Country, true
+	}
+	_, err = stmt.ExecContext(ctx,
+		o.ProjectID,
+		o.PaymentID,
+		o.Ident,
+		o.Created.UnixNano(),
+		o.Amount,
+		o.Subunits,
+		o.Currency,
+		status,
+		statusTs,
+		o.Balance,
+		o.Held,
+		methodID,
+		provider,
+		country,
+		o.Sequence,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectOverviewSequence = `
+SELECT COALESCE(MAX(sequence), 0) FROM payment_overview
+`
+
+// OverviewSequenceDB selects the sequence number of the latest change which was
+// projected to the overviews
+func OverviewSequenceDB(ctx context.Context, db *sql.DB) (int64, error) {
+	var seq int64
+	err := db.QueryRowContext(ctx, selectOverviewSequence).Scan(&seq)
+	return seq, err
+}
+
+func overviewCursor(sortField string, o *Overview) listing.Cursor {
+	c := listing.Cursor{Key: strconv.FormatInt(o.PaymentID, 10)}
+	if sortField == "Created" {
+		c.Sort = strconv.FormatInt(o.Created.UnixNano(), 10)
+	}
+	return c
+}
+
+const whereOverviewProject = `
+o.project_id = ?
+`
+
+const whereOverviewProjectStatus = `
+o.project_id = ?
+AND
+o.status = ?
+`
+
+// PaymentOverviewsDB selects a page of the payment overviews of the given
+// project
+//
+// If status is not empty, only payments with the given current status will be
+// selected.
+func PaymentOverviewsDB(ctx context.Context, db *sql.DB, projectID int64, status PaymentTransactionStatus, q *listing.Query) ([]*Overview, listing.Page, error) {
+	sortField, err := OverviewListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	var query string
+	var args []interface{}
+	if status == "" {
+		query, args, err = OverviewListing.Build(q, whereOverviewProject, projectID)
+	} else {
+		query, args, err = OverviewListing.Build(q, whereOverviewProjectStatus, projectID, status.String())
+	}
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	overviews := make([]*Overview, 0, q.Limit+1)
+	for rows.Next() {
+		o, err := scanOverview(rows)
+		if err != nil {
+			rows.Close()
+			return nil, listing.Page{}, err
+		}
+		overviews = append(overviews, o)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(overviews), func(i int) listing.Cursor {
+		return overviewCursor(sortField, overviews[i])
+	})
+	return overviews[:n], page, nil
+}
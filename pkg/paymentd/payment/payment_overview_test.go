@@ -0,0 +1,74 @@
+package payment_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOverview(t *testing.T) {
+	Convey("Given the changes of a paid payment", t, func() {
+		p := &payment.Payment{
+			Ident:    "order-1",
+			Created:  time.Unix(1400000000, 0),
+			Amount:   1234,
+			Subunits: 2,
+			Currency: "EUR",
+		}
+		p.Config.PaymentMethodID = sql.NullInt64{Int64: 7, Valid: true}
+		p.Config.Country = sql.NullString{String: "DE", Valid: true}
+		open := p.NewTransaction(payment.PaymentStatusOpen)
+		open.Amount = -1234
+		open.Timestamp = time.Unix(0, 1000)
+		paid := p.NewTransaction(payment.PaymentStatusPaid)
+		paid.Timestamp = time.Unix(0, 2000)
+
+		var changes []*payment.Change
+		add := func(c *payment.Change, err error) {
+			So(err, ShouldBeNil)
+			c.Sequence = int64(len(changes) + 1)
+			changes = append(changes, c)
+		}
+		add(payment.NewCreatedChange(p))
+		add(payment.NewConfigChange(p))
+		add(payment.NewTransactionChange(open))
+		add(payment.NewTransactionChange(paid))
+
+		Convey("When the changes are applied", func() {
+			o := payment.NewOverview(changes[0])
+			var methodChanged bool
+			for _, c := range changes {
+				changed, err := o.Apply(c)
+				So(err, ShouldBeNil)
+				methodChanged = methodChanged || changed
+			}
+
+			Convey("The overview should hold the latest state of the payment", func() {
+				So(o.Ident, ShouldEqual, "order-1")
+				So(o.Decimal().String(), ShouldEqual, "12.34")
+				So(o.Status, ShouldEqual, payment.PaymentStatusPaid)
+				So(o.StatusTimestamp.UnixNano(), ShouldEqual, 2000)
+				So(o.Balance, ShouldEqual, 0)
+				So(o.PaymentMethodID, ShouldEqual, 7)
+				So(o.Country, ShouldEqual, "DE")
+				So(o.Sequence, ShouldEqual, 4)
+			})
+			Convey("The payment method should be reported as changed", func() {
+				So(methodChanged, ShouldBeTrue)
+			})
+
+			Convey("When a change is delivered again", func() {
+				_, err := o.Apply(changes[3])
+				So(err, ShouldBeNil)
+
+				Convey("It should be ignored", func() {
+					So(o.Balance, ShouldEqual, 0)
+					So(o.Sequence, ShouldEqual, 4)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,158 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"golang.org/x/net/context"
+)
+
+const insertReferenceSequence = `
+INSERT INTO payment_reference_sequence
+(project_id, sequence)
+VALUES
+(?, LAST_INSERT_ID(1))
+ON DUPLICATE KEY UPDATE
+	sequence = LAST_INSERT_ID(sequence + 1)
+`
+
+// PostgreSQL has no LAST_INSERT_ID(), the sequence is returned instead
+const insertReferenceSequenceReturning = `
+INSERT INTO payment_reference_sequence
+(project_id, sequence)
+VALUES
+(?, 1)
+ON CONFLICT (project_id) DO UPDATE SET
+	sequence = payment_reference_sequence.sequence + 1
+RETURNING sequence
+`
+
+// NextReferenceSequenceTx allocates the next reference sequence number of the
+// project
+//
+// The sequence row stays locked until the transaction ends, so concurrent
+// payments of the project will not be assigned the same number. Sequences start
+// at 1.
+func NextReferenceSequenceTx(ctx context.Context, db *sql.Tx, projectID int64) (int64, error) {
+	if sqldialect.Default() == sqldialect.PostgreSQL {
+		var seq int64
+		err := db.QueryRowContext(ctx, insertReferenceSequenceReturning, projectID).Scan(&seq)
+		return seq, err
+	}
+	res, err := db.ExecContext(ctx, insertReferenceSequence, projectID)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+const insertPaymentReference = `
+INSERT INTO payment_reference
+(project_id, payment_id, created, reference)
+VALUES
+(?, ?, ?, ?)
+`
+
+// InsertPaymentReferenceTx saves the reference number of the payment
+//
+// It is a no-op if the payment has no reference.
+func InsertPaymentReferenceTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	if p.Reference == "" {
+		return nil
+	}
+	stmt, err := db.PrepareContext(ctx, insertPaymentReference)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		p.ProjectID(),
+		p.ID(),
+		time.Now().UnixNano(),
+		p.Reference,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentReference = `
+SELECT
+	reference
+FROM payment_reference
+WHERE
+	project_id = ?
+	AND
+	payment_id = ?
+`
+
+func scanPaymentReference(row *sql.Row, p *Payment) error {
+	err := row.Scan(&p.Reference)
+	if err == sql.ErrNoRows {
+		p.Reference = ""
+		return nil
+	}
+	return err
+}
+
+// PaymentReferenceDB loads the reference number of the payment
+//
+// The reference of payments without a reference number will be empty.
+func PaymentReferenceDB(ctx context.Context, db *sql.DB, p *Payment) error {
+	return scanPaymentReference(db.QueryRowContext(ctx, selectPaymentReference, p.ProjectID(), p.ID()), p)
+}
+
+// PaymentReferenceTx loads the reference number of the payment
+//
+// The reference of payments without a reference number will be empty.
+func PaymentReferenceTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	return scanPaymentReference(db.QueryRowContext(ctx, selectPaymentReference, p.ProjectID(), p.ID()), p)
+}
+
+const selectPaymentIDsByReference = `
+SELECT
+	project_id,
+	payment_id
+FROM payment_reference
+WHERE
+	reference = ?
+`
+
+func scanPaymentIDs(rows *sql.Rows) ([]PaymentID, error) {
+	var ids []PaymentID
+	for rows.Next() {
+		var id PaymentID
+		err := rows.Scan(&id.ProjectID, &id.PaymentID)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err := rows.Err()
+	rows.Close()
+	return ids, err
+}
+
+// PaymentIDsByReferenceDB returns the IDs of the payments with the given
+// reference number
+//
+// References are unique within a project. Projects with the same reference
+// scheme can assign the same reference number, so more than one ID can be
+// returned.
+func PaymentIDsByReferenceDB(ctx context.Context, db *sql.DB, ref string) ([]PaymentID, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentIDsByReference, ref)
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentIDs(rows)
+}
+
+// PaymentIDsByReferenceTx returns the IDs of the payments with the given
+// reference number
+func PaymentIDsByReferenceTx(ctx context.Context, db *sql.Tx, ref string) ([]PaymentID, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentIDsByReference, ref)
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentIDs(rows)
+}
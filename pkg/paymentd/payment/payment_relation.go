@@ -0,0 +1,127 @@
+package payment
+
+import (
+	"fmt"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+)
+
+// Relation types
+const (
+	// RelationAmendment is an additional payment for an amended order, e.g. an
+	// additional charge after an order change
+	RelationAmendment = "amendment"
+	// RelationReplacement replaces a failed or cancelled parent payment
+	RelationReplacement = "replacement"
+	// RelationRecurring is a charge of a subscription, which was created for
+	// the initial payment of the subscription
+	RelationRecurring = "recurring"
+)
+
+const (
+	// OrderMaxPayments is the maximum number of payments which will be
+	// traversed for an order
+	OrderMaxPayments = 100
+)
+
+// ValidRelation returns true if the given relation type is known
+func ValidRelation(rel string) bool {
+	return rel == RelationAmendment || rel == RelationReplacement || rel == RelationRecurring
+}
+
+// Relation links a payment to its parent payment
+//
+// Relations are immutable. A payment can have at most one parent, which has to
+// belong to the same project.
+type Relation struct {
+	ParentID int64
+	Type     string
+	Created  time.Time
+}
+
+// SetParent links the payment to the given parent payment
+//
+// The parent has to belong to the project of the payment.
+func (p *Payment) SetParent(parentID PaymentID, rel string) error {
+	if parentID.ProjectID != p.ProjectID() {
+		return fmt.Errorf("parent payment of project %d", parentID.ProjectID)
+	}
+	if !ValidRelation(rel) {
+		return fmt.Errorf("invalid relation %s", rel)
+	}
+	p.Parent = &Relation{
+		ParentID: parentID.PaymentID,
+		Type:     rel,
+		Created:  time.Now(),
+	}
+	return nil
+}
+
+// ParentPaymentID returns the identifier of the parent payment
+//
+// It returns false if the payment has no parent.
+func (p *Payment) ParentPaymentID() (PaymentID, bool) {
+	if p.Parent == nil {
+		return PaymentID{}, false
+	}
+	return PaymentID{p.ProjectID(), p.Parent.ParentID}, true
+}
+
+// Order is a tree of related payments
+//
+// The root is the payment without a parent. All other payments are its direct
+// or indirect children.
+type Order struct {
+	// Payments holds the root payment first, followed by its descendants in
+	// breadth-first order
+	Payments []*Payment
+}
+
+// Root returns the root payment of the order
+func (o *Order) Root() *Payment {
+	return o.Payments[0]
+}
+
+// OrderTotal is the aggregation of the payments of an order in one currency
+type OrderTotal struct {
+	Currency string
+	Payments int
+	// Amount is the sum of all payment amounts
+	Amount *decimal.Decimal
+	// Paid is the sum of the amounts of paid payments
+	Paid *decimal.Decimal
+}
+
+// Totals returns the aggregated amounts of the order by currency, ordered by
+// first occurrence
+//
+// Verification payments do not carry any revenue and will not be included.
+func (o *Order) Totals() []OrderTotal {
+	totals := make([]OrderTotal, 0, 1)
+	idx := make(map[string]int)
+	for _, p := range o.Payments {
+		if p.IsVerification() {
+			continue
+		}
+		i, ok := idx[p.Currency]
+		if !ok {
+			i = len(totals)
+			idx[p.Currency] = i
+			totals = append(totals, OrderTotal{
+				Currency: p.Currency,
+				Amount:   &decimal.Decimal{Dec: *dec.NewDecInt64(0)},
+				Paid:     &decimal.Decimal{Dec: *dec.NewDecInt64(0)},
+			})
+		}
+		t := &totals[i]
+		t.Payments++
+		amount := &p.Decimal().Dec
+		t.Amount.Add(&t.Amount.Dec, amount)
+		if p.Status == PaymentStatusPaid {
+			t.Paid.Add(&t.Paid.Dec, amount)
+		}
+	}
+	return totals
+}
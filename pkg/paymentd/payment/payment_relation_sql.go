@@ -0,0 +1,164 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrRelationNotFound = errors.New("payment relation not found")
+	ErrOrderTooLarge    = errors.New("order exceeds maximum number of payments")
+)
+
+const insertPaymentRelation = `
+INSERT INTO payment_relation
+(project_id, payment_id, parent_id, relation, created)
+VALUES
+(?, ?, ?, ?, ?)
+`
+
+// InsertPaymentRelationTx saves the relation of the payment to its parent
+//
+// It is a no-op if the payment has no parent.
+func InsertPaymentRelationTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	if p.Parent == nil {
+		return nil
+	}
+	stmt, err := db.PrepareContext(ctx, insertPaymentRelation)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		p.ProjectID(),
+		p.ID(),
+		p.Parent.ParentID,
+		p.Parent.Type,
+		p.Parent.Created,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentRelation = `
+SELECT
+	parent_id,
+	relation,
+	created
+FROM payment_relation
+WHERE
+	project_id = ?
+	AND
+	payment_id = ?
+`
+
+// PaymentParentDB loads the relation of the payment to its parent
+//
+// The parent of payments without a parent will be nil.
+func PaymentParentDB(ctx context.Context, db *sql.DB, p *Payment) error {
+	r := &Relation{}
+	err := db.QueryRowContext(ctx, selectPaymentRelation, p.ProjectID(), p.ID()).Scan(
+		&r.ParentID,
+		&r.Type,
+		&r.Created,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			p.Parent = nil
+			return nil
+		}
+		return err
+	}
+	p.Parent = r
+	return nil
+}
+
+const selectPaymentChildren = `
+SELECT
+	payment_id,
+	relation,
+	created
+FROM payment_relation
+WHERE
+	project_id = ?
+	AND
+	parent_id = ?
+ORDER BY payment_id
+`
+
+// PaymentChildrenDB returns the direct children of the payment
+//
+// The parent relation of the returned payments will be set.
+func PaymentChildrenDB(ctx context.Context, db *sql.DB, p *Payment) ([]*Payment, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentChildren, p.ProjectID(), p.ID())
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0)
+	rels := make([]Relation, 0)
+	for rows.Next() {
+		var id int64
+		r := Relation{ParentID: p.ID()}
+		err = rows.Scan(&id, &r.Type, &r.Created)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		rels = append(rels, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	children := make([]*Payment, len(ids))
+	for i, id := range ids {
+		children[i], err = PaymentByIDDB(ctx, db, PaymentID{p.ProjectID(), id})
+		if err != nil {
+			return nil, err
+		}
+		children[i].Parent = &rels[i]
+	}
+	return children, nil
+}
+
+// OrderDB returns the order the payment belongs to
+//
+// The order is determined by traversing to the root payment and collecting all
+// of its descendants. An ErrOrderTooLarge will be returned if the order
+// contains more than OrderMaxPayments payments.
+func OrderDB(ctx context.Context, db *sql.DB, p *Payment) (*Order, error) {
+	root := p
+	var err error
+	for i := 0; ; i++ {
+		if i >= OrderMaxPayments {
+			return nil, ErrOrderTooLarge
+		}
+		err = PaymentParentDB(ctx, db, root)
+		if err != nil {
+			return nil, err
+		}
+		parentID, ok := root.ParentPaymentID()
+		if !ok {
+			break
+		}
+		root, err = PaymentByIDDB(ctx, db, parentID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	o := &Order{Payments: []*Payment{root}}
+	for i := 0; i < len(o.Payments); i++ {
+		children, err := PaymentChildrenDB(ctx, db, o.Payments[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(o.Payments)+len(children) > OrderMaxPayments {
+			return nil, ErrOrderTooLarge
+		}
+		o.Payments = append(o.Payments, children...)
+	}
+	return o, nil
+}
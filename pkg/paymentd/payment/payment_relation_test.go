@@ -0,0 +1,73 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPaymentRelation(t *testing.T) {
+	Convey("Given a payment of a project", t, func() {
+		p := &Payment{}
+		err := p.SetProject(&project.Project{ID: 1, PrincipalID: 1, Name: "test"})
+		So(err, ShouldBeNil)
+
+		Convey("When it is linked to a parent of the same project", func() {
+			err = p.SetParent(PaymentID{1, 42}, RelationReplacement)
+			Convey("It should have a parent", func() {
+				So(err, ShouldBeNil)
+				parentID, ok := p.ParentPaymentID()
+				So(ok, ShouldBeTrue)
+				So(parentID, ShouldResemble, PaymentID{1, 42})
+				So(p.Parent.Type, ShouldEqual, RelationReplacement)
+			})
+		})
+		Convey("When it is linked to a parent of another project", func() {
+			err = p.SetParent(PaymentID{2, 42}, RelationAmendment)
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				_, ok := p.ParentPaymentID()
+				So(ok, ShouldBeFalse)
+			})
+		})
+		Convey("When it is linked with an unknown relation", func() {
+			err = p.SetParent(PaymentID{1, 42}, "sibling")
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given an order with an amendment and a failed payment", t, func() {
+		o := &Order{Payments: []*Payment{
+			{id: 1, Amount: 10000, Subunits: 2, Currency: "EUR", Status: PaymentStatusPaid},
+			{id: 2, Amount: 1550, Subunits: 2, Currency: "EUR", Status: PaymentStatusPaid},
+			{id: 3, Amount: 500, Subunits: 2, Currency: "EUR", Status: PaymentStatusFailed},
+			{id: 4, Amount: 20, Subunits: 0, Currency: "USD", Status: PaymentStatusOpen},
+		}}
+		Convey("The totals should be aggregated by currency", func() {
+			totals := o.Totals()
+			So(len(totals), ShouldEqual, 2)
+			So(totals[0].Currency, ShouldEqual, "EUR")
+			So(totals[0].Payments, ShouldEqual, 3)
+			So(totals[0].Amount.String(), ShouldEqual, "120.50")
+			So(totals[0].Paid.String(), ShouldEqual, "115.50")
+			So(totals[1].Currency, ShouldEqual, "USD")
+			So(totals[1].Amount.String(), ShouldEqual, "20")
+			So(totals[1].Paid.String(), ShouldEqual, "0")
+		})
+		Convey("The root should be the first payment", func() {
+			So(o.Root().ID(), ShouldEqual, 1)
+		})
+		Convey("When a verification payment was added", func() {
+			o.Payments = append(o.Payments, &Payment{id: 5, Amount: 0, Subunits: 2, Currency: "GBP", Status: PaymentStatusVerified})
+
+			Convey("It should not be included in the totals", func() {
+				totals := o.Totals()
+				So(len(totals), ShouldEqual, 2)
+				So(totals[0].Payments, ShouldEqual, 3)
+			})
+		})
+	})
+}
@@ -0,0 +1,45 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Review statuses
+const (
+	// ReviewHeld payments are waiting for a manual review
+	ReviewHeld = "held"
+	// ReviewApproved payments were released for processing
+	ReviewApproved = "approved"
+	// ReviewDeclined payments were cancelled after the review
+	ReviewDeclined = "declined"
+)
+
+// Review represents a status change of the manual review of a payment
+//
+// A payment is put on hold when a risk check requests a manual review. The
+// review is finished when the payment is approved or declined.
+type Review struct {
+	Timestamp time.Time
+	Status    string
+	// Due is the time until which held payments should be reviewed
+	Due       time.Time
+	CreatedBy string
+	// Comment is the reason for holding the payment or the comment on the
+	// decision
+	Comment sql.NullString
+}
+
+// Overdue returns true if the payment is held beyond the due time
+func (r *Review) Overdue() bool {
+	return r.Status == ReviewHeld && !r.Due.IsZero() && r.Due.Before(time.Now())
+}
+
+// NewReview creates a new review status for the payment
+func (p *Payment) NewReview(status, createdBy string) *Review {
+	return &Review{
+		Timestamp: time.Now(),
+		Status:    status,
+		CreatedBy: createdBy,
+	}
+}
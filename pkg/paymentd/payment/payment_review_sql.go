@@ -0,0 +1,204 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrReviewNotFound = errors.New("payment review not found")
+)
+
+const insertPaymentReview = `
+INSERT INTO payment_review
+(project_id, payment_id, timestamp, status, due, created_by, comment)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertPaymentReviewTx saves a new review status of the payment
+func InsertPaymentReviewTx(ctx context.Context, db *sql.Tx, p *Payment, r *Review) error {
+	stmt, err := db.PrepareContext(ctx, insertPaymentReview)
+	if err != nil {
+		return err
+	}
+	var due sql.NullInt64
+	if !r.Due.IsZero() {
+		due.Int64, due.Valid = r.Due.UnixNano(), true
+	}
+	_, err = stmt.ExecContext(ctx,
+		p.ProjectID(),
+		p.ID(),
+		r.Timestamp.UnixNano(),
+		r.Status,
+		due,
+		r.CreatedBy,
+		r.Comment,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentReviewFrom = `
+SELECT
+	r.payment_id,
+	r.timestamp,
+	r.status,
+	r.due,
+	r.created_by,
+	r.comment
+FROM payment_review AS r
+`
+
+const selectPaymentReview = selectPaymentReviewFrom + `
+WHERE
+	r.project_id = ?
+`
+
+const paymentReviewCurrent = `
+r.timestamp = (
+	SELECT MAX(timestamp) FROM payment_review
+	WHERE
+		project_id = r.project_id
+		AND
+		payment_id = r.payment_id
+)
+`
+
+const selectPaymentReviewByPaymentID = selectPaymentReview + `
+	AND
+	r.payment_id = ?
+	AND
+` + paymentReviewCurrent
+
+// ReviewQueueListing is the listing of the review queue
+//
+// Payments held without a due time are sorted by the time they were held.
+var ReviewQueueListing = listing.Builder{
+	Select:      selectPaymentReviewFrom,
+	Where:       paymentReviewCurrent,
+	Key:         "ID",
+	DefaultSort: "Due",
+	Columns: map[string]string{
+		"ID":   "r.payment_id",
+		"Due":  "COALESCE(r.due, r.timestamp)",
+		"Held": "r.timestamp",
+	},
+}
+
+const whereReviewQueue = `
+r.project_id = ?
+AND
+r.status = ?
+`
+
+func reviewCursor(sortField string, paymentID int64, r *Review) listing.Cursor {
+	c := listing.Cursor{Key: strconv.FormatInt(paymentID, 10)}
+	switch sortField {
+	case "Due":
+		due := r.Due
+		if due.IsZero() {
+			due = r.Timestamp
+		}
+		c.Sort = strconv.FormatInt(due.UnixNano(), 10)
+	case "Held":
+		c.Sort = strconv.FormatInt(r.Timestamp.UnixNano(), 10)
+	}
+	return c
+}
+
+func scanReview(row resultScanner) (int64, *Review, error) {
+	r := &Review{}
+	var paymentID, ts int64
+	var due sql.NullInt64
+	err := row.Scan(
+		&paymentID,
+		&ts,
+		&r.Status,
+		&due,
+		&r.CreatedBy,
+		&r.Comment,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil, ErrReviewNotFound
+		}
+		return 0, nil, err
+	}
+	r.Timestamp = time.Unix(0, ts)
+	if due.Valid {
+		r.Due = time.Unix(0, due.Int64)
+	}
+	return paymentID, r, nil
+}
+
+// PaymentReviewCurrentDB selects the current review status of the payment
+//
+// It returns an ErrReviewNotFound if the payment was never held.
+func PaymentReviewCurrentDB(ctx context.Context, db *sql.DB, p *Payment) (*Review, error) {
+	_, r, err := scanReview(db.QueryRowContext(ctx, selectPaymentReviewByPaymentID, p.ProjectID(), p.ID()))
+	return r, err
+}
+
+// PaymentReviewCurrentTx selects the current review status of the payment
+//
+// It returns an ErrReviewNotFound if the payment was never held.
+func PaymentReviewCurrentTx(ctx context.Context, db *sql.Tx, p *Payment) (*Review, error) {
+	_, r, err := scanReview(db.QueryRowContext(ctx, selectPaymentReviewByPaymentID, p.ProjectID(), p.ID()))
+	return r, err
+}
+
+// HeldPayment is a payment in the review queue
+type HeldPayment struct {
+	Payment *Payment
+	Review  *Review
+}
+
+// ReviewQueueDB selects a page of the held payments of the project
+func ReviewQueueDB(ctx context.Context, db *sql.DB, projectID int64, q *listing.Query) ([]HeldPayment, listing.Page, error) {
+	sortField, err := ReviewQueueListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	query, args, err := ReviewQueueListing.Build(q, whereReviewQueue, projectID, ReviewHeld)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	queue := make([]HeldPayment, 0, q.Limit+1)
+	for rows.Next() {
+		paymentID, r, err := scanReview(rows)
+		if err != nil {
+			rows.Close()
+			return nil, listing.Page{}, err
+		}
+		queue = append(queue, HeldPayment{
+			Payment: &Payment{projectID: projectID, id: paymentID},
+			Review:  r,
+		})
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(queue), func(i int) listing.Cursor {
+		return reviewCursor(sortField, queue[i].Payment.ID(), queue[i].Review)
+	})
+	queue = queue[:n]
+	for i, h := range queue {
+		queue[i].Payment, err = PaymentByIDDB(ctx, db, h.Payment.PaymentID())
+		if err != nil {
+			return nil, listing.Page{}, err
+		}
+	}
+	return queue, page, nil
+}
@@ -0,0 +1,39 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPaymentReviewOverdue(t *testing.T) {
+	Convey("Given a held payment", t, func() {
+		p := &Payment{Status: PaymentStatusHeld}
+		r := p.NewReview(ReviewHeld, "test")
+
+		Convey("When it has no due time", func() {
+			Convey("It should not be overdue", func() {
+				So(r.Overdue(), ShouldBeFalse)
+			})
+		})
+		Convey("When the due time is in the future", func() {
+			r.Due = time.Now().Add(time.Hour)
+			Convey("It should not be overdue", func() {
+				So(r.Overdue(), ShouldBeFalse)
+			})
+		})
+		Convey("When the due time has passed", func() {
+			r.Due = time.Now().Add(-time.Minute)
+			Convey("It should be overdue", func() {
+				So(r.Overdue(), ShouldBeTrue)
+			})
+			Convey("When the payment was reviewed", func() {
+				r.Status = ReviewApproved
+				Convey("It should not be overdue", func() {
+					So(r.Overdue(), ShouldBeFalse)
+				})
+			})
+		})
+	})
+}
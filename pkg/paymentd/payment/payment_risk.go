@@ -0,0 +1,23 @@
+package payment
+
+import (
+	"time"
+)
+
+// Risk is the risk assessment of a payment
+type Risk struct {
+	Timestamp time.Time
+	// Score is the risk score from 0 (lowest risk) to 100
+	Score int
+	// Source is the name of the risk check which assessed the payment
+	Source string
+}
+
+// NewRisk creates a new risk assessment of the payment
+func (p *Payment) NewRisk(score int, source string) *Risk {
+	return &Risk{
+		Timestamp: time.Now(),
+		Score:     score,
+		Source:    source,
+	}
+}
@@ -0,0 +1,91 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const insertPaymentRisk = `
+INSERT INTO payment_risk
+(project_id, payment_id, timestamp, score, source)
+VALUES
+(?, ?, ?, ?, ?)
+`
+
+// InsertPaymentRiskTx saves the risk assessment of the payment
+//
+// It is a no-op if the payment has no risk assessment.
+func InsertPaymentRiskTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	if p.Risk == nil {
+		return nil
+	}
+	stmt, err := db.PrepareContext(ctx, insertPaymentRisk)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		p.ProjectID(),
+		p.ID(),
+		p.Risk.Timestamp.UnixNano(),
+		p.Risk.Score,
+		p.Risk.Source,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectPaymentRisk = `
+SELECT
+	r.timestamp,
+	r.score,
+	r.source
+FROM payment_risk AS r
+WHERE
+	r.project_id = ?
+	AND
+	r.payment_id = ?
+	AND
+	r.timestamp = (
+		SELECT MAX(timestamp) FROM payment_risk
+		WHERE
+			project_id = r.project_id
+			AND
+			payment_id = r.payment_id
+	)
+`
+
+func scanPaymentRisk(row *sql.Row, p *Payment) error {
+	r := &Risk{}
+	var ts int64
+	err := row.Scan(
+		&ts,
+		&r.Score,
+		&r.Source,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			p.Risk = nil
+			return nil
+		}
+		return err
+	}
+	r.Timestamp = time.Unix(0, ts)
+	p.Risk = r
+	return nil
+}
+
+// PaymentRiskDB loads the current risk assessment of the payment
+//
+// The risk of payments which were not assessed will be nil.
+func PaymentRiskDB(ctx context.Context, db *sql.DB, p *Payment) error {
+	return scanPaymentRisk(db.QueryRowContext(ctx, selectPaymentRisk, p.ProjectID(), p.ID()), p)
+}
+
+// PaymentRiskTx loads the current risk assessment of the payment
+//
+// The risk of payments which were not assessed will be nil.
+func PaymentRiskTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	return scanPaymentRisk(db.QueryRowContext(ctx, selectPaymentRisk, p.ProjectID(), p.ID()), p)
+}
@@ -0,0 +1,180 @@
+package payment
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"golang.org/x/net/context"
+)
+
+const (
+	// cursor time format of the created field
+	createdCursorFormat = "2006-01-02 15:04:05"
+)
+
+// PaymentListing is the listing of payments
+var PaymentListing = listing.Builder{
+	Select:      selectPayment,
+	Key:         "ID",
+	DefaultSort: "ID",
+	Columns: map[string]string{
+		"ID":      "p.id",
+		"Created": "p.created",
+	},
+}
+
+// searches the current metadata values and note using the fulltext indexes
+const whereMetadataSearch = `
+p.project_id = ?
+AND
+(
+	p.ident = ?
+	OR
+	EXISTS (
+		SELECT 1 FROM payment_metadata AS m
+		WHERE
+			m.project_id = p.project_id
+			AND
+			m.payment_id = p.id
+			AND
+			MATCH (m.value) AGAINST (? IN BOOLEAN MODE)
+			AND
+			m.timestamp = (
+				SELECT MAX(timestamp) FROM payment_metadata
+				WHERE
+					project_id = m.project_id
+					AND
+					payment_id = m.payment_id
+			)
+	)
+	OR
+	EXISTS (
+		SELECT 1 FROM payment_note AS n
+		WHERE
+			n.project_id = p.project_id
+			AND
+			n.payment_id = p.id
+			AND
+			MATCH (n.note) AGAINST (? IN BOOLEAN MODE)
+			AND
+			n.timestamp = (
+				SELECT MAX(timestamp) FROM payment_note
+				WHERE
+					project_id = n.project_id
+					AND
+					payment_id = n.payment_id
+			)
+	)
+)
+`
+
+// MetadataSearchPhrase returns the fulltext search expression for the given
+// search term
+//
+// The term will be searched as a phrase, so that operators will not be
+// interpreted and values like e-mail addresses will be matched as a whole.
+func MetadataSearchPhrase(term string) string {
+	term = strings.TrimSpace(strings.Replace(term, `"`, " ", -1))
+	return `"` + term + `"`
+}
+
+func paymentCursor(sortField string, p *Payment) listing.Cursor {
+	c := listing.Cursor{Key: strconv.FormatInt(p.ID(), 10)}
+	if sortField == "Created" {
+		c.Sort = p.Created.Format(createdCursorFormat)
+	}
+	return c
+}
+
+// PaymentsByMetadataSearchDB selects a page of the payments of the given
+// project which match the search term
+//
+// A payment matches if its ident equals the search term or if one of its
+// current metadata values or its current note contains the search term.
+func PaymentsByMetadataSearchDB(ctx context.Context, db *sql.DB, projectID int64, term string, q *listing.Query) ([]*Payment, listing.Page, error) {
+	sortField, err := PaymentListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	phrase := MetadataSearchPhrase(term)
+	query, args, err := PaymentListing.Build(q, whereMetadataSearch, projectID, term, phrase, phrase)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	payments := make([]*Payment, 0, q.Limit+1)
+	for rows.Next() {
+		p, err := scanSingleRow(rows)
+		if err != nil {
+			rows.Close()
+			return nil, listing.Page{}, err
+		}
+		payments = append(payments, p)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(payments), func(i int) listing.Cursor {
+		return paymentCursor(sortField, payments[i])
+	})
+	return payments[:n], page, nil
+}
+
+// matches payments with the given current metadata value
+const wherePaymentsByMetadata = `
+WHERE
+	p.project_id = ?
+	AND
+	EXISTS (
+		SELECT 1 FROM payment_metadata AS m
+		WHERE
+			m.project_id = p.project_id
+			AND
+			m.payment_id = p.id
+			AND
+			m.name = ?
+			AND
+			m.value = ?
+			AND
+			m.timestamp = (
+				SELECT MAX(timestamp) FROM payment_metadata
+				WHERE
+					project_id = m.project_id
+					AND
+					payment_id = m.payment_id
+			)
+	)
+ORDER BY p.created DESC, p.id DESC
+LIMIT ?
+`
+
+// PaymentsByMetadataDB selects the latest payments of the given project whose
+// current metadata value of the given name equals the value
+func PaymentsByMetadataDB(ctx context.Context, db *sql.DB, projectID int64, name, value string, limit int) ([]*Payment, error) {
+	rows, err := db.QueryContext(ctx, selectPayment+wherePaymentsByMetadata, projectID, name, value, limit)
+	if err != nil {
+		return nil, err
+	}
+	payments := make([]*Payment, 0, limit)
+	for rows.Next() {
+		p, err := scanSingleRow(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
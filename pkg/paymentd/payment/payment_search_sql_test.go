@@ -0,0 +1,19 @@
+package payment_test
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetadataSearchPhrase(t *testing.T) {
+	Convey("Given a search term", t, func() {
+		Convey("It should be searched as a phrase", func() {
+			So(payment.MetadataSearchPhrase(" customer@example.com "), ShouldEqual, `"customer@example.com"`)
+		})
+		Convey("Quotes should not end the phrase", func() {
+			So(payment.MetadataSearchPhrase(`order" +1234 -x`), ShouldEqual, `"order  +1234 -x"`)
+		})
+	})
+}
@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -17,12 +20,12 @@ VALUES
 (?, ?, ?, ?, ?, ?)
 `
 
-func InsertPaymentTx(db *sql.Tx, p *Payment) error {
-	stmt, err := db.Prepare(insertPayment)
+func InsertPaymentTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	stmt, err := db.PrepareContext(ctx, insertPayment)
 	if err != nil {
 		return err
 	}
-	res, err := stmt.Exec(
+	res, err := stmt.ExecContext(ctx,
 		p.ProjectID(),
 		p.Created,
 		p.Ident,
@@ -57,6 +60,7 @@ SELECT
 	c.callback_project_key,
 	c.return_url,
 	c.expires,
+	c.statement_descriptor,
 
 	tx.timestamp,
 	tx.status
@@ -104,7 +108,7 @@ WHERE
 	p.ident = ?
 `
 
-func scanSingleRow(row *sql.Row) (*Payment, error) {
+func scanSingleRow(row resultScanner) (*Payment, error) {
 	p := &Payment{}
 	var ts, txTs sql.NullInt64
 	err := row.Scan(
@@ -124,6 +128,7 @@ func scanSingleRow(row *sql.Row) (*Payment, error) {
 		&p.Config.CallbackProjectKey,
 		&p.Config.ReturnURL,
 		&p.Config.Expires,
+		&p.Config.StatementDescriptor,
 		&txTs,
 		&p.Status,
 	)
@@ -142,40 +147,95 @@ func scanSingleRow(row *sql.Row) (*Payment, error) {
 	return p, nil
 }
 
-func PaymentByIDTx(db *sql.Tx, id PaymentID) (*Payment, error) {
-	row := db.QueryRow(selectPaymentByProjectIDAndID, id.ProjectID, id.PaymentID)
+func PaymentByIDTx(ctx context.Context, db *sql.Tx, id PaymentID) (*Payment, error) {
+	row := db.QueryRowContext(ctx, selectPaymentByProjectIDAndID, id.ProjectID, id.PaymentID)
 	return scanSingleRow(row)
 }
 
-func PaymentByIDDB(db *sql.DB, id PaymentID) (*Payment, error) {
-	row := db.QueryRow(selectPaymentByProjectIDAndID, id.ProjectID, id.PaymentID)
+// PaymentByIDForUpdateTx selects the payment and locks it until the transaction
+// ends, so that concurrent status changes of the payment are serialized
+func PaymentByIDForUpdateTx(ctx context.Context, db *sql.Tx, id PaymentID) (*Payment, error) {
+	row := db.QueryRowContext(ctx, selectPaymentByProjectIDAndID+"FOR UPDATE", id.ProjectID, id.PaymentID)
 	return scanSingleRow(row)
 }
 
-func PaymentByProjectIDAndIdentDB(db *sql.DB, projectID int64, ident string) (*Payment, error) {
-	row := db.QueryRow(selectPaymentByProjectIDAndIdent, projectID, ident)
+func PaymentByIDDB(ctx context.Context, db *sql.DB, id PaymentID) (*Payment, error) {
+	row := db.QueryRowContext(ctx, selectPaymentByProjectIDAndID, id.ProjectID, id.PaymentID)
 	return scanSingleRow(row)
 }
 
-func PaymentByProjectIDAndIdentTx(db *sql.Tx, projectID int64, ident string) (*Payment, error) {
-	row := db.QueryRow(selectPaymentByProjectIDAndIdent, projectID, ident)
+func PaymentByProjectIDAndIdentDB(ctx context.Context, db *sql.DB, projectID int64, ident string) (*Payment, error) {
+	row := db.QueryRowContext(ctx, selectPaymentByProjectIDAndIdent, projectID, ident)
 	return scanSingleRow(row)
 }
 
+func PaymentByProjectIDAndIdentTx(ctx context.Context, db *sql.Tx, projectID int64, ident string) (*Payment, error) {
+	row := db.QueryRowContext(ctx, selectPaymentByProjectIDAndIdent, projectID, ident)
+	return scanSingleRow(row)
+}
+
+const selectPaymentIDsByProjectIDAndStatus = `
+SELECT
+	p.id
+FROM payment AS p
+INNER JOIN payment_transaction AS tx ON
+	tx.project_id = p.project_id
+	AND
+	tx.payment_id = p.id
+	AND
+	tx.timestamp = (
+		SELECT MAX(timestamp) FROM payment_transaction
+		WHERE
+			project_id = tx.project_id
+			AND
+			payment_id = tx.payment_id
+	)
+WHERE
+	p.project_id = ?
+	AND
+	tx.status = ?
+ORDER BY p.id
+LIMIT ?
+`
+
+// PaymentIDsByProjectIDAndStatusDB returns the IDs of the payments of the
+// project with the given current status
+//
+// At most limit IDs will be returned.
+func PaymentIDsByProjectIDAndStatusDB(ctx context.Context, db *sql.DB, projectID int64, status PaymentTransactionStatus, limit int) ([]PaymentID, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentIDsByProjectIDAndStatus, projectID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]PaymentID, 0)
+	for rows.Next() {
+		id := PaymentID{ProjectID: projectID}
+		err = rows.Scan(&id.PaymentID)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	return ids, err
+}
+
 const insertPaymentConfig = `
 INSERT INTO payment_config
-(project_id, payment_id, timestamp, payment_method_id, country, locale, callback_url, callback_api_version, callback_project_key, return_url, expires)
+(project_id, payment_id, timestamp, payment_method_id, country, locale, callback_url, callback_api_version, callback_project_key, return_url, expires, statement_descriptor)
 VALUES
-(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
-func InsertPaymentConfigTx(db *sql.Tx, p *Payment) error {
-	stmt, err := db.Prepare(insertPaymentConfig)
+func InsertPaymentConfigTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	stmt, err := db.PrepareContext(ctx, insertPaymentConfig)
 	if err != nil {
 		return err
 	}
 	ts := time.Now().UnixNano()
-	_, err = stmt.Exec(
+	_, err = stmt.ExecContext(ctx,
 		p.ProjectID(),
 		p.ID(),
 		ts,
@@ -187,6 +247,7 @@ func InsertPaymentConfigTx(db *sql.Tx, p *Payment) error {
 		p.Config.CallbackProjectKey,
 		p.Config.ReturnURL,
 		p.Config.Expires,
+		p.Config.StatementDescriptor,
 	)
 	stmt.Close()
 	return err
@@ -229,16 +290,16 @@ func scanPaymentMetadata(rows *sql.Rows, p *Payment) error {
 	return err
 }
 
-func PaymentMetadataTx(db *sql.Tx, p *Payment) error {
-	rows, err := db.Query(selectPaymentMetadata, p.ProjectID(), p.ID())
+func PaymentMetadataTx(ctx context.Context, db *sql.Tx, p *Payment) error {
+	rows, err := db.QueryContext(ctx, selectPaymentMetadata, p.ProjectID(), p.ID())
 	if err != nil {
 		return err
 	}
 	return scanPaymentMetadata(rows, p)
 }
 
-func PaymentMetadataDB(db *sql.DB, p *Payment) error {
-	rows, err := db.Query(selectPaymentMetadata, p.ProjectID(), p.ID())
+func PaymentMetadataDB(ctx context.Context, db *sql.DB, p *Payment) error {
+	rows, err := db.QueryContext(ctx, selectPaymentMetadata, p.ProjectID(), p.ID())
 	if err != nil {
 		return err
 	}
@@ -252,17 +313,25 @@ VALUES
 (?, ?, ?, ?, ?)
 `
 
-func InsertPaymentMetadataTx(db *sql.Tx, p *Payment) error {
+// InsertPaymentMetadataTx saves the metadata of the payment
+//
+// Metadata exceeding the metadata.MaxLimits is rejected with a
+// *metadata.LimitError before it is written.
+func InsertPaymentMetadataTx(ctx context.Context, db *sql.Tx, p *Payment) error {
 	if p.Metadata == nil {
 		return nil
 	}
-	stmt, err := db.Prepare(insertPaymentMetadata)
+	err := metadata.MaxLimits.Validate(p.Metadata)
+	if err != nil {
+		return err
+	}
+	stmt, err := db.PrepareContext(ctx, insertPaymentMetadata)
 	if err != nil {
 		return err
 	}
 	ts := time.Now().UnixNano()
 	for n, v := range p.Metadata {
-		_, err = stmt.Exec(
+		_, err = stmt.ExecContext(ctx,
 			p.ProjectID(),
 			p.ID(),
 			n,
@@ -12,6 +12,7 @@ import (
 	"github.com/fritzpay/paymentd/pkg/paymentd/principal"
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
 )
 
 func WithTestProject(db, prDB *sql.DB, f func(pr *project.Project)) func() {
@@ -21,7 +22,7 @@ func WithTestProject(db, prDB *sql.DB, f func(pr *project.Project)) func() {
 		So(princ.ID, ShouldNotEqual, 0)
 		So(princ.Empty(), ShouldBeFalse)
 
-		proj, err := project.ProjectByPrincipalIDNameDB(prDB, princ.ID, "testproject")
+		proj, err := project.ProjectByPrincipalIDNameDB(context.Background(), prDB, princ.ID, "testproject")
 		So(err, ShouldBeNil)
 
 		f(proj)
@@ -39,7 +40,7 @@ func WithTestPayment(tx *sql.Tx, pr *project.Project, f func(p *payment.Payment)
 		p.Currency = "EUR"
 		p.Created = time.Unix(1234, 0)
 
-		err = payment.InsertPaymentTx(tx, p)
+		err = payment.InsertPaymentTx(context.Background(), tx, p)
 		So(err, ShouldBeNil)
 
 		f(p)
@@ -186,7 +187,7 @@ func TestPaymentSQL(t *testing.T) {
 
 					Convey("Given a test payment", WithTestPayment(tx, proj, func(p *payment.Payment) {
 						Convey("When selecting a payment by ident", func() {
-							p2, err := payment.PaymentByProjectIDAndIdentTx(tx, proj.ID, p.Ident)
+							p2, err := payment.PaymentByProjectIDAndIdentTx(context.Background(), tx, proj.ID, p.Ident)
 							Convey("It should succeed", func() {
 								So(err, ShouldBeNil)
 								Convey("It should match the original payment", func() {
@@ -198,11 +199,11 @@ func TestPaymentSQL(t *testing.T) {
 						Convey("Given the test payment has a transaction", func() {
 							paymentTx := p.NewTransaction(payment.PaymentStatusPaid)
 							paymentTx.Timestamp = time.Unix(9876, 0)
-							err = payment.InsertPaymentTransactionTx(tx, paymentTx)
+							err = payment.InsertPaymentTransactionTx(context.Background(), tx, paymentTx)
 							So(err, ShouldBeNil)
 
 							Convey("When selecting the payment", func() {
-								p2, err := payment.PaymentByIDTx(tx, p.PaymentID())
+								p2, err := payment.PaymentByIDTx(context.Background(), tx, p.PaymentID())
 								So(err, ShouldBeNil)
 
 								Convey("The transaction values should be set in the payment", func() {
@@ -218,6 +219,86 @@ func TestPaymentSQL(t *testing.T) {
 	}))
 }
 
+func TestPaymentStateAtSQL(t *testing.T) {
+	Convey("Given a payment DB", t, testutil.WithPaymentDB(t, func(db *sql.DB) {
+		Reset(func() {
+			db.Close()
+		})
+		Convey("Given a principal DB", testutil.WithPrincipalDB(t, func(prDB *sql.DB) {
+			Reset(func() {
+				prDB.Close()
+			})
+			Convey("Given a test project", WithTestProject(db, prDB, func(proj *project.Project) {
+				Convey("Given a transaction", func() {
+					tx, err := db.Begin()
+					So(err, ShouldBeNil)
+
+					Reset(func() {
+						err = tx.Rollback()
+						So(err, ShouldBeNil)
+					})
+
+					Convey("Given a test payment with a history of transactions", WithTestPayment(tx, proj, func(p *payment.Payment) {
+						openTx := p.NewTransaction(payment.PaymentStatusOpen)
+						openTx.Timestamp = time.Unix(2000, 0)
+						err = payment.InsertPaymentTransactionTx(context.Background(), tx, openTx)
+						So(err, ShouldBeNil)
+						paidTx := p.NewTransaction(payment.PaymentStatusPaid)
+						paidTx.Timestamp = time.Unix(3000, 0)
+						err = payment.InsertPaymentTransactionTx(context.Background(), tx, paidTx)
+						So(err, ShouldBeNil)
+
+						Convey("When selecting the payment before its creation", func() {
+							_, err := payment.PaymentByIDAtTx(context.Background(), tx, p.PaymentID(), time.Unix(1000, 0))
+							Convey("It should not be found", func() {
+								So(err, ShouldEqual, payment.ErrPaymentNotFound)
+							})
+						})
+						Convey("When selecting the payment between the transactions", func() {
+							p2, err := payment.PaymentByIDAtTx(context.Background(), tx, p.PaymentID(), time.Unix(2500, 0))
+							So(err, ShouldBeNil)
+							Convey("It should have the status of the first transaction", func() {
+								So(p2.Status, ShouldEqual, payment.PaymentStatusOpen)
+								So(p2.TransactionTimestamp.Unix(), ShouldEqual, 2000)
+							})
+						})
+						Convey("When selecting the payment after the transactions", func() {
+							p2, err := payment.PaymentByIDAtTx(context.Background(), tx, p.PaymentID(), time.Unix(4000, 0))
+							So(err, ShouldBeNil)
+							Convey("It should have the status of the last transaction", func() {
+								So(p2.Status, ShouldEqual, payment.PaymentStatusPaid)
+							})
+						})
+
+						Convey("Given the payment has metadata", func() {
+							p.Metadata = map[string]string{"key": "value"}
+							err = payment.InsertPaymentMetadataTx(context.Background(), tx, p)
+							So(err, ShouldBeNil)
+
+							Convey("When selecting the metadata before it was written", func() {
+								version, err := payment.PaymentMetadataAtTx(context.Background(), tx, p, time.Unix(4000, 0))
+								So(err, ShouldBeNil)
+								Convey("It should be empty", func() {
+									So(version.IsZero(), ShouldBeTrue)
+									So(len(p.Metadata), ShouldEqual, 0)
+								})
+							})
+							Convey("When selecting the current metadata", func() {
+								version, err := payment.PaymentMetadataAtTx(context.Background(), tx, p, time.Now())
+								So(err, ShouldBeNil)
+								Convey("It should return the metadata and its version", func() {
+									So(version.IsZero(), ShouldBeFalse)
+									So(p.Metadata["key"], ShouldEqual, "value")
+								})
+							})
+						})
+					}))
+				})
+			}))
+		}))
+	}))
+}
+
 func TestPaymentTokenGenerationSQL(t *testing.T) {
 	Convey("Given a payment DB", t, testutil.WithPaymentDB(t, func(db *sql.DB) {
 		Reset(func() {
@@ -244,7 +325,7 @@ func TestPaymentTokenGenerationSQL(t *testing.T) {
 							So(t.Valid(time.Minute), ShouldBeTrue)
 
 							Convey("When inserting the token", func() {
-								err = payment.InsertPaymentTokenTx(tx, t)
+								err = payment.InsertPaymentTokenTx(context.Background(), tx, t)
 
 								Convey("It should succeed", func() {
 									So(err, ShouldBeNil)
@@ -253,7 +334,7 @@ func TestPaymentTokenGenerationSQL(t *testing.T) {
 										t2 := *t
 										So(t.Token, ShouldEqual, t2.Token)
 										Convey("When inserting a duplicate token", func() {
-											err = payment.InsertPaymentTokenTx(tx, &t2)
+											err = payment.InsertPaymentTokenTx(context.Background(), tx, &t2)
 											Convey("It should succeed", func() {
 												So(err, ShouldBeNil)
 											})
@@ -294,13 +375,13 @@ func TestPaymentMetadata(t *testing.T) {
 					Convey("Given a test payment", WithTestPayment(tx, proj, func(p *payment.Payment) {
 						Convey("When adding metadata", func() {
 							p.Metadata = map[string]string{"metadataEntry": "metadataValue"}
-							err = payment.InsertPaymentMetadataTx(tx, p)
+							err = payment.InsertPaymentMetadataTx(context.Background(), tx, p)
 							So(err, ShouldBeNil)
 
 							Convey("When retrieving the payment with metadata", func() {
-								pRet, err := payment.PaymentByIDTx(tx, p.PaymentID())
+								pRet, err := payment.PaymentByIDTx(context.Background(), tx, p.PaymentID())
 								So(err, ShouldBeNil)
-								err = payment.PaymentMetadataTx(tx, pRet)
+								err = payment.PaymentMetadataTx(context.Background(), tx, pRet)
 								So(err, ShouldBeNil)
 
 								Convey("It should return the metadata", func() {
@@ -309,13 +390,13 @@ func TestPaymentMetadata(t *testing.T) {
 
 									Convey("When adding an additional metadata entry", func() {
 										pRet.Metadata["second"] = "me"
-										err = payment.InsertPaymentMetadataTx(tx, pRet)
+										err = payment.InsertPaymentMetadataTx(context.Background(), tx, pRet)
 										So(err, ShouldBeNil)
 
 										Convey("When retrieving the payment with metadata", func() {
-											p, err = payment.PaymentByIDTx(tx, pRet.PaymentID())
+											p, err = payment.PaymentByIDTx(context.Background(), tx, pRet.PaymentID())
 											So(err, ShouldBeNil)
-											err = payment.PaymentMetadataTx(tx, p)
+											err = payment.PaymentMetadataTx(context.Background(), tx, p)
 											So(err, ShouldBeNil)
 
 											Convey("It should have both entries", func() {
@@ -0,0 +1,124 @@
+package payment
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// EncryptedTokenPrefix is the prefix of encrypted (stateless) payment tokens
+	//
+	// Random payment tokens are hex-encoded and will never contain the prefix.
+	EncryptedTokenPrefix = "e."
+
+	// project ID, payment ID and expiry
+	encryptedTokenPayloadBytes = 3 * 8
+)
+
+var (
+	// ErrTokenInvalid is returned if an encrypted token cannot be decrypted
+	ErrTokenInvalid = errors.New("invalid payment token")
+	// ErrTokenExpired is returned if an encrypted token is expired
+	ErrTokenExpired = errors.New("payment token expired")
+)
+
+// IsEncryptedToken returns true if the given token is an encrypted token
+func IsEncryptedToken(token string) bool {
+	return strings.HasPrefix(token, EncryptedTokenPrefix)
+}
+
+// TokenCipher creates and reads encrypted payment tokens
+//
+// Encrypted tokens embed the payment ID and the expiry and are authenticated
+// with AES-GCM. They are not stored and cannot be revoked.
+type TokenCipher struct {
+	aeads []cipher.AEAD
+}
+
+// NewTokenCipher creates a new token cipher
+//
+// The first key will be used for encrypting tokens. All keys will be tried when
+// decrypting tokens.
+func NewTokenCipher(keys [][]byte) (*TokenCipher, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no token keys")
+	}
+	c := &TokenCipher{
+		aeads: make([]cipher.AEAD, len(keys)),
+	}
+	for i, key := range keys {
+		if len(key) == 0 {
+			return nil, errors.New("empty token key")
+		}
+		// AES-256
+		derived := sha256.Sum256(key)
+		b, err := aes.NewCipher(derived[:])
+		if err != nil {
+			return nil, err
+		}
+		c.aeads[i], err = cipher.NewGCM(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Encrypt creates an encrypted token for the given payment ID, which is valid
+// until the given expiry
+func (c *TokenCipher) Encrypt(id PaymentID, expires time.Time) (string, error) {
+	aead := c.aeads[0]
+	payload := make([]byte, encryptedTokenPayloadBytes)
+	binary.BigEndian.PutUint64(payload[0:], uint64(id.ProjectID))
+	binary.BigEndian.PutUint64(payload[8:], uint64(id.PaymentID))
+	binary.BigEndian.PutUint64(payload[16:], uint64(expires.UnixNano()))
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, payload, []byte(EncryptedTokenPrefix))
+	return EncryptedTokenPrefix + hex.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the payment ID and the expiry of the given encrypted token
+//
+// It will return ErrTokenExpired if the token is expired.
+func (c *TokenCipher) Decrypt(token string) (PaymentID, time.Time, error) {
+	var id PaymentID
+	if !IsEncryptedToken(token) {
+		return id, time.Time{}, ErrTokenInvalid
+	}
+	sealed, err := hex.DecodeString(token[len(EncryptedTokenPrefix):])
+	if err != nil {
+		return id, time.Time{}, ErrTokenInvalid
+	}
+	var payload []byte
+	for _, aead := range c.aeads {
+		size := aead.NonceSize()
+		if len(sealed) <= size {
+			return id, time.Time{}, ErrTokenInvalid
+		}
+		payload, err = aead.Open(nil, sealed[:size], sealed[size:], []byte(EncryptedTokenPrefix))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil || len(payload) != encryptedTokenPayloadBytes {
+		return id, time.Time{}, ErrTokenInvalid
+	}
+	id.ProjectID = int64(binary.BigEndian.Uint64(payload[0:]))
+	id.PaymentID = int64(binary.BigEndian.Uint64(payload[8:]))
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(payload[16:])))
+	if !time.Now().Before(expires) {
+		return id, expires, ErrTokenExpired
+	}
+	return id, expires, nil
+}
@@ -0,0 +1,70 @@
+package payment_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenCipher(t *testing.T) {
+	Convey("Given a token cipher", t, func() {
+		c, err := payment.NewTokenCipher([][]byte{[]byte("key")})
+		So(err, ShouldBeNil)
+		id := payment.PaymentID{ProjectID: 1, PaymentID: 1234}
+
+		Convey("When encrypting a token", func() {
+			token, err := c.Encrypt(id, time.Now().Add(time.Minute))
+			So(err, ShouldBeNil)
+
+			Convey("It should be recognized as an encrypted token", func() {
+				So(payment.IsEncryptedToken(token), ShouldBeTrue)
+			})
+			Convey("It should decrypt to the payment ID", func() {
+				decID, expires, err := c.Decrypt(token)
+				So(err, ShouldBeNil)
+				So(decID, ShouldResemble, id)
+				So(expires.After(time.Now()), ShouldBeTrue)
+			})
+			Convey("It should be decrypted by a cipher with rolled over keys", func() {
+				rolled, err := payment.NewTokenCipher([][]byte{[]byte("new"), []byte("key")})
+				So(err, ShouldBeNil)
+				decID, _, err := rolled.Decrypt(token)
+				So(err, ShouldBeNil)
+				So(decID, ShouldResemble, id)
+			})
+			Convey("It should not be decrypted with other keys", func() {
+				other, err := payment.NewTokenCipher([][]byte{[]byte("other")})
+				So(err, ShouldBeNil)
+				_, _, err = other.Decrypt(token)
+				So(err, ShouldEqual, payment.ErrTokenInvalid)
+			})
+			Convey("A modified token should be invalid", func() {
+				b := []byte(token)
+				if b[len(b)-1] == '0' {
+					b[len(b)-1] = '1'
+				} else {
+					b[len(b)-1] = '0'
+				}
+				_, _, err := c.Decrypt(string(b))
+				So(err, ShouldEqual, payment.ErrTokenInvalid)
+			})
+		})
+
+		Convey("When encrypting an expired token", func() {
+			token, err := c.Encrypt(id, time.Now().Add(-time.Second))
+			So(err, ShouldBeNil)
+			Convey("It should be expired", func() {
+				_, _, err := c.Decrypt(token)
+				So(err, ShouldEqual, payment.ErrTokenExpired)
+			})
+		})
+
+		Convey("Random tokens should not be recognized as encrypted tokens", func() {
+			token, err := payment.NewPaymentToken(id)
+			So(err, ShouldBeNil)
+			So(payment.IsEncryptedToken(token.Token), ShouldBeFalse)
+		})
+	})
+}
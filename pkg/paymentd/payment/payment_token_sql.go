@@ -4,36 +4,34 @@ import (
 	"database/sql"
 	"time"
 
-	"github.com/go-sql-driver/mysql"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"golang.org/x/net/context"
 )
 
-func InsertPaymentTokenTx(tx *sql.Tx, t *PaymentToken) error {
+func InsertPaymentTokenTx(ctx context.Context, tx *sql.Tx, t *PaymentToken) error {
 	const insert = `
 INSERT INTO payment_token
 (token, created, project_id, payment_id)
 VALUES
 (?, ?, ?, ?)
 `
-	stmt, err := tx.Prepare(insert)
+	stmt, err := tx.PrepareContext(ctx, insert)
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(
+	_, err = stmt.ExecContext(ctx,
 		t.Token,
 		t.Created,
 		t.id.ProjectID,
 		t.id.PaymentID)
 	stmt.Close()
 	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			// MySQL Error 1062 duplicate key
-			if mysqlErr.Number == 1062 {
-				err = t.GenerateToken()
-				if err != nil {
-					return err
-				}
-				return InsertPaymentTokenTx(tx, t)
+		if sqldialect.IsDuplicate(err) {
+			err = t.GenerateToken()
+			if err != nil {
+				return err
 			}
+			return InsertPaymentTokenTx(ctx, tx, t)
 		}
 		return err
 	}
@@ -76,8 +74,13 @@ WHERE
 	t.created > ?
 `
 
-func PaymentByTokenTx(db *sql.Tx, token string, tokenMaxAge time.Duration) (*Payment, error) {
-	row := db.QueryRow(selectPaymentByToken, token, time.Now().Add(tokenMaxAge*-1))
+func PaymentByTokenTx(ctx context.Context, db *sql.Tx, token string, tokenMaxAge time.Duration) (*Payment, error) {
+	row := db.QueryRowContext(ctx, selectPaymentByToken, token, time.Now().Add(tokenMaxAge*-1))
+	return scanSingleRow(row)
+}
+
+func PaymentByTokenDB(ctx context.Context, db *sql.DB, token string, tokenMaxAge time.Duration) (*Payment, error) {
+	row := db.QueryRowContext(ctx, selectPaymentByToken, token, time.Now().Add(tokenMaxAge*-1))
 	return scanSingleRow(row)
 }
 
@@ -85,12 +88,26 @@ const deletePaymentToken = `
 DELETE FROM payment_token WHERE token = ?
 `
 
-func DeletePaymentTokenTx(db *sql.Tx, token string) error {
-	stmt, err := db.Prepare(deletePaymentToken)
+func DeletePaymentTokenTx(ctx context.Context, db *sql.Tx, token string) error {
+	stmt, err := db.PrepareContext(ctx, deletePaymentToken)
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(token)
+	_, err = stmt.ExecContext(ctx, token)
 	stmt.Close()
 	return err
 }
+
+const deletePaymentTokensCreatedBefore = `
+DELETE FROM payment_token WHERE created < ?
+`
+
+// DeletePaymentTokensCreatedBeforeDB deletes the payment tokens created before
+// the given time and returns the number of deleted tokens
+func DeletePaymentTokensCreatedBeforeDB(ctx context.Context, db *sql.DB, t time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, deletePaymentTokensCreatedBefore, t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -49,6 +49,7 @@ const (
 	PaymentStatusOpen                                    = "open"
 	PaymentStatusPending                                 = "pending"
 	PaymentStatusPaid                                    = "paid"
+	PaymentStatusPartiallyPaid                           = "partially-paid"
 	PaymentStatusSettled                                 = "settled"
 	PaymentStatusAuthorized                              = "authorized"
 	PaymentStatusError                                   = "error"
@@ -57,6 +58,31 @@ const (
 	PaymentStatusChargeback                              = "chargeback"
 	PaymentStatusRefunded                                = "refunded"
 	PaymentStatusRefundReversed                          = "refund-reversed"
+	// PaymentStatusPartiallyCaptured payments were captured in part and can
+	// be captured further
+	PaymentStatusPartiallyCaptured = "partially-captured"
+	// PaymentStatusPartiallyRefunded payments were refunded in part and can
+	// be refunded further
+	PaymentStatusPartiallyRefunded = "partially-refunded"
+	// PaymentStatusChargebackReceived payments are disputed by the customer.
+	// The disputed amount is withdrawn
+	PaymentStatusChargebackReceived = "chargeback-received"
+	// PaymentStatusChargebackReversed payments won the dispute. The disputed
+	// amount is returned
+	PaymentStatusChargebackReversed = "chargeback-reversed"
+	// PaymentStatusChargebackLost payments lost the dispute
+	PaymentStatusChargebackLost = "chargeback-lost"
+	// PaymentStatusHeld payments are held for manual review
+	PaymentStatusHeld = "held"
+	// PaymentStatusVerified verification payments verified the payment
+	// instrument of the customer
+	PaymentStatusVerified = "verified"
+	// PaymentStatusVoided payments were authorized and the merchant released
+	// the authorization without capturing
+	PaymentStatusVoided = "voided"
+	// PaymentStatusExpired payments were not processed within the payment
+	// expiry of the project
+	PaymentStatusExpired = "expired"
 )
 
 // PaymentTransaction represents a transaction on a payment
@@ -73,6 +99,9 @@ type PaymentTransaction struct {
 	Currency  string
 	Status    PaymentTransactionStatus
 	Comment   sql.NullString
+	// Authentication is the outcome of the strong customer authentication of
+	// the customer, e.g. "frictionless"
+	Authentication sql.NullString
 }
 
 func (p *PaymentTransaction) Decimal() *decimal.Decimal {
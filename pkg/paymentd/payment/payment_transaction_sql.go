@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -18,23 +21,24 @@ SELECT
 	tx.subunits,
 	tx.currency,
 	tx.status,
-	tx.comment
+	tx.comment,
+	tx.authentication
 FROM payment_transaction AS tx
 `
 
 const insertPaymentTransaction = `
 INSERT INTO payment_transaction
-(project_id, payment_id, timestamp, amount, subunits, currency, status, comment)
+(project_id, payment_id, timestamp, amount, subunits, currency, status, comment, authentication)
 VALUES
-(?, ?, ?, ?, ?, ?, ?, ?)
+(?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
-func InsertPaymentTransactionTx(db *sql.Tx, paymentTx *PaymentTransaction) error {
-	stmt, err := db.Prepare(insertPaymentTransaction)
+func InsertPaymentTransactionTx(ctx context.Context, db *sql.Tx, paymentTx *PaymentTransaction) error {
+	stmt, err := db.PrepareContext(ctx, insertPaymentTransaction)
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(
+	_, err = stmt.ExecContext(ctx,
 		paymentTx.Payment.ProjectID(),
 		paymentTx.Payment.ID(),
 		paymentTx.Timestamp.UnixNano(),
@@ -43,11 +47,107 @@ func InsertPaymentTransactionTx(db *sql.Tx, paymentTx *PaymentTransaction) error
 		paymentTx.Currency,
 		paymentTx.Status,
 		paymentTx.Comment,
+		paymentTx.Authentication,
 	)
 	stmt.Close()
+	if err != nil {
+		return err
+	}
+	return savePaymentTransactionCurrentTx(ctx, db, paymentTx)
+}
+
+// the current transaction is only replaced by newer transactions
+const updatePaymentTransactionCurrent = `
+UPDATE payment_transaction_current
+SET
+	timestamp = ?,
+	amount = ?,
+	subunits = ?,
+	currency = ?,
+	status = ?,
+	comment = ?,
+	authentication = ?
+WHERE
+	project_id = ?
+	AND
+	payment_id = ?
+	AND
+	timestamp < ?
+`
+
+const insertPaymentTransactionCurrent = `
+INSERT INTO payment_transaction_current
+(project_id, payment_id, timestamp, amount, subunits, currency, status, comment, authentication)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// savePaymentTransactionCurrentTx maintains the summary row of the current
+// transaction of the payment
+//
+// The summary row spares the current status queries to search the latest of
+// all transactions of a payment. Payments without a summary row get one with
+// their next transaction.
+func savePaymentTransactionCurrentTx(ctx context.Context, db *sql.Tx, paymentTx *PaymentTransaction) error {
+	update := func() (int64, error) {
+		res, err := db.ExecContext(ctx, updatePaymentTransactionCurrent,
+			paymentTx.Timestamp.UnixNano(),
+			paymentTx.Amount,
+			paymentTx.Subunits,
+			paymentTx.Currency,
+			paymentTx.Status,
+			paymentTx.Comment,
+			paymentTx.Authentication,
+			paymentTx.Payment.ProjectID(),
+			paymentTx.Payment.ID(),
+			paymentTx.Timestamp.UnixNano(),
+		)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+	n, err := update()
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = db.ExecContext(ctx, insertPaymentTransactionCurrent,
+		paymentTx.Payment.ProjectID(),
+		paymentTx.Payment.ID(),
+		paymentTx.Timestamp.UnixNano(),
+		paymentTx.Amount,
+		paymentTx.Subunits,
+		paymentTx.Currency,
+		paymentTx.Status,
+		paymentTx.Comment,
+		paymentTx.Authentication,
+	)
+	if sqldialect.IsDuplicate(err) {
+		// the summary row is newer or was inserted in the meantime
+		_, err = update()
+	}
 	return err
 }
 
+const selectPaymentTransactionCurrent = `
+SELECT
+	c.timestamp,
+
+	c.amount,
+	c.subunits,
+	c.currency,
+	c.status,
+	c.comment,
+	c.authentication
+FROM payment_transaction_current AS c
+WHERE
+	c.project_id = ?
+	AND
+	c.payment_id = ?
+`
+
+// selectCurrentPaymentTransaction selects the current transaction of payments
+// without a summary row
 const selectCurrentPaymentTransaction = selectPaymentTransaction + `
 WHERE
 	tx.project_id = ?
@@ -76,6 +176,7 @@ func scanPaymentTx(r resultScanner, paymentTx *PaymentTransaction) error {
 		&paymentTx.Currency,
 		&paymentTx.Status,
 		&paymentTx.Comment,
+		&paymentTx.Authentication,
 	)
 	paymentTx.Timestamp = time.Unix(0, ts)
 	return err
@@ -86,12 +187,51 @@ func scanPaymentTx(r resultScanner, paymentTx *PaymentTransaction) error {
 // PaymentTransaction type
 //
 // If no payment transaction exists, it will return an ErrPaymentTransactionNotFound
-func PaymentTransactionCurrentTx(db *sql.Tx, p *Payment) (*PaymentTransaction, error) {
+func PaymentTransactionCurrentTx(ctx context.Context, db *sql.Tx, p *Payment) (*PaymentTransaction, error) {
+	paymentTx := &PaymentTransaction{
+		Payment: p,
+	}
+	row := db.QueryRowContext(ctx, selectPaymentTransactionCurrent, p.ProjectID(), p.ID())
+	err := scanPaymentTx(row, paymentTx)
+	if err == sql.ErrNoRows {
+		// payments without a transaction since the summary rows were introduced
+		row = db.QueryRowContext(ctx, selectCurrentPaymentTransaction, p.ProjectID(), p.ID())
+		err = scanPaymentTx(row, paymentTx)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return paymentTx, ErrPaymentTransactionNotFound
+		}
+		return paymentTx, err
+	}
+	p.TransactionTimestamp = paymentTx.Timestamp
+	p.Status = paymentTx.Status
+	return paymentTx, nil
+}
+
+// PaymentTransactionCurrentForUpdateTx reads the current payment transaction
+// into the given payment like PaymentTransactionCurrentTx and locks the summary
+// row of the payment until the transaction ends
+//
+// The lock serializes the status changes of the payment without blocking
+// writes referencing the payment. Payments without a summary row get one.
+func PaymentTransactionCurrentForUpdateTx(ctx context.Context, db *sql.Tx, p *Payment) (*PaymentTransaction, error) {
 	paymentTx := &PaymentTransaction{
 		Payment: p,
 	}
-	row := db.QueryRow(selectCurrentPaymentTransaction, p.ProjectID(), p.ID())
+	row := db.QueryRowContext(ctx, selectPaymentTransactionCurrent+" FOR UPDATE", p.ProjectID(), p.ID())
 	err := scanPaymentTx(row, paymentTx)
+	if err == sql.ErrNoRows {
+		row = db.QueryRowContext(ctx, selectCurrentPaymentTransaction, p.ProjectID(), p.ID())
+		err = scanPaymentTx(row, paymentTx)
+		if err == nil {
+			err = savePaymentTransactionCurrentTx(ctx, db, paymentTx)
+		}
+		if err == nil {
+			row = db.QueryRowContext(ctx, selectPaymentTransactionCurrent+" FOR UPDATE", p.ProjectID(), p.ID())
+			err = scanPaymentTx(row, paymentTx)
+		}
+	}
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return paymentTx, ErrPaymentTransactionNotFound
@@ -103,6 +243,88 @@ func PaymentTransactionCurrentTx(db *sql.Tx, p *Payment) (*PaymentTransaction, e
 	return paymentTx, nil
 }
 
+const selectOpenCreatedBefore = `
+SELECT
+	c.project_id,
+	c.payment_id
+FROM payment_transaction_current AS c
+INNER JOIN payment AS p ON
+	p.project_id = c.project_id
+	AND
+	p.id = c.payment_id
+WHERE
+	c.project_id = ?
+	AND
+	c.status = ?
+	AND
+	p.created < ?
+ORDER BY p.created
+LIMIT ?
+`
+
+// payments without a transaction since the summary rows were introduced have
+// no summary row and are selected by their latest transaction
+const selectOpenCreatedBeforeWithoutCurrent = `
+SELECT
+	p.project_id,
+	p.id
+FROM payment AS p
+INNER JOIN payment_transaction AS tx ON
+	tx.project_id = p.project_id
+	AND
+	tx.payment_id = p.id
+	AND
+	tx.timestamp = (
+		SELECT MAX(timestamp) FROM payment_transaction
+		WHERE
+			project_id = p.project_id
+			AND
+			payment_id = p.id
+	)
+WHERE
+	p.project_id = ?
+	AND
+	p.created < ?
+	AND
+	tx.status = ?
+	AND
+	NOT EXISTS (
+		SELECT 1 FROM payment_transaction_current AS c
+		WHERE
+			c.project_id = p.project_id
+			AND
+			c.payment_id = p.id
+	)
+ORDER BY p.created
+LIMIT ?
+`
+
+// PaymentsOpenCreatedBeforeDB selects the IDs of the open payments of the
+// project, which were created before the given time
+//
+// The payments are selected by the summary row of their current transaction,
+// ordered by their creation. If the limit is not reached, payments without a
+// summary row are selected by their latest transaction.
+func PaymentsOpenCreatedBeforeDB(ctx context.Context, db *sql.DB, projectID int64, before time.Time, limit int) ([]PaymentID, error) {
+	rows, err := db.QueryContext(ctx, selectOpenCreatedBefore, projectID, PaymentStatusOpen, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := scanPaymentIDs(rows)
+	if err != nil || len(ids) >= limit {
+		return ids, err
+	}
+	rows, err = db.QueryContext(ctx, selectOpenCreatedBeforeWithoutCurrent, projectID, before, PaymentStatusOpen, limit-len(ids))
+	if err != nil {
+		return nil, err
+	}
+	without, err := scanPaymentIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, without...), nil
+}
+
 const selectPaymentTransactionsBefore = selectPaymentTransaction + `
 WHERE
 	tx.project_id = ?
@@ -140,8 +362,8 @@ func scanTransactions(rows *sql.Rows, p *Payment) (PaymentTransactionList, error
 // before and including the given payment transaction.
 //
 // The list will be sorted by the earliest tx first.
-func PaymentTransactionsBeforeDB(db *sql.DB, paymentTx *PaymentTransaction) (PaymentTransactionList, error) {
-	query, err := db.Query(
+func PaymentTransactionsBeforeDB(ctx context.Context, db *sql.DB, paymentTx *PaymentTransaction) (PaymentTransactionList, error) {
+	query, err := db.QueryContext(ctx,
 		selectPaymentTransactionsBefore,
 		paymentTx.Payment.ProjectID(),
 		paymentTx.Payment.ID(),
@@ -157,8 +379,25 @@ func PaymentTransactionsBeforeDB(db *sql.DB, paymentTx *PaymentTransaction) (Pay
 // before and including the given payment transaction.
 //
 // The list will be sorted by the earliest tx first.
-func PaymentTransactionsBeforeTimestampDB(db *sql.DB, p *Payment, transactionTimestamp time.Time) (PaymentTransactionList, error) {
-	query, err := db.Query(
+func PaymentTransactionsBeforeTimestampDB(ctx context.Context, db *sql.DB, p *Payment, transactionTimestamp time.Time) (PaymentTransactionList, error) {
+	query, err := db.QueryContext(ctx,
+		selectPaymentTransactionsBefore,
+		p.ProjectID(),
+		p.ID(),
+		transactionTimestamp.UnixNano(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanTransactions(query, p)
+}
+
+// PaymentTransactionsBeforeTimestampTx returns a PaymentTransactionList with all
+// transactions before and including the given transaction timestamp.
+//
+// The list will be sorted by the earliest tx first.
+func PaymentTransactionsBeforeTimestampTx(ctx context.Context, db *sql.Tx, p *Payment, transactionTimestamp time.Time) (PaymentTransactionList, error) {
+	query, err := db.QueryContext(ctx,
 		selectPaymentTransactionsBefore,
 		p.ProjectID(),
 		p.ID(),
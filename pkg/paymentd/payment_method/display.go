@@ -0,0 +1,149 @@
+package payment_method
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+var (
+	ErrDisplayNotFound = errors.New("payment method display not found")
+	ErrLogoNotFound    = errors.New("payment method logo not found")
+)
+
+const (
+	// DisplayNameMaxLen is the maximum length of display names
+	DisplayNameMaxLen = 128
+	// DefaultDisplayLocale is the locale of the display metadata which will be
+	// used if there is none in the requested language
+	DefaultDisplayLocale = "en"
+	// LogoMaxSize is the maximum size of logos in bytes
+	LogoMaxSize = 64 << 10
+)
+
+// LogoContentTypes are the accepted content types of logos
+var LogoContentTypes = []string{
+	"image/gif",
+	"image/jpeg",
+	"image/png",
+	"image/svg+xml",
+}
+
+// Display is the display metadata of a payment method in a locale
+//
+// The display metadata is shared by all payment methods with the same provider
+// and method key.
+type Display struct {
+	Provider  string
+	MethodKey string
+	// Locale is the canonical language tag, e.g. "de-AT"
+	Locale      string
+	Timestamp   time.Time
+	CreatedBy   string
+	Name        string
+	Description string
+}
+
+// Valid returns true if the display metadata can be saved
+func (d *Display) Valid() bool {
+	if d.Provider == "" || d.MethodKey == "" || d.CreatedBy == "" {
+		return false
+	}
+	if d.Name == "" || len(d.Name) > DisplayNameMaxLen {
+		return false
+	}
+	_, err := NormalizeLocale(d.Locale)
+	return err == nil
+}
+
+// NormalizeLocale returns the canonical language tag of the given locale, e.g.
+// "de-AT" for "de_AT"
+func NormalizeLocale(locale string) (string, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", err
+	}
+	return tag.String(), nil
+}
+
+// SelectDisplay returns the display metadata matching the locale best
+//
+// Display metadata in the same language will be used if there is none for the
+// locale, e.g. "de" for "de-AT". Without a match, the display metadata of the
+// DefaultDisplayLocale language will be returned.
+func SelectDisplay(displays []*Display, locale string) (*Display, bool) {
+	if d, ok := selectDisplay(displays, locale); ok {
+		return d, true
+	}
+	return selectDisplay(displays, DefaultDisplayLocale)
+}
+
+// MatchDisplay returns the display metadata matching the locale or its
+// language
+//
+// Unlike SelectDisplay, it does not fall back to the DefaultDisplayLocale.
+func MatchDisplay(displays []*Display, locale string) (*Display, bool) {
+	return selectDisplay(displays, locale)
+}
+
+type displaysByLocale []*Display
+
+func (d displaysByLocale) Len() int           { return len(d) }
+func (d displaysByLocale) Less(i, j int) bool { return d[i].Locale < d[j].Locale }
+func (d displaysByLocale) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+func selectDisplay(displays []*Display, locale string) (*Display, bool) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return nil, false
+	}
+	base, _ := tag.Base()
+	var sameLang *Display
+	for _, d := range displays {
+		dTag, err := language.Parse(d.Locale)
+		if err != nil {
+			continue
+		}
+		if dTag == tag {
+			return d, true
+		}
+		if dBase, _ := dTag.Base(); sameLang == nil && dBase == base {
+			sameLang = d
+		}
+	}
+	return sameLang, sameLang != nil
+}
+
+// Logo is the logo of a payment method
+//
+// The logo is shared by all payment methods with the same provider and method
+// key.
+type Logo struct {
+	Provider    string
+	MethodKey   string
+	Timestamp   time.Time
+	CreatedBy   string
+	ContentType string
+	Data        []byte
+}
+
+// ValidLogoContentType returns true if logos can have the given content type
+func ValidLogoContentType(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, ct := range LogoContentTypes {
+		if ct == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// ETag returns the entity tag of the logo
+func (l *Logo) ETag() string {
+	sum := sha256.Sum256(l.Data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
@@ -0,0 +1,190 @@
+package payment_method
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const selectDisplaysByMethod = `
+SELECT
+	d.provider,
+	d.method_key,
+	d.locale,
+	d.timestamp,
+	d.created_by,
+	d.name,
+	d.description
+FROM payment_method_display AS d
+WHERE
+	d.provider = ?
+	AND
+	d.method_key = ?
+	AND
+	d.timestamp = (
+		SELECT MAX(timestamp) FROM payment_method_display
+		WHERE
+			provider = d.provider
+			AND
+			method_key = d.method_key
+			AND
+			locale = d.locale
+	)
+ORDER BY d.locale
+`
+
+// DisplaysByMethodDB selects the current display metadata of all locales of
+// the payment methods with the given provider and method key
+func DisplaysByMethodDB(ctx context.Context, db *sql.DB, provider, methodKey string) ([]*Display, error) {
+	rows, err := db.QueryContext(ctx, selectDisplaysByMethod, provider, methodKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	displays := make([]*Display, 0, 4)
+	for rows.Next() {
+		d := &Display{}
+		var ts int64
+		var description sql.NullString
+		err = rows.Scan(
+			&d.Provider,
+			&d.MethodKey,
+			&d.Locale,
+			&ts,
+			&d.CreatedBy,
+			&d.Name,
+			&description,
+		)
+		if err != nil {
+			return nil, err
+		}
+		d.Timestamp = time.Unix(0, ts)
+		d.Description = description.String
+		displays = append(displays, d)
+	}
+	return displays, rows.Err()
+}
+
+const insertDisplay = `
+INSERT INTO payment_method_display
+(provider, method_key, locale, timestamp, created_by, name, description)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertDisplayTx saves the display metadata
+//
+// It replaces the display metadata of the locale.
+func InsertDisplayTx(ctx context.Context, db *sql.Tx, d *Display) error {
+	stmt, err := db.PrepareContext(ctx, insertDisplay)
+	if err != nil {
+		return err
+	}
+	var description sql.NullString
+	if d.Description != "" {
+		description.String, description.Valid = d.Description, true
+	}
+	_, err = stmt.ExecContext(ctx,
+		d.Provider,
+		d.MethodKey,
+		d.Locale,
+		d.Timestamp.UnixNano(),
+		d.CreatedBy,
+		d.Name,
+		description,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectLogoByMethod = `
+SELECT
+	l.provider,
+	l.method_key,
+	l.timestamp,
+	l.created_by,
+	l.content_type,
+	l.data
+FROM payment_method_logo AS l
+WHERE
+	l.provider = ?
+	AND
+	l.method_key = ?
+ORDER BY l.timestamp DESC
+LIMIT 1
+`
+
+// LogoByMethodDB selects the current logo of the payment methods with the given
+// provider and method key
+func LogoByMethodDB(ctx context.Context, db *sql.DB, provider, methodKey string) (*Logo, error) {
+	l := &Logo{}
+	var ts int64
+	err := db.QueryRowContext(ctx, selectLogoByMethod, provider, methodKey).Scan(
+		&l.Provider,
+		&l.MethodKey,
+		&ts,
+		&l.CreatedBy,
+		&l.ContentType,
+		&l.Data,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrLogoNotFound
+		}
+		return nil, err
+	}
+	l.Timestamp = time.Unix(0, ts)
+	return l, nil
+}
+
+const selectLogoTimestampByMethod = `
+SELECT MAX(timestamp) FROM payment_method_logo
+WHERE
+	provider = ?
+	AND
+	method_key = ?
+`
+
+// LogoTimestampByMethodDB selects the time the current logo of the payment
+// methods with the given provider and method key was saved
+//
+// It can be used to check for a logo without loading it.
+func LogoTimestampByMethodDB(ctx context.Context, db *sql.DB, provider, methodKey string) (time.Time, error) {
+	var ts sql.NullInt64
+	err := db.QueryRowContext(ctx, selectLogoTimestampByMethod, provider, methodKey).Scan(&ts)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ts.Valid {
+		return time.Time{}, ErrLogoNotFound
+	}
+	return time.Unix(0, ts.Int64), nil
+}
+
+const insertLogo = `
+INSERT INTO payment_method_logo
+(provider, method_key, timestamp, created_by, content_type, data)
+VALUES
+(?, ?, ?, ?, ?, ?)
+`
+
+// InsertLogoTx saves the logo
+//
+// It replaces the current logo.
+func InsertLogoTx(ctx context.Context, db *sql.Tx, l *Logo) error {
+	stmt, err := db.PrepareContext(ctx, insertLogo)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		l.Provider,
+		l.MethodKey,
+		l.Timestamp.UnixNano(),
+		l.CreatedBy,
+		l.ContentType,
+		l.Data,
+	)
+	stmt.Close()
+	return err
+}
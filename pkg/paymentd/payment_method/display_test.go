@@ -0,0 +1,119 @@
+package payment_method
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDisplay(t *testing.T) {
+	Convey("Given display metadata in several locales", t, func() {
+		displays := []*Display{
+			{Locale: "de", Name: "Kreditkarte"},
+			{Locale: "de-AT", Name: "Kreditkarte (AT)"},
+			{Locale: "en", Name: "Credit card"},
+		}
+
+		Convey("It should select the exact locale", func() {
+			d, ok := SelectDisplay(displays, "de-AT")
+			So(ok, ShouldBeTrue)
+			So(d.Name, ShouldEqual, "Kreditkarte (AT)")
+		})
+		Convey("It should accept payment locales", func() {
+			d, ok := SelectDisplay(displays, "de_AT")
+			So(ok, ShouldBeTrue)
+			So(d.Name, ShouldEqual, "Kreditkarte (AT)")
+		})
+		Convey("It should fall back to the same language", func() {
+			d, ok := SelectDisplay(displays, "de_CH")
+			So(ok, ShouldBeTrue)
+			So(d.Name, ShouldEqual, "Kreditkarte")
+		})
+		Convey("It should fall back to the default locale", func() {
+			d, ok := SelectDisplay(displays, "fr_FR")
+			So(ok, ShouldBeTrue)
+			So(d.Name, ShouldEqual, "Credit card")
+		})
+		Convey("It should not select anything without a default", func() {
+			_, ok := SelectDisplay(displays[:2], "fr_FR")
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given display metadata", t, func() {
+		d := &Display{
+			Provider:  "fritzpay",
+			MethodKey: "card",
+			Locale:    "en",
+			CreatedBy: "test",
+			Name:      "Credit card",
+		}
+		So(d.Valid(), ShouldBeTrue)
+
+		Convey("It should be invalid without a name", func() {
+			d.Name = ""
+			So(d.Valid(), ShouldBeFalse)
+		})
+		Convey("It should be invalid with an invalid locale", func() {
+			d.Locale = "not a locale"
+			So(d.Valid(), ShouldBeFalse)
+		})
+	})
+
+	Convey("When normalizing a payment locale", t, func() {
+		locale, err := NormalizeLocale("de_AT")
+
+		Convey("It should return the language tag", func() {
+			So(err, ShouldBeNil)
+			So(locale, ShouldEqual, "de-AT")
+		})
+	})
+
+	Convey("Given logo content types", t, func() {
+		Convey("It should accept images", func() {
+			So(ValidLogoContentType("image/png"), ShouldBeTrue)
+			So(ValidLogoContentType("image/svg+xml; charset=utf-8"), ShouldBeTrue)
+		})
+		Convey("It should reject other content types", func() {
+			So(ValidLogoContentType("text/html"), ShouldBeFalse)
+			So(ValidLogoContentType(""), ShouldBeFalse)
+		})
+	})
+}
+
+func TestProjectDisplays(t *testing.T) {
+	Convey("Given a payment method with display metadata of its project", t, func() {
+		m := &Method{MethodKey: "card"}
+		m.Provider.Name = "fritzpay"
+		m.Metadata = map[string]string{
+			MetadataKeyCurrencies:                         "EUR",
+			MetadataKeyDisplayNamePrefix + "pt-BR":        "Cartão de crédito",
+			MetadataKeyDisplayDescriptionPrefix + "pt-BR": "Parcelamento",
+			MetadataKeyDisplayNamePrefix + "de":           "Karte",
+			MetadataKeyDisplayNamePrefix + "fr":           "",
+			MetadataKeyDisplayDescriptionPrefix + "fr":    "removed",
+		}
+
+		displays := m.ProjectDisplays()
+
+		Convey("It should return the displays with a name ordered by locale", func() {
+			So(len(displays), ShouldEqual, 2)
+			So(*displays[0], ShouldResemble, Display{Provider: "fritzpay", MethodKey: "card", Locale: "de", Name: "Karte"})
+			So(displays[1].Locale, ShouldEqual, "pt-BR")
+			So(displays[1].Description, ShouldEqual, "Parcelamento")
+		})
+		Convey("It should match the language without a default", func() {
+			d, ok := MatchDisplay(displays, "pt_PT")
+			So(ok, ShouldBeTrue)
+			So(d.Name, ShouldEqual, "Cartão de crédito")
+			_, ok = MatchDisplay(displays, "en")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("It should return the metadata setting a display", func() {
+			So(DisplayMetadata(displays[0]), ShouldResemble, map[string]string{
+				"display_name.de":        "Karte",
+				"display_description.de": "",
+			})
+		})
+	})
+}
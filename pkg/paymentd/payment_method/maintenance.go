@@ -0,0 +1,61 @@
+package payment_method
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrMaintenanceNotFound = errors.New("payment method maintenance not found")
+)
+
+const (
+	// MaintenanceReasonMaxLen is the maximum length of maintenance reasons
+	MaintenanceReasonMaxLen = 255
+)
+
+// Maintenance is a maintenance window of the provider of a payment method
+//
+// During the maintenance window the payment method will not be offered and
+// payments with the payment method can not be initialized.
+type Maintenance struct {
+	ID              int64 `json:",string"`
+	PaymentMethodID int64 `json:",string"`
+	Start           time.Time
+	End             time.Time
+	Created         time.Time
+	CreatedBy       string
+	Reason          string
+}
+
+// Valid returns true if the maintenance window can be saved
+func (m *Maintenance) Valid() bool {
+	if m.PaymentMethodID == 0 || m.CreatedBy == "" {
+		return false
+	}
+	if m.Start.IsZero() || !m.End.After(m.Start) {
+		return false
+	}
+	return len(m.Reason) <= MaintenanceReasonMaxLen
+}
+
+// Active returns true if the maintenance window covers the given time
+func (m *Maintenance) Active(t time.Time) bool {
+	return !t.Before(m.Start) && t.Before(m.End)
+}
+
+// ActiveMaintenance returns the maintenance window covering the given time
+//
+// If maintenance windows overlap, the one ending last will be returned.
+func ActiveMaintenance(windows []*Maintenance, t time.Time) (*Maintenance, bool) {
+	var active *Maintenance
+	for _, m := range windows {
+		if !m.Active(t) {
+			continue
+		}
+		if active == nil || m.End.After(active.End) {
+			active = m
+		}
+	}
+	return active, active != nil
+}
@@ -0,0 +1,159 @@
+package payment_method
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const selectMaintenance = `
+SELECT
+	m.id,
+	m.payment_method_id,
+	m.starts,
+	m.ends,
+	m.created,
+	m.created_by,
+	m.reason
+FROM payment_method_maintenance AS m
+`
+
+const selectMaintenanceByMethodID = selectMaintenance + `
+WHERE
+	m.payment_method_id = ?
+	AND
+	m.ends > ?
+ORDER BY m.starts, m.id
+`
+
+const selectActiveMaintenanceByProjectID = selectMaintenance + `
+INNER JOIN payment_method AS pm ON
+	pm.id = m.payment_method_id
+WHERE
+	pm.project_id = ?
+	AND
+	m.starts <= ?
+	AND
+	m.ends > ?
+ORDER BY m.starts, m.id
+`
+
+func scanMaintenance(rows *sql.Rows) ([]*Maintenance, error) {
+	windows := make([]*Maintenance, 0, 4)
+	for rows.Next() {
+		m := &Maintenance{}
+		var start, end, created int64
+		var reason sql.NullString
+		err := rows.Scan(
+			&m.ID,
+			&m.PaymentMethodID,
+			&start,
+			&end,
+			&created,
+			&m.CreatedBy,
+			&reason,
+		)
+		if err != nil {
+			return nil, err
+		}
+		m.Start, m.End, m.Created = time.Unix(0, start), time.Unix(0, end), time.Unix(0, created)
+		m.Reason = reason.String
+		windows = append(windows, m)
+	}
+	return windows, rows.Err()
+}
+
+// MaintenanceByMethodIDDB selects the maintenance windows of the payment method
+// which did not end before the given time
+func MaintenanceByMethodIDDB(ctx context.Context, db *sql.DB, methodID int64, since time.Time) ([]*Maintenance, error) {
+	rows, err := db.QueryContext(ctx, selectMaintenanceByMethodID, methodID, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMaintenance(rows)
+}
+
+// MaintenanceByMethodIDTx selects the maintenance windows of the payment method
+// which did not end before the given time
+func MaintenanceByMethodIDTx(ctx context.Context, db *sql.Tx, methodID int64, since time.Time) ([]*Maintenance, error) {
+	rows, err := db.QueryContext(ctx, selectMaintenanceByMethodID, methodID, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMaintenance(rows)
+}
+
+// ActiveMaintenanceByProjectIDDB selects the maintenance windows of the payment
+// methods of the project which cover the given time
+func ActiveMaintenanceByProjectIDDB(ctx context.Context, db *sql.DB, projectID int64, t time.Time) ([]*Maintenance, error) {
+	rows, err := db.QueryContext(ctx, selectActiveMaintenanceByProjectID, projectID, t.UnixNano(), t.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMaintenance(rows)
+}
+
+const insertMaintenance = `
+INSERT INTO payment_method_maintenance
+(payment_method_id, starts, ends, created, created_by, reason)
+VALUES
+(?, ?, ?, ?, ?, ?)
+`
+
+// InsertMaintenanceTx saves a new maintenance window and sets its ID
+func InsertMaintenanceTx(ctx context.Context, db *sql.Tx, m *Maintenance) error {
+	stmt, err := db.PrepareContext(ctx, insertMaintenance)
+	if err != nil {
+		return err
+	}
+	var reason sql.NullString
+	if m.Reason != "" {
+		reason.String, reason.Valid = m.Reason, true
+	}
+	res, err := stmt.ExecContext(ctx,
+		m.PaymentMethodID,
+		m.Start.UnixNano(),
+		m.End.UnixNano(),
+		m.Created.UnixNano(),
+		m.CreatedBy,
+		reason,
+	)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	m.ID, err = res.LastInsertId()
+	return err
+}
+
+const deleteMaintenance = `
+DELETE FROM payment_method_maintenance
+WHERE
+	payment_method_id = ?
+	AND
+	id = ?
+`
+
+// DeleteMaintenanceTx removes the maintenance window with the given ID from the
+// payment method
+//
+// It returns ErrMaintenanceNotFound if the payment method has no such
+// maintenance window.
+func DeleteMaintenanceTx(ctx context.Context, db *sql.Tx, methodID, id int64) error {
+	res, err := db.ExecContext(ctx, deleteMaintenance, methodID, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMaintenanceNotFound
+	}
+	return nil
+}
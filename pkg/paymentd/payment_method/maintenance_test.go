@@ -0,0 +1,59 @@
+package payment_method
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMaintenance(t *testing.T) {
+	Convey("Given a maintenance window", t, func() {
+		start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+		m := &Maintenance{
+			PaymentMethodID: 1,
+			Start:           start,
+			End:             start.Add(2 * time.Hour),
+			CreatedBy:       "test",
+		}
+
+		Convey("It should be valid", func() {
+			So(m.Valid(), ShouldBeTrue)
+		})
+
+		Convey("When it ends before it starts", func() {
+			m.End = start.Add(-time.Hour)
+			Convey("It should be invalid", func() {
+				So(m.Valid(), ShouldBeFalse)
+			})
+		})
+
+		Convey("It should be active from the start until the end", func() {
+			So(m.Active(start.Add(-time.Second)), ShouldBeFalse)
+			So(m.Active(start), ShouldBeTrue)
+			So(m.Active(start.Add(time.Hour)), ShouldBeTrue)
+			So(m.Active(m.End), ShouldBeFalse)
+		})
+
+		Convey("Given an overlapping maintenance window", func() {
+			o := &Maintenance{
+				PaymentMethodID: 1,
+				Start:           start.Add(time.Hour),
+				End:             start.Add(3 * time.Hour),
+				CreatedBy:       "test",
+			}
+			windows := []*Maintenance{m, o}
+
+			Convey("The window ending last should be active", func() {
+				active, ok := ActiveMaintenance(windows, start.Add(90*time.Minute))
+				So(ok, ShouldBeTrue)
+				So(active, ShouldEqual, o)
+			})
+
+			Convey("No window should be active after the end", func() {
+				_, ok := ActiveMaintenance(windows, o.End)
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -4,6 +4,9 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/provider"
@@ -75,6 +78,33 @@ type Method struct {
 	Metadata map[string]string
 }
 
+// Capability metadata keys of payment methods
+const (
+	// MetadataKeyCurrencies holds the comma-separated ISO 4217 codes of the
+	// currencies the payment method can process
+	MetadataKeyCurrencies = "currencies"
+	// MetadataKeyCurrencyFallback holds the ID of the payment method which will
+	// be used for payments in currencies the payment method cannot process
+	MetadataKeyCurrencyFallback = "currency_fallback"
+	// MetadataKeyDomesticDebitRoute holds the ID of the payment method which
+	// will be used for payments with debit cards issued in the payment country
+	MetadataKeyDomesticDebitRoute = "domestic_debit_route"
+)
+
+// Display metadata keys of payment methods
+//
+// The keys are followed by the canonical language tag of the locale, e.g.
+// "display_name.de-AT". They override the display metadata shared by all
+// payment methods with the same provider and method key.
+const (
+	// MetadataKeyDisplayNamePrefix prefixes the keys holding the display name
+	// of the payment method in a locale
+	MetadataKeyDisplayNamePrefix = "display_name."
+	// MetadataKeyDisplayDescriptionPrefix prefixes the keys holding the
+	// display description of the payment method in a locale
+	MetadataKeyDisplayDescriptionPrefix = "display_description."
+)
+
 // Active returns true if the payment method is considered active
 func (m *Method) Active() bool {
 	return m.Status == PaymentMethodStatusActive
@@ -101,3 +131,74 @@ func (m metadataModel) Table() string {
 func (m metadataModel) PrimaryField() string {
 	return metadataPrimaryField
 }
+
+// SupportsCurrency returns true if the payment method can process payments in
+// the given currency
+//
+// Payment methods without the currencies capability are assumed to process all
+// currencies.
+func (m *Method) SupportsCurrency(code string) bool {
+	currencies := strings.TrimSpace(m.Metadata[MetadataKeyCurrencies])
+	if currencies == "" {
+		return true
+	}
+	for _, c := range strings.Split(currencies, ",") {
+		if strings.EqualFold(strings.TrimSpace(c), code) {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrencyFallbackID returns the ID of the payment method which should be used
+// for payments in currencies the payment method cannot process
+func (m *Method) CurrencyFallbackID() (int64, bool) {
+	return m.metadataMethodID(MetadataKeyCurrencyFallback)
+}
+
+// DomesticDebitRouteID returns the ID of the payment method which should be
+// used for payments with domestic debit cards
+func (m *Method) DomesticDebitRouteID() (int64, bool) {
+	return m.metadataMethodID(MetadataKeyDomesticDebitRoute)
+}
+
+// ProjectDisplays returns the display metadata of the payment method set by
+// its project
+//
+// Locales without a display name will be ignored. The displays are ordered by
+// locale.
+func (m *Method) ProjectDisplays() []*Display {
+	displays := make([]*Display, 0)
+	for key, name := range m.Metadata {
+		if !strings.HasPrefix(key, MetadataKeyDisplayNamePrefix) || name == "" {
+			continue
+		}
+		locale := strings.TrimPrefix(key, MetadataKeyDisplayNamePrefix)
+		displays = append(displays, &Display{
+			Provider:    m.Provider.Name,
+			MethodKey:   m.MethodKey,
+			Locale:      locale,
+			Name:        name,
+			Description: m.Metadata[MetadataKeyDisplayDescriptionPrefix+locale],
+		})
+	}
+	sort.Sort(displaysByLocale(displays))
+	return displays
+}
+
+// DisplayMetadata returns the metadata values which set the display metadata
+// of the payment method in the locale of the given display
+func DisplayMetadata(d *Display) map[string]string {
+	return map[string]string{
+		MetadataKeyDisplayNamePrefix + d.Locale:        d.Name,
+		MetadataKeyDisplayDescriptionPrefix + d.Locale: d.Description,
+	}
+}
+
+func (m *Method) metadataMethodID(key string) (int64, bool) {
+	id, err := strconv.ParseInt(strings.TrimSpace(m.Metadata[key]), 10, 64)
+	if err != nil || id == 0 || id == m.ID {
+		return 0, false
+	}
+	return id, true
+}
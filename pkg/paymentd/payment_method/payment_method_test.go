@@ -31,3 +31,77 @@ func TestPaymentMethodStatus(t *testing.T) {
 		})
 	})
 }
+
+func TestPaymentMethodCurrencyCapability(t *testing.T) {
+	Convey("Given a payment method without capability metadata", t, func() {
+		m := &Method{ID: 1}
+
+		Convey("It should support any currency", func() {
+			So(m.SupportsCurrency("EUR"), ShouldBeTrue)
+			So(m.SupportsCurrency("JPY"), ShouldBeTrue)
+		})
+		Convey("It should not have a currency fallback", func() {
+			_, ok := m.CurrencyFallbackID()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When the currencies capability is set", func() {
+			m.Metadata = map[string]string{MetadataKeyCurrencies: "EUR, usd"}
+
+			Convey("It should support the listed currencies", func() {
+				So(m.SupportsCurrency("EUR"), ShouldBeTrue)
+				So(m.SupportsCurrency("USD"), ShouldBeTrue)
+			})
+			Convey("It should not support other currencies", func() {
+				So(m.SupportsCurrency("JPY"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When a currency fallback is set", func() {
+			m.Metadata = map[string]string{MetadataKeyCurrencyFallback: "2"}
+
+			Convey("It should return the fallback", func() {
+				id, ok := m.CurrencyFallbackID()
+				So(ok, ShouldBeTrue)
+				So(id, ShouldEqual, 2)
+			})
+		})
+		Convey("When the currency fallback refers to the method itself", func() {
+			m.Metadata = map[string]string{MetadataKeyCurrencyFallback: "1"}
+
+			Convey("It should be ignored", func() {
+				_, ok := m.CurrencyFallbackID()
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestPaymentMethodCardRoutes(t *testing.T) {
+	Convey("Given a payment method without card routes", t, func() {
+		m := &Method{ID: 1}
+
+		Convey("It should not have a domestic debit route", func() {
+			_, ok := m.DomesticDebitRouteID()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When a domestic debit route is set", func() {
+			m.Metadata = map[string]string{MetadataKeyDomesticDebitRoute: " 3 "}
+
+			Convey("It should return the route", func() {
+				id, ok := m.DomesticDebitRouteID()
+				So(ok, ShouldBeTrue)
+				So(id, ShouldEqual, 3)
+			})
+		})
+		Convey("When the domestic debit route is invalid", func() {
+			m.Metadata = map[string]string{MetadataKeyDomesticDebitRoute: "cheap"}
+
+			Convey("It should be ignored", func() {
+				_, ok := m.DomesticDebitRouteID()
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
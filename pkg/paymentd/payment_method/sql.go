@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/fritzpay/paymentd/pkg/metadata"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -50,7 +51,19 @@ AND
 	m.method_key = ?
 `
 
-func scanSinglePaymentMethod(row *sql.Row) (*Method, error) {
+const selectPaymentMethodsByProjectID = selectPaymentMethod + `
+WHERE
+	m.project_id = ?
+ORDER BY m.id
+`
+
+const selectPaymentMethodsByStatus = selectPaymentMethod + `
+WHERE
+	s.status = ?
+ORDER BY m.id
+`
+
+func scanSinglePaymentMethod(row resultScanner) (*Method, error) {
 	pm := &Method{}
 	var ts int64
 	err := row.Scan(
@@ -74,23 +87,70 @@ func scanSinglePaymentMethod(row *sql.Row) (*Method, error) {
 	return pm, nil
 }
 
-func PaymentMethodByIDDB(db *sql.DB, id int64) (*Method, error) {
-	row := db.QueryRow(selectPaymentMethodByID, id)
+type resultScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPaymentMethods(rows *sql.Rows) ([]*Method, error) {
+	methods := make([]*Method, 0, 8)
+	for rows.Next() {
+		pm, err := scanSinglePaymentMethod(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		methods = append(methods, pm)
+	}
+	err := rows.Err()
+	rows.Close()
+	return methods, err
+}
+
+// PaymentMethodsByProjectIDTx selects all payment methods of the given project
+func PaymentMethodsByProjectIDTx(ctx context.Context, db *sql.Tx, projectID int64) ([]*Method, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentMethodsByProjectID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentMethods(rows)
+}
+
+// PaymentMethodsByProjectIDDB selects all payment methods of the given project
+func PaymentMethodsByProjectIDDB(ctx context.Context, db *sql.DB, projectID int64) ([]*Method, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentMethodsByProjectID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentMethods(rows)
+}
+
+// PaymentMethodsByStatusDB selects the payment methods of all projects with
+// the given current status
+func PaymentMethodsByStatusDB(ctx context.Context, db *sql.DB, status methodStatus) ([]*Method, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentMethodsByStatus, status.String())
+	if err != nil {
+		return nil, err
+	}
+	return scanPaymentMethods(rows)
+}
+
+func PaymentMethodByIDDB(ctx context.Context, db *sql.DB, id int64) (*Method, error) {
+	row := db.QueryRowContext(ctx, selectPaymentMethodByID, id)
 	return scanSinglePaymentMethod(row)
 }
 
-func PaymentMethodByProjectIDProviderNameMethodKeyDB(db *sql.DB, project_id int64, provider string, method_key string) (*Method, error) {
-	row := db.QueryRow(selectPaymentMethodByProjectIDProviderIDMethodKey, project_id, provider, method_key)
+func PaymentMethodByProjectIDProviderNameMethodKeyDB(ctx context.Context, db *sql.DB, project_id int64, provider string, method_key string) (*Method, error) {
+	row := db.QueryRowContext(ctx, selectPaymentMethodByProjectIDProviderIDMethodKey, project_id, provider, method_key)
 	return scanSinglePaymentMethod(row)
 }
 
-func PaymentMethodByProjectIDProviderNameMethodKeyTx(tx *sql.Tx, project_id int64, provider string, method_key string) (*Method, error) {
-	row := tx.QueryRow(selectPaymentMethodByProjectIDProviderIDMethodKey, project_id, provider, method_key)
+func PaymentMethodByProjectIDProviderNameMethodKeyTx(ctx context.Context, tx *sql.Tx, project_id int64, provider string, method_key string) (*Method, error) {
+	row := tx.QueryRowContext(ctx, selectPaymentMethodByProjectIDProviderIDMethodKey, project_id, provider, method_key)
 	return scanSinglePaymentMethod(row)
 }
 
-func PaymentMethodByIDTx(db *sql.Tx, id int64) (*Method, error) {
-	row := db.QueryRow(selectPaymentMethodByID, id)
+func PaymentMethodByIDTx(ctx context.Context, db *sql.Tx, id int64) (*Method, error) {
+	row := db.QueryRowContext(ctx, selectPaymentMethodByID, id)
 	return scanSinglePaymentMethod(row)
 }
 
@@ -101,12 +161,12 @@ VALUES
 (?, ?, ?, ?, ?)
 `
 
-func InsertPaymentMethodTx(db *sql.Tx, pm *Method) error {
-	stmt, err := db.Prepare(insertPaymentMethod)
+func InsertPaymentMethodTx(ctx context.Context, db *sql.Tx, pm *Method) error {
+	stmt, err := db.PrepareContext(ctx, insertPaymentMethod)
 	if err != nil {
 		return err
 	}
-	res, err := stmt.Exec(pm.ProjectID, pm.Provider.Name, pm.MethodKey, pm.Created, pm.CreatedBy)
+	res, err := stmt.ExecContext(ctx, pm.ProjectID, pm.Provider.Name, pm.MethodKey, pm.Created, pm.CreatedBy)
 	stmt.Close()
 	if err != nil {
 		return err
@@ -121,18 +181,18 @@ INSERT INTO payment_method_status
 VALUES
 (?, ?, ?, ?)`
 
-func InsertPaymentMethodStatusTx(db *sql.Tx, pm *Method) error {
-	stmt, err := db.Prepare(insertPaymentMethodStatus)
+func InsertPaymentMethodStatusTx(ctx context.Context, db *sql.Tx, pm *Method) error {
+	stmt, err := db.PrepareContext(ctx, insertPaymentMethodStatus)
 	if err != nil {
 		return err
 	}
 	ts := time.Now()
-	_, err = stmt.Exec(pm.ID, ts.UnixNano(), pm.Status, pm.StatusCreatedBy)
+	_, err = stmt.ExecContext(ctx, pm.ID, ts.UnixNano(), pm.Status, pm.StatusCreatedBy)
 	stmt.Close()
 	return err
 }
 
-func InsertPaymentMethodMetadataTx(db *sql.Tx, pm *Method, createdBy string) error {
+func InsertPaymentMethodMetadataTx(ctx context.Context, db *sql.Tx, pm *Method, createdBy string) error {
 	if pm.ID == 0 {
 		return ErrPaymentMethodWithoutID
 	}
@@ -140,7 +200,7 @@ func InsertPaymentMethodMetadataTx(db *sql.Tx, pm *Method, createdBy string) err
 	return metadata.InsertMetadataTx(db, MetadataModel, pm.ID, m)
 }
 
-func PaymentMethodMetadataTx(db *sql.Tx, pm *Method) (map[string]string, error) {
+func PaymentMethodMetadataTx(ctx context.Context, db *sql.Tx, pm *Method) (map[string]string, error) {
 	if pm.ID == 0 {
 		return nil, ErrPaymentMethodWithoutID
 	}
@@ -150,3 +210,16 @@ func PaymentMethodMetadataTx(db *sql.Tx, pm *Method) (map[string]string, error)
 	}
 	return m.Values(), nil
 }
+
+// PaymentMethodMetadataDB selects the current metadata of the given payment
+// method
+func PaymentMethodMetadataDB(ctx context.Context, db *sql.DB, pm *Method) (map[string]string, error) {
+	if pm.ID == 0 {
+		return nil, ErrPaymentMethodWithoutID
+	}
+	m, err := metadata.MetadataByPrimaryDB(db, MetadataModel, pm.ID)
+	if err != nil {
+		return nil, err
+	}
+	return m.Values(), nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/fritzpay/paymentd/pkg/paymentd/provider"
 	"github.com/fritzpay/paymentd/pkg/testutil"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
 )
 
 func TestPaymentMethodSQL(t *testing.T) {
@@ -27,7 +28,7 @@ func TestPaymentMethodSQL(t *testing.T) {
 				So(princ.Empty(), ShouldBeFalse)
 
 				Convey("Given a test project", func() {
-					proj, err := project.ProjectByPrincipalIDNameDB(prDB, princ.ID, "testproject")
+					proj, err := project.ProjectByPrincipalIDNameDB(context.Background(), prDB, princ.ID, "testproject")
 					So(err, ShouldBeNil)
 
 					Convey("Given a transaction", func() {
@@ -40,19 +41,19 @@ func TestPaymentMethodSQL(t *testing.T) {
 						})
 
 						Convey("Given a test provider exists", func() {
-							pr, err := provider.ProviderByNameTx(tx, "fritzpay")
+							pr, err := provider.ProviderByNameTx(context.Background(), tx, "fritzpay")
 							So(err, ShouldBeNil)
 							So(pr.Name, ShouldEqual, "fritzpay")
 
 							Convey("When retrieving a nonexistent payment method", func() {
-								_, err = PaymentMethodByProjectIDProviderNameMethodKeyTx(tx, proj.ID, pr.Name, "nonexistent")
+								_, err = PaymentMethodByProjectIDProviderNameMethodKeyTx(context.Background(), tx, proj.ID, pr.Name, "nonexistent")
 								Convey("It should return a not found error", func() {
 									So(err, ShouldEqual, ErrPaymentMethodNotFound)
 								})
 							})
 
 							Convey("When retrieving an existent payment method", func() {
-								pm, err := PaymentMethodByProjectIDProviderNameMethodKeyTx(tx, proj.ID, pr.Name, "test")
+								pm, err := PaymentMethodByProjectIDProviderNameMethodKeyTx(context.Background(), tx, proj.ID, pr.Name, "test")
 								Convey("It should return a payment method", func() {
 									So(err, ShouldBeNil)
 									So(pm.MethodKey, ShouldEqual, "test")
@@ -66,7 +67,7 @@ func TestPaymentMethodSQL(t *testing.T) {
 								pm.MethodKey = "testInsert"
 								pm.CreatedBy = "test"
 
-								err = InsertPaymentMethodTx(tx, pm)
+								err = InsertPaymentMethodTx(context.Background(), tx, pm)
 								So(err, ShouldBeNil)
 								So(pm.ID, ShouldNotEqual, 0)
 
@@ -74,11 +75,11 @@ func TestPaymentMethodSQL(t *testing.T) {
 									pm.Status = PaymentMethodStatusActive
 									pm.CreatedBy = "test"
 
-									err = InsertPaymentMethodStatusTx(tx, pm)
+									err = InsertPaymentMethodStatusTx(context.Background(), tx, pm)
 									So(err, ShouldBeNil)
 
 									Convey("When retrieving the payment method", func() {
-										newPm, err := PaymentMethodByIDTx(tx, pm.ID)
+										newPm, err := PaymentMethodByIDTx(context.Background(), tx, pm.ID)
 										So(err, ShouldBeNil)
 
 										Convey("The retrieved payment method should match", func() {
@@ -92,11 +93,11 @@ func TestPaymentMethodSQL(t *testing.T) {
 										"name": "value",
 										"test": "check",
 									}
-									err = InsertPaymentMethodMetadataTx(tx, pm, "metatest")
+									err = InsertPaymentMethodMetadataTx(context.Background(), tx, pm, "metatest")
 									So(err, ShouldBeNil)
 
 									Convey("When selecting metadata", func() {
-										metadata, err := PaymentMethodMetadataTx(tx, pm)
+										metadata, err := PaymentMethodMetadataTx(context.Background(), tx, pm)
 										So(err, ShouldBeNil)
 
 										Convey("It should match", func() {
@@ -0,0 +1,19 @@
+package project
+
+import (
+	"time"
+)
+
+// CallbackKeyRotation is a change of the callback project key of a project
+type CallbackKeyRotation struct {
+	// PreviousKey is the callback project key before the change
+	PreviousKey string
+	// Rotated is the time of the config change which replaced the previous key
+	Rotated time.Time
+}
+
+// InOverlap returns true if notifications should be signed with the previous
+// key at the given time
+func (r *CallbackKeyRotation) InOverlap(overlap time.Duration, now time.Time) bool {
+	return overlap > 0 && now.Before(r.Rotated.Add(overlap))
+}
@@ -0,0 +1,59 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrCallbackKeyRotationNotFound will be returned when the callback project
+	// key of a project was never changed
+	ErrCallbackKeyRotationNotFound = errors.New("callback key rotation not found")
+)
+
+const selectCallbackKeyRotation = `
+SELECT
+	c.callback_project_key,
+	UNIX_TIMESTAMP((
+		SELECT MIN(n.timestamp) FROM project_config AS n
+		WHERE
+			n.project_id = c.project_id
+			AND
+			n.timestamp > c.timestamp
+	))
+FROM project_config AS c
+WHERE
+	c.project_id = ?
+	AND
+	c.callback_project_key IS NOT NULL
+	AND
+	c.callback_project_key <> ?
+ORDER BY c.timestamp DESC
+LIMIT 1
+`
+
+// CallbackKeyRotationDB selects the latest change of the callback project key
+// of the project to the given current key
+//
+// It returns an ErrCallbackKeyRotationNotFound if the project was never
+// configured with another callback project key.
+func CallbackKeyRotationDB(ctx context.Context, db *sql.DB, projectID int64, currentKey string) (*CallbackKeyRotation, error) {
+	r := &CallbackKeyRotation{}
+	var rotated sql.NullInt64
+	err := db.QueryRowContext(ctx, selectCallbackKeyRotation, projectID, currentKey).Scan(&r.PreviousKey, &rotated)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrCallbackKeyRotationNotFound
+		}
+		return nil, err
+	}
+	// the previous key is still the current key
+	if !rotated.Valid {
+		return nil, ErrCallbackKeyRotationNotFound
+	}
+	r.Rotated = time.Unix(rotated.Int64, 0)
+	return r, nil
+}
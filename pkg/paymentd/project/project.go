@@ -3,7 +3,40 @@ package project
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/fritzpay/paymentd/pkg/billing"
+	"github.com/fritzpay/paymentd/pkg/descriptor"
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"github.com/fritzpay/paymentd/pkg/paymentd/method_preference"
+	"github.com/fritzpay/paymentd/pkg/paymentd/sca"
+	"github.com/fritzpay/paymentd/pkg/reference"
+)
+
+const (
+	// MaxAuthorizationBuffer is the maximum percentage by which authorizations
+	// may exceed the payment amount
+	MaxAuthorizationBuffer = 100
+	// MaxClockSkew is the maximum tolerated clock skew of signed requests in
+	// seconds
+	MaxClockSkew = 300
+	// MaxEscrowHold is the maximum time in seconds for which captured funds can
+	// be held in escrow
+	MaxEscrowHold = 180 * 24 * 60 * 60
+	// MaxCallbackKeyOverlap is the maximum time in seconds for which
+	// notifications are signed with the previous callback project key
+	MaxCallbackKeyOverlap = 30 * 24 * 60 * 60
+	// MaxPaymentExpiry is the maximum time in seconds after which open payments
+	// expire
+	MaxPaymentExpiry = 90 * 24 * 60 * 60
+	// MaxCallbackRateLimit is the maximum configurable number of callback
+	// deliveries per second
+	MaxCallbackRateLimit = 1000
+	// MaxCallbackConcurrency is the maximum configurable number of concurrent
+	// callback deliveries
+	MaxCallbackConcurrency = 100
 )
 
 const (
@@ -40,14 +73,85 @@ type Config struct {
 	CallbackAPIVersion sql.NullString
 	CallbackProjectKey sql.NullString
 	ReturnURL          sql.NullString
+	// CallbackEvents is the comma-separated list of event types the project
+	// will be notified of
+	CallbackEvents sql.NullString
+	// CallbackTLSCertFile and CallbackTLSKeyFile are the client certificate
+	// used for callback deliveries
+	CallbackTLSCertFile sql.NullString
+	CallbackTLSKeyFile  sql.NullString
+	// CallbackHeaders is the JSON encoded map of static headers sent with
+	// callback deliveries
+	CallbackHeaders sql.NullString
+	// AuthorizationBuffer is the percentage by which authorizations may exceed
+	// the payment amount
+	AuthorizationBuffer sql.NullInt64
+	// MetadataSchema is the JSON encoded schema of the payment metadata
+	MetadataSchema sql.NullString
+	// ClockSkew is the tolerated difference in seconds between the timestamps
+	// of signed requests and the server time
+	ClockSkew sql.NullInt64
+	// StatementDescriptor is the default statement descriptor of the payments
+	// of the project
+	StatementDescriptor sql.NullString
+	// SCAPolicy is the JSON encoded policy for requesting exemptions from strong
+	// customer authentication
+	SCAPolicy sql.NullString
+	// CheckoutFields is the JSON encoded list of billing fields collected on
+	// the hosted payment pages
+	CheckoutFields sql.NullString
+	// ReferenceScheme is the JSON encoded scheme of the reference numbers of
+	// the payments of the project
+	ReferenceScheme sql.NullString
+	// EscrowHold is the time in seconds for which captured funds are held in
+	// escrow until they will be released automatically. Projects without an
+	// escrow hold are not in escrow mode
+	EscrowHold sql.NullInt64
+	// CallbackKeyOverlap is the time in seconds after a change of the callback
+	// project key, during which notifications are signed with the previous key
+	// as well
+	CallbackKeyOverlap sql.NullInt64
+	// CallbackRateLimit is the maximum number of callback deliveries per
+	// second
+	CallbackRateLimit sql.NullInt64
+	// CallbackConcurrency is the maximum number of concurrent callback
+	// deliveries
+	CallbackConcurrency sql.NullInt64
+	// MetadataLimits is the JSON encoded size limits of the payment metadata
+	MetadataLimits sql.NullString
+	// MethodPreferences is the JSON encoded order and default of the payment
+	// methods per payment country
+	MethodPreferences sql.NullString
+	// PaymentExpiry is the time in seconds after the creation of a payment,
+	// after which the payment will expire if it is still open. Payments of
+	// projects without a payment expiry do not expire
+	PaymentExpiry sql.NullInt64
 }
 
 type ConfigJSON struct {
-	WebURL             *string
-	CallbackURL        *string
-	CallbackAPIVersion *string
-	CallbackProjectKey *string
-	ReturnURL          *string
+	WebURL              *string
+	CallbackURL         *string
+	CallbackAPIVersion  *string
+	CallbackProjectKey  *string
+	ReturnURL           *string
+	CallbackEvents      []string                      `json:",omitempty"`
+	CallbackTLSCertFile *string                       `json:",omitempty"`
+	CallbackTLSKeyFile  *string                       `json:",omitempty"`
+	CallbackHeaders     map[string]string             `json:",omitempty"`
+	AuthorizationBuffer *int64                        `json:",omitempty"`
+	MetadataSchema      *metadata.Schema              `json:",omitempty"`
+	ClockSkew           *int64                        `json:",omitempty"`
+	StatementDescriptor *string                       `json:",omitempty"`
+	SCAPolicy           *sca.Policy                   `json:",omitempty"`
+	CheckoutFields      billing.Fields                `json:",omitempty"`
+	ReferenceScheme     *reference.Scheme             `json:",omitempty"`
+	EscrowHold          *int64                        `json:",omitempty"`
+	CallbackKeyOverlap  *int64                        `json:",omitempty"`
+	CallbackRateLimit   *int64                        `json:",omitempty"`
+	CallbackConcurrency *int64                        `json:",omitempty"`
+	MetadataLimits      *metadata.Limits              `json:",omitempty"`
+	MethodPreferences   method_preference.Preferences `json:",omitempty"`
+	PaymentExpiry       *int64                        `json:",omitempty"`
 }
 
 // IsSet returns true if the config was set and stored
@@ -57,7 +161,7 @@ func (c Config) IsSet() bool {
 
 // HasValues returns true if the config has any values set
 func (c Config) HasValues() bool {
-	return c.WebURL.Valid || c.CallbackURL.Valid || c.CallbackAPIVersion.Valid || c.CallbackProjectKey.Valid || c.ReturnURL.Valid
+	return c.WebURL.Valid || c.CallbackURL.Valid || c.CallbackAPIVersion.Valid || c.CallbackProjectKey.Valid || c.ReturnURL.Valid || c.CallbackEvents.Valid || c.CallbackTLSCertFile.Valid || c.CallbackTLSKeyFile.Valid || c.CallbackHeaders.Valid || c.AuthorizationBuffer.Valid || c.MetadataSchema.Valid || c.ClockSkew.Valid || c.StatementDescriptor.Valid || c.SCAPolicy.Valid || c.CheckoutFields.Valid || c.ReferenceScheme.Valid || c.EscrowHold.Valid || c.CallbackKeyOverlap.Valid || c.CallbackRateLimit.Valid || c.CallbackConcurrency.Valid || c.MetadataLimits.Valid || c.MethodPreferences.Valid || c.PaymentExpiry.Valid
 }
 
 func (c Config) HasCallback() bool {
@@ -88,6 +192,459 @@ func (c *Config) SetReturnURL(url string) {
 	c.ReturnURL.String, c.ReturnURL.Valid = url, true
 }
 
+func (c *Config) SetCallbackEvents(eventTypes []string) {
+	c.CallbackEvents.String, c.CallbackEvents.Valid = strings.Join(eventTypes, ","), true
+}
+
+// HasCallbackTLS returns true if a client certificate is configured for
+// callback deliveries
+func (c Config) HasCallbackTLS() bool {
+	return c.CallbackTLSCertFile.Valid && c.CallbackTLSKeyFile.Valid
+}
+
+// SetCallbackTLS sets the client certificate and key files for callback
+// deliveries
+func (c *Config) SetCallbackTLS(certFile, keyFile string) {
+	c.CallbackTLSCertFile.String, c.CallbackTLSCertFile.Valid = certFile, true
+	c.CallbackTLSKeyFile.String, c.CallbackTLSKeyFile.Valid = keyFile, true
+}
+
+// SetCallbackHeaders sets the static headers sent with callback deliveries
+func (c *Config) SetCallbackHeaders(headers map[string]string) error {
+	enc, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	c.CallbackHeaders.String, c.CallbackHeaders.Valid = string(enc), true
+	return nil
+}
+
+// CallbackHeaderValues returns the static headers sent with callback
+// deliveries
+//
+// If no headers are configured, it returns nil.
+func (c Config) CallbackHeaderValues() (map[string]string, error) {
+	if !c.CallbackHeaders.Valid || c.CallbackHeaders.String == "" {
+		return nil, nil
+	}
+	headers := make(map[string]string)
+	err := json.Unmarshal([]byte(c.CallbackHeaders.String), &headers)
+	if err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// SetAuthorizationBuffer sets the percentage by which authorizations may exceed
+// the payment amount
+func (c *Config) SetAuthorizationBuffer(percent int64) error {
+	if percent < 0 || percent > MaxAuthorizationBuffer {
+		return fmt.Errorf("authorization buffer must be between 0 and %d percent", MaxAuthorizationBuffer)
+	}
+	c.AuthorizationBuffer.Int64, c.AuthorizationBuffer.Valid = percent, true
+	return nil
+}
+
+// AuthorizationBufferPercent returns the percentage by which authorizations may
+// exceed the payment amount
+func (c Config) AuthorizationBufferPercent() int64 {
+	if !c.AuthorizationBuffer.Valid {
+		return 0
+	}
+	return c.AuthorizationBuffer.Int64
+}
+
+// SetClockSkew sets the tolerated clock skew of signed requests in seconds
+func (c *Config) SetClockSkew(seconds int64) error {
+	if seconds < 0 || seconds > MaxClockSkew {
+		return fmt.Errorf("clock skew must be between 0 and %d seconds", MaxClockSkew)
+	}
+	c.ClockSkew.Int64, c.ClockSkew.Valid = seconds, true
+	return nil
+}
+
+// ClockSkewTolerance returns the tolerated clock skew of signed requests
+//
+// If no clock skew is configured, it returns the given default.
+func (c Config) ClockSkewTolerance(def time.Duration) time.Duration {
+	if !c.ClockSkew.Valid {
+		return def
+	}
+	return time.Duration(c.ClockSkew.Int64) * time.Second
+}
+
+// SetEscrowHold sets the time in seconds for which captured funds are held in
+// escrow
+//
+// A hold of zero disables the escrow mode of the project.
+func (c *Config) SetEscrowHold(seconds int64) error {
+	if seconds < 0 || seconds > MaxEscrowHold {
+		return fmt.Errorf("escrow hold must be between 0 and %d seconds", MaxEscrowHold)
+	}
+	if seconds == 0 {
+		c.EscrowHold.Int64, c.EscrowHold.Valid = 0, false
+		return nil
+	}
+	c.EscrowHold.Int64, c.EscrowHold.Valid = seconds, true
+	return nil
+}
+
+// EscrowHoldDuration returns the time for which captured funds are held in
+// escrow
+//
+// The returned bool is false if the project is not in escrow mode.
+func (c Config) EscrowHoldDuration() (time.Duration, bool) {
+	if !c.EscrowHold.Valid || c.EscrowHold.Int64 <= 0 {
+		return 0, false
+	}
+	return time.Duration(c.EscrowHold.Int64) * time.Second, true
+}
+
+// SetPaymentExpiry sets the time in seconds after the creation of a payment,
+// after which the payment will expire if it is still open
+//
+// An expiry of zero disables the expiration of payments.
+func (c *Config) SetPaymentExpiry(seconds int64) error {
+	if seconds < 0 || seconds > MaxPaymentExpiry {
+		return fmt.Errorf("payment expiry must be between 0 and %d seconds", MaxPaymentExpiry)
+	}
+	if seconds == 0 {
+		c.PaymentExpiry.Int64, c.PaymentExpiry.Valid = 0, false
+		return nil
+	}
+	c.PaymentExpiry.Int64, c.PaymentExpiry.Valid = seconds, true
+	return nil
+}
+
+// PaymentExpiryDuration returns the time after the creation of a payment,
+// after which the payment will expire if it is still open
+//
+// The returned bool is false if the payments of the project do not expire.
+func (c Config) PaymentExpiryDuration() (time.Duration, bool) {
+	if !c.PaymentExpiry.Valid || c.PaymentExpiry.Int64 <= 0 {
+		return 0, false
+	}
+	return time.Duration(c.PaymentExpiry.Int64) * time.Second, true
+}
+
+// SetCallbackKeyOverlap sets the time in seconds after a change of the callback
+// project key, during which notifications are signed with the previous key as
+// well
+func (c *Config) SetCallbackKeyOverlap(seconds int64) error {
+	if seconds < 0 || seconds > MaxCallbackKeyOverlap {
+		return fmt.Errorf("callback key overlap must be between 0 and %d seconds", MaxCallbackKeyOverlap)
+	}
+	c.CallbackKeyOverlap.Int64, c.CallbackKeyOverlap.Valid = seconds, true
+	return nil
+}
+
+// CallbackKeyOverlapDuration returns the time after a change of the callback
+// project key, during which notifications are signed with the previous key as
+// well
+func (c Config) CallbackKeyOverlapDuration() time.Duration {
+	if !c.CallbackKeyOverlap.Valid {
+		return 0
+	}
+	return time.Duration(c.CallbackKeyOverlap.Int64) * time.Second
+}
+
+// SetCallbackRateLimit sets the maximum number of callback deliveries per
+// second
+//
+// A limit of zero removes the limit.
+func (c *Config) SetCallbackRateLimit(perSecond int64) error {
+	if perSecond < 0 || perSecond > MaxCallbackRateLimit {
+		return fmt.Errorf("callback rate limit must be between 0 and %d per second", MaxCallbackRateLimit)
+	}
+	if perSecond == 0 {
+		c.CallbackRateLimit.Int64, c.CallbackRateLimit.Valid = 0, false
+		return nil
+	}
+	c.CallbackRateLimit.Int64, c.CallbackRateLimit.Valid = perSecond, true
+	return nil
+}
+
+// SetCallbackConcurrency sets the maximum number of concurrent callback
+// deliveries
+//
+// A limit of zero removes the limit.
+func (c *Config) SetCallbackConcurrency(n int64) error {
+	if n < 0 || n > MaxCallbackConcurrency {
+		return fmt.Errorf("callback concurrency must be between 0 and %d", MaxCallbackConcurrency)
+	}
+	if n == 0 {
+		c.CallbackConcurrency.Int64, c.CallbackConcurrency.Valid = 0, false
+		return nil
+	}
+	c.CallbackConcurrency.Int64, c.CallbackConcurrency.Valid = n, true
+	return nil
+}
+
+// CallbackLimits returns the maximum number of callback deliveries per second
+// and the maximum number of concurrent callback deliveries
+//
+// Zero values are unlimited.
+func (c Config) CallbackLimits() (perSecond, concurrency int) {
+	if c.CallbackRateLimit.Valid {
+		perSecond = int(c.CallbackRateLimit.Int64)
+	}
+	if c.CallbackConcurrency.Valid {
+		concurrency = int(c.CallbackConcurrency.Int64)
+	}
+	return
+}
+
+// SetStatementDescriptor sets the default statement descriptor of the payments
+// of the project
+//
+// An empty descriptor removes the default.
+func (c *Config) SetStatementDescriptor(d string) error {
+	if d == "" {
+		c.StatementDescriptor.String, c.StatementDescriptor.Valid = "", false
+		return nil
+	}
+	err := descriptor.Default.Check(d)
+	if err != nil {
+		return err
+	}
+	c.StatementDescriptor.String, c.StatementDescriptor.Valid = d, true
+	return nil
+}
+
+// SetMetadataSchema sets the schema of the payment metadata
+//
+// The schema will be compiled. Use a nil schema to accept any metadata.
+func (c *Config) SetMetadataSchema(schema *metadata.Schema) error {
+	if schema == nil {
+		c.MetadataSchema.String, c.MetadataSchema.Valid = "", false
+		return nil
+	}
+	err := schema.Compile()
+	if err != nil {
+		return err
+	}
+	enc, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	c.MetadataSchema.String, c.MetadataSchema.Valid = string(enc), true
+	return nil
+}
+
+// SetMetadataLimits sets the size limits of the payment metadata
+//
+// The limits may not exceed the metadata.MaxLimits. Use nil limits to apply
+// the default limits.
+func (c *Config) SetMetadataLimits(limits *metadata.Limits) error {
+	if limits == nil || *limits == (metadata.Limits{}) {
+		c.MetadataLimits.String, c.MetadataLimits.Valid = "", false
+		return nil
+	}
+	err := limits.Check()
+	if err != nil {
+		return err
+	}
+	enc, err := json.Marshal(limits)
+	if err != nil {
+		return err
+	}
+	c.MetadataLimits.String, c.MetadataLimits.Valid = string(enc), true
+	return nil
+}
+
+// PaymentMetadataLimits returns the effective size limits of the payment
+// metadata
+//
+// Limits which are not configured are replaced by the default limits.
+func (c Config) PaymentMetadataLimits() (metadata.Limits, error) {
+	var limits metadata.Limits
+	if c.MetadataLimits.Valid && c.MetadataLimits.String != "" {
+		err := json.Unmarshal([]byte(c.MetadataLimits.String), &limits)
+		if err != nil {
+			return limits, err
+		}
+	}
+	return limits.Effective(), nil
+}
+
+// PaymentMetadataSchema returns the compiled schema of the payment metadata
+//
+// If no schema is configured, it returns nil.
+func (c Config) PaymentMetadataSchema() (*metadata.Schema, error) {
+	if !c.MetadataSchema.Valid || c.MetadataSchema.String == "" {
+		return nil, nil
+	}
+	return metadata.ParseSchema([]byte(c.MetadataSchema.String))
+}
+
+// SetMethodPreferences sets the order and default of the payment methods per
+// payment country
+//
+// Use an empty list to apply the default preferences.
+func (c *Config) SetMethodPreferences(prefs method_preference.Preferences) error {
+	if len(prefs) == 0 {
+		c.MethodPreferences.String, c.MethodPreferences.Valid = "", false
+		return nil
+	}
+	err := prefs.Check()
+	if err != nil {
+		return err
+	}
+	enc, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+	c.MethodPreferences.String, c.MethodPreferences.Valid = string(enc), true
+	return nil
+}
+
+// PaymentMethodPreferences returns the order and default of the payment methods
+// per payment country
+//
+// If no preferences are configured, it returns the default preferences.
+func (c Config) PaymentMethodPreferences() (method_preference.Preferences, error) {
+	if !c.MethodPreferences.Valid || c.MethodPreferences.String == "" {
+		return method_preference.DefaultPreferences, nil
+	}
+	var prefs method_preference.Preferences
+	err := json.Unmarshal([]byte(c.MethodPreferences.String), &prefs)
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// SetSCAPolicy sets the policy for requesting SCA exemptions
+//
+// The policy will be compiled. Use a nil policy to never request exemptions.
+func (c *Config) SetSCAPolicy(policy *sca.Policy) error {
+	if policy == nil {
+		c.SCAPolicy.String, c.SCAPolicy.Valid = "", false
+		return nil
+	}
+	err := policy.Compile()
+	if err != nil {
+		return err
+	}
+	enc, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	c.SCAPolicy.String, c.SCAPolicy.Valid = string(enc), true
+	return nil
+}
+
+// PaymentSCAPolicy returns the compiled policy for requesting SCA exemptions
+//
+// If no policy is configured, it returns nil.
+func (c Config) PaymentSCAPolicy() (*sca.Policy, error) {
+	if !c.SCAPolicy.Valid || c.SCAPolicy.String == "" {
+		return nil, nil
+	}
+	return sca.ParsePolicy([]byte(c.SCAPolicy.String))
+}
+
+// SetCheckoutFields sets the billing fields collected on the hosted payment
+// pages
+//
+// Use an empty list to not collect billing data.
+func (c *Config) SetCheckoutFields(fields billing.Fields) error {
+	if len(fields) == 0 {
+		c.CheckoutFields.String, c.CheckoutFields.Valid = "", false
+		return nil
+	}
+	err := fields.Check()
+	if err != nil {
+		return err
+	}
+	enc, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	c.CheckoutFields.String, c.CheckoutFields.Valid = string(enc), true
+	return nil
+}
+
+// PaymentCheckoutFields returns the billing fields collected on the hosted
+// payment pages
+func (c Config) PaymentCheckoutFields() (billing.Fields, error) {
+	if !c.CheckoutFields.Valid || c.CheckoutFields.String == "" {
+		return nil, nil
+	}
+	var fields billing.Fields
+	err := json.Unmarshal([]byte(c.CheckoutFields.String), &fields)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// SetReferenceScheme sets the scheme of the payment reference numbers
+//
+// Use a nil scheme to not assign reference numbers.
+func (c *Config) SetReferenceScheme(scheme *reference.Scheme) error {
+	if scheme == nil {
+		c.ReferenceScheme.String, c.ReferenceScheme.Valid = "", false
+		return nil
+	}
+	err := scheme.Check()
+	if err != nil {
+		return err
+	}
+	enc, err := json.Marshal(scheme)
+	if err != nil {
+		return err
+	}
+	c.ReferenceScheme.String, c.ReferenceScheme.Valid = string(enc), true
+	return nil
+}
+
+// PaymentReferenceScheme returns the scheme of the payment reference numbers
+//
+// If no scheme is configured, it returns nil.
+func (c Config) PaymentReferenceScheme() (*reference.Scheme, error) {
+	if !c.ReferenceScheme.Valid || c.ReferenceScheme.String == "" {
+		return nil, nil
+	}
+	scheme := &reference.Scheme{}
+	err := json.Unmarshal([]byte(c.ReferenceScheme.String), scheme)
+	if err != nil {
+		return nil, err
+	}
+	return scheme, nil
+}
+
+// CallbackEventTypes returns the event types the project will be notified of
+//
+// If no event types are configured, it returns nil.
+func (c Config) CallbackEventTypes() []string {
+	if !c.CallbackEvents.Valid {
+		return nil
+	}
+	if c.CallbackEvents.String == "" {
+		return []string{}
+	}
+	return strings.Split(c.CallbackEvents.String, ",")
+}
+
+// SubscribedTo returns true if the project should be notified of events of the
+// given type
+//
+// If no event types are configured, the project will be notified of the
+// default event types.
+func (c Config) SubscribedTo(eventType string, defaults ...string) bool {
+	types := c.CallbackEventTypes()
+	if types == nil {
+		types = defaults
+	}
+	for _, t := range types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) UnmarshalJSON(p []byte) error {
 	cfg := &ConfigJSON{}
 	err := json.Unmarshal(p, cfg)
@@ -109,6 +666,105 @@ func (c *Config) UnmarshalJSON(p []byte) error {
 	if cfg.ReturnURL != nil {
 		c.SetReturnURL(*cfg.ReturnURL)
 	}
+	if cfg.CallbackEvents != nil {
+		c.SetCallbackEvents(cfg.CallbackEvents)
+	}
+	if cfg.CallbackTLSCertFile != nil {
+		c.CallbackTLSCertFile.String, c.CallbackTLSCertFile.Valid = *cfg.CallbackTLSCertFile, true
+	}
+	if cfg.CallbackTLSKeyFile != nil {
+		c.CallbackTLSKeyFile.String, c.CallbackTLSKeyFile.Valid = *cfg.CallbackTLSKeyFile, true
+	}
+	if cfg.CallbackHeaders != nil {
+		err = c.SetCallbackHeaders(cfg.CallbackHeaders)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.AuthorizationBuffer != nil {
+		err = c.SetAuthorizationBuffer(*cfg.AuthorizationBuffer)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.MetadataSchema != nil {
+		err = c.SetMetadataSchema(cfg.MetadataSchema)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.ClockSkew != nil {
+		err = c.SetClockSkew(*cfg.ClockSkew)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.StatementDescriptor != nil {
+		err = c.SetStatementDescriptor(*cfg.StatementDescriptor)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.SCAPolicy != nil {
+		err = c.SetSCAPolicy(cfg.SCAPolicy)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.CheckoutFields != nil {
+		err = c.SetCheckoutFields(cfg.CheckoutFields)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.ReferenceScheme != nil {
+		err = c.SetReferenceScheme(cfg.ReferenceScheme)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.EscrowHold != nil {
+		err = c.SetEscrowHold(*cfg.EscrowHold)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.CallbackKeyOverlap != nil {
+		err = c.SetCallbackKeyOverlap(*cfg.CallbackKeyOverlap)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.CallbackRateLimit != nil {
+		err = c.SetCallbackRateLimit(*cfg.CallbackRateLimit)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.CallbackConcurrency != nil {
+		err = c.SetCallbackConcurrency(*cfg.CallbackConcurrency)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.MetadataLimits != nil {
+		err = c.SetMetadataLimits(cfg.MetadataLimits)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.MethodPreferences != nil {
+		err = c.SetMethodPreferences(cfg.MethodPreferences)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.PaymentExpiry != nil {
+		err = c.SetPaymentExpiry(*cfg.PaymentExpiry)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -129,6 +785,71 @@ func (c Config) MarshalJSON() ([]byte, error) {
 	if c.ReturnURL.Valid {
 		cfg.ReturnURL = &c.ReturnURL.String
 	}
+	cfg.CallbackEvents = c.CallbackEventTypes()
+	if c.CallbackTLSCertFile.Valid {
+		cfg.CallbackTLSCertFile = &c.CallbackTLSCertFile.String
+	}
+	if c.CallbackTLSKeyFile.Valid {
+		cfg.CallbackTLSKeyFile = &c.CallbackTLSKeyFile.String
+	}
+	var err error
+	cfg.CallbackHeaders, err = c.CallbackHeaderValues()
+	if err != nil {
+		return nil, err
+	}
+	if c.AuthorizationBuffer.Valid {
+		cfg.AuthorizationBuffer = &c.AuthorizationBuffer.Int64
+	}
+	cfg.MetadataSchema, err = c.PaymentMetadataSchema()
+	if err != nil {
+		return nil, err
+	}
+	if c.ClockSkew.Valid {
+		cfg.ClockSkew = &c.ClockSkew.Int64
+	}
+	if c.StatementDescriptor.Valid {
+		cfg.StatementDescriptor = &c.StatementDescriptor.String
+	}
+	cfg.SCAPolicy, err = c.PaymentSCAPolicy()
+	if err != nil {
+		return nil, err
+	}
+	cfg.CheckoutFields, err = c.PaymentCheckoutFields()
+	if err != nil {
+		return nil, err
+	}
+	cfg.ReferenceScheme, err = c.PaymentReferenceScheme()
+	if err != nil {
+		return nil, err
+	}
+	if c.EscrowHold.Valid {
+		cfg.EscrowHold = &c.EscrowHold.Int64
+	}
+	if c.CallbackKeyOverlap.Valid {
+		cfg.CallbackKeyOverlap = &c.CallbackKeyOverlap.Int64
+	}
+	if c.CallbackRateLimit.Valid {
+		cfg.CallbackRateLimit = &c.CallbackRateLimit.Int64
+	}
+	if c.CallbackConcurrency.Valid {
+		cfg.CallbackConcurrency = &c.CallbackConcurrency.Int64
+	}
+	if c.MetadataLimits.Valid {
+		cfg.MetadataLimits = &metadata.Limits{}
+		err = json.Unmarshal([]byte(c.MetadataLimits.String), cfg.MetadataLimits)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.MethodPreferences.Valid {
+		err = json.Unmarshal([]byte(c.MethodPreferences.String), &cfg.MethodPreferences)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.PaymentExpiry.Valid {
+		cfg.PaymentExpiry = &c.PaymentExpiry.Int64
+	}
 	return json.Marshal(cfg)
 }
 
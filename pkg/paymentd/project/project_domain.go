@@ -0,0 +1,170 @@
+package project
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	domainChallengeBytes = 16
+	// limited by the index length of the domain column
+	domainMaxLength = 175
+	// DomainChallengePrefix is the prefix of the DNS TXT record name which has to
+	// contain the challenge value for a domain to be verified
+	DomainChallengePrefix = "_paymentd-challenge."
+)
+
+var (
+	// ErrInvalidDomain will be returned when a domain name is not acceptable as a
+	// checkout domain
+	ErrInvalidDomain = errors.New("invalid domain name")
+	// ErrInvalidTLSFile will be returned when a TLS file of a domain is not
+	// located in the domain certificate directory
+	ErrInvalidTLSFile = errors.New("TLS file outside of the certificate directory")
+)
+
+// Domain represents a (white-label) checkout domain of a project
+//
+// Domains are versioned by timestamp. The current state of a domain is
+// represented by the most recent entry.
+type Domain struct {
+	ProjectID int64 `json:",string"`
+	Domain    string
+	Timestamp time.Time
+	CreatedBy string
+	// Challenge is the value which has to be present in the TXT record of
+	// the domain for the ownership verification
+	Challenge string
+	// Verified is true if the ownership of the domain was verified
+	Verified bool
+
+	// TLS certificate and key files for serving the domain
+	TLSCertFile sql.NullString `json:"-"`
+	TLSKeyFile  sql.NullString `json:"-"`
+}
+
+// NormalizeDomain returns the normalized form of the given domain name
+//
+// It will return ErrInvalidDomain if the name does not denote a valid host
+// name.
+func NormalizeDomain(name string) (string, error) {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if name == "" || len(name) > domainMaxLength || !strings.Contains(name, ".") {
+		return "", ErrInvalidDomain
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return "", ErrInvalidDomain
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return "", ErrInvalidDomain
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return "", ErrInvalidDomain
+			}
+		}
+	}
+	return name, nil
+}
+
+// NewDomain creates a new unverified domain for the given project
+//
+// It will generate a new challenge.
+func NewDomain(projectID int64, name, createdBy string) (*Domain, error) {
+	var err error
+	d := &Domain{
+		ProjectID: projectID,
+		CreatedBy: createdBy,
+	}
+	d.Domain, err = NormalizeDomain(name)
+	if err != nil {
+		return nil, err
+	}
+	err = d.GenerateChallenge()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// GenerateChallenge generates a new challenge value
+func (d *Domain) GenerateChallenge() error {
+	bin := make([]byte, domainChallengeBytes)
+	_, err := rand.Read(bin)
+	if err != nil {
+		return err
+	}
+	d.Challenge = hex.EncodeToString(bin)
+	return nil
+}
+
+// ChallengeRecord returns the name of the DNS TXT record which has to contain
+// the challenge
+func (d *Domain) ChallengeRecord() string {
+	return DomainChallengePrefix + d.Domain
+}
+
+// MatchChallenge returns true if any of the given (TXT record) values matches
+// the challenge
+func (d *Domain) MatchChallenge(values []string) bool {
+	if d.Challenge == "" {
+		return false
+	}
+	for _, v := range values {
+		if strings.TrimSpace(v) == d.Challenge {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTLS returns true if a TLS certificate is configured for the domain
+func (d *Domain) HasTLS() bool {
+	return d.TLSCertFile.Valid && d.TLSKeyFile.Valid
+}
+
+// SetTLS sets the TLS certificate and key files
+func (d *Domain) SetTLS(certFile, keyFile string) {
+	d.TLSCertFile.String, d.TLSCertFile.Valid = certFile, true
+	d.TLSKeyFile.String, d.TLSKeyFile.Valid = keyFile, true
+}
+
+// DomainTLSFile returns the path of the named TLS file in the domain
+// certificate directory
+//
+// The name can be relative to the directory or an absolute path within the
+// directory. It will return ErrInvalidTLSFile if the directory is empty or the
+// file is not located in the directory.
+func DomainTLSFile(dir, name string) (string, error) {
+	if dir == "" || name == "" {
+		return "", ErrInvalidTLSFile
+	}
+	dir = filepath.Clean(dir)
+	file := name
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	file = filepath.Clean(file)
+	rel, err := filepath.Rel(dir, file)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidTLSFile
+	}
+	return file, nil
+}
+
+// URL returns a copy of the given URL, which will point to the domain
+//
+// Checkout domains are always served via HTTPS.
+func (d *Domain) URL(u *url.URL) *url.URL {
+	domURL := *u
+	domURL.Scheme = "https"
+	domURL.Host = d.Domain
+	return &domURL
+}
@@ -0,0 +1,271 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrDomainNotFound will be returned by select functions when the requested
+	// domain was not found
+	ErrDomainNotFound = errors.New("domain not found")
+)
+
+const insertDomain = `
+INSERT INTO project_domain
+(project_id, domain, timestamp, created_by, challenge, verified, tls_cert_file, tls_key_file)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+func execInsertDomain(ctx context.Context, insert *sql.Stmt, d *Domain) error {
+	d.Timestamp = time.Now()
+	_, err := insert.ExecContext(ctx,
+		d.ProjectID,
+		d.Domain,
+		d.Timestamp.UnixNano(),
+		d.CreatedBy,
+		d.Challenge,
+		d.Verified,
+		d.TLSCertFile,
+		d.TLSKeyFile,
+	)
+	insert.Close()
+	return err
+}
+
+// InsertDomainTx saves a new domain state
+//
+// It will update the domain timestamp
+func InsertDomainTx(ctx context.Context, db *sql.Tx, d *Domain) error {
+	insert, err := db.PrepareContext(ctx, insertDomain)
+	if err != nil {
+		return err
+	}
+	return execInsertDomain(ctx, insert, d)
+}
+
+// InsertDomainDB saves a new domain state
+//
+// It will update the domain timestamp
+func InsertDomainDB(ctx context.Context, db *sql.DB, d *Domain) error {
+	insert, err := db.PrepareContext(ctx, insertDomain)
+	if err != nil {
+		return err
+	}
+	return execInsertDomain(ctx, insert, d)
+}
+
+const selectDomainFrom = `
+SELECT
+	d.project_id,
+	d.domain,
+	d.timestamp,
+	d.created_by,
+	d.challenge,
+	d.verified,
+	d.tls_cert_file,
+	d.tls_key_file
+FROM project_domain AS d
+`
+
+const currentDomain = `
+	d.timestamp = (
+		SELECT MAX(timestamp) FROM project_domain
+		WHERE
+			project_id = d.project_id
+			AND
+			domain = d.domain
+	)
+`
+
+const selectDomain = selectDomainFrom + `
+WHERE
+` + currentDomain
+
+const selectDomainsByProjectID = selectDomain + `
+	AND
+	d.project_id = ?
+ORDER BY d.domain
+`
+
+const selectDomainByProjectIDAndName = selectDomain + `
+	AND
+	d.project_id = ?
+	AND
+	d.domain = ?
+`
+
+const selectVerifiedDomainByProjectID = selectDomain + `
+	AND
+	d.project_id = ?
+	AND
+	d.verified = 1
+ORDER BY d.timestamp DESC
+LIMIT 1
+`
+
+const selectVerifiedDomainByName = selectDomain + `
+	AND
+	d.domain = ?
+	AND
+	d.verified = 1
+ORDER BY d.timestamp DESC
+LIMIT 1
+`
+
+func scanDomain(row resultScanner) (*Domain, error) {
+	d := &Domain{}
+	var ts int64
+	err := row.Scan(
+		&d.ProjectID,
+		&d.Domain,
+		&ts,
+		&d.CreatedBy,
+		&d.Challenge,
+		&d.Verified,
+		&d.TLSCertFile,
+		&d.TLSKeyFile,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDomainNotFound
+		}
+		return nil, err
+	}
+	d.Timestamp = time.Unix(0, ts)
+	return d, nil
+}
+
+type resultScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDomains(rows *sql.Rows) ([]*Domain, error) {
+	var err error
+	var d *Domain
+	domains := make([]*Domain, 0, 1)
+	for rows.Next() {
+		d, err = scanDomain(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	err = rows.Err()
+	rows.Close()
+	return domains, err
+}
+
+// DomainsByProjectIDTx selects all domains of the given project
+func DomainsByProjectIDTx(ctx context.Context, db *sql.Tx, projectID int64) ([]*Domain, error) {
+	rows, err := db.QueryContext(ctx, selectDomainsByProjectID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDomains(rows)
+}
+
+// DomainsByProjectIDDB selects all domains of the given project
+func DomainsByProjectIDDB(ctx context.Context, db *sql.DB, projectID int64) ([]*Domain, error) {
+	rows, err := db.QueryContext(ctx, selectDomainsByProjectID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDomains(rows)
+}
+
+// DomainListing is the listing of project domains
+var DomainListing = listing.Builder{
+	Select:      selectDomainFrom,
+	Where:       currentDomain,
+	Key:         "Domain",
+	DefaultSort: "Domain",
+	Columns: map[string]string{
+		"Domain":    "d.domain",
+		"Timestamp": "d.timestamp",
+	},
+}
+
+func domainCursor(sortField string, d *Domain) listing.Cursor {
+	c := listing.Cursor{Key: d.Domain}
+	if sortField == "Timestamp" {
+		c.Sort = strconv.FormatInt(d.Timestamp.UnixNano(), 10)
+	}
+	return c
+}
+
+// DomainsByProjectIDListDB selects a page of the domains of the given project
+func DomainsByProjectIDListDB(ctx context.Context, db *sql.DB, projectID int64, q *listing.Query) ([]*Domain, listing.Page, error) {
+	sortField, err := DomainListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	query, args, err := DomainListing.Build(q, "d.project_id = ?", projectID)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	domains, err := scanDomains(rows)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(domains), func(i int) listing.Cursor {
+		return domainCursor(sortField, domains[i])
+	})
+	return domains[:n], page, nil
+}
+
+// DomainByProjectIDAndNameTx selects the current state of the given project domain
+func DomainByProjectIDAndNameTx(ctx context.Context, db *sql.Tx, projectID int64, name string) (*Domain, error) {
+	row := db.QueryRowContext(ctx, selectDomainByProjectIDAndName, projectID, name)
+	return scanDomain(row)
+}
+
+// DomainByProjectIDAndNameDB selects the current state of the given project domain
+func DomainByProjectIDAndNameDB(ctx context.Context, db *sql.DB, projectID int64, name string) (*Domain, error) {
+	row := db.QueryRowContext(ctx, selectDomainByProjectIDAndName, projectID, name)
+	return scanDomain(row)
+}
+
+// VerifiedDomainByProjectIDTx selects the most recently verified domain of the
+// given project
+//
+// This is the domain which should be used for generating checkout URLs.
+func VerifiedDomainByProjectIDTx(ctx context.Context, db *sql.Tx, projectID int64) (*Domain, error) {
+	row := db.QueryRowContext(ctx, selectVerifiedDomainByProjectID, projectID)
+	return scanDomain(row)
+}
+
+// VerifiedDomainByProjectIDDB selects the most recently verified domain of the
+// given project
+//
+// This is the domain which should be used for generating checkout URLs.
+func VerifiedDomainByProjectIDDB(ctx context.Context, db *sql.DB, projectID int64) (*Domain, error) {
+	row := db.QueryRowContext(ctx, selectVerifiedDomainByProjectID, projectID)
+	return scanDomain(row)
+}
+
+// VerifiedDomainByNameTx selects a verified domain by its name
+//
+// The verified records of the domain will be locked for the transaction, so that
+// concurrent transactions cannot verify the domain for another project.
+func VerifiedDomainByNameTx(ctx context.Context, db *sql.Tx, name string) (*Domain, error) {
+	row := db.QueryRowContext(ctx, selectVerifiedDomainByName+" FOR UPDATE", name)
+	return scanDomain(row)
+}
+
+// VerifiedDomainByNameDB selects a verified domain by its name
+func VerifiedDomainByNameDB(ctx context.Context, db *sql.DB, name string) (*Domain, error) {
+	row := db.QueryRowContext(ctx, selectVerifiedDomainByName, name)
+	return scanDomain(row)
+}
@@ -0,0 +1,87 @@
+package project_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	Convey("Given valid domain names", t, func() {
+		Convey("They should be normalized", func() {
+			name, err := project.NormalizeDomain(" Pay.Example.COM. ")
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "pay.example.com")
+		})
+	})
+	Convey("Given invalid domain names", t, func() {
+		Convey("They should be rejected", func() {
+			for _, name := range []string{"", "localhost", "pay..example.com", "-pay.example.com", "pay.example.com:443", "pay_1.example.com"} {
+				_, err := project.NormalizeDomain(name)
+				So(err, ShouldEqual, project.ErrInvalidDomain)
+			}
+		})
+	})
+}
+
+func TestDomain(t *testing.T) {
+	Convey("Given a new domain", t, func() {
+		d, err := project.NewDomain(1, "pay.example.com", "test")
+		So(err, ShouldBeNil)
+
+		Convey("It should not be verified", func() {
+			So(d.Verified, ShouldBeFalse)
+		})
+		Convey("It should have a challenge", func() {
+			So(d.Challenge, ShouldNotBeEmpty)
+			So(d.ChallengeRecord(), ShouldEqual, "_paymentd-challenge.pay.example.com")
+		})
+		Convey("When matching TXT records", func() {
+			Convey("It should match the challenge", func() {
+				So(d.MatchChallenge([]string{"v=spf1 -all", d.Challenge}), ShouldBeTrue)
+				So(d.MatchChallenge([]string{"v=spf1 -all"}), ShouldBeFalse)
+			})
+		})
+		Convey("When creating a URL for the domain", func() {
+			base, err := url.Parse("http://localhost:8443/prefix")
+			So(err, ShouldBeNil)
+			u := d.URL(base)
+
+			Convey("It should point to the domain", func() {
+				So(u.String(), ShouldEqual, "https://pay.example.com/prefix")
+			})
+			Convey("It should not modify the base URL", func() {
+				So(base.String(), ShouldEqual, "http://localhost:8443/prefix")
+			})
+		})
+	})
+}
+
+func TestDomainTLSFile(t *testing.T) {
+	Convey("Given a domain certificate directory", t, func() {
+		dir := "/etc/paymentd/tls"
+
+		Convey("Files in the directory should be accepted", func() {
+			file, err := project.DomainTLSFile(dir, "pay.example.com.crt")
+			So(err, ShouldBeNil)
+			So(file, ShouldEqual, "/etc/paymentd/tls/pay.example.com.crt")
+			file, err = project.DomainTLSFile(dir+"/", "/etc/paymentd/tls/sub/pay.example.com.key")
+			So(err, ShouldBeNil)
+			So(file, ShouldEqual, "/etc/paymentd/tls/sub/pay.example.com.key")
+		})
+		Convey("Files outside of the directory should be rejected", func() {
+			for _, name := range []string{"", ".", "../paymentd.key", "sub/../../paymentd.key", "/etc/passwd", "/etc/paymentd/tls"} {
+				_, err := project.DomainTLSFile(dir, name)
+				So(err, ShouldEqual, project.ErrInvalidTLSFile)
+			}
+		})
+	})
+	Convey("Given no domain certificate directory", t, func() {
+		Convey("All files should be rejected", func() {
+			_, err := project.DomainTLSFile("", "pay.example.com.crt")
+			So(err, ShouldEqual, project.ErrInvalidTLSFile)
+		})
+	})
+}
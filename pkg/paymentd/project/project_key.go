@@ -1,10 +1,26 @@
 package project
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"time"
 )
 
+const (
+	projectKeyBytes    = 16
+	projectSecretBytes = 32
+)
+
+// Signature modes of project keys
+const (
+	// SignatureModeBaseString signatures cover the concatenated signature base
+	// string of the message
+	SignatureModeBaseString = "base_string"
+	// SignatureModeCanonicalJSON signatures cover the canonical JSON
+	// serialization (RFC 8785) of the message
+	SignatureModeCanonicalJSON = "canonical_json"
+)
+
 // ProjectKey represents a project key
 type Projectkey struct {
 	Key         string
@@ -14,6 +30,8 @@ type Projectkey struct {
 	Secret      string
 	secretBytes []byte
 	Active      bool
+	// SignatureMode is the signature mode of messages signed with the key
+	SignatureMode string
 }
 
 // IsValid returns true if the project key is considered valid
@@ -21,7 +39,33 @@ func (p *Projectkey) IsValid() bool {
 	return p.Key != "" && p.Active
 }
 
+// CanonicalJSON returns true if messages signed with the key use canonical
+// JSON signatures
+func (p *Projectkey) CanonicalJSON() bool {
+	return p.SignatureMode == SignatureModeCanonicalJSON
+}
+
 // SecretBytes returns the binary representation of the shared secret
 func (p *Projectkey) SecretBytes() ([]byte, error) {
 	return hex.DecodeString(p.Secret)
 }
+
+// NewProjectkey creates a new active project key for the given project
+//
+// The key and the shared secret will be generated randomly.
+func NewProjectkey(pr Project, createdBy string) (*Projectkey, error) {
+	pk := &Projectkey{
+		Project:       pr,
+		CreatedBy:     createdBy,
+		Active:        true,
+		SignatureMode: SignatureModeBaseString,
+	}
+	bin := make([]byte, projectKeyBytes+projectSecretBytes)
+	_, err := rand.Read(bin)
+	if err != nil {
+		return nil, err
+	}
+	pk.Key = hex.EncodeToString(bin[:projectKeyBytes])
+	pk.Secret = hex.EncodeToString(bin[projectKeyBytes:])
+	return pk, nil
+}
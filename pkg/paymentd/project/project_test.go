@@ -3,8 +3,13 @@ package project_test
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"github.com/fritzpay/paymentd/pkg/paymentd/method_preference"
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/paymentd/sca"
+	"github.com/fritzpay/paymentd/pkg/reference"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -57,3 +62,604 @@ func TestProjectConfig(t *testing.T) {
 		})
 	})
 }
+
+func TestProjectConfigCallbackEvents(t *testing.T) {
+	Convey("Given a project config without callback events", t, func() {
+		cfg := project.Config{}
+
+		Convey("It should be subscribed to the default event types", func() {
+			So(cfg.CallbackEventTypes(), ShouldBeNil)
+			So(cfg.SubscribedTo("payment.transaction", "payment.transaction"), ShouldBeTrue)
+			So(cfg.SubscribedTo("funds.matched", "payment.transaction"), ShouldBeFalse)
+		})
+
+		Convey("When callback events are set", func() {
+			cfg.SetCallbackEvents([]string{"funds.matched", "payment_method.status"})
+
+			Convey("It should be subscribed to the set event types only", func() {
+				So(cfg.HasValues(), ShouldBeTrue)
+				So(cfg.SubscribedTo("funds.matched", "payment.transaction"), ShouldBeTrue)
+				So(cfg.SubscribedTo("payment_method.status", "payment.transaction"), ShouldBeTrue)
+				So(cfg.SubscribedTo("payment.transaction", "payment.transaction"), ShouldBeFalse)
+			})
+
+			Convey("When marshalling the config", func() {
+				jsonStr, err := json.Marshal(cfg)
+
+				Convey("It should contain the event types", func() {
+					So(err, ShouldBeNil)
+					So(string(jsonStr), ShouldContainSubstring, `"CallbackEvents":["funds.matched","payment_method.status"]`)
+				})
+			})
+		})
+
+		Convey("When an empty list of callback events is set", func() {
+			cfg.SetCallbackEvents([]string{})
+
+			Convey("It should not be subscribed to any event type", func() {
+				So(cfg.SubscribedTo("payment.transaction", "payment.transaction"), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestProjectConfigCallbackTransport(t *testing.T) {
+	Convey("Given a serialized config with callback transport settings", t, func() {
+		cfgStr := `{"CallbackTLSCertFile":"client.crt","CallbackTLSKeyFile":"client.key","CallbackHeaders":{"Authorization":"Bearer token"}}`
+
+		Convey("When unmarshalling the JSON", func() {
+			cfg := project.Config{}
+			err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+			Convey("It should contain the transport settings", func() {
+				So(err, ShouldBeNil)
+				So(cfg.HasValues(), ShouldBeTrue)
+				So(cfg.HasCallbackTLS(), ShouldBeTrue)
+				So(cfg.CallbackTLSCertFile.String, ShouldEqual, "client.crt")
+				headers, err := cfg.CallbackHeaderValues()
+				So(err, ShouldBeNil)
+				So(headers["Authorization"], ShouldEqual, "Bearer token")
+			})
+
+			Convey("When re-marshalling the config", func() {
+				jsonStr, err := json.Marshal(cfg)
+
+				Convey("It should contain the transport settings", func() {
+					So(err, ShouldBeNil)
+					So(string(jsonStr), ShouldContainSubstring, `"CallbackTLSKeyFile":"client.key"`)
+					So(string(jsonStr), ShouldContainSubstring, `"CallbackHeaders":{"Authorization":"Bearer token"}`)
+				})
+			})
+		})
+	})
+}
+
+func TestProjectConfigAuthorizationBuffer(t *testing.T) {
+	Convey("Given a project config", t, func() {
+		cfg := project.Config{}
+
+		Convey("Without an authorization buffer", func() {
+			Convey("The buffer should be zero", func() {
+				So(cfg.AuthorizationBufferPercent(), ShouldEqual, 0)
+			})
+		})
+		Convey("When an authorization buffer exceeding the maximum is set", func() {
+			err := cfg.SetAuthorizationBuffer(project.MaxAuthorizationBuffer + 1)
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(cfg.HasValues(), ShouldBeFalse)
+			})
+		})
+		Convey("Given a serialized config with an authorization buffer", func() {
+			cfgStr := `{"AuthorizationBuffer":15}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("It should contain the buffer", func() {
+					So(err, ShouldBeNil)
+					So(cfg.HasValues(), ShouldBeTrue)
+					So(cfg.AuthorizationBufferPercent(), ShouldEqual, 15)
+				})
+
+				Convey("When re-marshalling the config", func() {
+					jsonStr, err := json.Marshal(cfg)
+
+					Convey("It should contain the buffer", func() {
+						So(err, ShouldBeNil)
+						So(string(jsonStr), ShouldContainSubstring, `"AuthorizationBuffer":15`)
+					})
+				})
+			})
+		})
+		Convey("Given a serialized config with a negative authorization buffer", func() {
+			cfgStr := `{"AuthorizationBuffer":-5}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("It should fail", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+}
+
+func TestProjectConfigClockSkew(t *testing.T) {
+	Convey("Given a project config", t, func() {
+		cfg := project.Config{}
+
+		Convey("Without a clock skew", func() {
+			Convey("The default tolerance should apply", func() {
+				So(cfg.ClockSkewTolerance(10*time.Second), ShouldEqual, 10*time.Second)
+			})
+		})
+		Convey("When a clock skew exceeding the maximum is set", func() {
+			err := cfg.SetClockSkew(project.MaxClockSkew + 1)
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(cfg.HasValues(), ShouldBeFalse)
+			})
+		})
+		Convey("Given a serialized config with a clock skew", func() {
+			cfgStr := `{"ClockSkew":60}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("It should contain the tolerance", func() {
+					So(err, ShouldBeNil)
+					So(cfg.HasValues(), ShouldBeTrue)
+					So(cfg.ClockSkewTolerance(10*time.Second), ShouldEqual, time.Minute)
+				})
+
+				Convey("When re-marshalling the config", func() {
+					jsonStr, err := json.Marshal(cfg)
+
+					Convey("It should contain the clock skew", func() {
+						So(err, ShouldBeNil)
+						So(string(jsonStr), ShouldContainSubstring, `"ClockSkew":60`)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestProjectConfigMetadataSchema(t *testing.T) {
+	Convey("Given a serialized config with a metadata schema", t, func() {
+		cfgStr := `{"MetadataSchema":{"Fields":[{"Name":"quantity","Type":"int","Required":true}]}}`
+
+		Convey("When unmarshalling the JSON", func() {
+			cfg := project.Config{}
+			err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+			Convey("It should contain the schema", func() {
+				So(err, ShouldBeNil)
+				So(cfg.HasValues(), ShouldBeTrue)
+				schema, err := cfg.PaymentMetadataSchema()
+				So(err, ShouldBeNil)
+				So(schema.Validate(map[string]string{"quantity": "1"}), ShouldBeNil)
+				So(schema.Validate(map[string]string{}), ShouldNotBeNil)
+			})
+
+			Convey("When re-marshalling the config", func() {
+				jsonStr, err := json.Marshal(cfg)
+
+				Convey("It should contain the schema", func() {
+					So(err, ShouldBeNil)
+					So(string(jsonStr), ShouldContainSubstring, `"MetadataSchema":{"Fields":[{"Name":"quantity","Type":"int","Required":true}]}`)
+				})
+			})
+		})
+	})
+	Convey("Given a serialized config with an invalid metadata schema", t, func() {
+		cfgStr := `{"MetadataSchema":{"Fields":[{"Name":"quantity","Type":"number"}]}}`
+
+		Convey("When unmarshalling the JSON", func() {
+			cfg := project.Config{}
+			err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestProjectConfigStatementDescriptor(t *testing.T) {
+	Convey("Given a project config", t, func() {
+		cfg := project.Config{}
+
+		Convey("When a descriptor with invalid characters is set", func() {
+			err := cfg.SetStatementDescriptor(`ACME "SHOP"`)
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(cfg.HasValues(), ShouldBeFalse)
+			})
+		})
+		Convey("Given a serialized config with a descriptor", func() {
+			cfgStr := `{"StatementDescriptor":"ACME SHOP"}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("It should contain the descriptor", func() {
+					So(err, ShouldBeNil)
+					So(cfg.StatementDescriptor.String, ShouldEqual, "ACME SHOP")
+				})
+
+				Convey("When the descriptor is cleared", func() {
+					err = cfg.SetStatementDescriptor("")
+
+					Convey("It should be removed", func() {
+						So(err, ShouldBeNil)
+						So(cfg.HasValues(), ShouldBeFalse)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestProjectConfigSCAPolicy(t *testing.T) {
+	Convey("Given a project config", t, func() {
+		cfg := project.Config{}
+
+		Convey("When a policy without currency is set", func() {
+			err := cfg.SetSCAPolicy(&sca.Policy{LowValueLimit: "30.00"})
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, sca.ErrPolicyCurrency)
+				So(cfg.HasValues(), ShouldBeFalse)
+			})
+		})
+		Convey("Given a serialized config with a policy", func() {
+			cfgStr := `{"SCAPolicy":{"Currency":"EUR","LowValueLimit":"30.00"}}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("It should contain the compiled policy", func() {
+					So(err, ShouldBeNil)
+					policy, err := cfg.PaymentSCAPolicy()
+					So(err, ShouldBeNil)
+					So(policy.Currency, ShouldEqual, "EUR")
+					So(policy.LowValueLimit, ShouldEqual, "30.00")
+				})
+
+				Convey("When the policy is cleared", func() {
+					err = cfg.SetSCAPolicy(nil)
+
+					Convey("It should be removed", func() {
+						So(err, ShouldBeNil)
+						So(cfg.HasValues(), ShouldBeFalse)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestProjectConfigReferenceScheme(t *testing.T) {
+	Convey("Given a project config", t, func() {
+		cfg := project.Config{}
+
+		Convey("When a scheme with an invalid prefix is set", func() {
+			err := cfg.SetReferenceScheme(&reference.Scheme{Prefix: "inv-"})
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, reference.ErrPrefix)
+				So(cfg.HasValues(), ShouldBeFalse)
+			})
+		})
+		Convey("Given a serialized config with a scheme", func() {
+			cfgStr := `{"ReferenceScheme":{"Prefix":"INV","Digits":8,"CheckDigit":"luhn"}}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("It should contain the scheme", func() {
+					So(err, ShouldBeNil)
+					scheme, err := cfg.PaymentReferenceScheme()
+					So(err, ShouldBeNil)
+					So(scheme.Prefix, ShouldEqual, "INV")
+					So(scheme.Digits, ShouldEqual, 8)
+					So(scheme.CheckDigit, ShouldEqual, reference.CheckLuhn)
+				})
+
+				Convey("When re-marshalling the config", func() {
+					jsonStr, err := json.Marshal(cfg)
+
+					Convey("It should contain the scheme", func() {
+						So(err, ShouldBeNil)
+						So(string(jsonStr), ShouldContainSubstring, `"ReferenceScheme":{"Prefix":"INV","Digits":8,"CheckDigit":"luhn"}`)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestProjectConfigEscrowHold(t *testing.T) {
+	Convey("Given a project config", t, func() {
+		cfg := project.Config{}
+
+		Convey("It should not be in escrow mode", func() {
+			_, ok := cfg.EscrowHoldDuration()
+			So(ok, ShouldBeFalse)
+		})
+		Convey("When an escrow hold beyond the maximum is set", func() {
+			err := cfg.SetEscrowHold(project.MaxEscrowHold + 1)
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(cfg.HasValues(), ShouldBeFalse)
+			})
+		})
+		Convey("Given a serialized config with an escrow hold", func() {
+			cfgStr := `{"EscrowHold":604800}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("It should be in escrow mode", func() {
+					So(err, ShouldBeNil)
+					hold, ok := cfg.EscrowHoldDuration()
+					So(ok, ShouldBeTrue)
+					So(hold, ShouldEqual, 7*24*time.Hour)
+				})
+
+				Convey("When the escrow hold is set to zero", func() {
+					err = cfg.SetEscrowHold(0)
+
+					Convey("It should not be in escrow mode anymore", func() {
+						So(err, ShouldBeNil)
+						_, ok := cfg.EscrowHoldDuration()
+						So(ok, ShouldBeFalse)
+						So(cfg.HasValues(), ShouldBeFalse)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestCallbackKeyRotation(t *testing.T) {
+	Convey("Given a project config with a callback key overlap", t, func() {
+		cfg := project.Config{}
+		err := cfg.SetCallbackKeyOverlap(86400)
+		So(err, ShouldBeNil)
+		So(cfg.CallbackKeyOverlapDuration(), ShouldEqual, 24*time.Hour)
+
+		Convey("Given a rotation of the callback key", func() {
+			r := &project.CallbackKeyRotation{
+				PreviousKey: "previous",
+				Rotated:     time.Unix(1400000000, 0),
+			}
+
+			Convey("It should be in the overlap within the window", func() {
+				So(r.InOverlap(cfg.CallbackKeyOverlapDuration(), r.Rotated.Add(time.Hour)), ShouldBeTrue)
+			})
+			Convey("It should not be in the overlap after the window", func() {
+				So(r.InOverlap(cfg.CallbackKeyOverlapDuration(), r.Rotated.Add(24*time.Hour)), ShouldBeFalse)
+			})
+			Convey("It should not be in the overlap without an overlap", func() {
+				So(r.InOverlap(0, r.Rotated), ShouldBeFalse)
+			})
+		})
+		Convey("When an overlap beyond the maximum is set", func() {
+			err := cfg.SetCallbackKeyOverlap(project.MaxCallbackKeyOverlap + 1)
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestProjectConfigCallbackLimits(t *testing.T) {
+	Convey("Given a project config", t, func() {
+		cfg := project.Config{}
+
+		Convey("Callbacks should not be limited", func() {
+			perSecond, concurrency := cfg.CallbackLimits()
+			So(perSecond, ShouldEqual, 0)
+			So(concurrency, ShouldEqual, 0)
+		})
+		Convey("When limits beyond the maximum are set", func() {
+			rateErr := cfg.SetCallbackRateLimit(project.MaxCallbackRateLimit + 1)
+			concurrencyErr := cfg.SetCallbackConcurrency(-1)
+			Convey("It should fail", func() {
+				So(rateErr, ShouldNotBeNil)
+				So(concurrencyErr, ShouldNotBeNil)
+				So(cfg.HasValues(), ShouldBeFalse)
+			})
+		})
+		Convey("Given a serialized config with callback limits", func() {
+			cfgStr := `{"CallbackRateLimit":20,"CallbackConcurrency":4}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("Callbacks should be limited", func() {
+					So(err, ShouldBeNil)
+					perSecond, concurrency := cfg.CallbackLimits()
+					So(perSecond, ShouldEqual, 20)
+					So(concurrency, ShouldEqual, 4)
+				})
+
+				Convey("When the rate limit is set to zero", func() {
+					err = cfg.SetCallbackRateLimit(0)
+
+					Convey("The rate should not be limited", func() {
+						So(err, ShouldBeNil)
+						perSecond, _ := cfg.CallbackLimits()
+						So(perSecond, ShouldEqual, 0)
+						So(cfg.CallbackRateLimit.Valid, ShouldBeFalse)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestProjectConfigMetadataLimits(t *testing.T) {
+	Convey("Given a project config", t, func() {
+		cfg := project.Config{}
+
+		Convey("The default metadata limits should apply", func() {
+			limits, err := cfg.PaymentMetadataLimits()
+			So(err, ShouldBeNil)
+			So(limits, ShouldResemble, metadata.DefaultLimits)
+		})
+		Convey("When limits beyond the maximum are set", func() {
+			err := cfg.SetMetadataLimits(&metadata.Limits{MaxKeys: metadata.MaxLimits.MaxKeys + 1})
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(cfg.HasValues(), ShouldBeFalse)
+			})
+		})
+		Convey("Given a serialized config with metadata limits", func() {
+			cfgStr := `{"MetadataLimits":{"MaxKeys":10}}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("The configured limits should apply", func() {
+					So(err, ShouldBeNil)
+					limits, err := cfg.PaymentMetadataLimits()
+					So(err, ShouldBeNil)
+					So(limits.MaxKeys, ShouldEqual, 10)
+					So(limits.MaxValueSize, ShouldEqual, metadata.DefaultLimits.MaxValueSize)
+				})
+
+				Convey("When marshalling the config", func() {
+					p, err := json.Marshal(cfg)
+
+					Convey("The limits should be contained", func() {
+						So(err, ShouldBeNil)
+						So(string(p), ShouldContainSubstring, `"MetadataLimits":{"MaxKeys":10}`)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestProjectConfigMethodPreferences(t *testing.T) {
+	Convey("Given a project config", t, func() {
+		cfg := project.Config{}
+
+		Convey("The default payment method preferences should apply", func() {
+			prefs, err := cfg.PaymentMethodPreferences()
+			So(err, ShouldBeNil)
+			So(prefs, ShouldResemble, method_preference.DefaultPreferences)
+		})
+		Convey("When invalid preferences are set", func() {
+			err := cfg.SetMethodPreferences(method_preference.Preferences{{Countries: []string{"Germany"}}})
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(cfg.HasValues(), ShouldBeFalse)
+			})
+		})
+		Convey("Given a serialized config with payment method preferences", func() {
+			cfgStr := `{"MethodPreferences":[{"Countries":["NL"],"Order":["stripe/ideal"],"Default":"stripe/ideal"}]}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("The configured preferences should apply", func() {
+					So(err, ShouldBeNil)
+					prefs, err := cfg.PaymentMethodPreferences()
+					So(err, ShouldBeNil)
+					So(prefs.For("NL").Default, ShouldEqual, "stripe/ideal")
+					So(prefs.For("DE").Order, ShouldBeEmpty)
+				})
+
+				Convey("When marshalling the config", func() {
+					p, err := json.Marshal(cfg)
+
+					Convey("The preferences should be contained", func() {
+						So(err, ShouldBeNil)
+						So(string(p), ShouldContainSubstring, `"MethodPreferences":[{"Countries":["NL"]`)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestProjectConfigPaymentExpiry(t *testing.T) {
+	Convey("Given a project config", t, func() {
+		cfg := project.Config{}
+
+		Convey("Its payments should not expire", func() {
+			_, ok := cfg.PaymentExpiryDuration()
+			So(ok, ShouldBeFalse)
+		})
+		Convey("When a payment expiry beyond the maximum is set", func() {
+			err := cfg.SetPaymentExpiry(project.MaxPaymentExpiry + 1)
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(cfg.HasValues(), ShouldBeFalse)
+			})
+		})
+		Convey("Given a serialized config with a payment expiry", func() {
+			cfgStr := `{"PaymentExpiry":3600}`
+
+			Convey("When unmarshalling the JSON", func() {
+				err := json.Unmarshal([]byte(cfgStr), &cfg)
+
+				Convey("Its payments should expire", func() {
+					So(err, ShouldBeNil)
+					expiry, ok := cfg.PaymentExpiryDuration()
+					So(ok, ShouldBeTrue)
+					So(expiry, ShouldEqual, time.Hour)
+				})
+
+				Convey("When marshalling the config", func() {
+					p, err := json.Marshal(cfg)
+
+					Convey("It should contain the payment expiry", func() {
+						So(err, ShouldBeNil)
+						So(string(p), ShouldContainSubstring, `"PaymentExpiry":3600`)
+					})
+				})
+
+				Convey("When the payment expiry is set to zero", func() {
+					err = cfg.SetPaymentExpiry(0)
+
+					Convey("Its payments should not expire anymore", func() {
+						So(err, ShouldBeNil)
+						_, ok := cfg.PaymentExpiryDuration()
+						So(ok, ShouldBeFalse)
+						So(cfg.HasValues(), ShouldBeFalse)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestNewProjectkey(t *testing.T) {
+	Convey("Given a project", t, func() {
+		pr := project.Project{ID: 1, PrincipalID: 2, Name: "shop"}
+		Convey("When creating a new project key", func() {
+			pk, err := project.NewProjectkey(pr, "onboarding")
+			So(err, ShouldBeNil)
+			Convey("It should be valid", func() {
+				So(pk.IsValid(), ShouldBeTrue)
+				So(pk.Project.ID, ShouldEqual, 1)
+				So(pk.CreatedBy, ShouldEqual, "onboarding")
+				So(pk.CanonicalJSON(), ShouldBeFalse)
+			})
+			Convey("It should have a random secret", func() {
+				secret, err := pk.SecretBytes()
+				So(err, ShouldBeNil)
+				So(len(secret), ShouldEqual, 32)
+				other, err := project.NewProjectkey(pr, "onboarding")
+				So(err, ShouldBeNil)
+				So(other.Key, ShouldNotEqual, pk.Key)
+				So(other.Secret, ShouldNotEqual, pk.Secret)
+			})
+		})
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 var (
@@ -22,8 +24,8 @@ VALUES
 (?, ?, ?,?)
 `
 
-func execInsertProject(insert *sql.Stmt, p *Project) error {
-	res, err := insert.Exec(p.PrincipalID, p.Created, p.CreatedBy, p.Name)
+func execInsertProject(ctx context.Context, insert *sql.Stmt, p *Project) error {
+	res, err := insert.ExecContext(ctx, p.PrincipalID, p.Created, p.CreatedBy, p.Name)
 	if err != nil {
 		insert.Close()
 		return err
@@ -36,35 +38,35 @@ func execInsertProject(insert *sql.Stmt, p *Project) error {
 // InsertProjectDB inserts a project
 //
 // This will modify the given project, setting the ID field.
-func InsertProjectDB(db *sql.DB, p *Project) error {
-	insert, err := db.Prepare(insertProject)
+func InsertProjectDB(ctx context.Context, db *sql.DB, p *Project) error {
+	insert, err := db.PrepareContext(ctx, insertProject)
 	if err != nil {
 		return err
 	}
-	return execInsertProject(insert, p)
+	return execInsertProject(ctx, insert, p)
 }
 
 // InsertProjectTx inserts a project
 //
 // This will modify the given project, setting the ID field.
-func InsertProjectTx(db *sql.Tx, p *Project) error {
-	insert, err := db.Prepare(insertProject)
+func InsertProjectTx(ctx context.Context, db *sql.Tx, p *Project) error {
+	insert, err := db.PrepareContext(ctx, insertProject)
 	if err != nil {
 		return err
 	}
-	return execInsertProject(insert, p)
+	return execInsertProject(ctx, insert, p)
 }
 
 const insertProjectConfig = `
 INSERT INTO project_config
-(project_id, timestamp, web_url, callback_url, callback_api_version, callback_project_key, return_url)
+(project_id, timestamp, web_url, callback_url, callback_api_version, callback_project_key, return_url, callback_events, callback_tls_cert_file, callback_tls_key_file, callback_headers, authorization_buffer, metadata_schema, clock_skew, statement_descriptor, sca_policy, checkout_fields, reference_scheme, escrow_hold, callback_key_overlap, callback_rate_limit, callback_concurrency, metadata_limits, method_preferences, payment_expiry)
 VALUES
-(?, ?, ?, ?, ?, ?, ?)
+(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
-func execInsertProjectConfig(insert *sql.Stmt, p *Project) error {
+func execInsertProjectConfig(ctx context.Context, insert *sql.Stmt, p *Project) error {
 	p.Config.Timestamp = time.Now()
-	_, err := insert.Exec(
+	_, err := insert.ExecContext(ctx,
 		p.ID,
 		p.Config.Timestamp,
 		p.Config.WebURL,
@@ -72,6 +74,24 @@ func execInsertProjectConfig(insert *sql.Stmt, p *Project) error {
 		p.Config.CallbackAPIVersion,
 		p.Config.CallbackProjectKey,
 		p.Config.ReturnURL,
+		p.Config.CallbackEvents,
+		p.Config.CallbackTLSCertFile,
+		p.Config.CallbackTLSKeyFile,
+		p.Config.CallbackHeaders,
+		p.Config.AuthorizationBuffer,
+		p.Config.MetadataSchema,
+		p.Config.ClockSkew,
+		p.Config.StatementDescriptor,
+		p.Config.SCAPolicy,
+		p.Config.CheckoutFields,
+		p.Config.ReferenceScheme,
+		p.Config.EscrowHold,
+		p.Config.CallbackKeyOverlap,
+		p.Config.CallbackRateLimit,
+		p.Config.CallbackConcurrency,
+		p.Config.MetadataLimits,
+		p.Config.MethodPreferences,
+		p.Config.PaymentExpiry,
 	)
 	insert.Close()
 	return err
@@ -80,23 +100,23 @@ func execInsertProjectConfig(insert *sql.Stmt, p *Project) error {
 // InsertProjectConfigDB sets a new project config
 //
 // It will update the project config timestamp
-func InsertProjectConfigDB(db *sql.DB, p *Project) error {
-	insert, err := db.Prepare(insertProjectConfig)
+func InsertProjectConfigDB(ctx context.Context, db *sql.DB, p *Project) error {
+	insert, err := db.PrepareContext(ctx, insertProjectConfig)
 	if err != nil {
 		return err
 	}
-	return execInsertProjectConfig(insert, p)
+	return execInsertProjectConfig(ctx, insert, p)
 }
 
 // InsertProjectConfigTx sets a new project config
 //
 // It will update the project config timestamp
-func InsertProjectConfigTx(db *sql.Tx, p *Project) error {
-	insert, err := db.Prepare(insertProjectConfig)
+func InsertProjectConfigTx(ctx context.Context, db *sql.Tx, p *Project) error {
+	insert, err := db.PrepareContext(ctx, insertProjectConfig)
 	if err != nil {
 		return err
 	}
-	return execInsertProjectConfig(insert, p)
+	return execInsertProjectConfig(ctx, insert, p)
 }
 
 const selectProject = `
@@ -111,7 +131,25 @@ SELECT
 	c.callback_url,
 	c.callback_api_version,
 	c.callback_project_key,
-	c.return_url
+	c.return_url,
+	c.callback_events,
+	c.callback_tls_cert_file,
+	c.callback_tls_key_file,
+	c.callback_headers,
+	c.authorization_buffer,
+	c.metadata_schema,
+	c.clock_skew,
+	c.statement_descriptor,
+	c.sca_policy,
+	c.checkout_fields,
+	c.reference_scheme,
+	c.escrow_hold,
+	c.callback_key_overlap,
+	c.callback_rate_limit,
+	c.callback_concurrency,
+	c.metadata_limits,
+	c.method_preferences,
+	c.payment_expiry
 FROM project AS p
 LEFT JOIN project_config AS c ON
 	c.project_id = p.id
@@ -157,6 +195,24 @@ func scanProject(row *sql.Row) (*Project, error) {
 		&p.Config.CallbackAPIVersion,
 		&p.Config.CallbackProjectKey,
 		&p.Config.ReturnURL,
+		&p.Config.CallbackEvents,
+		&p.Config.CallbackTLSCertFile,
+		&p.Config.CallbackTLSKeyFile,
+		&p.Config.CallbackHeaders,
+		&p.Config.AuthorizationBuffer,
+		&p.Config.MetadataSchema,
+		&p.Config.ClockSkew,
+		&p.Config.StatementDescriptor,
+		&p.Config.SCAPolicy,
+		&p.Config.CheckoutFields,
+		&p.Config.ReferenceScheme,
+		&p.Config.EscrowHold,
+		&p.Config.CallbackKeyOverlap,
+		&p.Config.CallbackRateLimit,
+		&p.Config.CallbackConcurrency,
+		&p.Config.MetadataLimits,
+		&p.Config.MethodPreferences,
+		&p.Config.PaymentExpiry,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -173,51 +229,80 @@ func scanProject(row *sql.Row) (*Project, error) {
 // ProjectByIdDB selects a project by the given project id
 //
 // If no such project exists, it will return an empty project
-func ProjectByIDDB(db *sql.DB, projectId int64) (*Project, error) {
-	row := db.QueryRow(selectProjectById, projectId)
+func ProjectByIDDB(ctx context.Context, db *sql.DB, projectId int64) (*Project, error) {
+	row := db.QueryRowContext(ctx, selectProjectById, projectId)
 	return scanProject(row)
 }
 
 // ProjectByIdTx selects a project by the given project id
 //
 // If no such project exists, it will return an empty project
-func ProjectByIDTx(db *sql.Tx, projectId int64) (*Project, error) {
-	row := db.QueryRow(selectProjectById, projectId)
+func ProjectByIDTx(ctx context.Context, db *sql.Tx, projectId int64) (*Project, error) {
+	row := db.QueryRowContext(ctx, selectProjectById, projectId)
 	return scanProject(row)
 }
 
 // ProjectByIdDB selects a project by the given project id
 //
 // If no such project exists, it will return an empty project
-func ProjectByPrincipalIDandIDDB(db *sql.DB, principalID int64, projectId int64) (*Project, error) {
-	row := db.QueryRow(selectProjectByPrincipalIDAndId, principalID, projectId)
+func ProjectByPrincipalIDandIDDB(ctx context.Context, db *sql.DB, principalID int64, projectId int64) (*Project, error) {
+	row := db.QueryRowContext(ctx, selectProjectByPrincipalIDAndId, principalID, projectId)
 	return scanProject(row)
 }
 
 // ProjectByIdTx selects a project by the given project id
 //
 // If no such project exists, it will return an empty project
-func ProjectByPrincipalIDandIDTx(db *sql.Tx, principalID int64, projectId int64) (*Project, error) {
-	row := db.QueryRow(selectProjectByPrincipalIDAndId, principalID, projectId)
+func ProjectByPrincipalIDandIDTx(ctx context.Context, db *sql.Tx, principalID int64, projectId int64) (*Project, error) {
+	row := db.QueryRowContext(ctx, selectProjectByPrincipalIDAndId, principalID, projectId)
 	return scanProject(row)
 }
 
 // ProjectByName selects a project by the given project name
 //
 // If no such project exists, it will return an empty project
-func ProjectByPrincipalIDNameDB(db *sql.DB, principalID int64, projectName string) (*Project, error) {
-	row := db.QueryRow(selectProjectByPrincipalIdAndName, principalID, projectName)
+func ProjectByPrincipalIDNameDB(ctx context.Context, db *sql.DB, principalID int64, projectName string) (*Project, error) {
+	row := db.QueryRowContext(ctx, selectProjectByPrincipalIdAndName, principalID, projectName)
 	return scanProject(row)
 }
 
 // ProjectByNameTx selects a project by the given project name
 //
 // If no such project exists, it will return an empty project
-func ProjectByPrincipalIDAndNameTx(db *sql.Tx, principalID int64, projectName string) (*Project, error) {
-	row := db.QueryRow(selectProjectByPrincipalIdAndName, principalID, projectName)
+func ProjectByPrincipalIDAndNameTx(ctx context.Context, db *sql.Tx, principalID int64, projectName string) (*Project, error) {
+	row := db.QueryRowContext(ctx, selectProjectByPrincipalIdAndName, principalID, projectName)
 	return scanProject(row)
 }
 
+const insertProjectKey = `
+INSERT INTO project_key
+(` + "`key`" + `, timestamp, project_id, created_by, secret, active, signature_mode)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertProjectKeyTx saves a new project key state
+//
+// It will update the project key timestamp.
+func InsertProjectKeyTx(ctx context.Context, db *sql.Tx, pk *Projectkey) error {
+	insert, err := db.PrepareContext(ctx, insertProjectKey)
+	if err != nil {
+		return err
+	}
+	pk.Timestamp = time.Now().UTC().Round(time.Second)
+	_, err = insert.ExecContext(ctx,
+		pk.Key,
+		pk.Timestamp,
+		pk.Project.ID,
+		pk.CreatedBy,
+		pk.Secret,
+		pk.Active,
+		pk.SignatureMode,
+	)
+	insert.Close()
+	return err
+}
+
 const selectProjectKey = `
 SELECT
 	k.key,
@@ -225,6 +310,7 @@ SELECT
 	k.created_by,
 	k.secret,
 	k.active,
+	k.signature_mode,
 	p.id,
 	p.principal_id,
 	p.name,
@@ -235,7 +321,25 @@ SELECT
 	c.callback_url,
 	c.callback_api_version,
 	c.callback_project_key,
-	c.return_url
+	c.return_url,
+	c.callback_events,
+	c.callback_tls_cert_file,
+	c.callback_tls_key_file,
+	c.callback_headers,
+	c.authorization_buffer,
+	c.metadata_schema,
+	c.clock_skew,
+	c.statement_descriptor,
+	c.sca_policy,
+	c.checkout_fields,
+	c.reference_scheme,
+	c.escrow_hold,
+	c.callback_key_overlap,
+	c.callback_rate_limit,
+	c.callback_concurrency,
+	c.metadata_limits,
+	c.method_preferences,
+	c.payment_expiry
 FROM project_key AS k
 INNER JOIN project AS p ON
 	p.id = k.project_id
@@ -260,15 +364,28 @@ WHERE
 	)
 `
 
-func scanProjectKey(row *sql.Row) (*Projectkey, error) {
+const selectActiveProjectKeys = selectProjectKey + `
+WHERE
+	k.active = 1
+	AND
+	k.timestamp = (
+		SELECT MAX(timestamp) FROM project_key AS mk
+		WHERE
+			mk.key = k.key
+	)
+`
+
+func scanProjectKey(row resultScanner) (*Projectkey, error) {
 	pk := &Projectkey{}
 	var ts sql.NullInt64
+	var mode sql.NullString
 	err := row.Scan(
 		&pk.Key,
 		&pk.Timestamp,
 		&pk.CreatedBy,
 		&pk.Secret,
 		&pk.Active,
+		&mode,
 		&pk.Project.ID,
 		&pk.Project.PrincipalID,
 		&pk.Project.Name,
@@ -280,6 +397,24 @@ func scanProjectKey(row *sql.Row) (*Projectkey, error) {
 		&pk.Project.Config.CallbackAPIVersion,
 		&pk.Project.Config.CallbackProjectKey,
 		&pk.Project.Config.ReturnURL,
+		&pk.Project.Config.CallbackEvents,
+		&pk.Project.Config.CallbackTLSCertFile,
+		&pk.Project.Config.CallbackTLSKeyFile,
+		&pk.Project.Config.CallbackHeaders,
+		&pk.Project.Config.AuthorizationBuffer,
+		&pk.Project.Config.MetadataSchema,
+		&pk.Project.Config.ClockSkew,
+		&pk.Project.Config.StatementDescriptor,
+		&pk.Project.Config.SCAPolicy,
+		&pk.Project.Config.CheckoutFields,
+		&pk.Project.Config.ReferenceScheme,
+		&pk.Project.Config.EscrowHold,
+		&pk.Project.Config.CallbackKeyOverlap,
+		&pk.Project.Config.CallbackRateLimit,
+		&pk.Project.Config.CallbackConcurrency,
+		&pk.Project.Config.MetadataLimits,
+		&pk.Project.Config.MethodPreferences,
+		&pk.Project.Config.PaymentExpiry,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -290,17 +425,76 @@ func scanProjectKey(row *sql.Row) (*Projectkey, error) {
 	if ts.Valid {
 		pk.Project.Config.Timestamp = time.Unix(ts.Int64, 0)
 	}
+	pk.SignatureMode = SignatureModeBaseString
+	if mode.Valid {
+		pk.SignatureMode = mode.String
+	}
 	return pk, nil
 }
 
 // ProjectKeyByKeyTx selects a project key by the given key
-func ProjectKeyByKeyTx(db *sql.Tx, key string) (*Projectkey, error) {
-	row := db.QueryRow(selectProjectKeyByKey, key)
+func ProjectKeyByKeyTx(ctx context.Context, db *sql.Tx, key string) (*Projectkey, error) {
+	row := db.QueryRowContext(ctx, selectProjectKeyByKey, key)
 	return scanProjectKey(row)
 }
 
 // ProjectKeyByKeyDB selects a project key by the given key
-func ProjectKeyByKeyDB(db *sql.DB, key string) (*Projectkey, error) {
-	row := db.QueryRow(selectProjectKeyByKey, key)
+func ProjectKeyByKeyDB(ctx context.Context, db *sql.DB, key string) (*Projectkey, error) {
+	row := db.QueryRowContext(ctx, selectProjectKeyByKey, key)
 	return scanProjectKey(row)
 }
+
+// ActiveProjectKeysDB selects all active project keys
+func ActiveProjectKeysDB(ctx context.Context, db *sql.DB) ([]*Projectkey, error) {
+	rows, err := db.QueryContext(ctx, selectActiveProjectKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]*Projectkey, 0)
+	for rows.Next() {
+		pk, err := scanProjectKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pk)
+	}
+	return keys, rows.Err()
+}
+
+const selectPaymentExpiries = `
+SELECT
+	c.project_id,
+	c.payment_expiry
+FROM project_config AS c
+WHERE
+	c.timestamp = (
+		SELECT MAX(timestamp) FROM project_config
+		WHERE
+			project_id = c.project_id
+	)
+	AND
+	c.payment_expiry > 0
+`
+
+// PaymentExpiriesDB selects the payment expiries of the projects whose
+// payments expire
+//
+// The returned map is keyed by project ID.
+func PaymentExpiriesDB(ctx context.Context, db *sql.DB) (map[int64]time.Duration, error) {
+	rows, err := db.QueryContext(ctx, selectPaymentExpiries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	expiries := make(map[int64]time.Duration)
+	for rows.Next() {
+		var projectID, seconds int64
+		err = rows.Scan(&projectID, &seconds)
+		if err != nil {
+			return nil, err
+		}
+		expiries[projectID] = time.Duration(seconds) * time.Second
+	}
+	return expiries, rows.Err()
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
 )
 
 func TestProjectSQLMapping(t *testing.T) {
@@ -24,7 +25,7 @@ func TestProjectSQLMapping(t *testing.T) {
 		So(err, ShouldBeNil)
 
 		Convey("When requesting a nonexistent project", func() {
-			project, err := ProjectByPrincipalIDNameDB(db, 1, "nonexistent")
+			project, err := ProjectByPrincipalIDNameDB(context.Background(), db, 1, "nonexistent")
 
 			Convey("It should return an empty project", func() {
 				So(project.Empty(), ShouldBeTrue)
@@ -8,6 +8,7 @@ import (
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
 	"github.com/fritzpay/paymentd/pkg/testutil"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
 )
 
 func WithTestProject(prDB *sql.Tx, f func(pr *project.Project)) func() {
@@ -24,7 +25,7 @@ func WithTestProject(prDB *sql.Tx, f func(pr *project.Project)) func() {
 		proj.PrincipalID = princ.ID
 		proj.Name = "project_testproject"
 		proj.CreatedBy = "test"
-		err = project.InsertProjectTx(prDB, proj)
+		err = project.InsertProjectTx(context.Background(), prDB, proj)
 		So(err, ShouldBeNil)
 
 		f(proj)
@@ -44,7 +45,7 @@ func TestProjectSQLMapping(t *testing.T) {
 			Convey("Given a test project", WithTestProject(tx, func(pr *project.Project) {
 
 				Convey("When selecting the project without a present config", func() {
-					selPr, err := project.ProjectByPrincipalIDAndNameTx(tx, pr.PrincipalID, pr.Name)
+					selPr, err := project.ProjectByPrincipalIDAndNameTx(context.Background(), tx, pr.PrincipalID, pr.Name)
 					So(err, ShouldBeNil)
 					So(selPr.Empty(), ShouldBeFalse)
 					Convey("The project config should not be set", func() {
@@ -55,11 +56,11 @@ func TestProjectSQLMapping(t *testing.T) {
 				Convey("Given a project config", func() {
 					pr.Config.CallbackURL.String, pr.Config.CallbackURL.Valid = "http://www.example.com", true
 					pr.Config.CallbackAPIVersion.String, pr.Config.CallbackAPIVersion.Valid = "1.2", true
-					err := project.InsertProjectConfigTx(tx, pr)
+					err := project.InsertProjectConfigTx(context.Background(), tx, pr)
 					So(err, ShouldBeNil)
 
 					Convey("When selecting the project", func() {
-						selPr, err := project.ProjectByPrincipalIDandIDTx(tx, pr.PrincipalID, pr.ID)
+						selPr, err := project.ProjectByPrincipalIDandIDTx(context.Background(), tx, pr.PrincipalID, pr.ID)
 						So(err, ShouldBeNil)
 						So(selPr.Empty(), ShouldBeFalse)
 
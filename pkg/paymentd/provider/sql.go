@@ -3,6 +3,9 @@ package provider
 import (
 	"database/sql"
 	"errors"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -32,8 +35,8 @@ func scanSingleRow(row *sql.Row) (Provider, error) {
 	return p, nil
 }
 
-func ProviderAllDB(db *sql.DB) ([]Provider, error) {
-	rows, err := db.Query(selectProvider)
+func ProviderAllDB(ctx context.Context, db *sql.DB) ([]Provider, error) {
+	rows, err := db.QueryContext(ctx, selectProvider)
 	if err != nil {
 		return nil, err
 	}
@@ -55,12 +58,53 @@ func ProviderAllDB(db *sql.DB) ([]Provider, error) {
 	return d, err
 }
 
-func ProviderByNameDB(db *sql.DB, name string) (Provider, error) {
-	row := db.QueryRow(selectProviderByName, name)
+func ProviderByNameDB(ctx context.Context, db *sql.DB, name string) (Provider, error) {
+	row := db.QueryRowContext(ctx, selectProviderByName, name)
 	return scanSingleRow(row)
 }
 
-func ProviderByNameTx(db *sql.Tx, name string) (Provider, error) {
-	row := db.QueryRow(selectProviderByName, name)
+func ProviderByNameTx(ctx context.Context, db *sql.Tx, name string) (Provider, error) {
+	row := db.QueryRowContext(ctx, selectProviderByName, name)
 	return scanSingleRow(row)
 }
+
+// ProviderListing is the listing of providers
+var ProviderListing = listing.Builder{
+	Select:      selectProvider,
+	Key:         "Name",
+	DefaultSort: "Name",
+	Columns: map[string]string{
+		"Name": "name",
+	},
+}
+
+// ProviderListDB selects a page of providers
+func ProviderListDB(ctx context.Context, db *sql.DB, q *listing.Query) ([]Provider, listing.Page, error) {
+	query, args, err := ProviderListing.Build(q, "")
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	d := make([]Provider, 0, q.Limit+1)
+	for rows.Next() {
+		pr := Provider{}
+		err = rows.Scan(&pr.Name)
+		if err != nil {
+			rows.Close()
+			return nil, listing.Page{}, err
+		}
+		d = append(d, pr)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(d), func(i int) listing.Cursor {
+		return listing.Cursor{Key: d[i].Name}
+	})
+	return d[:n], page, nil
+}
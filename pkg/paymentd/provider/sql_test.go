@@ -6,6 +6,7 @@ import (
 
 	"github.com/fritzpay/paymentd/pkg/testutil"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
 )
 
 func TestProviderSQL(t *testing.T) {
@@ -14,7 +15,7 @@ func TestProviderSQL(t *testing.T) {
 			db.Close()
 		})
 		Convey("When selecting the test provider", func() {
-			pr, err := ProviderByNameDB(db, "fritzpay")
+			pr, err := ProviderByNameDB(context.Background(), db, "fritzpay")
 
 			Convey("It should return the test provider", func() {
 				So(err, ShouldBeNil)
@@ -23,7 +24,7 @@ func TestProviderSQL(t *testing.T) {
 		})
 
 		Convey("When selecting a nonexistent provider", func() {
-			pr, err := ProviderByNameDB(db, "0")
+			pr, err := ProviderByNameDB(context.Background(), db, "0")
 
 			Convey("It should return an error", func() {
 				So(err, ShouldNotBeNil)
@@ -0,0 +1,27 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package reconciliation aggregates the money flows of a project by day
+
+A Report combines the created payments, the ledger of the payment transactions
+and the imported provider settlements into one row per day and currency. Finance
+teams reconcile the captured amounts against the settlements of the providers.
+The captured amounts of payments without a settlement are unreconciled.
+
+Days are in UTC.
+*/
+package reconciliation
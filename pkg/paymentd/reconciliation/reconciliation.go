@@ -0,0 +1,157 @@
+package reconciliation
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+)
+
+// DayFormat is the format of the days of a report
+const DayFormat = "2006-01-02"
+
+// MaxDays is the maximum number of days of a report
+const MaxDays = 92
+
+// ErrRange is returned if the days of a report are not a valid range
+var ErrRange = errors.New("invalid range of days")
+
+// Day is the reconciliation of the payments of a project in a currency on a day
+//
+// All amounts are positive and in the subunits of the currency.
+type Day struct {
+	// Day in the format "2006-01-02"
+	Day      string
+	Currency string
+	Subunits int8
+	// Created is the number of payments created on the day
+	Created int64
+	// CreatedAmount is the amount of the payments created on the day
+	CreatedAmount int64
+	// Paid is the amount captured or received on the day
+	Paid int64
+	// Refunded is the amount refunded on the day, less reversed refunds
+	Refunded int64
+	// ChargedBack is the amount withdrawn by chargebacks on the day, less
+	// reversed chargebacks
+	ChargedBack int64
+	// Settled is the number of payments whose settlement was imported on the
+	// day
+	Settled int64
+	// Fees are the fees of the settled payments
+	Fees int64
+	// Unsettled is the part of the paid amount, for which no settlement of the
+	// provider was imported yet
+	Unsettled int64
+}
+
+// Net returns the paid amount less refunds, chargebacks and fees
+func (d *Day) Net() int64 {
+	return d.Paid - d.Refunded - d.ChargedBack - d.Fees
+}
+
+// Decimal returns the decimal representation of an amount of the day
+func (d *Day) Decimal(units int64) *decimal.Decimal {
+	v := dec.NewDecInt64(units)
+	v.SetScale(dec.Scale(d.Subunits))
+	return &decimal.Decimal{Dec: *v}
+}
+
+// Report is the reconciliation of the payments of a project over a range of days
+type Report struct {
+	ProjectID int64
+	// From is the first day of the report
+	From time.Time
+	// To is the day after the last day of the report
+	To   time.Time
+	days map[dayKey]*Day
+}
+
+type dayKey struct {
+	day      string
+	currency string
+}
+
+// NewReport creates an empty report of the project for the given days
+//
+// The last day is included. The days are truncated to UTC days.
+func NewReport(projectID int64, first, last time.Time) (*Report, error) {
+	from := Truncate(first)
+	to := Truncate(last).AddDate(0, 0, 1)
+	if !from.Before(to) || to.Sub(from) > MaxDays*24*time.Hour {
+		return nil, ErrRange
+	}
+	return &Report{
+		ProjectID: projectID,
+		From:      from,
+		To:        to,
+		days:      make(map[dayKey]*Day),
+	}, nil
+}
+
+// Truncate returns the start of the UTC day of the given time
+func Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// day returns the day of the report in the given currency, creating it if
+// necessary
+func (r *Report) day(day, currency string, subunits int8) *Day {
+	k := dayKey{day: day, currency: currency}
+	d, ok := r.days[k]
+	if !ok {
+		d = &Day{
+			Day:      day,
+			Currency: currency,
+			Subunits: subunits,
+		}
+		r.days[k] = d
+	}
+	return d
+}
+
+// addTransactions adds the amounts of payment transactions of the given
+// status
+//
+// The positive amount is the sum of the positive transaction amounts, the
+// total amount the sum of all transaction amounts. The unsettled amount is the
+// part of the positive amount without settlement.
+func (r *Report) addTransactions(day, currency string, subunits int8, status payment.PaymentTransactionStatus, positive, total, unsettled int64) {
+	switch status {
+	case payment.PaymentStatusPaid,
+		payment.PaymentStatusPartiallyPaid,
+		payment.PaymentStatusPartiallyCaptured:
+		d := r.day(day, currency, subunits)
+		d.Paid += positive
+		d.Unsettled += unsettled
+	case payment.PaymentStatusRefunded,
+		payment.PaymentStatusPartiallyRefunded,
+		payment.PaymentStatusRefundReversed:
+		r.day(day, currency, subunits).Refunded -= total
+	case payment.PaymentStatusChargebackReceived,
+		payment.PaymentStatusChargebackReversed,
+		payment.PaymentStatusChargebackLost:
+		r.day(day, currency, subunits).ChargedBack -= total
+	}
+}
+
+// Days returns the days of the report, ordered by day and currency
+//
+// Days without payments, transactions or settlements are omitted.
+func (r *Report) Days() []*Day {
+	days := make([]*Day, 0, len(r.days))
+	for _, d := range r.days {
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		if days[i].Day != days[j].Day {
+			return days[i].Day < days[j].Day
+		}
+		return days[i].Currency < days[j].Currency
+	})
+	return days
+}
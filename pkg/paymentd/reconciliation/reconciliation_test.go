@@ -0,0 +1,69 @@
+package reconciliation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReport(t *testing.T) {
+	Convey("Given a report of two days", t, func() {
+		first := time.Date(2026, 3, 1, 13, 45, 0, 0, time.UTC)
+		r, err := NewReport(1, first, first.AddDate(0, 0, 1))
+		So(err, ShouldBeNil)
+
+		Convey("It should range over whole days", func() {
+			So(r.From, ShouldResemble, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+			So(r.To, ShouldResemble, time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC))
+		})
+
+		Convey("When adding transactions", func() {
+			r.addTransactions("2026-03-02", "EUR", 2, payment.PaymentStatusPaid, 10000, 10000, 4000)
+			r.addTransactions("2026-03-02", "EUR", 2, payment.PaymentStatusPartiallyCaptured, 2500, 2500, 0)
+			r.addTransactions("2026-03-02", "EUR", 2, payment.PaymentStatusPartiallyRefunded, 0, -1500, 0)
+			r.addTransactions("2026-03-02", "EUR", 2, payment.PaymentStatusChargebackReceived, 0, -3000, 0)
+			r.addTransactions("2026-03-02", "EUR", 2, payment.PaymentStatusChargebackReversed, 1000, 1000, 0)
+			r.addTransactions("2026-03-01", "USD", 2, payment.PaymentStatusPaid, 500, 500, 500)
+			r.addTransactions("2026-03-01", "EUR", 2, payment.PaymentStatusCancelled, 0, 0, 0)
+			r.day("2026-03-02", "EUR", 2).Fees = 350
+
+			days := r.Days()
+			Convey("The days should be ordered by day and currency", func() {
+				So(len(days), ShouldEqual, 2)
+				So(days[0].Day, ShouldEqual, "2026-03-01")
+				So(days[0].Currency, ShouldEqual, "USD")
+				So(days[1].Day, ShouldEqual, "2026-03-02")
+			})
+			Convey("The amounts should be aggregated by the ledger", func() {
+				d := days[1]
+				So(d.Paid, ShouldEqual, 12500)
+				So(d.Unsettled, ShouldEqual, 4000)
+				So(d.Refunded, ShouldEqual, 1500)
+				So(d.ChargedBack, ShouldEqual, 2000)
+				So(d.Net(), ShouldEqual, 12500-1500-2000-350)
+				So(d.Decimal(d.Net()).String(), ShouldEqual, "86.50")
+			})
+		})
+	})
+
+	Convey("When creating a report of too many days", t, func() {
+		first := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		_, err := NewReport(1, first, first.AddDate(0, 0, MaxDays))
+		Convey("It should fail", func() {
+			So(err, ShouldEqual, ErrRange)
+		})
+	})
+	Convey("When creating a report ending before it starts", t, func() {
+		first := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+		_, err := NewReport(1, first, first.AddDate(0, 0, -1))
+		Convey("It should fail", func() {
+			So(err, ShouldEqual, ErrRange)
+		})
+	})
+	Convey("The days since the unix epoch should be formatted as days", t, func() {
+		So(dayOf(0), ShouldEqual, "1970-01-01")
+		So(dayOf(time.Date(2026, 3, 2, 23, 59, 0, 0, time.UTC).UnixNano()/dayNanos), ShouldEqual, "2026-03-02")
+	})
+}
@@ -0,0 +1,194 @@
+package reconciliation
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/fee"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+)
+
+// nanoseconds of a day, timestamps are grouped by day with it
+const dayNanos = int64(24 * time.Hour)
+
+// ledgerStatuses are the statuses of the transactions which move money
+var ledgerStatuses = []payment.PaymentTransactionStatus{
+	payment.PaymentStatusPaid,
+	payment.PaymentStatusPartiallyPaid,
+	payment.PaymentStatusPartiallyCaptured,
+	payment.PaymentStatusRefunded,
+	payment.PaymentStatusPartiallyRefunded,
+	payment.PaymentStatusRefundReversed,
+	payment.PaymentStatusChargebackReceived,
+	payment.PaymentStatusChargebackReversed,
+	payment.PaymentStatusChargebackLost,
+}
+
+const selectCreated = `
+SELECT
+	DATE(created),
+	currency,
+	subunits,
+	COUNT(*),
+	SUM(amount)
+FROM payment
+WHERE
+	project_id = ?
+	AND
+	created >= ?
+	AND
+	created < ?
+GROUP BY DATE(created), currency, subunits
+`
+
+var selectTransactions = `
+SELECT
+	FLOOR(t.timestamp / ?),
+	t.currency,
+	t.subunits,
+	t.status,
+	SUM(CASE WHEN t.amount > 0 THEN t.amount ELSE 0 END),
+	SUM(t.amount),
+	SUM(CASE WHEN t.amount > 0 AND NOT EXISTS (
+		SELECT 1 FROM payment_cost AS c
+		WHERE
+			c.project_id = t.project_id
+			AND
+			c.payment_id = t.payment_id
+			AND
+			c.type = ?
+	) THEN t.amount ELSE 0 END)
+FROM payment_transaction AS t
+WHERE
+	t.project_id = ?
+	AND
+	t.timestamp >= ?
+	AND
+	t.timestamp < ?
+	AND
+	t.status IN (?` + strings.Repeat(", ?", len(ledgerStatuses)-1) + `)
+GROUP BY FLOOR(t.timestamp / ?), t.currency, t.subunits, t.status
+`
+
+const selectSettlements = `
+SELECT
+	FLOOR(a.timestamp / ?),
+	a.currency,
+	a.subunits,
+	COUNT(*),
+	SUM(a.amount)
+FROM payment_cost AS a
+WHERE
+	a.project_id = ?
+	AND
+	a.type = ?
+	AND
+	a.timestamp >= ?
+	AND
+	a.timestamp < ?
+	AND
+	a.timestamp = (
+		SELECT MAX(timestamp) FROM payment_cost
+		WHERE
+			project_id = a.project_id
+			AND
+			payment_id = a.payment_id
+			AND
+			type = a.type
+	)
+GROUP BY FLOOR(a.timestamp / ?), a.currency, a.subunits
+`
+
+// dayOf returns the day of the given number of days since the unix epoch
+func dayOf(days int64) string {
+	return time.Unix(days*int64(24*time.Hour/time.Second), 0).UTC().Format(DayFormat)
+}
+
+// ReportDB returns the reconciliation of the project from the first to the
+// last day
+func ReportDB(db *sql.DB, projectID int64, first, last time.Time) (*Report, error) {
+	r, err := NewReport(projectID, first, last)
+	if err != nil {
+		return nil, err
+	}
+	err = r.scanCreated(db.Query(selectCreated, projectID, r.From, r.To))
+	if err != nil {
+		return nil, err
+	}
+	args := []interface{}{dayNanos, fee.CostActual, projectID, r.From.UnixNano(), r.To.UnixNano()}
+	for _, s := range ledgerStatuses {
+		args = append(args, string(s))
+	}
+	args = append(args, dayNanos)
+	err = r.scanTransactions(db.Query(selectTransactions, args...))
+	if err != nil {
+		return nil, err
+	}
+	err = r.scanSettlements(db.Query(selectSettlements, dayNanos, projectID, fee.CostActual, r.From.UnixNano(), r.To.UnixNano(), dayNanos))
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Report) scanCreated(rows *sql.Rows, err error) error {
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day time.Time
+		var currency string
+		var subunits int8
+		var count, amount int64
+		err = rows.Scan(&day, &currency, &subunits, &count, &amount)
+		if err != nil {
+			return err
+		}
+		d := r.day(day.Format(DayFormat), currency, subunits)
+		d.Created += count
+		d.CreatedAmount += amount
+	}
+	return rows.Err()
+}
+
+func (r *Report) scanTransactions(rows *sql.Rows, err error) error {
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var days int64
+		var currency, status string
+		var subunits int8
+		var positive, total, unsettled int64
+		err = rows.Scan(&days, &currency, &subunits, &status, &positive, &total, &unsettled)
+		if err != nil {
+			return err
+		}
+		r.addTransactions(dayOf(days), currency, subunits, payment.PaymentTransactionStatus(status), positive, total, unsettled)
+	}
+	return rows.Err()
+}
+
+func (r *Report) scanSettlements(rows *sql.Rows, err error) error {
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var days int64
+		var currency string
+		var subunits int8
+		var count, fees int64
+		err = rows.Scan(&days, &currency, &subunits, &count, &fees)
+		if err != nil {
+			return err
+		}
+		d := r.day(dayOf(days), currency, subunits)
+		d.Settled += count
+		d.Fees += fees
+	}
+	return rows.Err()
+}
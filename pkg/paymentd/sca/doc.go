@@ -0,0 +1,28 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package sca provides the policies for exemptions from strong customer
+authentication (SCA)
+
+In SCA regions, card payments require the customer to authenticate, e.g. with
+3-D Secure. Projects can request exemptions for low-value payments and for
+payments with a low risk score (transaction risk analysis, TRA), so that the
+customer will not be challenged. The issuer decides whether the exemption is
+granted. The outcome of the authentication is recorded on the payment
+transaction.
+*/
+package sca
@@ -0,0 +1,160 @@
+package sca
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+)
+
+// MaxRiskScore is the highest risk score of payments
+const MaxRiskScore = 100
+
+// Exemption types
+const (
+	// ExemptionLowValue is the exemption of low-value payments
+	ExemptionLowValue = "low_value"
+	// ExemptionTRA is the exemption of payments with a low risk score
+	// (transaction risk analysis)
+	ExemptionTRA = "tra"
+)
+
+// Authentication outcomes
+const (
+	// OutcomeChallenged payments were authenticated by the customer
+	OutcomeChallenged = "challenged"
+	// OutcomeFrictionless payments were authenticated without challenging the
+	// customer
+	OutcomeFrictionless = "frictionless"
+	// OutcomeExempted payments were exempted from authentication
+	OutcomeExempted = "exempted"
+)
+
+var (
+	ErrPolicyCurrency = errors.New("policy without currency")
+)
+
+// ValidOutcome returns true if the given authentication outcome is known
+func ValidOutcome(outcome string) bool {
+	switch outcome {
+	case OutcomeChallenged, OutcomeFrictionless, OutcomeExempted:
+		return true
+	}
+	return false
+}
+
+// Policy is the policy of a project for requesting SCA exemptions
+//
+// The limits are decimal amounts in the currency of the policy. Exemptions are
+// not requested for payments in other currencies.
+type Policy struct {
+	// Countries are the ISO 3166-1 alpha-2 codes of the SCA region. The issuer
+	// country of the card is used, or the payment country if no card was
+	// recorded. An empty list applies to all countries.
+	Countries []string `json:",omitempty"`
+	Currency  string
+	// LowValueLimit is the maximum amount of low-value exemptions, e.g. "30.00".
+	// Low-value exemptions are not requested if it is empty.
+	LowValueLimit string `json:",omitempty"`
+	// TRALimit is the maximum amount of TRA exemptions, e.g. "250.00". TRA
+	// exemptions are not requested if it is empty.
+	TRALimit string `json:",omitempty"`
+	// TRAMaxRiskScore is the maximum risk score of payments eligible for TRA
+	// exemptions
+	TRAMaxRiskScore int `json:",omitempty"`
+
+	lowValueLimit *dec.Dec
+	traLimit      *dec.Dec
+}
+
+// ParsePolicy parses and compiles a JSON encoded policy
+func ParsePolicy(b []byte) (*Policy, error) {
+	p := &Policy{}
+	err := json.Unmarshal(b, p)
+	if err != nil {
+		return nil, err
+	}
+	err = p.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Compile validates the policy and parses its limits
+func (p *Policy) Compile() error {
+	if len(p.Currency) != 3 {
+		return ErrPolicyCurrency
+	}
+	var err error
+	p.lowValueLimit, err = parseLimit("LowValueLimit", p.LowValueLimit)
+	if err != nil {
+		return err
+	}
+	p.traLimit, err = parseLimit("TRALimit", p.TRALimit)
+	if err != nil {
+		return err
+	}
+	if p.TRAMaxRiskScore < 0 || p.TRAMaxRiskScore > MaxRiskScore {
+		return fmt.Errorf("TRAMaxRiskScore must be between 0 and %d", MaxRiskScore)
+	}
+	return nil
+}
+
+// parseLimit parses a non-negative decimal limit. Empty limits are nil
+func parseLimit(name, s string) (*dec.Dec, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d := dec.NewDecInt64(0)
+	if _, ok := d.SetString(s); !ok || d.Sign() < 0 {
+		return nil, fmt.Errorf("invalid %s %s", name, s)
+	}
+	return d, nil
+}
+
+// Transaction is a payment for which an exemption can be requested
+type Transaction struct {
+	Amount   *decimal.Decimal
+	Currency string
+	// Country is the issuer country of the card, or the payment country if the
+	// card is not known
+	Country string
+	// RiskScore is the risk score of the payment. It is nil if the payment was
+	// not assessed
+	RiskScore *int
+}
+
+// Applies returns true if the country is in the SCA region of the policy
+func (p *Policy) Applies(country string) bool {
+	if len(p.Countries) == 0 {
+		return true
+	}
+	for _, c := range p.Countries {
+		if c == country {
+			return true
+		}
+	}
+	return false
+}
+
+// Exemption returns the exemption which should be requested for the
+// transaction
+//
+// Low-value exemptions take precedence, since they do not depend on the risk
+// assessment. TRA exemptions are only requested for assessed payments. It
+// returns an empty string if no exemption should be requested.
+func (p *Policy) Exemption(t Transaction) string {
+	if t.Currency != p.Currency || !p.Applies(t.Country) {
+		return ""
+	}
+	if p.lowValueLimit != nil && t.Amount.Cmp(p.lowValueLimit) <= 0 {
+		return ExemptionLowValue
+	}
+	if p.traLimit != nil && t.RiskScore != nil && *t.RiskScore <= p.TRAMaxRiskScore && t.Amount.Cmp(p.traLimit) <= 0 {
+		return ExemptionTRA
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package sca
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func amount(s string) *decimal.Decimal {
+	d := &decimal.Decimal{}
+	if _, ok := d.SetString(s); !ok {
+		panic("invalid amount " + s)
+	}
+	return d
+}
+
+func TestPolicyCompile(t *testing.T) {
+	Convey("Given a policy without currency", t, func() {
+		_, err := ParsePolicy([]byte(`{"LowValueLimit":"30.00"}`))
+		Convey("It should fail", func() {
+			So(err, ShouldEqual, ErrPolicyCurrency)
+		})
+	})
+	Convey("Given a policy with an invalid limit", t, func() {
+		_, err := ParsePolicy([]byte(`{"Currency":"EUR","TRALimit":"-1"}`))
+		Convey("It should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Given a policy with a risk score out of range", t, func() {
+		_, err := ParsePolicy([]byte(`{"Currency":"EUR","TRAMaxRiskScore":101}`))
+		Convey("It should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPolicyExemption(t *testing.T) {
+	Convey("Given a policy", t, func() {
+		p, err := ParsePolicy([]byte(`{
+			"Countries": ["DE", "AT"],
+			"Currency": "EUR",
+			"LowValueLimit": "30.00",
+			"TRALimit": "250.00",
+			"TRAMaxRiskScore": 20
+		}`))
+		So(err, ShouldBeNil)
+		low, high := 10, 50
+
+		Convey("Low-value payments should request a low-value exemption", func() {
+			So(p.Exemption(Transaction{Amount: amount("30.00"), Currency: "EUR", Country: "DE"}), ShouldEqual, ExemptionLowValue)
+		})
+		Convey("Assessed payments with a low risk should request a TRA exemption", func() {
+			So(p.Exemption(Transaction{Amount: amount("100.00"), Currency: "EUR", Country: "AT", RiskScore: &low}), ShouldEqual, ExemptionTRA)
+		})
+		Convey("Payments with a high risk should not request an exemption", func() {
+			So(p.Exemption(Transaction{Amount: amount("100.00"), Currency: "EUR", Country: "AT", RiskScore: &high}), ShouldEqual, "")
+		})
+		Convey("Payments which were not assessed should not request a TRA exemption", func() {
+			So(p.Exemption(Transaction{Amount: amount("100.00"), Currency: "EUR", Country: "AT"}), ShouldEqual, "")
+		})
+		Convey("Payments exceeding the TRA limit should not request an exemption", func() {
+			So(p.Exemption(Transaction{Amount: amount("250.01"), Currency: "EUR", Country: "DE", RiskScore: &low}), ShouldEqual, "")
+		})
+		Convey("Payments outside of the policy should not request an exemption", func() {
+			So(p.Exemption(Transaction{Amount: amount("10.00"), Currency: "USD", Country: "DE"}), ShouldEqual, "")
+			So(p.Exemption(Transaction{Amount: amount("10.00"), Currency: "EUR", Country: "US"}), ShouldEqual, "")
+		})
+	})
+}
@@ -0,0 +1,29 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package subscription provides recurring payments of a project
+
+A subscription charges the amount of its plan once per plan interval. Each charge
+(cycle) is a payment of its own, which is related to the initial payment of the
+subscription as a recurring payment. The initial payment was paid by the customer
+and provides the payment method and the payment instrument stored with the
+provider.
+
+Subscriptions are append-only: Every status change and every charged cycle adds a
+new status, the current status of a subscription is the most recent one.
+*/
+package subscription
@@ -0,0 +1,57 @@
+package subscription
+
+import (
+	"time"
+)
+
+// Steps of the dunning of a subscription
+const (
+	// DunningRetry is the step after a failed charge, which will be retried
+	DunningRetry = "retry"
+	// DunningSuspended is the step after the last retry failed
+	DunningSuspended = "suspended"
+	// DunningRecovered is the step after a retry succeeded
+	DunningRecovered = "recovered"
+)
+
+// RetrySchedule holds the delays of the retries of a failed charge
+//
+// The n-th delay is the time between the n-th consecutive failed charge of a
+// cycle and its retry.
+type RetrySchedule []time.Duration
+
+// MaxFailures returns the number of failed charges of a cycle after which a
+// subscription will be suspended
+func (r RetrySchedule) MaxFailures() int {
+	return len(r) + 1
+}
+
+// ChargeFailed creates the status of the subscription after a failed charge of
+// its last cycle and returns the dunning step
+//
+// The subscription is past due until the charge is retried according to the
+// schedule. After the last retry failed, it is suspended.
+func (s *Subscription) ChargeFailed(r RetrySchedule, createdBy string) (*Status, string) {
+	failures := s.Status.Failures + 1
+	if failures >= r.MaxFailures() {
+		st := s.NewStatus(StatusSuspended, createdBy)
+		st.Failures = failures
+		return st, DunningSuspended
+	}
+	st := s.NewStatus(StatusPastDue, createdBy)
+	st.Failures = failures
+	st.NextCharge = st.Timestamp.Add(r[failures-1])
+	return st, DunningRetry
+}
+
+// ChargeRecovered creates the status of a past due subscription after its last
+// cycle was charged
+//
+// The subscription is active again. Its next cycle is charged as scheduled,
+// cycles scheduled while it was past due will not be charged.
+func (s *Subscription) ChargeRecovered(createdBy string) *Status {
+	st := s.NewStatus(StatusActive, createdBy)
+	st.Failures = 0
+	st.NextCharge = s.NextChargeAfter(st.Timestamp)
+	return st
+}
@@ -0,0 +1,273 @@
+package subscription
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"golang.org/x/net/context"
+)
+
+const selectSubscription = `
+SELECT
+	s.id,
+	s.project_id,
+	s.created,
+	s.created_by,
+	s.initial_payment_id,
+	s.plan_name,
+	s.amount,
+	s.subunits,
+	s.currency,
+	s.interval_unit,
+	s.interval_count,
+	s.start,
+	s.email,
+	st.timestamp,
+	st.status,
+	st.cycle,
+	st.next_charge,
+	st.failures,
+	st.payment_id,
+	st.created_by,
+	st.comment
+FROM subscription AS s
+INNER JOIN subscription_status AS st ON
+	st.subscription_id = s.id
+	AND
+	st.timestamp = (
+		SELECT MAX(timestamp) FROM subscription_status
+		WHERE
+			subscription_id = st.subscription_id
+	)
+`
+
+const selectSubscriptionByID = selectSubscription + `
+WHERE
+	s.id = ?
+`
+
+// SubscriptionListing is the listing of subscriptions
+var SubscriptionListing = listing.Builder{
+	Select:      selectSubscription,
+	Key:         "ID",
+	DefaultSort: "ID",
+	Columns: map[string]string{
+		"ID":    "s.id",
+		"Start": "s.start",
+	},
+}
+
+func subscriptionCursor(sortField string, s *Subscription) listing.Cursor {
+	c := listing.Cursor{Key: strconv.FormatInt(s.ID, 10)}
+	if sortField == "Start" {
+		c.Sort = strconv.FormatInt(s.Start.UnixNano(), 10)
+	}
+	return c
+}
+
+const selectSubscriptionsDue = `
+SELECT
+	s.id
+FROM subscription AS s
+INNER JOIN subscription_status AS st ON
+	st.subscription_id = s.id
+	AND
+	st.timestamp = (
+		SELECT MAX(timestamp) FROM subscription_status
+		WHERE
+			subscription_id = st.subscription_id
+	)
+WHERE
+	st.status IN ('` + StatusActive + `', '` + StatusPastDue + `')
+	AND
+	st.next_charge <= ?
+ORDER BY st.next_charge
+LIMIT ?
+`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSubscription(row scanner) (*Subscription, error) {
+	s := &Subscription{}
+	var created, start, ts, nextCharge int64
+	err := row.Scan(
+		&s.ID,
+		&s.ProjectID,
+		&created,
+		&s.CreatedBy,
+		&s.InitialPaymentID,
+		&s.Plan.Name,
+		&s.Plan.Amount,
+		&s.Plan.Subunits,
+		&s.Plan.Currency,
+		&s.Plan.Interval.Unit,
+		&s.Plan.Interval.Count,
+		&start,
+		&s.Email,
+		&ts,
+		&s.Status.Status,
+		&s.Status.Cycle,
+		&nextCharge,
+		&s.Status.Failures,
+		&s.Status.PaymentID,
+		&s.Status.CreatedBy,
+		&s.Status.Comment,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, err
+	}
+	s.Created = time.Unix(0, created)
+	s.Start = time.Unix(0, start)
+	s.Status.Timestamp = time.Unix(0, ts)
+	s.Status.NextCharge = time.Unix(0, nextCharge)
+	return s, nil
+}
+
+// SubscriptionByIDTx selects the subscription with the given ID
+//
+// The row will be locked for the transaction.
+func SubscriptionByIDTx(ctx context.Context, db *sql.Tx, id int64) (*Subscription, error) {
+	return scanSubscription(db.QueryRowContext(ctx, selectSubscriptionByID+" FOR UPDATE", id))
+}
+
+// SubscriptionByIDDB selects the subscription with the given ID
+func SubscriptionByIDDB(ctx context.Context, db *sql.DB, id int64) (*Subscription, error) {
+	return scanSubscription(db.QueryRowContext(ctx, selectSubscriptionByID, id))
+}
+
+// SubscriptionsByProjectIDDB selects a page of the subscriptions of a project
+func SubscriptionsByProjectIDDB(ctx context.Context, db *sql.DB, projectID int64, q *listing.Query) ([]*Subscription, listing.Page, error) {
+	sortField, err := SubscriptionListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	query, args, err := SubscriptionListing.Build(q, "s.project_id = ?", projectID)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	defer rows.Close()
+	list := make([]*Subscription, 0, q.Limit+1)
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, listing.Page{}, err
+		}
+		list = append(list, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(list), func(i int) listing.Cursor {
+		return subscriptionCursor(sortField, list[i])
+	})
+	return list[:n], page, nil
+}
+
+// DueDB selects the IDs of the active and past due subscriptions whose next
+// charge is due at the given time
+//
+// The subscriptions are ordered by their next charge.
+func DueDB(ctx context.Context, db *sql.DB, now time.Time, limit int) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, selectSubscriptionsDue, now.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int64, 0, limit)
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+const insertSubscription = `
+INSERT INTO subscription
+(project_id, created, created_by, initial_payment_id, plan_name, amount, subunits, currency, interval_unit, interval_count, start, email)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertSubscriptionTx saves a new active subscription, which will be charged
+// first at its start
+func InsertSubscriptionTx(ctx context.Context, db *sql.Tx, s *Subscription) error {
+	stmt, err := db.PrepareContext(ctx, insertSubscription)
+	if err != nil {
+		return err
+	}
+	if s.Created.IsZero() {
+		s.Created = time.Now()
+	}
+	res, err := stmt.ExecContext(ctx,
+		s.ProjectID,
+		s.Created.UnixNano(),
+		s.CreatedBy,
+		s.InitialPaymentID,
+		s.Plan.Name,
+		s.Plan.Amount,
+		s.Plan.Subunits,
+		s.Plan.Currency,
+		s.Plan.Interval.Unit,
+		s.Plan.Interval.Count,
+		s.Start.UnixNano(),
+		s.Email,
+	)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	s.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	st := s.NewStatus(StatusActive, s.CreatedBy)
+	st.Cycle = 0
+	st.NextCharge = s.Start
+	st.Failures = 0
+	st.PaymentID = sql.NullInt64{}
+	return InsertStatusTx(ctx, db, s, st)
+}
+
+const insertStatus = `
+INSERT INTO subscription_status
+(subscription_id, timestamp, status, cycle, next_charge, failures, payment_id, created_by, comment)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertStatusTx saves a new status of the given subscription
+func InsertStatusTx(ctx context.Context, db *sql.Tx, s *Subscription, st *Status) error {
+	stmt, err := db.PrepareContext(ctx, insertStatus)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		s.ID,
+		st.Timestamp.UnixNano(),
+		st.Status,
+		st.Cycle,
+		st.NextCharge.UnixNano(),
+		st.Failures,
+		st.PaymentID,
+		st.CreatedBy,
+		st.Comment,
+	)
+	stmt.Close()
+	return err
+}
@@ -0,0 +1,267 @@
+package subscription
+
+import (
+	"database/sql"
+	"errors"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+)
+
+// Statuses of subscriptions
+const (
+	// StatusActive subscriptions will be charged when their next charge is due
+	StatusActive = "active"
+	// StatusPaused subscriptions will not be charged until they are resumed
+	StatusPaused = "paused"
+	// StatusCancelled subscriptions will not be charged anymore
+	StatusCancelled = "cancelled"
+	// StatusPastDue subscriptions failed to charge their last cycle. The
+	// charge will be retried when their next charge is due.
+	StatusPastDue = "past_due"
+	// StatusSuspended subscriptions failed to charge their last cycle after
+	// all retries. They will not be charged until they are resumed.
+	StatusSuspended = "suspended"
+)
+
+// Interval units
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+const (
+	// limited by the name column of the subscription table
+	planNameMaxLength = 64
+	// intervalMaxCount is the maximum number of units of an interval
+	intervalMaxCount = 365
+	// limited by the email column of the subscription table
+	emailMaxLength = 254
+)
+
+var (
+	// ErrSubscriptionNotFound will be returned by select functions when the
+	// requested subscription was not found
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+
+	ErrInvalidPlanName = errors.New("invalid plan name")
+	ErrInvalidAmount   = errors.New("invalid amount")
+	ErrInvalidCurrency = errors.New("invalid currency")
+	ErrInvalidInterval = errors.New("invalid interval")
+	ErrInvalidEmail    = errors.New("invalid email address")
+)
+
+// Interval is the period between two charges of a subscription, e.g. 3 months
+type Interval struct {
+	Unit  string
+	Count int
+}
+
+// Valid returns true if the interval unit is known and the count is in range
+func (i Interval) Valid() bool {
+	switch i.Unit {
+	case IntervalDay, IntervalWeek, IntervalMonth, IntervalYear:
+	default:
+		return false
+	}
+	return i.Count > 0 && i.Count <= intervalMaxCount
+}
+
+// Time returns the time n intervals after the given start
+//
+// Monthly and yearly intervals keep the day of the month of the start. If the
+// target month is shorter, the last day of the month will be used, e.g. a monthly
+// interval starting on January 31st will return February 28th (or 29th) and
+// March 31st.
+func (i Interval) Time(start time.Time, n int64) time.Time {
+	count := int(n) * i.Count
+	switch i.Unit {
+	case IntervalDay:
+		return start.AddDate(0, 0, count)
+	case IntervalWeek:
+		return start.AddDate(0, 0, 7*count)
+	case IntervalYear:
+		count *= 12
+	}
+	t := start.AddDate(0, count, 0)
+	// AddDate normalizes overflowing days into the following month
+	if t.Day() != start.Day() {
+		t = t.AddDate(0, 0, -t.Day())
+	}
+	return t
+}
+
+func (i Interval) String() string {
+	return strconv.Itoa(i.Count) + " " + i.Unit
+}
+
+// Plan is what will be charged per interval
+type Plan struct {
+	Name     string
+	Amount   int64
+	Subunits int8
+	Currency string
+	Interval Interval
+}
+
+// Validate returns an error if the plan cannot be charged
+func (p *Plan) Validate() error {
+	if p.Name == "" || len(p.Name) > planNameMaxLength {
+		return ErrInvalidPlanName
+	}
+	if p.Amount <= 0 || p.Subunits < 0 {
+		return ErrInvalidAmount
+	}
+	if len(p.Currency) != 3 {
+		return ErrInvalidCurrency
+	}
+	if !p.Interval.Valid() {
+		return ErrInvalidInterval
+	}
+	return nil
+}
+
+// Decimal returns the decimal representation of the Amount and Subunits values
+func (p *Plan) Decimal() *decimal.Decimal {
+	d := dec.NewDecInt64(p.Amount)
+	sc := dec.Scale(int32(p.Subunits))
+	d.SetScale(sc)
+	return &decimal.Decimal{Dec: *d}
+}
+
+// Subscription represents the recurring payments of a customer of a project
+type Subscription struct {
+	ID        int64
+	ProjectID int64
+	Created   time.Time
+	CreatedBy string
+	// InitialPaymentID is the payment which was paid by the customer when
+	// subscribing
+	InitialPaymentID int64
+	Plan             Plan
+	// Start is the time of the first charge. The following charges are
+	// scheduled in plan intervals after the start.
+	Start time.Time
+	// Email is the address of the customer, which will be notified of failed
+	// charges
+	Email sql.NullString
+
+	Status Status
+}
+
+// Status represents a status change or a charged cycle of a subscription
+type Status struct {
+	Timestamp time.Time
+	Status    string
+	// Cycle is the number of charged cycles
+	Cycle int64
+	// NextCharge is the time when the next cycle is due, or the retry of the
+	// last cycle of a past due subscription
+	NextCharge time.Time
+	// Failures is the number of consecutive failed charges of the last cycle
+	Failures int
+	// PaymentID is the payment of the last charged cycle
+	PaymentID sql.NullInt64
+	CreatedBy string
+	Comment   sql.NullString
+}
+
+// SetEmail sets the address of the customer
+//
+// An empty address unsets it.
+func (s *Subscription) SetEmail(email string) error {
+	if email == "" {
+		s.Email = sql.NullString{}
+		return nil
+	}
+	if len(email) > emailMaxLength {
+		return ErrInvalidEmail
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" {
+		return ErrInvalidEmail
+	}
+	s.Email.String, s.Email.Valid = addr.Address, true
+	return nil
+}
+
+// Active returns true if the subscription will be charged
+func (s *Subscription) Active() bool {
+	return s.Status.Status == StatusActive
+}
+
+// Cancelled returns true if the subscription was cancelled
+func (s *Subscription) Cancelled() bool {
+	return s.Status.Status == StatusCancelled
+}
+
+// PastDue returns true if the charge of the last cycle failed and will be
+// retried
+func (s *Subscription) PastDue() bool {
+	return s.Status.Status == StatusPastDue
+}
+
+// Due returns true if the next cycle of an active subscription or the retry of
+// a past due subscription is due at the given time
+func (s *Subscription) Due(t time.Time) bool {
+	return (s.Active() || s.PastDue()) && !s.Status.NextCharge.After(t)
+}
+
+// NextChargeAfter returns the first scheduled charge after the given time
+func (s *Subscription) NextChargeAfter(t time.Time) time.Time {
+	if s.Start.After(t) {
+		return s.Start
+	}
+	var next time.Time
+	for n := int64(1); ; n++ {
+		next = s.Plan.Interval.Time(s.Start, n)
+		if next.After(t) {
+			return next
+		}
+	}
+}
+
+// CycleIdent returns the payment ident of the given cycle
+func (s *Subscription) CycleIdent(cycle int64) string {
+	return "subscription-" + strconv.FormatInt(s.ID, 10) + "-" + strconv.FormatInt(cycle, 10)
+}
+
+// CanChangeStatus returns true if the subscription can change to the given
+// status
+//
+// Active and past due subscriptions can be paused. Paused and suspended
+// subscriptions can be resumed. Cancelled subscriptions cannot change anymore.
+func (s *Subscription) CanChangeStatus(status string) bool {
+	switch status {
+	case StatusActive:
+		return s.Status.Status == StatusPaused || s.Status.Status == StatusSuspended
+	case StatusPaused:
+		return s.Status.Status == StatusActive || s.Status.Status == StatusPastDue
+	case StatusCancelled:
+		return !s.Cancelled()
+	default:
+		return false
+	}
+}
+
+// NewStatus creates a new status change for the subscription
+//
+// The cycle, the next charge, the failures and the payment of the last cycle
+// are copied from the current status.
+func (s *Subscription) NewStatus(status, createdBy string) *Status {
+	s.Status = Status{
+		Timestamp:  time.Now(),
+		Status:     status,
+		Cycle:      s.Status.Cycle,
+		NextCharge: s.Status.NextCharge,
+		Failures:   s.Status.Failures,
+		PaymentID:  s.Status.PaymentID,
+		CreatedBy:  createdBy,
+	}
+	return &s.Status
+}
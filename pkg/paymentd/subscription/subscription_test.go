@@ -0,0 +1,212 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInterval(t *testing.T) {
+	Convey("Given a start at the end of January", t, func() {
+		start := time.Date(2015, time.January, 31, 10, 0, 0, 0, time.UTC)
+
+		Convey("With a monthly interval", func() {
+			i := Interval{Unit: IntervalMonth, Count: 1}
+			Convey("It should keep the day of the month if possible", func() {
+				So(i.Time(start, 1), ShouldResemble, time.Date(2015, time.February, 28, 10, 0, 0, 0, time.UTC))
+				So(i.Time(start, 2), ShouldResemble, time.Date(2015, time.March, 31, 10, 0, 0, 0, time.UTC))
+				So(i.Time(start, 3), ShouldResemble, time.Date(2015, time.April, 30, 10, 0, 0, 0, time.UTC))
+			})
+		})
+		Convey("With a two-weekly interval", func() {
+			i := Interval{Unit: IntervalWeek, Count: 2}
+			Convey("It should add 14 days per interval", func() {
+				So(i.Time(start, 2), ShouldResemble, time.Date(2015, time.February, 28, 10, 0, 0, 0, time.UTC))
+			})
+		})
+		Convey("With a yearly interval", func() {
+			i := Interval{Unit: IntervalYear, Count: 1}
+			Convey("It should add 12 months per interval", func() {
+				So(i.Time(start, 1), ShouldResemble, time.Date(2016, time.January, 31, 10, 0, 0, 0, time.UTC))
+			})
+		})
+	})
+
+	Convey("Given intervals", t, func() {
+		Convey("An unknown unit should be invalid", func() {
+			So(Interval{Unit: "fortnight", Count: 1}.Valid(), ShouldBeFalse)
+		})
+		Convey("A zero count should be invalid", func() {
+			So(Interval{Unit: IntervalDay}.Valid(), ShouldBeFalse)
+		})
+		Convey("A daily interval should be valid", func() {
+			So(Interval{Unit: IntervalDay, Count: 1}.Valid(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestPlan(t *testing.T) {
+	Convey("Given a plan", t, func() {
+		p := &Plan{
+			Name:     "premium",
+			Amount:   999,
+			Subunits: 2,
+			Currency: "EUR",
+			Interval: Interval{Unit: IntervalMonth, Count: 1},
+		}
+		Convey("It should be valid", func() {
+			So(p.Validate(), ShouldBeNil)
+			So(p.Decimal().String(), ShouldEqual, "9.99")
+		})
+		Convey("When the amount is zero", func() {
+			p.Amount = 0
+			Convey("It should be invalid", func() {
+				So(p.Validate(), ShouldEqual, ErrInvalidAmount)
+			})
+		})
+		Convey("When the currency is invalid", func() {
+			p.Currency = "EURO"
+			Convey("It should be invalid", func() {
+				So(p.Validate(), ShouldEqual, ErrInvalidCurrency)
+			})
+		})
+		Convey("When the interval is invalid", func() {
+			p.Interval.Count = 0
+			Convey("It should be invalid", func() {
+				So(p.Validate(), ShouldEqual, ErrInvalidInterval)
+			})
+		})
+	})
+}
+
+func TestSubscription(t *testing.T) {
+	Convey("Given an active subscription", t, func() {
+		start := time.Date(2015, time.January, 15, 0, 0, 0, 0, time.UTC)
+		s := &Subscription{
+			ID: 7,
+			Plan: Plan{
+				Interval: Interval{Unit: IntervalMonth, Count: 1},
+			},
+			Start: start,
+		}
+		s.Status.Status = StatusActive
+		s.Status.NextCharge = start
+
+		Convey("It should be due at its next charge", func() {
+			So(s.Due(start.Add(-time.Second)), ShouldBeFalse)
+			So(s.Due(start), ShouldBeTrue)
+		})
+		Convey("It should schedule the next charge in intervals after the start", func() {
+			So(s.NextChargeAfter(start.Add(-time.Hour)), ShouldResemble, start)
+			So(s.NextChargeAfter(start), ShouldResemble, time.Date(2015, time.February, 15, 0, 0, 0, 0, time.UTC))
+			So(s.NextChargeAfter(time.Date(2015, time.May, 1, 0, 0, 0, 0, time.UTC)), ShouldResemble, time.Date(2015, time.May, 15, 0, 0, 0, 0, time.UTC))
+		})
+		Convey("It should have a payment ident per cycle", func() {
+			So(s.CycleIdent(3), ShouldEqual, "subscription-7-3")
+		})
+		Convey("It should be paused and cancelled, but not resumed", func() {
+			So(s.CanChangeStatus(StatusPaused), ShouldBeTrue)
+			So(s.CanChangeStatus(StatusCancelled), ShouldBeTrue)
+			So(s.CanChangeStatus(StatusActive), ShouldBeFalse)
+		})
+
+		Convey("It should accept a plain customer address only", func() {
+			So(s.SetEmail("customer@example.com"), ShouldBeNil)
+			So(s.Email.Valid, ShouldBeTrue)
+			So(s.SetEmail("Customer <customer@example.com>"), ShouldEqual, ErrInvalidEmail)
+			So(s.SetEmail("customer"), ShouldEqual, ErrInvalidEmail)
+			So(s.SetEmail(""), ShouldBeNil)
+			So(s.Email.Valid, ShouldBeFalse)
+		})
+
+		Convey("When it is paused", func() {
+			s.Status.Cycle = 2
+			st := s.NewStatus(StatusPaused, "operator")
+			Convey("It should keep its cycle and not be due", func() {
+				So(st.Cycle, ShouldEqual, 2)
+				So(st.CreatedBy, ShouldEqual, "operator")
+				So(s.Due(start), ShouldBeFalse)
+				So(s.CanChangeStatus(StatusActive), ShouldBeTrue)
+			})
+		})
+		Convey("When it is cancelled", func() {
+			s.NewStatus(StatusCancelled, "operator")
+			Convey("It should not change anymore", func() {
+				So(s.CanChangeStatus(StatusActive), ShouldBeFalse)
+				So(s.CanChangeStatus(StatusCancelled), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestDunning(t *testing.T) {
+	Convey("Given an active subscription and a retry schedule", t, func() {
+		start := time.Date(2015, time.January, 15, 0, 0, 0, 0, time.UTC)
+		s := &Subscription{
+			Plan: Plan{
+				Interval: Interval{Unit: IntervalMonth, Count: 1},
+			},
+			Start: start,
+		}
+		s.Status.Status = StatusActive
+		s.Status.Cycle = 1
+		r := RetrySchedule{24 * time.Hour, 72 * time.Hour}
+
+		Convey("It should suspend after the last retry", func() {
+			So(r.MaxFailures(), ShouldEqual, 3)
+		})
+
+		Convey("When the charge failed", func() {
+			st, step := s.ChargeFailed(r, "job")
+
+			Convey("It should be retried after the first delay", func() {
+				So(step, ShouldEqual, DunningRetry)
+				So(st.Status, ShouldEqual, StatusPastDue)
+				So(st.Failures, ShouldEqual, 1)
+				So(st.Cycle, ShouldEqual, 1)
+				So(st.NextCharge, ShouldResemble, st.Timestamp.Add(24*time.Hour))
+				So(s.Due(st.Timestamp), ShouldBeFalse)
+				So(s.Due(st.NextCharge), ShouldBeTrue)
+			})
+			Convey("It should be paused, but not resumed", func() {
+				So(s.CanChangeStatus(StatusPaused), ShouldBeTrue)
+				So(s.CanChangeStatus(StatusActive), ShouldBeFalse)
+			})
+
+			Convey("When the retries failed", func() {
+				st, step = s.ChargeFailed(r, "job")
+				So(step, ShouldEqual, DunningRetry)
+				So(st.NextCharge, ShouldResemble, st.Timestamp.Add(72*time.Hour))
+				st, step = s.ChargeFailed(r, "job")
+
+				Convey("It should be suspended", func() {
+					So(step, ShouldEqual, DunningSuspended)
+					So(st.Status, ShouldEqual, StatusSuspended)
+					So(st.Failures, ShouldEqual, 3)
+					So(s.Due(st.NextCharge), ShouldBeFalse)
+					So(s.CanChangeStatus(StatusActive), ShouldBeTrue)
+				})
+			})
+
+			Convey("When the retry succeeded", func() {
+				st = s.ChargeRecovered("job")
+
+				Convey("It should be active without failures", func() {
+					So(st.Status, ShouldEqual, StatusActive)
+					So(st.Failures, ShouldEqual, 0)
+					So(st.NextCharge, ShouldResemble, s.NextChargeAfter(st.Timestamp))
+				})
+			})
+		})
+	})
+	Convey("Given an empty retry schedule", t, func() {
+		s := &Subscription{}
+		s.Status.Status = StatusActive
+
+		Convey("The first failed charge should suspend the subscription", func() {
+			_, step := s.ChargeFailed(nil, "job")
+			So(step, ShouldEqual, DunningSuspended)
+		})
+	})
+}
@@ -0,0 +1,91 @@
+package testaccount
+
+import (
+	"sort"
+	"strings"
+)
+
+// Result is the result of a payment with a test number
+type Result string
+
+const (
+	// ResultSuccess payments will be paid
+	ResultSuccess Result = "success"
+	// ResultDecline payments will fail with the decline code of the outcome
+	ResultDecline Result = "decline"
+	// ResultChallenge payments will require a (simulated) 3-D Secure challenge.
+	// The payment will be paid if the challenge is passed and declined
+	// otherwise
+	ResultChallenge Result = "challenge"
+)
+
+// Decline codes
+const (
+	DeclineCardDeclined         = "card_declined"
+	DeclineInsufficientFunds    = "insufficient_funds"
+	DeclineExpiredCard          = "expired_card"
+	DeclineIncorrectCVC         = "incorrect_cvc"
+	DeclineAuthenticationFailed = "authentication_failed"
+)
+
+// Outcome is the deterministic outcome of a payment with a test number
+type Outcome struct {
+	Result Result
+	// DeclineCode is set for declined outcomes
+	DeclineCode string
+}
+
+var (
+	success   = Outcome{Result: ResultSuccess}
+	challenge = Outcome{Result: ResultChallenge}
+)
+
+func decline(code string) Outcome {
+	return Outcome{Result: ResultDecline, DeclineCode: code}
+}
+
+var numbers = map[string]Outcome{
+	// cards
+	"4242424242424242": success,
+	"5555555555554444": success,
+	"378282246310005":  success,
+	"4000000000000002": decline(DeclineCardDeclined),
+	"4000000000009995": decline(DeclineInsufficientFunds),
+	"4000000000000069": decline(DeclineExpiredCard),
+	"4000000000000127": decline(DeclineIncorrectCVC),
+	"4000000000003220": challenge,
+	"4000002760003184": challenge,
+	// accounts
+	"DE89370400440532013000": success,
+	"DE62370400440532013001": decline(DeclineInsufficientFunds),
+}
+
+// Normalize returns the number without whitespace and dashes and with upper
+// case letters
+func Normalize(number string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '-':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(number))
+}
+
+// Recognize returns the outcome of the given card or account number
+//
+// The second return value is false if the number is not a test number.
+func Recognize(number string) (Outcome, bool) {
+	o, ok := numbers[Normalize(number)]
+	return o, ok
+}
+
+// Numbers returns all recognized test numbers
+func Numbers() []string {
+	n := make([]string, 0, len(numbers))
+	for number := range numbers {
+		n = append(n, number)
+	}
+	sort.Strings(n)
+	return n
+}
@@ -0,0 +1,65 @@
+package testaccount
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecognize(t *testing.T) {
+	Convey("Given a formatted test card number", t, func() {
+		number := "4242 4242-4242 4242"
+
+		Convey("When recognizing the number", func() {
+			o, ok := Recognize(number)
+
+			Convey("It should be recognized as a successful payment", func() {
+				So(ok, ShouldBeTrue)
+				So(o.Result, ShouldEqual, ResultSuccess)
+				So(o.DeclineCode, ShouldEqual, "")
+			})
+		})
+	})
+
+	Convey("Given a lower case test IBAN", t, func() {
+		number := "de62 3704 0044 0532 0130 01"
+
+		Convey("When recognizing the number", func() {
+			o, ok := Recognize(number)
+
+			Convey("It should be recognized as a declined payment", func() {
+				So(ok, ShouldBeTrue)
+				So(o.Result, ShouldEqual, ResultDecline)
+				So(o.DeclineCode, ShouldEqual, DeclineInsufficientFunds)
+			})
+		})
+	})
+
+	Convey("Given a 3-D Secure test card number", t, func() {
+		number := "4000000000003220"
+
+		Convey("It should require a challenge", func() {
+			o, ok := Recognize(number)
+			So(ok, ShouldBeTrue)
+			So(o.Result, ShouldEqual, ResultChallenge)
+		})
+	})
+
+	Convey("Given an unknown number", t, func() {
+		number := "4111111111111112"
+
+		Convey("It should not be recognized", func() {
+			_, ok := Recognize(number)
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("All declined outcomes should have a decline code", t, func() {
+		for _, number := range Numbers() {
+			o, _ := Recognize(number)
+			if o.Result == ResultDecline {
+				So(o.DeclineCode, ShouldNotEqual, "")
+			}
+		}
+	})
+}
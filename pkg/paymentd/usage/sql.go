@@ -0,0 +1,236 @@
+package usage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+)
+
+var (
+	ErrRolledUp = errors.New("month already rolled up")
+)
+
+const insertRecord = `
+INSERT INTO api_usage
+(project_id, endpoint, hour, host, timestamp, requests, client_errors, server_errors)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertRecordsTx saves the given records
+func InsertRecordsTx(db *sql.Tx, records []*Record) error {
+	stmt, err := db.Prepare(insertRecord)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range records {
+		_, err = stmt.Exec(
+			r.ProjectID,
+			r.Endpoint,
+			r.Hour.UnixNano(),
+			r.Host,
+			r.Timestamp.UnixNano(),
+			r.Requests,
+			r.ClientErrors,
+			r.ServerErrors,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const selectRecordCounts = `
+SELECT
+	project_id,
+	endpoint,
+	SUM(requests),
+	SUM(client_errors),
+	SUM(server_errors)
+FROM api_usage
+WHERE
+	hour >= ?
+	AND
+	hour < ?
+`
+
+const selectRecordCountsByProject = selectRecordCounts + `
+	AND
+	project_id = ?
+GROUP BY project_id, endpoint
+`
+
+const selectRecordCountsAll = selectRecordCounts + `
+GROUP BY project_id, endpoint
+`
+
+// ProjectCounts are counts by project ID and endpoint
+type ProjectCounts map[int64]map[string]Counts
+
+func (p ProjectCounts) add(projectID int64, endpoint string, c Counts) {
+	if p[projectID] == nil {
+		p[projectID] = make(map[string]Counts)
+	}
+	p[projectID][endpoint] = c
+}
+
+// Merge adds the given counts, e.g. of another database shard
+func (p ProjectCounts) Merge(o ProjectCounts) {
+	for projectID, endpoints := range o {
+		for endpoint, c := range endpoints {
+			cur := p[projectID][endpoint]
+			cur.Add(c)
+			p.add(projectID, endpoint, cur)
+		}
+	}
+}
+
+func scanCounts(rows *sql.Rows) (ProjectCounts, error) {
+	defer rows.Close()
+	counts := make(ProjectCounts)
+	for rows.Next() {
+		var projectID int64
+		var endpoint string
+		var c Counts
+		err := rows.Scan(&projectID, &endpoint, &c.Requests, &c.ClientErrors, &c.ServerErrors)
+		if err != nil {
+			return nil, err
+		}
+		counts.add(projectID, endpoint, c)
+	}
+	return counts, rows.Err()
+}
+
+// RecordCountsByProjectDB selects the recorded counts of the given project
+// within the given time range by endpoint
+func RecordCountsByProjectDB(db *sql.DB, projectID int64, from, to time.Time) (map[string]Counts, error) {
+	rows, err := db.Query(selectRecordCountsByProject, from.UnixNano(), to.UnixNano(), projectID)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := scanCounts(rows)
+	if err != nil {
+		return nil, err
+	}
+	return counts[projectID], nil
+}
+
+// RecordCountsDB selects the recorded counts of all projects within the given
+// time range
+func RecordCountsDB(db *sql.DB, from, to time.Time) (ProjectCounts, error) {
+	rows, err := db.Query(selectRecordCountsAll, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	return scanCounts(rows)
+}
+
+const selectRollups = `
+SELECT
+	project_id,
+	endpoint,
+	requests,
+	client_errors,
+	server_errors
+FROM api_usage_month
+WHERE
+	month = ?
+`
+
+const selectRollupsByProject = selectRollups + `
+	AND
+	project_id = ?
+`
+
+const selectRollupsAll = selectRollups + `
+	AND
+	project_id <> 0
+`
+
+const selectRolledUp = `
+SELECT EXISTS (
+	SELECT 1 FROM api_usage_month
+	WHERE
+		month = ?
+		AND
+		project_id = 0
+)
+`
+
+// RolledUpDB returns true if the given month was rolled up
+func RolledUpDB(db *sql.DB, month time.Time) (bool, error) {
+	var rolledUp bool
+	err := db.QueryRow(selectRolledUp, month.UnixNano()).Scan(&rolledUp)
+	return rolledUp, err
+}
+
+// RollupsByProjectDB selects the rolled up counts of the given project in the
+// given month by endpoint
+func RollupsByProjectDB(db *sql.DB, projectID int64, month time.Time) (map[string]Counts, error) {
+	rows, err := db.Query(selectRollupsByProject, month.UnixNano(), projectID)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := scanCounts(rows)
+	if err != nil {
+		return nil, err
+	}
+	return counts[projectID], nil
+}
+
+// RollupsDB selects the rolled up counts of all projects in the given month
+func RollupsDB(db *sql.DB, month time.Time) (ProjectCounts, error) {
+	rows, err := db.Query(selectRollupsAll, month.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	return scanCounts(rows)
+}
+
+const insertRollup = `
+INSERT INTO api_usage_month
+(month, project_id, endpoint, timestamp, requests, client_errors, server_errors)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertRollupsTx saves the counts of the given month
+//
+// A row without project marks the month as rolled up, even if there was no
+// usage. It returns ErrRolledUp if the month was already rolled up.
+func InsertRollupsTx(db *sql.Tx, month time.Time, counts ProjectCounts) error {
+	stmt, err := db.Prepare(insertRollup)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	ts := time.Now().UnixNano()
+	_, err = stmt.Exec(month.UnixNano(), 0, "", ts, 0, 0, 0)
+	if err != nil {
+		if sqldialect.IsDuplicate(err) {
+			return ErrRolledUp
+		}
+		return err
+	}
+	for projectID, endpoints := range counts {
+		for endpoint, c := range endpoints {
+			_, err = stmt.Exec(
+				month.UnixNano(),
+				projectID,
+				endpoint,
+				ts,
+				c.Requests,
+				c.ClientErrors,
+				c.ServerErrors,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package usage
+
+import (
+	"sort"
+	"time"
+)
+
+// Counts are the counters of API requests
+type Counts struct {
+	Requests int64
+	// ClientErrors are requests answered with a 4xx status
+	ClientErrors int64
+	// ServerErrors are requests answered with a 5xx status
+	ServerErrors int64
+}
+
+// Observe counts a request answered with the given status
+func (c *Counts) Observe(status int) {
+	c.Requests++
+	switch {
+	case status >= 500:
+		c.ServerErrors++
+	case status >= 400:
+		c.ClientErrors++
+	}
+}
+
+// Add adds the given counts
+func (c *Counts) Add(o Counts) {
+	c.Requests += o.Requests
+	c.ClientErrors += o.ClientErrors
+	c.ServerErrors += o.ServerErrors
+}
+
+// ErrorRate returns the percentage of requests which were answered with an
+// error status
+func (c Counts) ErrorRate() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.ClientErrors+c.ServerErrors) / float64(c.Requests) * 100
+}
+
+// Record is a recorded count of requests of a project to an endpoint within an
+// hour
+type Record struct {
+	ProjectID int64
+	// Endpoint is the request method and the route, e.g. "POST /v1/payment"
+	Endpoint string
+	// Hour is the start of the hour in which the requests were received
+	Hour time.Time
+	// Host is the name of the instance which received the requests
+	Host      string
+	Timestamp time.Time
+	Counts
+}
+
+// Month returns the start of the month of the given time in UTC
+func Month(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ParseMonth parses a month in the format "2006-01"
+func ParseMonth(s string) (time.Time, error) {
+	return time.ParseInLocation("2006-01", s, time.UTC)
+}
+
+// Endpoint is the usage of an endpoint
+type Endpoint struct {
+	Endpoint string
+	Counts
+}
+
+// Summary is the usage of a project within a month
+type Summary struct {
+	ProjectID int64
+	// Month in the format "2006-01"
+	Month string
+	Counts
+	ErrorRate float64
+	// RolledUp is true if the month was rolled up and the usage is final
+	RolledUp bool
+	// Endpoints are the most requested endpoints, by requests descending
+	Endpoints []Endpoint
+}
+
+// Summarize returns the summary of the given counts by endpoint
+//
+// The summary lists up to top endpoints. All endpoints will be listed if top is
+// not positive.
+func Summarize(projectID int64, month time.Time, endpoints map[string]Counts, top int) *Summary {
+	s := &Summary{
+		ProjectID: projectID,
+		Month:     month.Format("2006-01"),
+		Endpoints: make([]Endpoint, 0, len(endpoints)),
+	}
+	for name, c := range endpoints {
+		s.Counts.Add(c)
+		s.Endpoints = append(s.Endpoints, Endpoint{Endpoint: name, Counts: c})
+	}
+	s.ErrorRate = s.Counts.ErrorRate()
+	sort.Sort(endpointsByRequests(s.Endpoints))
+	if top > 0 && len(s.Endpoints) > top {
+		s.Endpoints = s.Endpoints[:top]
+	}
+	return s
+}
+
+type endpointsByRequests []Endpoint
+
+func (e endpointsByRequests) Len() int { return len(e) }
+func (e endpointsByRequests) Less(i, j int) bool {
+	if e[i].Requests == e[j].Requests {
+		return e[i].Endpoint < e[j].Endpoint
+	}
+	return e[i].Requests > e[j].Requests
+}
+func (e endpointsByRequests) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+
+// Project is the usage of a project
+type Project struct {
+	ProjectID int64
+	Counts
+	ErrorRate float64
+}
+
+// SortProjects sorts the usage of projects by requests descending
+func SortProjects(projects []Project) {
+	sort.Sort(projectsByRequests(projects))
+}
+
+type projectsByRequests []Project
+
+func (p projectsByRequests) Len() int { return len(p) }
+func (p projectsByRequests) Less(i, j int) bool {
+	if p[i].Requests == p[j].Requests {
+		return p[i].ProjectID < p[j].ProjectID
+	}
+	return p[i].Requests > p[j].Requests
+}
+func (p projectsByRequests) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,86 @@
+package usage
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUsage(t *testing.T) {
+	Convey("Given usage counts", t, func() {
+		c := Counts{}
+		c.Observe(http.StatusOK)
+		c.Observe(http.StatusCreated)
+		c.Observe(http.StatusUnauthorized)
+		c.Observe(http.StatusServiceUnavailable)
+
+		Convey("Errors should be counted by class", func() {
+			So(c.Requests, ShouldEqual, 4)
+			So(c.ClientErrors, ShouldEqual, 1)
+			So(c.ServerErrors, ShouldEqual, 1)
+			So(c.ErrorRate(), ShouldEqual, 50)
+		})
+	})
+
+	Convey("Given counts by endpoint", t, func() {
+		endpoints := map[string]Counts{
+			"GET /v1/payment/paymentId/{paymentId}": {Requests: 40, ClientErrors: 4},
+			"POST /v1/payment":                      {Requests: 50, ServerErrors: 1},
+			"POST /v1/session":                      {Requests: 10},
+		}
+		month := time.Date(2015, 2, 1, 0, 0, 0, 0, time.UTC)
+
+		Convey("When summarized", func() {
+			s := Summarize(1, month, endpoints, 2)
+
+			Convey("It should contain the totals", func() {
+				So(s.Month, ShouldEqual, "2015-02")
+				So(s.Requests, ShouldEqual, 100)
+				So(s.ErrorRate, ShouldEqual, 5)
+			})
+			Convey("It should list the top endpoints", func() {
+				So(len(s.Endpoints), ShouldEqual, 2)
+				So(s.Endpoints[0].Endpoint, ShouldEqual, "POST /v1/payment")
+				So(s.Endpoints[1].Endpoint, ShouldEqual, "GET /v1/payment/paymentId/{paymentId}")
+			})
+		})
+	})
+
+	Convey("Given project counts of two shards", t, func() {
+		counts := ProjectCounts{
+			1: {"POST /v1/payment": {Requests: 10, ClientErrors: 1}},
+		}
+		other := ProjectCounts{
+			1: {
+				"POST /v1/payment": {Requests: 5, ServerErrors: 1},
+				"POST /v1/session": {Requests: 2},
+			},
+			2: {"POST /v1/payment": {Requests: 3}},
+		}
+
+		Convey("When merged", func() {
+			counts.Merge(other)
+
+			Convey("The counts of each project and endpoint should be added", func() {
+				So(counts, ShouldResemble, ProjectCounts{
+					1: {
+						"POST /v1/payment": {Requests: 15, ClientErrors: 1, ServerErrors: 1},
+						"POST /v1/session": {Requests: 2},
+					},
+					2: {"POST /v1/payment": {Requests: 3}},
+				})
+			})
+		})
+	})
+
+	Convey("When parsing a month", t, func() {
+		month, err := ParseMonth("2015-02")
+
+		Convey("It should be the start of the month in UTC", func() {
+			So(err, ShouldBeNil)
+			So(month.Equal(Month(time.Date(2015, 2, 11, 10, 0, 0, 0, time.UTC))), ShouldBeTrue)
+		})
+	})
+}
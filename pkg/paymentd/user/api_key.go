@@ -0,0 +1,96 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyIDBytes     = 8
+	apiKeySecretBytes = 32
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+)
+
+// APIKey is an API key issued to a user
+//
+// API keys authenticate as the user they were issued to, so actions taken with
+// an API key can be audited. Only a hash of the secret is stored. The plain
+// secret is only available directly after creating the key.
+type APIKey struct {
+	ID        string
+	Timestamp time.Time
+	UserID    int64
+	Name      string
+	Active    bool
+	CreatedBy string
+
+	// hex-encoded SHA-256 hash of the secret
+	secretHash string
+	secret     string
+}
+
+// NewAPIKey creates a new API key for the given user
+func NewAPIKey(u *User, name, createdBy string) (*APIKey, error) {
+	if u.Empty() {
+		return nil, errors.New("user id missing")
+	}
+	k := &APIKey{
+		Timestamp: time.Now(),
+		UserID:    u.ID,
+		Name:      name,
+		Active:    true,
+		CreatedBy: createdBy,
+	}
+	bin := make([]byte, apiKeyIDBytes)
+	_, err := rand.Read(bin)
+	if err != nil {
+		return nil, err
+	}
+	k.ID = hex.EncodeToString(bin)
+	bin = make([]byte, apiKeySecretBytes)
+	_, err = rand.Read(bin)
+	if err != nil {
+		return nil, err
+	}
+	k.secret = hex.EncodeToString(bin)
+	k.secretHash = hashSecret(k.secret)
+	return k, nil
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// Token returns the token which will be presented by clients
+//
+// It will only be available on newly created keys.
+func (k *APIKey) Token() string {
+	if k.secret == "" {
+		return ""
+	}
+	return k.ID + "." + k.secret
+}
+
+// ParseAPIKeyToken splits an API key token into its key ID and secret
+func ParseAPIKeyToken(token string) (id, secret string, err error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", ErrInvalidAPIKey
+	}
+	return parts[0], parts[1], nil
+}
+
+// CheckSecret returns true if the secret matches the secret of the key
+func (k *APIKey) CheckSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(k.secretHash)) == 1
+}
@@ -0,0 +1,38 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package user provides admin user accounts
+
+Admin users authenticate with a password or with API keys issued to them. Their
+permissions are determined by role grants, which can be global or scoped to a
+principal or project:
+
+	viewer
+		Read access.
+	operator
+		Read access and operations on payments.
+	admin
+		Full access including configuration changes.
+
+Higher roles include the permissions of lower roles.
+
+The impersonator role permits performing payment API requests as a project
+without its project key secret. It is neither included in nor includes the
+other roles, so it has to be granted explicitly. Impersonated requests are
+recorded with the impersonating user.
+*/
+package user
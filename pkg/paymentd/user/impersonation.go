@@ -0,0 +1,48 @@
+package user
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrImpersonationNotPermitted = errors.New("impersonation not permitted")
+)
+
+// Impersonation is the record of a payment API request, which a user performed
+// as a project
+//
+// Impersonations are the audit trail of the impersonator role. They record the
+// real actor of requests, which are otherwise indistinguishable from requests
+// of the project.
+type Impersonation struct {
+	ID         int64
+	UserID     int64
+	UserName   string
+	APIKeyID   string
+	ProjectID  int64
+	Timestamp  time.Time
+	Method     string
+	Path       string
+	RemoteAddr string
+}
+
+// NewImpersonation creates a record of a request, which the user performs as
+// the given project
+//
+// It will return an ErrImpersonationNotPermitted if the user is not granted the
+// impersonator role on the project.
+func NewImpersonation(u *User, principalID, projectID int64) (*Impersonation, error) {
+	if u.Empty() {
+		return nil, errors.New("user id missing")
+	}
+	if !u.Allows(RoleImpersonator, principalID, projectID) {
+		return nil, ErrImpersonationNotPermitted
+	}
+	return &Impersonation{
+		UserID:    u.ID,
+		UserName:  u.Name,
+		ProjectID: projectID,
+		Timestamp: time.Now(),
+	}, nil
+}
@@ -0,0 +1,62 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	sessionBytes = 32
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// Session is an admin UI session of a user
+//
+// Sessions are stored server-side, so they can be revoked before the
+// authorization expires.
+type Session struct {
+	ID         string
+	UserID     int64
+	Created    time.Time
+	Expires    time.Time
+	RemoteAddr string
+}
+
+// NewSession creates a new session for the given user
+func NewSession(u *User, lifetime time.Duration) (*Session, error) {
+	if u.Empty() {
+		return nil, errors.New("user id missing")
+	}
+	s := &Session{
+		UserID:  u.ID,
+		Created: time.Now(),
+	}
+	s.Expires = s.Created.Add(lifetime)
+	err := s.GenerateID()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GenerateID generates a new random session ID
+func (s *Session) GenerateID() error {
+	bin := make([]byte, sessionBytes)
+	_, err := rand.Read(bin)
+	if err != nil {
+		return err
+	}
+	s.ID = hex.EncodeToString(bin)
+	return nil
+}
+
+// Valid returns true if the session is not expired
+func (s *Session) Valid() bool {
+	now := time.Now()
+	return !now.Before(s.Created) && now.Before(s.Expires)
+}
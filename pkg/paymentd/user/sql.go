@@ -0,0 +1,499 @@
+package user
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+)
+
+const selectUser = `
+SELECT
+	u.id,
+	u.name,
+	u.created,
+	u.created_by,
+	c.timestamp,
+	c.password,
+	c.active,
+	c.roles,
+	c.created_by
+FROM admin_user AS u
+INNER JOIN admin_user_config AS c ON
+	c.user_id = u.id
+	AND
+	c.timestamp = (
+		SELECT MAX(timestamp) FROM admin_user_config
+		WHERE
+			user_id = c.user_id
+	)
+`
+
+const selectUserByID = selectUser + `
+WHERE
+	u.id = ?
+`
+
+const selectUserByName = selectUser + `
+WHERE
+	u.name = ?
+`
+
+const selectUsers = selectUser + `
+ORDER BY u.name
+`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*User, error) {
+	u := &User{}
+	var created, ts int64
+	var roles sql.NullString
+	err := row.Scan(
+		&u.ID,
+		&u.Name,
+		&created,
+		&u.CreatedBy,
+		&ts,
+		&u.Config.password,
+		&u.Config.Active,
+		&roles,
+		&u.Config.CreatedBy,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	u.Created = time.Unix(0, created)
+	u.Config.Timestamp = time.Unix(0, ts)
+	u.Config.Roles, err = parseGrants(roles.String)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// UserByIDDB selects the user with the given ID
+func UserByIDDB(db *sql.DB, id int64) (*User, error) {
+	return scanUser(db.QueryRow(selectUserByID, id))
+}
+
+// UserByNameDB selects the user with the given name
+func UserByNameDB(db *sql.DB, name string) (*User, error) {
+	return scanUser(db.QueryRow(selectUserByName, name))
+}
+
+// UserByNameTx selects the user with the given name
+//
+// The row will be locked for the transaction.
+func UserByNameTx(db *sql.Tx, name string) (*User, error) {
+	return scanUser(db.QueryRow(selectUserByName+" FOR UPDATE", name))
+}
+
+// UsersDB selects all users ordered by name
+func UsersDB(db *sql.DB) ([]*User, error) {
+	rows, err := db.Query(selectUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]*User, 0)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+const insertUser = `
+INSERT INTO admin_user
+(name, created, created_by)
+VALUES
+(?, ?, ?)
+`
+
+// InsertUserTx saves a new user along with its current config
+func InsertUserTx(db *sql.Tx, u *User) error {
+	stmt, err := db.Prepare(insertUser)
+	if err != nil {
+		return err
+	}
+	if u.Created.IsZero() {
+		u.Created = time.Now()
+	}
+	res, err := stmt.Exec(u.Name, u.Created.UnixNano(), u.CreatedBy)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	u.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if u.Config.CreatedBy == "" {
+		u.Config.CreatedBy = u.CreatedBy
+	}
+	return InsertConfigTx(db, u)
+}
+
+const insertConfig = `
+INSERT INTO admin_user_config
+(user_id, timestamp, password, active, roles, created_by)
+VALUES
+(?, ?, ?, ?, ?, ?)
+`
+
+// InsertConfigTx saves the current config of the given user
+func InsertConfigTx(db *sql.Tx, u *User) error {
+	stmt, err := db.Prepare(insertConfig)
+	if err != nil {
+		return err
+	}
+	u.Config.Timestamp = time.Now()
+	_, err = stmt.Exec(
+		u.ID,
+		u.Config.Timestamp.UnixNano(),
+		u.Config.password,
+		u.Config.Active,
+		u.Config.Roles.format(),
+		u.Config.CreatedBy,
+	)
+	stmt.Close()
+	return err
+}
+
+const insertSession = `
+INSERT INTO admin_session
+(id, user_id, created, expires, remote_addr)
+VALUES
+(?, ?, ?, ?, ?)
+`
+
+// InsertSessionDB saves a new session
+func InsertSessionDB(db *sql.DB, s *Session) error {
+	stmt, err := db.Prepare(insertSession)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		s.ID,
+		s.UserID,
+		s.Created.UnixNano(),
+		s.Expires.UnixNano(),
+		sql.NullString{String: s.RemoteAddr, Valid: s.RemoteAddr != ""},
+	)
+	stmt.Close()
+	return err
+}
+
+const selectSessionByID = `
+SELECT
+	id,
+	user_id,
+	created,
+	expires,
+	remote_addr
+FROM admin_session
+WHERE
+	id = ?
+`
+
+// SessionByIDDB selects the session with the given ID
+func SessionByIDDB(db *sql.DB, id string) (*Session, error) {
+	s := &Session{}
+	var created, expires int64
+	var addr sql.NullString
+	err := db.QueryRow(selectSessionByID, id).Scan(
+		&s.ID,
+		&s.UserID,
+		&created,
+		&expires,
+		&addr,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	s.Created = time.Unix(0, created)
+	s.Expires = time.Unix(0, expires)
+	s.RemoteAddr = addr.String
+	return s, nil
+}
+
+const updateSessionExpires = `
+UPDATE admin_session
+SET expires = ?
+WHERE
+	id = ?
+`
+
+// UpdateSessionExpiresDB saves the expiry of the given session
+func UpdateSessionExpiresDB(db *sql.DB, s *Session) error {
+	stmt, err := db.Prepare(updateSessionExpires)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(s.Expires.UnixNano(), s.ID)
+	stmt.Close()
+	return err
+}
+
+const deleteSession = `
+DELETE FROM admin_session
+WHERE
+	id = ?
+`
+
+// DeleteSessionDB removes the session with the given ID
+func DeleteSessionDB(db *sql.DB, id string) error {
+	stmt, err := db.Prepare(deleteSession)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(id)
+	stmt.Close()
+	return err
+}
+
+const deleteSessionsByUser = `
+DELETE FROM admin_session
+WHERE
+	user_id = ?
+`
+
+// DeleteSessionsByUserDB removes all sessions of the given user
+func DeleteSessionsByUserDB(db *sql.DB, u *User) error {
+	stmt, err := db.Prepare(deleteSessionsByUser)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(u.ID)
+	stmt.Close()
+	return err
+}
+
+const selectAPIKey = `
+SELECT
+	k.id,
+	k.timestamp,
+	k.user_id,
+	k.name,
+	k.secret,
+	k.active,
+	k.created_by
+FROM admin_api_key AS k
+WHERE
+	k.timestamp = (
+		SELECT MAX(timestamp) FROM admin_api_key
+		WHERE
+			id = k.id
+	)
+`
+
+const selectAPIKeyByID = selectAPIKey + `
+	AND
+	k.id = ?
+`
+
+const selectAPIKeysByUser = selectAPIKey + `
+	AND
+	k.user_id = ?
+ORDER BY k.name
+`
+
+func scanAPIKey(row scanner) (*APIKey, error) {
+	k := &APIKey{}
+	var ts int64
+	err := row.Scan(
+		&k.ID,
+		&ts,
+		&k.UserID,
+		&k.Name,
+		&k.secretHash,
+		&k.Active,
+		&k.CreatedBy,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	k.Timestamp = time.Unix(0, ts)
+	return k, nil
+}
+
+// APIKeyByIDDB selects the current version of the API key with the given ID
+func APIKeyByIDDB(db *sql.DB, id string) (*APIKey, error) {
+	return scanAPIKey(db.QueryRow(selectAPIKeyByID, id))
+}
+
+// APIKeysByUserDB selects all API keys of the given user
+func APIKeysByUserDB(db *sql.DB, u *User) ([]*APIKey, error) {
+	rows, err := db.Query(selectAPIKeysByUser, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]*APIKey, 0)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, k)
+	}
+	return list, rows.Err()
+}
+
+const insertAPIKey = `
+INSERT INTO admin_api_key
+(id, timestamp, user_id, name, secret, active, created_by)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertAPIKeyDB saves a new version of the given API key
+//
+// To revoke a key, save it with Active set to false.
+func InsertAPIKeyDB(db *sql.DB, k *APIKey) error {
+	stmt, err := db.Prepare(insertAPIKey)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		k.ID,
+		k.Timestamp.UnixNano(),
+		k.UserID,
+		k.Name,
+		k.secretHash,
+		k.Active,
+		k.CreatedBy,
+	)
+	stmt.Close()
+	return err
+}
+
+const insertImpersonation = `
+INSERT INTO admin_impersonation
+(user_id, user_name, api_key_id, project_id, timestamp, method, path, remote_addr)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertImpersonationDB saves the record of an impersonated request
+func InsertImpersonationDB(db *sql.DB, imp *Impersonation) error {
+	stmt, err := db.Prepare(insertImpersonation)
+	if err != nil {
+		return err
+	}
+	res, err := stmt.Exec(
+		imp.UserID,
+		imp.UserName,
+		sql.NullString{String: imp.APIKeyID, Valid: imp.APIKeyID != ""},
+		imp.ProjectID,
+		imp.Timestamp.UnixNano(),
+		imp.Method,
+		imp.Path,
+		sql.NullString{String: imp.RemoteAddr, Valid: imp.RemoteAddr != ""},
+	)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	imp.ID, err = res.LastInsertId()
+	return err
+}
+
+const selectImpersonations = `
+SELECT
+	id,
+	user_id,
+	user_name,
+	api_key_id,
+	project_id,
+	timestamp,
+	method,
+	path,
+	remote_addr
+FROM admin_impersonation
+`
+
+// ImpersonationListing is the listing of impersonated requests
+var ImpersonationListing = listing.Builder{
+	Select:      selectImpersonations,
+	Key:         "ID",
+	DefaultSort: "Timestamp",
+	Columns: map[string]string{
+		"ID":        "id",
+		"Timestamp": "timestamp",
+	},
+}
+
+func impersonationCursor(sortField string, imp *Impersonation) listing.Cursor {
+	c := listing.Cursor{Key: strconv.FormatInt(imp.ID, 10)}
+	if sortField == "Timestamp" {
+		c.Sort = strconv.FormatInt(imp.Timestamp.UnixNano(), 10)
+	}
+	return c
+}
+
+// ImpersonationsByProjectDB selects a page of the impersonated requests of the
+// given project
+func ImpersonationsByProjectDB(db *sql.DB, projectID int64, q *listing.Query) ([]*Impersonation, listing.Page, error) {
+	sortField, err := ImpersonationListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	query, args, err := ImpersonationListing.Build(q, "project_id = ?", projectID)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	defer rows.Close()
+	list := make([]*Impersonation, 0, q.Limit+1)
+	for rows.Next() {
+		imp := &Impersonation{}
+		var ts int64
+		var keyID, addr sql.NullString
+		err = rows.Scan(
+			&imp.ID,
+			&imp.UserID,
+			&imp.UserName,
+			&keyID,
+			&imp.ProjectID,
+			&ts,
+			&imp.Method,
+			&imp.Path,
+			&addr,
+		)
+		if err != nil {
+			return nil, listing.Page{}, err
+		}
+		imp.APIKeyID = keyID.String
+		imp.Timestamp = time.Unix(0, ts)
+		imp.RemoteAddr = addr.String
+		list = append(list, imp)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(list), func(i int) listing.Cursor {
+		return impersonationCursor(sortField, list[i])
+	})
+	return list[:n], page, nil
+}
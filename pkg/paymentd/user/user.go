@@ -0,0 +1,285 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// PasswordBcryptCost is the cost for bcrypting user passwords
+	PasswordBcryptCost = 10
+	// MinPasswordLength is the minimum length of user passwords
+	MinPasswordLength = 8
+)
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrInvalidPassword = errors.New("invalid password")
+	ErrInvalidGrant    = errors.New("invalid role grant")
+)
+
+var userNameRegexp = regexp.MustCompile(`^[-A-Za-z0-9_.@]{1,64}$`)
+
+// Role is a role of an admin user
+type Role string
+
+// Roles
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+	// RoleImpersonator permits performing payment API requests as a project
+	//
+	// It is not included in any other role and does not include other roles,
+	// so it has to be granted explicitly.
+	RoleImpersonator Role = "impersonator"
+)
+
+func (r Role) level() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Valid returns true if the role is known
+func (r Role) Valid() bool {
+	return r.level() > 0 || r == RoleImpersonator
+}
+
+// Includes returns true if the role includes the permissions of the other role
+func (r Role) Includes(o Role) bool {
+	if r == RoleImpersonator || o == RoleImpersonator {
+		return r == o
+	}
+	return r.Valid() && r.level() >= o.level()
+}
+
+// Grant grants a role to a user
+//
+// A grant without a principal and project ID is global. Grants on a principal
+// apply to all projects of the principal.
+//
+// Grants will be JSON-encoded in their string representation.
+type Grant struct {
+	Role        Role
+	PrincipalID int64
+	ProjectID   int64
+}
+
+// String returns the grant in its string representation
+//
+// E.g. "admin", "operator@principal:1" or "viewer@project:12"
+func (g Grant) String() string {
+	switch {
+	case g.ProjectID != 0:
+		return string(g.Role) + "@project:" + strconv.FormatInt(g.ProjectID, 10)
+	case g.PrincipalID != 0:
+		return string(g.Role) + "@principal:" + strconv.FormatInt(g.PrincipalID, 10)
+	default:
+		return string(g.Role)
+	}
+}
+
+// Valid returns true if the grant can be saved
+func (g Grant) Valid() bool {
+	return g.Role.Valid() && !(g.PrincipalID != 0 && g.ProjectID != 0)
+}
+
+// ParseGrant parses a grant from its string representation
+func ParseGrant(s string) (Grant, error) {
+	g := Grant{}
+	parts := strings.SplitN(s, "@", 2)
+	g.Role = Role(parts[0])
+	if len(parts) == 2 {
+		scope := strings.SplitN(parts[1], ":", 2)
+		if len(scope) != 2 {
+			return g, ErrInvalidGrant
+		}
+		id, err := strconv.ParseInt(scope[1], 10, 64)
+		if err != nil || id <= 0 {
+			return g, ErrInvalidGrant
+		}
+		switch scope[0] {
+		case "principal":
+			g.PrincipalID = id
+		case "project":
+			g.ProjectID = id
+		default:
+			return g, ErrInvalidGrant
+		}
+	}
+	if !g.Valid() {
+		return g, ErrInvalidGrant
+	}
+	return g, nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (g Grant) MarshalText() ([]byte, error) {
+	return []byte(g.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (g *Grant) UnmarshalText(text []byte) error {
+	var err error
+	*g, err = ParseGrant(string(text))
+	return err
+}
+
+// Grants is a list of role grants
+type Grants []Grant
+
+// Allows returns true if the grants include the given role for the given scope
+//
+// A principal ID of 0 requires a global grant. When the project ID is set, the
+// principal ID should be the principal of the project.
+func (gs Grants) Allows(r Role, principalID, projectID int64) bool {
+	for _, g := range gs {
+		if !g.Role.Includes(r) {
+			continue
+		}
+		switch {
+		case g.PrincipalID == 0 && g.ProjectID == 0:
+			return true
+		case g.ProjectID != 0:
+			if projectID != 0 && g.ProjectID == projectID {
+				return true
+			}
+		case g.PrincipalID != 0:
+			if principalID != 0 && g.PrincipalID == principalID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Equal returns true if both lists contain the same grants in the same order
+func (gs Grants) Equal(o Grants) bool {
+	if len(gs) != len(o) {
+		return false
+	}
+	for i := range gs {
+		if gs[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (gs Grants) format() sql.NullString {
+	if len(gs) == 0 {
+		return sql.NullString{}
+	}
+	parts := make([]string, len(gs))
+	for i, g := range gs {
+		parts[i] = g.String()
+	}
+	return sql.NullString{String: strings.Join(parts, ","), Valid: true}
+}
+
+func parseGrants(s string) (Grants, error) {
+	if s == "" {
+		return Grants{}, nil
+	}
+	parts := strings.Split(s, ",")
+	gs := make(Grants, len(parts))
+	for i, p := range parts {
+		var err error
+		gs[i], err = ParseGrant(p)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing grant %s: %v", p, err)
+		}
+	}
+	return gs, nil
+}
+
+// User represents an admin user
+type User struct {
+	ID        int64
+	Name      string
+	Created   time.Time
+	CreatedBy string
+
+	Config Config
+}
+
+// Config represents the current configuration of an admin user
+type Config struct {
+	Timestamp time.Time
+	// bcrypted password
+	password  sql.NullString
+	Active    bool
+	Roles     Grants
+	CreatedBy string
+}
+
+// ValidName returns true if the name can be used as a user name
+func ValidName(name string) bool {
+	return userNameRegexp.MatchString(name)
+}
+
+// Valid returns true if the user can be saved
+func (u *User) Valid() bool {
+	return ValidName(u.Name) && u.CreatedBy != ""
+}
+
+// Empty returns true if the user is considered empty/uninitialized
+func (u *User) Empty() bool {
+	return u.ID == 0
+}
+
+// HasPassword returns true if a password is set for the user
+func (u *User) HasPassword() bool {
+	return u.Config.password.Valid
+}
+
+// SetPassword sets a new password
+func (u *User) SetPassword(pw []byte) error {
+	if len(pw) < MinPasswordLength {
+		return ErrInvalidPassword
+	}
+	enc, err := bcrypt.GenerateFromPassword(pw, PasswordBcryptCost)
+	if err != nil {
+		return err
+	}
+	u.Config.password.String, u.Config.password.Valid = string(enc), true
+	return nil
+}
+
+// CheckPassword returns nil if the password matches the password of the user
+//
+// It will return an ErrInvalidPassword if the password does not match or if
+// the user has no password set.
+func (u *User) CheckPassword(pw []byte) error {
+	if !u.Config.password.Valid {
+		return ErrInvalidPassword
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(u.Config.password.String), pw)
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrInvalidPassword
+	}
+	return err
+}
+
+// Allows returns true if the user is active and has the given role for the
+// given scope
+//
+// See Grants.Allows
+func (u *User) Allows(r Role, principalID, projectID int64) bool {
+	return u.Config.Active && u.Config.Roles.Allows(r, principalID, projectID)
+}
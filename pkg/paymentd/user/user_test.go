@@ -0,0 +1,215 @@
+package user
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGrants(t *testing.T) {
+	Convey("Given a grant string", t, func() {
+		Convey("When it is a global grant", func() {
+			g, err := ParseGrant("admin")
+			Convey("It should parse", func() {
+				So(err, ShouldBeNil)
+				So(g.Role, ShouldEqual, RoleAdmin)
+				So(g.PrincipalID, ShouldEqual, 0)
+				So(g.ProjectID, ShouldEqual, 0)
+				So(g.String(), ShouldEqual, "admin")
+			})
+		})
+		Convey("When it is scoped to a principal", func() {
+			g, err := ParseGrant("operator@principal:3")
+			Convey("It should parse", func() {
+				So(err, ShouldBeNil)
+				So(g.Role, ShouldEqual, RoleOperator)
+				So(g.PrincipalID, ShouldEqual, 3)
+				So(g.String(), ShouldEqual, "operator@principal:3")
+			})
+		})
+		Convey("When it is scoped to a project", func() {
+			g, err := ParseGrant("viewer@project:12")
+			Convey("It should parse", func() {
+				So(err, ShouldBeNil)
+				So(g.Role, ShouldEqual, RoleViewer)
+				So(g.ProjectID, ShouldEqual, 12)
+			})
+		})
+		Convey("When it is invalid", func() {
+			for _, s := range []string{"", "root", "admin@", "admin@project", "admin@project:x", "admin@domain:1", "viewer@project:0"} {
+				_, err := ParseGrant(s)
+				So(err, ShouldEqual, ErrInvalidGrant)
+			}
+		})
+	})
+
+	Convey("Given grants", t, func() {
+		gs, err := parseGrants("operator@principal:3,viewer@project:12")
+		So(err, ShouldBeNil)
+		So(gs.format().String, ShouldEqual, "operator@principal:3,viewer@project:12")
+
+		Convey("Higher roles should include lower roles", func() {
+			So(gs.Allows(RoleViewer, 3, 0), ShouldBeTrue)
+			So(gs.Allows(RoleOperator, 3, 0), ShouldBeTrue)
+			So(gs.Allows(RoleAdmin, 3, 0), ShouldBeFalse)
+		})
+		Convey("Principal grants should apply to projects of the principal", func() {
+			So(gs.Allows(RoleOperator, 3, 7), ShouldBeTrue)
+		})
+		Convey("Project grants should only apply to the project", func() {
+			So(gs.Allows(RoleViewer, 4, 12), ShouldBeTrue)
+			So(gs.Allows(RoleOperator, 4, 12), ShouldBeFalse)
+			So(gs.Allows(RoleViewer, 4, 13), ShouldBeFalse)
+		})
+		Convey("Global access should not be allowed", func() {
+			So(gs.Allows(RoleViewer, 0, 0), ShouldBeFalse)
+		})
+		Convey("When a global grant is added", func() {
+			gs = append(gs, Grant{Role: RoleViewer})
+			Convey("Global viewer access should be allowed", func() {
+				So(gs.Allows(RoleViewer, 0, 0), ShouldBeTrue)
+				So(gs.Allows(RoleViewer, 9, 99), ShouldBeTrue)
+				So(gs.Allows(RoleOperator, 9, 99), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestUser(t *testing.T) {
+	Convey("Given a user", t, func() {
+		u := &User{
+			ID:        1,
+			Name:      "jane.doe",
+			CreatedBy: "root",
+		}
+		u.Config.Active = true
+		u.Config.Roles = Grants{{Role: RoleAdmin}}
+		So(u.Valid(), ShouldBeTrue)
+
+		Convey("When no password is set", func() {
+			Convey("No password should match", func() {
+				So(u.HasPassword(), ShouldBeFalse)
+				So(u.CheckPassword([]byte("")), ShouldEqual, ErrInvalidPassword)
+			})
+		})
+		Convey("When a short password is set", func() {
+			err := u.SetPassword([]byte("short"))
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrInvalidPassword)
+				So(u.HasPassword(), ShouldBeFalse)
+			})
+		})
+		Convey("When a password is set", func() {
+			So(u.SetPassword([]byte("correct horse")), ShouldBeNil)
+			Convey("It should match", func() {
+				So(u.CheckPassword([]byte("correct horse")), ShouldBeNil)
+				So(u.CheckPassword([]byte("battery staple")), ShouldEqual, ErrInvalidPassword)
+			})
+		})
+		Convey("When the user is inactive", func() {
+			u.Config.Active = false
+			Convey("Nothing should be allowed", func() {
+				So(u.Allows(RoleViewer, 0, 0), ShouldBeFalse)
+			})
+		})
+		Convey("When a session is created", func() {
+			s, err := NewSession(u, time.Minute)
+			So(err, ShouldBeNil)
+			Convey("It should be valid", func() {
+				So(s.ID, ShouldNotBeEmpty)
+				So(s.UserID, ShouldEqual, u.ID)
+				So(s.Valid(), ShouldBeTrue)
+			})
+		})
+		Convey("When an API key is issued", func() {
+			k, err := NewAPIKey(u, "ci", "root")
+			So(err, ShouldBeNil)
+			Convey("The token should authenticate", func() {
+				id, secret, err := ParseAPIKeyToken(k.Token())
+				So(err, ShouldBeNil)
+				So(id, ShouldEqual, k.ID)
+				So(k.CheckSecret(secret), ShouldBeTrue)
+				So(k.CheckSecret(secret+"x"), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestGrantJSON(t *testing.T) {
+	Convey("Given JSON-encoded grants", t, func() {
+		var gs Grants
+		err := json.Unmarshal([]byte(`["admin","viewer@project:12"]`), &gs)
+		Convey("They should decode", func() {
+			So(err, ShouldBeNil)
+			So(gs, ShouldResemble, Grants{{Role: RoleAdmin}, {Role: RoleViewer, ProjectID: 12}})
+		})
+		Convey("They should encode to their string representation", func() {
+			b, err := json.Marshal(gs)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, `["admin","viewer@project:12"]`)
+		})
+		Convey("When a grant is invalid", func() {
+			err := json.Unmarshal([]byte(`["root"]`), &gs)
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestImpersonation(t *testing.T) {
+	Convey("Given a global admin", t, func() {
+		u := &User{
+			ID:        1,
+			Name:      "jane.doe",
+			CreatedBy: "root",
+		}
+		u.Config.Active = true
+		u.Config.Roles = Grants{{Role: RoleAdmin}}
+
+		Convey("Impersonation should not be allowed", func() {
+			So(u.Allows(RoleImpersonator, 3, 12), ShouldBeFalse)
+			_, err := NewImpersonation(u, 3, 12)
+			So(err, ShouldEqual, ErrImpersonationNotPermitted)
+		})
+
+		Convey("When the impersonator role is granted on a project", func() {
+			g, err := ParseGrant("impersonator@project:12")
+			So(err, ShouldBeNil)
+			u.Config.Roles = append(u.Config.Roles, g)
+
+			Convey("The project should be impersonated", func() {
+				imp, err := NewImpersonation(u, 3, 12)
+				So(err, ShouldBeNil)
+				So(imp.UserID, ShouldEqual, u.ID)
+				So(imp.UserName, ShouldEqual, "jane.doe")
+				So(imp.ProjectID, ShouldEqual, 12)
+			})
+			Convey("Other projects should not be impersonated", func() {
+				_, err := NewImpersonation(u, 3, 13)
+				So(err, ShouldEqual, ErrImpersonationNotPermitted)
+			})
+		})
+
+		Convey("When only the impersonator role is granted", func() {
+			u.Config.Roles = Grants{{Role: RoleImpersonator}}
+
+			Convey("It should not include other roles", func() {
+				So(u.Allows(RoleImpersonator, 3, 12), ShouldBeTrue)
+				So(u.Allows(RoleViewer, 3, 12), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the user is inactive", func() {
+			u.Config.Roles = Grants{{Role: RoleImpersonator}}
+			u.Config.Active = false
+
+			Convey("Impersonation should not be allowed", func() {
+				_, err := NewImpersonation(u, 3, 12)
+				So(err, ShouldEqual, ErrImpersonationNotPermitted)
+			})
+		})
+	})
+}
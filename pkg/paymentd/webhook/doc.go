@@ -0,0 +1,25 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package webhook provides provider webhooks which could not be processed
+
+Webhooks which fail validation or reference unknown payments are kept as dead
+letters with the raw request and the reason they were rejected. Once the data
+was fixed, a dead letter can be re-processed with the handler which rejected
+it or be discarded.
+*/
+package webhook
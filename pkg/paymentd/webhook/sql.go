@@ -0,0 +1,235 @@
+package webhook
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+)
+
+const selectDeadLetter = `
+SELECT
+	d.id,
+	d.received,
+	d.provider,
+	d.handler,
+	d.reason,
+	d.method,
+	d.url,
+	d.header,
+	d.remote_addr,
+	d.body,
+	s.timestamp,
+	s.status,
+	s.created_by,
+	s.comment
+FROM provider_webhook_dead_letter AS d
+INNER JOIN provider_webhook_dead_letter_status AS s ON
+	s.dead_letter_id = d.id
+	AND
+	s.timestamp = (
+		SELECT MAX(timestamp) FROM provider_webhook_dead_letter_status
+		WHERE
+			dead_letter_id = s.dead_letter_id
+	)
+`
+
+const selectDeadLetterByID = selectDeadLetter + `
+WHERE
+	d.id = ?
+`
+
+// DeadLetterListing is the listing of dead letters
+var DeadLetterListing = listing.Builder{
+	Select:      selectDeadLetter,
+	Key:         "ID",
+	DefaultSort: "ID",
+	Columns: map[string]string{
+		"ID":       "d.id",
+		"Received": "d.received",
+	},
+}
+
+func deadLetterCursor(sortField string, d *DeadLetter) listing.Cursor {
+	c := listing.Cursor{Key: strconv.FormatInt(d.ID, 10)}
+	if sortField == "Received" {
+		c.Sort = strconv.FormatInt(d.Received.UnixNano(), 10)
+	}
+	return c
+}
+
+const selectDeadLetterCountByStatus = `
+SELECT COUNT(*)
+FROM provider_webhook_dead_letter_status AS s
+WHERE
+	s.status = ?
+	AND
+	s.timestamp = (
+		SELECT MAX(timestamp) FROM provider_webhook_dead_letter_status
+		WHERE
+			dead_letter_id = s.dead_letter_id
+	)
+`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDeadLetter(row scanner) (*DeadLetter, error) {
+	d := &DeadLetter{}
+	var received, ts int64
+	var header []byte
+	err := row.Scan(
+		&d.ID,
+		&received,
+		&d.Provider,
+		&d.Handler,
+		&d.Reason,
+		&d.Method,
+		&d.URL,
+		&header,
+		&d.RemoteAddr,
+		&d.Body,
+		&ts,
+		&d.Status.Status,
+		&d.Status.CreatedBy,
+		&d.Status.Comment,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDeadLetterNotFound
+		}
+		return nil, err
+	}
+	if len(header) > 0 {
+		err = json.Unmarshal(header, &d.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d.Received = time.Unix(0, received)
+	d.Status.Timestamp = time.Unix(0, ts)
+	return d, nil
+}
+
+// DeadLetterByIDTx selects the dead letter with the given ID
+//
+// The row will be locked for the transaction.
+func DeadLetterByIDTx(db *sql.Tx, id int64) (*DeadLetter, error) {
+	return scanDeadLetter(db.QueryRow(selectDeadLetterByID+" FOR UPDATE", id))
+}
+
+// DeadLetterByIDDB selects the dead letter with the given ID
+func DeadLetterByIDDB(db *sql.DB, id int64) (*DeadLetter, error) {
+	return scanDeadLetter(db.QueryRow(selectDeadLetterByID, id))
+}
+
+// DeadLettersByStatusDB selects a page of the dead letters with the given
+// current status
+func DeadLettersByStatusDB(db *sql.DB, status string, q *listing.Query) ([]*DeadLetter, listing.Page, error) {
+	sortField, err := DeadLetterListing.SortField(q)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	query, args, err := DeadLetterListing.Build(q, "s.status = ?", status)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	defer rows.Close()
+	list := make([]*DeadLetter, 0, q.Limit+1)
+	for rows.Next() {
+		d, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, listing.Page{}, err
+		}
+		list = append(list, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, listing.Page{}, err
+	}
+	page, n := listing.Paginate(q, len(list), func(i int) listing.Cursor {
+		return deadLetterCursor(sortField, list[i])
+	})
+	return list[:n], page, nil
+}
+
+// DeadLetterCountByStatusDB returns the number of dead letters with the given
+// current status
+func DeadLetterCountByStatusDB(db *sql.DB, status string) (int, error) {
+	var n int
+	err := db.QueryRow(selectDeadLetterCountByStatus, status).Scan(&n)
+	return n, err
+}
+
+const insertDeadLetter = `
+INSERT INTO provider_webhook_dead_letter
+(received, provider, handler, reason, method, url, header, remote_addr, body)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertDeadLetterTx saves a new dead letter with a pending status
+func InsertDeadLetterTx(db *sql.Tx, d *DeadLetter) error {
+	header, err := json.Marshal(d.Header)
+	if err != nil {
+		return err
+	}
+	stmt, err := db.Prepare(insertDeadLetter)
+	if err != nil {
+		return err
+	}
+	if d.Received.IsZero() {
+		d.Received = time.Now()
+	}
+	res, err := stmt.Exec(
+		d.Received.UnixNano(),
+		d.Provider,
+		d.Handler,
+		d.Reason,
+		d.Method,
+		d.URL,
+		header,
+		d.RemoteAddr,
+		d.Body,
+	)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	d.ID, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return InsertStatusTx(db, d, d.NewStatus(StatusPending, d.Provider))
+}
+
+const insertStatus = `
+INSERT INTO provider_webhook_dead_letter_status
+(dead_letter_id, timestamp, status, created_by, comment)
+VALUES
+(?, ?, ?, ?, ?)
+`
+
+// InsertStatusTx saves a new status of the given dead letter
+func InsertStatusTx(db *sql.Tx, d *DeadLetter, s *Status) error {
+	stmt, err := db.Prepare(insertStatus)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(
+		d.ID,
+		s.Timestamp.UnixNano(),
+		s.Status,
+		s.CreatedBy,
+		s.Comment,
+	)
+	stmt.Close()
+	return err
+}
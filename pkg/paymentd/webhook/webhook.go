@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Statuses of dead letters
+const (
+	// StatusPending dead letters are waiting to be re-processed
+	StatusPending = "pending"
+	// StatusProcessed dead letters were re-processed successfully
+	StatusProcessed = "processed"
+	// StatusDiscarded dead letters will not be processed
+	StatusDiscarded = "discarded"
+)
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+)
+
+// DeadLetter is a provider webhook which could not be processed
+type DeadLetter struct {
+	ID       int64
+	Received time.Time
+	// Provider is the name of the provider which sent the webhook
+	Provider string
+	// Handler is the name of the handler which rejected the webhook and
+	// which will re-process it
+	Handler string
+	// Reason describes why the webhook was rejected
+	Reason string
+
+	Method     string
+	URL        string
+	Header     http.Header
+	RemoteAddr string
+	Body       []byte
+
+	Status Status
+}
+
+// Status represents a status change on a dead letter
+type Status struct {
+	Timestamp time.Time
+	Status    string
+	CreatedBy string
+	Comment   sql.NullString
+}
+
+// NewDeadLetter creates a dead letter of the given request
+//
+// The body must be read by the caller, since the body of the request might
+// already be consumed.
+func NewDeadLetter(provider, handler, reason string, r *http.Request, body []byte) *DeadLetter {
+	return &DeadLetter{
+		Received:   time.Now(),
+		Provider:   provider,
+		Handler:    handler,
+		Reason:     reason,
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Header:     r.Header,
+		RemoteAddr: r.RemoteAddr,
+		Body:       body,
+	}
+}
+
+// Valid returns true if the dead letter can be saved
+func (d *DeadLetter) Valid() bool {
+	return d.Provider != "" && d.Handler != "" && d.Reason != "" && d.Method != "" && d.URL != ""
+}
+
+// Pending returns true if the dead letter is waiting to be re-processed
+func (d *DeadLetter) Pending() bool {
+	return d.Status.Status == StatusPending
+}
+
+// Request recreates the request of the webhook
+func (d *DeadLetter) Request() (*http.Request, error) {
+	r, err := http.NewRequest(d.Method, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header = d.Header
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.RemoteAddr = d.RemoteAddr
+	return r, nil
+}
+
+// NewStatus creates a new status change for the dead letter
+func (d *DeadLetter) NewStatus(status, createdBy string) *Status {
+	d.Status = Status{
+		Timestamp: time.Now(),
+		Status:    status,
+		CreatedBy: createdBy,
+	}
+	return &d.Status
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeadLetter(t *testing.T) {
+	Convey("Given a rejected webhook request", t, func() {
+		r, err := http.NewRequest("POST", "https://example.com/fritzpay/f?paymentID=1-2", strings.NewReader("payload"))
+		So(err, ShouldBeNil)
+		r.Header.Set("Content-Type", "text/plain")
+		r.RemoteAddr = "10.0.0.1:1234"
+
+		d := NewDeadLetter("fritzpay", "fritzpay/callback", "payment not found", r, []byte("payload"))
+
+		Convey("The dead letter should be valid", func() {
+			So(d.Valid(), ShouldBeTrue)
+		})
+		Convey("It should be invalid without a reason", func() {
+			d.Reason = ""
+			So(d.Valid(), ShouldBeFalse)
+		})
+		Convey("When recreating the request", func() {
+			re, err := d.Request()
+			So(err, ShouldBeNil)
+
+			Convey("It should equal the rejected request", func() {
+				So(re.Method, ShouldEqual, "POST")
+				So(re.URL.String(), ShouldEqual, r.URL.String())
+				So(re.Header.Get("Content-Type"), ShouldEqual, "text/plain")
+				So(re.RemoteAddr, ShouldEqual, "10.0.0.1:1234")
+				body, err := ioutil.ReadAll(re.Body)
+				So(err, ShouldBeNil)
+				So(string(body), ShouldEqual, "payload")
+			})
+		})
+		Convey("When the status changes", func() {
+			d.NewStatus(StatusPending, "fritzpay")
+			So(d.Pending(), ShouldBeTrue)
+			d.NewStatus(StatusDiscarded, "admin")
+
+			Convey("It should not be pending", func() {
+				So(d.Pending(), ShouldBeFalse)
+			})
+		})
+	})
+}
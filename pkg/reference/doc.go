@@ -0,0 +1,28 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package reference provides the reference numbers of payments.
+
+Reference numbers are printed on invoices and bank transfer instructions, so that
+customers quote them with their transfers. Projects configure a Scheme with a
+prefix, the number of sequence digits and a check digit algorithm. The sequence
+numbers are allocated from the payment database when the payment is created.
+
+Check digits let reconciliation reject mistyped references before they are
+looked up.
+*/
+package reference
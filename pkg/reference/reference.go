@@ -0,0 +1,176 @@
+package reference
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// MaxLen is the maximum length of reference numbers
+const MaxLen = 32
+
+// Check digit algorithms
+const (
+	// CheckNone adds no check digit
+	CheckNone = ""
+	// CheckLuhn adds one check digit calculated by the Luhn (mod 10) algorithm
+	CheckLuhn = "luhn"
+	// CheckMod97 adds two check digits calculated by ISO 7064 MOD 97-10
+	CheckMod97 = "mod97"
+)
+
+const (
+	maxPrefixLen = 12
+	maxDigits    = 18
+	// length of unpadded int64 sequence numbers
+	maxSeqLen = 19
+)
+
+var (
+	ErrPrefix     = errors.New("invalid reference prefix")
+	ErrDigits     = errors.New("invalid number of reference digits")
+	ErrCheckDigit = errors.New("unknown check digit algorithm")
+	ErrLength     = errors.New("reference exceeds maximum length")
+	ErrSequence   = errors.New("sequence number exceeds reference digits")
+)
+
+// Scheme describes the reference numbers of a project
+//
+// A reference consists of the prefix, the sequence number zero-padded to Digits
+// and the check digits, e.g. "INV000042" followed by its check digit. If Digits
+// is 0, the sequence number will not be padded.
+type Scheme struct {
+	// Prefix consists of up to 12 uppercase letters and digits
+	Prefix     string
+	Digits     int
+	CheckDigit string `json:",omitempty"`
+}
+
+// Check returns an error if the scheme is invalid
+func (s Scheme) Check() error {
+	if len(s.Prefix) > maxPrefixLen {
+		return ErrPrefix
+	}
+	for i := 0; i < len(s.Prefix); i++ {
+		c := s.Prefix[i]
+		if !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') {
+			return ErrPrefix
+		}
+	}
+	if s.Digits < 0 || s.Digits > maxDigits {
+		return ErrDigits
+	}
+	switch s.CheckDigit {
+	case CheckNone, CheckLuhn, CheckMod97:
+	default:
+		return ErrCheckDigit
+	}
+	seqLen := s.Digits
+	if seqLen == 0 {
+		seqLen = maxSeqLen
+	}
+	if len(s.Prefix)+seqLen+s.checkLen() > MaxLen {
+		return ErrLength
+	}
+	return nil
+}
+
+func (s Scheme) checkLen() int {
+	switch s.CheckDigit {
+	case CheckLuhn:
+		return 1
+	case CheckMod97:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Format returns the reference for the given sequence number
+func (s Scheme) Format(seq int64) (string, error) {
+	if seq < 0 {
+		return "", ErrSequence
+	}
+	num := strconv.FormatInt(seq, 10)
+	if s.Digits > 0 {
+		if len(num) > s.Digits {
+			return "", ErrSequence
+		}
+		num = strings.Repeat("0", s.Digits-len(num)) + num
+	}
+	return s.Prefix + num + s.check(num), nil
+}
+
+func (s Scheme) check(num string) string {
+	switch s.CheckDigit {
+	case CheckLuhn:
+		return strconv.Itoa(luhn(num))
+	case CheckMod97:
+		c := 98 - mod97(num+"00")
+		return strconv.Itoa(c/10) + strconv.Itoa(c%10)
+	default:
+		return ""
+	}
+}
+
+// Valid returns true if the (normalized) reference matches the scheme and its
+// check digits are correct
+func (s Scheme) Valid(ref string) bool {
+	if !strings.HasPrefix(ref, s.Prefix) {
+		return false
+	}
+	num := ref[len(s.Prefix):]
+	if len(num) <= s.checkLen() {
+		return false
+	}
+	num, check := num[:len(num)-s.checkLen()], num[len(num)-s.checkLen():]
+	if s.Digits > 0 && len(num) != s.Digits {
+		return false
+	}
+	for i := 0; i < len(num); i++ {
+		if num[i] < '0' || num[i] > '9' {
+			return false
+		}
+	}
+	return s.check(num) == check
+}
+
+// Normalize returns the reference as it was formatted, i.e. in upper case
+// without spaces and dashes
+//
+// Customers tend to group references when quoting them on transfers.
+func Normalize(ref string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(ref)))
+}
+
+// luhn returns the Luhn check digit of the number
+func luhn(num string) int {
+	var sum int
+	double := true
+	for i := len(num) - 1; i >= 0; i-- {
+		d := int(num[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
+
+// mod97 returns the remainder of the decimal number divided by 97
+func mod97(num string) int {
+	var r int
+	for i := 0; i < len(num); i++ {
+		r = (r*10 + int(num[i]-'0')) % 97
+	}
+	return r
+}
@@ -0,0 +1,51 @@
+package reference
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScheme(t *testing.T) {
+	Convey("Given a scheme with Luhn check digit", t, func() {
+		s := Scheme{Prefix: "INV", Digits: 6, CheckDigit: CheckLuhn}
+		So(s.Check(), ShouldBeNil)
+
+		Convey("The reference should be padded and checked", func() {
+			ref, err := s.Format(42)
+			So(err, ShouldBeNil)
+			So(ref, ShouldEqual, "INV0000422")
+			So(s.Valid(ref), ShouldBeTrue)
+		})
+		Convey("A mistyped reference should not be valid", func() {
+			So(s.Valid("INV0000242"), ShouldBeFalse)
+			So(s.Valid("INV000422"), ShouldBeFalse)
+			So(s.Valid("ABC0000422"), ShouldBeFalse)
+		})
+		Convey("A sequence exceeding the digits should fail", func() {
+			_, err := s.Format(1000000)
+			So(err, ShouldEqual, ErrSequence)
+		})
+	})
+	Convey("Given a scheme with ISO 7064 check digits", t, func() {
+		s := Scheme{Prefix: "RF", CheckDigit: CheckMod97}
+		So(s.Check(), ShouldBeNil)
+
+		Convey("The reference should not be padded", func() {
+			ref, err := s.Format(42)
+			So(err, ShouldBeNil)
+			So(ref, ShouldEqual, "RF4269")
+			So(s.Valid(ref), ShouldBeTrue)
+			So(s.Valid("RF2469"), ShouldBeFalse)
+		})
+	})
+	Convey("Invalid schemes should fail", t, func() {
+		So(Scheme{Prefix: "inv"}.Check(), ShouldEqual, ErrPrefix)
+		So(Scheme{Digits: 19}.Check(), ShouldEqual, ErrDigits)
+		So(Scheme{CheckDigit: "crc"}.Check(), ShouldEqual, ErrCheckDigit)
+		So(Scheme{Prefix: "INVOICE12345", CheckDigit: CheckMod97}.Check(), ShouldEqual, ErrLength)
+	})
+	Convey("Quoted references should be normalized", t, func() {
+		So(Normalize(" inv 0000-422 "), ShouldEqual, "INV0000422")
+	})
+}
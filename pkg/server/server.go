@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -81,6 +82,17 @@ func (s *Server) RegisterService(cfg config.ServiceConfig, handler http.Handler)
 	return nil
 }
 
+// RegisterTLSService adds a service to the server, which will be served via
+// TLS with the given TLS config
+func (s *Server) RegisterTLSService(cfg config.ServiceConfig, handler http.Handler, tlsConfig *tls.Config) error {
+	err := s.RegisterService(cfg, handler)
+	if err != nil {
+		return err
+	}
+	s.httpServers[len(s.httpServers)-1].TLSConfig = tlsConfig
+	return nil
+}
+
 // Serve starts serving
 func (s *Server) Serve() error {
 	if len(s.httpServers) == 0 {
@@ -196,7 +208,12 @@ func (s *Server) serveHTTP() {
 	for i, l := range s.listeners {
 		go func(i int, l grace.Listener) {
 			server := s.httpServers[i]
-			err := server.Serve(l)
+			var err error
+			if server.TLSConfig != nil {
+				err = server.Serve(tls.NewListener(l, server.TLSConfig))
+			} else {
+				err = server.Serve(l)
+			}
 			if err != nil && err != grace.ErrAlreadyClosed {
 				s.errors <- fmt.Errorf("error serving HTTP %s: %v", server.Addr, err)
 			}
@@ -0,0 +1,106 @@
+package alert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severity of an alert
+type Severity int
+
+// Alert severities
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+var severityNames = map[Severity]string{
+	SeverityInfo:     "info",
+	SeverityWarning:  "warning",
+	SeverityCritical: "critical",
+}
+
+// ParseSeverity parses a severity name. An empty name yields the
+// SeverityWarning
+func ParseSeverity(s string) (Severity, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return SeverityWarning, nil
+	}
+	for sev, name := range severityNames {
+		if name == s {
+			return sev, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid alert severity %q", s)
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return "severity(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Alert kinds
+const (
+	// KindDeclineSpike alerts on a spike of declined payments of a project
+	KindDeclineSpike = "decline_spike"
+	// KindProviderCircuitOpen alerts on a provider which is unavailable and
+	// will not be called until it recovers
+	KindProviderCircuitOpen = "provider_circuit_open"
+	// KindReconciliationMismatch alerts on received funds which do not match
+	// the payment
+	KindReconciliationMismatch = "reconciliation_mismatch"
+	// KindDatabaseDown alerts on a failed database connection
+	KindDatabaseDown = "database_down"
+	// KindDeadLetterGrowth alerts on provider webhooks piling up in the
+	// dead-letter queue
+	KindDeadLetterGrowth = "dead_letter_growth"
+)
+
+// Principal metadata keys configuring the sinks of a principal
+const (
+	// MetadataKeySlackURL holds the Slack incoming webhook URL
+	MetadataKeySlackURL = "alert_slack_url"
+	// MetadataKeyTeamsURL holds the Microsoft Teams incoming webhook URL
+	MetadataKeyTeamsURL = "alert_teams_url"
+	// MetadataKeySeverity holds the minimum severity of the alerts posted to
+	// the sinks of the principal. Defaults to "warning"
+	MetadataKeySeverity = "alert_severity"
+)
+
+// Field is an additional detail of an alert
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Alert is an operational notification on an anomaly
+type Alert struct {
+	Kind     string
+	Severity Severity
+	// PrincipalID of the principal concerned. The alert will be posted to the
+	// sinks of the principal
+	PrincipalID int64
+	// ProjectID of the project concerned. 0 if the alert is not project
+	// specific
+	ProjectID int64
+	Title     string
+	Text      string
+	Fields    []Field
+	Time      time.Time
+}
+
+// AddField adds a field to the alert
+func (a *Alert) AddField(name, value string) {
+	a.Fields = append(a.Fields, Field{Name: name, Value: value})
+}
+
+// Key identifies alerts on the same anomaly
+func (a *Alert) Key() string {
+	return a.Kind + "/" + strconv.FormatInt(a.PrincipalID, 10) + "/" + strconv.FormatInt(a.ProjectID, 10) + "/" + a.Title
+}
@@ -0,0 +1,108 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseSeverity(t *testing.T) {
+	Convey("Given severity names", t, func() {
+		Convey("They should be parsed case insensitive", func() {
+			s, err := ParseSeverity("Critical")
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, SeverityCritical)
+			So(s.String(), ShouldEqual, "critical")
+		})
+		Convey("An empty name should default to warning", func() {
+			s, err := ParseSeverity("")
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, SeverityWarning)
+		})
+		Convey("An unknown name should fail", func() {
+			_, err := ParseSeverity("urgent")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSinks(t *testing.T) {
+	Convey("Given an alert", t, func() {
+		a := &Alert{
+			Kind:     KindDeclineSpike,
+			Severity: SeverityCritical,
+			Title:    "Spike in declined payments of project test",
+			Text:     "25 of 40 payments were declined within 10m0s.",
+			Time:     time.Date(2015, time.March, 14, 10, 17, 0, 0, time.UTC),
+		}
+		a.AddField("Declined", "62%")
+
+		var received map[string]interface{}
+		status := http.StatusOK
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = nil
+			json.NewDecoder(r.Body).Decode(&received)
+			w.WriteHeader(status)
+			w.Write([]byte("invalid_payload"))
+		}))
+		defer srv.Close()
+
+		Convey("When posting it to Slack", func() {
+			s, err := NewSink(SinkTypeSlack, srv.URL, nil)
+			So(err, ShouldBeNil)
+			err = s.Send(a)
+			So(err, ShouldBeNil)
+
+			Convey("It should post an attachment", func() {
+				So(received["text"], ShouldEqual, "*critical*: "+a.Title)
+				atts := received["attachments"].([]interface{})
+				So(len(atts), ShouldEqual, 1)
+				att := atts[0].(map[string]interface{})
+				So(att["color"], ShouldEqual, "#D00000")
+				So(att["ts"], ShouldEqual, float64(a.Time.Unix()))
+				fields := att["fields"].([]interface{})
+				So(fields[0].(map[string]interface{})["value"], ShouldEqual, "62%")
+			})
+		})
+
+		Convey("When posting it to Teams", func() {
+			s, err := NewSink(SinkTypeTeams, srv.URL, nil)
+			So(err, ShouldBeNil)
+			err = s.Send(a)
+			So(err, ShouldBeNil)
+
+			Convey("It should post a message card", func() {
+				So(received["@type"], ShouldEqual, "MessageCard")
+				So(received["themeColor"], ShouldEqual, "D00000")
+				So(received["title"], ShouldEqual, a.Title)
+				sections := received["sections"].([]interface{})
+				facts := sections[0].(map[string]interface{})["facts"].([]interface{})
+				So(facts[0].(map[string]interface{})["name"], ShouldEqual, "Declined")
+			})
+		})
+
+		Convey("When the webhook rejects the alert", func() {
+			status = http.StatusBadRequest
+			s, err := NewSink(SinkTypeSlack, srv.URL, nil)
+			So(err, ShouldBeNil)
+			err = s.Send(a)
+
+			Convey("It should report the response", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "invalid_payload")
+			})
+		})
+
+		Convey("When creating a sink of an unknown type", func() {
+			_, err := NewSink("irc", srv.URL, nil)
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,28 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package alert provides operational alerts on payment anomalies and the sinks
+posting them to chat channels
+
+Alerts are posted to Slack and Microsoft Teams through incoming webhooks. Sinks
+can be configured globally and per principal. Every sink has a minimum severity;
+alerts with a lower severity will not be posted to it.
+
+Principals configure their sinks with the principal metadata keys
+"alert_slack_url", "alert_teams_url" and "alert_severity".
+*/
+package alert
@@ -0,0 +1,172 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Sink types
+const (
+	SinkTypeSlack = "slack"
+	SinkTypeTeams = "teams"
+)
+
+// Sink posts alerts
+type Sink interface {
+	Send(a *Alert) error
+}
+
+// NewSink creates a sink of the given type posting to the webhook URL
+func NewSink(typ, url string, cl *http.Client) (Sink, error) {
+	if url == "" {
+		return nil, fmt.Errorf("missing webhook URL for %s sink", typ)
+	}
+	switch typ {
+	case SinkTypeSlack:
+		return &SlackSink{URL: url, Client: cl}, nil
+	case SinkTypeTeams:
+		return &TeamsSink{URL: url, Client: cl}, nil
+	default:
+		return nil, fmt.Errorf("invalid alert sink type %q", typ)
+	}
+}
+
+var severityColors = map[Severity]string{
+	SeverityInfo:     "439FE0",
+	SeverityWarning:  "FFA500",
+	SeverityCritical: "D00000",
+}
+
+func (a *Alert) color() string {
+	if c, ok := severityColors[a.Severity]; ok {
+		return c
+	}
+	return severityColors[SeverityInfo]
+}
+
+// SlackSink posts alerts to a Slack incoming webhook
+type SlackSink struct {
+	URL    string
+	Client *http.Client
+}
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Fallback string       `json:"fallback"`
+	Color    string       `json:"color"`
+	Title    string       `json:"title"`
+	Text     string       `json:"text,omitempty"`
+	Fields   []slackField `json:"fields,omitempty"`
+	Footer   string       `json:"footer"`
+	Ts       int64        `json:"ts"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+func newSlackMessage(a *Alert) slackMessage {
+	att := slackAttachment{
+		Fallback: "[" + a.Severity.String() + "] " + a.Title,
+		Color:    "#" + a.color(),
+		Title:    a.Title,
+		Text:     a.Text,
+		Footer:   "paymentd " + a.Kind,
+		Ts:       a.Time.Unix(),
+	}
+	for _, f := range a.Fields {
+		att.Fields = append(att.Fields, slackField{Title: f.Name, Value: f.Value, Short: true})
+	}
+	return slackMessage{
+		Text:        "*" + a.Severity.String() + "*: " + a.Title,
+		Attachments: []slackAttachment{att},
+	}
+}
+
+// Send implements the Sink
+func (s *SlackSink) Send(a *Alert) error {
+	return post(s.Client, s.URL, newSlackMessage(a))
+}
+
+// TeamsSink posts alerts to a Microsoft Teams incoming webhook
+type TeamsSink struct {
+	URL    string
+	Client *http.Client
+}
+
+type teamsMessage struct {
+	Type       string         `json:"@type"`
+	Context    string         `json:"@context"`
+	Summary    string         `json:"summary"`
+	ThemeColor string         `json:"themeColor"`
+	Title      string         `json:"title"`
+	Sections   []teamsSection `json:"sections"`
+}
+
+type teamsSection struct {
+	ActivitySubtitle string      `json:"activitySubtitle"`
+	Text             string      `json:"text,omitempty"`
+	Facts            []teamsFact `json:"facts,omitempty"`
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func newTeamsMessage(a *Alert) teamsMessage {
+	sec := teamsSection{
+		ActivitySubtitle: a.Severity.String() + " " + a.Kind + " at " + a.Time.UTC().Format(time.RFC3339),
+		Text:             a.Text,
+	}
+	for _, f := range a.Fields {
+		sec.Facts = append(sec.Facts, teamsFact{Name: f.Name, Value: f.Value})
+	}
+	return teamsMessage{
+		Type:       "MessageCard",
+		Context:    "http://schema.org/extensions",
+		Summary:    "[" + a.Severity.String() + "] " + a.Title,
+		ThemeColor: a.color(),
+		Title:      a.Title,
+		Sections:   []teamsSection{sec},
+	}
+}
+
+// Send implements the Sink
+func (s *TeamsSink) Send(a *Alert) error {
+	return post(s.Client, s.URL, newTeamsMessage(a))
+}
+
+// maximum number of bytes of an error response which will be reported
+const maxErrorBody = 512
+
+func post(cl *http.Client, url string, msg interface{}) error {
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := cl.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("webhook responded with status %d: %s", resp.StatusCode, b)
+	}
+	return nil
+}
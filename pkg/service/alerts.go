@@ -0,0 +1,174 @@
+package service
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"github.com/fritzpay/paymentd/pkg/service/alert"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// timeout for posting an alert to a sink
+	alertTimeout = 10 * time.Second
+	// the cooldowns will be pruned when this many alerts are tracked
+	alertPruneSize = 1024
+)
+
+// principalMetadataModel is the metadata model of principals
+//
+// It mirrors the principal.MetadataModel, which cannot be used here since the
+// tests of the principal package depend on this package.
+type principalMetadataModel struct{}
+
+func (principalMetadataModel) Table() string {
+	return "principal_metadata"
+}
+
+func (principalMetadataModel) PrimaryField() string {
+	return "principal_id"
+}
+
+type alertSink struct {
+	sink     alert.Sink
+	severity alert.Severity
+}
+
+// Alerter posts operational alerts to the configured sinks and to the sinks of
+// the principals concerned
+//
+// Alerts on the same anomaly will be dropped within the cooldown.
+type Alerter struct {
+	ctx *Context
+	log log15.Logger
+	cl  *http.Client
+
+	cooldown time.Duration
+	sinks    []alertSink
+
+	m    sync.Mutex
+	last map[string]time.Time
+}
+
+func alerterFromConfig(ctx *Context, cfg config.Config) (*Alerter, error) {
+	a := &Alerter{
+		ctx: ctx,
+		log: ctx.Log().New(log15.Ctx{
+			"pkg":  "github.com/fritzpay/paymentd/pkg/service",
+			"type": "Alerter",
+		}),
+		cl:   &http.Client{Timeout: alertTimeout},
+		last: make(map[string]time.Time),
+	}
+	var err error
+	if cfg.Alerts.Cooldown != "" {
+		if a.cooldown, err = cfg.Alerts.Cooldown.Duration(); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range cfg.Alerts.Sinks {
+		sink, err := a.newSink(s.Type, s.URL, s.Severity)
+		if err != nil {
+			return nil, err
+		}
+		a.sinks = append(a.sinks, sink)
+	}
+	return a, nil
+}
+
+func (a *Alerter) newSink(typ, url, severity string) (alertSink, error) {
+	sev, err := alert.ParseSeverity(severity)
+	if err != nil {
+		return alertSink{}, err
+	}
+	sink, err := alert.NewSink(typ, url, a.cl)
+	if err != nil {
+		return alertSink{}, err
+	}
+	return alertSink{sink: sink, severity: sev}, nil
+}
+
+// Alert posts the alert in the background
+func (a *Alerter) Alert(al *alert.Alert) {
+	if al.Time.IsZero() {
+		al.Time = time.Now()
+	}
+	log := a.log.New(log15.Ctx{
+		"kind":        al.Kind,
+		"severity":    al.Severity.String(),
+		"principalID": al.PrincipalID,
+		"projectID":   al.ProjectID,
+	})
+	if !a.admit(al) {
+		log.Debug("alert within cooldown. dropping", log15.Ctx{"title": al.Title})
+		return
+	}
+	log.Warn(al.Title, log15.Ctx{"text": al.Text})
+	go a.post(al, log)
+}
+
+// admit returns true if no alert on the same anomaly was admitted within the
+// cooldown
+func (a *Alerter) admit(al *alert.Alert) bool {
+	a.m.Lock()
+	defer a.m.Unlock()
+	key := al.Key()
+	if last, ok := a.last[key]; ok && al.Time.Sub(last) < a.cooldown {
+		return false
+	}
+	if len(a.last) >= alertPruneSize {
+		for k, t := range a.last {
+			if al.Time.Sub(t) >= a.cooldown {
+				delete(a.last, k)
+			}
+		}
+	}
+	a.last[key] = al.Time
+	return true
+}
+
+func (a *Alerter) post(al *alert.Alert, log log15.Logger) {
+	sinks := a.sinks
+	if al.PrincipalID != 0 {
+		principalSinks, err := a.principalSinks(al.PrincipalID)
+		if err != nil {
+			log.Error("error retrieving alert sinks of principal", log15.Ctx{"err": err})
+		}
+		sinks = append(principalSinks, sinks...)
+	}
+	for _, s := range sinks {
+		if al.Severity < s.severity {
+			continue
+		}
+		if err := s.sink.Send(al); err != nil {
+			log.Error("error posting alert", log15.Ctx{"err": err})
+		}
+	}
+}
+
+// principalSinks returns the sinks configured in the metadata of the principal
+func (a *Alerter) principalSinks(principalID int64) ([]alertSink, error) {
+	md, err := metadata.MetadataByPrimaryDB(a.ctx.PrincipalDB(ReadOnly), principalMetadataModel{}, principalID)
+	if err != nil {
+		return nil, err
+	}
+	values := md.Values()
+	var sinks []alertSink
+	for typ, key := range map[string]string{
+		alert.SinkTypeSlack: alert.MetadataKeySlackURL,
+		alert.SinkTypeTeams: alert.MetadataKeyTeamsURL,
+	} {
+		if values[key] == "" {
+			continue
+		}
+		s, err := a.newSink(typ, values[key], values[alert.MetadataKeySeverity])
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
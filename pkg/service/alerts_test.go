@@ -0,0 +1,78 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/service/alert"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestAlerter(t *testing.T) {
+	Convey("Given a sink for critical alerts", t, func() {
+		posted := make(chan struct{}, 4)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			posted <- struct{}{}
+		}))
+		defer srv.Close()
+
+		cfg := config.DefaultConfig()
+		cfg.Alerts.Sinks = []config.AlertSink{
+			{Type: alert.SinkTypeSlack, URL: srv.URL, Severity: "critical"},
+		}
+		ctx, err := NewContext(context.Background(), cfg, log15.New())
+		So(err, ShouldBeNil)
+		a := ctx.Alerts()
+
+		Convey("When raising a critical alert", func() {
+			a.Alert(&alert.Alert{Kind: alert.KindDeclineSpike, Severity: alert.SeverityCritical, ProjectID: 1})
+
+			Convey("It should be posted", func() {
+				select {
+				case <-posted:
+				case <-time.After(time.Second):
+					t.Error("alert not posted")
+				}
+			})
+
+			Convey("Repeating it within the cooldown should be dropped", func() {
+				<-posted
+				a.Alert(&alert.Alert{Kind: alert.KindDeclineSpike, Severity: alert.SeverityCritical, ProjectID: 1})
+				a.Alert(&alert.Alert{Kind: alert.KindDeclineSpike, Severity: alert.SeverityCritical, ProjectID: 2})
+				<-posted
+				select {
+				case <-posted:
+					t.Error("alert within cooldown posted")
+				case <-time.After(100 * time.Millisecond):
+				}
+			})
+		})
+
+		Convey("When raising a warning", func() {
+			a.Alert(&alert.Alert{Kind: alert.KindReconciliationMismatch, Severity: alert.SeverityWarning})
+
+			Convey("It should not be posted to the sink", func() {
+				select {
+				case <-posted:
+					t.Error("warning posted to critical sink")
+				case <-time.After(100 * time.Millisecond):
+				}
+			})
+		})
+	})
+
+	Convey("Given an invalid sink config", t, func() {
+		cfg := config.DefaultConfig()
+		cfg.Alerts.Sinks = []config.AlertSink{{Type: alert.SinkTypeTeams, Severity: "urgent", URL: "http://localhost"}}
+
+		Convey("Creating the context should fail", func() {
+			_, err := NewContext(context.Background(), cfg, log15.New())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -74,6 +74,11 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}()
 	service.SetRequestContext(r, h.ctx)
 	defer service.ClearRequestContext(r)
+	// work on behalf of clients which already gave up will be abandoned
+	if deadline, ok := service.RequestDeadline(r, h.timeout, time.Now()); ok {
+		cancel := service.SetRequestDeadline(r, deadline)
+		defer cancel()
+	}
 	h.mux.ServeHTTP(w, r)
 	// service.TimeoutHandler(h.log.Warn, h.timeout, h.mux).ServeHTTP(w, r)
 }
@@ -3,7 +3,13 @@ package v1
 import (
 	"time"
 
+	"github.com/fritzpay/paymentd/pkg/oidc"
+	"github.com/fritzpay/paymentd/pkg/paymentd/user"
 	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/archive"
+	"github.com/fritzpay/paymentd/pkg/service/doctor"
+	"github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
@@ -17,6 +23,14 @@ const (
 	AuthLifetime = 15 * time.Minute
 	// AuthUserIDKey is the key for the user ID entry in the authorization container
 	AuthUserIDKey = "userID"
+	// AuthSessionKey is the key for the session ID entry in the authorization container
+	//
+	// It will be present when a user authorized with a password.
+	AuthSessionKey = "session"
+	// AuthAPIKeyKey is the key for the API key ID entry in the authorization container
+	//
+	// It will be present when a user authorized with an API key.
+	AuthAPIKeyKey = "apiKey"
 	// AuthCookieName is the cookie name for cookie-based authentication
 	AuthCookieName = "auth"
 )
@@ -25,6 +39,15 @@ const (
 type AdminAPI struct {
 	ctx *service.Context
 	log log15.Logger
+
+	paymentService  *payment.Service
+	providerService *provider.Service
+	// archiver is nil if archiving is not configured
+	archiver *archive.Archiver
+	doctor   *doctor.Doctor
+
+	oidc           *oidc.Provider
+	oidcGroupRoles map[string]user.Grants
 }
 
 // type used for formated AdminAPI Responses
@@ -33,13 +56,31 @@ type AdminAPIResponse struct {
 }
 
 // NewAPI creates a new admin API
-func NewAdminAPI(ctx *service.Context) *AdminAPI {
+func NewAdminAPI(ctx *service.Context, paymentService *payment.Service) (*AdminAPI, error) {
 	a := &AdminAPI{
 		ctx: ctx,
 		log: ctx.Log().New(log15.Ctx{
 			"pkg": "github.com/fritzpay/paymentd/pkg/service/api/v1",
 			"API": "AdminAPI",
 		}),
+		paymentService: paymentService,
+	}
+	var err error
+	a.providerService, err = provider.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a.archiver, err = archive.NewArchiver(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a.doctor, err = doctor.NewDoctor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = a.initOIDC()
+	if err != nil {
+		return nil, err
 	}
-	return a
+	return a, nil
 }
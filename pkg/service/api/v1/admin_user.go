@@ -0,0 +1,550 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/user"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// AdminUser is the admin API representation of an admin user
+type AdminUser struct {
+	Name        string
+	Active      bool
+	Roles       user.Grants
+	HasPassword bool
+	Created     time.Time
+	CreatedBy   string
+	Changed     time.Time
+	ChangedBy   string
+}
+
+func adminUser(u *user.User) AdminUser {
+	return AdminUser{
+		Name:        u.Name,
+		Active:      u.Config.Active,
+		Roles:       u.Config.Roles,
+		HasPassword: u.HasPassword(),
+		Created:     u.Created,
+		CreatedBy:   u.CreatedBy,
+		Changed:     u.Config.Timestamp,
+		ChangedBy:   u.Config.CreatedBy,
+	}
+}
+
+// AdminAPIKey is the admin API representation of an API key
+type AdminAPIKey struct {
+	ID        string
+	Name      string
+	Active    bool
+	Changed   time.Time
+	CreatedBy string
+	// Token is only present when the key was created
+	Token string `json:",omitempty"`
+}
+
+func adminAPIKey(k *user.APIKey) AdminAPIKey {
+	return AdminAPIKey{
+		ID:        k.ID,
+		Name:      k.Name,
+		Active:    k.Active,
+		Changed:   k.Timestamp,
+		CreatedBy: k.CreatedBy,
+		Token:     k.Token(),
+	}
+}
+
+// UserCreateRequest is the request body for creating admin users
+type UserCreateRequest struct {
+	Name     string
+	Password string
+	Roles    user.Grants
+}
+
+// UserChangeRequest is the request body for changing admin users
+//
+// Fields which are not present will not be changed.
+type UserChangeRequest struct {
+	Password *string
+	Active   *bool
+	Roles    *user.Grants
+}
+
+// APIKeyCreateRequest is the request body for issuing API keys
+type APIKeyCreateRequest struct {
+	Name string
+}
+
+// UsersRequest returns a handler which lists or creates admin users
+func (a *AdminAPI) UsersRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "UsersRequest"})
+		switch r.Method {
+		case "GET":
+			a.getUsers(w, r, log)
+		case "PUT":
+			a.putNewUser(w, r, log)
+		default:
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) getUsers(w http.ResponseWriter, r *http.Request, log log15.Logger) {
+	list, err := user.UsersDB(a.ctx.PrincipalDB(service.ReadOnly))
+	if err != nil {
+		log.Error("error retrieving users", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	users := make([]AdminUser, len(list))
+	for i, u := range list {
+		users[i] = adminUser(u)
+	}
+	resp := AdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = strconv.Itoa(len(users)) + " users found"
+	resp.Response = users
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("write error", log15.Ctx{"err": err})
+	}
+}
+
+func (a *AdminAPI) putNewUser(w http.ResponseWriter, r *http.Request, log log15.Logger) {
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("error getting auth container", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return
+	}
+	req := UserCreateRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Error("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return
+	}
+	u := &user.User{
+		Name:      req.Name,
+		CreatedBy: auth[AuthUserIDKey].(string),
+	}
+	u.Config.Active = true
+	u.Config.Roles = req.Roles
+	if !u.Valid() || u.Name == systemUserID {
+		resp := ErrInval
+		resp.Info = "invalid user name"
+		resp.Write(w)
+		return
+	}
+	if req.Password != "" {
+		err = u.SetPassword([]byte(req.Password))
+		if err != nil {
+			if err == user.ErrInvalidPassword {
+				resp := ErrInval
+				resp.Info = "invalid password"
+				resp.Write(w)
+				return
+			}
+			log.Error("error setting password", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+	}
+
+	var tx *sql.Tx
+	var commit bool
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = a.ctx.PrincipalDB().Begin()
+	if err != nil {
+		commit = true
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	_, err = user.UserByNameTx(tx, u.Name)
+	if err != user.ErrUserNotFound {
+		if err != nil {
+			log.Error("error retrieving user", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		ErrConflict.Write(w)
+		return
+	}
+	err = user.InsertUserTx(tx, u)
+	if err != nil {
+		log.Error("error saving user", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+
+	resp := AdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = "user " + u.Name + " created"
+	resp.Response = adminUser(u)
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("write error", log15.Ctx{"err": err})
+	}
+}
+
+// UserRequest returns a handler which displays or changes an admin user
+//
+// Deactivating a user will end all sessions of the user.
+func (a *AdminAPI) UserRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "UserRequest"})
+		switch r.Method {
+		case "GET":
+			u, ok := a.requestUser(w, r, a.ctx.PrincipalDB(service.ReadOnly), log)
+			if !ok {
+				return
+			}
+			resp := AdminAPIResponse{}
+			resp.Status = StatusSuccess
+			resp.Info = "user " + u.Name + " found"
+			resp.Response = adminUser(u)
+			err := resp.Write(w)
+			if err != nil {
+				log.Error("write error", log15.Ctx{"err": err})
+			}
+		case "POST":
+			a.postChangeUser(w, r, log)
+		default:
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// requestUser retrieves the user in the route variable "username"
+//
+// If the user could not be retrieved, an error response will be written and ok
+// will be false.
+func (a *AdminAPI) requestUser(w http.ResponseWriter, r *http.Request, db *sql.DB, log log15.Logger) (u *user.User, ok bool) {
+	name := mux.Vars(r)["username"]
+	u, err := user.UserByNameDB(db, name)
+	if err != nil {
+		if err == user.ErrUserNotFound {
+			resp := ErrNotFound
+			resp.Info = "user " + name + " not found"
+			resp.Write(w)
+			return nil, false
+		}
+		log.Error("error retrieving user", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return nil, false
+	}
+	return u, true
+}
+
+func (a *AdminAPI) postChangeUser(w http.ResponseWriter, r *http.Request, log log15.Logger) {
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("error getting auth container", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return
+	}
+	req := UserChangeRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Error("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return
+	}
+
+	var tx *sql.Tx
+	var commit bool
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = a.ctx.PrincipalDB().Begin()
+	if err != nil {
+		commit = true
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	name := mux.Vars(r)["username"]
+	u, err := user.UserByNameTx(tx, name)
+	if err != nil {
+		if err == user.ErrUserNotFound {
+			resp := ErrNotFound
+			resp.Info = "user " + name + " not found"
+			resp.Write(w)
+			return
+		}
+		log.Error("error retrieving user", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	if req.Password != nil {
+		err = u.SetPassword([]byte(*req.Password))
+		if err != nil {
+			if err == user.ErrInvalidPassword {
+				resp := ErrInval
+				resp.Info = "invalid password"
+				resp.Write(w)
+				return
+			}
+			log.Error("error setting password", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+	}
+	if req.Active != nil {
+		u.Config.Active = *req.Active
+	}
+	if req.Roles != nil {
+		u.Config.Roles = *req.Roles
+	}
+	u.Config.CreatedBy = auth[AuthUserIDKey].(string)
+	err = user.InsertConfigTx(tx, u)
+	if err != nil {
+		log.Error("error saving user config", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	if !u.Config.Active {
+		err = user.DeleteSessionsByUserDB(a.ctx.PrincipalDB(), u)
+		if err != nil {
+			log.Error("error deleting sessions of inactive user", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+	}
+
+	resp := AdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = "user " + u.Name + " changed"
+	resp.Response = adminUser(u)
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("write error", log15.Ctx{"err": err})
+	}
+}
+
+// UserSessionRequest returns a handler which ends all sessions of an admin user
+func (a *AdminAPI) UserSessionRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "UserSessionRequest"})
+		if r.Method != "DELETE" {
+			ErrMethod.Write(w)
+			return
+		}
+		db := a.ctx.PrincipalDB()
+		u, ok := a.requestUser(w, r, db, log)
+		if !ok {
+			return
+		}
+		err := user.DeleteSessionsByUserDB(db, u)
+		if err != nil {
+			log.Error("error deleting sessions", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "sessions of user " + u.Name + " ended"
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// UserKeyRequest returns a handler which lists or issues API keys of an admin user
+//
+// The token of a new API key will only be present in the response to the PUT
+// request.
+func (a *AdminAPI) UserKeyRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "UserKeyRequest"})
+		switch r.Method {
+		case "GET":
+			a.getUserKeys(w, r, log)
+		case "PUT":
+			a.putNewUserKey(w, r, log)
+		default:
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) getUserKeys(w http.ResponseWriter, r *http.Request, log log15.Logger) {
+	db := a.ctx.PrincipalDB(service.ReadOnly)
+	u, ok := a.requestUser(w, r, db, log)
+	if !ok {
+		return
+	}
+	list, err := user.APIKeysByUserDB(db, u)
+	if err != nil {
+		log.Error("error retrieving api keys", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	keys := make([]AdminAPIKey, len(list))
+	for i, k := range list {
+		keys[i] = adminAPIKey(k)
+	}
+	resp := AdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = strconv.Itoa(len(keys)) + " api keys found"
+	resp.Response = keys
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("write error", log15.Ctx{"err": err})
+	}
+}
+
+func (a *AdminAPI) putNewUserKey(w http.ResponseWriter, r *http.Request, log log15.Logger) {
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("error getting auth container", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return
+	}
+	req := APIKeyCreateRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Error("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return
+	}
+	if req.Name == "" || len(req.Name) > 64 {
+		resp := ErrInval
+		resp.Info = "invalid api key name"
+		resp.Write(w)
+		return
+	}
+	db := a.ctx.PrincipalDB()
+	u, ok := a.requestUser(w, r, db, log)
+	if !ok {
+		return
+	}
+	k, err := user.NewAPIKey(u, req.Name, auth[AuthUserIDKey].(string))
+	if err != nil {
+		log.Error("error creating api key", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return
+	}
+	err = user.InsertAPIKeyDB(db, k)
+	if err != nil {
+		log.Error("error saving api key", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	resp := AdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = "api key " + k.ID + " issued to user " + u.Name
+	resp.Response = adminAPIKey(k)
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("write error", log15.Ctx{"err": err})
+	}
+}
+
+// UserKeyRevokeRequest returns a handler which revokes an API key of an admin user
+func (a *AdminAPI) UserKeyRevokeRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "UserKeyRevokeRequest"})
+		if r.Method != "DELETE" {
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		db := a.ctx.PrincipalDB()
+		u, ok := a.requestUser(w, r, db, log)
+		if !ok {
+			return
+		}
+		k, err := user.APIKeyByIDDB(db, mux.Vars(r)["keyid"])
+		if err != nil && err != user.ErrAPIKeyNotFound {
+			log.Error("error retrieving api key", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if err == user.ErrAPIKeyNotFound || k.UserID != u.ID {
+			resp := ErrNotFound
+			resp.Info = "api key not found"
+			resp.Write(w)
+			return
+		}
+		k.Timestamp = time.Now()
+		k.Active = false
+		k.CreatedBy = auth[AuthUserIDKey].(string)
+		err = user.InsertAPIKeyDB(db, k)
+		if err != nil {
+			log.Error("error saving api key", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "api key " + k.ID + " revoked"
+		resp.Response = adminAPIKey(k)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
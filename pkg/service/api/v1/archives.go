@@ -0,0 +1,136 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/service/archive"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ArchiveRequest returns a handler which verifies the compliance archive of a
+// day and responds with its manifest
+func (a *AdminAPI) ArchiveRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ArchiveRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		day, ok := a.archiveDay(w, r)
+		if !ok {
+			return
+		}
+		v, err := a.archiver.Verify(day)
+		if err != nil {
+			if err == archive.ErrNotFound {
+				resp := ErrNotFound
+				resp.Info = "archive not found"
+				resp.Write(w)
+				return
+			}
+			log.Error("error verifying archive", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		if !v.Valid {
+			log.Warn("archive verification failed", log15.Ctx{
+				"day":    v.Manifest.Day,
+				"errors": v.Errors,
+			})
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		if v.Valid {
+			resp.Info = "archive verified"
+		} else {
+			resp.Info = strconv.Itoa(len(v.Errors)) + " verification errors"
+		}
+		resp.Response = v
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ArchiveFileRequest returns a handler which retrieves an archived file
+//
+// The file will only be returned if it matches the manifest of the archive.
+func (a *AdminAPI) ArchiveFileRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := a.log.New(log15.Ctx{"method": "ArchiveFileRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			ErrMethod.Write(w)
+			return
+		}
+		day, ok := a.archiveDay(w, r)
+		if !ok {
+			return
+		}
+		name := mux.Vars(r)["file"]
+		data, err := a.archiver.File(day, name)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			switch err {
+			case archive.ErrNotFound, archive.ErrFileNotArchived:
+				resp := ErrNotFound
+				resp.Info = "archived file not found"
+				resp.Write(w)
+			case archive.ErrFileCorrupt:
+				log.Crit("archived file does not match manifest", log15.Ctx{
+					"day":  day.Format(archive.DayFormat),
+					"file": name,
+				})
+				resp := ErrConflict
+				resp.Info = err.Error()
+				resp.Write(w)
+			default:
+				log.Error("error retrieving archived file", log15.Ctx{"err": err})
+				ErrSystem.Write(w)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, err = w.Write(data)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// archiveDay reads the requested archive day
+//
+// It writes an error response and returns false if archiving is not configured
+// or the day is invalid.
+func (a *AdminAPI) archiveDay(w http.ResponseWriter, r *http.Request) (t time.Time, ok bool) {
+	if a.archiver == nil {
+		w.Header().Set("Content-Type", "application/json")
+		resp := ErrNotFound
+		resp.Info = "archiving is not configured"
+		resp.Write(w)
+		return
+	}
+	t, err := archive.ParseDay(mux.Vars(r)["day"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		resp := ErrReadParam
+		resp.Info = "invalid day"
+		resp.Write(w)
+		return
+	}
+	return t, true
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"hash"
 	"io/ioutil"
 	"net/http"
@@ -15,6 +16,7 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/user"
 	"github.com/fritzpay/paymentd/pkg/service"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/inconshreveable/log15.v2"
@@ -22,12 +24,19 @@ import (
 
 const badAuthWaitTime = 2 * time.Second
 
+// apiKeyAuthScheme is the scheme of Authorization headers carrying an API key
+const apiKeyAuthScheme = "ApiKey"
+
 func (a *AdminAPI) authorizationHash() func() hash.Hash {
 	return sha256.New
 }
 
-func (a *AdminAPI) authenticateSystemPassword(pw string, w http.ResponseWriter) {
+func (a *AdminAPI) authenticateSystemPassword(pw string, w http.ResponseWriter, r *http.Request) {
 	log := a.log.New(log15.Ctx{"method": "authenticateSystemPassword"})
+	subjects := []string{"user:" + systemUserID, clientSubject(r)}
+	if lockedOut(a.ctx, w, log, subjects...) {
+		return
+	}
 	pwEntry, err := config.EntryByNameDB(a.ctx.PaymentDB(), config.ConfigNameSystemPassword)
 	if err != nil {
 		if err == config.ErrEntryNotFound {
@@ -42,6 +51,7 @@ func (a *AdminAPI) authenticateSystemPassword(pw string, w http.ResponseWriter)
 	err = bcrypt.CompareHashAndPassword([]byte(pwEntry.Value), []byte(pw))
 	if err != nil {
 		if err == bcrypt.ErrMismatchedHashAndPassword {
+			authFailed(a.ctx, log, subjects...)
 			time.Sleep(badAuthWaitTime)
 			w.WriteHeader(http.StatusUnauthorized)
 			return
@@ -50,7 +60,75 @@ func (a *AdminAPI) authenticateSystemPassword(pw string, w http.ResponseWriter)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	a.respondWithAuthorization(w)
+	authSucceeded(a.ctx, log, subjects...)
+	a.respondWithAuthorization(w, systemUserID, nil)
+}
+
+// UserCredentials are the credentials of an admin user
+type UserCredentials struct {
+	Name     string
+	Password string
+}
+
+func (a *AdminAPI) authenticateUserAuth(w http.ResponseWriter, r *http.Request) {
+	log := a.log.New(log15.Ctx{"method": "authenticateUserAuth"})
+	creds := UserCredentials{}
+	err := json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		if Debug {
+			log.Debug("error decoding credentials", log15.Ctx{"err": err})
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if creds.Name == systemUserID {
+		a.authenticateSystemPassword(creds.Password, w, r)
+		return
+	}
+	subjects := []string{"user:" + creds.Name, clientSubject(r)}
+	if lockedOut(a.ctx, w, log, subjects...) {
+		return
+	}
+	db := a.ctx.PrincipalDB()
+	u, err := user.UserByNameDB(db, creds.Name)
+	if err != nil && err != user.ErrUserNotFound {
+		log.Error("error retrieving user", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err == user.ErrUserNotFound || !u.Config.Active {
+		authFailed(a.ctx, log, subjects...)
+		time.Sleep(badAuthWaitTime)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	err = u.CheckPassword([]byte(creds.Password))
+	if err != nil {
+		if err == user.ErrInvalidPassword {
+			authFailed(a.ctx, log, subjects...)
+			time.Sleep(badAuthWaitTime)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		log.Error("error checking password", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	authSucceeded(a.ctx, log, subjects...)
+	sess, err := user.NewSession(u, AuthLifetime)
+	if err != nil {
+		log.Error("error creating session", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sess.RemoteAddr = r.RemoteAddr
+	err = user.InsertSessionDB(db, sess)
+	if err != nil {
+		log.Error("error saving session", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.respondWithAuthorization(w, u.Name, sess)
 }
 
 // GetCredentialsResponse is the response for all GET /user/credentials requests
@@ -59,21 +137,16 @@ type GetCredentialsResponse struct {
 	Authorization string
 }
 
-func (a *AdminAPI) respondWithAuthorization(w http.ResponseWriter) {
+// respondWithAuthorization writes a new authorization for the given user
+//
+// If a session is given, the authorization will be tied to the session and
+// expire with it.
+func (a *AdminAPI) respondWithAuthorization(w http.ResponseWriter, userID string, sess *user.Session) {
 	log := a.log.New(log15.Ctx{"method": "respondWithAuthorization"})
 
-	auth := service.NewAuthorization(a.authorizationHash())
-	auth.Payload[AuthUserIDKey] = systemUserID
-	auth.Expires(time.Now().Add(AuthLifetime))
-	key, err := a.ctx.APIKeychain().BinKey()
-	if err != nil {
-		log.Error("error retrieving key from keychain", log15.Ctx{"err": err})
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	err = auth.Encode(key)
+	auth, err := a.newAuthorization(userID, sess)
 	if err != nil {
-		log.Error("error encoding authorization", log15.Ctx{"err": err})
+		log.Error("error creating authorization", log15.Ctx{"err": err})
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -90,23 +163,49 @@ func (a *AdminAPI) respondWithAuthorization(w http.ResponseWriter) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if a.ctx.Config().API.Cookie.AllowCookieAuth {
-		c := &http.Cookie{
-			Name:     AuthCookieName,
-			Value:    resp.Authorization,
-			Path:     ServicePath,
-			Expires:  auth.Expiry(),
-			HttpOnly: a.ctx.Config().API.Cookie.HTTPOnly,
-			Secure:   a.ctx.Config().API.Secure,
-		}
-		http.SetCookie(w, c)
-	}
+	a.setAuthorizationCookie(w, resp.Authorization, auth.Expiry())
 	_, err = w.Write(jsonResp)
 	if err != nil {
 		log.Error("error writing HTTP response", log15.Ctx{"err": err})
 	}
 }
 
+// newAuthorization returns a new encoded authorization for the given user
+func (a *AdminAPI) newAuthorization(userID string, sess *user.Session) (*service.Authorization, error) {
+	auth := service.NewAuthorization(a.authorizationHash())
+	auth.Payload[AuthUserIDKey] = userID
+	if sess != nil {
+		auth.Payload[AuthSessionKey] = sess.ID
+		auth.Expires(sess.Expires)
+	} else {
+		auth.Expires(time.Now().Add(AuthLifetime))
+	}
+	key, err := a.ctx.APIKeychain().BinKey()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving key from keychain: %v", err)
+	}
+	err = auth.Encode(key)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding authorization: %v", err)
+	}
+	return auth, nil
+}
+
+func (a *AdminAPI) setAuthorizationCookie(w http.ResponseWriter, authorization string, expiry time.Time) {
+	if !a.ctx.Config().API.Cookie.AllowCookieAuth {
+		return
+	}
+	c := &http.Cookie{
+		Name:     AuthCookieName,
+		Value:    authorization,
+		Path:     ServicePath,
+		Expires:  expiry,
+		HttpOnly: a.ctx.Config().API.Cookie.HTTPOnly,
+		Secure:   a.ctx.Config().API.Secure,
+	}
+	http.SetCookie(w, c)
+}
+
 // AuthorizationHandler implements /authorization requests
 func (a *AdminAPI) AuthorizationHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -120,7 +219,7 @@ func (a *AdminAPI) AuthorizationHandler() http.Handler {
 			return
 
 		case "DELETE":
-			a.AuthRequiredHandler(http.HandlerFunc(a.resetCookie)).ServeHTTP(w, r)
+			a.AuthRequiredHandler(http.HandlerFunc(a.revokeAuthorization)).ServeHTTP(w, r)
 			return
 
 		default:
@@ -142,6 +241,9 @@ func (a *AdminAPI) AuthorizeHandler() http.Handler {
 			case "basic":
 				a.authenticateBasicAuth(w, r)
 				return
+			case "oidc":
+				a.authenticateOIDC(w, r)
+				return
 			default:
 				w.WriteHeader(http.StatusNotFound)
 				return
@@ -153,6 +255,9 @@ func (a *AdminAPI) AuthorizeHandler() http.Handler {
 			case "text":
 				a.authenticateBodyAuth(w, r)
 				return
+			case "user":
+				a.authenticateUserAuth(w, r)
+				return
 			default:
 				w.WriteHeader(http.StatusNotFound)
 				return
@@ -198,7 +303,7 @@ func (a *AdminAPI) authenticateBasicAuth(w http.ResponseWriter, r *http.Request)
 		requestBasicAuth(w)
 		return
 	} else {
-		a.authenticateSystemPassword(pw, w)
+		a.authenticateSystemPassword(pw, w, r)
 	}
 }
 
@@ -213,7 +318,7 @@ func (a *AdminAPI) authenticateBodyAuth(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	a.authenticateSystemPassword(string(b), w)
+	a.authenticateSystemPassword(string(b), w, r)
 }
 
 func requestBasicAuth(w http.ResponseWriter) {
@@ -247,10 +352,66 @@ func (a *AdminAPI) updateSystemUserPasswordHandler() http.Handler {
 
 func (a *AdminAPI) refreshAuthorizationHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		a.respondWithAuthorization(w)
+		log := a.log.New(log15.Ctx{"method": "refreshAuthorizationHandler"})
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("auth container error", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		userID, _ := auth[AuthUserIDKey].(string)
+		if userID == systemUserID {
+			a.respondWithAuthorization(w, systemUserID, nil)
+			return
+		}
+		// API key authorizations can not be exchanged for a session
+		sessionID, ok := auth[AuthSessionKey].(string)
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		db := a.ctx.PrincipalDB()
+		sess, err := user.SessionByIDDB(db, sessionID)
+		if err != nil {
+			if err == user.ErrSessionNotFound {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			log.Error("error retrieving session", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sess.Expires = time.Now().Add(AuthLifetime)
+		err = user.UpdateSessionExpiresDB(db, sess)
+		if err != nil {
+			log.Error("error updating session", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		a.respondWithAuthorization(w, userID, sess)
 	})
 }
 
+// revokeAuthorization ends the current session
+func (a *AdminAPI) revokeAuthorization(w http.ResponseWriter, r *http.Request) {
+	log := a.log.New(log15.Ctx{"method": "revokeAuthorization"})
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("auth container error", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if sessionID, ok := auth[AuthSessionKey].(string); ok {
+		err = user.DeleteSessionDB(a.ctx.PrincipalDB(), sessionID)
+		if err != nil {
+			log.Error("error deleting session", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	a.resetCookie(w, r)
+}
+
 // AuthRequiredHandler wraps the given handler with an authorization method using the
 // Authorization Header and the authorization container
 //
@@ -271,6 +432,35 @@ func (a *AdminAPI) AuthHandler(success, failed http.Handler) http.Handler {
 		log := a.log.New(log15.Ctx{"method": "AuthHandler"})
 
 		authStr := r.Header.Get("Authorization")
+		if strings.HasPrefix(authStr, apiKeyAuthScheme+" ") {
+			token := strings.TrimPrefix(authStr, apiKeyAuthScheme+" ")
+			subjects := []string{clientSubject(r)}
+			if keyID, _, err := user.ParseAPIKeyToken(token); err == nil {
+				subjects = append(subjects, "apikey:"+keyID)
+			}
+			if lockedOut(a.ctx, w, log, subjects...) {
+				return
+			}
+			payload, err := a.authenticateAPIKey(token)
+			if err != nil {
+				if err != user.ErrInvalidAPIKey {
+					log.Error("error authenticating api key", log15.Ctx{"err": err})
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				if Debug {
+					log.Debug("invalid api key")
+				}
+				authFailed(a.ctx, log, subjects...)
+				time.Sleep(badAuthWaitTime)
+				failed.ServeHTTP(w, r)
+				return
+			}
+			authSucceeded(a.ctx, log, subjects...)
+			service.SetRequestContextVar(r, service.ContextVarAuthKey, payload)
+			success.ServeHTTP(w, r)
+			return
+		}
 		if authStr == "" {
 			if !a.ctx.Config().API.Cookie.AllowCookieAuth {
 				if Debug {
@@ -331,6 +521,30 @@ func (a *AdminAPI) AuthHandler(success, failed http.Handler) http.Handler {
 			failed.ServeHTTP(w, r)
 			return
 		}
+		// authorizations of users are tied to a session, which can be revoked
+		if sessionID, ok := auth.Payload[AuthSessionKey].(string); ok {
+			sess, err := user.SessionByIDDB(a.ctx.PrincipalDB(service.ReadOnly), sessionID)
+			if err != nil && err != user.ErrSessionNotFound {
+				log.Error("error retrieving session", log15.Ctx{"err": err})
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if err == user.ErrSessionNotFound || !sess.Valid() {
+				if Debug {
+					log.Debug("session invalid", log15.Ctx{"session": sessionID})
+				}
+				a.resetCookie(w, r)
+				failed.ServeHTTP(w, r)
+				return
+			}
+		} else if auth.Payload[AuthUserIDKey] != systemUserID {
+			if Debug {
+				log.Debug("user authorization without session")
+			}
+			a.resetCookie(w, r)
+			failed.ServeHTTP(w, r)
+			return
+		}
 		// store auth container in request context
 		service.SetRequestContextVar(r, service.ContextVarAuthKey, auth.Payload)
 
@@ -338,6 +552,53 @@ func (a *AdminAPI) AuthHandler(success, failed http.Handler) http.Handler {
 	})
 }
 
+// authenticateAPIKey returns the authorization container for the given API key
+// token
+//
+// It will return a user.ErrInvalidAPIKey if the token does not authenticate an
+// active user.
+func (a *AdminAPI) authenticateAPIKey(token string) (map[string]interface{}, error) {
+	u, k, err := apiKeyUser(a.ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		AuthUserIDKey: u.Name,
+		AuthAPIKeyKey: k.ID,
+	}, nil
+}
+
+// apiKeyUser returns the active user and the API key authenticated by the given
+// API key token
+//
+// It will return a user.ErrInvalidAPIKey if the token does not authenticate an
+// active user.
+func apiKeyUser(ctx *service.Context, token string) (*user.User, *user.APIKey, error) {
+	keyID, secret, err := user.ParseAPIKeyToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	db := ctx.PrincipalDB(service.ReadOnly)
+	k, err := user.APIKeyByIDDB(db, keyID)
+	if err != nil {
+		if err == user.ErrAPIKeyNotFound {
+			return nil, nil, user.ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	if !k.Active || !k.CheckSecret(secret) {
+		return nil, nil, user.ErrInvalidAPIKey
+	}
+	u, err := user.UserByIDDB(db, k.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !u.Config.Active {
+		return nil, nil, user.ErrInvalidAPIKey
+	}
+	return u, k, nil
+}
+
 func (a *AdminAPI) resetCookie(w http.ResponseWriter, r *http.Request) {
 	if !a.ctx.Config().API.Cookie.AllowCookieAuth {
 		return
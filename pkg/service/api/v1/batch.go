@@ -0,0 +1,248 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	jsonutil "github.com/fritzpay/paymentd/pkg/json"
+	"github.com/fritzpay/paymentd/pkg/paymentd/batch"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// BatchRequest is the request body for applying an intent to many payments
+//
+// The payments are either given by their display payment IDs or selected by
+// project and current status.
+type BatchRequest struct {
+	Intent  string
+	Comment string
+
+	PaymentIDs []string
+	ProjectID  int64
+	Status     string
+}
+
+// BatchResult is the admin API representation of the result of a payment in a
+// batch
+type BatchResult struct {
+	// display payment ID
+	PaymentID string
+	Status    string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// Batch is the admin API representation of a batch
+type Batch struct {
+	ID        int64
+	Intent    string
+	Comment   string `json:",omitempty"`
+	CreatedBy string
+	Created   time.Time
+	// Finished is omitted while the batch is running
+	Finished  *time.Time `json:",omitempty"`
+	Total     int
+	Processed int
+	Failed    int
+	Results   []BatchResult `json:",omitempty"`
+}
+
+func (a *AdminAPI) batch(b *batch.Batch, results []*batch.Result) Batch {
+	out := Batch{
+		ID:        b.ID,
+		Intent:    b.Intent,
+		Comment:   b.Comment,
+		CreatedBy: b.CreatedBy,
+		Created:   b.Created,
+		Total:     b.Total,
+		Processed: b.Processed,
+		Failed:    b.Failed,
+	}
+	if !b.Running() {
+		out.Finished = &b.Finished
+	}
+	if results == nil {
+		return out
+	}
+	out.Results = make([]BatchResult, len(results))
+	for i, res := range results {
+		out.Results[i] = BatchResult{
+			PaymentID: a.paymentService.EncodedPaymentID(res.PaymentID).String(),
+			Status:    res.Status.String(),
+			Error:     res.Error,
+		}
+	}
+	return out
+}
+
+// BatchRequest returns a handler which starts applying an intent to many
+// payments at once, e.g. to cancel all open payments of a discontinued project
+//
+// The batch is executed in the background. Its progress and the results per
+// payment can be retrieved with the BatchGetRequest.
+func (a *AdminAPI) BatchRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "BatchRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("auth container error", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		req := BatchRequest{}
+		// reject oversized batches before reading them completely
+		err = jsonutil.DecodeLimited(r.Body, &req, paymentService.BatchMaxPayments)
+		r.Body.Close()
+		if err == jsonutil.ErrTooManyEntries {
+			resp := ErrTooLarge
+			resp.Info = "batch must contain between 1 and " + strconv.Itoa(paymentService.BatchMaxPayments) + " payments"
+			resp.Write(w)
+			return
+		}
+		if err != nil {
+			log.Warn("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		var ids []payment.PaymentID
+		switch {
+		case len(req.PaymentIDs) > 0 && req.ProjectID != 0:
+			resp := ErrInval
+			resp.Info = "either PaymentIDs or ProjectID and Status can be given"
+			resp.Write(w)
+			return
+		case len(req.PaymentIDs) > 0:
+			ids = make([]payment.PaymentID, len(req.PaymentIDs))
+			for i, idStr := range req.PaymentIDs {
+				id, err := payment.ParsePaymentIDStr(idStr)
+				if err != nil {
+					resp := ErrInval
+					resp.Info = "invalid payment id " + idStr
+					resp.Write(w)
+					return
+				}
+				ids[i] = a.paymentService.DecodedPaymentID(id)
+			}
+		case req.ProjectID != 0:
+			status := payment.PaymentTransactionStatus(req.Status)
+			if !status.Valid() {
+				resp := ErrInval
+				resp.Info = "invalid status " + req.Status
+				resp.Write(w)
+				return
+			}
+			// select one more to detect oversized batches
+			ids, err = payment.PaymentIDsByProjectIDAndStatusDB(ctx, a.ctx.ProjectPaymentDB(req.ProjectID, service.ReadOnly), req.ProjectID, status, paymentService.BatchMaxPayments+1)
+			if err != nil {
+				log.Error("error selecting payments", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+		default:
+			resp := ErrInval
+			resp.Info = "no payments selected"
+			resp.Write(w)
+			return
+		}
+
+		b, err := a.paymentService.StartBatch(req.Intent, ids, auth[AuthUserIDKey].(string), req.Comment)
+		if err != nil {
+			switch {
+			case errors.Is(err, paymentService.ErrBatchIntent):
+				resp := ErrInval
+				resp.Info = "invalid intent " + req.Intent
+				resp.Write(w)
+			case errors.Is(err, paymentService.ErrBatchSize):
+				resp := ErrInval
+				resp.Info = "batch must contain between 1 and " + strconv.Itoa(paymentService.BatchMaxPayments) + " payments"
+				resp.Write(w)
+			case errors.Is(err, paymentService.ErrDB):
+				log.Error("error starting batch", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+			default:
+				log.Error("error starting batch", log15.Ctx{"err": err})
+				ErrSystem.Write(w)
+			}
+			return
+		}
+		log.Info("batch started", log15.Ctx{
+			"batchID": b.ID,
+			"intent":  b.Intent,
+			"total":   b.Total,
+		})
+
+		resp := AdminAPIResponse{}
+		resp.HttpStatus = http.StatusAccepted
+		resp.Status = StatusSuccess
+		resp.Info = "batch started"
+		resp.Response = a.batch(b, nil)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// BatchGetRequest returns a handler which reports the progress and the results
+// per payment of a batch
+func (a *AdminAPI) BatchGetRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "BatchGetRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		id, err := strconv.ParseInt(mux.Vars(r)["batchid"], 10, 64)
+		if err != nil {
+			ErrReadParam.Write(w)
+			return
+		}
+		b, err := a.paymentService.Batch(id)
+		if err != nil {
+			if err == batch.ErrBatchNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving batch", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		results, err := a.paymentService.BatchResults(id)
+		if err != nil {
+			log.Error("error retrieving batch results", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "batch running"
+		if !b.Running() {
+			resp.Info = "batch finished"
+		}
+		resp.Response = a.batch(b, results)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -0,0 +1,55 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/bin"
+	"github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// BINRequest returns a handler for BIN lookups
+//
+// GET returns the issuer data of the BIN range of the requested BIN from the
+// latest BIN dataset
+func (a *AdminAPI) BINRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "BINRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		rng, err := a.paymentService.LookupBIN(mux.Vars(r)["bin"])
+		if err != nil {
+			switch {
+			case errors.Is(err, payment.ErrCardNumber):
+				resp := ErrReadParam
+				resp.Info = "invalid BIN"
+				resp.Write(w)
+			case errors.Is(err, bin.ErrRangeNotFound):
+				resp := ErrNotFound
+				resp.Info = "BIN range not found"
+				resp.Write(w)
+			default:
+				log.Error("error on BIN lookup", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+			}
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "BIN range " + rng.Prefix
+		resp.Response = rng
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -0,0 +1,31 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/fritzpay/paymentd/pkg/service"
+)
+
+// BodyLimitHandler wraps the given handler and limits the size of the request
+// bodies of the endpoint
+//
+// Requests announcing a larger body will be rejected before the body is read.
+// Reading beyond the limit will fail, so larger bodies without a content length
+// will be rejected by the handler when decoding.
+func BodyLimitHandler(ctx *service.Context, endpoint string, parent http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := ctx.BodyLimits().Size(r.Method + " " + endpoint)
+		if limit > 0 && r.Body != nil {
+			if r.ContentLength > limit {
+				w.Header().Set("Content-Type", "application/json")
+				resp := ErrTooLarge
+				resp.Info = "request body exceeds " + strconv.FormatInt(limit, 10) + " bytes"
+				resp.Write(w)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		parent.ServeHTTP(w, r)
+	})
+}
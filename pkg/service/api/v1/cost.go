@@ -0,0 +1,393 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/paymentd/fee"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// FeeScheduleRequest is the request body for setting the fee schedule of a
+// provider
+type FeeScheduleRequest struct {
+	Rules []fee.Rule
+}
+
+// ProjectFeeScheduleRequest returns a handler which reads and sets the fee
+// schedule of a provider for a project
+//
+// GET returns the current fee schedule.
+// PUT sets a new fee schedule.
+func (a *AdminAPI) ProjectFeeScheduleRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectFeeScheduleRequest"})
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		prov := mux.Vars(r)["provider"]
+		log = log.New(log15.Ctx{"projectID": projectID, "provider": prov})
+		if !a.providerExists(w, prov, log) {
+			return
+		}
+		var sched *fee.Schedule
+		var err error
+		switch r.Method {
+		case "GET":
+			sched, err = fee.ScheduleDB(a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, prov)
+			if err != nil {
+				if err == fee.ErrScheduleNotFound {
+					resp := ErrNotFound
+					resp.Info = "no fee schedule for provider " + prov
+					resp.Write(w)
+					return
+				}
+				log.Error("error retrieving fee schedule", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+		case "PUT":
+			sched, ok = a.putFeeSchedule(w, r, projectID, prov, log)
+			if !ok {
+				return
+			}
+		default:
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "fee schedule of provider " + prov
+		resp.Response = sched
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) putFeeSchedule(w http.ResponseWriter, r *http.Request, projectID int64, prov string, log log15.Logger) (*fee.Schedule, bool) {
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("error getting auth container", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return nil, false
+	}
+	req := FeeScheduleRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Error("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return nil, false
+	}
+	sched := &fee.Schedule{
+		ProjectID: projectID,
+		Provider:  prov,
+		Created:   time.Now(),
+		CreatedBy: auth[AuthUserIDKey].(string),
+		Rules:     req.Rules,
+	}
+	if sched.Rules == nil {
+		sched.Rules = []fee.Rule{}
+	}
+	if !sched.Valid() {
+		resp := ErrInval
+		resp.Info = "invalid fee rule"
+		resp.Write(w)
+		return nil, false
+	}
+	tx, err := a.ctx.ProjectPaymentDB(projectID).Begin()
+	if err != nil {
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return nil, false
+	}
+	err = fee.InsertScheduleTx(tx, sched)
+	if err != nil {
+		log.Error("error saving fee schedule", log15.Ctx{"err": err})
+		tx.Rollback()
+		ErrDatabase.Write(w)
+		return nil, false
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return nil, false
+	}
+	return sched, true
+}
+
+// SettlementEntry is the fee of a payment in a settlement
+type SettlementEntry struct {
+	PaymentId payment.PaymentID
+	Fee       string
+	Currency  string
+}
+
+// SettlementRequest is the request body for importing the fees of a provider
+// settlement
+type SettlementRequest struct {
+	Provider  string
+	Reference string
+	Entries   []SettlementEntry
+}
+
+// SettlementResponse is the response JSON struct of a settlement import
+type SettlementResponse struct {
+	Provider  string
+	Reference string
+	Entries   int
+}
+
+// ProjectSettlementRequest returns a handler which imports the fees of a
+// provider settlement
+//
+// The fees are recorded as the actual costs of the payments. All entries are
+// imported or none.
+func (a *AdminAPI) ProjectSettlementRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectSettlementRequest"})
+		if r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		req := SettlementRequest{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		if req.Provider == "" || req.Reference == "" || len(req.Entries) == 0 {
+			resp := ErrInval
+			resp.Info = "provider, reference and entries required"
+			resp.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID": projectID,
+			"provider":  req.Provider,
+			"reference": req.Reference,
+		})
+		if !a.providerExists(w, req.Provider, log) {
+			return
+		}
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.ProjectPaymentDB(projectID).Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		for i, e := range req.Entries {
+			info, err := a.importSettlementEntry(tx, projectID, &req, &e)
+			if err != nil {
+				log.Error("error importing settlement entry", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			if info != "" {
+				resp := ErrInval
+				resp.Info = "entry " + strconv.Itoa(i) + ": " + info
+				resp.Write(w)
+				return
+			}
+		}
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		log.Info("imported settlement", log15.Ctx{"entries": len(req.Entries)})
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "settlement imported"
+		resp.Response = SettlementResponse{
+			Provider:  req.Provider,
+			Reference: req.Reference,
+			Entries:   len(req.Entries),
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// importSettlementEntry records the fee of the entry as the actual cost of its
+// payment
+//
+// If the entry is invalid, the reason will be returned.
+func (a *AdminAPI) importSettlementEntry(tx *sql.Tx, projectID int64, req *SettlementRequest, e *SettlementEntry) (string, error) {
+	if e.PaymentId.ProjectID != projectID {
+		return "invalid payment id", nil
+	}
+	p, err := payment.PaymentByIDTx(a.ctx, tx, a.paymentService.DecodedPaymentID(e.PaymentId))
+	if err != nil {
+		if err == payment.ErrPaymentNotFound {
+			return "payment " + e.PaymentId.String() + " not found", nil
+		}
+		return "", err
+	}
+	if !p.Config.PaymentMethodID.Valid {
+		return "payment " + e.PaymentId.String() + " without payment method", nil
+	}
+	meth, err := a.paymentService.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return "", err
+	}
+	if meth.Provider.Name != req.Provider {
+		return "payment " + e.PaymentId.String() + " not processed by provider", nil
+	}
+	if e.Currency != p.Currency {
+		return "currency must be the payment currency " + p.Currency, nil
+	}
+	amount := &dec.Dec{}
+	if _, ok := amount.SetString(e.Fee); !ok || amount.Sign() < 0 {
+		return "invalid fee", nil
+	}
+	units := &dec.Dec{}
+	units.Round(amount, dec.Scale(p.Subunits), dec.RoundDown)
+	if units.Cmp(amount) != 0 {
+		return "fee has more decimal places than the currency", nil
+	}
+	c := fee.NewCost(p, fee.CostActual, req.Provider)
+	c.Amount = units.Unscaled().Int64()
+	c.Source = req.Reference
+	err = a.paymentService.SetActualCost(tx, p, c)
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// CostReportEntry are the costs of the payments of a provider, card brand and
+// currency
+type CostReportEntry struct {
+	Provider string
+	Brand    string
+	Currency string
+	// Payments is the number of payments with an estimated cost
+	Payments  int64
+	Estimated string
+	// Settled is the number of payments with an actual cost
+	Settled int64
+	Actual  string
+	// EstimatedSettled is the estimated cost of the settled payments
+	EstimatedSettled string
+	// Difference is the actual minus the estimated cost of the settled
+	// payments
+	Difference string
+}
+
+// CostReportResponse is the cost report of a project in a month
+type CostReportResponse struct {
+	ProjectID int64
+	// Month in the format "2006-01"
+	Month   string
+	Entries []CostReportEntry
+}
+
+func costDecimal(amount int64, subunits int8) string {
+	d := dec.NewDecInt64(amount)
+	d.SetScale(dec.Scale(subunits))
+	return d.String()
+}
+
+// ProjectCostReportRequest returns a handler for the cost report of a project
+//
+// GET returns the estimated and actual costs of the payments estimated in the
+// requested month
+func (a *AdminAPI) ProjectCostReportRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectCostReportRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		month, ok := usageMonthParam(w, r)
+		if !ok {
+			return
+		}
+		entries, err := fee.ReportDB(a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, month, month.AddDate(0, 1, 0))
+		if err != nil {
+			log.Error("error retrieving cost report", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		report := CostReportResponse{
+			ProjectID: projectID,
+			Month:     month.Format("2006-01"),
+			Entries:   make([]CostReportEntry, len(entries)),
+		}
+		for i, e := range entries {
+			report.Entries[i] = CostReportEntry{
+				Provider:         e.Provider,
+				Brand:            e.Brand,
+				Currency:         e.Currency,
+				Payments:         e.Payments,
+				Estimated:        costDecimal(e.Estimated, e.Subunits),
+				Settled:          e.Settled,
+				Actual:           costDecimal(e.Actual, e.Subunits),
+				EstimatedSettled: costDecimal(e.EstimatedSettled, e.Subunits),
+				Difference:       costDecimal(e.Difference(), e.Subunits),
+			}
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "costs of " + report.Month
+		resp.Response = report
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
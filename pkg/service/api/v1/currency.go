@@ -71,18 +71,27 @@ func (a *AdminAPI) CurrencyGetAllRequest() http.Handler {
 		// get all
 		log := a.log.New(log15.Ctx{"method": "CurrencyGetAllRequest"})
 
+		q, ok := listQuery(w, r, currency.CurrencyListing, log)
+		if !ok {
+			return
+		}
 		db := a.ctx.PaymentDB(service.ReadOnly)
-		cl, err := currency.CurrencyAllDB(db)
+		cl, page, err := currency.CurrencyListDB(db, q)
 		if err != nil {
 			ErrDatabase.Write(w)
 			log.Error("database error", log15.Ctx{"err": err})
 			return
 		}
+		items, ok := selectFields(w, q, cl)
+		if !ok {
+			return
+		}
+		setPageHeader(w, r, q, page)
 		// response write
 		resp := CurrencyAdminAPIResponse{}
 		resp.Status = StatusSuccess
 		resp.Info = "currencies found"
-		resp.Response = cl
+		resp.Response = items
 		resp.Write(w)
 		if err != nil {
 			log.Error("write error", log15.Ctx{"err": err})
@@ -0,0 +1,317 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/webhook"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// DeadLetter is the admin API representation of a provider webhook in the
+// dead-letter queue
+type DeadLetter struct {
+	ID         int64
+	Received   time.Time
+	Provider   string
+	Handler    string
+	Reason     string
+	Method     string
+	URL        string
+	Header     http.Header
+	RemoteAddr string
+	// Body is the raw request body
+	Body          string
+	Status        string
+	StatusChanged time.Time
+	// Comment is the reason of a failed re-processing or the comment of the
+	// last status change
+	Comment   string `json:",omitempty"`
+	CreatedBy string
+}
+
+func deadLetter(d *webhook.DeadLetter) DeadLetter {
+	return DeadLetter{
+		ID:            d.ID,
+		Received:      d.Received,
+		Provider:      d.Provider,
+		Handler:       d.Handler,
+		Reason:        d.Reason,
+		Method:        d.Method,
+		URL:           d.URL,
+		Header:        d.Header,
+		RemoteAddr:    d.RemoteAddr,
+		Body:          string(d.Body),
+		Status:        d.Status.Status,
+		StatusChanged: d.Status.Timestamp,
+		Comment:       d.Status.Comment.String,
+		CreatedBy:     d.Status.CreatedBy,
+	}
+}
+
+// DeadLetterDiscardRequest is the request body for discarding a dead letter
+type DeadLetterDiscardRequest struct {
+	Comment string
+}
+
+// DeadLettersRequest returns a handler which lists the provider webhooks in the
+// dead-letter queue by status
+func (a *AdminAPI) DeadLettersRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "DeadLettersRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = webhook.StatusPending
+		}
+		q, ok := listQuery(w, r, webhook.DeadLetterListing, log)
+		if !ok {
+			return
+		}
+		list, page, err := webhook.DeadLettersByStatusDB(a.ctx.PaymentDB(service.ReadOnly), status, q)
+		if err != nil {
+			log.Error("error retrieving dead letters", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		letters := make([]DeadLetter, len(list))
+		for i, d := range list {
+			letters[i] = deadLetter(d)
+		}
+		items, ok := selectFields(w, q, letters)
+		if !ok {
+			return
+		}
+		setPageHeader(w, r, q, page)
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(list)) + " " + status + " dead letters found"
+		resp.Response = items
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) deadLetterIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["deadletterid"], 10, 64)
+	if err != nil {
+		ErrReadParam.Write(w)
+		return 0, false
+	}
+	return id, true
+}
+
+// DeadLetterGetRequest returns a handler which returns a dead letter by ID
+func (a *AdminAPI) DeadLetterGetRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "DeadLetterGetRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		id, ok := a.deadLetterIDParam(w, r)
+		if !ok {
+			return
+		}
+		d, err := webhook.DeadLetterByIDDB(a.ctx.PaymentDB(service.ReadOnly), id)
+		if err != nil {
+			if err == webhook.ErrDeadLetterNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving dead letter", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "dead letter found"
+		resp.Response = deadLetter(d)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// DeadLetterProcessRequest returns a handler which re-processes a pending dead
+// letter with the handler which rejected it
+//
+// Dead letters which are rejected again remain pending.
+func (a *AdminAPI) DeadLetterProcessRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "DeadLetterProcessRequest"})
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		id, ok := a.deadLetterIDParam(w, r)
+		if !ok {
+			return
+		}
+		log = log.New(log15.Ctx{"deadLetterID": id})
+		d, err := a.ctx.DeadLetters().Reprocess(id, auth[AuthUserIDKey].(string))
+		switch err {
+		case nil:
+		case webhook.ErrDeadLetterNotFound:
+			ErrNotFound.Write(w)
+			return
+		case service.ErrDeadLetterNotPending:
+			resp := ErrConflict
+			resp.Info = "dead letter is " + d.Status.Status
+			resp.Write(w)
+			return
+		case service.ErrDeadLetterNoHandler:
+			resp := ErrConflict
+			resp.Info = "no handler " + d.Handler
+			resp.Write(w)
+			return
+		case service.ErrDeadLetterRejected:
+			resp := ErrConflict
+			resp.Info = "dead letter rejected: " + d.Status.Comment.String
+			resp.Response = deadLetter(d)
+			resp.Write(w)
+			return
+		case service.ErrDeadLetterFailed:
+			ErrSystem.Write(w)
+			return
+		default:
+			log.Error("error re-processing dead letter", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "dead letter processed"
+		resp.Response = deadLetter(d)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// DeadLetterDiscardRequest returns a handler which discards a pending dead
+// letter, which will not be processed
+func (a *AdminAPI) DeadLetterDiscardRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "DeadLetterDiscardRequest"})
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		id, ok := a.deadLetterIDParam(w, r)
+		if !ok {
+			return
+		}
+		req := DeadLetterDiscardRequest{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{"deadLetterID": id})
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.PaymentDB().Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		d, err := webhook.DeadLetterByIDTx(tx, id)
+		if err != nil {
+			if err == webhook.ErrDeadLetterNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving dead letter", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if !d.Pending() {
+			resp := ErrConflict
+			resp.Info = "dead letter is " + d.Status.Status
+			resp.Write(w)
+			return
+		}
+		st := d.NewStatus(webhook.StatusDiscarded, auth[AuthUserIDKey].(string))
+		st.Comment.String, st.Comment.Valid = req.Comment, req.Comment != ""
+		err = webhook.InsertStatusTx(tx, d, st)
+		if err != nil {
+			log.Error("error saving dead letter status", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "dead letter discarded"
+		resp.Response = deadLetter(d)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -0,0 +1,32 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/service"
+	"golang.org/x/net/context"
+)
+
+// requestContext returns the context of the request, which is done once the
+// request deadline passed or the client went away
+//
+// It falls back to the service context for requests which were not served by
+// the API handler.
+func requestContext(ctx *service.Context, r *http.Request) context.Context {
+	if reqCtx := service.RequestContext(r); reqCtx != nil {
+		return reqCtx
+	}
+	return ctx
+}
+
+// requestDone writes a timeout response if the request deadline passed or the
+// client went away
+//
+// It returns true if the response was written.
+func requestDone(w http.ResponseWriter, r *http.Request) bool {
+	if !service.RequestDone(r) {
+		return false
+	}
+	ErrTimeout.Write(w)
+	return true
+}
@@ -0,0 +1,59 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/sqltrace"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// DiagnosticsResponse is the response of the diagnostics endpoint
+type DiagnosticsResponse struct {
+	// Objective is the configured percentage of requests which should comply
+	// with the latency targets
+	Objective float64
+	Endpoints []service.SLOEndpoint
+	// Queries are the statistics of the database connections. Only present if
+	// slow query logging is enabled
+	Queries *sqltrace.Report `json:",omitempty"`
+	// Notifications are the queue metrics of the callback notifications of
+	// this instance
+	Notifications service.NotificationStats
+}
+
+// DiagnosticsRequest returns a handler which reports the latency SLO compliance
+// of the API endpoints, the slow queries and the notification queues of this
+// instance
+func (a *AdminAPI) DiagnosticsRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "DiagnosticsRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		diag := DiagnosticsResponse{
+			Objective:     a.ctx.SLO().Objective,
+			Endpoints:     a.ctx.SLO().Endpoints(),
+			Notifications: a.ctx.Notifications().Stats(),
+		}
+		if a.ctx.Config().Database.SlowQueryThreshold != "" {
+			report := a.ctx.QueryStats().Report()
+			diag.Queries = &report
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "diagnostics"
+		resp.Response = diag
+		err := resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
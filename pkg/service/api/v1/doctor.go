@@ -0,0 +1,206 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service/doctor"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// doctorDefaultPeriod is the period searched for missing notifications if no
+// start is given
+const doctorDefaultPeriod = 24 * time.Hour
+
+// DoctorFinding is the admin API representation of an inconsistency found by
+// the doctor
+type DoctorFinding struct {
+	Check string
+	// display payment ID
+	PaymentId payment.PaymentID
+	Status    string
+	// TransactionTimestamp identifies the payment transaction of missing
+	// notifications
+	TransactionTimestamp string `json:",omitempty"`
+	Detail               string
+	// Repair is the repair action, empty if the inconsistency cannot be
+	// repaired by the doctor
+	Repair string `json:",omitempty"`
+}
+
+// DoctorRepair is the request body for repairing an inconsistency
+type DoctorRepair struct {
+	Check string
+	// display payment ID
+	PaymentId            string
+	TransactionTimestamp string
+}
+
+func (a *AdminAPI) doctorFinding(f *doctor.Finding) DoctorFinding {
+	df := DoctorFinding{
+		Check:     f.Check,
+		PaymentId: a.paymentService.EncodedPaymentID(f.PaymentID),
+		Status:    string(f.Status),
+		Detail:    f.Detail,
+		Repair:    f.Repair,
+	}
+	if !f.TransactionTimestamp.IsZero() {
+		df.TransactionTimestamp = strconv.FormatInt(f.TransactionTimestamp.UnixNano(), 10)
+	}
+	return df
+}
+
+// ProjectDoctorRequest returns a handler which examines the payments of a
+// project for inconsistencies
+func (a *AdminAPI) ProjectDoctorRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectDoctorRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		since := time.Now().Add(-doctorDefaultPeriod)
+		if s := r.URL.Query().Get("since"); s != "" {
+			var err error
+			since, err = time.Parse(time.RFC3339, s)
+			if err != nil {
+				resp := ErrReadParam
+				resp.Info = "invalid since " + s
+				resp.Write(w)
+				return
+			}
+		}
+		log = log.New(log15.Ctx{"projectID": projectID})
+		findings, err := a.doctor.Examine(projectID, since)
+		if err != nil {
+			log.Error("error examining payments", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		list := make([]DoctorFinding, len(findings))
+		for i, f := range findings {
+			list[i] = a.doctorFinding(f)
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(findings)) + " inconsistencies found"
+		resp.Response = list
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ProjectDoctorRepairRequest returns a handler which repairs an inconsistency
+// of a payment of a project
+func (a *AdminAPI) ProjectDoctorRepairRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectDoctorRepairRequest"})
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		req := DoctorRepair{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(req.PaymentId)
+		if err != nil || paymentID.ProjectID != projectID {
+			resp := ErrInval
+			resp.Info = "invalid payment id"
+			resp.Write(w)
+			return
+		}
+		paymentID = a.paymentService.DecodedPaymentID(paymentID)
+		var ts time.Time
+		if req.TransactionTimestamp != "" {
+			nanos, err := strconv.ParseInt(req.TransactionTimestamp, 10, 64)
+			if err != nil {
+				resp := ErrInval
+				resp.Info = "invalid transaction timestamp"
+				resp.Write(w)
+				return
+			}
+			ts = time.Unix(0, nanos)
+		}
+		log = log.New(log15.Ctx{
+			"projectID": projectID,
+			"paymentID": paymentID.PaymentID,
+			"check":     req.Check,
+		})
+		f, err := a.doctor.Repair(req.Check, paymentID, ts, auth[AuthUserIDKey].(string))
+		switch err {
+		case nil:
+		case doctor.ErrCheck:
+			resp := ErrInval
+			resp.Info = "invalid check " + req.Check
+			resp.Write(w)
+			return
+		case payment.ErrPaymentNotFound:
+			ErrNotFound.Write(w)
+			return
+		case doctor.ErrResolved:
+			resp := ErrConflict
+			resp.Info = "inconsistency not found"
+			resp.Write(w)
+			return
+		case doctor.ErrNoRepair:
+			resp := ErrConflict
+			resp.Info = "inconsistency cannot be repaired by the doctor"
+			resp.Write(w)
+			return
+		default:
+			if errors.Is(err, paymentService.ErrIntentNotAllowed) {
+				resp := ErrConflict
+				resp.Info = "intent " + f.Repair + " not allowed"
+				resp.Write(w)
+				return
+			}
+			log.Error("error repairing payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "payment repaired with " + f.Repair
+		resp.Response = a.doctorFinding(f)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
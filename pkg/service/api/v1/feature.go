@@ -0,0 +1,146 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/feature"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// FeatureRequest is the request body for setting a feature flag
+type FeatureRequest struct {
+	Enabled    bool
+	Percentage int
+	Projects   []int64
+}
+
+// FeatureGetAllRequest returns a handler which lists the current feature flags
+func (a *AdminAPI) FeatureGetAllRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "FeatureGetAllRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		flags := a.ctx.FeatureFlags()
+		names := make([]string, 0, len(flags))
+		for name := range flags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		list := make([]feature.Flag, len(names))
+		for i, name := range names {
+			list[i] = flags[name]
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "features found"
+		resp.Response = list
+		err := resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// FeatureRequest returns a handler which sets a feature flag
+//
+// The flag will be stored in the database and takes precedence over the
+// configured default.
+func (a *AdminAPI) FeatureRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "FeatureRequest"})
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		req := FeatureRequest{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		f := &feature.Flag{
+			Name:       mux.Vars(r)["name"],
+			Enabled:    req.Enabled,
+			Percentage: req.Percentage,
+			Projects:   req.Projects,
+			Created:    time.Now(),
+			CreatedBy:  auth[AuthUserIDKey].(string),
+		}
+		if !f.Valid() {
+			ErrInval.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{"feature": f.Name})
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.PaymentDB().Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = feature.InsertFlagTx(tx, f)
+		if err != nil {
+			log.Error("error saving feature flag", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		// other instances will pick up the change on their next reload
+		err = a.ctx.Features().Load(a.ctx.PaymentDB())
+		if err != nil {
+			log.Error("error reloading feature flags", log15.Ctx{"err": err})
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "feature " + f.Name + " set"
+		resp.Response = f
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -0,0 +1,571 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/paymentd/funds"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const fundsIntentTimeout = 500 * time.Millisecond
+
+// IncomingFunds is the admin API representation of incoming funds
+type IncomingFunds struct {
+	ID            int64
+	Created       time.Time
+	Source        string
+	Reference     string
+	Sender        string `json:",omitempty"`
+	Amount        string
+	Currency      string
+	Status        string
+	StatusChanged time.Time
+	// display payment ID of a matched payment
+	PaymentID string `json:",omitempty"`
+	Comment   string `json:",omitempty"`
+	CreatedBy string
+}
+
+func (a *AdminAPI) incomingFunds(f *funds.Funds) IncomingFunds {
+	in := IncomingFunds{
+		ID:            f.ID,
+		Created:       f.Created,
+		Source:        f.Source,
+		Reference:     f.Reference,
+		Sender:        f.Sender.String,
+		Amount:        f.Decimal().String(),
+		Currency:      f.Currency,
+		Status:        f.Status.Status,
+		StatusChanged: f.Status.Timestamp,
+		Comment:       f.Status.Comment.String,
+		CreatedBy:     f.CreatedBy,
+	}
+	if f.Status.PaymentID.Valid {
+		id := payment.PaymentID{
+			ProjectID: f.Status.ProjectID.Int64,
+			PaymentID: f.Status.PaymentID.Int64,
+		}
+		in.PaymentID = a.paymentService.EncodedPaymentID(id).String()
+	}
+	return in
+}
+
+// IncomingFundsRequest is the request body for adding incoming funds
+type IncomingFundsRequest struct {
+	Source    string
+	Reference string
+	Sender    string
+	Amount    string
+	Currency  string
+}
+
+// FundsMatchRequest is the request body for matching incoming funds with a
+// payment
+//
+// The payment is identified by its display payment ID or by its reference
+// number. If neither is given, the reference of the funds will be used as the
+// reference number.
+type FundsMatchRequest struct {
+	PaymentID string
+	Reference string
+	Comment   string
+}
+
+// FundsReturnRequest is the request body for marking incoming funds to be
+// returned
+type FundsReturnRequest struct {
+	Comment string
+}
+
+// FundsRequest returns a handler which lists incoming funds by status or adds
+// unmatched incoming funds
+//
+// Imports which could not reconcile incoming funds automatically should add them
+// here, so they can be matched manually.
+func (a *AdminAPI) FundsRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "FundsRequest"})
+		switch r.Method {
+		case "GET":
+			a.getFunds(w, r, log)
+		case "POST":
+			a.addFunds(w, r, log)
+		default:
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) getFunds(w http.ResponseWriter, r *http.Request, log log15.Logger) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = funds.StatusUnmatched
+	}
+	q, ok := listQuery(w, r, funds.FundsListing, log)
+	if !ok {
+		return
+	}
+	list, page, err := funds.FundsByStatusDB(a.ctx.PaymentDB(service.ReadOnly), status, q)
+	if err != nil {
+		log.Error("error retrieving funds", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	in := make([]IncomingFunds, len(list))
+	for i, f := range list {
+		in[i] = a.incomingFunds(f)
+	}
+	items, ok := selectFields(w, q, in)
+	if !ok {
+		return
+	}
+	setPageHeader(w, r, q, page)
+	resp := AdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = strconv.Itoa(len(list)) + " " + status + " funds found"
+	resp.Response = items
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("write error", log15.Ctx{"err": err})
+	}
+}
+
+func (a *AdminAPI) addFunds(w http.ResponseWriter, r *http.Request, log log15.Logger) {
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("error getting auth container", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return
+	}
+	req := IncomingFundsRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Error("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return
+	}
+	amount := dec.NewDecInt64(0)
+	if _, ok := amount.SetString(req.Amount); !ok || amount.Scale() < 0 || amount.Scale() > 8 {
+		resp := ErrInval
+		resp.Info = "invalid amount"
+		resp.Write(w)
+		return
+	}
+	f := &funds.Funds{
+		Source:    req.Source,
+		Reference: req.Reference,
+		Amount:    amount.Unscaled().Int64(),
+		Subunits:  int8(amount.Scale()),
+		Currency:  req.Currency,
+		CreatedBy: auth[AuthUserIDKey].(string),
+	}
+	f.Sender.String, f.Sender.Valid = req.Sender, req.Sender != ""
+	if !f.Valid() {
+		ErrInval.Write(w)
+		return
+	}
+
+	var tx *sql.Tx
+	var commit bool
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = a.ctx.PaymentDB().Begin()
+	if err != nil {
+		commit = true
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	err = funds.InsertFundsTx(tx, f)
+	if err != nil {
+		log.Error("error saving funds", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+
+	resp := AdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = "funds added"
+	resp.Response = a.incomingFunds(f)
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("write error", log15.Ctx{"err": err})
+	}
+}
+
+func (a *AdminAPI) fundsIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["fundsid"], 10, 64)
+	if err != nil {
+		ErrReadParam.Write(w)
+		return 0, false
+	}
+	return id, true
+}
+
+// FundsGetRequest returns a handler which returns incoming funds by ID
+func (a *AdminAPI) FundsGetRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "FundsGetRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		id, ok := a.fundsIDParam(w, r)
+		if !ok {
+			return
+		}
+		f, err := funds.FundsByIDDB(a.ctx.PaymentDB(service.ReadOnly), id)
+		if err != nil {
+			if err == funds.ErrFundsNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving funds", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "funds found"
+		resp.Response = a.incomingFunds(f)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// FundsMatchRequest returns a handler which matches unmatched incoming funds
+// with a payment
+//
+// The funds will be received for the payment, which will be paid or partially
+// paid depending on the amount.
+func (a *AdminAPI) FundsMatchRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "FundsMatchRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		id, ok := a.fundsIDParam(w, r)
+		if !ok {
+			return
+		}
+		req := FundsMatchRequest{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		var paymentID payment.PaymentID
+		if req.PaymentID != "" {
+			paymentID, err = payment.ParsePaymentIDStr(req.PaymentID)
+			if err != nil {
+				resp := ErrInval
+				resp.Info = "invalid payment id"
+				resp.Write(w)
+				return
+			}
+			paymentID = a.paymentService.DecodedPaymentID(paymentID)
+		}
+		log = log.New(log15.Ctx{
+			"fundsID":          id,
+			"DisplayPaymentId": req.PaymentID,
+		})
+
+		// the funds are kept in the default shard, the payment in the shard of
+		// its project
+		var tx, shardTx *sql.Tx
+		var commit bool
+		defer func() {
+			if commit {
+				return
+			}
+			if shardTx != nil {
+				err = shardTx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+			if tx != nil {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.PaymentDB().Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		f, err := funds.FundsByIDTx(tx, id)
+		if err != nil {
+			if err == funds.ErrFundsNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving funds", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if f.Status.Status != funds.StatusUnmatched {
+			resp := ErrConflict
+			resp.Info = "funds are " + f.Status.Status
+			resp.Write(w)
+			return
+		}
+		if req.PaymentID == "" {
+			ref := req.Reference
+			if ref == "" {
+				ref = f.Reference
+			}
+			paymentID, err = a.paymentService.PaymentIDByReference(ref)
+			if err != nil {
+				if err == payment.ErrPaymentNotFound {
+					resp := ErrNotFound
+					resp.Info = "no payment with reference " + ref
+					resp.Write(w)
+					return
+				}
+				ErrDatabase.Write(w)
+				return
+			}
+		}
+		paymentTx := tx
+		if a.ctx.Shards().Shard(paymentID.ProjectID) != service.DefaultShard {
+			shardTx, err = a.ctx.ProjectPaymentDB(paymentID.ProjectID).Begin()
+			if err != nil {
+				log.Crit("error on begin", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			paymentTx = shardTx
+		}
+		p, err := payment.PaymentByIDTx(ctx, paymentTx, paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if p.Currency != f.Currency {
+			resp := ErrConflict
+			resp.Info = "currency mismatch"
+			resp.Write(w)
+			return
+		}
+		receivedTx, commitIntent, err := a.paymentService.IntentReceived(ctx, p, f.Decimal(), fundsIntentTimeout)
+		if err != nil {
+			if errors.Is(err, paymentService.ErrIntentNotAllowed) {
+				resp := ErrConflict
+				resp.Info = "payment is " + p.Status.String()
+				resp.Write(w)
+				return
+			}
+			log.Error("error on intent received", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		err = a.paymentService.SetPaymentTransaction(paymentTx, receivedTx)
+		if err != nil {
+			log.Error("error on payment transaction", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		st := f.NewStatus(funds.StatusMatched, auth[AuthUserIDKey].(string))
+		st.ProjectID.Int64, st.ProjectID.Valid = p.ProjectID(), true
+		st.PaymentID.Int64, st.PaymentID.Valid = p.ID(), true
+		st.Comment.String, st.Comment.Valid = req.Comment, req.Comment != ""
+		err = funds.InsertStatusTx(tx, f, st)
+		if err != nil {
+			log.Error("error saving funds status", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		// the funds stay locked until the payment is committed
+		if shardTx != nil {
+			err = shardTx.Commit()
+			shardTx = nil
+			if err != nil {
+				log.Crit("error on commit", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err, "projectID": p.ProjectID(), "paymentID": p.ID()})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = commitIntent()
+		if err != nil {
+			log.Error("error committing intent", log15.Ctx{"err": err})
+		}
+		a.paymentService.NotifyEvent(p.ProjectID(), paymentService.EventFundsMatched, map[string]string{
+			"FundsId":   strconv.FormatInt(f.ID, 10),
+			"PaymentId": a.paymentService.EncodedPaymentID(p.PaymentID()).String(),
+			"Amount":    f.Decimal().String(),
+			"Currency":  f.Currency,
+			"Reference": f.Reference,
+		})
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "funds matched, payment is " + p.Status.String()
+		resp.Response = a.incomingFunds(f)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// FundsReturnRequest returns a handler which marks unmatched incoming funds to
+// be returned to the sender
+func (a *AdminAPI) FundsReturnRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "FundsReturnRequest"})
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		id, ok := a.fundsIDParam(w, r)
+		if !ok {
+			return
+		}
+		req := FundsReturnRequest{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{"fundsID": id})
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.PaymentDB().Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		f, err := funds.FundsByIDTx(tx, id)
+		if err != nil {
+			if err == funds.ErrFundsNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving funds", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if f.Status.Status != funds.StatusUnmatched {
+			resp := ErrConflict
+			resp.Info = "funds are " + f.Status.Status
+			resp.Write(w)
+			return
+		}
+		st := f.NewStatus(funds.StatusReturn, auth[AuthUserIDKey].(string))
+		st.Comment.String, st.Comment.Valid = req.Comment, req.Comment != ""
+		err = funds.InsertStatusTx(tx, f, st)
+		if err != nil {
+			log.Error("error saving funds status", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "funds marked for return"
+		resp.Response = a.incomingFunds(f)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -21,12 +21,15 @@ import (
 )
 
 // GetPaymentRequest represents a get payment request
+//
+// Canonical JSON signatures of the request cover the JSON object of its
+// parameters, e.g. {"Nonce":"...","PaymentId":"...","ProjectKey":"...","Timestamp":"..."}
 type GetPaymentRequest struct {
 	ProjectKey   string
-	PaymentId    string
+	PaymentId    string `json:",omitempty"`
 	paymentID    payment.PaymentID
-	Ident        string
-	Timestamp    int64
+	Ident        string `json:",omitempty"`
+	Timestamp    int64  `json:",string"`
 	Nonce        string
 	hexSignature string
 }
@@ -74,6 +77,10 @@ func (r *GetPaymentRequest) RequestProjectKey() string {
 	return r.ProjectKey
 }
 
+func (r *GetPaymentRequest) RequestNonce() string {
+	return r.Nonce
+}
+
 func (r *GetPaymentRequest) Time() time.Time {
 	return time.Unix(r.Timestamp, 0)
 }
@@ -103,6 +110,7 @@ func (r *GetPaymentRequest) ReadFromRequest(req *http.Request) error {
 	if r.Nonce == "" {
 		return errors.New("no nonce")
 	}
+	r.hexSignature = q.Get("Signature")
 	return nil
 }
 
@@ -112,6 +120,7 @@ func (a *PaymentAPI) GetPayment() http.Handler {
 		log := a.log.New(log15.Ctx{
 			"method": "GetPayment",
 		})
+		ctx := requestContext(a.ctx, r)
 		var err error
 		req := &GetPaymentRequest{}
 		err = req.ReadFromRequest(r)
@@ -137,14 +146,14 @@ func (a *PaymentAPI) GetPayment() http.Handler {
 			return
 		}
 		var projectKey *project.Projectkey
-		if projectKey = a.authenticateRequest(req, log, w); projectKey == nil {
+		if projectKey = a.authenticateRequest(r, req, log, w); projectKey == nil {
 			return
 		}
 		var p *payment.Payment
 		if req.Ident != "" {
-			p, err = payment.PaymentByProjectIDAndIdentDB(a.ctx.PaymentDB(service.ReadOnly), projectKey.Project.ID, req.Ident)
+			p, err = payment.PaymentByProjectIDAndIdentDB(ctx, a.ctx.ProjectPaymentDB(projectKey.Project.ID, service.ReadOnly), projectKey.Project.ID, req.Ident)
 		} else {
-			p, err = payment.PaymentByIDDB(a.ctx.PaymentDB(service.ReadOnly), req.paymentID)
+			p, err = payment.PaymentByIDDB(ctx, a.ctx.ProjectPaymentDB(projectKey.Project.ID, service.ReadOnly), req.paymentID)
 		}
 		if err != nil {
 			if err == payment.ErrPaymentNotFound {
@@ -168,45 +177,13 @@ func (a *PaymentAPI) GetPayment() http.Handler {
 			return
 		}
 
-		// create notification
-		var not *notification.Notification
-		not, err = notification.New(a.paymentService.EncodedPaymentID(p.PaymentID()), p)
-		if err != nil {
-			log.Error("error creating response notification", log15.Ctx{"err": err})
-			ErrSystem.Write(w)
-			return
-		}
-		// balance/transaction list
-		if p.HasTransaction() {
-			tl, err := payment.PaymentTransactionsBeforeTimestampDB(a.ctx.PaymentDB(service.ReadOnly), p, p.TransactionTimestamp)
-			if err != nil && err != payment.ErrPaymentTransactionNotFound {
-				log.Error("error retrieving payment transactions", log15.Ctx{"err": err})
-				ErrDatabase.Write(w)
-				return
-			}
-			not.SetTransactions(tl)
-		}
-		// notification signing
-		non, err := nonce.New()
-		if err != nil {
-			log.Error("error creating nonce", log15.Ctx{"err": err})
-			ErrSystem.Write(w)
-			return
-		}
-		secret, err := projectKey.SecretBytes()
-		if err != nil {
-			log.Error("error retrieving project secret", log15.Ctx{"err": err})
-			ErrSystem.Write(w)
-			return
-		}
-		err = not.Sign(time.Now(), non.Nonce, secret)
-		if err != nil {
-			log.Error("error signing", log15.Ctx{"err": err})
-			ErrSystem.Write(w)
+		resp := ServiceResponse{}
+		not := a.paymentNotification(projectKey, p, log, &resp)
+		if not == nil {
+			resp.Write(w)
 			return
 		}
 
-		resp := ServiceResponse{}
 		resp.Status = StatusSuccess
 		resp.HttpStatus = http.StatusOK
 		resp.Info = "returning payment"
@@ -214,3 +191,88 @@ func (a *PaymentAPI) GetPayment() http.Handler {
 		resp.Write(w)
 	})
 }
+
+// paymentNotification creates the signed notification of the payment, which is
+// returned when retrieving payments
+//
+// It returns nil and sets the error response if the notification cannot be
+// created.
+func (a *PaymentAPI) paymentNotification(projectKey *project.Projectkey, p *payment.Payment, log log15.Logger, resp *ServiceResponse) *notification.Notification {
+	db := a.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly)
+	err := payment.PaymentParentDB(a.ctx, db, p)
+	if err != nil {
+		log.Error("error retrieving parent payment relation", log15.Ctx{"err": err})
+		*resp = ErrDatabase
+		return nil
+	}
+	err = payment.PaymentAuthorizationDB(a.ctx, db, p)
+	if err != nil {
+		log.Error("error retrieving payment authorization", log15.Ctx{"err": err})
+		*resp = ErrDatabase
+		return nil
+	}
+	err = payment.PaymentReferenceDB(a.ctx, db, p)
+	if err != nil {
+		log.Error("error retrieving payment reference", log15.Ctx{"err": err})
+		*resp = ErrDatabase
+		return nil
+	}
+
+	// create notification
+	not, err := notification.New(a.paymentService.EncodedPaymentID(p.PaymentID()), p)
+	if err != nil {
+		log.Error("error creating response notification", log15.Ctx{"err": err})
+		*resp = ErrSystem
+		return nil
+	}
+	if parentID, ok := p.ParentPaymentID(); ok {
+		not.SetParent(a.paymentService.EncodedPaymentID(parentID), p.Parent.Type)
+	}
+	schema, err := projectKey.Project.Config.PaymentMetadataSchema()
+	if err != nil {
+		log.Error("invalid metadata schema", log15.Ctx{"err": err})
+		*resp = ErrSystem
+		return nil
+	}
+	not.SetMetadataSchema(schema)
+	methodName, err := a.paymentService.PaymentMethodName(p)
+	if err != nil {
+		log.Error("error retrieving payment method name", log15.Ctx{"err": err})
+		*resp = ErrDatabase
+		return nil
+	}
+	not.SetPaymentMethodName(methodName)
+	// balance/transaction list
+	if p.HasTransaction() {
+		tl, err := payment.PaymentTransactionsBeforeTimestampDB(a.ctx, db, p, p.TransactionTimestamp)
+		if err != nil && err != payment.ErrPaymentTransactionNotFound {
+			log.Error("error retrieving payment transactions", log15.Ctx{"err": err})
+			*resp = ErrDatabase
+			return nil
+		}
+		not.SetTransactions(tl)
+	}
+	// notification signing
+	if projectKey.CanonicalJSON() {
+		not.UseCanonicalJSON()
+	}
+	non, err := nonce.New()
+	if err != nil {
+		log.Error("error creating nonce", log15.Ctx{"err": err})
+		*resp = ErrSystem
+		return nil
+	}
+	secret, err := projectKey.SecretBytes()
+	if err != nil {
+		log.Error("error retrieving project secret", log15.Ctx{"err": err})
+		*resp = ErrSystem
+		return nil
+	}
+	err = not.Sign(time.Now(), non.Nonce, secret)
+	if err != nil {
+		log.Error("error signing", log15.Ctx{"err": err})
+		*resp = ErrSystem
+		return nil
+	}
+	return not
+}
@@ -0,0 +1,136 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	healthOK    = "ok"
+	healthError = "error"
+)
+
+// HealthResponse is the response of the health endpoint
+type HealthResponse struct {
+	PrincipalDB string
+	PaymentDB   string
+	// Ready is true if the instance finished warming its cache
+	Ready bool
+	// States of the monitored database connections
+	Connections []service.DBStatus
+	// Number of requests queued during a database outage, which were not
+	// replayed yet
+	QueuedRequests int
+	Features       map[string]HealthFeatureResponse
+}
+
+// HealthFeatureResponse is the representation of a feature flag in the health
+// response
+type HealthFeatureResponse struct {
+	Enabled    bool
+	Percentage int
+	// Number of projects for which the feature is explicitly enabled
+	Projects int
+}
+
+// HealthHandler returns a handler reporting the health of the service
+//
+// The database connections will be checked. The current feature flags are
+// included in the response.
+func HealthHandler(ctx *service.Context) http.Handler {
+	log := ctx.Log().New(log15.Ctx{
+		"pkg":    "github.com/fritzpay/paymentd/pkg/service/api/v1",
+		"method": "HealthHandler",
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "GET" {
+			ErrMethod.Write(w)
+			return
+		}
+		health := HealthResponse{
+			PrincipalDB:    healthOK,
+			PaymentDB:      healthOK,
+			Ready:          ctx.CacheWarmer().Ready(),
+			Connections:    ctx.DBMonitor().Status(),
+			QueuedRequests: ctx.OutageQueue().Len(),
+			Features:       make(map[string]HealthFeatureResponse),
+		}
+		resp := ServiceResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "healthy"
+		if err := ctx.PrincipalDB().Ping(); err != nil {
+			log.Error("principal DB ping failed", log15.Ctx{"err": err})
+			health.PrincipalDB = healthError
+		}
+		// the payments are stored in all shards
+		for _, sh := range ctx.PaymentShardDBs() {
+			if err := sh.DB.Ping(); err != nil {
+				log.Error("payment DB ping failed", log15.Ctx{"shard": sh.Shard, "err": err})
+				health.PaymentDB = healthError
+			}
+		}
+		if health.PrincipalDB != healthOK || health.PaymentDB != healthOK {
+			resp.HttpStatus = http.StatusServiceUnavailable
+			resp.Status = StatusError
+			resp.Info = "unhealthy"
+		}
+		for name, f := range ctx.FeatureFlags() {
+			health.Features[name] = HealthFeatureResponse{
+				Enabled:    f.Enabled,
+				Percentage: f.Percentage,
+				Projects:   len(f.Projects),
+			}
+		}
+		resp.Response = health
+		err := resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+}
+
+// ReadyResponse is the response of the readiness endpoint
+type ReadyResponse struct {
+	Ready bool
+	// Number of entries loaded into the cache by each warmer
+	Warmed map[string]int
+}
+
+// ReadyHandler returns a handler reporting whether the instance is ready to
+// serve requests
+//
+// An instance is ready once its cache is warm. Load balancers should only route
+// requests to ready instances.
+func ReadyHandler(ctx *service.Context) http.Handler {
+	log := ctx.Log().New(log15.Ctx{
+		"pkg":    "github.com/fritzpay/paymentd/pkg/service/api/v1",
+		"method": "ReadyHandler",
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "GET" {
+			ErrMethod.Write(w)
+			return
+		}
+		ready := ReadyResponse{
+			Ready:  ctx.CacheWarmer().Ready(),
+			Warmed: ctx.CacheWarmer().Warmed(),
+		}
+		resp := ServiceResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "ready"
+		if !ready.Ready {
+			resp.HttpStatus = http.StatusServiceUnavailable
+			resp.Status = StatusError
+			resp.Info = "warming cache"
+		}
+		resp.Response = ready
+		err := resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+}
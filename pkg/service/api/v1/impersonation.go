@@ -0,0 +1,159 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/paymentd/user"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ImpersonateHeader is the header of payment API requests, which an admin user
+// performs as a project
+//
+// The value is the ID of the project. Impersonated requests are not signed with
+// the project key. Instead, the user authenticates with an API key in the
+// Authorization header and requires the impersonator role on the project.
+const ImpersonateHeader = "X-Paymentd-Impersonate"
+
+// impersonate authenticates a request, which an admin user performs as the
+// project of the requested project key
+//
+// Every impersonated request is recorded with the real actor before it is
+// served. Requests which cannot be recorded will be refused.
+func (a *PaymentAPI) impersonate(r *http.Request, req ProjectKeyRequester, log log15.Logger, w http.ResponseWriter) *project.Projectkey {
+	log = log.New(log15.Ctx{"impersonate": r.Header.Get(ImpersonateHeader)})
+	projectID, err := strconv.ParseInt(r.Header.Get(ImpersonateHeader), 10, 64)
+	if err != nil {
+		resp := ErrReadParam
+		resp.Info = "invalid " + ImpersonateHeader + " header"
+		resp.Write(w)
+		return nil
+	}
+	authStr := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authStr, apiKeyAuthScheme+" ") {
+		resp := ErrUnauthorized
+		resp.Info = "impersonated requests require an API key"
+		resp.Write(w)
+		return nil
+	}
+	token := strings.TrimPrefix(authStr, apiKeyAuthScheme+" ")
+	subjects := []string{clientSubject(r)}
+	if keyID, _, err := user.ParseAPIKeyToken(token); err == nil {
+		subjects = append(subjects, "apikey:"+keyID)
+	}
+	if lockedOut(a.ctx, w, log, subjects...) {
+		return nil
+	}
+	u, k, err := apiKeyUser(a.ctx, token)
+	if err != nil {
+		if err != user.ErrInvalidAPIKey {
+			log.Error("error authenticating api key", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return nil
+		}
+		authFailed(a.ctx, log, subjects...)
+		ErrUnauthorized.Write(w)
+		return nil
+	}
+	authSucceeded(a.ctx, log, subjects...)
+	log = log.New(log15.Ctx{"user": u.Name})
+
+	projectKey, err := a.projectKey(req.RequestProjectKey(), log)
+	if err != nil && err != project.ErrProjectKeyNotFound {
+		log.Error("error on retrieving project key", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return nil
+	}
+	// do not disclose whether the project key exists
+	if err == project.ErrProjectKeyNotFound || !projectKey.IsValid() || projectKey.Project.ID != projectID {
+		resp := ErrForbidden
+		if Debug {
+			resp.Info = fmt.Sprintf("project key %s is not an active key of project %d", req.RequestProjectKey(), projectID)
+		}
+		resp.Write(w)
+		return nil
+	}
+	imp, err := user.NewImpersonation(u, projectKey.Project.PrincipalID, projectKey.Project.ID)
+	if err != nil {
+		if err != user.ErrImpersonationNotPermitted {
+			log.Error("error on impersonation", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return nil
+		}
+		log.Warn("impersonation not permitted")
+		ErrForbidden.Write(w)
+		return nil
+	}
+	imp.APIKeyID = k.ID
+	imp.Method = r.Method
+	imp.Path = r.URL.Path
+	imp.RemoteAddr = r.RemoteAddr
+	err = user.InsertImpersonationDB(a.ctx.PrincipalDB(), imp)
+	if err != nil {
+		log.Error("error saving impersonation", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return nil
+	}
+	log.Info("impersonated request", log15.Ctx{
+		"impersonationID": imp.ID,
+		"apiKey":          k.ID,
+		"projectID":       projectKey.Project.ID,
+	})
+	service.SetRequestProject(r, projectKey.Project.ID)
+	return projectKey
+}
+
+// ProjectImpersonationsRequest returns a handler for the impersonated requests
+// of a project
+//
+// GET returns a page of the impersonated requests, newest first by default.
+func (a *AdminAPI) ProjectImpersonationsRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectImpersonationsRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		q, ok := listQuery(w, r, user.ImpersonationListing, log)
+		if !ok {
+			return
+		}
+		// newest first unless sorted explicitly
+		if q.Sort == "" {
+			q.Desc = true
+		}
+		list, page, err := user.ImpersonationsByProjectDB(a.ctx.PrincipalDB(service.ReadOnly), projectID, q)
+		if err != nil {
+			log.Error("error retrieving impersonations", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		items, ok := selectFields(w, q, list)
+		if !ok {
+			return
+		}
+		setPageHeader(w, r, q, page)
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(list)) + " impersonated requests"
+		resp.Response = items
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
@@ -13,18 +14,20 @@ import (
 	"net/url"
 	"strconv"
 	"time"
-	"unicode/utf8"
 
+	"github.com/fritzpay/paymentd/pkg/descriptor"
 	jsonutil "github.com/fritzpay/paymentd/pkg/json"
 	"github.com/fritzpay/paymentd/pkg/maputil"
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"github.com/fritzpay/paymentd/pkg/paymentd/checkout"
 	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
 	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
 	"github.com/fritzpay/paymentd/pkg/service"
 	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
-	"github.com/go-sql-driver/mysql"
-	"golang.org/x/text/language"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"github.com/fritzpay/paymentd/pkg/validate"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
@@ -44,6 +47,14 @@ type InitPaymentRequest struct {
 	CallbackProjectKey string `json:",omitempty"`
 	ReturnURL          string `json:",omitempty"`
 	Expires            int64  `json:",string,omitempty"`
+	ParentPaymentId    string `json:",omitempty"`
+	Relation           string `json:",omitempty"`
+	// Session is the token of the checkout session converted into the payment
+	Session string `json:",omitempty"`
+	// Note is the free-text note of the payment, e.g. an order description
+	Note string `json:",omitempty"`
+	// StatementDescriptor overrides the statement descriptor of the project
+	StatementDescriptor string `json:",omitempty"`
 
 	Metadata map[string]string
 
@@ -52,76 +63,44 @@ type InitPaymentRequest struct {
 
 	HexSignature    string `json:"Signature"`
 	binarySignature []byte
+
+	rawJSON []byte
 }
 
 // Validate input
+//
+// It returns validate.Errors addressing the invalid fields.
 func (r *InitPaymentRequest) Validate() error {
-	if r.ProjectKey == "" {
-		return fmt.Errorf("missing ProjectKey")
-	}
-	if r.Ident == "" {
-		return fmt.Errorf("missing Ident")
-	}
-	if utf8.RuneCountInString(r.Ident) > payment.IdentMaxLen {
-		return fmt.Errorf("invalid Ident")
-	}
-	if !r.Amount.Set {
-		return fmt.Errorf("missing Amount")
-	}
-	if r.Amount.Int64 < 0 {
-		return fmt.Errorf("invalid Amount: %d", r.Amount.Int64)
-	}
-	if !r.Subunits.Set {
-		return fmt.Errorf("missing Subunits")
-	}
-	if r.Currency == "" {
-		return fmt.Errorf("missing Currency")
-	}
-	if len(r.Currency) != 3 {
-		return fmt.Errorf("invalid Currency")
-	}
-	if r.Country == "" {
-		return fmt.Errorf("missing Country")
-	}
-	if len(r.Country) != 2 {
-		return fmt.Errorf("invalid Country")
-	}
-	var err error
-	if r.HexSignature == "" {
-		return fmt.Errorf("missing Signature")
-	} else if r.binarySignature, err = hex.DecodeString(r.HexSignature); err != nil {
-		return fmt.Errorf("invalid Signature format")
-	}
-	if r.Locale != "" {
-		if _, err := language.Parse(r.Locale); err != nil {
-			return fmt.Errorf("invalid Locale")
-		}
-	}
-	if r.CallbackURL != "" {
-		if _, err = url.Parse(r.CallbackURL); err != nil {
-			return fmt.Errorf("invalid CallbackURL")
-		}
-	}
-	if r.ReturnURL != "" {
-		if _, err := url.Parse(r.ReturnURL); err != nil {
-			return fmt.Errorf("invalid ReturnURL")
-		}
-	}
-	if r.Expires != 0 {
-		expires := time.Unix(r.Expires, 0)
-		if expires.Before(time.Now()) {
-			return fmt.Errorf("invalid Expires. Expires is in the past")
-		}
-	}
-	if r.Timestamp == 0 {
-		return fmt.Errorf("missing Timestamp")
-	}
-	if r.Nonce == "" {
-		return fmt.Errorf("missing Nonce")
-	}
-	if len(r.Nonce) > nonce.NonceBytes {
-		return fmt.Errorf("invalid Nonce")
+	_, parentErr := payment.ParsePaymentIDStr(r.ParentPaymentId)
+	err := validate.Validate(
+		validate.Field("ProjectKey", validate.Required(r.ProjectKey)),
+		validate.Field("Ident", validate.Required(r.Ident), validate.MaxLen(r.Ident, payment.IdentMaxLen)),
+		validate.Field("Amount", validate.Set(r.Amount.Set), validate.Min(r.Amount.Int64, 0)),
+		validate.Field("Subunits", validate.Set(r.Subunits.Set)),
+		validate.Field("Currency", validate.Required(r.Currency), validate.Len(r.Currency, 3)),
+		validate.Field("Country", validate.Required(r.Country), validate.Len(r.Country, 2)),
+		validate.Field("Signature", validate.Required(r.HexSignature), validate.Hex(r.HexSignature)),
+		validate.Field("Locale", validate.Locale(r.Locale)),
+		validate.Field("CallbackURL", validate.URL(r.CallbackURL)),
+		validate.Field("ReturnURL", validate.URL(r.ReturnURL)),
+		validate.Field("Expires", validate.Future(r.Expires)),
+		validate.Field("ParentPaymentId",
+			validate.Assert(r.ParentPaymentId == "" || parentErr == nil, validate.CodeInvalid),
+			validate.Assert(r.ParentPaymentId != "" || r.Relation == "", validate.CodeMissing)),
+		// recurring payments are created by the subscriptions only
+		validate.Field("Relation", validate.Assert(r.Relation == "" || (payment.ValidRelation(r.Relation) && r.Relation != payment.RelationRecurring), validate.CodeInvalid)),
+		validate.Field("Session", validate.Hex(r.Session)),
+		validate.Field("Note", validate.Assert(payment.ValidNote(r.Note), validate.CodeInvalid)),
+		validate.Field("StatementDescriptor", validate.Assert(r.StatementDescriptor == "" || descriptor.Default.Check(r.StatementDescriptor) == nil, validate.CodeInvalid)),
+		validate.Field("Metadata", validate.Assert(metadata.MaxLimits.Validate(r.Metadata) == nil, validate.CodeInvalid)),
+		validate.Field("Timestamp", validate.Assert(r.Timestamp != 0, validate.CodeMissing)),
+		validate.Field("Nonce", validate.Required(r.Nonce), validate.Assert(len(r.Nonce) <= nonce.NonceBytes, validate.CodeInvalid)),
+	)
+	if err != nil {
+		return err
 	}
+	// validated
+	r.binarySignature, _ = hex.DecodeString(r.HexSignature)
 	return nil
 }
 
@@ -207,6 +186,36 @@ func (r *InitPaymentRequest) Message() ([]byte, error) {
 			return nil, fmt.Errorf("buffer error: %v", err)
 		}
 	}
+	if r.ParentPaymentId != "" {
+		_, err = buf.WriteString(r.ParentPaymentId)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	if r.Relation != "" {
+		_, err = buf.WriteString(r.Relation)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	if r.Session != "" {
+		_, err = buf.WriteString(r.Session)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	if r.Note != "" {
+		_, err = buf.WriteString(r.Note)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	if r.StatementDescriptor != "" {
+		_, err = buf.WriteString(r.StatementDescriptor)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
 	if r.Metadata != nil {
 		err = maputil.WriteSortedMap(buf, r.Metadata)
 		if err != nil {
@@ -229,14 +238,35 @@ func (r *InitPaymentRequest) RequestProjectKey() string {
 	return r.ProjectKey
 }
 
+func (r *InitPaymentRequest) RequestNonce() string {
+	return r.Nonce
+}
+
 func (r *InitPaymentRequest) Time() time.Time {
 	return time.Unix(r.Timestamp, 0)
 }
 
 func (r *InitPaymentRequest) ReadJSON(rd io.Reader) error {
-	dec := json.NewDecoder(rd)
-	err := dec.Decode(r)
-	return err
+	return r.ReadJSONLimited(rd, 0)
+}
+
+// ReadJSONLimited reads and decodes the request
+//
+// Reading fails with jsonutil.ErrTooManyEntries as soon as an object of the
+// request, e.g. the metadata, has more than maxEntries entries. A
+// maxEntries <= 0 disables the limit.
+func (r *InitPaymentRequest) ReadJSONLimited(rd io.Reader, maxEntries int) error {
+	var err error
+	r.rawJSON, err = jsonutil.ReadLimited(rd, maxEntries)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(r.rawJSON, r)
+}
+
+// JSONPayload returns the received JSON document
+func (r *InitPaymentRequest) JSONPayload() []byte {
+	return r.rawJSON
 }
 
 func (r *InitPaymentRequest) PopulatePaymentFields(p *payment.Payment) {
@@ -269,9 +299,15 @@ func (r *InitPaymentRequest) PopulatePaymentFields(p *payment.Payment) {
 		t := time.Unix(r.Expires, 0)
 		p.Config.SetExpires(t)
 	}
+	if r.StatementDescriptor != "" {
+		p.Config.SetStatementDescriptor(r.StatementDescriptor)
+	}
 	if r.Metadata != nil {
 		p.Metadata = r.Metadata
 	}
+	if r.Note != "" {
+		p.Note = p.NewNote(r.Note, "")
+	}
 }
 
 // InitPaymentResponse is the JSON response struct for POST /payment
@@ -290,6 +326,8 @@ type InitPaymentResponse struct {
 		CallbackProjectKey string            `json:",omitempty"`
 		ReturnURL          string            `json:",omitempty"`
 		Expires            int64             `json:",string,omitempty"`
+		ParentPaymentId    string            `json:",omitempty"`
+		Relation           string            `json:",omitempty"`
 		Metadata           map[string]string `json:",omitempty"`
 	}
 	Payment struct {
@@ -298,6 +336,8 @@ type InitPaymentResponse struct {
 		Created     string
 		Token       string
 		RedirectURL string `json:",omitempty"`
+		// Reference is not part of the signature
+		Reference string `json:",omitempty"`
 	}
 	Timestamp int64 `json:",string"`
 	Nonce     string
@@ -408,6 +448,16 @@ func (r *InitPaymentResponse) Message() ([]byte, error) {
 			return nil, fmt.Errorf("buffer error: %v", err)
 		}
 	}
+	if r.Confirmation.ParentPaymentId != "" {
+		_, err = buf.WriteString(r.Confirmation.ParentPaymentId)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+		_, err = buf.WriteString(r.Confirmation.Relation)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
 	if r.Confirmation.Metadata != nil {
 		err = maputil.WriteSortedMap(buf, r.Confirmation.Metadata)
 		if err != nil {
@@ -442,6 +492,19 @@ func (r *InitPaymentResponse) Message() ([]byte, error) {
 	return s, nil
 }
 
+// errInitPaymentRejected rolls back the payment writes of a request which was
+// answered with an error response
+var errInitPaymentRejected = errors.New("payment rejected")
+
+// retryInitPayment returns true if the payment writes failed on a lock error
+// and should be retried
+func retryInitPayment(err error) bool {
+	if errors.Is(err, paymentService.ErrDBLockTimeout) || err == service.ErrWriteBatchAborted {
+		return true
+	}
+	return sqldialect.IsDeadlock(err)
+}
+
 func (a *PaymentAPI) InitPayment() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -463,8 +526,13 @@ func (a *PaymentAPI) InitPayment() http.Handler {
 			}
 		}()
 		req := &InitPaymentRequest{}
-		err := req.ReadJSON(r.Body)
+		err := req.ReadJSONLimited(r.Body, a.ctx.BodyLimits().Entries())
 		if err != nil {
+			if err == jsonutil.ErrTooManyEntries {
+				resp = ErrTooLarge
+				resp.Info = "request exceeds " + strconv.Itoa(a.ctx.BodyLimits().Entries()) + " entries"
+				return
+			}
 			resp = ErrReadJson
 			if Debug {
 				resp.Info = err.Error()
@@ -473,12 +541,11 @@ func (a *PaymentAPI) InitPayment() http.Handler {
 		}
 		err = req.Validate()
 		if err != nil {
-			resp = ErrInval
-			resp.Info = err.Error()
+			resp = invalidRequestResponse(r, err)
 			return
 		}
 		var projectKey *project.Projectkey
-		if projectKey = a.authenticateRequest(req, log, w); projectKey == nil {
+		if projectKey = a.authenticateRequest(r, req, log, w); projectKey == nil {
 			responseWritten = true
 			return
 		}
@@ -522,6 +589,22 @@ func (a *PaymentAPI) InitPayment() http.Handler {
 		}
 		req.PopulatePaymentFields(p)
 
+		// parent payment
+		if req.ParentPaymentId != "" {
+			// validated in req.Validate()
+			parentID, _ := payment.ParsePaymentIDStr(req.ParentPaymentId)
+			rel := req.Relation
+			if rel == "" {
+				rel = payment.RelationAmendment
+			}
+			err = p.SetParent(a.paymentService.DecodedPaymentID(parentID), rel)
+			if err != nil {
+				resp = ErrInval
+				resp.Info = "invalid ParentPaymentId"
+				return
+			}
+		}
+
 		// callback config
 		if p.Config.HasCallback() {
 			if !p.Config.CallbackURL.Valid || !p.Config.CallbackAPIVersion.Valid || !p.Config.CallbackProjectKey.Valid {
@@ -531,143 +614,214 @@ func (a *PaymentAPI) InitPayment() http.Handler {
 			}
 		}
 
-		// DB
-		var tx *sql.Tx
-		var commit bool
-		// deferred rollback if commit == false
-		defer func() {
-			if tx != nil && !commit {
-				txErr := tx.Rollback()
-				if txErr != nil {
-					log.Crit("error on rollback", log15.Ctx{"err": txErr})
-					resp = ErrDatabase
-					if Debug {
-						resp.Info = fmt.Sprintf("error on rollback: %v", err)
-					}
-				}
-			}
-		}()
-		maxRetries := a.ctx.Config().Database.TransactionMaxRetries
-		var retries int
-	beginTx:
-		if retries >= maxRetries {
-			// no need to roll back
-			commit = true
-			log.Crit("too many retries on tx. aborting...", log15.Ctx{"maxRetries": maxRetries})
-			resp = ErrDatabase
-			return
-		}
-		tx, err = a.ctx.PaymentDB().Begin()
-		if err != nil {
-			commit = true
-			log.Crit("error on begin", log15.Ctx{"err": err})
-			resp = ErrDatabase
-			return
-		}
-
 		// actions on payment service errors
 		handlePaymentServiceErr := func(err error) {
-			switch err {
-			case paymentService.ErrDB:
+			switch {
+			case errors.Is(err, paymentService.ErrDB):
 				resp = ErrDatabase
-			case paymentService.ErrDuplicateIdent:
+			case errors.Is(err, paymentService.ErrDuplicateIdent):
 				resp = ErrConflict
 				resp.Info = "your ident was already used"
+			case errors.Is(err, paymentService.ErrReferenceExhausted):
+				resp = ErrConflict
+				resp.Info = "reference numbers of the project exhausted"
 			default:
 				resp = ErrSystem
-				log.Error("unknown error in payment service")
+				log.Error("unknown error in payment service", log15.Ctx{"err": err})
 			}
 		}
 
-		err = a.paymentService.CreatePayment(tx, p)
-		if err != nil {
-			if err == paymentService.ErrDBLockTimeout {
-				retries++
-				time.Sleep(time.Second)
-				goto beginTx
+		// DB
+		//
+		// the payment is written with the write batcher, which groups the
+		// payment inserts of concurrent requests in ingestion mode
+		var paymentResp *InitPaymentResponse
+		var rejected bool
+		// reject rolls back the writes and responds with the resp set
+		reject := func() error {
+			rejected = true
+			return errInitPaymentRejected
+		}
+		write := func(tx *sql.Tx) error {
+			var err error
+			rejected = false
+			// checkout session
+			var sess *checkout.Session
+			if req.Session != "" {
+				sess, err = checkout.SessionByTokenTx(tx, req.Session)
+				if err != nil {
+					if err == checkout.ErrSessionNotFound {
+						resp = ErrInval
+						resp.Info = "invalid Session"
+						return reject()
+					}
+					log.Error("error retrieving checkout session", log15.Ctx{"err": err})
+					resp = ErrDatabase
+					return reject()
+				}
+				if sess.ProjectID != p.ProjectID() {
+					log.Warn("checkout session of other project requested", log15.Ctx{"sessionProjectID": sess.ProjectID})
+					resp = ErrInval
+					resp.Info = "invalid Session"
+					return reject()
+				}
+				// the session provides defaults for values not given
+				if !p.Config.Locale.Valid && sess.Locale.Valid {
+					p.Config.SetLocale(sess.Locale.String)
+				}
+				if p.Metadata == nil && sess.Metadata != nil {
+					p.Metadata = sess.Metadata
+				}
 			}
-			if err == paymentService.ErrPaymentCallbackConfig {
-				resp = ErrInval
-				resp.Info = "callback config error"
-				return
+
+			err = a.paymentService.CreatePayment(tx, p)
+			if err != nil {
+				if errors.Is(err, paymentService.ErrDBLockTimeout) {
+					return err
+				}
+				if errors.Is(err, paymentService.ErrPaymentCallbackConfig) {
+					resp = ErrInval
+					resp.Info = "callback config error"
+					return reject()
+				}
+				if errors.Is(err, paymentService.ErrPaymentParent) {
+					resp = ErrInval
+					resp.Info = "invalid ParentPaymentId"
+					return reject()
+				}
+				if errors.Is(err, paymentService.ErrPaymentMethodNotFound) || errors.Is(err, paymentService.ErrPaymentMethodConflict) {
+					resp = ErrInval
+					resp.Info = "invalid PaymentMethodId"
+					return reject()
+				}
+				if errors.Is(err, paymentService.ErrPaymentMethodCurrency) {
+					resp = ErrInval
+					resp.Info = "Currency not supported by PaymentMethodId"
+					return reject()
+				}
+				if schemaErr, ok := err.(*metadata.SchemaError); ok {
+					resp = ErrInval
+					resp.Info = "invalid Metadata " + schemaErr.Error()
+					return reject()
+				}
+				if limitErr, ok := err.(*metadata.LimitError); ok {
+					resp = ErrInval
+					resp.Info = "invalid Metadata " + limitErr.Error()
+					return reject()
+				}
+				handlePaymentServiceErr(err)
+				return reject()
 			}
-			handlePaymentServiceErr(err)
-			return
-		}
-		// payment token
-		token, err := a.paymentService.CreatePaymentToken(tx, p)
-		if err != nil {
-			if err == paymentService.ErrDBLockTimeout {
-				retries++
-				time.Sleep(time.Second)
-				goto beginTx
+			if sess != nil {
+				err = a.paymentService.ConvertSession(tx, sess, p)
+				if err != nil {
+					switch {
+					case errors.Is(err, paymentService.ErrDBLockTimeout):
+						return err
+					case errors.Is(err, paymentService.ErrSessionConverted):
+						resp = ErrConflict
+						resp.Info = "session was already converted into a payment"
+					case errors.Is(err, paymentService.ErrSessionExpired):
+						resp = ErrInval
+						resp.Info = "session expired"
+					case errors.Is(err, paymentService.ErrSessionMismatch):
+						resp = ErrInval
+						resp.Info = "payment does not match Session"
+					default:
+						handlePaymentServiceErr(err)
+					}
+					return reject()
+				}
+			}
+			// payment token
+			token, err := a.paymentService.CreatePaymentToken(tx, p)
+			if err != nil {
+				if errors.Is(err, paymentService.ErrDBLockTimeout) {
+					return err
+				}
+				handlePaymentServiceErr(err)
+				return reject()
 			}
-			handlePaymentServiceErr(err)
-			return
-		}
 
-		paymentResp := &InitPaymentResponse{}
-		paymentResp.ConfirmationFromPayment(p)
-		paymentResp.Payment.PaymentId = a.paymentService.EncodedPaymentID(p.PaymentID())
-		paymentResp.Payment.Created = p.Created.UTC().Format(time.RFC3339)
-		paymentResp.Payment.Token = token.Token
+			paymentResp = &InitPaymentResponse{}
+			paymentResp.ConfirmationFromPayment(p)
+			if p.Parent != nil {
+				paymentResp.Confirmation.ParentPaymentId = req.ParentPaymentId
+				paymentResp.Confirmation.Relation = p.Parent.Type
+			}
+			paymentResp.Payment.PaymentId = a.paymentService.EncodedPaymentID(p.PaymentID())
+			paymentResp.Payment.Created = p.Created.UTC().Format(time.RFC3339)
+			paymentResp.Payment.Token = token.Token
+			paymentResp.Payment.Reference = p.Reference
 
-		if projectKey.Project.Config.WebURL.Valid {
-			redirect, err := url.ParseRequestURI(projectKey.Project.Config.WebURL.String)
+			if projectKey.Project.Config.WebURL.Valid {
+				redirect, err := url.ParseRequestURI(projectKey.Project.Config.WebURL.String)
+				if err != nil {
+					log.Error("could not parse project URL", log15.Ctx{
+						"err":    err,
+						"rawURL": projectKey.Project.Config.WebURL.String,
+					})
+					resp = ErrSystem
+					return reject()
+				}
+				redirect, err = a.paymentService.CheckoutURL(projectKey.Project.ID, redirect)
+				if err != nil {
+					handlePaymentServiceErr(err)
+					return reject()
+				}
+				redirectQ := redirect.Query()
+				redirectQ.Set(paymentService.PaymentTokenParam, token.Token)
+				redirect.RawQuery = redirectQ.Encode()
+				paymentResp.Payment.RedirectURL = redirect.String()
+			}
+
+			n, err := nonce.New()
 			if err != nil {
-				log.Error("could not parse project URL", log15.Ctx{
-					"err":    err,
-					"rawURL": projectKey.Project.Config.WebURL.String,
-				})
+				log.Error("error generating nonce", log15.Ctx{"err": err})
 				resp = ErrSystem
-				return
+				return reject()
 			}
-			redirectQ := redirect.Query()
-			redirectQ.Set(paymentService.PaymentTokenParam, token.Token)
-			redirect.RawQuery = redirectQ.Encode()
-			paymentResp.Payment.RedirectURL = redirect.String()
-		}
-
-		n, err := nonce.New()
-		if err != nil {
-			log.Error("error generating nonce", log15.Ctx{"err": err})
-			resp = ErrSystem
-			return
-		}
-		// TODO save nonce
-		paymentResp.Nonce = n.Nonce
-		paymentResp.Timestamp = time.Now().Unix()
+			// TODO save nonce
+			paymentResp.Nonce = n.Nonce
+			paymentResp.Timestamp = time.Now().Unix()
 
-		secret, err := projectKey.SecretBytes()
-		if err != nil {
-			log.Error("error retrieving project secret", log15.Ctx{"err": err})
-			resp = ErrSystem
-			return
-		}
-		sig, err := service.Sign(paymentResp, secret)
-		if err != nil {
-			log.Error("error signing response", log15.Ctx{"err": err})
-			resp = ErrSystem
-			return
+			sig, err := signResponse(projectKey, paymentResp)
+			if err != nil {
+				log.Error("error signing response", log15.Ctx{"err": err})
+				resp = ErrSystem
+				return reject()
+			}
+			paymentResp.Signature = hex.EncodeToString(sig)
+			return nil
 		}
-		paymentResp.Signature = hex.EncodeToString(sig)
-
-		err = tx.Commit()
-		if err != nil {
-			if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-				// lock error
-				if mysqlErr.Number == 1213 {
-					retries++
-					time.Sleep(time.Second)
-					goto beginTx
-				}
+		maxRetries := a.ctx.Config().Database.TransactionMaxRetries
+		for retries := 0; ; retries++ {
+			if retries >= maxRetries {
+				log.Crit("too many retries on tx. aborting...", log15.Ctx{"maxRetries": maxRetries})
+				resp = ErrDatabase
+				return
+			}
+			// the client gave up, no need to create the payment
+			if service.RequestDone(r) {
+				log.Warn("payment abandoned", log15.Ctx{"retries": retries})
+				resp = ErrTimeout
+				return
+			}
+			err = a.ctx.WriteBatcher().Do(p.ProjectID(), write)
+			if err == nil {
+				break
+			}
+			if rejected {
+				return
+			}
+			if retryInitPayment(err) {
+				time.Sleep(time.Second)
+				continue
 			}
-			commit = true
-			log.Crit("error on commit tx", log15.Ctx{"err": err})
+			log.Crit("error on payment tx", log15.Ctx{"err": err})
 			resp = ErrDatabase
 			return
 		}
-		commit = true
 
 		const info = "payment initiated"
 		resp.Status = StatusSuccess
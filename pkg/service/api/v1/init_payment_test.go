@@ -0,0 +1,204 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	jsonutil "github.com/fritzpay/paymentd/pkg/json"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	testProjectKey    = "testkey"
+	testProjectSecret = "0123456789abcdef0123456789abcdef"
+)
+
+func testInitPaymentRequest() *InitPaymentRequest {
+	return &InitPaymentRequest{
+		ProjectKey: testProjectKey,
+		Ident:      "order-1",
+		Amount:     jsonutil.RequiredInt64{Int64: 1990, Set: true},
+		Subunits:   jsonutil.RequiredInt8{Int8: 2, Set: true},
+		Currency:   "EUR",
+		Country:    "DE",
+		Timestamp:  time.Now().Unix(),
+		Nonce:      "nonce",
+	}
+}
+
+// signedBody returns the JSON body of the request signed with the secret
+func signedBody(req *InitPaymentRequest, secret string) []byte {
+	key, err := hex.DecodeString(secret)
+	So(err, ShouldBeNil)
+	sig, err := service.Sign(req, key)
+	So(err, ShouldBeNil)
+	req.HexSignature = hex.EncodeToString(sig)
+	b, err := json.Marshal(req)
+	So(err, ShouldBeNil)
+	return b
+}
+
+func TestInitPaymentHandler(t *testing.T) {
+	Convey("Given a payment API with a cached project key", t, func() {
+		log := log15.New()
+		log.SetHandler(log15.DiscardHandler())
+		ctx, err := service.NewContext(context.Background(), config.DefaultConfig(), log)
+		So(err, ShouldBeNil)
+		paymentSvc, err := paymentService.NewService(ctx)
+		So(err, ShouldBeNil)
+		a, err := NewPaymentAPI(ctx, paymentSvc)
+		So(err, ShouldBeNil)
+		err = a.cacheProjectKey(&project.Projectkey{
+			Key:     testProjectKey,
+			Project: project.Project{ID: 1},
+			Secret:  testProjectSecret,
+			Active:  true,
+		})
+		So(err, ShouldBeNil)
+
+		tests := []struct {
+			name   string
+			method string
+			body   func() []byte
+			status int
+			info   string
+		}{
+			{
+				name:   "a GET request",
+				method: "GET",
+				body:   func() []byte { return nil },
+				status: http.StatusMethodNotAllowed,
+			},
+			{
+				name:   "a malformed body",
+				method: "POST",
+				body:   func() []byte { return []byte("{") },
+				status: http.StatusBadRequest,
+				info:   ErrReadJson.Info,
+			},
+			{
+				name:   "a request without ident",
+				method: "POST",
+				body: func() []byte {
+					req := testInitPaymentRequest()
+					req.Ident = ""
+					return signedBody(req, testProjectSecret)
+				},
+				status: http.StatusBadRequest,
+				info:   "missing Ident",
+			},
+			{
+				name:   "a request with an invalid currency",
+				method: "POST",
+				body: func() []byte {
+					req := testInitPaymentRequest()
+					req.Currency = "EURO"
+					return signedBody(req, testProjectSecret)
+				},
+				status: http.StatusBadRequest,
+				info:   "invalid Currency",
+			},
+			{
+				name:   "a request with a note exceeding the maximum length",
+				method: "POST",
+				body: func() []byte {
+					req := testInitPaymentRequest()
+					req.Note = strings.Repeat("a", payment.NoteMaxLen+1)
+					return signedBody(req, testProjectSecret)
+				},
+				status: http.StatusBadRequest,
+				info:   "invalid Note",
+			},
+			{
+				name:   "a request with an invalid signature format",
+				method: "POST",
+				body: func() []byte {
+					req := testInitPaymentRequest()
+					req.HexSignature = "xyz"
+					b, err := json.Marshal(req)
+					So(err, ShouldBeNil)
+					return b
+				},
+				status: http.StatusBadRequest,
+				info:   "invalid Signature format",
+			},
+			{
+				name:   "a request signed with another secret",
+				method: "POST",
+				body: func() []byte {
+					return signedBody(testInitPaymentRequest(), "fedcba9876543210fedcba9876543210")
+				},
+				status: http.StatusUnauthorized,
+				info:   ErrUnauthorized.Info,
+			},
+			{
+				name:   "a request with an outdated timestamp",
+				method: "POST",
+				body: func() []byte {
+					req := testInitPaymentRequest()
+					req.Timestamp = time.Now().Add(-2 * requestClockSkew).Unix()
+					return signedBody(req, testProjectSecret)
+				},
+				status: http.StatusUnauthorized,
+				info:   "request timestamp exceeds the tolerated clock skew",
+			},
+		}
+		for _, test := range tests {
+			test := test
+			Convey("When the handler is called with "+test.name, func() {
+				r := httptest.NewRequest(test.method, ServicePath+"/payment", bytes.NewReader(test.body()))
+				w := httptest.NewRecorder()
+				a.InitPayment().ServeHTTP(w, r)
+
+				Convey("It should respond with the expected status", func() {
+					So(w.Code, ShouldEqual, test.status)
+				})
+				if test.info != "" {
+					Convey("It should describe the error", func() {
+						resp := ServiceResponse{}
+						So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+						So(resp.Info, ShouldEqual, test.info)
+						So(resp.Version, ShouldEqual, APIVersion)
+					})
+				}
+			})
+		}
+
+		Convey("When the handler is called with an invalid request in german", func() {
+			req := testInitPaymentRequest()
+			req.Ident = ""
+			req.Currency = ""
+			r := httptest.NewRequest("POST", ServicePath+"/payment", bytes.NewReader(signedBody(req, testProjectSecret)))
+			r.Header.Set("Accept-Language", "de-DE,de;q=0.8,en;q=0.4")
+			w := httptest.NewRecorder()
+			a.InitPayment().ServeHTTP(w, r)
+
+			Convey("It should list the localized field errors", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				resp := struct {
+					Info     string
+					Response ValidationResponse
+				}{}
+				So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+				So(resp.Info, ShouldEqual, "Ident fehlt")
+				So(resp.Response.Errors, ShouldResemble, []ValidationError{
+					{Field: "Ident", Code: "missing", Message: "Ident fehlt"},
+					{Field: "Currency", Code: "missing", Message: "Currency fehlt"},
+				})
+			})
+		})
+	})
+}
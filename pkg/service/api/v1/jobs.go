@@ -0,0 +1,168 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/job"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// JobRun is the admin API representation of a job run
+type JobRun struct {
+	ID       int64
+	Job      string
+	Attempt  int
+	Trigger  string
+	Host     string
+	Started  time.Time
+	Finished *time.Time `json:",omitempty"`
+	Status   string
+	Error    string `json:",omitempty"`
+}
+
+func newJobRun(r *job.Run) JobRun {
+	run := JobRun{
+		ID:      r.ID,
+		Job:     r.Job,
+		Attempt: r.Attempt,
+		Trigger: r.Trigger,
+		Host:    r.Host,
+		Started: r.Started,
+		Status:  r.Status,
+		Error:   r.Error,
+	}
+	if !r.Finished.IsZero() {
+		run.Finished = &r.Finished
+	}
+	return run
+}
+
+// JobsRequest returns a handler which lists the background jobs of this
+// instance
+func (a *AdminAPI) JobsRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "JobsRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		jobs := a.ctx.Jobs().Jobs()
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(jobs)) + " jobs found"
+		resp.Response = jobs
+		err := resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// JobRunRequest returns a handler which runs a background job as soon as a
+// worker is available
+func (a *AdminAPI) JobRunRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "JobRunRequest"})
+		if r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		name := mux.Vars(r)["name"]
+		err := a.ctx.Jobs().Enqueue(name)
+		if err != nil {
+			switch err {
+			case service.ErrJobNotFound:
+				resp := ErrNotFound
+				resp.Info = "job not found"
+				resp.Write(w)
+			case service.ErrJobNotRunning:
+				resp := ErrConflict
+				resp.Info = "jobs are not active on this instance"
+				resp.Write(w)
+			default:
+				log.Error("error enqueuing job", log15.Ctx{"err": err})
+				ErrSystem.Write(w)
+			}
+			return
+		}
+		log.Info("job enqueued", log15.Ctx{"job": name})
+
+		resp := AdminAPIResponse{}
+		resp.HttpStatus = http.StatusAccepted
+		resp.Status = StatusSuccess
+		resp.Info = "job " + name + " enqueued"
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// JobRunsRequest returns a handler which lists the recorded job runs, latest
+// first
+//
+// The runs can be filtered by the query parameters "job" and "status".
+func (a *AdminAPI) JobRunsRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "JobRunsRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		q, ok := listQuery(w, r, job.RunListing, log)
+		if !ok {
+			return
+		}
+		// latest runs first unless sorted explicitly
+		if q.Sort == "" {
+			q.Desc = true
+		}
+		params := r.URL.Query()
+		f := job.RunFilter{
+			Job:    params.Get("job"),
+			Status: params.Get("status"),
+		}
+		runs, page, err := job.RunsDB(a.ctx.PaymentDB(service.ReadOnly), f, q)
+		if err != nil {
+			log.Error("error retrieving job runs", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		results := make([]JobRun, len(runs))
+		for i, run := range runs {
+			results[i] = newJobRun(run)
+		}
+		items, ok := selectFields(w, q, results)
+		if !ok {
+			return
+		}
+		setPageHeader(w, r, q, page)
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(runs)) + " runs found"
+		resp.Response = items
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
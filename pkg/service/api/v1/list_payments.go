@@ -0,0 +1,353 @@
+package v1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	notification "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// paymentListDefaultLimit is the number of payments on a page if no limit
+	// was requested
+	paymentListDefaultLimit = 20
+	// paymentListMaxLimit is the maximum number of payments on a page
+	paymentListMaxLimit = 100
+)
+
+// ListPaymentsRequest represents a request to list the payments of a project
+//
+// Canonical JSON signatures of the request cover the JSON object of its
+// parameters, e.g. {"Currency":"EUR","Nonce":"...","ProjectKey":"...","Timestamp":"..."}
+type ListPaymentsRequest struct {
+	ProjectKey   string
+	Status       string `json:",omitempty"`
+	Currency     string `json:",omitempty"`
+	CreatedFrom  int64  `json:",string,omitempty"`
+	CreatedUntil int64  `json:",string,omitempty"`
+	IdentPrefix  string `json:",omitempty"`
+	Limit        int64  `json:",string,omitempty"`
+	Cursor       string `json:",omitempty"`
+	Timestamp    int64  `json:",string"`
+	Nonce        string
+	hexSignature string
+}
+
+func (r *ListPaymentsRequest) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	_, err = buf.WriteString(r.ProjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	for _, s := range []string{r.Status, r.Currency} {
+		_, err = buf.WriteString(s)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	for _, i := range []int64{r.CreatedFrom, r.CreatedUntil} {
+		if i == 0 {
+			continue
+		}
+		_, err = buf.WriteString(strconv.FormatInt(i, 10))
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	_, err = buf.WriteString(r.IdentPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	if r.Limit != 0 {
+		_, err = buf.WriteString(strconv.FormatInt(r.Limit, 10))
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	_, err = buf.WriteString(r.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *ListPaymentsRequest) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+func (r *ListPaymentsRequest) Signature() ([]byte, error) {
+	return hex.DecodeString(r.hexSignature)
+}
+
+func (r *ListPaymentsRequest) RequestProjectKey() string {
+	return r.ProjectKey
+}
+
+func (r *ListPaymentsRequest) RequestNonce() string {
+	return r.Nonce
+}
+
+func (r *ListPaymentsRequest) Time() time.Time {
+	return time.Unix(r.Timestamp, 0)
+}
+
+func (r *ListPaymentsRequest) ReadFromRequest(req *http.Request) error {
+	var err error
+	q := req.URL.Query()
+	r.ProjectKey = q.Get("ProjectKey")
+	if r.ProjectKey == "" {
+		return errors.New("no project key")
+	}
+	r.Status = q.Get("Status")
+	r.Currency = q.Get("Currency")
+	r.IdentPrefix = q.Get("IdentPrefix")
+	r.Cursor = q.Get("Cursor")
+	ints := []struct {
+		name string
+		v    *int64
+	}{
+		{"CreatedFrom", &r.CreatedFrom},
+		{"CreatedUntil", &r.CreatedUntil},
+		{"Limit", &r.Limit},
+	}
+	for _, i := range ints {
+		if q.Get(i.name) == "" {
+			continue
+		}
+		*i.v, err = strconv.ParseInt(q.Get(i.name), 10, 64)
+		if err != nil || *i.v < 0 {
+			return fmt.Errorf("invalid %s", i.name)
+		}
+	}
+	r.Timestamp, err = strconv.ParseInt(q.Get("Timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %v", err)
+	}
+	r.Nonce = q.Get("Nonce")
+	if r.Nonce == "" {
+		return errors.New("no nonce")
+	}
+	r.hexSignature = q.Get("Signature")
+	return nil
+}
+
+// filter returns the payment filter for the requested project
+func (r *ListPaymentsRequest) filter(projectID int64) (payment.PaymentFilter, error) {
+	f := payment.PaymentFilter{
+		ProjectID:   projectID,
+		Status:      payment.PaymentTransactionStatus(r.Status),
+		Currency:    r.Currency,
+		IdentPrefix: r.IdentPrefix,
+	}
+	if r.CreatedFrom != 0 {
+		f.CreatedFrom = time.Unix(r.CreatedFrom, 0)
+	}
+	if r.CreatedUntil != 0 {
+		f.CreatedUntil = time.Unix(r.CreatedUntil, 0)
+	}
+	if !f.CreatedFrom.IsZero() && !f.CreatedUntil.IsZero() && !f.CreatedFrom.Before(f.CreatedUntil) {
+		return f, errors.New("CreatedFrom must be before CreatedUntil")
+	}
+	return f, nil
+}
+
+// query returns the listing query of the request
+//
+// Payments are listed newest first. The cursor key is the encoded payment id of
+// the last payment of the previous page.
+func (r *ListPaymentsRequest) query() (*listing.Query, error) {
+	q := listing.NewQuery()
+	q.Desc = true
+	q.Limit = paymentListDefaultLimit
+	if r.Limit != 0 {
+		if r.Limit > paymentListMaxLimit {
+			return nil, listing.ErrInvalidLimit
+		}
+		q.Limit = int(r.Limit)
+	}
+	if r.Cursor != "" {
+		var err error
+		q.Cursor, err = listing.DecodeCursor(r.Cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// PaymentListResponse is the JSON response struct for payment list requests
+//
+// Every payment is signed individually. The signature of the response covers
+// the signatures of the payments.
+type PaymentListResponse struct {
+	Payments   []*notification.Notification
+	NextCursor string `json:",omitempty"`
+	Timestamp  int64  `json:",string"`
+	Nonce      string
+	Signature  string
+}
+
+// HashFunc returns the hash function for signing a payment list response
+func (r *PaymentListResponse) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+// Returns the signature base string
+//
+// implementing SignableMessage
+func (r *PaymentListResponse) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	for _, not := range r.Payments {
+		_, err = buf.WriteString(not.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	_, err = buf.WriteString(r.NextCursor)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ListPayments returns a handler for listing the payments of the project
+//
+// Payments can be filtered by status, currency, creation time and ident prefix.
+// Pages are requested with the cursor of the previous page.
+func (a *PaymentAPI) ListPayments() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{
+			"method": "ListPayments",
+		})
+		ctx := requestContext(a.ctx, r)
+		req := &ListPaymentsRequest{}
+		err := req.ReadFromRequest(r)
+		if err != nil {
+			ret := ErrReadParam
+			if Debug {
+				ret.Info = err.Error()
+			}
+			ret.Write(w)
+			return
+		}
+		var projectKey *project.Projectkey
+		if projectKey = a.authenticateRequest(r, req, log, w); projectKey == nil {
+			return
+		}
+		f, err := req.filter(projectKey.Project.ID)
+		if err != nil {
+			ret := ErrInval
+			ret.Info = err.Error()
+			ret.Write(w)
+			return
+		}
+		q, err := req.query()
+		if err != nil {
+			ret := ErrInval
+			ret.Info = err.Error()
+			ret.Write(w)
+			return
+		}
+		// cursors hold the encoded payment id
+		if q.Cursor != nil {
+			id, err := strconv.ParseInt(q.Cursor.Key, 10, 64)
+			if err != nil {
+				ret := ErrInval
+				ret.Info = listing.ErrInvalidCursor.Error()
+				ret.Write(w)
+				return
+			}
+			q.Cursor.Key = strconv.FormatInt(a.paymentService.DecodedPaymentID(payment.PaymentID{PaymentID: id}).PaymentID, 10)
+		}
+
+		tx, err := service.BeginRequestTx(r, a.ctx.ProjectPaymentDB(projectKey.Project.ID, service.ReadOnly))
+		if err != nil && requestDone(w, r) {
+			return
+		}
+		if err != nil {
+			log.Error("error on begin tx", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		payments, page, err := payment.PaymentListTx(ctx, tx, f, q)
+		tx.Rollback()
+		if err != nil && requestDone(w, r) {
+			return
+		}
+		if err != nil {
+			log.Error("error retrieving payments", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		resp := ServiceResponse{}
+		listResp := &PaymentListResponse{
+			Payments: make([]*notification.Notification, 0, len(payments)),
+		}
+		if page.HasMore {
+			last := a.paymentService.EncodedPaymentID(payments[len(payments)-1].PaymentID())
+			listResp.NextCursor = listing.Cursor{Key: strconv.FormatInt(last.PaymentID, 10)}.Encode()
+		}
+		for _, p := range payments {
+			not := a.paymentNotification(projectKey, p, log, &resp)
+			if not == nil {
+				resp.Write(w)
+				return
+			}
+			listResp.Payments = append(listResp.Payments, not)
+		}
+		non, err := nonce.New()
+		if err != nil {
+			log.Error("error creating nonce", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		listResp.Nonce = non.Nonce
+		listResp.Timestamp = time.Now().Unix()
+		sig, err := signResponse(projectKey, listResp)
+		if err != nil {
+			log.Error("error signing response", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		listResp.Signature = hex.EncodeToString(sig)
+
+		resp.Status = StatusSuccess
+		resp.HttpStatus = http.StatusOK
+		resp.Info = strconv.Itoa(len(payments)) + " payments"
+		resp.Response = listResp
+		resp.Write(w)
+	})
+}
@@ -0,0 +1,63 @@
+package v1
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// listQuery parses the listing parameters of the request and validates them
+// against the given listing
+//
+// On error, the error response will be written and the returned bool will be
+// false.
+func listQuery(w http.ResponseWriter, r *http.Request, b listing.Builder, log log15.Logger) (*listing.Query, bool) {
+	q, err := listing.ParseQuery(r.URL.Query())
+	if err == nil {
+		_, err = b.SortField(q)
+	}
+	if err != nil {
+		log.Info("invalid listing parameters", log15.Ctx{"err": err})
+		resp := ErrReadParam
+		resp.Info = err.Error()
+		resp.Write(w)
+		return nil, false
+	}
+	return q, true
+}
+
+// selectFields applies the field selection of the query to the items
+//
+// On error, the error response will be written and the returned bool will be
+// false.
+func selectFields(w http.ResponseWriter, q *listing.Query, items interface{}) (interface{}, bool) {
+	sel, err := listing.SelectFields(items, q.Fields)
+	if err != nil {
+		resp := ErrReadParam
+		resp.Info = err.Error()
+		resp.Write(w)
+		return nil, false
+	}
+	return sel, true
+}
+
+// setPageHeader sets the Link header pointing to the next page
+func setPageHeader(w http.ResponseWriter, r *http.Request, q *listing.Query, page listing.Page) {
+	if !page.HasMore {
+		return
+	}
+	next := *q
+	next.Cursor, _ = listing.DecodeCursor(page.NextCursor)
+	// keep other parameters like filters
+	v := r.URL.Query()
+	for k, val := range next.Values() {
+		v[k] = val
+	}
+	u := url.URL{
+		Path:     r.URL.Path,
+		RawQuery: v.Encode(),
+	}
+	w.Header().Set("Link", "<"+u.String()+">; rel=\"next\"")
+}
@@ -0,0 +1,68 @@
+package v1
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// clientSubject returns the lockout subject for the client address of the request
+func clientSubject(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// lockedOut writes an ErrLockedOut response if authentication attempts of one of
+// the subjects are currently refused
+//
+// Errors of the lockout tracker will be logged and will not refuse attempts.
+func lockedOut(ctx *service.Context, w http.ResponseWriter, log log15.Logger, subjects ...string) bool {
+	var wait time.Duration
+	for _, s := range subjects {
+		d, err := ctx.Lockout().Check(s)
+		if err != nil {
+			log.Error("error checking lockout", log15.Ctx{"err": err, "subject": s})
+			continue
+		}
+		if d > wait {
+			wait = d
+		}
+	}
+	if wait <= 0 {
+		return false
+	}
+	secs := int(wait / time.Second)
+	if wait%time.Second != 0 {
+		secs++
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	ErrLockedOut.Write(w)
+	return true
+}
+
+// authFailed records an authentication failure of the subjects
+func authFailed(ctx *service.Context, log log15.Logger, subjects ...string) {
+	for _, s := range subjects {
+		_, err := ctx.Lockout().Fail(s)
+		if err != nil {
+			log.Error("error recording authentication failure", log15.Ctx{"err": err, "subject": s})
+		}
+	}
+}
+
+// authSucceeded clears the authentication failures of the subjects
+func authSucceeded(ctx *service.Context, log log15.Logger, subjects ...string) {
+	for _, s := range subjects {
+		err := ctx.Lockout().Reset(s)
+		if err != nil {
+			log.Error("error resetting authentication failures", log15.Ctx{"err": err, "subject": s})
+		}
+	}
+}
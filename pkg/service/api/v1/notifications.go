@@ -0,0 +1,261 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/outbox"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Notification is the admin API representation of a notification in the
+// notification outbox
+type Notification struct {
+	ID        int64
+	Created   time.Time
+	ProjectID int64
+	Event     string
+	// PaymentId and TransactionTimestamp identify the payment transaction of
+	// payment notifications
+	PaymentId            *payment.PaymentID `json:",omitempty"`
+	TransactionTimestamp string             `json:",omitempty"`
+	// Data is the data of event notifications
+	Data          map[string]string `json:",omitempty"`
+	Status        string
+	StatusChanged time.Time
+	Attempts      int
+	// NextAttempt is the time of the next delivery of pending notifications
+	NextAttempt *time.Time `json:",omitempty"`
+	// Error is the error of the last failed delivery attempt
+	Error     string `json:",omitempty"`
+	CreatedBy string
+}
+
+func (a *AdminAPI) notification(n *outbox.Notification) Notification {
+	not := Notification{
+		ID:            n.ID,
+		Created:       n.Created,
+		ProjectID:     n.ProjectID,
+		Event:         n.Event,
+		Data:          n.Data,
+		Status:        n.Status.Status,
+		StatusChanged: n.Status.Timestamp,
+		Attempts:      n.Status.Attempts,
+		Error:         n.Status.Error.String,
+		CreatedBy:     n.Status.CreatedBy,
+	}
+	if n.PaymentID.Valid {
+		id := a.paymentService.EncodedPaymentID(payment.PaymentID{
+			ProjectID: n.ProjectID,
+			PaymentID: n.PaymentID.Int64,
+		})
+		not.PaymentId = &id
+	}
+	if n.TransactionTimestamp.Valid {
+		not.TransactionTimestamp = strconv.FormatInt(n.TransactionTimestamp.Int64, 10)
+	}
+	if n.Pending() {
+		next := n.Status.NextAttempt
+		not.NextAttempt = &next
+	}
+	return not
+}
+
+// ProjectNotificationsRequest returns a handler which lists the notifications
+// of a project in the notification outbox by status
+func (a *AdminAPI) ProjectNotificationsRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectNotificationsRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = outbox.StatusFailed
+		case outbox.StatusPending, outbox.StatusDelivered, outbox.StatusSuperseded, outbox.StatusFailed:
+		default:
+			resp := ErrReadParam
+			resp.Info = "invalid status " + status
+			resp.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID})
+		q, ok := listQuery(w, r, outbox.NotificationListing, log)
+		if !ok {
+			return
+		}
+		// latest notifications first unless sorted explicitly
+		if q.Sort == "" {
+			q.Desc = true
+		}
+		list, page, err := outbox.NotificationsByProjectIDAndStatusDB(a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, status, q)
+		if err != nil {
+			log.Error("error retrieving notifications", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		notifications := make([]Notification, len(list))
+		for i, n := range list {
+			notifications[i] = a.notification(n)
+		}
+		items, ok := selectFields(w, q, notifications)
+		if !ok {
+			return
+		}
+		setPageHeader(w, r, q, page)
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(list)) + " " + status + " notifications found"
+		resp.Response = items
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) notificationIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["notificationid"], 10, 64)
+	if err != nil {
+		ErrReadParam.Write(w)
+		return 0, false
+	}
+	return id, true
+}
+
+// ProjectNotificationGetRequest returns a handler which returns a notification
+// of a project by ID
+func (a *AdminAPI) ProjectNotificationGetRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectNotificationGetRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		id, ok := a.notificationIDParam(w, r)
+		if !ok {
+			return
+		}
+		n, err := outbox.NotificationByIDDB(a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), id)
+		if err != nil {
+			if err == outbox.ErrNotificationNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving notification", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if n.ProjectID != projectID {
+			ErrNotFound.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "notification found"
+		resp.Response = a.notification(n)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ProjectNotificationRetryRequest returns a handler which queues a notification
+// of a project, which is not pending, for delivery again
+func (a *AdminAPI) ProjectNotificationRetryRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectNotificationRetryRequest"})
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		id, ok := a.notificationIDParam(w, r)
+		if !ok {
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":      projectID,
+			"notificationID": id,
+		})
+		// the project must match before the notification is touched
+		n, err := outbox.NotificationByIDDB(a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), id)
+		if err != nil {
+			if err == outbox.ErrNotificationNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving notification", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if n.ProjectID != projectID {
+			ErrNotFound.Write(w)
+			return
+		}
+		n, err = a.paymentService.RetryNotification(projectID, id, auth[AuthUserIDKey].(string))
+		switch err {
+		case nil:
+		case outbox.ErrNotificationNotFound:
+			ErrNotFound.Write(w)
+			return
+		case paymentService.ErrNotificationPending:
+			resp := ErrConflict
+			resp.Info = "notification is pending"
+			resp.Write(w)
+			return
+		default:
+			log.Error("error retrying notification", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "notification queued for delivery"
+		resp.Response = a.notification(n)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
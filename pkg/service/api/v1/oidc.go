@@ -0,0 +1,278 @@
+package v1
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/oidc"
+	"github.com/fritzpay/paymentd/pkg/paymentd/user"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// oidcUserID is recorded as the creator of users provisioned by the identity
+	// provider
+	oidcUserID = "oidc"
+	// oidcStateCookieName is the cookie holding the state and nonce during a login
+	oidcStateCookieName = "oidc"
+	oidcLoginTimeout    = 10 * time.Minute
+)
+
+// initOIDC sets up the identity provider for admin logins, if configured
+func (a *AdminAPI) initOIDC() error {
+	cfg := a.ctx.Config().API.OIDC
+	if !cfg.Active {
+		return nil
+	}
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return fmt.Errorf("OIDC requires an issuer, client ID and redirect URL")
+	}
+	a.oidcGroupRoles = make(map[string]user.Grants, len(cfg.GroupRoles))
+	for group, roles := range cfg.GroupRoles {
+		for _, r := range roles {
+			g, err := user.ParseGrant(r)
+			if err != nil {
+				return fmt.Errorf("invalid grant %s for OIDC group %s", r, group)
+			}
+			a.oidcGroupRoles[group] = append(a.oidcGroupRoles[group], g)
+		}
+	}
+	a.oidc = oidc.NewProvider(cfg.Issuer, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL, cfg.Scopes)
+	return nil
+}
+
+// oidcGrants returns the grants for the given groups
+//
+// The grants will be sorted, so they can be compared to the current grants of
+// a user.
+func (a *AdminAPI) oidcGrants(groups []string) user.Grants {
+	set := make(map[string]user.Grant)
+	for _, group := range groups {
+		for _, g := range a.oidcGroupRoles[group] {
+			set[g.String()] = g
+		}
+	}
+	names := make([]string, 0, len(set))
+	for n := range set {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	gs := make(user.Grants, len(names))
+	for i, n := range names {
+		gs[i] = set[n]
+	}
+	return gs
+}
+
+// authenticateOIDC redirects to the login page of the identity provider
+func (a *AdminAPI) authenticateOIDC(w http.ResponseWriter, r *http.Request) {
+	log := a.log.New(log15.Ctx{"method": "authenticateOIDC"})
+	if a.oidc == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	bin := make([]byte, 32)
+	_, err := rand.Read(bin)
+	if err != nil {
+		log.Error("error generating state", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	state, nonce := hex.EncodeToString(bin[:16]), hex.EncodeToString(bin[16:])
+	u, err := a.oidc.AuthCodeURL(state, nonce)
+	if err != nil {
+		log.Error("error retrieving login URL", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state + "." + nonce,
+		Path:     ServicePath + "/authorization/oidc",
+		Expires:  time.Now().Add(oidcLoginTimeout),
+		HttpOnly: true,
+		Secure:   a.ctx.Config().API.Secure,
+	})
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// OIDCCallbackHandler handles the redirect from the identity provider
+//
+// Users will be provisioned on their first login. Their role grants will be
+// synchronized with their groups on each login. Users which were not provisioned
+// by the identity provider can not log in.
+func (a *AdminAPI) OIDCCallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := a.log.New(log15.Ctx{"method": "OIDCCallbackHandler"})
+		if a.oidc == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		c, err := r.Cookie(oidcStateCookieName)
+		if err != nil {
+			if Debug {
+				log.Debug("missing state cookie")
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:    oidcStateCookieName,
+			Path:    ServicePath + "/authorization/oidc",
+			Expires: time.Unix(0, 0),
+		})
+		parts := strings.SplitN(c.Value, ".", 2)
+		state := r.URL.Query().Get("state")
+		if len(parts) != 2 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(state)) != 1 {
+			log.Warn("state mismatch")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if e := r.URL.Query().Get("error"); e != "" {
+			log.Info("login rejected by identity provider", log15.Ctx{"error": e})
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rawToken, err := a.oidc.Exchange(r.URL.Query().Get("code"))
+		if err != nil {
+			if err == oidc.ErrTokenExchange {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			log.Error("error exchanging code", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		claims, err := a.oidc.Verify(rawToken, parts[1])
+		if err != nil {
+			log.Warn("invalid ID token", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		cfg := a.ctx.Config().API.OIDC
+		name := claims.String(cfg.UserClaim)
+		if !user.ValidName(name) || name == systemUserID {
+			log.Warn("invalid user name claim", log15.Ctx{"claim": cfg.UserClaim, "name": name})
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		grants := a.oidcGrants(claims.Strings(cfg.GroupsClaim))
+		if len(grants) == 0 {
+			log.Info("no roles granted to user", log15.Ctx{"name": name})
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		u, status := a.provisionOIDCUser(name, grants, log)
+		if u == nil {
+			w.WriteHeader(status)
+			return
+		}
+
+		sess, err := user.NewSession(u, AuthLifetime)
+		if err != nil {
+			log.Error("error creating session", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sess.RemoteAddr = r.RemoteAddr
+		err = user.InsertSessionDB(a.ctx.PrincipalDB(), sess)
+		if err != nil {
+			log.Error("error saving session", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if cfg.ReturnURL == "" {
+			w.Header().Set("Content-Type", "application/json")
+			a.respondWithAuthorization(w, u.Name, sess)
+			return
+		}
+		auth, err := a.newAuthorization(u.Name, sess)
+		if err != nil {
+			log.Error("error creating authorization", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		authorization, err := auth.Serialized()
+		if err != nil {
+			log.Error("error serializing authorization", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		a.setAuthorizationCookie(w, authorization, auth.Expiry())
+		http.Redirect(w, r, cfg.ReturnURL, http.StatusFound)
+	})
+}
+
+// provisionOIDCUser creates or updates the user authenticated by the identity
+// provider
+//
+// If the user can not log in, the returned user will be nil and status will be
+// the HTTP status for the response.
+func (a *AdminAPI) provisionOIDCUser(name string, grants user.Grants, log log15.Logger) (u *user.User, status int) {
+	var tx *sql.Tx
+	var commit bool
+	var err error
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = a.ctx.PrincipalDB().Begin()
+	if err != nil {
+		commit = true
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		return nil, http.StatusInternalServerError
+	}
+	u, err = user.UserByNameTx(tx, name)
+	switch err {
+	case nil:
+		if u.CreatedBy != oidcUserID {
+			log.Warn("local user can not log in with identity provider", log15.Ctx{"name": name})
+			return nil, http.StatusForbidden
+		}
+		if !u.Config.Active {
+			log.Info("inactive user", log15.Ctx{"name": name})
+			return nil, http.StatusUnauthorized
+		}
+		if u.Config.Roles.Equal(grants) {
+			break
+		}
+		u.Config.Roles = grants
+		u.Config.CreatedBy = oidcUserID
+		err = user.InsertConfigTx(tx, u)
+	case user.ErrUserNotFound:
+		u = &user.User{
+			Name:      name,
+			CreatedBy: oidcUserID,
+		}
+		u.Config.Active = true
+		u.Config.Roles = grants
+		err = user.InsertUserTx(tx, u)
+	}
+	if err != nil {
+		log.Error("error saving user", log15.Ctx{"err": err})
+		return nil, http.StatusInternalServerError
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		return nil, http.StatusInternalServerError
+	}
+	return u, http.StatusOK
+}
@@ -0,0 +1,676 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"github.com/fritzpay/paymentd/pkg/paymentd/onboarding"
+	"github.com/fritzpay/paymentd/pkg/paymentd/principal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// mails of the onboarding steps
+const (
+	onboardingSubmittedSubject = "Your application %d was received"
+	onboardingSubmittedBody    = `Hello %s,
+
+we received your application for the principal %s and the project %s. We will
+review it and notify you of the outcome.
+
+Your application number is %d.
+`
+	onboardingNotifySubject = "New onboarding application %d"
+	onboardingNotifyBody    = `A new onboarding application is waiting for a review.
+
+Application: %d
+Principal:   %s
+Project:     %s
+Company:     %s
+Contact:     %s <%s>
+Website:     %s
+`
+	onboardingApprovedSubject = "Your application %d was approved"
+	onboardingApprovedBody    = `Hello %s,
+
+your application was approved. Your project %s is ready:
+
+Project ID:  %d
+Project key: %s
+
+The secret of the project key will be handed over to you separately.
+%s`
+	onboardingRejectedSubject = "Your application %d was rejected"
+	onboardingRejectedBody    = `Hello %s,
+
+we are sorry, your application for the project %s was rejected.
+%s`
+)
+
+// OnboardingApplicationRequest is the request body for submitting an
+// application
+type OnboardingApplicationRequest struct {
+	PrincipalName string
+	ProjectName   string
+	Company       string
+	ContactName   string
+	Email         string
+	WebURL        string
+}
+
+// OnboardingApplication is the API representation of an application
+type OnboardingApplication struct {
+	ID            int64
+	Created       time.Time
+	PrincipalName string
+	ProjectName   string
+	Company       string
+	ContactName   string
+	Email         string
+	WebURL        string `json:",omitempty"`
+	Status        string
+	StatusChanged time.Time
+	// IDs of the principal and the project provisioned for an approved
+	// application
+	PrincipalID int64  `json:",omitempty"`
+	ProjectID   int64  `json:",omitempty"`
+	ReviewedBy  string `json:",omitempty"`
+	Comment     string `json:",omitempty"`
+}
+
+func onboardingApplication(app *onboarding.Application) OnboardingApplication {
+	out := OnboardingApplication{
+		ID:            app.ID,
+		Created:       app.Created,
+		PrincipalName: app.PrincipalName,
+		ProjectName:   app.ProjectName,
+		Company:       app.Company,
+		ContactName:   app.ContactName,
+		Email:         app.Email,
+		WebURL:        app.WebURL.String,
+		Status:        app.Status.Status,
+		StatusChanged: app.Status.Timestamp,
+		PrincipalID:   app.Status.PrincipalID.Int64,
+		ProjectID:     app.Status.ProjectID.Int64,
+		Comment:       app.Status.Comment.String,
+	}
+	if !app.Submitted() {
+		out.ReviewedBy = app.Status.CreatedBy
+	}
+	return out
+}
+
+// OnboardingReviewRequest is the request body for approving or rejecting an
+// application
+//
+// The comment will be sent to the applicant.
+type OnboardingReviewRequest struct {
+	Comment string
+}
+
+// OnboardingApprovalResponse is the response of an approval
+//
+// The secret of the project key will only be returned here. It is not sent to
+// the applicant.
+type OnboardingApprovalResponse struct {
+	Application OnboardingApplication
+	ProjectKey  string
+	Secret      string
+}
+
+// onboardingProjectConfig returns the project config of the projects of
+// approved applications
+func onboardingProjectConfig(cfg *config.Config) (project.Config, error) {
+	c := project.Config{}
+	if len(cfg.API.Onboarding.ProjectConfig) == 0 {
+		return c, nil
+	}
+	err := json.Unmarshal(cfg.API.Onboarding.ProjectConfig, &c)
+	return c, err
+}
+
+// OnboardingApplicationHandler returns a handler for submitting applications of
+// prospective merchants
+//
+// POST submits an application. The applicant will receive a confirmation and
+// the operators will be notified.
+func OnboardingApplicationHandler(ctx *service.Context) http.Handler {
+	log := ctx.Log().New(log15.Ctx{
+		"pkg":    "github.com/fritzpay/paymentd/pkg/service/api/v1",
+		"method": "OnboardingApplicationHandler",
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "POST" {
+			ErrMethod.Write(w)
+			return
+		}
+		reqCtx := requestContext(ctx, r)
+		req := OnboardingApplicationRequest{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			if Debug {
+				log.Debug("json decode failed", log15.Ctx{"err": err})
+			}
+			ErrReadJson.Write(w)
+			return
+		}
+		app := &onboarding.Application{
+			PrincipalName: req.PrincipalName,
+			ProjectName:   req.ProjectName,
+			Company:       req.Company,
+			ContactName:   req.ContactName,
+			Email:         req.Email,
+		}
+		app.WebURL.String, app.WebURL.Valid = req.WebURL, req.WebURL != ""
+		err = app.Validate()
+		if err != nil {
+			resp := ErrInval
+			resp.Info = err.Error()
+			resp.Write(w)
+			return
+		}
+		log := log.New(log15.Ctx{"principalName": app.PrincipalName})
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = ctx.PrincipalDB().Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		_, err = principal.PrincipalByNameTx(tx, app.PrincipalName)
+		if err != principal.ErrPrincipalNotFound {
+			if err != nil {
+				log.Error("error retrieving principal", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			resp := ErrConflict
+			resp.Info = "principal name not available"
+			resp.Write(w)
+			return
+		}
+		submitted, err := onboarding.SubmittedByPrincipalNameTx(reqCtx, tx, app.PrincipalName)
+		if err != nil {
+			log.Error("error retrieving applications", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if submitted {
+			resp := ErrConflict
+			resp.Info = "an application for the principal name is waiting for a review"
+			resp.Write(w)
+			return
+		}
+		err = onboarding.InsertApplicationTx(reqCtx, tx, app)
+		if err != nil {
+			log.Error("error saving application", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		log.Info("application submitted", log15.Ctx{"applicationID": app.ID})
+
+		ctx.Mailer().Send(&service.Mail{
+			To:      []string{app.Email},
+			Subject: fmt.Sprintf(onboardingSubmittedSubject, app.ID),
+			Body:    fmt.Sprintf(onboardingSubmittedBody, app.ContactName, app.PrincipalName, app.ProjectName, app.ID),
+		})
+		ctx.Mailer().Send(&service.Mail{
+			To:      ctx.Config().API.Onboarding.NotifyAddresses,
+			Subject: fmt.Sprintf(onboardingNotifySubject, app.ID),
+			Body:    fmt.Sprintf(onboardingNotifyBody, app.ID, app.PrincipalName, app.ProjectName, app.Company, app.ContactName, app.Email, app.WebURL.String),
+		})
+
+		resp := ServiceResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "application submitted"
+		resp.Response = struct {
+			ID     int64
+			Status string
+		}{app.ID, app.Status.Status}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+}
+
+// OnboardingApplicationsRequest returns a handler which lists the applications
+// by status
+//
+// Without a status, the applications waiting for a review will be listed.
+func (a *AdminAPI) OnboardingApplicationsRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "OnboardingApplicationsRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		ctx := requestContext(a.ctx, r)
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = onboarding.StatusSubmitted
+		}
+		q, ok := listQuery(w, r, onboarding.ApplicationListing, log)
+		if !ok {
+			return
+		}
+		list, page, err := onboarding.ApplicationsByStatusDB(ctx, a.ctx.PrincipalDB(service.ReadOnly), status, q)
+		if err != nil {
+			log.Error("error retrieving applications", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		apps := make([]OnboardingApplication, len(list))
+		for i, app := range list {
+			apps[i] = onboardingApplication(app)
+		}
+		items, ok := selectFields(w, q, apps)
+		if !ok {
+			return
+		}
+		setPageHeader(w, r, q, page)
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(list)) + " " + status + " applications found"
+		resp.Response = items
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) applicationIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["applicationid"], 10, 64)
+	if err != nil {
+		ErrReadParam.Write(w)
+		return 0, false
+	}
+	return id, true
+}
+
+// OnboardingApplicationGetRequest returns a handler which returns an
+// application by ID
+func (a *AdminAPI) OnboardingApplicationGetRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "OnboardingApplicationGetRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		ctx := requestContext(a.ctx, r)
+		id, ok := a.applicationIDParam(w, r)
+		if !ok {
+			return
+		}
+		app, err := onboarding.ApplicationByIDDB(ctx, a.ctx.PrincipalDB(service.ReadOnly), id)
+		if err != nil {
+			if err == onboarding.ErrApplicationNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving application", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "application found"
+		resp.Response = onboardingApplication(app)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// OnboardingApplicationApproveRequest returns a handler which approves an
+// application
+//
+// Approving provisions the principal, the project with the configured project
+// config and a project key. The applicant will be notified. The secret of the
+// project key is only part of the response.
+func (a *AdminAPI) OnboardingApplicationApproveRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "OnboardingApplicationApproveRequest"})
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		ctx := requestContext(a.ctx, r)
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		userID := auth[AuthUserIDKey].(string)
+		id, ok := a.applicationIDParam(w, r)
+		if !ok {
+			return
+		}
+		req, ok := readOnboardingReview(w, r, log)
+		if !ok {
+			return
+		}
+		projectConfig, err := onboardingProjectConfig(a.ctx.Config())
+		if err != nil {
+			log.Crit("invalid onboarding project config", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{"applicationID": id})
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.PrincipalDB().Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		app, ok := a.submittedApplication(ctx, w, tx, id, log)
+		if !ok {
+			return
+		}
+		_, err = principal.PrincipalByNameTx(tx, app.PrincipalName)
+		if err != principal.ErrPrincipalNotFound {
+			if err != nil {
+				log.Error("error retrieving principal", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			resp := ErrConflict
+			resp.Info = "principal " + app.PrincipalName + " already exists"
+			resp.Write(w)
+			return
+		}
+
+		created := time.Now().UTC().Round(time.Second)
+		pr := principal.Principal{
+			Created:   created,
+			CreatedBy: userID,
+			Name:      app.PrincipalName,
+			Metadata: map[string]string{
+				"Company":     app.Company,
+				"ContactName": app.ContactName,
+				"Email":       app.Email,
+			},
+		}
+		err = principal.InsertPrincipalTx(tx, &pr)
+		if err != nil {
+			log.Error("error saving principal", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = metadata.InsertMetadataTx(tx, principal.MetadataModel, pr.ID, metadata.MetadataFromValues(pr.Metadata, pr.CreatedBy))
+		if err != nil {
+			log.Error("error saving principal metadata", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		proj := project.Project{
+			PrincipalID: pr.ID,
+			Created:     created,
+			CreatedBy:   userID,
+			Name:        app.ProjectName,
+			Config:      projectConfig,
+		}
+		if app.WebURL.Valid && !proj.Config.WebURL.Valid {
+			proj.Config.SetWebURL(app.WebURL.String)
+		}
+		err = project.InsertProjectTx(ctx, tx, &proj)
+		if err != nil {
+			log.Error("error saving project", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if proj.Config.HasValues() {
+			err = project.InsertProjectConfigTx(ctx, tx, &proj)
+			if err != nil {
+				log.Error("error saving project config", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+		}
+		pk, err := project.NewProjectkey(proj, userID)
+		if err != nil {
+			log.Crit("error generating project key", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		err = project.InsertProjectKeyTx(ctx, tx, pk)
+		if err != nil {
+			log.Error("error saving project key", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		st := app.NewStatus(onboarding.StatusApproved, userID)
+		st.PrincipalID.Int64, st.PrincipalID.Valid = pr.ID, true
+		st.ProjectID.Int64, st.ProjectID.Valid = proj.ID, true
+		st.Comment.String, st.Comment.Valid = req.Comment, req.Comment != ""
+		err = onboarding.InsertStatusTx(ctx, tx, app, st)
+		if err != nil {
+			log.Error("error saving application status", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		log.Info("application approved", log15.Ctx{
+			"principalID": pr.ID,
+			"projectID":   proj.ID,
+		})
+
+		a.ctx.Mailer().Send(&service.Mail{
+			To:      []string{app.Email},
+			Subject: fmt.Sprintf(onboardingApprovedSubject, app.ID),
+			Body:    fmt.Sprintf(onboardingApprovedBody, app.ContactName, app.ProjectName, proj.ID, pk.Key, onboardingComment(req.Comment)),
+		})
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "application approved"
+		resp.Response = OnboardingApprovalResponse{
+			Application: onboardingApplication(app),
+			ProjectKey:  pk.Key,
+			Secret:      pk.Secret,
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// OnboardingApplicationRejectRequest returns a handler which rejects an
+// application
+//
+// The applicant will be notified.
+func (a *AdminAPI) OnboardingApplicationRejectRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "OnboardingApplicationRejectRequest"})
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		ctx := requestContext(a.ctx, r)
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		id, ok := a.applicationIDParam(w, r)
+		if !ok {
+			return
+		}
+		req, ok := readOnboardingReview(w, r, log)
+		if !ok {
+			return
+		}
+		log = log.New(log15.Ctx{"applicationID": id})
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.PrincipalDB().Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		app, ok := a.submittedApplication(ctx, w, tx, id, log)
+		if !ok {
+			return
+		}
+		st := app.NewStatus(onboarding.StatusRejected, auth[AuthUserIDKey].(string))
+		st.Comment.String, st.Comment.Valid = req.Comment, req.Comment != ""
+		err = onboarding.InsertStatusTx(ctx, tx, app, st)
+		if err != nil {
+			log.Error("error saving application status", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		log.Info("application rejected")
+
+		a.ctx.Mailer().Send(&service.Mail{
+			To:      []string{app.Email},
+			Subject: fmt.Sprintf(onboardingRejectedSubject, app.ID),
+			Body:    fmt.Sprintf(onboardingRejectedBody, app.ContactName, app.ProjectName, onboardingComment(req.Comment)),
+		})
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "application rejected"
+		resp.Response = onboardingApplication(app)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func readOnboardingReview(w http.ResponseWriter, r *http.Request, log log15.Logger) (OnboardingReviewRequest, bool) {
+	req := OnboardingReviewRequest{}
+	if r.ContentLength == 0 {
+		return req, true
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Error("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return req, false
+	}
+	return req, true
+}
+
+// submittedApplication selects the application for a review
+//
+// It writes the error response if the application is not waiting for a review.
+func (a *AdminAPI) submittedApplication(ctx context.Context, w http.ResponseWriter, tx *sql.Tx, id int64, log log15.Logger) (*onboarding.Application, bool) {
+	app, err := onboarding.ApplicationByIDTx(ctx, tx, id)
+	if err != nil {
+		if err == onboarding.ErrApplicationNotFound {
+			ErrNotFound.Write(w)
+			return nil, false
+		}
+		log.Error("error retrieving application", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return nil, false
+	}
+	if !app.Submitted() {
+		resp := ErrConflict
+		resp.Info = "application was already " + app.Status.Status
+		resp.Write(w)
+		return nil, false
+	}
+	return app, true
+}
+
+// onboardingComment returns the comment of the reviewing operator for a mail
+func onboardingComment(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	return "\n" + comment + "\n"
+}
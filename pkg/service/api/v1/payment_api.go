@@ -1,10 +1,13 @@
 package v1
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/fritzpay/paymentd/pkg/cache"
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
 	"github.com/fritzpay/paymentd/pkg/service"
 	"github.com/fritzpay/paymentd/pkg/service/payment"
@@ -12,7 +15,11 @@ import (
 )
 
 const (
-	requestTimestampMaxAge = 10 * time.Second
+	// requestClockSkew is the default tolerated difference between the
+	// timestamp of signed requests and the server time
+	requestClockSkew = 10 * time.Second
+	// projectKeyCacheTTL is the time for which project keys will be cached
+	projectKeyCacheTTL = 30 * time.Second
 )
 
 // API represents the payment API in the version 1.x
@@ -24,28 +31,109 @@ type PaymentAPI struct {
 }
 
 // NewAPI creates a new payment API
-func NewPaymentAPI(ctx *service.Context) (*PaymentAPI, error) {
+func NewPaymentAPI(ctx *service.Context, paymentService *payment.Service) (*PaymentAPI, error) {
 	p := &PaymentAPI{
 		ctx: ctx,
 		log: ctx.Log().New(log15.Ctx{
 			"pkg": "github.com/fritzpay/paymentd/pkg/service/api/v1",
 			"API": "PaymentAPI",
 		}),
+		paymentService: paymentService,
 	}
-	var err error
-	p.paymentService, err = payment.NewService(ctx)
-	if err != nil {
-		return nil, err
-	}
+	ctx.CacheWarmer().Register("project_keys", p.warmProjectKeys)
 	return p, nil
 }
 
 type ProjectKeyRequester interface {
 	service.Signed
 	RequestProjectKey() string
+	RequestNonce() string
 	Time() time.Time
 }
 
+// projectKey retrieves the project key through the shared cache
+func (a *PaymentAPI) projectKey(key string, log log15.Logger) (*project.Projectkey, error) {
+	cacheKey := "projectkey:" + key
+	if b, err := a.ctx.Cache().Get(cacheKey); err == nil {
+		projectKey := &project.Projectkey{}
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(projectKey)
+		if err == nil {
+			return projectKey, nil
+		}
+		log.Warn("error decoding cached project key", log15.Ctx{"err": err})
+	} else if err != cache.ErrNotFound {
+		log.Error("error retrieving cached project key", log15.Ctx{"err": err})
+	}
+	projectKey, err := project.ProjectKeyByKeyDB(a.ctx, a.ctx.PrincipalDB(service.ReadOnly), key)
+	if err != nil {
+		return nil, err
+	}
+	err = a.cacheProjectKey(projectKey)
+	if err != nil {
+		log.Error("error caching project key", log15.Ctx{"err": err})
+	}
+	return projectKey, nil
+}
+
+func (a *PaymentAPI) cacheProjectKey(projectKey *project.Projectkey) error {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(projectKey)
+	if err != nil {
+		return err
+	}
+	return a.ctx.Cache().Set("projectkey:"+projectKey.Key, buf.Bytes(), projectKeyCacheTTL)
+}
+
+// warmProjectKeys loads the active project keys into the cache
+func (a *PaymentAPI) warmProjectKeys() (int, error) {
+	keys, err := project.ActiveProjectKeysDB(a.ctx, a.ctx.PrincipalDB(service.ReadOnly))
+	if err != nil {
+		return 0, err
+	}
+	for i, projectKey := range keys {
+		err = a.cacheProjectKey(projectKey)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// useNonce marks the nonce of the request as used
+//
+// It returns false if the nonce was already used with the project key within the
+// validity of the request timestamp.
+func (a *PaymentAPI) useNonce(projectKey *project.Projectkey, req ProjectKeyRequester) (bool, error) {
+	ttl := req.Time().Add(clockSkew(projectKey)).Sub(time.Now()) + time.Second
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return a.ctx.Cache().SetNX("nonce:"+projectKey.Key+":"+req.RequestNonce(), []byte{1}, ttl)
+}
+
+// clockSkew returns the tolerated clock skew of requests signed with the
+// project key
+func clockSkew(projectKey *project.Projectkey) time.Duration {
+	return projectKey.Project.Config.ClockSkewTolerance(requestClockSkew)
+}
+
+// timestampValid returns true if the timestamp of the request does not differ
+// from the server time by more than the tolerated clock skew
+func timestampValid(projectKey *project.Projectkey, req ProjectKeyRequester) bool {
+	d := time.Since(req.Time())
+	if d < 0 {
+		d = -d
+	}
+	return d <= clockSkew(projectKey)
+}
+
+// jsonPayloader is a request which was received as a JSON document
+//
+// Canonical JSON signatures of these requests cover the received document.
+type jsonPayloader interface {
+	JSONPayload() []byte
+}
+
 func (a *PaymentAPI) authenticateMessage(projectKey *project.Projectkey, msg service.Signed) (bool, error) {
 	if projectKey == nil || !projectKey.IsValid() {
 		return false, fmt.Errorf("invalid project key: %+v", projectKey)
@@ -54,13 +142,53 @@ func (a *PaymentAPI) authenticateMessage(projectKey *project.Projectkey, msg ser
 	if err != nil {
 		return false, err
 	}
+	if projectKey.CanonicalJSON() {
+		var payload []byte
+		if p, ok := msg.(jsonPayloader); ok {
+			payload = p.JSONPayload()
+		}
+		msg = service.CanonicalSigned(msg, payload)
+	}
 	return service.IsAuthentic(msg, secret)
 }
 
-func (a *PaymentAPI) authenticateRequest(req ProjectKeyRequester, log log15.Logger, w http.ResponseWriter) *project.Projectkey {
-	projectKey, err := project.ProjectKeyByKeyDB(a.ctx.PrincipalDB(service.ReadOnly), req.RequestProjectKey())
+// signResponse signs the response with the project key according to its
+// signature mode
+func signResponse(projectKey *project.Projectkey, msg service.Signable) ([]byte, error) {
+	secret, err := projectKey.SecretBytes()
+	if err != nil {
+		return nil, err
+	}
+	if projectKey.CanonicalJSON() {
+		msg = service.CanonicalSignable(msg, nil)
+	}
+	return service.Sign(msg, secret)
+}
+
+// authenticateRequest returns the authenticated project key of the request
+//
+// Authentication failures will be tracked per project key. Project keys with
+// repeated failures will be locked out temporarily. Authenticated requests will
+// be counted in the API usage of the project.
+//
+// Requests with an ImpersonateHeader will be authenticated as impersonated
+// requests. Requests whose deadline already passed will be answered with a
+// timeout.
+func (a *PaymentAPI) authenticateRequest(r *http.Request, req ProjectKeyRequester, log log15.Logger, w http.ResponseWriter) *project.Projectkey {
+	if requestDone(w, r) {
+		return nil
+	}
+	if r.Header.Get(ImpersonateHeader) != "" {
+		return a.impersonate(r, req, log, w)
+	}
+	subject := "projectkey:" + req.RequestProjectKey()
+	if lockedOut(a.ctx, w, log, subject) {
+		return nil
+	}
+	projectKey, err := a.projectKey(req.RequestProjectKey(), log)
 	if err != nil {
 		if err == project.ErrProjectKeyNotFound {
+			authFailed(a.ctx, log, subject)
 			resp := ErrUnauthorized
 			if Debug {
 				resp.Info = fmt.Sprintf("project key %s not found", req.RequestProjectKey())
@@ -95,14 +223,35 @@ func (a *PaymentAPI) authenticateRequest(req ProjectKeyRequester, log log15.Logg
 			ErrSystem.Write(w)
 			return nil
 		} else if !auth {
+			authFailed(a.ctx, log, subject)
 			ErrUnauthorized.Write(w)
 			return nil
 		}
-		if time.Since(req.Time()) > requestTimestampMaxAge {
+		if !timestampValid(projectKey, req) {
+			log.Info("request timestamp exceeds clock skew", log15.Ctx{
+				"timestamp": req.Time().Unix(),
+				"clockSkew": clockSkew(projectKey),
+			})
+			resp := ErrUnauthorized
+			resp.Info = "request timestamp exceeds the tolerated clock skew"
+			resp.Response = NewTimeResponse(time.Now())
+			resp.Write(w)
+			return nil
+		}
+		unused, err := a.useNonce(projectKey, req)
+		if err != nil {
+			log.Error("error on nonce replay check", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return nil
+		}
+		if !unused {
+			log.Warn("replayed nonce", log15.Ctx{"ProjectKey": projectKey.Key})
+			authFailed(a.ctx, log, subject)
 			ErrUnauthorized.Write(w)
 			return nil
 		}
-		// TODO include nonce handling
+		authSucceeded(a.ctx, log, subject)
 	}
+	service.SetRequestProject(r, projectKey.Project.ID)
 	return projectKey
 }
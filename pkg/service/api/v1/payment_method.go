@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fritzpay/paymentd/pkg/metadata"
@@ -12,6 +14,8 @@ import (
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
 	"github.com/fritzpay/paymentd/pkg/paymentd/provider"
 	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	serviceProvider "github.com/fritzpay/paymentd/pkg/service/provider"
 	"github.com/gorilla/mux"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -26,11 +30,31 @@ type PaymentMethodRequest struct {
 	Metadata  map[string]string
 }
 
+// PaymentMethodResponse is the response JSON struct for GET
+// project/(id)/method/(methodkey)/provider/(provider)
+type PaymentMethodResponse struct {
+	*payment_method.Method
+	// Capabilities are the capabilities of the provider driver, e.g. "capture"
+	Capabilities []string
+	// Displays is the display metadata of all locales
+	Displays []*payment_method.Display
+	// ProjectDisplays is the display metadata set by the project, which
+	// overrides the display metadata in the same language
+	ProjectDisplays []*payment_method.Display
+	// LogoURL is empty if there is no logo
+	LogoURL string
+	// Available is false while the payment method is under maintenance
+	Available bool
+	// Maintenance lists the current and upcoming maintenance windows
+	Maintenance []*payment_method.Maintenance
+}
+
 func (a *AdminAPI) PaymentMethodGetRequest() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
 		log := a.log.New(log15.Ctx{"method": "Project payment methods GET"})
+		ctx := requestContext(a.ctx, r)
 
 		// parameter
 		vars := mux.Vars(r)
@@ -51,8 +75,8 @@ func (a *AdminAPI) PaymentMethodGetRequest() http.Handler {
 		}
 
 		// get payment method
-		db := a.ctx.PaymentDB(service.ReadOnly)
-		pm, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyDB(db, projectID, providerParam, methodKey)
+		db := a.ctx.ProjectPaymentDB(projectID, service.ReadOnly)
+		pm, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyDB(ctx, db, projectID, providerParam, methodKey)
 		if err == payment_method.ErrPaymentMethodNotFound {
 			ErrNotFound.Write(w)
 			log.Error("error retrieving payment method", log15.Ctx{"err": err})
@@ -64,11 +88,40 @@ func (a *AdminAPI) PaymentMethodGetRequest() http.Handler {
 			return
 		}
 
+		pm.Metadata, err = payment_method.PaymentMethodMetadataDB(ctx, db, pm)
+		if err != nil {
+			ErrDatabase.Write(w)
+			log.Error("error retrieving payment method metadata", log15.Ctx{"err": err})
+			return
+		}
+		displays, logoURL, err := a.paymentService.MethodDisplays(pm.Provider.Name, pm.MethodKey)
+		if err != nil {
+			ErrDatabase.Write(w)
+			log.Error("error retrieving display metadata", log15.Ctx{"err": err})
+			return
+		}
+		now := time.Now()
+		maintenance, err := payment_method.MaintenanceByMethodIDDB(ctx, db, pm.ID, now)
+		if err != nil {
+			ErrDatabase.Write(w)
+			log.Error("error retrieving maintenance windows", log15.Ctx{"err": err})
+			return
+		}
+		_, underMaintenance := payment_method.ActiveMaintenance(maintenance, now)
+
 		// return methods
 		resp := ProjectAdminAPIResponse{}
 		resp.HttpStatus = http.StatusOK
 		resp.Info = "paymentmethod found"
-		resp.Response = pm
+		resp.Response = PaymentMethodResponse{
+			Method:          pm,
+			Capabilities:    serviceProvider.Capabilities(pm.Provider.Name),
+			Displays:        displays,
+			ProjectDisplays: pm.ProjectDisplays(),
+			LogoURL:         logoURL,
+			Available:       pm.Active() && !underMaintenance,
+			Maintenance:     maintenance,
+		}
 		resp.Write(w)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -103,6 +156,7 @@ func (a *AdminAPI) PaymentMethodRequest() http.Handler {
 }
 
 func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(a.ctx, r)
 	log := a.log.New(log15.Ctx{"method": "PaymentMethod PUT Request"})
 	// get parameters
 	// projectid and methodname
@@ -130,7 +184,7 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 		log.Error("error on begin", log15.Ctx{"err": err})
 	}
 
-	proj, err := project.ProjectByPrincipalIDandIDDB(db, principalID, projectID)
+	proj, err := project.ProjectByPrincipalIDandIDDB(ctx, db, principalID, projectID)
 	if err != nil && err != project.ErrProjectNotFound {
 		ErrDatabase.Write(w)
 		log.Error("database request failed", log15.Ctx{"err": err})
@@ -165,8 +219,8 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 	// get Provider
-	tx, err = a.ctx.PaymentDB().Begin()
-	prov, err := provider.ProviderByNameTx(tx, pmr.Provider)
+	tx, err = a.ctx.ProjectPaymentDB(projectID).Begin()
+	prov, err := provider.ProviderByNameTx(ctx, tx, pmr.Provider)
 	if err != nil {
 		commit = true
 		ErrDatabase.Write(w)
@@ -193,7 +247,7 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 	pm.Metadata = pmr.Metadata
 
 	// check if payment_method already exists
-	_, err = payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(tx, pm.ProjectID, pm.Provider.Name, pm.MethodKey)
+	_, err = payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(ctx, tx, pm.ProjectID, pm.Provider.Name, pm.MethodKey)
 	if err != nil && err != payment_method.ErrPaymentMethodNotFound {
 		ErrDatabase.Write(w)
 		log.Error("database error", log15.Ctx{"err": err})
@@ -205,7 +259,7 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// insert method
-	err = payment_method.InsertPaymentMethodTx(tx, &pm)
+	err = payment_method.InsertPaymentMethodTx(ctx, tx, &pm)
 	if err != nil {
 		ErrDatabase.Write(w)
 		log.Error("database error", log15.Ctx{"err": err})
@@ -213,7 +267,7 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// insert status
-	err = payment_method.InsertPaymentMethodStatusTx(tx, &pm)
+	err = payment_method.InsertPaymentMethodStatusTx(ctx, tx, &pm)
 	if err != nil {
 		ErrDatabase.Write(w)
 		log.Error("database error", log15.Ctx{"err": err})
@@ -235,7 +289,7 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// get payment_method from db with all set values like status created
-	pmdb, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(tx, pm.ProjectID, pm.Provider.Name, pm.MethodKey)
+	pmdb, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(ctx, tx, pm.ProjectID, pm.Provider.Name, pm.MethodKey)
 	if err != nil {
 		ErrDatabase.Write(w)
 		log.Error("database error", log15.Ctx{"err": err})
@@ -249,6 +303,7 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 		log.Error("database error", log15.Ctx{"err": err})
 		return
 	}
+	a.notifyPaymentMethodStatus(pmdb)
 
 	resp := ProjectAdminAPIResponse{}
 	resp.Status = StatusSuccess
@@ -261,6 +316,7 @@ func (a *AdminAPI) putNewPaymentMethod(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *AdminAPI) postChangePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(a.ctx, r)
 	log := a.log.New(log15.Ctx{"method": "PaymentMethod POST Request"})
 	// get parameters
 	// projectid and methodname
@@ -303,9 +359,9 @@ func (a *AdminAPI) postChangePaymentMethod(w http.ResponseWriter, r *http.Reques
 		}
 	}()
 
-	tx, err = a.ctx.PaymentDB().Begin()
+	tx, err = a.ctx.ProjectPaymentDB(projectID).Begin()
 	// check if payment_method exists
-	pm, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(tx, projectID, pmr.Provider, methodKey)
+	pm, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(ctx, tx, projectID, pmr.Provider, methodKey)
 	if err == payment_method.ErrPaymentMethodNotFound {
 		ErrNotFound.Write(w)
 		log.Error("payment method not found", log15.Ctx{"err": err})
@@ -319,8 +375,10 @@ func (a *AdminAPI) postChangePaymentMethod(w http.ResponseWriter, r *http.Reques
 
 	// user data
 	auth := service.RequestContextAuth(r)
+	var statusChanged bool
 	// insert new status if set
 	if pmr.Status != pm.Status.String() {
+		statusChanged = true
 		// set paymentMethod values
 		// get user id
 		// parse status value
@@ -331,9 +389,9 @@ func (a *AdminAPI) postChangePaymentMethod(w http.ResponseWriter, r *http.Reques
 		}
 		pm.Status.Scan(pmr.Status)
 		pm.StatusCreatedBy = auth[AuthUserIDKey].(string)
-		payment_method.InsertPaymentMethodStatusTx(tx, pm)
+		payment_method.InsertPaymentMethodStatusTx(ctx, tx, pm)
 		// reload payment_method
-		pm, err = payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(tx, pm.ProjectID, pm.Provider.Name, pm.MethodKey)
+		pm, err = payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(ctx, tx, pm.ProjectID, pm.Provider.Name, pm.MethodKey)
 		if err == payment_method.ErrPaymentMethodNotFound {
 			ErrNotFound.Write(w)
 			log.Error("payment method not found", log15.Ctx{"err": err})
@@ -362,7 +420,7 @@ func (a *AdminAPI) postChangePaymentMethod(w http.ResponseWriter, r *http.Reques
 		}
 
 		// reload payment method matadata
-		pmmd, err := payment_method.PaymentMethodMetadataTx(tx, pm)
+		pmmd, err := payment_method.PaymentMethodMetadataTx(ctx, tx, pm)
 		if err != nil {
 			ErrDatabase.Write(w)
 			log.Error("database error", log15.Ctx{"err": err})
@@ -384,4 +442,43 @@ func (a *AdminAPI) postChangePaymentMethod(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	commit = true
+
+	if statusChanged {
+		a.notifyPaymentMethodStatus(pm)
+	}
+	if pmr.Metadata != nil {
+		a.notifyPaymentMethodConfig(pm, pmr.Metadata)
+	}
+}
+
+func paymentMethodEventData(pm *payment_method.Method) map[string]string {
+	return map[string]string{
+		"MethodId":  strconv.FormatInt(pm.ID, 10),
+		"MethodKey": pm.MethodKey,
+		"Provider":  pm.Provider.Name,
+	}
+}
+
+// notifyPaymentMethodStatus notifies the project of the current status of the
+// payment method
+func (a *AdminAPI) notifyPaymentMethodStatus(pm *payment_method.Method) {
+	data := paymentMethodEventData(pm)
+	data["Status"] = pm.Status.String()
+	a.paymentService.NotifyEvent(pm.ProjectID, paymentService.EventPaymentMethodStatus, data)
+}
+
+// notifyPaymentMethodConfig notifies the project of changed metadata of the
+// payment method
+//
+// Only the names of the changed metadata entries will be sent, since the values
+// may contain provider credentials.
+func (a *AdminAPI) notifyPaymentMethodConfig(pm *payment_method.Method, changed map[string]string) {
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	data := paymentMethodEventData(pm)
+	data["Changed"] = strings.Join(names, ",")
+	a.paymentService.NotifyEvent(pm.ProjectID, paymentService.EventPaymentMethodConfig, data)
 }
@@ -0,0 +1,390 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/paymentd/provider"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// PaymentMethodDisplayRequest is the request body for setting the display
+// metadata of a payment method
+type PaymentMethodDisplayRequest struct {
+	Locale      string
+	Name        string
+	Description string
+}
+
+// PaymentMethodDisplayResponse is the response JSON struct for the display
+// metadata of a payment method
+type PaymentMethodDisplayResponse struct {
+	Provider  string
+	MethodKey string
+	Displays  []*payment_method.Display
+	// LogoURL is empty if there is no logo
+	LogoURL string
+}
+
+// PaymentMethodDisplayRequest returns a handler which reads and sets the
+// display metadata of the payment methods with a provider and method key
+//
+// GET returns the display metadata of all locales.
+// PUT sets the display metadata of a locale.
+func (a *AdminAPI) PaymentMethodDisplayRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "PaymentMethodDisplayRequest"})
+
+		vars := mux.Vars(r)
+		prov, methodKey := vars["provider"], vars["methodkey"]
+		log = log.New(log15.Ctx{"provider": prov, "methodKey": methodKey})
+		if !a.providerExists(w, prov, log) {
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			if !a.putPaymentMethodDisplay(w, r, prov, methodKey, log) {
+				return
+			}
+		default:
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+
+		displays, logoURL, err := a.paymentService.MethodDisplays(prov, methodKey)
+		if err != nil {
+			log.Error("error retrieving display metadata", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "display metadata found"
+		resp.Response = PaymentMethodDisplayResponse{
+			Provider:  prov,
+			MethodKey: methodKey,
+			Displays:  displays,
+			LogoURL:   logoURL,
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) putPaymentMethodDisplay(w http.ResponseWriter, r *http.Request, prov, methodKey string, log log15.Logger) bool {
+	ctx := requestContext(a.ctx, r)
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("error getting auth container", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return false
+	}
+	req := PaymentMethodDisplayRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Error("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return false
+	}
+	d := &payment_method.Display{
+		Provider:    prov,
+		MethodKey:   methodKey,
+		Timestamp:   time.Now(),
+		CreatedBy:   auth[AuthUserIDKey].(string),
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	d.Locale, err = payment_method.NormalizeLocale(req.Locale)
+	if err != nil || !d.Valid() {
+		resp := ErrInval
+		resp.Info = "locale and a name of up to " + strconv.Itoa(payment_method.DisplayNameMaxLen) + " bytes required"
+		resp.Write(w)
+		return false
+	}
+	err = a.insertPaymentMethodDisplayData(func(tx *sql.Tx) error {
+		return payment_method.InsertDisplayTx(ctx, tx, d)
+	}, log)
+	if err != nil {
+		ErrDatabase.Write(w)
+		return false
+	}
+	a.paymentService.ForgetMethodDisplay(prov, methodKey)
+	return true
+}
+
+// PaymentMethodProjectDisplayResponse is the response JSON struct for the
+// display metadata of a payment method set by its project
+type PaymentMethodProjectDisplayResponse struct {
+	ProjectID int64 `json:",string"`
+	Provider  string
+	MethodKey string
+	Displays  []*payment_method.Display
+}
+
+// PaymentMethodProjectDisplayRequest returns a handler which reads and sets
+// the display metadata of a project payment method
+//
+// The display metadata of the project overrides the display metadata shared by
+// all payment methods with the same provider and method key.
+//
+// GET returns the display metadata of all locales.
+// PUT sets the display metadata of a locale. An empty name removes it.
+func (a *AdminAPI) PaymentMethodProjectDisplayRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "PaymentMethodProjectDisplayRequest"})
+		ctx := requestContext(a.ctx, r)
+
+		vars := mux.Vars(r)
+		prov, methodKey := vars["provider"], vars["methodkey"]
+		projectID, err := strconv.ParseInt(vars["projectid"], 10, 64)
+		if err != nil {
+			log.Error("param conversion error", log15.Ctx{"err": err})
+			ErrReadParam.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID, "provider": prov, "methodKey": methodKey})
+
+		db := a.ctx.PaymentDB(service.ReadOnly)
+		pm, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyDB(ctx, db, projectID, prov, methodKey)
+		if err != nil {
+			if err == payment_method.ErrPaymentMethodNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment method", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			pm.Metadata, err = payment_method.PaymentMethodMetadataDB(ctx, db, pm)
+			if err != nil {
+				log.Error("error retrieving payment method metadata", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+		case "PUT":
+			if !a.putPaymentMethodProjectDisplay(w, r, pm, log) {
+				return
+			}
+		default:
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+
+		resp := ProjectAdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "display metadata found"
+		resp.Response = PaymentMethodProjectDisplayResponse{
+			ProjectID: pm.ProjectID,
+			Provider:  pm.Provider.Name,
+			MethodKey: pm.MethodKey,
+			Displays:  pm.ProjectDisplays(),
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// putPaymentMethodProjectDisplay saves the display metadata of the request in
+// the metadata of the payment method and reloads its metadata
+func (a *AdminAPI) putPaymentMethodProjectDisplay(w http.ResponseWriter, r *http.Request, pm *payment_method.Method, log log15.Logger) bool {
+	ctx := requestContext(a.ctx, r)
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("error getting auth container", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return false
+	}
+	req := PaymentMethodDisplayRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Error("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return false
+	}
+	d := &payment_method.Display{
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	d.Locale, err = payment_method.NormalizeLocale(req.Locale)
+	if err != nil || len(d.Name) > payment_method.DisplayNameMaxLen {
+		resp := ErrInval
+		resp.Info = "locale and a name of up to " + strconv.Itoa(payment_method.DisplayNameMaxLen) + " bytes required"
+		resp.Write(w)
+		return false
+	}
+	if d.Name == "" {
+		d.Description = ""
+	}
+	values := payment_method.DisplayMetadata(d)
+	err = a.insertPaymentMethodDisplayData(func(tx *sql.Tx) error {
+		md := metadata.MetadataFromValues(values, auth[AuthUserIDKey].(string))
+		err := metadata.InsertMetadataTx(tx, payment_method.MetadataModel, pm.ID, md)
+		if err != nil {
+			return err
+		}
+		pm.Metadata, err = payment_method.PaymentMethodMetadataTx(ctx, tx, pm)
+		return err
+	}, log)
+	if err != nil {
+		ErrDatabase.Write(w)
+		return false
+	}
+	a.notifyPaymentMethodConfig(pm, values)
+	return true
+}
+
+// PaymentMethodLogoRequest returns a handler which sets the logo of the payment
+// methods with a provider and method key
+//
+// The request body is the image. Its content type must be one of
+// payment_method.LogoContentTypes.
+func (a *AdminAPI) PaymentMethodLogoRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "PaymentMethodLogoRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		vars := mux.Vars(r)
+		prov, methodKey := vars["provider"], vars["methodkey"]
+		log = log.New(log15.Ctx{"provider": prov, "methodKey": methodKey})
+		if !a.providerExists(w, prov, log) {
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		l := &payment_method.Logo{
+			Provider:    prov,
+			MethodKey:   methodKey,
+			Timestamp:   time.Now(),
+			CreatedBy:   auth[AuthUserIDKey].(string),
+			ContentType: r.Header.Get("Content-Type"),
+		}
+		if !payment_method.ValidLogoContentType(l.ContentType) {
+			resp := ErrInval
+			resp.Info = "unsupported logo content type"
+			resp.Write(w)
+			return
+		}
+		l.Data, err = ioutil.ReadAll(io.LimitReader(r.Body, payment_method.LogoMaxSize+1))
+		r.Body.Close()
+		if err != nil {
+			log.Error("error reading logo", log15.Ctx{"err": err})
+			ErrReadParam.Write(w)
+			return
+		}
+		if len(l.Data) > payment_method.LogoMaxSize {
+			resp := ErrTooLarge
+			resp.Info = "logo must not exceed " + strconv.Itoa(payment_method.LogoMaxSize) + " bytes"
+			resp.Write(w)
+			return
+		}
+		if len(l.Data) == 0 {
+			resp := ErrInval
+			resp.Info = "logo required"
+			resp.Write(w)
+			return
+		}
+		err = a.insertPaymentMethodDisplayData(func(tx *sql.Tx) error {
+			return payment_method.InsertLogoTx(ctx, tx, l)
+		}, log)
+		if err != nil {
+			ErrDatabase.Write(w)
+			return
+		}
+		a.paymentService.ForgetMethodDisplay(prov, methodKey)
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "logo set"
+		resp.Response = PaymentMethodDisplayResponse{
+			Provider:  prov,
+			MethodKey: methodKey,
+			LogoURL:   a.paymentService.MethodLogoURL(prov, methodKey, l.Timestamp),
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// providerExists writes a not found response if there is no provider with the
+// given name
+func (a *AdminAPI) providerExists(w http.ResponseWriter, name string, log log15.Logger) bool {
+	_, err := provider.ProviderByNameDB(a.ctx, a.ctx.PaymentDB(service.ReadOnly), name)
+	if err == provider.ErrProviderNotFound {
+		resp := ErrNotFound
+		resp.Info = "provider " + name + " not found"
+		resp.Write(w)
+		return false
+	}
+	if err != nil {
+		log.Error("error retrieving provider", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return false
+	}
+	return true
+}
+
+// insertPaymentMethodDisplayData runs the given insert in a transaction
+func (a *AdminAPI) insertPaymentMethodDisplayData(insert func(tx *sql.Tx) error, log log15.Logger) error {
+	tx, err := a.ctx.PaymentDB().Begin()
+	if err != nil {
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		return err
+	}
+	err = insert(tx)
+	if err != nil {
+		log.Error("error saving display metadata", log15.Ctx{"err": err})
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Crit("error on rollback", log15.Ctx{"err": rbErr})
+		}
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+	}
+	return err
+}
@@ -0,0 +1,208 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// PaymentMethodMaintenanceRequest is the request body for scheduling a
+// maintenance window of a payment method
+type PaymentMethodMaintenanceRequest struct {
+	Start  time.Time
+	End    time.Time
+	Reason string
+}
+
+// PaymentMethodMaintenanceResponse is the response JSON struct for the
+// maintenance windows of a payment method
+type PaymentMethodMaintenanceResponse struct {
+	ProjectID int64 `json:",string"`
+	Provider  string
+	MethodKey string
+	// Maintenance lists the current and upcoming maintenance windows
+	Maintenance []*payment_method.Maintenance
+}
+
+// PaymentMethodMaintenanceRequest returns a handler which schedules the
+// maintenance windows of a project payment method
+//
+// During a maintenance window the payment method will be hidden from the
+// payment method selection and payments with the payment method can not be
+// initialized.
+//
+// GET returns the current and upcoming maintenance windows.
+// PUT schedules a maintenance window.
+// DELETE removes the maintenance window with the ID in the path.
+func (a *AdminAPI) PaymentMethodMaintenanceRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "PaymentMethodMaintenanceRequest"})
+		ctx := requestContext(a.ctx, r)
+
+		vars := mux.Vars(r)
+		prov, methodKey := vars["provider"], vars["methodkey"]
+		projectID, err := strconv.ParseInt(vars["projectid"], 10, 64)
+		if err != nil {
+			log.Error("param conversion error", log15.Ctx{"err": err})
+			ErrReadParam.Write(w)
+			return
+		}
+		var maintenanceID int64
+		if idStr, ok := vars["maintenanceid"]; ok {
+			maintenanceID, err = strconv.ParseInt(idStr, 10, 64)
+			if err != nil {
+				log.Error("param conversion error", log15.Ctx{"err": err})
+				ErrReadParam.Write(w)
+				return
+			}
+		}
+		log = log.New(log15.Ctx{"projectID": projectID, "provider": prov, "methodKey": methodKey})
+
+		db := a.ctx.ProjectPaymentDB(projectID, service.ReadOnly)
+		pm, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyDB(ctx, db, projectID, prov, methodKey)
+		if err != nil {
+			if err == payment_method.ErrPaymentMethodNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment method", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		switch {
+		case r.Method == "GET" && maintenanceID == 0:
+		case r.Method == "PUT" && maintenanceID == 0:
+			if !a.putPaymentMethodMaintenance(w, r, pm, log) {
+				return
+			}
+		case r.Method == "DELETE" && maintenanceID != 0:
+			if !a.deletePaymentMethodMaintenance(w, pm, maintenanceID, log) {
+				return
+			}
+		default:
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+
+		// read from the primary, the windows might just have been changed
+		windows, err := payment_method.MaintenanceByMethodIDDB(ctx, a.ctx.ProjectPaymentDB(projectID), pm.ID, time.Now())
+		if err != nil {
+			log.Error("error retrieving maintenance windows", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := ProjectAdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "maintenance windows found"
+		resp.Response = PaymentMethodMaintenanceResponse{
+			ProjectID:   pm.ProjectID,
+			Provider:    pm.Provider.Name,
+			MethodKey:   pm.MethodKey,
+			Maintenance: windows,
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) putPaymentMethodMaintenance(w http.ResponseWriter, r *http.Request, pm *payment_method.Method, log log15.Logger) bool {
+	ctx := requestContext(a.ctx, r)
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("error getting auth container", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return false
+	}
+	req := PaymentMethodMaintenanceRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Error("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return false
+	}
+	now := time.Now()
+	m := &payment_method.Maintenance{
+		PaymentMethodID: pm.ID,
+		Start:           req.Start,
+		End:             req.End,
+		Created:         now,
+		CreatedBy:       auth[AuthUserIDKey].(string),
+		Reason:          req.Reason,
+	}
+	if !m.Valid() || !m.End.After(now) {
+		resp := ErrInval
+		resp.Info = "start and a future end after the start required, reason of up to " + strconv.Itoa(payment_method.MaintenanceReasonMaxLen) + " bytes"
+		resp.Write(w)
+		return false
+	}
+	err = a.changePaymentMethodMaintenance(pm, func(tx *sql.Tx) error {
+		return payment_method.InsertMaintenanceTx(ctx, tx, m)
+	}, log)
+	if err != nil {
+		ErrDatabase.Write(w)
+		return false
+	}
+	log.Info("maintenance scheduled", log15.Ctx{
+		"maintenanceID": m.ID,
+		"start":         m.Start,
+		"end":           m.End,
+	})
+	return true
+}
+
+func (a *AdminAPI) deletePaymentMethodMaintenance(w http.ResponseWriter, pm *payment_method.Method, maintenanceID int64, log log15.Logger) bool {
+	err := a.changePaymentMethodMaintenance(pm, func(tx *sql.Tx) error {
+		return payment_method.DeleteMaintenanceTx(a.ctx, tx, pm.ID, maintenanceID)
+	}, log)
+	if err == payment_method.ErrMaintenanceNotFound {
+		ErrNotFound.Write(w)
+		return false
+	}
+	if err != nil {
+		ErrDatabase.Write(w)
+		return false
+	}
+	log.Info("maintenance removed", log15.Ctx{"maintenanceID": maintenanceID})
+	return true
+}
+
+// changePaymentMethodMaintenance runs the given change in a transaction on the
+// shard of the payment method
+func (a *AdminAPI) changePaymentMethodMaintenance(pm *payment_method.Method, change func(tx *sql.Tx) error, log log15.Logger) error {
+	tx, err := a.ctx.ProjectPaymentDB(pm.ProjectID).Begin()
+	if err != nil {
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		return err
+	}
+	err = change(tx)
+	if err != nil {
+		if err != payment_method.ErrMaintenanceNotFound {
+			log.Error("error saving maintenance", log15.Ctx{"err": err})
+		}
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Crit("error on rollback", log15.Ctx{"err": rbErr})
+		}
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+	}
+	return err
+}
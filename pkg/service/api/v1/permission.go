@@ -0,0 +1,121 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/principal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/paymentd/user"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// permissionScope determines the principal and project a request operates on
+//
+// A principal ID of 0 means the request requires a global grant.
+type permissionScope func(a *AdminAPI, r *http.Request) (principalID, projectID int64, err error)
+
+// globalScope is the scope of requests which are not restricted to a principal
+// or project
+func globalScope(a *AdminAPI, r *http.Request) (int64, int64, error) {
+	return 0, 0, nil
+}
+
+// principalScope is the scope of requests on the principal in the route variable
+// "name"
+func principalScope(a *AdminAPI, r *http.Request) (int64, int64, error) {
+	pr, err := principal.PrincipalByNameDB(a.ctx.PrincipalDB(service.ReadOnly), mux.Vars(r)["name"])
+	if err != nil {
+		return 0, 0, err
+	}
+	return pr.ID, 0, nil
+}
+
+// projectScope is the scope of requests on the project in the route variable
+// "projectid"
+func projectScope(a *AdminAPI, r *http.Request) (int64, int64, error) {
+	ctx := requestContext(a.ctx, r)
+	projectID, err := strconv.ParseInt(mux.Vars(r)["projectid"], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	pr, err := project.ProjectByIDDB(ctx, a.ctx.PrincipalDB(service.ReadOnly), projectID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return pr.PrincipalID, pr.ID, nil
+}
+
+// PermissionHandler wraps the given handler and checks whether the authorized user
+// is permitted to perform the request
+//
+// Reading requests (GET and HEAD) require the viewer role, all other requests
+// require the given role. The system user is permitted to perform all requests.
+//
+// The handler must be wrapped by an AuthRequiredHandler.
+func (a *AdminAPI) PermissionHandler(role user.Role, scope permissionScope, parent http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		required := role
+		if r.Method == "GET" || r.Method == "HEAD" {
+			required = user.RoleViewer
+		}
+		a.servePermitted(w, r, required, scope, parent)
+	})
+}
+
+// RoleRequiredHandler wraps the given handler and requires the given role for
+// all requests
+//
+// The handler must be wrapped by an AuthRequiredHandler.
+func (a *AdminAPI) RoleRequiredHandler(role user.Role, scope permissionScope, parent http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.servePermitted(w, r, role, scope, parent)
+	})
+}
+
+func (a *AdminAPI) servePermitted(w http.ResponseWriter, r *http.Request, role user.Role, scope permissionScope, parent http.Handler) {
+	log := a.log.New(log15.Ctx{"method": "servePermitted"})
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("auth container error", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return
+	}
+	userID, _ := auth[AuthUserIDKey].(string)
+	if userID == systemUserID {
+		parent.ServeHTTP(w, r)
+		return
+	}
+	u, err := user.UserByNameDB(a.ctx.PrincipalDB(service.ReadOnly), userID)
+	if err != nil {
+		if err == user.ErrUserNotFound {
+			ErrUnauthorized.Write(w)
+			return
+		}
+		log.Error("error retrieving user", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	principalID, projectID, err := scope(a, r)
+	if err != nil {
+		// do not disclose whether the resource exists
+		if Debug {
+			log.Debug("error determining permission scope", log15.Ctx{"err": err})
+		}
+		ErrForbidden.Write(w)
+		return
+	}
+	if !u.Allows(role, principalID, projectID) {
+		log.Info("permission denied", log15.Ctx{
+			"user":        u.Name,
+			"role":        role,
+			"principalID": principalID,
+			"projectID":   projectID,
+		})
+		ErrForbidden.Write(w)
+		return
+	}
+	parent.ServeHTTP(w, r)
+}
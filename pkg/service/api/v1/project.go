@@ -1,6 +1,7 @@
 package v1
 
 import (
+	"crypto/tls"
 	"database/sql"
 	"encoding/json"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"github.com/fritzpay/paymentd/pkg/paymentd/principal"
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
 	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"github.com/gorilla/mux"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -60,6 +62,7 @@ func (a *AdminAPI) ProjectGetRequest() http.Handler {
 }
 
 func (a *AdminAPI) getProject(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(a.ctx, r)
 	log := a.log.New(log15.Ctx{"method": "getProject"})
 
 	// parse request paramter
@@ -75,7 +78,7 @@ func (a *AdminAPI) getProject(w http.ResponseWriter, r *http.Request) {
 
 	// get project from database
 	db := a.ctx.PrincipalDB(service.ReadOnly)
-	pr, err := project.ProjectByIDDB(db, projectID)
+	pr, err := project.ProjectByIDDB(ctx, db, projectID)
 	if err == project.ErrProjectNotFound {
 		log.Warn("project not found", log15.Ctx{"err": err})
 		ErrNotFound.Write(w)
@@ -112,6 +115,7 @@ func (a *AdminAPI) getProject(w http.ResponseWriter, r *http.Request) {
 
 // add new project
 func (a *AdminAPI) putNewProject(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(a.ctx, r)
 
 	log := a.log.New(log15.Ctx{"method": "putNewProject"})
 	auth, err := getAuthContainer(r)
@@ -150,6 +154,13 @@ func (a *AdminAPI) putNewProject(w http.ResponseWriter, r *http.Request) {
 		ErrInval.Write(w)
 		return
 	}
+	if info := callbackConfigError(pr.Config); info != "" {
+		log.Warn("invalid callback config", log15.Ctx{"info": info})
+		resp := ErrInval
+		resp.Info = info
+		resp.Write(w)
+		return
+	}
 
 	log = log.New(log15.Ctx{"projectName": pr.Name, "principalID": pr.PrincipalID})
 
@@ -186,7 +197,7 @@ func (a *AdminAPI) putNewProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//check if this project already exist
-	_, err = project.ProjectByPrincipalIDAndNameTx(tx, pr.PrincipalID, pr.Name)
+	_, err = project.ProjectByPrincipalIDAndNameTx(ctx, tx, pr.PrincipalID, pr.Name)
 	if err != nil && err != project.ErrProjectNotFound {
 		log.Error("error retrieving project", log15.Ctx{"err": err})
 		ErrDatabase.Write(w)
@@ -201,14 +212,14 @@ func (a *AdminAPI) putNewProject(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// insert project from database
-	err = project.InsertProjectTx(tx, &pr)
+	err = project.InsertProjectTx(ctx, tx, &pr)
 	if err != nil {
 		log.Error("project creation failed", log15.Ctx{"err": err})
 		ErrDatabase.Write(w)
 		return
 	}
 	if pr.Config.HasValues() {
-		err = project.InsertProjectConfigTx(tx, &pr)
+		err = project.InsertProjectConfigTx(ctx, tx, &pr)
 		if err != nil {
 			log.Error("error saving project config", log15.Ctx{"err": err})
 			ErrDatabase.Write(w)
@@ -245,6 +256,7 @@ func (a *AdminAPI) putNewProject(w http.ResponseWriter, r *http.Request) {
 
 // add change project data
 func (a *AdminAPI) postChangeProject(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(a.ctx, r)
 
 	log := a.log.New(log15.Ctx{"method": "postChangeProject"})
 
@@ -279,6 +291,13 @@ func (a *AdminAPI) postChangeProject(w http.ResponseWriter, r *http.Request) {
 	pr.CreatedBy = auth[AuthUserIDKey].(string)
 	pr.Created = time.Now().UTC().Round(time.Second)
 	pr.Name = projectName
+	if info := callbackConfigError(pr.Config); info != "" {
+		log.Warn("invalid callback config", log15.Ctx{"info": info})
+		resp := ErrInval
+		resp.Info = info
+		resp.Write(w)
+		return
+	}
 
 	// Rollback handling
 	var tx *sql.Tx
@@ -301,7 +320,7 @@ func (a *AdminAPI) postChangeProject(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//does project exist
-	prDB, err := project.ProjectByPrincipalIDAndNameTx(tx, pr.PrincipalID, pr.Name)
+	prDB, err := project.ProjectByPrincipalIDAndNameTx(ctx, tx, pr.PrincipalID, pr.Name)
 	if err == project.ErrProjectNotFound {
 		log.Error("error retrieving project", log15.Ctx{"err": err})
 		ErrInval.Write(w)
@@ -315,7 +334,7 @@ func (a *AdminAPI) postChangeProject(w http.ResponseWriter, r *http.Request) {
 	pr.ID = prDB.ID
 	// update config data
 	if pr.Config.HasValues() {
-		err = project.InsertProjectConfigTx(tx, pr)
+		err = project.InsertProjectConfigTx(ctx, tx, pr)
 		if err != nil {
 			log.Error("error saving project config", log15.Ctx{"err": err})
 			ErrDatabase.Write(w)
@@ -332,7 +351,7 @@ func (a *AdminAPI) postChangeProject(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// get stored and added metadata from db
-	pr, err = project.ProjectByPrincipalIDandIDTx(tx, pr.PrincipalID, prDB.ID)
+	pr, err = project.ProjectByPrincipalIDandIDTx(ctx, tx, pr.PrincipalID, prDB.ID)
 	if err != nil {
 		log.Error("get metadata failed", log15.Ctx{"err": err})
 		ErrDatabase.Write(w)
@@ -367,3 +386,35 @@ func (a *AdminAPI) postChangeProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// callbackConfigError validates the callback settings of the project config
+//
+// It returns a description of the first error found or an empty string if the
+// config is valid.
+func callbackConfigError(cfg project.Config) string {
+	for _, eventType := range cfg.CallbackEventTypes() {
+		if !paymentService.ValidEventType(eventType) {
+			return "invalid callback event type " + eventType
+		}
+	}
+	if cfg.CallbackTLSCertFile.Valid != cfg.CallbackTLSKeyFile.Valid {
+		return "callback TLS requires both a certificate and a key file"
+	}
+	if cfg.HasCallbackTLS() {
+		_, err := tls.LoadX509KeyPair(cfg.CallbackTLSCertFile.String, cfg.CallbackTLSKeyFile.String)
+		if err != nil {
+			return "invalid callback TLS certificate: " + err.Error()
+		}
+	}
+	headers, err := cfg.CallbackHeaderValues()
+	if err != nil {
+		return "invalid callback headers"
+	}
+	for name := range headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "User-Agent", "Host", "Content-Length", "Content-Type", "Transfer-Encoding", "Connection", paymentService.SignatureHeader, paymentService.DigestHeader:
+			return "callback header " + name + " is reserved"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,209 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// PaymentAuthorization is an entry of the authorization history of a payment
+type PaymentAuthorization struct {
+	Timestamp string
+	// Amount is the authorized amount
+	Amount string
+	// Increment is the amount by which the previous authorization was
+	// incremented
+	Increment string `json:",omitempty"`
+	Currency  string
+}
+
+// ProjectPaymentAuthorizationIncrement is a request to increment the
+// authorization of a payment
+type ProjectPaymentAuthorizationIncrement struct {
+	// Amount is the decimal amount by which the authorization will be incremented
+	Amount string
+}
+
+// ProjectPaymentAuthorizationRequest returns a handler for the authorization
+// of a payment
+//
+// GET returns the authorization history of the payment. POST increments the
+// authorization with the provider of the payment method and returns the history.
+func (a *AdminAPI) ProjectPaymentAuthorizationRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentAuthorizationRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" && r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(mux.Vars(r)["paymentid"])
+		if err != nil || paymentID.ProjectID != projectID {
+			resp := ErrReadParam
+			resp.Info = "invalid payment id"
+			resp.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":        projectID,
+			"DisplayPaymentId": paymentID.String(),
+		})
+		paymentID = a.paymentService.DecodedPaymentID(paymentID)
+
+		p, err := payment.PaymentByIDDB(ctx, a.ctx.ProjectPaymentDB(paymentID.ProjectID, service.ReadOnly), paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if r.Method == "POST" {
+			if !a.incrementAuthorization(w, r, p, log) {
+				return
+			}
+		}
+
+		auths, err := payment.PaymentAuthorizationsDB(ctx, a.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly), p)
+		if err != nil {
+			log.Error("error retrieving authorizations", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		history := make([]PaymentAuthorization, len(auths))
+		var prev *payment.Authorization
+		for i, auth := range auths {
+			history[i] = PaymentAuthorization{
+				Timestamp: auth.Timestamp.UTC().Format(time.RFC3339Nano),
+				Amount:    auth.Decimal().String(),
+				Currency:  auth.Currency,
+			}
+			if prev != nil {
+				history[i].Increment = (&payment.Authorization{
+					Amount:   auth.Increment(prev),
+					Subunits: auth.Subunits,
+				}).Decimal().String()
+			}
+			prev = auth
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(history)) + " authorizations"
+		if r.Method == "POST" {
+			resp.Info = "authorization incremented, authorized amount is " + p.Authorization.Decimal().String()
+		}
+		resp.Response = history
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// incrementAuthorization increments the authorization of the payment with the
+// amount of the request
+//
+// It returns false if the response was written.
+func (a *AdminAPI) incrementAuthorization(w http.ResponseWriter, r *http.Request, p *payment.Payment, log log15.Logger) bool {
+	ctx := requestContext(a.ctx, r)
+	req := ProjectPaymentAuthorizationIncrement{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Error("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return false
+	}
+	amount := &decimal.Decimal{}
+	if _, ok := amount.SetString(req.Amount); !ok {
+		resp := ErrInval
+		resp.Info = "invalid amount"
+		resp.Write(w)
+		return false
+	}
+	if !p.Config.PaymentMethodID.Valid {
+		resp := ErrConflict
+		resp.Info = "payment has no payment method"
+		resp.Write(w)
+		return false
+	}
+	method, err := a.paymentService.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return false
+	}
+	err = payment.PaymentAuthorizationDB(ctx, a.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly), p)
+	if err != nil {
+		log.Error("error retrieving payment authorization", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return false
+	}
+	previous := p.AuthorizedAmount()
+	err = a.providerService.IncrementAuthorization(ctx, p, method, amount)
+	if err != nil {
+		switch {
+		case errors.Is(err, paymentService.ErrIntentNotAllowed):
+			resp := ErrConflict
+			resp.Info = "payment is " + p.Status.String()
+			resp.Write(w)
+		case errors.Is(err, paymentService.ErrAuthorizationIncrement):
+			resp := ErrInval
+			resp.Info = "invalid amount"
+			resp.Write(w)
+		case errors.Is(err, paymentService.ErrPaymentMethodDisabled):
+			resp := ErrConflict
+			resp.Info = "payment method is disabled"
+			resp.Write(w)
+		case errors.Is(err, provider.ErrNoDriver):
+			resp := ErrConflict
+			resp.Info = "provider " + method.Provider.Name + " is not attached on this instance"
+			resp.Write(w)
+		case errors.Is(err, provider.ErrNotSupported):
+			resp := ErrConflict
+			resp.Info = "provider " + method.Provider.Name + " does not support incremental authorizations"
+			resp.Write(w)
+		case service.RequestDone(r):
+			log.Warn("authorization increment abandoned", log15.Ctx{"err": err})
+			ErrTimeout.Write(w)
+		default:
+			log.Error("error on authorization increment", log15.Ctx{"err": err})
+			resp := ErrSystem
+			resp.Info = "authorization increment failed"
+			resp.Write(w)
+		}
+		return false
+	}
+	a.paymentService.NotifyEvent(p.ProjectID(), paymentService.EventPaymentAuthorization, map[string]string{
+		"PaymentId":        a.paymentService.EncodedPaymentID(p.PaymentID()).String(),
+		"AuthorizedAmount": p.Authorization.Decimal().String(),
+		"Increment": (&payment.Authorization{
+			Amount:   p.Authorization.Amount - previous,
+			Subunits: p.Subunits,
+		}).Decimal().String(),
+		"Currency": p.Currency,
+	})
+	return true
+}
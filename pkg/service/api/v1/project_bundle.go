@@ -0,0 +1,345 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/paymentd/principal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/bundle"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ProjectBundleImportResponse is the response of a bundle import
+type ProjectBundleImportResponse struct {
+	Project  *project.Project
+	Warnings []string
+}
+
+// ProjectBundleRequest returns a handler which exports the configuration of a
+// project as a signed bundle
+func (a *AdminAPI) ProjectBundleRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectBundleRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID})
+
+		keys, err := bundle.DecodeKeys(a.ctx.Config().API.BundleKeys)
+		if err != nil || len(keys) == 0 {
+			log.Error("no bundle key configured", log15.Ctx{"err": err})
+			resp := ErrSystem
+			resp.Info = "no bundle key configured"
+			resp.Write(w)
+			return
+		}
+		b, err := bundle.Export(
+			ctx,
+			a.ctx.PrincipalDB(service.ReadOnly),
+			a.ctx.ProjectPaymentDB(projectID, service.ReadOnly),
+			projectID,
+			auth[AuthUserIDKey].(string))
+		if err != nil {
+			if err == project.ErrProjectNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error exporting project", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = b.Sign(keys[0])
+		if err != nil {
+			log.Error("error signing bundle", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "project " + b.Project.Name + " exported"
+		resp.Response = b
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// PrincipalBundleRequest returns a handler which imports a signed project bundle
+// into a project of the principal
+//
+// The project will be created if it does not exist.
+func (a *AdminAPI) PrincipalBundleRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "PrincipalBundleRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		createdBy := auth[AuthUserIDKey].(string)
+		principalName := mux.Vars(r)["name"]
+		log = log.New(log15.Ctx{"principalName": principalName})
+
+		b := &bundle.Bundle{}
+		jd := json.NewDecoder(r.Body)
+		err = jd.Decode(b)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		keys, err := bundle.DecodeKeys(a.ctx.Config().API.BundleKeys)
+		if err != nil {
+			log.Error("invalid bundle keys", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		err = b.Verify(keys)
+		if err != nil {
+			log.Warn("bundle verification failed", log15.Ctx{"err": err})
+			resp := ErrInval
+			resp.Info = err.Error()
+			resp.Write(w)
+			return
+		}
+
+		var principalTx, paymentTx *sql.Tx
+		var commit bool
+		defer func() {
+			if commit {
+				return
+			}
+			for _, tx := range []*sql.Tx{principalTx, paymentTx} {
+				if tx == nil {
+					continue
+				}
+				err := tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		principalTx, err = a.ctx.PrincipalDB().Begin()
+		if err != nil {
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		pr, err := principal.PrincipalByNameTx(principalTx, principalName)
+		if err != nil {
+			if err == principal.ErrPrincipalNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving principal", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		res, err := bundle.ImportProject(ctx, principalTx, pr.ID, b, createdBy, a.ctx.Config().Web.TLS.DomainCertDir)
+		if err != nil {
+			if err == bundle.ErrInvalidBundle {
+				ErrInval.Write(w)
+				return
+			}
+			log.Error("error importing project", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		// the payment methods reference the project
+		err = principalTx.Commit()
+		principalTx = nil
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		paymentTx, err = a.ctx.ProjectPaymentDB(res.Project.ID).Begin()
+		if err != nil {
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = bundle.ImportMethods(ctx, paymentTx, res, b, createdBy)
+		if err != nil {
+			log.Error("error importing payment methods", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = paymentTx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "project " + res.Project.Name + " imported"
+		resp.Response = ProjectBundleImportResponse{
+			Project:  res.Project,
+			Warnings: res.Warnings,
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ProjectOnboardingResponse is the response of applying a method bundle
+type ProjectOnboardingResponse struct {
+	Method  *payment_method.Method
+	Changes []string
+	DryRun  bool `json:",omitempty"`
+}
+
+// ProjectOnboardingRequest returns a handler which applies a method bundle to a
+// project
+//
+// The payment method, its provider config, routing rules and webhook
+// subscription are applied in one transaction. With the dryrun parameter, the
+// bundle is validated and the changes are reported, but not saved.
+func (a *AdminAPI) ProjectOnboardingRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectOnboardingRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		dryRun := r.URL.Query().Get("dryrun") != ""
+		log = log.New(log15.Ctx{"projectID": projectID})
+
+		b := &bundle.MethodBundle{}
+		err = json.NewDecoder(r.Body).Decode(b)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		_, err = project.ProjectByIDDB(ctx, a.ctx.PrincipalDB(service.ReadOnly), projectID)
+		if err != nil {
+			if err == project.ErrProjectNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving project", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.ProjectPaymentDB(projectID).Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		res, err := bundle.ApplyMethod(ctx, tx, projectID, b, auth[AuthUserIDKey].(string))
+		if err != nil {
+			if errors.Is(err, bundle.ErrInvalidBundle) || err == bundle.ErrVersion {
+				resp := ErrInval
+				resp.Info = err.Error()
+				resp.Write(w)
+				return
+			}
+			log.Error("error applying method bundle", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if !dryRun {
+			commit = true
+			err = tx.Commit()
+			if err != nil {
+				log.Crit("error on commit", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			log.Info("method bundle applied", log15.Ctx{
+				"provider":  b.Provider,
+				"methodKey": b.MethodKey,
+				"changes":   len(res.Changes),
+			})
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "payment method " + b.Provider + "/" + b.MethodKey + " applied"
+		if dryRun {
+			resp.Info = "payment method " + b.Provider + "/" + b.MethodKey + " validated"
+		}
+		resp.Response = ProjectOnboardingResponse{
+			Method:  res.Method,
+			Changes: res.Changes,
+			DryRun:  dryRun,
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
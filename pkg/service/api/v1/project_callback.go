@@ -0,0 +1,118 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ProjectCallbackTestRequest is the request JSON struct for POST
+// project/(id)/callback/test
+type ProjectCallbackTestRequest struct {
+	// Event is the event type of the test notification. Defaults to
+	// payment.transaction
+	Event string
+}
+
+// ProjectCallbackTestResponse is the delivery result of a test notification
+type ProjectCallbackTestResponse struct {
+	URL        string
+	Event      string
+	Delivered  bool
+	StatusCode int    `json:",omitempty"`
+	Body       string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	Duration   string
+}
+
+// ProjectCallbackTestRequest returns a handler which sends a synthetic signed
+// notification to the callback of the project and reports the delivery result
+//
+// Merchants can verify their notification receivers with it before going live.
+func (a *AdminAPI) ProjectCallbackTestRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectCallbackTestRequest"})
+		if r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID})
+		req := ProjectCallbackTestRequest{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		// the request body is optional
+		if err != nil && err != io.EOF {
+			log.Warn("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		if req.Event == "" {
+			req.Event = paymentService.EventPaymentTransaction
+		}
+		if !paymentService.ValidEventType(req.Event) {
+			resp := ErrInval
+			resp.Info = "unknown event type " + req.Event
+			resp.Write(w)
+			return
+		}
+
+		d, err := a.paymentService.TestEvent(projectID, req.Event)
+		if err != nil {
+			switch {
+			case errors.Is(err, project.ErrProjectNotFound):
+				resp := ErrNotFound
+				resp.Info = "project not found"
+				resp.Write(w)
+			case errors.Is(err, paymentService.ErrPaymentCallbackConfig):
+				resp := ErrInval
+				resp.Info = "project has no callback configured"
+				resp.Write(w)
+			default:
+				log.Error("error sending test notification", log15.Ctx{"err": err})
+				resp := ErrSystem
+				resp.Info = err.Error()
+				resp.Write(w)
+			}
+			return
+		}
+		log.Info("test notification sent", log15.Ctx{
+			"eventType":      d.EventType,
+			"delivered":      d.Delivered,
+			"HTTPStatusCode": d.StatusCode,
+		})
+
+		resp := ProjectAdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "test notification delivered"
+		if !d.Delivered {
+			resp.Info = "test notification not delivered"
+		}
+		resp.Response = ProjectCallbackTestResponse{
+			URL:        d.URL,
+			Event:      d.EventType,
+			Delivered:  d.Delivered,
+			StatusCode: d.StatusCode,
+			Body:       d.Body,
+			Error:      d.Error,
+			Duration:   d.Duration.String(),
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
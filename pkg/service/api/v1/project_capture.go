@@ -0,0 +1,165 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	notification "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ProjectPaymentCapture is a request to capture an authorized payment
+type ProjectPaymentCapture struct {
+	// Amount is the decimal amount to capture. It defaults to the payment amount
+	// less the captured amount. All captures together may not exceed the
+	// authorized amount.
+	Amount string
+	// Partial captures leave the payment partially captured, so it can be
+	// captured further, until the payment amount is captured. Otherwise the
+	// capture is final and the remainder of the authorization is released.
+	Partial bool
+}
+
+// ProjectPaymentCaptureRequest returns a handler to capture an authorized payment
+//
+// POST captures the requested amount with the provider of the payment method.
+// Payments can be captured repeatedly with partial captures.
+func (a *AdminAPI) ProjectPaymentCaptureRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentCaptureRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(mux.Vars(r)["paymentid"])
+		if err != nil || paymentID.ProjectID != projectID {
+			resp := ErrReadParam
+			resp.Info = "invalid payment id"
+			resp.Write(w)
+			return
+		}
+		req := ProjectPaymentCapture{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":        projectID,
+			"DisplayPaymentId": paymentID.String(),
+		})
+		paymentID = a.paymentService.DecodedPaymentID(paymentID)
+
+		p, err := payment.PaymentByIDDB(ctx, a.ctx.ProjectPaymentDB(paymentID.ProjectID, service.ReadOnly), paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		// prior captures must be visible
+		captured, err := a.paymentService.CapturedAmount(a.ctx.ProjectPaymentDB(p.ProjectID()), p)
+		if err != nil {
+			log.Error("error retrieving captured amount", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		amount := &decimal.Decimal{}
+		amount.Sub(&p.Decimal().Dec, &captured.Dec)
+		if req.Amount != "" {
+			amount = &decimal.Decimal{}
+			if _, ok := amount.SetString(req.Amount); !ok {
+				resp := ErrInval
+				resp.Info = "invalid amount"
+				resp.Write(w)
+				return
+			}
+		}
+		if !p.Config.PaymentMethodID.Valid {
+			resp := ErrConflict
+			resp.Info = "payment has no payment method"
+			resp.Write(w)
+			return
+		}
+		method, err := a.paymentService.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+		if err != nil {
+			log.Error("error retrieving payment method", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = a.providerService.Capture(ctx, p, method, amount, !req.Partial)
+		if err != nil {
+			switch {
+			case errors.Is(err, paymentService.ErrIntentNotAllowed):
+				resp := ErrConflict
+				resp.Info = "payment is " + p.Status.String()
+				resp.Write(w)
+			case errors.Is(err, paymentService.ErrCaptureAmount):
+				resp := ErrInval
+				authorized := p.Decimal()
+				if p.Authorization != nil {
+					authorized = p.Authorization.Decimal()
+				}
+				resp.Info = "invalid amount, authorized amount is " + authorized.String() + ", captured amount is " + captured.String()
+				resp.Write(w)
+			case errors.Is(err, paymentService.ErrPaymentMethodDisabled):
+				resp := ErrConflict
+				resp.Info = "payment method is disabled"
+				resp.Write(w)
+			case errors.Is(err, provider.ErrNoDriver):
+				resp := ErrConflict
+				resp.Info = "provider " + method.Provider.Name + " is not attached on this instance"
+				resp.Write(w)
+			case errors.Is(err, provider.ErrNotSupported):
+				resp := ErrConflict
+				resp.Info = "provider " + method.Provider.Name + " does not support captures"
+				resp.Write(w)
+			case service.RequestDone(r):
+				log.Warn("capture abandoned", log15.Ctx{"err": err})
+				ErrTimeout.Write(w)
+			default:
+				log.Error("error on capture", log15.Ctx{"err": err})
+				resp := ErrSystem
+				resp.Info = "capture failed"
+				resp.Write(w)
+			}
+			return
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "payment captured, payment is " + p.Status.String()
+		resp.Response, err = notification.New(a.paymentService.EncodedPaymentID(p.PaymentID()), p)
+		if err != nil {
+			log.Error("error creating payment representation", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -0,0 +1,101 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/listing"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ProjectChangeResponse is the representation of a payment change in the change
+// feed
+type ProjectChangeResponse struct {
+	Sequence  int64 `json:",string"`
+	PaymentId payment.PaymentID
+	Timestamp int64 `json:",string"`
+	Type      string
+	Data      json.RawMessage
+}
+
+// ProjectChangeFeedResponse is the response of the change feed
+type ProjectChangeFeedResponse struct {
+	Changes []ProjectChangeResponse
+	// Cursor is the cursor for the subsequent request. It points to the last
+	// returned change or is the requested cursor if no changes were returned
+	Cursor string
+}
+
+// ProjectChangeFeedRequest returns a handler for the payment change feed of a
+// project
+//
+// GET returns the changes following the requested cursor in the feed order
+func (a *AdminAPI) ProjectChangeFeedRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectChangeFeedRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		q, err := listing.ParseQuery(r.URL.Query())
+		if err == nil && (q.Sort != "" || len(q.Fields) > 0) {
+			err = listing.ErrInvalidSort
+		}
+		var after int64
+		if err == nil && q.Cursor != nil {
+			after, err = strconv.ParseInt(q.Cursor.Key, 10, 64)
+			if err != nil {
+				err = listing.ErrInvalidCursor
+			}
+		}
+		if err != nil {
+			log.Info("invalid feed parameters", log15.Ctx{"err": err})
+			resp := ErrReadParam
+			resp.Info = err.Error()
+			resp.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID})
+
+		changes, err := a.paymentService.Changes(projectID, after, q.Limit)
+		if err != nil {
+			log.Error("error retrieving changes", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		feed := ProjectChangeFeedResponse{
+			Changes: make([]ProjectChangeResponse, len(changes)),
+		}
+		for i, c := range changes {
+			feed.Changes[i] = ProjectChangeResponse{
+				Sequence:  c.Sequence,
+				PaymentId: a.paymentService.EncodedPaymentID(payment.PaymentID{ProjectID: c.ProjectID, PaymentID: c.PaymentID}),
+				Timestamp: c.Timestamp.UnixNano(),
+				Type:      c.Type,
+				Data:      c.Data,
+			}
+			after = c.Sequence
+		}
+		feed.Cursor = listing.Cursor{Key: strconv.FormatInt(after, 10)}.Encode()
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(changes)) + " changes found"
+		resp.Response = feed
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
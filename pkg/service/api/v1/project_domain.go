@@ -0,0 +1,373 @@
+package v1
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// lookupTXT resolves the TXT records for domain challenges
+//
+// It can be replaced for testing.
+var lookupTXT = net.LookupTXT
+
+// ProjectDomainRequest is the request JSON struct for PUT
+// project/(id)/domain
+//
+// The TLS files must be located in the configured domain certificate
+// directory. They can be given relative to the directory.
+type ProjectDomainRequest struct {
+	Domain      string
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// ProjectDomainResponse is the representation of a project domain
+type ProjectDomainResponse struct {
+	Domain *project.Domain
+	// ChallengeRecord is the name of the TXT record which must contain the
+	// challenge
+	ChallengeRecord string
+	HasTLS          bool
+}
+
+func newProjectDomainResponse(d *project.Domain) ProjectDomainResponse {
+	return ProjectDomainResponse{
+		Domain:          d,
+		ChallengeRecord: d.ChallengeRecord(),
+		HasTLS:          d.HasTLS(),
+	}
+}
+
+func (a *AdminAPI) projectIDParam(w http.ResponseWriter, r *http.Request, log log15.Logger) (int64, bool) {
+	projectIDParam := mux.Vars(r)["projectid"]
+	projectID, err := strconv.ParseInt(projectIDParam, 10, 64)
+	if err != nil {
+		log.Warn("param projectid conversion error", log15.Ctx{"err": err})
+		ErrReadParam.Write(w)
+		return 0, false
+	}
+	return projectID, true
+}
+
+// ProjectDomainRequest returns a handler to list and register checkout domains
+//
+// GET lists the domains of the project
+// PUT registers a new (unverified) domain and issues a challenge
+func (a *AdminAPI) ProjectDomainRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectDomainRequest"})
+		switch r.Method {
+		case "GET":
+			a.getProjectDomains(w, r)
+		case "PUT":
+			a.putNewProjectDomain(w, r)
+		default:
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+func (a *AdminAPI) getProjectDomains(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(a.ctx, r)
+	log := a.log.New(log15.Ctx{"method": "getProjectDomains"})
+	projectID, ok := a.projectIDParam(w, r, log)
+	if !ok {
+		return
+	}
+	q, ok := listQuery(w, r, project.DomainListing, log)
+	if !ok {
+		return
+	}
+	domains, page, err := project.DomainsByProjectIDListDB(ctx, a.ctx.PrincipalDB(service.ReadOnly), projectID, q)
+	if err != nil {
+		log.Error("error retrieving domains", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	domainsResp := make([]ProjectDomainResponse, len(domains))
+	for i, d := range domains {
+		domainsResp[i] = newProjectDomainResponse(d)
+	}
+	items, ok := selectFields(w, q, domainsResp)
+	if !ok {
+		return
+	}
+	setPageHeader(w, r, q, page)
+	resp := ProjectAdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = strconv.Itoa(len(domains)) + " domains found"
+	resp.Response = items
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("write error", log15.Ctx{"err": err})
+	}
+}
+
+func (a *AdminAPI) putNewProjectDomain(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(a.ctx, r)
+	log := a.log.New(log15.Ctx{"method": "putNewProjectDomain"})
+	auth, err := getAuthContainer(r)
+	if err != nil {
+		log.Crit("auth container error", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return
+	}
+	projectID, ok := a.projectIDParam(w, r, log)
+	if !ok {
+		return
+	}
+	req := ProjectDomainRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	r.Body.Close()
+	if err != nil {
+		log.Warn("json decode failed", log15.Ctx{"err": err})
+		ErrReadJson.Write(w)
+		return
+	}
+	if (req.TLSCertFile == "") != (req.TLSKeyFile == "") {
+		resp := ErrInval
+		resp.Info = "TLS requires both a certificate and a key file"
+		resp.Write(w)
+		return
+	}
+	d, err := project.NewDomain(projectID, req.Domain, auth[AuthUserIDKey].(string))
+	if err != nil {
+		if err == project.ErrInvalidDomain {
+			resp := ErrInval
+			resp.Info = err.Error()
+			resp.Write(w)
+			return
+		}
+		log.Error("error creating domain", log15.Ctx{"err": err})
+		ErrSystem.Write(w)
+		return
+	}
+	log = log.New(log15.Ctx{"projectID": projectID, "domain": d.Domain})
+	if req.TLSCertFile != "" {
+		certDir := a.ctx.Config().Web.TLS.DomainCertDir
+		certFile, err := project.DomainTLSFile(certDir, req.TLSCertFile)
+		if err != nil {
+			resp := ErrInval
+			resp.Info = "TLS certificate file: " + err.Error()
+			resp.Write(w)
+			return
+		}
+		keyFile, err := project.DomainTLSFile(certDir, req.TLSKeyFile)
+		if err != nil {
+			resp := ErrInval
+			resp.Info = "TLS key file: " + err.Error()
+			resp.Write(w)
+			return
+		}
+		_, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Warn("invalid TLS certificate", log15.Ctx{"err": err})
+			resp := ErrInval
+			resp.Info = "invalid TLS certificate"
+			resp.Write(w)
+			return
+		}
+		d.SetTLS(certFile, keyFile)
+	}
+
+	var tx *sql.Tx
+	var commit bool
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = a.ctx.PrincipalDB().Begin()
+	if err != nil {
+		commit = true
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	_, err = project.ProjectByIDTx(ctx, tx, projectID)
+	if err != nil {
+		if err == project.ErrProjectNotFound {
+			resp := ErrNotFound
+			resp.Info = "project not found"
+			resp.Write(w)
+			return
+		}
+		log.Error("error retrieving project", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	// a domain can be used by one project only
+	existing, err := project.VerifiedDomainByNameTx(ctx, tx, d.Domain)
+	if err != nil && err != project.ErrDomainNotFound {
+		log.Error("error retrieving domain", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	if err == nil && existing.ProjectID != projectID {
+		log.Warn("domain already verified for another project", log15.Ctx{"otherProjectID": existing.ProjectID})
+		ErrConflict.Write(w)
+		return
+	}
+	err = project.InsertDomainTx(ctx, tx, d)
+	if err != nil {
+		log.Error("error saving domain", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		ErrDatabase.Write(w)
+		return
+	}
+	commit = true
+	// a verified domain of the project needs to be verified again
+	a.paymentService.ForgetVerifiedDomain(d.Domain)
+
+	resp := ProjectAdminAPIResponse{}
+	resp.Status = StatusSuccess
+	resp.Info = "domain registered. awaiting verification"
+	resp.Response = newProjectDomainResponse(d)
+	err = resp.Write(w)
+	if err != nil {
+		log.Error("write error", log15.Ctx{"err": err})
+	}
+}
+
+// ProjectDomainVerifyRequest returns a handler which will verify the ownership
+// of a registered domain
+//
+// The challenge value must be present in a TXT record of the challenge record
+// name.
+func (a *AdminAPI) ProjectDomainVerifyRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectDomainVerifyRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "POST" {
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("auth container error", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		name, err := project.NormalizeDomain(mux.Vars(r)["domain"])
+		if err != nil {
+			ErrReadParam.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID, "domain": name})
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.PrincipalDB().Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		d, err := project.DomainByProjectIDAndNameTx(ctx, tx, projectID, name)
+		if err != nil {
+			if err == project.ErrDomainNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving domain", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if d.Verified {
+			resp := ProjectAdminAPIResponse{}
+			resp.Status = StatusSuccess
+			resp.Info = "domain already verified"
+			resp.Response = newProjectDomainResponse(d)
+			resp.Write(w)
+			return
+		}
+		records, err := lookupTXT(d.ChallengeRecord())
+		if err != nil || !d.MatchChallenge(records) {
+			if Debug {
+				log.Debug("challenge failed", log15.Ctx{"err": err, "records": records})
+			}
+			resp := ErrConflict
+			resp.Info = "challenge record not found"
+			resp.Response = newProjectDomainResponse(d)
+			resp.Write(w)
+			return
+		}
+		// another project might have verified the domain since it was
+		// registered
+		existing, err := project.VerifiedDomainByNameTx(ctx, tx, d.Domain)
+		if err != nil && err != project.ErrDomainNotFound {
+			log.Error("error retrieving domain", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if err == nil && existing.ProjectID != projectID {
+			log.Warn("domain already verified for another project", log15.Ctx{"otherProjectID": existing.ProjectID})
+			resp := ErrConflict
+			resp.Info = "domain already verified for another project"
+			resp.Write(w)
+			return
+		}
+		d.Verified = true
+		d.CreatedBy = auth[AuthUserIDKey].(string)
+		err = project.InsertDomainTx(ctx, tx, d)
+		if err != nil {
+			log.Error("error saving domain", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		a.paymentService.ForgetVerifiedDomain(d.Domain)
+
+		resp := ProjectAdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "domain verified"
+		resp.Response = newProjectDomainResponse(d)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
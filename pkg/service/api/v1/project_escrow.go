@@ -0,0 +1,230 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ProjectEscrowBalance is the amount held in escrow for the payments of a
+// project in a currency
+//
+// Held funds are not eligible for payout.
+type ProjectEscrowBalance struct {
+	Currency string
+	Held     string
+	Payments int64 `json:",string"`
+	// Due is the number of payments due for the automatic release
+	Due int64 `json:",string"`
+}
+
+// ProjectPaymentEscrow is the representation of the escrow status of a payment
+type ProjectPaymentEscrow struct {
+	PaymentId    payment.PaymentID
+	Status       string
+	Amount       string
+	Currency     string
+	Timestamp    string
+	ReleaseAfter string `json:",omitempty"`
+	CreatedBy    string
+	Comment      string `json:",omitempty"`
+}
+
+// ProjectPaymentEscrowRelease is a request to release a payment held in escrow
+type ProjectPaymentEscrowRelease struct {
+	Comment string
+}
+
+// ProjectEscrowRequest returns a handler for the escrow balances of a project
+//
+// GET returns the amounts held in escrow by currency
+func (a *AdminAPI) ProjectEscrowRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectEscrowRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID})
+		balances, err := payment.EscrowBalancesDB(ctx, a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, time.Now())
+		if err != nil {
+			log.Error("error retrieving escrow balances", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		results := make([]ProjectEscrowBalance, len(balances))
+		for i, b := range balances {
+			results[i] = ProjectEscrowBalance{
+				Currency: b.Currency,
+				Held:     b.HeldDecimal().String(),
+				Payments: b.Payments,
+				Due:      b.Due,
+			}
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(results)) + " currencies held in escrow"
+		resp.Response = results
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ProjectPaymentEscrowRequest returns a handler for the escrow of a payment
+//
+// GET returns the current escrow status of the payment. PUT releases the funds
+// held in escrow, e.g. when the delivery was confirmed.
+func (a *AdminAPI) ProjectPaymentEscrowRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentEscrowRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" && r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(mux.Vars(r)["paymentid"])
+		if err != nil || paymentID.ProjectID != projectID {
+			resp := ErrReadParam
+			resp.Info = "invalid payment id"
+			resp.Write(w)
+			return
+		}
+		req := ProjectPaymentEscrowRelease{}
+		if r.Method == "PUT" {
+			err = json.NewDecoder(r.Body).Decode(&req)
+			r.Body.Close()
+			if err != nil {
+				log.Error("json decode failed", log15.Ctx{"err": err})
+				ErrReadJson.Write(w)
+				return
+			}
+		}
+		log = log.New(log15.Ctx{
+			"projectID":        projectID,
+			"DisplayPaymentId": paymentID.String(),
+		})
+		displayID := paymentID
+		paymentID = a.paymentService.DecodedPaymentID(paymentID)
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.ProjectPaymentDB(projectID).Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		p, err := payment.PaymentByIDTx(ctx, tx, paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		var e *payment.Escrow
+		commitIntent := paymentService.CommitIntentFunc(func() error { return nil })
+		if r.Method == "GET" {
+			e, err = a.paymentService.PaymentEscrow(tx, p)
+			if err == payment.ErrEscrowNotFound {
+				resp := ErrNotFound
+				resp.Info = "payment was not held in escrow"
+				resp.Write(w)
+				return
+			}
+		} else {
+			e, commitIntent, err = a.paymentService.ReleaseEscrow(tx, p, auth[AuthUserIDKey].(string), req.Comment)
+			if errors.Is(err, paymentService.ErrEscrowNotHeld) {
+				resp := ErrConflict
+				resp.Info = "payment is not held in escrow"
+				resp.Write(w)
+				return
+			}
+		}
+		if err != nil {
+			log.Error("error on payment escrow", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = commitIntent()
+		if err != nil {
+			log.Error("error committing intent", log15.Ctx{"err": err})
+		}
+
+		escrow := ProjectPaymentEscrow{
+			PaymentId: displayID,
+			Status:    e.Status,
+			Amount:    e.Decimal().String(),
+			Currency:  p.Currency,
+			Timestamp: e.Timestamp.UTC().Format(time.RFC3339),
+			CreatedBy: e.CreatedBy,
+			Comment:   e.Comment.String,
+		}
+		if !e.ReleaseAfter.IsZero() {
+			escrow.ReleaseAfter = e.ReleaseAfter.UTC().Format(time.RFC3339)
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "payment escrow is " + e.Status
+		resp.Response = escrow
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -0,0 +1,109 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ProjectPaymentOverview is the representation of a payment overview
+type ProjectPaymentOverview struct {
+	PaymentId       payment.PaymentID
+	Ident           string
+	Created         string
+	Amount          string
+	Currency        string
+	Status          string `json:",omitempty"`
+	StatusChanged   string `json:",omitempty"`
+	Balance         string
+	Held            string `json:",omitempty"`
+	PaymentMethodId int64  `json:",string,omitempty"`
+	Provider        string `json:",omitempty"`
+	Country         string `json:",omitempty"`
+}
+
+// ProjectPaymentOverviewRequest returns a handler for the payment overviews of a
+// project
+//
+// GET lists the payments of the project with their latest status from the
+// projected payment overview. The current status can be filtered with the
+// query parameter "status"
+func (a *AdminAPI) ProjectPaymentOverviewRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentOverviewRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		if !a.ctx.Config().Payment.Projection {
+			resp := ErrConflict
+			resp.Info = "payment projection disabled"
+			resp.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		q, ok := listQuery(w, r, payment.OverviewListing, log)
+		if !ok {
+			return
+		}
+		status := payment.PaymentTransactionStatus(r.URL.Query().Get("status"))
+		log = log.New(log15.Ctx{"projectID": projectID})
+
+		overviews, page, err := payment.PaymentOverviewsDB(ctx, a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, status, q)
+		if err != nil {
+			log.Error("error retrieving payment overviews", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		results := make([]ProjectPaymentOverview, len(overviews))
+		for i, o := range overviews {
+			results[i] = ProjectPaymentOverview{
+				PaymentId: a.paymentService.EncodedPaymentID(payment.PaymentID{
+					ProjectID: o.ProjectID,
+					PaymentID: o.PaymentID,
+				}),
+				Ident:           o.Ident,
+				Created:         o.Created.UTC().Format(time.RFC3339),
+				Amount:          o.Decimal().String(),
+				Currency:        o.Currency,
+				Status:          o.Status.String(),
+				Balance:         o.BalanceDecimal().String(),
+				PaymentMethodId: o.PaymentMethodID,
+				Provider:        o.Provider,
+				Country:         o.Country,
+			}
+			if o.Held != 0 {
+				results[i].Held = o.HeldDecimal().String()
+			}
+			if !o.StatusTimestamp.IsZero() {
+				results[i].StatusChanged = o.StatusTimestamp.UTC().Format(time.RFC3339)
+			}
+		}
+		items, ok := selectFields(w, q, results)
+		if !ok {
+			return
+		}
+		setPageHeader(w, r, q, page)
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(results)) + " payments found"
+		resp.Response = items
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
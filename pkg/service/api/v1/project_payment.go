@@ -0,0 +1,561 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/sca"
+	"github.com/fritzpay/paymentd/pkg/service"
+	notification "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// the minimum length of a search term
+	searchTermMinLength = 3
+)
+
+// ProjectPaymentSearchRequest returns a handler to search the payments of a
+// project
+//
+// GET searches the payments by ident, metadata values and note. The search term
+// is passed as the query parameter "q"
+func (a *AdminAPI) ProjectPaymentSearchRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentSearchRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		term := strings.TrimSpace(r.URL.Query().Get("q"))
+		if len(term) < searchTermMinLength {
+			resp := ErrReadParam
+			resp.Info = "search term too short"
+			resp.Write(w)
+			return
+		}
+		q, ok := listQuery(w, r, payment.PaymentListing, log)
+		if !ok {
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID})
+
+		db := a.ctx.ProjectPaymentDB(projectID, service.ReadOnly)
+		payments, page, err := payment.PaymentsByMetadataSearchDB(ctx, db, projectID, term, q)
+		if err != nil {
+			log.Error("error searching payments", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		schema, err := a.paymentService.MetadataSchema(projectID)
+		if err != nil {
+			log.Error("error retrieving metadata schema", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		results := make([]*notification.Notification, len(payments))
+		for i, p := range payments {
+			err = payment.PaymentMetadataDB(ctx, db, p)
+			if err != nil {
+				log.Error("error retrieving payment metadata", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			err = payment.PaymentNoteDB(ctx, db, p)
+			if err != nil {
+				log.Error("error retrieving payment note", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			err = payment.PaymentBillingDB(ctx, db, p)
+			if err != nil {
+				log.Error("error retrieving payment billing", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			err = payment.PaymentReferenceDB(ctx, db, p)
+			if err != nil {
+				log.Error("error retrieving payment reference", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			results[i], err = notification.New(a.paymentService.EncodedPaymentID(p.PaymentID()), p)
+			if err != nil {
+				log.Error("error creating payment representation", log15.Ctx{"err": err})
+				ErrSystem.Write(w)
+				return
+			}
+			results[i].SetMetadataSchema(schema)
+		}
+		items, ok := selectFields(w, q, results)
+		if !ok {
+			return
+		}
+		setPageHeader(w, r, q, page)
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(results)) + " payments found"
+		resp.Response = items
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ProjectPaymentUpdate is the update of a payment
+//
+// Omitted fields will not be changed.
+type ProjectPaymentUpdate struct {
+	// Note replaces the note of the payment. An empty note clears the note
+	Note *string
+	// RiskScore records a risk assessment of the payment from 0 (lowest risk)
+	// to 100. It is used when requesting TRA exemptions
+	RiskScore *int
+	// RiskSource names the risk check which assessed the payment
+	RiskSource string
+}
+
+// ProjectPaymentRequest returns a handler for a payment of a project
+//
+// PATCH updates the note or the risk score of the payment
+func (a *AdminAPI) ProjectPaymentRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "PATCH" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(mux.Vars(r)["paymentid"])
+		if err != nil || paymentID.ProjectID != projectID {
+			resp := ErrReadParam
+			resp.Info = "invalid payment id"
+			resp.Write(w)
+			return
+		}
+		req := ProjectPaymentUpdate{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		if req.Note == nil && req.RiskScore == nil {
+			resp := ErrInval
+			resp.Info = "nothing to update"
+			resp.Write(w)
+			return
+		}
+		if req.Note != nil && !payment.ValidNote(*req.Note) {
+			resp := ErrInval
+			resp.Info = "invalid Note"
+			resp.Write(w)
+			return
+		}
+		if req.RiskScore != nil && (*req.RiskScore < 0 || *req.RiskScore > sca.MaxRiskScore) {
+			resp := ErrInval
+			resp.Info = "invalid RiskScore"
+			resp.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":        projectID,
+			"DisplayPaymentId": paymentID.String(),
+		})
+		paymentID = a.paymentService.DecodedPaymentID(paymentID)
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.ProjectPaymentDB(projectID).Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		p, err := payment.PaymentByIDTx(ctx, tx, paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = payment.PaymentMetadataTx(ctx, tx, p)
+		if err != nil {
+			log.Error("error retrieving payment metadata", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if req.Note != nil {
+			p.Note = p.NewNote(*req.Note, auth[AuthUserIDKey].(string))
+			err = a.paymentService.SetPaymentNote(tx, p)
+			if err != nil {
+				log.Error("error saving payment note", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+		}
+		if req.RiskScore != nil {
+			err = a.paymentService.SetPaymentRiskScore(tx, p, *req.RiskScore, req.RiskSource)
+			if err != nil {
+				log.Error("error saving payment risk", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "payment updated"
+		resp.Response, err = notification.New(a.paymentService.EncodedPaymentID(p.PaymentID()), p)
+		if err != nil {
+			log.Error("error creating payment representation", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ProjectPaymentOrderTotal is the aggregation of the payments of an order in
+// one currency
+type ProjectPaymentOrderTotal struct {
+	Currency string
+	Payments int
+	Amount   string
+	Paid     string
+}
+
+// ProjectPaymentOrderResponse is the representation of an order of related
+// payments
+type ProjectPaymentOrderResponse struct {
+	RootPaymentId payment.PaymentID
+	// Payments holds the root payment first, followed by its descendants
+	Payments []*notification.Notification
+	Totals   []ProjectPaymentOrderTotal
+}
+
+// ProjectPaymentOrderRequest returns a handler for the order of a payment
+//
+// GET returns the tree of payments related to the payment with aggregated
+// amounts by currency
+func (a *AdminAPI) ProjectPaymentOrderRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentOrderRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(mux.Vars(r)["paymentid"])
+		if err != nil || paymentID.ProjectID != projectID {
+			resp := ErrReadParam
+			resp.Info = "invalid payment id"
+			resp.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":        projectID,
+			"DisplayPaymentId": paymentID.String(),
+		})
+		paymentID = a.paymentService.DecodedPaymentID(paymentID)
+
+		db := a.ctx.ProjectPaymentDB(projectID, service.ReadOnly)
+		p, err := payment.PaymentByIDDB(ctx, db, paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		order, err := payment.OrderDB(ctx, db, p)
+		if err != nil {
+			if err == payment.ErrOrderTooLarge {
+				resp := ErrConflict
+				resp.Info = err.Error()
+				resp.Write(w)
+				return
+			}
+			log.Error("error retrieving order", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		orderResp := ProjectPaymentOrderResponse{
+			RootPaymentId: a.paymentService.EncodedPaymentID(order.Root().PaymentID()),
+			Payments:      make([]*notification.Notification, len(order.Payments)),
+		}
+		for i, p := range order.Payments {
+			orderResp.Payments[i], err = notification.New(a.paymentService.EncodedPaymentID(p.PaymentID()), p)
+			if err != nil {
+				log.Error("error creating payment representation", log15.Ctx{"err": err})
+				ErrSystem.Write(w)
+				return
+			}
+			if parentID, ok := p.ParentPaymentID(); ok {
+				orderResp.Payments[i].SetParent(a.paymentService.EncodedPaymentID(parentID), p.Parent.Type)
+			}
+		}
+		for _, t := range order.Totals() {
+			orderResp.Totals = append(orderResp.Totals, ProjectPaymentOrderTotal{
+				Currency: t.Currency,
+				Payments: t.Payments,
+				Amount:   t.Amount.String(),
+				Paid:     t.Paid.String(),
+			})
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(order.Payments)) + " payments in order"
+		resp.Response = orderResp
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ProjectPaymentStateResponse is the representation of the state of a payment
+// at a point in time
+type ProjectPaymentStateResponse struct {
+	// At is the requested point in time
+	At      time.Time
+	Payment *notification.Notification
+	// ConfigTimestamp is the time the effective payment config was written
+	ConfigTimestamp *time.Time `json:",omitempty"`
+	// MetadataTimestamp is the time the effective metadata was written
+	MetadataTimestamp *time.Time `json:",omitempty"`
+}
+
+// ProjectPaymentStateRequest returns a handler for the state of a payment at a
+// point in time
+//
+// GET returns the status, config, metadata and balance of the payment which were
+// in effect at the time given as the RFC3339 query parameter "at"
+func (a *AdminAPI) ProjectPaymentStateRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentStateRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(mux.Vars(r)["paymentid"])
+		if err != nil || paymentID.ProjectID != projectID {
+			resp := ErrReadParam
+			resp.Info = "invalid payment id"
+			resp.Write(w)
+			return
+		}
+		at, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("at"))
+		if err != nil {
+			resp := ErrReadParam
+			resp.Info = "invalid time. the parameter at must be an RFC3339 date/time"
+			resp.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":        projectID,
+			"DisplayPaymentId": paymentID.String(),
+			"at":               at,
+		})
+		paymentID = a.paymentService.DecodedPaymentID(paymentID)
+
+		db := a.ctx.ProjectPaymentDB(projectID, service.ReadOnly)
+		p, err := payment.PaymentByIDAtDB(ctx, db, paymentID, at)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		metadataTimestamp, err := payment.PaymentMetadataAtDB(ctx, db, p, at)
+		if err != nil {
+			log.Error("error retrieving payment metadata", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = payment.PaymentParentDB(ctx, db, p)
+		if err != nil {
+			log.Error("error retrieving parent payment relation", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		stateResp := ProjectPaymentStateResponse{At: at}
+		stateResp.Payment, err = notification.New(a.paymentService.EncodedPaymentID(p.PaymentID()), p)
+		if err != nil {
+			log.Error("error creating payment representation", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		if parentID, ok := p.ParentPaymentID(); ok {
+			stateResp.Payment.SetParent(a.paymentService.EncodedPaymentID(parentID), p.Parent.Type)
+		}
+		if p.HasTransaction() {
+			tl, err := payment.PaymentTransactionsBeforeTimestampDB(ctx, db, p, p.TransactionTimestamp)
+			if err != nil && err != payment.ErrPaymentTransactionNotFound {
+				log.Error("error retrieving payment transactions", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			stateResp.Payment.SetTransactions(tl)
+		}
+		if !p.Config.Timestamp.IsZero() {
+			stateResp.ConfigTimestamp = &p.Config.Timestamp
+		}
+		if !metadataTimestamp.IsZero() {
+			stateResp.MetadataTimestamp = &metadataTimestamp
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "payment state at " + at.Format(time.RFC3339Nano)
+		resp.Response = stateResp
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ProjectPaymentRejectionsRequest returns a handler for the rejected intents of
+// a payment
+//
+// GET returns the rejected status changes of the payment, oldest first
+func (a *AdminAPI) ProjectPaymentRejectionsRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentRejectionsRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(mux.Vars(r)["paymentid"])
+		if err != nil || paymentID.ProjectID != projectID {
+			resp := ErrReadParam
+			resp.Info = "invalid payment id"
+			resp.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":        projectID,
+			"DisplayPaymentId": paymentID.String(),
+		})
+		paymentID = a.paymentService.DecodedPaymentID(paymentID)
+
+		db := a.ctx.ProjectPaymentDB(projectID, service.ReadOnly)
+		p, err := payment.PaymentByIDDB(ctx, db, paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		rejections, err := payment.PaymentIntentRejectionsDB(ctx, db, p)
+		if err != nil {
+			log.Error("error retrieving rejected intents", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(rejections)) + " rejected intents"
+		resp.Response = rejections
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
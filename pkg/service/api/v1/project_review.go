@@ -0,0 +1,235 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	notification "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// review decisions
+const (
+	ReviewDecisionApprove = "approve"
+	ReviewDecisionDecline = "decline"
+)
+
+// ProjectReviewQueueEntry is a payment held for manual review
+type ProjectReviewQueueEntry struct {
+	Payment *notification.Notification
+	Held    string
+	HeldBy  string
+	Reason  string `json:",omitempty"`
+	// Due is the time until which the payment should be reviewed
+	Due     string `json:",omitempty"`
+	Overdue bool
+}
+
+// ProjectPaymentReview is a review decision on a held payment
+type ProjectPaymentReview struct {
+	// Decision is either "approve" or "decline"
+	Decision string
+	Comment  string
+}
+
+// ProjectReviewQueueRequest returns a handler for the review queue of a project
+//
+// GET returns a page of the payments held for manual review, ordered by their
+// due time by default
+func (a *AdminAPI) ProjectReviewQueueRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectReviewQueueRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID})
+		q, ok := listQuery(w, r, payment.ReviewQueueListing, log)
+		if !ok {
+			return
+		}
+		queue, page, err := payment.ReviewQueueDB(ctx, a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, q)
+		if err != nil {
+			log.Error("error retrieving review queue", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		entries := make([]ProjectReviewQueueEntry, len(queue))
+		for i, held := range queue {
+			entries[i].Payment, err = notification.New(a.paymentService.EncodedPaymentID(held.Payment.PaymentID()), held.Payment)
+			if err != nil {
+				log.Error("error creating payment representation", log15.Ctx{"err": err})
+				ErrSystem.Write(w)
+				return
+			}
+			entries[i].Held = held.Review.Timestamp.UTC().Format(time.RFC3339)
+			entries[i].HeldBy = held.Review.CreatedBy
+			entries[i].Reason = held.Review.Comment.String
+			if !held.Review.Due.IsZero() {
+				entries[i].Due = held.Review.Due.UTC().Format(time.RFC3339)
+			}
+			entries[i].Overdue = held.Review.Overdue()
+		}
+		items, ok := selectFields(w, q, entries)
+		if !ok {
+			return
+		}
+		setPageHeader(w, r, q, page)
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = strconv.Itoa(len(entries)) + " payments held for review"
+		resp.Response = items
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ProjectPaymentReviewRequest returns a handler to review a held payment
+//
+// PUT approves or declines the payment. Approved payments will be open again, so
+// that the customer can continue the checkout. Declined payments will be
+// cancelled.
+func (a *AdminAPI) ProjectPaymentReviewRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentReviewRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(mux.Vars(r)["paymentid"])
+		if err != nil || paymentID.ProjectID != projectID {
+			resp := ErrReadParam
+			resp.Info = "invalid payment id"
+			resp.Write(w)
+			return
+		}
+		req := ProjectPaymentReview{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		if req.Decision != ReviewDecisionApprove && req.Decision != ReviewDecisionDecline {
+			resp := ErrInval
+			resp.Info = "invalid decision"
+			resp.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":        projectID,
+			"DisplayPaymentId": paymentID.String(),
+			"decision":         req.Decision,
+		})
+		paymentID = a.paymentService.DecodedPaymentID(paymentID)
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.ProjectPaymentDB(projectID).Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		p, err := payment.PaymentByIDTx(ctx, tx, paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		var commitIntent paymentService.CommitIntentFunc
+		userID := auth[AuthUserIDKey].(string)
+		if req.Decision == ReviewDecisionApprove {
+			commitIntent, err = a.paymentService.ApprovePayment(tx, p, userID, req.Comment)
+		} else {
+			commitIntent, err = a.paymentService.DeclinePayment(tx, p, userID, req.Comment)
+		}
+		if err != nil {
+			if errors.Is(err, paymentService.ErrPaymentNotHeld) {
+				resp := ErrConflict
+				resp.Info = "payment is " + p.Status.String()
+				resp.Write(w)
+				return
+			}
+			log.Error("error on payment review", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = commitIntent()
+		if err != nil {
+			log.Error("error committing intent", log15.Ctx{"err": err})
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "payment reviewed, payment is " + p.Status.String()
+		resp.Response, err = notification.New(a.paymentService.EncodedPaymentID(p.PaymentID()), p)
+		if err != nil {
+			log.Error("error creating payment representation", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -0,0 +1,434 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/subscription"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// SubscriptionPlan is the admin API representation of a subscription plan
+type SubscriptionPlan struct {
+	Name string
+	// Amount is the decimal amount charged per interval
+	Amount   string
+	Currency string
+	// IntervalUnit is one of day, week, month or year
+	IntervalUnit  string
+	IntervalCount int
+}
+
+// ProjectSubscription is the admin API representation of a subscription
+type ProjectSubscription struct {
+	ID               int64
+	InitialPaymentId payment.PaymentID
+	Plan             SubscriptionPlan
+	Start            string
+	Created          string
+	CreatedBy        string
+	// Email is the address of the customer, which is notified of failed
+	// charges
+	Email         string `json:",omitempty"`
+	Status        string
+	StatusChanged string
+	// Cycle is the number of charged cycles
+	Cycle int64
+	// NextCharge is the time when the next cycle of an active subscription or
+	// the retry of a past due subscription is due
+	NextCharge string `json:",omitempty"`
+	// Failures is the number of consecutive failed charges of the last cycle
+	Failures int `json:",omitempty"`
+	// LastPaymentId is the payment of the last charged cycle
+	LastPaymentId *payment.PaymentID `json:",omitempty"`
+	Comment       string             `json:",omitempty"`
+}
+
+// ProjectSubscriptionCreate is a request to create a subscription
+type ProjectSubscriptionCreate struct {
+	// InitialPaymentId is the paid payment which provides the payment
+	// instrument for the recurring charges
+	InitialPaymentId string
+	Plan             SubscriptionPlan
+	// Start is the time of the first charge in RFC3339 format. It defaults to
+	// one plan interval after now.
+	Start string
+	// Email is the optional address of the customer, which will be notified
+	// of failed charges
+	Email string
+}
+
+// ProjectSubscriptionStatus is a request to pause, resume or cancel a
+// subscription
+type ProjectSubscriptionStatus struct {
+	Status  string
+	Comment string
+}
+
+func (a *AdminAPI) subscription(sub *subscription.Subscription) ProjectSubscription {
+	s := ProjectSubscription{
+		ID: sub.ID,
+		InitialPaymentId: a.paymentService.EncodedPaymentID(payment.PaymentID{
+			ProjectID: sub.ProjectID,
+			PaymentID: sub.InitialPaymentID,
+		}),
+		Plan: SubscriptionPlan{
+			Name:          sub.Plan.Name,
+			Amount:        sub.Plan.Decimal().String(),
+			Currency:      sub.Plan.Currency,
+			IntervalUnit:  sub.Plan.Interval.Unit,
+			IntervalCount: sub.Plan.Interval.Count,
+		},
+		Start:         sub.Start.UTC().Format(time.RFC3339),
+		Created:       sub.Created.UTC().Format(time.RFC3339),
+		CreatedBy:     sub.CreatedBy,
+		Email:         sub.Email.String,
+		Status:        sub.Status.Status,
+		StatusChanged: sub.Status.Timestamp.UTC().Format(time.RFC3339),
+		Cycle:         sub.Status.Cycle,
+		Failures:      sub.Status.Failures,
+		Comment:       sub.Status.Comment.String,
+	}
+	if sub.Active() || sub.PastDue() {
+		s.NextCharge = sub.Status.NextCharge.UTC().Format(time.RFC3339)
+	}
+	if sub.Status.PaymentID.Valid {
+		id := a.paymentService.EncodedPaymentID(payment.PaymentID{
+			ProjectID: sub.ProjectID,
+			PaymentID: sub.Status.PaymentID.Int64,
+		})
+		s.LastPaymentId = &id
+	}
+	return s
+}
+
+// ProjectSubscriptionsRequest returns a handler for the subscriptions of a
+// project
+//
+// GET lists the most recent subscriptions of the project. POST creates a new
+// subscription for a paid initial payment.
+func (a *AdminAPI) ProjectSubscriptionsRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectSubscriptionsRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" && r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		log = log.New(log15.Ctx{"projectID": projectID})
+		if r.Method == "GET" {
+			q, ok := listQuery(w, r, subscription.SubscriptionListing, log)
+			if !ok {
+				return
+			}
+			// most recent subscriptions first unless sorted explicitly
+			if q.Sort == "" {
+				q.Desc = true
+			}
+			list, page, err := subscription.SubscriptionsByProjectIDDB(ctx, a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, q)
+			if err != nil {
+				log.Error("error retrieving subscriptions", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			subscriptions := make([]ProjectSubscription, len(list))
+			for i, sub := range list {
+				subscriptions[i] = a.subscription(sub)
+			}
+			items, ok := selectFields(w, q, subscriptions)
+			if !ok {
+				return
+			}
+			setPageHeader(w, r, q, page)
+			resp := AdminAPIResponse{}
+			resp.Status = StatusSuccess
+			resp.Info = strconv.Itoa(len(list)) + " subscriptions found"
+			resp.Response = items
+			err = resp.Write(w)
+			if err != nil {
+				log.Error("write error", log15.Ctx{"err": err})
+			}
+			return
+		}
+
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		req := ProjectSubscriptionCreate{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		initialID, err := payment.ParsePaymentIDStr(req.InitialPaymentId)
+		if err != nil || initialID.ProjectID != projectID {
+			resp := ErrInval
+			resp.Info = "invalid InitialPaymentId"
+			resp.Write(w)
+			return
+		}
+		initialID = a.paymentService.DecodedPaymentID(initialID)
+		amount := dec.NewDecInt64(0)
+		if _, ok := amount.SetString(req.Plan.Amount); !ok || amount.Scale() < 0 || amount.Scale() > 8 {
+			resp := ErrInval
+			resp.Info = "invalid amount"
+			resp.Write(w)
+			return
+		}
+		sub := &subscription.Subscription{
+			ProjectID:        projectID,
+			CreatedBy:        auth[AuthUserIDKey].(string),
+			InitialPaymentID: initialID.PaymentID,
+			Plan: subscription.Plan{
+				Name:     req.Plan.Name,
+				Amount:   amount.Unscaled().Int64(),
+				Subunits: int8(amount.Scale()),
+				Currency: req.Plan.Currency,
+				Interval: subscription.Interval{
+					Unit:  req.Plan.IntervalUnit,
+					Count: req.Plan.IntervalCount,
+				},
+			},
+		}
+		err = sub.Plan.Validate()
+		if err != nil {
+			resp := ErrInval
+			resp.Info = err.Error()
+			resp.Write(w)
+			return
+		}
+		err = sub.SetEmail(req.Email)
+		if err != nil {
+			resp := ErrInval
+			resp.Info = err.Error()
+			resp.Write(w)
+			return
+		}
+		if req.Start != "" {
+			sub.Start, err = time.Parse(time.RFC3339, req.Start)
+			if err != nil || !sub.Start.After(time.Now()) {
+				resp := ErrInval
+				resp.Info = "invalid Start"
+				resp.Write(w)
+				return
+			}
+		}
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.ProjectPaymentDB(projectID).Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = a.paymentService.CreateSubscription(tx, sub, provider.ChargesRecurring)
+		if err != nil {
+			if errors.Is(err, paymentService.ErrSubscriptionPayment) {
+				resp := ErrInval
+				resp.Info = "InitialPaymentId must be paid in the plan currency"
+				resp.Write(w)
+				return
+			}
+			if errors.Is(err, paymentService.ErrSubscriptionMethod) {
+				resp := ErrInval
+				resp.Info = "the payment method of InitialPaymentId cannot charge recurring payments"
+				resp.Write(w)
+				return
+			}
+			log.Error("error creating subscription", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "subscription created"
+		resp.Response = a.subscription(sub)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// ProjectSubscriptionRequest returns a handler for a subscription of a project
+//
+// GET returns the subscription. PUT pauses, resumes or cancels the
+// subscription.
+func (a *AdminAPI) ProjectSubscriptionRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectSubscriptionRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "GET" && r.Method != "PUT" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		id, err := strconv.ParseInt(mux.Vars(r)["subscriptionid"], 10, 64)
+		if err != nil {
+			ErrReadParam.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":      projectID,
+			"subscriptionID": id,
+		})
+		if r.Method == "GET" {
+			sub, err := subscription.SubscriptionByIDDB(ctx, a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), id)
+			if err != nil {
+				if err == subscription.ErrSubscriptionNotFound {
+					ErrNotFound.Write(w)
+					return
+				}
+				log.Error("error retrieving subscription", log15.Ctx{"err": err})
+				ErrDatabase.Write(w)
+				return
+			}
+			if sub.ProjectID != projectID {
+				ErrNotFound.Write(w)
+				return
+			}
+			resp := AdminAPIResponse{}
+			resp.Status = StatusSuccess
+			resp.Info = "subscription is " + sub.Status.Status
+			resp.Response = a.subscription(sub)
+			err = resp.Write(w)
+			if err != nil {
+				log.Error("write error", log15.Ctx{"err": err})
+			}
+			return
+		}
+
+		auth, err := getAuthContainer(r)
+		if err != nil {
+			log.Crit("error getting auth container", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		req := ProjectSubscriptionStatus{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		r.Body.Close()
+		if err != nil {
+			log.Error("json decode failed", log15.Ctx{"err": err})
+			ErrReadJson.Write(w)
+			return
+		}
+		switch req.Status {
+		case subscription.StatusActive, subscription.StatusPaused, subscription.StatusCancelled:
+		default:
+			resp := ErrInval
+			resp.Info = "invalid Status"
+			resp.Write(w)
+			return
+		}
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = a.ctx.ProjectPaymentDB(projectID).Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		sub, err := subscription.SubscriptionByIDTx(ctx, tx, id)
+		if err != nil {
+			if err == subscription.ErrSubscriptionNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving subscription", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if sub.ProjectID != projectID {
+			ErrNotFound.Write(w)
+			return
+		}
+		err = a.paymentService.SetSubscriptionStatus(tx, sub, req.Status, auth[AuthUserIDKey].(string), req.Comment)
+		if err != nil {
+			if errors.Is(err, paymentService.ErrSubscriptionStatus) {
+				resp := ErrConflict
+				resp.Info = "subscription is " + sub.Status.Status
+				resp.Write(w)
+				return
+			}
+			log.Error("error changing subscription status", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "subscription is " + sub.Status.Status
+		resp.Response = a.subscription(sub)
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
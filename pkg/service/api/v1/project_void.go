@@ -0,0 +1,133 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	notification "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ProjectPaymentVoidRequest returns a handler to void the authorization of an
+// authorized payment
+//
+// POST voids the authorization with the provider of the payment method. The
+// payment will be voided and the authorized amount released.
+func (a *AdminAPI) ProjectPaymentVoidRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectPaymentVoidRequest"})
+		ctx := requestContext(a.ctx, r)
+		if r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		paymentID, err := payment.ParsePaymentIDStr(mux.Vars(r)["paymentid"])
+		if err != nil || paymentID.ProjectID != projectID {
+			resp := ErrReadParam
+			resp.Info = "invalid payment id"
+			resp.Write(w)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID":        projectID,
+			"DisplayPaymentId": paymentID.String(),
+		})
+		paymentID = a.paymentService.DecodedPaymentID(paymentID)
+
+		p, err := payment.PaymentByIDDB(ctx, a.ctx.ProjectPaymentDB(paymentID.ProjectID, service.ReadOnly), paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if !p.Config.PaymentMethodID.Valid {
+			resp := ErrConflict
+			resp.Info = "payment has no payment method"
+			resp.Write(w)
+			return
+		}
+		method, err := a.paymentService.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+		if err != nil {
+			log.Error("error retrieving payment method", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		err = payment.PaymentAuthorizationDB(ctx, a.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly), p)
+		if err != nil {
+			log.Error("error retrieving payment authorization", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		released := p.Decimal()
+		if p.Authorization != nil {
+			released = p.Authorization.Decimal()
+		}
+		err = a.providerService.Void(ctx, p, method)
+		if err != nil {
+			switch {
+			case errors.Is(err, paymentService.ErrIntentNotAllowed):
+				resp := ErrConflict
+				resp.Info = "payment is " + p.Status.String()
+				resp.Write(w)
+			case errors.Is(err, paymentService.ErrPaymentMethodDisabled):
+				resp := ErrConflict
+				resp.Info = "payment method is disabled"
+				resp.Write(w)
+			case errors.Is(err, provider.ErrNoDriver):
+				resp := ErrConflict
+				resp.Info = "provider " + method.Provider.Name + " is not attached on this instance"
+				resp.Write(w)
+			case errors.Is(err, provider.ErrNotSupported):
+				resp := ErrConflict
+				resp.Info = "provider " + method.Provider.Name + " does not support voids"
+				resp.Write(w)
+			case service.RequestDone(r):
+				log.Warn("void abandoned", log15.Ctx{"err": err})
+				ErrTimeout.Write(w)
+			default:
+				log.Error("error on void", log15.Ctx{"err": err})
+				resp := ErrSystem
+				resp.Info = "void failed"
+				resp.Write(w)
+			}
+			return
+		}
+		a.paymentService.NotifyEvent(p.ProjectID(), paymentService.EventPaymentVoid, map[string]string{
+			"PaymentId": a.paymentService.EncodedPaymentID(p.PaymentID()).String(),
+			"Amount":    released.String(),
+			"Currency":  p.Currency,
+		})
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "authorization voided, payment is " + p.Status.String()
+		resp.Response, err = notification.New(a.paymentService.EncodedPaymentID(p.PaymentID()), p)
+		if err != nil {
+			log.Error("error creating payment representation", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
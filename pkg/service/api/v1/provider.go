@@ -19,6 +19,7 @@ func (a *AdminAPI) ProviderGetRequest() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		log := a.log.New(log15.Ctx{"method": "Provider Request"})
+		ctx := requestContext(a.ctx, r)
 
 		if r.Method != "GET" {
 			ErrInval.Write(w)
@@ -30,7 +31,7 @@ func (a *AdminAPI) ProviderGetRequest() http.Handler {
 
 		// get one Provider
 		db := a.ctx.PaymentDB(service.ReadOnly)
-		pr, err := provider.ProviderByNameDB(db, providerParam)
+		pr, err := provider.ProviderByNameDB(ctx, db, providerParam)
 		if err == provider.ErrProviderNotFound {
 			ErrNotFound.Write(w)
 			log.Info("provider not found", log15.Ctx{"providerName": providerParam})
@@ -59,22 +60,32 @@ func (a *AdminAPI) ProviderGetRequest() http.Handler {
 // return a handler brokering get a provider
 func (a *AdminAPI) ProviderGetAllRequest() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := requestContext(a.ctx, r)
 
 		w.Header().Set("Content-Type", "application/json")
 		// get all
 		log := a.log.New(log15.Ctx{"method": "Provider Request"})
+		q, ok := listQuery(w, r, provider.ProviderListing, log)
+		if !ok {
+			return
+		}
 		db := a.ctx.PaymentDB(service.ReadOnly)
-		prl, err := provider.ProviderAllDB(db)
+		prl, page, err := provider.ProviderListDB(ctx, db, q)
 		if err != nil {
 			ErrDatabase.Write(w)
 			log.Error("database error", log15.Ctx{"err": err})
 			return
 		}
+		items, ok := selectFields(w, q, prl)
+		if !ok {
+			return
+		}
+		setPageHeader(w, r, q, page)
 		// response write
 		resp := ProviderAdminAPIResponse{}
 		resp.Status = StatusSuccess
 		resp.Info = "providers found"
-		resp.Response = prl
+		resp.Response = items
 		resp.Write(w)
 		if err != nil {
 			log.Error("write error", log15.Ctx{"err": err})
@@ -0,0 +1,145 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/reconciliation"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// defaultReconciliationDays is the number of days of the reconciliation if no
+// range is requested
+const defaultReconciliationDays = 30
+
+// ReconciliationDay is the reconciliation of the payments of a project in a
+// currency on a day
+type ReconciliationDay struct {
+	// Day in the format "2006-01-02"
+	Day      string
+	Currency string
+	// Created is the number of payments created on the day
+	Created       int64
+	CreatedAmount string
+	// Paid is the amount captured or received on the day
+	Paid        string
+	Refunded    string
+	ChargedBack string
+	// Settled is the number of payments whose settlement was imported on the
+	// day
+	Settled int64
+	Fees    string
+	// Unsettled is the part of the paid amount without imported settlement
+	Unsettled string
+	// Net is the paid amount less refunds, chargebacks and fees
+	Net string
+}
+
+// ReconciliationResponse is the reconciliation of a project over a range of days
+type ReconciliationResponse struct {
+	ProjectID int64
+	// From is the first day
+	From string
+	// To is the last day
+	To   string
+	Days []ReconciliationDay
+}
+
+// reconciliationDaysParams returns the first and the last day of the requested
+// range
+func reconciliationDaysParams(w http.ResponseWriter, r *http.Request) (first, last time.Time, ok bool) {
+	var err error
+	last = time.Now()
+	if param := r.URL.Query().Get("to"); param != "" {
+		last, err = time.Parse(reconciliation.DayFormat, param)
+		if err != nil {
+			resp := ErrReadParam
+			resp.Info = "to must be in the format YYYY-MM-DD"
+			resp.Write(w)
+			return first, last, false
+		}
+	}
+	first = last.AddDate(0, 0, -(defaultReconciliationDays - 1))
+	if param := r.URL.Query().Get("from"); param != "" {
+		first, err = time.Parse(reconciliation.DayFormat, param)
+		if err != nil {
+			resp := ErrReadParam
+			resp.Info = "from must be in the format YYYY-MM-DD"
+			resp.Write(w)
+			return first, last, false
+		}
+	}
+	return first, last, true
+}
+
+// ProjectReconciliationRequest returns a handler for the reconciliation of a
+// project
+//
+// GET returns the created, paid, refunded, charged back and settled amounts of
+// the requested days along with the fees and the unsettled amounts.
+func (a *AdminAPI) ProjectReconciliationRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectReconciliationRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		first, last, ok := reconciliationDaysParams(w, r)
+		if !ok {
+			return
+		}
+		report, err := reconciliation.ReportDB(a.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, first, last)
+		if err != nil {
+			if err == reconciliation.ErrRange {
+				resp := ErrInval
+				resp.Info = "invalid range of days, at most " + strconv.Itoa(reconciliation.MaxDays) + " days can be requested"
+				resp.Write(w)
+				return
+			}
+			log.Error("error retrieving reconciliation", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		days := report.Days()
+		res := ReconciliationResponse{
+			ProjectID: projectID,
+			From:      report.From.Format(reconciliation.DayFormat),
+			To:        report.To.AddDate(0, 0, -1).Format(reconciliation.DayFormat),
+			Days:      make([]ReconciliationDay, len(days)),
+		}
+		for i, d := range days {
+			res.Days[i] = ReconciliationDay{
+				Day:           d.Day,
+				Currency:      d.Currency,
+				Created:       d.Created,
+				CreatedAmount: d.Decimal(d.CreatedAmount).String(),
+				Paid:          d.Decimal(d.Paid).String(),
+				Refunded:      d.Decimal(d.Refunded).String(),
+				ChargedBack:   d.Decimal(d.ChargedBack).String(),
+				Settled:       d.Settled,
+				Fees:          d.Decimal(d.Fees).String(),
+				Unsettled:     d.Decimal(d.Unsettled).String(),
+				Net:           d.Decimal(d.Net()).String(),
+			}
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "reconciliation from " + res.From + " to " + res.To
+		resp.Response = res
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -1,7 +1,12 @@
 package v1
 
 import (
+	"net/http"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/user"
 	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"github.com/gorilla/mux"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -36,45 +41,153 @@ type Service struct {
 // NewService creates a new API service
 // It requires a valid service context and takes a router to which
 // the service routes will be attached
-func NewService(ctx *service.Context, mux *mux.Router) (*Service, error) {
+func NewService(ctx *service.Context, router *mux.Router) (*Service, error) {
 	s := &Service{
 		log: ctx.Log().New(log15.Ctx{"pkg": "github.com/fritzpay/paymentd/pkg/service/api/v1"}),
 	}
 
 	cfg := ctx.Config()
 
+	// handle registers the handler, limits its request bodies and records its
+	// latency SLO compliance and the API usage of the projects
+	handle := func(path string, h http.Handler) *mux.Route {
+		return router.Handle(path, ctx.SLOHandler(path, ctx.UsageHandler(path, BodyLimitHandler(ctx, path, h))))
+	}
+
+	// the admin and the payment API share the payment service
+	paymentSvc, err := paymentService.NewService(ctx)
+	if err != nil {
+		s.log.Error("error initializing payment service", log15.Ctx{"err": err})
+		return nil, err
+	}
+
 	if cfg.API.ServeAdmin {
 		s.log.Info("registering admin API...")
 
-		admin := NewAdminAPI(ctx)
-		mux.Handle(ServicePath+"/authorization", admin.AuthorizationHandler())
-		mux.Handle(ServicePath+"/authorization/{method}", admin.AuthorizeHandler())
-		mux.Handle(ServicePath+"/user", admin.AuthRequiredHandler(admin.GetUserID()))
-
-		mux.Handle(ServicePath+"/principal", admin.AuthRequiredHandler(admin.PrincipalRequest()))
-		mux.Handle(ServicePath+"/principal/{name:[-A-Za-z0-9_]+}", admin.AuthRequiredHandler(admin.PrincipalNameRequest()))
-		mux.Handle(ServicePath+"/provider", admin.AuthRequiredHandler(admin.ProviderGetAllRequest()))
-		mux.Handle(ServicePath+"/provider/{provider}", admin.AuthRequiredHandler(admin.ProviderGetRequest()))
-		mux.Handle(ServicePath+"/project/{name:[-A-Za-z0-9_]+}/", admin.AuthRequiredHandler(admin.ProjectRequest()))
-		mux.Handle(ServicePath+"/project/{projectid}", admin.AuthRequiredHandler(admin.ProjectGetRequest()))
-		mux.Handle(ServicePath+"/project/{projectid}/method/{methodkey}/provider/{provider}", admin.AuthRequiredHandler(admin.PaymentMethodGetRequest()))
-		mux.Handle(ServicePath+"/project/{projectid}/method/", admin.AuthRequiredHandler(admin.PaymentMethodRequest()))
-		mux.Handle(ServicePath+"/project/{projectid}/method/{methodkey}", admin.AuthRequiredHandler(admin.PaymentMethodRequest()))
-		mux.Handle(ServicePath+"/currency", admin.AuthRequiredHandler(admin.CurrencyGetAllRequest()))
-		mux.Handle(ServicePath+"/currency/{currencycode}", admin.AuthRequiredHandler(admin.CurrencyGetRequest()))
+		admin, err := NewAdminAPI(ctx, paymentSvc)
+		if err != nil {
+			s.log.Error("error registering admin API", log15.Ctx{"err": err})
+			return nil, err
+		}
+		handle(ServicePath+"/authorization", admin.AuthorizationHandler())
+		handle(ServicePath+"/authorization/{method}", admin.AuthorizeHandler())
+		handle(ServicePath+"/authorization/oidc/callback", admin.OIDCCallbackHandler())
+		handle(ServicePath+"/user", admin.AuthRequiredHandler(admin.GetUserID()))
+
+		handle(ServicePath+"/users", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.UsersRequest())))
+		handle(ServicePath+"/users/{username:[-A-Za-z0-9_.@]+}", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.UserRequest())))
+		handle(ServicePath+"/users/{username:[-A-Za-z0-9_.@]+}/session", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.UserSessionRequest())))
+		handle(ServicePath+"/users/{username:[-A-Za-z0-9_.@]+}/key", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.UserKeyRequest())))
+		handle(ServicePath+"/users/{username:[-A-Za-z0-9_.@]+}/key/{keyid:[0-9a-f]+}", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.UserKeyRevokeRequest())))
+
+		handle(ServicePath+"/principal", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.PrincipalRequest())))
+		handle(ServicePath+"/principal/{name:[-A-Za-z0-9_]+}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, principalScope, admin.PrincipalNameRequest())))
+		handle(ServicePath+"/principal/{name:[-A-Za-z0-9_]+}/bundle", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, principalScope, admin.PrincipalBundleRequest())))
+		handle(ServicePath+"/provider", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.ProviderGetAllRequest())))
+		handle(ServicePath+"/provider/{provider}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.ProviderGetRequest())))
+		handle(ServicePath+"/provider/{provider}/method/{methodkey}/display", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.PaymentMethodDisplayRequest())))
+		handle(ServicePath+"/provider/{provider}/method/{methodkey}/logo", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.PaymentMethodLogoRequest())))
+		handle(ServicePath+"/project/{name:[-A-Za-z0-9_]+}/", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.ProjectRequest())))
+		handle(ServicePath+"/project/{projectid}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.ProjectGetRequest())))
+		handle(ServicePath+"/project/{projectid}/method/{methodkey}/provider/{provider}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.PaymentMethodGetRequest())))
+		handle(ServicePath+"/project/{projectid}/method/{methodkey}/provider/{provider}/display", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.PaymentMethodProjectDisplayRequest())))
+		handle(ServicePath+"/project/{projectid}/method/{methodkey}/provider/{provider}/maintenance", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.PaymentMethodMaintenanceRequest())))
+		handle(ServicePath+"/project/{projectid}/method/{methodkey}/provider/{provider}/maintenance/{maintenanceid}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.PaymentMethodMaintenanceRequest())))
+		handle(ServicePath+"/project/{projectid}/method/", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.PaymentMethodRequest())))
+		handle(ServicePath+"/project/{projectid}/method/{methodkey}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.PaymentMethodRequest())))
+		handle(ServicePath+"/project/{projectid}/domain", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.ProjectDomainRequest())))
+		handle(ServicePath+"/project/{projectid}/domain/{domain}/verify", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.ProjectDomainVerifyRequest())))
+		handle(ServicePath+"/project/{projectid}/payment", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentSearchRequest())))
+		handle(ServicePath+"/project/{projectid}/overview", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentOverviewRequest())))
+		handle(ServicePath+"/project/{projectid}/escrow", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectEscrowRequest())))
+		handle(ServicePath+"/project/{projectid}/notification", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectNotificationsRequest())))
+		handle(ServicePath+"/project/{projectid}/notification/{notificationid:[0-9]+}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectNotificationGetRequest())))
+		handle(ServicePath+"/project/{projectid}/notification/{notificationid:[0-9]+}/retry", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectNotificationRetryRequest())))
+		handle(ServicePath+"/project/{projectid}/doctor", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectDoctorRequest())))
+		handle(ServicePath+"/project/{projectid}/doctor/repair", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectDoctorRepairRequest())))
+		handle(ServicePath+"/project/{projectid}/payment/{paymentid}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentRequest())))
+		handle(ServicePath+"/project/{projectid}/payment/{paymentid}/order", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentOrderRequest())))
+		handle(ServicePath+"/project/{projectid}/payment/{paymentid}/state", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentStateRequest())))
+		handle(ServicePath+"/project/{projectid}/payment/{paymentid}/rejection", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentRejectionsRequest())))
+		handle(ServicePath+"/project/{projectid}/payment/{paymentid}/authorization", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentAuthorizationRequest())))
+		handle(ServicePath+"/project/{projectid}/payment/{paymentid}/capture", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentCaptureRequest())))
+		handle(ServicePath+"/project/{projectid}/payment/{paymentid}/void", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentVoidRequest())))
+		handle(ServicePath+"/project/{projectid}/payment/{paymentid}/review", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentReviewRequest())))
+		handle(ServicePath+"/project/{projectid}/payment/{paymentid}/escrow", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectPaymentEscrowRequest())))
+		handle(ServicePath+"/project/{projectid}/subscription", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectSubscriptionsRequest())))
+		handle(ServicePath+"/project/{projectid}/subscription/{subscriptionid:[0-9]+}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectSubscriptionRequest())))
+		handle(ServicePath+"/project/{projectid}/review", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectReviewQueueRequest())))
+		handle(ServicePath+"/project/{projectid}/change", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectChangeFeedRequest())))
+		handle(ServicePath+"/project/{projectid}/fee/{provider}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.ProjectFeeScheduleRequest())))
+		handle(ServicePath+"/project/{projectid}/settlement", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectSettlementRequest())))
+		handle(ServicePath+"/project/{projectid}/cost", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectCostReportRequest())))
+		handle(ServicePath+"/project/{projectid}/reconciliation", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectReconciliationRequest())))
+		handle(ServicePath+"/project/{projectid}/usage", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, projectScope, admin.ProjectUsageRequest())))
+		handle(ServicePath+"/project/{projectid}/bundle", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.ProjectBundleRequest())))
+		handle(ServicePath+"/project/{projectid}/onboarding", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.ProjectOnboardingRequest())))
+		handle(ServicePath+"/project/{projectid}/impersonation", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, projectScope, admin.ProjectImpersonationsRequest())))
+		handle(ServicePath+"/project/{projectid}/callback/test", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, projectScope, admin.ProjectCallbackTestRequest())))
+		handle(ServicePath+"/currency", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.CurrencyGetAllRequest())))
+		handle(ServicePath+"/currency/{currencycode}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.CurrencyGetRequest())))
+		handle(ServicePath+"/feature", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.FeatureGetAllRequest())))
+		handle(ServicePath+"/feature/{name:[-A-Za-z0-9_.]+}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.FeatureRequest())))
+		handle(ServicePath+"/bin/{bin:[0-9]{6,8}}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.BINRequest())))
+		handle(ServicePath+"/usage", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.UsageRequest())))
+		handle(ServicePath+"/funds", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.FundsRequest())))
+		handle(ServicePath+"/funds/{fundsid:[0-9]+}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.FundsGetRequest())))
+		handle(ServicePath+"/funds/{fundsid:[0-9]+}/match", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.FundsMatchRequest())))
+		handle(ServicePath+"/funds/{fundsid:[0-9]+}/return", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.FundsReturnRequest())))
+		handle(ServicePath+"/webhook/deadletter", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.DeadLettersRequest())))
+		handle(ServicePath+"/webhook/deadletter/{deadletterid:[0-9]+}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.DeadLetterGetRequest())))
+		handle(ServicePath+"/webhook/deadletter/{deadletterid:[0-9]+}/process", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.DeadLetterProcessRequest())))
+		handle(ServicePath+"/webhook/deadletter/{deadletterid:[0-9]+}/discard", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.DeadLetterDiscardRequest())))
+		handle(ServicePath+"/onboarding/applications", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.OnboardingApplicationsRequest())))
+		handle(ServicePath+"/onboarding/applications/{applicationid:[0-9]+}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.OnboardingApplicationGetRequest())))
+		handle(ServicePath+"/onboarding/applications/{applicationid:[0-9]+}/approve", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.OnboardingApplicationApproveRequest())))
+		handle(ServicePath+"/onboarding/applications/{applicationid:[0-9]+}/reject", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleAdmin, globalScope, admin.OnboardingApplicationRejectRequest())))
+		handle(ServicePath+"/batch", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.BatchRequest())))
+		handle(ServicePath+"/batch/{batchid:[0-9]+}", admin.AuthRequiredHandler(admin.PermissionHandler(user.RoleOperator, globalScope, admin.BatchGetRequest())))
+		handle(ServicePath+"/diagnostics", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.DiagnosticsRequest())))
+		handle(ServicePath+"/jobs", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.JobsRequest())))
+		handle(ServicePath+"/jobs/runs", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.JobRunsRequest())))
+		handle(ServicePath+"/jobs/{name:[-a-z0-9_.]+}/run", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.JobRunRequest())))
+		handle(ServicePath+"/archives/{day:[0-9]{4}-[0-9]{2}-[0-9]{2}}", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.ArchiveRequest())))
+		handle(ServicePath+"/archives/{day:[0-9]{4}-[0-9]{2}-[0-9]{2}}/{file:[a-z]+\\.jsonl}", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.ArchiveFileRequest())))
+		handle(ServicePath+"/templates/reload", admin.AuthRequiredHandler(admin.RoleRequiredHandler(user.RoleAdmin, globalScope, admin.TemplatesReloadRequest())))
+	}
+
+	handle(ServicePath+"/health", HealthHandler(ctx)).Methods("GET")
+	handle(ServicePath+"/ready", ReadyHandler(ctx)).Methods("GET")
+	handle(ServicePath+"/time", TimeHandler()).Methods("GET")
+
+	if cfg.API.Onboarding.Active {
+		s.log.Info("registering onboarding API...")
+		if _, err := onboardingProjectConfig(cfg); err != nil {
+			s.log.Error("invalid onboarding project config", log15.Ctx{"err": err})
+			return nil, err
+		}
+		h := ctx.RequestRateLimitHandler("onboarding", cfg.API.Onboarding.RequestRateLimit, time.Hour, OnboardingApplicationHandler(ctx))
+		handle(ServicePath+"/onboarding/application", ctx.RateLimitHandler(h)).Methods("POST")
 	}
 
 	s.log.Info("registering payment API...")
-	payment, err := NewPaymentAPI(ctx)
+	payment, err := NewPaymentAPI(ctx, paymentSvc)
 	if err != nil {
 		s.log.Error("error registering payment API", log15.Ctx{"err": err})
 		return nil, err
 	}
-	mux.Handle(ServicePath+"/payment", ctx.RateLimitHandler(payment.InitPayment())).Methods("POST")
-	mux.Handle(ServicePath+"/payment/paymentId/{paymentId}", payment.GetPayment()).Methods("GET")
-	mux.Handle(ServicePath+"/payment/PaymentId/{paymentId}", payment.GetPayment()).Methods("GET")
-	mux.Handle(ServicePath+"/payment/ident/{ident}", payment.GetPayment()).Methods("GET")
-	mux.Handle(ServicePath+"/payment/Ident/{ident}", payment.GetPayment()).Methods("GET")
+	limit := func(h http.Handler) http.Handler {
+		return ctx.RequestRateLimitHandler("payment", cfg.API.RequestRateLimit, time.Minute, h)
+	}
+	handle(ServicePath+"/payment", limit(ctx.RateLimitHandler(payment.InitPayment()))).Methods("POST")
+	handle(ServicePath+"/payments", limit(payment.ListPayments())).Methods("GET")
+	handle(ServicePath+"/payment/paymentId/{paymentId}", limit(payment.GetPayment())).Methods("GET")
+	handle(ServicePath+"/payment/PaymentId/{paymentId}", limit(payment.GetPayment())).Methods("GET")
+	handle(ServicePath+"/payment/ident/{ident}", limit(payment.GetPayment())).Methods("GET")
+	handle(ServicePath+"/payment/Ident/{ident}", limit(payment.GetPayment())).Methods("GET")
+	handle(ServicePath+"/session", limit(ctx.RateLimitHandler(payment.CreateSession()))).Methods("POST")
+	handle(ServicePath+"/session/{token:[0-9a-f]+}", limit(ctx.RateLimitHandler(payment.UpdateSession()))).Methods("PUT")
+	handle(ServicePath+"/session/{token:[0-9a-f]+}", limit(payment.GetSession())).Methods("GET")
 
 	return s, nil
 }
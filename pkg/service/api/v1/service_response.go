@@ -3,6 +3,8 @@ package v1
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -54,6 +56,22 @@ var (
 		nil,
 		nil,
 	}
+	ErrForbidden = ServiceResponse{
+		http.StatusForbidden,
+		APIVersion,
+		StatusUnauthorized,
+		"forbidden",
+		nil,
+		nil,
+	}
+	ErrLockedOut = ServiceResponse{
+		http.StatusTooManyRequests,
+		APIVersion,
+		StatusUnauthorized,
+		"too many failed authentication attempts",
+		nil,
+		nil,
+	}
 	ErrDatabase = ServiceResponse{
 		http.StatusInternalServerError,
 		APIVersion,
@@ -102,6 +120,14 @@ var (
 		nil,
 		nil,
 	}
+	ErrTooLarge = ServiceResponse{
+		http.StatusRequestEntityTooLarge,
+		APIVersion,
+		StatusImplementationError,
+		"request too large",
+		nil,
+		nil,
+	}
 	ErrMethod = ServiceResponse{
 		http.StatusMethodNotAllowed,
 		APIVersion,
@@ -110,6 +136,14 @@ var (
 		nil,
 		nil,
 	}
+	ErrTimeout = ServiceResponse{
+		http.StatusGatewayTimeout,
+		APIVersion,
+		StatusError,
+		"request deadline exceeded",
+		nil,
+		nil,
+	}
 )
 
 func (sr *ServiceResponse) Write(w http.ResponseWriter) error {
@@ -127,6 +161,10 @@ func (sr *ServiceResponse) Write(w http.ResponseWriter) error {
 		sr.HttpStatus = http.StatusInternalServerError
 	}
 
+	// server time for clients debugging their clock skew
+	if sr.HttpStatus >= http.StatusBadRequest {
+		w.Header().Set(HeaderServerTime, strconv.FormatInt(time.Now().Unix(), 10))
+	}
 	w.WriteHeader(sr.HttpStatus)
 
 	// json encode response struct
@@ -523,3 +523,30 @@ func TestPrincipal(t *testing.T) {
 		}))
 	}))
 }
+
+func TestBodyLimit(t *testing.T) {
+	Convey("Given a new context", t, testutil.WithContext(func(ctx *service.Context, logChan <-chan *log15.Record) {
+		ctx.BodyLimits().Sizes["POST "+ServicePath+"/payment"] = 16
+
+		Convey("Given a new API service", WithService(ctx, logChan, func(s *Service, mx *mux.Router) {
+
+			Convey("When a payment request exceeds the body size of the endpoint", func() {
+				r, err := http.NewRequest("POST", ServicePath+"/payment", strings.NewReader(`{"Metadata":{"a":"1"}}`))
+				So(err, ShouldBeNil)
+
+				w := testutil.NewResponseWriter()
+				mx.ServeHTTP(w, r)
+
+				Convey("It should be rejected before it is read", func() {
+					So(w.HeaderWritten, ShouldBeTrue)
+					So(w.StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+
+					resp := ServiceResponse{}
+					err := json.NewDecoder(&w.Buf).Decode(&resp)
+					So(err, ShouldBeNil)
+					So(resp.Info, ShouldEqual, "request body exceeds 16 bytes")
+				})
+			})
+		}))
+	}))
+}
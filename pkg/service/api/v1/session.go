@@ -0,0 +1,677 @@
+package v1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	jsonutil "github.com/fritzpay/paymentd/pkg/json"
+	"github.com/fritzpay/paymentd/pkg/maputil"
+	"github.com/fritzpay/paymentd/pkg/paymentd/checkout"
+	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"github.com/fritzpay/paymentd/pkg/validate"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// SessionRequest is the request JSON struct for POST /session and
+// PUT /session/{token}
+//
+// Updates replace all values of the session.
+type SessionRequest struct {
+	ProjectKey string
+	// Token of the updated session, taken from the path
+	Token         string `json:",omitempty"`
+	CartReference string `json:",omitempty"`
+	Amount        jsonutil.RequiredInt64
+	Subunits      jsonutil.RequiredInt8
+	Currency      string
+	Country       string
+	Locale        string `json:",omitempty"`
+
+	Customer map[string]string
+	Metadata map[string]string
+
+	Timestamp int64 `json:",string"`
+	Nonce     string
+
+	HexSignature    string `json:"Signature"`
+	binarySignature []byte
+
+	rawJSON []byte
+}
+
+// Validate input
+//
+// It returns validate.Errors addressing the invalid fields.
+func (r *SessionRequest) Validate() error {
+	err := validate.Validate(
+		validate.Field("ProjectKey", validate.Required(r.ProjectKey)),
+		validate.Field("CartReference", validate.MaxLen(r.CartReference, checkout.CartReferenceMaxLen)),
+		validate.Field("Amount", validate.Set(r.Amount.Set), validate.Min(r.Amount.Int64, 0)),
+		validate.Field("Subunits", validate.Set(r.Subunits.Set)),
+		validate.Field("Currency", validate.Required(r.Currency), validate.Len(r.Currency, 3)),
+		validate.Field("Country", validate.Required(r.Country), validate.Len(r.Country, 2)),
+		validate.Field("Locale", validate.Locale(r.Locale)),
+		validate.Field("Signature", validate.Required(r.HexSignature), validate.Hex(r.HexSignature)),
+		validate.Field("Timestamp", validate.Assert(r.Timestamp != 0, validate.CodeMissing)),
+		validate.Field("Nonce", validate.Required(r.Nonce), validate.Assert(len(r.Nonce) <= nonce.NonceBytes, validate.CodeInvalid)),
+	)
+	if err != nil {
+		return err
+	}
+	// validated
+	r.binarySignature, _ = hex.DecodeString(r.HexSignature)
+	return nil
+}
+
+// Return the (binary) signature from the request
+//
+// implementing AuthenticatedRequest
+func (r *SessionRequest) Signature() ([]byte, error) {
+	return r.binarySignature, nil
+}
+
+// HashFunc returns the hash function used to generate a signature
+func (r *SessionRequest) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+// Return the signature base string (msg)
+func (r *SessionRequest) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	_, err = buf.WriteString(r.ProjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	if r.Token != "" {
+		_, err = buf.WriteString(r.Token)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	if r.CartReference != "" {
+		_, err = buf.WriteString(r.CartReference)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Amount.Int64, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(int64(r.Subunits.Int8), 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Country)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	if r.Locale != "" {
+		_, err = buf.WriteString(r.Locale)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	if r.Customer != nil {
+		err = maputil.WriteSortedMap(buf, r.Customer)
+		if err != nil {
+			return nil, fmt.Errorf("error writing map: %v", err)
+		}
+	}
+	if r.Metadata != nil {
+		err = maputil.WriteSortedMap(buf, r.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("error writing map: %v", err)
+		}
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *SessionRequest) RequestProjectKey() string {
+	return r.ProjectKey
+}
+
+func (r *SessionRequest) RequestNonce() string {
+	return r.Nonce
+}
+
+func (r *SessionRequest) Time() time.Time {
+	return time.Unix(r.Timestamp, 0)
+}
+
+// ReadJSONLimited reads and decodes the request
+//
+// See InitPaymentRequest.ReadJSONLimited
+func (r *SessionRequest) ReadJSONLimited(rd io.Reader, maxEntries int) error {
+	var err error
+	r.rawJSON, err = jsonutil.ReadLimited(rd, maxEntries)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(r.rawJSON, r)
+}
+
+// JSONPayload returns the received JSON document
+func (r *SessionRequest) JSONPayload() []byte {
+	return r.rawJSON
+}
+
+// PopulateSessionFields sets the values of the session
+func (r *SessionRequest) PopulateSessionFields(s *checkout.Session) {
+	s.CartReference = sql.NullString{String: r.CartReference, Valid: r.CartReference != ""}
+	s.Amount = r.Amount.Int64
+	s.Subunits = r.Subunits.Int8
+	s.Currency = r.Currency
+	s.Country = r.Country
+	s.Locale = sql.NullString{String: r.Locale, Valid: r.Locale != ""}
+	s.Customer = r.Customer
+	s.Metadata = r.Metadata
+}
+
+// GetSessionRequest represents a get checkout session request
+type GetSessionRequest struct {
+	ProjectKey   string
+	Token        string
+	Timestamp    int64 `json:",string"`
+	Nonce        string
+	hexSignature string
+}
+
+func (r *GetSessionRequest) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	_, err = buf.WriteString(r.ProjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Token)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *GetSessionRequest) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+func (r *GetSessionRequest) Signature() ([]byte, error) {
+	return hex.DecodeString(r.hexSignature)
+}
+
+func (r *GetSessionRequest) RequestProjectKey() string {
+	return r.ProjectKey
+}
+
+func (r *GetSessionRequest) RequestNonce() string {
+	return r.Nonce
+}
+
+func (r *GetSessionRequest) Time() time.Time {
+	return time.Unix(r.Timestamp, 0)
+}
+
+func (r *GetSessionRequest) ReadFromRequest(req *http.Request) error {
+	var err error
+	r.Token = mux.Vars(req)["token"]
+	if r.Token == "" {
+		return errors.New("no token")
+	}
+	q := req.URL.Query()
+	r.ProjectKey = q.Get("ProjectKey")
+	if r.ProjectKey == "" {
+		return errors.New("no project key")
+	}
+	r.Timestamp, err = strconv.ParseInt(q.Get("Timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %v", err)
+	}
+	r.Nonce = q.Get("Nonce")
+	if r.Nonce == "" {
+		return errors.New("no nonce")
+	}
+	r.hexSignature = q.Get("Signature")
+	return nil
+}
+
+// SessionResponse is the JSON response struct for checkout session requests
+type SessionResponse struct {
+	Session struct {
+		Token string
+		// RFC3339 date/time strings
+		Created       string
+		Expires       string
+		CartReference string `json:",omitempty"`
+		Amount        int64  `json:",string"`
+		Subunits      int8   `json:",string"`
+		Currency      string
+		Country       string
+		Locale        string             `json:",omitempty"`
+		Customer      map[string]string  `json:",omitempty"`
+		Metadata      map[string]string  `json:",omitempty"`
+		PaymentId     *payment.PaymentID `json:",omitempty"`
+	}
+	Timestamp int64 `json:",string"`
+	Nonce     string
+	Signature string
+}
+
+// SessionFromCheckout populates the response "Session" object with the fields
+// from the given checkout session
+//
+// The payment ID of converted sessions must be encoded.
+func (r *SessionResponse) SessionFromCheckout(s *checkout.Session, paymentID *payment.PaymentID) {
+	r.Session.Token = s.Token
+	r.Session.Created = s.Created.UTC().Format(time.RFC3339)
+	r.Session.Expires = s.Expires.UTC().Format(time.RFC3339)
+	if s.CartReference.Valid {
+		r.Session.CartReference = s.CartReference.String
+	}
+	r.Session.Amount = s.Amount
+	r.Session.Subunits = s.Subunits
+	r.Session.Currency = s.Currency
+	r.Session.Country = s.Country
+	if s.Locale.Valid {
+		r.Session.Locale = s.Locale.String
+	}
+	r.Session.Customer = s.Customer
+	r.Session.Metadata = s.Metadata
+	r.Session.PaymentId = paymentID
+}
+
+// HashFunc returns the hash function for signing a session response
+func (r *SessionResponse) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+// Returns the signature base string
+//
+// implementing SignableMessage
+func (r *SessionResponse) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	_, err = buf.WriteString(r.Session.Token)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Session.Created)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Session.Expires)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	if r.Session.CartReference != "" {
+		_, err = buf.WriteString(r.Session.CartReference)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Session.Amount, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(int64(r.Session.Subunits), 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Session.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Session.Country)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	if r.Session.Locale != "" {
+		_, err = buf.WriteString(r.Session.Locale)
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	if r.Session.Customer != nil {
+		err = maputil.WriteSortedMap(buf, r.Session.Customer)
+		if err != nil {
+			return nil, fmt.Errorf("error writing map: %v", err)
+		}
+	}
+	if r.Session.Metadata != nil {
+		err = maputil.WriteSortedMap(buf, r.Session.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("error writing map: %v", err)
+		}
+	}
+	if r.Session.PaymentId != nil {
+		_, err = buf.WriteString(r.Session.PaymentId.String())
+		if err != nil {
+			return nil, fmt.Errorf("buffer error: %v", err)
+		}
+	}
+	_, err = buf.WriteString(strconv.FormatInt(r.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	_, err = buf.WriteString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// sessionResponse creates the signed response for the checkout session
+func (a *PaymentAPI) sessionResponse(projectKey *project.Projectkey, s *checkout.Session) (*SessionResponse, error) {
+	sessionResp := &SessionResponse{}
+	if id, ok := s.Payment(); ok {
+		id = a.paymentService.EncodedPaymentID(id)
+		sessionResp.SessionFromCheckout(s, &id)
+	} else {
+		sessionResp.SessionFromCheckout(s, nil)
+	}
+	n, err := nonce.New()
+	if err != nil {
+		return nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+	sessionResp.Nonce = n.Nonce
+	sessionResp.Timestamp = time.Now().Unix()
+	sig, err := signResponse(projectKey, sessionResp)
+	if err != nil {
+		return nil, fmt.Errorf("error signing response: %v", err)
+	}
+	sessionResp.Signature = hex.EncodeToString(sig)
+	return sessionResp, nil
+}
+
+// readSessionRequest reads and validates a checkout session request
+//
+// It returns nil and sets the error response if the request is invalid.
+func (a *PaymentAPI) readSessionRequest(r *http.Request, resp *ServiceResponse) *SessionRequest {
+	req := &SessionRequest{}
+	err := req.ReadJSONLimited(r.Body, a.ctx.BodyLimits().Entries())
+	if err != nil {
+		if err == jsonutil.ErrTooManyEntries {
+			*resp = ErrTooLarge
+			resp.Info = "request exceeds " + strconv.Itoa(a.ctx.BodyLimits().Entries()) + " entries"
+			return nil
+		}
+		*resp = ErrReadJson
+		if Debug {
+			resp.Info = err.Error()
+		}
+		return nil
+	}
+	// the token of updated sessions is part of the path
+	req.Token = mux.Vars(r)["token"]
+	err = req.Validate()
+	if err != nil {
+		*resp = invalidRequestResponse(r, err)
+		return nil
+	}
+	return req
+}
+
+// CreateSession creates a new checkout session
+func (a *PaymentAPI) CreateSession() http.Handler {
+	return a.saveSession("CreateSession")
+}
+
+// UpdateSession replaces the values of a checkout session and renews its
+// expiry
+func (a *PaymentAPI) UpdateSession() http.Handler {
+	return a.saveSession("UpdateSession")
+}
+
+func (a *PaymentAPI) saveSession(method string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{
+			"method": method,
+		})
+		var responseWritten bool
+		var resp ServiceResponse
+		defer func() {
+			if !responseWritten {
+				err := resp.Write(w)
+				if err != nil {
+					log.Error("error writing response", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		req := a.readSessionRequest(r, &resp)
+		if req == nil {
+			return
+		}
+		var projectKey *project.Projectkey
+		if projectKey = a.authenticateRequest(r, req, log, w); projectKey == nil {
+			responseWritten = true
+			return
+		}
+
+		// extend log info
+		log = log.New(log15.Ctx{"projectId": projectKey.Project.ID})
+
+		_, err := currency.CurrencyByCodeISO4217DB(a.ctx.PaymentDB(service.ReadOnly), req.Currency)
+		if err != nil {
+			if err == currency.ErrCurrencyNotFound {
+				resp = ErrInval
+				resp.Info = "invalid Currency"
+				return
+			}
+			log.Error("error retrieving currency", log15.Ctx{"err": err})
+			resp = ErrDatabase
+			if Debug {
+				resp.Info = fmt.Sprintf("error retrieving currency: %v", err)
+			}
+			return
+		}
+
+		// DB
+		var tx *sql.Tx
+		var commit bool
+		// deferred rollback if commit == false
+		defer func() {
+			if tx != nil && !commit {
+				txErr := tx.Rollback()
+				// transactions of abandoned requests are rolled back already
+				if txErr != nil && txErr != sql.ErrTxDone {
+					log.Crit("error on rollback", log15.Ctx{"err": txErr})
+					resp = ErrDatabase
+				}
+			}
+		}()
+		maxRetries := a.ctx.Config().Database.TransactionMaxRetries
+		var retries int
+	beginTx:
+		if retries >= maxRetries {
+			// no need to roll back
+			commit = true
+			log.Crit("too many retries on tx. aborting...", log15.Ctx{"maxRetries": maxRetries})
+			resp = ErrDatabase
+			return
+		}
+		tx, err = service.BeginRequestTx(r, a.ctx.ProjectPaymentDB(projectKey.Project.ID))
+		if err != nil {
+			commit = true
+			if service.RequestDone(r) {
+				resp = ErrTimeout
+				return
+			}
+			log.Crit("error on begin", log15.Ctx{"err": err})
+			resp = ErrDatabase
+			return
+		}
+
+		var sess *checkout.Session
+		if req.Token == "" {
+			sess, err = checkout.NewSession(projectKey.Project.ID)
+			if err != nil {
+				log.Error("error creating checkout session", log15.Ctx{"err": err})
+				resp = ErrSystem
+				return
+			}
+			req.PopulateSessionFields(sess)
+			err = a.paymentService.CreateSession(tx, sess)
+		} else {
+			sess, err = checkout.SessionByTokenTx(tx, req.Token)
+			if err != nil {
+				if err == checkout.ErrSessionNotFound {
+					resp = ErrNotFound
+					return
+				}
+				log.Error("error retrieving checkout session", log15.Ctx{"err": err})
+				resp = ErrDatabase
+				return
+			}
+			if sess.ProjectID != projectKey.Project.ID {
+				log.Warn("checkout session of other project requested", log15.Ctx{"sessionProjectID": sess.ProjectID})
+				resp = ErrNotFound
+				return
+			}
+			req.PopulateSessionFields(sess)
+			err = a.paymentService.UpdateSession(tx, sess)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, paymentService.ErrDBLockTimeout):
+				tx.Rollback()
+				retries++
+				time.Sleep(time.Second)
+				goto beginTx
+			case errors.Is(err, paymentService.ErrSessionConverted):
+				resp = ErrConflict
+				resp.Info = "session was already converted into a payment"
+			case errors.Is(err, paymentService.ErrSessionExpired):
+				resp = ErrNotFound
+				resp.Info = "session expired"
+			case service.RequestDone(r):
+				resp = ErrTimeout
+			case errors.Is(err, paymentService.ErrDB):
+				resp = ErrDatabase
+			default:
+				log.Error("unknown error in payment service", log15.Ctx{"err": err})
+				resp = ErrSystem
+			}
+			return
+		}
+
+		sessionResp, err := a.sessionResponse(projectKey, sess)
+		if err != nil {
+			log.Error("error creating response", log15.Ctx{"err": err})
+			resp = ErrSystem
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			if sqldialect.IsDeadlock(err) {
+				retries++
+				time.Sleep(time.Second)
+				goto beginTx
+			}
+			commit = true
+			log.Crit("error on commit tx", log15.Ctx{"err": err})
+			resp = ErrDatabase
+			return
+		}
+		commit = true
+
+		resp.Status = StatusSuccess
+		if req.Token == "" {
+			resp.Info = "session created"
+		} else {
+			resp.Info = "session updated"
+		}
+		resp.Response = sessionResp
+	})
+}
+
+// GetSession retrieves a checkout session
+func (a *PaymentAPI) GetSession() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{
+			"method": "GetSession",
+		})
+		req := &GetSessionRequest{}
+		err := req.ReadFromRequest(r)
+		if err != nil {
+			ret := ErrReadParam
+			if Debug {
+				ret.Info = err.Error()
+			}
+			ret.Write(w)
+			return
+		}
+		var projectKey *project.Projectkey
+		if projectKey = a.authenticateRequest(r, req, log, w); projectKey == nil {
+			return
+		}
+		sess, err := checkout.SessionByTokenDB(a.ctx.ProjectPaymentDB(projectKey.Project.ID, service.ReadOnly), req.Token)
+		if err != nil {
+			if err == checkout.ErrSessionNotFound {
+				ErrNotFound.Write(w)
+				return
+			}
+			log.Error("error retrieving checkout session", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		if projectKey.Project.ID != sess.ProjectID {
+			log.Warn("project key project and requested session mismatch", log15.Ctx{
+				"projectID": projectKey.Project.ID,
+			})
+			ErrUnauthorized.Write(w)
+			return
+		}
+		sessionResp, err := a.sessionResponse(projectKey, sess)
+		if err != nil {
+			log.Error("error creating response", log15.Ctx{"err": err})
+			ErrSystem.Write(w)
+			return
+		}
+		resp := ServiceResponse{}
+		resp.Status = StatusSuccess
+		resp.HttpStatus = http.StatusOK
+		resp.Info = "returning session"
+		resp.Response = sessionResp
+		resp.Write(w)
+	})
+}
@@ -0,0 +1,63 @@
+package v1
+
+import (
+	"net/http"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// TemplateReloadResult is the result of reloading the templates of one
+// template manager
+type TemplateReloadResult struct {
+	Name string
+	// Error is set if the templates could not be reloaded. The previously
+	// loaded templates will be used until they are fixed
+	Error string `json:",omitempty"`
+}
+
+// TemplatesReloadRequest returns a handler which reloads the templates of the
+// web service and the provider drivers
+//
+// If any templates are broken, the response will list them and the previously
+// loaded templates stay in use.
+func (a *AdminAPI) TemplatesReloadRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "TemplatesReloadRequest"})
+		if r.Method != "POST" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		errs := a.ctx.Templates().Load()
+		results := make([]TemplateReloadResult, 0)
+		for _, name := range a.ctx.Templates().Names() {
+			res := TemplateReloadResult{Name: name}
+			if err, ok := errs[name]; ok {
+				log.Warn("error reloading templates", log15.Ctx{
+					"templates": name,
+					"err":       err,
+				})
+				res.Error = err.Error()
+			}
+			results = append(results, res)
+		}
+
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "templates reloaded"
+		if len(errs) > 0 {
+			resp.HttpStatus = http.StatusUnprocessableEntity
+			resp.Status = StatusError
+			resp.Info = "broken templates"
+		}
+		resp.Response = results
+		err := resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
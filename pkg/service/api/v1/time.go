@@ -0,0 +1,49 @@
+package v1
+
+import (
+	"net/http"
+	"time"
+)
+
+// HeaderServerTime is the header carrying the unix timestamp of the server time
+// in error responses
+const HeaderServerTime = "X-Server-Time"
+
+// TimeResponse is the server time
+//
+// Signed requests are rejected if their timestamp differs from the server
+// time by more than the clock skew tolerated for the project.
+type TimeResponse struct {
+	// Timestamp is the unix timestamp of the server time
+	Timestamp int64 `json:",string"`
+	// Time is the server time in RFC 3339 format
+	Time string
+}
+
+// NewTimeResponse returns the response for the given server time
+func NewTimeResponse(t time.Time) TimeResponse {
+	return TimeResponse{
+		Timestamp: t.Unix(),
+		Time:      t.UTC().Format(time.RFC3339),
+	}
+}
+
+// TimeHandler responds with the server time
+//
+// Clients can synchronize the timestamps of signed requests against it. It
+// does not require authentication.
+func TimeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != "GET" {
+			ErrMethod.Write(w)
+			return
+		}
+		resp := ServiceResponse{
+			Status:   StatusSuccess,
+			Info:     "server time",
+			Response: NewTimeResponse(time.Now()),
+		}
+		resp.Write(w)
+	})
+}
@@ -0,0 +1,71 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTimeHandler(t *testing.T) {
+	Convey("When the server time is requested", t, func() {
+		r := httptest.NewRequest("GET", ServicePath+"/time", nil)
+		w := httptest.NewRecorder()
+		TimeHandler().ServeHTTP(w, r)
+
+		Convey("It should respond with the server time", func() {
+			So(w.Code, ShouldEqual, http.StatusOK)
+			resp := struct {
+				Response TimeResponse
+			}{}
+			So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+			So(resp.Response.Timestamp, ShouldBeBetweenOrEqual, time.Now().Add(-time.Second).Unix(), time.Now().Unix())
+		})
+	})
+	Convey("When an error response is written", t, func() {
+		w := httptest.NewRecorder()
+		ErrUnauthorized.Write(w)
+
+		Convey("It should contain the server time header", func() {
+			ts, err := strconv.ParseInt(w.Header().Get(HeaderServerTime), 10, 64)
+			So(err, ShouldBeNil)
+			So(ts, ShouldBeBetweenOrEqual, time.Now().Add(-time.Second).Unix(), time.Now().Unix())
+		})
+	})
+}
+
+func TestTimestampValid(t *testing.T) {
+	Convey("Given a project key without a configured clock skew", t, func() {
+		projectKey := &project.Projectkey{}
+		req := &InitPaymentRequest{}
+
+		Convey("Requests within the default tolerance should be valid", func() {
+			req.Timestamp = time.Now().Add(-requestClockSkew / 2).Unix()
+			So(timestampValid(projectKey, req), ShouldBeTrue)
+			req.Timestamp = time.Now().Add(requestClockSkew / 2).Unix()
+			So(timestampValid(projectKey, req), ShouldBeTrue)
+		})
+		Convey("Requests from the future should be invalid", func() {
+			req.Timestamp = time.Now().Add(2 * requestClockSkew).Unix()
+			So(timestampValid(projectKey, req), ShouldBeFalse)
+		})
+
+		Convey("When the project tolerates a clock skew of 2 minutes", func() {
+			So(projectKey.Project.Config.SetClockSkew(120), ShouldBeNil)
+
+			Convey("Requests within the tolerance should be valid", func() {
+				req.Timestamp = time.Now().Add(-time.Minute).Unix()
+				So(timestampValid(projectKey, req), ShouldBeTrue)
+			})
+			Convey("Requests exceeding the tolerance should be invalid", func() {
+				req.Timestamp = time.Now().Add(-3 * time.Minute).Unix()
+				So(timestampValid(projectKey, req), ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,116 @@
+package v1
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/usage"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// UsageResponse is the usage of all projects in a month
+type UsageResponse struct {
+	// Month in the format "2006-01"
+	Month string
+	// Projects is the usage by project, by requests descending
+	Projects []usage.Project
+}
+
+// usageMonthParam returns the month of the "month" query parameter
+//
+// It returns the current month if no month was requested.
+func usageMonthParam(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	param := r.URL.Query().Get("month")
+	if param == "" {
+		return usage.Month(time.Now()), true
+	}
+	month, err := usage.ParseMonth(param)
+	if err != nil {
+		resp := ErrReadParam
+		resp.Info = "month must be in the format YYYY-MM"
+		resp.Write(w)
+		return time.Time{}, false
+	}
+	return month, true
+}
+
+// ProjectUsageRequest returns a handler for the API usage of a project
+//
+// GET returns the request counts, error rates and top endpoints of the
+// requested month
+func (a *AdminAPI) ProjectUsageRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "ProjectUsageRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		projectID, ok := a.projectIDParam(w, r, log)
+		if !ok {
+			return
+		}
+		month, ok := usageMonthParam(w, r)
+		if !ok {
+			return
+		}
+		s, err := a.ctx.Usage().Summary(projectID, month)
+		if err != nil {
+			log.Error("error retrieving usage", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "usage of " + s.Month
+		resp.Response = s
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
+
+// UsageRequest returns a handler for the API usage of all projects
+//
+// GET returns the request counts and error rates of all projects in the
+// requested month
+func (a *AdminAPI) UsageRequest() http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		log := a.log.New(log15.Ctx{"method": "UsageRequest"})
+		if r.Method != "GET" {
+			if Debug {
+				log.Debug("request method not supported", log15.Ctx{"requestMethod": r.Method})
+			}
+			ErrMethod.Write(w)
+			return
+		}
+		month, ok := usageMonthParam(w, r)
+		if !ok {
+			return
+		}
+		projects, err := a.ctx.Usage().Projects(month)
+		if err != nil {
+			log.Error("error retrieving usage", log15.Ctx{"err": err})
+			ErrDatabase.Write(w)
+			return
+		}
+		resp := AdminAPIResponse{}
+		resp.Status = StatusSuccess
+		resp.Info = "usage of " + month.Format("2006-01")
+		resp.Response = UsageResponse{
+			Month:    month.Format("2006-01"),
+			Projects: projects,
+		}
+		err = resp.Write(w)
+		if err != nil {
+			log.Error("write error", log15.Ctx{"err": err})
+		}
+	})
+	return a.ctx.RateLimitHandler(h)
+}
@@ -0,0 +1,46 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/validate"
+)
+
+// ValidationError is a field error of an invalid request
+type ValidationError struct {
+	Field   string
+	Code    string
+	Message string
+}
+
+// ValidationResponse is the response to an invalid request
+type ValidationResponse struct {
+	Errors []ValidationError
+}
+
+// invalidRequestResponse returns the ErrInval response for the validation
+// error of the request
+//
+// Field errors will be listed in the response. Their messages will be
+// localized for the Accept-Language of the request. The info contains the
+// message of the first error.
+func invalidRequestResponse(r *http.Request, err error) ServiceResponse {
+	resp := ErrInval
+	errs, ok := err.(validate.Errors)
+	if !ok || len(errs) == 0 {
+		resp.Info = err.Error()
+		return resp
+	}
+	lang := validate.Language(r.Header.Get("Accept-Language"))
+	v := ValidationResponse{Errors: make([]ValidationError, len(errs))}
+	for i, e := range errs {
+		v.Errors[i] = ValidationError{
+			Field:   e.Field,
+			Code:    e.Code,
+			Message: e.Message(lang),
+		}
+	}
+	resp.Info = v.Errors[0].Message
+	resp.Response = v
+	return resp
+}
@@ -0,0 +1,263 @@
+package archive
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/job"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// JobExport writes the archive of the previous day
+const JobExport = "archive.export"
+
+// Archived files
+const (
+	FilePayments     = "payments.jsonl"
+	FileTransactions = "transactions.jsonl"
+	FileEvents       = "events.jsonl"
+)
+
+var (
+	ErrFileNotArchived = errors.New("file not in archive")
+	ErrFileCorrupt     = errors.New("archived file does not match the manifest")
+)
+
+// ParseDay parses an archive day
+func ParseDay(s string) (time.Time, error) {
+	return time.ParseInLocation(DayFormat, s, time.UTC)
+}
+
+// Verification is the result of verifying an archive
+type Verification struct {
+	Manifest *Manifest
+	Valid    bool
+	// Errors lists the failed checks
+	Errors []string `json:",omitempty"`
+}
+
+// Archiver writes and verifies the compliance archives
+type Archiver struct {
+	ctx *service.Context
+	log log15.Logger
+
+	target Target
+	keys   [][]byte
+}
+
+// NewArchiver creates an archiver for the configured target
+//
+// It returns nil if no archive target is configured.
+func NewArchiver(ctx *service.Context) (*Archiver, error) {
+	cfg := ctx.Config()
+	if cfg.Archive.Target == "" {
+		return nil, nil
+	}
+	a := &Archiver{
+		ctx: ctx,
+		log: ctx.Log().New(log15.Ctx{
+			"pkg": "github.com/fritzpay/paymentd/pkg/service/archive",
+		}),
+		keys: make([][]byte, len(cfg.Archive.Keys)),
+	}
+	for i, k := range cfg.Archive.Keys {
+		var err error
+		a.keys[i], err = hex.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive key: %v", err)
+		}
+	}
+	if len(a.keys) == 0 || len(a.keys[0]) == 0 {
+		return nil, ErrNoKey
+	}
+	var err error
+	a.target, err = NewTarget(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func objectName(day time.Time, name string) string {
+	return day.Format(DayFormat) + "/" + name
+}
+
+// Export writes the archive of the given (UTC) day
+//
+// It returns ErrExists if the archive was already written. Files of a previous
+// incomplete export will be kept if their contents did not change.
+func (a *Archiver) Export(day time.Time) (*Manifest, error) {
+	day = day.UTC().Truncate(24 * time.Hour)
+	log := a.log.New(log15.Ctx{
+		"method": "Export",
+		"day":    day.Format(DayFormat),
+	})
+	_, err := a.target.Get(objectName(day, ManifestName))
+	if err == nil {
+		return nil, ErrExists
+	}
+	if err != ErrNotFound {
+		return nil, err
+	}
+	from, to := day, day.Add(24*time.Hour)
+	shards := a.ctx.PaymentShardDBs(service.ReadOnly)
+	m := &Manifest{
+		Version: Version,
+		Day:     day.Format(DayFormat),
+		Created: time.Now(),
+	}
+	for _, f := range []struct {
+		name string
+		dump func(db *sql.DB, from, to time.Time) ([]byte, int, error)
+	}{
+		{FilePayments, dumpPaymentsDB},
+		{FileTransactions, dumpTransactionsDB},
+		{FileEvents, dumpEventsDB},
+	} {
+		// the records of all shards are archived in one file
+		var data []byte
+		var n int
+		for _, sh := range shards {
+			shardData, shardN, err := f.dump(sh.DB, from, to)
+			if err != nil {
+				log.Error("error reading records", log15.Ctx{"file": f.name, "shard": sh.Shard, "err": err})
+				return nil, err
+			}
+			data = append(data, shardData...)
+			n += shardN
+		}
+		err = a.put(objectName(day, f.name), data)
+		if err != nil {
+			log.Error("error writing file", log15.Ctx{"file": f.name, "err": err})
+			return nil, err
+		}
+		m.AddFile(f.name, n, data)
+	}
+	err = m.Sign(a.keys[0])
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	err = a.target.Put(objectName(day, ManifestName), data)
+	if err != nil {
+		log.Error("error writing manifest", log15.Ctx{"err": err})
+		return nil, err
+	}
+	log.Info("archive written", log15.Ctx{"files": len(m.Files)})
+	return m, nil
+}
+
+// put writes the object. Existing objects are accepted if their contents match
+func (a *Archiver) put(name string, data []byte) error {
+	err := a.target.Put(name, data)
+	if err != ErrExists {
+		return err
+	}
+	existing, err := a.target.Get(name)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(existing, data) {
+		return fmt.Errorf("archive object %s exists with different contents", name)
+	}
+	return nil
+}
+
+// Manifest retrieves the manifest of the archive of the given day
+func (a *Archiver) Manifest(day time.Time) (*Manifest, error) {
+	data, err := a.target.Get(objectName(day, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding manifest: %v", err)
+	}
+	return m, nil
+}
+
+// Verify checks the signature of the manifest and the hashes of the archived
+// files of the given day
+func (a *Archiver) Verify(day time.Time) (*Verification, error) {
+	m, err := a.Manifest(day)
+	if err != nil {
+		return nil, err
+	}
+	v := &Verification{Manifest: m}
+	if err = m.Verify(a.keys); err != nil {
+		v.Errors = append(v.Errors, err.Error())
+	}
+	for _, f := range m.Files {
+		data, err := a.target.Get(objectName(day, f.Name))
+		if err == ErrNotFound {
+			v.Errors = append(v.Errors, f.Name+": missing")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !f.Check(data) {
+			v.Errors = append(v.Errors, f.Name+": "+ErrFileCorrupt.Error())
+		}
+	}
+	v.Valid = len(v.Errors) == 0
+	return v, nil
+}
+
+// File retrieves an archived file of the given day
+//
+// Only files listed in the manifest can be retrieved. The contents will be
+// checked against the manifest.
+func (a *Archiver) File(day time.Time, name string) ([]byte, error) {
+	m, err := a.Manifest(day)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := m.File(name)
+	if !ok {
+		return nil, ErrFileNotArchived
+	}
+	data, err := a.target.Get(objectName(day, name))
+	if err != nil {
+		return nil, err
+	}
+	if !f.Check(data) {
+		return nil, ErrFileCorrupt
+	}
+	return data, nil
+}
+
+// RegisterJobs registers the archive job with the job runner
+func (a *Archiver) RegisterJobs(r *service.JobRunner) error {
+	daily, err := job.ParseSchedule("@daily")
+	if err != nil {
+		return err
+	}
+	return r.Register(&service.Job{
+		Name:     JobExport,
+		Schedule: daily,
+		Retry: job.RetryPolicy{
+			MaxAttempts: 5,
+			Backoff:     10 * time.Minute,
+		},
+		Run: a.exportPreviousDay,
+	})
+}
+
+func (a *Archiver) exportPreviousDay(done <-chan struct{}) error {
+	_, err := a.Export(time.Now().UTC().Add(-24 * time.Hour))
+	if err == ErrExists {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package archive
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestManifestSignature(t *testing.T) {
+	Convey("Given a signed manifest", t, func() {
+		key := []byte("archivekey")
+		m := &Manifest{
+			Version: Version,
+			Day:     "2015-06-01",
+			Created: time.Date(2015, 6, 2, 0, 0, 0, 0, time.UTC),
+		}
+		m.AddFile(FilePayments, 1, []byte("{}\n"))
+		So(m.Sign(key), ShouldBeNil)
+
+		Convey("It should verify with the key", func() {
+			So(m.Verify([][]byte{[]byte("old"), key}), ShouldBeNil)
+		})
+		Convey("It should not verify with another key", func() {
+			So(m.Verify([][]byte{[]byte("old")}), ShouldEqual, ErrInvalidSignature)
+		})
+		Convey("When the file list is modified", func() {
+			m.Files[0].Records = 0
+
+			Convey("It should not verify", func() {
+				So(m.Verify([][]byte{key}), ShouldEqual, ErrInvalidSignature)
+			})
+		})
+	})
+}
+
+func TestArchiverVerify(t *testing.T) {
+	Convey("Given an archive in a directory target", t, func() {
+		dir, err := ioutil.TempDir("", "archive")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		day := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+		log := log15.New()
+		log.SetHandler(log15.DiscardHandler())
+		a := &Archiver{
+			log:    log,
+			target: &DirTarget{Dir: dir},
+			keys:   [][]byte{[]byte("archivekey")},
+		}
+		data := []byte("{\"id\":\"1\"}\n")
+		So(a.put(objectName(day, FilePayments), data), ShouldBeNil)
+		m := &Manifest{Version: Version, Day: day.Format(DayFormat), Created: time.Now()}
+		m.AddFile(FilePayments, 1, data)
+		So(m.Sign(a.keys[0]), ShouldBeNil)
+		enc, err := json.Marshal(m)
+		So(err, ShouldBeNil)
+		So(a.target.Put(objectName(day, ManifestName), enc), ShouldBeNil)
+
+		Convey("When verifying the archive", func() {
+			v, err := a.Verify(day)
+
+			Convey("It should be valid", func() {
+				So(err, ShouldBeNil)
+				So(v.Valid, ShouldBeTrue)
+				So(len(v.Manifest.Files), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When retrieving an archived file", func() {
+			f, err := a.File(day, FilePayments)
+
+			Convey("It should match the archived data", func() {
+				So(err, ShouldBeNil)
+				So(string(f), ShouldEqual, string(data))
+			})
+		})
+
+		Convey("When retrieving a file which is not in the manifest", func() {
+			_, err := a.File(day, FileEvents)
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrFileNotArchived)
+			})
+		})
+
+		Convey("When writing an existing file with the same contents", func() {
+			err := a.put(objectName(day, FilePayments), data)
+
+			Convey("It should succeed", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When writing an existing file with other contents", func() {
+			err := a.put(objectName(day, FilePayments), []byte("{}\n"))
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When exporting the day again", func() {
+			_, err := a.Export(day)
+
+			Convey("It should not overwrite the archive", func() {
+				So(err, ShouldEqual, ErrExists)
+			})
+		})
+	})
+}
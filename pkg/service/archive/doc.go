@@ -0,0 +1,29 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package archive provides the compliance archives of payments
+
+Every day, the payments, payment transactions and payment events (the audit log)
+of the previous day are written to an archive. An archive consists of JSON lines
+files and a manifest holding the SHA-256 hashes of the files. The manifest is
+signed with the configured archive key.
+
+Archives are written to a target which does not allow overwriting existing
+objects. The S3 target locks the objects in compliance mode, so that they cannot
+be deleted or modified within the retention period.
+*/
+package archive
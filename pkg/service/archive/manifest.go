@@ -0,0 +1,124 @@
+package archive
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	// Version is the version of the archive format
+	Version = "1"
+	// DayFormat is the format of the archive days
+	DayFormat = "2006-01-02"
+	// ManifestName is the name of the manifest in an archive
+	ManifestName = "manifest.json"
+)
+
+var (
+	ErrNoKey            = errors.New("no archive key")
+	ErrInvalidSignature = errors.New("invalid manifest signature")
+	ErrVersion          = errors.New("unsupported archive version")
+)
+
+// File describes an archived file
+type File struct {
+	Name    string
+	Records int
+	Size    int
+	// SHA256 is the hex-encoded SHA-256 hash of the file
+	SHA256 string
+}
+
+// Manifest describes the files of an archive
+type Manifest struct {
+	Version string
+	// Day is the (UTC) day of the archived records
+	Day     string
+	Created time.Time
+	Files   []File
+
+	// Signature is the hex-encoded HMAC-SHA256 of the manifest
+	Signature string `json:",omitempty"`
+}
+
+// AddFile adds the file with the given contents to the manifest
+func (m *Manifest) AddFile(name string, records int, data []byte) {
+	sum := sha256.Sum256(data)
+	m.Files = append(m.Files, File{
+		Name:    name,
+		Records: records,
+		Size:    len(data),
+		SHA256:  hex.EncodeToString(sum[:]),
+	})
+}
+
+// File returns the description of the named file
+func (m *Manifest) File(name string) (File, bool) {
+	for _, f := range m.Files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return File{}, false
+}
+
+// Check returns true if the data matches the described file
+func (f File) Check(data []byte) bool {
+	sum := sha256.Sum256(data)
+	return len(data) == f.Size && hex.EncodeToString(sum[:]) == f.SHA256
+}
+
+func (m *Manifest) mac(key []byte) ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = ""
+	msg, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+// Sign signs the manifest with the given key
+func (m *Manifest) Sign(key []byte) error {
+	if len(key) == 0 {
+		return ErrNoKey
+	}
+	sig, err := m.mac(key)
+	if err != nil {
+		return err
+	}
+	m.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+// Verify verifies the manifest signature and version
+//
+// The signature must match one of the given keys.
+func (m *Manifest) Verify(keys [][]byte) error {
+	if len(keys) == 0 {
+		return ErrNoKey
+	}
+	sig, err := hex.DecodeString(m.Signature)
+	if err != nil || len(sig) == 0 {
+		return ErrInvalidSignature
+	}
+	for _, key := range keys {
+		expected, err := m.mac(key)
+		if err != nil {
+			return err
+		}
+		if hmac.Equal(sig, expected) {
+			if m.Version != Version {
+				return ErrVersion
+			}
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
@@ -0,0 +1,169 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3Timeout = time.Minute
+	// maximum number of bytes of an error response which will be reported
+	maxErrorBody = 512
+
+	amzDateFormat = "20060102T150405Z"
+	amzDayFormat  = "20060102"
+)
+
+// S3Target stores the archive objects in an S3 bucket
+//
+// Object lock must be enabled on the bucket. The objects will be locked in
+// compliance mode for the retention period. Objects are only created if they do
+// not exist.
+type S3Target struct {
+	// Endpoint is the base URL of the S3 service. Objects are addressed in path
+	// style
+	Endpoint        string
+	Region          string
+	Bucket          string
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Retention is the duration for which the objects will be locked. Zero
+	// disables object lock
+	Retention time.Duration
+
+	Client *http.Client
+}
+
+func (t *S3Target) url(name string) string {
+	return t.Endpoint + "/" + t.Bucket + "/" + t.Prefix + name
+}
+
+// Put implements the Target
+func (t *S3Target) Put(name string, data []byte) error {
+	req, err := http.NewRequest("PUT", t.url(name), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	sum := md5.Sum(data)
+	req.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	req.Header.Set("If-None-Match", "*")
+	if t.Retention > 0 {
+		req.Header.Set("X-Amz-Object-Lock-Mode", "COMPLIANCE")
+		req.Header.Set("X-Amz-Object-Lock-Retain-Until-Date", time.Now().Add(t.Retention).UTC().Format(time.RFC3339))
+	}
+	resp, err := t.do(req, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get implements the Target
+func (t *S3Target) Get(name string) ([]byte, error) {
+	req, err := http.NewRequest("GET", t.url(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (t *S3Target) do(req *http.Request, payload []byte) (*http.Response, error) {
+	SignV4(req, payload, t.AccessKeyID, t.SecretAccessKey, t.Region, time.Now())
+	cl := t.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		resp.Body.Close()
+		return nil, ErrExists
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 responded with status %d: %s", resp.StatusCode, b)
+	}
+	return resp, nil
+}
+
+// SignV4 signs the S3 request with the AWS signature version 4
+//
+// All headers of the request and the host will be signed.
+func SignV4(req *http.Request, payload []byte, keyID, secret, region string, t time.Time) {
+	t = t.UTC()
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonicalHeaders := &bytes.Buffer{}
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := t.Format(amzDayFormat) + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format(amzDateFormat) + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret), t.Format(amzDayFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+keyID+"/"+scope+
+		",SignedHeaders="+signedHeaders+",Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
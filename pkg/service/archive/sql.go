@@ -0,0 +1,135 @@
+package archive
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const selectPayments = `
+SELECT
+	p.id,
+	p.project_id,
+	p.created,
+	p.ident,
+	p.amount,
+	p.subunits,
+	p.currency,
+	(
+		SELECT n.note FROM payment_note AS n
+		WHERE
+			n.project_id = p.project_id
+			AND
+			n.payment_id = p.id
+		ORDER BY n.timestamp DESC
+		LIMIT 1
+	) AS note
+FROM payment AS p
+WHERE
+	p.created >= ?
+	AND
+	p.created < ?
+ORDER BY p.id
+`
+
+const selectTransactions = `
+SELECT
+	tx.project_id,
+	tx.payment_id,
+	tx.timestamp,
+	tx.amount,
+	tx.subunits,
+	tx.currency,
+	tx.status,
+	tx.comment
+FROM payment_transaction AS tx
+WHERE
+	tx.timestamp >= ?
+	AND
+	tx.timestamp < ?
+ORDER BY tx.timestamp, tx.project_id, tx.payment_id
+`
+
+const selectEvents = `
+SELECT
+	e.id,
+	e.project_id,
+	e.payment_id,
+	e.timestamp,
+	e.amount,
+	e.subunits,
+	e.currency,
+	e.status,
+	e.comment,
+	e.prev_hash,
+	e.hash
+FROM payment_event AS e
+WHERE
+	e.timestamp >= ?
+	AND
+	e.timestamp < ?
+ORDER BY e.id
+`
+
+// dumpPaymentsDB writes the payments created within the given period as JSON
+// lines
+//
+// The note column holds the current note of the payment. It is null for
+// payments without a note.
+func dumpPaymentsDB(db *sql.DB, from, to time.Time) ([]byte, int, error) {
+	return dumpRows(db.Query(selectPayments, from, to))
+}
+
+// dumpTransactionsDB writes the payment transactions within the given period
+// as JSON lines
+func dumpTransactionsDB(db *sql.DB, from, to time.Time) ([]byte, int, error) {
+	return dumpRows(db.Query(selectTransactions, from.UnixNano(), to.UnixNano()))
+}
+
+// dumpEventsDB writes the payment events within the given period as JSON lines
+func dumpEventsDB(db *sql.DB, from, to time.Time) ([]byte, int, error) {
+	return dumpRows(db.Query(selectEvents, from.UnixNano(), to.UnixNano()))
+}
+
+// dumpRows encodes every row as a JSON object of the column names and the
+// string values
+func dumpRows(rows *sql.Rows, err error) ([]byte, int, error) {
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, 0, err
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var n int
+	for rows.Next() {
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, n, err
+		}
+		rec := make(map[string]*string, len(cols))
+		for i, col := range cols {
+			if values[i] != nil {
+				v := string(values[i])
+				rec[col] = &v
+			} else {
+				rec[col] = nil
+			}
+		}
+		err = enc.Encode(rec)
+		if err != nil {
+			return nil, n, err
+		}
+		n++
+	}
+	return buf.Bytes(), n, rows.Err()
+}
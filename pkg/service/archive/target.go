@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+)
+
+// Target types
+const (
+	TargetTypeDir = "dir"
+	TargetTypeS3  = "s3"
+)
+
+var (
+	ErrExists   = errors.New("archive object exists")
+	ErrNotFound = errors.New("archive object not found")
+)
+
+// Target stores archive objects
+//
+// Targets must not overwrite existing objects.
+type Target interface {
+	// Put stores a new object. It returns ErrExists if the object exists
+	Put(name string, data []byte) error
+	// Get retrieves an object. It returns ErrNotFound if no such object exists
+	Get(name string) ([]byte, error)
+}
+
+// NewTarget creates the configured archive target
+func NewTarget(cfg *config.Config) (Target, error) {
+	switch cfg.Archive.Target {
+	case TargetTypeDir:
+		if cfg.Archive.Dir == "" {
+			return nil, fmt.Errorf("missing archive dir")
+		}
+		return &DirTarget{Dir: cfg.Archive.Dir}, nil
+	case TargetTypeS3:
+		s3 := cfg.Archive.S3
+		if s3.Endpoint == "" || s3.Region == "" || s3.Bucket == "" {
+			return nil, fmt.Errorf("S3 archive target requires endpoint, region and bucket")
+		}
+		if s3.RetentionDays < 0 {
+			return nil, fmt.Errorf("invalid S3 retention days %d", s3.RetentionDays)
+		}
+		return &S3Target{
+			Endpoint:        strings.TrimRight(s3.Endpoint, "/"),
+			Region:          s3.Region,
+			Bucket:          s3.Bucket,
+			Prefix:          s3.Prefix,
+			AccessKeyID:     s3.AccessKeyID,
+			SecretAccessKey: s3.SecretAccessKey,
+			Retention:       time.Duration(s3.RetentionDays) * 24 * time.Hour,
+			Client:          &http.Client{Timeout: s3Timeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid archive target %q", cfg.Archive.Target)
+	}
+}
+
+// DirTarget stores the archive objects as read-only files in a directory
+type DirTarget struct {
+	Dir string
+}
+
+func (t *DirTarget) path(name string) string {
+	return filepath.Join(t.Dir, filepath.FromSlash(name))
+}
+
+// Put implements the Target
+func (t *DirTarget) Put(name string, data []byte) error {
+	p := t.path(name)
+	err := os.MkdirAll(filepath.Dir(p), 0750)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0440)
+	if err != nil {
+		if os.IsExist(err) {
+			return ErrExists
+		}
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Get implements the Target
+func (t *DirTarget) Get(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(t.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"github.com/fritzpay/paymentd/pkg/config"
+)
+
+// BodyLimits are the limits of the request bodies of the API endpoints
+type BodyLimits struct {
+	// MaxSize is the default maximum body size in bytes
+	MaxSize int64
+	// Sizes are maximum body sizes by endpoint
+	Sizes map[string]int64
+	// MaxEntries is the maximum number of entries of a JSON object or array
+	MaxEntries int
+}
+
+// NewBodyLimits creates new body limits without any limit
+func NewBodyLimits() *BodyLimits {
+	return &BodyLimits{
+		Sizes: make(map[string]int64),
+	}
+}
+
+// Size returns the maximum body size of the endpoint
+//
+// The endpoint name is prefixed with the request method, e.g.
+// "POST /v1/payment". A size <= 0 means the body size is not limited.
+func (b *BodyLimits) Size(endpoint string) int64 {
+	if b == nil {
+		return 0
+	}
+	if s, ok := b.Sizes[endpoint]; ok {
+		return s
+	}
+	return b.MaxSize
+}
+
+// Entries returns the maximum number of entries of a JSON object or array
+//
+// A value <= 0 means the entries are not limited.
+func (b *BodyLimits) Entries() int {
+	if b == nil {
+		return 0
+	}
+	return b.MaxEntries
+}
+
+func bodyLimitsFromConfig(cfg config.Config) *BodyLimits {
+	b := NewBodyLimits()
+	b.MaxSize = cfg.API.Body.MaxSize
+	b.MaxEntries = cfg.API.Body.MaxEntries
+	for endpoint, s := range cfg.API.Body.Sizes {
+		b.Sizes[endpoint] = s
+	}
+	return b
+}
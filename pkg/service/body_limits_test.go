@@ -0,0 +1,30 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBodyLimits(t *testing.T) {
+	Convey("Given body limits from the config", t, func() {
+		cfg := config.DefaultConfig()
+		cfg.API.Body.Sizes["POST /v1/payment"] = 4096
+		b := bodyLimitsFromConfig(cfg)
+
+		Convey("Endpoint sizes should override the default", func() {
+			So(b.Size("POST /v1/payment"), ShouldEqual, 4096)
+			So(b.Size("PUT /v1/project/{projectid}"), ShouldEqual, cfg.API.Body.MaxSize)
+			So(b.Entries(), ShouldEqual, cfg.API.Body.MaxEntries)
+		})
+	})
+	Convey("Given no body limits", t, func() {
+		var b *BodyLimits
+
+		Convey("Nothing should be limited", func() {
+			So(b.Size("POST /v1/payment"), ShouldEqual, 0)
+			So(b.Entries(), ShouldEqual, 0)
+		})
+	})
+}
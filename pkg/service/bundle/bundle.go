@@ -0,0 +1,137 @@
+package bundle
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+)
+
+const (
+	// Version is the version of the bundle format
+	Version = "1"
+)
+
+var (
+	ErrNoKey            = errors.New("no bundle key")
+	ErrInvalidSignature = errors.New("invalid bundle signature")
+	ErrVersion          = errors.New("unsupported bundle version")
+)
+
+// Bundle represents the exported configuration of a project
+type Bundle struct {
+	Version   string
+	Created   time.Time
+	CreatedBy string
+
+	Project Project
+
+	// Signature is the hex-encoded HMAC-SHA256 of the bundle
+	Signature string `json:",omitempty"`
+}
+
+// Project represents the configuration of a project in a bundle
+type Project struct {
+	Name     string
+	Config   project.Config
+	Metadata map[string]string `json:",omitempty"`
+
+	Methods []Method `json:",omitempty"`
+	Domains []Domain `json:",omitempty"`
+}
+
+// Method represents a payment method in a bundle
+type Method struct {
+	Provider  string
+	MethodKey string
+	Status    string
+	Metadata  map[string]string `json:",omitempty"`
+
+	// PayPal holds the PayPal configuration (without the secret) of the method
+	PayPal *PayPalConfig `json:",omitempty"`
+}
+
+// PayPalConfig represents the PayPal provider configuration of a method
+type PayPalConfig struct {
+	Endpoint  string
+	ClientID  string
+	Type      string
+	WebhookID string `json:",omitempty"`
+}
+
+// Domain represents a checkout domain in a bundle
+//
+// Domains will have to be verified in every environment.
+type Domain struct {
+	Domain      string
+	TLSCertFile string `json:",omitempty"`
+	TLSKeyFile  string `json:",omitempty"`
+}
+
+func (b *Bundle) mac(key []byte) ([]byte, error) {
+	unsigned := *b
+	unsigned.Signature = ""
+	msg, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+// Sign signs the bundle with the given key
+func (b *Bundle) Sign(key []byte) error {
+	if len(key) == 0 {
+		return ErrNoKey
+	}
+	sig, err := b.mac(key)
+	if err != nil {
+		return err
+	}
+	b.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+// Verify verifies the bundle signature and version
+//
+// The signature must match one of the given keys.
+func (b *Bundle) Verify(keys [][]byte) error {
+	if len(keys) == 0 {
+		return ErrNoKey
+	}
+	sig, err := hex.DecodeString(b.Signature)
+	if err != nil || len(sig) == 0 {
+		return ErrInvalidSignature
+	}
+	for _, key := range keys {
+		expected, err := b.mac(key)
+		if err != nil {
+			return err
+		}
+		if hmac.Equal(sig, expected) {
+			if b.Version != Version {
+				return ErrVersion
+			}
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// DecodeKeys decodes the given hex-encoded bundle keys
+func DecodeKeys(hexKeys []string) ([][]byte, error) {
+	keys := make([][]byte, len(hexKeys))
+	for i, k := range hexKeys {
+		var err error
+		keys[i], err = hex.DecodeString(k)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
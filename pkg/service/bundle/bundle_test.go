@@ -0,0 +1,77 @@
+package bundle
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBundleSignature(t *testing.T) {
+	Convey("Given a bundle", t, func() {
+		b := &Bundle{
+			Version:   Version,
+			Created:   time.Now().UTC(),
+			CreatedBy: "test",
+		}
+		b.Project.Name = "testproject"
+		b.Project.Metadata = map[string]string{"key": "value"}
+		b.Project.Methods = []Method{
+			{Provider: "fritzpay", MethodKey: "test", Status: "active"},
+		}
+		key := []byte("secret")
+
+		Convey("When signing without a key", func() {
+			err := b.Sign(nil)
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrNoKey)
+			})
+		})
+
+		Convey("When signing the bundle", func() {
+			err := b.Sign(key)
+			So(err, ShouldBeNil)
+			So(b.Signature, ShouldNotBeEmpty)
+
+			Convey("It should verify after encoding", func() {
+				enc, err := json.Marshal(b)
+				So(err, ShouldBeNil)
+				dec := &Bundle{}
+				err = json.Unmarshal(enc, dec)
+				So(err, ShouldBeNil)
+				So(dec.Verify([][]byte{[]byte("other"), key}), ShouldBeNil)
+			})
+			Convey("It should not verify with other keys", func() {
+				So(b.Verify([][]byte{[]byte("other")}), ShouldEqual, ErrInvalidSignature)
+				So(b.Verify(nil), ShouldEqual, ErrNoKey)
+			})
+			Convey("It should not verify when modified", func() {
+				b.Project.Methods[0].Status = "inactive"
+				So(b.Verify([][]byte{key}), ShouldEqual, ErrInvalidSignature)
+			})
+			Convey("It should not verify an unsupported version", func() {
+				b.Version = "0"
+				err = b.Sign(key)
+				So(err, ShouldBeNil)
+				So(b.Verify([][]byte{key}), ShouldEqual, ErrVersion)
+			})
+		})
+	})
+}
+
+func TestDecodeKeys(t *testing.T) {
+	Convey("Given hex-encoded keys", t, func() {
+		keys, err := DecodeKeys([]string{"0102", "ff"})
+		Convey("They should be decoded", func() {
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, [][]byte{{1, 2}, {255}})
+		})
+	})
+	Convey("Given invalid keys", t, func() {
+		_, err := DecodeKeys([]string{"xyz"})
+		Convey("Decoding should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,30 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package bundle provides the export and import of a project's configuration as
+signed JSON bundles
+
+Bundles can be used to promote a project configuration from one environment to
+another, e.g. from staging to production. Project keys and provider secrets are
+never included in a bundle. Those have to be managed per environment.
+
+Method bundles describe the onboarding of a single payment method of a project,
+including its provider config, routing rules and webhook subscription. They are
+written by operators, validated against the provider driver and applied in one
+transaction of the payment database.
+*/
+package bundle
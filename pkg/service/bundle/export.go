@@ -0,0 +1,96 @@
+package bundle
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service/provider/paypal_rest"
+	"golang.org/x/net/context"
+)
+
+// provider names with provider configurations
+const (
+	providerPayPal = "paypal_rest"
+)
+
+// Export creates an (unsigned) bundle of the configuration of the given project
+//
+// It will return project.ErrProjectNotFound if no such project exists.
+func Export(ctx context.Context, principalDB, paymentDB *sql.DB, projectID int64, createdBy string) (*Bundle, error) {
+	pr, err := project.ProjectByIDDB(ctx, principalDB, projectID)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{
+		Version:   Version,
+		Created:   time.Now().UTC(),
+		CreatedBy: createdBy,
+	}
+	b.Project.Name = pr.Name
+	b.Project.Config = pr.Config
+	// project keys are managed per environment
+	b.Project.Config.CallbackProjectKey = sql.NullString{}
+	// callback transport settings contain certificate paths and credentials
+	b.Project.Config.CallbackTLSCertFile = sql.NullString{}
+	b.Project.Config.CallbackTLSKeyFile = sql.NullString{}
+	b.Project.Config.CallbackHeaders = sql.NullString{}
+
+	md, err := metadata.MetadataByPrimaryDB(principalDB, project.MetadataModel, pr.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving project metadata: %v", err)
+	}
+	if len(md) > 0 {
+		b.Project.Metadata = md.Values()
+	}
+
+	domains, err := project.DomainsByProjectIDDB(ctx, principalDB, pr.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving domains: %v", err)
+	}
+	for _, d := range domains {
+		b.Project.Domains = append(b.Project.Domains, Domain{
+			Domain:      d.Domain,
+			TLSCertFile: d.TLSCertFile.String,
+			TLSKeyFile:  d.TLSKeyFile.String,
+		})
+	}
+
+	methods, err := payment_method.PaymentMethodsByProjectIDDB(ctx, paymentDB, pr.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving payment methods: %v", err)
+	}
+	for _, pm := range methods {
+		m := Method{
+			Provider:  pm.Provider.Name,
+			MethodKey: pm.MethodKey,
+			Status:    pm.Status.String(),
+		}
+		md, err = metadata.MetadataByPrimaryDB(paymentDB, payment_method.MetadataModel, pm.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving payment method metadata: %v", err)
+		}
+		if len(md) > 0 {
+			m.Metadata = md.Values()
+		}
+		if pm.Provider.Name == providerPayPal {
+			cfg, err := paypal_rest.ConfigByPaymentMethodDB(ctx, paymentDB, pm)
+			if err != nil && err != paypal_rest.ErrConfigNotFound {
+				return nil, fmt.Errorf("error retrieving paypal config: %v", err)
+			}
+			if err == nil {
+				m.PayPal = &PayPalConfig{
+					Endpoint:  cfg.Endpoint,
+					ClientID:  cfg.ClientID,
+					Type:      cfg.Type,
+					WebhookID: cfg.WebhookID.String,
+				}
+			}
+		}
+		b.Project.Methods = append(b.Project.Methods, m)
+	}
+	return b, nil
+}
@@ -0,0 +1,221 @@
+package bundle
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service/provider/paypal_rest"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrInvalidBundle is returned if the bundle contents cannot be imported
+	ErrInvalidBundle = errors.New("invalid bundle")
+)
+
+// Result is the outcome of an import
+type Result struct {
+	Project *project.Project
+	// Warnings lists the parts of the bundle which were not applied
+	Warnings []string
+}
+
+func (r *Result) warn(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// ImportProject applies the project part of the bundle to the project with the
+// bundle's name of the given principal
+//
+// The project will be created if it does not exist. Existing configurations will
+// receive new versions, nothing will be removed.
+//
+// The bundle signature must be verified before importing. The transaction must
+// be committed before calling ImportMethods. The TLS files of the domains must
+// be located in the domain certificate directory certDir.
+func ImportProject(ctx context.Context, principalTx *sql.Tx, principalID int64, b *Bundle, createdBy, certDir string) (*Result, error) {
+	if b.Project.Name == "" {
+		return nil, ErrInvalidBundle
+	}
+	for _, m := range b.Project.Methods {
+		if _, err := payment_method.ParseMethodStatus(m.Status); err != nil {
+			return nil, ErrInvalidBundle
+		}
+	}
+	res := &Result{}
+	pr, err := importProject(ctx, principalTx, principalID, b, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	res.Project = pr
+	err = importDomains(ctx, principalTx, pr, b, createdBy, certDir, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ImportMethods applies the payment methods of the bundle to the imported
+// project of the result
+//
+// Existing payment methods will receive new versions, nothing will be removed.
+func ImportMethods(ctx context.Context, paymentTx *sql.Tx, res *Result, b *Bundle, createdBy string) error {
+	return importMethods(ctx, paymentTx, res.Project, b, createdBy, res)
+}
+
+func importProject(ctx context.Context, tx *sql.Tx, principalID int64, b *Bundle, createdBy string) (*project.Project, error) {
+	pr, err := project.ProjectByPrincipalIDAndNameTx(ctx, tx, principalID, b.Project.Name)
+	if err != nil && err != project.ErrProjectNotFound {
+		return nil, fmt.Errorf("error retrieving project: %v", err)
+	}
+	if err == project.ErrProjectNotFound {
+		pr = &project.Project{
+			PrincipalID: principalID,
+			Name:        b.Project.Name,
+			Created:     time.Now().UTC().Round(time.Second),
+			CreatedBy:   createdBy,
+		}
+		err = project.InsertProjectTx(ctx, tx, pr)
+		if err != nil {
+			return nil, fmt.Errorf("error creating project: %v", err)
+		}
+	}
+	if b.Project.Config.HasValues() {
+		cfg := b.Project.Config
+		// keep the callback project key of this environment
+		cfg.CallbackProjectKey = pr.Config.CallbackProjectKey
+		cfg.CallbackTLSCertFile = pr.Config.CallbackTLSCertFile
+		cfg.CallbackTLSKeyFile = pr.Config.CallbackTLSKeyFile
+		cfg.CallbackHeaders = pr.Config.CallbackHeaders
+		pr.Config = cfg
+		err = project.InsertProjectConfigTx(ctx, tx, pr)
+		if err != nil {
+			return nil, fmt.Errorf("error saving project config: %v", err)
+		}
+	}
+	if len(b.Project.Metadata) > 0 {
+		md := metadata.MetadataFromValues(b.Project.Metadata, createdBy)
+		err = metadata.InsertMetadataTx(tx, project.MetadataModel, pr.ID, md)
+		if err != nil {
+			return nil, fmt.Errorf("error saving project metadata: %v", err)
+		}
+		pr.Metadata = b.Project.Metadata
+	}
+	return pr, nil
+}
+
+func importDomains(ctx context.Context, tx *sql.Tx, pr *project.Project, b *Bundle, createdBy, certDir string, res *Result) error {
+	for _, bd := range b.Project.Domains {
+		d, err := project.NewDomain(pr.ID, bd.Domain, createdBy)
+		if err != nil {
+			res.warn("domain %s: %v", bd.Domain, err)
+			continue
+		}
+		_, err = project.DomainByProjectIDAndNameTx(ctx, tx, pr.ID, d.Domain)
+		if err == nil {
+			// already registered
+			continue
+		}
+		if err != project.ErrDomainNotFound {
+			return fmt.Errorf("error retrieving domain: %v", err)
+		}
+		existing, err := project.VerifiedDomainByNameTx(ctx, tx, d.Domain)
+		if err != nil && err != project.ErrDomainNotFound {
+			return fmt.Errorf("error retrieving domain: %v", err)
+		}
+		if err == nil && existing.ProjectID != pr.ID {
+			res.warn("domain %s: verified for another project", d.Domain)
+			continue
+		}
+		if bd.TLSCertFile != "" && bd.TLSKeyFile != "" {
+			certFile, certErr := project.DomainTLSFile(certDir, bd.TLSCertFile)
+			keyFile, keyErr := project.DomainTLSFile(certDir, bd.TLSKeyFile)
+			if certErr == nil && keyErr == nil {
+				d.SetTLS(certFile, keyFile)
+			} else {
+				res.warn("domain %s: TLS files outside of the certificate directory, not assigned", d.Domain)
+			}
+		}
+		err = project.InsertDomainTx(ctx, tx, d)
+		if err != nil {
+			return fmt.Errorf("error saving domain: %v", err)
+		}
+		res.warn("domain %s: registered, verification required", d.Domain)
+	}
+	return nil
+}
+
+func importMethods(ctx context.Context, tx *sql.Tx, pr *project.Project, b *Bundle, createdBy string, res *Result) error {
+	for _, m := range b.Project.Methods {
+		// validated before
+		status, _ := payment_method.ParseMethodStatus(m.Status)
+		pm, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(ctx, tx, pr.ID, m.Provider, m.MethodKey)
+		if err != nil && err != payment_method.ErrPaymentMethodNotFound {
+			return fmt.Errorf("error retrieving payment method: %v", err)
+		}
+		if err == payment_method.ErrPaymentMethodNotFound {
+			pm = &payment_method.Method{
+				ProjectID: pr.ID,
+				MethodKey: m.MethodKey,
+				Created:   time.Now().UTC().Round(time.Second),
+				CreatedBy: createdBy,
+			}
+			pm.Provider.Name = m.Provider
+			err = payment_method.InsertPaymentMethodTx(ctx, tx, pm)
+			if err != nil {
+				return fmt.Errorf("error creating payment method %s: %v", m.MethodKey, err)
+			}
+		}
+		if pm.Status != status {
+			pm.Status = status
+			pm.StatusCreatedBy = createdBy
+			err = payment_method.InsertPaymentMethodStatusTx(ctx, tx, pm)
+			if err != nil {
+				return fmt.Errorf("error saving payment method status: %v", err)
+			}
+		}
+		if len(m.Metadata) > 0 {
+			pm.Metadata = m.Metadata
+			err = payment_method.InsertPaymentMethodMetadataTx(ctx, tx, pm, createdBy)
+			if err != nil {
+				return fmt.Errorf("error saving payment method metadata: %v", err)
+			}
+		}
+		if m.PayPal != nil {
+			err = importPayPalConfig(ctx, tx, pm, m.PayPal, createdBy, res)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func importPayPalConfig(ctx context.Context, tx *sql.Tx, pm *payment_method.Method, c *PayPalConfig, createdBy string, res *Result) error {
+	cfg, err := paypal_rest.ConfigByPaymentMethodTx(ctx, tx, pm)
+	if err == paypal_rest.ErrConfigNotFound {
+		// the secret is not part of the bundle
+		res.warn("method %s: paypal config requires a secret, not imported", pm.MethodKey)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error retrieving paypal config: %v", err)
+	}
+	if cfg.Endpoint == c.Endpoint && cfg.ClientID == c.ClientID && cfg.Type == c.Type && cfg.WebhookID.String == c.WebhookID {
+		return nil
+	}
+	cfg.Endpoint, cfg.ClientID, cfg.Type = c.Endpoint, c.ClientID, c.Type
+	cfg.WebhookID.String, cfg.WebhookID.Valid = c.WebhookID, c.WebhookID != ""
+	cfg.Created = time.Now().UTC().Round(time.Second)
+	cfg.CreatedBy = createdBy
+	err = paypal_rest.InsertConfigTx(ctx, tx, cfg)
+	if err != nil {
+		return fmt.Errorf("error saving paypal config: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package bundle
+
+import (
+	"fmt"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+)
+
+const (
+	// MethodVersion is the version of the method bundle format
+	MethodVersion = "1"
+)
+
+// MethodBundle describes the onboarding of a payment method of a project
+//
+// It holds the payment method with its provider config, its routing rules and
+// its webhook subscription with the provider. Method bundles are written by
+// operators and are not signed. They are validated against the capabilities of
+// the provider driver and applied in one transaction.
+type MethodBundle struct {
+	Version   string
+	Provider  string
+	MethodKey string
+	Status    string
+	// Capabilities lists the driver capabilities the payment method relies
+	// on, e.g. "capture"
+	Capabilities []string          `json:",omitempty"`
+	Metadata     map[string]string `json:",omitempty"`
+
+	Routing *Routing `json:",omitempty"`
+	// PayPal holds the PayPal configuration of the method
+	PayPal *MethodPayPalConfig `json:",omitempty"`
+	// Webhook holds the subscription of the method to the webhook events of
+	// the provider
+	Webhook *Webhook `json:",omitempty"`
+}
+
+// Routing holds the routing rules of a payment method
+//
+// Routing rules which are not set will be removed from the payment method.
+type Routing struct {
+	// Currencies lists the ISO 4217 codes of the currencies the method can
+	// process. All currencies will be processed if empty.
+	Currencies []string `json:",omitempty"`
+	// CurrencyFallback is the payment method of the project which will be used
+	// for payments in other currencies
+	CurrencyFallback *MethodRef `json:",omitempty"`
+	// DomesticDebitRoute is the payment method of the project which will be
+	// used for payments with domestic debit cards
+	DomesticDebitRoute *MethodRef `json:",omitempty"`
+}
+
+// MethodRef references a payment method of the same project
+type MethodRef struct {
+	Provider  string
+	MethodKey string
+}
+
+func (r *MethodRef) String() string {
+	return r.Provider + "/" + r.MethodKey
+}
+
+// MethodPayPalConfig represents the PayPal provider configuration of a method
+// bundle
+//
+// The secret of the current configuration will be kept if the secret is empty.
+type MethodPayPalConfig struct {
+	Endpoint string
+	ClientID string
+	Secret   string `json:",omitempty"`
+	Type     string
+}
+
+// Webhook represents the subscription of a payment method to the webhook events
+// of the provider
+type Webhook struct {
+	// ID is the ID of the webhook registered with the provider
+	ID string
+}
+
+// routingMetadataKeys are the payment method metadata keys which are set by
+// the routing rules of a method bundle
+var routingMetadataKeys = []string{
+	payment_method.MetadataKeyCurrencies,
+	payment_method.MetadataKeyCurrencyFallback,
+	payment_method.MetadataKeyDomesticDebitRoute,
+}
+
+// invalidf returns an ErrInvalidBundle describing the problem
+func invalidf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidBundle, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the method bundle against the driver of its provider
+//
+// It does not access the database. References to other payment methods and the
+// resulting provider config are checked when the bundle is applied. The
+// returned errors wrap ErrInvalidBundle.
+func (b *MethodBundle) Validate() error {
+	if b.Version != MethodVersion {
+		return ErrVersion
+	}
+	if b.Provider == "" || b.MethodKey == "" {
+		return invalidf("provider and method key required")
+	}
+	if !provider.HasDriver(b.Provider) {
+		return invalidf("no driver for provider %s", b.Provider)
+	}
+	if _, err := payment_method.ParseMethodStatus(b.Status); err != nil {
+		return invalidf("invalid status %s", b.Status)
+	}
+	caps := provider.Capabilities(b.Provider)
+	for _, c := range b.Capabilities {
+		if !containsString(caps, c) {
+			return invalidf("capability %s not supported by provider %s", c, b.Provider)
+		}
+	}
+	for _, k := range routingMetadataKeys {
+		if _, ok := b.Metadata[k]; ok {
+			return invalidf("metadata key %s is set by the routing rules", k)
+		}
+	}
+	if b.Routing != nil {
+		for _, c := range b.Routing.Currencies {
+			if len(c) != 3 {
+				return invalidf("invalid currency %s", c)
+			}
+		}
+		for _, ref := range []*MethodRef{b.Routing.CurrencyFallback, b.Routing.DomesticDebitRoute} {
+			if ref == nil {
+				continue
+			}
+			if ref.Provider == "" || ref.MethodKey == "" {
+				return invalidf("provider and method key required in routing rules")
+			}
+			if ref.Provider == b.Provider && ref.MethodKey == b.MethodKey {
+				return invalidf("payment method %s routes to itself", ref)
+			}
+		}
+	}
+	if b.PayPal != nil && b.Provider != providerPayPal {
+		return invalidf("PayPal config not supported by provider %s", b.Provider)
+	}
+	if b.Webhook != nil {
+		if b.Provider != providerPayPal {
+			return invalidf("webhooks not supported by provider %s", b.Provider)
+		}
+		if b.Webhook.ID == "" {
+			return invalidf("webhook id required")
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package bundle
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMethodBundleValidation(t *testing.T) {
+	Convey("Given a method bundle", t, func() {
+		b := &MethodBundle{}
+		err := json.Unmarshal([]byte(`{
+			"Version": "1",
+			"Provider": "paypal_rest",
+			"MethodKey": "paypal",
+			"Status": "active",
+			"Capabilities": ["capture"],
+			"Routing": {
+				"Currencies": ["EUR", "USD"],
+				"CurrencyFallback": {"Provider": "fritzpay", "MethodKey": "test"}
+			},
+			"PayPal": {
+				"Endpoint": "https://api.sandbox.paypal.com",
+				"ClientID": "client",
+				"Secret": "secret",
+				"Type": "sale"
+			},
+			"Webhook": {"ID": "8PT597110X687430LKGECATA"}
+		}`), b)
+		So(err, ShouldBeNil)
+
+		Convey("It should be valid", func() {
+			So(b.Validate(), ShouldBeNil)
+		})
+
+		Convey("When the version is unknown", func() {
+			b.Version = "2"
+			Convey("It should be rejected", func() {
+				So(b.Validate(), ShouldEqual, ErrVersion)
+			})
+		})
+
+		Convey("When the provider has no driver", func() {
+			b.Provider = "unknown"
+			Convey("It should be invalid", func() {
+				So(errors.Is(b.Validate(), ErrInvalidBundle), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the status is invalid", func() {
+			b.Status = "enabled"
+			Convey("It should be invalid", func() {
+				So(errors.Is(b.Validate(), ErrInvalidBundle), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a capability is not supported by the driver", func() {
+			b.Capabilities = append(b.Capabilities, "verification")
+			Convey("It should be invalid", func() {
+				err := b.Validate()
+				So(errors.Is(err, ErrInvalidBundle), ShouldBeTrue)
+				So(err.Error(), ShouldContainSubstring, "verification")
+			})
+		})
+
+		Convey("When the metadata holds a routing key", func() {
+			b.Metadata = map[string]string{"currency_fallback": "2"}
+			Convey("It should be invalid", func() {
+				So(errors.Is(b.Validate(), ErrInvalidBundle), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the method routes to itself", func() {
+			b.Routing.CurrencyFallback = &MethodRef{Provider: "paypal_rest", MethodKey: "paypal"}
+			Convey("It should be invalid", func() {
+				So(errors.Is(b.Validate(), ErrInvalidBundle), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the provider does not match the provider config", func() {
+			b.Provider = "fritzpay"
+			b.Capabilities = nil
+			b.Webhook = nil
+			Convey("It should be invalid", func() {
+				err := b.Validate()
+				So(errors.Is(err, ErrInvalidBundle), ShouldBeTrue)
+				So(err.Error(), ShouldContainSubstring, "PayPal config")
+			})
+		})
+
+		Convey("When the provider does not receive webhooks", func() {
+			b.Provider = "fritzpay"
+			b.Capabilities = nil
+			b.PayPal = nil
+			Convey("It should be invalid", func() {
+				err := b.Validate()
+				So(errors.Is(err, ErrInvalidBundle), ShouldBeTrue)
+				So(err.Error(), ShouldContainSubstring, "webhooks")
+			})
+		})
+	})
+}
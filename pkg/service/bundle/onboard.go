@@ -0,0 +1,229 @@
+package bundle
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	paymentdProvider "github.com/fritzpay/paymentd/pkg/paymentd/provider"
+	"github.com/fritzpay/paymentd/pkg/service/provider/paypal_rest"
+	"golang.org/x/net/context"
+)
+
+// MethodResult is the outcome of applying a method bundle
+type MethodResult struct {
+	Method *payment_method.Method
+	// Changes lists the parts of the payment method which were changed
+	Changes []string
+}
+
+func (r *MethodResult) change(format string, args ...interface{}) {
+	r.Changes = append(r.Changes, fmt.Sprintf(format, args...))
+}
+
+// ApplyMethod applies the method bundle to the project with the given ID
+//
+// The payment method will be created if it does not exist. Configurations
+// which differ from the bundle receive new versions, so applying the same
+// bundle again will not change anything.
+//
+// All changes are made in the given transaction. It must be rolled back if an
+// error is returned. Errors caused by the bundle wrap ErrInvalidBundle.
+func ApplyMethod(ctx context.Context, paymentTx *sql.Tx, projectID int64, b *MethodBundle, createdBy string) (*MethodResult, error) {
+	err := b.Validate()
+	if err != nil {
+		return nil, err
+	}
+	prov, err := paymentdProvider.ProviderByNameTx(ctx, paymentTx, b.Provider)
+	if err != nil {
+		if err == paymentdProvider.ErrProviderNotFound {
+			return nil, invalidf("provider %s not registered", b.Provider)
+		}
+		return nil, fmt.Errorf("error retrieving provider: %v", err)
+	}
+	routing, err := routingMetadata(ctx, paymentTx, projectID, b.Routing)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &MethodResult{}
+	pm, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(ctx, paymentTx, projectID, b.Provider, b.MethodKey)
+	if err != nil && err != payment_method.ErrPaymentMethodNotFound {
+		return nil, fmt.Errorf("error retrieving payment method: %v", err)
+	}
+	if err == payment_method.ErrPaymentMethodNotFound {
+		pm = &payment_method.Method{
+			ProjectID: projectID,
+			Provider:  prov,
+			MethodKey: b.MethodKey,
+			Created:   time.Now().UTC().Round(time.Second),
+			CreatedBy: createdBy,
+		}
+		err = payment_method.InsertPaymentMethodTx(ctx, paymentTx, pm)
+		if err != nil {
+			return nil, fmt.Errorf("error creating payment method: %v", err)
+		}
+		res.change("payment method created")
+	}
+	res.Method = pm
+
+	// validated before
+	status, _ := payment_method.ParseMethodStatus(b.Status)
+	if pm.Status != status {
+		pm.Status = status
+		pm.StatusCreatedBy = createdBy
+		err = payment_method.InsertPaymentMethodStatusTx(ctx, paymentTx, pm)
+		if err != nil {
+			return nil, fmt.Errorf("error saving payment method status: %v", err)
+		}
+		res.change("status %s", status)
+	}
+
+	err = applyMethodMetadata(ctx, paymentTx, pm, b, routing, createdBy, res)
+	if err != nil {
+		return nil, err
+	}
+	if b.Provider == providerPayPal {
+		err = applyMethodPayPalConfig(ctx, paymentTx, pm, b, createdBy, res)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// routingMetadata returns the payment method metadata of the routing rules
+//
+// Rules which are not set have empty values. A nil routing does not change the
+// routing of the payment method.
+func routingMetadata(ctx context.Context, tx *sql.Tx, projectID int64, r *Routing) (map[string]string, error) {
+	if r == nil {
+		return nil, nil
+	}
+	md := make(map[string]string, len(routingMetadataKeys))
+	codes := make([]string, len(r.Currencies))
+	for i, c := range r.Currencies {
+		cur, err := currency.CurrencyByCodeISO4217Tx(tx, strings.ToUpper(c))
+		if err != nil {
+			if err == currency.ErrCurrencyNotFound {
+				return nil, invalidf("currency %s not supported", c)
+			}
+			return nil, fmt.Errorf("error retrieving currency: %v", err)
+		}
+		codes[i] = cur.CodeISO4217
+	}
+	md[payment_method.MetadataKeyCurrencies] = strings.Join(codes, ",")
+	refs := []struct {
+		key string
+		ref *MethodRef
+	}{
+		{payment_method.MetadataKeyCurrencyFallback, r.CurrencyFallback},
+		{payment_method.MetadataKeyDomesticDebitRoute, r.DomesticDebitRoute},
+	}
+	for _, rf := range refs {
+		md[rf.key] = ""
+		if rf.ref == nil {
+			continue
+		}
+		target, err := payment_method.PaymentMethodByProjectIDProviderNameMethodKeyTx(ctx, tx, projectID, rf.ref.Provider, rf.ref.MethodKey)
+		if err != nil {
+			if err == payment_method.ErrPaymentMethodNotFound {
+				return nil, invalidf("routing to unknown payment method %s", rf.ref)
+			}
+			return nil, fmt.Errorf("error retrieving payment method: %v", err)
+		}
+		md[rf.key] = strconv.FormatInt(target.ID, 10)
+	}
+	return md, nil
+}
+
+func applyMethodMetadata(ctx context.Context, tx *sql.Tx, pm *payment_method.Method, b *MethodBundle, routing map[string]string, createdBy string, res *MethodResult) error {
+	current, err := payment_method.PaymentMethodMetadataTx(ctx, tx, pm)
+	if err != nil {
+		return fmt.Errorf("error retrieving payment method metadata: %v", err)
+	}
+	changed := make(map[string]string)
+	for k, v := range b.Metadata {
+		if current[k] != v {
+			changed[k] = v
+		}
+	}
+	for k, v := range routing {
+		if current[k] != v {
+			changed[k] = v
+		}
+	}
+	pm.Metadata = current
+	if len(changed) == 0 {
+		return nil
+	}
+	update := &payment_method.Method{ID: pm.ID, Metadata: changed}
+	err = payment_method.InsertPaymentMethodMetadataTx(ctx, tx, update, createdBy)
+	if err != nil {
+		return fmt.Errorf("error saving payment method metadata: %v", err)
+	}
+	if pm.Metadata == nil {
+		pm.Metadata = make(map[string]string, len(changed))
+	}
+	for k, v := range changed {
+		pm.Metadata[k] = v
+	}
+	res.change("%d metadata values", len(changed))
+	return nil
+}
+
+// applyMethodPayPalConfig saves the PayPal config and the webhook subscription
+// of the bundle
+//
+// The resulting config is validated by the driver. Active payment methods
+// require a config.
+func applyMethodPayPalConfig(ctx context.Context, tx *sql.Tx, pm *payment_method.Method, b *MethodBundle, createdBy string, res *MethodResult) error {
+	cfg, err := paypal_rest.ConfigByPaymentMethodTx(ctx, tx, pm)
+	if err != nil && err != paypal_rest.ErrConfigNotFound {
+		return fmt.Errorf("error retrieving paypal config: %v", err)
+	}
+	exists := err == nil
+	next := *cfg
+	if b.PayPal != nil {
+		next.Endpoint, next.ClientID, next.Type = b.PayPal.Endpoint, b.PayPal.ClientID, b.PayPal.Type
+		if b.PayPal.Secret != "" {
+			next.Secret = b.PayPal.Secret
+		}
+	}
+	if b.Webhook != nil {
+		next.WebhookID.String, next.WebhookID.Valid = b.Webhook.ID, true
+	}
+	if exists && next == *cfg {
+		return nil
+	}
+	if !exists && b.PayPal == nil {
+		if b.Webhook != nil {
+			return invalidf("webhook requires a PayPal config")
+		}
+		if pm.Active() {
+			return invalidf("active payment method requires a PayPal config")
+		}
+		return nil
+	}
+	err = next.Check()
+	if err != nil {
+		return invalidf("%v", err)
+	}
+	next.ProjectID, next.MethodKey = pm.ProjectID, pm.MethodKey
+	next.Created = time.Now().UTC().Round(time.Second)
+	next.CreatedBy = createdBy
+	// config versions are keyed by the creation time in seconds
+	if exists && !next.Created.After(cfg.Created) {
+		next.Created = cfg.Created.Add(time.Second)
+	}
+	err = paypal_rest.InsertConfigTx(ctx, tx, &next)
+	if err != nil {
+		return fmt.Errorf("error saving paypal config: %v", err)
+	}
+	res.change("paypal config")
+	return nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"encoding/json"
+
+	jsonutil "github.com/fritzpay/paymentd/pkg/json"
+)
+
+// CanonicalSignatureField is the name of the signature member, which is not
+// covered by canonical JSON signatures
+const CanonicalSignatureField = "Signature"
+
+type canonicalSignable struct {
+	Signable
+	payload []byte
+}
+
+// CanonicalSignable returns a signable message whose signature covers the
+// canonical JSON serialization of the given payload instead of the signature
+// base string of the message
+//
+// If payload is nil, the JSON serialization of the message will be used.
+func CanonicalSignable(msg Signable, payload []byte) Signable {
+	return &canonicalSignable{Signable: msg, payload: payload}
+}
+
+func (c *canonicalSignable) Message() ([]byte, error) {
+	return canonicalMessage(c.Signable, c.payload)
+}
+
+type canonicalSigned struct {
+	Signed
+	payload []byte
+}
+
+// CanonicalSigned returns a signed message whose signature will be
+// authenticated against the canonical JSON serialization of the given payload
+//
+// If payload is nil, the JSON serialization of the message will be used.
+func CanonicalSigned(msg Signed, payload []byte) Signed {
+	return &canonicalSigned{Signed: msg, payload: payload}
+}
+
+func (c *canonicalSigned) Message() ([]byte, error) {
+	return canonicalMessage(c.Signed, c.payload)
+}
+
+func canonicalMessage(msg interface{}, payload []byte) ([]byte, error) {
+	if payload == nil {
+		var err error
+		payload, err = json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jsonutil.Canonical(payload, CanonicalSignatureField)
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testCanonicalMsg struct {
+	B            string
+	A            string
+	HexSignature string `json:"Signature,omitempty"`
+}
+
+func (t *testCanonicalMsg) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+func (t *testCanonicalMsg) Message() ([]byte, error) {
+	return []byte(t.B + t.A), nil
+}
+
+func (t *testCanonicalMsg) Signature() ([]byte, error) {
+	return hex.DecodeString(t.HexSignature)
+}
+
+func TestCanonicalSignatures(t *testing.T) {
+	Convey("Given a signable message", t, func() {
+		msg := &testCanonicalMsg{B: "2", A: "1"}
+		key := []byte("secret")
+
+		Convey("When signing the canonical JSON serialization", func() {
+			sig, err := Sign(CanonicalSignable(msg, nil), key)
+			So(err, ShouldBeNil)
+
+			Convey("The signature should cover the canonical JSON", func() {
+				mac := hmac.New(sha256.New, key)
+				mac.Write([]byte(`{"A":"1","B":"2"}`))
+				So(hmac.Equal(sig, mac.Sum(nil)), ShouldBeTrue)
+			})
+
+			Convey("When authenticating the signed message", func() {
+				msg.HexSignature = hex.EncodeToString(sig)
+
+				Convey("It should be authentic with canonical signatures", func() {
+					auth, err := IsAuthentic(CanonicalSigned(msg, nil), key)
+					So(err, ShouldBeNil)
+					So(auth, ShouldBeTrue)
+				})
+				Convey("It should not be authentic with the signature base string", func() {
+					auth, err := IsAuthentic(msg, key)
+					So(err, ShouldBeNil)
+					So(auth, ShouldBeFalse)
+				})
+				Convey("It should be authentic with a received payload in a different order", func() {
+					payload := []byte(`{ "Signature": "` + msg.HexSignature + `", "B": "2", "A": "1" }`)
+					auth, err := IsAuthentic(CanonicalSigned(msg, payload), key)
+					So(err, ShouldBeNil)
+					So(auth, ShouldBeTrue)
+				})
+			})
+		})
+	})
+}
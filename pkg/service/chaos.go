@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/chaos"
+	"github.com/fritzpay/paymentd/pkg/config"
+)
+
+// Chaos holds the fault injectors of the chaos mode
+//
+// Injectors of operations without configured faults are nil. All injectors are
+// nil if the chaos mode is disabled.
+type Chaos struct {
+	// Database injects faults into database statements and commits
+	Database *chaos.Injector
+	// Provider injects faults into provider calls
+	Provider *chaos.Injector
+	// Notification injects faults into notification deliveries
+	Notification *chaos.Injector
+}
+
+func chaosFromConfig(cfg config.Config) (*Chaos, error) {
+	c := &Chaos{}
+	if !cfg.Chaos.Enabled {
+		return c, nil
+	}
+	if !cfg.Payment.TestMode {
+		return nil, errors.New("chaos mode requires test mode")
+	}
+	var err error
+	if c.Database, err = injectorFromConfig(cfg.Chaos.Database); err != nil {
+		return nil, fmt.Errorf("database: %v", err)
+	}
+	if c.Provider, err = injectorFromConfig(cfg.Chaos.Provider); err != nil {
+		return nil, fmt.Errorf("provider: %v", err)
+	}
+	if c.Notification, err = injectorFromConfig(cfg.Chaos.Notification); err != nil {
+		return nil, fmt.Errorf("notification: %v", err)
+	}
+	return c, nil
+}
+
+func injectorFromConfig(f config.Fault) (*chaos.Injector, error) {
+	if f.FailPercentage == 0 && f.DelayPercentage == 0 {
+		return nil, nil
+	}
+	var delay time.Duration
+	if f.Delay != "" {
+		var err error
+		if delay, err = f.Delay.Duration(); err != nil {
+			return nil, err
+		}
+	}
+	return chaos.NewInjector(f.FailPercentage, f.DelayPercentage, delay)
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChaosFromConfig(t *testing.T) {
+	Convey("Given a config with database faults", t, func() {
+		cfg := config.DefaultConfig()
+		cfg.Chaos.Database.FailPercentage = 5
+		cfg.Chaos.Database.DelayPercentage = 10
+		cfg.Chaos.Database.Delay = "200ms"
+
+		Convey("When chaos mode is disabled", func() {
+			c, err := chaosFromConfig(cfg)
+
+			Convey("No faults should be injected", func() {
+				So(err, ShouldBeNil)
+				So(c.Database, ShouldBeNil)
+			})
+		})
+		Convey("When chaos mode is enabled", func() {
+			cfg.Chaos.Enabled = true
+
+			Convey("It should require test mode", func() {
+				_, err := chaosFromConfig(cfg)
+				So(err, ShouldNotBeNil)
+			})
+			Convey("In test mode", func() {
+				cfg.Payment.TestMode = true
+				c, err := chaosFromConfig(cfg)
+
+				Convey("Database faults should be injected", func() {
+					So(err, ShouldBeNil)
+					So(c.Database, ShouldNotBeNil)
+					So(c.Provider, ShouldBeNil)
+					So(c.Notification, ShouldBeNil)
+				})
+			})
+			Convey("With an invalid delay", func() {
+				cfg.Payment.TestMode = true
+				cfg.Chaos.Database.Delay = "soon"
+				_, err := chaosFromConfig(cfg)
+
+				Convey("It should fail", func() {
+					So(err, ShouldNotBeNil)
+				})
+			})
+		})
+	})
+}
@@ -4,14 +4,27 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/fritzpay/paymentd/pkg/cache"
 	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/feature"
+	"github.com/fritzpay/paymentd/pkg/sqltrace"
 	"golang.org/x/net/context"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
+const (
+	// FeatureReloadInterval is the interval after which feature flags will be
+	// reloaded from the database
+	FeatureReloadInterval = time.Minute
+)
+
 const (
 	// ContextVarAuthKey is the name of the key under which the auth container
 	// will be stored in request contexts
@@ -34,7 +47,37 @@ type Context struct {
 	paymentDBWrite    *sql.DB
 	paymentDBReadOnly *sql.DB
 
+	shards *ShardRouter
+
 	rateLimit chan struct{}
+
+	trustedProxies TrustedProxies
+
+	features *feature.Registry
+
+	cache cache.Store
+
+	lockout *Lockout
+
+	slo        *SLO
+	usage      *UsageRecorder
+	bodyLimits *BodyLimits
+	queryStats *sqltrace.Stats
+
+	templates *TemplateRegistry
+	drivers   *DriverRegistry
+	jobs      *JobRunner
+	alerts    *Alerter
+	declines  *DeclineWindows
+	mailer    *Mailer
+	warmer    *CacheWarmer
+	dbMonitor *DBMonitor
+	outages   *OutageQueue
+	chaos     *Chaos
+
+	deadLetters   *DeadLetterQueue
+	writeBatch    *WriteBatcher
+	notifications *NotificationDispatcher
 }
 
 // Value wraps the Context.Value
@@ -67,7 +110,29 @@ func (ctx *Context) WithValue(key, value interface{}) *Context {
 		principalDBReadOnly: ctx.principalDBReadOnly,
 		paymentDBWrite:      ctx.paymentDBWrite,
 		paymentDBReadOnly:   ctx.paymentDBReadOnly,
+		shards:              ctx.shards,
 		rateLimit:           ctx.rateLimit,
+		trustedProxies:      ctx.trustedProxies,
+		features:            ctx.features,
+		cache:               ctx.cache,
+		lockout:             ctx.lockout,
+		slo:                 ctx.slo,
+		usage:               ctx.usage,
+		bodyLimits:          ctx.bodyLimits,
+		queryStats:          ctx.queryStats,
+		templates:           ctx.templates,
+		drivers:             ctx.drivers,
+		jobs:                ctx.jobs,
+		alerts:              ctx.alerts,
+		declines:            ctx.declines,
+		mailer:              ctx.mailer,
+		warmer:              ctx.warmer,
+		dbMonitor:           ctx.dbMonitor,
+		outages:             ctx.outages,
+		chaos:               ctx.chaos,
+		deadLetters:         ctx.deadLetters,
+		writeBatch:          ctx.writeBatch,
+		notifications:       ctx.notifications,
 	}
 }
 
@@ -90,6 +155,164 @@ func (ctx *Context) WebKeychain() *Keychain {
 	return ctx.webKeychain
 }
 
+// TrustedProxies returns the proxies from which forwarded headers are accepted
+func (ctx *Context) TrustedProxies() TrustedProxies {
+	return ctx.trustedProxies
+}
+
+// RequestBaseURL resolves the given (configured) base URL for the given request
+//
+// Deployments behind trusted proxies will receive the forwarded scheme and host.
+func (ctx *Context) RequestBaseURL(r *http.Request, baseURL string) (*url.URL, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return base, nil
+	}
+	return ctx.trustedProxies.BaseURL(r, base), nil
+}
+
+// Cache returns the shared cache
+//
+// The cache is backed by Redis if configured. Otherwise it is an in-memory cache
+// which is not shared with other instances.
+func (ctx *Context) Cache() cache.Store {
+	return ctx.cache
+}
+
+// Lockout returns the tracker for authentication failures
+func (ctx *Context) Lockout() *Lockout {
+	return ctx.lockout
+}
+
+// SLO returns the latency SLO tracker of the API endpoints
+func (ctx *Context) SLO() *SLO {
+	return ctx.slo
+}
+
+// Usage returns the API usage recorder
+func (ctx *Context) Usage() *UsageRecorder {
+	return ctx.usage
+}
+
+// BodyLimits returns the request body limits of the API endpoints
+func (ctx *Context) BodyLimits() *BodyLimits {
+	return ctx.bodyLimits
+}
+
+// QueryStats returns the statistics of the instrumented database connections
+func (ctx *Context) QueryStats() *sqltrace.Stats {
+	return ctx.queryStats
+}
+
+// Chaos returns the fault injectors of the chaos mode
+func (ctx *Context) Chaos() *Chaos {
+	return ctx.chaos
+}
+
+// Templates returns the template registry
+func (ctx *Context) Templates() *TemplateRegistry {
+	return ctx.templates
+}
+
+// Drivers returns the registry of the attached provider drivers
+func (ctx *Context) Drivers() *DriverRegistry {
+	return ctx.drivers
+}
+
+// Jobs returns the background job runner
+func (ctx *Context) Jobs() *JobRunner {
+	return ctx.jobs
+}
+
+// Alerts returns the operational alerter
+func (ctx *Context) Alerts() *Alerter {
+	return ctx.alerts
+}
+
+// Declines returns the decline windows of the decline spike alert
+//
+// It returns nil if the alert is disabled.
+func (ctx *Context) Declines() *DeclineWindows {
+	return ctx.declines
+}
+
+// Mailer returns the mailer for outgoing mails
+func (ctx *Context) Mailer() *Mailer {
+	return ctx.mailer
+}
+
+// CacheWarmer returns the cache warmer which gates the readiness of the
+// instance
+func (ctx *Context) CacheWarmer() *CacheWarmer {
+	return ctx.warmer
+}
+
+// DBMonitor returns the health monitor of the database connections
+func (ctx *Context) DBMonitor() *DBMonitor {
+	return ctx.dbMonitor
+}
+
+// OutageQueue returns the queue of requests received during database outages
+func (ctx *Context) OutageQueue() *OutageQueue {
+	return ctx.outages
+}
+
+// DeadLetters returns the queue of provider webhooks which could not be
+// processed
+func (ctx *Context) DeadLetters() *DeadLetterQueue {
+	return ctx.deadLetters
+}
+
+// Shards returns the router of projects to payment database shards
+func (ctx *Context) Shards() *ShardRouter {
+	return ctx.shards
+}
+
+// WriteBatcher returns the batcher of payment database writes
+func (ctx *Context) WriteBatcher() *WriteBatcher {
+	return ctx.writeBatch
+}
+
+// Notifications returns the dispatcher of callback notifications
+func (ctx *Context) Notifications() *NotificationDispatcher {
+	return ctx.notifications
+}
+
+// Features returns the feature flag registry
+func (ctx *Context) Features() *feature.Registry {
+	return ctx.features
+}
+
+// FeatureEnabled returns true if the named feature is enabled for the given
+// project
+//
+// A project ID of 0 only matches globally enabled features.
+func (ctx *Context) FeatureEnabled(name string, projectID int64) bool {
+	ctx.reloadFeatures()
+	return ctx.features.Enabled(name, projectID)
+}
+
+// FeatureFlags returns the current feature flags
+func (ctx *Context) FeatureFlags() feature.Flags {
+	ctx.reloadFeatures()
+	return ctx.features.Flags()
+}
+
+// reloadFeatures reloads the feature flags from the payment database if they
+// are stale
+func (ctx *Context) reloadFeatures() {
+	if ctx.paymentDBWrite == nil || !ctx.features.Stale(FeatureReloadInterval) {
+		return
+	}
+	err := ctx.features.Load(ctx.PaymentDB(ReadOnly))
+	if err != nil {
+		ctx.log.Error("error loading feature flags", log15.Ctx{"err": err})
+	}
+}
+
 type dbRequestReadOnly bool
 
 // ReadOnly is a possible parameter for the ctx.xDB() methods. If this parameter
@@ -98,6 +321,7 @@ var ReadOnly = dbRequestReadOnly(true)
 
 // PrincipalDB returns the *sql.DB for the principal DB
 // If the parameter(s) contain a service.ReadOnly, the read-only connection will be returned if present
+// and not reconnecting
 func (ctx *Context) PrincipalDB(ros ...dbRequestReadOnly) *sql.DB {
 	var ro bool
 	if len(ros) > 0 {
@@ -110,7 +334,7 @@ func (ctx *Context) PrincipalDB(ros ...dbRequestReadOnly) *sql.DB {
 	if !ro {
 		return ctx.principalDBWrite
 	}
-	if ctx.principalDBReadOnly == nil {
+	if ctx.principalDBReadOnly == nil || ctx.dbMonitor.Down(ctx.principalDBReadOnly) {
 		return ctx.principalDBWrite
 	}
 	return ctx.principalDBReadOnly
@@ -123,10 +347,15 @@ func (ctx *Context) SetPrincipalDB(w, ro *sql.DB) {
 		panic("write DB connection cannot be nil")
 	}
 	ctx.principalDBWrite, ctx.principalDBReadOnly = w, ro
+	if ctx.dbMonitor != nil {
+		ctx.dbMonitor.Monitor("principal/write", w, true)
+		ctx.dbMonitor.Monitor("principal/readonly", ro, false)
+	}
 }
 
 // PaymentDB returns the *sql.DB for the payment DB
 // If the parameter(s) contain a service.ReadOnly, the read-only connection will be returned if present
+// and not reconnecting
 func (ctx *Context) PaymentDB(ros ...dbRequestReadOnly) *sql.DB {
 	var ro bool
 	if len(ros) > 0 {
@@ -139,7 +368,7 @@ func (ctx *Context) PaymentDB(ros ...dbRequestReadOnly) *sql.DB {
 	if !ro {
 		return ctx.paymentDBWrite
 	}
-	if ctx.paymentDBReadOnly == nil {
+	if ctx.paymentDBReadOnly == nil || ctx.dbMonitor.Down(ctx.paymentDBReadOnly) {
 		return ctx.paymentDBWrite
 	}
 	return ctx.paymentDBReadOnly
@@ -152,6 +381,10 @@ func (ctx *Context) SetPaymentDB(w, ro *sql.DB) {
 		panic("write DB connection cannot be nil")
 	}
 	ctx.paymentDBWrite, ctx.paymentDBReadOnly = w, ro
+	if ctx.dbMonitor != nil {
+		ctx.dbMonitor.Monitor("payment/write", w, true)
+		ctx.dbMonitor.Monitor("payment/readonly", ro, false)
+	}
 }
 
 func (ctx *Context) registerKeychain(kc *Keychain, keys []string) error {
@@ -181,8 +414,15 @@ func (ctx *Context) registerKeychainFromConfig() error {
 //
 // The capacity of the ctx.rateLimit buffered channel determines the maximum
 // amount of concurrent requests on this context.
+//
+// Requests will be shed while a database connection is reconnecting.
 func (ctx *Context) RateLimitHandler(parent http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ctx.dbMonitor.Available() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(ctx.dbMonitor.RetryAfter()/time.Second)))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 		<-ctx.rateLimit
 		defer func() {
 			ctx.rateLimit <- struct{}{}
@@ -191,6 +431,34 @@ func (ctx *Context) RateLimitHandler(parent http.Handler) http.Handler {
 	})
 }
 
+// RequestRateLimitHandler wraps the given handler with a limit of requests per
+// client address within the given window
+//
+// The request counters are kept in the shared cache. A limit <= 0 disables the
+// rate limit. Requests will not be limited if the cache is unavailable.
+func (ctx *Context) RequestRateLimitHandler(name string, limit int, window time.Duration, parent http.Handler) http.Handler {
+	if limit <= 0 || window <= 0 {
+		return parent
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		slot := time.Now().UnixNano() / int64(window)
+		key := "ratelimit:" + name + ":" + host + ":" + strconv.FormatInt(slot, 10)
+		n, err := ctx.cache.Incr(key, window)
+		if err != nil {
+			ctx.log.Error("error on rate limit counter", log15.Ctx{"err": err})
+		} else if n > int64(limit) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(window/time.Second)))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		parent.ServeHTTP(w, r)
+	})
+}
+
 // NewContext creates a new service context for use in the service pkg
 func NewContext(ctx context.Context, cfg config.Config, log log15.Logger) (*Context, error) {
 	if log == nil {
@@ -210,6 +478,77 @@ func NewContext(ctx context.Context, cfg config.Config, log log15.Logger) (*Cont
 	if cfg.Database.MaxOpenConns <= 0 {
 		return nil, fmt.Errorf("invalid value for max open db conns %d", cfg.Database.MaxOpenConns)
 	}
+	c.trustedProxies, err = ParseTrustedProxies(cfg.Web.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("error on trusted proxies: %v", err)
+	}
+	c.shards, err = shardRouterFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on shard config: %v", err)
+	}
+	c.features = feature.NewRegistry(featureFlagsFromConfig(cfg.Features))
+	if cfg.Cache.RedisAddress != "" {
+		c.cache = cache.NewRedisStore(cache.RedisConfig{
+			Address:   cfg.Cache.RedisAddress,
+			Password:  cfg.Cache.RedisPassword,
+			Database:  cfg.Cache.RedisDatabase,
+			MaxIdle:   cfg.Cache.RedisMaxIdle,
+			KeyPrefix: cfg.Cache.RedisKeyPrefix,
+		})
+	} else {
+		c.cache = cache.NewMemoryStore()
+	}
+	c.warmer = newCacheWarmer(c)
+	c.lockout, err = lockoutFromConfig(c.cache, log, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on lockout config: %v", err)
+	}
+	c.slo, err = sloFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on SLO config: %v", err)
+	}
+	c.usage, err = usageFromConfig(c, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on usage config: %v", err)
+	}
+	c.bodyLimits = bodyLimitsFromConfig(cfg)
+	c.chaos, err = chaosFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on chaos config: %v", err)
+	}
+	c.queryStats = sqltrace.NewStats()
+	c.templates = NewTemplateRegistry()
+	c.drivers = NewDriverRegistry()
+	c.jobs = newJobRunner(c)
+	c.alerts, err = alerterFromConfig(c, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on alerts config: %v", err)
+	}
+	c.declines, err = declineWindowsFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on alerts config: %v", err)
+	}
+	c.mailer, err = mailerFromConfig(c, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on mail config: %v", err)
+	}
+	c.deadLetters = deadLetterQueueFromConfig(c, cfg)
+	c.dbMonitor, err = dbMonitorFromConfig(c, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on database health check config: %v", err)
+	}
+	c.outages, err = outageQueueFromConfig(c, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on outage queue config: %v", err)
+	}
+	c.writeBatch, err = writeBatcherFromConfig(c, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on write batch config: %v", err)
+	}
+	c.notifications, err = notificationDispatcherFromConfig(c, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error on notification config: %v", err)
+	}
 	c.rateLimit = make(chan struct{}, cfg.Database.MaxOpenConns)
 	for i := 0; i < cfg.Database.MaxOpenConns; i++ {
 		c.rateLimit <- struct{}{}
@@ -217,6 +556,42 @@ func NewContext(ctx context.Context, cfg config.Config, log log15.Logger) (*Cont
 	return c, nil
 }
 
+func lockoutFromConfig(c cache.Store, log log15.Logger, cfg config.Config) (*Lockout, error) {
+	l := NewLockout(c, log)
+	l.MaxFailures = cfg.API.Lockout.MaxFailures
+	if l.MaxFailures <= 0 {
+		return l, nil
+	}
+	var err error
+	if l.Window, err = cfg.API.Lockout.Window.Duration(); err != nil {
+		return nil, err
+	}
+	if l.Duration, err = cfg.API.Lockout.Duration.Duration(); err != nil {
+		return nil, err
+	}
+	if l.Delay, err = cfg.API.Lockout.Delay.Duration(); err != nil {
+		return nil, err
+	}
+	if l.MaxDelay, err = cfg.API.Lockout.MaxDelay.Duration(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func featureFlagsFromConfig(features map[string]config.FeatureFlag) feature.Flags {
+	flags := make(feature.Flags, len(features))
+	for name, f := range features {
+		flags[name] = feature.Flag{
+			Name:       name,
+			Enabled:    f.Enabled,
+			Percentage: f.Percentage,
+			Projects:   f.Projects,
+			CreatedBy:  "config",
+		}
+	}
+	return flags
+}
+
 var (
 	mutex           sync.RWMutex
 	requestContexts = make(map[*http.Request]context.Context)
@@ -3,7 +3,9 @@ package service
 import (
 	"database/sql"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/fritzpay/paymentd/pkg/config"
 	. "github.com/smartystreets/goconvey/convey"
@@ -164,3 +166,30 @@ func TestRequestContextPurging(t *testing.T) {
 		})
 	}))
 }
+
+func TestRequestRateLimit(t *testing.T) {
+	Convey("Given a rate limited handler", t, WithContext(func(ctx *Context) {
+		h := ctx.RequestRateLimitHandler("test", 2, time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		serve := func(addr string) int {
+			r, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			r.RemoteAddr = addr
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			return w.Code
+		}
+
+		Convey("When exceeding the limit", func() {
+			So(serve("10.0.0.1:1234"), ShouldEqual, http.StatusOK)
+			So(serve("10.0.0.1:1235"), ShouldEqual, http.StatusOK)
+			Convey("Requests should be rejected", func() {
+				So(serve("10.0.0.1:1236"), ShouldEqual, http.StatusTooManyRequests)
+			})
+			Convey("Requests of other clients should be served", func() {
+				So(serve("10.0.0.2:1234"), ShouldEqual, http.StatusOK)
+			})
+		})
+	}))
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/service/alert"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	defaultDBCheckTimeout = 2 * time.Second
+	defaultDBRetryAfter   = 5 * time.Second
+)
+
+// Database connection states
+const (
+	DBStateUp = "up"
+	// the connection is down or points to a read-only server while it should
+	// be writable. Idle connections were dropped, new connections will
+	// re-resolve the endpoint
+	DBStateReconnecting = "reconnecting"
+)
+
+const selectReadOnly = `SELECT @@global.read_only`
+
+type monitoredDB struct {
+	name  string
+	db    *sql.DB
+	write bool
+
+	down    bool
+	since   time.Time
+	lastErr error
+}
+
+// DBStatus is the state of a monitored database connection
+type DBStatus struct {
+	Name  string
+	State string
+	Since time.Time
+	Error string `json:",omitempty"`
+}
+
+// DBMonitor checks the health of the database connections
+//
+// Connections failing the health check will drop their idle connections, so
+// that subsequent connections re-resolve DNS-based endpoints. Write
+// connections pointing to a read-only server (i.e. a demoted primary after a
+// replica was promoted) are treated as failed.
+//
+// While a write connection is reconnecting, requests will be shed. While a
+// read-only connection is reconnecting, the write connection will be used
+// instead.
+type DBMonitor struct {
+	ctx *Context
+	log log15.Logger
+
+	interval   time.Duration
+	timeout    time.Duration
+	retryAfter time.Duration
+	maxIdle    int
+
+	m   sync.RWMutex
+	dbs []*monitoredDB
+}
+
+func dbMonitorFromConfig(ctx *Context, cfg config.Config) (*DBMonitor, error) {
+	m := &DBMonitor{
+		ctx: ctx,
+		log: ctx.Log().New(log15.Ctx{
+			"pkg":  "github.com/fritzpay/paymentd/pkg/service",
+			"type": "DBMonitor",
+		}),
+		timeout:    defaultDBCheckTimeout,
+		retryAfter: defaultDBRetryAfter,
+		maxIdle:    cfg.Database.MaxIdleConns,
+	}
+	hc := cfg.Database.HealthCheck
+	var err error
+	if hc.Interval != "" {
+		if m.interval, err = hc.Interval.Duration(); err != nil {
+			return nil, fmt.Errorf("invalid health check interval: %v", err)
+		}
+	}
+	if hc.Timeout != "" {
+		if m.timeout, err = hc.Timeout.Duration(); err != nil {
+			return nil, fmt.Errorf("invalid health check timeout: %v", err)
+		}
+	}
+	if hc.RetryAfter != "" {
+		if m.retryAfter, err = hc.RetryAfter.Duration(); err != nil {
+			return nil, fmt.Errorf("invalid retry after: %v", err)
+		}
+	}
+	return m, nil
+}
+
+// Monitor adds a database connection to the monitored connections
+//
+// A connection added under an existing name replaces the previous one.
+func (m *DBMonitor) Monitor(name string, db *sql.DB, write bool) {
+	if db == nil {
+		return
+	}
+	m.m.Lock()
+	defer m.m.Unlock()
+	mdb := &monitoredDB{name: name, db: db, write: write}
+	for i := range m.dbs {
+		if m.dbs[i].name == name {
+			m.dbs[i] = mdb
+			return
+		}
+	}
+	m.dbs = append(m.dbs, mdb)
+}
+
+// Start checks the connections in the configured interval until the context
+// is done
+//
+// If no interval is configured, the connections will not be monitored.
+func (m *DBMonitor) Start() {
+	if m.interval <= 0 {
+		return
+	}
+	go func() {
+		tick := time.NewTicker(m.interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-tick.C:
+				m.Check()
+			}
+		}
+	}()
+}
+
+// Check checks all monitored connections
+//
+// Requests queued during an outage will be replayed while the write
+// connections are available.
+func (m *DBMonitor) Check() {
+	m.m.RLock()
+	dbs := make([]*monitoredDB, len(m.dbs))
+	copy(dbs, m.dbs)
+	m.m.RUnlock()
+	for _, mdb := range dbs {
+		m.observe(mdb, m.check(mdb))
+	}
+	if m.Available() && m.ctx.OutageQueue().Len() > 0 {
+		m.ctx.OutageQueue().Replay()
+	}
+}
+
+func (m *DBMonitor) check(mdb *monitoredDB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	if !mdb.write {
+		return mdb.db.PingContext(ctx)
+	}
+	var readOnly bool
+	err := mdb.db.QueryRowContext(ctx, selectReadOnly).Scan(&readOnly)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		return fmt.Errorf("write connection points to a read-only server")
+	}
+	return nil
+}
+
+// observe records the outcome of a check
+func (m *DBMonitor) observe(mdb *monitoredDB, err error) {
+	log := m.log.New(log15.Ctx{"db": mdb.name})
+	m.m.Lock()
+	wasDown := mdb.down
+	mdb.lastErr = err
+	if (err != nil) != wasDown {
+		mdb.down = err != nil
+		mdb.since = time.Now()
+	}
+	m.m.Unlock()
+
+	switch {
+	case err != nil && !wasDown:
+		log.Crit("database connection failed. reconnecting...", log15.Ctx{"err": err})
+		// dropping the idle connections forces new connections, which will
+		// resolve the endpoint again
+		mdb.db.SetMaxIdleConns(0)
+		a := &alert.Alert{
+			Kind:     alert.KindDatabaseDown,
+			Severity: alert.SeverityCritical,
+			Title:    "Database connection " + mdb.name + " failed",
+			Text:     err.Error(),
+		}
+		a.AddField("Connection", mdb.name)
+		m.ctx.Alerts().Alert(a)
+	case err != nil:
+		log.Warn("database connection still failing", log15.Ctx{"err": err})
+	case wasDown:
+		log.Info("database connection reestablished")
+		mdb.db.SetMaxIdleConns(m.maxIdle)
+	}
+}
+
+// Down returns true if the given connection is monitored and reconnecting
+func (m *DBMonitor) Down(db *sql.DB) bool {
+	if m == nil || db == nil {
+		return false
+	}
+	m.m.RLock()
+	defer m.m.RUnlock()
+	for _, mdb := range m.dbs {
+		if mdb.db == db {
+			return mdb.down
+		}
+	}
+	return false
+}
+
+// Available returns false if any write connection is reconnecting
+func (m *DBMonitor) Available() bool {
+	if m == nil {
+		return true
+	}
+	m.m.RLock()
+	defer m.m.RUnlock()
+	for _, mdb := range m.dbs {
+		if mdb.write && mdb.down {
+			return false
+		}
+	}
+	return true
+}
+
+// RetryAfter returns the duration after which shed requests should be retried
+func (m *DBMonitor) RetryAfter() time.Duration {
+	return m.retryAfter
+}
+
+// Status returns the states of the monitored connections ordered by name
+func (m *DBMonitor) Status() []DBStatus {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	st := make([]DBStatus, len(m.dbs))
+	for i, mdb := range m.dbs {
+		st[i] = DBStatus{
+			Name:  mdb.name,
+			State: DBStateUp,
+			Since: mdb.since,
+		}
+		if mdb.down {
+			st[i].State = DBStateReconnecting
+		}
+		if mdb.lastErr != nil {
+			st[i].Error = mdb.lastErr.Error()
+		}
+	}
+	sort.Sort(dbStatusByName(st))
+	return st
+}
+
+type dbStatusByName []DBStatus
+
+func (s dbStatusByName) Len() int           { return len(s) }
+func (s dbStatusByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s dbStatusByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
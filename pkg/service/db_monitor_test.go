@@ -0,0 +1,83 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDBMonitor(t *testing.T) {
+	Convey("Given a context with monitored database connections", t, WithContext(func(ctx *Context) {
+		// sql.Open does not connect
+		write, err := sql.Open("mysql", "paymentd@tcp(localhost:3306)/fritzpay_payment")
+		So(err, ShouldBeNil)
+		readOnly, err := sql.Open("mysql", "paymentd@tcp(localhost:3307)/fritzpay_payment")
+		So(err, ShouldBeNil)
+		ctx.SetPaymentDB(write, readOnly)
+		m := ctx.DBMonitor()
+
+		Convey("The connections should be available", func() {
+			So(m.Available(), ShouldBeTrue)
+			So(ctx.PaymentDB(ReadOnly), ShouldEqual, readOnly)
+			st := m.Status()
+			So(len(st), ShouldEqual, 2)
+			So(st[0].Name, ShouldEqual, "payment/readonly")
+			So(st[0].State, ShouldEqual, DBStateUp)
+		})
+
+		Convey("When the read-only connection fails", func() {
+			m.observe(m.dbs[1], errors.New("connection refused"))
+
+			Convey("The write connection should be used for reads", func() {
+				So(m.Down(readOnly), ShouldBeTrue)
+				So(ctx.PaymentDB(ReadOnly), ShouldEqual, write)
+			})
+			Convey("Requests should not be shed", func() {
+				So(m.Available(), ShouldBeTrue)
+			})
+
+			Convey("When the connection recovers", func() {
+				m.observe(m.dbs[1], nil)
+
+				Convey("The read-only connection should be used again", func() {
+					So(m.Down(readOnly), ShouldBeFalse)
+					So(ctx.PaymentDB(ReadOnly), ShouldEqual, readOnly)
+				})
+			})
+		})
+
+		Convey("When the write connection fails", func() {
+			m.observe(m.dbs[0], errors.New("write connection points to a read-only server"))
+
+			Convey("It should report the connection as reconnecting", func() {
+				So(m.Available(), ShouldBeFalse)
+				st := m.Status()
+				So(st[1].Name, ShouldEqual, "payment/write")
+				So(st[1].State, ShouldEqual, DBStateReconnecting)
+				So(st[1].Error, ShouldEqual, "write connection points to a read-only server")
+			})
+
+			Convey("When requesting a rate limited handler", func() {
+				var served bool
+				h := ctx.RateLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					served = true
+				}))
+				w := httptest.NewRecorder()
+				r, err := http.NewRequest("GET", "/", nil)
+				So(err, ShouldBeNil)
+				h.ServeHTTP(w, r)
+
+				Convey("The request should be shed", func() {
+					So(served, ShouldBeFalse)
+					So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+					So(w.Header().Get("Retry-After"), ShouldEqual, "5")
+				})
+			})
+		})
+	}))
+}
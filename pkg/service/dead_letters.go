@@ -0,0 +1,280 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/webhook"
+	"github.com/fritzpay/paymentd/pkg/service/alert"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// deadLetterReasonMaxLen is the maximum length of the reasons of dead letters
+const deadLetterReasonMaxLen = 255
+
+var (
+	// ErrDeadLetterNotPending is returned when re-processing a dead letter
+	// which was already processed or discarded
+	ErrDeadLetterNotPending = errors.New("dead letter not pending")
+	// ErrDeadLetterNoHandler is returned when re-processing a dead letter of a
+	// handler which is not registered
+	ErrDeadLetterNoHandler = errors.New("no handler registered for dead letter")
+	// ErrDeadLetterRejected is returned if a re-processed dead letter was
+	// rejected again
+	ErrDeadLetterRejected = errors.New("dead letter rejected")
+	// ErrDeadLetterFailed is returned if re-processing a dead letter failed
+	// with a server error
+	ErrDeadLetterFailed = errors.New("error re-processing dead letter")
+)
+
+type deadLetterHandler struct {
+	provider string
+	parent   http.Handler
+}
+
+// deadLetterRequest is a request served by a dead letter handler
+type deadLetterRequest struct {
+	handler  string
+	provider string
+	body     []byte
+	// reprocessed is the dead letter which is re-processed with the request
+	reprocessed *webhook.DeadLetter
+	// reason is set if the request was rejected
+	reason string
+}
+
+// DeadLetterQueue keeps provider webhooks which could not be processed
+//
+// Webhook handlers wrapped with the DeadLetterHandler reject webhooks which
+// fail validation or reference unknown payments with Reject. The rejected
+// webhooks are saved with the raw request, so they can be re-processed with
+// the same handler once the data was fixed.
+type DeadLetterQueue struct {
+	ctx *Context
+	log log15.Logger
+
+	maxPending int
+
+	m        sync.Mutex
+	handlers map[string]deadLetterHandler
+	requests map[*http.Request]*deadLetterRequest
+}
+
+func deadLetterQueueFromConfig(ctx *Context, cfg config.Config) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		ctx: ctx,
+		log: ctx.Log().New(log15.Ctx{
+			"pkg":  "github.com/fritzpay/paymentd/pkg/service",
+			"type": "DeadLetterQueue",
+		}),
+		maxPending: cfg.Alerts.DeadLetters.MaxPending,
+		handlers:   make(map[string]deadLetterHandler),
+		requests:   make(map[*http.Request]*deadLetterRequest),
+	}
+}
+
+// DeadLetterHandler wraps the given provider webhook handler, so it can reject
+// webhooks to the dead-letter queue
+//
+// The request body will be buffered. The name identifies the handler when
+// re-processing. It must be unique and should not change between releases.
+func (ctx *Context) DeadLetterHandler(name, provider string, parent http.Handler) http.Handler {
+	q := ctx.deadLetters
+	if q == nil {
+		return parent
+	}
+	q.m.Lock()
+	q.handlers[name] = deadLetterHandler{provider: provider, parent: parent}
+	q.m.Unlock()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dr := &deadLetterRequest{
+			handler:  name,
+			provider: provider,
+		}
+		if r.Body != nil {
+			var err error
+			dr.body, err = ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				q.log.Error("error reading webhook", log15.Ctx{"handler": name, "err": err})
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(dr.body))
+		}
+		q.track(r, dr)
+		defer q.untrack(r)
+		parent.ServeHTTP(w, r)
+	})
+}
+
+func (q *DeadLetterQueue) track(r *http.Request, dr *deadLetterRequest) {
+	q.m.Lock()
+	q.requests[r] = dr
+	q.m.Unlock()
+}
+
+func (q *DeadLetterQueue) untrack(r *http.Request) {
+	q.m.Lock()
+	delete(q.requests, r)
+	q.m.Unlock()
+}
+
+// Reject saves the webhook request as a dead letter with the given reason
+//
+// Handlers should reject webhooks which fail validation or reference unknown
+// payments and answer them like processed webhooks, so the provider does not
+// resend them. The request must be served by a DeadLetterHandler.
+func (q *DeadLetterQueue) Reject(r *http.Request, reason string) {
+	if q == nil {
+		return
+	}
+	q.m.Lock()
+	dr := q.requests[r]
+	q.m.Unlock()
+	if dr == nil {
+		q.log.Warn("cannot reject webhook without dead letter handler", log15.Ctx{"reason": reason})
+		return
+	}
+	if len(reason) > deadLetterReasonMaxLen {
+		reason = reason[:deadLetterReasonMaxLen]
+	}
+	dr.reason = reason
+	// the dead letter will be updated by the re-processing
+	if dr.reprocessed != nil {
+		return
+	}
+	log := q.log.New(log15.Ctx{
+		"handler":  dr.handler,
+		"provider": dr.provider,
+		"reason":   reason,
+	})
+	d := webhook.NewDeadLetter(dr.provider, dr.handler, reason, r, dr.body)
+	err := q.save(func(tx *sql.Tx) error {
+		return webhook.InsertDeadLetterTx(tx, d)
+	})
+	if err != nil {
+		log.Crit("error saving dead letter", log15.Ctx{"err": err})
+		return
+	}
+	log.Warn("rejected webhook to dead-letter queue", log15.Ctx{"deadLetterID": d.ID})
+	q.checkPending()
+}
+
+func (q *DeadLetterQueue) save(f func(tx *sql.Tx) error) error {
+	tx, err := q.ctx.PaymentDB().Begin()
+	if err != nil {
+		return err
+	}
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkPending alerts if the number of pending dead letters reached the
+// configured maximum
+func (q *DeadLetterQueue) checkPending() {
+	if q.maxPending <= 0 {
+		return
+	}
+	n, err := webhook.DeadLetterCountByStatusDB(q.ctx.PaymentDB(ReadOnly), webhook.StatusPending)
+	if err != nil {
+		q.log.Error("error counting pending dead letters", log15.Ctx{"err": err})
+		return
+	}
+	if n < q.maxPending {
+		return
+	}
+	a := &alert.Alert{
+		Kind:     alert.KindDeadLetterGrowth,
+		Severity: alert.SeverityWarning,
+		Title:    "Provider webhooks are piling up in the dead-letter queue",
+		Text:     fmt.Sprintf("%d provider webhooks could not be processed and are waiting to be re-processed.", n),
+	}
+	a.AddField("Pending", strconv.Itoa(n))
+	q.ctx.Alerts().Alert(a)
+}
+
+// Reprocess re-processes the pending dead letter with the given ID with the
+// handler which rejected it
+//
+// The dead letter will be processed if the handler accepts it. If it is
+// rejected again, the dead letter remains pending with the new reason and
+// ErrDeadLetterRejected is returned.
+func (q *DeadLetterQueue) Reprocess(id int64, createdBy string) (*webhook.DeadLetter, error) {
+	log := q.log.New(log15.Ctx{
+		"method":       "Reprocess",
+		"deadLetterID": id,
+	})
+	tx, err := q.ctx.PaymentDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	d, err := webhook.DeadLetterByIDTx(tx, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if !d.Pending() {
+		tx.Rollback()
+		return d, ErrDeadLetterNotPending
+	}
+	q.m.Lock()
+	h, ok := q.handlers[d.Handler]
+	q.m.Unlock()
+	if !ok {
+		tx.Rollback()
+		return d, ErrDeadLetterNoHandler
+	}
+	r, err := d.Request()
+	if err != nil {
+		tx.Rollback()
+		return d, err
+	}
+	dr := &deadLetterRequest{
+		handler:     d.Handler,
+		provider:    h.provider,
+		body:        d.Body,
+		reprocessed: d,
+	}
+	q.track(r, dr)
+	status := serveReplay(h.parent, r, log)
+	q.untrack(r)
+	if status >= 500 {
+		tx.Rollback()
+		log.Error("re-processed dead letter failed", log15.Ctx{"status": status})
+		return d, ErrDeadLetterFailed
+	}
+	var st *webhook.Status
+	if dr.reason != "" {
+		st = d.NewStatus(webhook.StatusPending, createdBy)
+		st.Comment.String, st.Comment.Valid = dr.reason, true
+	} else {
+		st = d.NewStatus(webhook.StatusProcessed, createdBy)
+	}
+	err = webhook.InsertStatusTx(tx, d, st)
+	if err != nil {
+		tx.Rollback()
+		return d, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return d, err
+	}
+	if dr.reason != "" {
+		log.Info("re-processed dead letter rejected", log15.Ctx{"reason": dr.reason})
+		return d, ErrDeadLetterRejected
+	}
+	log.Info("re-processed dead letter", log15.Ctx{"status": status})
+	return d, nil
+}
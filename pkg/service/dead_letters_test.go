@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/webhook"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestDeadLetterHandler(t *testing.T) {
+	Convey("Given a context with a dead-letter queue", t, func() {
+		log := log15.New()
+		log.SetHandler(log15.DiscardHandler())
+		ctx, err := NewContext(context.Background(), config.DefaultConfig(), log)
+		So(err, ShouldBeNil)
+		q := ctx.DeadLetters()
+
+		var body string
+		var tracked *deadLetterRequest
+		h := ctx.DeadLetterHandler("test/callback", "test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			body = string(b)
+			q.m.Lock()
+			tracked = q.requests[r]
+			q.m.Unlock()
+			if tracked.reprocessed != nil {
+				q.Reject(r, "still unknown")
+			}
+		}))
+
+		Convey("When a webhook is served", func() {
+			r, err := http.NewRequest("POST", "/callback", strings.NewReader("payload"))
+			So(err, ShouldBeNil)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			Convey("The handler should read the buffered body", func() {
+				So(body, ShouldEqual, "payload")
+			})
+			Convey("The request should be tracked while it is served", func() {
+				So(tracked, ShouldNotBeNil)
+				So(tracked.handler, ShouldEqual, "test/callback")
+				So(string(tracked.body), ShouldEqual, "payload")
+				So(len(q.requests), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a dead letter is rejected again while re-processing", func() {
+			d := &webhook.DeadLetter{Method: "GET", URL: "/callback"}
+			r, err := d.Request()
+			So(err, ShouldBeNil)
+			dr := &deadLetterRequest{handler: "test/callback", reprocessed: d}
+			q.track(r, dr)
+			q.handlers["test/callback"].parent.ServeHTTP(httptest.NewRecorder(), r)
+			q.untrack(r)
+
+			Convey("The reason should be recorded for the re-processing", func() {
+				So(dr.reason, ShouldEqual, "still unknown")
+			})
+		})
+
+		Convey("When rejecting a request without dead letter handler", func() {
+			r, err := http.NewRequest("GET", "/other", nil)
+			So(err, ShouldBeNil)
+
+			Convey("It should be ignored", func() {
+				So(func() { q.Reject(r, "invalid") }, ShouldNotPanic)
+			})
+		})
+	})
+}
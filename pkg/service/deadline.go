@@ -0,0 +1,78 @@
+package service
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TimeoutHeader is the header in which clients announce how long they will wait
+// for the response, in milliseconds
+//
+// It can only shorten the configured timeout of a service.
+const TimeoutHeader = "X-Paymentd-Timeout"
+
+// RequestDeadline returns the deadline of a request received at the given time
+//
+// The deadline is the earlier of the timeout of the service and the timeout
+// announced by the client. It returns false if neither is set.
+func RequestDeadline(r *http.Request, timeout time.Duration, received time.Time) (time.Time, bool) {
+	if ms, err := strconv.ParseInt(r.Header.Get(TimeoutHeader), 10, 64); err == nil && ms > 0 {
+		clientTimeout := time.Duration(ms) * time.Millisecond
+		if timeout <= 0 || clientTimeout < timeout {
+			timeout = clientTimeout
+		}
+	}
+	if timeout <= 0 {
+		return time.Time{}, false
+	}
+	return received.Add(timeout), true
+}
+
+// SetRequestDeadline derives the request context with the given deadline
+//
+// The request context will also be cancelled when the client closes the
+// connection. The returned cancel func must be called when the request was
+// served. SetRequestContext has to be called before.
+func SetRequestDeadline(r *http.Request, deadline time.Time) context.CancelFunc {
+	mutex.Lock()
+	ctx := requestContexts[r]
+	if ctx == nil {
+		mutex.Unlock()
+		return func() {}
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	requestContexts[r] = ctx
+	mutex.Unlock()
+
+	go func() {
+		select {
+		case <-r.Context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return cancel
+}
+
+// BeginRequestTx starts a transaction bound to the request context
+//
+// The transaction will be rolled back and its connection released once the
+// request deadline passed or the client went away.
+func BeginRequestTx(r *http.Request, db *sql.DB) (*sql.Tx, error) {
+	ctx := RequestContext(r)
+	if ctx == nil {
+		return db.Begin()
+	}
+	return db.BeginTx(ctx, nil)
+}
+
+// RequestDone returns true if the request deadline passed or the client went
+// away
+func RequestDone(r *http.Request) bool {
+	ctx := RequestContext(r)
+	return ctx != nil && ctx.Err() != nil
+}
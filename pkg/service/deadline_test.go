@@ -0,0 +1,102 @@
+package service
+
+import (
+	stdcontext "context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	Convey("Given a request", t, func() {
+		r, err := http.NewRequest("POST", "www.example.com", nil)
+		So(err, ShouldBeNil)
+		received := time.Unix(1500000000, 0)
+
+		Convey("Without a timeout", func() {
+			_, ok := RequestDeadline(r, 0, received)
+			Convey("It should have no deadline", func() {
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("With a service timeout", func() {
+			deadline, ok := RequestDeadline(r, 5*time.Second, received)
+			Convey("It should end after the timeout", func() {
+				So(ok, ShouldBeTrue)
+				So(deadline, ShouldResemble, received.Add(5*time.Second))
+			})
+		})
+
+		Convey("With a shorter client timeout", func() {
+			r.Header.Set(TimeoutHeader, "1500")
+			deadline, ok := RequestDeadline(r, 5*time.Second, received)
+			Convey("It should end after the client timeout", func() {
+				So(ok, ShouldBeTrue)
+				So(deadline, ShouldResemble, received.Add(1500*time.Millisecond))
+			})
+		})
+
+		Convey("With a longer client timeout", func() {
+			r.Header.Set(TimeoutHeader, "60000")
+			deadline, ok := RequestDeadline(r, 5*time.Second, received)
+			Convey("It should end after the service timeout", func() {
+				So(ok, ShouldBeTrue)
+				So(deadline, ShouldResemble, received.Add(5*time.Second))
+			})
+		})
+
+		Convey("With an invalid client timeout", func() {
+			r.Header.Set(TimeoutHeader, "-1")
+			_, ok := RequestDeadline(r, 0, received)
+			Convey("It should be ignored", func() {
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestSetRequestDeadline(t *testing.T) {
+	Convey("Given a registered request", t, WithContext(func(ctx *Context) {
+		clientCtx, clientCancel := stdcontext.WithCancel(stdcontext.Background())
+		defer clientCancel()
+		r, err := http.NewRequest("POST", "www.example.com", nil)
+		So(err, ShouldBeNil)
+		r = r.WithContext(clientCtx)
+		SetRequestContext(r, ctx)
+		defer ClearRequestContext(r)
+
+		Convey("When setting a deadline", func() {
+			cancel := SetRequestDeadline(r, time.Now().Add(time.Hour))
+			defer cancel()
+
+			Convey("The request context should have the deadline", func() {
+				_, ok := RequestContext(r).Deadline()
+				So(ok, ShouldBeTrue)
+				So(RequestDone(r), ShouldBeFalse)
+			})
+
+			Convey("When the client goes away", func() {
+				clientCancel()
+				select {
+				case <-RequestContext(r).Done():
+				case <-time.After(time.Second):
+				}
+				Convey("The request should be done", func() {
+					So(RequestDone(r), ShouldBeTrue)
+				})
+			})
+		})
+
+		Convey("When the deadline passed", func() {
+			cancel := SetRequestDeadline(r, time.Now().Add(-time.Second))
+			defer cancel()
+
+			Convey("The request should be done", func() {
+				So(RequestDone(r), ShouldBeTrue)
+			})
+		})
+	}))
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+)
+
+// number of buckets a decline window is divided into
+const declineBuckets = 10
+
+type declineBucket struct {
+	n        int64
+	declined int
+	total    int
+}
+
+// declineWindow counts the declined and the total payments of a project within
+// a sliding window
+type declineWindow [declineBuckets]declineBucket
+
+func (w *declineWindow) add(n int64, declined bool) {
+	b := &w[n%declineBuckets]
+	if b.n != n {
+		*b = declineBucket{n: n}
+	}
+	b.total++
+	if declined {
+		b.declined++
+	}
+}
+
+func (w *declineWindow) counts(n int64) (declined, total int) {
+	for _, b := range w {
+		if b.n > n-declineBuckets && b.n <= n {
+			declined += b.declined
+			total += b.total
+		}
+	}
+	return
+}
+
+// DeclineWindows detects spikes of declined payments per project
+//
+// The windows are kept with the context, so that the payments of a project are
+// counted together regardless of the payment service which processed them.
+type DeclineWindows struct {
+	bucket      time.Duration
+	minDeclines int
+	percentage  int
+
+	m       sync.Mutex
+	windows map[int64]*declineWindow
+}
+
+// declineWindowsFromConfig returns nil if the decline spike alert is disabled
+func declineWindowsFromConfig(cfg config.Config) (*DeclineWindows, error) {
+	c := cfg.Alerts.DeclineSpike
+	if c.MinDeclines <= 0 {
+		return nil, nil
+	}
+	window, err := c.Window.Duration()
+	if err != nil {
+		return nil, fmt.Errorf("invalid decline spike window: %v", err)
+	}
+	if window < declineBuckets*time.Second {
+		return nil, fmt.Errorf("decline spike window %s too short", window)
+	}
+	if c.Percentage <= 0 || c.Percentage > 100 {
+		return nil, fmt.Errorf("invalid decline spike percentage %d", c.Percentage)
+	}
+	return &DeclineWindows{
+		bucket:      window / declineBuckets,
+		minDeclines: c.MinDeclines,
+		percentage:  c.Percentage,
+		windows:     make(map[int64]*declineWindow),
+	}, nil
+}
+
+// Window returns the duration of the sliding window
+func (d *DeclineWindows) Window() time.Duration {
+	return d.bucket * declineBuckets
+}
+
+// Observe counts the payment of the project
+//
+// It returns the number of declined and of all payments of the project within
+// the window and whether the declines exceed the threshold.
+func (d *DeclineWindows) Observe(projectID int64, declined bool, t time.Time) (int, int, bool) {
+	n := t.UnixNano() / int64(d.bucket)
+	d.m.Lock()
+	defer d.m.Unlock()
+	w, ok := d.windows[projectID]
+	if !ok {
+		w = &declineWindow{}
+		d.windows[projectID] = w
+	}
+	w.add(n, declined)
+	if !declined {
+		return 0, 0, false
+	}
+	dec, total := w.counts(n)
+	return dec, total, dec >= d.minDeclines && dec*100 >= d.percentage*total
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeclineWindows(t *testing.T) {
+	Convey("Given decline windows", t, func() {
+		d := &DeclineWindows{
+			bucket:      time.Minute,
+			minDeclines: 3,
+			percentage:  50,
+			windows:     make(map[int64]*declineWindow),
+		}
+		now := time.Date(2015, time.March, 14, 10, 0, 0, 0, time.UTC)
+
+		Convey("When few payments are declined", func() {
+			for i := 0; i < 6; i++ {
+				d.Observe(1, false, now)
+			}
+			d.Observe(1, true, now)
+			d.Observe(1, true, now)
+			_, _, spike := d.Observe(1, true, now)
+
+			Convey("It should not report a spike", func() {
+				So(spike, ShouldBeFalse)
+			})
+		})
+
+		Convey("When most payments are declined", func() {
+			d.Observe(1, false, now)
+			d.Observe(1, true, now)
+			d.Observe(1, true, now.Add(time.Minute))
+			declined, total, spike := d.Observe(1, true, now.Add(2*time.Minute))
+
+			Convey("It should report a spike", func() {
+				So(spike, ShouldBeTrue)
+				So(declined, ShouldEqual, 3)
+				So(total, ShouldEqual, 4)
+			})
+			Convey("Other projects should not be affected", func() {
+				_, _, spike := d.Observe(2, true, now)
+				So(spike, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the declines are spread beyond the window", func() {
+			d.Observe(1, true, now)
+			d.Observe(1, true, now.Add(5*time.Minute))
+			_, _, spike := d.Observe(1, true, now.Add(declineBuckets*time.Minute))
+
+			Convey("It should not report a spike", func() {
+				So(spike, ShouldBeFalse)
+			})
+		})
+	})
+}
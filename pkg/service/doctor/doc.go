@@ -0,0 +1,31 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package doctor finds and repairs inconsistent payments
+
+The doctor examines the payments of a project for inconsistencies, which can
+be left behind by failed provider callbacks, crashes or lost notifications:
+
+  - open payments which the provider reported as paid, authorized or failed
+  - payment transactions for which the project was never notified
+  - authorized payments, whose authorization with the provider expired
+
+Repairs are performed by applying the proper intents to the payments or by
+notifying the project again, so that the payment history and the notifications
+of the project stay consistent.
+*/
+package doctor
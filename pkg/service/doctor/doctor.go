@@ -0,0 +1,344 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/outbox"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Checks performed by the doctor
+const (
+	// CheckProviderStatus finds open payments, which the provider reported as
+	// paid, authorized or failed
+	CheckProviderStatus = "provider_status"
+	// CheckMissingNotification finds payment transactions, for which the
+	// project was not notified
+	CheckMissingNotification = "missing_notification"
+	// CheckAuthorizationExpired finds authorized payments, whose authorization
+	// with the provider expired
+	CheckAuthorizationExpired = "authorization_expired"
+)
+
+// RepairNotify notifies the project of the payment transaction again
+//
+// The other repair actions are the batch intents of the payment service.
+const RepairNotify = "notify"
+
+const (
+	// examineLimit is the maximum number of payments or transactions examined
+	// per check
+	examineLimit = 1000
+	// notificationGrace is the time after which the notification of a
+	// payment transaction is expected to be in the outbox
+	notificationGrace = time.Minute
+)
+
+var (
+	// ErrCheck is returned when repairing an unknown check
+	ErrCheck = errors.New("unknown check")
+	// ErrResolved is returned when repairing an inconsistency, which is not
+	// found (anymore)
+	ErrResolved = errors.New("inconsistency not found")
+	// ErrNoRepair is returned when repairing an inconsistency, which cannot be
+	// repaired by the doctor
+	ErrNoRepair = errors.New("no repair for inconsistency")
+)
+
+// Finding is an inconsistency found by the doctor
+type Finding struct {
+	Check     string
+	PaymentID payment.PaymentID
+	// Status is the current status of the payment
+	Status payment.PaymentTransactionStatus
+	// TransactionTimestamp identifies the payment transaction of missing
+	// notifications
+	TransactionTimestamp time.Time
+	Detail               string
+	// Repair is the repair action for the inconsistency, empty if it cannot be
+	// repaired by the doctor
+	Repair string
+}
+
+// Doctor finds and repairs inconsistent payments
+type Doctor struct {
+	ctx *service.Context
+	log log15.Logger
+
+	payments  *paymentService.Service
+	providers *provider.Service
+}
+
+// NewDoctor creates a new doctor
+func NewDoctor(ctx *service.Context) (*Doctor, error) {
+	d := &Doctor{
+		ctx: ctx,
+		log: ctx.Log().New(log15.Ctx{
+			"pkg": "github.com/fritzpay/paymentd/pkg/service/doctor",
+		}),
+	}
+	var err error
+	d.payments, err = paymentService.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.providers, err = provider.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Examine runs all checks on the payments of the project
+//
+// Missing notifications are only searched for payment transactions since the
+// given time.
+func (d *Doctor) Examine(projectID int64, since time.Time) ([]*Finding, error) {
+	findings := make([]*Finding, 0)
+	f, err := d.examineProviderStatus(projectID)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, f...)
+	f, err = d.examineAuthorizations(projectID)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, f...)
+	f, err = d.examineNotifications(projectID, since)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, f...)
+	return findings, nil
+}
+
+func (d *Doctor) examineProviderStatus(projectID int64) ([]*Finding, error) {
+	return d.examinePayments(projectID, payment.PaymentStatusOpen, d.providerStatusFinding)
+}
+
+func (d *Doctor) examineAuthorizations(projectID int64) ([]*Finding, error) {
+	return d.examinePayments(projectID, payment.PaymentStatusAuthorized, d.authorizationFinding)
+}
+
+func (d *Doctor) examinePayments(projectID int64, status payment.PaymentTransactionStatus, check func(*payment.Payment) (*Finding, error)) ([]*Finding, error) {
+	ids, err := payment.PaymentIDsByProjectIDAndStatusDB(d.ctx, d.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, status, examineLimit)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving %s payments: %v", status, err)
+	}
+	findings := make([]*Finding, 0)
+	for _, id := range ids {
+		p, err := payment.PaymentByIDDB(d.ctx, d.ctx.ProjectPaymentDB(projectID, service.ReadOnly), id)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				continue
+			}
+			return nil, fmt.Errorf("error retrieving payment: %v", err)
+		}
+		f, err := check(p)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+func (d *Doctor) examineNotifications(projectID int64, since time.Time) ([]*Finding, error) {
+	until := time.Now().Add(-notificationGrace)
+	txs, err := outbox.TransactionsWithoutNotificationDB(d.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, since, until, examineLimit)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving payment transactions: %v", err)
+	}
+	findings := make([]*Finding, 0)
+	for _, t := range txs {
+		id := payment.PaymentID{ProjectID: projectID, PaymentID: t.PaymentID}
+		f, err := d.notificationFinding(id, t.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+// method returns the payment method of the payment or nil if the payment has
+// no payment method
+func (d *Doctor) method(p *payment.Payment) (*payment_method.Method, error) {
+	if !p.Config.PaymentMethodID.Valid {
+		return nil, nil
+	}
+	method, err := payment_method.PaymentMethodByIDDB(d.ctx, d.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		if err == payment_method.ErrPaymentMethodNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving payment method: %v", err)
+	}
+	return method, nil
+}
+
+// providerStatusRepair returns the intent which applies the status reported by
+// the provider to an open payment
+func providerStatusRepair(reported payment.PaymentTransactionStatus) string {
+	switch reported {
+	case payment.PaymentStatusPaid:
+		return paymentService.BatchIntentPaid
+	case payment.PaymentStatusAuthorized:
+		return paymentService.BatchIntentAuthorized
+	case payment.PaymentStatusFailed:
+		return paymentService.BatchIntentFailed
+	}
+	return ""
+}
+
+func (d *Doctor) providerStatusFinding(p *payment.Payment) (*Finding, error) {
+	if p.Status != payment.PaymentStatusOpen {
+		return nil, nil
+	}
+	method, err := d.method(p)
+	if err != nil || method == nil {
+		return nil, err
+	}
+	reported, ok, err := d.providers.ReportedStatus(p, method)
+	if err != nil {
+		if err == provider.ErrNotSupported || err == provider.ErrNoDriver {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving reported status: %v", err)
+	}
+	repair := providerStatusRepair(reported)
+	if !ok || repair == "" {
+		return nil, nil
+	}
+	return &Finding{
+		Check:     CheckProviderStatus,
+		PaymentID: p.PaymentID(),
+		Status:    p.Status,
+		Detail:    fmt.Sprintf("provider %s reported the payment as %s", method.Provider.Name, reported),
+		Repair:    repair,
+	}, nil
+}
+
+func (d *Doctor) authorizationFinding(p *payment.Payment) (*Finding, error) {
+	if p.Status != payment.PaymentStatusAuthorized {
+		return nil, nil
+	}
+	method, err := d.method(p)
+	if err != nil || method == nil {
+		return nil, err
+	}
+	validUntil, ok, err := d.providers.AuthorizationValidUntil(p, method)
+	if err != nil {
+		if err == provider.ErrNotSupported || err == provider.ErrNoDriver {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving authorization: %v", err)
+	}
+	if !ok || validUntil.IsZero() || validUntil.After(time.Now()) {
+		return nil, nil
+	}
+	// the provider released the authorization already
+	return &Finding{
+		Check:     CheckAuthorizationExpired,
+		PaymentID: p.PaymentID(),
+		Status:    p.Status,
+		Detail:    "authorization expired at " + validUntil.UTC().Format(time.RFC3339),
+		Repair:    paymentService.BatchIntentVoid,
+	}, nil
+}
+
+func (d *Doctor) notificationFinding(id payment.PaymentID, ts time.Time) (*Finding, error) {
+	_, err := outbox.NotificationByTransactionDB(d.ctx.ProjectPaymentDB(id.ProjectID, service.ReadOnly), id.ProjectID, id.PaymentID, ts)
+	if err == nil {
+		return nil, nil
+	}
+	if err != outbox.ErrNotificationNotFound {
+		return nil, fmt.Errorf("error retrieving notification: %v", err)
+	}
+	p, err := payment.PaymentByIDDB(d.ctx, d.ctx.ProjectPaymentDB(id.ProjectID, service.ReadOnly), id)
+	if err != nil {
+		if err == payment.ErrPaymentNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving payment: %v", err)
+	}
+	notifies, err := d.payments.NotifiesPayment(p)
+	if err != nil {
+		return nil, err
+	}
+	if !notifies {
+		return nil, nil
+	}
+	return &Finding{
+		Check:                CheckMissingNotification,
+		PaymentID:            id,
+		Status:               p.Status,
+		TransactionTimestamp: ts,
+		Detail:               "the project was not notified of the payment transaction",
+		Repair:               RepairNotify,
+	}, nil
+}
+
+// Repair repairs an inconsistency found by the given check
+//
+// The inconsistency is examined again before it is repaired. The transaction
+// timestamp is only used for missing notifications. It returns the repaired
+// finding.
+func (d *Doctor) Repair(check string, id payment.PaymentID, ts time.Time, createdBy string) (*Finding, error) {
+	var f *Finding
+	var err error
+	switch check {
+	case CheckProviderStatus, CheckAuthorizationExpired:
+		var p *payment.Payment
+		p, err = payment.PaymentByIDDB(d.ctx, d.ctx.ProjectPaymentDB(id.ProjectID, service.ReadOnly), id)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				return nil, err
+			}
+			return nil, fmt.Errorf("error retrieving payment: %v", err)
+		}
+		if check == CheckProviderStatus {
+			f, err = d.providerStatusFinding(p)
+		} else {
+			f, err = d.authorizationFinding(p)
+		}
+	case CheckMissingNotification:
+		f, err = d.notificationFinding(id, ts)
+	default:
+		return nil, ErrCheck
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, ErrResolved
+	}
+	if f.Repair == "" {
+		return f, ErrNoRepair
+	}
+	d.log.Info("repairing payment", log15.Ctx{
+		"method":    "Repair",
+		"check":     check,
+		"projectID": id.ProjectID,
+		"paymentID": id.PaymentID,
+		"repair":    f.Repair,
+		"createdBy": createdBy,
+	})
+	if f.Repair == RepairNotify {
+		return f, d.payments.NotifyTransaction(id, ts)
+	}
+	f.Status, err = d.payments.ApplyIntent(id, f.Repair, "doctor "+check+" by "+createdBy)
+	return f, err
+}
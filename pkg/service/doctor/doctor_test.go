@@ -0,0 +1,39 @@
+package doctor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProviderStatusRepair(t *testing.T) {
+	Convey("Given statuses reported by a provider", t, func() {
+		Convey("Final statuses should be repaired with the matching intent", func() {
+			So(providerStatusRepair(payment.PaymentStatusPaid), ShouldEqual, paymentService.BatchIntentPaid)
+			So(providerStatusRepair(payment.PaymentStatusAuthorized), ShouldEqual, paymentService.BatchIntentAuthorized)
+			So(providerStatusRepair(payment.PaymentStatusFailed), ShouldEqual, paymentService.BatchIntentFailed)
+		})
+		Convey("Other statuses should not be repaired", func() {
+			So(providerStatusRepair(payment.PaymentStatusOpen), ShouldEqual, "")
+			So(providerStatusRepair(payment.PaymentStatusPending), ShouldEqual, "")
+			So(providerStatusRepair(""), ShouldEqual, "")
+		})
+	})
+}
+
+func TestRepairUnknownCheck(t *testing.T) {
+	Convey("Given a doctor", t, func() {
+		d := &Doctor{}
+
+		Convey("When repairing an unknown check", func() {
+			_, err := d.Repair("unknown", payment.PaymentID{ProjectID: 1, PaymentID: 1}, time.Time{}, "admin")
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrCheck)
+			})
+		})
+	})
+}
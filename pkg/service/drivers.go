@@ -0,0 +1,61 @@
+package service
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Driver is an attached provider driver
+//
+// It is implemented by the drivers of the provider service, which add the
+// payment operations.
+type Driver interface {
+	Attach(ctx *Context, mux *mux.Router) error
+}
+
+// DriverRegistry holds the attached provider drivers by provider name
+//
+// The drivers are attached by the service serving the provider endpoints. Other
+// services of the same instance can request provider operations, e.g. captures
+// through the admin API, from the registered drivers. Users should assert the
+// optional capabilities they need.
+type DriverRegistry struct {
+	mu      sync.RWMutex
+	drivers map[string]Driver
+}
+
+// NewDriverRegistry creates a new driver registry
+func NewDriverRegistry() *DriverRegistry {
+	return &DriverRegistry{drivers: make(map[string]Driver)}
+}
+
+// Register registers the attached driver of the named provider
+func (r *DriverRegistry) Register(name string, driver Driver) {
+	r.mu.Lock()
+	r.drivers[name] = driver
+	r.mu.Unlock()
+}
+
+// Driver returns the attached driver of the named provider
+//
+// It returns false if no driver for the provider was attached on this instance.
+func (r *DriverRegistry) Driver(name string) (Driver, bool) {
+	r.mu.RLock()
+	d, ok := r.drivers[name]
+	r.mu.RUnlock()
+	return d, ok
+}
+
+// Names returns the names of the providers with attached drivers
+func (r *DriverRegistry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.drivers))
+	for name := range r.drivers {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
@@ -1,8 +1,12 @@
 package service
 
 import (
+	"compress/gzip"
+	"compress/zlib"
 	"errors"
+	"io"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -80,3 +84,125 @@ func (w *timeoutWriter) WriteHeader(status int) {
 	w.wroteHeader = true
 	w.w.WriteHeader(status)
 }
+
+// compressible content types
+var compressTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/x-javascript",
+	"image/svg+xml",
+}
+
+func compressible(contentType string) bool {
+	for _, t := range compressTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedEncoding returns the preferred compression encoding accepted by the
+// request
+func acceptedEncoding(r *http.Request) string {
+	var deflate bool
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc = strings.TrimSpace(enc)
+		if i := strings.Index(enc, ";"); i >= 0 {
+			// ignore disabled encodings
+			if strings.TrimSpace(enc[i+1:]) == "q=0" {
+				continue
+			}
+			enc = strings.TrimSpace(enc[:i])
+		}
+		switch enc {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+// CompressionHandler wraps the given handler with gzip/deflate response
+// compression
+//
+// Responses will only be compressed if the client accepts a supported encoding
+// and the content type of the response is compressible.
+func CompressionHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := acceptedEncoding(r)
+		if enc == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// partial content cannot be compressed
+		r.Header.Del("Range")
+		cw := &compressWriter{w: w, encoding: enc}
+		defer cw.Close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+type compressWriter struct {
+	w        http.ResponseWriter
+	encoding string
+
+	wroteHeader bool
+	compress    bool
+	cw          io.WriteCloser
+}
+
+func (c *compressWriter) Header() http.Header {
+	return c.w.Header()
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	hdr := c.w.Header()
+	if status == http.StatusOK && hdr.Get("Content-Encoding") == "" && compressible(hdr.Get("Content-Type")) {
+		c.compress = true
+		hdr.Set("Content-Encoding", c.encoding)
+		hdr.Del("Content-Length")
+	}
+	c.w.WriteHeader(status)
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		if c.w.Header().Get("Content-Type") == "" {
+			c.w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.compress {
+		return c.w.Write(p)
+	}
+	if c.cw == nil {
+		switch c.encoding {
+		case "gzip":
+			c.cw = gzip.NewWriter(c.w)
+		case "deflate":
+			// the deflate content coding is the zlib format (RFC 1950)
+			c.cw = zlib.NewWriter(c.w)
+		}
+	}
+	return c.cw.Write(p)
+}
+
+// Close flushes the compressed output
+func (c *compressWriter) Close() error {
+	if c.cw == nil {
+		return nil
+	}
+	return c.cw.Close()
+}
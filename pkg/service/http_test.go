@@ -0,0 +1,76 @@
+package service
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompressionHandler(t *testing.T) {
+	Convey("Given a compression handler", t, func() {
+		body := strings.Repeat("body { color: red; }\n", 100)
+		contentType := "text/css; charset=utf-8"
+		h := CompressionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(body))
+		}))
+		r, err := http.NewRequest("GET", "/static/style.css", nil)
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+
+		Convey("When the client accepts gzip", func() {
+			r.Header.Set("Accept-Encoding", "deflate, gzip")
+			h.ServeHTTP(w, r)
+
+			Convey("The response should be gzip compressed", func() {
+				So(w.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+				So(w.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+				gz, err := gzip.NewReader(w.Body)
+				So(err, ShouldBeNil)
+				b, err := ioutil.ReadAll(gz)
+				So(err, ShouldBeNil)
+				So(string(b), ShouldEqual, body)
+			})
+		})
+
+		Convey("When the client accepts deflate only", func() {
+			r.Header.Set("Accept-Encoding", "deflate, gzip;q=0")
+			h.ServeHTTP(w, r)
+
+			Convey("The response should be deflate compressed", func() {
+				So(w.Header().Get("Content-Encoding"), ShouldEqual, "deflate")
+				zr, err := zlib.NewReader(w.Body)
+				So(err, ShouldBeNil)
+				b, err := ioutil.ReadAll(zr)
+				So(err, ShouldBeNil)
+				So(string(b), ShouldEqual, body)
+			})
+		})
+
+		Convey("When the client does not accept compression", func() {
+			h.ServeHTTP(w, r)
+
+			Convey("The response should not be compressed", func() {
+				So(w.Header().Get("Content-Encoding"), ShouldBeEmpty)
+				So(w.Body.String(), ShouldEqual, body)
+			})
+		})
+
+		Convey("When the content is not compressible", func() {
+			contentType = "image/png"
+			r.Header.Set("Accept-Encoding", "gzip")
+			h.ServeHTTP(w, r)
+
+			Convey("The response should not be compressed", func() {
+				So(w.Header().Get("Content-Encoding"), ShouldBeEmpty)
+				So(w.Body.String(), ShouldEqual, body)
+			})
+		})
+	})
+}
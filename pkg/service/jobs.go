@@ -0,0 +1,375 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/job"
+	"github.com/fritzpay/paymentd/pkg/server"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	ErrJobExists     = errors.New("job already registered")
+	ErrJobNotFound   = errors.New("job not found")
+	ErrJobNotRunning = errors.New("job runner not started")
+)
+
+const (
+	// interval in which the schedules and pending retries are checked
+	jobTick = time.Second
+	// size of the queue of due runs
+	jobQueueSize = 64
+)
+
+// JobFunc performs the work of a job
+//
+// The done channel will be closed when the service is shutting down. Long
+// running jobs should return early in that case.
+type JobFunc func(done <-chan struct{}) error
+
+// Job is a unit of background work
+type Job struct {
+	Name string
+	// Schedule determines when the job runs. Jobs without a schedule will only
+	// run when enqueued
+	Schedule job.Schedule
+	Retry    job.RetryPolicy
+	Run      JobFunc
+}
+
+// JobInfo describes a registered job
+type JobInfo struct {
+	Name string
+	// Schedule is the schedule specification. Empty if the job is not scheduled
+	Schedule string
+	// Next is the time of the next scheduled run. Nil if the job is not
+	// scheduled or the runner is not started
+	Next    *time.Time `json:",omitempty"`
+	Running bool
+}
+
+type jobRun struct {
+	job     *Job
+	attempt int
+	trigger string
+	at      time.Time
+}
+
+// JobRunner runs the registered jobs on their schedules in a pool of workers
+//
+// Every run is recorded in the payment database. A job will not be run
+// concurrently, scheduled runs of a still running job will be skipped.
+type JobRunner struct {
+	ctx  *Context
+	log  log15.Logger
+	host string
+
+	m       sync.Mutex
+	jobs    map[string]*Job
+	next    map[string]time.Time
+	running map[string]bool
+	retries []jobRun
+	queue   chan jobRun
+
+	mShutdown    sync.Mutex
+	shutdown     []func()
+	shutdownWait bool
+}
+
+func newJobRunner(ctx *Context) *JobRunner {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &JobRunner{
+		ctx: ctx,
+		log: ctx.Log().New(log15.Ctx{
+			"pkg":  "github.com/fritzpay/paymentd/pkg/service",
+			"type": "JobRunner",
+		}),
+		host:    host,
+		jobs:    make(map[string]*Job),
+		next:    make(map[string]time.Time),
+		running: make(map[string]bool),
+	}
+}
+
+// Register registers a job
+//
+// A configured schedule for the job name overrides the schedule of the job.
+func (r *JobRunner) Register(j *Job) error {
+	if j.Name == "" || j.Run == nil {
+		return fmt.Errorf("invalid job %q", j.Name)
+	}
+	if spec, ok := r.ctx.Config().Jobs.Schedules[j.Name]; ok {
+		j.Schedule = nil
+		if spec != "" {
+			s, err := job.ParseSchedule(spec)
+			if err != nil {
+				return err
+			}
+			j.Schedule = s
+		}
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.jobs[j.Name]; ok {
+		return ErrJobExists
+	}
+	r.jobs[j.Name] = j
+	if r.queue != nil && j.Schedule != nil {
+		r.next[j.Name] = j.Schedule.Next(time.Now())
+	}
+	return nil
+}
+
+// Jobs returns the registered jobs ordered by name
+func (r *JobRunner) Jobs() []JobInfo {
+	r.m.Lock()
+	defer r.m.Unlock()
+	jobs := make([]JobInfo, 0, len(r.jobs))
+	for name, j := range r.jobs {
+		info := JobInfo{
+			Name:    name,
+			Running: r.running[name],
+		}
+		if j.Schedule != nil {
+			info.Schedule = j.Schedule.String()
+		}
+		if next := r.next[name]; !next.IsZero() {
+			info.Next = &next
+		}
+		jobs = append(jobs, info)
+	}
+	sort.Sort(jobInfosByName(jobs))
+	return jobs
+}
+
+type jobInfosByName []JobInfo
+
+func (j jobInfosByName) Len() int           { return len(j) }
+func (j jobInfosByName) Less(a, b int) bool { return j[a].Name < j[b].Name }
+func (j jobInfosByName) Swap(a, b int)      { j[a], j[b] = j[b], j[a] }
+
+// Enqueue runs the job with the given name as soon as a worker is available
+func (r *JobRunner) Enqueue(name string) error {
+	r.m.Lock()
+	j, ok := r.jobs[name]
+	queue := r.queue
+	r.m.Unlock()
+	if !ok {
+		return ErrJobNotFound
+	}
+	if queue == nil {
+		return ErrJobNotRunning
+	}
+	select {
+	case queue <- jobRun{job: j, attempt: 1, trigger: job.TriggerManual}:
+		return nil
+	case <-r.ctx.Done():
+		return ErrJobNotRunning
+	}
+}
+
+// Start starts the scheduler and the given number of workers
+//
+// Runs which were left running by a previous process on this host will be
+// marked as aborted. The runner stops when the service context is done. The
+// server will wait for running jobs to return on shutdown.
+func (r *JobRunner) Start(workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("invalid number of job workers %d", workers)
+	}
+	aborted, err := job.AbortRunsDB(r.ctx.PaymentDB(), r.host)
+	if err != nil {
+		return err
+	}
+	if aborted > 0 {
+		r.log.Warn("marked runs of previous process as aborted", log15.Ctx{"runs": aborted})
+	}
+	r.m.Lock()
+	if r.queue != nil {
+		r.m.Unlock()
+		return fmt.Errorf("job runner already started")
+	}
+	r.queue = make(chan jobRun, jobQueueSize)
+	now := time.Now()
+	for name, j := range r.jobs {
+		if j.Schedule != nil {
+			r.next[name] = j.Schedule.Next(now)
+		}
+	}
+	r.m.Unlock()
+
+	server.Wait.Add(workers + 1)
+	go r.schedule()
+	for i := 0; i < workers; i++ {
+		go r.work()
+	}
+	r.log.Info("job runner started", log15.Ctx{"workers": workers})
+	return nil
+}
+
+// OnShutdown registers a function which will be called when the service
+// context is done, e.g. to release the resources of a service
+//
+// The functions are called regardless of whether the runner was started. The
+// server will wait for them to return on shutdown.
+func (r *JobRunner) OnShutdown(f func()) {
+	r.mShutdown.Lock()
+	defer r.mShutdown.Unlock()
+	r.shutdown = append(r.shutdown, f)
+	if r.shutdownWait {
+		return
+	}
+	r.shutdownWait = true
+	server.Wait.Add(1)
+	go r.waitShutdown()
+}
+
+func (r *JobRunner) waitShutdown() {
+	defer server.Wait.Done()
+	<-r.ctx.Done()
+	r.mShutdown.Lock()
+	shutdown := r.shutdown
+	r.shutdown = nil
+	r.mShutdown.Unlock()
+	for _, f := range shutdown {
+		f()
+	}
+}
+
+func (r *JobRunner) schedule() {
+	defer server.Wait.Done()
+	t := time.NewTicker(jobTick)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case now := <-t.C:
+			for _, run := range r.due(now) {
+				select {
+				case r.queue <- run:
+				default:
+					r.log.Warn("job queue full. skipping run", log15.Ctx{
+						"job":     run.job.Name,
+						"trigger": run.trigger,
+					})
+				}
+			}
+		}
+	}
+}
+
+// due returns the scheduled runs and the retries due at the given time
+func (r *JobRunner) due(now time.Time) []jobRun {
+	r.m.Lock()
+	defer r.m.Unlock()
+	var runs []jobRun
+	for name, next := range r.next {
+		if next.IsZero() || next.After(now) {
+			continue
+		}
+		j := r.jobs[name]
+		r.next[name] = j.Schedule.Next(now)
+		if r.running[name] {
+			r.log.Warn("job still running. skipping scheduled run", log15.Ctx{"job": name})
+			continue
+		}
+		runs = append(runs, jobRun{job: j, attempt: 1, trigger: job.TriggerSchedule})
+	}
+	pending := r.retries[:0]
+	for _, run := range r.retries {
+		if run.at.After(now) {
+			pending = append(pending, run)
+			continue
+		}
+		runs = append(runs, run)
+	}
+	r.retries = pending
+	return runs
+}
+
+func (r *JobRunner) work() {
+	defer server.Wait.Done()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case run := <-r.queue:
+			r.m.Lock()
+			if r.running[run.job.Name] {
+				r.m.Unlock()
+				r.log.Warn("job already running. skipping run", log15.Ctx{
+					"job":     run.job.Name,
+					"trigger": run.trigger,
+				})
+				continue
+			}
+			r.running[run.job.Name] = true
+			r.m.Unlock()
+
+			err := r.execute(run)
+
+			r.m.Lock()
+			delete(r.running, run.job.Name)
+			if err != nil {
+				if delay, ok := run.job.Retry.Retry(run.attempt); ok {
+					r.retries = append(r.retries, jobRun{
+						job:     run.job,
+						attempt: run.attempt + 1,
+						trigger: job.TriggerRetry,
+						at:      time.Now().Add(delay),
+					})
+				}
+			}
+			r.m.Unlock()
+		}
+	}
+}
+
+// execute runs the job and records the run
+func (r *JobRunner) execute(run jobRun) (err error) {
+	log := r.log.New(log15.Ctx{
+		"job":     run.job.Name,
+		"attempt": run.attempt,
+		"trigger": run.trigger,
+	})
+	rec := &job.Run{
+		Job:     run.job.Name,
+		Attempt: run.attempt,
+		Trigger: run.trigger,
+		Host:    r.host,
+		Started: time.Now(),
+		Status:  job.StatusRunning,
+	}
+	db := r.ctx.PaymentDB()
+	if dbErr := job.InsertRunDB(db, rec); dbErr != nil {
+		log.Error("error recording job run", log15.Ctx{"err": dbErr})
+	}
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			err = fmt.Errorf("panic: %v", rcv)
+		}
+		rec.Finish(err)
+		if err != nil {
+			log.Error("job failed", log15.Ctx{"err": err})
+		} else {
+			log.Info("job succeeded", log15.Ctx{"duration": rec.Finished.Sub(rec.Started)})
+		}
+		if rec.ID == 0 {
+			return
+		}
+		if dbErr := job.FinishRunDB(db, rec); dbErr != nil {
+			log.Error("error recording job result", log15.Ctx{"err": dbErr})
+		}
+	}()
+	log.Info("running job...")
+	return run.job.Run(r.ctx.Done())
+}
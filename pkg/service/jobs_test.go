@@ -0,0 +1,113 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/job"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestJobRunner(t *testing.T) {
+	Convey("Given a job runner", t, WithContext(func(ctx *Context) {
+		ctx.cfg.Jobs.Schedules["disabled"] = ""
+		ctx.cfg.Jobs.Schedules["overridden"] = "@every 5m"
+		r := newJobRunner(ctx)
+		run := func(done <-chan struct{}) error { return nil }
+		hourly, err := job.ParseSchedule("@hourly")
+		So(err, ShouldBeNil)
+
+		Convey("When registering jobs", func() {
+			So(r.Register(&Job{Name: "scheduled", Schedule: hourly, Run: run}), ShouldBeNil)
+			So(r.Register(&Job{Name: "disabled", Schedule: hourly, Run: run}), ShouldBeNil)
+			So(r.Register(&Job{Name: "overridden", Schedule: hourly, Run: run}), ShouldBeNil)
+
+			Convey("Registering a job twice should fail", func() {
+				So(r.Register(&Job{Name: "scheduled", Run: run}), ShouldEqual, ErrJobExists)
+			})
+			Convey("Configured schedules should override the job schedules", func() {
+				jobs := r.Jobs()
+				So(len(jobs), ShouldEqual, 3)
+				So(jobs[0].Name, ShouldEqual, "disabled")
+				So(jobs[0].Schedule, ShouldEqual, "")
+				So(jobs[1].Name, ShouldEqual, "overridden")
+				So(jobs[1].Schedule, ShouldEqual, "@every 5m0s")
+				So(jobs[2].Name, ShouldEqual, "scheduled")
+				So(jobs[2].Schedule, ShouldEqual, "@hourly")
+				So(jobs[2].Next, ShouldBeNil)
+			})
+			Convey("Enqueuing an unknown job should fail", func() {
+				So(r.Enqueue("unknown"), ShouldEqual, ErrJobNotFound)
+			})
+			Convey("Enqueuing a job before the runner is started should fail", func() {
+				So(r.Enqueue("scheduled"), ShouldEqual, ErrJobNotRunning)
+			})
+		})
+
+		Convey("When a scheduled job is due", func() {
+			So(r.Register(&Job{Name: "scheduled", Schedule: hourly, Run: run}), ShouldBeNil)
+			now := time.Now()
+			r.next["scheduled"] = now.Add(-time.Second)
+
+			Convey("It should be run", func() {
+				runs := r.due(now)
+				So(len(runs), ShouldEqual, 1)
+				So(runs[0].trigger, ShouldEqual, job.TriggerSchedule)
+				So(r.next["scheduled"].After(now), ShouldBeTrue)
+			})
+
+			Convey("It should be skipped while still running", func() {
+				r.running["scheduled"] = true
+				So(len(r.due(now)), ShouldEqual, 0)
+				So(r.next["scheduled"].After(now), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a job fails", func() {
+			j := &Job{
+				Name:  "failing",
+				Retry: job.RetryPolicy{MaxAttempts: 2, Backoff: time.Minute},
+				Run: func(done <-chan struct{}) error {
+					return errors.New("failed")
+				},
+			}
+			So(r.Register(j), ShouldBeNil)
+			r.retries = append(r.retries, jobRun{job: j, attempt: 2, trigger: job.TriggerRetry, at: time.Now().Add(time.Minute)})
+
+			Convey("Retries should only be run when due", func() {
+				So(len(r.due(time.Now())), ShouldEqual, 0)
+				runs := r.due(time.Now().Add(2 * time.Minute))
+				So(len(runs), ShouldEqual, 1)
+				So(runs[0].attempt, ShouldEqual, 2)
+				So(len(r.retries), ShouldEqual, 0)
+			})
+		})
+	}))
+}
+
+func TestJobRunnerShutdown(t *testing.T) {
+	Convey("Given a job runner which was not started", t, func() {
+		c, cancel := context.WithCancel(context.Background())
+		ctx, err := NewContext(c, config.DefaultConfig(), log15.New())
+		So(err, ShouldBeNil)
+		r := newJobRunner(ctx)
+
+		Convey("When the service context is done", func() {
+			called := make(chan struct{})
+			r.OnShutdown(func() { close(called) })
+			cancel()
+
+			Convey("The shutdown functions should be called", func() {
+				select {
+				case <-called:
+				case <-time.After(time.Second):
+					t.Error("shutdown function not called")
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/cache"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Lockout tracks authentication failures and temporarily locks out subjects
+// after repeated failures
+//
+// Subjects are e.g. project keys, admin users or client addresses. After each
+// failure, further attempts will be refused for a delay, which doubles with
+// each failure. After MaxFailures failures within the window, the subject will
+// be locked out for the lockout duration.
+//
+// The state is kept in the shared cache, so it applies to all instances.
+type Lockout struct {
+	cache cache.Store
+	log   log15.Logger
+
+	// MaxFailures is the number of failures after which a subject will be locked
+	// out. A value <= 0 disables lockouts and delays
+	MaxFailures int
+	Window      time.Duration
+	Duration    time.Duration
+	Delay       time.Duration
+	MaxDelay    time.Duration
+}
+
+// NewLockout creates a new lockout tracker
+func NewLockout(c cache.Store, log log15.Logger) *Lockout {
+	return &Lockout{
+		cache: c,
+		log:   log.New(log15.Ctx{"type": "Lockout"}),
+	}
+}
+
+func (l *Lockout) enabled() bool {
+	return l.MaxFailures > 0
+}
+
+// until returns the time until which the key blocks attempts
+func (l *Lockout) until(key string) (time.Time, error) {
+	b, err := l.cache.Get(key)
+	if err != nil {
+		if err == cache.ErrNotFound {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	ns, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ns), nil
+}
+
+func (l *Lockout) block(key string, d time.Duration) error {
+	return l.cache.Set(key, []byte(strconv.FormatInt(time.Now().Add(d).UnixNano(), 10)), d)
+}
+
+// Check returns the duration for which attempts of the subject will be refused
+//
+// A duration of 0 means the subject may attempt to authenticate.
+func (l *Lockout) Check(subject string) (time.Duration, error) {
+	if !l.enabled() {
+		return 0, nil
+	}
+	var wait time.Duration
+	for _, key := range []string{"lockout:" + subject, "authdelay:" + subject} {
+		until, err := l.until(key)
+		if err != nil {
+			return 0, err
+		}
+		if d := until.Sub(time.Now()); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// Fail records an authentication failure of the subject
+//
+// It returns the duration for which further attempts will be refused.
+func (l *Lockout) Fail(subject string) (time.Duration, error) {
+	if !l.enabled() {
+		return 0, nil
+	}
+	n, err := l.cache.Incr("authfail:"+subject, l.Window)
+	if err != nil {
+		return 0, err
+	}
+	if n >= int64(l.MaxFailures) {
+		err = l.block("lockout:"+subject, l.Duration)
+		if err != nil {
+			return 0, err
+		}
+		l.log.Error("authentication lockout", log15.Ctx{
+			"event":    "auth_lockout",
+			"subject":  subject,
+			"failures": n,
+			"duration": l.Duration,
+		})
+		return l.Duration, nil
+	}
+	delay := l.Delay
+	for i := int64(1); i < n && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.MaxDelay {
+		delay = l.MaxDelay
+	}
+	if delay > 0 {
+		err = l.block("authdelay:"+subject, delay)
+		if err != nil {
+			return 0, err
+		}
+	}
+	l.log.Warn("authentication failure", log15.Ctx{
+		"event":    "auth_failure",
+		"subject":  subject,
+		"failures": n,
+		"delay":    delay,
+	})
+	return delay, nil
+}
+
+// Reset clears the failures of the subject after a successful authentication
+//
+// An active lockout will not be cleared.
+func (l *Lockout) Reset(subject string) error {
+	if !l.enabled() {
+		return nil
+	}
+	return l.cache.Delete("authfail:" + subject)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/cache"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestLockout(t *testing.T) {
+	Convey("Given a lockout tracker", t, func() {
+		log := log15.New()
+		log.SetHandler(log15.DiscardHandler())
+		l := NewLockout(cache.NewMemoryStore(), log)
+		l.MaxFailures = 3
+		l.Window = time.Minute
+		l.Duration = time.Hour
+		l.Delay = time.Second
+		l.MaxDelay = 90 * time.Second
+
+		Convey("A new subject should not be refused", func() {
+			wait, err := l.Check("user:jane")
+			So(err, ShouldBeNil)
+			So(wait, ShouldEqual, 0)
+		})
+
+		Convey("When an authentication fails", func() {
+			delay, err := l.Fail("user:jane")
+			So(err, ShouldBeNil)
+			So(delay, ShouldEqual, time.Second)
+
+			Convey("Attempts should be refused for the delay", func() {
+				wait, err := l.Check("user:jane")
+				So(err, ShouldBeNil)
+				So(wait, ShouldBeGreaterThan, 0)
+				So(wait, ShouldBeLessThanOrEqualTo, time.Second)
+			})
+			Convey("Other subjects should not be affected", func() {
+				wait, err := l.Check("user:john")
+				So(err, ShouldBeNil)
+				So(wait, ShouldEqual, 0)
+			})
+			Convey("When it fails again", func() {
+				delay, err := l.Fail("user:jane")
+				Convey("The delay should double", func() {
+					So(err, ShouldBeNil)
+					So(delay, ShouldEqual, 2*time.Second)
+				})
+				Convey("When it fails the maximum number of times", func() {
+					delay, err := l.Fail("user:jane")
+					Convey("The subject should be locked out", func() {
+						So(err, ShouldBeNil)
+						So(delay, ShouldEqual, time.Hour)
+						wait, err := l.Check("user:jane")
+						So(err, ShouldBeNil)
+						So(wait, ShouldBeGreaterThan, 59*time.Minute)
+					})
+				})
+			})
+			Convey("When the subject authenticates successfully", func() {
+				So(l.Reset("user:jane"), ShouldBeNil)
+				Convey("The failures should be cleared", func() {
+					delay, err := l.Fail("user:jane")
+					So(err, ShouldBeNil)
+					So(delay, ShouldEqual, time.Second)
+				})
+			})
+		})
+
+		Convey("When lockouts are disabled", func() {
+			l.MaxFailures = 0
+			Convey("Failures should not delay attempts", func() {
+				delay, err := l.Fail("user:jane")
+				So(err, ShouldBeNil)
+				So(delay, ShouldEqual, 0)
+				wait, err := l.Check("user:jane")
+				So(err, ShouldBeNil)
+				So(wait, ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Mail is a plain text mail
+type Mail struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Mailer sends mails through the configured SMTP server
+//
+// Mails are sent in the background. If no SMTP server is configured, the mails
+// will be logged instead.
+type Mailer struct {
+	log  log15.Logger
+	addr string
+	auth smtp.Auth
+	from *mail.Address
+
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func mailerFromConfig(ctx *Context, cfg config.Config) (*Mailer, error) {
+	m := &Mailer{
+		log: ctx.Log().New(log15.Ctx{
+			"pkg":  "github.com/fritzpay/paymentd/pkg/service",
+			"type": "Mailer",
+		}),
+		addr: cfg.Mail.SMTPAddress,
+		send: smtp.SendMail,
+	}
+	if m.addr == "" {
+		return m, nil
+	}
+	host, _, err := net.SplitHostPort(m.addr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Mail.From == "" {
+		return nil, errors.New("missing sender address")
+	}
+	m.from, err = mail.ParseAddress(cfg.Mail.From)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Mail.Username != "" {
+		m.auth = smtp.PlainAuth("", cfg.Mail.Username, cfg.Mail.Password, host)
+	}
+	return m, nil
+}
+
+// Send sends the mail in the background
+//
+// Invalid recipient addresses will be skipped.
+func (m *Mailer) Send(ml *Mail) {
+	log := m.log.New(log15.Ctx{"subject": ml.Subject})
+	to := make([]string, 0, len(ml.To))
+	for _, rcpt := range ml.To {
+		addr, err := mail.ParseAddress(rcpt)
+		if err != nil {
+			log.Warn("invalid recipient address. skipping", log15.Ctx{"to": rcpt, "err": err})
+			continue
+		}
+		to = append(to, addr.Address)
+	}
+	if len(to) == 0 {
+		return
+	}
+	if m.addr == "" {
+		log.Info("no SMTP server configured. not sending mail", log15.Ctx{"to": to})
+		return
+	}
+	msg, err := m.message(to, ml, time.Now())
+	if err != nil {
+		log.Error("error composing mail", log15.Ctx{"err": err})
+		return
+	}
+	go func() {
+		err := m.send(m.addr, m.auth, m.from.Address, to, msg)
+		if err != nil {
+			log.Error("error sending mail", log15.Ctx{"to": to, "err": err})
+			return
+		}
+		log.Info("mail sent", log15.Ctx{"to": to})
+	}()
+}
+
+// message returns the mail message with its headers
+func (m *Mailer) message(to []string, ml *Mail, now time.Time) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("From: " + m.from.String() + "\r\n")
+	buf.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", ml.Subject) + "\r\n")
+	buf.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(buf)
+	_, err := qp.Write([]byte(strings.Replace(ml.Body, "\n", "\r\n", -1)))
+	if err != nil {
+		return nil, err
+	}
+	err = qp.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
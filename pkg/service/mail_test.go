@@ -0,0 +1,80 @@
+package service
+
+import (
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+type sentMail struct {
+	from string
+	to   []string
+	msg  string
+}
+
+func TestMailer(t *testing.T) {
+	Convey("Given a mailer with an SMTP server", t, func() {
+		cfg := config.DefaultConfig()
+		cfg.Mail.SMTPAddress = "localhost:25"
+		cfg.Mail.From = "paymentd <paymentd@example.com>"
+		ctx, err := NewContext(context.Background(), cfg, log15.New())
+		So(err, ShouldBeNil)
+		m := ctx.Mailer()
+
+		sent := make(chan sentMail, 1)
+		m.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			sent <- sentMail{from: from, to: to, msg: string(msg)}
+			return nil
+		}
+
+		Convey("When sending a mail", func() {
+			m.Send(&Mail{
+				To:      []string{"Merchant <merchant@example.com>", "invalid"},
+				Subject: "Bestätigung",
+				Body:    "line 1\nline 2",
+			})
+
+			Convey("It should be sent to the valid recipients", func() {
+				var s sentMail
+				select {
+				case s = <-sent:
+				case <-time.After(time.Second):
+					t.Fatal("mail not sent")
+				}
+				So(s.from, ShouldEqual, "paymentd@example.com")
+				So(s.to, ShouldResemble, []string{"merchant@example.com"})
+				So(s.msg, ShouldContainSubstring, "To: merchant@example.com\r\n")
+				So(s.msg, ShouldContainSubstring, "Subject: =?utf-8?q?Best=C3=A4tigung?=\r\n")
+				So(strings.HasSuffix(s.msg, "line 1\r\nline 2"), ShouldBeTrue)
+			})
+		})
+
+		Convey("When sending a mail without valid recipients", func() {
+			m.Send(&Mail{To: []string{"invalid"}, Subject: "Test"})
+
+			Convey("It should not be sent", func() {
+				select {
+				case <-sent:
+					t.Error("mail sent")
+				case <-time.After(100 * time.Millisecond):
+				}
+			})
+		})
+	})
+
+	Convey("Given a mail config without sender address", t, func() {
+		cfg := config.DefaultConfig()
+		cfg.Mail.SMTPAddress = "localhost:25"
+
+		Convey("Creating the context should fail", func() {
+			_, err := NewContext(context.Background(), cfg, log15.New())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
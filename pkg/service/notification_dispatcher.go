@@ -0,0 +1,377 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/job"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	defaultNotificationConcurrency  = 64
+	defaultNotificationTimeout      = 30 * time.Second
+	defaultNotificationMaxAttempts  = 10
+	defaultNotificationRetryBackoff = time.Minute
+)
+
+// DeliverFunc delivers a notification
+//
+// digest is true if the callback endpoint is in digest mode. Delivery errors
+// should be returned, so that timeouts of the endpoint can be detected.
+type DeliverFunc func(digest bool) error
+
+// DeliveryLimits limit the deliveries to a callback endpoint
+//
+// Zero values are unlimited.
+type DeliveryLimits struct {
+	// PerSecond is the maximum number of deliveries per second
+	PerSecond int
+	// Concurrency is the maximum number of concurrent deliveries
+	Concurrency int
+}
+
+// Delivery is a notification queued for delivery
+type Delivery struct {
+	// Key identifies the notifications which can be merged in digest mode,
+	// e.g. the notifications of a payment. Notifications with an empty key
+	// will never be merged
+	Key     string
+	Deliver DeliverFunc
+	// Discard is called if the notification was merged into a later
+	// notification and will not be delivered. Optional
+	Discard func()
+}
+
+// subscription is the delivery state of the callback of a project
+type subscription struct {
+	limits  DeliveryLimits
+	queue   []*Delivery
+	active  int
+	running bool
+	next    time.Time
+
+	// consecutive timeouts and consecutive deliveries without timeout in
+	// digest mode
+	timeouts  int
+	successes int
+	digest    bool
+
+	delivered int64
+	failed    int64
+	coalesced int64
+}
+
+func (sub *subscription) concurrency() int {
+	if sub.digest {
+		return 1
+	}
+	return sub.limits.Concurrency
+}
+
+func (sub *subscription) interval() time.Duration {
+	if sub.limits.PerSecond <= 0 {
+		return 0
+	}
+	return time.Second / time.Duration(sub.limits.PerSecond)
+}
+
+// NotificationStats are the queue metrics of the notification dispatcher
+type NotificationStats struct {
+	// Concurrency is the maximum number of concurrent deliveries
+	Concurrency int
+	// Active is the number of deliveries in progress
+	Active int
+	// Queued is the number of notifications waiting for delivery
+	Queued        int
+	Subscriptions []NotificationSubscriptionStats
+}
+
+// NotificationSubscriptionStats are the queue metrics of the callback of a
+// project
+type NotificationSubscriptionStats struct {
+	ProjectID int64 `json:",string"`
+	Queued    int
+	Active    int
+	// Number of deliveries since the start of the instance
+	Delivered int64
+	// Number of failed deliveries since the start of the instance
+	Failed int64
+	// Number of notifications merged into later notifications in digest mode
+	Coalesced int64
+	// Timeouts is the number of consecutive timeouts of the endpoint
+	Timeouts int
+	Digest   bool
+}
+
+// NotificationDispatcher delivers the callback notifications of the projects
+//
+// Every project is delivered from its own queue, limited by the configured
+// delivery rate and concurrency of the project. The deliveries of all projects
+// share the maximum concurrency of the instance, so a slow callback endpoint
+// cannot exhaust the resources of the instance.
+//
+// Callback endpoints which regularly time out or whose queue grows beyond the
+// configured depth are switched to digest mode: notifications are delivered
+// one at a time and queued notifications with the same key, e.g. of the same
+// payment, are merged into the latest one. The endpoint leaves the digest mode
+// once it responded in time again.
+type NotificationDispatcher struct {
+	ctx *Context
+	log log15.Logger
+
+	concurrency      int
+	timeout          time.Duration
+	digestTimeouts   int
+	digestQueueDepth int
+	retry            job.RetryPolicy
+
+	slots chan struct{}
+
+	m    sync.Mutex
+	cond *sync.Cond
+	subs map[int64]*subscription
+}
+
+func notificationDispatcherFromConfig(ctx *Context, cfg config.Config) (*NotificationDispatcher, error) {
+	d := &NotificationDispatcher{
+		ctx: ctx,
+		log: ctx.Log().New(log15.Ctx{
+			"pkg":  "github.com/fritzpay/paymentd/pkg/service",
+			"type": "NotificationDispatcher",
+		}),
+		concurrency:      cfg.Payment.Notification.Concurrency,
+		timeout:          defaultNotificationTimeout,
+		digestTimeouts:   cfg.Payment.Notification.DigestTimeouts,
+		digestQueueDepth: cfg.Payment.Notification.DigestQueueDepth,
+		retry: job.RetryPolicy{
+			MaxAttempts: cfg.Payment.Notification.MaxAttempts,
+			Backoff:     defaultNotificationRetryBackoff,
+		},
+		subs: make(map[int64]*subscription),
+	}
+	d.cond = sync.NewCond(&d.m)
+	if d.concurrency <= 0 {
+		d.concurrency = defaultNotificationConcurrency
+	}
+	if cfg.Payment.Notification.Timeout != "" {
+		var err error
+		if d.timeout, err = cfg.Payment.Notification.Timeout.Duration(); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+		if d.timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %s", d.timeout)
+		}
+	}
+	if d.retry.MaxAttempts <= 0 {
+		d.retry.MaxAttempts = defaultNotificationMaxAttempts
+	}
+	if cfg.Payment.Notification.RetryBackoff != "" {
+		var err error
+		if d.retry.Backoff, err = cfg.Payment.Notification.RetryBackoff.Duration(); err != nil {
+			return nil, fmt.Errorf("invalid retry backoff: %v", err)
+		}
+		if d.retry.Backoff <= 0 {
+			return nil, fmt.Errorf("invalid retry backoff %s", d.retry.Backoff)
+		}
+	}
+	if d.digestTimeouts < 0 || d.digestQueueDepth < 0 {
+		return nil, fmt.Errorf("invalid digest thresholds")
+	}
+	d.slots = make(chan struct{}, d.concurrency)
+	return d, nil
+}
+
+// Timeout returns the timeout of a delivery
+func (d *NotificationDispatcher) Timeout() time.Duration {
+	return d.timeout
+}
+
+// RetryPolicy returns the policy for retries of failed deliveries
+func (d *NotificationDispatcher) RetryPolicy() job.RetryPolicy {
+	return d.retry
+}
+
+// Dispatch queues a notification for the callback of the project
+//
+// The limits replace the limits of previous notifications of the project.
+// In digest mode, a queued notification with the same key will be replaced
+// and discarded.
+func (d *NotificationDispatcher) Dispatch(projectID int64, limits DeliveryLimits, dl *Delivery) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	sub, ok := d.subs[projectID]
+	if !ok {
+		sub = &subscription{}
+		d.subs[projectID] = sub
+	}
+	sub.limits = limits
+	if sub.digest && dl.Key != "" {
+		for i, queued := range sub.queue {
+			if queued.Key == dl.Key {
+				sub.queue[i] = dl
+				sub.coalesced++
+				if queued.Discard != nil {
+					go queued.Discard()
+				}
+				return
+			}
+		}
+	}
+	sub.queue = append(sub.queue, dl)
+	if !sub.digest && d.digestQueueDepth > 0 && len(sub.queue) > d.digestQueueDepth {
+		sub.digest, sub.successes = true, 0
+		d.log.Warn("callback queue too deep, switching to digest mode", log15.Ctx{
+			"projectID": projectID,
+			"queued":    len(sub.queue),
+		})
+	}
+	if !sub.running {
+		sub.running = true
+		go d.run(projectID, sub)
+	}
+}
+
+// run delivers the queued notifications of a project
+func (d *NotificationDispatcher) run(projectID int64, sub *subscription) {
+	d.m.Lock()
+	for {
+		for sub.concurrency() > 0 && sub.active >= sub.concurrency() {
+			d.cond.Wait()
+		}
+		if len(sub.queue) == 0 {
+			sub.running = false
+			d.m.Unlock()
+			return
+		}
+		if wait := sub.next.Sub(time.Now()); wait > 0 {
+			d.m.Unlock()
+			select {
+			case <-d.ctx.Done():
+				d.drop(projectID, sub)
+				return
+			case <-time.After(wait):
+			}
+			d.m.Lock()
+			continue
+		}
+		next := sub.queue[0]
+		sub.queue = sub.queue[1:]
+		sub.active++
+		sub.next = time.Now().Add(sub.interval())
+		digest := sub.digest
+		d.m.Unlock()
+
+		select {
+		case <-d.ctx.Done():
+			d.m.Lock()
+			sub.active--
+			d.m.Unlock()
+			d.drop(projectID, sub)
+			return
+		case d.slots <- struct{}{}:
+		}
+		go d.deliver(projectID, sub, next, digest)
+
+		d.m.Lock()
+	}
+}
+
+func (d *NotificationDispatcher) deliver(projectID int64, sub *subscription, next *Delivery, digest bool) {
+	err := next.Deliver(digest)
+	<-d.slots
+
+	d.m.Lock()
+	defer d.m.Unlock()
+	sub.active--
+	d.cond.Broadcast()
+	if err != nil {
+		sub.failed++
+	} else {
+		sub.delivered++
+	}
+	if isTimeout(err) {
+		sub.timeouts++
+		sub.successes = 0
+		if !sub.digest && d.digestTimeouts > 0 && sub.timeouts >= d.digestTimeouts {
+			sub.digest = true
+			d.log.Warn("callback timed out repeatedly, switching to digest mode", log15.Ctx{
+				"projectID": projectID,
+				"timeouts":  sub.timeouts,
+			})
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	sub.timeouts = 0
+	if !sub.digest {
+		return
+	}
+	sub.successes++
+	if sub.successes < d.digestTimeouts {
+		return
+	}
+	if d.digestQueueDepth > 0 && len(sub.queue) > d.digestQueueDepth {
+		return
+	}
+	sub.digest = false
+	d.log.Info("callback recovered, leaving digest mode", log15.Ctx{"projectID": projectID})
+}
+
+// drop discards the queued notifications of a project on shutdown
+func (d *NotificationDispatcher) drop(projectID int64, sub *subscription) {
+	d.m.Lock()
+	dropped := len(sub.queue)
+	sub.queue = nil
+	sub.running = false
+	d.m.Unlock()
+	if dropped > 0 {
+		d.log.Warn("service context closed, dropping queued notifications", log15.Ctx{
+			"projectID": projectID,
+			"dropped":   dropped,
+		})
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// Stats returns the queue metrics of the dispatcher
+func (d *NotificationDispatcher) Stats() NotificationStats {
+	d.m.Lock()
+	defer d.m.Unlock()
+	stats := NotificationStats{
+		Concurrency:   d.concurrency,
+		Subscriptions: make([]NotificationSubscriptionStats, 0, len(d.subs)),
+	}
+	for projectID, sub := range d.subs {
+		stats.Active += sub.active
+		stats.Queued += len(sub.queue)
+		stats.Subscriptions = append(stats.Subscriptions, NotificationSubscriptionStats{
+			ProjectID: projectID,
+			Queued:    len(sub.queue),
+			Active:    sub.active,
+			Delivered: sub.delivered,
+			Failed:    sub.failed,
+			Coalesced: sub.coalesced,
+			Timeouts:  sub.timeouts,
+			Digest:    sub.digest,
+		})
+	}
+	sort.Sort(subscriptionStatsByProject(stats.Subscriptions))
+	return stats
+}
+
+type subscriptionStatsByProject []NotificationSubscriptionStats
+
+func (s subscriptionStatsByProject) Len() int           { return len(s) }
+func (s subscriptionStatsByProject) Less(i, j int) bool { return s[i].ProjectID < s[j].ProjectID }
+func (s subscriptionStatsByProject) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
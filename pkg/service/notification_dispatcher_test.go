@@ -0,0 +1,172 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// deliveryRecorder records the deliveries of a dispatcher. Deliveries block
+// until they are released
+type deliveryRecorder struct {
+	m         sync.Mutex
+	active    int
+	maxActive int
+	delivered []string
+	digests   []bool
+
+	release chan error
+	started chan struct{}
+}
+
+func newDeliveryRecorder() *deliveryRecorder {
+	return &deliveryRecorder{
+		release: make(chan error),
+		started: make(chan struct{}, 100),
+	}
+}
+
+func (r *deliveryRecorder) deliver(name string) DeliverFunc {
+	return func(digest bool) error {
+		r.m.Lock()
+		r.active++
+		if r.active > r.maxActive {
+			r.maxActive = r.active
+		}
+		r.m.Unlock()
+		r.started <- struct{}{}
+		err := <-r.release
+		r.m.Lock()
+		r.active--
+		r.delivered = append(r.delivered, name)
+		r.digests = append(r.digests, digest)
+		r.m.Unlock()
+		return err
+	}
+}
+
+func (r *deliveryRecorder) waitStarted() {
+	select {
+	case <-r.started:
+	case <-time.After(time.Second):
+		panic("delivery not started")
+	}
+}
+
+func (r *deliveryRecorder) settled(d *NotificationDispatcher) {
+	for i := 0; i < 1000; i++ {
+		if d.Stats().Active == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNotificationDispatcher(t *testing.T) {
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+
+	Convey("Given a notification dispatcher", t, func() {
+		cfg := config.DefaultConfig()
+		cfg.Payment.Notification.DigestTimeouts = 2
+		bgCtx, cancel := context.WithCancel(context.Background())
+		Reset(cancel)
+		ctx, err := NewContext(bgCtx, cfg, log)
+		So(err, ShouldBeNil)
+		d := ctx.Notifications()
+		So(d.Timeout(), ShouldEqual, 30*time.Second)
+		So(d.RetryPolicy().MaxAttempts, ShouldEqual, 10)
+		So(d.RetryPolicy().Backoff, ShouldEqual, time.Minute)
+		r := newDeliveryRecorder()
+
+		Convey("When notifications are dispatched with a concurrency limit", func() {
+			limits := DeliveryLimits{Concurrency: 2}
+			for _, name := range []string{"a", "b", "c"} {
+				d.Dispatch(1, limits, &Delivery{Key: name, Deliver: r.deliver(name)})
+			}
+			r.waitStarted()
+			r.waitStarted()
+
+			Convey("It should not exceed the limit", func() {
+				stats := d.Stats()
+				So(stats.Active, ShouldEqual, 2)
+				So(stats.Queued, ShouldEqual, 1)
+				So(stats.Subscriptions[0].ProjectID, ShouldEqual, 1)
+
+				r.release <- nil
+				r.waitStarted()
+				r.release <- nil
+				r.release <- nil
+				r.settled(d)
+				So(r.maxActive, ShouldEqual, 2)
+				So(len(r.delivered), ShouldEqual, 3)
+				So(d.Stats().Subscriptions[0].Delivered, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the callback endpoint repeatedly times out", func() {
+			limits := DeliveryLimits{}
+			d.Dispatch(1, limits, &Delivery{Deliver: r.deliver("a")})
+			r.waitStarted()
+			r.release <- timeoutError{}
+			r.settled(d)
+			d.Dispatch(1, limits, &Delivery{Deliver: r.deliver("b")})
+			r.waitStarted()
+			r.release <- timeoutError{}
+			r.settled(d)
+
+			Convey("It should switch to digest mode", func() {
+				stats := d.Stats()
+				So(stats.Subscriptions[0].Timeouts, ShouldEqual, 2)
+				So(stats.Subscriptions[0].Digest, ShouldBeTrue)
+				So(stats.Subscriptions[0].Failed, ShouldEqual, 2)
+			})
+
+			Convey("When notifications of the same payment are queued", func() {
+				d.Dispatch(1, limits, &Delivery{Deliver: r.deliver("c")})
+				r.waitStarted()
+				discarded := make(chan struct{})
+				d.Dispatch(1, limits, &Delivery{Key: "payment:1", Deliver: r.deliver("d"), Discard: func() { close(discarded) }})
+				d.Dispatch(1, limits, &Delivery{Key: "payment:1", Deliver: r.deliver("e")})
+				d.Dispatch(1, limits, &Delivery{Key: "payment:2", Deliver: r.deliver("f")})
+
+				Convey("They should be merged and delivered one at a time", func() {
+					So(d.Stats().Queued, ShouldEqual, 2)
+					So(d.Stats().Subscriptions[0].Coalesced, ShouldEqual, 1)
+					var wasDiscarded bool
+					select {
+					case <-discarded:
+						wasDiscarded = true
+					case <-time.After(time.Second):
+					}
+					So(wasDiscarded, ShouldBeTrue)
+					r.release <- nil
+					r.waitStarted()
+					r.release <- nil
+					r.waitStarted()
+					r.release <- nil
+					r.settled(d)
+					So(r.maxActive, ShouldEqual, 1)
+					So(r.delivered, ShouldResemble, []string{"a", "b", "c", "e", "f"})
+					So(r.digests, ShouldResemble, []bool{false, false, true, true, false})
+
+					Convey("The endpoint should have recovered", func() {
+						So(d.Stats().Subscriptions[0].Digest, ShouldBeFalse)
+						So(d.Stats().Subscriptions[0].Timeouts, ShouldEqual, 0)
+					})
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,378 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	queuedRequestSuffix = ".json"
+	// queued requests which could not be replayed
+	failedRequestSuffix = ".failed"
+	tempRequestSuffix   = ".tmp"
+)
+
+// ErrQueueFull is returned if the maximum number of requests is queued
+var ErrQueueFull = errors.New("queue full")
+
+// QueuedRequest is a request queued on disk
+type QueuedRequest struct {
+	// Handler is the name of the handler which will replay the request
+	Handler    string
+	Received   time.Time
+	Method     string
+	URL        string
+	Header     http.Header
+	RemoteAddr string
+	Body       []byte
+}
+
+// OutageQueue durably queues requests on local disk while a write database
+// connection is reconnecting and replays them once the connection recovered
+//
+// It is meant for requests which must not be lost, but which can be processed
+// late, e.g. provider notifications and shoppers returning from a provider.
+// Queued requests are replayed in the order they were received with the
+// handler they were queued for.
+type OutageQueue struct {
+	ctx *Context
+	log log15.Logger
+
+	dir         string
+	maxRequests int
+
+	m         sync.Mutex
+	handlers  map[string]http.Handler
+	queued    int
+	seq       int64
+	replaying bool
+}
+
+func outageQueueFromConfig(ctx *Context, cfg config.Config) (*OutageQueue, error) {
+	q := &OutageQueue{
+		ctx: ctx,
+		log: ctx.Log().New(log15.Ctx{
+			"pkg":  "github.com/fritzpay/paymentd/pkg/service",
+			"type": "OutageQueue",
+		}),
+		dir:         cfg.Database.OutageQueue.Dir,
+		maxRequests: cfg.Database.OutageQueue.MaxRequests,
+		handlers:    make(map[string]http.Handler),
+	}
+	if q.dir == "" {
+		return q, nil
+	}
+	err := os.MkdirAll(q.dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	names, err := q.names()
+	if err != nil {
+		return nil, err
+	}
+	q.queued = len(names)
+	return q, nil
+}
+
+// Active returns true if requests will be queued
+func (q *OutageQueue) Active() bool {
+	return q != nil && q.dir != ""
+}
+
+// Len returns the number of queued requests
+func (q *OutageQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.queued
+}
+
+// names returns the file names of the queued requests in the order they were
+// received
+func (q *OutageQueue) names() ([]string, error) {
+	infos, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), queuedRequestSuffix) {
+			continue
+		}
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Enqueue writes the request to the queue
+//
+// The request will be replayed with the handler registered under the given
+// name. The request body will be consumed.
+func (q *OutageQueue) Enqueue(handler string, r *http.Request) error {
+	qr := &QueuedRequest{
+		Handler:    handler,
+		Received:   time.Now(),
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Header:     r.Header,
+		RemoteAddr: r.RemoteAddr,
+	}
+	if r.Body != nil {
+		var err error
+		qr.Body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	p, err := json.Marshal(qr)
+	if err != nil {
+		return err
+	}
+
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.maxRequests > 0 && q.queued >= q.maxRequests {
+		return ErrQueueFull
+	}
+	q.seq++
+	// zero padded, so the names sort in the order the requests were received
+	name := fmt.Sprintf("%020d-%010d", qr.Received.UnixNano(), q.seq)
+	tmp := filepath.Join(q.dir, name+tempRequestSuffix)
+	err = writeFileSync(tmp, p)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, filepath.Join(q.dir, name+queuedRequestSuffix))
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	q.queued++
+	return nil
+}
+
+// writeFileSync writes the file and syncs it to the disk
+func writeFileSync(name string, p []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(p)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Replay replays the queued requests
+//
+// Replaying stops if a write connection is reconnecting again. Requests
+// failing with a server error while the database is available and requests
+// without a registered handler will be set aside with the suffix ".failed"
+// and must be handled manually.
+func (q *OutageQueue) Replay() {
+	if !q.Active() {
+		return
+	}
+	q.m.Lock()
+	if q.replaying {
+		q.m.Unlock()
+		return
+	}
+	q.replaying = true
+	q.m.Unlock()
+	defer func() {
+		q.m.Lock()
+		q.replaying = false
+		q.m.Unlock()
+	}()
+
+	names, err := q.names()
+	if err != nil {
+		q.log.Error("error reading queue", log15.Ctx{"err": err})
+		return
+	}
+	for _, name := range names {
+		if !q.ctx.DBMonitor().Available() {
+			return
+		}
+		if !q.replay(name) {
+			return
+		}
+	}
+}
+
+// replay replays the request in the file with the given name
+//
+// It returns false if replaying should stop.
+func (q *OutageQueue) replay(name string) bool {
+	log := q.log.New(log15.Ctx{"file": name})
+	file := filepath.Join(q.dir, name)
+	p, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Error("error reading queued request", log15.Ctx{"err": err})
+		return false
+	}
+	qr := &QueuedRequest{}
+	err = json.Unmarshal(p, qr)
+	if err != nil {
+		log.Error("error decoding queued request", log15.Ctx{"err": err})
+		q.setAside(file, log)
+		return true
+	}
+	log = log.New(log15.Ctx{
+		"handler":  qr.Handler,
+		"received": qr.Received,
+	})
+	q.m.Lock()
+	h, ok := q.handlers[qr.Handler]
+	q.m.Unlock()
+	if !ok {
+		log.Error("no handler registered for queued request")
+		q.setAside(file, log)
+		return true
+	}
+	r, err := http.NewRequest(qr.Method, qr.URL, bytes.NewReader(qr.Body))
+	if err != nil {
+		log.Error("error creating request", log15.Ctx{"err": err})
+		q.setAside(file, log)
+		return true
+	}
+	r.Header = qr.Header
+	r.RemoteAddr = qr.RemoteAddr
+
+	status := serveReplay(h, r, log)
+	if status >= 500 {
+		if !q.ctx.DBMonitor().Available() {
+			return false
+		}
+		log.Error("replayed request failed", log15.Ctx{"status": status})
+		q.setAside(file, log)
+		return true
+	}
+	err = os.Remove(file)
+	if err != nil {
+		log.Crit("error removing replayed request", log15.Ctx{"err": err})
+		return false
+	}
+	q.m.Lock()
+	q.queued--
+	q.m.Unlock()
+	log.Info("replayed queued request", log15.Ctx{"status": status})
+	return true
+}
+
+// setAside renames the file of a request which could not be replayed
+func (q *OutageQueue) setAside(file string, log log15.Logger) {
+	err := os.Rename(file, strings.TrimSuffix(file, queuedRequestSuffix)+failedRequestSuffix)
+	if err != nil {
+		log.Crit("error setting aside queued request", log15.Ctx{"err": err})
+		return
+	}
+	q.m.Lock()
+	q.queued--
+	q.m.Unlock()
+}
+
+func serveReplay(h http.Handler, r *http.Request, log log15.Logger) (status int) {
+	w := &replayResponse{header: make(http.Header)}
+	defer func() {
+		if err := recover(); err != nil {
+			log.Crit("panic on replay", log15.Ctx{"err": err})
+			status = http.StatusInternalServerError
+		}
+	}()
+	h.ServeHTTP(w, r)
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// replayResponse discards the response to a replayed request
+type replayResponse struct {
+	header http.Header
+	status int
+}
+
+func (w *replayResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *replayResponse) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (w *replayResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// OutageQueueHandler wraps the given handler and queues its requests while a
+// write database connection is reconnecting
+//
+// Queued requests are answered with 202 Accepted and will be replayed with the
+// given handler once the connection recovered. While older requests are queued,
+// new requests will be queued as well, so they will be handled in order. If the
+// queue is full, requests will be shed like with the RateLimitHandler. If the
+// queue is not active, requests will be passed to the handler.
+//
+// The name identifies the handler when replaying. It must be unique and should
+// not change between releases.
+func (ctx *Context) OutageQueueHandler(name string, parent http.Handler) http.Handler {
+	q := ctx.outages
+	if q == nil {
+		return parent
+	}
+	q.m.Lock()
+	q.handlers[name] = parent
+	q.m.Unlock()
+	log := q.log.New(log15.Ctx{"handler": name})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ctx.dbMonitor.Available() && q.Len() == 0 {
+			parent.ServeHTTP(w, r)
+			return
+		}
+		if !q.Active() {
+			parent.ServeHTTP(w, r)
+			return
+		}
+		err := q.Enqueue(name, r)
+		if err != nil {
+			log.Crit("error queueing request", log15.Ctx{"err": err})
+			w.Header().Set("Retry-After", strconv.Itoa(int(ctx.dbMonitor.RetryAfter()/time.Second)))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		log.Warn("queued request during database outage")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "Your request was received and will be processed shortly.")
+	})
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestOutageQueue(t *testing.T) {
+	Convey("Given a context with an outage queue", t, func() {
+		dir, err := ioutil.TempDir("", "paymentd-outage-queue")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		cfg := config.DefaultConfig()
+		cfg.Database.OutageQueue.Dir = dir
+		ctx, err := NewContext(context.Background(), cfg, log15.New())
+		So(err, ShouldBeNil)
+		// sql.Open does not connect
+		write, err := sql.Open("mysql", "paymentd@tcp(localhost:3306)/fritzpay_payment")
+		So(err, ShouldBeNil)
+		ctx.SetPaymentDB(write, nil)
+		m := ctx.DBMonitor()
+		q := ctx.OutageQueue()
+		So(q.Active(), ShouldBeTrue)
+
+		var served []string
+		h := ctx.OutageQueueHandler("test/callback", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			served = append(served, r.URL.Query().Get("status")+" "+string(body))
+		}))
+		request := func(status string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r, err := http.NewRequest("POST", "/callback?status="+status, strings.NewReader("body "+status))
+			So(err, ShouldBeNil)
+			h.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("When the database is available", func() {
+			w := request("success")
+
+			Convey("The request should be served", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(len(served), ShouldEqual, 1)
+				So(q.Len(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the write connection fails", func() {
+			m.observe(m.dbs[0], errors.New("connection refused"))
+			w1 := request("pending")
+			w2 := request("success")
+
+			Convey("The requests should be queued on disk", func() {
+				So(w1.Code, ShouldEqual, http.StatusAccepted)
+				So(w2.Code, ShouldEqual, http.StatusAccepted)
+				So(len(served), ShouldEqual, 0)
+				So(q.Len(), ShouldEqual, 2)
+				files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+				So(err, ShouldBeNil)
+				So(len(files), ShouldEqual, 2)
+			})
+
+			Convey("When replaying during the outage", func() {
+				q.Replay()
+
+				Convey("The requests should stay queued", func() {
+					So(len(served), ShouldEqual, 0)
+					So(q.Len(), ShouldEqual, 2)
+				})
+			})
+
+			Convey("When the connection recovers", func() {
+				m.observe(m.dbs[0], nil)
+
+				Convey("New requests should be queued behind the older ones", func() {
+					w := request("refunded")
+					So(w.Code, ShouldEqual, http.StatusAccepted)
+					So(q.Len(), ShouldEqual, 3)
+				})
+
+				Convey("When replaying", func() {
+					q.Replay()
+
+					Convey("The requests should be replayed in order", func() {
+						So(served, ShouldResemble, []string{"pending body pending", "success body success"})
+						So(q.Len(), ShouldEqual, 0)
+						files, err := filepath.Glob(filepath.Join(dir, "*"))
+						So(err, ShouldBeNil)
+						So(len(files), ShouldEqual, 0)
+					})
+				})
+			})
+
+			Convey("When a new context is created with the same directory", func() {
+				ctx2, err := NewContext(context.Background(), cfg, log15.New())
+				So(err, ShouldBeNil)
+
+				Convey("It should recover the queued requests", func() {
+					So(ctx2.OutageQueue().Len(), ShouldEqual, 2)
+				})
+			})
+		})
+
+		Convey("When a queued request has no registered handler", func() {
+			m.observe(m.dbs[0], errors.New("connection refused"))
+			r, err := http.NewRequest("GET", "/unknown", nil)
+			So(err, ShouldBeNil)
+			So(q.Enqueue("test/unknown", r), ShouldBeNil)
+			m.observe(m.dbs[0], nil)
+			q.Replay()
+
+			Convey("It should be set aside", func() {
+				So(q.Len(), ShouldEqual, 0)
+				files, err := filepath.Glob(filepath.Join(dir, "*.failed"))
+				So(err, ShouldBeNil)
+				So(len(files), ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,322 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// AuthorizationAmount returns the amount which should be authorized for the
+// payment
+//
+// It is the payment amount increased by the authorization buffer of the
+// project.
+func (s *Service) AuthorizationAmount(p *payment.Payment) (int64, error) {
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		s.log.Error("error retrieving project", log15.Ctx{
+			"method":    "AuthorizationAmount",
+			"projectID": p.ProjectID(),
+			"err":       err,
+		})
+		return 0, wrapError(ErrDB, "AuthorizationAmount", err)
+	}
+	return p.BufferedAmount(pr.Config.AuthorizationBufferPercent()), nil
+}
+
+// SetPaymentAuthorization records the authorized amount (in the subunits of the
+// payment) of an authorized payment
+func (s *Service) SetPaymentAuthorization(tx *sql.Tx, p *payment.Payment, amount int64) error {
+	p.NewAuthorization(amount)
+	err := payment.InsertPaymentAuthorizationTx(s.ctx, tx, p)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "SetPaymentAuthorization", err)
+		}
+		s.log.Error("error saving payment authorization", log15.Ctx{
+			"method": "SetPaymentAuthorization",
+			"err":    err,
+		})
+		return wrapError(ErrDB, "SetPaymentAuthorization", err)
+	}
+	return nil
+}
+
+// captureAmount converts the amount to capture into the subunits of the payment
+//
+// It returns an ErrCaptureAmount if the amount is not positive, has more
+// decimal places than the payment or exceeds the authorized amount. The
+// authorization of the payment must be loaded.
+func (s *Service) captureAmount(p *payment.Payment, amount *decimal.Decimal) (int64, error) {
+	units, ok := amountUnits(p, amount)
+	if !ok || units > p.AuthorizedAmount() {
+		return 0, ErrCaptureAmount
+	}
+	return units, nil
+}
+
+// amountUnits converts the amount into the subunits of the payment
+//
+// It returns false if the amount is not positive or has more decimal places
+// than the payment.
+func amountUnits(p *payment.Payment, amount *decimal.Decimal) (int64, bool) {
+	if amount.Sign() <= 0 {
+		return 0, false
+	}
+	units := &decimal.Decimal{}
+	units.Round(&amount.Dec, dec.Scale(p.Subunits), dec.RoundDown)
+	if units.Cmp(&amount.Dec) != 0 {
+		return 0, false
+	}
+	return units.Unscaled().Int64(), true
+}
+
+// AuthorizationIncrement checks whether the authorization of the payment can be
+// incremented by the amount and returns the increment in the subunits of the
+// payment
+//
+// Only authorized payments can be incremented. The authorization of the payment
+// will be loaded if it is not present.
+func (s *Service) AuthorizationIncrement(p *payment.Payment, amount *decimal.Decimal) (int64, error) {
+	if p.Status != payment.PaymentStatusAuthorized {
+		return 0, ErrIntentNotAllowed
+	}
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return 0, err
+	}
+	if meth.Disabled() {
+		return 0, ErrPaymentMethodDisabled
+	}
+	if p.Authorization == nil {
+		err = payment.PaymentAuthorizationDB(s.ctx, s.ctx.ProjectPaymentDB(p.ProjectID()), p)
+		if err != nil {
+			s.log.Error("error retrieving payment authorization", log15.Ctx{
+				"method": "AuthorizationIncrement",
+				"err":    err,
+			})
+			return 0, wrapError(ErrDB, "AuthorizationIncrement", err)
+		}
+	}
+	increment, ok := amountUnits(p, amount)
+	if !ok {
+		return 0, ErrAuthorizationIncrement
+	}
+	return increment, nil
+}
+
+// capturable returns true if payments with the given status can be captured
+func capturable(status payment.PaymentTransactionStatus) bool {
+	return status == payment.PaymentStatusAuthorized || status == payment.PaymentStatusPartiallyCaptured
+}
+
+// capturedUnits returns the amount (in the subunits of the payment) which was
+// captured according to the ledger of the payment
+func capturedUnits(p *payment.Payment, txs payment.PaymentTransactionList) int64 {
+	var units int64
+	for _, tx := range txs {
+		if tx.Currency != p.Currency || tx.Amount <= 0 {
+			continue
+		}
+		switch tx.Status {
+		case payment.PaymentStatusPartiallyCaptured, payment.PaymentStatusPaid:
+			units += tx.Amount
+		}
+	}
+	return units
+}
+
+// CapturedAmount returns the amount which was captured on the payment
+//
+// It is calculated from the ledger of the payment.
+func (s *Service) CapturedAmount(db *sql.DB, p *payment.Payment) (*decimal.Decimal, error) {
+	txs, err := payment.PaymentTransactionsBeforeTimestampDB(s.ctx, db, p, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return unitsDecimal(capturedUnits(p, txs), p.Subunits), nil
+}
+
+// newCaptureTransaction creates the capture transaction for the payment
+//
+// The captured amounts must not exceed the authorized amount in total. The
+// payment will be paid if the capture is final or the captured amounts reach
+// the payment amount. Otherwise it will be partially captured and can be
+// captured further. The authorization of the payment must be loaded.
+func (s *Service) newCaptureTransaction(p *payment.Payment, txs payment.PaymentTransactionList, amount *decimal.Decimal, final bool) (*payment.PaymentTransaction, error) {
+	units, err := s.captureAmount(p, amount)
+	if err != nil {
+		return nil, err
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusPartiallyCaptured)
+	paymentTx.Amount = units
+	err = setCaptureStatus(p, paymentTx, capturedUnits(p, txs), final)
+	if err != nil {
+		return nil, err
+	}
+	return paymentTx, nil
+}
+
+// setCaptureStatus sets the status and the comment of the capture transaction
+// given the amount captured before
+//
+// It returns ErrCaptureAmount if the captured amounts would exceed the
+// authorized amount in total.
+func setCaptureStatus(p *payment.Payment, paymentTx *payment.PaymentTransaction, captured int64, final bool) error {
+	total := captured + paymentTx.Amount
+	if total > p.AuthorizedAmount() {
+		return ErrCaptureAmount
+	}
+	if final || total >= p.Amount {
+		paymentTx.Status = payment.PaymentStatusPaid
+	} else {
+		paymentTx.Status = payment.PaymentStatusPartiallyCaptured
+	}
+	paymentTx.Comment = sql.NullString{}
+	switch {
+	case captured > 0:
+		paymentTx.Comment.String, paymentTx.Comment.Valid = fmt.Sprintf("captured %s, total %s of %s %s",
+			paymentTx.Decimal(), unitsDecimal(total, p.Subunits), p.Decimal(), p.Currency), true
+	case paymentTx.Amount != p.Amount:
+		paymentTx.Comment.String, paymentTx.Comment.Valid = fmt.Sprintf("captured %s of %s %s",
+			paymentTx.Decimal(), p.Decimal(), p.Currency), true
+	}
+	return nil
+}
+
+// LockCaptureTx locks the current transaction of the payment and checks the
+// capture against the captures recorded since the intent
+//
+// The lock is held until the transaction ends, so that concurrent captures of
+// the payment are serialized. Writes referencing the payment, like records of
+// the provider requests, are not blocked. The capture transaction must be saved with the
+// same transaction. Its status and comment will be updated to the captures
+// recorded meanwhile. It returns ErrCaptureAmount if the captured amounts
+// would exceed the authorized amount in total and ErrIntentNotAllowed if the
+// payment cannot be captured anymore.
+func (s *Service) LockCaptureTx(tx *sql.Tx, paymentTx *payment.PaymentTransaction) error {
+	p := paymentTx.Payment
+	log := s.log.New(log15.Ctx{
+		"method":    "LockCaptureTx",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	// the status of the payment of the intent stays unchanged
+	locked := *p
+	current, err := payment.PaymentTransactionCurrentForUpdateTx(s.ctx, tx, &locked)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "LockCaptureTx", err)
+		}
+		log.Error("error locking payment", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "LockCaptureTx", err)
+	}
+	if !capturable(current.Status) {
+		return ErrIntentNotAllowed
+	}
+	txs, err := payment.PaymentTransactionsBeforeTimestampTx(s.ctx, tx, p, time.Now())
+	if err != nil {
+		log.Error("error retrieving payment transactions", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "LockCaptureTx", err)
+	}
+	// a final or completing capture stays final
+	return setCaptureStatus(p, paymentTx, capturedUnits(p, txs), paymentTx.Status == payment.PaymentStatusPaid)
+}
+
+// IntentCapture captures the amount of an authorized payment
+//
+// Payments can be captured repeatedly. The payment will be partially captured
+// until the captured amounts reach the payment amount. In total, the captured
+// amounts may exceed the payment amount up to the authorized amount. The
+// authorization of the payment will be loaded if it is not present.
+//
+// The capture must be checked again with LockCaptureTx in the transaction
+// which saves it, before it is submitted to the provider.
+func (s *Service) IntentCapture(ctx context.Context, p *payment.Payment, amount *decimal.Decimal, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	return s.intentCapture(ctx, p, amount, false, timeout)
+}
+
+// IntentFinalCapture captures the amount of an authorized payment and
+// completes the capture
+//
+// The payment will be paid, even if the captured amounts are less than the
+// payment amount. The remainder of the authorization is released.
+func (s *Service) IntentFinalCapture(ctx context.Context, p *payment.Payment, amount *decimal.Decimal, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	return s.intentCapture(ctx, p, amount, true, timeout)
+}
+
+func (s *Service) intentCapture(ctx context.Context, p *payment.Payment, amount *decimal.Decimal, final bool, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	var intent payment.PaymentTransactionStatus = payment.PaymentStatusPaid
+	if !final {
+		intent = payment.PaymentStatusPartiallyCaptured
+	}
+	if !capturable(p.Status) {
+		return s.rejectIntent(p, intent, ErrIntentNotAllowed)
+	}
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meth.Disabled() {
+		return s.rejectIntent(p, intent, ErrPaymentMethodDisabled)
+	}
+	if p.Authorization == nil {
+		err = payment.PaymentAuthorizationDB(ctx, s.ctx.ProjectPaymentDB(p.ProjectID()), p)
+		if err != nil {
+			s.log.Error("error retrieving payment authorization", log15.Ctx{
+				"method": "IntentCapture",
+				"err":    err,
+			})
+			return nil, nil, wrapError(ErrDB, "IntentCapture", err)
+		}
+	}
+	// prior captures must be visible, so the write connection is used. The
+	// captures will be checked again under lock by LockCaptureTx
+	txs, err := payment.PaymentTransactionsBeforeTimestampDB(ctx, s.ctx.ProjectPaymentDB(p.ProjectID()), p, time.Now())
+	if err != nil {
+		s.log.Error("error retrieving payment transactions", log15.Ctx{
+			"method":    "IntentCapture",
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+			"err":       err,
+		})
+		return nil, nil, wrapError(ErrDB, "IntentCapture", err)
+	}
+	paymentTx, err := s.newCaptureTransaction(p, txs, amount, final)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.handleIntent(ctx, p, paymentTx, timeout)
+}
+
+// IntentVoid voids the authorization of an authorized payment
+//
+// The authorization is released without capturing, e.g. when the merchant
+// cannot fulfill the order. Unlike cancelling, which is the decision of the
+// customer before the payment is processed, voiding is the decision of the
+// merchant after the payment was authorized. Partially captured payments
+// cannot be voided, they are completed with a final capture.
+func (s *Service) IntentVoid(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if p.Status != payment.PaymentStatusAuthorized {
+		return s.rejectIntent(p, payment.PaymentStatusVoided, ErrIntentNotAllowed)
+	}
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meth.Disabled() {
+		return s.rejectIntent(p, payment.PaymentStatusVoided, ErrPaymentMethodDisabled)
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusVoided)
+	paymentTx.Amount = 0
+	return s.handleIntent(ctx, p, paymentTx, timeout)
+}
@@ -0,0 +1,198 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestCaptureAmount(t *testing.T) {
+	Convey("Given a payment service", t, func() {
+		s := &Service{}
+		Convey("Given a payment of 100.00 EUR authorized for 115.00 EUR", func() {
+			p := &payment.Payment{
+				Amount:   10000,
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusAuthorized,
+			}
+			p.NewAuthorization(11500)
+
+			Convey("When capturing the payment amount", func() {
+				amount, err := s.captureAmount(p, decimalString("100"))
+				Convey("It should capture the payment amount", func() {
+					So(err, ShouldBeNil)
+					So(amount, ShouldEqual, 10000)
+				})
+			})
+			Convey("When capturing up to the authorized amount", func() {
+				amount, err := s.captureAmount(p, decimalString("115.00"))
+				Convey("It should capture the amount", func() {
+					So(err, ShouldBeNil)
+					So(amount, ShouldEqual, 11500)
+				})
+			})
+			Convey("When capturing more than the authorized amount", func() {
+				_, err := s.captureAmount(p, decimalString("115.01"))
+				Convey("It should fail", func() {
+					So(err, ShouldEqual, ErrCaptureAmount)
+				})
+			})
+			Convey("When capturing an amount with too many decimal places", func() {
+				_, err := s.captureAmount(p, decimalString("99.999"))
+				Convey("It should fail", func() {
+					So(err, ShouldEqual, ErrCaptureAmount)
+				})
+			})
+			Convey("When capturing a zero amount", func() {
+				_, err := s.captureAmount(p, decimalString("0"))
+				Convey("It should fail", func() {
+					So(err, ShouldEqual, ErrCaptureAmount)
+				})
+			})
+		})
+	})
+}
+
+func TestCaptureTransaction(t *testing.T) {
+	Convey("Given a payment service", t, func() {
+		s := &Service{}
+		Convey("Given a payment of 100.00 EUR authorized for 115.00 EUR", func() {
+			p := &payment.Payment{
+				Amount:   10000,
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusAuthorized,
+			}
+			p.NewAuthorization(11500)
+			txs := payment.PaymentTransactionList{
+				{Amount: -10000, Subunits: 2, Currency: "EUR", Status: payment.PaymentStatusOpen},
+				{Amount: 0, Subunits: 2, Currency: "EUR", Status: payment.PaymentStatusAuthorized},
+			}
+
+			Convey("When capturing a part of the payment", func() {
+				paymentTx, err := s.newCaptureTransaction(p, txs, decimalString("30.00"), false)
+				Convey("It should be partially captured", func() {
+					So(err, ShouldBeNil)
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPartiallyCaptured)
+					So(paymentTx.Amount, ShouldEqual, 3000)
+					So(paymentTx.Comment.String, ShouldEqual, "captured 30.00 of 100.00 EUR")
+				})
+
+				Convey("When captures were recorded concurrently", func() {
+					Convey("It should be updated to the captured total", func() {
+						err = setCaptureStatus(p, paymentTx, 7000, false)
+						So(err, ShouldBeNil)
+						So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPaid)
+						So(paymentTx.Comment.String, ShouldEqual, "captured 30.00, total 100.00 of 100.00 EUR")
+					})
+					Convey("It should fail if the authorized amount would be exceeded", func() {
+						So(setCaptureStatus(p, paymentTx, 9000, false), ShouldEqual, ErrCaptureAmount)
+					})
+				})
+			})
+
+			Convey("When capturing a part of the payment finally", func() {
+				paymentTx, err := s.newCaptureTransaction(p, txs, decimalString("30.00"), true)
+				Convey("It should be paid", func() {
+					So(err, ShouldBeNil)
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPaid)
+					So(paymentTx.Amount, ShouldEqual, 3000)
+				})
+			})
+
+			Convey("Given a prior partial capture", func() {
+				txs = append(txs, &payment.PaymentTransaction{Amount: 6000, Subunits: 2, Currency: "EUR", Status: payment.PaymentStatusPartiallyCaptured})
+				p.Status = payment.PaymentStatusPartiallyCaptured
+
+				Convey("The captured amount should be taken from the ledger", func() {
+					So(capturedUnits(p, txs), ShouldEqual, 6000)
+				})
+
+				Convey("When capturing the rest of the payment", func() {
+					paymentTx, err := s.newCaptureTransaction(p, txs, decimalString("40.00"), false)
+					Convey("It should be paid", func() {
+						So(err, ShouldBeNil)
+						So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPaid)
+						So(paymentTx.Amount, ShouldEqual, 4000)
+						So(paymentTx.Comment.String, ShouldEqual, "captured 40.00, total 100.00 of 100.00 EUR")
+					})
+				})
+
+				Convey("When capturing up to the authorized amount", func() {
+					paymentTx, err := s.newCaptureTransaction(p, txs, decimalString("55.00"), false)
+					Convey("It should be paid", func() {
+						So(err, ShouldBeNil)
+						So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPaid)
+						So(paymentTx.Amount, ShouldEqual, 5500)
+					})
+				})
+
+				Convey("When capturing more than the authorized amount in total", func() {
+					_, err := s.newCaptureTransaction(p, txs, decimalString("55.01"), false)
+					Convey("It should fail", func() {
+						So(err, ShouldEqual, ErrCaptureAmount)
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestAmountUnits(t *testing.T) {
+	Convey("Given a payment in EUR", t, func() {
+		p := &payment.Payment{
+			Subunits: 2,
+			Currency: "EUR",
+		}
+		Convey("When converting an amount", func() {
+			units, ok := amountUnits(p, decimalString("20.5"))
+			Convey("It should be converted to the subunits", func() {
+				So(ok, ShouldBeTrue)
+				So(units, ShouldEqual, 2050)
+			})
+		})
+		Convey("When converting a negative amount", func() {
+			_, ok := amountUnits(p, decimalString("-1"))
+			Convey("It should fail", func() {
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestIntentVoid(t *testing.T) {
+	Convey("Given a payment service", t, func() {
+		s := &Service{}
+		Convey("Given a paid payment", func() {
+			p := &payment.Payment{
+				Amount:   10000,
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusPaid,
+			}
+			Convey("When voiding the payment", func() {
+				_, _, err := s.IntentVoid(context.Background(), p, 0)
+				Convey("It should be rejected", func() {
+					So(err, ShouldEqual, ErrIntentNotAllowed)
+				})
+			})
+		})
+		Convey("Given a partially captured payment", func() {
+			p := &payment.Payment{
+				Amount:   10000,
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusPartiallyCaptured,
+			}
+			Convey("When voiding the payment", func() {
+				_, _, err := s.IntentVoid(context.Background(), p, 0)
+				Convey("It should be rejected", func() {
+					So(err, ShouldEqual, ErrIntentNotAllowed)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,354 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/batch"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Intents which can be applied to many payments at once
+const (
+	BatchIntentCancel     = "cancel"
+	BatchIntentPaid       = "paid"
+	BatchIntentAuthorized = "authorized"
+	BatchIntentFailed     = "failed"
+	BatchIntentVoid       = "void"
+	BatchIntentExpire     = "expire"
+)
+
+const (
+	// BatchMaxPayments is the maximum number of payments of a batch
+	BatchMaxPayments   = 10000
+	batchIntentTimeout = 500 * time.Millisecond
+)
+
+var (
+	// ErrBatchIntent is returned when starting a batch with an unknown intent
+	ErrBatchIntent = errors.New("invalid batch intent")
+	// ErrBatchSize is returned when starting a batch without payments or with
+	// more than BatchMaxPayments payments
+	ErrBatchSize = errors.New("invalid batch size")
+)
+
+type intentFunc func(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error)
+
+func (s *Service) batchIntentFunc(intent string) (intentFunc, bool) {
+	switch intent {
+	case BatchIntentCancel:
+		return s.IntentCancel, true
+	case BatchIntentPaid:
+		return s.IntentPaid, true
+	case BatchIntentAuthorized:
+		return s.IntentAuthorized, true
+	case BatchIntentFailed:
+		return s.IntentFailed, true
+	case BatchIntentVoid:
+		return s.IntentVoid, true
+	case BatchIntentExpire:
+		return s.IntentExpired, true
+	default:
+		return nil, false
+	}
+}
+
+// StartBatch saves the batch of the intent for the given payments and enqueues
+// the batch job, which applies the intent in the background
+//
+// The progress of the returned batch can be retrieved with Batch and
+// BatchResults. If the job cannot be enqueued, the batch will be run on the next
+// schedule of the job.
+func (s *Service) StartBatch(intent string, ids []payment.PaymentID, createdBy, comment string) (*batch.Batch, error) {
+	if _, ok := s.batchIntentFunc(intent); !ok {
+		return nil, ErrBatchIntent
+	}
+	if len(ids) == 0 || len(ids) > BatchMaxPayments {
+		return nil, ErrBatchSize
+	}
+	log := s.log.New(log15.Ctx{
+		"method":    "StartBatch",
+		"intent":    intent,
+		"createdBy": createdBy,
+	})
+	b := &batch.Batch{
+		Created:   time.Now(),
+		Intent:    intent,
+		Comment:   comment,
+		CreatedBy: createdBy,
+	}
+	var tx *sql.Tx
+	var commit bool
+	var err error
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = s.ctx.PaymentDB().Begin()
+	if err != nil {
+		log.Crit("error on begin tx", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "StartBatch", err)
+	}
+	err = batch.InsertBatchTx(tx, b, ids)
+	if err != nil {
+		log.Error("error saving batch", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "StartBatch", err)
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "StartBatch", err)
+	}
+	err = s.ctx.Jobs().Enqueue(JobPaymentBatch)
+	if err != nil {
+		log.Warn("error enqueuing batch job. batch will run on schedule", log15.Ctx{
+			"batchID": b.ID,
+			"err":     err,
+		})
+	}
+	return b, nil
+}
+
+// Batch returns the batch with the given ID including its progress
+func (s *Service) Batch(id int64) (*batch.Batch, error) {
+	b, err := batch.BatchByIDDB(s.ctx.PaymentDB(service.ReadOnly), id)
+	if err != nil && err != batch.ErrBatchNotFound {
+		return nil, wrapError(ErrDB, "Batch", err)
+	}
+	return b, err
+}
+
+// BatchResults returns the results of the processed payments of the batch in
+// processing order
+func (s *Service) BatchResults(id int64) ([]*batch.Result, error) {
+	results, err := batch.ResultsDB(s.ctx.PaymentDB(service.ReadOnly), id)
+	if err != nil {
+		return nil, wrapError(ErrDB, "BatchResults", err)
+	}
+	return results, nil
+}
+
+// runBatches processes the payments of the unfinished batches
+//
+// Batches started while processing will be run by the same job run. If the
+// service shuts down, the remaining payments will be processed by the next run.
+func (s *Service) runBatches(done <-chan struct{}) error {
+	for {
+		ids, err := batch.UnfinishedBatchIDsDB(s.ctx.PaymentDB())
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		for _, id := range ids {
+			err = s.runBatch(done, id)
+			if err != nil {
+				return err
+			}
+			select {
+			case <-done:
+				return nil
+			default:
+			}
+		}
+	}
+}
+
+func (s *Service) runBatch(done <-chan struct{}, id int64) error {
+	b, err := batch.BatchByIDDB(s.ctx.PaymentDB(), id)
+	if err != nil {
+		return err
+	}
+	log := s.log.New(log15.Ctx{
+		"method":    "runBatch",
+		"batchID":   b.ID,
+		"intent":    b.Intent,
+		"createdBy": b.CreatedBy,
+	})
+	log.Info("running batch...", log15.Ctx{
+		"total":     b.Total,
+		"processed": b.Processed,
+	})
+	for {
+		select {
+		case <-done:
+			log.Warn("service shutdown. batch will be resumed")
+			return nil
+		default:
+		}
+		finished, err := s.processBatchPayment(b, log)
+		if err != nil {
+			return err
+		}
+		if finished {
+			return nil
+		}
+	}
+}
+
+// processBatchPayment applies the intent of the batch to its next payment and
+// saves the result
+//
+// It marks the batch finished and returns true if all payments were processed.
+func (s *Service) processBatchPayment(b *batch.Batch, log log15.Logger) (bool, error) {
+	var tx *sql.Tx
+	var commit bool
+	var err error
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = s.ctx.PaymentDB().Begin()
+	if err != nil {
+		log.Crit("error on begin tx", log15.Ctx{"err": err})
+		return false, err
+	}
+	res, err := batch.NextPaymentTx(tx, b.ID)
+	if err == batch.ErrNoPayment {
+		err = batch.FinishBatchTx(tx, b.ID, time.Now())
+		if err != nil {
+			log.Error("error finishing batch", log15.Ctx{"err": err})
+			return false, err
+		}
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			return false, err
+		}
+		log.Info("batch finished", log15.Ctx{"total": b.Total})
+		return true, nil
+	}
+	if err != nil {
+		log.Error("error retrieving batch payment", log15.Ctx{"err": err})
+		return false, err
+	}
+	res.SetResult(s.applyBatchIntent(b, res.PaymentID))
+	err = batch.SetResultTx(tx, b.ID, res)
+	if err != nil {
+		log.Error("error saving batch result", log15.Ctx{"err": err})
+		return false, err
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		return false, err
+	}
+	return false, nil
+}
+
+// applyBatchIntent applies the intent of the batch to the payment and returns
+// the resulting status
+func (s *Service) applyBatchIntent(b *batch.Batch, id payment.PaymentID) (payment.PaymentTransactionStatus, error) {
+	comment := "batch " + b.Intent
+	if b.Comment != "" {
+		comment += ": " + b.Comment
+	}
+	return s.ApplyIntent(id, b.Intent, comment)
+}
+
+// ApplyIntent applies one of the batch intents to the payment and returns the
+// resulting status
+//
+// The intent is applied as if it was requested for the payment alone, i.e. the
+// project will be notified of the change. The comment will be added to the
+// payment transaction. If the intent is not allowed, the unchanged status of the
+// payment is returned with the error.
+func (s *Service) ApplyIntent(id payment.PaymentID, intentName, comment string) (payment.PaymentTransactionStatus, error) {
+	intent, ok := s.batchIntentFunc(intentName)
+	if !ok {
+		return "", ErrBatchIntent
+	}
+	return s.applyIntent(id, intentName, intent, comment)
+}
+
+func (s *Service) applyIntent(id payment.PaymentID, intentName string, intent intentFunc, comment string) (payment.PaymentTransactionStatus, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "ApplyIntent",
+		"intent":    intentName,
+		"projectID": id.ProjectID,
+		"paymentID": id.PaymentID,
+	})
+	var tx *sql.Tx
+	var commit bool
+	var err error
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	maxRetries := s.ctx.Config().Database.TransactionMaxRetries
+	var retries int
+beginTx:
+	if retries >= maxRetries {
+		// no need to roll back
+		commit = true
+		return "", ErrDBLockTimeout
+	}
+	tx, err = s.ctx.ProjectPaymentDB(id.ProjectID).Begin()
+	if err != nil {
+		commit = true
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		return "", wrapError(ErrDB, "ApplyIntent", err)
+	}
+	p, err := payment.PaymentByIDTx(s.ctx, tx, id)
+	if err != nil {
+		if err != payment.ErrPaymentNotFound {
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			err = wrapError(ErrDB, "ApplyIntent", err)
+		}
+		return "", err
+	}
+	paymentTx, commitIntent, err := intent(s.ctx, p, batchIntentTimeout)
+	if err != nil {
+		return p.Status, err
+	}
+	paymentTx.Comment.String, paymentTx.Comment.Valid = comment, true
+	err = s.SetPaymentTransaction(tx, paymentTx)
+	if err != nil {
+		if errors.Is(err, ErrDBLockTimeout) {
+			retries++
+			time.Sleep(time.Second)
+			goto beginTx
+		}
+		return p.Status, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			retries++
+			time.Sleep(time.Second)
+			goto beginTx
+		}
+		commit = true
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		return p.Status, wrapError(ErrDB, "ApplyIntent", err)
+	}
+	commit = true
+	if commitIntent != nil {
+		err = commitIntent()
+		if err != nil {
+			log.Error("error committing intent", log15.Ctx{"err": err})
+		}
+	}
+	return paymentTx.Status, nil
+}
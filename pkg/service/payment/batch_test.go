@@ -0,0 +1,48 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatch(t *testing.T) {
+	Convey("Given a payment service", t, func() {
+		s := &Service{}
+
+		Convey("When starting a batch with an unknown intent", func() {
+			_, err := s.StartBatch("refund", []payment.PaymentID{{ProjectID: 1, PaymentID: 1}}, "admin", "")
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrBatchIntent)
+			})
+		})
+
+		Convey("The void intent should be a valid batch intent", func() {
+			_, ok := s.batchIntentFunc(BatchIntentVoid)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("The expire intent should be a valid batch intent", func() {
+			_, ok := s.batchIntentFunc(BatchIntentExpire)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("When starting a batch without payments", func() {
+			_, err := s.StartBatch(BatchIntentCancel, nil, "admin", "")
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrBatchSize)
+			})
+		})
+
+		Convey("When starting a batch with too many payments", func() {
+			_, err := s.StartBatch(BatchIntentCancel, make([]payment.PaymentID, BatchMaxPayments+1), "admin", "")
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrBatchSize)
+			})
+		})
+	})
+}
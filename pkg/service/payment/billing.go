@@ -0,0 +1,53 @@
+package payment
+
+import (
+	"database/sql"
+
+	"github.com/fritzpay/paymentd/pkg/billing"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// CheckoutFields returns the billing fields of the project which will be
+// collected in the checkout of the payment
+//
+// Only fields applying to the payment country are returned.
+func (s *Service) CheckoutFields(p *payment.Payment) (billing.Fields, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "CheckoutFields",
+		"projectID": p.ProjectID(),
+	})
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		log.Error("error retrieving project", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "CheckoutFields", err)
+	}
+	fields, err := pr.Config.PaymentCheckoutFields()
+	if err != nil {
+		log.Error("invalid checkout fields", log15.Ctx{"err": err})
+		return nil, wrapError(ErrInternal, "CheckoutFields", err)
+	}
+	return fields.For(p.Config.Country.String), nil
+}
+
+// SetPaymentBilling records the billing data collected in the checkout of the
+// payment
+func (s *Service) SetPaymentBilling(tx *sql.Tx, p *payment.Payment) error {
+	log := s.log.New(log15.Ctx{
+		"method":    "SetPaymentBilling",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	err := payment.InsertPaymentBillingTx(s.ctx, tx, p)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "SetPaymentBilling", err)
+		}
+		log.Error("error saving payment billing", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "SetPaymentBilling", err)
+	}
+	return nil
+}
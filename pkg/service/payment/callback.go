@@ -1,6 +1,7 @@
 package payment
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
@@ -12,6 +13,30 @@ import (
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
+// SignatureHeader is the header of callback requests, which holds the signature
+// of the notification with the ID of the signing key, e.g.
+//
+//	keyId=<callback project key>,signature=<hex encoded signature>
+//
+// During the overlap window after a change of the callback project key, the
+// header is sent twice, with the signatures of the current and the previous
+// key.
+const SignatureHeader = "X-Paymentd-Signature"
+
+// DigestHeader is set on callback requests while the callback endpoint is in
+// digest mode
+//
+// The endpoint was switched to digest mode because it regularly timed out or
+// too many notifications were queued for it. Until it recovers, notifications
+// are delivered one at a time and only the latest notification of a payment
+// will be delivered.
+const DigestHeader = "X-Paymentd-Digest"
+
+// keySigner is a signed notification which can be signed with other keys
+type keySigner interface {
+	KeySignature(secret []byte, canonical bool) (string, error)
+}
+
 // Callbacker describes a type that can provide information about callbacks to be made
 type Callbacker interface {
 	HasCallback() bool
@@ -30,32 +55,56 @@ func (s *Service) notify(paymentTx *payment.PaymentTransaction) error {
 		"projectID": paymentTx.Payment.ProjectID(),
 		"paymentID": paymentTx.Payment.ID(),
 	})
-	var callback Callbacker
-	if CanCallback(&paymentTx.Payment.Config) {
-		callback = &paymentTx.Payment.Config
-	} else {
-		pr, err := project.ProjectByIDDB(s.ctx.PrincipalDB(service.ReadOnly), paymentTx.Payment.ProjectID())
-		if err != nil {
-			if err == project.ErrProjectNotFound {
-				log.Crit("payment with invalid project", log15.Ctx{"projectID": paymentTx.Payment.ProjectID()})
-				return ErrInternal
-			}
-			log.Error("error retrieving project", log15.Ctx{"err": err})
-			return ErrDB
-		}
-		if CanCallback(pr.Config) {
-			callback = pr.Config
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), paymentTx.Payment.ProjectID())
+	if err != nil {
+		if err == project.ErrProjectNotFound {
+			log.Crit("payment with invalid project", log15.Ctx{"projectID": paymentTx.Payment.ProjectID()})
+			return wrapError(ErrInternal, "notify", err)
 		}
+		log.Error("error retrieving project", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "notify", err)
 	}
-	if callback != nil {
-		s.doNotify(callback, paymentTx)
-	} else {
-		log.Warn("payment without configured callback")
+	callback := notificationCallback(pr, paymentTx.Payment)
+	if callback == nil {
+		return nil
 	}
+	// in digest mode, only the latest notification of a payment is delivered
+	s.dispatchNotification(pr, outboxPaymentNotification(paymentTx), paymentNotificationKey(paymentTx.Payment), func(digest bool) error {
+		return s.doNotify(callback, paymentTx, digest)
+	})
 	return nil
 }
 
-func (s *Service) doNotify(c Callbacker, paymentTx *payment.PaymentTransaction) {
+// notificationCallback returns the callback for the notifications of the
+// payment
+//
+// It returns nil if the project should not be notified.
+func notificationCallback(pr *project.Project, p *payment.Payment) Callbacker {
+	if CanCallback(&p.Config) {
+		return &p.Config
+	}
+	if !pr.Config.SubscribedTo(EventPaymentTransaction, defaultEvents...) {
+		return nil
+	}
+	if CanCallback(pr.Config) {
+		return pr.Config
+	}
+	return nil
+}
+
+// deliveryLimits returns the limits of callback deliveries of the project
+func deliveryLimits(cfg project.Config) service.DeliveryLimits {
+	perSecond, concurrency := cfg.CallbackLimits()
+	return service.DeliveryLimits{
+		PerSecond:   perSecond,
+		Concurrency: concurrency,
+	}
+}
+
+// doNotify delivers the notification of the payment transaction
+//
+// It returns an error if the notification could not be delivered.
+func (s *Service) doNotify(c Callbacker, paymentTx *payment.PaymentTransaction, digest bool) error {
 	cbURL, cbAPIVersion, cbProjectKey := c.CallbackConfig()
 	log := s.log.New(log15.Ctx{
 		"method":                      "doNotify",
@@ -67,71 +116,200 @@ func (s *Service) doNotify(c Callbacker, paymentTx *payment.PaymentTransaction)
 		"callbackProjectKey":          cbProjectKey,
 	})
 	log.Info("notifying...")
-	projectKey, err := project.ProjectKeyByKeyDB(s.ctx.PrincipalDB(service.ReadOnly), cbProjectKey)
+	projectKey, err := project.ProjectKeyByKeyDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), cbProjectKey)
 	if err != nil {
 		if err == project.ErrProjectKeyNotFound {
 			log.Error("invalid project key")
-			return
+			return err
 		}
 		log.Error("error retrieving project key", log15.Ctx{"err": err})
-		return
+		return err
 	}
 	if !projectKey.IsValid() {
 		log.Warn("cannot notify with invalid project key", log15.Ctx{"projectKey": projectKey})
-		return
+		return fmt.Errorf("invalid project key %s", cbProjectKey)
 	}
 	// metadata
-	err = payment.PaymentMetadataDB(s.ctx.PaymentDB(service.ReadOnly), paymentTx.Payment)
+	err = payment.PaymentMetadataDB(s.ctx, s.ctx.ProjectPaymentDB(paymentTx.Payment.ProjectID(), service.ReadOnly), paymentTx.Payment)
 	if err != nil {
 		log.Error("error retrieving payment metadata", log15.Ctx{"err": err})
-		return
+		return err
+	}
+	err = payment.PaymentBillingDB(s.ctx, s.ctx.ProjectPaymentDB(paymentTx.Payment.ProjectID(), service.ReadOnly), paymentTx.Payment)
+	if err != nil {
+		log.Error("error retrieving payment billing", log15.Ctx{"err": err})
+		return err
+	}
+	err = payment.PaymentReferenceDB(s.ctx, s.ctx.ProjectPaymentDB(paymentTx.Payment.ProjectID(), service.ReadOnly), paymentTx.Payment)
+	if err != nil {
+		log.Error("error retrieving payment reference", log15.Ctx{"err": err})
+		return err
+	}
+	err = payment.PaymentParentDB(s.ctx, s.ctx.ProjectPaymentDB(paymentTx.Payment.ProjectID(), service.ReadOnly), paymentTx.Payment)
+	if err != nil {
+		log.Error("error retrieving parent payment relation", log15.Ctx{"err": err})
+		return err
+	}
+	err = payment.PaymentAuthorizationDB(s.ctx, s.ctx.ProjectPaymentDB(paymentTx.Payment.ProjectID(), service.ReadOnly), paymentTx.Payment)
+	if err != nil {
+		log.Error("error retrieving payment authorization", log15.Ctx{"err": err})
+		return err
 	}
 	// create new notification
 	notF, err := notification.NotificationByVersion(cbAPIVersion)
 	if err != nil {
 		log.Error("error retrieving notification by version", log15.Ctx{"err": err})
-		return
+		return err
 	}
 	not, err := notF(s.EncodedPaymentID(paymentTx.Payment.PaymentID()), paymentTx.Payment)
 	if err != nil {
 		log.Error("error creating notification", log15.Ctx{"err": err})
-		return
+		return err
+	}
+	schema, err := s.MetadataSchema(paymentTx.Payment.ProjectID())
+	if err != nil {
+		log.Error("error retrieving metadata schema", log15.Ctx{"err": err})
+		return err
+	}
+	not.SetMetadataSchema(schema)
+	methodName, err := s.PaymentMethodName(paymentTx.Payment)
+	if err != nil {
+		log.Error("error retrieving payment method name", log15.Ctx{"err": err})
+		return err
+	}
+	not.SetPaymentMethodName(methodName)
+	if parentID, ok := paymentTx.Payment.ParentPaymentID(); ok {
+		not.SetParent(s.EncodedPaymentID(parentID), paymentTx.Payment.Parent.Type)
 	}
 	// balance
-	tl, err := payment.PaymentTransactionsBeforeDB(s.ctx.PaymentDB(service.ReadOnly), paymentTx)
+	tl, err := payment.PaymentTransactionsBeforeDB(s.ctx, s.ctx.ProjectPaymentDB(paymentTx.Payment.ProjectID(), service.ReadOnly), paymentTx)
 	if err != nil {
 		log.Error("error retrieving transaction history", log15.Ctx{"err": err})
-		return
+		return err
 	}
 	not.SetTransactions(tl)
 	// signing
+	if projectKey.CanonicalJSON() {
+		not.UseCanonicalJSON()
+	}
 	non, err := nonce.New()
 	if err != nil {
 		log.Error("error generating nonce", log15.Ctx{"err": err})
-		return
+		return err
 	}
 	secret, err := projectKey.SecretBytes()
 	if err != nil {
 		log.Error("error retrieving secret", log15.Ctx{"err": err})
-		return
+		return err
 	}
 	err = not.Sign(time.Now(), non.Nonce, secret)
 	if err != nil {
 		log.Error("error signing notification", log15.Ctx{"err": err})
-		return
+		return err
 	}
 
 	req, err := http.NewRequest("POST", cbURL, not.Reader())
 	if err != nil {
 		log.Error("error creating HTTP request", log15.Ctx{"err": err})
-		return
+		return err
+	}
+	cl, err := s.prepareCallback(paymentTx.Payment.ProjectID(), req)
+	if err != nil {
+		log.Error("error applying callback transport settings", log15.Ctx{"err": err})
+		return err
+	}
+	err = s.setSignatureHeaders(req, paymentTx.Payment.ProjectID(), projectKey, not)
+	if err != nil {
+		log.Error("error signing notification", log15.Ctx{"err": err})
+		return err
 	}
 	req.Header.Set("User-Agent", not.Identification())
+	if digest {
+		req.Header.Set(DigestHeader, "1")
+	}
 	req.Close = true
-	res, err := s.cl.Do(req)
+	res, err := cl.Do(req)
 	if err != nil {
 		log.Error("error on HTTP request", log15.Ctx{"err": err})
-	} else {
-		log.Info("notified", log15.Ctx{"HTTPStatusCode": res.StatusCode})
+		return err
+	}
+	res.Body.Close()
+	log.Info("notified", log15.Ctx{"HTTPStatusCode": res.StatusCode})
+	return callbackStatusError(res.StatusCode)
+}
+
+// callbackStatusError returns an error if the callback endpoint did not accept
+// the notification
+func callbackStatusError(statusCode int) error {
+	if statusCode < 200 || statusCode > 299 {
+		return fmt.Errorf("callback responded with HTTP status %d", statusCode)
+	}
+	return nil
+}
+
+// setSignatureHeaders adds the signature headers to a callback request
+//
+// The notification is signed with the given callback project key. If the key
+// replaced the previous callback project key of the project within the
+// configured overlap, the notification is signed with the previous key as
+// well.
+func (s *Service) setSignatureHeaders(req *http.Request, projectID int64, projectKey *project.Projectkey, not keySigner) error {
+	keys := []*project.Projectkey{projectKey}
+	prev, err := s.previousCallbackKey(projectID, projectKey.Key)
+	if err != nil {
+		return err
+	}
+	if prev != nil {
+		keys = append(keys, prev)
+	}
+	for _, k := range keys {
+		secret, err := k.SecretBytes()
+		if err != nil {
+			return fmt.Errorf("error retrieving secret of key %s: %v", k.Key, err)
+		}
+		sig, err := not.KeySignature(secret, k.CanonicalJSON())
+		if err != nil {
+			return err
+		}
+		req.Header.Add(SignatureHeader, "keyId="+k.Key+",signature="+sig)
+	}
+	return nil
+}
+
+// previousCallbackKey returns the previous callback project key of the project
+// if it is still in the overlap window after it was replaced by the given key
+//
+// It returns nil if the notifications should not be signed with another key.
+func (s *Service) previousCallbackKey(projectID int64, key string) (*project.Projectkey, error) {
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving project: %v", err)
+	}
+	// keys configured on the payment are not rotated
+	overlap := pr.Config.CallbackKeyOverlapDuration()
+	if overlap == 0 || pr.Config.CallbackProjectKey.String != key {
+		return nil, nil
+	}
+	r, err := project.CallbackKeyRotationDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), projectID, key)
+	if err != nil {
+		if err == project.ErrCallbackKeyRotationNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving callback key rotation: %v", err)
+	}
+	if !r.InOverlap(overlap, time.Now()) {
+		return nil, nil
+	}
+	prev, err := project.ProjectKeyByKeyDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), r.PreviousKey)
+	if err != nil {
+		if err == project.ErrProjectKeyNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving previous callback key: %v", err)
+	}
+	// revoked keys will not sign anymore
+	if !prev.IsValid() || prev.Project.ID != projectID {
+		return nil, nil
 	}
+	return prev, nil
 }
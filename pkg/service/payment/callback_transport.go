@@ -0,0 +1,77 @@
+package payment
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/chaos"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+)
+
+func checkCallbackRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > 10 {
+		return errors.New("too many redirects")
+	}
+	// keep user-agent
+	if len(via) > 0 {
+		lastReq := via[len(via)-1]
+		if lastReq.Header.Get("User-Agent") != "" {
+			req.Header.Set("User-Agent", lastReq.Header.Get("User-Agent"))
+		}
+	}
+	return nil
+}
+
+// prepareCallback applies the callback transport settings of the project to
+// the request
+//
+// It sets the configured custom headers and returns the HTTP client which will
+// present the configured client certificate.
+func (s *Service) prepareCallback(projectID int64, req *http.Request) (*http.Client, error) {
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), projectID)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := pr.Config.CallbackHeaderValues()
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if !pr.Config.HasCallbackTLS() {
+		return s.cl, nil
+	}
+	return s.callbackClient(pr.Config.CallbackTLSCertFile.String, pr.Config.CallbackTLSKeyFile.String)
+}
+
+// callbackClient returns an HTTP client presenting the given client certificate
+//
+// Clients are reused per certificate. Renewed certificates will be loaded after
+// a restart or when the project config points to new files.
+func (s *Service) callbackClient(certFile, keyFile string) (*http.Client, error) {
+	key := certFile + "\x00" + keyFile
+	s.mCallbackClients.Lock()
+	defer s.mCallbackClients.Unlock()
+	if cl, ok := s.callbackClients[key]; ok {
+		return cl, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+	}
+	cl := &http.Client{
+		Transport:     chaos.WrapTransport(tr, s.ctx.Chaos().Notification),
+		CheckRedirect: checkCallbackRedirect,
+		Timeout:       s.ctx.Notifications().Timeout(),
+	}
+	s.callbackClients[key] = cl
+	return cl, nil
+}
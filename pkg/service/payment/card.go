@@ -0,0 +1,174 @@
+package payment
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/cache"
+	"github.com/fritzpay/paymentd/pkg/paymentd/bin"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// binCacheTTL is the time for which BIN lookups will be cached
+const binCacheTTL = 10 * time.Minute
+
+func binCacheKey(prefix string) string {
+	return "bin:" + prefix
+}
+
+// LookupBIN resolves the BIN range of the given card number (or its BIN) from
+// the latest BIN dataset
+//
+// It returns bin.ErrRangeNotFound if the number does not belong to any range.
+// Lookups are cached, so that a refreshed dataset may take up to 10 minutes to
+// be seen.
+func (s *Service) LookupBIN(number string) (*bin.Range, error) {
+	log := s.log.New(log15.Ctx{"method": "LookupBIN"})
+	prefixes, err := bin.Prefixes(number)
+	if err != nil {
+		return nil, ErrCardNumber
+	}
+	key := binCacheKey(prefixes[0])
+	if b, err := s.ctx.Cache().Get(key); err == nil {
+		// unknown numbers are cached as empty values
+		if len(b) == 0 {
+			return nil, bin.ErrRangeNotFound
+		}
+		r := &bin.Range{}
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(r)
+		if err == nil {
+			return r, nil
+		}
+		log.Warn("error decoding cached BIN range", log15.Ctx{"err": err})
+	} else if err != cache.ErrNotFound {
+		log.Error("error retrieving cached BIN range", log15.Ctx{"err": err})
+	}
+	r, err := bin.RangeByNumberDB(s.ctx.PaymentDB(service.ReadOnly), prefixes[0])
+	if err != nil && err != bin.ErrRangeNotFound {
+		log.Error("error retrieving BIN range", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "LookupBIN", err)
+	}
+	buf := &bytes.Buffer{}
+	if r != nil {
+		if encErr := gob.NewEncoder(buf).Encode(r); encErr != nil {
+			log.Error("error encoding BIN range", log15.Ctx{"err": encErr})
+			return r, nil
+		}
+	}
+	if setErr := s.ctx.Cache().Set(key, buf.Bytes(), binCacheTTL); setErr != nil {
+		log.Error("error caching BIN range", log15.Ctx{"err": setErr})
+	}
+	return r, err
+}
+
+// SetPaymentCard records the card used for the payment
+//
+// The number can be the full card number or its BIN. Only the BIN will be
+// recorded along with the issuer data of the BIN range. Cards with unknown
+// BINs are recorded without issuer data.
+//
+// The payment will be routed according to the card routes of its payment
+// method. It returns true if the payment method of the payment was changed.
+// Card drivers should record the card before the payment is processed with
+// the provider and hand the payment over to the routed payment method.
+func (s *Service) SetPaymentCard(tx *sql.Tx, p *payment.Payment, number string) (routed bool, err error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "SetPaymentCard",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	r, err := s.LookupBIN(number)
+	if err != nil && err != bin.ErrRangeNotFound {
+		return false, err
+	}
+	prefixes, _ := bin.Prefixes(number)
+	card := p.NewCard(prefixes[len(prefixes)-1])
+	if r != nil {
+		card.BIN = r.Prefix
+		card.Country = r.Country
+		card.Brand = r.Brand
+		card.Debit = r.Debit
+		card.Prepaid = r.Prepaid
+	} else {
+		log.Info("unknown BIN", log15.Ctx{"bin": card.BIN})
+	}
+	p.Card = card
+	err = payment.InsertPaymentCardTx(s.ctx, tx, p)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return false, wrapError(ErrDBLockTimeout, "SetPaymentCard", err)
+		}
+		log.Error("error saving payment card", log15.Ctx{"err": err})
+		return false, wrapError(ErrDB, "SetPaymentCard", err)
+	}
+	return s.routePaymentCard(tx, p)
+}
+
+// routePaymentCard routes the payment with a recorded card to the card route of
+// its payment method
+//
+// Payments with debit cards issued in the payment country will be routed to
+// the domestic debit route. Routes which are inactive or cannot process the
+// payment currency will be ignored.
+func (s *Service) routePaymentCard(tx *sql.Tx, p *payment.Payment) (bool, error) {
+	if p.Card == nil || !p.Config.PaymentMethodID.Valid {
+		return false, nil
+	}
+	if !p.Card.Debit || !p.Card.Domestic(p.Config.Country.String) {
+		return false, nil
+	}
+	log := s.log.New(log15.Ctx{
+		"method":          "routePaymentCard",
+		"projectID":       p.ProjectID(),
+		"paymentID":       p.ID(),
+		"paymentMethodID": p.Config.PaymentMethodID.Int64,
+	})
+	meth, err := payment_method.PaymentMethodByIDTx(s.ctx, tx, p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		if err == payment_method.ErrPaymentMethodNotFound {
+			return false, ErrPaymentMethodNotFound
+		}
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return false, wrapError(ErrDB, "routePaymentCard", err)
+	}
+	meth.Metadata, err = payment_method.PaymentMethodMetadataTx(s.ctx, tx, meth)
+	if err != nil {
+		log.Error("error retrieving payment method metadata", log15.Ctx{"err": err})
+		return false, wrapError(ErrDB, "routePaymentCard", err)
+	}
+	routeID, ok := meth.DomesticDebitRouteID()
+	if !ok {
+		return false, nil
+	}
+	route, err := payment_method.PaymentMethodByIDTx(s.ctx, tx, routeID)
+	if err != nil {
+		if err == payment_method.ErrPaymentMethodNotFound {
+			log.Warn("domestic debit route not found", log15.Ctx{"routeID": routeID})
+			return false, nil
+		}
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return false, wrapError(ErrDB, "routePaymentCard", err)
+	}
+	route.Metadata, err = payment_method.PaymentMethodMetadataTx(s.ctx, tx, route)
+	if err != nil {
+		log.Error("error retrieving payment method metadata", log15.Ctx{"err": err})
+		return false, wrapError(ErrDB, "routePaymentCard", err)
+	}
+	if route.ProjectID != p.ProjectID() || !route.Active() || !route.SupportsCurrency(p.Currency) {
+		log.Warn("domestic debit route cannot process payment", log15.Ctx{"routeID": routeID})
+		return false, nil
+	}
+	p.Config.SetPaymentMethodID(route.ID)
+	err = s.SetPaymentConfig(tx, p)
+	if err != nil {
+		return false, err
+	}
+	log.Info("payment routed to domestic debit route", log15.Ctx{"routeID": route.ID})
+	return true, nil
+}
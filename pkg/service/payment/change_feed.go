@@ -0,0 +1,140 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// number of changes published per batch
+	changePublishBatchSize = 500
+	// maximum number of batches published before reading the change feed
+	changePublishMaxBatches = 10
+)
+
+// addChange writes the change to the outbox
+//
+// The change will be published to the change feed after the transaction was
+// committed.
+func (s *Service) addChange(tx *sql.Tx, change *payment.Change) error {
+	err := payment.InsertChangeTx(s.ctx, tx, change)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "addChange", err)
+		}
+		s.log.Error("error saving payment change", log15.Ctx{
+			"method": "addChange",
+			"type":   change.Type,
+			"err":    err,
+		})
+		return wrapError(ErrDB, "addChange", err)
+	}
+	return nil
+}
+
+// publishChanges assigns sequence numbers to committed changes in the outbox
+// of the given payment database shard
+//
+// The sequence numbers are assigned per shard. It returns the number of
+// published changes.
+func (s *Service) publishChanges(db *sql.DB) (int, error) {
+	log := s.log.New(log15.Ctx{"method": "publishChanges"})
+	var tx *sql.Tx
+	var err error
+	var commit bool
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = db.Begin()
+	if err != nil {
+		commit = true
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		return 0, wrapError(ErrDB, "publishChanges", err)
+	}
+	ids, err := payment.UnsequencedChangeIDsTx(s.ctx, tx, changePublishBatchSize)
+	if err != nil {
+		log.Error("error retrieving unpublished changes", log15.Ctx{"err": err})
+		return 0, wrapError(ErrDB, "publishChanges", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	seq, err := payment.MaxChangeSequenceTx(s.ctx, tx)
+	if err != nil {
+		log.Error("error retrieving change sequence", log15.Ctx{"err": err})
+		return 0, wrapError(ErrDB, "publishChanges", err)
+	}
+	for _, id := range ids {
+		seq++
+		err = payment.SetChangeSequenceTx(s.ctx, tx, id, seq)
+		if err != nil {
+			// a duplicate sequence was assigned by another publisher concurrently
+			if sqldialect.IsDeadlock(err) || sqldialect.IsDuplicate(err) {
+				return 0, wrapError(ErrDBLockTimeout, "publishChanges", err)
+			}
+			log.Error("error publishing change", log15.Ctx{"err": err})
+			return 0, wrapError(ErrDB, "publishChanges", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		return 0, wrapError(ErrDB, "publishChanges", err)
+	}
+	commit = true
+	return len(ids), nil
+}
+
+// publishPendingChanges publishes the pending changes in the outbox of the
+// given payment database shard in batches
+//
+// It returns early if another reader is publishing.
+func (s *Service) publishPendingChanges(db *sql.DB) error {
+	for i := 0; i < changePublishMaxBatches; i++ {
+		n, err := s.publishChanges(db)
+		if errors.Is(err, ErrDBLockTimeout) {
+			// another reader is publishing
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n < changePublishBatchSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Changes returns the changes of the given project which follow the given
+// sequence number in the change feed
+//
+// Pending changes in the outbox will be published before reading the change
+// feed. Consumers should remember the sequence number of the last processed
+// change. Changes will be delivered at least once.
+func (s *Service) Changes(projectID, after int64, limit int) ([]*payment.Change, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "Changes",
+		"projectID": projectID,
+	})
+	err := s.publishPendingChanges(s.ctx.ProjectPaymentDB(projectID))
+	if err != nil {
+		return nil, err
+	}
+	changes, err := payment.ChangesByProjectIDAfterSequenceDB(s.ctx, s.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, after, limit)
+	if err != nil {
+		log.Error("error retrieving changes", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "Changes", err)
+	}
+	return changes, nil
+}
@@ -0,0 +1,281 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// disputable returns true if payments with the given status can receive a
+// chargeback
+func disputable(status payment.PaymentTransactionStatus) bool {
+	return refundable(status)
+}
+
+// newChargebackTransaction creates the transaction for the status change of
+// the dispute
+//
+// prev is the current status of the dispute, nil if the dispute is new. A
+// received chargeback withdraws the disputed amount, a reversed chargeback
+// returns it. A lost chargeback does not move any funds. The amount of the
+// chargeback will be set according to the ledger of the payment.
+func newChargebackTransaction(p *payment.Payment, txs payment.PaymentTransactionList, c, prev *payment.Chargeback) (*payment.PaymentTransaction, error) {
+	var paymentTx *payment.PaymentTransaction
+	switch c.Status {
+	case payment.ChargebackReceived:
+		if prev != nil || !disputable(p.Status) {
+			return nil, ErrIntentNotAllowed
+		}
+		left := refundableUnits(p, txs)
+		if left <= 0 {
+			return nil, ErrChargebackAmount
+		}
+		if c.Amount == 0 {
+			c.Amount = left
+		}
+		if c.Amount < 0 || c.Amount > left {
+			return nil, ErrChargebackAmount
+		}
+		paymentTx = p.NewTransaction(payment.PaymentStatusChargebackReceived)
+		paymentTx.Amount = -c.Amount
+
+	case payment.ChargebackReversed, payment.ChargebackLost:
+		if prev == nil || !prev.Open() || p.Status != payment.PaymentStatusChargebackReceived {
+			return nil, ErrIntentNotAllowed
+		}
+		c.Amount = prev.Amount
+		if c.Status == payment.ChargebackReversed {
+			paymentTx = p.NewTransaction(payment.PaymentStatusChargebackReversed)
+			paymentTx.Amount = c.Amount
+		} else {
+			paymentTx = p.NewTransaction(payment.PaymentStatusChargebackLost)
+			paymentTx.Amount = 0
+		}
+
+	default:
+		return nil, ErrIntentNotAllowed
+	}
+	c.Subunits = p.Subunits
+	paymentTx.Comment.String, paymentTx.Comment.Valid = "dispute "+c.DisputeID, true
+	if c.Reason.Valid && c.Reason.String != "" {
+		paymentTx.Comment.String += ": " + c.Reason.String
+	}
+	return paymentTx, nil
+}
+
+// IntentChargebackReceived records a chargeback received for the payment
+//
+// The chargeback withdraws the disputed amount, which must not exceed the
+// refundable amount. A zero amount disputes the refundable amount. The dispute
+// ID must be new to the payment.
+func (s *Service) IntentChargebackReceived(ctx context.Context, p *payment.Payment, c *payment.Chargeback, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	c.Status = payment.ChargebackReceived
+	return s.intentChargeback(ctx, p, c, timeout)
+}
+
+// IntentChargebackReversed records the dispute being resolved in favor of the
+// merchant
+//
+// The disputed amount will be returned to the payment.
+func (s *Service) IntentChargebackReversed(ctx context.Context, p *payment.Payment, c *payment.Chargeback, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	c.Status = payment.ChargebackReversed
+	return s.intentChargeback(ctx, p, c, timeout)
+}
+
+// IntentChargebackLost records the dispute being resolved in favor of the
+// customer
+func (s *Service) IntentChargebackLost(ctx context.Context, p *payment.Payment, c *payment.Chargeback, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	c.Status = payment.ChargebackLost
+	return s.intentChargeback(ctx, p, c, timeout)
+}
+
+func (s *Service) intentChargeback(ctx context.Context, p *payment.Payment, c *payment.Chargeback, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if p.IsVerification() || c.DisputeID == "" {
+		return s.rejectIntent(p, chargebackIntent(c), ErrIntentNotAllowed)
+	}
+	log := s.log.New(log15.Ctx{
+		"method":    "IntentChargeback",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+		"disputeID": c.DisputeID,
+	})
+	// prior disputes and refunds must be visible, so the write connection is used
+	prev, err := payment.PaymentChargebackCurrentDB(ctx, s.ctx.ProjectPaymentDB(p.ProjectID()), p, c.DisputeID)
+	if err != nil {
+		if err != payment.ErrChargebackNotFound {
+			log.Error("error retrieving chargeback", log15.Ctx{"err": err})
+			return nil, nil, wrapError(ErrDB, "IntentChargeback", err)
+		}
+		prev = nil
+	}
+	txs, err := payment.PaymentTransactionsBeforeTimestampDB(ctx, s.ctx.ProjectPaymentDB(p.ProjectID()), p, time.Now())
+	if err != nil {
+		log.Error("error retrieving payment transactions", log15.Ctx{"err": err})
+		return nil, nil, wrapError(ErrDB, "IntentChargeback", err)
+	}
+	return s.handleChargeback(ctx, p, txs, c, prev, timeout)
+}
+
+// handleChargeback runs the chargeback intent on the ledger and the current
+// status of the dispute
+//
+// The intent is not subject to the payment method being disabled, since
+// disputes are reported by the provider regardless.
+func (s *Service) handleChargeback(ctx context.Context, p *payment.Payment, txs payment.PaymentTransactionList, c, prev *payment.Chargeback, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	paymentTx, err := newChargebackTransaction(p, txs, c, prev)
+	if err == ErrIntentNotAllowed {
+		return s.rejectIntent(p, chargebackIntent(c), err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	paymentTx, commit, err := s.handleIntent(ctx, p, paymentTx, timeout)
+	if err != nil {
+		return paymentTx, commit, err
+	}
+	// notify about the dispute once the chargeback is committed
+	return paymentTx, CommitIntentFunc(func() error {
+		if commit != nil {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+		s.notifyChargeback(p, c)
+		return nil
+	}), nil
+}
+
+// chargebackIntent returns the transaction status the chargeback intends
+func chargebackIntent(c *payment.Chargeback) payment.PaymentTransactionStatus {
+	switch c.Status {
+	case payment.ChargebackReversed:
+		return payment.PaymentStatusChargebackReversed
+	case payment.ChargebackLost:
+		return payment.PaymentStatusChargebackLost
+	default:
+		return payment.PaymentStatusChargebackReceived
+	}
+}
+
+func (s *Service) notifyChargeback(p *payment.Payment, c *payment.Chargeback) {
+	s.NotifyEvent(p.ProjectID(), EventPaymentChargeback, map[string]string{
+		"PaymentId": s.EncodedPaymentID(p.PaymentID()).String(),
+		"DisputeId": c.DisputeID,
+		"Status":    c.Status,
+		"Amount":    c.Decimal().String(),
+		"Currency":  p.Currency,
+		"Reason":    c.Reason.String,
+	})
+}
+
+// SetPaymentChargeback saves the status of the dispute of the payment
+//
+// It should be called in the same transaction as SetPaymentTransaction with
+// the transaction of the chargeback intent.
+func (s *Service) SetPaymentChargeback(tx *sql.Tx, p *payment.Payment, c *payment.Chargeback) error {
+	err := payment.InsertPaymentChargebackTx(s.ctx, tx, p, c)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "SetPaymentChargeback", err)
+		}
+		// each status of a dispute can only be recorded once
+		if sqldialect.IsDuplicate(err) {
+			return wrapError(ErrIntentNotAllowed, "SetPaymentChargeback", err)
+		}
+		s.log.Error("error saving chargeback", log15.Ctx{
+			"method":    "SetPaymentChargeback",
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+			"err":       err,
+		})
+		return wrapError(ErrDB, "SetPaymentChargeback", err)
+	}
+	return nil
+}
+
+// RecordChargeback records a status change of a dispute reported by the
+// provider
+//
+// It is a convenience method for provider drivers, which runs the chargeback
+// intent according to the status of the chargeback, saves the transaction and
+// the chargeback and commits the intent.
+//
+// The payment is locked while the current status of the dispute and the ledger
+// are read, so that a dispute reported concurrently, e.g. by a retried webhook,
+// will be recorded once.
+func (s *Service) RecordChargeback(id payment.PaymentID, c *payment.Chargeback, timeout time.Duration) error {
+	switch c.Status {
+	case payment.ChargebackReceived, payment.ChargebackReversed, payment.ChargebackLost:
+	default:
+		return fmt.Errorf("invalid chargeback status %s", c.Status)
+	}
+	log := s.log.New(log15.Ctx{
+		"method":    "RecordChargeback",
+		"projectID": id.ProjectID,
+		"paymentID": id.PaymentID,
+		"disputeID": c.DisputeID,
+	})
+	tx, err := s.ctx.ProjectPaymentDB(id.ProjectID).Begin()
+	if err != nil {
+		log.Crit("error on begin", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "RecordChargeback", err)
+	}
+	var commit bool
+	defer func() {
+		if !commit {
+			err := tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	p, err := payment.PaymentByIDForUpdateTx(s.ctx, tx, id)
+	if err != nil {
+		if err == payment.ErrPaymentNotFound {
+			return err
+		}
+		log.Error("error retrieving payment", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "RecordChargeback", err)
+	}
+	if p.IsVerification() || c.DisputeID == "" {
+		_, _, err = s.rejectIntent(p, chargebackIntent(c), ErrIntentNotAllowed)
+		return err
+	}
+	prev, err := payment.PaymentChargebackCurrentTx(s.ctx, tx, p, c.DisputeID)
+	if err != nil {
+		if err != payment.ErrChargebackNotFound {
+			log.Error("error retrieving chargeback", log15.Ctx{"err": err})
+			return wrapError(ErrDB, "RecordChargeback", err)
+		}
+		prev = nil
+	}
+	txs, err := payment.PaymentTransactionsBeforeTimestampTx(s.ctx, tx, p, time.Now())
+	if err != nil {
+		log.Error("error retrieving payment transactions", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "RecordChargeback", err)
+	}
+	paymentTx, commitIntent, err := s.handleChargeback(s.ctx, p, txs, c, prev, timeout)
+	if err != nil {
+		return err
+	}
+	err = s.SetPaymentTransaction(tx, paymentTx)
+	if err != nil {
+		return err
+	}
+	err = s.SetPaymentChargeback(tx, p, c)
+	if err != nil {
+		return err
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "RecordChargeback", err)
+	}
+	return commitIntent()
+}
@@ -0,0 +1,111 @@
+package payment
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestChargebackTransaction(t *testing.T) {
+	Convey("Given a paid payment of 100.00 EUR", t, func() {
+		p := &payment.Payment{
+			Amount:   10000,
+			Subunits: 2,
+			Currency: "EUR",
+			Status:   payment.PaymentStatusPaid,
+		}
+		txs := payment.PaymentTransactionList{
+			{Amount: -10000, Subunits: 2, Currency: "EUR", Status: payment.PaymentStatusOpen},
+			{Amount: 10000, Subunits: 2, Currency: "EUR", Status: payment.PaymentStatusPaid},
+		}
+
+		Convey("When a chargeback without amount is received", func() {
+			c := p.NewChargeback("dp_1", payment.ChargebackReceived, 0, "test")
+			c.Reason = sql.NullString{String: "fraudulent", Valid: true}
+			paymentTx, err := newChargebackTransaction(p, txs, c, nil)
+
+			Convey("It should withdraw the refundable amount", func() {
+				So(err, ShouldBeNil)
+				So(paymentTx.Status, ShouldEqual, payment.PaymentStatusChargebackReceived)
+				So(paymentTx.Amount, ShouldEqual, -10000)
+				So(c.Amount, ShouldEqual, 10000)
+				So(c.Decimal().String(), ShouldEqual, "100.00")
+				So(paymentTx.Comment.String, ShouldEqual, "dispute dp_1: fraudulent")
+			})
+
+			Convey("When the dispute is resolved in favor of the merchant", func() {
+				txs = append(txs, paymentTx)
+				reversed := p.NewChargeback("dp_1", payment.ChargebackReversed, 0, "test")
+				paymentTx, err := newChargebackTransaction(p, txs, reversed, c)
+
+				Convey("It should return the disputed amount", func() {
+					So(err, ShouldBeNil)
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusChargebackReversed)
+					So(paymentTx.Amount, ShouldEqual, 10000)
+					So(reversed.Amount, ShouldEqual, 10000)
+				})
+
+				Convey("The returned amount should be refundable again", func() {
+					txs = append(txs, paymentTx)
+					So(refundableUnits(p, txs), ShouldEqual, 10000)
+				})
+			})
+
+			Convey("When the dispute is lost", func() {
+				lost := p.NewChargeback("dp_1", payment.ChargebackLost, 0, "test")
+				paymentTx, err := newChargebackTransaction(p, txs, lost, c)
+
+				Convey("It should not move any funds", func() {
+					So(err, ShouldBeNil)
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusChargebackLost)
+					So(paymentTx.Amount, ShouldEqual, 0)
+				})
+			})
+
+			Convey("When the same dispute is received again", func() {
+				_, err := newChargebackTransaction(p, txs, p.NewChargeback("dp_1", payment.ChargebackReceived, 0, "test"), c)
+
+				Convey("It should not be allowed", func() {
+					So(err, ShouldEqual, ErrIntentNotAllowed)
+				})
+			})
+		})
+
+		Convey("When a chargeback exceeds the refundable amount", func() {
+			_, err := newChargebackTransaction(p, txs, p.NewChargeback("dp_2", payment.ChargebackReceived, 10001, "test"), nil)
+
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrChargebackAmount)
+			})
+		})
+
+		Convey("When an unknown dispute is resolved", func() {
+			_, err := newChargebackTransaction(p, txs, p.NewChargeback("dp_3", payment.ChargebackLost, 0, "test"), nil)
+
+			Convey("It should not be allowed", func() {
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+			})
+		})
+	})
+
+	Convey("Given a payment service", t, func() {
+		s := &Service{}
+
+		Convey("Given a verification payment", func() {
+			p := &payment.Payment{
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusVerified,
+			}
+
+			Convey("It should not receive chargebacks", func() {
+				_, _, err := s.IntentChargebackReceived(context.Background(), p, p.NewChargeback("dp_1", "", 0, "test"), time.Second)
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+			})
+		})
+	})
+}
@@ -0,0 +1,87 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+type commitRecorder chan *payment.PaymentTransaction
+
+func (c commitRecorder) CommitIntent(paymentTx *payment.PaymentTransaction) error {
+	c <- paymentTx
+	return nil
+}
+
+func TestCommitIntentFunc(t *testing.T) {
+	Convey("Given a payment service with a commit intent worker", t, func() {
+		log := log15.New()
+		log.SetHandler(log15.DiscardHandler())
+		committed := make(commitRecorder, 2)
+		s := &Service{
+			log:                 log,
+			commitIntentTimeout: 10 * time.Millisecond,
+			lateCommitPolicy:    LateCommitReject,
+			commitIntents:       []CommitIntentWorker{committed},
+		}
+		p := &payment.Payment{Status: payment.PaymentStatusOpen}
+		paymentTx := p.NewTransaction(payment.PaymentStatusPaid)
+
+		Convey("When the intent is committed in time", func() {
+			commitIntent := s.newCommitIntentFunc(paymentTx)
+			err := commitIntent()
+			Convey("The workers should be invoked", func() {
+				So(err, ShouldBeNil)
+				select {
+				case tx := <-committed:
+					So(tx, ShouldEqual, paymentTx)
+				case <-time.After(time.Second):
+					t.Error("commit intent worker not invoked")
+				}
+			})
+			Convey("Subsequent commits should have no effect", func() {
+				So(commitIntent(), ShouldBeNil)
+				<-committed
+				select {
+				case <-committed:
+					t.Error("commit intent worker invoked twice")
+				case <-time.After(20 * time.Millisecond):
+				}
+			})
+		})
+
+		Convey("When the intent is committed after the timeout", func() {
+			commitIntent := s.newCommitIntentFunc(paymentTx)
+			time.Sleep(20 * time.Millisecond)
+			err := commitIntent()
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, ErrCommitExpired)
+				select {
+				case <-committed:
+					t.Error("commit intent worker invoked on expired intent")
+				case <-time.After(20 * time.Millisecond):
+				}
+			})
+		})
+
+		Convey("Given the late commit policy execute", func() {
+			s.lateCommitPolicy = LateCommitExecute
+			Convey("When the intent is committed after the timeout", func() {
+				commitIntent := s.newCommitIntentFunc(paymentTx)
+				time.Sleep(20 * time.Millisecond)
+				err := commitIntent()
+				Convey("The workers should be invoked", func() {
+					So(err, ShouldBeNil)
+					select {
+					case <-committed:
+					case <-time.After(time.Second):
+						t.Error("commit intent worker not invoked")
+					}
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,89 @@
+package payment
+
+import (
+	"database/sql"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/fee"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// estimatePaymentCost records the estimated processing cost of an authorized
+// or paid payment
+//
+// The cost is estimated once with the fee schedule of the provider of the
+// payment method. Payments without a fee schedule or a matching fee rule will
+// not be estimated.
+func (s *Service) estimatePaymentCost(tx *sql.Tx, p *payment.Payment) error {
+	if !p.Config.PaymentMethodID.Valid || p.IsVerification() {
+		return nil
+	}
+	log := s.log.New(log15.Ctx{
+		"method":    "estimatePaymentCost",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	_, err := fee.CostTx(tx, p.ProjectID(), p.ID(), fee.CostEstimated)
+	if err == nil {
+		// estimated on authorization
+		return nil
+	}
+	if err != fee.ErrCostNotFound {
+		return s.costDBErr(log, "estimatePaymentCost", err)
+	}
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "estimatePaymentCost", err)
+	}
+	sched, err := fee.ScheduleTx(tx, p.ProjectID(), meth.Provider.Name)
+	if err != nil {
+		if err == fee.ErrScheduleNotFound {
+			return nil
+		}
+		return s.costDBErr(log, "estimatePaymentCost", err)
+	}
+	if p.Card == nil {
+		err = payment.PaymentCardTx(s.ctx, tx, p)
+		if err != nil {
+			return s.costDBErr(log, "estimatePaymentCost", err)
+		}
+	}
+	c, ok := sched.Estimate(p)
+	if !ok {
+		log.Warn("no fee rule matches payment", log15.Ctx{"provider": sched.Provider})
+		return nil
+	}
+	err = fee.InsertCostTx(tx, p.ProjectID(), p.ID(), c)
+	if err != nil {
+		return s.costDBErr(log, "estimatePaymentCost", err)
+	}
+	return nil
+}
+
+// SetActualCost records the actual processing cost of the payment, e.g. from
+// an imported settlement
+//
+// The cost must be in the currency of the payment.
+func (s *Service) SetActualCost(tx *sql.Tx, p *payment.Payment, c *fee.Cost) error {
+	log := s.log.New(log15.Ctx{
+		"method":    "SetActualCost",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	c.Type = fee.CostActual
+	err := fee.InsertCostTx(tx, p.ProjectID(), p.ID(), c)
+	if err != nil {
+		return s.costDBErr(log, "SetActualCost", err)
+	}
+	return nil
+}
+
+func (s *Service) costDBErr(log log15.Logger, method string, err error) error {
+	if sqldialect.IsDeadlock(err) {
+		return wrapError(ErrDBLockTimeout, method, err)
+	}
+	log.Error("error on payment cost", log15.Ctx{"err": err})
+	return wrapError(ErrDB, method, err)
+}
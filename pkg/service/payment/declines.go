@@ -0,0 +1,68 @@
+package payment
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/alert"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// declineMonitor alerts on spikes of declined payments
+//
+// It observes the committed intents. Failed payments count as declined,
+// paid and authorized payments as approved. The payments are counted in the
+// decline windows of the service context, which are shared by all payment
+// services.
+type declineMonitor struct {
+	s       *Service
+	windows *service.DeclineWindows
+}
+
+// CommitIntent implements the CommitIntentWorker
+//
+// Verification payments will not be observed. Declined verifications do not
+// lose any revenue.
+func (d *declineMonitor) CommitIntent(paymentTx *payment.PaymentTransaction) error {
+	if paymentTx.Payment.IsVerification() {
+		return nil
+	}
+	var declined bool
+	switch paymentTx.Status {
+	case payment.PaymentStatusFailed:
+		declined = true
+	case payment.PaymentStatusPaid, payment.PaymentStatusAuthorized:
+	default:
+		return nil
+	}
+	projectID := paymentTx.Payment.ProjectID()
+	dec, total, spike := d.windows.Observe(projectID, declined, time.Now())
+	if !spike {
+		return nil
+	}
+	pr, err := project.ProjectByIDDB(d.s.ctx, d.s.ctx.PrincipalDB(service.ReadOnly), projectID)
+	if err != nil {
+		d.s.log.Error("error retrieving project of decline spike", log15.Ctx{
+			"projectID": projectID,
+			"err":       err,
+		})
+		return err
+	}
+	a := &alert.Alert{
+		Kind:        alert.KindDeclineSpike,
+		Severity:    alert.SeverityCritical,
+		PrincipalID: pr.PrincipalID,
+		ProjectID:   projectID,
+		Title:       "Spike in declined payments of project " + pr.Name,
+		Text: fmt.Sprintf("%d of %d payments were declined within %s.",
+			dec, total, d.windows.Window()),
+	}
+	a.AddField("Project", pr.Name+" ("+strconv.FormatInt(projectID, 10)+")")
+	a.AddField("Declined", strconv.Itoa(dec*100/total)+"%")
+	d.s.ctx.Alerts().Alert(a)
+	return nil
+}
@@ -0,0 +1,23 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeclineMonitor(t *testing.T) {
+	Convey("Given a decline monitor without decline windows", t, func() {
+		d := &declineMonitor{}
+
+		Convey("When a verification payment failed", func() {
+			p := &payment.Payment{Currency: "EUR", Status: payment.PaymentStatusOpen}
+			err := d.CommitIntent(p.NewTransaction(payment.PaymentStatusFailed))
+
+			Convey("It should not be observed", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
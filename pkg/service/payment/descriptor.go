@@ -0,0 +1,30 @@
+package payment
+
+import (
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// StatementDescriptor returns the statement descriptor of the payment
+//
+// The descriptor of the payment config overrides the descriptor of the project
+// config. It returns an empty string if neither is set. Drivers should forward
+// the descriptor to the provider, so that the customer recognizes the charge on
+// the statement.
+func (s *Service) StatementDescriptor(p *payment.Payment) (string, error) {
+	if p.Config.StatementDescriptor.Valid {
+		return p.Config.StatementDescriptor.String, nil
+	}
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		s.log.Error("error retrieving project", log15.Ctx{
+			"method":    "StatementDescriptor",
+			"projectID": p.ProjectID(),
+			"err":       err,
+		})
+		return "", wrapError(ErrDB, "StatementDescriptor", err)
+	}
+	return pr.Config.StatementDescriptor.String, nil
+}
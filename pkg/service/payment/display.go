@@ -0,0 +1,210 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/cache"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// MethodLogoPath is the path of the payment method logos, relative to the
+// web URL
+const MethodLogoPath = "/method/{provider}/{methodkey}/logo"
+
+// methodDisplayCacheTTL is the time for which payment method display metadata
+// will be cached
+const methodDisplayCacheTTL = 30 * time.Second
+
+// MethodDisplay is the display metadata of a payment method as presented to
+// customers
+type MethodDisplay struct {
+	Name        string
+	Description string
+	// LogoURL is empty if the payment method has no logo
+	LogoURL string
+}
+
+// methodDisplays is the cached display metadata of a payment method
+type methodDisplays struct {
+	Displays []*payment_method.Display
+	// Logo is the time the current logo was saved. It is zero if there is no
+	// logo.
+	Logo time.Time
+}
+
+func methodDisplayCacheKey(provider, methodKey string) string {
+	return "methoddisplay:" + provider + ":" + methodKey
+}
+
+// MethodDisplay returns the display metadata of the given payment method in
+// the given locale
+//
+// Display metadata set by the project in the metadata of the payment method
+// takes precedence over the shared display metadata in the same language. The
+// metadata of the payment method must be loaded. If no display metadata is
+// present, the method key will be used as the name. Changes of the shared
+// display metadata may take up to 30 seconds to be seen.
+func (s *Service) MethodDisplay(meth *payment_method.Method, locale string) (*MethodDisplay, error) {
+	md, err := s.methodDisplays(meth.Provider.Name, meth.MethodKey)
+	if err != nil {
+		return nil, err
+	}
+	disp := &MethodDisplay{
+		Name: meth.MethodKey,
+	}
+	if d, ok := selectMethodDisplay(meth.ProjectDisplays(), md.Displays, locale); ok {
+		disp.Name = d.Name
+		disp.Description = d.Description
+	}
+	if !md.Logo.IsZero() {
+		disp.LogoURL = s.MethodLogoURL(meth.Provider.Name, meth.MethodKey, md.Logo)
+	}
+	return disp, nil
+}
+
+// PaymentMethodName returns the display name of the payment method of the
+// given payment in the locale of the payment
+//
+// It returns an empty string if the payment has no payment method.
+func (s *Service) PaymentMethodName(p *payment.Payment) (string, error) {
+	if !p.Config.PaymentMethodID.Valid {
+		return "", nil
+	}
+	log := s.log.New(log15.Ctx{
+		"method":          "PaymentMethodName",
+		"projectID":       p.ProjectID(),
+		"paymentID":       p.ID(),
+		"paymentMethodID": p.Config.PaymentMethodID.Int64,
+	})
+	db := s.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly)
+	meth, err := payment_method.PaymentMethodByIDDB(s.ctx, db, p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		if err == payment_method.ErrPaymentMethodNotFound {
+			return "", ErrPaymentMethodNotFound
+		}
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return "", wrapError(ErrDB, "PaymentMethodName", err)
+	}
+	meth.Metadata, err = payment_method.PaymentMethodMetadataDB(s.ctx, db, meth)
+	if err != nil {
+		log.Error("error retrieving payment method metadata", log15.Ctx{"err": err})
+		return "", wrapError(ErrDB, "PaymentMethodName", err)
+	}
+	disp, err := s.MethodDisplay(meth, p.Config.Locale.String)
+	if err != nil {
+		return "", err
+	}
+	return disp.Name, nil
+}
+
+// selectMethodDisplay selects the display metadata of the project or the
+// shared display metadata matching the locale, falling back to the
+// DefaultDisplayLocale
+func selectMethodDisplay(project, shared []*payment_method.Display, locale string) (*payment_method.Display, bool) {
+	for _, l := range []string{locale, payment_method.DefaultDisplayLocale} {
+		if d, ok := payment_method.MatchDisplay(project, l); ok {
+			return d, true
+		}
+		if d, ok := payment_method.MatchDisplay(shared, l); ok {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// MethodDisplays returns the display metadata of all locales and the logo URL
+// of the payment methods with the given provider and method key
+//
+// The logo URL is empty if there is no logo.
+func (s *Service) MethodDisplays(provider, methodKey string) ([]*payment_method.Display, string, error) {
+	md, err := s.methodDisplays(provider, methodKey)
+	if err != nil {
+		return nil, "", err
+	}
+	if md.Logo.IsZero() {
+		return md.Displays, "", nil
+	}
+	return md.Displays, s.MethodLogoURL(provider, methodKey, md.Logo), nil
+}
+
+func (s *Service) methodDisplays(provider, methodKey string) (*methodDisplays, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "methodDisplays",
+		"provider":  provider,
+		"methodKey": methodKey,
+	})
+	key := methodDisplayCacheKey(provider, methodKey)
+	if b, err := s.ctx.Cache().Get(key); err == nil {
+		md := &methodDisplays{}
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(md)
+		if err == nil {
+			return md, nil
+		}
+		log.Warn("error decoding cached display metadata", log15.Ctx{"err": err})
+	} else if err != cache.ErrNotFound {
+		log.Error("error retrieving cached display metadata", log15.Ctx{"err": err})
+	}
+	db := s.ctx.PaymentDB(service.ReadOnly)
+	displays, err := payment_method.DisplaysByMethodDB(s.ctx, db, provider, methodKey)
+	if err != nil {
+		log.Error("error retrieving display metadata", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "methodDisplays", err)
+	}
+	md := &methodDisplays{Displays: displays}
+	md.Logo, err = payment_method.LogoTimestampByMethodDB(s.ctx, db, provider, methodKey)
+	if err != nil && err != payment_method.ErrLogoNotFound {
+		log.Error("error retrieving logo", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "methodDisplays", err)
+	}
+	buf := &bytes.Buffer{}
+	err = gob.NewEncoder(buf).Encode(md)
+	if err == nil {
+		err = s.ctx.Cache().Set(key, buf.Bytes(), methodDisplayCacheTTL)
+	}
+	if err != nil {
+		log.Error("error caching display metadata", log15.Ctx{"err": err})
+	}
+	return md, nil
+}
+
+// ForgetMethodDisplay removes the cached display metadata of the payment
+// methods with the given provider and method key
+//
+// It should be called after the display metadata or the logo changed.
+func (s *Service) ForgetMethodDisplay(provider, methodKey string) {
+	err := s.ctx.Cache().Delete(methodDisplayCacheKey(provider, methodKey))
+	if err != nil && err != cache.ErrNotFound {
+		s.log.Error("error removing cached display metadata", log15.Ctx{
+			"provider":  provider,
+			"methodKey": methodKey,
+			"err":       err,
+		})
+	}
+}
+
+// MethodLogoURL returns the URL of the logo saved at the given time
+//
+// The URL changes with every new logo, so it can be cached indefinitely.
+func (s *Service) MethodLogoURL(provider, methodKey string, saved time.Time) string {
+	u := url.URL{
+		Path: strings.NewReplacer("{provider}", provider, "{methodkey}", methodKey).Replace(MethodLogoPath),
+		RawQuery: url.Values{
+			"v": []string{MethodLogoVersion(saved)},
+		}.Encode(),
+	}
+	return s.ctx.Config().Web.URL + u.String()
+}
+
+// MethodLogoVersion returns the version parameter of the URL of the logo saved
+// at the given time
+func MethodLogoVersion(saved time.Time) string {
+	return strconv.FormatInt(saved.UnixNano(), 36)
+}
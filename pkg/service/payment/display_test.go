@@ -0,0 +1,47 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSelectMethodDisplay(t *testing.T) {
+	Convey("Given shared and project display metadata", t, func() {
+		shared := []*payment_method.Display{
+			{Locale: "de", Name: "Kreditkarte"},
+			{Locale: "en", Name: "Credit card"},
+			{Locale: "fr", Name: "Carte bancaire"},
+		}
+		project := []*payment_method.Display{
+			{Locale: "de-AT", Name: "Karte"},
+			{Locale: "en", Name: "Card"},
+		}
+
+		Convey("The project display should take precedence in the same language", func() {
+			d, ok := selectMethodDisplay(project, shared, "de_DE")
+			So(ok, ShouldBeTrue)
+			So(d.Name, ShouldEqual, "Karte")
+		})
+		Convey("The shared display should be used in other languages", func() {
+			d, ok := selectMethodDisplay(project, shared, "fr_FR")
+			So(ok, ShouldBeTrue)
+			So(d.Name, ShouldEqual, "Carte bancaire")
+		})
+		Convey("The project default should be used without a match", func() {
+			d, ok := selectMethodDisplay(project, shared, "it_IT")
+			So(ok, ShouldBeTrue)
+			So(d.Name, ShouldEqual, "Card")
+		})
+		Convey("The shared default should be used without project displays", func() {
+			d, ok := selectMethodDisplay(nil, shared, "it_IT")
+			So(ok, ShouldBeTrue)
+			So(d.Name, ShouldEqual, "Credit card")
+		})
+		Convey("Nothing should be selected without displays", func() {
+			_, ok := selectMethodDisplay(nil, nil, "it_IT")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
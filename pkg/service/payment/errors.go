@@ -0,0 +1,179 @@
+package payment
+
+// errorID identifies the kind of a payment service error
+//
+// Each errorID is a sentinel error. Errors returned by the service are either
+// one of the sentinels or an *Error wrapping a sentinel. Use errors.Is to test
+// for the kind of an error.
+type errorID int
+
+func (e errorID) Error() string {
+	switch e {
+	case ErrDB:
+		return "database error"
+	case ErrDBLockTimeout:
+		return "lock wait timeout"
+	case ErrDuplicateIdent:
+		return "duplicate ident in payment"
+	case ErrPaymentCallbackConfig:
+		return "callback config error"
+	case ErrPaymentMethodNotFound:
+		return "payment method not found"
+	case ErrPaymentMethodConflict:
+		return "payment method project mismatch"
+	case ErrPaymentMethodInactive:
+		return "payment method inactive"
+	case ErrPaymentMethodDisabled:
+		return "payment method disabled"
+	case ErrInternal:
+		return "internal error"
+	case ErrIntentTimeout:
+		return "intent timeout"
+	case ErrIntentNotAllowed:
+		return "intent not allowed"
+	case ErrCommitExpired:
+		return "intent commit expired"
+	case ErrPaymentParent:
+		return "invalid parent payment"
+	case ErrPaymentNotHeld:
+		return "payment not held for review"
+	case ErrPaymentMethodCurrency:
+		return "payment method cannot process currency"
+	case ErrCaptureAmount:
+		return "invalid capture amount"
+	case ErrAuthorizationIncrement:
+		return "invalid authorization increment"
+	case ErrSessionConverted:
+		return "checkout session already converted"
+	case ErrSessionExpired:
+		return "checkout session expired"
+	case ErrSessionMismatch:
+		return "payment does not match checkout session"
+	case ErrCardNumber:
+		return "invalid card number"
+	case ErrRefundAmount:
+		return "invalid refund amount"
+	case ErrChargebackAmount:
+		return "invalid chargeback amount"
+	case ErrReferenceExhausted:
+		return "reference numbers exhausted"
+	case ErrEscrowNotHeld:
+		return "payment not held in escrow"
+	case ErrNotificationPending:
+		return "notification pending"
+	case ErrSubscriptionPayment:
+		return "invalid initial payment of subscription"
+	case ErrSubscriptionStatus:
+		return "subscription status change not allowed"
+	case ErrSubscriptionMethod:
+		return "payment method cannot charge recurring payments"
+	default:
+		return "unknown error"
+	}
+}
+
+const (
+	// general database error
+	ErrDB errorID = iota
+	// lock wait timeout
+	ErrDBLockTimeout
+	// duplicate Ident in payment
+	ErrDuplicateIdent
+	// callback config error
+	ErrPaymentCallbackConfig
+	// payment method not found
+	ErrPaymentMethodNotFound
+	// payment method project mismatch
+	ErrPaymentMethodConflict
+	// payment method inactive
+	ErrPaymentMethodInactive
+	// payment method disabled
+	ErrPaymentMethodDisabled
+	// internal error
+	ErrInternal
+	// intent timeout
+	ErrIntentTimeout
+	// intent not allowed
+	ErrIntentNotAllowed
+	// intent committed after the commit timeout
+	ErrCommitExpired
+	// parent payment not found or invalid relation
+	ErrPaymentParent
+	// review of a payment which is not held
+	ErrPaymentNotHeld
+	// payment method (and its fallbacks) cannot process the payment currency
+	ErrPaymentMethodCurrency
+	// capture amount not positive or exceeding the authorized amount
+	ErrCaptureAmount
+	// authorization increment not positive
+	ErrAuthorizationIncrement
+	// checkout session was already converted into a payment
+	ErrSessionConverted
+	// checkout session expired
+	ErrSessionExpired
+	// payment project, amount, currency or country differ from the checkout
+	// session
+	ErrSessionMismatch
+	// card number or BIN invalid
+	ErrCardNumber
+	// refund amount not positive or exceeding the refundable amount
+	ErrRefundAmount
+	// chargeback amount negative or exceeding the disputable amount
+	ErrChargebackAmount
+	// sequence numbers exceed the digits of the reference scheme
+	ErrReferenceExhausted
+	// release of a payment which is not held in escrow
+	ErrEscrowNotHeld
+	// retry of a notification which is waiting for its delivery
+	ErrNotificationPending
+	// initial payment of a subscription not paid or not matching the plan
+	ErrSubscriptionPayment
+	// status change of a subscription not allowed in its current status
+	ErrSubscriptionStatus
+	// provider driver of the payment method of the initial payment cannot
+	// charge recurring payments
+	ErrSubscriptionMethod
+)
+
+// Error is an error of the payment service which carries the context of the
+// failure
+//
+// The underlying error, e.g. a database or provider error, can be accessed
+// with errors.Unwrap or errors.As. errors.Is reports true for the sentinel of
+// the error kind.
+type Error struct {
+	// ID is the kind of the error
+	ID errorID
+	// Op is the operation which failed, usually the service method
+	Op string
+	// Err is the underlying error, can be nil
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := e.ID.Error()
+	if e.Op != "" {
+		msg = e.Op + ": " + msg
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is the sentinel of the error kind
+func (e *Error) Is(target error) bool {
+	id, ok := target.(errorID)
+	return ok && id == e.ID
+}
+
+// wrapError returns an error of the given kind for the failed operation caused
+// by err
+func wrapError(id errorID, op string, err error) error {
+	return &Error{ID: id, Op: op, Err: err}
+}
@@ -0,0 +1,51 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestError(t *testing.T) {
+	Convey("Given a wrapped database error", t, func() {
+		cause := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+		err := wrapError(ErrDBLockTimeout, "SetPaymentTransaction", cause)
+
+		Convey("It should match its sentinel", func() {
+			So(errors.Is(err, ErrDBLockTimeout), ShouldBeTrue)
+			So(errors.Is(err, ErrDB), ShouldBeFalse)
+		})
+		Convey("It should unwrap the underlying error", func() {
+			var mysqlErr *mysql.MySQLError
+			So(errors.As(err, &mysqlErr), ShouldBeTrue)
+			So(mysqlErr.Number, ShouldEqual, 1213)
+		})
+		Convey("It should be accessible as a payment service error", func() {
+			var svcErr *Error
+			So(errors.As(err, &svcErr), ShouldBeTrue)
+			So(svcErr.ID, ShouldEqual, ErrDBLockTimeout)
+			So(svcErr.Op, ShouldEqual, "SetPaymentTransaction")
+		})
+		Convey("It should describe the operation and the cause", func() {
+			So(err.Error(), ShouldEqual, "SetPaymentTransaction: lock wait timeout: "+cause.Error())
+		})
+		Convey("When it is wrapped again", func() {
+			err = fmt.Errorf("batch: %w", err)
+
+			Convey("It should still match its sentinel", func() {
+				So(errors.Is(err, ErrDBLockTimeout), ShouldBeTrue)
+			})
+		})
+	})
+	Convey("Given an error without cause", t, func() {
+		err := &Error{ID: ErrInternal}
+
+		Convey("It should print the sentinel message", func() {
+			So(err.Error(), ShouldEqual, ErrInternal.Error())
+			So(errors.Unwrap(err), ShouldBeNil)
+		})
+	})
+}
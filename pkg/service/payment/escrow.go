@@ -0,0 +1,239 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// escrowReleaseBatchSize is the maximum number of escrows released per run
+	// of the release job
+	escrowReleaseBatchSize = 500
+)
+
+// adjustEscrow updates the escrow of the payment with the amount of a new
+// payment transaction
+//
+// Captured funds of projects in escrow mode are held in escrow until they are
+// released. Funds returned to the customer while being held, e.g. by refunds or
+// chargebacks, reduce the held amount. Funds returned by reversals are not held
+// again.
+func (s *Service) adjustEscrow(tx *sql.Tx, paymentTx *payment.PaymentTransaction) error {
+	p := paymentTx.Payment
+	if p.IsVerification() || paymentTx.Amount == 0 || paymentTx.Status == payment.PaymentStatusOpen {
+		return nil
+	}
+	log := s.log.New(log15.Ctx{
+		"method":    "adjustEscrow",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	var captured bool
+	switch paymentTx.Status {
+	case payment.PaymentStatusPaid, payment.PaymentStatusPartiallyPaid, payment.PaymentStatusPartiallyCaptured:
+		captured = paymentTx.Amount > 0
+	}
+	if !captured && paymentTx.Amount > 0 {
+		return nil
+	}
+	var hold time.Duration
+	if captured {
+		pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+		if err != nil {
+			log.Error("error retrieving project", log15.Ctx{"err": err})
+			return wrapError(ErrDB, "adjustEscrow", err)
+		}
+		var ok bool
+		if hold, ok = pr.Config.EscrowHoldDuration(); !ok {
+			return nil
+		}
+	}
+	current, err := payment.PaymentEscrowCurrentTx(s.ctx, tx, p)
+	if err != nil && err != payment.ErrEscrowNotFound {
+		log.Error("error retrieving payment escrow", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "adjustEscrow", err)
+	}
+	held := err == nil && current.IsHeld()
+	if !captured && !held {
+		return nil
+	}
+	e := p.NewEscrow(payment.EscrowHeld, "paymentd")
+	e.Timestamp = paymentTx.Timestamp
+	e.Comment.String, e.Comment.Valid = paymentTx.Status.String(), true
+	if held {
+		e.Amount = current.Amount
+		e.ReleaseAfter = current.ReleaseAfter
+	} else {
+		e.ReleaseAfter = paymentTx.Timestamp.Add(hold)
+	}
+	e.Amount += paymentTx.Amount
+	if e.Amount <= 0 {
+		e.Status = payment.EscrowReturned
+		e.Amount = 0
+		e.ReleaseAfter = time.Time{}
+	}
+	return s.setEscrow(tx, p, e)
+}
+
+// PaymentEscrow returns the current escrow status of the payment
+//
+// It returns a payment.ErrEscrowNotFound if the payment was never held in
+// escrow.
+func (s *Service) PaymentEscrow(tx *sql.Tx, p *payment.Payment) (*payment.Escrow, error) {
+	return payment.PaymentEscrowCurrentTx(s.ctx, tx, p)
+}
+
+// ReleaseEscrow is the release intent of a payment held in escrow
+//
+// The held funds will be eligible for payout. The returned CommitIntentFunc has
+// to be called after the tx was committed to notify the project.
+func (s *Service) ReleaseEscrow(tx *sql.Tx, p *payment.Payment, createdBy, comment string) (*payment.Escrow, CommitIntentFunc, error) {
+	current, err := payment.PaymentEscrowCurrentTx(s.ctx, tx, p)
+	if err != nil {
+		if err == payment.ErrEscrowNotFound {
+			return nil, nil, ErrEscrowNotHeld
+		}
+		s.log.Error("error retrieving payment escrow", log15.Ctx{
+			"method":    "ReleaseEscrow",
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+			"err":       err,
+		})
+		return nil, nil, wrapError(ErrDB, "ReleaseEscrow", err)
+	}
+	if !current.IsHeld() {
+		return nil, nil, ErrEscrowNotHeld
+	}
+	e := p.NewEscrow(payment.EscrowReleased, createdBy)
+	e.Amount = current.Amount
+	e.Comment.String, e.Comment.Valid = comment, comment != ""
+	err = s.setEscrow(tx, p, e)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, CommitIntentFunc(func() error {
+		s.notifyEscrow(p, e)
+		return nil
+	}), nil
+}
+
+// setEscrow saves the escrow status of the payment
+func (s *Service) setEscrow(tx *sql.Tx, p *payment.Payment, e *payment.Escrow) error {
+	log := s.log.New(log15.Ctx{
+		"method":    "setEscrow",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+		"escrow":    e.Status,
+	})
+	err := payment.InsertPaymentEscrowTx(s.ctx, tx, p, e)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "setEscrow", err)
+		}
+		log.Error("error saving payment escrow", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "setEscrow", err)
+	}
+	change, err := payment.NewEscrowChange(p, e)
+	if err != nil {
+		log.Error("error creating change", log15.Ctx{"err": err})
+		return wrapError(ErrInternal, "setEscrow", err)
+	}
+	log.Info("payment escrow", log15.Ctx{
+		"amount":    e.Amount,
+		"createdBy": e.CreatedBy,
+	})
+	return s.addChange(tx, change)
+}
+
+func (s *Service) notifyEscrow(p *payment.Payment, e *payment.Escrow) {
+	s.NotifyEvent(p.ProjectID(), EventPaymentEscrow, map[string]string{
+		"PaymentId": s.EncodedPaymentID(p.PaymentID()).String(),
+		"Status":    e.Status,
+		"Amount":    e.Decimal().String(),
+		"Currency":  p.Currency,
+	})
+}
+
+// releaseDueEscrows releases the escrows of all shards whose hold expired
+//
+// Errors releasing an escrow are logged, so that one payment does not hold up
+// the other releases. It returns an error if every release failed.
+func (s *Service) releaseDueEscrows(done <-chan struct{}) error {
+	log := s.log.New(log15.Ctx{"method": "releaseDueEscrows"})
+	var ids []payment.PaymentID
+	for _, sh := range s.ctx.PaymentShardDBs() {
+		shardIDs, err := payment.EscrowDueDB(s.ctx, sh.DB, time.Now(), escrowReleaseBatchSize)
+		if err != nil {
+			return fmt.Errorf("shard %s: %v", sh.Shard, err)
+		}
+		ids = append(ids, shardIDs...)
+	}
+	var err error
+	var released, failed int
+	var lastErr error
+	for _, id := range ids {
+		select {
+		case <-done:
+			log.Info("release cancelled", log15.Ctx{"released": released})
+			return nil
+		default:
+		}
+		err = s.releaseDueEscrow(id)
+		if err != nil {
+			log.Error("error releasing escrow", log15.Ctx{
+				"projectID": id.ProjectID,
+				"paymentID": id.PaymentID,
+				"err":       err,
+			})
+			failed++
+			lastErr = err
+			continue
+		}
+		released++
+	}
+	if released > 0 {
+		log.Info("released due escrows", log15.Ctx{"released": released})
+	}
+	if failed > 0 && released == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+func (s *Service) releaseDueEscrow(id payment.PaymentID) error {
+	tx, err := s.ctx.ProjectPaymentDB(id.ProjectID).Begin()
+	if err != nil {
+		return err
+	}
+	p, err := payment.PaymentByIDTx(s.ctx, tx, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	current, err := payment.PaymentEscrowCurrentTx(s.ctx, tx, p)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// released by the project in the meantime
+	if !current.Due(time.Now()) {
+		return tx.Rollback()
+	}
+	_, commit, err := s.ReleaseEscrow(tx, p, JobEscrowRelease, "hold expired")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return commit()
+}
@@ -0,0 +1,187 @@
+package payment
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
+	"github.com/fritzpay/paymentd/pkg/paymentd/outbox"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/payment/notification"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Event types projects can subscribe to
+//
+// Projects without configured event types will be notified of payment
+// transactions only.
+const (
+	// EventPaymentTransaction is the notification about a new payment transaction
+	EventPaymentTransaction = "payment.transaction"
+	// EventPaymentMethodStatus is emitted when a payment method was created or
+	// its status changed
+	EventPaymentMethodStatus = "payment_method.status"
+	// EventPaymentMethodConfig is emitted when the metadata (e.g. the provider
+	// configuration) of a payment method changed
+	EventPaymentMethodConfig = "payment_method.config"
+	// EventFundsMatched is emitted when received funds were matched against a
+	// payment
+	EventFundsMatched = "funds.matched"
+	// EventPaymentAuthorization is emitted when the authorization of a payment
+	// was incremented
+	EventPaymentAuthorization = "payment.authorization"
+	// EventPaymentChargeback is emitted when a chargeback was received for a
+	// payment or the dispute was resolved
+	EventPaymentChargeback = "payment.chargeback"
+	// EventPaymentIntentRejected is emitted when an intended status change of a
+	// payment was rejected
+	EventPaymentIntentRejected = "payment.intent_rejected"
+	// EventPaymentEscrow is emitted when the funds of a payment held in escrow
+	// were released
+	EventPaymentEscrow = "payment.escrow"
+	// EventPaymentVoid is emitted when the merchant voided the authorization
+	// of a payment
+	EventPaymentVoid = "payment.void"
+	// EventSubscriptionCycle is emitted when a cycle of a subscription was
+	// charged
+	EventSubscriptionCycle = "subscription.cycle"
+	// EventSubscriptionDunning is emitted when the charge of a subscription
+	// cycle failed, was retried or the subscription was suspended
+	EventSubscriptionDunning = "subscription.dunning"
+)
+
+var defaultEvents = []string{EventPaymentTransaction}
+
+// ValidEventType returns true if the given event type is known
+func ValidEventType(eventType string) bool {
+	switch eventType {
+	case EventPaymentTransaction,
+		EventPaymentMethodStatus,
+		EventPaymentMethodConfig,
+		EventFundsMatched,
+		EventPaymentAuthorization,
+		EventPaymentChargeback,
+		EventPaymentIntentRejected,
+		EventPaymentEscrow,
+		EventPaymentVoid,
+		EventSubscriptionCycle,
+		EventSubscriptionDunning:
+		return true
+	default:
+		return false
+	}
+}
+
+// NotifyEvent notifies the project of an event, if the project has a callback
+// configured and is subscribed to the event type
+//
+// The notification will be queued for delivery in the background.
+func (s *Service) NotifyEvent(projectID int64, eventType string, data map[string]string) {
+	log := s.log.New(log15.Ctx{
+		"method":    "NotifyEvent",
+		"projectID": projectID,
+		"eventType": eventType,
+	})
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), projectID)
+	if err != nil {
+		if err == project.ErrProjectNotFound {
+			log.Crit("event with invalid project")
+			return
+		}
+		log.Error("error retrieving project", log15.Ctx{"err": err})
+		return
+	}
+	if !CanCallback(pr.Config) || !pr.Config.SubscribedTo(eventType, defaultEvents...) {
+		return
+	}
+	n := &outbox.Notification{
+		Event: eventType,
+		Data:  data,
+	}
+	s.dispatchNotification(pr, n, "", func(digest bool) error {
+		return s.doNotifyEvent(pr.Config, projectID, eventType, data, digest)
+	})
+}
+
+func (s *Service) doNotifyEvent(c Callbacker, projectID int64, eventType string, data map[string]string, digest bool) error {
+	cbURL, cbAPIVersion, cbProjectKey := c.CallbackConfig()
+	log := s.log.New(log15.Ctx{
+		"method":             "doNotifyEvent",
+		"projectID":          projectID,
+		"eventType":          eventType,
+		"callbackURL":        cbURL,
+		"callbackAPIVersion": cbAPIVersion,
+		"callbackProjectKey": cbProjectKey,
+	})
+	log.Info("notifying...")
+	req, cl, err := s.eventRequest(c, projectID, eventType, data)
+	if err != nil {
+		log.Error("error preparing event notification", log15.Ctx{"err": err})
+		return err
+	}
+	if digest {
+		req.Header.Set(DigestHeader, "1")
+	}
+	res, err := cl.Do(req)
+	if err != nil {
+		log.Error("error on HTTP request", log15.Ctx{"err": err})
+		return err
+	}
+	res.Body.Close()
+	log.Info("notified", log15.Ctx{"HTTPStatusCode": res.StatusCode})
+	return callbackStatusError(res.StatusCode)
+}
+
+// eventRequest creates the signed event notification request for the callback
+// of the project and returns it with the HTTP client to perform it
+func (s *Service) eventRequest(c Callbacker, projectID int64, eventType string, data map[string]string) (*http.Request, *http.Client, error) {
+	cbURL, cbAPIVersion, cbProjectKey := c.CallbackConfig()
+	projectKey, err := project.ProjectKeyByKeyDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), cbProjectKey)
+	if err != nil {
+		if err == project.ErrProjectKeyNotFound {
+			return nil, nil, fmt.Errorf("invalid project key %s", cbProjectKey)
+		}
+		return nil, nil, fmt.Errorf("error retrieving project key: %v", err)
+	}
+	if !projectKey.IsValid() {
+		return nil, nil, fmt.Errorf("cannot notify with invalid project key %s", cbProjectKey)
+	}
+	ev, err := notification.EventByVersion(cbAPIVersion, eventType, projectID, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating event notification: %v", err)
+	}
+	// signing
+	if projectKey.CanonicalJSON() {
+		ev.UseCanonicalJSON()
+	}
+	non, err := nonce.New()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+	secret, err := projectKey.SecretBytes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error retrieving secret: %v", err)
+	}
+	err = ev.Sign(time.Now(), non.Nonce, secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error signing event notification: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", cbURL, ev.Reader())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	cl, err := s.prepareCallback(projectID, req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error applying callback transport settings: %v", err)
+	}
+	err = s.setSignatureHeaders(req, projectID, projectKey, ev)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error signing event notification: %v", err)
+	}
+	req.Header.Set("User-Agent", ev.Identification())
+	req.Close = true
+	return req, cl, nil
+}
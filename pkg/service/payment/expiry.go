@@ -0,0 +1,132 @@
+package payment
+
+import (
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// expiryBatchSize is the maximum number of payments per project expired per
+	// run of the expiry job
+	expiryBatchSize = 500
+	// timeout of the cancellation of a provider session of an expired payment
+	expirySessionTimeout = 30 * time.Second
+)
+
+// SessionCanceller is implemented by provider drivers which keep a session
+// with the provider while a payment is open, e.g. a payment waiting for the
+// approval of the customer with the provider
+//
+// The drivers are looked up in the driver registry of the service context.
+type SessionCanceller interface {
+	// CancelSession cancels the pending session of the payment with the
+	// provider, so that the customer cannot complete the payment anymore
+	//
+	// Requests to the provider should be abandoned once the context is done.
+	CancelSession(ctx context.Context, p *payment.Payment) error
+}
+
+// IntentExpired creates a transaction for an open payment, which was not
+// processed within the payment expiry of its project
+//
+// Expired payments cannot be processed anymore. Unlike cancelling, which is
+// the decision of the customer, the expiration is the decision of paymentd.
+func (s *Service) IntentExpired(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if p.Status != payment.PaymentStatusOpen {
+		return s.rejectIntent(p, payment.PaymentStatusExpired, ErrIntentNotAllowed)
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusExpired)
+	paymentTx.Amount = 0
+	return s.handleIntent(ctx, p, paymentTx, timeout)
+}
+
+// expirePayments expires the open payments of the projects with a payment
+// expiry, which are older than the payment expiry
+func (s *Service) expirePayments(done <-chan struct{}) error {
+	log := s.log.New(log15.Ctx{"method": "expirePayments"})
+	expiries, err := project.PaymentExpiriesDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly))
+	if err != nil {
+		return err
+	}
+	var expired int
+	for projectID, expiry := range expiries {
+		ids, err := payment.PaymentsOpenCreatedBeforeDB(s.ctx, s.ctx.ProjectPaymentDB(projectID, service.ReadOnly), projectID, time.Now().Add(-expiry), expiryBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			select {
+			case <-done:
+				log.Info("expiration cancelled", log15.Ctx{"expired": expired})
+				return nil
+			default:
+			}
+			status, err := s.ApplyIntent(id, BatchIntentExpire, "expired after "+expiry.String())
+			if err != nil {
+				// processed in the meantime
+				if err == ErrIntentNotAllowed {
+					continue
+				}
+				return err
+			}
+			if status != payment.PaymentStatusExpired {
+				continue
+			}
+			expired++
+			s.cancelProviderSession(id)
+		}
+	}
+	if expired > 0 {
+		log.Info("expired payments", log15.Ctx{"expired": expired})
+	}
+	return nil
+}
+
+// cancelProviderSession cancels the pending session of the expired payment
+// with the provider of its payment method
+//
+// Sessions can only be cancelled if the driver of the provider is attached on
+// this instance. Errors are logged, since the payment expired regardless.
+func (s *Service) cancelProviderSession(id payment.PaymentID) {
+	log := s.log.New(log15.Ctx{
+		"method":    "cancelProviderSession",
+		"projectID": id.ProjectID,
+		"paymentID": id.PaymentID,
+	})
+	p, err := payment.PaymentByIDDB(s.ctx, s.ctx.ProjectPaymentDB(id.ProjectID), id)
+	if err != nil {
+		log.Error("error retrieving payment", log15.Ctx{"err": err})
+		return
+	}
+	if !p.Config.PaymentMethodID.Valid {
+		return
+	}
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return
+	}
+	dr, ok := s.ctx.Drivers().Driver(meth.Provider.Name)
+	if !ok {
+		log.Debug("provider driver not attached", log15.Ctx{"provider": meth.Provider.Name})
+		return
+	}
+	canceller, ok := dr.(SessionCanceller)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, expirySessionTimeout)
+	defer cancel()
+	err = canceller.CancelSession(ctx, p)
+	if err != nil {
+		log.Warn("error cancelling provider session", log15.Ctx{
+			"provider": meth.Provider.Name,
+			"err":      err,
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestIntentExpired(t *testing.T) {
+	Convey("Given a payment service", t, func() {
+		s := &Service{}
+		for _, status := range []payment.PaymentTransactionStatus{
+			payment.PaymentStatusPaid,
+			payment.PaymentStatusAuthorized,
+			payment.PaymentStatusCancelled,
+			payment.PaymentStatusExpired,
+		} {
+			status := status
+			Convey("Given a payment which is "+status.String(), func() {
+				p := &payment.Payment{
+					Amount:   10000,
+					Subunits: 2,
+					Currency: "EUR",
+					Status:   status,
+				}
+				Convey("When expiring the payment", func() {
+					_, _, err := s.IntentExpired(context.Background(), p, 0)
+					Convey("It should be rejected", func() {
+						So(err, ShouldEqual, ErrIntentNotAllowed)
+					})
+				})
+			})
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package payment
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/bin"
+	"github.com/fritzpay/paymentd/pkg/paymentd/job"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Job names
+const (
+	// JobPaymentTokenCleanup deletes expired payment tokens
+	JobPaymentTokenCleanup = "payment_token.cleanup"
+	// JobBINRefresh imports the local BIN dataset if it changed
+	JobBINRefresh = "bin.refresh"
+	// JobPaymentProjection projects the change feed to the payment overviews
+	JobPaymentProjection = "payment_overview.projection"
+	// JobEscrowRelease releases the payments held in escrow whose hold expired
+	JobEscrowRelease = "payment_escrow.release"
+	// JobNotificationRetry retries the failed deliveries of the notification
+	// outbox
+	JobNotificationRetry = "notification.retry"
+	// JobPaymentExpiry expires the open payments which are older than the
+	// payment expiry of their project
+	JobPaymentExpiry = "payment.expiry"
+	// JobSubscriptionCharge charges the cycles of the subscriptions which are
+	// due
+	JobSubscriptionCharge = "subscription.charge"
+	// JobPaymentBatch applies the intents of the unfinished batches to their
+	// payments
+	JobPaymentBatch = "payment_batch.run"
+)
+
+// RegisterJobs registers the background jobs of the payment service with the
+// job runner
+func (s *Service) RegisterJobs(r *service.JobRunner) error {
+	hourly, err := job.ParseSchedule("@hourly")
+	if err != nil {
+		return err
+	}
+	err = r.Register(&service.Job{
+		Name:     JobPaymentTokenCleanup,
+		Schedule: hourly,
+		Retry: job.RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     time.Minute,
+		},
+		Run: s.cleanupPaymentTokens,
+	})
+	if err != nil {
+		return err
+	}
+	err = r.Register(&service.Job{
+		Name:     JobEscrowRelease,
+		Schedule: hourly,
+		Retry: job.RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     time.Minute,
+		},
+		Run: s.releaseDueEscrows,
+	})
+	if err != nil {
+		return err
+	}
+	everyMinute, err := job.ParseSchedule("@every 1m")
+	if err != nil {
+		return err
+	}
+	err = r.Register(&service.Job{
+		Name:     JobPaymentExpiry,
+		Schedule: everyMinute,
+		Retry: job.RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     time.Minute,
+		},
+		Run: s.expirePayments,
+	})
+	if err != nil {
+		return err
+	}
+	err = r.Register(&service.Job{
+		Name:     JobSubscriptionCharge,
+		Schedule: everyMinute,
+		Retry: job.RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     time.Minute,
+		},
+		Run: s.chargeDueSubscriptions,
+	})
+	if err != nil {
+		return err
+	}
+	err = r.Register(&service.Job{
+		Name:     JobPaymentBatch,
+		Schedule: everyMinute,
+		Retry: job.RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     time.Minute,
+		},
+		Run: s.runBatches,
+	})
+	if err != nil {
+		return err
+	}
+	every30s, err := job.ParseSchedule("@every 30s")
+	if err != nil {
+		return err
+	}
+	err = r.Register(&service.Job{
+		Name:     JobNotificationRetry,
+		Schedule: every30s,
+		Run:      s.retryNotifications,
+	})
+	if err != nil {
+		return err
+	}
+	if s.ctx.Config().Payment.Projection {
+		every, err := job.ParseSchedule("@every 10s")
+		if err != nil {
+			return err
+		}
+		err = r.Register(&service.Job{
+			Name:     JobPaymentProjection,
+			Schedule: every,
+			Run:      s.projectChanges,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if s.ctx.Config().Provider.BINDataset == "" {
+		return nil
+	}
+	daily, err := job.ParseSchedule("@daily")
+	if err != nil {
+		return err
+	}
+	return r.Register(&service.Job{
+		Name:     JobBINRefresh,
+		Schedule: daily,
+		Retry: job.RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     time.Minute,
+		},
+		Run: s.refreshBINDataset,
+	})
+}
+
+// cleanupPaymentTokens deletes the payment tokens of all shards which cannot
+// be used anymore
+func (s *Service) cleanupPaymentTokens(done <-chan struct{}) error {
+	var n int64
+	for _, sh := range s.ctx.PaymentShardDBs() {
+		deleted, err := payment.DeletePaymentTokensCreatedBeforeDB(s.ctx, sh.DB, time.Now().Add(-PaymentTokenMaxAgeDefault))
+		if err != nil {
+			return fmt.Errorf("shard %s: %v", sh.Shard, err)
+		}
+		n += deleted
+	}
+	s.log.Info("deleted expired payment tokens", log15.Ctx{
+		"method": "cleanupPaymentTokens",
+		"tokens": n,
+	})
+	return nil
+}
+
+// refreshBINDataset imports the local BIN dataset as a new dataset
+//
+// The import is skipped if the file did not change since the latest import.
+func (s *Service) refreshBINDataset(done <-chan struct{}) error {
+	log := s.log.New(log15.Ctx{"method": "refreshBINDataset"})
+	path := s.ctx.Config().Provider.BINDataset
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ds := &bin.Dataset{
+		Created:   time.Now(),
+		Checksum:  bin.Checksum(b),
+		Source:    path,
+		CreatedBy: JobBINRefresh,
+	}
+	latest, err := bin.LatestDatasetDB(s.ctx.PaymentDB())
+	if err != nil && err != bin.ErrDatasetNotFound {
+		return err
+	}
+	if latest != nil && latest.Checksum == ds.Checksum {
+		log.Info("BIN dataset unchanged", log15.Ctx{"created": latest.Created})
+		return nil
+	}
+	ranges, err := bin.ParseCSV(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("error parsing BIN dataset %s: %v", path, err)
+	}
+	tx, err := s.ctx.PaymentDB().Begin()
+	if err != nil {
+		return err
+	}
+	err = bin.InsertDatasetTx(tx, ds, ranges)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	log.Info("imported BIN dataset", log15.Ctx{
+		"source": path,
+		"ranges": ds.Ranges,
+	})
+	return nil
+}
@@ -0,0 +1,35 @@
+package payment
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Balance returns the ledger of the payment
+//
+// It aggregates the open, authorized, captured, refunded and charged back
+// amounts from the transactions of the payment. The authorization of the
+// payment will be loaded if it is not present.
+func (s *Service) Balance(tx *sql.Tx, p *payment.Payment) (*payment.Ledger, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "Balance",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	if p.Authorization == nil {
+		err := payment.PaymentAuthorizationTx(s.ctx, tx, p)
+		if err != nil {
+			log.Error("error retrieving payment authorization", log15.Ctx{"err": err})
+			return nil, wrapError(ErrDB, "Balance", err)
+		}
+	}
+	txs, err := payment.PaymentTransactionsBeforeTimestampTx(s.ctx, tx, p, time.Now())
+	if err != nil && err != payment.ErrPaymentTransactionNotFound {
+		log.Error("error retrieving payment transactions", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "Balance", err)
+	}
+	return payment.NewLedger(p, txs), nil
+}
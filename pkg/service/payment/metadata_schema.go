@@ -0,0 +1,65 @@
+package payment
+
+import (
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// MetadataSchema returns the schema of the payment metadata of the project
+//
+// If the project has no schema configured, it returns nil.
+func (s *Service) MetadataSchema(projectID int64) (*metadata.Schema, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "MetadataSchema",
+		"projectID": projectID,
+	})
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), projectID)
+	if err != nil {
+		log.Error("error retrieving project", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "MetadataSchema", err)
+	}
+	schema, err := pr.Config.PaymentMetadataSchema()
+	if err != nil {
+		log.Error("invalid metadata schema", log15.Ctx{"err": err})
+		return nil, wrapError(ErrInternal, "MetadataSchema", err)
+	}
+	return schema, nil
+}
+
+// validateMetadata validates the metadata of the payment against the size
+// limits and the schema of its project
+//
+// It returns a *metadata.LimitError if the metadata exceeds the limits and a
+// *metadata.SchemaError if the metadata does not match the schema.
+func (s *Service) validateMetadata(p *payment.Payment) error {
+	log := s.log.New(log15.Ctx{
+		"method":    "validateMetadata",
+		"projectID": p.ProjectID(),
+	})
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		log.Error("error retrieving project", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "validateMetadata", err)
+	}
+	limits, err := pr.Config.PaymentMetadataLimits()
+	if err != nil {
+		log.Error("invalid metadata limits", log15.Ctx{"err": err})
+		return wrapError(ErrInternal, "validateMetadata", err)
+	}
+	err = limits.Validate(p.Metadata)
+	if err != nil {
+		return err
+	}
+	schema, err := pr.Config.PaymentMetadataSchema()
+	if err != nil {
+		log.Error("invalid metadata schema", log15.Ctx{"err": err})
+		return wrapError(ErrInternal, "validateMetadata", err)
+	}
+	if schema == nil {
+		return nil
+	}
+	return schema.Validate(p.Metadata)
+}
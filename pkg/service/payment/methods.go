@@ -0,0 +1,111 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/cache"
+	"github.com/fritzpay/paymentd/pkg/paymentd/method_preference"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// paymentMethodCacheTTL is the time for which payment methods will be cached
+const paymentMethodCacheTTL = 30 * time.Second
+
+// paymentMethodCacheKey returns the cache key of a payment method
+//
+// The IDs of payment methods are unique per payment database shard.
+func paymentMethodCacheKey(shard string, id int64) string {
+	return "paymentmethod:" + shard + ":" + strconv.FormatInt(id, 10)
+}
+
+// PaymentMethod retrieves the payment method with the given ID of the given
+// project through the shared cache
+//
+// Changes of the payment method status may take up to 30 seconds to be seen.
+// Lookups inside of transactions which must lock the payment method should
+// use the payment_method package directly.
+func (s *Service) PaymentMethod(projectID, id int64) (*payment_method.Method, error) {
+	log := s.log.New(log15.Ctx{
+		"method":          "PaymentMethod",
+		"projectID":       projectID,
+		"paymentMethodID": id,
+	})
+	shard := s.ctx.Shards().Shard(projectID)
+	if b, err := s.ctx.Cache().Get(paymentMethodCacheKey(shard, id)); err == nil {
+		meth := &payment_method.Method{}
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(meth)
+		if err == nil {
+			return meth, nil
+		}
+		log.Warn("error decoding cached payment method", log15.Ctx{"err": err})
+	} else if err != cache.ErrNotFound {
+		log.Error("error retrieving cached payment method", log15.Ctx{"err": err})
+	}
+	meth, err := payment_method.PaymentMethodByIDDB(s.ctx, s.ctx.ProjectPaymentDB(projectID, service.ReadOnly), id)
+	if err != nil {
+		return nil, err
+	}
+	err = s.cachePaymentMethod(shard, meth)
+	if err != nil {
+		log.Error("error caching payment method", log15.Ctx{"err": err})
+	}
+	return meth, nil
+}
+
+func (s *Service) cachePaymentMethod(shard string, meth *payment_method.Method) error {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(meth)
+	if err != nil {
+		return err
+	}
+	return s.ctx.Cache().Set(paymentMethodCacheKey(shard, meth.ID), buf.Bytes(), paymentMethodCacheTTL)
+}
+
+// warmPaymentMethods loads the active payment methods of all shards into the
+// cache
+func (s *Service) warmPaymentMethods() (int, error) {
+	var n int
+	for _, sh := range s.ctx.PaymentShardDBs(service.ReadOnly) {
+		methods, err := payment_method.PaymentMethodsByStatusDB(s.ctx, sh.DB, payment_method.PaymentMethodStatusActive)
+		if err != nil {
+			return n, err
+		}
+		for _, meth := range methods {
+			err = s.cachePaymentMethod(sh.Shard, meth)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// MethodPreference returns the order and default of the payment methods for
+// the country of the payment
+//
+// Projects without configured preferences use the default preferences.
+func (s *Service) MethodPreference(p *payment.Payment) (method_preference.Preference, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "MethodPreference",
+		"projectID": p.ProjectID(),
+	})
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		log.Error("error retrieving project", log15.Ctx{"err": err})
+		return method_preference.Preference{}, wrapError(ErrDB, "MethodPreference", err)
+	}
+	prefs, err := pr.Config.PaymentMethodPreferences()
+	if err != nil {
+		log.Error("invalid payment method preferences", log15.Ctx{"err": err})
+		return method_preference.Preference{}, wrapError(ErrInternal, "MethodPreference", err)
+	}
+	return prefs.For(p.Config.Country.String), nil
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"time"
 
+	"github.com/fritzpay/paymentd/pkg/metadata"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/service"
 	notificationV2 "github.com/fritzpay/paymentd/pkg/service/payment/notification/v2"
@@ -19,7 +20,12 @@ type NewNotificationFunc func(encPaymentID payment.PaymentID, p *payment.Payment
 type Notification interface {
 	service.Signable
 	SetTransactions(payment.PaymentTransactionList)
+	SetParent(encParentID payment.PaymentID, relation string)
+	SetMetadataSchema(*metadata.Schema)
+	SetPaymentMethodName(string)
+	UseCanonicalJSON()
 	Sign(time.Time, string, []byte) error
+	KeySignature(secret []byte, canonical bool) (string, error)
 	Reader() io.ReadCloser
 	Identification() string
 }
@@ -34,3 +40,23 @@ func NotificationByVersion(ver string) (NewNotificationFunc, error) {
 		return nil, ErrInvalidNotificationVersion
 	}
 }
+
+// Event describes a notification about a change of an entity other than a
+// payment
+type Event interface {
+	service.Signable
+	UseCanonicalJSON()
+	Sign(time.Time, string, []byte) error
+	KeySignature(secret []byte, canonical bool) (string, error)
+	Reader() io.ReadCloser
+	Identification() string
+}
+
+func EventByVersion(ver, eventType string, projectID int64, data map[string]string) (Event, error) {
+	switch ver {
+	case "2":
+		return notificationV2.NewEvent(eventType, projectID, data), nil
+	default:
+		return nil, ErrInvalidNotificationVersion
+	}
+}
@@ -0,0 +1,137 @@
+package notification
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/maputil"
+	"github.com/fritzpay/paymentd/pkg/service"
+)
+
+const (
+	EventNotificationVersion = "2.0.0-alpha"
+)
+
+// Event represents a notification for connected systems about a change of an
+// entity other than a payment, e.g. a payment method
+type Event struct {
+	Version   string
+	Event     string
+	ProjectId int64             `json:",string"`
+	Data      map[string]string `json:",omitempty"`
+	Timestamp int64             `json:",string"`
+	Nonce     string            `json:",omitempty"`
+	Signature string            `json:",omitempty"`
+
+	canonical bool
+}
+
+// NewEvent creates a new event notification of the given type
+func NewEvent(eventType string, projectID int64, data map[string]string) *Event {
+	return &Event{
+		Version:   EventNotificationVersion,
+		Event:     eventType,
+		ProjectId: projectID,
+		Data:      data,
+	}
+}
+
+func (e *Event) Identification() string {
+	return fmt.Sprintf("event notification %s", e.Version)
+}
+
+// UseCanonicalJSON lets the signature cover the canonical JSON serialization
+// of the event instead of the signature base string
+func (e *Event) UseCanonicalJSON() {
+	e.canonical = true
+}
+
+func (e *Event) Sign(timestamp time.Time, nonce string, secret []byte) error {
+	e.Timestamp = timestamp.Unix()
+	e.Nonce = nonce
+	var msg service.Signable = e
+	if e.canonical {
+		msg = service.CanonicalSignable(e, nil)
+	}
+	sig, err := service.Sign(msg, secret)
+	if err != nil {
+		return err
+	}
+	e.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+// KeySignature returns the hex encoded signature of the signed event with
+// another key
+//
+// The signature covers the timestamp and nonce of the last Sign. If canonical
+// is true, it covers the canonical JSON serialization.
+func (e *Event) KeySignature(secret []byte, canonical bool) (string, error) {
+	var msg service.Signable = e
+	if canonical {
+		msg = service.CanonicalSignable(e, nil)
+	}
+	sig, err := service.Sign(msg, secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sig), nil
+}
+
+func (e *Event) Message() ([]byte, error) {
+	var err error
+	buf := bytes.NewBuffer(nil)
+	_, err = buf.WriteString(e.Version)
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.WriteString(e.Event)
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.WriteString(strconv.FormatInt(e.ProjectId, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	if e.Data != nil {
+		err = maputil.WriteSortedMap(buf, e.Data)
+		if err != nil {
+			return nil, fmt.Errorf("buffer write error: %v", err)
+		}
+	}
+	_, err = buf.WriteString(strconv.FormatInt(e.Timestamp, 10))
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	_, err = buf.WriteString(e.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("buffer write error: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *Event) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+func (e *Event) Reader() io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(w)
+		err := enc.Encode(e)
+		if err != nil {
+			r.CloseWithError(err)
+			w.CloseWithError(err)
+			return
+		}
+		w.Close()
+	}()
+	return r
+}
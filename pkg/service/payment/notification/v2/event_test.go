@@ -0,0 +1,74 @@
+package notification
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEventSignature(t *testing.T) {
+	Convey("Given an event notification", t, func() {
+		ev := NewEvent("funds.matched", 1234, map[string]string{
+			"FundsId":  "1",
+			"Currency": "EUR",
+		})
+		secret := []byte("secret")
+
+		Convey("When signing the event", func() {
+			err := ev.Sign(time.Unix(1400000000, 0), "nonce", secret)
+			So(err, ShouldBeNil)
+
+			Convey("The message should contain the sorted data", func() {
+				msg, err := ev.Message()
+				So(err, ShouldBeNil)
+				So(string(msg), ShouldEqual, EventNotificationVersion+"funds.matched1234CurrencyEURFundsId11400000000nonce")
+			})
+
+			Convey("The signature should be verifiable", func() {
+				sig, err := hex.DecodeString(ev.Signature)
+				So(err, ShouldBeNil)
+				expect, err := service.Sign(ev, secret)
+				So(err, ShouldBeNil)
+				So(hmac.Equal(sig, expect), ShouldBeTrue)
+			})
+
+			Convey("The key signature of the same key should equal the signature", func() {
+				sig, err := ev.KeySignature(secret, false)
+				So(err, ShouldBeNil)
+				So(sig, ShouldEqual, ev.Signature)
+			})
+
+			Convey("When signing the event with a previous key", func() {
+				sig, err := ev.KeySignature([]byte("previous"), false)
+				So(err, ShouldBeNil)
+
+				Convey("It should be verifiable with the previous key", func() {
+					So(sig, ShouldNotEqual, ev.Signature)
+					expect, err := service.Sign(ev, []byte("previous"))
+					So(err, ShouldBeNil)
+					So(sig, ShouldEqual, hex.EncodeToString(expect))
+				})
+			})
+
+			Convey("When reading the event", func() {
+				body, err := ioutil.ReadAll(ev.Reader())
+				So(err, ShouldBeNil)
+
+				Convey("It should be encoded as JSON", func() {
+					dec := &Event{}
+					err = json.Unmarshal(body, dec)
+					So(err, ShouldBeNil)
+					So(dec.Event, ShouldEqual, "funds.matched")
+					So(dec.ProjectId, ShouldEqual, 1234)
+					So(dec.Signature, ShouldEqual, ev.Signature)
+				})
+			})
+		})
+	})
+}
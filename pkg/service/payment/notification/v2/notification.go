@@ -11,7 +11,9 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/fritzpay/paymentd/pkg/billing"
 	"github.com/fritzpay/paymentd/pkg/maputil"
+	"github.com/fritzpay/paymentd/pkg/metadata"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/service"
 )
@@ -23,23 +25,73 @@ const (
 // PaymentNotification represents a notification for connected systems about
 // the state of a payment
 type Notification struct {
-	Version              string
-	PaymentId            payment.PaymentID
-	Ident                string
-	Amount               int64 `json:",string"`
-	Subunits             int8  `json:",string"`
-	DecimalAmount        string
-	Currency             string
-	Country              string            `json:",omitempty"`
-	PaymentMethodId      int64             `json:",string,omitempty"`
-	Locale               string            `json:",omitempty"`
-	Balance              payment.Balance   `json:",omitempty"`
-	Status               string            `json:",omitempty"`
-	TransactionTimestamp int64             `json:",string,omitempty"`
-	Metadata             map[string]string `json:",omitempty"`
-	Timestamp            int64             `json:",string"`
-	Nonce                string            `json:",omitempty"`
-	Signature            string            `json:",omitempty"`
+	Version         string
+	PaymentId       payment.PaymentID
+	Ident           string
+	Amount          int64 `json:",string"`
+	Subunits        int8  `json:",string"`
+	DecimalAmount   string
+	Currency        string
+	Country         string             `json:",omitempty"`
+	PaymentMethodId int64              `json:",string,omitempty"`
+	Locale          string             `json:",omitempty"`
+	ParentPaymentId *payment.PaymentID `json:",omitempty"`
+	Relation        string             `json:",omitempty"`
+	Balance         payment.Balance    `json:",omitempty"`
+	// AuthorizedAmount and DecimalAuthorizedAmount are the authorized amount
+	// of authorized payments. They are not part of the signature base string,
+	// since clients of this version do not expect them.
+	AuthorizedAmount        int64             `json:",string,omitempty"`
+	DecimalAuthorizedAmount string            `json:",omitempty"`
+	Status                  string            `json:",omitempty"`
+	TransactionTimestamp    int64             `json:",string,omitempty"`
+	Metadata                map[string]string `json:",omitempty"`
+	// Fields are the typed metadata entries described by the metadata schema
+	// of the project. They are not part of the signature base string.
+	Fields map[string]interface{} `json:",omitempty"`
+	// Note is the free-text note of the payment. It is not part of the
+	// signature base string.
+	Note string `json:",omitempty"`
+	// Reference is the reference number of the payment. It is not part of the
+	// signature base string.
+	Reference string `json:",omitempty"`
+	// Billing is the billing data collected in the checkout. It is not part of
+	// the signature base string.
+	Billing *billing.Data `json:",omitempty"`
+	// PaymentMethodName is the display name of the payment method in the
+	// locale of the payment. It is not part of the signature base string.
+	PaymentMethodName string `json:",omitempty"`
+	// Ledger aggregates the amounts of the payment by their state. It is not
+	// part of the signature base string.
+	Ledger    *Ledger `json:",omitempty"`
+	Timestamp int64   `json:",string"`
+	Nonce     string  `json:",omitempty"`
+	Signature string  `json:",omitempty"`
+
+	payment   *payment.Payment
+	canonical bool
+}
+
+// Ledger holds the decimal amounts of the ledger of a payment
+type Ledger struct {
+	Open        string
+	Authorized  string
+	Captured    string
+	Refunded    string
+	ChargedBack string
+	// Net is the captured amount less refunds and chargebacks
+	Net string
+}
+
+func newLedger(l *payment.Ledger) *Ledger {
+	return &Ledger{
+		Open:        l.Decimal(l.Open).String(),
+		Authorized:  l.Decimal(l.Authorized).String(),
+		Captured:    l.Decimal(l.Captured).String(),
+		Refunded:    l.Decimal(l.Refunded).String(),
+		ChargedBack: l.Decimal(l.ChargedBack).String(),
+		Net:         l.Decimal(l.Net()).String(),
+	}
 }
 
 func New(encodedPaymentID payment.PaymentID, p *payment.Payment) (*Notification, error) {
@@ -53,6 +105,18 @@ func New(encodedPaymentID payment.PaymentID, p *payment.Payment) (*Notification,
 		Currency:      p.Currency,
 		Status:        p.Status.String(),
 		Metadata:      p.Metadata,
+		Reference:     p.Reference,
+		payment:       p,
+	}
+	if p.Note != nil {
+		n.Note = p.Note.Text
+	}
+	if p.Billing != nil {
+		n.Billing = &p.Billing.Data
+	}
+	if p.Authorization != nil {
+		n.AuthorizedAmount = p.Authorization.Amount
+		n.DecimalAuthorizedAmount = p.Authorization.Decimal().String()
 	}
 	if !p.TransactionTimestamp.IsZero() {
 		n.TransactionTimestamp = p.TransactionTimestamp.UnixNano()
@@ -76,14 +140,47 @@ func (n *Notification) Identification() string {
 	return fmt.Sprintf("payment notification %s", n.Version)
 }
 
+// SetParent sets the parent of the payment
+func (n *Notification) SetParent(encodedParentID payment.PaymentID, relation string) {
+	n.ParentPaymentId = &encodedParentID
+	n.Relation = relation
+}
+
+// SetMetadataSchema sets the typed fields of the metadata described by the
+// schema
+func (n *Notification) SetMetadataSchema(schema *metadata.Schema) {
+	if schema == nil || n.Metadata == nil {
+		return
+	}
+	n.Fields = schema.Typed(n.Metadata)
+}
+
+// SetPaymentMethodName sets the display name of the payment method
+func (n *Notification) SetPaymentMethodName(name string) {
+	n.PaymentMethodName = name
+}
+
+// SetTransactions sets the balance and the ledger of the payment from its
+// transactions
 func (n *Notification) SetTransactions(tl payment.PaymentTransactionList) {
 	n.Balance = tl.Balance()
+	n.Ledger = newLedger(payment.NewLedger(n.payment, tl))
+}
+
+// UseCanonicalJSON lets the signature cover the canonical JSON serialization
+// of the notification instead of the signature base string
+func (n *Notification) UseCanonicalJSON() {
+	n.canonical = true
 }
 
 func (n *Notification) Sign(timestamp time.Time, nonce string, secret []byte) error {
 	n.Timestamp = timestamp.Unix()
 	n.Nonce = nonce
-	sig, err := service.Sign(n, secret)
+	var msg service.Signable = n
+	if n.canonical {
+		msg = service.CanonicalSignable(n, nil)
+	}
+	sig, err := service.Sign(msg, secret)
 	if err != nil {
 		return err
 	}
@@ -91,6 +188,23 @@ func (n *Notification) Sign(timestamp time.Time, nonce string, secret []byte) er
 	return nil
 }
 
+// KeySignature returns the hex encoded signature of the signed notification with
+// another key
+//
+// The signature covers the timestamp and nonce of the last Sign. If canonical
+// is true, it covers the canonical JSON serialization.
+func (n *Notification) KeySignature(secret []byte, canonical bool) (string, error) {
+	var msg service.Signable = n
+	if canonical {
+		msg = service.CanonicalSignable(n, nil)
+	}
+	sig, err := service.Sign(msg, secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sig), nil
+}
+
 func (n *Notification) Message() ([]byte, error) {
 	var err error
 	buf := bytes.NewBuffer(nil)
@@ -138,6 +252,16 @@ func (n *Notification) Message() ([]byte, error) {
 			return nil, fmt.Errorf("buffer write error: %v", err)
 		}
 	}
+	if n.ParentPaymentId != nil {
+		_, err = buf.WriteString(n.ParentPaymentId.String())
+		if err != nil {
+			return nil, fmt.Errorf("buffer write error: %v", err)
+		}
+		_, err = buf.WriteString(n.Relation)
+		if err != nil {
+			return nil, fmt.Errorf("buffer write error: %v", err)
+		}
+	}
 	if n.Balance != nil {
 		balanceMap := n.Balance.FlatMap()
 		err = maputil.WriteSortedMap(buf, balanceMap)
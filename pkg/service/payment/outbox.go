@@ -0,0 +1,385 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/outbox"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// notificationRetryBatch is the maximum number of due notifications retried in
+// one run of the retry job
+const notificationRetryBatch = 100
+
+// createdByDispatcher is the creator of the statuses of delivery attempts
+const createdByDispatcher = "notification.dispatcher"
+
+// dispatchNotification saves the notification in the outbox and queues it for
+// delivery
+//
+// If the notification cannot be saved, it will be delivered once without
+// retries.
+func (s *Service) dispatchNotification(pr *project.Project, n *outbox.Notification, key string, deliver service.DeliverFunc) {
+	log := s.log.New(log15.Ctx{
+		"method":    "dispatchNotification",
+		"projectID": pr.ID,
+		"eventType": n.Event,
+	})
+	n.ProjectID = pr.ID
+	st := n.NewStatus(outbox.StatusPending, createdByDispatcher)
+	st.NextAttempt = st.Timestamp.Add(s.notificationLease(st.Attempts))
+	err := s.insertNotification(n)
+	if err != nil {
+		log.Error("error saving notification in outbox, delivering without retries", log15.Ctx{"err": err})
+		s.ctx.Notifications().Dispatch(pr.ID, deliveryLimits(pr.Config), &service.Delivery{
+			Key:     key,
+			Deliver: deliver,
+		})
+		return
+	}
+	s.queueNotification(pr, n, key, deliver)
+}
+
+func (s *Service) insertNotification(n *outbox.Notification) error {
+	tx, err := s.ctx.ProjectPaymentDB(n.ProjectID).Begin()
+	if err != nil {
+		return err
+	}
+	err = outbox.InsertNotificationTx(tx, n)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// queueNotification queues a notification of the outbox for delivery
+//
+// The outcome of the delivery will be recorded in the outbox.
+func (s *Service) queueNotification(pr *project.Project, n *outbox.Notification, key string, deliver service.DeliverFunc) {
+	s.ctx.Notifications().Dispatch(pr.ID, deliveryLimits(pr.Config), &service.Delivery{
+		Key: key,
+		Deliver: func(digest bool) error {
+			err := deliver(digest)
+			s.recordAttempt(n.ProjectID, n.ID, err)
+			return err
+		},
+		Discard: func() {
+			s.recordSuperseded(n.ProjectID, n.ID)
+		},
+	})
+}
+
+// notificationLease returns the time after which a notification with the
+// given number of attempts will be retried if its delivery in progress did
+// not finish
+func (s *Service) notificationLease(attempts int) time.Duration {
+	policy := s.ctx.Notifications().RetryPolicy()
+	if delay, ok := policy.Retry(attempts + 1); ok {
+		return delay
+	}
+	return policy.Backoff
+}
+
+// recordAttempt records the outcome of a delivery attempt
+//
+// Failed deliveries will be retried until the maximum number of attempts is
+// reached.
+func (s *Service) recordAttempt(projectID, id int64, deliverErr error) {
+	log := s.log.New(log15.Ctx{
+		"method":         "recordAttempt",
+		"projectID":      projectID,
+		"notificationID": id,
+	})
+	err := s.updateNotification(projectID, id, func(n *outbox.Notification) *outbox.Status {
+		// delivered by a concurrent attempt
+		if !n.Pending() {
+			return nil
+		}
+		attempts := n.Status.Attempts + 1
+		if deliverErr == nil {
+			st := n.NewStatus(outbox.StatusDelivered, createdByDispatcher)
+			st.Attempts = attempts
+			return st
+		}
+		delay, ok := s.ctx.Notifications().RetryPolicy().Retry(attempts)
+		if !ok {
+			log.Warn("notification failed", log15.Ctx{
+				"attempts": attempts,
+				"err":      deliverErr,
+			})
+			st := n.NewStatus(outbox.StatusFailed, createdByDispatcher)
+			st.Attempts = attempts
+			st.SetError(deliverErr)
+			return st
+		}
+		st := n.NewStatus(outbox.StatusPending, createdByDispatcher)
+		st.Attempts = attempts
+		st.NextAttempt = st.Timestamp.Add(delay)
+		st.SetError(deliverErr)
+		return st
+	})
+	if err != nil {
+		log.Error("error recording delivery attempt", log15.Ctx{"err": err})
+	}
+}
+
+// recordSuperseded records that the notification was merged into a later
+// notification
+func (s *Service) recordSuperseded(projectID, id int64) {
+	err := s.updateNotification(projectID, id, func(n *outbox.Notification) *outbox.Status {
+		if !n.Pending() {
+			return nil
+		}
+		return n.NewStatus(outbox.StatusSuperseded, createdByDispatcher)
+	})
+	if err != nil {
+		s.log.Error("error recording superseded notification", log15.Ctx{
+			"method":         "recordSuperseded",
+			"notificationID": id,
+			"err":            err,
+		})
+	}
+}
+
+// updateNotification locks the notification of the project and saves the
+// status returned by the update function
+//
+// No status will be saved if the update function returns nil.
+func (s *Service) updateNotification(projectID, id int64, update func(n *outbox.Notification) *outbox.Status) error {
+	tx, err := s.ctx.ProjectPaymentDB(projectID).Begin()
+	if err != nil {
+		return err
+	}
+	n, err := outbox.NotificationByIDTx(tx, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	st := update(n)
+	if st == nil {
+		return tx.Rollback()
+	}
+	err = outbox.InsertStatusTx(tx, n, st)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// retryNotifications is the job which retries the due notifications of the
+// outboxes of all shards
+func (s *Service) retryNotifications(done <-chan struct{}) error {
+	log := s.log.New(log15.Ctx{"method": "retryNotifications"})
+	var retried int
+	for _, sh := range s.ctx.PaymentShardDBs(service.ReadOnly) {
+		list, err := outbox.DueNotificationsDB(sh.DB, time.Now(), notificationRetryBatch)
+		if err != nil {
+			return err
+		}
+		for _, n := range list {
+			select {
+			case <-done:
+				log.Info("retry cancelled", log15.Ctx{"retried": retried})
+				return nil
+			default:
+			}
+			ok, err := s.retryDueNotification(n.ProjectID, n.ID)
+			if err != nil {
+				return err
+			}
+			if ok {
+				retried++
+			}
+		}
+	}
+	if retried > 0 {
+		log.Info("retried notifications", log15.Ctx{"retried": retried})
+	}
+	return nil
+}
+
+// retryDueNotification queues the notification for delivery if it is still
+// due
+//
+// The notification will be leased, so it will not be retried again before the
+// attempt finished.
+func (s *Service) retryDueNotification(projectID, id int64) (bool, error) {
+	var leased *outbox.Notification
+	err := s.updateNotification(projectID, id, func(n *outbox.Notification) *outbox.Status {
+		// retried by another instance in the meantime
+		if !n.Due(time.Now()) {
+			return nil
+		}
+		leased = n
+		st := n.NewStatus(outbox.StatusPending, JobNotificationRetry)
+		st.NextAttempt = st.Timestamp.Add(s.notificationLease(st.Attempts))
+		return st
+	})
+	if err != nil || leased == nil {
+		return false, err
+	}
+	s.redeliver(leased)
+	return true, nil
+}
+
+// RetryNotification delivers a notification of the outbox of the project
+// again
+//
+// The number of attempts will be reset. Pending notifications cannot be
+// retried.
+func (s *Service) RetryNotification(projectID, id int64, createdBy string) (*outbox.Notification, error) {
+	var retried *outbox.Notification
+	var pending bool
+	err := s.updateNotification(projectID, id, func(n *outbox.Notification) *outbox.Status {
+		retried = n
+		if n.Pending() {
+			pending = true
+			return nil
+		}
+		st := n.NewStatus(outbox.StatusPending, createdBy)
+		st.Attempts = 0
+		st.NextAttempt = st.Timestamp.Add(s.notificationLease(st.Attempts))
+		return st
+	})
+	if err != nil {
+		if err == outbox.ErrNotificationNotFound {
+			return nil, err
+		}
+		return nil, wrapError(ErrDB, "RetryNotification", err)
+	}
+	if pending {
+		return retried, ErrNotificationPending
+	}
+	s.redeliver(retried)
+	return retried, nil
+}
+
+// redeliver queues a leased notification of the outbox for delivery
+//
+// Notifications which cannot be delivered anymore, e.g. because the callback
+// was removed, are marked as failed.
+func (s *Service) redeliver(n *outbox.Notification) {
+	log := s.log.New(log15.Ctx{
+		"method":         "redeliver",
+		"projectID":      n.ProjectID,
+		"notificationID": n.ID,
+		"eventType":      n.Event,
+	})
+	pr, key, deliver, cause := s.notificationDelivery(n)
+	if cause != nil {
+		log.Warn("cannot deliver notification", log15.Ctx{"err": cause})
+		err := s.updateNotification(n.ProjectID, n.ID, func(n *outbox.Notification) *outbox.Status {
+			if !n.Pending() {
+				return nil
+			}
+			st := n.NewStatus(outbox.StatusFailed, createdByDispatcher)
+			st.SetError(cause)
+			return st
+		})
+		if err != nil {
+			log.Error("error saving notification status", log15.Ctx{"err": err})
+		}
+		return
+	}
+	s.queueNotification(pr, n, key, deliver)
+}
+
+// notificationDelivery restores the delivery of a notification of the outbox
+//
+// Payment notifications carry the state of the payment at the time of the
+// original notification.
+func (s *Service) notificationDelivery(n *outbox.Notification) (*project.Project, string, service.DeliverFunc, error) {
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), n.ProjectID)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("error retrieving project: %v", err)
+	}
+	if n.Event != EventPaymentTransaction {
+		if !CanCallback(pr.Config) {
+			return nil, "", nil, errors.New("project has no callback")
+		}
+		return pr, "", func(digest bool) error {
+			return s.doNotifyEvent(pr.Config, n.ProjectID, n.Event, n.Data, digest)
+		}, nil
+	}
+	if !n.PaymentID.Valid || !n.TransactionTimestamp.Valid {
+		return nil, "", nil, errors.New("payment notification without payment transaction")
+	}
+	id := payment.PaymentID{ProjectID: n.ProjectID, PaymentID: n.PaymentID.Int64}
+	paymentTx, err := s.paymentTransactionAt(id, time.Unix(0, n.TransactionTimestamp.Int64))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	p := paymentTx.Payment
+	callback := notificationCallback(pr, p)
+	if callback == nil {
+		return nil, "", nil, errors.New("payment has no callback")
+	}
+	return pr, paymentNotificationKey(p), func(digest bool) error {
+		return s.doNotify(callback, paymentTx, digest)
+	}, nil
+}
+
+// paymentTransactionAt returns the payment transaction with the given
+// timestamp, with the state of the payment at the time of the transaction
+func (s *Service) paymentTransactionAt(id payment.PaymentID, ts time.Time) (*payment.PaymentTransaction, error) {
+	db := s.ctx.ProjectPaymentDB(id.ProjectID)
+	p, err := payment.PaymentByIDAtDB(s.ctx, db, id, ts)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving payment: %v", err)
+	}
+	txs, err := payment.PaymentTransactionsBeforeTimestampDB(s.ctx, db, p, ts)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving payment transactions: %v", err)
+	}
+	if len(txs) == 0 || !txs[len(txs)-1].Timestamp.Equal(ts) {
+		return nil, errors.New("payment transaction not found")
+	}
+	return txs[len(txs)-1], nil
+}
+
+// NotifiesPayment returns true if the project of the payment will be notified
+// of its transactions
+func (s *Service) NotifiesPayment(p *payment.Payment) (bool, error) {
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		return false, wrapError(ErrDB, "NotifiesPayment", err)
+	}
+	return notificationCallback(pr, p) != nil, nil
+}
+
+// NotifyTransaction notifies the project of the payment transaction with the
+// given timestamp, e.g. if the notification was lost
+//
+// The notification carries the state of the payment at the time of the
+// transaction.
+func (s *Service) NotifyTransaction(id payment.PaymentID, ts time.Time) error {
+	paymentTx, err := s.paymentTransactionAt(id, ts)
+	if err != nil {
+		return wrapError(ErrInternal, "NotifyTransaction", err)
+	}
+	return s.notify(paymentTx)
+}
+
+// paymentNotificationKey returns the key of the notifications of the payment,
+// which can be merged in digest mode
+func paymentNotificationKey(p *payment.Payment) string {
+	return EventPaymentTransaction + ":" + p.PaymentID().String()
+}
+
+// outboxPaymentNotification returns the outbox entry of the notification of a
+// payment transaction
+func outboxPaymentNotification(paymentTx *payment.PaymentTransaction) *outbox.Notification {
+	return &outbox.Notification{
+		Event:                EventPaymentTransaction,
+		PaymentID:            sql.NullInt64{Int64: paymentTx.Payment.ID(), Valid: true},
+		TransactionTimestamp: sql.NullInt64{Int64: paymentTx.Timestamp.UnixNano(), Valid: true},
+	}
+}
@@ -0,0 +1,118 @@
+package payment
+
+import (
+	"database/sql"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// number of changes projected per database transaction
+	projectionBatchSize = 500
+)
+
+// projectChanges projects the published changes to the payment overviews of
+// all shards
+func (s *Service) projectChanges(done <-chan struct{}) error {
+	log := s.log.New(log15.Ctx{"method": "projectChanges"})
+	var projected int
+	defer func() {
+		if projected > 0 {
+			log.Info("projected payment changes", log15.Ctx{"changes": projected})
+		}
+	}()
+	for _, sh := range s.ctx.PaymentShardDBs() {
+		n, err := s.projectShardChanges(done, sh.DB)
+		projected += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// projectShardChanges projects the published changes of a shard to the
+// payment overviews of the shard and returns the number of projected changes
+//
+// The changes are read from the change feed following the latest projected
+// change, until the feed is exhausted or the service shuts down.
+func (s *Service) projectShardChanges(done <-chan struct{}, db *sql.DB) (int, error) {
+	err := s.publishPendingChanges(db)
+	if err != nil {
+		return 0, err
+	}
+	var projected int
+	for {
+		select {
+		case <-done:
+			return projected, nil
+		default:
+		}
+		seq, err := payment.OverviewSequenceDB(s.ctx, db)
+		if err != nil {
+			return projected, err
+		}
+		changes, err := payment.ChangesAfterSequenceDB(s.ctx, db, seq, projectionBatchSize)
+		if err != nil {
+			return projected, err
+		}
+		if len(changes) == 0 {
+			return projected, nil
+		}
+		err = s.projectChangeBatch(db, changes)
+		if err != nil {
+			return projected, err
+		}
+		projected += len(changes)
+		if len(changes) < projectionBatchSize {
+			return projected, nil
+		}
+	}
+}
+
+// projectChangeBatch applies the changes to the overviews of their payments in
+// a single database transaction of their shard
+func (s *Service) projectChangeBatch(db *sql.DB, changes []*payment.Change) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	overviews := make(map[int64]*payment.Overview)
+	for _, c := range changes {
+		o, ok := overviews[c.PaymentID]
+		if !ok {
+			o, err = payment.PaymentOverviewTx(s.ctx, tx, c.ProjectID, c.PaymentID)
+			if err == payment.ErrOverviewNotFound {
+				o, err = payment.NewOverview(c), nil
+			}
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			overviews[c.PaymentID] = o
+		}
+		methodChanged, err := o.Apply(c)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if methodChanged {
+			o.Provider = ""
+			if o.PaymentMethodID != 0 {
+				meth, err := s.PaymentMethod(o.ProjectID, o.PaymentMethodID)
+				if err != nil {
+					tx.Rollback()
+					return err
+				}
+				o.Provider = meth.Provider.Name
+			}
+		}
+		err = payment.SavePaymentOverviewTx(s.ctx, tx, o)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,250 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/funds"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/alert"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// overpayment policies
+const (
+	// OverpaymentAccept keeps the overpaid amount
+	OverpaymentAccept = "accept"
+	// OverpaymentRefund refunds the overpaid amount to the shopper
+	OverpaymentRefund = "refund"
+	// OverpaymentCredit records the overpaid amount as unmatched incoming funds,
+	// which can be matched with another payment of the shopper
+	OverpaymentCredit = "credit"
+)
+
+const (
+	// FundsSourceOverpayment is the source of incoming funds credited from an
+	// overpayment
+	FundsSourceOverpayment = "overpayment"
+	overpaymentCreatedBy   = "paymentd"
+)
+
+var hundred = dec.NewDecInt64(100)
+
+func (s *Service) initReceivedFunds() error {
+	cfg := s.ctx.Config()
+	s.underpaymentTolerance = dec.NewDecInt64(0)
+	if cfg.Payment.UnderpaymentTolerance != "" {
+		_, ok := s.underpaymentTolerance.SetString(cfg.Payment.UnderpaymentTolerance)
+		if !ok || s.underpaymentTolerance.Sign() < 0 {
+			return fmt.Errorf("invalid underpayment tolerance %s", cfg.Payment.UnderpaymentTolerance)
+		}
+	}
+	switch cfg.Payment.OverpaymentPolicy {
+	case "":
+		s.overpaymentPolicy = OverpaymentAccept
+	case OverpaymentAccept, OverpaymentRefund, OverpaymentCredit:
+		s.overpaymentPolicy = cfg.Payment.OverpaymentPolicy
+	default:
+		return fmt.Errorf("invalid overpayment policy %s", cfg.Payment.OverpaymentPolicy)
+	}
+	return nil
+}
+
+// OverpaymentPolicy returns the configured overpayment policy
+func (s *Service) OverpaymentPolicy() string {
+	return s.overpaymentPolicy
+}
+
+// RemainingAmount returns the amount which remains to be paid on the payment
+//
+// It is calculated from the ledger of the payment. A negative amount means
+// the payment was overpaid.
+func (s *Service) RemainingAmount(db *sql.DB, p *payment.Payment) (*decimal.Decimal, error) {
+	txs, err := payment.PaymentTransactionsBeforeTimestampDB(s.ctx, db, p, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	remaining := &decimal.Decimal{}
+	if bal, ok := txs.Balance()[p.Currency]; ok {
+		remaining.Neg(&bal.Dec)
+	}
+	return remaining, nil
+}
+
+// IntentReceived handles funds received for a payment of a transfer-based payment
+// method, e.g. prepayment or SEPA credit transfers
+//
+// Unlike IntentPaid, the received amount does not have to match the payment amount.
+// If the remaining amount exceeds the configured underpayment tolerance, the
+// payment will be partially paid. Subsequent transfers will be added until the
+// payment is paid. Overpayments will be handled according to the configured
+// overpayment policy, which will be noted in the transaction comment.
+func (s *Service) IntentReceived(ctx context.Context, p *payment.Payment, amount *decimal.Decimal, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if p.Status != payment.PaymentStatusOpen && p.Status != payment.PaymentStatusPartiallyPaid {
+		return s.rejectIntent(p, payment.PaymentStatusPaid, ErrIntentNotAllowed)
+	}
+	if amount.Sign() <= 0 || p.IsVerification() {
+		return s.rejectIntent(p, payment.PaymentStatusPaid, ErrIntentNotAllowed)
+	}
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meth.Disabled() {
+		return s.rejectIntent(p, payment.PaymentStatusPaid, ErrPaymentMethodDisabled)
+	}
+	// transfers received moments before must be visible, so the write connection
+	// is used
+	remaining, err := s.RemainingAmount(s.ctx.ProjectPaymentDB(p.ProjectID()), p)
+	if err != nil {
+		s.log.Error("error calculating remaining amount", log15.Ctx{"err": err})
+		return nil, nil, wrapError(ErrDB, "IntentReceived", err)
+	}
+	paymentTx := s.newReceivedTransaction(p, remaining, amount)
+	paymentTx, commit, err := s.handleIntent(ctx, p, paymentTx, timeout)
+	if err != nil || amount.Cmp(&remaining.Dec) <= 0 {
+		return paymentTx, commit, err
+	}
+	excess := &decimal.Decimal{}
+	excess.Sub(&paymentTx.Decimal().Dec, &remaining.Dec)
+	// the overpayment policy is applied once the received funds are committed,
+	// since the payment has to be paid before it can be refunded
+	return paymentTx, CommitIntentFunc(func() error {
+		if commit != nil {
+			err := commit()
+			if err != nil {
+				return err
+			}
+		}
+		s.alertOverpayment(p, remaining, amount)
+		s.applyOverpaymentPolicy(p.PaymentID(), excess)
+		return nil
+	}), nil
+}
+
+// applyOverpaymentPolicy handles the overpaid amount of the payment according to
+// the configured overpayment policy
+//
+// Errors are logged, since the received funds are already committed.
+func (s *Service) applyOverpaymentPolicy(id payment.PaymentID, excess *decimal.Decimal) {
+	log := s.log.New(log15.Ctx{
+		"method":    "applyOverpaymentPolicy",
+		"policy":    s.overpaymentPolicy,
+		"projectID": id.ProjectID,
+		"paymentID": id.PaymentID,
+		"excess":    excess.String(),
+	})
+	switch s.overpaymentPolicy {
+	case OverpaymentRefund:
+		refund := func(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+			return s.IntentPartialRefund(ctx, p, excess, timeout)
+		}
+		_, err := s.applyIntent(id, "overpaymentRefund", refund, "refund of overpaid "+excess.String())
+		if err != nil {
+			log.Error("error refunding overpayment", log15.Ctx{"err": err})
+		}
+	case OverpaymentCredit:
+		err := s.creditOverpayment(id, excess)
+		if err != nil {
+			log.Error("error crediting overpayment", log15.Ctx{"err": err})
+		}
+	}
+}
+
+// creditOverpayment records the overpaid amount as unmatched incoming funds
+//
+// The reference of the funds is the encoded payment ID, so the credit can be
+// traced back to the overpaid payment.
+func (s *Service) creditOverpayment(id payment.PaymentID, excess *decimal.Decimal) error {
+	p, err := payment.PaymentByIDDB(s.ctx, s.ctx.ProjectPaymentDB(id.ProjectID), id)
+	if err != nil {
+		return err
+	}
+	amount := &decimal.Decimal{}
+	amount.Round(&excess.Dec, dec.Scale(p.Subunits), dec.RoundDown)
+	f := &funds.Funds{
+		Source:    FundsSourceOverpayment,
+		Reference: s.EncodedPaymentID(id).String(),
+		Amount:    amount.Unscaled().Int64(),
+		Subunits:  p.Subunits,
+		Currency:  p.Currency,
+		CreatedBy: overpaymentCreatedBy,
+	}
+	tx, err := s.ctx.PaymentDB().Begin()
+	if err != nil {
+		return err
+	}
+	err = funds.InsertFundsTx(tx, f)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// alertOverpayment alerts on received funds exceeding the remaining amount of
+// the payment
+func (s *Service) alertOverpayment(p *payment.Payment, remaining, amount *decimal.Decimal) {
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		s.log.Error("error retrieving project of overpayment", log15.Ctx{
+			"projectID": p.ProjectID(),
+			"err":       err,
+		})
+		return
+	}
+	a := &alert.Alert{
+		Kind:        alert.KindReconciliationMismatch,
+		Severity:    alert.SeverityWarning,
+		PrincipalID: pr.PrincipalID,
+		ProjectID:   pr.ID,
+		Title:       "Overpayment received for payment " + s.EncodedPaymentID(p.PaymentID()).String(),
+		Text: fmt.Sprintf("Received %s %s, but only %s %s remained to be paid. Overpayment policy: %s.",
+			amount, p.Currency, remaining, p.Currency, s.overpaymentPolicy),
+	}
+	a.AddField("Project", pr.Name)
+	a.AddField("Payment", s.EncodedPaymentID(p.PaymentID()).String())
+	s.ctx.Alerts().Alert(a)
+}
+
+// newReceivedTransaction creates the transaction for the received amount
+//
+// The remaining amount is the amount which remained to be paid before the
+// funds were received.
+func (s *Service) newReceivedTransaction(p *payment.Payment, remaining, amount *decimal.Decimal) *payment.PaymentTransaction {
+	// the received amount will be recorded in the subunits of the payment
+	received := &decimal.Decimal{}
+	received.Round(&amount.Dec, dec.Scale(p.Subunits), dec.RoundDown)
+	left := &decimal.Decimal{}
+	left.Sub(&remaining.Dec, &received.Dec)
+
+	tolerance := &decimal.Decimal{}
+	tolerance.Mul(&p.Decimal().Dec, s.underpaymentTolerance)
+	tolerance.Quo(&tolerance.Dec, hundred, dec.Scale(p.Subunits), dec.RoundDown)
+
+	var paymentTx *payment.PaymentTransaction
+	switch {
+	case left.Cmp(&tolerance.Dec) > 0:
+		paymentTx = p.NewTransaction(payment.PaymentStatusPartiallyPaid)
+		paymentTx.Comment.String = fmt.Sprintf("remaining %s %s", left, p.Currency)
+	case left.Sign() > 0:
+		paymentTx = p.NewTransaction(payment.PaymentStatusPaid)
+		paymentTx.Comment.String = fmt.Sprintf("underpaid %s %s within tolerance", left, p.Currency)
+	case left.Sign() < 0:
+		paymentTx = p.NewTransaction(payment.PaymentStatusPaid)
+		left.Neg(&left.Dec)
+		paymentTx.Comment.String = fmt.Sprintf("overpaid %s %s: %s", left, p.Currency, s.overpaymentPolicy)
+	default:
+		paymentTx = p.NewTransaction(payment.PaymentStatusPaid)
+	}
+	paymentTx.Comment.Valid = paymentTx.Comment.String != ""
+	paymentTx.Amount = received.Unscaled().Int64()
+	paymentTx.Subunits = p.Subunits
+	return paymentTx
+}
@@ -0,0 +1,74 @@
+package payment
+
+import (
+	"testing"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func decimalString(s string) *decimal.Decimal {
+	d := &decimal.Decimal{}
+	d.SetString(s)
+	return d
+}
+
+func TestReceivedTransaction(t *testing.T) {
+	Convey("Given a payment service with an underpayment tolerance of 1 percent", t, func() {
+		s := &Service{
+			underpaymentTolerance: dec.NewDecInt64(1),
+			overpaymentPolicy:     OverpaymentRefund,
+		}
+		Convey("Given an open payment of 100.00 EUR", func() {
+			p := &payment.Payment{
+				Amount:   10000,
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusOpen,
+			}
+			remaining := decimalString("100.00")
+
+			Convey("When the exact amount is received", func() {
+				paymentTx := s.newReceivedTransaction(p, remaining, decimalString("100"))
+				Convey("The payment should be paid", func() {
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPaid)
+					So(paymentTx.Amount, ShouldEqual, 10000)
+					So(paymentTx.Comment.Valid, ShouldBeFalse)
+				})
+			})
+			Convey("When less than the tolerance is missing", func() {
+				paymentTx := s.newReceivedTransaction(p, remaining, decimalString("99.50"))
+				Convey("The payment should be paid", func() {
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPaid)
+					So(paymentTx.Amount, ShouldEqual, 9950)
+					So(paymentTx.Comment.String, ShouldEqual, "underpaid 0.50 EUR within tolerance")
+				})
+			})
+			Convey("When more than the tolerance is missing", func() {
+				paymentTx := s.newReceivedTransaction(p, remaining, decimalString("60"))
+				Convey("The payment should be partially paid", func() {
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPartiallyPaid)
+					So(paymentTx.Amount, ShouldEqual, 6000)
+					So(paymentTx.Comment.String, ShouldEqual, "remaining 40.00 EUR")
+				})
+				Convey("When the remaining amount is received", func() {
+					paymentTx = s.newReceivedTransaction(p, decimalString("40.00"), decimalString("40"))
+					Convey("The payment should be paid", func() {
+						So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPaid)
+						So(paymentTx.Amount, ShouldEqual, 4000)
+					})
+				})
+			})
+			Convey("When too much is received", func() {
+				paymentTx := s.newReceivedTransaction(p, remaining, decimalString("105.999"))
+				Convey("The payment should be paid noting the overpayment policy", func() {
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPaid)
+					So(paymentTx.Amount, ShouldEqual, 10599)
+					So(paymentTx.Comment.String, ShouldEqual, "overpaid 5.99 EUR: refund")
+				})
+			})
+		})
+	})
+}
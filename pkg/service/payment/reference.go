@@ -0,0 +1,82 @@
+package payment
+
+import (
+	"database/sql"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/reference"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// SetPaymentReference assigns the next reference number of the project to a
+// new payment
+//
+// It is a no-op if the project has no reference scheme. The sequence number is
+// allocated in the given transaction, so it will be released on rollback.
+func (s *Service) SetPaymentReference(tx *sql.Tx, p *payment.Payment) error {
+	log := s.log.New(log15.Ctx{
+		"method":    "SetPaymentReference",
+		"projectID": p.ProjectID(),
+	})
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		log.Error("error retrieving project", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "SetPaymentReference", err)
+	}
+	scheme, err := pr.Config.PaymentReferenceScheme()
+	if err != nil {
+		log.Error("error decoding reference scheme", log15.Ctx{"err": err})
+		return wrapError(ErrInternal, "SetPaymentReference", err)
+	}
+	if scheme == nil {
+		return nil
+	}
+	seq, err := payment.NextReferenceSequenceTx(s.ctx, tx, p.ProjectID())
+	if err != nil {
+		log.Error("error allocating reference sequence", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "SetPaymentReference", err)
+	}
+	p.Reference, err = scheme.Format(seq)
+	if err != nil {
+		log.Crit("reference numbers exhausted", log15.Ctx{
+			"sequence": seq,
+			"err":      err,
+		})
+		return wrapError(ErrReferenceExhausted, "SetPaymentReference", err)
+	}
+	err = payment.InsertPaymentReferenceTx(s.ctx, tx, p)
+	if err != nil {
+		log.Error("error saving payment reference", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "SetPaymentReference", err)
+	}
+	return nil
+}
+
+// PaymentIDByReference returns the ID of the payment with the given reference
+// number
+//
+// The reference will be normalized, since customers tend to quote references
+// with spaces or dashes. The payments of all shards are searched. It returns
+// payment.ErrPaymentNotFound if no or more than one payment has the reference.
+func (s *Service) PaymentIDByReference(ref string) (payment.PaymentID, error) {
+	var ids []payment.PaymentID
+	for _, sh := range s.ctx.PaymentShardDBs() {
+		shardIDs, err := payment.PaymentIDsByReferenceDB(s.ctx, sh.DB, reference.Normalize(ref))
+		if err != nil {
+			s.log.Error("error retrieving payment by reference", log15.Ctx{
+				"method":    "PaymentIDByReference",
+				"shard":     sh.Shard,
+				"reference": ref,
+				"err":       err,
+			})
+			return payment.PaymentID{}, wrapError(ErrDB, "PaymentIDByReference", err)
+		}
+		ids = append(ids, shardIDs...)
+	}
+	if len(ids) != 1 {
+		return payment.PaymentID{}, payment.ErrPaymentNotFound
+	}
+	return ids[0], nil
+}
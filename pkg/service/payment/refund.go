@@ -0,0 +1,152 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// refundable returns true if payments with the given status can be refunded
+func refundable(status payment.PaymentTransactionStatus) bool {
+	switch status {
+	case payment.PaymentStatusPaid,
+		payment.PaymentStatusSettled,
+		payment.PaymentStatusPartiallyRefunded,
+		payment.PaymentStatusRefundReversed,
+		payment.PaymentStatusChargebackReversed:
+		return true
+	default:
+		return false
+	}
+}
+
+// refundableUnits returns the amount (in the subunits of the payment) which can
+// be refunded according to the ledger of the payment
+//
+// It is the captured amount less the refunded amount and open or lost
+// chargebacks. Reversed refunds and chargebacks can be refunded again.
+func refundableUnits(p *payment.Payment, txs payment.PaymentTransactionList) int64 {
+	var units int64
+	for _, tx := range txs {
+		if tx.Currency != p.Currency {
+			continue
+		}
+		switch tx.Status {
+		case payment.PaymentStatusPaid,
+			payment.PaymentStatusPartiallyPaid,
+			payment.PaymentStatusPartiallyCaptured,
+			payment.PaymentStatusRefunded,
+			payment.PaymentStatusPartiallyRefunded,
+			payment.PaymentStatusRefundReversed,
+			payment.PaymentStatusChargebackReceived,
+			payment.PaymentStatusChargebackReversed:
+			units += tx.Amount
+		}
+	}
+	return units
+}
+
+// RefundableAmount returns the amount which can still be refunded on the
+// payment
+//
+// It is calculated from the ledger of the payment.
+func (s *Service) RefundableAmount(db *sql.DB, p *payment.Payment) (*decimal.Decimal, error) {
+	txs, err := payment.PaymentTransactionsBeforeTimestampDB(s.ctx, db, p, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return unitsDecimal(refundableUnits(p, txs), p.Subunits), nil
+}
+
+func unitsDecimal(units int64, subunits int8) *decimal.Decimal {
+	tx := &payment.PaymentTransaction{Amount: units, Subunits: subunits}
+	return tx.Decimal()
+}
+
+// newRefundTransaction creates the refund transaction for the payment
+//
+// The refunded amount is recorded as a negative amount. A nil amount refunds
+// the refundable amount. If less than the refundable amount is refunded, the
+// payment will be partially refunded.
+func newRefundTransaction(p *payment.Payment, txs payment.PaymentTransactionList, amount *decimal.Decimal) (*payment.PaymentTransaction, error) {
+	left := refundableUnits(p, txs)
+	if left <= 0 {
+		return nil, ErrRefundAmount
+	}
+	units := left
+	if amount != nil {
+		var ok bool
+		units, ok = amountUnits(p, amount)
+		if !ok || units > left {
+			return nil, ErrRefundAmount
+		}
+	}
+	var paymentTx *payment.PaymentTransaction
+	if units < left {
+		paymentTx = p.NewTransaction(payment.PaymentStatusPartiallyRefunded)
+		paymentTx.Comment.String, paymentTx.Comment.Valid = fmt.Sprintf("refunded %s, refundable %s %s",
+			unitsDecimal(units, p.Subunits), unitsDecimal(left-units, p.Subunits), p.Currency), true
+	} else {
+		paymentTx = p.NewTransaction(payment.PaymentStatusRefunded)
+	}
+	paymentTx.Amount = -units
+	return paymentTx, nil
+}
+
+// IntentRefund refunds the refundable amount of a paid payment
+//
+// The refundable amount is the captured amount less prior refunds. Intent
+// workers can react to the refunded transaction, e.g. a provider driver
+// requesting the refund from the provider.
+func (s *Service) IntentRefund(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	return s.intentRefund(ctx, p, nil, timeout)
+}
+
+// IntentPartialRefund refunds the given amount of a paid payment
+//
+// The amount must not exceed the refundable amount. The payment will be
+// partially refunded unless the amount refunds the refundable amount.
+func (s *Service) IntentPartialRefund(ctx context.Context, p *payment.Payment, amount *decimal.Decimal, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if amount == nil {
+		return nil, nil, ErrRefundAmount
+	}
+	return s.intentRefund(ctx, p, amount, timeout)
+}
+
+func (s *Service) intentRefund(ctx context.Context, p *payment.Payment, amount *decimal.Decimal, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	var intent payment.PaymentTransactionStatus = payment.PaymentStatusRefunded
+	if amount != nil {
+		intent = payment.PaymentStatusPartiallyRefunded
+	}
+	if !refundable(p.Status) || p.IsVerification() {
+		return s.rejectIntent(p, intent, ErrIntentNotAllowed)
+	}
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meth.Disabled() {
+		return s.rejectIntent(p, intent, ErrPaymentMethodDisabled)
+	}
+	// prior refunds must be visible, so the write connection is used
+	txs, err := payment.PaymentTransactionsBeforeTimestampDB(ctx, s.ctx.ProjectPaymentDB(p.ProjectID()), p, time.Now())
+	if err != nil {
+		s.log.Error("error retrieving payment transactions", log15.Ctx{
+			"method":    "IntentRefund",
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+			"err":       err,
+		})
+		return nil, nil, wrapError(ErrDB, "IntentRefund", err)
+	}
+	paymentTx, err := newRefundTransaction(p, txs, amount)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.handleIntent(ctx, p, paymentTx, timeout)
+}
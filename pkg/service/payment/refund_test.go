@@ -0,0 +1,138 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestRefundTransaction(t *testing.T) {
+	Convey("Given a payment of 100.00 EUR", t, func() {
+		p := &payment.Payment{
+			Amount:   10000,
+			Subunits: 2,
+			Currency: "EUR",
+			Status:   payment.PaymentStatusPaid,
+		}
+		ledger := func(amounts map[payment.PaymentTransactionStatus]int64) payment.PaymentTransactionList {
+			txs := payment.PaymentTransactionList{
+				{Amount: -10000, Subunits: 2, Currency: "EUR", Status: payment.PaymentStatusOpen},
+			}
+			for status, amount := range amounts {
+				txs = append(txs, &payment.PaymentTransaction{Amount: amount, Subunits: 2, Currency: "EUR", Status: status})
+			}
+			return txs
+		}
+
+		Convey("Given the payment was captured in full", func() {
+			txs := ledger(map[payment.PaymentTransactionStatus]int64{
+				payment.PaymentStatusPaid: 10000,
+			})
+
+			Convey("When refunding the payment", func() {
+				paymentTx, err := newRefundTransaction(p, txs, nil)
+
+				Convey("It should refund the captured amount", func() {
+					So(err, ShouldBeNil)
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusRefunded)
+					So(paymentTx.Amount, ShouldEqual, -10000)
+				})
+			})
+
+			Convey("When refunding a part of the payment", func() {
+				paymentTx, err := newRefundTransaction(p, txs, decimalString("30.00"))
+
+				Convey("It should be partially refunded", func() {
+					So(err, ShouldBeNil)
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusPartiallyRefunded)
+					So(paymentTx.Amount, ShouldEqual, -3000)
+					So(paymentTx.Comment.String, ShouldEqual, "refunded 30.00, refundable 70.00 EUR")
+				})
+			})
+
+			Convey("When refunding more than the captured amount", func() {
+				_, err := newRefundTransaction(p, txs, decimalString("100.01"))
+
+				Convey("It should fail", func() {
+					So(err, ShouldEqual, ErrRefundAmount)
+				})
+			})
+
+			Convey("When refunding an amount with too many decimal places", func() {
+				_, err := newRefundTransaction(p, txs, decimalString("1.001"))
+
+				Convey("It should fail", func() {
+					So(err, ShouldEqual, ErrRefundAmount)
+				})
+			})
+		})
+
+		Convey("Given a partial capture and a prior partial refund", func() {
+			txs := ledger(map[payment.PaymentTransactionStatus]int64{
+				payment.PaymentStatusPaid:              8000,
+				payment.PaymentStatusPartiallyRefunded: -3000,
+			})
+
+			Convey("The refundable amount should be the rest of the captured amount", func() {
+				So(refundableUnits(p, txs), ShouldEqual, 5000)
+			})
+
+			Convey("When refunding the rest", func() {
+				paymentTx, err := newRefundTransaction(p, txs, decimalString("50"))
+
+				Convey("It should be refunded", func() {
+					So(err, ShouldBeNil)
+					So(paymentTx.Status, ShouldEqual, payment.PaymentStatusRefunded)
+					So(paymentTx.Amount, ShouldEqual, -5000)
+				})
+			})
+		})
+
+		Convey("Given a reversed refund", func() {
+			txs := ledger(map[payment.PaymentTransactionStatus]int64{
+				payment.PaymentStatusPaid:           10000,
+				payment.PaymentStatusRefunded:       -10000,
+				payment.PaymentStatusRefundReversed: 10000,
+			})
+
+			Convey("The reversed amount should be refundable again", func() {
+				So(refundableUnits(p, txs), ShouldEqual, 10000)
+			})
+		})
+
+		Convey("Given the payment was refunded", func() {
+			txs := ledger(map[payment.PaymentTransactionStatus]int64{
+				payment.PaymentStatusPaid:     10000,
+				payment.PaymentStatusRefunded: -10000,
+			})
+
+			Convey("It should not be refunded again", func() {
+				_, err := newRefundTransaction(p, txs, nil)
+				So(err, ShouldEqual, ErrRefundAmount)
+			})
+		})
+	})
+
+	Convey("Given a payment service", t, func() {
+		s := &Service{}
+
+		Convey("Given an open payment", func() {
+			p := &payment.Payment{
+				Amount:   1000,
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusOpen,
+			}
+
+			Convey("It should not be refunded", func() {
+				_, _, err := s.IntentRefund(context.Background(), p, time.Second)
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+				_, _, err = s.IntentPartialRefund(context.Background(), p, decimalString("1.00"), time.Second)
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+			})
+		})
+	})
+}
@@ -0,0 +1,84 @@
+package payment
+
+import (
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const pkgPath = "github.com/fritzpay/paymentd/pkg/service/payment"
+
+// RejectedIntentWorker are invoked concurrently when an intent was rejected, e.g.
+// because the payment is not in a status allowing the intended change or its
+// payment method is inactive.
+//
+// The default Payment Service has the audit of rejected intents registered as a
+// default rejected intent worker.
+type RejectedIntentWorker interface {
+	RejectedIntent(p payment.Payment, r *payment.IntentRejection)
+}
+
+func (s *Service) RegisterRejectedIntentWorker(worker RejectedIntentWorker) {
+	s.mIntent.Lock()
+	s.rejectedIntents = append(s.rejectedIntents, worker)
+	s.mIntent.Unlock()
+}
+
+// rejectIntent rejects the intended status change of the payment with the given
+// error and invokes the rejected intent workers
+func (s *Service) rejectIntent(p *payment.Payment, intent payment.PaymentTransactionStatus, err error) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	s.mIntent.RLock()
+	if len(s.rejectedIntents) > 0 {
+		r := p.NewIntentRejection(intent, err.Error(), intentCaller())
+		for _, w := range s.rejectedIntents {
+			go w.RejectedIntent(*p, r)
+		}
+	}
+	s.mIntent.RUnlock()
+	return nil, nil, err
+}
+
+// intentCaller returns the function which requested the intent, i.e. the first
+// caller outside of this package
+func intentCaller() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, pkgPath+".") || strings.HasSuffix(f.File, "_test.go") {
+			// strip the package path, keep the package name
+			return path.Base(f.Function)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// intentAudit records rejected intents and notifies the project
+type intentAudit struct {
+	s *Service
+}
+
+func (a *intentAudit) RejectedIntent(p payment.Payment, r *payment.IntentRejection) {
+	err := payment.InsertPaymentIntentRejectionDB(a.s.ctx, a.s.ctx.ProjectPaymentDB(p.ProjectID()), &p, r)
+	if err != nil {
+		a.s.log.Error("error saving rejected intent", log15.Ctx{
+			"method":    "RejectedIntent",
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+			"intent":    r.Intent.String(),
+			"err":       err,
+		})
+	}
+	a.s.NotifyEvent(p.ProjectID(), EventPaymentIntentRejected, map[string]string{
+		"PaymentId": a.s.EncodedPaymentID(p.PaymentID()).String(),
+		"Intent":    r.Intent.String(),
+		"Status":    r.Status.String(),
+		"Reason":    r.Reason,
+	})
+}
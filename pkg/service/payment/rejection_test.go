@@ -0,0 +1,52 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type rejectionRecorder chan *payment.IntentRejection
+
+func (r rejectionRecorder) RejectedIntent(p payment.Payment, rej *payment.IntentRejection) {
+	r <- rej
+}
+
+func TestRejectedIntent(t *testing.T) {
+	Convey("Given a service with a rejected intent worker", t, func() {
+		s := &Service{}
+		rejected := make(rejectionRecorder, 1)
+		s.RegisterRejectedIntentWorker(rejected)
+
+		Convey("When a paid payment is cancelled", func() {
+			p := &payment.Payment{
+				Amount:   10000,
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusPaid,
+			}
+			paymentTx, commit, err := s.IntentCancel(context.Background(), p, time.Second)
+
+			Convey("The intent should not be allowed", func() {
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+				So(paymentTx, ShouldBeNil)
+				So(commit, ShouldBeNil)
+			})
+			Convey("The rejection should be recorded", func() {
+				var r *payment.IntentRejection
+				select {
+				case r = <-rejected:
+				case <-time.After(time.Second):
+				}
+				So(r, ShouldNotBeNil)
+				So(r.Intent, ShouldEqual, payment.PaymentStatusCancelled)
+				So(r.Status, ShouldEqual, payment.PaymentStatusPaid)
+				So(r.Reason, ShouldEqual, "intent not allowed")
+				So(r.CreatedBy, ShouldStartWith, "payment.TestRejectedIntent")
+			})
+		})
+	})
+}
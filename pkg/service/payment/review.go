@@ -0,0 +1,99 @@
+package payment
+
+import (
+	"database/sql"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// HoldError is sent by pre-intent workers, e.g. fraud or velocity checks, to
+// hold the payment for a manual review instead of cancelling the intent
+//
+// Holds are supported for open intents, i.e. when the customer starts the
+// checkout. The payment will be held until it is approved or declined in the
+// review queue.
+type HoldError struct {
+	// Worker is the name of the worker which requested the hold
+	Worker string
+	Reason string
+}
+
+func (e *HoldError) Error() string {
+	return "payment held for review: " + e.Reason
+}
+
+// HoldPayment holds the payment for a manual review
+//
+// The returned CommitIntentFunc has to be called after the tx was committed to
+// notify the project.
+func (s *Service) HoldPayment(tx *sql.Tx, p *payment.Payment, hold *HoldError) (CommitIntentFunc, error) {
+	if p.Status != payment.PaymentStatusNone && p.Status != payment.PaymentStatusOpen {
+		return nil, ErrIntentNotAllowed
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusHeld)
+	paymentTx.Amount = 0
+	paymentTx.Comment.String, paymentTx.Comment.Valid = hold.Reason, hold.Reason != ""
+	r := p.NewReview(payment.ReviewHeld, hold.Worker)
+	if s.reviewSLA > 0 {
+		r.Due = r.Timestamp.Add(s.reviewSLA)
+	}
+	r.Comment = paymentTx.Comment
+	return s.setReview(tx, paymentTx, r)
+}
+
+// ApprovePayment releases a held payment for processing
+//
+// The payment will be open again, so that the customer can continue the
+// checkout.
+func (s *Service) ApprovePayment(tx *sql.Tx, p *payment.Payment, createdBy, comment string) (CommitIntentFunc, error) {
+	if p.Status != payment.PaymentStatusHeld {
+		return nil, ErrPaymentNotHeld
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusOpen)
+	paymentTx.Amount = paymentTx.Amount * -1
+	r := p.NewReview(payment.ReviewApproved, createdBy)
+	r.Comment.String, r.Comment.Valid = comment, comment != ""
+	return s.setReview(tx, paymentTx, r)
+}
+
+// DeclinePayment cancels a held payment
+func (s *Service) DeclinePayment(tx *sql.Tx, p *payment.Payment, createdBy, comment string) (CommitIntentFunc, error) {
+	if p.Status != payment.PaymentStatusHeld {
+		return nil, ErrPaymentNotHeld
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusCancelled)
+	paymentTx.Amount = 0
+	r := p.NewReview(payment.ReviewDeclined, createdBy)
+	r.Comment.String, r.Comment.Valid = comment, comment != ""
+	paymentTx.Comment = r.Comment
+	return s.setReview(tx, paymentTx, r)
+}
+
+// setReview saves the review status along with its payment transaction
+//
+// The pre-intent workers will not be invoked, since the state change was
+// decided by a review.
+func (s *Service) setReview(tx *sql.Tx, paymentTx *payment.PaymentTransaction, r *payment.Review) (CommitIntentFunc, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "setReview",
+		"projectID": paymentTx.Payment.ProjectID(),
+		"paymentID": paymentTx.Payment.ID(),
+		"review":    r.Status,
+	})
+	err := s.SetPaymentTransaction(tx, paymentTx)
+	if err != nil {
+		return nil, err
+	}
+	err = payment.InsertPaymentReviewTx(s.ctx, tx, paymentTx.Payment, r)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return nil, wrapError(ErrDBLockTimeout, "setReview", err)
+		}
+		log.Error("error saving payment review", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "setReview", err)
+	}
+	log.Info("payment review", log15.Ctx{"createdBy": r.CreatedBy})
+	return s.newCommitIntentFunc(paymentTx), nil
+}
@@ -0,0 +1,47 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestPaymentReview(t *testing.T) {
+	Convey("Given a payment service", t, func() {
+		log := log15.New()
+		log.SetHandler(log15.DiscardHandler())
+		s := &Service{log: log}
+
+		Convey("When a paid payment should be held", func() {
+			p := &payment.Payment{Status: payment.PaymentStatusPaid}
+			_, err := s.HoldPayment(nil, p, &HoldError{Worker: "test", Reason: "velocity"})
+			Convey("It should not be allowed", func() {
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+				So(p.Status, ShouldEqual, payment.PaymentStatusPaid)
+			})
+		})
+		Convey("When an open payment should be approved", func() {
+			p := &payment.Payment{Status: payment.PaymentStatusOpen}
+			_, err := s.ApprovePayment(nil, p, "operator", "")
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrPaymentNotHeld)
+			})
+		})
+		Convey("When an open payment should be declined", func() {
+			p := &payment.Payment{Status: payment.PaymentStatusOpen}
+			_, err := s.DeclinePayment(nil, p, "operator", "")
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrPaymentNotHeld)
+			})
+		})
+	})
+
+	Convey("Given a hold error", t, func() {
+		var err error = &HoldError{Worker: "test", Reason: "velocity"}
+		Convey("It should describe the reason", func() {
+			So(err.Error(), ShouldContainSubstring, "velocity")
+		})
+	})
+}
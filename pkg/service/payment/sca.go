@@ -0,0 +1,94 @@
+package payment
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/paymentd/sca"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	// ErrRiskScore is returned when recording a risk score out of range
+	ErrRiskScore = errors.New("invalid risk score")
+)
+
+// SetPaymentRiskScore records the risk assessment of the payment
+//
+// The score ranges from 0 (lowest risk) to sca.MaxRiskScore. The source names
+// the risk check which assessed the payment. The latest assessment is used
+// when requesting TRA exemptions.
+func (s *Service) SetPaymentRiskScore(tx *sql.Tx, p *payment.Payment, score int, source string) error {
+	log := s.log.New(log15.Ctx{
+		"method":    "SetPaymentRiskScore",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	if score < 0 || score > sca.MaxRiskScore {
+		return ErrRiskScore
+	}
+	p.Risk = p.NewRisk(score, source)
+	err := payment.InsertPaymentRiskTx(s.ctx, tx, p)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "SetPaymentRiskScore", err)
+		}
+		log.Error("error saving payment risk", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "SetPaymentRiskScore", err)
+	}
+	return nil
+}
+
+// SCAExemption returns the SCA exemption which should be requested for the
+// payment according to the policy of its project
+//
+// It returns an empty string if the project has no policy or the payment is not
+// eligible for an exemption. Card drivers which support exemptions should
+// request the exemption from the provider and record the authentication
+// outcome on the payment transaction.
+func (s *Service) SCAExemption(tx *sql.Tx, p *payment.Payment) (string, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "SCAExemption",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.ProjectID())
+	if err != nil {
+		log.Error("error retrieving project", log15.Ctx{"err": err})
+		return "", wrapError(ErrDB, "SCAExemption", err)
+	}
+	policy, err := pr.Config.PaymentSCAPolicy()
+	if err != nil {
+		log.Error("invalid SCA policy", log15.Ctx{"err": err})
+		return "", wrapError(ErrInternal, "SCAExemption", err)
+	}
+	if policy == nil {
+		return "", nil
+	}
+	err = payment.PaymentCardTx(s.ctx, tx, p)
+	if err != nil {
+		log.Error("error retrieving payment card", log15.Ctx{"err": err})
+		return "", wrapError(ErrDB, "SCAExemption", err)
+	}
+	err = payment.PaymentRiskTx(s.ctx, tx, p)
+	if err != nil {
+		log.Error("error retrieving payment risk", log15.Ctx{"err": err})
+		return "", wrapError(ErrDB, "SCAExemption", err)
+	}
+	t := sca.Transaction{
+		Amount:   p.Decimal(),
+		Currency: p.Currency,
+		Country:  p.Config.Country.String,
+	}
+	if p.Card != nil && p.Card.Country != "" {
+		t.Country = p.Card.Country
+	}
+	if p.Risk != nil {
+		t.RiskScore = &p.Risk.Score
+	}
+	return policy.Exemption(t), nil
+}
@@ -2,79 +2,39 @@ package payment
 
 import (
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/chaos"
+	"github.com/fritzpay/paymentd/pkg/metadata"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
-	"github.com/fritzpay/paymentd/pkg/server"
+	"github.com/fritzpay/paymentd/pkg/paymentd/subscription"
 	"github.com/fritzpay/paymentd/pkg/service"
-	"github.com/go-sql-driver/mysql"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"golang.org/x/net/context"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
-type errorID int
-
-func (e errorID) Error() string {
-	switch e {
-	case ErrDB:
-		return "database error"
-	case ErrDBLockTimeout:
-		return "lock wait timeout"
-	case ErrDuplicateIdent:
-		return "duplicate ident in payment"
-	case ErrPaymentCallbackConfig:
-		return "callback config error"
-	case ErrPaymentMethodNotFound:
-		return "payment method not found"
-	case ErrPaymentMethodConflict:
-		return "payment method project mismatch"
-	case ErrPaymentMethodInactive:
-		return "payment method inactive"
-	case ErrPaymentMethodDisabled:
-		return "payment method disabled"
-	case ErrInternal:
-		return "internal error"
-	case ErrIntentTimeout:
-		return "intent timeout"
-	case ErrIntentNotAllowed:
-		return "intent not allowed"
-	default:
-		return "unknown error"
-	}
-}
-
 const (
-	// general database error
-	ErrDB errorID = iota
-	// lock wait timeout
-	ErrDBLockTimeout
-	// duplicate Ident in payment
-	ErrDuplicateIdent
-	// callback config error
-	ErrPaymentCallbackConfig
-	// payment method not found
-	ErrPaymentMethodNotFound
-	// payment method project mismatch
-	ErrPaymentMethodConflict
-	// payment method inactive
-	ErrPaymentMethodInactive
-	// payment method disabled
-	ErrPaymentMethodDisabled
-	// internal error
-	ErrInternal
-	// intent timeout
-	ErrIntentTimeout
-	// intent not allowed
-	ErrIntentNotAllowed
+	notificationBufferSize = 16
+	commitIntentTimeout    = time.Minute
 )
 
+// late commit policies
 const (
-	notificationBufferSize = 16
-	commitIntentTimeout    = time.Minute
+	// LateCommitReject rejects commits after the commit timeout with an
+	// ErrCommitExpired
+	LateCommitReject = "reject"
+	// LateCommitExecute executes the commit intent workers after the commit
+	// timeout with a warning
+	LateCommitExecute = "execute"
 )
 
 const (
@@ -82,6 +42,17 @@ const (
 	PaymentTokenMaxAgeDefault = time.Minute * 15
 	// PaymentTokenParam is the name of the token parameter
 	PaymentTokenParam = "token"
+	// SessionTTLDefault is the default time to live of checkout sessions
+	SessionTTLDefault = time.Minute * 30
+)
+
+// payment token modes
+const (
+	// PaymentTokenModeDatabase creates random payment tokens which are stored
+	// in the database
+	PaymentTokenModeDatabase = "database"
+	// PaymentTokenModeEncrypted creates stateless encrypted payment tokens
+	PaymentTokenModeEncrypted = "encrypted"
 )
 
 // IntentWorkers are the primary means of synchronizing and controlling changes on payment
@@ -119,7 +90,13 @@ type CommitIntentWorker interface {
 	CommitIntent(paymentTx *payment.PaymentTransaction) error
 }
 
-type CommitIntentFunc func()
+// CommitIntentFunc commits an intent
+//
+// It has to be called within the commit timeout after the intent was created.
+// Later commits are handled according to the late commit policy. If they are
+// rejected, an ErrCommitExpired will be returned and the commit intent workers
+// will not be invoked. Subsequent calls of a CommitIntentFunc have no effect.
+type CommitIntentFunc func() error
 
 // Service is the payment service
 type Service struct {
@@ -128,13 +105,39 @@ type Service struct {
 
 	idCoder *payment.IDEncoder
 
+	// tokenCipher decrypts encrypted payment tokens if token keys are configured
+	tokenCipher   *payment.TokenCipher
+	encryptTokens bool
+
+	// handling of received funds of transfer-based payment methods
+	underpaymentTolerance *dec.Dec
+	overpaymentPolicy     string
+
+	// handling of intents committed after the commit timeout
+	commitIntentTimeout time.Duration
+	lateCommitPolicy    string
+
+	// time within which held payments should be reviewed
+	reviewSLA time.Duration
+
+	// time to live of checkout sessions
+	sessionTTL time.Duration
+
+	// retries of failed recurring charges
+	retrySchedule subscription.RetrySchedule
+
 	tr *http.Transport
 	cl *http.Client
 
-	mIntent       sync.RWMutex
-	preIntents    []PreIntentWorker
-	postIntents   []PostIntentWorker
-	commitIntents []CommitIntentWorker
+	// HTTP clients for callbacks with client certificates
+	mCallbackClients sync.Mutex
+	callbackClients  map[string]*http.Client
+
+	mIntent         sync.RWMutex
+	preIntents      []PreIntentWorker
+	postIntents     []PostIntentWorker
+	commitIntents   []CommitIntentWorker
+	rejectedIntents []RejectedIntentWorker
 }
 
 // NewService creates a new payment service
@@ -142,12 +145,15 @@ func NewService(ctx *service.Context) (*Service, error) {
 	s := &Service{
 		ctx: ctx,
 		log: ctx.Log().New(log15.Ctx{
-			"pkg": "github.com/fritzpay/paymentd/pkg/service/payment",
+			"pkg": pkgPath,
 		}),
 
-		preIntents:    make([]PreIntentWorker, 0, 16),
-		postIntents:   make([]PostIntentWorker, 0, 16),
-		commitIntents: make([]CommitIntentWorker, 0, 16),
+		commitIntentTimeout: commitIntentTimeout,
+
+		preIntents:      make([]PreIntentWorker, 0, 16),
+		postIntents:     make([]PostIntentWorker, 0, 16),
+		commitIntents:   make([]CommitIntentWorker, 0, 16),
+		rejectedIntents: make([]RejectedIntentWorker, 0, 16),
 	}
 
 	var err error
@@ -158,46 +164,111 @@ func NewService(ctx *service.Context) (*Service, error) {
 		s.log.Error("error initializing payment ID encoder", log15.Ctx{"err": err})
 		return nil, err
 	}
+	err = s.initTokenCipher()
+	if err != nil {
+		s.log.Error("error initializing payment token cipher", log15.Ctx{"err": err})
+		return nil, err
+	}
+	err = s.initReceivedFunds()
+	if err != nil {
+		s.log.Error("error initializing received funds handling", log15.Ctx{"err": err})
+		return nil, err
+	}
+	switch cfg.Payment.LateCommitPolicy {
+	case "":
+		s.lateCommitPolicy = LateCommitReject
+	case LateCommitReject, LateCommitExecute:
+		s.lateCommitPolicy = cfg.Payment.LateCommitPolicy
+	default:
+		err = fmt.Errorf("invalid late commit policy %s", cfg.Payment.LateCommitPolicy)
+		s.log.Error("error initializing intent handling", log15.Ctx{"err": err})
+		return nil, err
+	}
+
+	if cfg.Payment.ReviewSLA != "" {
+		s.reviewSLA, err = cfg.Payment.ReviewSLA.Duration()
+		if err != nil {
+			s.log.Error("error initializing review SLA", log15.Ctx{"err": err})
+			return nil, err
+		}
+	}
+	s.sessionTTL = SessionTTLDefault
+	if cfg.Payment.SessionTTL != "" {
+		s.sessionTTL, err = cfg.Payment.SessionTTL.Duration()
+		if err != nil {
+			s.log.Error("error initializing checkout session TTL", log15.Ctx{"err": err})
+			return nil, err
+		}
+	}
+	s.retrySchedule = make(subscription.RetrySchedule, len(cfg.Payment.Dunning.RetrySchedule))
+	for i, delay := range cfg.Payment.Dunning.RetrySchedule {
+		s.retrySchedule[i], err = delay.Duration()
+		if err == nil && s.retrySchedule[i] <= 0 {
+			err = fmt.Errorf("invalid dunning retry delay %s", delay)
+		}
+		if err != nil {
+			s.log.Error("error initializing dunning retry schedule", log15.Ctx{"err": err})
+			return nil, err
+		}
+	}
 
 	s.tr = &http.Transport{}
 	s.cl = &http.Client{
-		Transport: s.tr,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) > 10 {
-				return errors.New("too many redirects")
-			}
-			// keep user-agent
-			if len(via) > 0 {
-				lastReq := via[len(via)-1]
-				if lastReq.Header.Get("User-Agent") != "" {
-					req.Header.Set("User-Agent", lastReq.Header.Get("User-Agent"))
-				}
-			}
-			return nil
-		},
+		Transport:     chaos.WrapTransport(s.tr, ctx.Chaos().Notification),
+		CheckRedirect: checkCallbackRedirect,
+		Timeout:       ctx.Notifications().Timeout(),
 	}
+	s.callbackClients = make(map[string]*http.Client)
 
 	s.RegisterCommitIntentWorker(&intentNotify{s})
+	s.RegisterRejectedIntentWorker(&intentAudit{s})
+	if declines := ctx.Declines(); declines != nil {
+		s.RegisterCommitIntentWorker(&declineMonitor{s: s, windows: declines})
+	}
 
-	go s.handleBackground()
+	ctx.CacheWarmer().Register("payment_methods", s.warmPaymentMethods)
+
+	ctx.Jobs().OnShutdown(s.closeIdleConnections)
 
 	return s, nil
 }
 
-func (s *Service) handleBackground() {
-	// if attached to a server, this will tell the server to wait with shutting down
-	// until the cleanup process is complete
-	server.Wait.Add(1)
-	defer server.Wait.Done()
-	for {
-		select {
-		case <-s.ctx.Done():
-			s.log.Info("service context closed", log15.Ctx{"err": s.ctx.Err()})
-			s.log.Info("closing idle connections...")
-			s.tr.CloseIdleConnections()
-			return
+func (s *Service) initTokenCipher() error {
+	cfg := s.ctx.Config()
+	if len(cfg.Payment.TokenKeys) > 0 {
+		keys := make([][]byte, len(cfg.Payment.TokenKeys))
+		for i, k := range cfg.Payment.TokenKeys {
+			var err error
+			keys[i], err = hex.DecodeString(k)
+			if err != nil {
+				return fmt.Errorf("invalid token key: %v", err)
+			}
+		}
+		var err error
+		s.tokenCipher, err = payment.NewTokenCipher(keys)
+		if err != nil {
+			return err
 		}
 	}
+	switch cfg.Payment.TokenMode {
+	case "", PaymentTokenModeDatabase:
+	case PaymentTokenModeEncrypted:
+		if s.tokenCipher == nil {
+			return errors.New("encrypted payment tokens require token keys")
+		}
+		s.encryptTokens = true
+	default:
+		return fmt.Errorf("invalid payment token mode %s", cfg.Payment.TokenMode)
+	}
+	return nil
+}
+
+// closeIdleConnections closes the idle connections of the callback client on
+// shutdown
+func (s *Service) closeIdleConnections() {
+	s.log.Info("service context closed", log15.Ctx{"err": s.ctx.Err()})
+	s.log.Info("closing idle connections...")
+	s.tr.CloseIdleConnections()
 }
 
 func (s *Service) RegisterPreIntentWorker(worker PreIntentWorker) {
@@ -231,19 +302,24 @@ func (s *Service) DecodedPaymentID(id payment.PaymentID) payment.PaymentID {
 }
 
 // CreatePayment creates a new payment
+//
+// The payment metadata will be validated against the metadata size limits and
+// the metadata schema of the project. Metadata exceeding the limits is returned
+// as a *metadata.LimitError, metadata not matching the schema as a
+// *metadata.SchemaError.
 func (s *Service) CreatePayment(tx *sql.Tx, p *payment.Payment) error {
 	log := s.log.New(log15.Ctx{
 		"method": "CreatePayment",
 	})
 	if p.Config.HasCallback() {
-		callbackProjectKey, err := project.ProjectKeyByKeyDB(s.ctx.PrincipalDB(service.ReadOnly), p.Config.CallbackProjectKey.String)
+		callbackProjectKey, err := project.ProjectKeyByKeyDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), p.Config.CallbackProjectKey.String)
 		if err != nil {
 			if err == project.ErrProjectKeyNotFound {
 				log.Error("callback project key not found", log15.Ctx{"callbackProjectKey": p.Config.CallbackProjectKey.String})
 				return ErrPaymentCallbackConfig
 			}
 			log.Error("error retrieving callback project key", log15.Ctx{"err": err})
-			return ErrDB
+			return wrapError(ErrDB, "CreatePayment", err)
 		}
 		if callbackProjectKey.Project.ID != p.ProjectID() {
 			log.Error("callback project mismatch", log15.Ctx{
@@ -254,24 +330,61 @@ func (s *Service) CreatePayment(tx *sql.Tx, p *payment.Payment) error {
 			return ErrPaymentCallbackConfig
 		}
 	}
-	err := payment.InsertPaymentTx(tx, p)
-	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			if mysqlErr.Number == 1213 {
-				return ErrDBLockTimeout
+	if p.Parent != nil {
+		if !payment.ValidRelation(p.Parent.Type) {
+			log.Warn("invalid payment relation", log15.Ctx{"relation": p.Parent.Type})
+			return ErrPaymentParent
+		}
+		parentID, _ := p.ParentPaymentID()
+		_, err := payment.PaymentByIDTx(s.ctx, tx, parentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				log.Warn("parent payment not found", log15.Ctx{"parentID": parentID.PaymentID})
+				return ErrPaymentParent
 			}
+			log.Error("error retrieving parent payment", log15.Ctx{"err": err})
+			return wrapError(ErrDB, "CreatePayment", err)
+		}
+	}
+	err := s.validateMetadata(p)
+	if err != nil {
+		return err
+	}
+	if p.Config.PaymentMethodID.Valid {
+		err = s.routePaymentMethodCurrency(tx, p)
+		if err != nil {
+			return err
+		}
+	}
+	err = payment.InsertPaymentTx(s.ctx, tx, p)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "CreatePayment", err)
 		}
-		_, existErr := payment.PaymentByProjectIDAndIdentTx(tx, p.ProjectID(), p.Ident)
+		_, existErr := payment.PaymentByProjectIDAndIdentTx(s.ctx, tx, p.ProjectID(), p.Ident)
 		if existErr != nil && existErr != payment.ErrPaymentNotFound {
-			log.Error("error on checking duplicate ident", log15.Ctx{"err": err})
-			return ErrDB
+			log.Error("error on checking duplicate ident", log15.Ctx{"err": existErr})
+			return wrapError(ErrDB, "CreatePayment", existErr)
 		}
 		// payment found => duplicate error
 		if existErr == nil {
 			return ErrDuplicateIdent
 		}
 		log.Error("error on insert payment", log15.Ctx{"err": err})
-		return ErrDB
+		return wrapError(ErrDB, "CreatePayment", err)
+	}
+	change, err := payment.NewCreatedChange(p)
+	if err != nil {
+		log.Error("error creating change", log15.Ctx{"err": err})
+		return wrapError(ErrInternal, "CreatePayment", err)
+	}
+	err = s.addChange(tx, change)
+	if err != nil {
+		return err
+	}
+	err = s.setPaymentParent(tx, p)
+	if err != nil {
+		return err
 	}
 	err = s.SetPaymentConfig(tx, p)
 	if err != nil {
@@ -281,27 +394,107 @@ func (s *Service) CreatePayment(tx *sql.Tx, p *payment.Payment) error {
 	if err != nil {
 		return err
 	}
+	err = s.SetPaymentNote(tx, p)
+	if err != nil {
+		return err
+	}
+	err = s.SetPaymentReference(tx, p)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// maxCurrencyFallbacks limits the chain of currency fallbacks of payment methods
+const maxCurrencyFallbacks = 3
+
+// routePaymentMethodCurrency ensures the configured payment method of the
+// payment can process the payment currency
+//
+// If the payment method cannot process the currency according to its
+// capabilities, the payment will be routed to its currency fallback. It returns
+// ErrPaymentMethodCurrency if neither the method nor its fallbacks can process
+// the currency.
+func (s *Service) routePaymentMethodCurrency(tx *sql.Tx, p *payment.Payment) error {
+	log := s.log.New(log15.Ctx{
+		"method":          "routePaymentMethodCurrency",
+		"paymentMethodID": p.Config.PaymentMethodID.Int64,
+		"currency":        p.Currency,
+	})
+	methodID := p.Config.PaymentMethodID.Int64
+	for i := 0; i <= maxCurrencyFallbacks; i++ {
+		meth, err := payment_method.PaymentMethodByIDTx(s.ctx, tx, methodID)
+		if err != nil {
+			if err == payment_method.ErrPaymentMethodNotFound {
+				return ErrPaymentMethodNotFound
+			}
+			log.Error("error retrieving payment method", log15.Ctx{"err": err})
+			return wrapError(ErrDB, "routePaymentMethodCurrency", err)
+		}
+		if meth.ProjectID != p.ProjectID() {
+			return ErrPaymentMethodConflict
+		}
+		meth.Metadata, err = payment_method.PaymentMethodMetadataTx(s.ctx, tx, meth)
+		if err != nil {
+			log.Error("error retrieving payment method metadata", log15.Ctx{"err": err})
+			return wrapError(ErrDB, "routePaymentMethodCurrency", err)
+		}
+		// fallbacks must be active, the requested method is checked on checkout
+		if (i == 0 || meth.Active()) && meth.SupportsCurrency(p.Currency) {
+			if i > 0 {
+				log.Info("payment routed to currency fallback", log15.Ctx{"fallbackID": meth.ID})
+				p.Config.SetPaymentMethodID(meth.ID)
+			}
+			return nil
+		}
+		var ok bool
+		if methodID, ok = meth.CurrencyFallbackID(); !ok {
+			break
+		}
+	}
+	log.Info("payment method cannot process currency")
+	return ErrPaymentMethodCurrency
+}
+
+// setPaymentParent saves the relation of a new payment to its parent
+func (s *Service) setPaymentParent(tx *sql.Tx, p *payment.Payment) error {
+	if p.Parent == nil {
+		return nil
+	}
+	log := s.log.New(log15.Ctx{"method": "setPaymentParent"})
+	err := payment.InsertPaymentRelationTx(s.ctx, tx, p)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "setPaymentParent", err)
+		}
+		log.Error("error on insert payment relation", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "setPaymentParent", err)
+	}
+	parentID, _ := p.ParentPaymentID()
+	change, err := payment.NewRelationChange(p, s.EncodedPaymentID(parentID))
+	if err != nil {
+		log.Error("error creating change", log15.Ctx{"err": err})
+		return wrapError(ErrInternal, "setPaymentParent", err)
+	}
+	return s.addChange(tx, change)
+}
+
 // SetPaymentConfig sets/updates the payment configuration
 func (s *Service) SetPaymentConfig(tx *sql.Tx, p *payment.Payment) error {
 	log := s.log.New(log15.Ctx{"method": "SetPaymentConfig"})
 	if p.Config.PaymentMethodID.Valid {
 		log = log.New(log15.Ctx{"paymentMethodID": p.Config.PaymentMethodID.Int64})
-		meth, err := payment_method.PaymentMethodByIDTx(tx, p.Config.PaymentMethodID.Int64)
+		meth, err := payment_method.PaymentMethodByIDTx(s.ctx, tx, p.Config.PaymentMethodID.Int64)
 		if err != nil {
-			if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-				if mysqlErr.Number == 1213 {
-					return ErrDBLockTimeout
-				}
+			if sqldialect.IsDeadlock(err) {
+				return wrapError(ErrDBLockTimeout, "SetPaymentConfig", err)
 			}
 			if err == payment_method.ErrPaymentMethodNotFound {
 				log.Warn(ErrPaymentMethodNotFound.Error())
 				return ErrPaymentMethodNotFound
 			}
 			log.Error("error on select payment method", log15.Ctx{"err": err})
-			return ErrDB
+			return wrapError(ErrDB, "SetPaymentConfig", err)
 		}
 		if meth.ProjectID != p.ProjectID() {
 			log.Warn(ErrPaymentMethodConflict.Error())
@@ -312,37 +505,74 @@ func (s *Service) SetPaymentConfig(tx *sql.Tx, p *payment.Payment) error {
 			return ErrPaymentMethodInactive
 		}
 	}
-	err := payment.InsertPaymentConfigTx(tx, p)
+	err := payment.InsertPaymentConfigTx(s.ctx, tx, p)
 	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			if mysqlErr.Number == 1213 {
-				return ErrDBLockTimeout
-			}
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "SetPaymentConfig", err)
 		}
 		log.Error("error on insert payment config", log15.Ctx{"err": err})
-		return ErrDB
+		return wrapError(ErrDB, "SetPaymentConfig", err)
 	}
-	return nil
+	change, err := payment.NewConfigChange(p)
+	if err != nil {
+		log.Error("error creating change", log15.Ctx{"err": err})
+		return wrapError(ErrInternal, "SetPaymentConfig", err)
+	}
+	return s.addChange(tx, change)
 }
 
 // SetPaymentMetadata sets/updates the payment metadata
+//
+// Metadata exceeding the metadata.MaxLimits is returned as a
+// *metadata.LimitError.
 func (s *Service) SetPaymentMetadata(tx *sql.Tx, p *payment.Payment) error {
 	log := s.log.New(log15.Ctx{"method": "SetPaymentMetadata"})
 	// payment metadata
 	if p.Metadata == nil {
 		return nil
 	}
-	err := payment.InsertPaymentMetadataTx(tx, p)
+	err := payment.InsertPaymentMetadataTx(s.ctx, tx, p)
 	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			if mysqlErr.Number == 1213 {
-				return ErrDBLockTimeout
-			}
+		if limitErr, ok := err.(*metadata.LimitError); ok {
+			log.Warn("payment metadata exceeds limits", log15.Ctx{"err": limitErr})
+			return limitErr
+		}
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "SetPaymentMetadata", err)
 		}
 		log.Error("error on insert payment metadata", log15.Ctx{"err": err})
-		return ErrDB
+		return wrapError(ErrDB, "SetPaymentMetadata", err)
 	}
-	return nil
+	change, err := payment.NewMetadataChange(p)
+	if err != nil {
+		log.Error("error creating change", log15.Ctx{"err": err})
+		return wrapError(ErrInternal, "SetPaymentMetadata", err)
+	}
+	return s.addChange(tx, change)
+}
+
+// SetPaymentNote sets/updates the payment note
+//
+// It is a no-op if the payment has no note. An empty note text clears the note.
+func (s *Service) SetPaymentNote(tx *sql.Tx, p *payment.Payment) error {
+	log := s.log.New(log15.Ctx{"method": "SetPaymentNote"})
+	if p.Note == nil {
+		return nil
+	}
+	err := payment.InsertPaymentNoteTx(s.ctx, tx, p)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "SetPaymentNote", err)
+		}
+		log.Error("error on insert payment note", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "SetPaymentNote", err)
+	}
+	change, err := payment.NewNoteChange(p)
+	if err != nil {
+		log.Error("error creating change", log15.Ctx{"err": err})
+		return wrapError(ErrInternal, "SetPaymentNote", err)
+	}
+	return s.addChange(tx, change)
 }
 
 // IsProcessablePayment returns true if the given payment is considered processable
@@ -372,21 +602,49 @@ func (s *Service) IsInitialized(p *payment.Payment) bool {
 
 // SetPaymentTransaction adds a new payment transaction
 //
+// If the event log is enabled, the transaction will be appended to the event
+// chain of the project.
+//
 // If a callback method is configured for this payment/project, it will send a callback
 // notification
+//
+// Captured funds of projects in escrow mode will be held in escrow.
 func (s *Service) SetPaymentTransaction(tx *sql.Tx, paymentTx *payment.PaymentTransaction) error {
 	log := s.log.New(log15.Ctx{"method": "SetPaymentTransaction"})
-	err := payment.InsertPaymentTransactionTx(tx, paymentTx)
+	err := payment.InsertPaymentTransactionTx(s.ctx, tx, paymentTx)
 	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			if mysqlErr.Number == 1213 {
-				return ErrDBLockTimeout
-			}
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "SetPaymentTransaction", err)
 		}
 		log.Error("error saving payment transaction", log15.Ctx{"err": err})
-		return ErrDB
+		return wrapError(ErrDB, "SetPaymentTransaction", err)
 	}
-	return nil
+	if s.ctx.Config().Payment.EventLog {
+		err = payment.AppendEventTx(s.ctx, tx, payment.NewEvent(paymentTx))
+		if err != nil {
+			if sqldialect.IsDeadlock(err) {
+				return wrapError(ErrDBLockTimeout, "SetPaymentTransaction", err)
+			}
+			log.Error("error saving payment event", log15.Ctx{"err": err})
+			return wrapError(ErrDB, "SetPaymentTransaction", err)
+		}
+	}
+	if paymentTx.Status == payment.PaymentStatusAuthorized || paymentTx.Status == payment.PaymentStatusPaid {
+		err = s.estimatePaymentCost(tx, paymentTx.Payment)
+		if err != nil {
+			return err
+		}
+	}
+	err = s.adjustEscrow(tx, paymentTx)
+	if err != nil {
+		return err
+	}
+	change, err := payment.NewTransactionChange(paymentTx)
+	if err != nil {
+		log.Error("error creating change", log15.Ctx{"err": err})
+		return wrapError(ErrInternal, "SetPaymentTransaction", err)
+	}
+	return s.addChange(tx, change)
 }
 
 // PaymentTransaction returns the current payment transaction for the given payment
@@ -394,15 +652,21 @@ func (s *Service) SetPaymentTransaction(tx *sql.Tx, paymentTx *payment.PaymentTr
 // PaymentTransaction will return a payment.ErrPaymentTransactionNotFound if no such
 // transaction exists (i.e. the payment is uninitialized)
 func (s *Service) PaymentTransaction(tx *sql.Tx, p *payment.Payment) (*payment.PaymentTransaction, error) {
-	return payment.PaymentTransactionCurrentTx(tx, p)
+	return payment.PaymentTransactionCurrentTx(s.ctx, tx, p)
 }
 
 func (s *Service) handleIntent(
+	ctx context.Context,
 	p *payment.Payment,
 	paymentTx *payment.PaymentTransaction,
 	timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
 
-	if deadline, ok := s.ctx.Deadline(); ok {
+	// the context of the intent is done or will be done before the intent
+	// workers had their time
+	if ctx.Err() != nil {
+		return nil, nil, ErrIntentTimeout
+	}
+	if deadline, ok := ctx.Deadline(); ok {
 		if time.Now().Add(timeout).After(deadline) {
 			return nil, nil, ErrIntentTimeout
 		}
@@ -423,10 +687,10 @@ func (s *Service) handleIntent(
 		// wait
 		select {
 		// context cancelled
-		case <-s.ctx.Done():
+		case <-ctx.Done():
 			close(done)
 			s.mIntent.RUnlock()
-			return nil, nil, s.ctx.Err()
+			return nil, nil, ctx.Err()
 
 		// error received
 		case err := <-c:
@@ -466,151 +730,285 @@ func (s *Service) handleIntent(
 	}
 
 	if len(s.commitIntents) > 0 {
-		// commit intent
-		commit := make(chan struct{})
-		commitFunc = CommitIntentFunc(func() {
-			close(commit)
-		})
-		errC := make(chan error)
-		go func() {
-			select {
-			case <-commit:
-				var wg sync.WaitGroup
-				s.mIntent.RLock()
-				for _, w := range s.commitIntents {
-					wg.Add(1)
-					go func(w CommitIntentWorker) { errC <- w.CommitIntent(paymentTx) }(w)
-				}
-				s.mIntent.RUnlock()
-				go func() {
-					for {
-						err, ok := <-errC
-						if !ok {
-							return
-						}
-						wg.Done()
-						if err != nil {
-							s.log.Warn("error on commit intent action", log15.Ctx{
-								"intent": paymentTx.Status.String(),
-								"err":    err,
-							})
-						}
-					}
-				}()
-				wg.Wait()
-			case <-time.After(commitIntentTimeout):
-			}
-		}()
+		commitFunc = s.newCommitIntentFunc(paymentTx)
 	}
 	s.mIntent.RUnlock()
 
 	return paymentTx, commitFunc, nil
 }
 
-func (s *Service) IntentOpen(p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+// newCommitIntentFunc returns the CommitIntentFunc for the given transaction
+//
+// The intent expires after the commit timeout.
+func (s *Service) newCommitIntentFunc(paymentTx *payment.PaymentTransaction) CommitIntentFunc {
+	var m sync.Mutex
+	var committed, expired bool
+	created := time.Now()
+	expire := time.AfterFunc(s.commitIntentTimeout, func() {
+		m.Lock()
+		expired = true
+		m.Unlock()
+	})
+	return CommitIntentFunc(func() error {
+		m.Lock()
+		defer m.Unlock()
+		if committed {
+			return nil
+		}
+		committed = true
+		expire.Stop()
+		if expired {
+			log := s.log.New(log15.Ctx{
+				"intent":        paymentTx.Status.String(),
+				"paymentID":     paymentTx.Payment.ID(),
+				"projectID":     paymentTx.Payment.ProjectID(),
+				"commitTimeout": s.commitIntentTimeout,
+				"elapsed":       time.Since(created),
+			})
+			if s.lateCommitPolicy != LateCommitExecute {
+				log.Error("intent commit rejected after commit timeout")
+				return ErrCommitExpired
+			}
+			log.Warn("intent committed after commit timeout")
+		}
+		go s.commitIntent(paymentTx)
+		return nil
+	})
+}
+
+// commitIntent invokes the commit intent workers and waits for them to finish
+func (s *Service) commitIntent(paymentTx *payment.PaymentTransaction) {
+	var wg sync.WaitGroup
+	s.mIntent.RLock()
+	for _, w := range s.commitIntents {
+		wg.Add(1)
+		go func(w CommitIntentWorker) {
+			defer wg.Done()
+			err := w.CommitIntent(paymentTx)
+			if err != nil {
+				s.log.Warn("error on commit intent action", log15.Ctx{
+					"intent": paymentTx.Status.String(),
+					"err":    err,
+				})
+			}
+		}(w)
+	}
+	s.mIntent.RUnlock()
+	wg.Wait()
+}
+
+func (s *Service) IntentOpen(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
 	if !s.IsProcessablePayment(p) {
-		return nil, nil, ErrIntentNotAllowed
+		return s.rejectIntent(p, payment.PaymentStatusOpen, ErrIntentNotAllowed)
 	}
-	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
 	if err != nil {
 		return nil, nil, err
 	}
 	if !meth.Active() {
-		return nil, nil, ErrPaymentMethodInactive
+		return s.rejectIntent(p, payment.PaymentStatusOpen, ErrPaymentMethodInactive)
 	}
 	paymentTx := p.NewTransaction(payment.PaymentStatusOpen)
 	paymentTx.Amount = paymentTx.Amount * -1
-	return s.handleIntent(p, paymentTx, timeout)
+	return s.handleIntent(ctx, p, paymentTx, timeout)
 }
 
-func (s *Service) IntentCancel(p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+func (s *Service) IntentCancel(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
 	if p.Status != payment.PaymentStatusOpen {
-		return nil, nil, ErrIntentNotAllowed
+		return s.rejectIntent(p, payment.PaymentStatusCancelled, ErrIntentNotAllowed)
 	}
-	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
 	if err != nil {
 		return nil, nil, err
 	}
 	if meth.Disabled() {
-		return nil, nil, ErrPaymentMethodDisabled
+		return s.rejectIntent(p, payment.PaymentStatusCancelled, ErrPaymentMethodDisabled)
 	}
 	paymentTx := p.NewTransaction(payment.PaymentStatusCancelled)
 	paymentTx.Amount = 0
-	return s.handleIntent(p, paymentTx, timeout)
+	return s.handleIntent(ctx, p, paymentTx, timeout)
 }
 
-func (s *Service) IntentPaid(p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
-	if p.Status != payment.PaymentStatusOpen {
-		return nil, nil, ErrIntentNotAllowed
+func (s *Service) IntentPaid(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if p.Status != payment.PaymentStatusOpen || p.IsVerification() {
+		return s.rejectIntent(p, payment.PaymentStatusPaid, ErrIntentNotAllowed)
 	}
-	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
 	if err != nil {
 		return nil, nil, err
 	}
 	if meth.Disabled() {
-		return nil, nil, ErrPaymentMethodDisabled
+		return s.rejectIntent(p, payment.PaymentStatusPaid, ErrPaymentMethodDisabled)
 	}
 	paymentTx := p.NewTransaction(payment.PaymentStatusPaid)
-	return s.handleIntent(p, paymentTx, timeout)
+	return s.handleIntent(ctx, p, paymentTx, timeout)
 }
 
-func (s *Service) IntentAuthorized(p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
-	if p.Status != payment.PaymentStatusOpen {
-		return nil, nil, ErrIntentNotAllowed
+func (s *Service) IntentAuthorized(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if p.Status != payment.PaymentStatusOpen || p.IsVerification() {
+		return s.rejectIntent(p, payment.PaymentStatusAuthorized, ErrIntentNotAllowed)
 	}
-	meth, err := payment_method.PaymentMethodByIDDB(s.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
 	if err != nil {
 		return nil, nil, err
 	}
 	if meth.Disabled() {
-		return nil, nil, ErrPaymentMethodDisabled
+		return s.rejectIntent(p, payment.PaymentStatusAuthorized, ErrPaymentMethodDisabled)
 	}
 	paymentTx := p.NewTransaction(payment.PaymentStatusAuthorized)
 	paymentTx.Amount = 0
-	return s.handleIntent(p, paymentTx, timeout)
+	return s.handleIntent(ctx, p, paymentTx, timeout)
+}
+
+// IntentFailed creates a transaction for a failed payment, e.g. a payment
+// declined by the provider
+func (s *Service) IntentFailed(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if p.Status != payment.PaymentStatusOpen {
+		return s.rejectIntent(p, payment.PaymentStatusFailed, ErrIntentNotAllowed)
+	}
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meth.Disabled() {
+		return s.rejectIntent(p, payment.PaymentStatusFailed, ErrPaymentMethodDisabled)
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusFailed)
+	paymentTx.Amount = 0
+	return s.handleIntent(ctx, p, paymentTx, timeout)
+}
+
+// IntentVerified creates a transaction for a verified verification payment
+//
+// Only zero-amount payments can be verified. Verification payments will not be
+// paid or authorized. Pending payments can be verified, since providers might
+// need the customer to confirm the verification, e.g. a direct debit mandate.
+func (s *Service) IntentVerified(ctx context.Context, p *payment.Payment, timeout time.Duration) (*payment.PaymentTransaction, CommitIntentFunc, error) {
+	if !p.IsVerification() {
+		return s.rejectIntent(p, payment.PaymentStatusVerified, ErrIntentNotAllowed)
+	}
+	if p.Status != payment.PaymentStatusOpen && p.Status != payment.PaymentStatusPending {
+		return s.rejectIntent(p, payment.PaymentStatusVerified, ErrIntentNotAllowed)
+	}
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meth.Disabled() {
+		return s.rejectIntent(p, payment.PaymentStatusVerified, ErrPaymentMethodDisabled)
+	}
+	paymentTx := p.NewTransaction(payment.PaymentStatusVerified)
+	return s.handleIntent(ctx, p, paymentTx, timeout)
 }
 
-// CreatePaymentToken creates a new random payment token
+// CreatePaymentToken creates a new payment token
+//
+// Depending on the configured token mode, the token will either be a random
+// token stored in the database or a stateless encrypted token.
 func (s *Service) CreatePaymentToken(tx *sql.Tx, p *payment.Payment) (*payment.PaymentToken, error) {
 	log := s.log.New(log15.Ctx{"method": "CreatePaymentToken"})
+	if s.encryptTokens {
+		token := &payment.PaymentToken{
+			Created: time.Now(),
+		}
+		var err error
+		token.Token, err = s.tokenCipher.Encrypt(p.PaymentID(), token.Created.Add(PaymentTokenMaxAgeDefault))
+		if err != nil {
+			log.Error("error encrypting payment token", log15.Ctx{"err": err})
+			return nil, wrapError(ErrInternal, "CreatePaymentToken", err)
+		}
+		return token, nil
+	}
 	token, err := payment.NewPaymentToken(p.PaymentID())
 	if err != nil {
 		log.Error("error creating payment token", log15.Ctx{"err": err})
-		return nil, ErrInternal
+		return nil, wrapError(ErrInternal, "CreatePaymentToken", err)
 	}
-	err = payment.InsertPaymentTokenTx(tx, token)
+	err = payment.InsertPaymentTokenTx(s.ctx, tx, token)
 	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			if mysqlErr.Number == 1213 {
-				return nil, ErrDBLockTimeout
-			}
+		if sqldialect.IsDeadlock(err) {
+			return nil, wrapError(ErrDBLockTimeout, "CreatePaymentToken", err)
 		}
 		log.Error("error saving payment token", log15.Ctx{"err": err})
-		return nil, ErrDB
+		return nil, wrapError(ErrDB, "CreatePaymentToken", err)
 	}
 	return token, nil
 }
 
 // PaymentByToken returns the payment associated with the given payment token
 //
+// Encrypted tokens will be decrypted. Invalid and expired encrypted tokens will
+// result in a payment.ErrPaymentNotFound.
+//
 // TODO use token max age from config
 func (s *Service) PaymentByToken(tx *sql.Tx, token string) (*payment.Payment, error) {
+	if payment.IsEncryptedToken(token) {
+		if s.tokenCipher == nil {
+			return nil, payment.ErrPaymentNotFound
+		}
+		id, _, err := s.tokenCipher.Decrypt(token)
+		if err != nil {
+			s.log.Info("rejected encrypted payment token", log15.Ctx{"err": err})
+			return nil, payment.ErrPaymentNotFound
+		}
+		return payment.PaymentByIDTx(s.ctx, tx, id)
+	}
 	tokenMaxAge := PaymentTokenMaxAgeDefault
-	return payment.PaymentByTokenTx(tx, token, tokenMaxAge)
+	return payment.PaymentByTokenTx(s.ctx, tx, token, tokenMaxAge)
+}
+
+// PaymentTokenProjectID returns the ID of the project of the payment associated
+// with the given payment token
+//
+// The project determines the shard of the payment. Stored tokens are searched
+// in all shards. Invalid and expired tokens will result in a
+// payment.ErrPaymentNotFound.
+func (s *Service) PaymentTokenProjectID(token string) (int64, error) {
+	if payment.IsEncryptedToken(token) {
+		if s.tokenCipher == nil {
+			return 0, payment.ErrPaymentNotFound
+		}
+		id, _, err := s.tokenCipher.Decrypt(token)
+		if err != nil {
+			s.log.Info("rejected encrypted payment token", log15.Ctx{"err": err})
+			return 0, payment.ErrPaymentNotFound
+		}
+		return id.ProjectID, nil
+	}
+	for _, sh := range s.ctx.PaymentShardDBs() {
+		p, err := payment.PaymentByTokenDB(s.ctx, sh.DB, token, PaymentTokenMaxAgeDefault)
+		if err == payment.ErrPaymentNotFound {
+			continue
+		}
+		if err != nil {
+			s.log.Error("error retrieving payment token", log15.Ctx{
+				"method": "PaymentTokenProjectID",
+				"shard":  sh.Shard,
+				"err":    err,
+			})
+			return 0, wrapError(ErrDB, "PaymentTokenProjectID", err)
+		}
+		return p.ProjectID(), nil
+	}
+	return 0, payment.ErrPaymentNotFound
 }
 
 // DeletePaymentToken deletes/invalidates the given payment token
+//
+// Encrypted tokens are not stored and cannot be invalidated. They stay valid
+// until they expire.
 func (s *Service) DeletePaymentToken(tx *sql.Tx, token string) error {
+	if payment.IsEncryptedToken(token) {
+		return nil
+	}
 	log := s.log.New(log15.Ctx{"method": "DeletePaymentToken"})
-	err := payment.DeletePaymentTokenTx(tx, token)
+	err := payment.DeletePaymentTokenTx(s.ctx, tx, token)
 	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			if mysqlErr.Number == 1213 {
-				return ErrDBLockTimeout
-			}
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "DeletePaymentToken", err)
 		}
 		log.Error("error deleting payment token", log15.Ctx{"err": err})
-		return ErrDB
+		return wrapError(ErrDB, "DeletePaymentToken", err)
 	}
 	return nil
 }
@@ -66,7 +66,7 @@ func TestPaymentNotification(t *testing.T) {
 								})
 
 								Convey("Given a test callback configuration", func() {
-									testPk, err := project.ProjectKeyByKeyDB(principalDB, "testkey")
+									testPk, err := project.ProjectKeyByKeyDB(ctx, principalDB, "testkey")
 									So(err, ShouldBeNil)
 									So(testPk.IsValid(), ShouldBeTrue)
 
@@ -84,7 +84,7 @@ func TestPaymentNotification(t *testing.T) {
 										Convey("When creating a transaction", func() {
 											So(s.IsProcessablePayment(p), ShouldBeTrue)
 											var commitIntent paymentService.CommitIntentFunc
-											paymentTx, commitIntent, err = s.IntentOpen(p, 500*time.Millisecond)
+											paymentTx, commitIntent, err = s.IntentOpen(ctx, p, 500*time.Millisecond)
 											So(err, ShouldBeNil)
 											So(commitIntent, ShouldNotBeNil)
 											So(paymentTx.Timestamp.UnixNano(), ShouldNotEqual, 0)
@@ -96,7 +96,7 @@ func TestPaymentNotification(t *testing.T) {
 												So(err, ShouldBeNil)
 
 												Convey("When requesting a notification", func() {
-													commitIntent()
+													So(commitIntent(), ShouldBeNil)
 
 													Convey("A notification should be sent", func() {
 														select {
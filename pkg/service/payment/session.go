@@ -0,0 +1,88 @@
+package payment
+
+import (
+	"database/sql"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/checkout"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// CreateSession saves a new checkout session
+//
+// The session expires after the configured time to live.
+func (s *Service) CreateSession(tx *sql.Tx, sess *checkout.Session) error {
+	sess.Renew(s.sessionTTL)
+	err := checkout.InsertSessionTx(tx, sess)
+	if err != nil {
+		return s.sessionDBErr("CreateSession", err)
+	}
+	return nil
+}
+
+// UpdateSession saves the changed data of a checkout session
+//
+// The expiry of the session will be renewed. Expired and converted sessions
+// cannot be updated. The session should be locked for the transaction.
+func (s *Service) UpdateSession(tx *sql.Tx, sess *checkout.Session) error {
+	if sess.Converted() {
+		return ErrSessionConverted
+	}
+	if sess.Expired() {
+		return ErrSessionExpired
+	}
+	sess.Renew(s.sessionTTL)
+	err := checkout.InsertSessionDataTx(tx, sess)
+	if err != nil {
+		return s.sessionDBErr("UpdateSession", err)
+	}
+	return nil
+}
+
+// ConvertSession marks the checkout session as converted into the given
+// payment
+//
+// The payment must have been created within the transaction. Its project,
+// amount, currency and country must match the session. The session should be
+// locked for the transaction.
+func (s *Service) ConvertSession(tx *sql.Tx, sess *checkout.Session, p *payment.Payment) error {
+	if sess.Converted() {
+		return ErrSessionConverted
+	}
+	if sess.Expired() {
+		return ErrSessionExpired
+	}
+	if !sessionMatches(sess, p) {
+		return ErrSessionMismatch
+	}
+	err := checkout.InsertSessionPaymentTx(tx, sess, p.PaymentID())
+	if err != nil {
+		if err == checkout.ErrSessionConverted {
+			return ErrSessionConverted
+		}
+		return s.sessionDBErr("ConvertSession", err)
+	}
+	return nil
+}
+
+// sessionMatches returns true if the payment was initialized with the values of
+// the checkout session
+func sessionMatches(sess *checkout.Session, p *payment.Payment) bool {
+	return sess.ProjectID == p.ProjectID() &&
+		sess.Amount == p.Amount &&
+		sess.Subunits == p.Subunits &&
+		sess.Currency == p.Currency &&
+		p.Config.Country.Valid && sess.Country == p.Config.Country.String
+}
+
+func (s *Service) sessionDBErr(method string, err error) error {
+	if sqldialect.IsDeadlock(err) {
+		return wrapError(ErrDBLockTimeout, method, err)
+	}
+	s.log.Error("error saving checkout session", log15.Ctx{
+		"method": method,
+		"err":    err,
+	})
+	return wrapError(ErrDB, method, err)
+}
@@ -0,0 +1,83 @@
+package payment
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/checkout"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConvertSession(t *testing.T) {
+	Convey("Given a payment service", t, func() {
+		s := &Service{}
+		Convey("Given a checkout session", func() {
+			sess := &checkout.Session{
+				ProjectID: 1,
+				Token:     "token",
+				Expires:   time.Now().Add(time.Minute),
+				Amount:    1990,
+				Subunits:  2,
+				Currency:  "EUR",
+				Country:   "DE",
+			}
+			Convey("Given a payment initialized with the session values", func() {
+				p := &payment.Payment{
+					Amount:   1990,
+					Subunits: 2,
+					Currency: "EUR",
+				}
+				So(p.SetProject(&project.Project{ID: 1}), ShouldBeNil)
+				p.Config.SetCountry("DE")
+
+				Convey("It should match the session", func() {
+					So(sessionMatches(sess, p), ShouldBeTrue)
+				})
+				Convey("When the amount differs", func() {
+					p.Amount = 2000
+
+					Convey("It should not match the session", func() {
+						So(sessionMatches(sess, p), ShouldBeFalse)
+					})
+					Convey("Converting should fail", func() {
+						So(s.ConvertSession(nil, sess, p), ShouldEqual, ErrSessionMismatch)
+					})
+				})
+				Convey("When the country differs", func() {
+					p.Config.SetCountry("AT")
+
+					Convey("It should not match the session", func() {
+						So(sessionMatches(sess, p), ShouldBeFalse)
+					})
+				})
+				Convey("When the session belongs to another project", func() {
+					sess.ProjectID = 2
+
+					Convey("It should not match the session", func() {
+						So(sessionMatches(sess, p), ShouldBeFalse)
+					})
+				})
+				Convey("When the session expired", func() {
+					sess.Expires = time.Now().Add(-time.Second)
+
+					Convey("Converting should fail", func() {
+						So(s.ConvertSession(nil, sess, p), ShouldEqual, ErrSessionExpired)
+					})
+				})
+				Convey("When the session was converted", func() {
+					sess.PaymentID = sql.NullInt64{Int64: 2, Valid: true}
+
+					Convey("Converting should fail", func() {
+						So(s.ConvertSession(nil, sess, p), ShouldEqual, ErrSessionConverted)
+					})
+					Convey("Updating should fail", func() {
+						So(s.UpdateSession(nil, sess), ShouldEqual, ErrSessionConverted)
+					})
+				})
+			})
+		})
+	})
+}
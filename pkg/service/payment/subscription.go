@@ -0,0 +1,469 @@
+package payment
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/paymentd/subscription"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// subscriptionBatchSize is the maximum number of subscriptions charged per
+	// run of the charge job
+	subscriptionBatchSize = 500
+	// timeout of the recurring charge with the provider
+	recurringChargeTimeout = time.Minute
+)
+
+// Results of the recurring charge of a subscription cycle, as notified in the
+// subscription.cycle events
+const (
+	// ChargeSubmitted cycles were submitted to the provider. The project will
+	// be notified of the outcome like of any other payment transaction.
+	ChargeSubmitted = "submitted"
+	// ChargeFailed cycles could not be submitted to the provider
+	ChargeFailed = "failed"
+	// ChargeUnsupported cycles cannot be charged with the stored payment
+	// instrument of the initial payment, e.g. because the driver cannot charge
+	// recurring payments or is not attached on this instance.
+	ChargeUnsupported = "unsupported"
+)
+
+// Mails to the customers of subscriptions with failed charges
+const (
+	dunningDateFormat = "January 2, 2006"
+
+	dunningFailedSubject = "Payment of your subscription %s failed"
+	dunningFailedBody    = `Hello,
+
+we could not charge %s for your subscription %s with your payment method.
+We will try again on %s. Please make sure that your payment method can be
+charged.
+`
+	dunningReminderSubject = "Reminder: payment of your subscription %s failed"
+	dunningReminderBody    = `Hello,
+
+we still could not charge %s for your subscription %s with your payment
+method. We will try again on %s. Please make sure that your payment method can
+be charged.
+`
+	dunningFinalSubject = "Final notice: payment of your subscription %s failed"
+	dunningFinalBody    = `Hello,
+
+we still could not charge %s for your subscription %s with your payment
+method. We will try a last time on %s. If this charge fails, your
+subscription will be suspended.
+`
+	dunningSuspendedSubject = "Your subscription %s was suspended"
+	dunningSuspendedBody    = `Hello,
+
+we could not charge %s for your subscription %s with your payment method.
+Your subscription was suspended on %s. Please contact us to resume it.
+`
+)
+
+// RecurringCharger is implemented by provider drivers which can charge
+// recurring payments with the payment instrument of an initial payment, e.g. a
+// stored card or a billing agreement with the provider
+//
+// The drivers are looked up in the driver registry of the service context.
+type RecurringCharger interface {
+	// ChargeRecurring charges the recurring payment without the interaction of
+	// the customer, with the payment instrument used for the initial payment
+	//
+	// The driver records the outcome as payment transactions of the recurring
+	// payment. Requests to the provider should be abandoned once the context is
+	// done.
+	ChargeRecurring(ctx context.Context, p, initial *payment.Payment) error
+}
+
+// CreateSubscription saves a new subscription for a paid initial payment
+//
+// The initial payment has to be paid in the currency of the plan. recurring
+// reports whether the driver of the named provider can charge recurring
+// payments; subscriptions of payment methods of other providers are refused
+// with an ErrSubscriptionMethod. If the start of the subscription is not set,
+// the first cycle will be charged one plan interval after now.
+func (s *Service) CreateSubscription(tx *sql.Tx, sub *subscription.Subscription, recurring func(provider string) bool) error {
+	log := s.log.New(log15.Ctx{
+		"method":           "CreateSubscription",
+		"projectID":        sub.ProjectID,
+		"initialPaymentID": sub.InitialPaymentID,
+	})
+	initial, err := payment.PaymentByIDTx(s.ctx, tx, payment.PaymentID{
+		ProjectID: sub.ProjectID,
+		PaymentID: sub.InitialPaymentID,
+	})
+	if err != nil {
+		if err == payment.ErrPaymentNotFound {
+			return ErrSubscriptionPayment
+		}
+		log.Error("error retrieving initial payment", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "CreateSubscription", err)
+	}
+	if initial.Status != payment.PaymentStatusPaid || initial.Currency != sub.Plan.Currency || !initial.Config.PaymentMethodID.Valid {
+		return ErrSubscriptionPayment
+	}
+	meth, err := s.PaymentMethod(initial.ProjectID(), initial.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "CreateSubscription", err)
+	}
+	if !recurring(meth.Provider.Name) {
+		return ErrSubscriptionMethod
+	}
+	if sub.Start.IsZero() {
+		sub.Start = sub.Plan.Interval.Time(time.Now(), 1)
+	}
+	err = subscription.InsertSubscriptionTx(s.ctx, tx, sub)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "CreateSubscription", err)
+		}
+		log.Error("error saving subscription", log15.Ctx{"err": err})
+		return wrapError(ErrDB, "CreateSubscription", err)
+	}
+	log.Info("subscription created", log15.Ctx{
+		"subscriptionID": sub.ID,
+		"plan":           sub.Plan.Name,
+	})
+	return nil
+}
+
+// SetSubscriptionStatus pauses, resumes or cancels a subscription
+//
+// Resumed subscriptions are charged at their next scheduled charge. Cycles
+// scheduled while the subscription was paused or suspended will not be
+// charged. The failed charges of resumed subscriptions are reset.
+func (s *Service) SetSubscriptionStatus(tx *sql.Tx, sub *subscription.Subscription, status, createdBy, comment string) error {
+	if !sub.CanChangeStatus(status) {
+		return ErrSubscriptionStatus
+	}
+	st := sub.NewStatus(status, createdBy)
+	st.Comment.String, st.Comment.Valid = comment, comment != ""
+	if status == subscription.StatusActive {
+		st.Failures = 0
+		if st.NextCharge.Before(st.Timestamp) {
+			st.NextCharge = sub.NextChargeAfter(st.Timestamp)
+		}
+	}
+	err := subscription.InsertStatusTx(s.ctx, tx, sub, st)
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			return wrapError(ErrDBLockTimeout, "SetSubscriptionStatus", err)
+		}
+		s.log.Error("error saving subscription status", log15.Ctx{
+			"method":         "SetSubscriptionStatus",
+			"subscriptionID": sub.ID,
+			"err":            err,
+		})
+		return wrapError(ErrDB, "SetSubscriptionStatus", err)
+	}
+	return nil
+}
+
+// chargeDueSubscriptions charges the cycles of the subscriptions of all shards
+// which are due
+//
+// A subscription which cannot be charged will not keep the other subscriptions
+// from being charged. It will be retried with the next run.
+func (s *Service) chargeDueSubscriptions(done <-chan struct{}) error {
+	log := s.log.New(log15.Ctx{"method": "chargeDueSubscriptions"})
+	var charged int
+	for _, sh := range s.ctx.PaymentShardDBs() {
+		ids, err := subscription.DueDB(s.ctx, sh.DB, time.Now(), subscriptionBatchSize)
+		if err != nil {
+			return fmt.Errorf("shard %s: %v", sh.Shard, err)
+		}
+		for _, id := range ids {
+			select {
+			case <-done:
+				log.Info("charging cancelled", log15.Ctx{"charged": charged})
+				return nil
+			default:
+			}
+			err = s.chargeSubscription(sh.DB, id)
+			if err != nil {
+				log.Error("error charging subscription", log15.Ctx{
+					"shard":          sh.Shard,
+					"subscriptionID": id,
+					"err":            err,
+				})
+				continue
+			}
+			charged++
+		}
+	}
+	if charged > 0 {
+		log.Info("charged subscriptions", log15.Ctx{"charged": charged})
+	}
+	return nil
+}
+
+// chargeSubscription creates the payment of the next cycle of a due
+// subscription and charges it with the provider
+//
+// The payment of the last cycle of a past due subscription is charged again
+// instead. The subscription is read from the given shard.
+func (s *Service) chargeSubscription(db *sql.DB, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	sub, err := subscription.SubscriptionByIDTx(s.ctx, tx, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// paused, cancelled or charged in the meantime
+	if !sub.Due(time.Now()) {
+		return tx.Rollback()
+	}
+	initial, err := payment.PaymentByIDTx(s.ctx, tx, payment.PaymentID{
+		ProjectID: sub.ProjectID,
+		PaymentID: sub.InitialPaymentID,
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = payment.PaymentMetadataTx(s.ctx, tx, initial)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if sub.PastDue() {
+		return s.retrySubscription(tx, sub, initial)
+	}
+	st := sub.NewStatus(subscription.StatusActive, JobSubscriptionCharge)
+	st.Cycle++
+	p, err := s.newCyclePayment(sub, initial, st.Cycle)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = s.CreatePayment(tx, p)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	st.PaymentID.Int64, st.PaymentID.Valid = p.ID(), true
+	st.NextCharge = sub.NextChargeAfter(st.Timestamp)
+	err = subscription.InsertStatusTx(s.ctx, tx, sub, st)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	cycle := st.Cycle
+	charge := s.chargeRecurring(p, initial)
+	s.NotifyEvent(sub.ProjectID, EventSubscriptionCycle, map[string]string{
+		"SubscriptionId": strconv.FormatInt(sub.ID, 10),
+		"Cycle":          strconv.FormatInt(cycle, 10),
+		"PaymentId":      s.EncodedPaymentID(p.PaymentID()).String(),
+		"Amount":         p.Decimal().String(),
+		"Currency":       p.Currency,
+		"NextCharge":     st.NextCharge.UTC().Format(time.RFC3339),
+		"Charge":         charge,
+	})
+	if charge == ChargeSubmitted {
+		return nil
+	}
+
+	// the cycle was committed before the charge, so that the driver can see
+	// its payment
+	tx, err = db.Begin()
+	if err != nil {
+		return err
+	}
+	sub, err = subscription.SubscriptionByIDTx(s.ctx, tx, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// paused or cancelled in the meantime
+	if !sub.Active() || sub.Status.Cycle != cycle {
+		return tx.Rollback()
+	}
+	return s.dunSubscription(tx, sub, p, charge)
+}
+
+// retrySubscription charges the payment of the last cycle of a past due
+// subscription again
+//
+// The subscription stays locked by the transaction until the outcome of the
+// retry is saved, so that the retry will not be charged concurrently. If the
+// payment was paid in the meantime, the subscription recovers without a charge.
+func (s *Service) retrySubscription(tx *sql.Tx, sub *subscription.Subscription, initial *payment.Payment) error {
+	p, err := payment.PaymentByIDTx(s.ctx, tx, payment.PaymentID{
+		ProjectID: sub.ProjectID,
+		PaymentID: sub.Status.PaymentID.Int64,
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	charge := ChargeSubmitted
+	if p.Status != payment.PaymentStatusPaid {
+		charge = s.chargeRecurring(p, initial)
+	}
+	return s.dunSubscription(tx, sub, p, charge)
+}
+
+// dunSubscription saves the outcome of the charge of the last cycle of a
+// subscription in the given transaction and commits it
+//
+// After a failed charge, the subscription is past due until the retry
+// according to the retry schedule, or suspended after the last retry. A past
+// due subscription recovers once its charge was submitted. The project is
+// notified of every dunning step and the customer of the failed charges.
+func (s *Service) dunSubscription(tx *sql.Tx, sub *subscription.Subscription, p *payment.Payment, charge string) error {
+	var st *subscription.Status
+	var step string
+	switch {
+	case charge != ChargeSubmitted:
+		st, step = sub.ChargeFailed(s.retrySchedule, JobSubscriptionCharge)
+	case sub.PastDue():
+		st, step = sub.ChargeRecovered(JobSubscriptionCharge), subscription.DunningRecovered
+	default:
+		return tx.Rollback()
+	}
+	err := subscription.InsertStatusTx(s.ctx, tx, sub, st)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	s.log.Info("subscription dunning", log15.Ctx{
+		"method":         "dunSubscription",
+		"subscriptionID": sub.ID,
+		"step":           step,
+		"failures":       st.Failures,
+	})
+	data := map[string]string{
+		"SubscriptionId": strconv.FormatInt(sub.ID, 10),
+		"Cycle":          strconv.FormatInt(st.Cycle, 10),
+		"PaymentId":      s.EncodedPaymentID(p.PaymentID()).String(),
+		"Step":           step,
+		"Status":         st.Status,
+		"Failures":       strconv.Itoa(st.Failures),
+	}
+	if step == subscription.DunningRetry {
+		data["NextRetry"] = st.NextCharge.UTC().Format(time.RFC3339)
+	}
+	s.NotifyEvent(sub.ProjectID, EventSubscriptionDunning, data)
+	s.mailDunning(sub, step)
+	return nil
+}
+
+// mailDunning notifies the customer of a subscription of a failed charge
+//
+// The mails escalate from the first failure over reminders to the final
+// notice before the last retry and the suspension.
+func (s *Service) mailDunning(sub *subscription.Subscription, step string) {
+	if !sub.Email.Valid {
+		return
+	}
+	var subject, body string
+	date := sub.Status.NextCharge
+	switch {
+	case step == subscription.DunningSuspended:
+		subject, body = dunningSuspendedSubject, dunningSuspendedBody
+		date = sub.Status.Timestamp
+	case step != subscription.DunningRetry:
+		return
+	case sub.Status.Failures == 1:
+		subject, body = dunningFailedSubject, dunningFailedBody
+	case sub.Status.Failures == len(s.retrySchedule):
+		subject, body = dunningFinalSubject, dunningFinalBody
+	default:
+		subject, body = dunningReminderSubject, dunningReminderBody
+	}
+	amount := sub.Plan.Decimal().String() + " " + sub.Plan.Currency
+	s.ctx.Mailer().Send(&service.Mail{
+		To:      []string{sub.Email.String},
+		Subject: fmt.Sprintf(subject, sub.Plan.Name),
+		Body:    fmt.Sprintf(body, amount, sub.Plan.Name, date.UTC().Format(dunningDateFormat)),
+	})
+}
+
+// newCyclePayment returns the payment of a subscription cycle
+//
+// The payment is related to the initial payment. It uses the payment method,
+// the callback and the metadata of the initial payment.
+func (s *Service) newCyclePayment(sub *subscription.Subscription, initial *payment.Payment, cycle int64) (*payment.Payment, error) {
+	p := &payment.Payment{
+		Created:  time.Now(),
+		Ident:    sub.CycleIdent(cycle),
+		Amount:   sub.Plan.Amount,
+		Subunits: sub.Plan.Subunits,
+		Currency: sub.Plan.Currency,
+		Metadata: initial.Metadata,
+	}
+	err := p.SetProject(&project.Project{ID: sub.ProjectID})
+	if err != nil {
+		return nil, err
+	}
+	p.Config = initial.Config
+	// the customer does not return from a recurring charge
+	p.Config.ReturnURL = sql.NullString{}
+	p.Config.Expires = nil
+	err = p.SetParent(initial.PaymentID(), payment.RelationRecurring)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// chargeRecurring charges the payment of a subscription cycle with the driver
+// of the provider of its payment method
+//
+// It returns the result of the charge. Errors are logged, since the cycle was
+// created regardless.
+func (s *Service) chargeRecurring(p, initial *payment.Payment) string {
+	log := s.log.New(log15.Ctx{
+		"method":    "chargeRecurring",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	meth, err := s.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return ChargeFailed
+	}
+	dr, ok := s.ctx.Drivers().Driver(meth.Provider.Name)
+	if !ok {
+		log.Warn("provider driver not attached", log15.Ctx{"provider": meth.Provider.Name})
+		return ChargeUnsupported
+	}
+	charger, ok := dr.(RecurringCharger)
+	if !ok {
+		log.Warn("provider driver cannot charge recurring payments", log15.Ctx{"provider": meth.Provider.Name})
+		return ChargeUnsupported
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, recurringChargeTimeout)
+	defer cancel()
+	err = charger.ChargeRecurring(ctx, p, initial)
+	if err != nil {
+		log.Error("error charging recurring payment", log15.Ctx{
+			"provider": meth.Provider.Name,
+			"err":      err,
+		})
+		return ChargeFailed
+	}
+	return ChargeSubmitted
+}
@@ -0,0 +1,76 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/paymentd/subscription"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSubscriptionCycle(t *testing.T) {
+	Convey("Given a payment service", t, func() {
+		s := &Service{}
+
+		Convey("Given a subscription with a paid initial payment", func() {
+			initial := &payment.Payment{
+				Amount:   1999,
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusPaid,
+				Metadata: map[string]string{"customer": "42"},
+			}
+			So(initial.SetProject(&project.Project{ID: 1}), ShouldBeNil)
+			initial.Config.SetPaymentMethodID(3)
+			initial.Config.SetCountry("DE")
+			initial.Config.SetLocale("de_DE")
+			initial.Config.SetReturnURL("https://shop.example.com/return")
+			initial.Config.SetExpires(time.Now().Add(time.Hour))
+
+			sub := &subscription.Subscription{
+				ID:        5,
+				ProjectID: 1,
+				Plan: subscription.Plan{
+					Name:     "basic",
+					Amount:   999,
+					Subunits: 2,
+					Currency: "EUR",
+					Interval: subscription.Interval{Unit: subscription.IntervalMonth, Count: 1},
+				},
+			}
+			sub.Status.Status = subscription.StatusActive
+
+			Convey("When creating the payment of a cycle", func() {
+				p, err := s.newCyclePayment(sub, initial, 2)
+				So(err, ShouldBeNil)
+
+				Convey("It should charge the plan amount", func() {
+					So(p.ProjectID(), ShouldEqual, 1)
+					So(p.Ident, ShouldEqual, "subscription-5-2")
+					So(p.Amount, ShouldEqual, 999)
+					So(p.Currency, ShouldEqual, "EUR")
+				})
+				Convey("It should be a recurring payment of the initial payment", func() {
+					So(p.Parent, ShouldNotBeNil)
+					So(p.Parent.Type, ShouldEqual, payment.RelationRecurring)
+					So(p.Config.PaymentMethodID.Int64, ShouldEqual, 3)
+					So(p.Metadata["customer"], ShouldEqual, "42")
+				})
+				Convey("It should not have a return URL or expire", func() {
+					So(p.Config.ReturnURL.Valid, ShouldBeFalse)
+					So(p.Config.Expires, ShouldBeNil)
+				})
+			})
+
+			Convey("When resuming the active subscription", func() {
+				err := s.SetSubscriptionStatus(nil, sub, subscription.StatusActive, "operator", "")
+				Convey("It should be rejected", func() {
+					So(err, ShouldEqual, ErrSubscriptionStatus)
+					So(sub.Status.Status, ShouldEqual, subscription.StatusActive)
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,142 @@
+package payment
+
+import (
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/paymentd/subscription"
+	"github.com/fritzpay/paymentd/pkg/service"
+)
+
+const (
+	// EventDataTest is the data key which marks synthetic test notifications
+	EventDataTest = "Test"
+
+	testEventTimeout      = 10 * time.Second
+	testEventMaxBodyBytes = 4096
+)
+
+// EventDelivery is the result of a test notification
+type EventDelivery struct {
+	URL       string
+	EventType string
+	// Delivered is true if the callback responded with a 2xx status code
+	Delivered bool
+	// StatusCode is the HTTP status code of the response. It is 0 if no
+	// response was received
+	StatusCode int
+	// Body holds the beginning of the response body
+	Body string
+	// Error is set if the request failed
+	Error    string
+	Duration time.Duration
+}
+
+// testEventData returns the synthetic data of a test notification of the
+// given event type
+//
+// The data resembles the data of real notifications, but does not refer to
+// existing entities.
+func testEventData(eventType string) map[string]string {
+	data := map[string]string{EventDataTest: "true"}
+	switch eventType {
+	case EventPaymentTransaction:
+		data["PaymentId"] = "0"
+		data["Status"] = string(payment.PaymentStatusPaid)
+	case EventPaymentMethodStatus, EventPaymentMethodConfig:
+		data["MethodId"] = "0"
+		data["MethodKey"] = "test"
+		data["Provider"] = "fritzpay"
+		if eventType == EventPaymentMethodStatus {
+			data["Status"] = "active"
+		} else {
+			data["Changed"] = "test"
+		}
+	case EventFundsMatched:
+		data["FundsId"] = "0"
+		data["PaymentId"] = "0"
+		data["Amount"] = "1.00"
+		data["Currency"] = "EUR"
+		data["Reference"] = "test"
+	case EventPaymentChargeback:
+		data["PaymentId"] = "0"
+		data["DisputeId"] = "test"
+		data["Status"] = payment.ChargebackReceived
+		data["Amount"] = "1.00"
+		data["Currency"] = "EUR"
+		data["Reason"] = "test"
+	case EventPaymentIntentRejected:
+		data["PaymentId"] = "0"
+		data["Intent"] = payment.PaymentStatusCancelled
+		data["Status"] = payment.PaymentStatusPaid
+		data["Reason"] = ErrIntentNotAllowed.Error()
+	case EventPaymentVoid:
+		data["PaymentId"] = "0"
+		data["Amount"] = "1.00"
+		data["Currency"] = "EUR"
+	case EventSubscriptionCycle:
+		data["SubscriptionId"] = "0"
+		data["Cycle"] = "1"
+		data["PaymentId"] = "0"
+		data["Amount"] = "1.00"
+		data["Currency"] = "EUR"
+		data["NextCharge"] = time.Unix(0, 0).UTC().Format(time.RFC3339)
+		data["Charge"] = ChargeSubmitted
+	case EventSubscriptionDunning:
+		data["SubscriptionId"] = "0"
+		data["Cycle"] = "1"
+		data["PaymentId"] = "0"
+		data["Step"] = subscription.DunningRetry
+		data["Status"] = subscription.StatusPastDue
+		data["Failures"] = "1"
+		data["NextRetry"] = time.Unix(0, 0).UTC().Format(time.RFC3339)
+	}
+	return data
+}
+
+// TestEvent sends a synthetic signed event notification of the given type to
+// the callback of the project and waits for the response
+//
+// The notification is sent regardless of the event types the project is
+// subscribed to. Its data contains the key EventDataTest. Failed deliveries are
+// reported in the returned EventDelivery. An error will be returned if the
+// notification cannot be sent at all, e.g. if the project has no callback.
+func (s *Service) TestEvent(projectID int64, eventType string) (*EventDelivery, error) {
+	pr, err := project.ProjectByIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), projectID)
+	if err != nil {
+		return nil, err
+	}
+	if !CanCallback(pr.Config) {
+		return nil, ErrPaymentCallbackConfig
+	}
+	req, cl, err := s.eventRequest(pr.Config, projectID, eventType, testEventData(eventType))
+	if err != nil {
+		return nil, err
+	}
+	d := &EventDelivery{
+		URL:       req.URL.String(),
+		EventType: eventType,
+	}
+	testCl := *cl
+	testCl.Timeout = testEventTimeout
+	start := time.Now()
+	res, err := testCl.Do(req)
+	if err != nil {
+		d.Duration = time.Since(start)
+		d.Error = err.Error()
+		return d, nil
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, testEventMaxBodyBytes))
+	d.Duration = time.Since(start)
+	d.StatusCode = res.StatusCode
+	d.Delivered = res.StatusCode >= 200 && res.StatusCode < 300
+	d.Body = string(body)
+	if err != nil {
+		d.Error = err.Error()
+	}
+	return d, nil
+}
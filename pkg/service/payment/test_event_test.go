@@ -0,0 +1,38 @@
+package payment
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTestEventData(t *testing.T) {
+	Convey("Given the known event types", t, func() {
+		eventTypes := []string{
+			EventPaymentTransaction,
+			EventPaymentMethodStatus,
+			EventPaymentMethodConfig,
+			EventFundsMatched,
+			EventPaymentVoid,
+			EventSubscriptionCycle,
+			EventSubscriptionDunning,
+		}
+
+		Convey("The test event data should be marked as test data", func() {
+			for _, eventType := range eventTypes {
+				So(ValidEventType(eventType), ShouldBeTrue)
+				data := testEventData(eventType)
+				So(data[EventDataTest], ShouldEqual, "true")
+				So(len(data), ShouldBeGreaterThan, 1)
+			}
+		})
+
+		Convey("The funds data should resemble a real notification", func() {
+			data := testEventData(EventFundsMatched)
+			So(data["FundsId"], ShouldNotBeEmpty)
+			So(data["PaymentId"], ShouldNotBeEmpty)
+			So(data["Amount"], ShouldNotBeEmpty)
+			So(data["Currency"], ShouldNotBeEmpty)
+		})
+	})
+}
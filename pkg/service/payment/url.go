@@ -0,0 +1,112 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/url"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/cache"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// verifiedDomainCacheTTL is the time for which verified domains and unknown
+// domain names will be cached
+const verifiedDomainCacheTTL = time.Minute
+
+func verifiedDomainCacheKey(name string) string {
+	return "verifieddomain:" + name
+}
+
+// verifiedDomain is the cached result of a verified domain lookup
+type verifiedDomain struct {
+	// Domain is nil if no verified domain with the name exists
+	Domain *project.Domain
+}
+
+// VerifiedDomain returns the verified checkout domain with the given
+// (normalized) name
+//
+// It returns project.ErrDomainNotFound if no such domain exists. Results,
+// including unknown names, are cached, so that lookups of client-provided
+// names, e.g. on TLS handshakes, do not hit the database each time.
+func (s *Service) VerifiedDomain(name string) (*project.Domain, error) {
+	log := s.log.New(log15.Ctx{
+		"method": "VerifiedDomain",
+		"domain": name,
+	})
+	key := verifiedDomainCacheKey(name)
+	if b, err := s.ctx.Cache().Get(key); err == nil {
+		vd := &verifiedDomain{}
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(vd)
+		if err == nil {
+			if vd.Domain == nil {
+				return nil, project.ErrDomainNotFound
+			}
+			return vd.Domain, nil
+		}
+		log.Warn("error decoding cached domain", log15.Ctx{"err": err})
+	} else if err != cache.ErrNotFound {
+		log.Error("error retrieving cached domain", log15.Ctx{"err": err})
+	}
+	vd := &verifiedDomain{}
+	dom, err := project.VerifiedDomainByNameDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), name)
+	if err != nil {
+		if err != project.ErrDomainNotFound {
+			log.Error("error retrieving domain", log15.Ctx{"err": err})
+			return nil, wrapError(ErrDB, "VerifiedDomain", err)
+		}
+	} else {
+		vd.Domain = dom
+	}
+	buf := &bytes.Buffer{}
+	err = gob.NewEncoder(buf).Encode(vd)
+	if err == nil {
+		err = s.ctx.Cache().Set(key, buf.Bytes(), verifiedDomainCacheTTL)
+	}
+	if err != nil {
+		log.Error("error caching domain", log15.Ctx{"err": err})
+	}
+	if vd.Domain == nil {
+		return nil, project.ErrDomainNotFound
+	}
+	return vd.Domain, nil
+}
+
+// ForgetVerifiedDomain removes the cached lookup of the domain with the given
+// name
+//
+// It should be called after a domain was registered or verified.
+func (s *Service) ForgetVerifiedDomain(name string) {
+	err := s.ctx.Cache().Delete(verifiedDomainCacheKey(name))
+	if err != nil && err != cache.ErrNotFound {
+		s.log.Error("error removing cached domain", log15.Ctx{
+			"domain": name,
+			"err":    err,
+		})
+	}
+}
+
+// CheckoutURL returns the URL under which the hosted pages of the given project
+// should be served
+//
+// If the project has a verified checkout domain, the returned URL will point to
+// the checkout domain. Otherwise a copy of the given base URL will be returned.
+func (s *Service) CheckoutURL(projectID int64, base *url.URL) (*url.URL, error) {
+	log := s.log.New(log15.Ctx{
+		"method":    "CheckoutURL",
+		"projectID": projectID,
+	})
+	dom, err := project.VerifiedDomainByProjectIDDB(s.ctx, s.ctx.PrincipalDB(service.ReadOnly), projectID)
+	if err != nil {
+		if err == project.ErrDomainNotFound {
+			u := *base
+			return &u, nil
+		}
+		log.Error("error retrieving checkout domain", log15.Ctx{"err": err})
+		return nil, wrapError(ErrDB, "CheckoutURL", err)
+	}
+	return dom.URL(base), nil
+}
@@ -0,0 +1,66 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestVerificationIntents(t *testing.T) {
+	Convey("Given a payment service", t, func() {
+		s := &Service{}
+
+		Convey("Given an open verification payment", func() {
+			p := &payment.Payment{
+				Amount:   0,
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusOpen,
+			}
+			So(p.IsVerification(), ShouldBeTrue)
+
+			Convey("It should not be paid", func() {
+				_, _, err := s.IntentPaid(context.Background(), p, time.Second)
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+			})
+			Convey("It should not be authorized", func() {
+				_, _, err := s.IntentAuthorized(context.Background(), p, time.Second)
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+			})
+			Convey("It should not receive funds", func() {
+				_, _, err := s.IntentReceived(context.Background(), p, decimalString("1.00"), time.Second)
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+			})
+		})
+
+		Convey("Given an open payment of 10.00 EUR", func() {
+			p := &payment.Payment{
+				Amount:   1000,
+				Subunits: 2,
+				Currency: "EUR",
+				Status:   payment.PaymentStatusOpen,
+			}
+			So(p.IsVerification(), ShouldBeFalse)
+
+			Convey("It should not be verified", func() {
+				_, _, err := s.IntentVerified(context.Background(), p, time.Second)
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+			})
+		})
+
+		Convey("Given a cancelled verification payment", func() {
+			p := &payment.Payment{
+				Currency: "EUR",
+				Status:   payment.PaymentStatusCancelled,
+			}
+
+			Convey("It should not be verified", func() {
+				_, _, err := s.IntentVerified(context.Background(), p, time.Second)
+				So(err, ShouldEqual, ErrIntentNotAllowed)
+			})
+		})
+	})
+}
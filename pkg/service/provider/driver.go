@@ -1,12 +1,16 @@
 package provider
 
 import (
+	"database/sql"
 	"net/http"
+	"time"
 
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/decimal"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
 	"github.com/fritzpay/paymentd/pkg/service"
-	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
 )
 
 // Provider Driver Registry
@@ -18,8 +22,149 @@ const (
 	driverStripe     = "stripe"
 )
 
+// Driver is a provider driver
+//
+// The attached drivers are registered with the driver registry of the service
+// context.
 type Driver interface {
-	Attach(ctx *service.Context, mux *mux.Router) error
+	service.Driver
+
+	// InitPayment initializes the payment with the provider
+	//
+	// The request is the (web) request which initiated the payment. It can be
+	// used to resolve the URLs which will be presented to the client.
+	InitPayment(p *payment.Payment, method *payment_method.Method, r *http.Request) (http.Handler, error)
+}
+
+// Capturer is implemented by drivers which can capture authorized payments
+type Capturer interface {
+	// Capture captures the amount of the authorized payment with the provider
+	//
+	// The payment transaction will be set by the driver. Payments can be
+	// captured repeatedly, in total up to the authorized amount. A final
+	// capture releases the remainder of the authorization. Requests to the
+	// provider should be abandoned once the context is done.
+	Capture(ctx context.Context, p *payment.Payment, amount *decimal.Decimal, final bool) error
+}
+
+// Incrementer is implemented by drivers which can increment the authorization
+// of authorized payments
+type Incrementer interface {
+	// IncrementAuthorization increments the authorization of the payment by the
+	// amount with the provider
+	//
+	// The new authorization will be recorded by the driver. Requests to the
+	// provider should be abandoned once the context is done.
+	IncrementAuthorization(ctx context.Context, p *payment.Payment, amount *decimal.Decimal) error
+}
+
+// Voider is implemented by drivers which can void the authorization of
+// authorized payments
+type Voider interface {
+	// Void releases the authorization of the payment with the provider without
+	// capturing
+	//
+	// The payment transaction will be set by the driver. Requests to the
+	// provider should be abandoned once the context is done.
+	Void(ctx context.Context, p *payment.Payment) error
+}
+
+// Verifier is implemented by drivers which can process verification payments
+//
+// Verification payments have a zero amount. They only verify the payment
+// instrument of the customer, e.g. with a zero-amount card authorization or a
+// direct debit mandate setup.
+type Verifier interface {
+	// InitVerification initializes the verification payment with the provider
+	//
+	// It is used instead of InitPayment for verification payments. The driver
+	// will set the verified payment transaction once the provider verified the
+	// payment instrument.
+	InitVerification(p *payment.Payment, method *payment_method.Method, r *http.Request) (http.Handler, error)
+}
+
+// DescriptorChecker is implemented by drivers which forward statement
+// descriptors to the provider
+type DescriptorChecker interface {
+	// CheckDescriptor returns an error if the statement descriptor violates the
+	// length or charset rules of the provider
+	CheckDescriptor(descriptor string) error
+}
+
+// ExemptionRequester is implemented by card drivers which can request
+// exemptions from strong customer authentication
+//
+// Such drivers request the exemption returned by the SCAExemption method of the
+// payment service and record the authentication outcome on the payment
+// transaction.
+type ExemptionRequester interface {
+	// SupportsExemption returns true if the provider accepts requests for the
+	// given exemption type, e.g. sca.ExemptionTRA
+	SupportsExemption(exemption string) bool
+}
+
+// BillingRequirer is implemented by drivers of providers which require billing
+// data of the customer, e.g. the CPF of brazilian customers
+//
+// The required fields will be collected on the hosted payment pages before the
+// payment is initialized with the driver, in addition to the checkout fields of
+// the project.
+type BillingRequirer interface {
+	// RequiredBillingFields returns the names of the billing fields required
+	// for payments in the given country
+	RequiredBillingFields(country string) []string
+}
+
+// StatusReporter is implemented by drivers which record the status of the
+// payments reported by the provider
+//
+// The reported status is used to detect payments whose status differs from the
+// provider, e.g. payments which remained open although the provider approved
+// them.
+type StatusReporter interface {
+	// ReportedStatus returns the status of the payment as last reported by the
+	// provider. It returns false if the provider did not report a final status
+	ReportedStatus(db *sql.DB, p *payment.Payment) (payment.PaymentTransactionStatus, bool, error)
+}
+
+// AuthorizationExpirer is implemented by drivers of providers whose
+// authorizations expire
+type AuthorizationExpirer interface {
+	// AuthorizationValidUntil returns the time until which the authorization of
+	// the payment can be captured. It returns false if the provider did not
+	// report an expiry
+	AuthorizationValidUntil(db *sql.DB, p *payment.Payment) (time.Time, bool, error)
+}
+
+// Driver capabilities
+const (
+	// CapabilityCapture is the capability of capturing authorized payments
+	CapabilityCapture = "capture"
+	// CapabilityIncrementalAuthorization is the capability of incrementing the
+	// authorization of authorized payments
+	CapabilityIncrementalAuthorization = "incremental_authorization"
+	// CapabilityVoid is the capability of voiding the authorization of
+	// authorized payments
+	CapabilityVoid = "void"
+	// CapabilityVerification is the capability of processing zero-amount
+	// verification payments
+	CapabilityVerification = "verification"
+	// CapabilityStatementDescriptor is the capability of forwarding statement
+	// descriptors
+	CapabilityStatementDescriptor = "statement_descriptor"
+	// CapabilitySCAExemption is the capability of requesting exemptions from
+	// strong customer authentication
+	CapabilitySCAExemption = "sca_exemption"
+	// CapabilityRecurring is the capability of charging recurring payments of
+	// subscriptions with the payment instrument of the initial payment
+	CapabilityRecurring = "recurring"
+)
 
-	InitPayment(p *payment.Payment, method *payment_method.Method) (http.Handler, error)
+// ConfigChecker is implemented by drivers which can validate their
+// configuration without being attached
+type ConfigChecker interface {
+	// CheckConfig validates the paymentd config used by the driver
+	CheckConfig(cfg *config.Config) error
+	// CheckMethodConfig validates the provider config of the payment method
+	CheckMethodConfig(db *sql.DB, method *payment_method.Method) error
 }
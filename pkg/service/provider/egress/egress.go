@@ -0,0 +1,57 @@
+package egress
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+)
+
+const (
+	dialTimeout      = 30 * time.Second
+	dialKeepAlive    = 30 * time.Second
+	handshakeTimeout = 10 * time.Second
+)
+
+// Transport creates the HTTP transport for calls to the given provider
+//
+// Without an egress config for the provider, proxies will be determined by the
+// environment, like with the http.DefaultTransport.
+func Transport(cfg *config.Config, provider string) (*http.Transport, error) {
+	egress := cfg.Provider.Egress[provider]
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: dialKeepAlive,
+	}
+	if egress.LocalAddress != "" {
+		ip := net.ParseIP(egress.LocalAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local address %q", egress.LocalAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	tr := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		Dial:                dialer.Dial,
+		TLSHandshakeTimeout: handshakeTimeout,
+	}
+	if egress.Proxy != "" {
+		proxy, err := url.Parse(egress.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %v", err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", proxy.Scheme)
+		}
+		if proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %s", egress.Proxy)
+		}
+		tr.Proxy = http.ProxyURL(proxy)
+	}
+	return tr, nil
+}
@@ -0,0 +1,50 @@
+package egress
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTransport(t *testing.T) {
+	Convey("Given a config", t, func() {
+		cfg := config.DefaultConfig()
+		req, err := http.NewRequest("GET", "https://api.example.com/v1/payments", nil)
+		So(err, ShouldBeNil)
+
+		Convey("When a provider has a proxy", func() {
+			cfg.Provider.Egress["test"] = config.Egress{
+				LocalAddress: "127.0.0.1",
+				Proxy:        "socks5://10.0.0.1:1080",
+			}
+			tr, err := Transport(&cfg, "test")
+			So(err, ShouldBeNil)
+
+			Convey("Requests should be routed through the proxy", func() {
+				proxy, err := tr.Proxy(req)
+				So(err, ShouldBeNil)
+				So(proxy.String(), ShouldEqual, "socks5://10.0.0.1:1080")
+			})
+		})
+
+		Convey("When a provider has an invalid local address", func() {
+			cfg.Provider.Egress["test"] = config.Egress{LocalAddress: "eth0"}
+			_, err := Transport(&cfg, "test")
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When a provider has an unsupported proxy", func() {
+			cfg.Provider.Egress["test"] = config.Egress{Proxy: "ftp://10.0.0.1"}
+			_, err := Transport(&cfg, "test")
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
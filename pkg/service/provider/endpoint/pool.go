@@ -0,0 +1,155 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// number of consecutive failures after which an endpoint will be taken out
+	// of rotation
+	maxFailures = 3
+	// weight of the previous latency in the moving average
+	latencyWeight = 4
+)
+
+var (
+	ErrNoEndpoints = errors.New("endpoint pool without endpoints")
+)
+
+// Endpoint is a regional endpoint of a provider
+type Endpoint struct {
+	URL *url.URL
+
+	failures int
+	down     bool
+	latency  time.Duration
+}
+
+// Status is the state of an endpoint
+type Status struct {
+	URL      string
+	Down     bool
+	Failures int
+	// Latency is the moving average of the response times
+	Latency time.Duration
+}
+
+// Pool selects the endpoint to use from a pool of equivalent endpoints
+type Pool struct {
+	endpoints []*Endpoint
+
+	// invoked when an endpoint is taken out of rotation or returns to it
+	changed func(p *Pool, e *Endpoint, down bool)
+
+	m sync.Mutex
+}
+
+// NewPool creates a pool of the endpoints with the given URLs
+func NewPool(urls []string) (*Pool, error) {
+	if len(urls) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	p := &Pool{endpoints: make([]*Endpoint, len(urls))}
+	for i, s := range urls {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint URL %s", s)
+		}
+		p.endpoints[i] = &Endpoint{URL: u}
+	}
+	return p, nil
+}
+
+// Contains returns true if the URL points to an endpoint of the pool
+func (p *Pool) Contains(u *url.URL) bool {
+	for _, e := range p.endpoints {
+		if e.URL.Scheme == u.Scheme && e.URL.Host == u.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the endpoint with the lowest latency which is not down
+//
+// Endpoints without a measured latency will be tried first. If all endpoints
+// are down, the first endpoint will be returned.
+func (p *Pool) Select() *Endpoint {
+	p.m.Lock()
+	defer p.m.Unlock()
+	var sel *Endpoint
+	for _, e := range p.endpoints {
+		if e.down {
+			continue
+		}
+		if sel == nil || e.latency < sel.latency {
+			sel = e
+		}
+	}
+	if sel == nil {
+		return p.endpoints[0]
+	}
+	return sel
+}
+
+// Observe records the outcome of a request to the endpoint
+func (p *Pool) Observe(e *Endpoint, latency time.Duration, ok bool) {
+	p.m.Lock()
+	var changed, down bool
+	if ok {
+		if e.latency == 0 {
+			e.latency = latency
+		} else {
+			e.latency = (e.latency*latencyWeight + latency) / (latencyWeight + 1)
+		}
+		e.failures = 0
+		changed = e.down
+		e.down = false
+	} else {
+		e.failures++
+		if !e.down && e.failures >= maxFailures {
+			e.down = true
+			changed = true
+		}
+	}
+	down = e.down
+	p.m.Unlock()
+	if changed && p.changed != nil {
+		p.changed(p, e, down)
+	}
+}
+
+// Down returns true if all endpoints of the pool are down
+func (p *Pool) Down() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	for _, e := range p.endpoints {
+		if !e.down {
+			return false
+		}
+	}
+	return true
+}
+
+// Status returns the states of the endpoints
+func (p *Pool) Status() []Status {
+	p.m.Lock()
+	defer p.m.Unlock()
+	st := make([]Status, len(p.endpoints))
+	for i, e := range p.endpoints {
+		st[i] = Status{
+			URL:      e.URL.String(),
+			Down:     e.down,
+			Failures: e.failures,
+			Latency:  e.latency,
+		}
+	}
+	return st
+}
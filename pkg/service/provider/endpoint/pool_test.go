@@ -0,0 +1,123 @@
+package endpoint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPool(t *testing.T) {
+	Convey("Given a pool of two endpoints", t, func() {
+		p, err := NewPool([]string{"https://api.example.com", "https://api-eu.example.com"})
+		So(err, ShouldBeNil)
+		us, eu := p.endpoints[0], p.endpoints[1]
+
+		Convey("It should contain URLs pointing to its endpoints", func() {
+			u, _ := url.Parse("https://api-eu.example.com/v1/payments")
+			So(p.Contains(u), ShouldBeTrue)
+			u, _ = url.Parse("http://api-eu.example.com/v1/payments")
+			So(p.Contains(u), ShouldBeFalse)
+		})
+
+		Convey("When the latencies are measured", func() {
+			p.Observe(us, 300*time.Millisecond, true)
+			p.Observe(eu, 100*time.Millisecond, true)
+
+			Convey("It should select the faster endpoint", func() {
+				So(p.Select(), ShouldEqual, eu)
+			})
+
+			Convey("When the faster endpoint fails repeatedly", func() {
+				var changed []bool
+				p.changed = func(p *Pool, e *Endpoint, down bool) {
+					changed = append(changed, down)
+				}
+				for i := 0; i < maxFailures; i++ {
+					p.Observe(eu, time.Second, false)
+				}
+
+				Convey("It should be taken out of rotation", func() {
+					So(p.Select(), ShouldEqual, us)
+					So(changed, ShouldResemble, []bool{true})
+					So(p.Down(), ShouldBeFalse)
+				})
+
+				Convey("It should return after a success", func() {
+					p.Observe(eu, 100*time.Millisecond, true)
+					So(p.Select(), ShouldEqual, eu)
+					So(changed, ShouldResemble, []bool{true, false})
+				})
+			})
+		})
+
+		Convey("When all endpoints are down", func() {
+			for i := 0; i < maxFailures; i++ {
+				p.Observe(us, time.Second, false)
+				p.Observe(eu, time.Second, false)
+			}
+
+			Convey("It should fall back to the first endpoint", func() {
+				So(p.Down(), ShouldBeTrue)
+				So(p.Select(), ShouldEqual, us)
+			})
+		})
+	})
+
+	Convey("Given invalid endpoint URLs", t, func() {
+		Convey("Creating a pool should fail", func() {
+			_, err := NewPool(nil)
+			So(err, ShouldEqual, ErrNoEndpoints)
+			_, err = NewPool([]string{"api.example.com"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTransport(t *testing.T) {
+	Convey("Given a transport with a pool of a failing and a healthy endpoint", t, func() {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer failing.Close()
+		var path string
+		healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+		}))
+		defer healthy.Close()
+
+		p, err := NewPool([]string{failing.URL, healthy.URL})
+		So(err, ShouldBeNil)
+		tr := &Transport{pools: []*Pool{p}, reqs: make(map[*http.Request]*http.Request)}
+		cl := &http.Client{Transport: tr}
+
+		Convey("When requesting the failing endpoint repeatedly", func() {
+			var status int
+			for i := 0; i < maxFailures+1; i++ {
+				resp, err := cl.Get(failing.URL + "/v1/payments")
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				status = resp.StatusCode
+			}
+
+			Convey("The requests should be routed to the healthy endpoint", func() {
+				So(status, ShouldEqual, http.StatusOK)
+				So(path, ShouldEqual, "/v1/payments")
+			})
+		})
+
+		Convey("Requests to other hosts should not be routed", func() {
+			other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			defer other.Close()
+			resp, err := cl.Get(other.URL)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusTeapot)
+		})
+	})
+}
@@ -0,0 +1,187 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/chaos"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/alert"
+	"github.com/fritzpay/paymentd/pkg/service/provider/egress"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	defaultProbeInterval = 30 * time.Second
+	defaultProbePath     = "/"
+	probeTimeout         = 5 * time.Second
+)
+
+// Transport is an http.RoundTripper routing requests to any endpoint of its
+// pools to the endpoint selected by the pool
+type Transport struct {
+	// Transport performs the requests. If nil, the http.DefaultTransport will
+	// be used
+	Transport http.RoundTripper
+
+	pools []*Pool
+
+	mReq sync.Mutex
+	reqs map[*http.Request]*http.Request
+}
+
+// NewTransport creates a transport for the endpoint pools configured for the
+// provider
+//
+// The requests will be performed according to the egress config of the
+// provider. In chaos mode, the configured provider faults will be injected. The endpoints will be probed until the context is done. An alert
+// will be raised when an endpoint is taken out of rotation.
+func NewTransport(ctx *service.Context, provider string) (*Transport, error) {
+	tr, err := egress.Transport(ctx.Config(), provider)
+	if err != nil {
+		return nil, fmt.Errorf("error on egress config: %v", err)
+	}
+	t := &Transport{
+		// faults are injected per endpoint, so they trip the endpoint health
+		Transport: chaos.WrapTransport(tr, ctx.Chaos().Provider),
+		reqs:      make(map[*http.Request]*http.Request),
+	}
+	log := ctx.Log().New(log15.Ctx{
+		"pkg":      "github.com/fritzpay/paymentd/pkg/service/provider/endpoint",
+		"provider": provider,
+	})
+	for _, cfg := range ctx.Config().Provider.EndpointPools[provider] {
+		p, err := NewPool(cfg.URLs)
+		if err != nil {
+			return nil, err
+		}
+		interval := defaultProbeInterval
+		if cfg.ProbeInterval != "" {
+			interval, err = cfg.ProbeInterval.Duration()
+			if err != nil {
+				return nil, fmt.Errorf("invalid probe interval: %v", err)
+			}
+		}
+		path := cfg.ProbePath
+		if path == "" {
+			path = defaultProbePath
+		}
+		p.changed = func(p *Pool, e *Endpoint, down bool) {
+			if !down {
+				log.Info("endpoint returned to rotation", log15.Ctx{"endpoint": e.URL.String()})
+				return
+			}
+			a := &alert.Alert{
+				Kind:     alert.KindProviderCircuitOpen,
+				Severity: alert.SeverityWarning,
+				Title:    "Endpoint " + e.URL.Host + " of provider " + provider + " is down",
+				Text: fmt.Sprintf("The endpoint failed %d times in a row. It will not be used until its health probe succeeds.",
+					maxFailures),
+			}
+			if p.Down() {
+				a.Severity = alert.SeverityCritical
+				a.Title = "All endpoints of provider " + provider + " are down"
+			}
+			a.AddField("Provider", provider)
+			a.AddField("Endpoint", e.URL.String())
+			ctx.Alerts().Alert(a)
+		}
+		t.pools = append(t.pools, p)
+		go t.probe(ctx, p, path, interval)
+	}
+	return t, nil
+}
+
+// Pools returns the endpoint pools
+func (t *Transport) Pools() []*Pool {
+	return t.pools
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip implements the http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var pool *Pool
+	for _, p := range t.pools {
+		if p.Contains(req.URL) {
+			pool = p
+			break
+		}
+	}
+	if pool == nil {
+		return t.transport().RoundTrip(req)
+	}
+	e := pool.Select()
+	routed := new(http.Request)
+	*routed = *req
+	u := *req.URL
+	u.Scheme, u.Host = e.URL.Scheme, e.URL.Host
+	routed.URL = &u
+	routed.Host = ""
+
+	t.mReq.Lock()
+	t.reqs[req] = routed
+	t.mReq.Unlock()
+	defer func() {
+		t.mReq.Lock()
+		delete(t.reqs, req)
+		t.mReq.Unlock()
+	}()
+
+	start := time.Now()
+	resp, err := t.transport().RoundTrip(routed)
+	pool.Observe(e, time.Since(start), err == nil && resp.StatusCode < 500)
+	return resp, err
+}
+
+// CancelRequest cancels an in-flight request
+func (t *Transport) CancelRequest(req *http.Request) {
+	canceler, ok := t.transport().(interface {
+		CancelRequest(*http.Request)
+	})
+	if !ok {
+		return
+	}
+	t.mReq.Lock()
+	routed, ok := t.reqs[req]
+	t.mReq.Unlock()
+	if !ok {
+		routed = req
+	}
+	canceler.CancelRequest(routed)
+}
+
+// probe requests the probe path of every endpoint of the pool in the given
+// interval
+func (t *Transport) probe(ctx *service.Context, p *Pool, path string, interval time.Duration) {
+	cl := &http.Client{
+		Transport: t.transport(),
+		Timeout:   probeTimeout,
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			for _, e := range p.endpoints {
+				u := *e.URL
+				u.Path = path
+				start := time.Now()
+				resp, err := cl.Get(u.String())
+				if err == nil {
+					resp.Body.Close()
+				}
+				p.Observe(e, time.Since(start), err == nil && resp.StatusCode < 500)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package fritzpay
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+)
+
+// templateDir returns the template directory of the driver
+func templateDir(cfg *config.Config) (string, error) {
+	if cfg.Provider.ProviderTemplateDir == "" {
+		return "", fmt.Errorf("provider template dir not set")
+	}
+	dir := path.Join(cfg.Provider.ProviderTemplateDir, FritzpayTemplateDir)
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		return dir, err
+	}
+	if !dirInfo.IsDir() {
+		return dir, fmt.Errorf("provider template dir %s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// CheckConfig validates the paymentd config used by the driver
+func (d *Driver) CheckConfig(cfg *config.Config) error {
+	_, err := templateDir(cfg)
+	return err
+}
+
+// CheckMethodConfig validates the provider config of the payment method
+//
+// The FritzPay demo provider does not need a provider config.
+func (d *Driver) CheckMethodConfig(db *sql.DB, method *payment_method.Method) error {
+	return nil
+}
@@ -5,12 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"path"
 	"time"
 
+	"github.com/fritzpay/paymentd/pkg/decimal"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/paymentd/sca"
 	"github.com/fritzpay/paymentd/pkg/service"
 	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"github.com/gorilla/mux"
@@ -26,11 +26,15 @@ const (
 	providerIDFritzpay     = "fritzpay"
 	defaultLocale          = "en_US"
 	fritzpayDefaultTimeout = 30 * time.Second
+	fritzpayIntentTimeout  = 500 * time.Millisecond
 )
 
 var (
 	ErrDB       = errors.New("database error")
 	ErrConflict = errors.New("conflict")
+	// ErrRecurringInstrument is returned if a recurring payment cannot be
+	// charged, because its initial payment was not paid with FritzPay
+	ErrRecurringInstrument = errors.New("no payment instrument for recurring payment")
 )
 
 type Driver struct {
@@ -55,12 +59,7 @@ func (d *Driver) Attach(ctx *service.Context, mux *mux.Router) error {
 		return err
 	}
 
-	cfg := ctx.Config()
-	if cfg.Provider.ProviderTemplateDir == "" {
-		return fmt.Errorf("provider template dir not set")
-	}
-	d.tmplDir = path.Join(cfg.Provider.ProviderTemplateDir, FritzpayTemplateDir)
-	dirInfo, err := os.Stat(d.tmplDir)
+	d.tmplDir, err = templateDir(ctx.Config())
 	if err != nil {
 		d.log.Error("error opening template dir", log15.Ctx{
 			"err":     err,
@@ -68,14 +67,14 @@ func (d *Driver) Attach(ctx *service.Context, mux *mux.Router) error {
 		})
 		return err
 	}
-	if !dirInfo.IsDir() {
-		return fmt.Errorf("provider template dir %s is not a directory", d.tmplDir)
-	}
 
 	d.mux = mux
 	mux.HandleFunc(FritzpayDriverPath+"/status", d.Status)
 	mux.Handle(FritzpayDriverPath+"/payment", d.PaymentInfo())
-	mux.HandleFunc(FritzpayDriverPath+"/f", d.Callback).Name("fritzpayCallback")
+	// callbacks received during a database outage will be queued, unprocessable
+	// callbacks will be kept in the dead-letter queue
+	callback := ctx.DeadLetterHandler("fritzpay/callback", providerIDFritzpay, http.HandlerFunc(d.Callback))
+	mux.Handle(FritzpayDriverPath+"/f", ctx.OutageQueueHandler("fritzpay/callback", callback)).Name("fritzpayCallback")
 	return nil
 }
 
@@ -94,6 +93,9 @@ func (d *Driver) PaymentInfo() http.Handler {
 // It will always answer with a HTTP status 200 OK unless there was a data error
 // We expect the PSP to re-send the callback notification if we answer with anything
 // other than 200
+//
+// Callbacks which fail validation or reference unknown payments will be rejected
+// to the dead-letter queue.
 func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 	log := d.log.New(log15.Ctx{
 		"method": "Callback",
@@ -107,12 +109,14 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 	paymentIDStr := r.URL.Query().Get("paymentID")
 	if paymentIDStr == "" {
 		log.Warn("no payment id in callback")
+		d.ctx.DeadLetters().Reject(r, "no payment id")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 	paymentID, err := payment.ParsePaymentIDStr(paymentIDStr)
 	if err != nil {
 		log.Warn("invalid payment id", log15.Ctx{"err": err})
+		d.ctx.DeadLetters().Reject(r, "invalid payment id")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
@@ -120,10 +124,11 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 		"displayPaymentId": paymentID.String(),
 	})
 	paymentID = d.paymentService.DecodedPaymentID(paymentID)
-	p, err := payment.PaymentByIDDB(d.ctx.PaymentDB(service.ReadOnly), paymentID)
+	p, err := payment.PaymentByIDDB(d.ctx, d.ctx.ProjectPaymentDB(paymentID.ProjectID, service.ReadOnly), paymentID)
 	if err != nil {
 		if err == payment.ErrPaymentNotFound {
 			log.Warn("payment not found")
+			d.ctx.DeadLetters().Reject(r, "payment not found")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -137,18 +142,21 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 	})
 	if !p.Config.IsConfigured() {
 		log.Warn("received callback for unconfigured payment")
+		d.ctx.DeadLetters().Reject(r, "unconfigured payment")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 	if !p.Config.PaymentMethodID.Valid {
 		log.Warn("received callback for payment without payment method")
+		d.ctx.DeadLetters().Reject(r, "payment without payment method")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	method, err := payment_method.PaymentMethodByIDDB(d.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	method, err := payment_method.PaymentMethodByIDDB(d.ctx, d.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly), p.Config.PaymentMethodID.Int64)
 	if err != nil {
 		if err == payment_method.ErrPaymentMethodNotFound {
 			log.Warn("payment method not found", log15.Ctx{"paymentMethodID": p.Config.PaymentMethodID.Int64})
+			d.ctx.DeadLetters().Reject(r, "payment method not found")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -163,6 +171,7 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 	}
 	if method.Provider.Name != providerIDFritzpay {
 		log.Warn("invalid payment method provider", log15.Ctx{"providerName": method.Provider.Name})
+		d.ctx.DeadLetters().Reject(r, "invalid payment method provider "+method.Provider.Name)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
@@ -181,7 +190,7 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}()
-	tx, err = d.ctx.PaymentDB().Begin()
+	tx, err = d.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
 	if err != nil {
 		commit = true
 		log.Crit("error on begin tx", log15.Ctx{"err": err})
@@ -192,6 +201,7 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		if err == ErrPaymentNotFound {
 			log.Warn("callback on unknown payment")
+			d.ctx.DeadLetters().Reject(r, "unknown fritzpay payment")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -205,6 +215,8 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	var paymentTx *payment.PaymentTransaction
+	var commitIntent paymentService.CommitIntentFunc
 	fritzpayTx := PaymentTransaction{
 		FritzpayPaymentID: fritzpayP.ID,
 		Timestamp:         time.Now(),
@@ -220,8 +232,44 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		fritzpayTx.Status = TransactionOpen
+	case TransactionPSPPaid:
+		// the mock PSP reports the transferred amount, which does not
+		// necessarily match the payment amount
+		amount := &decimal.Decimal{}
+		if _, ok := amount.SetString(r.URL.Query().Get("amount")); !ok {
+			log.Warn("invalid amount", log15.Ctx{"amount": r.URL.Query().Get("amount")})
+			d.ctx.DeadLetters().Reject(r, "invalid amount")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		paymentTx, commitIntent, err = d.paymentService.IntentReceived(d.ctx, p, amount, fritzpayIntentTimeout)
+		if err != nil {
+			if errors.Is(err, paymentService.ErrIntentNotAllowed) {
+				log.Warn("received funds not allowed", log15.Ctx{"status": p.Status})
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log.Error("error on intent received", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fritzpayTx.Status = TransactionPaid
+	case TransactionPSPVerified:
+		paymentTx, commitIntent, err = d.paymentService.IntentVerified(d.ctx, p, fritzpayIntentTimeout)
+		if err != nil {
+			if errors.Is(err, paymentService.ErrIntentNotAllowed) {
+				log.Warn("verification not allowed", log15.Ctx{"status": p.Status})
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log.Error("error on intent verified", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fritzpayTx.Status = TransactionVerified
 	default:
 		log.Warn("invalid status", log15.Ctx{"status": r.URL.Query().Get("status")})
+		d.ctx.DeadLetters().Reject(r, "invalid status")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
@@ -231,6 +279,17 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if paymentTx != nil {
+		if auth := r.URL.Query().Get("authentication"); sca.ValidOutcome(auth) {
+			paymentTx.Authentication.String, paymentTx.Authentication.Valid = auth, true
+		}
+		err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
+		if err != nil {
+			log.Error("error on payment transaction", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
 
 	commit = true
 	err = tx.Commit()
@@ -239,4 +298,10 @@ func (d *Driver) Callback(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if commitIntent != nil {
+		err = commitIntent()
+		if err != nil {
+			log.Error("error committing intent", log15.Ctx{"err": err})
+		}
+	}
 }
@@ -2,6 +2,7 @@ package fritzpay
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -9,18 +10,42 @@ import (
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/paymentd/sca"
 	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
-	"github.com/go-sql-driver/mysql"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
 	"golang.org/x/net/context"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
-func (d *Driver) InitPayment(p *payment.Payment, method *payment_method.Method) (http.Handler, error) {
+func (d *Driver) InitPayment(p *payment.Payment, method *payment_method.Method, r *http.Request) (http.Handler, error) {
+	return d.initPayment(p, method, TransactionPSPInit)
+}
+
+// InitVerification implements the provider.Verifier
+//
+// The FritzPay PSP verifies instantly.
+func (d *Driver) InitVerification(p *payment.Payment, method *payment_method.Method, r *http.Request) (http.Handler, error) {
+	return d.initPayment(p, method, TransactionPSPVerified)
+}
+
+// SupportsExemption implements the provider.ExemptionRequester
+//
+// The FritzPay PSP accepts low-value and TRA exemptions.
+func (d *Driver) SupportsExemption(exemption string) bool {
+	return exemption == sca.ExemptionLowValue || exemption == sca.ExemptionTRA
+}
+
+// initPayment initializes the payment on the PSP
+//
+// The PSP will report the given status to the callback. The SCA exemption for
+// the payment is requested with the callback URL.
+func (d *Driver) initPayment(p *payment.Payment, method *payment_method.Method, pspStatus string) (http.Handler, error) {
 	log := d.log.New(log15.Ctx{
-		"method":          "InitPayment",
+		"method":          "initPayment",
 		"projectID":       p.ProjectID(),
 		"paymentID":       p.ID(),
 		"paymentMethodID": method.ID,
+		"pspStatus":       pspStatus,
 	})
 	if Debug {
 		log.Debug("initialize payment")
@@ -50,7 +75,7 @@ beginTx:
 		log.Crit("too many retries on tx. aborting...", log15.Ctx{"maxRetries": maxRetries})
 		return nil, ErrDB
 	}
-	tx, err = d.ctx.PaymentDB().Begin()
+	tx, err = d.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
 	if err != nil {
 		commit = true
 		log.Crit("error on begin tx", log15.Ctx{"err": err})
@@ -95,7 +120,7 @@ beginTx:
 			paymentTx.Comment.String, paymentTx.Comment.Valid = "initialized by FritzPay demo provider", true
 			err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
 			if err != nil {
-				if err == paymentService.ErrDBLockTimeout {
+				if errors.Is(err, paymentService.ErrDBLockTimeout) {
 					retries++
 					time.Sleep(time.Second)
 					goto beginTx
@@ -105,14 +130,20 @@ beginTx:
 			}
 		}
 	}
+	exemption, err := d.paymentService.SCAExemption(tx, p)
+	if err != nil {
+		log.Error("error retrieving SCA exemption", log15.Ctx{"err": err})
+		return nil, ErrDB
+	}
+	if exemption != "" && !d.SupportsExemption(exemption) {
+		exemption = ""
+	}
 	err = tx.Commit()
 	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			if mysqlErr.Number == 1213 {
-				retries++
-				time.Sleep(time.Second)
-				goto beginTx
-			}
+		if sqldialect.IsDeadlock(err) {
+			retries++
+			time.Sleep(time.Second)
+			goto beginTx
 		}
 		log.Crit("error on commit", log15.Ctx{"err": err})
 		commit = true
@@ -141,10 +172,13 @@ beginTx:
 		callbackURL.Path = routeURL.Path
 		q := callbackURL.Query()
 		q.Set("paymentID", d.paymentService.EncodedPaymentID(p.PaymentID()).String())
+		if exemption != "" {
+			q.Set("exemption", exemption)
+		}
 		callbackURL.RawQuery = q.Encode()
 
 		workerCtx, _ := context.WithTimeout(d.ctx, fritzpayDefaultTimeout)
-		go pspInit(workerCtx, fritzpayP, callbackURL.String())
+		go pspInit(workerCtx, fritzpayP, callbackURL.String(), pspStatus)
 		defer func() {
 			if err := recover(); err != nil {
 				log.Crit("panic on worker", log15.Ctx{"err": err})
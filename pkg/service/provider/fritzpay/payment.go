@@ -18,6 +18,11 @@ const (
 	TransactionInit     = "initialized"
 	TransactionPSPError = "psp_error"
 	TransactionOpen     = "open"
+	TransactionPSPPaid  = "psp_paid"
+	TransactionPaid     = "paid"
+	// verification payments
+	TransactionPSPVerified = "psp_verified"
+	TransactionVerified    = "verified"
 )
 
 type PaymentTransaction struct {
@@ -9,11 +9,17 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/fritzpay/paymentd/pkg/paymentd/sca"
 	"golang.org/x/net/context"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
-func pspInit(ctx context.Context, fritzpayP Payment, callbackURL string) {
+// pspInit simulates the initialization of the payment on the PSP
+//
+// The PSP reports the status to the callback URL along with the authentication
+// outcome. Payments with a requested exemption are exempted, all other payments
+// are authenticated frictionless.
+func pspInit(ctx context.Context, fritzpayP Payment, callbackURL, status string) {
 	if deadline, ok := ctx.Deadline(); ok {
 		// let's assume we will need at least 3 seconds to run
 		if deadline.Before(time.Now().Add(3 * time.Second)) {
@@ -57,7 +63,7 @@ func pspInit(ctx context.Context, fritzpayP Payment, callbackURL string) {
 			}
 			paymentTx.FritzpayPaymentID = fritzpayP.ID
 			paymentTx.Timestamp = time.Now()
-			paymentTx.Status = TransactionPSPInit
+			paymentTx.Status = status
 			paymentTx.FritzpayID.String, paymentTx.FritzpayID.Valid = hex.EncodeToString(h.Sum(nil)), true
 			paymentTx.Payload.String, paymentTx.Payload.Valid = "initialized on psp", true
 			err = InsertPaymentTransactionTx(tx, paymentTx)
@@ -69,6 +75,11 @@ func pspInit(ctx context.Context, fritzpayP Payment, callbackURL string) {
 		q := callback.Query()
 		q.Set("fritzpayID", paymentTx.FritzpayID.String)
 		q.Set("status", paymentTx.Status)
+		if q.Get("exemption") != "" {
+			q.Set("authentication", sca.OutcomeExempted)
+		} else {
+			q.Set("authentication", sca.OutcomeFrictionless)
+		}
 		callback.RawQuery = q.Encode()
 		req, err = http.NewRequest("GET", callback.String(), nil)
 		if err != nil {
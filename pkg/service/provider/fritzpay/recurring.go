@@ -0,0 +1,146 @@
+package fritzpay
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// ChargeRecurring implements the payment.RecurringCharger
+//
+// The FritzPay PSP charges the recurring payment instantly with the payment
+// instrument of the FritzPay payment of the initial payment. Unlike initial
+// payments, there is no callback, the payment is opened and paid in one
+// transaction. Retried charges of open payments are paid only.
+func (d *Driver) ChargeRecurring(ctx context.Context, p, initial *payment.Payment) error {
+	log := d.log.New(log15.Ctx{
+		"method":           "ChargeRecurring",
+		"projectID":        p.ProjectID(),
+		"paymentID":        p.ID(),
+		"initialPaymentID": initial.ID(),
+	})
+	var tx *sql.Tx
+	var commit bool
+	var err error
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = d.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
+	if err != nil {
+		commit = true
+		log.Crit("error on begin tx", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	initialP, err := PaymentByPaymentIDTx(tx, initial.PaymentID())
+	if err != nil {
+		if err == ErrPaymentNotFound {
+			log.Warn("initial payment was not paid with FritzPay")
+			return ErrRecurringInstrument
+		}
+		log.Error("error retrieving initial payment", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	// retried charges reuse the FritzPay payment of the failed charge
+	fritzpayP, err := PaymentByPaymentIDTx(tx, p.PaymentID())
+	if err != nil && err != ErrPaymentNotFound {
+		log.Error("error retrieving payment", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	if err == ErrPaymentNotFound {
+		fritzpayP = Payment{
+			ProjectID: p.ProjectID(),
+			PaymentID: p.ID(),
+			Created:   time.Now(),
+			MethodKey: initialP.MethodKey,
+		}
+		err = InsertPaymentTx(tx, &fritzpayP)
+		if err != nil {
+			log.Error("error creating new payment", log15.Ctx{"err": err})
+			return ErrDB
+		}
+	}
+	var commitOpen paymentService.CommitIntentFunc
+	if !d.paymentService.IsInitialized(p) {
+		var openTx *payment.PaymentTransaction
+		openTx, commitOpen, err = d.paymentService.IntentOpen(ctx, p, fritzpayIntentTimeout)
+		if err != nil {
+			var hold *paymentService.HoldError
+			if !errors.As(err, &hold) {
+				log.Error("error on intent open", log15.Ctx{"err": err})
+				return err
+			}
+			// the charge is left to the review of the held payment
+			log.Info("payment held for review", log15.Ctx{"worker": hold.Worker, "reason": hold.Reason})
+			commitOpen, err = d.paymentService.HoldPayment(tx, p, hold)
+			if err != nil {
+				log.Error("error holding payment", log15.Ctx{"err": err})
+				return err
+			}
+			return d.commitRecurring(tx, &commit, commitOpen)
+		}
+		err = d.paymentService.SetPaymentTransaction(tx, openTx)
+		if err != nil {
+			log.Error("error on payment transaction", log15.Ctx{"err": err})
+			return err
+		}
+	}
+	paidTx, commitPaid, err := d.paymentService.IntentPaid(ctx, p, fritzpayIntentTimeout)
+	if err != nil {
+		log.Error("error on intent paid", log15.Ctx{"err": err})
+		return err
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "%d", fritzpayP.ID)
+	fritzpayTx := PaymentTransaction{
+		FritzpayPaymentID: fritzpayP.ID,
+		Timestamp:         time.Now(),
+		Status:            TransactionPaid,
+	}
+	fritzpayTx.FritzpayID.String, fritzpayTx.FritzpayID.Valid = hex.EncodeToString(h.Sum(nil)), true
+	fritzpayTx.Payload.String, fritzpayTx.Payload.Valid = fmt.Sprintf("charged recurring with payment %d", initialP.ID), true
+	err = InsertPaymentTransactionTx(tx, fritzpayTx)
+	if err != nil {
+		log.Error("error on insert payment tx", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	err = d.paymentService.SetPaymentTransaction(tx, paidTx)
+	if err != nil {
+		log.Error("error on payment transaction", log15.Ctx{"err": err})
+		return err
+	}
+	return d.commitRecurring(tx, &commit, commitOpen, commitPaid)
+}
+
+// commitRecurring commits the transaction of a recurring charge and the
+// intents of its payment transactions
+func (d *Driver) commitRecurring(tx *sql.Tx, commit *bool, intents ...paymentService.CommitIntentFunc) error {
+	err := tx.Commit()
+	if err != nil {
+		d.log.Crit("error on commit", log15.Ctx{"err": err})
+		return ErrDB
+	}
+	*commit = true
+	for _, commitIntent := range intents {
+		if commitIntent == nil {
+			continue
+		}
+		err = commitIntent()
+		if err != nil {
+			d.log.Error("error committing intent", log15.Ctx{"err": err})
+		}
+	}
+	return nil
+}
@@ -99,7 +99,7 @@ func (d *Driver) oAuthTransport(log log15.Logger) func(p *payment.Payment, cfg *
 				TokenURL:     tokenURL.String(),
 				TokenCache:   NewTokenCache(),
 			}
-			tr = &oauth.Transport{Config: oAuthCfg}
+			tr = &oauth.Transport{Config: oAuthCfg, Transport: d.endpoints}
 			d.oauth.PutTransport(p.ProjectID(), cfg.MethodKey, tr)
 		}
 		return tr, nil
@@ -125,7 +125,7 @@ func (d *Driver) oAuthTransportFunc(p *payment.Payment, cfg *Config) func() (*oa
 				TokenURL:     tokenURL.String(),
 				TokenCache:   NewTokenCache(),
 			}
-			tr = &oauth.Transport{Config: oAuthCfg}
+			tr = &oauth.Transport{Config: oAuthCfg, Transport: d.endpoints}
 			d.oauth.PutTransport(p.ProjectID(), cfg.MethodKey, tr)
 		}
 		return tr, nil
@@ -0,0 +1,84 @@
+package paypal_rest
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/cache"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// configCacheTTL is the time for which PayPal configs will be cached
+const configCacheTTL = time.Minute
+
+func configCacheKey(method *payment_method.Method) string {
+	return "paypalconfig:" + strconv.FormatInt(method.ProjectID, 10) + ":" + method.MethodKey
+}
+
+// config retrieves the PayPal config of the payment method through the shared
+// cache
+func (d *Driver) config(method *payment_method.Method, log log15.Logger) (*Config, error) {
+	if b, err := d.ctx.Cache().Get(configCacheKey(method)); err == nil {
+		cfg := &Config{}
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(cfg)
+		if err == nil {
+			return cfg, nil
+		}
+		log.Warn("error decoding cached PayPal config", log15.Ctx{"err": err})
+	} else if err != cache.ErrNotFound {
+		log.Error("error retrieving cached PayPal config", log15.Ctx{"err": err})
+	}
+	cfg, err := ConfigByPaymentMethodDB(d.ctx, d.ctx.ProjectPaymentDB(method.ProjectID, service.ReadOnly), method)
+	if err != nil {
+		return nil, err
+	}
+	err = d.cacheConfig(method, cfg)
+	if err != nil {
+		log.Error("error caching PayPal config", log15.Ctx{"err": err})
+	}
+	return cfg, nil
+}
+
+func (d *Driver) cacheConfig(method *payment_method.Method, cfg *Config) error {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(cfg)
+	if err != nil {
+		return err
+	}
+	return d.ctx.Cache().Set(configCacheKey(method), buf.Bytes(), configCacheTTL)
+}
+
+// warmConfigs loads the configs of the active PayPal payment methods of all
+// shards into the cache
+func (d *Driver) warmConfigs() (int, error) {
+	var n int
+	for _, sh := range d.ctx.PaymentShardDBs(service.ReadOnly) {
+		methods, err := payment_method.PaymentMethodsByStatusDB(d.ctx, sh.DB, payment_method.PaymentMethodStatusActive)
+		if err != nil {
+			return n, err
+		}
+		for _, method := range methods {
+			if method.Provider.Name != providerName {
+				continue
+			}
+			cfg, err := ConfigByPaymentMethodDB(d.ctx, sh.DB, method)
+			if err == ErrConfigNotFound {
+				d.log.Warn("no PayPal config for active payment method", log15.Ctx{"paymentMethodID": method.ID})
+				continue
+			}
+			if err != nil {
+				return n, err
+			}
+			err = d.cacheConfig(method, cfg)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
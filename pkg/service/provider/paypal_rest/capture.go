@@ -0,0 +1,201 @@
+package paypal_rest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// endpoint path for authorizations, followed by the authorization ID
+	paypalAuthorizationPath = "/v1/payments/authorization/"
+)
+
+// PayPalCaptureRequest is the request to capture an authorization
+type PayPalCaptureRequest struct {
+	Amount         PayPalAmount `json:"amount"`
+	IsFinalCapture bool         `json:"is_final_capture"`
+}
+
+// Capture captures the amount of an authorized payment
+//
+// It implements the provider.Capturer. The capture is final once the payment is
+// paid, then PayPal releases the remaining authorized amount. The request to
+// PayPal will be cancelled with the context. Captures confirmed by PayPal will
+// be recorded regardless.
+//
+// The payment is locked from before the request to PayPal until the capture is
+// recorded, so that concurrent captures cannot exceed the authorized amount.
+func (d *Driver) Capture(ctx context.Context, p *payment.Payment, amount *decimal.Decimal, final bool) error {
+	log := d.log.New(log15.Ctx{
+		"method":    "Capture",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+		"amount":    amount.String(),
+	})
+	method, err := d.paymentService.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return err
+	}
+	cfg, err := d.config(method, log)
+	if err != nil {
+		log.Error("error retrieving PayPal config", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	auth, err := AuthorizationCurrentByPaymentIDDB(ctx, d.ctx.ProjectPaymentDB(p.ProjectID()), p.PaymentID())
+	if err != nil {
+		if err == ErrAuthorizationNotFound {
+			return err
+		}
+		log.Error("error retrieving authorization", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+
+	// the client gave up
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	var paymentTx *payment.PaymentTransaction
+	var commitIntent paymentService.CommitIntentFunc
+	if final {
+		paymentTx, commitIntent, err = d.paymentService.IntentFinalCapture(ctx, p, amount, 500*time.Millisecond)
+	} else {
+		paymentTx, commitIntent, err = d.paymentService.IntentCapture(ctx, p, amount, 500*time.Millisecond)
+	}
+	if err != nil {
+		log.Info("capture intent rejected", log15.Ctx{"err": err})
+		return err
+	}
+
+	var tx *sql.Tx
+	var commit bool
+	defer func() {
+		if tx != nil && !commit {
+			err := tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = d.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
+	if err != nil {
+		log.Crit("error on begin tx", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	err = d.paymentService.LockCaptureTx(tx, paymentTx)
+	if err != nil {
+		log.Info("capture rejected", log15.Ctx{"err": err})
+		return err
+	}
+
+	capture := &PayPalCaptureRequest{
+		Amount:         d.payPalAmount(d.paymentService.EncodedPaymentID(p.PaymentID()), paymentTx.Decimal(), p.Currency),
+		IsFinalCapture: paymentTx.Status == payment.PaymentStatusPaid,
+	}
+	body, err := json.Marshal(capture)
+	if err != nil {
+		log.Error("error encoding capture request", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		log.Error("error on endpoint URL", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	endpoint.Path = paypalAuthorizationPath + auth.AuthorizationID + "/capture"
+
+	paypalTx := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeCapture,
+	}
+	paypalTx.SetPaypalID(auth.PaypalID)
+	paypalTx.Data = body
+	err = InsertTransactionDB(ctx, d.ctx.ProjectPaymentDB(p.ProjectID()), paypalTx)
+	if err != nil {
+		log.Error("error saving paypal transaction", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+
+	req, err := http.NewRequest("POST", endpoint.String(), strings.NewReader(string(body)))
+	if err != nil {
+		log.Error("error creating capture request", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	req.Header.Set("Content-Type", "application/json")
+	responseFunc := func(resp *http.Response, err error) error {
+		if err != nil {
+			log.Error("error on request", log15.Ctx{"err": err})
+			return err
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Error("error reading response body", log15.Ctx{"err": err})
+			return ErrHTTP
+		}
+		log = log.New(log15.Ctx{"responseBody": string(respBody)})
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			log.Error("invalid HTTP status code", log15.Ctx{"statusCode": resp.StatusCode})
+			return ErrProvider
+		}
+		res := &PayPalResource{}
+		err = json.Unmarshal(respBody, res)
+		if err != nil {
+			log.Error("error decoding response", log15.Ctx{"err": err})
+			return ErrHTTP
+		}
+		if res.State != "completed" && res.State != "pending" {
+			log.Error("capture not completed", log15.Ctx{"state": res.State})
+			return ErrProvider
+		}
+
+		paypalTx := &Transaction{
+			ProjectID: p.ProjectID(),
+			PaymentID: p.ID(),
+			Timestamp: time.Now(),
+			Type:      TransactionTypeCaptureResponse,
+		}
+		paypalTx.SetPaypalID(auth.PaypalID)
+		paypalTx.SetState(res.State)
+		paypalTx.Data = respBody
+		err = InsertTransactionTx(ctx, tx, paypalTx)
+		if err != nil {
+			log.Error("error saving paypal transaction", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+
+		paymentTx.Comment.String, paymentTx.Comment.Valid = strings.TrimSpace(paymentTx.Comment.String+" PayPal CaptureID: "+res.ID), true
+		err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
+		if err != nil {
+			log.Error("error on payment transaction", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+		commit = true
+		err = commitIntent()
+		if err != nil {
+			log.Error("error committing intent", log15.Ctx{"err": err})
+		}
+		log.Info("payment captured", log15.Ctx{"captureID": res.ID})
+		return nil
+	}
+	return httpDoContext(ctx, log, d.oAuthTransportFunc(p, cfg), req, responseFunc)
+}
@@ -0,0 +1,228 @@
+package paypal_rest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// PayPal webhook event types of customer disputes
+const (
+	WebhookEventDisputeCreated  = "CUSTOMER.DISPUTE.CREATED"
+	WebhookEventDisputeUpdated  = "CUSTOMER.DISPUTE.UPDATED"
+	WebhookEventDisputeResolved = "CUSTOMER.DISPUTE.RESOLVED"
+)
+
+// PayPal dispute outcomes
+const (
+	DisputeOutcomeSellerFavour    = "RESOLVED_SELLER_FAVOUR"
+	DisputeOutcomeBuyerFavour     = "RESOLVED_BUYER_FAVOUR"
+	DisputeOutcomeCanceledByBuyer = "CANCELED_BY_BUYER"
+)
+
+const (
+	disputeCreatedBy    = "paypal"
+	disputeMaxReasonLen = 255
+)
+
+var (
+	ErrDisputeTransaction = errors.New("no disputed transaction of a payment")
+)
+
+// PayPalDispute is the resource of a customer dispute event
+//
+// See https://developer.paypal.com/docs/api/customer-disputes/
+type PayPalDispute struct {
+	DisputeID            string                     `json:"dispute_id"`
+	Reason               string                     `json:"reason"`
+	Status               string                     `json:"status"`
+	DisputeAmount        PayPalDisputeAmount        `json:"dispute_amount"`
+	DisputedTransactions []PayPalDisputeTransaction `json:"disputed_transactions"`
+	DisputeOutcome       struct {
+		OutcomeCode string `json:"outcome_code"`
+	} `json:"dispute_outcome"`
+}
+
+// PayPalDisputeAmount is the disputed amount
+type PayPalDisputeAmount struct {
+	Currency string `json:"currency_code"`
+	Value    string `json:"value"`
+}
+
+// PayPalDisputeTransaction is a transaction disputed by the customer
+type PayPalDisputeTransaction struct {
+	SellerTransactionID string `json:"seller_transaction_id"`
+	InvoiceNumber       string `json:"invoice_number"`
+	Custom              string `json:"custom"`
+}
+
+// disputeEvent returns true if the event type is one of the customer dispute
+// events
+func disputeEvent(eventType string) bool {
+	switch eventType {
+	case WebhookEventDisputeCreated, WebhookEventDisputeUpdated, WebhookEventDisputeResolved:
+		return true
+	default:
+		return false
+	}
+}
+
+// webhookDispute reads the dispute resource of the webhook event body
+func webhookDispute(body []byte) (*PayPalDispute, error) {
+	e := &struct {
+		Resource PayPalDispute `json:"resource"`
+	}{}
+	err := json.Unmarshal(body, e)
+	if err != nil {
+		return nil, err
+	}
+	return &e.Resource, nil
+}
+
+// disputePaymentID returns the ID of the disputed payment
+//
+// The driver sets the encoded payment ID as the invoice number of the PayPal
+// transaction.
+func (d *Driver) disputePaymentID(dp *PayPalDispute) (payment.PaymentID, error) {
+	for _, t := range dp.DisputedTransactions {
+		if t.InvoiceNumber == "" {
+			continue
+		}
+		id, err := payment.ParsePaymentIDStr(t.InvoiceNumber)
+		if err != nil {
+			continue
+		}
+		return d.paymentService.DecodedPaymentID(id), nil
+	}
+	return payment.PaymentID{}, ErrDisputeTransaction
+}
+
+// disputeChargeback returns the status change of the dispute for the event
+//
+// Created disputes are received chargebacks. Resolved disputes are reversed if
+// the seller won or the buyer cancelled and lost if the buyer won. It returns
+// nil if the event does not change the dispute, e.g. on updates.
+func disputeChargeback(p *payment.Payment, eventType string, dp *PayPalDispute) (*payment.Chargeback, error) {
+	var status string
+	switch eventType {
+	case WebhookEventDisputeCreated:
+		status = payment.ChargebackReceived
+	case WebhookEventDisputeResolved:
+		switch dp.DisputeOutcome.OutcomeCode {
+		case DisputeOutcomeSellerFavour, DisputeOutcomeCanceledByBuyer:
+			status = payment.ChargebackReversed
+		case DisputeOutcomeBuyerFavour:
+			status = payment.ChargebackLost
+		default:
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	var amount int64
+	if status == payment.ChargebackReceived {
+		if dp.DisputeAmount.Currency != p.Currency {
+			return nil, ErrWebhookAmount
+		}
+		d := &decimal.Decimal{}
+		if _, ok := d.SetString(dp.DisputeAmount.Value); !ok || d.Sign() <= 0 {
+			return nil, ErrWebhookAmount
+		}
+		d.Round(&d.Dec, dec.Scale(p.Subunits), dec.RoundHalfUp)
+		amount = d.Unscaled().Int64()
+	}
+	c := p.NewChargeback(dp.DisputeID, status, amount, disputeCreatedBy)
+	reason := dp.Reason
+	if status != payment.ChargebackReceived {
+		reason = "outcome " + dp.DisputeOutcome.OutcomeCode
+	}
+	if len(reason) > disputeMaxReasonLen {
+		reason = reason[:disputeMaxReasonLen]
+	}
+	c.Reason.String, c.Reason.Valid = reason, reason != ""
+	return c, nil
+}
+
+// processDisputeEvent records the status change of the dispute and saves the
+// webhook event
+//
+// The chargeback is recorded before the event is saved. Should saving the
+// event fail, the redelivered event will be rejected by the chargeback, since
+// each status of a dispute is recorded once. Errors are logged.
+func (d *Driver) processDisputeEvent(p *payment.Payment, e *WebhookEvent, dp *PayPalDispute, body []byte, log log15.Logger) error {
+	var tx *sql.Tx
+	var commit bool
+	var err error
+	defer func() {
+		if tx != nil && !commit {
+			err := tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = d.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
+	if err != nil {
+		log.Crit("error on begin tx", log15.Ctx{"err": err})
+		return err
+	}
+	_, err = EventByEventIDTx(d.ctx, tx, e.ID)
+	if err == nil {
+		if Debug {
+			log.Debug("event already processed")
+		}
+		return nil
+	}
+	if err != ErrEventNotFound {
+		log.Error("error retrieving event", log15.Ctx{"err": err})
+		return err
+	}
+	c, err := disputeChargeback(p, e.EventType, dp)
+	if err != nil {
+		log.Warn("invalid dispute amount", log15.Ctx{"amount": dp.DisputeAmount})
+	} else if c == nil {
+		if Debug {
+			log.Debug("ignoring dispute event", log15.Ctx{"outcome": dp.DisputeOutcome.OutcomeCode})
+		}
+	} else {
+		err = d.paymentService.RecordChargeback(p.PaymentID(), c, webhookIntentTimeout)
+		if err != nil {
+			switch {
+			case errors.Is(err, paymentService.ErrIntentNotAllowed):
+				log.Warn("chargeback not allowed", log15.Ctx{"status": p.Status})
+			case errors.Is(err, paymentService.ErrChargebackAmount):
+				log.Warn("invalid chargeback amount", log15.Ctx{"amount": dp.DisputeAmount})
+			default:
+				log.Error("error recording chargeback", log15.Ctx{"err": err})
+				return err
+			}
+		}
+	}
+	err = InsertEventTx(d.ctx, tx, &Event{
+		ProjectID:  p.ProjectID(),
+		PaymentID:  p.ID(),
+		Timestamp:  time.Now(),
+		EventID:    e.ID,
+		EventType:  e.EventType,
+		ResourceID: dp.DisputeID,
+		Data:       body,
+	})
+	if err != nil {
+		log.Error("error saving event", log15.Ctx{"err": err})
+		return err
+	}
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package paypal_rest
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDisputeChargeback(t *testing.T) {
+	Convey("Given a dispute event of a paid payment", t, func() {
+		p := &payment.Payment{
+			Amount:   1000,
+			Subunits: 2,
+			Currency: "EUR",
+			Status:   payment.PaymentStatusPaid,
+		}
+		body := []byte(`{"id":"WH-2","event_type":"CUSTOMER.DISPUTE.CREATED","resource":{
+			"dispute_id":"PP-D-1",
+			"reason":"MERCHANDISE_OR_SERVICE_NOT_RECEIVED",
+			"status":"OPEN",
+			"dispute_amount":{"currency_code":"EUR","value":"4.50"},
+			"disputed_transactions":[{"seller_transaction_id":"SALE-1","invoice_number":"1-1"}]
+		}}`)
+		dp, err := webhookDispute(body)
+		So(err, ShouldBeNil)
+		So(len(dp.DisputedTransactions), ShouldEqual, 1)
+		So(dp.DisputedTransactions[0].InvoiceNumber, ShouldEqual, "1-1")
+
+		Convey("When the dispute is created", func() {
+			c, err := disputeChargeback(p, WebhookEventDisputeCreated, dp)
+			So(err, ShouldBeNil)
+			Convey("It should be received with the disputed amount", func() {
+				So(c.DisputeID, ShouldEqual, "PP-D-1")
+				So(c.Status, ShouldEqual, payment.ChargebackReceived)
+				So(c.Amount, ShouldEqual, 450)
+				So(c.Reason.String, ShouldEqual, "MERCHANDISE_OR_SERVICE_NOT_RECEIVED")
+			})
+		})
+		Convey("When the dispute is updated", func() {
+			c, err := disputeChargeback(p, WebhookEventDisputeUpdated, dp)
+			Convey("It should not change the dispute", func() {
+				So(err, ShouldBeNil)
+				So(c, ShouldBeNil)
+			})
+		})
+		Convey("When the dispute is resolved in favor of the seller", func() {
+			dp.DisputeOutcome.OutcomeCode = DisputeOutcomeSellerFavour
+			c, err := disputeChargeback(p, WebhookEventDisputeResolved, dp)
+			So(err, ShouldBeNil)
+			Convey("It should be reversed", func() {
+				So(c.Status, ShouldEqual, payment.ChargebackReversed)
+				So(c.Reason.String, ShouldEqual, "outcome RESOLVED_SELLER_FAVOUR")
+			})
+		})
+		Convey("When the dispute is resolved in favor of the buyer", func() {
+			dp.DisputeOutcome.OutcomeCode = DisputeOutcomeBuyerFavour
+			c, err := disputeChargeback(p, WebhookEventDisputeResolved, dp)
+			So(err, ShouldBeNil)
+			Convey("It should be lost", func() {
+				So(c.Status, ShouldEqual, payment.ChargebackLost)
+			})
+		})
+	})
+}
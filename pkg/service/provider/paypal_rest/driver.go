@@ -8,7 +8,6 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"strings"
 	"time"
@@ -19,11 +18,18 @@ import (
 
 	"code.google.com/p/goauth2/oauth"
 
+	"github.com/fritzpay/paymentd/pkg/asset"
+	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
+
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 
 	"github.com/fritzpay/paymentd/pkg/service"
 	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/service/provider/egress"
+	"github.com/fritzpay/paymentd/pkg/service/provider/endpoint"
+	tmpl "github.com/fritzpay/paymentd/pkg/template"
 	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
@@ -34,6 +40,7 @@ const (
 )
 
 const (
+	providerName        = "paypal_rest"
 	providerTemplateDir = "paypal_rest"
 	defaultLocale       = "en_US"
 	// endpoint path for REST API URL
@@ -53,11 +60,20 @@ type Driver struct {
 	mux *mux.Router
 	log log15.Logger
 
-	tmplDir string
+	tmplDir   string
+	assets    *asset.Pipeline
+	templates *tmpl.Manager
 
 	paymentService *paymentService.Service
+	rounding       currency.RoundingPolicy
 
 	oauth *OAuthTransportStore
+	// routes requests to the configured regional endpoints
+	endpoints *endpoint.Transport
+	// webhook signing certificates
+	certs *certStore
+	// orders the webhook events of payments
+	webhooks *webhookSequencer
 }
 
 func (d *Driver) Attach(ctx *service.Context, mux *mux.Router) error {
@@ -73,12 +89,15 @@ func (d *Driver) Attach(ctx *service.Context, mux *mux.Router) error {
 		return err
 	}
 
-	cfg := ctx.Config()
-	if cfg.Provider.ProviderTemplateDir == "" {
-		return fmt.Errorf("provider template dir not set")
+	rounding := ctx.Config().Provider.Rounding[providerName]
+	d.rounding, err = currency.NewRoundingPolicy(rounding.Mode, rounding.Exponents)
+	if err != nil {
+		d.log.Error("error on rounding policy", log15.Ctx{"err": err})
+		return err
 	}
-	d.tmplDir = path.Join(cfg.Provider.ProviderTemplateDir, providerTemplateDir)
-	dirInfo, err := os.Stat(d.tmplDir)
+
+	cfg := ctx.Config()
+	d.tmplDir, err = templateDir(cfg)
 	if err != nil {
 		d.log.Error("error opening template dir", log15.Ctx{
 			"err":     err,
@@ -86,9 +105,6 @@ func (d *Driver) Attach(ctx *service.Context, mux *mux.Router) error {
 		})
 		return err
 	}
-	if !dirInfo.IsDir() {
-		return fmt.Errorf("provider template dir %s is not a directory", d.tmplDir)
-	}
 	_, err = url.Parse(cfg.Provider.URL)
 	if err != nil {
 		d.log.Error("error parsing provider base URL", log15.Ctx{"err": err})
@@ -102,22 +118,54 @@ func (d *Driver) Attach(ctx *service.Context, mux *mux.Router) error {
 		return fmt.Errorf("error on subroute path: %v", err)
 	}
 	d.mux = driverRoute.Subrouter()
-	d.mux.Handle("/return", ctx.RateLimitHandler(d.ReturnHandler())).Name("returnHandler")
-	d.mux.Handle("/cancel", ctx.RateLimitHandler(d.CancelHandler())).Name("cancelHandler")
+	// shoppers returning during a database outage will be queued
+	d.mux.Handle("/return", ctx.OutageQueueHandler("paypal_rest/return", ctx.RateLimitHandler(d.ReturnHandler()))).Name("returnHandler")
+	d.mux.Handle("/cancel", ctx.OutageQueueHandler("paypal_rest/cancel", ctx.RateLimitHandler(d.CancelHandler()))).Name("cancelHandler")
 	staticDir := path.Join(d.tmplDir, "static")
 	d.log.Info("serving static dir", log15.Ctx{
 		"staticDir": staticDir,
 		"prefix":    u.Path + "/static",
 	})
-	d.mux.PathPrefix("/static").Handler(http.StripPrefix(u.Path+"/static", http.FileServer(http.Dir(staticDir)))).Name("staticHandler")
+	d.assets, err = asset.NewPipeline(staticDir, u.Path+"/static")
+	if err != nil {
+		d.log.Error("error loading static assets", log15.Ctx{"err": err})
+		return err
+	}
+	d.mux.PathPrefix("/static").Handler(http.StripPrefix(u.Path+"/static", service.CompressionHandler(d.assets))).Name("staticHandler")
+
+	d.templates = tmpl.NewManager(d.tmplDir, defaultLocale, d.templateFuncs())
+	err = d.templates.Load()
+	if err != nil {
+		d.log.Error("error loading templates", log15.Ctx{"err": err})
+		return err
+	}
+	ctx.Templates().Register("provider/"+providerName, d.templates)
 
 	d.oauth = NewOAuthTransportStore()
+	d.endpoints, err = endpoint.NewTransport(ctx, providerName)
+	if err != nil {
+		d.log.Error("error on endpoint pools", log15.Ctx{"err": err})
+		return err
+	}
+	tr, err := egress.Transport(cfg, providerName)
+	if err != nil {
+		d.log.Error("error on egress config", log15.Ctx{"err": err})
+		return err
+	}
+	d.certs = newCertStore(tr)
+	d.webhooks = newWebhookSequencer()
+	// webhooks received during a database outage will be queued, unprocessable
+	// webhooks will be kept in the dead-letter queue
+	webhook := ctx.DeadLetterHandler("paypal_rest/webhook", providerName, d.WebhookHandler())
+	d.mux.Handle("/webhook", ctx.OutageQueueHandler("paypal_rest/webhook", webhook)).Name("webhookHandler")
+	ctx.CacheWarmer().Register("provider/"+providerName, d.warmConfigs)
 
 	return nil
 }
 
-func (d *Driver) baseURL() (*url.URL, error) {
-	return url.Parse(d.ctx.Config().Provider.URL)
+// baseURL returns the provider base URL for the given request
+func (d *Driver) baseURL(r *http.Request) (*url.URL, error) {
+	return d.ctx.RequestBaseURL(r, d.ctx.Config().Provider.URL)
 }
 
 // creates an error transaction
@@ -136,7 +184,7 @@ func (d *Driver) setPayPalError(p *payment.Payment, data []byte) {
 		Type:      TransactionTypeError,
 	}
 	paypalTx.Data = data
-	err := InsertTransactionDB(d.ctx.PaymentDB(), paypalTx)
+	err := InsertTransactionDB(d.ctx, d.ctx.ProjectPaymentDB(p.ProjectID()), paypalTx)
 	if err != nil {
 		log.Error("error saving paypal transaction", log15.Ctx{"err": err})
 	}
@@ -149,26 +197,39 @@ func httpDo(
 	req *http.Request,
 	f func(*http.Response, error) error) error {
 
+	return httpDoContext(ctx, ctx.Log(), createTr, req, f)
+}
+
+// execute an HTTP request, which will be cancelled with the given context
+func httpDoContext(
+	ctx context.Context,
+	log log15.Logger,
+	createTr func() (*oauth.Transport, error),
+	req *http.Request,
+	f func(*http.Response, error) error) error {
+
 	tr, err := createTr()
 	if err != nil {
-		ctx.Log().Error("error on auth transport", log15.Ctx{"err": err})
+		log.Error("error on auth transport", log15.Ctx{"err": err})
 		return err
 	}
 	err = tr.AuthenticateClient()
 	if err != nil {
-		ctx.Log().Error("error authenticating", log15.Ctx{"err": err})
+		log.Error("error authenticating", log15.Ctx{"err": err})
 		return err
 	}
 	if Debug {
-		ctx.Log().Debug("authenticated", log15.Ctx{"accessToken": tr.Token.AccessToken})
+		log.Debug("authenticated", log15.Ctx{"accessToken": tr.Token.AccessToken})
 	}
 	cl := tr.Client()
 	c := make(chan error, 1)
 	go func() { c <- f(cl.Do(req)) }()
 	select {
 	case <-ctx.Done():
-		if httpTr, ok := tr.Transport.(*http.Transport); ok {
-			httpTr.CancelRequest(req)
+		if canceler, ok := tr.Transport.(interface {
+			CancelRequest(*http.Request)
+		}); ok {
+			canceler.CancelRequest(req)
 		}
 		<-c
 		return ctx.Err()
@@ -179,7 +240,7 @@ func httpDo(
 
 func (d *Driver) getPayment(p *payment.Payment) {
 	log := d.log.New(log15.Ctx{"method": "getPayment"})
-	paypalTx, err := TransactionByPaymentIDAndTypeDB(d.ctx.PaymentDB(service.ReadOnly), p.PaymentID(), TransactionTypeCreatePaymentResponse)
+	paypalTx, err := TransactionByPaymentIDAndTypeDB(d.ctx, d.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly), p.PaymentID(), TransactionTypeCreatePaymentResponse)
 	if err != nil {
 		log.Error("error retrieving paypal transaction. unitialized payment?", log15.Ctx{"err": err})
 		return
@@ -205,12 +266,12 @@ func (d *Driver) getPayment(p *payment.Payment) {
 			return
 		}
 	}
-	method, err := payment_method.PaymentMethodByIDDB(d.ctx.PaymentDB(service.ReadOnly), p.Config.PaymentMethodID.Int64)
+	method, err := d.paymentService.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
 	if err != nil {
 		log.Error("error retrieving payment method", log15.Ctx{"err": err})
 		return
 	}
-	cfg, err := ConfigByPaymentMethodDB(d.ctx.PaymentDB(service.ReadOnly), method)
+	cfg, err := d.config(method, log)
 	if err != nil {
 		log.Error("error retrieving paypal config", log15.Ctx{"err": err})
 		return
@@ -242,7 +303,7 @@ func (d *Driver) getPayment(p *payment.Payment) {
 		paypalTx.PaymentID = p.ID()
 		paypalTx.Type = TransactionTypeGetPaymentResponse
 
-		err = InsertTransactionDB(d.ctx.PaymentDB(), paypalTx)
+		err = InsertTransactionDB(d.ctx, d.ctx.ProjectPaymentDB(p.ProjectID()), paypalTx)
 		if err != nil {
 			log.Error("error saving paypal transaction", log15.Ctx{"err": err})
 			return err
@@ -256,7 +317,7 @@ func (d *Driver) getPayment(p *payment.Payment) {
 	}
 }
 
-func (d *Driver) InitPayment(p *payment.Payment, method *payment_method.Method) (http.Handler, error) {
+func (d *Driver) InitPayment(p *payment.Payment, method *payment_method.Method, r *http.Request) (http.Handler, error) {
 	log := d.log.New(log15.Ctx{
 		"method":          "InitPayment",
 		"projectID":       p.ProjectID(),
@@ -275,14 +336,14 @@ func (d *Driver) InitPayment(p *payment.Payment, method *payment_method.Method)
 			}
 		}
 	}()
-	tx, err = d.ctx.PaymentDB().Begin()
+	tx, err = d.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
 	if err != nil {
 		commit = true
 		log.Crit("error on begin tx", log15.Ctx{"err": err})
 		return nil, ErrDatabase
 	}
 
-	currentTx, err := TransactionCurrentByPaymentIDTx(tx, p.PaymentID())
+	currentTx, err := TransactionCurrentByPaymentIDTx(d.ctx, tx, p.PaymentID())
 	if err != nil && err != ErrTransactionNotFound {
 		log.Error("error retrieving transaction", log15.Ctx{"err": err})
 		return nil, ErrDatabase
@@ -294,7 +355,7 @@ func (d *Driver) InitPayment(p *payment.Payment, method *payment_method.Method)
 		return d.statusHandler(currentTx, p, d.InitPageHandler(p)), nil
 	}
 
-	cfg, err := ConfigByPaymentMethodTx(tx, method)
+	cfg, err := d.config(method, log)
 	if err != nil {
 		log.Error("error retrieving PayPal config", log15.Ctx{"err": err})
 		return nil, ErrDatabase
@@ -306,7 +367,7 @@ func (d *Driver) InitPayment(p *payment.Payment, method *payment_method.Method)
 		log.Error("error generating nonce", log15.Ctx{"err": err})
 		return nil, ErrInternal
 	}
-	req, err := d.createPaypalPaymentRequest(p, cfg, non)
+	req, err := d.createPaypalPaymentRequest(p, cfg, non, r)
 	if err != nil {
 		log.Error("error creating paypal payment request", log15.Ctx{"err": err})
 		return nil, ErrInternal
@@ -338,7 +399,7 @@ func (d *Driver) InitPayment(p *payment.Payment, method *payment_method.Method)
 	paypalTx.SetNonce(non.Nonce)
 	paypalTx.Data = jsonBytes
 
-	err = InsertTransactionTx(tx, paypalTx)
+	err = InsertTransactionTx(d.ctx, tx, paypalTx)
 	if err != nil {
 		log.Error("error saving transaction", log15.Ctx{"err": err})
 		return nil, ErrDatabase
@@ -427,13 +488,13 @@ func (d *Driver) doInit(cfg *Config, reqURL *url.URL, p *payment.Payment, body s
 				}
 			}
 		}()
-		tx, err = d.ctx.PaymentDB().Begin()
+		tx, err = d.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
 		if err != nil {
 			log.Crit("error on creating db tx", log15.Ctx{"err": err})
 			d.setPayPalError(p, respBody)
 			return ErrDatabase
 		}
-		err = InsertTransactionTx(tx, paypalTx)
+		err = InsertTransactionTx(d.ctx, tx, paypalTx)
 		if err != nil {
 			log.Error("error saving paypal response", log15.Ctx{"err": err})
 			d.setPayPalError(p, respBody)
@@ -443,7 +504,7 @@ func (d *Driver) doInit(cfg *Config, reqURL *url.URL, p *payment.Payment, body s
 			log.Error("invalid paypal state received", log15.Ctx{"state": paypalP.State})
 			paypalTx.Type = TransactionTypeError
 			paypalTx.Timestamp = time.Now()
-			err = InsertTransactionTx(tx, paypalTx)
+			err = InsertTransactionTx(d.ctx, tx, paypalTx)
 			if err != nil {
 				log.Error("error saving error state", log15.Ctx{"err": err})
 				d.setPayPalError(p, respBody)
@@ -503,14 +564,14 @@ func (d *Driver) ReturnHandler() http.Handler {
 				}
 			}
 		}()
-		tx, err = d.ctx.PaymentDB().Begin()
+		tx, err = d.ctx.ProjectPaymentDB(paymentID.ProjectID).Begin()
 		if err != nil {
 			commit = true
 			log.Crit("error on begin tx", log15.Ctx{"err": err})
 			d.InternalErrorHandler(nil).ServeHTTP(w, r)
 			return
 		}
-		_, err = TransactionByPaymentIDAndNonceTx(tx, paymentID, nonce)
+		_, err = TransactionByPaymentIDAndNonceTx(d.ctx, tx, paymentID, nonce)
 		if err != nil {
 			if err == ErrTransactionNotFound {
 				log.Info("paypal transaction not found")
@@ -521,7 +582,7 @@ func (d *Driver) ReturnHandler() http.Handler {
 			d.InternalErrorHandler(nil).ServeHTTP(w, r)
 			return
 		}
-		p, err := payment.PaymentByIDTx(tx, paymentID)
+		p, err := payment.PaymentByIDTx(d.ctx, tx, paymentID)
 		if err != nil {
 			if err == payment.ErrPaymentNotFound {
 				log.Info("payment not found", log15.Ctx{"err": err})
@@ -532,7 +593,7 @@ func (d *Driver) ReturnHandler() http.Handler {
 			d.InternalErrorHandler(nil).ServeHTTP(w, r)
 			return
 		}
-		method, err := payment_method.PaymentMethodByIDTx(tx, p.Config.PaymentMethodID.Int64)
+		method, err := payment_method.PaymentMethodByIDTx(d.ctx, tx, p.Config.PaymentMethodID.Int64)
 		if err != nil {
 			log.Error("error retrieving payment method", log15.Ctx{"err": err})
 			d.InternalErrorHandler(p).ServeHTTP(w, r)
@@ -543,13 +604,13 @@ func (d *Driver) ReturnHandler() http.Handler {
 			d.InternalErrorHandler(p).ServeHTTP(w, r)
 			return
 		}
-		cfg, err := ConfigByPaymentMethodTx(tx, method)
+		cfg, err := ConfigByPaymentMethodTx(d.ctx, tx, method)
 		if err != nil {
 			log.Error("error retrieving config", log15.Ctx{"err": err})
 			d.InternalErrorHandler(p).ServeHTTP(w, r)
 			return
 		}
-		currentTx, err := TransactionCurrentByPaymentIDTx(tx, p.PaymentID())
+		currentTx, err := TransactionCurrentByPaymentIDTx(d.ctx, tx, p.PaymentID())
 		if err != nil {
 			log.Error("error retrieving current transaction", log15.Ctx{"err": err})
 			d.InternalErrorHandler(p).ServeHTTP(w, r)
@@ -562,6 +623,13 @@ func (d *Driver) ReturnHandler() http.Handler {
 			d.statusHandler(currentTx, p, d.ReturnPageHandler(p)).ServeHTTP(w, r)
 			return
 		}
+		// the session was not cancelled, if the payment expired on an instance
+		// without this driver
+		if p.Status == payment.PaymentStatusExpired {
+			log.Info("payment expired. skipping execute payment...")
+			d.PaymentStatusHandler(p).ServeHTTP(w, r)
+			return
+		}
 
 		exec := &PayPalPaymentExecution{
 			PayerID: payerID,
@@ -607,7 +675,7 @@ func (d *Driver) ReturnHandler() http.Handler {
 		if currentTx.PaypalID.Valid {
 			currentTx.SetPaypalID(currentTx.PaypalID.String)
 		}
-		err = InsertTransactionTx(tx, execTx)
+		err = InsertTransactionTx(d.ctx, tx, execTx)
 		if err != nil {
 			log.Error("error saving execute payment transaction", log15.Ctx{"err": err})
 			d.InternalErrorHandler(p).ServeHTTP(w, r)
@@ -644,9 +712,9 @@ func (d *Driver) executePayment(cfg *Config, reqURL *url.URL, p *payment.Payment
 
 	switch intent {
 	case IntentSale:
-		paymentTx, commitIntent, err = d.paymentService.IntentPaid(p, 500*time.Millisecond)
+		paymentTx, commitIntent, err = d.paymentService.IntentPaid(d.ctx, p, 500*time.Millisecond)
 	case IntentAuth:
-		paymentTx, commitIntent, err = d.paymentService.IntentAuthorized(p, 500*time.Millisecond)
+		paymentTx, commitIntent, err = d.paymentService.IntentAuthorized(d.ctx, p, 500*time.Millisecond)
 	default:
 		log.Error("invalid intent on execute payment")
 		return
@@ -714,13 +782,13 @@ func (d *Driver) executePayment(cfg *Config, reqURL *url.URL, p *payment.Payment
 				}
 			}
 		}()
-		tx, err = d.ctx.PaymentDB().Begin()
+		tx, err = d.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
 		if err != nil {
 			log.Crit("error on begin tx", log15.Ctx{"err": err})
 			return ErrDatabase
 		}
 
-		err = InsertTransactionTx(tx, paypalTx)
+		err = InsertTransactionTx(d.ctx, tx, paypalTx)
 		if err != nil {
 			log.Error("error saving paypal transaction", log15.Ctx{"err": err})
 			d.setPayPalError(p, respBody)
@@ -742,12 +810,24 @@ func (d *Driver) executePayment(cfg *Config, reqURL *url.URL, p *payment.Payment
 				d.setPayPalError(p, respBody)
 				return ErrInternal
 			}
-			err = InsertAuthorizationTx(tx, auth)
+			err = InsertAuthorizationTx(d.ctx, tx, auth)
 			if err != nil {
 				log.Error("error saving authorization", log15.Ctx{"err": err})
 				d.setPayPalError(p, respBody)
 				return ErrDatabase
 			}
+			authorized, err := amountUnits(p, auth.Amount)
+			if err != nil {
+				log.Error("error on authorized amount", log15.Ctx{"err": err})
+				d.setPayPalError(p, respBody)
+				return ErrInternal
+			}
+			err = d.paymentService.SetPaymentAuthorization(tx, p, authorized)
+			if err != nil {
+				log.Error("error saving payment authorization", log15.Ctx{"err": err})
+				d.setPayPalError(p, respBody)
+				return ErrDatabase
+			}
 		}
 
 		commit = true
@@ -756,7 +836,10 @@ func (d *Driver) executePayment(cfg *Config, reqURL *url.URL, p *payment.Payment
 			log.Crit("error on commit", log15.Ctx{"err": err})
 			return ErrDatabase
 		}
-		commitIntent()
+		err = commitIntent()
+		if err != nil {
+			log.Error("error committing intent", log15.Ctx{"err": err})
+		}
 
 		return nil
 	}
@@ -801,7 +884,7 @@ func (d *Driver) CancelHandler() http.Handler {
 				}
 			}
 		}()
-		tx, err = d.ctx.PaymentDB().Begin()
+		tx, err = d.ctx.ProjectPaymentDB(paymentID.ProjectID).Begin()
 		if err != nil {
 			commit = true
 			log.Crit("error on begin tx", log15.Ctx{"err": err})
@@ -809,7 +892,7 @@ func (d *Driver) CancelHandler() http.Handler {
 			return
 		}
 
-		_, err = TransactionByPaymentIDAndNonceTx(tx, paymentID, nonce)
+		_, err = TransactionByPaymentIDAndNonceTx(d.ctx, tx, paymentID, nonce)
 		if err != nil {
 			if err == ErrTransactionNotFound {
 				log.Info("paypal transaction not found")
@@ -820,7 +903,7 @@ func (d *Driver) CancelHandler() http.Handler {
 			d.InternalErrorHandler(nil).ServeHTTP(w, r)
 			return
 		}
-		p, err := payment.PaymentByIDTx(tx, paymentID)
+		p, err := payment.PaymentByIDTx(d.ctx, tx, paymentID)
 		if err != nil {
 			if err == payment.ErrPaymentNotFound {
 				log.Info("payment not found", log15.Ctx{"err": err})
@@ -838,7 +921,7 @@ func (d *Driver) CancelHandler() http.Handler {
 			if Debug {
 				log.Debug("intent cancel")
 			}
-			paymentTx, commitIntent, err = d.paymentService.IntentCancel(p, 500*time.Millisecond)
+			paymentTx, commitIntent, err = d.paymentService.IntentCancel(d.ctx, p, 500*time.Millisecond)
 			if err != nil {
 				log.Error("error on intent payment cancel", log15.Ctx{"err": err})
 				d.PaymentErrorHandler(p).ServeHTTP(w, r)
@@ -865,7 +948,10 @@ func (d *Driver) CancelHandler() http.Handler {
 			if Debug {
 				log.Debug("intent commit", log15.Ctx{"commitIntent": commitIntent})
 			}
-			commitIntent()
+			err = commitIntent()
+			if err != nil {
+				log.Error("error committing intent", log15.Ctx{"err": err})
+			}
 		}
 
 		d.CancelPageHandler(p).ServeHTTP(w, r)
@@ -0,0 +1,158 @@
+package paypal_rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"code.google.com/p/goauth2/oauth"
+	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/testutil"
+	"github.com/fritzpay/paymentd/pkg/testutil/vcr"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	sandboxEndpoint = "https://api.sandbox.paypal.com"
+	// sandbox credentials are read from the environment when recording
+	envVarClientID = "PAYPAL_CLIENT_ID"
+	envVarSecret   = "PAYPAL_SECRET"
+)
+
+// sandboxConfig returns the PayPal config for the recorder
+//
+// When recording, the credentials will be replaced by placeholders in the
+// cassette.
+func sandboxConfig(rec *vcr.Recorder) *Config {
+	cfg := &Config{
+		MethodKey: "sandbox",
+		Endpoint:  sandboxEndpoint,
+		ClientID:  "CLIENT_ID",
+		Secret:    "SECRET",
+		Type:      "sandbox",
+	}
+	if rec.Mode() == vcr.ModeRecord {
+		cfg.ClientID, cfg.Secret = os.Getenv(envVarClientID), os.Getenv(envVarSecret)
+		rec.Redact(cfg.ClientID, "CLIENT_ID")
+		rec.Redact(cfg.Secret, "SECRET")
+	}
+	return cfg
+}
+
+// doPayPal performs the PayPal API request with the driver and decodes the
+// response into the PayPal payment
+func doPayPal(d *Driver, p *payment.Payment, cfg *Config, url string, body interface{}, paypalP *PaypalPayment) (int, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var statusCode int
+	err = httpDo(d.ctx, d.oAuthTransportFunc(p, cfg), req, func(resp *http.Response, err error) error {
+		if err != nil {
+			return err
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		statusCode = resp.StatusCode
+		return json.Unmarshal(respBody, paypalP)
+	})
+	return statusCode, err
+}
+
+func TestAuthorizePayment(t *testing.T) {
+	rec, err := vcr.New("testdata/authorize.json", vcr.ModeFromEnv(), nil)
+	if err != nil {
+		t.Fatalf("error loading cassette: %v", err)
+	}
+	defer func() {
+		if err := rec.Stop(); err != nil {
+			t.Errorf("error saving cassette: %v", err)
+		}
+	}()
+	Convey("Given a driver replaying the PayPal sandbox", t, testutil.WithContext(func(ctx *service.Context, logs <-chan *log15.Record) {
+		rounding, err := currency.NewRoundingPolicy("halfUp", nil)
+		So(err, ShouldBeNil)
+		d := &Driver{
+			ctx:      ctx,
+			log:      ctx.Log(),
+			rounding: rounding,
+			oauth:    NewOAuthTransportStore(),
+		}
+		cfg := sandboxConfig(rec)
+		p := &payment.Payment{
+			Amount:   1000,
+			Subunits: 2,
+			Currency: "EUR",
+		}
+		d.oauth.PutTransport(p.ProjectID(), cfg.MethodKey, &oauth.Transport{
+			Config: &oauth.Config{
+				ClientId:     cfg.ClientID,
+				ClientSecret: cfg.Secret,
+				TokenURL:     sandboxEndpoint + paypalTokenPath,
+				TokenCache:   NewTokenCache(),
+			},
+			Transport: rec,
+		})
+
+		Convey("When authorizing a payment", func() {
+			paypalReq := &PayPalPaymentRequest{
+				Intent: "authorize",
+				Payer: PaypalPayer{
+					PaymentMethod: PayPalPaymentMethodPayPal,
+				},
+				Transactions: []PayPalTransaction{
+					{
+						Custom:        "1-1",
+						InvoiceNumber: "1-1",
+						Amount:        d.payPalAmount(p.PaymentID(), p.Decimal(), p.Currency),
+					},
+				},
+				RedirectURLs: PayPalRedirectURLs{
+					ReturnURL: "https://example.com/paypal/return?nonce=n0nce",
+					CancelURL: "https://example.com/paypal/cancel?nonce=n0nce",
+				},
+			}
+			created := &PaypalPayment{}
+			status, err := doPayPal(d, p, cfg, sandboxEndpoint+paypalPaymentPath, paypalReq, created)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusCreated)
+
+			paypalTx, err := NewPayPalPaymentTransaction(created)
+			So(err, ShouldBeNil)
+			So(paypalTx.PaypalID.String, ShouldEqual, "PAY-6RV70583SB702805EKEYSZ6Y")
+			So(paypalTx.PaypalState.String, ShouldEqual, "created")
+			links, err := paypalTx.PayPalLinks()
+			So(err, ShouldBeNil)
+			So(links["approval_url"], ShouldNotBeNil)
+			So(links["execute"], ShouldNotBeNil)
+
+			executed := &PaypalPayment{}
+			status, err = doPayPal(d, p, cfg, links["execute"].HRef, &PayPalPaymentExecution{PayerID: "7E7MGXCWTTKK2"}, executed)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusOK)
+			So(executed.State, ShouldEqual, "approved")
+
+			auth, err := NewPayPalPaymentAuthorization(p, executed)
+			So(err, ShouldBeNil)
+			So(auth.AuthorizationID, ShouldEqual, "2DC87612EK520411B")
+			So(auth.State, ShouldEqual, "authorized")
+			units, err := amountUnits(p, auth.Amount)
+			So(err, ShouldBeNil)
+			So(units, ShouldEqual, p.Amount)
+		})
+	}))
+}
@@ -0,0 +1,51 @@
+package paypal_rest
+
+import (
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// CancelSession ends the approval session of an expired payment
+//
+// It implements the payment.SessionCanceller. PayPal payments which were
+// created but not approved cannot be cancelled with PayPal, they expire with
+// PayPal on their own. The session is ended locally instead: The customer will
+// not be redirected to the approval anymore and a returning customer will not
+// be charged.
+func (d *Driver) CancelSession(ctx context.Context, p *payment.Payment) error {
+	log := d.log.New(log15.Ctx{
+		"method":    "CancelSession",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	currentTx, err := TransactionCurrentByPaymentIDDB(ctx, d.ctx.ProjectPaymentDB(p.ProjectID()), p.PaymentID())
+	if err != nil {
+		if err == ErrTransactionNotFound {
+			return nil
+		}
+		log.Error("error retrieving current transaction", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	// no approval pending
+	if currentTx.Type != TransactionTypeCreatePaymentResponse {
+		return nil
+	}
+	paypalTx := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeCancelSession,
+		Intent:    currentTx.Intent,
+		PaypalID:  currentTx.PaypalID,
+	}
+	err = InsertTransactionDB(ctx, d.ctx.ProjectPaymentDB(p.ProjectID()), paypalTx)
+	if err != nil {
+		log.Error("error saving paypal transaction", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	log.Info("approval session cancelled")
+	return nil
+}
@@ -4,29 +4,17 @@ import (
 	"fmt"
 	"html/template"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"path"
 	"strings"
 	"time"
 
-	tmpl "github.com/fritzpay/paymentd/pkg/template"
-
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
-func (d *Driver) getTemplate(t *template.Template, tmplDir, locale, baseName string) (err error) {
-	tmplFile, err := tmpl.TemplateFileName(tmplDir, locale, defaultLocale, baseName)
-	if err != nil {
-		return err
-	}
-	tmplB, err := ioutil.ReadFile(tmplFile)
-	if err != nil {
-		return err
-	}
-	tmplLocale := path.Base(path.Ext(tmplFile))
-	t.Funcs(template.FuncMap(map[string]interface{}{
+// templateFuncs returns the driver specific template functions
+func (d *Driver) templateFuncs() map[string]interface{} {
+	return map[string]interface{}{
 		"staticPath": func() (string, error) {
 			url, err := d.mux.Get("staticHandler").URLPath()
 			if err != nil {
@@ -34,15 +22,8 @@ func (d *Driver) getTemplate(t *template.Template, tmplDir, locale, baseName str
 			}
 			return url.Path, nil
 		},
-		"locale": func() string {
-			return tmplLocale
-		},
-	}))
-	_, err = t.Parse(string(tmplB))
-	if err != nil {
-		return err
+		"asset": d.assets.Path,
 	}
-	return nil
 }
 
 func (d *Driver) templatePaymentData(p *payment.Payment) map[string]interface{} {
@@ -50,7 +31,7 @@ func (d *Driver) templatePaymentData(p *payment.Payment) map[string]interface{}
 	if p != nil {
 		tmplData["payment"] = p
 		tmplData["paymentID"] = d.paymentService.EncodedPaymentID(p.PaymentID())
-		tmplData["amount"] = p.DecimalRound(2)
+		tmplData["amount"], _ = d.rounding.Round(p.Decimal(), p.Currency)
 	}
 	tmplData["timestamp"] = time.Now().Unix()
 	return tmplData
@@ -78,8 +59,7 @@ func (d *Driver) InitPageHandler(p *payment.Payment) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := d.log.New(log15.Ctx{"method": "InitPageHandler"})
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl := template.New("init")
-		err := d.getTemplate(tmpl, d.tmplDir, p.Config.Locale.String, baseName)
+		tmpl, err := d.templates.Template(p.Config.Locale.String, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -110,8 +90,7 @@ func (d *Driver) InternalErrorHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("internal_error")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.templates.Template(locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -141,8 +120,7 @@ func (d *Driver) NotFoundHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("not_found")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.templates.Template(locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -159,9 +137,8 @@ func (d *Driver) CancelPageHandler(p *payment.Payment) http.Handler {
 			"paymentID": p.PaymentID(),
 		})
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl := template.New("cancel")
 		const baseName = "cancel.html.tmpl"
-		err := d.getTemplate(tmpl, d.tmplDir, p.Config.Locale.String, baseName)
+		tmpl, err := d.templates.Template(p.Config.Locale.String, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -183,9 +160,8 @@ func (d *Driver) ReturnPageHandler(p *payment.Payment) http.Handler {
 			"paymentID": p.PaymentID(),
 		})
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl := template.New("return")
 		const baseName = "return.html.tmpl"
-		err := d.getTemplate(tmpl, d.tmplDir, p.Config.Locale.String, baseName)
+		tmpl, err := d.templates.Template(p.Config.Locale.String, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -209,8 +185,7 @@ func (d *Driver) SuccessHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("success")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.templates.Template(locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -244,7 +219,7 @@ func (d *Driver) ApprovalHandler(tx *Transaction, p *payment.Payment) http.Handl
 
 func (d *Driver) PaymentStatusHandler(p *payment.Payment) http.Handler {
 	switch p.Status {
-	case payment.PaymentStatusCancelled:
+	case payment.PaymentStatusCancelled, payment.PaymentStatusExpired:
 		return d.CancelPageHandler(p)
 	case payment.PaymentStatusPaid, payment.PaymentStatusAuthorized:
 		return d.SuccessHandler(p)
@@ -272,7 +247,11 @@ func (d *Driver) statusHandler(tx *Transaction, p *payment.Payment, defaultHandl
 			d.PaymentStatusHandler(p).ServeHTTP(w, r)
 		case TransactionTypeError:
 			d.PaymentErrorHandler(p).ServeHTTP(w, r)
-		case TransactionTypeGetPaymentResponse, TransactionTypeExecutePaymentResponse:
+		case TransactionTypeGetPaymentResponse, TransactionTypeExecutePaymentResponse,
+			TransactionTypeCapture, TransactionTypeCaptureResponse,
+			TransactionTypeReauthorize, TransactionTypeReauthorizeResponse,
+			TransactionTypeVoid, TransactionTypeVoidResponse,
+			TransactionTypeCancelSession:
 			d.PaymentStatusHandler(p).ServeHTTP(w, r)
 		default:
 			defaultHandler.ServeHTTP(w, r)
@@ -6,12 +6,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"time"
 
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/billing"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/descriptor"
 	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
@@ -28,6 +34,10 @@ const (
 	PayPalPaymentMethodCC                         = "credit_card"
 )
 
+// payPalTaxIDTypeCPF is the tax ID type of brazilian individual taxpayer
+// numbers
+const payPalTaxIDTypeCPF = "BR_CPF"
+
 const (
 	IntentSale = "sale"
 	IntentAuth = "authorize"
@@ -42,8 +52,21 @@ const (
 	TransactionTypeExecutePaymentResponse = "executePaymentResponse"
 	TransactionTypeGetPayment             = "getPayment"
 	TransactionTypeGetPaymentResponse     = "getPaymentResponse"
+	TransactionTypeCapture                = "capture"
+	TransactionTypeCaptureResponse        = "captureResponse"
+	TransactionTypeReauthorize            = "reauthorize"
+	TransactionTypeReauthorizeResponse    = "reauthorizeResponse"
+	TransactionTypeVoid                   = "void"
+	TransactionTypeVoidResponse           = "voidResponse"
+	// the approval session of an expired payment was ended locally
+	TransactionTypeCancelSession = "cancelSession"
 )
 
+// descriptorRules are the rules of PayPal soft descriptors
+var descriptorRules = descriptor.Rules{
+	MaxLen: descriptor.MaxLen,
+}
+
 var (
 	ErrNoLinks           = errors.New("no links")
 	ErrPayPalPaymentNoID = errors.New("paypal payment withoud ID")
@@ -62,17 +85,18 @@ type PayPalError struct {
 type PaypalPayer struct {
 	PaymentMethod PayPalPaymentMethod `json:"payment_method"`
 	Status        string              `json:"status,omitempty"`
+	PayerInfo     *PayPalPayerInfo    `json:"payer_info,omitempty"`
 }
 
 type PayPalPayerInfo struct {
-	Email           string                `json:"email,omitempty"`
-	FirstName       string                `json:"first_name,omitempty"`
-	LastName        string                `json:"last_name,omitempty"`
-	PayerID         string                `json:"payer_id,omitempty"`
-	Phone           string                `json:"phone,omitempty"`
-	ShippingAddress PayPalShippingAddress `json:"shipping_address,omitempty"`
-	TaxIDType       string                `json:"tax_id_type,omitempty"`
-	TaxID           string                `json:"tax_id,omitempty"`
+	Email           string                 `json:"email,omitempty"`
+	FirstName       string                 `json:"first_name,omitempty"`
+	LastName        string                 `json:"last_name,omitempty"`
+	PayerID         string                 `json:"payer_id,omitempty"`
+	Phone           string                 `json:"phone,omitempty"`
+	ShippingAddress *PayPalShippingAddress `json:"shipping_address,omitempty"`
+	TaxIDType       string                 `json:"tax_id_type,omitempty"`
+	TaxID           string                 `json:"tax_id,omitempty"`
 }
 
 type PayPalShippingAddress struct {
@@ -181,7 +205,7 @@ type PayPalResource struct {
 	Links                     []PayPalLink `json:"links,omitempty"`
 }
 
-func (d *Driver) createPaypalPaymentRequest(p *payment.Payment, cfg *Config, non *nonce.Nonce) (*PayPalPaymentRequest, error) {
+func (d *Driver) createPaypalPaymentRequest(p *payment.Payment, cfg *Config, non *nonce.Nonce, r *http.Request) (*PayPalPaymentRequest, error) {
 	if cfg.Type != IntentSale && cfg.Type != IntentAuth {
 		return nil, fmt.Errorf("invalid config. type %s not recognized", cfg.Type)
 	}
@@ -189,29 +213,105 @@ func (d *Driver) createPaypalPaymentRequest(p *payment.Payment, cfg *Config, non
 	req := &PayPalPaymentRequest{}
 	req.Intent = cfg.Type
 	req.Payer.PaymentMethod = PayPalPaymentMethodPayPal
-	req.RedirectURLs, err = d.redirectURLs(p, urlSetNonce(non.Nonce))
+	req.RedirectURLs, err = d.redirectURLs(p, r, urlSetNonce(non.Nonce))
 	if err != nil {
 		d.log.Error("error creating redirect urls", log15.Ctx{"err": err})
 		return nil, ErrInternal
 	}
-	req.Transactions = []PayPalTransaction{
-		d.payPalTransactionFromPayment(p),
+	amount := p.Decimal()
+	if cfg.Type == IntentAuth {
+		// authorize the payment amount including the authorization buffer
+		authorized, err := d.paymentService.AuthorizationAmount(p)
+		if err != nil {
+			return nil, err
+		}
+		amount = (&payment.Authorization{Amount: authorized, Subunits: p.Subunits}).Decimal()
+	}
+	t := d.payPalTransactionFromPayment(p, amount)
+	desc, err := d.paymentService.StatementDescriptor(p)
+	if err != nil {
+		return nil, err
+	}
+	if desc != "" {
+		if err = d.CheckDescriptor(desc); err != nil {
+			d.log.Warn("statement descriptor not accepted by provider", log15.Ctx{
+				"err":        err,
+				"descriptor": desc,
+			})
+		} else {
+			t.SoftDescriptor = desc
+		}
+	}
+	req.Transactions = []PayPalTransaction{t}
+	if p.Billing == nil {
+		err = payment.PaymentBillingDB(d.ctx, d.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly), p)
+		if err != nil {
+			d.log.Error("error retrieving payment billing", log15.Ctx{"err": err})
+			return nil, ErrDatabase
+		}
+	}
+	if p.Billing != nil && p.Billing.CPF != "" {
+		req.Payer.PayerInfo = &PayPalPayerInfo{
+			TaxIDType: payPalTaxIDTypeCPF,
+			TaxID:     p.Billing.CPF,
+		}
 	}
 	return req, nil
 }
 
-func (d *Driver) payPalTransactionFromPayment(p *payment.Payment) PayPalTransaction {
+// RequiredBillingFields implements the provider.BillingRequirer
+//
+// PayPal requires the CPF of brazilian customers.
+func (d *Driver) RequiredBillingFields(country string) []string {
+	if country == "BR" {
+		return []string{billing.FieldCPF}
+	}
+	return nil
+}
+
+// CheckDescriptor checks the statement descriptor against the rules of PayPal
+//
+// PayPal soft descriptors are limited to 22 characters.
+func (d *Driver) CheckDescriptor(desc string) error {
+	return descriptorRules.Check(desc)
+}
+
+func (d *Driver) payPalTransactionFromPayment(p *payment.Payment, amount *decimal.Decimal) PayPalTransaction {
 	t := PayPalTransaction{}
 	encPaymentID := d.paymentService.EncodedPaymentID(p.PaymentID())
 	t.Custom = encPaymentID.String()
 	t.InvoiceNumber = encPaymentID.String()
-	t.Amount = PayPalAmount{
-		Currency: p.Currency,
-		Total:    p.DecimalRound(2).String(),
-	}
+	t.Amount = d.payPalAmount(encPaymentID, amount, p.Currency)
 	return t
 }
 
+// payPalAmount rounds the amount according to the rounding policy
+func (d *Driver) payPalAmount(encPaymentID payment.PaymentID, amount *decimal.Decimal, currency string) PayPalAmount {
+	total, exact := d.rounding.Round(amount, currency)
+	if !exact {
+		d.log.Warn("rounding discrepancy", log15.Ctx{
+			"paymentID": encPaymentID.String(),
+			"amount":    amount.String(),
+			"currency":  currency,
+			"rounded":   total.String(),
+		})
+	}
+	return PayPalAmount{
+		Currency: currency,
+		Total:    total.String(),
+	}
+}
+
+// amountUnits converts a PayPal amount into the subunits of the payment
+func amountUnits(p *payment.Payment, total string) (int64, error) {
+	d := dec.NewDecInt64(0)
+	if _, ok := d.SetString(total); !ok {
+		return 0, fmt.Errorf("invalid amount %s", total)
+	}
+	d.Round(d, dec.Scale(p.Subunits), dec.RoundDown)
+	return d.Unscaled().Int64(), nil
+}
+
 type urlModification func(u *url.URL) error
 
 var urlSetNonce = func(nonce string) urlModification {
@@ -223,7 +323,7 @@ var urlSetNonce = func(nonce string) urlModification {
 	})
 }
 
-func (d *Driver) redirectURLs(p *payment.Payment, mods ...urlModification) (PayPalRedirectURLs, error) {
+func (d *Driver) redirectURLs(p *payment.Payment, r *http.Request, mods ...urlModification) (PayPalRedirectURLs, error) {
 	u := PayPalRedirectURLs{}
 	returnRoute, err := d.mux.Get("returnHandler").URLPath()
 	if err != nil {
@@ -237,17 +337,19 @@ func (d *Driver) redirectURLs(p *payment.Payment, mods ...urlModification) (PayP
 	q := url.Values(make(map[string][]string))
 	q.Set(paymentIDParam, d.paymentService.EncodedPaymentID(p.PaymentID()).String())
 
-	returnURL, err := d.baseURL()
+	baseURL, err := d.baseURL(r)
 	if err != nil {
 		return u, err
 	}
-	returnURL.Path = returnRoute.Path
-	returnURL.RawQuery = q.Encode()
-
-	cancelURL, err := d.baseURL()
+	// use the checkout domain of the project if present
+	returnURL, err := d.paymentService.CheckoutURL(p.ProjectID(), baseURL)
 	if err != nil {
 		return u, err
 	}
+	returnURL.Path = returnRoute.Path
+	returnURL.RawQuery = q.Encode()
+
+	cancelURL := *returnURL
 	cancelURL.Path = cancelRoute.Path
 	cancelURL.RawQuery = q.Encode()
 
@@ -256,7 +358,7 @@ func (d *Driver) redirectURLs(p *payment.Payment, mods ...urlModification) (PayP
 		if err != nil {
 			return u, err
 		}
-		err = mod(cancelURL)
+		err = mod(&cancelURL)
 		if err != nil {
 			return u, err
 		}
@@ -278,6 +380,9 @@ type Config struct {
 	ClientID string
 	Secret   string
 	Type     string
+	// WebhookID is the ID of the webhook registered with PayPal. Webhook
+	// events will only be accepted if it is set.
+	WebhookID sql.NullString
 }
 
 // Transaction represents a transaction on a paypal payment
@@ -404,12 +509,6 @@ func NewPayPalPaymentAuthorization(p *payment.Payment, paypalP *PaypalPayment) (
 	if paypalP.ID == "" {
 		return nil, ErrPayPalPaymentNoID
 	}
-	auth := &Authorization{
-		ProjectID: p.ProjectID(),
-		PaymentID: p.ID(),
-		Timestamp: time.Now(),
-		PaypalID:  paypalP.ID,
-	}
 	var authRes *PayPalResource
 	for _, tx := range paypalP.Transactions {
 		authLen := len(tx.RelatedResources.Resources("authorization"))
@@ -425,6 +524,18 @@ func NewPayPalPaymentAuthorization(p *payment.Payment, paypalP *PaypalPayment) (
 	if authRes == nil {
 		return nil, fmt.Errorf("no authorization resource")
 	}
+	return newAuthorization(p, paypalP.ID, authRes)
+}
+
+// newAuthorization creates an authorization entry for the given payment from
+// the PayPal authorization resource
+func newAuthorization(p *payment.Payment, paypalID string, authRes *PayPalResource) (*Authorization, error) {
+	auth := &Authorization{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		PaypalID:  paypalID,
+	}
 	valid, err := time.Parse(time.RFC3339, authRes.ValidUntil)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing validity: %v", err)
@@ -0,0 +1,190 @@
+package paypal_rest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// PayPalReauthorizeRequest is the request to reauthorize an authorization
+type PayPalReauthorizeRequest struct {
+	Amount PayPalAmount `json:"amount"`
+}
+
+// IncrementAuthorization increments the authorization of an authorized payment
+//
+// It implements the provider.Incrementer. PayPal increments authorizations by
+// reauthorizing them for the new total. PayPal limits the reauthorized amount
+// and rejects reauthorizations within the honor period of the authorization.
+// The request to PayPal will be cancelled with the context.
+func (d *Driver) IncrementAuthorization(ctx context.Context, p *payment.Payment, amount *decimal.Decimal) error {
+	log := d.log.New(log15.Ctx{
+		"method":    "IncrementAuthorization",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+		"amount":    amount.String(),
+	})
+	method, err := d.paymentService.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return err
+	}
+	cfg, err := d.config(method, log)
+	if err != nil {
+		log.Error("error retrieving PayPal config", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	auth, err := AuthorizationCurrentByPaymentIDDB(ctx, d.ctx.ProjectPaymentDB(p.ProjectID()), p.PaymentID())
+	if err != nil {
+		if err == ErrAuthorizationNotFound {
+			return err
+		}
+		log.Error("error retrieving authorization", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	// the client gave up
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	increment, err := d.paymentService.AuthorizationIncrement(p, amount)
+	if err != nil {
+		log.Info("authorization increment rejected", log15.Ctx{"err": err})
+		return err
+	}
+	total := (&payment.Authorization{
+		Amount:   p.AuthorizedAmount() + increment,
+		Subunits: p.Subunits,
+	}).Decimal()
+
+	reauth := &PayPalReauthorizeRequest{
+		Amount: d.payPalAmount(d.paymentService.EncodedPaymentID(p.PaymentID()), total, p.Currency),
+	}
+	body, err := json.Marshal(reauth)
+	if err != nil {
+		log.Error("error encoding reauthorize request", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		log.Error("error on endpoint URL", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	endpoint.Path = paypalAuthorizationPath + auth.AuthorizationID + "/reauthorize"
+
+	paypalTx := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeReauthorize,
+	}
+	paypalTx.SetPaypalID(auth.PaypalID)
+	paypalTx.Data = body
+	err = InsertTransactionDB(ctx, d.ctx.ProjectPaymentDB(p.ProjectID()), paypalTx)
+	if err != nil {
+		log.Error("error saving paypal transaction", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+
+	req, err := http.NewRequest("POST", endpoint.String(), strings.NewReader(string(body)))
+	if err != nil {
+		log.Error("error creating reauthorize request", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	req.Header.Set("Content-Type", "application/json")
+	responseFunc := func(resp *http.Response, err error) error {
+		if err != nil {
+			log.Error("error on request", log15.Ctx{"err": err})
+			return err
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Error("error reading response body", log15.Ctx{"err": err})
+			return ErrHTTP
+		}
+		log = log.New(log15.Ctx{"responseBody": string(respBody)})
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			log.Error("invalid HTTP status code", log15.Ctx{"statusCode": resp.StatusCode})
+			return ErrProvider
+		}
+		res := &PayPalResource{}
+		err = json.Unmarshal(respBody, res)
+		if err != nil {
+			log.Error("error decoding response", log15.Ctx{"err": err})
+			return ErrHTTP
+		}
+		newAuth, err := newAuthorization(p, auth.PaypalID, res)
+		if err != nil {
+			log.Error("error creating PayPal authorization", log15.Ctx{"err": err})
+			return ErrProvider
+		}
+		authorized, err := amountUnits(p, newAuth.Amount)
+		if err != nil {
+			log.Error("error on authorized amount", log15.Ctx{"err": err})
+			return ErrProvider
+		}
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = d.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
+		if err != nil {
+			log.Crit("error on begin tx", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+
+		paypalTx := &Transaction{
+			ProjectID: p.ProjectID(),
+			PaymentID: p.ID(),
+			Timestamp: time.Now(),
+			Type:      TransactionTypeReauthorizeResponse,
+		}
+		paypalTx.SetPaypalID(auth.PaypalID)
+		paypalTx.SetState(res.State)
+		paypalTx.Data = respBody
+		err = InsertTransactionTx(ctx, tx, paypalTx)
+		if err != nil {
+			log.Error("error saving paypal transaction", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+		err = InsertAuthorizationTx(ctx, tx, newAuth)
+		if err != nil {
+			log.Error("error saving authorization", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+		err = d.paymentService.SetPaymentAuthorization(tx, p, authorized)
+		if err != nil {
+			log.Error("error saving payment authorization", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+		log.Info("authorization incremented", log15.Ctx{
+			"authorizationID": newAuth.AuthorizationID,
+			"authorized":      newAuth.Amount,
+		})
+		return nil
+	}
+	return httpDoContext(ctx, log, d.oAuthTransportFunc(p, cfg), req, responseFunc)
+}
@@ -8,11 +8,18 @@ import (
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"golang.org/x/net/context"
 )
 
 var (
 	ErrConfigNotFound      = errors.New("config not found")
 	ErrTransactionNotFound = errors.New("transaction not found")
+	// ErrAuthorizationNotFound is returned if a payment has no PayPal
+	// authorization
+	ErrAuthorizationNotFound = errors.New("authorization not found")
+	// ErrEventNotFound is returned if a PayPal webhook event was not received
+	// before
+	ErrEventNotFound = errors.New("event not found")
 )
 
 const selectConfig = `
@@ -24,7 +31,8 @@ SELECT
 	c.endpoint,
 	c.client_id,
 	c.secret,
-	c.type
+	c.type,
+	c.webhook_id
 FROM provider_paypal_config AS c
 `
 const selectConfigByProjectIDAndMethodKey = selectConfig + `
@@ -53,6 +61,7 @@ func scanConfig(row *sql.Row) (*Config, error) {
 		&cfg.ClientID,
 		&cfg.Secret,
 		&cfg.Type,
+		&cfg.WebhookID,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -63,16 +72,44 @@ func scanConfig(row *sql.Row) (*Config, error) {
 	return cfg, nil
 }
 
-func ConfigByPaymentMethodTx(db *sql.Tx, method *payment_method.Method) (*Config, error) {
-	row := db.QueryRow(selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
+func ConfigByPaymentMethodTx(ctx context.Context, db *sql.Tx, method *payment_method.Method) (*Config, error) {
+	row := db.QueryRowContext(ctx, selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
 	return scanConfig(row)
 }
 
-func ConfigByPaymentMethodDB(db *sql.DB, method *payment_method.Method) (*Config, error) {
-	row := db.QueryRow(selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
+func ConfigByPaymentMethodDB(ctx context.Context, db *sql.DB, method *payment_method.Method) (*Config, error) {
+	row := db.QueryRowContext(ctx, selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
 	return scanConfig(row)
 }
 
+const insertConfig = `
+INSERT INTO provider_paypal_config
+(project_id, method_key, created, created_by, endpoint, client_id, secret, type, webhook_id)
+VALUES
+(?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertConfigTx saves a new config version
+func InsertConfigTx(ctx context.Context, db *sql.Tx, cfg *Config) error {
+	stmt, err := db.PrepareContext(ctx, insertConfig)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		cfg.ProjectID,
+		cfg.MethodKey,
+		cfg.Created,
+		cfg.CreatedBy,
+		cfg.Endpoint,
+		cfg.ClientID,
+		cfg.Secret,
+		cfg.Type,
+		cfg.WebhookID,
+	)
+	stmt.Close()
+	return err
+}
+
 const selectTransaction = `
 SELECT
 	t.project_id,
@@ -173,33 +210,48 @@ func scanTransactionRow(row *sql.Row) (*Transaction, error) {
 	return t, nil
 }
 
-func TransactionCurrentByPaymentIDTx(db *sql.Tx, paymentID payment.PaymentID) (*Transaction, error) {
-	row := db.QueryRow(selectTransactionCurrentByPaymentID, paymentID.ProjectID, paymentID.PaymentID)
+func TransactionCurrentByPaymentIDTx(ctx context.Context, db *sql.Tx, paymentID payment.PaymentID) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, selectTransactionCurrentByPaymentID, paymentID.ProjectID, paymentID.PaymentID)
 	return scanTransactionRow(row)
 }
 
-func TransactionCurrentByPaymentIDDB(db *sql.DB, paymentID payment.PaymentID) (*Transaction, error) {
-	row := db.QueryRow(selectTransactionCurrentByPaymentID, paymentID.ProjectID, paymentID.PaymentID)
+func TransactionCurrentByPaymentIDDB(ctx context.Context, db *sql.DB, paymentID payment.PaymentID) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, selectTransactionCurrentByPaymentID, paymentID.ProjectID, paymentID.PaymentID)
 	return scanTransactionRow(row)
 }
 
-func TransactionByPaymentIDAndNonceTx(db *sql.Tx, paymentID payment.PaymentID, nonce string) (*Transaction, error) {
-	row := db.QueryRow(selectTransactionByPaymentIDAndNonce, paymentID.ProjectID, paymentID.PaymentID, nonce)
+func TransactionByPaymentIDAndNonceTx(ctx context.Context, db *sql.Tx, paymentID payment.PaymentID, nonce string) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, selectTransactionByPaymentIDAndNonce, paymentID.ProjectID, paymentID.PaymentID, nonce)
 	return scanTransactionRow(row)
 }
 
-func TransactionByPaymentIDAndNonceDB(db *sql.DB, paymentID payment.PaymentID, nonce string) (*Transaction, error) {
-	row := db.QueryRow(selectTransactionByPaymentIDAndNonce, paymentID.ProjectID, paymentID.PaymentID, nonce)
+func TransactionByPaymentIDAndNonceDB(ctx context.Context, db *sql.DB, paymentID payment.PaymentID, nonce string) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, selectTransactionByPaymentIDAndNonce, paymentID.ProjectID, paymentID.PaymentID, nonce)
+	return scanTransactionRow(row)
+}
+
+const selectTransactionByPaypalID = selectTransaction + `
+FROM provider_paypal_transaction AS t
+WHERE
+	t.paypal_id = ?
+ORDER BY t.timestamp DESC
+LIMIT 1
+`
+
+// TransactionByPaypalIDDB returns the latest transaction with the given PayPal
+// payment ID
+func TransactionByPaypalIDDB(ctx context.Context, db *sql.DB, paypalID string) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, selectTransactionByPaypalID, paypalID)
 	return scanTransactionRow(row)
 }
 
-func TransactionByPaymentIDAndTypeTx(db *sql.Tx, paymentID payment.PaymentID, t string) (*Transaction, error) {
-	row := db.QueryRow(selectTransactionByPaymentIDAndType, paymentID.ProjectID, paymentID.PaymentID, t)
+func TransactionByPaymentIDAndTypeTx(ctx context.Context, db *sql.Tx, paymentID payment.PaymentID, t string) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, selectTransactionByPaymentIDAndType, paymentID.ProjectID, paymentID.PaymentID, t)
 	return scanTransactionRow(row)
 }
 
-func TransactionByPaymentIDAndTypeDB(db *sql.DB, paymentID payment.PaymentID, t string) (*Transaction, error) {
-	row := db.QueryRow(selectTransactionByPaymentIDAndType, paymentID.ProjectID, paymentID.PaymentID, t)
+func TransactionByPaymentIDAndTypeDB(ctx context.Context, db *sql.DB, paymentID payment.PaymentID, t string) (*Transaction, error) {
+	row := db.QueryRowContext(ctx, selectTransactionByPaymentIDAndType, paymentID.ProjectID, paymentID.PaymentID, t)
 	return scanTransactionRow(row)
 }
 
@@ -210,8 +262,8 @@ VALUES
 (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
-func doInsertTransaction(stmt *sql.Stmt, t *Transaction) error {
-	_, err := stmt.Exec(
+func doInsertTransaction(ctx context.Context, stmt *sql.Stmt, t *Transaction) error {
+	_, err := stmt.ExecContext(ctx,
 		t.ProjectID,
 		t.PaymentID,
 		t.Timestamp.UnixNano(),
@@ -230,20 +282,20 @@ func doInsertTransaction(stmt *sql.Stmt, t *Transaction) error {
 	return err
 }
 
-func InsertTransactionTx(db *sql.Tx, t *Transaction) error {
-	stmt, err := db.Prepare(insertTransaction)
+func InsertTransactionTx(ctx context.Context, db *sql.Tx, t *Transaction) error {
+	stmt, err := db.PrepareContext(ctx, insertTransaction)
 	if err != nil {
 		return err
 	}
-	return doInsertTransaction(stmt, t)
+	return doInsertTransaction(ctx, stmt, t)
 }
 
-func InsertTransactionDB(db *sql.DB, t *Transaction) error {
-	stmt, err := db.Prepare(insertTransaction)
+func InsertTransactionDB(ctx context.Context, db *sql.DB, t *Transaction) error {
+	stmt, err := db.PrepareContext(ctx, insertTransaction)
 	if err != nil {
 		return err
 	}
-	return doInsertTransaction(stmt, t)
+	return doInsertTransaction(ctx, stmt, t)
 }
 
 const insertAuthorization = `
@@ -253,12 +305,12 @@ VALUES
 (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
-func InsertAuthorizationTx(db *sql.Tx, auth *Authorization) error {
-	stmt, err := db.Prepare(insertAuthorization)
+func InsertAuthorizationTx(ctx context.Context, db *sql.Tx, auth *Authorization) error {
+	stmt, err := db.PrepareContext(ctx, insertAuthorization)
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(
+	_, err = stmt.ExecContext(ctx,
 		auth.ProjectID,
 		auth.PaymentID,
 		auth.Timestamp.UnixNano(),
@@ -274,3 +326,122 @@ func InsertAuthorizationTx(db *sql.Tx, auth *Authorization) error {
 	stmt.Close()
 	return err
 }
+
+const selectAuthorizationCurrentByPaymentID = `
+SELECT
+	a.project_id,
+	a.payment_id,
+	a.timestamp,
+	a.valid_until,
+	a.state,
+	a.authorization_id,
+	a.paypal_id,
+	a.amount,
+	a.currency,
+	a.links,
+	a.data
+FROM provider_paypal_authorization AS a
+WHERE
+	a.project_id = ?
+	AND
+	a.payment_id = ?
+	AND
+	a.timestamp = (
+		SELECT MAX(timestamp) FROM provider_paypal_authorization
+		WHERE
+			project_id = a.project_id
+			AND
+			payment_id = a.payment_id
+	)
+`
+
+// AuthorizationCurrentByPaymentIDDB returns the current PayPal authorization of
+// the payment
+func AuthorizationCurrentByPaymentIDDB(ctx context.Context, db *sql.DB, paymentID payment.PaymentID) (*Authorization, error) {
+	auth := &Authorization{}
+	var ts int64
+	err := db.QueryRowContext(ctx, selectAuthorizationCurrentByPaymentID, paymentID.ProjectID, paymentID.PaymentID).Scan(
+		&auth.ProjectID,
+		&auth.PaymentID,
+		&ts,
+		&auth.ValidUntil,
+		&auth.State,
+		&auth.AuthorizationID,
+		&auth.PaypalID,
+		&auth.Amount,
+		&auth.Currency,
+		&auth.Links,
+		&auth.Data,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAuthorizationNotFound
+		}
+		return nil, err
+	}
+	auth.Timestamp = time.Unix(0, ts)
+	return auth, nil
+}
+
+const insertEvent = `
+INSERT INTO provider_paypal_event
+(project_id, payment_id, timestamp, event_id, event_type, resource_id, data)
+VALUES
+(?, ?, ?, ?, ?, ?, ?)
+`
+
+// InsertEventTx saves a received webhook event
+func InsertEventTx(ctx context.Context, db *sql.Tx, e *Event) error {
+	stmt, err := db.PrepareContext(ctx, insertEvent)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		e.ProjectID,
+		e.PaymentID,
+		e.Timestamp.UnixNano(),
+		e.EventID,
+		e.EventType,
+		e.ResourceID,
+		e.Data,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectEventByEventID = `
+SELECT
+	project_id,
+	payment_id,
+	timestamp,
+	event_id,
+	event_type,
+	resource_id,
+	data
+FROM provider_paypal_event
+WHERE
+	event_id = ?
+`
+
+// EventByEventIDTx returns the webhook event with the given PayPal event ID
+func EventByEventIDTx(ctx context.Context, db *sql.Tx, eventID string) (*Event, error) {
+	e := &Event{}
+	var ts int64
+	err := db.QueryRowContext(ctx, selectEventByEventID, eventID).Scan(
+		&e.ProjectID,
+		&e.PaymentID,
+		&ts,
+		&e.EventID,
+		&e.EventType,
+		&e.ResourceID,
+		&e.Data,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrEventNotFound
+		}
+		return nil, err
+	}
+	e.Timestamp = time.Unix(0, ts)
+	return e, nil
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/fritzpay/paymentd/pkg/testutil"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
 )
 
 func TestPaypalTransaction(t *testing.T) {
@@ -39,13 +40,13 @@ func TestPaypalTransaction(t *testing.T) {
 						So(pt.PaypalUpdateTime, ShouldBeNil)
 
 						Convey("When inserting the transaction", func() {
-							err = paypal_rest.InsertTransactionTx(tx, pt)
+							err = paypal_rest.InsertTransactionTx(context.Background(), tx, pt)
 
 							Convey("It should succeed", func() {
 								So(err, ShouldBeNil)
 
 								Convey("When retrieving the inserted transaction", func() {
-									ptRet, err := paypal_rest.TransactionCurrentByPaymentIDTx(tx, p.PaymentID())
+									ptRet, err := paypal_rest.TransactionCurrentByPaymentIDTx(context.Background(), tx, p.PaymentID())
 
 									Convey("It should succeed", func() {
 										So(err, ShouldBeNil)
@@ -65,13 +66,13 @@ func TestPaypalTransaction(t *testing.T) {
 						pt.PaypalUpdateTime = &tm
 
 						Convey("When inserting the transaction", func() {
-							err = paypal_rest.InsertTransactionTx(tx, pt)
+							err = paypal_rest.InsertTransactionTx(context.Background(), tx, pt)
 
 							Convey("It should succeed", func() {
 								So(err, ShouldBeNil)
 
 								Convey("When retrieving the inserted transaction", func() {
-									ptRet, err := paypal_rest.TransactionCurrentByPaymentIDTx(tx, p.PaymentID())
+									ptRet, err := paypal_rest.TransactionCurrentByPaymentIDTx(context.Background(), tx, p.PaymentID())
 
 									Convey("It should succeed", func() {
 										So(err, ShouldBeNil)
@@ -0,0 +1,58 @@
+package paypal_rest
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+)
+
+// PayPal payment states
+const (
+	payPalStateApproved = "approved"
+	payPalStateFailed   = "failed"
+)
+
+// ReportedStatus returns the status of the payment as last reported by PayPal
+//
+// It implements the provider.StatusReporter. Only the responses to executing or
+// retrieving the payment are final.
+func (d *Driver) ReportedStatus(db *sql.DB, p *payment.Payment) (payment.PaymentTransactionStatus, bool, error) {
+	t, err := TransactionCurrentByPaymentIDDB(d.ctx, db, p.PaymentID())
+	if err != nil {
+		if err == ErrTransactionNotFound {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if t.Type != TransactionTypeExecutePaymentResponse && t.Type != TransactionTypeGetPaymentResponse {
+		return "", false, nil
+	}
+	switch t.PaypalState.String {
+	case payPalStateApproved:
+		switch t.Intent.String {
+		case IntentSale:
+			return payment.PaymentStatusPaid, true, nil
+		case IntentAuth:
+			return payment.PaymentStatusAuthorized, true, nil
+		}
+	case payPalStateFailed:
+		return payment.PaymentStatusFailed, true, nil
+	}
+	return "", false, nil
+}
+
+// AuthorizationValidUntil returns the expiry of the current PayPal
+// authorization of the payment
+//
+// It implements the provider.AuthorizationExpirer.
+func (d *Driver) AuthorizationValidUntil(db *sql.DB, p *payment.Payment) (time.Time, bool, error) {
+	auth, err := AuthorizationCurrentByPaymentIDDB(d.ctx, db, p.PaymentID())
+	if err != nil {
+		if err == ErrAuthorizationNotFound {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	return auth.ValidUntil, true, nil
+}
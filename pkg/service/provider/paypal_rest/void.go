@@ -0,0 +1,165 @@
+package paypal_rest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// PayPal authorization state of voided authorizations
+const payPalStateVoided = "voided"
+
+// Void voids the authorization of an authorized payment
+//
+// It implements the provider.Voider. PayPal releases the authorized amount.
+// The request to PayPal will be cancelled with the context. Voids confirmed by
+// PayPal will be recorded regardless.
+func (d *Driver) Void(ctx context.Context, p *payment.Payment) error {
+	log := d.log.New(log15.Ctx{
+		"method":    "Void",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	method, err := d.paymentService.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+	if err != nil {
+		log.Error("error retrieving payment method", log15.Ctx{"err": err})
+		return err
+	}
+	cfg, err := d.config(method, log)
+	if err != nil {
+		log.Error("error retrieving PayPal config", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+	auth, err := AuthorizationCurrentByPaymentIDDB(ctx, d.ctx.ProjectPaymentDB(p.ProjectID()), p.PaymentID())
+	if err != nil {
+		if err == ErrAuthorizationNotFound {
+			return err
+		}
+		log.Error("error retrieving authorization", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+
+	// the client gave up
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	paymentTx, commitIntent, err := d.paymentService.IntentVoid(ctx, p, 500*time.Millisecond)
+	if err != nil {
+		log.Info("void intent rejected", log15.Ctx{"err": err})
+		return err
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		log.Error("error on endpoint URL", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	endpoint.Path = paypalAuthorizationPath + auth.AuthorizationID + "/void"
+
+	paypalTx := &Transaction{
+		ProjectID: p.ProjectID(),
+		PaymentID: p.ID(),
+		Timestamp: time.Now(),
+		Type:      TransactionTypeVoid,
+	}
+	paypalTx.SetPaypalID(auth.PaypalID)
+	err = InsertTransactionDB(ctx, d.ctx.ProjectPaymentDB(p.ProjectID()), paypalTx)
+	if err != nil {
+		log.Error("error saving paypal transaction", log15.Ctx{"err": err})
+		return ErrDatabase
+	}
+
+	req, err := http.NewRequest("POST", endpoint.String(), strings.NewReader("{}"))
+	if err != nil {
+		log.Error("error creating void request", log15.Ctx{"err": err})
+		return ErrInternal
+	}
+	req.Header.Set("Content-Type", "application/json")
+	responseFunc := func(resp *http.Response, err error) error {
+		if err != nil {
+			log.Error("error on request", log15.Ctx{"err": err})
+			return err
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Error("error reading response body", log15.Ctx{"err": err})
+			return ErrHTTP
+		}
+		log = log.New(log15.Ctx{"responseBody": string(respBody)})
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			log.Error("invalid HTTP status code", log15.Ctx{"statusCode": resp.StatusCode})
+			return ErrProvider
+		}
+		res := &PayPalResource{}
+		err = json.Unmarshal(respBody, res)
+		if err != nil {
+			log.Error("error decoding response", log15.Ctx{"err": err})
+			return ErrHTTP
+		}
+		if res.State != payPalStateVoided {
+			log.Error("authorization not voided", log15.Ctx{"state": res.State})
+			return ErrProvider
+		}
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		tx, err = d.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
+		if err != nil {
+			log.Crit("error on begin tx", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+
+		paypalTx := &Transaction{
+			ProjectID: p.ProjectID(),
+			PaymentID: p.ID(),
+			Timestamp: time.Now(),
+			Type:      TransactionTypeVoidResponse,
+		}
+		paypalTx.SetPaypalID(auth.PaypalID)
+		paypalTx.SetState(res.State)
+		paypalTx.Data = respBody
+		err = InsertTransactionTx(ctx, tx, paypalTx)
+		if err != nil {
+			log.Error("error saving paypal transaction", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+
+		paymentTx.Comment.String, paymentTx.Comment.Valid = "PayPal AuthorizationID: "+auth.AuthorizationID, true
+		err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
+		if err != nil {
+			log.Error("error on payment transaction", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+
+		commit = true
+		err = tx.Commit()
+		if err != nil {
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			return ErrDatabase
+		}
+		err = commitIntent()
+		if err != nil {
+			log.Error("error committing intent", log15.Ctx{"err": err})
+		}
+		log.Info("authorization voided", log15.Ctx{"authorizationID": auth.AuthorizationID})
+		return nil
+	}
+	return httpDoContext(ctx, log, d.oAuthTransportFunc(p, cfg), req, responseFunc)
+}
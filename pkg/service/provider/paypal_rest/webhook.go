@@ -0,0 +1,602 @@
+package paypal_rest
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// PayPal webhook event types handled by the driver
+const (
+	WebhookEventSaleCompleted = "PAYMENT.SALE.COMPLETED"
+	WebhookEventSaleRefunded  = "PAYMENT.SALE.REFUNDED"
+	WebhookEventSaleDenied    = "PAYMENT.SALE.DENIED"
+)
+
+// PayPal webhook transmission headers
+const (
+	webhookHeaderTransmissionID   = "Paypal-Transmission-Id"
+	webhookHeaderTransmissionTime = "Paypal-Transmission-Time"
+	webhookHeaderTransmissionSig  = "Paypal-Transmission-Sig"
+	webhookHeaderCertURL          = "Paypal-Cert-Url"
+	webhookHeaderAuthAlgo         = "Paypal-Auth-Algo"
+)
+
+const (
+	// webhookAuthAlgo is the only supported signature algorithm
+	webhookAuthAlgo = "SHA256withRSA"
+	// webhookCertHost is the domain the signing certificates must be served
+	// from
+	webhookCertHost = "paypal.com"
+	// maximum number of cached signing certificates
+	webhookMaxCerts = 16
+	// maximum size of a signing certificate
+	webhookMaxCertSize = 64 << 10
+	webhookCertTimeout = 10 * time.Second
+)
+
+var (
+	ErrWebhookSignature = errors.New("invalid webhook signature")
+	ErrWebhookCertURL   = errors.New("invalid webhook certificate URL")
+	ErrWebhookAmount    = errors.New("invalid webhook event amount")
+)
+
+// WebhookEvent represents a webhook event as sent by PayPal
+//
+// See https://developer.paypal.com/docs/api/#webhooks
+type WebhookEvent struct {
+	ID           string         `json:"id"`
+	CreateTime   string         `json:"create_time"`
+	ResourceType string         `json:"resource_type"`
+	EventType    string         `json:"event_type"`
+	Summary      string         `json:"summary"`
+	Resource     PayPalResource `json:"resource"`
+}
+
+// Handled returns true if the driver translates the event into a payment
+// intent
+func (e *WebhookEvent) Handled() bool {
+	switch e.EventType {
+	case WebhookEventSaleCompleted, WebhookEventSaleRefunded, WebhookEventSaleDenied:
+		return true
+	default:
+		return disputeEvent(e.EventType)
+	}
+}
+
+// Event is the record of a received PayPal webhook event
+type Event struct {
+	ProjectID  int64
+	PaymentID  int64
+	Timestamp  time.Time
+	EventID    string
+	EventType  string
+	ResourceID string
+	Data       []byte
+}
+
+// WebhookSignature is the signature of a webhook transmission
+type WebhookSignature struct {
+	TransmissionID   string
+	TransmissionTime string
+	CertURL          string
+	AuthAlgo         string
+	Signature        []byte
+}
+
+// WebhookSignatureFromRequest reads the signature from the transmission
+// headers of the webhook request
+func WebhookSignatureFromRequest(r *http.Request) (*WebhookSignature, error) {
+	sig := &WebhookSignature{
+		TransmissionID:   r.Header.Get(webhookHeaderTransmissionID),
+		TransmissionTime: r.Header.Get(webhookHeaderTransmissionTime),
+		CertURL:          r.Header.Get(webhookHeaderCertURL),
+		AuthAlgo:         r.Header.Get(webhookHeaderAuthAlgo),
+	}
+	if sig.TransmissionID == "" || sig.TransmissionTime == "" || sig.CertURL == "" {
+		return nil, ErrWebhookSignature
+	}
+	if sig.AuthAlgo != webhookAuthAlgo {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", sig.AuthAlgo)
+	}
+	var err error
+	sig.Signature, err = base64.StdEncoding.DecodeString(r.Header.Get(webhookHeaderTransmissionSig))
+	if err != nil || len(sig.Signature) == 0 {
+		return nil, ErrWebhookSignature
+	}
+	return sig, nil
+}
+
+// Message returns the signed message of the transmission
+//
+// The message is the concatenation of the transmission ID, the transmission
+// time, the webhook ID and the CRC32 checksum of the body, separated by pipes.
+func (s *WebhookSignature) Message(webhookID string, body []byte) []byte {
+	return []byte(strings.Join([]string{
+		s.TransmissionID,
+		s.TransmissionTime,
+		webhookID,
+		strconv.FormatUint(uint64(crc32.ChecksumIEEE(body)), 10),
+	}, "|"))
+}
+
+// Verify verifies the signature of the webhook body with the given signing
+// certificate
+func (s *WebhookSignature) Verify(cert *x509.Certificate, webhookID string, body []byte) error {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported public key %T", cert.PublicKey)
+	}
+	h := sha256.Sum256(s.Message(webhookID, body))
+	if rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], s.Signature) != nil {
+		return ErrWebhookSignature
+	}
+	return nil
+}
+
+// validCertURL returns true if the certificate URL points to PayPal
+func validCertURL(certURL string) bool {
+	u, err := url.Parse(certURL)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	return strings.HasSuffix(strings.ToLower(u.Host), "."+webhookCertHost)
+}
+
+// certStore retrieves and caches the certificates PayPal signs webhooks with
+type certStore struct {
+	cl *http.Client
+
+	m     sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+func newCertStore(tr http.RoundTripper) *certStore {
+	return &certStore{
+		cl: &http.Client{
+			Transport: tr,
+			Timeout:   webhookCertTimeout,
+		},
+		certs: make(map[string]*x509.Certificate),
+	}
+}
+
+// Certificate returns the verified signing certificate at the given URL
+func (c *certStore) Certificate(certURL string) (*x509.Certificate, error) {
+	if !validCertURL(certURL) {
+		return nil, ErrWebhookCertURL
+	}
+	c.m.Lock()
+	cert := c.certs[certURL]
+	c.m.Unlock()
+	if cert != nil && time.Now().Before(cert.NotAfter) {
+		return cert, nil
+	}
+	cert, err := c.fetch(certURL)
+	if err != nil {
+		return nil, err
+	}
+	c.m.Lock()
+	if len(c.certs) >= webhookMaxCerts {
+		c.certs = make(map[string]*x509.Certificate)
+	}
+	c.certs[certURL] = cert
+	c.m.Unlock()
+	return cert, nil
+}
+
+func (c *certStore) fetch(certURL string) (*x509.Certificate, error) {
+	resp, err := c.cl.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error retrieving certificate: HTTP status %d", resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, webhookMaxCertSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseCertChain(b)
+}
+
+// parseCertChain parses the PEM encoded certificate chain and verifies the
+// leaf certificate against the system roots
+func parseCertChain(b []byte) (*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{Intermediates: intermediates})
+	if err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+const (
+	// maximum size of a webhook event
+	webhookMaxBodySize   = 1 << 20
+	webhookIntentTimeout = 500 * time.Millisecond
+)
+
+// WebhookHandler receives the webhook events of PayPal
+//
+// Events of payments created by the driver will be verified with the webhook
+// ID of the PayPal config of the payment method, saved and translated into
+// payment intents. Sales which completed will be paid, denied sales failed and
+// refunded sales refunded by the refunded amount.
+//
+// The events of a payment are applied one at a time. Events which would
+// regress the status of the payment are ignored. Refunds of payments which were
+// not paid yet are held until the completion of the sale arrives, or answered
+// with HTTP status 503 Service Unavailable after the hold timeout, so that
+// PayPal resends them.
+//
+// Customer disputes are recorded as chargebacks of the payment whose ID is the
+// invoice number of the disputed transaction.
+//
+// It answers with HTTP status 200 OK once the event was processed or if it
+// cannot be processed at all, so that PayPal does not resend it. Events which
+// reference unknown payments or cannot be verified due to a missing webhook ID
+// will be rejected to the dead-letter queue.
+func (d *Driver) WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := d.log.New(log15.Ctx{"method": "WebhookHandler"})
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, webhookMaxBodySize))
+		r.Body.Close()
+		if err != nil {
+			log.Error("error reading webhook", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		e := &WebhookEvent{}
+		err = json.Unmarshal(body, e)
+		if err != nil || e.ID == "" {
+			log.Warn("invalid webhook event", log15.Ctx{"err": err})
+			d.ctx.DeadLetters().Reject(r, "invalid event")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"eventID":   e.ID,
+			"eventType": e.EventType,
+		})
+		if Debug {
+			log.Debug("received webhook event", log15.Ctx{"body": string(body)})
+		}
+		var paymentID payment.PaymentID
+		var dispute *PayPalDispute
+		if disputeEvent(e.EventType) {
+			// disputes reference the sale, whose invoice number is the payment ID
+			dispute, err = webhookDispute(body)
+			if err == nil {
+				paymentID, err = d.disputePaymentID(dispute)
+			}
+			if err != nil {
+				log.Warn("dispute without payment", log15.Ctx{"err": err})
+				d.ctx.DeadLetters().Reject(r, "dispute without payment")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log = log.New(log15.Ctx{"disputeID": dispute.DisputeID})
+		} else {
+			if e.Resource.ParentPayment == "" {
+				log.Info("ignoring event without PayPal payment")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log = log.New(log15.Ctx{"paypalID": e.Resource.ParentPayment})
+
+			paypalTx, err := d.transactionByPaypalID(e.Resource.ParentPayment)
+			if err != nil {
+				if err == ErrTransactionNotFound {
+					log.Warn("event of unknown PayPal payment")
+					d.ctx.DeadLetters().Reject(r, "unknown paypal payment")
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				log.Error("error retrieving paypal transaction", log15.Ctx{"err": err})
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			paymentID = payment.PaymentID{ProjectID: paypalTx.ProjectID, PaymentID: paypalTx.PaymentID}
+		}
+		p, err := payment.PaymentByIDDB(d.ctx, d.ctx.ProjectPaymentDB(paymentID.ProjectID), paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				log.Warn("event of unknown payment")
+				d.ctx.DeadLetters().Reject(r, "unknown payment")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+		})
+		method, err := d.paymentService.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+		if err != nil {
+			log.Error("error retrieving payment method", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		cfg, err := d.config(method, log)
+		if err != nil {
+			log.Error("error retrieving PayPal config", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !cfg.WebhookID.Valid || cfg.WebhookID.String == "" {
+			log.Warn("no webhook ID in the PayPal config", log15.Ctx{"methodKey": method.MethodKey})
+			d.ctx.DeadLetters().Reject(r, "no webhook id configured for method "+method.MethodKey)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		sig, err := WebhookSignatureFromRequest(r)
+		if err != nil {
+			log.Warn("invalid webhook signature", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cert, err := d.certs.Certificate(sig.CertURL)
+		if err != nil {
+			log.Error("error retrieving signing certificate", log15.Ctx{
+				"err":     err,
+				"certURL": sig.CertURL,
+			})
+			if err == ErrWebhookCertURL {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = sig.Verify(cert, cfg.WebhookID.String, body)
+		if err != nil {
+			log.Warn("webhook signature mismatch", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// disputes are serialized by the chargeback, which locks the payment
+		if dispute != nil {
+			err = d.processDisputeEvent(p, e, dispute, body, log)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		seq := d.webhooks.enter(paymentID)
+		defer d.webhooks.leave(paymentID)
+		hold := time.NewTimer(webhookHoldTimeout)
+		defer hold.Stop()
+		for {
+			seq.Lock()
+			applied := seq.applied()
+			action, err := d.processWebhookEvent(paymentID, e, body, log)
+			if err == nil && action == webhookApply {
+				seq.notify()
+			}
+			seq.Unlock()
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if action != webhookHold {
+				break
+			}
+			if Debug {
+				log.Debug("holding event until an earlier event of the payment was applied")
+			}
+			select {
+			case <-applied:
+			case <-hold.C:
+				log.Warn("earlier event of the payment not received, waiting for redelivery")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// transactionByPaypalID returns the PayPal transaction with the given PayPal
+// payment ID
+//
+// Webhook events do not reference the project, so all shards are searched.
+func (d *Driver) transactionByPaypalID(paypalID string) (*Transaction, error) {
+	for _, sh := range d.ctx.PaymentShardDBs(service.ReadOnly) {
+		t, err := TransactionByPaypalIDDB(d.ctx, sh.DB, paypalID)
+		if err == ErrTransactionNotFound {
+			continue
+		}
+		return t, err
+	}
+	return nil, ErrTransactionNotFound
+}
+
+// processWebhookEvent saves the webhook event and applies it to its payment
+//
+// Held events are not saved, so that they will be processed again. Errors are
+// logged.
+func (d *Driver) processWebhookEvent(paymentID payment.PaymentID, e *WebhookEvent, body []byte, log log15.Logger) (webhookAction, error) {
+	var tx *sql.Tx
+	var commit bool
+	var err error
+	defer func() {
+		if tx != nil && !commit {
+			err := tx.Rollback()
+			if err != nil {
+				log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = d.ctx.ProjectPaymentDB(paymentID.ProjectID).Begin()
+	if err != nil {
+		log.Crit("error on begin tx", log15.Ctx{"err": err})
+		return webhookIgnore, err
+	}
+	_, err = EventByEventIDTx(d.ctx, tx, e.ID)
+	if err == nil {
+		if Debug {
+			log.Debug("event already processed")
+		}
+		return webhookIgnore, nil
+	}
+	if err != ErrEventNotFound {
+		log.Error("error retrieving event", log15.Ctx{"err": err})
+		return webhookIgnore, err
+	}
+	// the status of the payment might have been changed by an earlier event
+	p, err := payment.PaymentByIDTx(d.ctx, tx, paymentID)
+	if err != nil {
+		log.Error("error retrieving payment", log15.Ctx{"err": err})
+		return webhookIgnore, err
+	}
+	action := webhookEventAction(p.Status, e.EventType)
+	if action == webhookHold {
+		return action, nil
+	}
+	err = InsertEventTx(d.ctx, tx, &Event{
+		ProjectID:  p.ProjectID(),
+		PaymentID:  p.ID(),
+		Timestamp:  time.Now(),
+		EventID:    e.ID,
+		EventType:  e.EventType,
+		ResourceID: e.Resource.ID,
+		Data:       body,
+	})
+	if err != nil {
+		log.Error("error saving event", log15.Ctx{"err": err})
+		return webhookIgnore, err
+	}
+	var paymentTx *payment.PaymentTransaction
+	var commitIntent paymentService.CommitIntentFunc
+	if action == webhookApply {
+		paymentTx, commitIntent, err = d.webhookIntent(p, e)
+		if err != nil {
+			switch {
+			case errors.Is(err, paymentService.ErrIntentNotAllowed):
+				log.Warn("event intent not allowed", log15.Ctx{"status": p.Status})
+				action, paymentTx = webhookIgnore, nil
+			case err == ErrWebhookAmount:
+				log.Warn("invalid event amount", log15.Ctx{"amount": e.Resource.Amount})
+				action, paymentTx = webhookIgnore, nil
+			default:
+				log.Error("error on event intent", log15.Ctx{"err": err})
+				return webhookIgnore, err
+			}
+		}
+	} else if Debug {
+		log.Debug("ignoring stale event", log15.Ctx{"status": p.Status})
+	}
+	if paymentTx != nil {
+		paymentTx.Comment.String, paymentTx.Comment.Valid = "PayPal event: "+e.ID, true
+		err = d.paymentService.SetPaymentTransaction(tx, paymentTx)
+		if err != nil {
+			log.Error("error on payment transaction", log15.Ctx{"err": err})
+			return webhookIgnore, err
+		}
+	}
+
+	commit = true
+	err = tx.Commit()
+	if err != nil {
+		log.Crit("error on commit", log15.Ctx{"err": err})
+		return webhookIgnore, err
+	}
+	if commitIntent != nil && paymentTx != nil {
+		err = commitIntent()
+		if err != nil {
+			log.Error("error committing intent", log15.Ctx{"err": err})
+		}
+	}
+	return action, nil
+}
+
+// webhookIntent translates the webhook event into an intent on the payment
+//
+// It returns a nil transaction if the event does not change the payment.
+func (d *Driver) webhookIntent(p *payment.Payment, e *WebhookEvent) (*payment.PaymentTransaction, paymentService.CommitIntentFunc, error) {
+	switch e.EventType {
+	case WebhookEventSaleCompleted:
+		return d.paymentService.IntentPaid(d.ctx, p, webhookIntentTimeout)
+	case WebhookEventSaleDenied:
+		return d.paymentService.IntentFailed(d.ctx, p, webhookIntentTimeout)
+	case WebhookEventSaleRefunded:
+		amount, err := webhookRefundAmount(p, &e.Resource.Amount)
+		if err != nil {
+			return nil, nil, err
+		}
+		return d.paymentService.IntentPartialRefund(d.ctx, p, amount, webhookIntentTimeout)
+	default:
+		return nil, nil, nil
+	}
+}
+
+// webhookRefundAmount returns the refunded amount of the refund resource
+//
+// PayPal reports refunded amounts as positive or negative totals.
+func webhookRefundAmount(p *payment.Payment, amount *PayPalAmount) (*decimal.Decimal, error) {
+	if amount.Currency != p.Currency {
+		return nil, ErrWebhookAmount
+	}
+	d := &decimal.Decimal{}
+	if _, ok := d.SetString(strings.TrimPrefix(amount.Total, "-")); !ok || d.Sign() <= 0 {
+		return nil, ErrWebhookAmount
+	}
+	return d, nil
+}
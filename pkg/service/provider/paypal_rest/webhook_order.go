@@ -0,0 +1,142 @@
+package paypal_rest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+)
+
+// webhookHoldTimeout is the time an event is held, which cannot be applied
+// before an earlier event of the payment
+//
+// If the earlier event does not arrive in time, the held event is answered with
+// an error, so that PayPal resends it later.
+const webhookHoldTimeout = 20 * time.Second
+
+// webhookAction is what the driver does with a webhook event, given the status
+// of its payment
+type webhookAction int
+
+const (
+	// the event changes the payment
+	webhookApply webhookAction = iota
+	// the event is stale or was already applied
+	webhookIgnore
+	// the event raced an earlier event of the payment, which did not arrive yet
+	webhookHold
+)
+
+func (a webhookAction) String() string {
+	switch a {
+	case webhookApply:
+		return "apply"
+	case webhookIgnore:
+		return "ignore"
+	case webhookHold:
+		return "hold"
+	default:
+		return "unknown"
+	}
+}
+
+// webhookEventAction returns the action for the webhook event type on a
+// payment with the given status
+//
+// The rules make applying the events idempotent and keep the payment status
+// from regressing when events race: A completed or denied sale only changes
+// open payments. Refunds are held until the payment was paid, since PayPal might
+// send the refund before the completion of the sale.
+func webhookEventAction(status payment.PaymentTransactionStatus, eventType string) webhookAction {
+	switch eventType {
+	case WebhookEventSaleCompleted, WebhookEventSaleDenied:
+		if status == payment.PaymentStatusOpen {
+			return webhookApply
+		}
+		return webhookIgnore
+	case WebhookEventSaleRefunded:
+		switch status {
+		case payment.PaymentStatusPaid,
+			payment.PaymentStatusSettled,
+			payment.PaymentStatusPartiallyRefunded,
+			payment.PaymentStatusRefundReversed,
+			payment.PaymentStatusChargebackReversed:
+			return webhookApply
+		case payment.PaymentStatusOpen,
+			payment.PaymentStatusPending,
+			payment.PaymentStatusAuthorized,
+			payment.PaymentStatusPartiallyCaptured:
+			return webhookHold
+		default:
+			return webhookIgnore
+		}
+	default:
+		return webhookIgnore
+	}
+}
+
+// webhookSequencer applies the webhook events of a payment one at a time
+//
+// Events which have to wait for an earlier event of their payment wait until
+// the next event of the payment was applied.
+type webhookSequencer struct {
+	m        sync.Mutex
+	payments map[payment.PaymentID]*webhookSequence
+}
+
+func newWebhookSequencer() *webhookSequencer {
+	return &webhookSequencer{
+		payments: make(map[payment.PaymentID]*webhookSequence),
+	}
+}
+
+// webhookSequence is the sequence of webhook events of a payment
+type webhookSequence struct {
+	sync.Mutex
+	// number of events in the sequence
+	events int
+	// closed once the next event of the payment was applied
+	next chan struct{}
+}
+
+// enter adds an event of the payment to the sequence of the payment
+//
+// Every call to enter must be followed by a call to leave.
+func (s *webhookSequencer) enter(id payment.PaymentID) *webhookSequence {
+	s.m.Lock()
+	seq, ok := s.payments[id]
+	if !ok {
+		seq = &webhookSequence{next: make(chan struct{})}
+		s.payments[id] = seq
+	}
+	seq.events++
+	s.m.Unlock()
+	return seq
+}
+
+// leave removes an event of the payment from the sequence of the payment
+func (s *webhookSequencer) leave(id payment.PaymentID) {
+	s.m.Lock()
+	seq := s.payments[id]
+	seq.events--
+	if seq.events == 0 {
+		delete(s.payments, id)
+	}
+	s.m.Unlock()
+}
+
+// applied returns a channel which is closed once the next event of the payment
+// was applied
+//
+// It must be called while holding the lock of the sequence.
+func (seq *webhookSequence) applied() <-chan struct{} {
+	return seq.next
+}
+
+// notify notifies the held events that an event was applied
+//
+// It must be called while holding the lock of the sequence.
+func (seq *webhookSequence) notify() {
+	close(seq.next)
+	seq.next = make(chan struct{})
+}
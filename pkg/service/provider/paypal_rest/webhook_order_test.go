@@ -0,0 +1,89 @@
+package paypal_rest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhookEventAction(t *testing.T) {
+	Convey("Given an open payment", t, func() {
+		status := payment.PaymentTransactionStatus(payment.PaymentStatusOpen)
+		Convey("A completed sale should be applied", func() {
+			So(webhookEventAction(status, WebhookEventSaleCompleted), ShouldEqual, webhookApply)
+		})
+		Convey("A denied sale should be applied", func() {
+			So(webhookEventAction(status, WebhookEventSaleDenied), ShouldEqual, webhookApply)
+		})
+		Convey("A refund should be held", func() {
+			So(webhookEventAction(status, WebhookEventSaleRefunded), ShouldEqual, webhookHold)
+		})
+	})
+	Convey("Given a paid payment", t, func() {
+		status := payment.PaymentTransactionStatus(payment.PaymentStatusPaid)
+		Convey("A completed sale should be ignored", func() {
+			So(webhookEventAction(status, WebhookEventSaleCompleted), ShouldEqual, webhookIgnore)
+		})
+		Convey("A denied sale should be ignored", func() {
+			So(webhookEventAction(status, WebhookEventSaleDenied), ShouldEqual, webhookIgnore)
+		})
+		Convey("A refund should be applied", func() {
+			So(webhookEventAction(status, WebhookEventSaleRefunded), ShouldEqual, webhookApply)
+		})
+	})
+	Convey("Given a refunded payment", t, func() {
+		status := payment.PaymentTransactionStatus(payment.PaymentStatusRefunded)
+		Convey("A late completed sale should not regress the status", func() {
+			So(webhookEventAction(status, WebhookEventSaleCompleted), ShouldEqual, webhookIgnore)
+		})
+		Convey("Another refund should be ignored", func() {
+			So(webhookEventAction(status, WebhookEventSaleRefunded), ShouldEqual, webhookIgnore)
+		})
+	})
+	Convey("Given a failed payment", t, func() {
+		status := payment.PaymentTransactionStatus(payment.PaymentStatusFailed)
+		Convey("A refund should be ignored", func() {
+			So(webhookEventAction(status, WebhookEventSaleRefunded), ShouldEqual, webhookIgnore)
+		})
+	})
+}
+
+func TestWebhookSequencer(t *testing.T) {
+	Convey("Given a webhook sequencer", t, func() {
+		s := newWebhookSequencer()
+		id := payment.PaymentID{ProjectID: 1, PaymentID: 2}
+
+		Convey("When an event is held", func() {
+			held := s.enter(id)
+			held.Lock()
+			applied := held.applied()
+			held.Unlock()
+
+			Convey("When another event of the payment is applied", func() {
+				seq := s.enter(id)
+				So(seq, ShouldEqual, held)
+				seq.Lock()
+				seq.notify()
+				seq.Unlock()
+				s.leave(id)
+
+				Convey("The held event should be released", func() {
+					select {
+					case <-applied:
+					case <-time.After(time.Second):
+						t.Error("held event was not released")
+					}
+				})
+			})
+
+			Convey("When the events left", func() {
+				s.leave(id)
+				Convey("The sequence should be removed", func() {
+					So(s.payments, ShouldBeEmpty)
+				})
+			})
+		})
+	})
+}
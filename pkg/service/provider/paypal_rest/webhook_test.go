@@ -0,0 +1,84 @@
+package paypal_rest_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/service/provider/paypal_rest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	Convey("Given a signing certificate", t, func() {
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		So(err, ShouldBeNil)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "messageverificationcerts.paypal.com"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		So(err, ShouldBeNil)
+		cert, err := x509.ParseCertificate(der)
+		So(err, ShouldBeNil)
+
+		Convey("Given a signed webhook request", func() {
+			body := []byte(`{"id":"WH-1","event_type":"PAYMENT.SALE.COMPLETED"}`)
+			r, err := http.NewRequest("POST", "/paypal/webhook", strings.NewReader(string(body)))
+			So(err, ShouldBeNil)
+			r.Header.Set("Paypal-Transmission-Id", "103e3700-8b0d-11e4-9b4d-1d7e39b1a2d1")
+			r.Header.Set("Paypal-Transmission-Time", "2014-12-24T09:24:57Z")
+			r.Header.Set("Paypal-Cert-Url", "https://api.sandbox.paypal.com/v1/notifications/certs/CERT-1")
+			r.Header.Set("Paypal-Auth-Algo", "SHA256withRSA")
+			sign := func(webhookID string) {
+				unsigned := &paypal_rest.WebhookSignature{
+					TransmissionID:   r.Header.Get("Paypal-Transmission-Id"),
+					TransmissionTime: r.Header.Get("Paypal-Transmission-Time"),
+				}
+				h := sha256.Sum256(unsigned.Message(webhookID, body))
+				sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+				So(err, ShouldBeNil)
+				r.Header.Set("Paypal-Transmission-Sig", base64.StdEncoding.EncodeToString(sig))
+			}
+
+			Convey("When the signature was created with the webhook ID", func() {
+				sign("WEBHOOK-1")
+				sig, err := paypal_rest.WebhookSignatureFromRequest(r)
+				So(err, ShouldBeNil)
+
+				Convey("The signed message should contain the checksum of the body", func() {
+					So(string(sig.Message("WEBHOOK-1", body)), ShouldStartWith, "103e3700-8b0d-11e4-9b4d-1d7e39b1a2d1|2014-12-24T09:24:57Z|WEBHOOK-1|")
+				})
+				Convey("It should be verified", func() {
+					So(sig.Verify(cert, "WEBHOOK-1", body), ShouldBeNil)
+				})
+				Convey("It should not be verified for another webhook", func() {
+					So(sig.Verify(cert, "WEBHOOK-2", body), ShouldEqual, paypal_rest.ErrWebhookSignature)
+				})
+				Convey("It should not be verified for a modified body", func() {
+					modified := []byte(strings.Replace(string(body), "COMPLETED", "REFUNDED", 1))
+					So(sig.Verify(cert, "WEBHOOK-1", modified), ShouldEqual, paypal_rest.ErrWebhookSignature)
+				})
+			})
+
+			Convey("When the signature header is missing", func() {
+				_, err := paypal_rest.WebhookSignatureFromRequest(r)
+
+				Convey("It should fail", func() {
+					So(err, ShouldEqual, paypal_rest.ErrWebhookSignature)
+				})
+			})
+		})
+	})
+}
@@ -2,16 +2,22 @@ package provider
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/provider"
 
 	"github.com/fritzpay/paymentd/pkg/service/provider/paypal_rest"
 	"github.com/fritzpay/paymentd/pkg/service/provider/stripe"
 
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
 	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"github.com/fritzpay/paymentd/pkg/service/provider/fritzpay"
 	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
@@ -21,13 +27,13 @@ const (
 
 var (
 	ErrNoDriver = errors.New("no driver found")
+	// ErrNotSupported is returned if the driver does not support an operation
+	ErrNotSupported = errors.New("operation not supported by driver")
 )
 
 type Service struct {
 	ctx *service.Context
 	log log15.Logger
-
-	drivers map[string]Driver
 }
 
 func NewService(ctx *service.Context) (*Service, error) {
@@ -36,50 +42,253 @@ func NewService(ctx *service.Context) (*Service, error) {
 		log: ctx.Log().New(log15.Ctx{
 			"pkg": "github.com/fritzpay/paymentd/pkg/service/provider",
 		}),
-
-		drivers: make(map[string]Driver),
 	}
 	return s, nil
 }
 
+func newDriver(name string) (Driver, error) {
+	switch name {
+	case driverFritzpay:
+		return &fritzpay.Driver{}, nil
+	case driverPaypalREST:
+		return &paypal_rest.Driver{}, nil
+	case driverStripe:
+		return &stripe.Driver{}, nil
+	default:
+		return nil, ErrNoDriver
+	}
+}
+
 func (s *Service) AttachDrivers(mux *mux.Router) error {
-	providers, err := provider.ProviderAllDB(s.ctx.PaymentDB())
+	providers, err := provider.ProviderAllDB(s.ctx, s.ctx.PaymentDB())
 	if err != nil {
 		s.log.Error("error retrieving providers", log15.Ctx{"err": err})
 		return err
 	}
 	// add drivers
+	drivers := make(map[string]Driver, len(providers))
 	for _, prov := range providers {
 		s.log.Info("attaching provider driver...", log15.Ctx{
 			"providerName": prov.Name,
 		})
-		switch prov.Name {
-		case driverFritzpay:
-			s.drivers[driverFritzpay] = &fritzpay.Driver{}
-		case driverPaypalREST:
-			s.drivers[driverPaypalREST] = &paypal_rest.Driver{}
-		case driverStripe:
-			s.drivers[driverStripe] = &stripe.Driver{}
-		default:
+		dr, err := newDriver(prov.Name)
+		if err != nil {
 			s.log.Error("unknown provider id in database", log15.Ctx{"providerName": prov.Name})
-			return ErrNoDriver
+			return err
 		}
+		drivers[prov.Name] = dr
 	}
 
 	mux = mux.PathPrefix(ProviderPath).Subrouter()
-	for _, dr := range s.drivers {
+	for name, dr := range drivers {
 		err = dr.Attach(s.ctx, mux)
 		if err != nil {
 			return err
 		}
+		s.ctx.Drivers().Register(name, dr)
 	}
 	return nil
 }
 
 func (s *Service) Driver(method *payment_method.Method) (Driver, error) {
-	if dr, ok := s.drivers[method.Provider.Name]; !ok {
+	dr, ok := s.ctx.Drivers().Driver(method.Provider.Name)
+	if !ok {
 		return nil, ErrNoDriver
-	} else {
-		return dr, nil
 	}
+	d, ok := dr.(Driver)
+	if !ok {
+		return nil, ErrNoDriver
+	}
+	return d, nil
+}
+
+// Capture captures the amount of the authorized payment with the provider of
+// the payment method
+//
+// The driver of the provider has to be attached on this instance. It returns
+// ErrNotSupported if the driver cannot capture payments. Payments which are not
+// captured finally can be captured again. The capture will be abandoned once
+// the context is done.
+func (s *Service) Capture(ctx context.Context, p *payment.Payment, method *payment_method.Method, amount *decimal.Decimal, final bool) error {
+	dr, ok := s.ctx.Drivers().Driver(method.Provider.Name)
+	if !ok {
+		return ErrNoDriver
+	}
+	c, ok := dr.(Capturer)
+	if !ok {
+		return ErrNotSupported
+	}
+	return c.Capture(ctx, p, amount, final)
+}
+
+// IncrementAuthorization increments the authorization of the payment with the
+// provider of the payment method
+//
+// The driver of the provider has to be attached on this instance. It returns
+// ErrNotSupported if the driver cannot increment authorizations. The increment
+// will be abandoned once the context is done.
+func (s *Service) IncrementAuthorization(ctx context.Context, p *payment.Payment, method *payment_method.Method, amount *decimal.Decimal) error {
+	dr, ok := s.ctx.Drivers().Driver(method.Provider.Name)
+	if !ok {
+		return ErrNoDriver
+	}
+	inc, ok := dr.(Incrementer)
+	if !ok {
+		return ErrNotSupported
+	}
+	return inc.IncrementAuthorization(ctx, p, amount)
+}
+
+// Void voids the authorization of the payment with the provider of the payment
+// method
+//
+// The driver of the provider has to be attached on this instance. It returns
+// ErrNotSupported if the driver cannot void authorizations. The void will be
+// abandoned once the context is done.
+func (s *Service) Void(ctx context.Context, p *payment.Payment, method *payment_method.Method) error {
+	dr, ok := s.ctx.Drivers().Driver(method.Provider.Name)
+	if !ok {
+		return ErrNoDriver
+	}
+	v, ok := dr.(Voider)
+	if !ok {
+		return ErrNotSupported
+	}
+	return v.Void(ctx, p)
+}
+
+// ReportedStatus returns the status of the payment as last reported by the
+// provider of the payment method
+//
+// The driver does not need to be attached. It returns ErrNotSupported if the
+// driver does not record the reported statuses.
+func (s *Service) ReportedStatus(p *payment.Payment, method *payment_method.Method) (payment.PaymentTransactionStatus, bool, error) {
+	dr, err := newDriver(method.Provider.Name)
+	if err != nil {
+		return "", false, err
+	}
+	r, ok := dr.(StatusReporter)
+	if !ok {
+		return "", false, ErrNotSupported
+	}
+	return r.ReportedStatus(s.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly), p)
+}
+
+// AuthorizationValidUntil returns the time until which the authorization of the
+// payment can be captured with the provider of the payment method
+//
+// The driver does not need to be attached. It returns ErrNotSupported if the
+// authorizations of the provider do not expire.
+func (s *Service) AuthorizationValidUntil(p *payment.Payment, method *payment_method.Method) (time.Time, bool, error) {
+	dr, err := newDriver(method.Provider.Name)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	e, ok := dr.(AuthorizationExpirer)
+	if !ok {
+		return time.Time{}, false, ErrNotSupported
+	}
+	return e.AuthorizationValidUntil(s.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly), p)
+}
+
+// HasDriver returns true if this build of paymentd has a driver for the named
+// provider
+func HasDriver(name string) bool {
+	_, err := newDriver(name)
+	return err == nil
+}
+
+// Capabilities returns the capabilities of the driver of the named provider
+//
+// The driver does not need to be attached.
+func Capabilities(name string) []string {
+	caps := make([]string, 0)
+	dr, err := newDriver(name)
+	if err != nil {
+		return caps
+	}
+	if _, ok := dr.(Capturer); ok {
+		caps = append(caps, CapabilityCapture)
+	}
+	if _, ok := dr.(Incrementer); ok {
+		caps = append(caps, CapabilityIncrementalAuthorization)
+	}
+	if _, ok := dr.(Voider); ok {
+		caps = append(caps, CapabilityVoid)
+	}
+	if _, ok := dr.(Verifier); ok {
+		caps = append(caps, CapabilityVerification)
+	}
+	if _, ok := dr.(DescriptorChecker); ok {
+		caps = append(caps, CapabilityStatementDescriptor)
+	}
+	if _, ok := dr.(ExemptionRequester); ok {
+		caps = append(caps, CapabilitySCAExemption)
+	}
+	if _, ok := dr.(paymentService.RecurringCharger); ok {
+		caps = append(caps, CapabilityRecurring)
+	}
+	return caps
+}
+
+// ChargesRecurring returns true if the driver of the named provider can charge
+// recurring payments
+//
+// The driver does not need to be attached.
+func ChargesRecurring(name string) bool {
+	dr, err := newDriver(name)
+	if err != nil {
+		return false
+	}
+	_, ok := dr.(paymentService.RecurringCharger)
+	return ok
+}
+
+// CheckConfig validates the configuration of all registered providers and the
+// provider configs of all active payment methods
+//
+// It returns all problems found. The drivers will not be attached.
+func (s *Service) CheckConfig() ([]error, error) {
+	providers, err := provider.ProviderAllDB(s.ctx, s.ctx.PaymentDB(service.ReadOnly))
+	if err != nil {
+		return nil, err
+	}
+	problems := make([]error, 0)
+	drivers := make(map[string]Driver)
+	for _, prov := range providers {
+		dr, err := newDriver(prov.Name)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("provider %s: no driver for provider. remove the provider from the provider table or upgrade paymentd", prov.Name))
+			continue
+		}
+		drivers[prov.Name] = dr
+		if c, ok := dr.(ConfigChecker); ok {
+			err = c.CheckConfig(s.ctx.Config())
+			if err != nil {
+				problems = append(problems, fmt.Errorf("provider %s: %v", prov.Name, err))
+			}
+		}
+	}
+	for _, sh := range s.ctx.PaymentShardDBs(service.ReadOnly) {
+		methods, err := payment_method.PaymentMethodsByStatusDB(s.ctx, sh.DB, payment_method.PaymentMethodStatusActive)
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %v", sh.Shard, err)
+		}
+		for _, method := range methods {
+			dr, ok := drivers[method.Provider.Name]
+			if !ok {
+				problems = append(problems, fmt.Errorf("payment method %d: active with unknown provider %s. disable the payment method", method.ID, method.Provider.Name))
+				continue
+			}
+			c, ok := dr.(ConfigChecker)
+			if !ok {
+				continue
+			}
+			err = c.CheckMethodConfig(sh.DB, method)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("payment method %d (project %d, %s/%s): %v", method.ID, method.ProjectID, method.Provider.Name, method.MethodKey, err))
+			}
+		}
+	}
+	return problems, nil
 }
@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCapabilities(t *testing.T) {
+	Convey("Given the PayPal REST driver", t, func() {
+		caps := Capabilities(driverPaypalREST)
+		Convey("It should capture, increment and void authorizations and forward statement descriptors", func() {
+			So(caps, ShouldResemble, []string{CapabilityCapture, CapabilityIncrementalAuthorization, CapabilityVoid, CapabilityStatementDescriptor})
+		})
+	})
+	Convey("Given the fritzpay driver", t, func() {
+		caps := Capabilities(driverFritzpay)
+		Convey("It should verify payment instruments and charge recurring payments", func() {
+			So(caps, ShouldResemble, []string{CapabilityVerification, CapabilitySCAExemption, CapabilityRecurring})
+		})
+	})
+	Convey("Given the Stripe driver", t, func() {
+		caps := Capabilities(driverStripe)
+		Convey("It should forward statement descriptors", func() {
+			So(caps, ShouldResemble, []string{CapabilityStatementDescriptor})
+		})
+	})
+	Convey("Given an unknown provider", t, func() {
+		caps := Capabilities("unknown")
+		Convey("It should have no capabilities", func() {
+			So(len(caps), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestChargesRecurring(t *testing.T) {
+	Convey("Only the fritzpay driver should charge recurring payments", t, func() {
+		So(ChargesRecurring(driverFritzpay), ShouldBeTrue)
+		So(ChargesRecurring(driverPaypalREST), ShouldBeFalse)
+		So(ChargesRecurring(driverStripe), ShouldBeFalse)
+		So(ChargesRecurring("unknown"), ShouldBeFalse)
+	})
+}
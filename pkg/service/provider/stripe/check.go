@@ -0,0 +1,78 @@
+package stripe
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service/provider/egress"
+	"github.com/fritzpay/paymentd/pkg/service/provider/endpoint"
+)
+
+// templateDir returns the template directory of the driver
+func templateDir(cfg *config.Config) (string, error) {
+	if cfg.Provider.ProviderTemplateDir == "" {
+		return "", fmt.Errorf("provider template dir not set")
+	}
+	dir := path.Join(cfg.Provider.ProviderTemplateDir, providerTemplateDir)
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		return dir, err
+	}
+	if !dirInfo.IsDir() {
+		return dir, fmt.Errorf("provider template dir %s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// CheckConfig validates the paymentd config used by the driver
+func (d *Driver) CheckConfig(cfg *config.Config) error {
+	dir, err := templateDir(cfg)
+	if err != nil {
+		return err
+	}
+	staticDir := path.Join(dir, "static")
+	if _, err = os.Stat(staticDir); err != nil {
+		return fmt.Errorf("static assets dir: %v", err)
+	}
+	_, err = url.Parse(cfg.Provider.URL)
+	if err != nil {
+		return fmt.Errorf("error on provider base URL: %v", err)
+	}
+	rounding := cfg.Provider.Rounding[providerName]
+	_, err = currency.NewRoundingPolicy(rounding.Mode, rounding.Exponents)
+	if err != nil {
+		return fmt.Errorf("error on rounding policy: %v", err)
+	}
+	for _, pool := range cfg.Provider.EndpointPools[providerName] {
+		_, err = endpoint.NewPool(pool.URLs)
+		if err != nil {
+			return fmt.Errorf("error on endpoint pool: %v", err)
+		}
+	}
+	_, err = egress.Transport(cfg, providerName)
+	if err != nil {
+		return fmt.Errorf("error on egress config: %v", err)
+	}
+	return nil
+}
+
+// CheckMethodConfig validates the Stripe config of the payment method
+func (d *Driver) CheckMethodConfig(db *sql.DB, method *payment_method.Method) error {
+	cfg, err := ConfigByPaymentMethodDB(d.context, db, method)
+	if err != nil {
+		if err == ErrConfigNotFound {
+			return fmt.Errorf("no Stripe config found. insert a config for method key %s into provider_stripe_config", method.MethodKey)
+		}
+		return err
+	}
+	if cfg.SecretKey == "" || cfg.PublicKey == "" {
+		return fmt.Errorf("secret key and public key required in the Stripe config")
+	}
+	return nil
+}
@@ -4,18 +4,19 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"html/template"
-	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"time"
 
+	"github.com/fritzpay/paymentd/pkg/asset"
+	"github.com/fritzpay/paymentd/pkg/descriptor"
+	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
 	"github.com/fritzpay/paymentd/pkg/service"
 	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/service/provider/endpoint"
 	tmpl "github.com/fritzpay/paymentd/pkg/template"
 	"github.com/gorilla/mux"
 	"github.com/stripe/stripe-go"
@@ -29,7 +30,12 @@ const (
 	StripeDriverPath = "/stripe"
 )
 
+// webPaymentPath is the path of the hosted payment page, which will dispatch
+// routed payments to their payment method
+const webPaymentPath = "/payment"
+
 const (
+	providerName         = "stripe"
 	providerTemplateDir  = "stripe"
 	defaultLocale        = "en_US"
 	stripeSecretKey      = ""
@@ -43,13 +49,27 @@ var (
 	ErrProvider = errors.New("provider error")
 )
 
+// descriptorRules are the rules of Stripe statement descriptors
+var descriptorRules = descriptor.Rules{
+	MinLen:    5,
+	MaxLen:    descriptor.MaxLen,
+	Forbidden: `<>\'"*`,
+	Letter:    true,
+}
+
 // Driver is the Stripe provider driver
 type Driver struct {
 	context        *service.Context
 	tmplDir        string
+	assets         *asset.Pipeline
+	templates      *tmpl.Manager
 	log            log15.Logger
 	mux            *mux.Router
 	paymentService *paymentService.Service
+	rounding       currency.RoundingPolicy
+	// routes requests to the configured regional endpoints
+	endpoints *endpoint.Transport
+	backend   stripe.Backend
 }
 
 func (d *Driver) Attach(ctx *service.Context, m *mux.Router) error {
@@ -61,11 +81,8 @@ func (d *Driver) Attach(ctx *service.Context, m *mux.Router) error {
 
 	//set template path
 	cfg := ctx.Config()
-	if cfg.Provider.ProviderTemplateDir == "" {
-		return fmt.Errorf("provider template dir not set")
-	}
-	d.tmplDir = path.Join(cfg.Provider.ProviderTemplateDir, providerTemplateDir)
-	dirInfo, err := os.Stat(d.tmplDir)
+	var err error
+	d.tmplDir, err = templateDir(cfg)
 	if err != nil {
 		d.log.Error("error opening template dir", log15.Ctx{
 			"err":     err,
@@ -73,15 +90,19 @@ func (d *Driver) Attach(ctx *service.Context, m *mux.Router) error {
 		})
 		return err
 	}
-	if !dirInfo.IsDir() {
-		return fmt.Errorf("provider template dir %s is not a directory", d.tmplDir)
-	}
 	_, err = url.Parse(cfg.Provider.URL)
 	if err != nil {
 		d.log.Error("error parsing provider base URL", log15.Ctx{"err": err})
 		return fmt.Errorf("error on provider base URL: %v", err)
 	}
 
+	rounding := ctx.Config().Provider.Rounding[providerName]
+	d.rounding, err = currency.NewRoundingPolicy(rounding.Mode, rounding.Exponents)
+	if err != nil {
+		d.log.Error("error on rounding policy", log15.Ctx{"err": err})
+		return err
+	}
+
 	d.paymentService, err = paymentService.NewService(ctx)
 
 	// add subrouting
@@ -93,22 +114,46 @@ func (d *Driver) Attach(ctx *service.Context, m *mux.Router) error {
 	}
 	d.mux = driverRoute.Subrouter()
 	d.mux.Handle("/process", ctx.RateLimitHandler(d.ProcessHandler())).Name("processFormHandler")
+	// webhooks received during a database outage will be queued, unprocessable
+	// webhooks will be kept in the dead-letter queue
+	webhook := ctx.DeadLetterHandler("stripe/webhook", providerName, d.WebhookHandler())
+	d.mux.Handle("/webhook", ctx.OutageQueueHandler("stripe/webhook", webhook)).Name("webhookHandler")
 	staticDir := path.Join(d.tmplDir, "static")
 	d.log.Info("serving static dir", log15.Ctx{
 		"staticDir": staticDir,
 		"prefix":    url.Path + "/static",
 	})
-	d.mux.PathPrefix("/static").Handler(http.StripPrefix(url.Path+"/static", http.FileServer(http.Dir(staticDir)))).Name("staticHandler")
+	d.assets, err = asset.NewPipeline(staticDir, url.Path+"/static")
+	if err != nil {
+		d.log.Error("error loading static assets", log15.Ctx{"err": err})
+		return err
+	}
+	d.mux.PathPrefix("/static").Handler(http.StripPrefix(url.Path+"/static", service.CompressionHandler(d.assets))).Name("staticHandler")
+
+	d.templates = tmpl.NewManager(d.tmplDir, defaultLocale, d.templateFuncs())
+	err = d.templates.Load()
+	if err != nil {
+		d.log.Error("error loading templates", log15.Ctx{"err": err})
+		return err
+	}
+	ctx.Templates().Register("provider/"+providerName, d.templates)
 
 	if err != nil {
 		d.log.Error("error initializing payment service", log15.Ctx{"err": err})
 		return err
 	}
 
+	d.endpoints, err = endpoint.NewTransport(ctx, providerName)
+	if err != nil {
+		d.log.Error("error on endpoint pools", log15.Ctx{"err": err})
+		return err
+	}
+	d.backend = stripe.NewInternalBackend(&http.Client{Transport: d.endpoints}, "")
+
 	return err
 }
 
-func (d *Driver) InitPayment(p *payment.Payment, pm *payment_method.Method) (http.Handler, error) {
+func (d *Driver) InitPayment(p *payment.Payment, pm *payment_method.Method, r *http.Request) (http.Handler, error) {
 
 	// start transaction
 	// show stripe.js form
@@ -122,8 +167,7 @@ func (d *Driver) InitPageHandler(p *payment.Payment) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := d.log.New(log15.Ctx{"method": "InitPageHandler"})
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl := template.New("init")
-		err := d.getTemplate(tmpl, d.tmplDir, p.Config.Locale.String, baseName)
+		tmpl, err := d.templates.Template(p.Config.Locale.String, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -168,10 +212,10 @@ func (d *Driver) ProcessHandler() http.Handler {
 				}
 			}
 		}()
-		tx, err = d.context.PaymentDB().Begin()
 		paymentID = d.paymentService.DecodedPaymentID(paymentID)
+		tx, err = d.context.ProjectPaymentDB(paymentID.ProjectID).Begin()
 
-		p, err := payment.PaymentByIDTx(tx, paymentID)
+		p, err := payment.PaymentByIDTx(d.context, tx, paymentID)
 		if err != nil {
 			if err == payment.ErrPaymentNotFound {
 				log.Info("payment not found", log15.Ctx{"err": err})
@@ -183,20 +227,53 @@ func (d *Driver) ProcessHandler() http.Handler {
 			return
 		}
 
+		// record the card and hand over routed payments
+		if cardBIN := r.Form.Get("cardbin"); cardBIN != "" {
+			routed, err := d.paymentService.SetPaymentCard(tx, p, cardBIN)
+			if err != nil && !errors.Is(err, paymentService.ErrCardNumber) {
+				log.Error("error recording payment card", log15.Ctx{"err": err})
+				d.InternalErrorHandler(p).ServeHTTP(w, r)
+				return
+			}
+			if routed {
+				commit = true
+				err = tx.Commit()
+				if err != nil {
+					log.Crit("error on commit", log15.Ctx{"err": err})
+					d.InternalErrorHandler(p).ServeHTTP(w, r)
+					return
+				}
+				log.Info("payment routed by card", log15.Ctx{"paymentMethodID": p.Config.PaymentMethodID.Int64})
+				http.Redirect(w, r, d.context.Config().Web.URL+webPaymentPath, http.StatusSeeOther)
+				return
+			}
+		}
+
 		// stripe charge
-		stripe.Key = stripeSecretKey
-
-		params := &stripe.ChargeParams{
-			Amount:   uint64(p.Amount),
-			Currency: stripe.Currency(p.Currency),
-			Card: &stripe.CardParams{
-				Token: stripeTokenStr,
-			},
+		desc, err := d.paymentService.StatementDescriptor(p)
+		if err != nil {
+			log.Error("error retrieving statement descriptor", log15.Ctx{"err": err})
+			d.InternalErrorHandler(p).ServeHTTP(w, r)
+			return
 		}
-		ch, err := charge.New(params)
+		params := d.chargeParams(p, stripeTokenStr, desc)
+		ch, err := d.charge(params)
 		if err != nil {
 			log.Error("error retrieving stripe charge object", log15.Ctx{"err": err})
 			d.InternalErrorHandler(nil).ServeHTTP(w, r)
+			return
+		}
+		// disputes reference the charge, which is matched with the payment
+		err = InsertTransactionTx(d.context, tx, &Transaction{
+			ProjectID: p.ProjectID(),
+			PaymentID: p.ID(),
+			Timestamp: time.Now(),
+			ChargeID:  ch.ID,
+		})
+		if err != nil {
+			log.Error("error saving stripe transaction", log15.Ctx{"err": err})
+			d.InternalErrorHandler(p).ServeHTTP(w, r)
+			return
 		}
 		log.Debug("payment", log15.Ctx{"payment": p})
 		log.Debug("charge params", log15.Ctx{"params": params})
@@ -215,14 +292,61 @@ func (d *Driver) ProcessHandler() http.Handler {
 	})
 }
 
+// chargeParams returns the parameters of the Stripe charge for the payment
+//
+// The amount is converted to the minor units of the currency. The statement
+// descriptor will be omitted if it is not accepted by Stripe.
+func (d *Driver) chargeParams(p *payment.Payment, token, desc string) *stripe.ChargeParams {
+	log := d.log.New(log15.Ctx{"method": "chargeParams"})
+	amount, exact := d.rounding.MinorUnits(p.Decimal(), p.Currency)
+	if !exact {
+		log.Warn("rounding discrepancy", log15.Ctx{
+			"amount":   p.Decimal().String(),
+			"currency": p.Currency,
+			"rounded":  amount,
+		})
+	}
+	params := &stripe.ChargeParams{
+		Amount:   uint64(amount),
+		Currency: stripe.Currency(p.Currency),
+		Card: &stripe.CardParams{
+			Token: token,
+		},
+	}
+	if desc != "" {
+		if err := d.CheckDescriptor(desc); err != nil {
+			log.Warn("statement descriptor not accepted by provider", log15.Ctx{
+				"err":        err,
+				"descriptor": desc,
+			})
+		} else {
+			params.Statement = desc
+		}
+	}
+	return params
+}
+
+// charge creates the Stripe charge
+func (d *Driver) charge(params *stripe.ChargeParams) (*stripe.Charge, error) {
+	c := charge.Client{B: d.backend, Key: stripeSecretKey}
+	return c.New(params)
+}
+
+// CheckDescriptor checks the statement descriptor against the rules of Stripe
+//
+// Stripe descriptors must be 5 to 22 characters long and contain at least one
+// letter.
+func (d *Driver) CheckDescriptor(desc string) error {
+	return descriptorRules.Check(desc)
+}
+
 // ProcessFormPageHandler serves the post action (form processing)
 func (d *Driver) processFormPageHandler(p *payment.Payment) http.Handler {
 	const baseName = "form.html.tmpl"
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := d.log.New(log15.Ctx{"method": "InitPageHandler"})
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl := template.New("init")
-		err := d.getTemplate(tmpl, d.tmplDir, p.Config.Locale.String, baseName)
+		tmpl, err := d.templates.Template(p.Config.Locale.String, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -237,17 +361,9 @@ func (d *Driver) processFormPageHandler(p *payment.Payment) http.Handler {
 	})
 }
 
-func (d *Driver) getTemplate(t *template.Template, tmplDir, locale, baseName string) (err error) {
-	tmplFile, err := tmpl.TemplateFileName(tmplDir, locale, defaultLocale, baseName)
-	if err != nil {
-		return err
-	}
-	tmplB, err := ioutil.ReadFile(tmplFile)
-	if err != nil {
-		return err
-	}
-	tmplLocale := path.Base(path.Ext(tmplFile))
-	t.Funcs(template.FuncMap(map[string]interface{}{
+// templateFuncs returns the driver specific template functions
+func (d *Driver) templateFuncs() map[string]interface{} {
+	return map[string]interface{}{
 		"staticPath": func() (string, error) {
 			url, err := d.mux.Get("staticHandler").URLPath()
 			if err != nil {
@@ -255,15 +371,8 @@ func (d *Driver) getTemplate(t *template.Template, tmplDir, locale, baseName str
 			}
 			return url.Path, nil
 		},
-		"locale": func() string {
-			return tmplLocale
-		},
-	}))
-	_, err = t.Parse(string(tmplB))
-	if err != nil {
-		return err
+		"asset": d.assets.Path,
 	}
-	return nil
 }
 
 func (d *Driver) templatePaymentData(p *payment.Payment) map[string]interface{} {
@@ -271,7 +380,7 @@ func (d *Driver) templatePaymentData(p *payment.Payment) map[string]interface{}
 	if p != nil {
 		tmplData["payment"] = p
 		tmplData["paymentID"] = d.paymentService.EncodedPaymentID(p.PaymentID())
-		tmplData["amount"] = p.DecimalRound(2)
+		tmplData["amount"], _ = d.rounding.Round(p.Decimal(), p.Currency)
 
 	}
 	tmplData["timestamp"] = time.Now().Unix()
@@ -296,8 +405,7 @@ func (d *Driver) NotFoundHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("not_found")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.templates.Template(locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -322,8 +430,7 @@ func (d *Driver) InternalErrorHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("internal_error")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.templates.Template(locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
@@ -345,8 +452,7 @@ func (d *Driver) SuccessHandler(p *payment.Payment) http.Handler {
 		if p != nil {
 			locale = p.Config.Locale.String
 		}
-		tmpl := template.New("success")
-		err := d.getTemplate(tmpl, d.tmplDir, locale, baseName)
+		tmpl, err := d.templates.Template(locale, baseName)
 		if err != nil {
 			log.Error("error initializing template", log15.Ctx{"err": err})
 			return
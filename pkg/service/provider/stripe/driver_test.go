@@ -0,0 +1,74 @@
+package stripe
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/testutil/vcr"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stripe/stripe-go"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestCharge(t *testing.T) {
+	rec, err := vcr.New("testdata/charge.json", vcr.ModeFromEnv(), nil)
+	if err != nil {
+		t.Fatalf("error loading cassette: %v", err)
+	}
+	defer func() {
+		if err := rec.Stop(); err != nil {
+			t.Errorf("error saving cassette: %v", err)
+		}
+	}()
+	Convey("Given a driver replaying the Stripe sandbox", t, func() {
+		rounding, err := currency.NewRoundingPolicy("halfUp", nil)
+		So(err, ShouldBeNil)
+		d := &Driver{
+			log:      log15.New(),
+			rounding: rounding,
+			backend:  stripe.NewInternalBackend(&http.Client{Transport: rec}, ""),
+		}
+		d.log.SetHandler(log15.DiscardHandler())
+
+		Convey("Given a payment", func() {
+			p := &payment.Payment{
+				Amount:   1000,
+				Subunits: 2,
+				Currency: "EUR",
+			}
+
+			Convey("When charging a valid card", func() {
+				params := d.chargeParams(p, "tok_visa", "FRITZPAY ORDER 1")
+				ch, err := d.charge(params)
+
+				Convey("The charge should be paid", func() {
+					So(err, ShouldBeNil)
+					So(ch.ID, ShouldEqual, "ch_15IA8x2eZvKYlo2CYFSw2IfI")
+					So(ch.Paid, ShouldBeTrue)
+					So(ch.Amount, ShouldEqual, 1000)
+					So(ch.Statement, ShouldEqual, "FRITZPAY ORDER 1")
+				})
+			})
+
+			Convey("When charging a declined card with a descriptor not accepted by Stripe", func() {
+				params := d.chargeParams(p, "tok_chargeDeclined", "FRITZ*PAY")
+
+				Convey("The descriptor should be omitted", func() {
+					So(params.Statement, ShouldBeEmpty)
+				})
+
+				Convey("The charge should fail with the card error", func() {
+					_, err := d.charge(params)
+					So(err, ShouldNotBeNil)
+					stripeErr, ok := err.(*stripe.Error)
+					So(ok, ShouldBeTrue)
+					So(stripeErr.Type, ShouldEqual, stripe.CardErr)
+					So(stripeErr.Code, ShouldEqual, stripe.CardDeclined)
+					So(stripeErr.HTTPStatusCode, ShouldEqual, http.StatusPaymentRequired)
+				})
+			})
+		})
+	})
+}
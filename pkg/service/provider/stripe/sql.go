@@ -3,8 +3,10 @@ package stripe
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -56,12 +58,69 @@ func scanConfig(row *sql.Row) (*Config, error) {
 	return cfg, nil
 }
 
-func ConfigByPaymentMethodTx(db *sql.Tx, method *payment_method.Method) (*Config, error) {
-	row := db.QueryRow(selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
+func ConfigByPaymentMethodTx(ctx context.Context, db *sql.Tx, method *payment_method.Method) (*Config, error) {
+	row := db.QueryRowContext(ctx, selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
 	return scanConfig(row)
 }
 
-func ConfigByPaymentMethodDB(db *sql.DB, method *payment_method.Method) (*Config, error) {
-	row := db.QueryRow(selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
+func ConfigByPaymentMethodDB(ctx context.Context, db *sql.DB, method *payment_method.Method) (*Config, error) {
+	row := db.QueryRowContext(ctx, selectConfigByProjectIDAndMethodKey, method.ProjectID, method.MethodKey)
 	return scanConfig(row)
 }
+
+const insertTransaction = `
+INSERT INTO provider_stripe_transaction
+(project_id, payment_id, timestamp, charge_id)
+VALUES
+(?, ?, ?, ?)
+`
+
+// InsertTransactionTx saves the Stripe charge of a payment
+func InsertTransactionTx(ctx context.Context, db *sql.Tx, t *Transaction) error {
+	stmt, err := db.PrepareContext(ctx, insertTransaction)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx,
+		t.ProjectID,
+		t.PaymentID,
+		t.Timestamp.UnixNano(),
+		t.ChargeID,
+	)
+	stmt.Close()
+	return err
+}
+
+const selectTransactionByChargeID = `
+SELECT
+	project_id,
+	payment_id,
+	timestamp,
+	charge_id
+FROM provider_stripe_transaction
+WHERE
+	charge_id = ?
+`
+
+// TransactionByChargeIDDB returns the transaction of the Stripe charge
+//
+// It returns an ErrTransactionNotFound if the charge was not created by the
+// driver.
+func TransactionByChargeIDDB(ctx context.Context, db *sql.DB, chargeID string) (*Transaction, error) {
+	t := &Transaction{}
+	var ts int64
+	err := db.QueryRowContext(ctx, selectTransactionByChargeID, chargeID).Scan(
+		&t.ProjectID,
+		&t.PaymentID,
+		&ts,
+		&t.ChargeID,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, err
+	}
+	t.Timestamp = time.Unix(0, ts)
+	return t, nil
+}
@@ -13,3 +13,11 @@ type Config struct {
 	SecretKey string
 	PublicKey string
 }
+
+// Transaction is the Stripe charge of a payment
+type Transaction struct {
+	ProjectID int64
+	PaymentID int64
+	Timestamp time.Time
+	ChargeID  string
+}
@@ -0,0 +1,264 @@
+package stripe
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/event"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Stripe webhook event types of disputes handled by the driver
+const (
+	WebhookEventDisputeCreated = "charge.dispute.created"
+	WebhookEventDisputeClosed  = "charge.dispute.closed"
+)
+
+// Stripe dispute statuses of closed disputes
+const (
+	DisputeStatusWon  = "won"
+	DisputeStatusLost = "lost"
+)
+
+const (
+	// maximum size of a webhook event
+	webhookMaxBodySize   = 1 << 20
+	webhookIntentTimeout = 500 * time.Millisecond
+	disputeCreatedBy     = "stripe"
+)
+
+var (
+	ErrWebhookAmount = errors.New("invalid webhook event amount")
+)
+
+// Dispute is the dispute object of a Stripe dispute event
+//
+// See https://stripe.com/docs/api#disputes
+type Dispute struct {
+	ID       string `json:"id"`
+	Charge   string `json:"charge"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Reason   string `json:"reason"`
+	Status   string `json:"status"`
+}
+
+// webhookDispute reads the dispute object of the event
+func webhookDispute(e *stripe.Event) (*Dispute, error) {
+	if e.Data == nil {
+		return nil, errors.New("event without data")
+	}
+	dp := &Dispute{}
+	err := json.Unmarshal(e.Data.Raw, dp)
+	if err != nil {
+		return nil, err
+	}
+	if dp.ID == "" || dp.Charge == "" {
+		return nil, errors.New("invalid dispute")
+	}
+	return dp, nil
+}
+
+// disputeChargeback returns the status change of the dispute for the event
+//
+// Created disputes are received chargebacks. Closed disputes are reversed if
+// the merchant won and lost if the customer won. It returns nil if the event
+// does not change the dispute, e.g. if an inquiry was closed. exp is the
+// exponent of the minor units of the currency at Stripe.
+func disputeChargeback(p *payment.Payment, eventType string, dp *Dispute, exp int32) (*payment.Chargeback, error) {
+	var status string
+	switch eventType {
+	case WebhookEventDisputeCreated:
+		status = payment.ChargebackReceived
+	case WebhookEventDisputeClosed:
+		switch dp.Status {
+		case DisputeStatusWon:
+			status = payment.ChargebackReversed
+		case DisputeStatusLost:
+			status = payment.ChargebackLost
+		default:
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	var amount int64
+	if status == payment.ChargebackReceived {
+		if !strings.EqualFold(dp.Currency, p.Currency) || dp.Amount <= 0 {
+			return nil, ErrWebhookAmount
+		}
+		// Stripe amounts are in the minor units of the currency
+		d := &decimal.Decimal{Dec: *dec.NewDecInt64(dp.Amount)}
+		d.SetScale(dec.Scale(exp))
+		d.Round(&d.Dec, dec.Scale(p.Subunits), dec.RoundHalfUp)
+		amount = d.Unscaled().Int64()
+	}
+	c := p.NewChargeback(dp.ID, status, amount, disputeCreatedBy)
+	reason := dp.Reason
+	if status != payment.ChargebackReceived {
+		reason = "status " + dp.Status
+	}
+	c.Reason.String, c.Reason.Valid = reason, reason != ""
+	return c, nil
+}
+
+// WebhookHandler receives the webhook events of Stripe
+//
+// Disputes of charges created by the driver will be recorded as chargebacks of
+// their payment. Since Stripe does not sign the events, each event is
+// retrieved from Stripe with the secret key of the payment method of the
+// payment, and only the retrieved event will be processed.
+//
+// It answers with HTTP status 200 OK once the event was processed or if it
+// cannot be processed at all, so that Stripe does not resend it. Disputes of
+// unknown charges will be rejected to the dead-letter queue.
+func (d *Driver) WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := d.log.New(log15.Ctx{"method": "WebhookHandler"})
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, webhookMaxBodySize))
+		r.Body.Close()
+		if err != nil {
+			log.Error("error reading webhook", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		e := &stripe.Event{}
+		err = json.Unmarshal(body, e)
+		if err != nil || e.ID == "" {
+			log.Warn("invalid webhook event", log15.Ctx{"err": err})
+			d.context.DeadLetters().Reject(r, "invalid event")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"eventID":   e.ID,
+			"eventType": e.Type,
+		})
+		if e.Type != WebhookEventDisputeCreated && e.Type != WebhookEventDisputeClosed {
+			log.Debug("ignoring event")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		dp, err := webhookDispute(e)
+		if err != nil {
+			log.Warn("invalid dispute", log15.Ctx{"err": err})
+			d.context.DeadLetters().Reject(r, "invalid dispute")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"chargeID":  dp.Charge,
+			"disputeID": dp.ID,
+		})
+		stripeTx, err := d.transactionByChargeID(dp.Charge)
+		if err != nil {
+			if err == ErrTransactionNotFound {
+				log.Warn("dispute of unknown charge")
+				d.context.DeadLetters().Reject(r, "unknown stripe charge")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log.Error("error retrieving stripe transaction", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		paymentID := payment.PaymentID{ProjectID: stripeTx.ProjectID, PaymentID: stripeTx.PaymentID}
+		p, err := payment.PaymentByIDDB(d.context, d.context.ProjectPaymentDB(paymentID.ProjectID), paymentID)
+		if err != nil {
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log = log.New(log15.Ctx{
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+		})
+		method, err := d.paymentService.PaymentMethod(p.ProjectID(), p.Config.PaymentMethodID.Int64)
+		if err != nil {
+			log.Error("error retrieving payment method", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		cfg, err := ConfigByPaymentMethodDB(d.context, d.context.ProjectPaymentDB(method.ProjectID, service.ReadOnly), method)
+		if err != nil {
+			if err == ErrConfigNotFound {
+				log.Warn("no Stripe config", log15.Ctx{"methodKey": method.MethodKey})
+				d.context.DeadLetters().Reject(r, "no stripe config for method "+method.MethodKey)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			log.Error("error retrieving Stripe config", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// only the event as retrieved from Stripe is trusted
+		verified, err := event.Client{B: d.backend, Key: cfg.SecretKey}.Get(e.ID)
+		if err != nil {
+			log.Error("error retrieving event from Stripe", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		dp, err = webhookDispute(verified)
+		if err != nil || verified.Type != e.Type || dp.Charge != stripeTx.ChargeID {
+			log.Warn("event mismatch", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c, err := disputeChargeback(p, verified.Type, dp, d.rounding.Exponent(p.Currency))
+		if err != nil {
+			log.Warn("invalid dispute amount", log15.Ctx{"amount": dp.Amount})
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if c == nil {
+			log.Debug("ignoring dispute event", log15.Ctx{"status": dp.Status})
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		// a resent event will be rejected, since each status of a dispute is
+		// recorded once
+		err = d.paymentService.RecordChargeback(paymentID, c, webhookIntentTimeout)
+		if err != nil {
+			switch {
+			case errors.Is(err, paymentService.ErrIntentNotAllowed):
+				log.Warn("chargeback not allowed", log15.Ctx{"status": p.Status})
+			case errors.Is(err, paymentService.ErrChargebackAmount):
+				log.Warn("invalid chargeback amount", log15.Ctx{"amount": dp.Amount})
+			default:
+				log.Error("error recording chargeback", log15.Ctx{"err": err})
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// transactionByChargeID returns the Stripe transaction of the given charge
+//
+// Webhook events do not reference the project, so all shards are searched.
+func (d *Driver) transactionByChargeID(chargeID string) (*Transaction, error) {
+	for _, sh := range d.context.PaymentShardDBs(service.ReadOnly) {
+		t, err := TransactionByChargeIDDB(d.context, sh.DB, chargeID)
+		if err == ErrTransactionNotFound {
+			continue
+		}
+		return t, err
+	}
+	return nil, ErrTransactionNotFound
+}
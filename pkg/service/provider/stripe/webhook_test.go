@@ -0,0 +1,69 @@
+package stripe
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDisputeChargeback(t *testing.T) {
+	Convey("Given a paid payment of 10.00 EUR", t, func() {
+		p := &payment.Payment{
+			Amount:   1000,
+			Subunits: 2,
+			Currency: "EUR",
+			Status:   payment.PaymentStatusPaid,
+		}
+		dp := &Dispute{
+			ID:       "dp_1",
+			Charge:   "ch_1",
+			Amount:   750,
+			Currency: "eur",
+			Reason:   "fraudulent",
+			Status:   "needs_response",
+		}
+
+		Convey("When the dispute is created", func() {
+			c, err := disputeChargeback(p, WebhookEventDisputeCreated, dp, 2)
+			So(err, ShouldBeNil)
+			Convey("It should be received with the disputed amount", func() {
+				So(c.DisputeID, ShouldEqual, "dp_1")
+				So(c.Status, ShouldEqual, payment.ChargebackReceived)
+				So(c.Amount, ShouldEqual, 750)
+				So(c.Reason.String, ShouldEqual, "fraudulent")
+			})
+		})
+		Convey("When the dispute is created in another currency", func() {
+			dp.Currency = "usd"
+			_, err := disputeChargeback(p, WebhookEventDisputeCreated, dp, 2)
+			Convey("It should fail", func() {
+				So(err, ShouldEqual, ErrWebhookAmount)
+			})
+		})
+		Convey("When the dispute is won", func() {
+			dp.Status = DisputeStatusWon
+			c, err := disputeChargeback(p, WebhookEventDisputeClosed, dp, 2)
+			So(err, ShouldBeNil)
+			Convey("It should be reversed", func() {
+				So(c.Status, ShouldEqual, payment.ChargebackReversed)
+			})
+		})
+		Convey("When the dispute is lost", func() {
+			dp.Status = DisputeStatusLost
+			c, err := disputeChargeback(p, WebhookEventDisputeClosed, dp, 2)
+			So(err, ShouldBeNil)
+			Convey("It should be lost", func() {
+				So(c.Status, ShouldEqual, payment.ChargebackLost)
+			})
+		})
+		Convey("When an inquiry is closed", func() {
+			dp.Status = "warning_closed"
+			c, err := disputeChargeback(p, WebhookEventDisputeClosed, dp, 2)
+			Convey("It should not change the dispute", func() {
+				So(err, ShouldBeNil)
+				So(c, ShouldBeNil)
+			})
+		})
+	})
+}
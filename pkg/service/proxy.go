@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// HeaderForwardedProto is the de-facto standard header for the protocol the
+	// client used to connect to a proxy
+	HeaderForwardedProto = "X-Forwarded-Proto"
+	// HeaderForwardedHost is the de-facto standard header for the host the client
+	// requested from a proxy
+	HeaderForwardedHost = "X-Forwarded-Host"
+	// HeaderForwardedFor is the de-facto standard header for the addresses of
+	// the client and the proxies a request passed
+	HeaderForwardedFor = "X-Forwarded-For"
+)
+
+// TrustedProxies is a list of networks from which forwarded headers will be
+// accepted
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a list of trusted proxies
+//
+// Each entry can either be a single IP address or a network in CIDR notation.
+func ParseTrustedProxies(addrs []string) (TrustedProxies, error) {
+	t := make(TrustedProxies, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %s", addr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			t = append(t, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network %s: %v", addr, err)
+		}
+		t = append(t, n)
+	}
+	return t, nil
+}
+
+// Trusted returns true if the given request was received from a trusted proxy
+func (t TrustedProxies) Trusted(r *http.Request) bool {
+	if len(t) == 0 {
+		return false
+	}
+	return t.contains(remoteHost(r))
+}
+
+func (t TrustedProxies) contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientAddr returns the IP address of the client
+//
+// If the request was received from a trusted proxy, the address will be taken
+// from the forwarded addresses, skipping the addresses of trusted proxies.
+func (t TrustedProxies) ClientAddr(r *http.Request) string {
+	addr := remoteHost(r)
+	if !t.Trusted(r) {
+		return addr
+	}
+	forwarded := strings.Split(strings.Join(r.Header[HeaderForwardedFor], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		fwd := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(fwd) == nil {
+			break
+		}
+		addr = fwd
+		if !t.contains(fwd) {
+			break
+		}
+	}
+	return addr
+}
+
+// firstHeaderValue returns the first (client-side) entry of a possibly
+// comma-separated header value
+func firstHeaderValue(r *http.Request, name string) string {
+	v := r.Header.Get(name)
+	if i := strings.Index(v, ","); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+// BaseURL returns the base URL as requested by the client
+//
+// The scheme and host of the given base URL will be replaced by the forwarded
+// values if the request was received from a trusted proxy. If the base URL has
+// no host, the requested host will be used.
+func (t TrustedProxies) BaseURL(r *http.Request, base *url.URL) *url.URL {
+	u := *base
+	if u.Host == "" {
+		u.Host = r.Host
+		if r.TLS != nil {
+			u.Scheme = "https"
+		} else {
+			u.Scheme = "http"
+		}
+	}
+	if !t.Trusted(r) {
+		return &u
+	}
+	if proto := strings.ToLower(firstHeaderValue(r, HeaderForwardedProto)); proto == "http" || proto == "https" {
+		u.Scheme = proto
+	}
+	if host := firstHeaderValue(r, HeaderForwardedHost); host != "" {
+		u.Host = host
+	}
+	return &u
+}
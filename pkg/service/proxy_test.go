@@ -0,0 +1,79 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	Convey("Given invalid trusted proxy entries", t, func() {
+		Convey("Parsing should fail", func() {
+			_, err := ParseTrustedProxies([]string{"proxy.local"})
+			So(err, ShouldNotBeNil)
+			_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given trusted proxies", t, func() {
+		proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"})
+		So(err, ShouldBeNil)
+		base, err := url.Parse("http://localhost:8443/prefix")
+		So(err, ShouldBeNil)
+
+		r, err := http.NewRequest("GET", "/payment", nil)
+		So(err, ShouldBeNil)
+		r.Header.Set(HeaderForwardedProto, "https")
+		r.Header.Set(HeaderForwardedHost, "pay.example.com, proxy.local")
+
+		Convey("When a request is received from a trusted proxy", func() {
+			r.RemoteAddr = "10.1.2.3:51234"
+
+			Convey("It should be trusted", func() {
+				So(proxies.Trusted(r), ShouldBeTrue)
+			})
+			Convey("The client address should be the last untrusted forwarded address", func() {
+				r.Header.Set(HeaderForwardedFor, "1.1.1.1, 203.0.113.7, 10.0.0.2")
+				So(proxies.ClientAddr(r), ShouldEqual, "203.0.113.7")
+			})
+			Convey("Without forwarded addresses the client address should be the proxy", func() {
+				So(proxies.ClientAddr(r), ShouldEqual, "10.1.2.3")
+			})
+			Convey("The base URL should contain the forwarded values", func() {
+				u := proxies.BaseURL(r, base)
+				So(u.String(), ShouldEqual, "https://pay.example.com/prefix")
+				So(base.String(), ShouldEqual, "http://localhost:8443/prefix")
+			})
+		})
+
+		Convey("When a request is received from an untrusted address", func() {
+			r.RemoteAddr = "192.168.1.1:51234"
+
+			Convey("It should not be trusted", func() {
+				So(proxies.Trusted(r), ShouldBeFalse)
+			})
+			Convey("Forwarded addresses should be ignored", func() {
+				r.Header.Set(HeaderForwardedFor, "203.0.113.7")
+				So(proxies.ClientAddr(r), ShouldEqual, "192.168.1.1")
+			})
+			Convey("The base URL should not be modified", func() {
+				u := proxies.BaseURL(r, base)
+				So(u.String(), ShouldEqual, "http://localhost:8443/prefix")
+			})
+		})
+
+		Convey("When the base URL has no host", func() {
+			r.RemoteAddr = "192.168.1.1:51234"
+			r.Host = "checkout.example.com"
+			base.Scheme, base.Host = "", ""
+
+			Convey("The requested host should be used", func() {
+				u := proxies.BaseURL(r, base)
+				So(u.String(), ShouldEqual, "http://checkout.example.com/prefix")
+			})
+		})
+	})
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+)
+
+// DefaultShard is the name of the payment database, which holds the data shared
+// by all projects and the payments of the projects not routed to a shard
+const DefaultShard = "default"
+
+// ShardDB is a connection to a shard of the payment database
+type ShardDB struct {
+	Shard string
+	DB    *sql.DB
+}
+
+type shardConns struct {
+	write    *sql.DB
+	readOnly *sql.DB
+}
+
+// ShardRouter maps projects to the shards of the payment database
+//
+// The shards and their projects are defined in the config. Projects not
+// listed for any shard are mapped to the DefaultShard.
+type ShardRouter struct {
+	projects map[int64]string
+	shards   []string
+
+	m     sync.RWMutex
+	conns map[string]shardConns
+}
+
+func shardRouterFromConfig(cfg config.Config) (*ShardRouter, error) {
+	s := &ShardRouter{
+		projects: make(map[int64]string),
+		shards:   []string{DefaultShard},
+		conns:    make(map[string]shardConns),
+	}
+	names := map[string]bool{DefaultShard: true}
+	for _, sh := range cfg.Database.Payment.Shards {
+		if sh.Name == "" {
+			return nil, fmt.Errorf("shard without name")
+		}
+		if names[sh.Name] {
+			return nil, fmt.Errorf("duplicate shard %s", sh.Name)
+		}
+		names[sh.Name] = true
+		if sh.Write == nil {
+			return nil, fmt.Errorf("shard %s without write DB", sh.Name)
+		}
+		for _, projectID := range sh.Projects {
+			if other, ok := s.projects[projectID]; ok {
+				return nil, fmt.Errorf("project %d listed for shards %s and %s", projectID, other, sh.Name)
+			}
+			s.projects[projectID] = sh.Name
+		}
+		s.shards = append(s.shards, sh.Name)
+	}
+	return s, nil
+}
+
+// Shard returns the name of the shard of the given project
+func (s *ShardRouter) Shard(projectID int64) string {
+	if s == nil {
+		return DefaultShard
+	}
+	if shard, ok := s.projects[projectID]; ok {
+		return shard
+	}
+	return DefaultShard
+}
+
+// Shards returns the names of all shards, starting with the DefaultShard
+func (s *ShardRouter) Shards() []string {
+	if s == nil {
+		return []string{DefaultShard}
+	}
+	shards := make([]string, len(s.shards))
+	copy(shards, s.shards)
+	return shards
+}
+
+func (s *ShardRouter) conn(shard string) (shardConns, bool) {
+	s.m.RLock()
+	c, ok := s.conns[shard]
+	s.m.RUnlock()
+	return c, ok
+}
+
+// SetPaymentShardDB sets the DB connection(s) of the payment database shard
+// It will panic if the write connection is nil
+func (ctx *Context) SetPaymentShardDB(shard string, w, ro *sql.DB) {
+	if w == nil {
+		panic("write DB connection cannot be nil")
+	}
+	if shard == DefaultShard {
+		ctx.SetPaymentDB(w, ro)
+		return
+	}
+	ctx.shards.m.Lock()
+	ctx.shards.conns[shard] = shardConns{write: w, readOnly: ro}
+	ctx.shards.m.Unlock()
+	if ctx.dbMonitor != nil {
+		ctx.dbMonitor.Monitor("payment/"+shard+"/write", w, true)
+		ctx.dbMonitor.Monitor("payment/"+shard+"/readonly", ro, false)
+	}
+}
+
+// ProjectPaymentDB returns the *sql.DB for the payment database shard of the
+// given project
+//
+// If the parameter(s) contain a service.ReadOnly, the read-only connection of
+// the shard will be returned if present and not reconnecting.
+func (ctx *Context) ProjectPaymentDB(projectID int64, ros ...dbRequestReadOnly) *sql.DB {
+	return ctx.shardDB(ctx.shards.Shard(projectID), ros...)
+}
+
+// PaymentShardDBs returns the connections to all shards of the payment
+// database, starting with the DefaultShard
+//
+// Jobs and reports spanning projects have to aggregate the results of all
+// shards.
+func (ctx *Context) PaymentShardDBs(ros ...dbRequestReadOnly) []ShardDB {
+	shards := ctx.shards.Shards()
+	dbs := make([]ShardDB, len(shards))
+	for i, shard := range shards {
+		dbs[i] = ShardDB{Shard: shard, DB: ctx.shardDB(shard, ros...)}
+	}
+	return dbs
+}
+
+func (ctx *Context) shardDB(shard string, ros ...dbRequestReadOnly) *sql.DB {
+	if shard == DefaultShard {
+		return ctx.PaymentDB(ros...)
+	}
+	c, _ := ctx.shards.conn(shard)
+	var ro bool
+	for _, r := range ros {
+		if r {
+			ro = true
+		}
+	}
+	if !ro || c.readOnly == nil || ctx.dbMonitor.Down(c.readOnly) {
+		return c.write
+	}
+	return c.readOnly
+}
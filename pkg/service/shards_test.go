@@ -0,0 +1,79 @@
+package service
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+func TestShardRouter(t *testing.T) {
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+
+	Convey("Given a config with payment database shards", t, func() {
+		cfg := config.DefaultConfig()
+		cfg.Database.Payment.Shards = []config.DatabaseShard{
+			{
+				Name:     "eu",
+				Projects: []int64{2, 3},
+				Write:    config.DatabaseConfig{"mysql": "paymentd@tcp(eu:3306)/fritzpay_payment"},
+			},
+			{
+				Name:     "us",
+				Projects: []int64{4},
+				Write:    config.DatabaseConfig{"mysql": "paymentd@tcp(us:3306)/fritzpay_payment"},
+				ReadOnly: config.DatabaseConfig{"mysql": "paymentd@tcp(us-ro:3306)/fritzpay_payment"},
+			},
+		}
+
+		Convey("When the context is created", func() {
+			ctx, err := NewContext(context.Background(), cfg, log)
+			So(err, ShouldBeNil)
+			def, eu, us, usRO := &sql.DB{}, &sql.DB{}, &sql.DB{}, &sql.DB{}
+			ctx.SetPaymentDB(def, nil)
+			ctx.SetPaymentShardDB("eu", eu, nil)
+			ctx.SetPaymentShardDB("us", us, usRO)
+
+			Convey("Projects should be routed to their shards", func() {
+				So(ctx.Shards().Shard(1), ShouldEqual, DefaultShard)
+				So(ctx.Shards().Shard(3), ShouldEqual, "eu")
+				So(ctx.Shards().Shard(4), ShouldEqual, "us")
+				So(ctx.ProjectPaymentDB(1), ShouldEqual, def)
+				So(ctx.ProjectPaymentDB(2), ShouldEqual, eu)
+				So(ctx.ProjectPaymentDB(2, ReadOnly), ShouldEqual, eu)
+				So(ctx.ProjectPaymentDB(4), ShouldEqual, us)
+				So(ctx.ProjectPaymentDB(4, ReadOnly), ShouldEqual, usRO)
+			})
+
+			Convey("All shards should be listed for aggregation", func() {
+				So(ctx.PaymentShardDBs(ReadOnly), ShouldResemble, []ShardDB{
+					{Shard: DefaultShard, DB: def},
+					{Shard: "eu", DB: eu},
+					{Shard: "us", DB: usRO},
+				})
+			})
+		})
+
+		Convey("When a project is routed to two shards", func() {
+			cfg.Database.Payment.Shards[1].Projects = append(cfg.Database.Payment.Shards[1].Projects, 2)
+			_, err := NewContext(context.Background(), cfg, log)
+
+			Convey("The context should not be created", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When a shard is named like the default shard", func() {
+			cfg.Database.Payment.Shards[0].Name = DefaultShard
+			_, err := NewContext(context.Background(), cfg, log)
+
+			Convey("The context should not be created", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+)
+
+// SLO tracks the latency service level objective compliance of endpoints
+//
+// A request complies with the objective if it completes within the latency
+// target of its endpoint without a server error. The counters are kept per
+// instance since the process start.
+type SLO struct {
+	// Latency is the default latency target
+	Latency time.Duration
+	// Objective is the percentage of requests which should comply
+	Objective float64
+	// Targets are latency targets by endpoint
+	Targets map[string]time.Duration
+
+	mu        sync.Mutex
+	endpoints map[string]*sloCounter
+}
+
+type sloCounter struct {
+	requests  int64
+	within    int64
+	errors    int64
+	totalTime time.Duration
+	maxTime   time.Duration
+}
+
+// NewSLO creates a new SLO tracker
+func NewSLO() *SLO {
+	return &SLO{
+		Targets:   make(map[string]time.Duration),
+		endpoints: make(map[string]*sloCounter),
+	}
+}
+
+func (s *SLO) target(endpoint string) time.Duration {
+	if t, ok := s.Targets[endpoint]; ok {
+		return t
+	}
+	return s.Latency
+}
+
+// Observe records a request to the endpoint
+func (s *SLO) Observe(endpoint string, d time.Duration, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.endpoints[endpoint]
+	if !ok {
+		c = &sloCounter{}
+		s.endpoints[endpoint] = c
+	}
+	c.requests++
+	c.totalTime += d
+	if d > c.maxTime {
+		c.maxTime = d
+	}
+	if status >= 500 {
+		c.errors++
+		return
+	}
+	if d <= s.target(endpoint) {
+		c.within++
+	}
+}
+
+// SLOEndpoint is a snapshot of the SLO compliance of an endpoint
+type SLOEndpoint struct {
+	Endpoint string
+	Target   string
+	Requests int64
+	// Requests completed within the target without a server error
+	WithinTarget int64
+	ServerErrors int64
+	AverageTime  string
+	MaxTime      string
+	// Compliance is the percentage of complying requests
+	Compliance float64
+	// Met is true if the compliance satisfies the objective
+	Met bool
+}
+
+// Endpoints returns a snapshot of all observed endpoints, sorted by endpoint
+func (s *SLO) Endpoints() []SLOEndpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	eps := make([]SLOEndpoint, 0, len(s.endpoints))
+	for name, c := range s.endpoints {
+		e := SLOEndpoint{
+			Endpoint:     name,
+			Target:       s.target(name).String(),
+			Requests:     c.requests,
+			WithinTarget: c.within,
+			ServerErrors: c.errors,
+			AverageTime:  (c.totalTime / time.Duration(c.requests)).String(),
+			MaxTime:      c.maxTime.String(),
+			Compliance:   float64(c.within) / float64(c.requests) * 100,
+		}
+		e.Met = e.Compliance >= s.Objective
+		eps = append(eps, e)
+	}
+	sort.Sort(sloEndpointsByName(eps))
+	return eps
+}
+
+type sloEndpointsByName []SLOEndpoint
+
+func (s sloEndpointsByName) Len() int           { return len(s) }
+func (s sloEndpointsByName) Less(i, j int) bool { return s[i].Endpoint < s[j].Endpoint }
+func (s sloEndpointsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// statusRecorder records the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// SLOHandler wraps the given handler and records its latency SLO compliance
+//
+// Requests will be recorded under the endpoint name prefixed with the request
+// method, e.g. "GET /v1/payment".
+func (ctx *Context) SLOHandler(endpoint string, parent http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if err := recover(); err != nil {
+				ctx.slo.Observe(r.Method+" "+endpoint, time.Since(start), http.StatusInternalServerError)
+				panic(err)
+			}
+			ctx.slo.Observe(r.Method+" "+endpoint, time.Since(start), status)
+		}()
+		parent.ServeHTTP(rec, r)
+	})
+}
+
+func sloFromConfig(cfg config.Config) (*SLO, error) {
+	s := NewSLO()
+	var err error
+	if cfg.API.SLO.Latency != "" {
+		if s.Latency, err = cfg.API.SLO.Latency.Duration(); err != nil {
+			return nil, err
+		}
+	}
+	s.Objective = cfg.API.SLO.Objective
+	for endpoint, d := range cfg.API.SLO.Targets {
+		if s.Targets[endpoint], err = d.Duration(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSLO(t *testing.T) {
+	Convey("Given an SLO tracker", t, func() {
+		s := NewSLO()
+		s.Latency = 100 * time.Millisecond
+		s.Objective = 75
+		s.Targets["POST /v1/payment"] = time.Second
+
+		Convey("When requests are observed", func() {
+			s.Observe("GET /v1/payment", 10*time.Millisecond, http.StatusOK)
+			s.Observe("GET /v1/payment", 20*time.Millisecond, http.StatusNotFound)
+			s.Observe("GET /v1/payment", 200*time.Millisecond, http.StatusOK)
+			s.Observe("GET /v1/payment", 10*time.Millisecond, http.StatusInternalServerError)
+			s.Observe("POST /v1/payment", 500*time.Millisecond, http.StatusOK)
+
+			eps := s.Endpoints()
+			So(len(eps), ShouldEqual, 2)
+
+			Convey("Slow requests and server errors should not comply", func() {
+				So(eps[0].Endpoint, ShouldEqual, "GET /v1/payment")
+				So(eps[0].Requests, ShouldEqual, 4)
+				So(eps[0].WithinTarget, ShouldEqual, 2)
+				So(eps[0].ServerErrors, ShouldEqual, 1)
+				So(eps[0].Compliance, ShouldEqual, 50)
+				So(eps[0].Met, ShouldBeFalse)
+				So(eps[0].MaxTime, ShouldEqual, "200ms")
+			})
+			Convey("Endpoint targets should override the default", func() {
+				So(eps[1].Endpoint, ShouldEqual, "POST /v1/payment")
+				So(eps[1].Target, ShouldEqual, "1s")
+				So(eps[1].Compliance, ShouldEqual, 100)
+				So(eps[1].Met, ShouldBeTrue)
+			})
+		})
+
+		Convey("Given a context with the tracker", func() {
+			ctx := &Context{slo: s}
+			h := ctx.SLOHandler("/v1/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			Convey("When a request is handled", func() {
+				r, _ := http.NewRequest("PUT", "/v1/test", nil)
+				h.ServeHTTP(httptest.NewRecorder(), r)
+				Convey("It should be recorded with its status", func() {
+					eps := s.Endpoints()
+					So(len(eps), ShouldEqual, 1)
+					So(eps[0].Endpoint, ShouldEqual, "PUT /v1/test")
+					So(eps[0].ServerErrors, ShouldEqual, 1)
+				})
+			})
+		})
+	})
+}
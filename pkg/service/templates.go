@@ -0,0 +1,104 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Templates is a set of templates which can be reloaded
+//
+// It is implemented by the template.Manager.
+type Templates interface {
+	// Dir returns the template directory
+	Dir() string
+	// Changed returns true if the templates changed since they were loaded
+	Changed() (bool, error)
+	// Load (re-)loads the templates
+	Load() error
+}
+
+// TemplateRegistry holds the templates of the running services, so they can be
+// reloaded at once
+type TemplateRegistry struct {
+	mu        sync.RWMutex
+	templates map[string]Templates
+}
+
+// NewTemplateRegistry creates a new template registry
+func NewTemplateRegistry() *TemplateRegistry {
+	return &TemplateRegistry{templates: make(map[string]Templates)}
+}
+
+// Register registers the templates under the given name
+func (r *TemplateRegistry) Register(name string, t Templates) {
+	r.mu.Lock()
+	r.templates[name] = t
+	r.mu.Unlock()
+}
+
+// Names returns the names of the registered templates
+func (r *TemplateRegistry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Load (re-)loads the templates of all registered template sets
+//
+// It returns the errors by name. Template sets with errors keep their
+// previously loaded templates.
+func (r *TemplateRegistry) Load() map[string]error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	errs := make(map[string]error)
+	for name, t := range r.templates {
+		if err := t.Load(); err != nil {
+			errs[name] = err
+		}
+	}
+	return errs
+}
+
+// Watch checks the template directories of all registered templates for changes
+// in the given interval and reloads the changed ones
+//
+// It returns when the done channel is closed. Broken templates will be
+// logged. The previously loaded templates will be used until they are fixed.
+func (r *TemplateRegistry) Watch(interval time.Duration, done <-chan struct{}, log log15.Logger) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			r.mu.RLock()
+			for name, t := range r.templates {
+				log := log.New(log15.Ctx{"templates": name, "tmplDir": t.Dir()})
+				changed, err := t.Changed()
+				if err != nil {
+					log.Error("error checking templates", log15.Ctx{"err": err})
+					continue
+				}
+				if !changed {
+					continue
+				}
+				err = t.Load()
+				if err != nil {
+					log.Error("error reloading templates", log15.Ctx{"err": err})
+					continue
+				}
+				log.Info("templates reloaded")
+			}
+			r.mu.RUnlock()
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/job"
+	"github.com/fritzpay/paymentd/pkg/paymentd/usage"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// ContextVarUsageKey is the name of the key under which the usage of a
+	// request will be stored in request contexts
+	ContextVarUsageKey = "Usage"
+)
+
+const (
+	// JobUsageRollup rolls up the API usage of the previous month
+	JobUsageRollup = "usage.rollup"
+	// usageRollupDelay is the time after the end of a month after which it
+	// will be rolled up. Instances might still record counts of the month
+	// before.
+	usageRollupDelay = time.Hour
+)
+
+// UsageRecorder counts the API requests of projects and records the counts
+// periodically
+//
+// Only requests which were attributed to a project with SetRequestProject will
+// be counted.
+type UsageRecorder struct {
+	ctx  *Context
+	log  log15.Logger
+	host string
+
+	// FlushInterval is the interval in which the counts will be recorded. The
+	// usage will not be counted if it is not positive
+	FlushInterval time.Duration
+	// TopEndpoints is the number of endpoints listed in usage summaries
+	TopEndpoints int
+
+	mu     sync.Mutex
+	counts map[usageKey]*usage.Counts
+}
+
+type usageKey struct {
+	projectID int64
+	endpoint  string
+	hour      int64
+}
+
+// usageRequest is the usage of a request
+type usageRequest struct {
+	projectID int64
+}
+
+func newUsageRecorder(ctx *Context) *UsageRecorder {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &UsageRecorder{
+		ctx: ctx,
+		log: ctx.Log().New(log15.Ctx{
+			"pkg":  "github.com/fritzpay/paymentd/pkg/service",
+			"type": "UsageRecorder",
+		}),
+		host:   host,
+		counts: make(map[usageKey]*usage.Counts),
+	}
+}
+
+func usageFromConfig(ctx *Context, cfg config.Config) (*UsageRecorder, error) {
+	u := newUsageRecorder(ctx)
+	var err error
+	if cfg.API.Usage.FlushInterval != "" {
+		if u.FlushInterval, err = cfg.API.Usage.FlushInterval.Duration(); err != nil {
+			return nil, err
+		}
+	}
+	u.TopEndpoints = cfg.API.Usage.TopEndpoints
+	return u, nil
+}
+
+// Enabled returns true if the usage is counted
+func (u *UsageRecorder) Enabled() bool {
+	return u.FlushInterval > 0
+}
+
+// Observe counts a request of the project to the endpoint
+func (u *UsageRecorder) Observe(projectID int64, endpoint string, status int, at time.Time) {
+	k := usageKey{
+		projectID: projectID,
+		endpoint:  endpoint,
+		hour:      at.Truncate(time.Hour).UnixNano(),
+	}
+	u.mu.Lock()
+	c, ok := u.counts[k]
+	if !ok {
+		c = &usage.Counts{}
+		u.counts[k] = c
+	}
+	c.Observe(status)
+	u.mu.Unlock()
+}
+
+// take returns the current counts and resets them
+func (u *UsageRecorder) take() map[usageKey]*usage.Counts {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counts := u.counts
+	u.counts = make(map[usageKey]*usage.Counts)
+	return counts
+}
+
+// restore adds counts which could not be recorded to the current counts
+func (u *UsageRecorder) restore(counts map[usageKey]*usage.Counts) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for k, c := range counts {
+		if cur, ok := u.counts[k]; ok {
+			cur.Add(*c)
+		} else {
+			u.counts[k] = c
+		}
+	}
+}
+
+// Flush records the current counts
+//
+// Counts which could not be recorded will be kept for the next flush.
+func (u *UsageRecorder) Flush() error {
+	counts := u.take()
+	if len(counts) == 0 {
+		return nil
+	}
+	// the counts are recorded in the payment database shard of their project
+	shards := make(map[string]map[usageKey]*usage.Counts)
+	for k, c := range counts {
+		shard := u.ctx.Shards().Shard(k.projectID)
+		if shards[shard] == nil {
+			shards[shard] = make(map[usageKey]*usage.Counts)
+		}
+		shards[shard][k] = c
+	}
+	var err error
+	for shard, counts := range shards {
+		if shardErr := u.flushShard(shard, counts); shardErr != nil {
+			u.restore(counts)
+			err = shardErr
+		}
+	}
+	return err
+}
+
+func (u *UsageRecorder) flushShard(shard string, counts map[usageKey]*usage.Counts) error {
+	now := time.Now()
+	records := make([]*usage.Record, 0, len(counts))
+	for k, c := range counts {
+		records = append(records, &usage.Record{
+			ProjectID: k.projectID,
+			Endpoint:  k.endpoint,
+			Hour:      time.Unix(0, k.hour),
+			Host:      u.host,
+			Timestamp: now,
+			Counts:    *c,
+		})
+	}
+	tx, err := u.ctx.shardDB(shard).Begin()
+	if err != nil {
+		return err
+	}
+	err = usage.InsertRecordsTx(tx, records)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			u.log.Crit("error on rollback", log15.Ctx{"err": rbErr})
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// Start records the counts in the configured interval until the context is
+// done
+func (u *UsageRecorder) Start() {
+	if !u.Enabled() {
+		return
+	}
+	go func() {
+		tick := time.NewTicker(u.FlushInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-u.ctx.Done():
+				if err := u.Flush(); err != nil {
+					u.log.Error("error recording usage on shutdown", log15.Ctx{"err": err})
+				}
+				return
+			case <-tick.C:
+				if err := u.Flush(); err != nil {
+					u.log.Error("error recording usage", log15.Ctx{"err": err})
+				}
+			}
+		}
+	}()
+}
+
+// RegisterJobs registers the monthly rollup with the job runner
+func (u *UsageRecorder) RegisterJobs(r *JobRunner) error {
+	daily, err := job.ParseSchedule("@daily")
+	if err != nil {
+		return err
+	}
+	return r.Register(&Job{
+		Name:     JobUsageRollup,
+		Schedule: daily,
+		Retry: job.RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     10 * time.Minute,
+		},
+		Run: u.rollupPreviousMonth,
+	})
+}
+
+func (u *UsageRecorder) rollupPreviousMonth(done <-chan struct{}) error {
+	now := time.Now()
+	month := usage.Month(now)
+	if now.Sub(month) < usageRollupDelay {
+		// the previous month will be rolled up on the next run
+		return nil
+	}
+	err := u.Rollup(month.AddDate(0, -1, 0))
+	if err == usage.ErrRolledUp {
+		return nil
+	}
+	return err
+}
+
+// Rollup aggregates the recorded usage of the given month
+//
+// Each payment database shard is rolled up separately. It returns
+// usage.ErrRolledUp if the month was already rolled up in all shards.
+func (u *UsageRecorder) Rollup(month time.Time) error {
+	month = usage.Month(month)
+	var rolledUp int
+	shards := u.ctx.PaymentShardDBs(ReadOnly)
+	for _, sh := range shards {
+		err := u.rollupShard(sh, month)
+		if err == usage.ErrRolledUp {
+			rolledUp++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("shard %s: %v", sh.Shard, err)
+		}
+	}
+	if rolledUp == len(shards) {
+		return usage.ErrRolledUp
+	}
+	return nil
+}
+
+func (u *UsageRecorder) rollupShard(sh ShardDB, month time.Time) error {
+	counts, err := usage.RecordCountsDB(sh.DB, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return err
+	}
+	tx, err := u.ctx.shardDB(sh.Shard).Begin()
+	if err != nil {
+		return err
+	}
+	err = usage.InsertRollupsTx(tx, month, counts)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			u.log.Crit("error on rollback", log15.Ctx{"err": rbErr})
+		}
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	u.log.Info("rolled up usage", log15.Ctx{
+		"month":    month.Format("2006-01"),
+		"shard":    sh.Shard,
+		"projects": len(counts),
+	})
+	return nil
+}
+
+// Summary returns the usage of the project in the given month
+//
+// Rolled up months will be read from the rollup. The usage of other months
+// includes the recorded counts only.
+func (u *UsageRecorder) Summary(projectID int64, month time.Time) (*usage.Summary, error) {
+	month = usage.Month(month)
+	db := u.ctx.ProjectPaymentDB(projectID, ReadOnly)
+	rolledUp, err := usage.RolledUpDB(db, month)
+	if err != nil {
+		return nil, err
+	}
+	var endpoints map[string]usage.Counts
+	if rolledUp {
+		endpoints, err = usage.RollupsByProjectDB(db, projectID, month)
+	} else {
+		endpoints, err = usage.RecordCountsByProjectDB(db, projectID, month, month.AddDate(0, 1, 0))
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := usage.Summarize(projectID, month, endpoints, u.TopEndpoints)
+	s.RolledUp = rolledUp
+	return s, nil
+}
+
+// Projects returns the usage of all projects in the given month, by requests
+// descending
+//
+// The usage is aggregated across all payment database shards.
+func (u *UsageRecorder) Projects(month time.Time) ([]usage.Project, error) {
+	month = usage.Month(month)
+	counts := make(usage.ProjectCounts)
+	for _, sh := range u.ctx.PaymentShardDBs(ReadOnly) {
+		shardCounts, err := u.shardCounts(sh.DB, month)
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %v", sh.Shard, err)
+		}
+		counts.Merge(shardCounts)
+	}
+	projects := make([]usage.Project, 0, len(counts))
+	for projectID, endpoints := range counts {
+		p := usage.Project{ProjectID: projectID}
+		for _, c := range endpoints {
+			p.Counts.Add(c)
+		}
+		p.ErrorRate = p.Counts.ErrorRate()
+		projects = append(projects, p)
+	}
+	usage.SortProjects(projects)
+	return projects, nil
+}
+
+func (u *UsageRecorder) shardCounts(db *sql.DB, month time.Time) (usage.ProjectCounts, error) {
+	rolledUp, err := usage.RolledUpDB(db, month)
+	if err != nil {
+		return nil, err
+	}
+	if rolledUp {
+		return usage.RollupsDB(db, month)
+	}
+	return usage.RecordCountsDB(db, month, month.AddDate(0, 1, 0))
+}
+
+// UsageHandler wraps the given handler and counts the API usage of its requests
+//
+// Requests will be counted under the endpoint name prefixed with the request
+// method, e.g. "GET /v1/payment", once they are attributed to a project.
+func (ctx *Context) UsageHandler(endpoint string, parent http.Handler) http.Handler {
+	if !ctx.usage.Enabled() {
+		return parent
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &usageRequest{}
+		SetRequestContextVar(r, ContextVarUsageKey, req)
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if req.projectID == 0 {
+				return
+			}
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if err := recover(); err != nil {
+				ctx.usage.Observe(req.projectID, r.Method+" "+endpoint, http.StatusInternalServerError, time.Now())
+				panic(err)
+			}
+			ctx.usage.Observe(req.projectID, r.Method+" "+endpoint, status, time.Now())
+		}()
+		parent.ServeHTTP(rec, r)
+	})
+}
+
+// SetRequestProject attributes the request to the project in the API usage
+func SetRequestProject(r *http.Request, projectID int64) {
+	ctx := RequestContext(r)
+	if ctx == nil {
+		return
+	}
+	if req, ok := ctx.Value(ContextVarUsageKey).(*usageRequest); ok {
+		req.projectID = projectID
+	}
+}
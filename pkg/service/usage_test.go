@@ -0,0 +1,90 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/usage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUsage(t *testing.T) {
+	Convey("Given a usage recorder", t, func() {
+		u := &UsageRecorder{
+			FlushInterval: time.Minute,
+			counts:        make(map[usageKey]*usage.Counts),
+		}
+		at := time.Date(2015, 2, 11, 10, 18, 0, 0, time.UTC)
+
+		Convey("When requests are observed", func() {
+			u.Observe(1, "GET /v1/payment", http.StatusOK, at)
+			u.Observe(1, "GET /v1/payment", http.StatusNotFound, at.Add(time.Minute))
+			u.Observe(1, "GET /v1/payment", http.StatusOK, at.Add(time.Hour))
+			u.Observe(2, "GET /v1/payment", http.StatusBadGateway, at)
+
+			Convey("They should be counted by project, endpoint and hour", func() {
+				counts := u.take()
+				So(len(counts), ShouldEqual, 3)
+				c := counts[usageKey{1, "GET /v1/payment", at.Truncate(time.Hour).UnixNano()}]
+				So(c.Requests, ShouldEqual, 2)
+				So(c.ClientErrors, ShouldEqual, 1)
+				So(counts[usageKey{2, "GET /v1/payment", at.Truncate(time.Hour).UnixNano()}].ServerErrors, ShouldEqual, 1)
+
+				Convey("The counts should be reset", func() {
+					So(len(u.take()), ShouldEqual, 0)
+				})
+				Convey("When the counts are restored", func() {
+					u.Observe(2, "GET /v1/payment", http.StatusOK, at)
+					u.restore(counts)
+
+					Convey("They should be added to the current counts", func() {
+						restored := u.take()
+						So(len(restored), ShouldEqual, 3)
+						So(restored[usageKey{2, "GET /v1/payment", at.Truncate(time.Hour).UnixNano()}].Requests, ShouldEqual, 2)
+					})
+				})
+			})
+		})
+
+		Convey("Given a context with the recorder", func() {
+			ctx := &Context{usage: u}
+			project := int64(0)
+			h := ctx.UsageHandler("/v1/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if project != 0 {
+					SetRequestProject(r, project)
+				}
+				w.WriteHeader(http.StatusBadRequest)
+			}))
+			serve := func() {
+				r, _ := http.NewRequest("PUT", "/v1/test", nil)
+				SetRequestContext(r, ctx)
+				defer ClearRequestContext(r)
+				h.ServeHTTP(httptest.NewRecorder(), r)
+			}
+
+			Convey("When a request is attributed to a project", func() {
+				project = 3
+				serve()
+
+				Convey("It should be counted with its status", func() {
+					counts := u.take()
+					So(len(counts), ShouldEqual, 1)
+					for k, c := range counts {
+						So(k.projectID, ShouldEqual, 3)
+						So(k.endpoint, ShouldEqual, "PUT /v1/test")
+						So(c.ClientErrors, ShouldEqual, 1)
+					}
+				})
+			})
+			Convey("When a request is not attributed to a project", func() {
+				serve()
+
+				Convey("It should not be counted", func() {
+					So(len(u.take()), ShouldEqual, 0)
+				})
+			})
+		})
+	})
+}
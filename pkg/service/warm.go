@@ -0,0 +1,105 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// WarmFunc pre-loads entries into the shared cache
+//
+// It returns the number of entries loaded.
+type WarmFunc func() (int, error)
+
+type cacheWarmer struct {
+	name string
+	warm WarmFunc
+}
+
+// CacheWarmer pre-loads the shared cache when an instance starts
+//
+// The instance is reported as ready once all registered warmers ran. Failing
+// warmers will be logged and do not prevent the instance from becoming ready,
+// since the cache will be filled on demand.
+type CacheWarmer struct {
+	log    log15.Logger
+	active bool
+
+	m       sync.Mutex
+	warmers []cacheWarmer
+	warmed  map[string]int
+
+	ready int32
+}
+
+func newCacheWarmer(ctx *Context) *CacheWarmer {
+	return &CacheWarmer{
+		log: ctx.Log().New(log15.Ctx{
+			"pkg":  "github.com/fritzpay/paymentd/pkg/service",
+			"type": "CacheWarmer",
+		}),
+		active: ctx.Config().Cache.Warm,
+		warmed: make(map[string]int),
+	}
+}
+
+// Register adds a warmer
+//
+// A warmer registered under an existing name replaces the previous one.
+func (w *CacheWarmer) Register(name string, f WarmFunc) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	for i := range w.warmers {
+		if w.warmers[i].name == name {
+			w.warmers[i].warm = f
+			return
+		}
+	}
+	w.warmers = append(w.warmers, cacheWarmer{name: name, warm: f})
+}
+
+// Warm runs the registered warmers in the order of their registration and
+// marks the instance as ready
+//
+// If cache warming is disabled, the instance will be marked ready immediately.
+func (w *CacheWarmer) Warm() {
+	if w.active {
+		w.m.Lock()
+		warmers := make([]cacheWarmer, len(w.warmers))
+		copy(warmers, w.warmers)
+		w.m.Unlock()
+
+		start := time.Now()
+		for _, cw := range warmers {
+			log := w.log.New(log15.Ctx{"warmer": cw.name})
+			n, err := cw.warm()
+			if err != nil {
+				log.Error("error warming cache", log15.Ctx{"err": err})
+			}
+			w.m.Lock()
+			w.warmed[cw.name] = n
+			w.m.Unlock()
+			log.Info("warmed cache", log15.Ctx{"entries": n})
+		}
+		w.log.Info("cache warm", log15.Ctx{"duration": time.Since(start)})
+	}
+	atomic.StoreInt32(&w.ready, 1)
+}
+
+// Ready returns true if the cache was warmed
+func (w *CacheWarmer) Ready() bool {
+	return atomic.LoadInt32(&w.ready) == 1
+}
+
+// Warmed returns the number of entries loaded by each warmer
+func (w *CacheWarmer) Warmed() map[string]int {
+	w.m.Lock()
+	defer w.m.Unlock()
+	warmed := make(map[string]int, len(w.warmed))
+	for name, n := range w.warmed {
+		warmed[name] = n
+	}
+	return warmed
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCacheWarmer(t *testing.T) {
+	Convey("Given a cache warmer", t, WithContext(func(ctx *Context) {
+		ctx.cfg.Cache.Warm = true
+		w := newCacheWarmer(ctx)
+		var order []string
+		warmer := func(name string, n int, err error) WarmFunc {
+			return func() (int, error) {
+				order = append(order, name)
+				return n, err
+			}
+		}
+
+		Convey("It should not be ready before warming", func() {
+			So(w.Ready(), ShouldBeFalse)
+		})
+
+		Convey("When warming the registered warmers", func() {
+			w.Register("first", warmer("first", 2, nil))
+			w.Register("failing", warmer("failing", 0, errors.New("db down")))
+			w.Register("second", warmer("second", 0, nil))
+			w.Register("first", warmer("replaced", 3, nil))
+			w.Warm()
+
+			Convey("The warmers should run in the order of their registration", func() {
+				So(len(order), ShouldEqual, 3)
+				So(order[0], ShouldEqual, "replaced")
+				So(order[1], ShouldEqual, "failing")
+				So(order[2], ShouldEqual, "second")
+			})
+			Convey("It should be ready despite failing warmers", func() {
+				So(w.Ready(), ShouldBeTrue)
+			})
+			Convey("It should report the loaded entries", func() {
+				warmed := w.Warmed()
+				So(len(warmed), ShouldEqual, 3)
+				So(warmed["first"], ShouldEqual, 3)
+			})
+		})
+
+		Convey("Given cache warming is disabled", func() {
+			ctx.cfg.Cache.Warm = false
+			w = newCacheWarmer(ctx)
+			w.Register("first", warmer("first", 2, nil))
+
+			Convey("When warming", func() {
+				w.Warm()
+
+				Convey("No warmer should run", func() {
+					So(len(order), ShouldEqual, 0)
+				})
+				Convey("It should be ready", func() {
+					So(w.Ready(), ShouldBeTrue)
+				})
+			})
+		})
+	}))
+}
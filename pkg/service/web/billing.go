@@ -0,0 +1,156 @@
+package web
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/fritzpay/paymentd/pkg/billing"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+	"github.com/fritzpay/paymentd/pkg/validate"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	billingTemplate = "/payment/billing.html.tmpl"
+)
+
+// billingFormMaxSize is the maximum size of submitted billing forms
+const billingFormMaxSize = 1 << 16
+
+// billingPage is the template data of the billing form
+type billingPage struct {
+	// Country is the payment country
+	Country string
+	Fields  billing.Fields
+	Values  billing.Data
+	// Errors are the localized messages of invalid form parameters
+	Errors map[string]string
+}
+
+// billingFields returns the billing fields collected for the payment
+//
+// These are the checkout fields of the project and the fields required by the
+// provider.
+func (h *Handler) billingFields(p *payment.Payment, driver provider.Driver) (billing.Fields, error) {
+	fields, err := h.paymentService.CheckoutFields(p)
+	if err != nil {
+		return nil, err
+	}
+	if req, ok := driver.(provider.BillingRequirer); ok {
+		fields = fields.Require(req.RequiredBillingFields(p.Config.Country.String)...)
+	}
+	return fields, nil
+}
+
+// collectBilling serves the billing form until the customer submitted the
+// required billing fields of the payment
+//
+// It returns true if the payment can be processed.
+func (h *Handler) collectBilling(p *payment.Payment, driver provider.Driver, w http.ResponseWriter, r *http.Request) bool {
+	log := h.log.New(log15.Ctx{
+		"method":    "collectBilling",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	fields, err := h.billingFields(p, driver)
+	if err != nil {
+		log.Error("error retrieving billing fields", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if len(fields) == 0 {
+		return true
+	}
+	err = payment.PaymentBillingDB(h.ctx, h.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly), p)
+	if err != nil {
+		log.Error("error retrieving payment billing", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if r.Method != "POST" {
+		if p.Billing != nil && fields.Complete(&p.Billing.Data) {
+			return true
+		}
+		page := billingPage{
+			Country: p.Config.Country.String,
+			Fields:  fields,
+		}
+		if p.Billing != nil {
+			page.Values = p.Billing.Data
+		}
+		h.renderPage(billingTemplate, page, w, r)
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, billingFormMaxSize)
+	err = r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	data, err := fields.Parse(p.Config.Country.String, r.PostForm)
+	if err != nil {
+		errs, ok := err.(validate.Errors)
+		if !ok {
+			log.Error("error parsing billing form", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusBadRequest)
+			return false
+		}
+		lang := validate.Language(r.Header.Get("Accept-Language"))
+		page := billingPage{
+			Country: p.Config.Country.String,
+			Fields:  fields,
+			Values: billing.Data{
+				VATID:      r.PostForm.Get(billing.FieldVATID),
+				CPF:        r.PostForm.Get(billing.FieldCPF),
+				Street:     r.PostForm.Get(billing.ParamStreet),
+				PostalCode: r.PostForm.Get(billing.ParamPostalCode),
+				City:       r.PostForm.Get(billing.ParamCity),
+			},
+			Errors: make(map[string]string, len(errs)),
+		}
+		for _, e := range errs {
+			page.Errors[e.Field] = e.Message(lang)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		h.renderPage(billingTemplate, page, w, r)
+		return false
+	}
+	p.Billing = p.NewBilling(*data)
+	err = h.setPaymentBilling(p)
+	if err != nil {
+		log.Error("error saving payment billing", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	// continue the checkout with a GET request
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+	return false
+}
+
+func (h *Handler) setPaymentBilling(p *payment.Payment) error {
+	var tx *sql.Tx
+	var commit bool
+	var err error
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				h.log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	tx, err = h.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
+	if err != nil {
+		commit = true
+		return err
+	}
+	err = h.paymentService.SetPaymentBilling(tx, p)
+	if err != nil {
+		return err
+	}
+	commit = true
+	return tx.Commit()
+}
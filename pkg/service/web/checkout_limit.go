@@ -0,0 +1,83 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// checkoutLimit limits the provider init attempts of the hosted checkout per
+// payment and per client address
+//
+// The attempts are counted in the shared cache within fixed windows. Attempts
+// will not be limited if the cache is unavailable.
+type checkoutLimit struct {
+	paymentAttempts int
+	addressAttempts int
+	window          time.Duration
+}
+
+func (h *Handler) checkoutLimitFromConfig() (checkoutLimit, error) {
+	cfg := h.ctx.Config().Web.CheckoutLimit
+	l := checkoutLimit{
+		paymentAttempts: cfg.PaymentAttempts,
+		addressAttempts: cfg.AddressAttempts,
+	}
+	if cfg.Window == "" {
+		return l, nil
+	}
+	var err error
+	l.window, err = cfg.Window.Duration()
+	return l, err
+}
+
+// allowCheckoutAttempt counts a provider init attempt of the payment
+//
+// If the attempt exceeds a limit, the throttling page will be served and false
+// will be returned.
+func (h *Handler) allowCheckoutAttempt(p *payment.Payment, w http.ResponseWriter, r *http.Request) bool {
+	l := h.checkoutLimit
+	if l.window <= 0 {
+		return true
+	}
+	log := h.log.New(log15.Ctx{
+		"method":    "allowCheckoutAttempt",
+		"projectID": p.ProjectID(),
+		"paymentID": p.ID(),
+	})
+	now := time.Now()
+	slot := now.UnixNano() / int64(l.window)
+	suffix := ":" + strconv.FormatInt(slot, 10)
+	addr := h.ctx.TrustedProxies().ClientAddr(r)
+	checks := []struct {
+		key   string
+		limit int
+	}{
+		{"checkout:payment:" + strconv.FormatInt(p.ProjectID(), 10) + "-" + strconv.FormatInt(p.ID(), 10) + suffix, l.paymentAttempts},
+		{"checkout:address:" + addr + suffix, l.addressAttempts},
+	}
+	for _, c := range checks {
+		if c.limit <= 0 {
+			continue
+		}
+		n, err := h.ctx.Cache().Incr(c.key, l.window)
+		if err != nil {
+			log.Error("error on checkout attempt counter", log15.Ctx{"err": err})
+			continue
+		}
+		if n > int64(c.limit) {
+			log.Warn("checkout attempts exceeded", log15.Ctx{
+				"clientAddress": addr,
+				"limit":         c.key,
+			})
+			retryAfter := time.Unix(0, (slot+1)*int64(l.window)).Sub(now)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return false
+		}
+	}
+	return true
+}
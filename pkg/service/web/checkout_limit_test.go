@@ -0,0 +1,84 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckoutLimit(t *testing.T) {
+	Convey("Given a web handler with a checkout limit", t, func() {
+		log := log15.New()
+		log.SetHandler(log15.DiscardHandler())
+		ctx, err := service.NewContext(context.Background(), config.DefaultConfig(), log)
+		So(err, ShouldBeNil)
+		h := &Handler{
+			ctx: ctx,
+			log: log,
+			checkoutLimit: checkoutLimit{
+				paymentAttempts: 2,
+				addressAttempts: 3,
+				window:          time.Hour,
+			},
+		}
+		newPayment := func(projectID int64) *payment.Payment {
+			p := &payment.Payment{}
+			So(p.SetProject(&project.Project{ID: projectID}), ShouldBeNil)
+			return p
+		}
+		attempt := func(p *payment.Payment, addr string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r, err := http.NewRequest("GET", "/payment", nil)
+			So(err, ShouldBeNil)
+			r.RemoteAddr = addr
+			h.allowCheckoutAttempt(p, w, r)
+			return w
+		}
+
+		Convey("When a payment is attempted within the limit", func() {
+			p := newPayment(1)
+			So(attempt(p, "192.0.2.1:1234").Code, ShouldEqual, http.StatusOK)
+			So(attempt(p, "192.0.2.1:1234").Code, ShouldEqual, http.StatusOK)
+
+			Convey("Another attempt should be throttled", func() {
+				w := attempt(p, "192.0.2.2:1234")
+				So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+				So(w.Header().Get("Retry-After"), ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("When a client attempts several payments", func() {
+			for i := int64(10); i < 13; i++ {
+				So(attempt(newPayment(i), "192.0.2.3:1234").Code, ShouldEqual, http.StatusOK)
+			}
+
+			Convey("Another payment of the client should be throttled", func() {
+				So(attempt(newPayment(13), "192.0.2.3:1234").Code, ShouldEqual, http.StatusTooManyRequests)
+			})
+			Convey("Other clients should not be throttled", func() {
+				So(attempt(newPayment(14), "192.0.2.4:1234").Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When the limit is disabled", func() {
+			h.checkoutLimit.window = 0
+			p := newPayment(20)
+
+			Convey("Attempts should not be throttled", func() {
+				for i := 0; i < 5; i++ {
+					So(attempt(p, "192.0.2.5:1234").Code, ShouldEqual, http.StatusOK)
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,486 @@
+package web
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	tmpl "github.com/fritzpay/paymentd/pkg/template"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/metadata"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"golang.org/x/text/language"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	CustomerPath      = "/customer"
+	CustomerRetryPath = "/customer/retry"
+)
+
+const (
+	customerPageTemplate = "/customer/payments.html.tmpl"
+)
+
+// CustomerLink is a link to the customer portal
+//
+// The link is created and signed by the merchant using the secret of a project
+// key. It grants access to the payments of the customer of the project until
+// it expires.
+type CustomerLink struct {
+	ProjectKey string
+	Customer   string
+	// Expires is the unix timestamp until which the link is valid
+	Expires int64
+
+	hexSignature string
+}
+
+// ReadFromQuery reads the link parameters from the given URL query
+func (l *CustomerLink) ReadFromQuery(q url.Values) error {
+	l.ProjectKey = q.Get("ProjectKey")
+	if l.ProjectKey == "" {
+		return errors.New("no project key")
+	}
+	l.Customer = q.Get("Customer")
+	if l.Customer == "" {
+		return errors.New("no customer")
+	}
+	var err error
+	l.Expires, err = strconv.ParseInt(q.Get("Expires"), 10, 64)
+	if err != nil {
+		return errors.New("invalid expires")
+	}
+	l.hexSignature = q.Get("Signature")
+	if l.hexSignature == "" {
+		return errors.New("no signature")
+	}
+	return nil
+}
+
+// Query returns the URL query of the link
+func (l *CustomerLink) Query() url.Values {
+	q := url.Values{}
+	q.Set("ProjectKey", l.ProjectKey)
+	q.Set("Customer", l.Customer)
+	q.Set("Expires", strconv.FormatInt(l.Expires, 10))
+	q.Set("Signature", l.hexSignature)
+	return q
+}
+
+// Expired returns true if the link is expired
+func (l *CustomerLink) Expired() bool {
+	return time.Unix(l.Expires, 0).Before(time.Now())
+}
+
+// Sign signs the link with the given project key secret
+func (l *CustomerLink) Sign(secret []byte) error {
+	sig, err := service.Sign(l, secret)
+	if err != nil {
+		return err
+	}
+	l.hexSignature = hex.EncodeToString(sig)
+	return nil
+}
+
+// Message implements service.Signable
+func (l *CustomerLink) Message() ([]byte, error) {
+	return []byte(l.ProjectKey + l.Customer + strconv.FormatInt(l.Expires, 10)), nil
+}
+
+// HashFunc implements service.Signable
+func (l *CustomerLink) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+// Signature implements service.Signed
+func (l *CustomerLink) Signature() ([]byte, error) {
+	return hex.DecodeString(l.hexSignature)
+}
+
+// customerPage is the template data of the customer portal page
+type customerPage struct {
+	ProjectName string
+	// Branding are the metadata values of the project
+	Branding map[string]string
+	Customer string
+	Payments []customerPayment
+}
+
+type customerPayment struct {
+	PaymentID string
+	Ident     string
+	Created   time.Time
+	Amount    *decimal.Decimal
+	Currency  string
+	// PaymentMethod is the display name of the payment method in the locale
+	// of the page. It is empty if no payment method was selected.
+	PaymentMethod string
+	Status        payment.PaymentTransactionStatus
+	// Updated is the time of the latest status change
+	Updated time.Time
+	// Paid is true if the receipt of the payment can be shown
+	Paid bool
+	// RetryURL is set for open payments
+	RetryURL string
+}
+
+// retryable returns true if the customer may (re-)start the checkout of the
+// payment
+func retryable(p *payment.Payment) bool {
+	if p.Status != payment.PaymentStatusNone && p.Status != payment.PaymentStatusOpen {
+		return false
+	}
+	if p.Config.Expires != nil && p.Config.Expires.Before(time.Now()) {
+		return false
+	}
+	return true
+}
+
+func (h *Handler) registerCustomer() error {
+	if !h.ctx.Config().Web.CustomerPortal.Active {
+		return nil
+	}
+	h.log.Info("registering web customer portal handler...")
+	h.router.Handle(
+		CustomerPath,
+		h.paymentDefaultsHandler(h.ctx.RateLimitHandler(h.CustomerHandler()))).
+		Methods("GET")
+	h.router.Handle(
+		CustomerRetryPath,
+		h.paymentDefaultsHandler(h.ctx.RateLimitHandler(h.CustomerRetryHandler()))).
+		Methods("GET")
+	return nil
+}
+
+// authenticateCustomerLink reads and authenticates the customer link of the
+// request
+//
+// It will write the appropriate header if the link is not valid.
+func (h *Handler) authenticateCustomerLink(w http.ResponseWriter, r *http.Request) (*CustomerLink, *project.Projectkey, bool) {
+	log := h.log.New(log15.Ctx{"method": "authenticateCustomerLink"})
+	link := &CustomerLink{}
+	err := link.ReadFromQuery(r.URL.Query())
+	if err != nil {
+		if Debug {
+			log.Debug("invalid customer link", log15.Ctx{"err": err})
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if link.Expired() {
+		log.Info("expired customer link", log15.Ctx{"expires": link.Expires})
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	projectKey, err := project.ProjectKeyByKeyDB(h.ctx, h.ctx.PrincipalDB(service.ReadOnly), link.ProjectKey)
+	if err != nil {
+		if err == project.ErrProjectKeyNotFound {
+			log.Warn("project key not found", log15.Ctx{"projectKey": link.ProjectKey})
+			w.WriteHeader(http.StatusUnauthorized)
+			return nil, nil, false
+		}
+		log.Error("error retrieving project key", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if !projectKey.IsValid() {
+		log.Warn("inactive project key", log15.Ctx{"projectKey": link.ProjectKey})
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	secret, err := projectKey.SecretBytes()
+	if err != nil {
+		log.Error("error retrieving project secret", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	ok, err := service.IsAuthentic(link, secret)
+	if err != nil {
+		if Debug {
+			log.Debug("error authenticating customer link", log15.Ctx{"err": err})
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	if !ok {
+		log.Warn("invalid customer link signature", log15.Ctx{"projectKey": link.ProjectKey})
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	return link, projectKey, true
+}
+
+// CustomerHandler serves the customer portal page, listing the payments of a
+// customer
+func (h *Handler) CustomerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		link, projectKey, ok := h.authenticateCustomerLink(w, r)
+		if !ok {
+			return
+		}
+		log := h.log.New(log15.Ctx{
+			"method":    "CustomerHandler",
+			"projectID": projectKey.Project.ID,
+		})
+		cfg := h.ctx.Config().Web.CustomerPortal
+		payments, err := payment.PaymentsByMetadataDB(h.ctx,
+			h.ctx.ProjectPaymentDB(projectKey.Project.ID, service.ReadOnly),
+			projectKey.Project.ID,
+			cfg.MetadataKey,
+			link.Customer,
+			cfg.MaxPayments)
+		if err != nil {
+			log.Error("error retrieving payments", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		branding, err := metadata.MetadataByPrimaryDB(h.ctx.PrincipalDB(service.ReadOnly), project.MetadataModel, projectKey.Project.ID)
+		if err != nil {
+			log.Error("error retrieving project metadata", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var locale string
+		if acceptLang := r.Header.Get("Accept-Language"); acceptLang != "" {
+			tags, _, err := language.ParseAcceptLanguage(acceptLang)
+			if err == nil && len(tags) >= 1 {
+				locale = tags[0].String()
+			}
+		}
+		methodNames := make(map[int64]string)
+
+		page := customerPage{
+			ProjectName: projectKey.Project.Name,
+			Branding:    branding.Values(),
+			Customer:    link.Customer,
+			Payments:    make([]customerPayment, len(payments)),
+		}
+		for i, p := range payments {
+			paymentID := h.paymentService.EncodedPaymentID(p.PaymentID()).String()
+			page.Payments[i] = customerPayment{
+				PaymentID: paymentID,
+				Ident:     p.Ident,
+				Created:   p.Created,
+				Amount:    p.Decimal(),
+				Currency:  p.Currency,
+				Status:    p.Status,
+				Updated:   p.TransactionTimestamp,
+				Paid:      p.Status == payment.PaymentStatusPaid,
+			}
+			if p.Config.PaymentMethodID.Valid {
+				page.Payments[i].PaymentMethod, err = h.customerMethodName(p.ProjectID(), p.Config.PaymentMethodID.Int64, locale, methodNames)
+				if err != nil {
+					log.Error("error retrieving payment method name", log15.Ctx{"err": err})
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+			}
+			if retryable(p) {
+				q := link.Query()
+				q.Set("PaymentId", paymentID)
+				page.Payments[i].RetryURL = CustomerRetryPath + "?" + q.Encode()
+			}
+		}
+
+		t, err := h.templates.TemplateIn(h.projectTemplateDir(projectKey.Project.ID, locale), locale, customerPageTemplate)
+		if err != nil {
+			log.Error("error retrieving template", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = t.Execute(w, page)
+		if err != nil {
+			log.Error("template error", log15.Ctx{"err": err})
+		}
+	})
+}
+
+// projectTemplateDir returns the template subdirectory of the project for the
+// customer portal page
+//
+// Projects can provide branded templates in the subdirectory
+// project/<projectID> of the template directory. If no such template exists,
+// the default template directory will be used.
+func (h *Handler) projectTemplateDir(projectID int64, locale string) string {
+	dir := path.Join("project", strconv.FormatInt(projectID, 10))
+	_, err := tmpl.TemplateFileName(path.Join(h.templateDir, dir), locale, defaultLocale, customerPageTemplate)
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// CustomerRetryHandler (re-)starts the checkout of an open payment of the
+// customer
+//
+// It redirects the customer to the checkout using a new payment token.
+func (h *Handler) CustomerRetryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		link, projectKey, ok := h.authenticateCustomerLink(w, r)
+		if !ok {
+			return
+		}
+		log := h.log.New(log15.Ctx{
+			"method":    "CustomerRetryHandler",
+			"projectID": projectKey.Project.ID,
+		})
+		paymentID, err := payment.ParsePaymentIDStr(r.URL.Query().Get("PaymentId"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		paymentID = h.paymentService.DecodedPaymentID(paymentID)
+		if paymentID.ProjectID != projectKey.Project.ID {
+			log.Warn("payment of other project requested", log15.Ctx{"requestedProjectID": paymentID.ProjectID})
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var tx *sql.Tx
+		var commit bool
+		defer func() {
+			if tx != nil && !commit {
+				err = tx.Rollback()
+				if err != nil {
+					log.Crit("error on rollback", log15.Ctx{"err": err})
+				}
+			}
+		}()
+		maxRetries := h.ctx.Config().Database.TransactionMaxRetries
+		var retries int
+	beginTx:
+		if retries >= maxRetries {
+			// no need to roll back
+			commit = true
+			log.Crit("too many retries on tx. aborting...", log15.Ctx{"maxRetries": maxRetries})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		tx, err = h.ctx.ProjectPaymentDB(paymentID.ProjectID).Begin()
+		if err != nil {
+			commit = true
+			log.Crit("error on begin tx", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		p, err := payment.PaymentByIDTx(h.ctx, tx, paymentID)
+		if err != nil {
+			if err == payment.ErrPaymentNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			log.Error("error retrieving payment", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = payment.PaymentMetadataTx(h.ctx, tx, p)
+		if err != nil {
+			log.Error("error retrieving payment metadata", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if p.Metadata[h.ctx.Config().Web.CustomerPortal.MetadataKey] != link.Customer {
+			log.Warn("payment of other customer requested", log15.Ctx{"paymentID": p.ID()})
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !retryable(p) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		token, err := h.paymentService.CreatePaymentToken(tx, p)
+		if err != nil {
+			if errors.Is(err, paymentService.ErrDBLockTimeout) {
+				tx.Rollback()
+				retries++
+				time.Sleep(time.Second)
+				goto beginTx
+			}
+			log.Error("error creating payment token", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			if sqldialect.IsDeadlock(err) {
+				retries++
+				time.Sleep(time.Second)
+				goto beginTx
+			}
+			log.Crit("error on commit", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		commit = true
+
+		checkout, err := h.customerCheckoutURL(projectKey.Project)
+		if err != nil {
+			log.Error("error creating checkout URL", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		q := checkout.Query()
+		q.Set(paymentService.PaymentTokenParam, token.Token)
+		checkout.RawQuery = q.Encode()
+		http.Redirect(w, r, checkout.String(), http.StatusFound)
+	})
+}
+
+// customerCheckoutURL returns the checkout URL of the project
+//
+// If the project has no web URL configured, the payment page of this web
+// server will be used.
+func (h *Handler) customerCheckoutURL(pr project.Project) (*url.URL, error) {
+	rawURL := h.ctx.Config().Web.URL + PaymentPath
+	if pr.Config.WebURL.Valid {
+		rawURL = pr.Config.WebURL.String
+	}
+	base, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return h.paymentService.CheckoutURL(pr.ID, base)
+}
+
+// customerMethodName returns the display name of the payment method in the
+// given locale
+//
+// The names are cached in the given map, as the payments of a customer are
+// usually made with few payment methods.
+func (h *Handler) customerMethodName(projectID, methodID int64, locale string, names map[int64]string) (string, error) {
+	if name, ok := names[methodID]; ok {
+		return name, nil
+	}
+	db := h.ctx.ProjectPaymentDB(projectID, service.ReadOnly)
+	meth, err := payment_method.PaymentMethodByIDDB(h.ctx, db, methodID)
+	if err != nil {
+		return "", err
+	}
+	meth.Metadata, err = payment_method.PaymentMethodMetadataDB(h.ctx, db, meth)
+	if err != nil {
+		return "", err
+	}
+	disp, err := h.paymentService.MethodDisplay(meth, locale)
+	if err != nil {
+		return "", err
+	}
+	names[methodID] = disp.Name
+	return disp.Name, nil
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/service"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCustomerLink(t *testing.T) {
+	Convey("Given a signed customer link", t, func() {
+		secret := []byte("secret")
+		link := &CustomerLink{
+			ProjectKey: "testkey",
+			Customer:   "customer@example.com",
+			Expires:    time.Now().Add(time.Hour).Unix(),
+		}
+		So(link.Sign(secret), ShouldBeNil)
+		So(link.Expired(), ShouldBeFalse)
+
+		Convey("When reading the link from its query", func() {
+			read := &CustomerLink{}
+			err := read.ReadFromQuery(link.Query())
+			So(err, ShouldBeNil)
+
+			Convey("It should be authentic", func() {
+				ok, err := service.IsAuthentic(read, secret)
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			})
+			Convey("It should not be authentic with another secret", func() {
+				ok, err := service.IsAuthentic(read, []byte("other"))
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the customer is changed", func() {
+			q := link.Query()
+			q.Set("Customer", "other@example.com")
+			read := &CustomerLink{}
+			So(read.ReadFromQuery(q), ShouldBeNil)
+
+			Convey("It should not be authentic", func() {
+				ok, err := service.IsAuthentic(read, secret)
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the signature is missing", func() {
+			q := link.Query()
+			q.Del("Signature")
+			err := (&CustomerLink{}).ReadFromQuery(q)
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given an expired customer link", t, func() {
+		link := &CustomerLink{Expires: time.Now().Add(-time.Minute).Unix()}
+
+		Convey("It should be expired", func() {
+			So(link.Expired(), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given an empty query", t, func() {
+		err := (&CustomerLink{}).ReadFromQuery(url.Values{})
+
+		Convey("Reading a link should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCustomerPaymentRetryable(t *testing.T) {
+	Convey("Given a payment", t, func() {
+		p := &payment.Payment{Status: payment.PaymentStatusOpen}
+
+		Convey("When it is open", func() {
+			Convey("It should be retryable", func() {
+				So(retryable(p), ShouldBeTrue)
+			})
+		})
+		Convey("When it is open and expired", func() {
+			p.Config.SetExpires(time.Now().Add(-time.Minute))
+
+			Convey("It should not be retryable", func() {
+				So(retryable(p), ShouldBeFalse)
+			})
+		})
+		Convey("When it is paid", func() {
+			p.Status = payment.PaymentStatusPaid
+
+			Convey("It should not be retryable", func() {
+				So(retryable(p), ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/fritzpay/paymentd/pkg/service"
 	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
 	"github.com/fritzpay/paymentd/pkg/service/provider"
+	tmpl "github.com/fritzpay/paymentd/pkg/template"
 	"github.com/gorilla/mux"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -33,9 +34,11 @@ type Handler struct {
 
 	paymentService *paymentService.Service
 	templateDir    string
+	templates      *tmpl.Manager
 	keyChain       *service.Keychain
 
 	providerService *provider.Service
+	checkoutLimit   checkoutLimit
 }
 
 func NewHandler(ctx *service.Context) (*Handler, error) {
@@ -55,6 +58,10 @@ func NewHandler(ctx *service.Context) (*Handler, error) {
 	if err != nil {
 		return nil, err
 	}
+	h.checkoutLimit, err = h.checkoutLimitFromConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error on checkout limit window: %v", err)
+	}
 
 	h.paymentService, err = paymentService.NewService(ctx)
 	if err != nil {
@@ -79,6 +86,17 @@ func NewHandler(ctx *service.Context) (*Handler, error) {
 		return nil, fmt.Errorf("error on template dir: %v", err)
 	}
 	h.templateDir = cfg.Web.TemplateDir
+	h.templates = tmpl.NewManager(h.templateDir, defaultLocale, nil)
+	if cfg.Provider.ProviderTemplateDir != "" {
+		// provider templates are managed by the drivers
+		h.templates.Skip(cfg.Provider.ProviderTemplateDir)
+	}
+	err = h.templates.Load()
+	if err != nil {
+		h.log.Error("error loading templates", log15.Ctx{"err": err})
+		return nil, err
+	}
+	ctx.Templates().Register("web", h.templates)
 
 	err = h.registerPayment()
 	if err != nil {
@@ -86,6 +104,12 @@ func NewHandler(ctx *service.Context) (*Handler, error) {
 		return nil, err
 	}
 
+	err = h.registerCustomer()
+	if err != nil {
+		h.log.Error("error registering customer portal", log15.Ctx{"err": err})
+		return nil, err
+	}
+
 	err = h.registerPublic()
 	if err != nil {
 		h.log.Error("error registering www public dir", log15.Ctx{"err": err})
@@ -99,6 +123,15 @@ func NewHandler(ctx *service.Context) (*Handler, error) {
 		return nil, err
 	}
 
+	if cfg.Web.TemplateWatchInterval != "" {
+		interval, err := cfg.Web.TemplateWatchInterval.Duration()
+		if err != nil {
+			return nil, fmt.Errorf("error on template watch interval: %v", err)
+		}
+		h.log.Info("watching templates for changes...", log15.Ctx{"interval": interval})
+		go ctx.Templates().Watch(interval, ctx.Done(), h.log)
+	}
+
 	return h, nil
 }
 
@@ -118,7 +151,11 @@ func (h *Handler) registerPayment() error {
 	h.router.Handle(
 		PaymentPath,
 		h.paymentDefaultsHandler(h.ctx.RateLimitHandler(h.PaymentHandler()))).
-		Methods("GET")
+		Methods("GET", "POST")
+	h.router.Handle(
+		paymentService.MethodLogoPath,
+		h.ctx.RateLimitHandler(h.MethodLogoHandler())).
+		Methods("GET", "HEAD")
 	return nil
 }
 
@@ -132,7 +169,7 @@ func (h *Handler) registerPublic() error {
 		return fmt.Errorf("error on public www dir: %v", err)
 	}
 	dir := http.Dir(cfg.Web.PubWWWDir)
-	h.router.NotFoundHandler = http.FileServer(dir)
+	h.router.NotFoundHandler = service.CompressionHandler(http.FileServer(dir))
 	return nil
 }
 
@@ -0,0 +1,29 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+)
+
+const (
+	maintenanceTemplate = "/payment/maintenance.html.tmpl"
+)
+
+// maintenancePage is the template data of the retry-later page served during
+// the maintenance of a payment method
+type maintenancePage struct {
+	Maintenance *payment_method.Maintenance
+}
+
+// serveMaintenance serves the retry-later page for the given maintenance window
+//
+// The Retry-After header is set to the end of the maintenance window.
+func (h *Handler) serveMaintenance(m *payment_method.Maintenance, w http.ResponseWriter, r *http.Request) {
+	retryAfter := m.End.Sub(time.Now())
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)+1))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	h.renderPage(maintenanceTemplate, maintenancePage{Maintenance: m}, w, r)
+}
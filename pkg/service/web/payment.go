@@ -3,24 +3,23 @@ package web
 import (
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"fmt"
 	"hash"
-	"html/template"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"path"
 	"strconv"
 	"strings"
 	"time"
 
-	tmpl "github.com/fritzpay/paymentd/pkg/template"
-
+	"github.com/fritzpay/paymentd/pkg/cache"
+	"github.com/fritzpay/paymentd/pkg/paymentd/nonce"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
 	"github.com/fritzpay/paymentd/pkg/service"
 	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
-	"github.com/go-sql-driver/mysql"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
 	"golang.org/x/text/language"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -28,8 +27,35 @@ import (
 const (
 	PaymentCookieName    = "payment"
 	PaymentAuthPaymentID = "paymentID"
+	PaymentAuthSessionID = "sessionID"
+)
+
+const (
+	// checkoutSessionTTL is the time for which the current checkout session of
+	// payments without expiry will be stored
+	checkoutSessionTTL = 24 * time.Hour
 )
 
+func checkoutSessionKey(paymentIDStr string) string {
+	return "checkout:" + paymentIDStr
+}
+
+// currentCheckoutSession returns false if the given session was superseded by a
+// newer checkout session of the payment
+//
+// Checkout sessions are tracked in the shared cache. If the current session is
+// unknown, the session will be accepted.
+func (h *Handler) currentCheckoutSession(paymentIDStr, sessionID string) bool {
+	current, err := h.ctx.Cache().Get(checkoutSessionKey(paymentIDStr))
+	if err != nil {
+		if err != cache.ErrNotFound {
+			h.log.Error("error retrieving checkout session", log15.Ctx{"err": err})
+		}
+		return true
+	}
+	return string(current) == sessionID
+}
+
 func (h *Handler) hashFunc() func() hash.Hash {
 	return sha256.New
 }
@@ -58,6 +84,16 @@ func (h *Handler) authenticatePaymentToken(w http.ResponseWriter, r *http.Reques
 			}
 		}
 	}()
+	projectID, err := h.paymentService.PaymentTokenProjectID(tokenStr)
+	if err != nil {
+		if err == payment.ErrPaymentNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log.Error("error retrieving payment token", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	maxRetries := h.ctx.Config().Database.TransactionMaxRetries
 	var retries int
 beginTx:
@@ -68,7 +104,7 @@ beginTx:
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	tx, err = h.ctx.PaymentDB(service.ReadOnly).Begin()
+	tx, err = h.ctx.ProjectPaymentDB(projectID, service.ReadOnly).Begin()
 	if err != nil {
 		commit = true
 		log.Crit("error on begin tx", log15.Ctx{"err": err})
@@ -92,7 +128,7 @@ beginTx:
 	}
 	err = h.paymentService.DeletePaymentToken(tx, tokenStr)
 	if err != nil {
-		if err == paymentService.ErrDBLockTimeout {
+		if errors.Is(err, paymentService.ErrDBLockTimeout) {
 			retries++
 			time.Sleep(time.Second)
 			goto beginTx
@@ -109,12 +145,10 @@ beginTx:
 	}
 	err = tx.Commit()
 	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			if mysqlErr.Number == 1213 {
-				retries++
-				time.Sleep(time.Second)
-				goto beginTx
-			}
+		if sqldialect.IsDeadlock(err) {
+			retries++
+			time.Sleep(time.Second)
+			goto beginTx
 		}
 		log.Crit("error on commit", log15.Ctx{"err": err})
 		w.WriteHeader(http.StatusInternalServerError)
@@ -174,6 +208,14 @@ func (h *Handler) readPaymentCookie(w http.ResponseWriter, r *http.Request) (pro
 			w.WriteHeader(http.StatusInternalServerError)
 			return false
 		}
+		if sessionID, ok := auth.Payload[PaymentAuthSessionID].(string); ok {
+			if !h.currentCheckoutSession(paymentIDStr, sessionID) {
+				log.Info("superseded checkout session", log15.Ctx{"paymentID": paymentIDStr})
+				h.resetPaymentCookie(w)
+				w.WriteHeader(http.StatusUnauthorized)
+				return false
+			}
+		}
 		service.SetRequestContextVar(r, PaymentAuthPaymentID, paymentIDStr)
 		return true
 	}
@@ -189,6 +231,21 @@ func (h *Handler) setPaymentCookie(w http.ResponseWriter, p *payment.Payment) er
 	if p.Config.Expires != nil {
 		auth.Expires(*p.Config.Expires)
 	}
+	// a new checkout session supersedes previous sessions of the payment
+	session, err := nonce.New()
+	if err != nil {
+		log.Error("error generating session ID", log15.Ctx{"err": err})
+		return err
+	}
+	auth.Payload[PaymentAuthSessionID] = session.Nonce
+	ttl := checkoutSessionTTL
+	if !auth.Expiry().IsZero() && auth.Expiry().After(time.Now()) {
+		ttl = auth.Expiry().Sub(time.Now())
+	}
+	err = h.ctx.Cache().Set(checkoutSessionKey(p.PaymentID().String()), []byte(session.Nonce), ttl)
+	if err != nil {
+		log.Error("error storing checkout session", log15.Ctx{"err": err})
+	}
 	key, err := h.ctx.WebKeychain().BinKey()
 	if err != nil {
 		log.Error("error retrieving auth key", log15.Ctx{"err": err})
@@ -274,14 +331,14 @@ func (h *Handler) PaymentHandler() http.Handler {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		tx, err = h.ctx.PaymentDB().Begin()
+		tx, err = h.ctx.ProjectPaymentDB(paymentID.ProjectID).Begin()
 		if err != nil {
 			commit = true
 			log.Crit("error on begin tx", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		p, err := payment.PaymentByIDTx(tx, paymentID)
+		p, err := payment.PaymentByIDTx(h.ctx, tx, paymentID)
 		if err != nil {
 			if err == payment.ErrPaymentNotFound {
 				log.Warn("requested payment not found")
@@ -296,7 +353,7 @@ func (h *Handler) PaymentHandler() http.Handler {
 			"projectID": p.ProjectID(),
 			"paymentID": p.ID(),
 		})
-		err = payment.PaymentMetadataTx(tx, p)
+		err = payment.PaymentMetadataTx(h.ctx, tx, p)
 		if err != nil {
 			log.Error("error retrieving payment metadata", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -315,11 +372,26 @@ func (h *Handler) PaymentHandler() http.Handler {
 			log.Warn("error determining payment method id", log15.Ctx{"err": err})
 			return
 		}
+		// fail fast while the provider is under maintenance
+		maintenance, err := payment_method.MaintenanceByMethodIDTx(h.ctx, tx, method.ID, time.Now())
+		if err != nil {
+			log.Error("error retrieving payment method maintenance", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if m, ok := payment_method.ActiveMaintenance(maintenance, time.Now()); ok {
+			log.Info("payment method under maintenance", log15.Ctx{
+				"paymentMethodID": method.ID,
+				"until":           m.End,
+			})
+			h.serveMaintenance(m, w, r)
+			return
+		}
 
 		if configChanged {
 			err = h.paymentService.SetPaymentConfig(tx, p)
 			if err != nil {
-				if err == paymentService.ErrDBLockTimeout {
+				if errors.Is(err, paymentService.ErrDBLockTimeout) {
 					retries++
 					time.Sleep(time.Second)
 					goto beginTx
@@ -332,7 +404,7 @@ func (h *Handler) PaymentHandler() http.Handler {
 		if metadataChanged {
 			err = h.paymentService.SetPaymentMetadata(tx, p)
 			if err != nil {
-				if err == paymentService.ErrDBLockTimeout {
+				if errors.Is(err, paymentService.ErrDBLockTimeout) {
 					retries++
 					time.Sleep(time.Second)
 					goto beginTx
@@ -352,13 +424,10 @@ func (h *Handler) PaymentHandler() http.Handler {
 			}
 			err = tx.Commit()
 			if err != nil {
-				if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-					// lock error
-					if mysqlErr.Number == 1213 {
-						retries++
-						time.Sleep(time.Second)
-						goto beginTx
-					}
+				if sqldialect.IsDeadlock(err) {
+					retries++
+					time.Sleep(time.Second)
+					goto beginTx
 				}
 				commit = true
 				log.Crit("error on commit tx", log15.Ctx{"err": err})
@@ -379,15 +448,20 @@ func (h *Handler) PaymentHandler() http.Handler {
 		// payment is not initialized, set open status
 		var commitIntent paymentService.CommitIntentFunc
 		if !h.paymentService.IsInitialized(p) {
-			paymentTx, commitIntent, err = h.paymentService.IntentOpen(p, 500*time.Millisecond)
-			if err != nil {
+			paymentTx, commitIntent, err = h.paymentService.IntentOpen(h.ctx, p, 500*time.Millisecond)
+			var hold *paymentService.HoldError
+			if errors.As(err, &hold) {
+				log.Info("payment held for review", log15.Ctx{"worker": hold.Worker, "reason": hold.Reason})
+				commitIntent, err = h.paymentService.HoldPayment(tx, p, hold)
+			} else if err == nil {
+				err = h.paymentService.SetPaymentTransaction(tx, paymentTx)
+			} else {
 				log.Error("error opening payment", log15.Ctx{"err": err})
 				w.WriteHeader(http.StatusConflict)
 				return
 			}
-			err = h.paymentService.SetPaymentTransaction(tx, paymentTx)
 			if err != nil {
-				if err == paymentService.ErrDBLockTimeout {
+				if errors.Is(err, paymentService.ErrDBLockTimeout) {
 					retries++
 					time.Sleep(time.Second)
 					goto beginTx
@@ -400,13 +474,10 @@ func (h *Handler) PaymentHandler() http.Handler {
 
 		err = tx.Commit()
 		if err != nil {
-			if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-				// lock error
-				if mysqlErr.Number == 1213 {
-					retries++
-					time.Sleep(time.Second)
-					goto beginTx
-				}
+			if sqldialect.IsDeadlock(err) {
+				retries++
+				time.Sleep(time.Second)
+				goto beginTx
 			}
 			commit = true
 			log.Crit("error on commit tx", log15.Ctx{"err": err})
@@ -415,7 +486,23 @@ func (h *Handler) PaymentHandler() http.Handler {
 		}
 		commit = true
 		if commitIntent != nil {
-			commitIntent()
+			err = commitIntent()
+			if err != nil {
+				log.Error("error committing intent", log15.Ctx{"err": err})
+			}
+		}
+
+		// held payments cannot be processed until they are reviewed
+		if p.Status == payment.PaymentStatusHeld {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		// test numbers are not processed by the provider in test mode
+		if outcome, ok := h.testOutcome(p, r); ok {
+			log.Info("test account recognized", log15.Ctx{"result": outcome.Result})
+			h.testOutcomeHandler(p, outcome).ServeHTTP(w, r)
+			return
 		}
 
 		h.servePaymentHandler(p, method).ServeHTTP(w, r)
@@ -473,7 +560,7 @@ func (h *Handler) determinePaymentMethodID(tx *sql.Tx, p *payment.Payment, w htt
 			return nil, fmt.Errorf("invalid payment method id: %s", idStr)
 		}
 	}
-	meth, err := payment_method.PaymentMethodByIDTx(tx, paymentMethodID)
+	meth, err := payment_method.PaymentMethodByIDTx(h.ctx, tx, paymentMethodID)
 	if err != nil {
 		if err == payment_method.ErrPaymentMethodNotFound {
 			w.WriteHeader(http.StatusNotFound)
@@ -490,7 +577,28 @@ func (h *Handler) determinePaymentMethodID(tx *sql.Tx, p *payment.Payment, w htt
 		w.WriteHeader(http.StatusConflict)
 		return nil, fmt.Errorf("invalid payment method id %d. payment method not active", paymentMethodID)
 	}
+	if p.IsVerification() {
+		driver, err := h.providerService.Driver(meth)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return nil, fmt.Errorf("error retrieving driver: %v", err)
+		}
+		if _, ok := driver.(provider.Verifier); !ok {
+			w.WriteHeader(http.StatusConflict)
+			return nil, fmt.Errorf("invalid payment method id %d. verification not supported", paymentMethodID)
+		}
+	}
 	if !p.Config.PaymentMethodID.Valid {
+		// methods configured on init were already routed by currency
+		meth.Metadata, err = payment_method.PaymentMethodMetadataTx(h.ctx, tx, meth)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return nil, fmt.Errorf("error selecting payment method metadata: %v", err)
+		}
+		if !meth.SupportsCurrency(p.Currency) {
+			w.WriteHeader(http.StatusConflict)
+			return nil, fmt.Errorf("invalid payment method id %d. currency %s not supported", paymentMethodID, p.Currency)
+		}
 		p.Config.SetPaymentMethodID(meth.ID)
 		*configChanged = true
 	}
@@ -512,10 +620,30 @@ func (h *Handler) servePaymentHandler(p *payment.Payment, method *payment_method
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if Debug {
-			log.Debug("initializing payment with driver...")
+		if !h.collectBilling(p, driver, w, r) {
+			return
+		}
+		if !h.allowCheckoutAttempt(p, w, r) {
+			return
+		}
+		var h http.Handler
+		if p.IsVerification() {
+			verifier, ok := driver.(provider.Verifier)
+			if !ok {
+				log.Warn("verification payment with provider not supporting verification")
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			if Debug {
+				log.Debug("initializing verification with driver...")
+			}
+			h, err = verifier.InitVerification(p, method, r)
+		} else {
+			if Debug {
+				log.Debug("initializing payment with driver...")
+			}
+			h, err = driver.InitPayment(p, method, r)
 		}
-		h, err := driver.InitPayment(p, method)
 		if err != nil {
 			log.Error("error on driver init payment", log15.Ctx{"err": err})
 			w.WriteHeader(http.StatusInternalServerError)
@@ -548,6 +676,8 @@ func (h *Handler) paymentDefaultsHandler(parent http.Handler) http.Handler {
 				h.log.Debug("serving default page", log15.Ctx{"HTTPStatusCode": wr.statusCode})
 			}
 			switch wr.statusCode {
+			case http.StatusAccepted:
+				h.defaultPage("/payment/held.html.tmpl", w, r)
 			case http.StatusNotFound:
 				h.defaultPage("/payment/not_found.html.tmpl", w, r)
 			case http.StatusInternalServerError:
@@ -560,6 +690,8 @@ func (h *Handler) paymentDefaultsHandler(parent http.Handler) http.Handler {
 				h.defaultPage("/payment/conflict.html.tmpl", w, r)
 			case http.StatusUnauthorized:
 				h.defaultPage("/payment/unauthorized.html.tmpl", w, r)
+			case http.StatusTooManyRequests:
+				h.defaultPage("/payment/too_many_requests.html.tmpl", w, r)
 			default:
 				h.log.Warn("no default handler found for HTTP status", log15.Ctx{
 					"method":         "paymentDefaultsHandler",
@@ -570,28 +702,6 @@ func (h *Handler) paymentDefaultsHandler(parent http.Handler) http.Handler {
 	})
 }
 
-func (h *Handler) getTemplate(t *template.Template, tmplDir, locale, baseName string) (err error) {
-	tmplFile, err := tmpl.TemplateFileName(tmplDir, locale, defaultLocale, baseName)
-	if err != nil {
-		return err
-	}
-	tmplB, err := ioutil.ReadFile(tmplFile)
-	if err != nil {
-		return err
-	}
-	tmplLocale := path.Base(path.Ext(tmplFile))
-	t.Funcs(template.FuncMap(map[string]interface{}{
-		"locale": func() string {
-			return tmplLocale
-		},
-	}))
-	_, err = t.Parse(string(tmplB))
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func (h *Handler) defaultPage(base string, w http.ResponseWriter, r *http.Request) {
 	var locale string
 	if acceptLang := r.Header.Get("Accept-Language"); acceptLang != "" {
@@ -601,9 +711,7 @@ func (h *Handler) defaultPage(base string, w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	tmpl := template.New("page")
-
-	err := h.getTemplate(tmpl, h.templateDir, locale, base)
+	tmpl, err := h.templates.Template(locale, base)
 	if err != nil {
 		h.log.Error("error retrieving template", log15.Ctx{"err": err})
 		return
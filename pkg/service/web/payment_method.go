@@ -1,13 +1,213 @@
 package web
 
 import (
+	"bytes"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
 
+	"github.com/fritzpay/paymentd/pkg/asset"
+	"github.com/fritzpay/paymentd/pkg/paymentd/method_preference"
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/service"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/service/provider"
+	"github.com/gorilla/mux"
+	"gopkg.in/inconshreveable/log15.v2"
 )
 
+const (
+	selectMethodTemplate = "/payment/select_method.html.tmpl"
+)
+
+// SelectableMethod is a payment method on the payment method selection page
+type SelectableMethod struct {
+	*paymentService.MethodDisplay
+	// URL continues the payment with the payment method
+	URL string
+	// Preselected is true for the default payment method of the payment
+	// country
+	Preselected bool
+}
+
+// SelectMethodPage is the template data of the payment method selection page
+type SelectMethodPage struct {
+	Payment *payment.Payment
+	Methods []SelectableMethod
+}
+
+// SelectPaymentMethodHandler serves the payment method selection page
+//
+// It lists the active payment methods of the project which can process the
+// payment in the order of the payment method preference of the payment country.
 func (h *Handler) SelectPaymentMethodHandler(p *payment.Payment) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := h.log.New(log15.Ctx{
+			"method":    "SelectPaymentMethodHandler",
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+		})
+		methods, maintenance, err := h.selectableMethods(p)
+		if err != nil {
+			log.Error("error retrieving payment methods", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(methods) == 0 && maintenance != nil {
+			log.Info("payment methods under maintenance", log15.Ctx{"until": maintenance.End})
+			h.serveMaintenance(maintenance, w, r)
+			return
+		}
+		if len(methods) == 0 {
+			log.Warn("no payment method available")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		pref, err := h.paymentService.MethodPreference(p)
+		if err != nil {
+			log.Error("error retrieving payment method preference", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sortMethods(methods, pref)
+		selected := preselected(methods, pref)
+		page := &SelectMethodPage{
+			Payment: p,
+			Methods: make([]SelectableMethod, len(methods)),
+		}
+		for i, meth := range methods {
+			page.Methods[i].MethodDisplay, err = h.paymentService.MethodDisplay(meth, p.Config.Locale.String)
+			if err != nil {
+				log.Error("error retrieving display metadata", log15.Ctx{"err": err})
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			page.Methods[i].URL = PaymentPath + "?" + url.Values{
+				"paymentMethodId": []string{strconv.FormatInt(meth.ID, 10)},
+			}.Encode()
+			page.Methods[i].Preselected = meth == selected
+		}
+		t, err := h.templates.Template(p.Config.Locale.String, selectMethodTemplate)
+		if err != nil {
+			log.Error("error retrieving template", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = t.Execute(w, page)
+		if err != nil {
+			log.Error("template error", log15.Ctx{"err": err})
+		}
+	})
+}
 
+// selectableMethods returns the payment methods which can process the given
+// payment
+//
+// Payment methods under maintenance are hidden. The maintenance window of the
+// hidden payment methods which ends first will be returned.
+func (h *Handler) selectableMethods(p *payment.Payment) ([]*payment_method.Method, *payment_method.Maintenance, error) {
+	db := h.ctx.ProjectPaymentDB(p.ProjectID(), service.ReadOnly)
+	methods, err := payment_method.PaymentMethodsByProjectIDDB(h.ctx, db, p.ProjectID())
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	windows, err := payment_method.ActiveMaintenanceByProjectIDDB(h.ctx, db, p.ProjectID(), now)
+	if err != nil {
+		return nil, nil, err
+	}
+	maintenance := make(map[int64][]*payment_method.Maintenance, len(windows))
+	for _, m := range windows {
+		maintenance[m.PaymentMethodID] = append(maintenance[m.PaymentMethodID], m)
+	}
+	var next *payment_method.Maintenance
+	selectable := make([]*payment_method.Method, 0, len(methods))
+	for _, meth := range methods {
+		if !meth.Active() {
+			continue
+		}
+		meth.Metadata, err = payment_method.PaymentMethodMetadataDB(h.ctx, db, meth)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !meth.SupportsCurrency(p.Currency) {
+			continue
+		}
+		if p.IsVerification() {
+			driver, err := h.providerService.Driver(meth)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, ok := driver.(provider.Verifier); !ok {
+				continue
+			}
+		}
+		if m, ok := payment_method.ActiveMaintenance(maintenance[meth.ID], now); ok {
+			if next == nil || m.End.Before(next.End) {
+				next = m
+			}
+			continue
+		}
+		selectable = append(selectable, meth)
+	}
+	return selectable, next, nil
+}
+
+// sortMethods sorts the payment methods by the preference
+//
+// Payment methods with the same rank keep their order.
+func sortMethods(methods []*payment_method.Method, pref method_preference.Preference) {
+	sort.SliceStable(methods, func(i, j int) bool {
+		return pref.Rank(methods[i].Provider.Name, methods[i].MethodKey) < pref.Rank(methods[j].Provider.Name, methods[j].MethodKey)
+	})
+}
+
+// preselected returns the first payment method preselected by the preference
+// or nil if there is none
+func preselected(methods []*payment_method.Method, pref method_preference.Preference) *payment_method.Method {
+	for _, meth := range methods {
+		if pref.Preselects(meth.Provider.Name, meth.MethodKey) {
+			return meth
+		}
+	}
+	return nil
+}
+
+// MethodLogoHandler serves the payment method logos
+//
+// Requests for the current version of a logo can be cached indefinitely.
+func (h *Handler) MethodLogoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		log := h.log.New(log15.Ctx{
+			"method":    "MethodLogoHandler",
+			"provider":  vars["provider"],
+			"methodKey": vars["methodkey"],
+		})
+		l, err := payment_method.LogoByMethodDB(h.ctx, h.ctx.PaymentDB(service.ReadOnly), vars["provider"], vars["methodkey"])
+		if err != nil {
+			if err == payment_method.ErrLogoNotFound {
+				http.NotFound(w, r)
+				return
+			}
+			log.Error("error retrieving logo", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("v") == paymentService.MethodLogoVersion(l.Timestamp) {
+			w.Header().Set("Cache-Control", asset.CacheControlHashed)
+		} else {
+			w.Header().Set("Cache-Control", asset.CacheControlDefault)
+		}
+		w.Header().Set("Content-Type", l.ContentType)
+		w.Header().Set("ETag", l.ETag())
+		// logos can be SVG documents
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, "", l.Timestamp, bytes.NewReader(l.Data))
 	})
 }
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/method_preference"
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
+	"github.com/fritzpay/paymentd/pkg/paymentd/provider"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSortMethods(t *testing.T) {
+	Convey("Given the payment methods of a project", t, func() {
+		methods := []*payment_method.Method{
+			{ID: 1, Provider: provider.Provider{Name: "stripe"}, MethodKey: "card"},
+			{ID: 2, Provider: provider.Provider{Name: "fritzpay"}, MethodKey: "demo"},
+			{ID: 3, Provider: provider.Provider{Name: "stripe"}, MethodKey: "sofort"},
+			{ID: 4, Provider: provider.Provider{Name: "paypal_rest"}, MethodKey: "paypal"},
+		}
+		ids := func() []int64 {
+			ids := make([]int64, len(methods))
+			for i, m := range methods {
+				ids[i] = m.ID
+			}
+			return ids
+		}
+
+		Convey("When sorting them by a preference", func() {
+			pref := method_preference.Preference{
+				Order:   []string{"paypal_rest", "stripe/sofort"},
+				Default: "stripe/sofort",
+			}
+			sortMethods(methods, pref)
+
+			Convey("They should be in the order of the preference", func() {
+				So(ids(), ShouldResemble, []int64{4, 3, 1, 2})
+			})
+			Convey("The default should be preselected", func() {
+				So(preselected(methods, pref), ShouldEqual, methods[1])
+			})
+		})
+
+		Convey("When sorting them by an empty preference", func() {
+			pref := method_preference.Preference{}
+			sortMethods(methods, pref)
+
+			Convey("They should keep their order", func() {
+				So(ids(), ShouldResemble, []int64{1, 2, 3, 4})
+			})
+			Convey("No payment method should be preselected", func() {
+				So(preselected(methods, pref), ShouldBeNil)
+			})
+		})
+	})
+}
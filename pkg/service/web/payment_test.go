@@ -19,6 +19,7 @@ import (
 	"gopkg.in/inconshreveable/log15.v2"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
 )
 
 func WithPayment(db *sql.DB, prDB *sql.DB, s *paymentService.Service, f func(p *payment.Payment, t *payment.PaymentToken)) func() {
@@ -32,7 +33,7 @@ func WithPayment(db *sql.DB, prDB *sql.DB, s *paymentService.Service, f func(p *
 		}
 		p.Config.SetCountry("DE")
 		p.Config.SetPaymentMethodID(1)
-		pr, err := project.ProjectByIDDB(prDB, 1)
+		pr, err := project.ProjectByIDDB(context.Background(), prDB, 1)
 		So(err, ShouldBeNil)
 		err = p.SetProject(pr)
 		So(err, ShouldBeNil)
@@ -125,7 +126,7 @@ func TestPayment(t *testing.T) {
 															So(wr.StatusCode, ShouldEqual, http.StatusOK)
 
 															Convey("When retrieving the payment", func() {
-																p, err := payment.PaymentByIDDB(db, p.PaymentID())
+																p, err := payment.PaymentByIDDB(ctx, db, p.PaymentID())
 																So(err, ShouldBeNil)
 
 																Convey("The configuration should be extended with the missing configuration", func() {
@@ -165,9 +166,9 @@ func TestPayment(t *testing.T) {
 													})
 
 													Convey("When retrieving the payment with metadata", func() {
-														pRet, err := payment.PaymentByIDDB(db, p.PaymentID())
+														pRet, err := payment.PaymentByIDDB(ctx, db, p.PaymentID())
 														So(err, ShouldBeNil)
-														err = payment.PaymentMetadataDB(db, pRet)
+														err = payment.PaymentMetadataDB(ctx, db, pRet)
 														So(err, ShouldBeNil)
 
 														Convey("The metadata should be preserved", func() {
@@ -0,0 +1,242 @@
+package web
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/payment"
+	"github.com/fritzpay/paymentd/pkg/paymentd/testaccount"
+	paymentService "github.com/fritzpay/paymentd/pkg/service/payment"
+	"github.com/fritzpay/paymentd/pkg/sqldialect"
+	"golang.org/x/text/language"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	testChallengeTemplate = "/payment/test_challenge.html.tmpl"
+	testResultTemplate    = "/payment/test_result.html.tmpl"
+)
+
+const (
+	testAccountParam   = "testAccount"
+	testChallengeParam = "testChallenge"
+)
+
+const (
+	testChallengePass = "pass"
+	testChallengeFail = "fail"
+)
+
+// testAccountNumber returns the test card or account number of the payment
+//
+// The number can be given in the checkout request or in the payment metadata.
+func testAccountNumber(p *payment.Payment, r *http.Request) string {
+	if number := r.URL.Query().Get(testAccountParam); number != "" {
+		return number
+	}
+	if p.Metadata != nil {
+		return p.Metadata[payment.MetadataKeyTestAccount]
+	}
+	return ""
+}
+
+// testOutcome returns the outcome of the test number of the payment
+//
+// The second return value is false if test mode is disabled or if the payment
+// has no recognized test number.
+func (h *Handler) testOutcome(p *payment.Payment, r *http.Request) (testaccount.Outcome, bool) {
+	if !h.ctx.Config().Payment.TestMode {
+		return testaccount.Outcome{}, false
+	}
+	number := testAccountNumber(p, r)
+	if number == "" {
+		return testaccount.Outcome{}, false
+	}
+	return testaccount.Recognize(number)
+}
+
+type testChallengePage struct {
+	PassURL string
+	FailURL string
+}
+
+type testResultPage struct {
+	Status      payment.PaymentTransactionStatus
+	Paid        bool
+	DeclineCode string
+}
+
+// testOutcomeHandler short-circuits the payment to the outcome of its test
+// number instead of processing it with the provider driver
+func (h *Handler) testOutcomeHandler(p *payment.Payment, outcome testaccount.Outcome) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := h.log.New(log15.Ctx{
+			"method":    "testOutcomeHandler",
+			"projectID": p.ProjectID(),
+			"paymentID": p.ID(),
+			"result":    outcome.Result,
+		})
+		if outcome.Result == testaccount.ResultChallenge {
+			switch r.URL.Query().Get(testChallengeParam) {
+			case testChallengePass:
+				outcome = testaccount.Outcome{Result: testaccount.ResultSuccess}
+			case testChallengeFail:
+				outcome = testaccount.Outcome{
+					Result:      testaccount.ResultDecline,
+					DeclineCode: testaccount.DeclineAuthenticationFailed,
+				}
+			default:
+				h.serveTestChallenge(w, r)
+				return
+			}
+		}
+		if Debug {
+			log.Debug("short-circuiting test payment...")
+		}
+		status, err := h.setTestOutcome(p, outcome)
+		if err != nil {
+			log.Error("error on setting test outcome", log15.Ctx{"err": err})
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if p.Config.ReturnURL.Valid {
+			http.Redirect(w, r, p.Config.ReturnURL.String, http.StatusFound)
+			return
+		}
+		page := testResultPage{
+			Status:      status,
+			Paid:        status == payment.PaymentStatusPaid || status == payment.PaymentStatusVerified,
+			DeclineCode: outcome.DeclineCode,
+		}
+		h.renderPage(testResultTemplate, page, w, r)
+	})
+}
+
+// setTestOutcome sets the payment status according to the test outcome and
+// returns the resulting status
+//
+// Payments which are not open (anymore) will not be changed.
+func (h *Handler) setTestOutcome(p *payment.Payment, outcome testaccount.Outcome) (payment.PaymentTransactionStatus, error) {
+	var tx *sql.Tx
+	var commit bool
+	var err error
+	defer func() {
+		if tx != nil && !commit {
+			err = tx.Rollback()
+			if err != nil {
+				h.log.Crit("error on rollback", log15.Ctx{"err": err})
+			}
+		}
+	}()
+	maxRetries := h.ctx.Config().Database.TransactionMaxRetries
+	var retries int
+beginTx:
+	if retries >= maxRetries {
+		// no need to roll back
+		commit = true
+		return "", paymentService.ErrDBLockTimeout
+	}
+	tx, err = h.ctx.ProjectPaymentDB(p.ProjectID()).Begin()
+	if err != nil {
+		commit = true
+		return "", err
+	}
+	// reload the payment for the current status
+	current, err := payment.PaymentByIDTx(h.ctx, tx, p.PaymentID())
+	if err != nil {
+		return "", err
+	}
+	if current.Status != payment.PaymentStatusOpen {
+		return current.Status, nil
+	}
+	var paymentTx *payment.PaymentTransaction
+	var commitIntent paymentService.CommitIntentFunc
+	switch outcome.Result {
+	case testaccount.ResultSuccess:
+		if current.IsVerification() {
+			paymentTx, commitIntent, err = h.paymentService.IntentVerified(h.ctx, current, 500*time.Millisecond)
+			if err == nil {
+				paymentTx.Comment.String, paymentTx.Comment.Valid = "verified with test account", true
+			}
+			break
+		}
+		paymentTx, commitIntent, err = h.paymentService.IntentPaid(h.ctx, current, 500*time.Millisecond)
+		if err == nil {
+			paymentTx.Comment.String, paymentTx.Comment.Valid = "paid with test account", true
+		}
+	default:
+		paymentTx, commitIntent, err = h.paymentService.IntentFailed(h.ctx, current, 500*time.Millisecond)
+		if err == nil {
+			paymentTx.Comment.String, paymentTx.Comment.Valid = "declined with test account: "+outcome.DeclineCode, true
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	err = h.paymentService.SetPaymentTransaction(tx, paymentTx)
+	if err != nil {
+		if errors.Is(err, paymentService.ErrDBLockTimeout) {
+			retries++
+			time.Sleep(time.Second)
+			goto beginTx
+		}
+		return "", err
+	}
+	err = tx.Commit()
+	if err != nil {
+		if sqldialect.IsDeadlock(err) {
+			retries++
+			time.Sleep(time.Second)
+			goto beginTx
+		}
+		commit = true
+		return "", err
+	}
+	commit = true
+	if commitIntent != nil {
+		err = commitIntent()
+		if err != nil {
+			h.log.Error("error committing intent", log15.Ctx{"err": err})
+		}
+	}
+	return paymentTx.Status, nil
+}
+
+// serveTestChallenge serves the simulated 3-D Secure challenge
+func (h *Handler) serveTestChallenge(w http.ResponseWriter, r *http.Request) {
+	challengeURL := func(result string) string {
+		q := r.URL.Query()
+		q.Del(paymentService.PaymentTokenParam)
+		q.Set(testChallengeParam, result)
+		u := *r.URL
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+	page := testChallengePage{
+		PassURL: challengeURL(testChallengePass),
+		FailURL: challengeURL(testChallengeFail),
+	}
+	h.renderPage(testChallengeTemplate, page, w, r)
+}
+
+func (h *Handler) renderPage(base string, page interface{}, w http.ResponseWriter, r *http.Request) {
+	var locale string
+	if acceptLang := r.Header.Get("Accept-Language"); acceptLang != "" {
+		tags, _, err := language.ParseAcceptLanguage(acceptLang)
+		if err == nil && len(tags) >= 1 {
+			locale = tags[0].String()
+		}
+	}
+	t, err := h.templates.Template(locale, base)
+	if err != nil {
+		h.log.Error("error retrieving template", log15.Ctx{"err": err})
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = t.Execute(w, page)
+	if err != nil {
+		h.log.Error("template error", log15.Ctx{"err": err})
+	}
+}
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/paymentd/project"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	// ErrNoCertificate is returned if no certificate can be served for a TLS
+	// handshake
+	ErrNoCertificate = errors.New("no certificate")
+)
+
+// domainCertificates caches the loaded certificates of project checkout domains
+type domainCertificates struct {
+	m     sync.RWMutex
+	certs map[string]domainCertificate
+}
+
+type domainCertificate struct {
+	timestamp time.Time
+	cert      *tls.Certificate
+}
+
+// TLSConfig returns the TLS config for serving the WWW-service via HTTPS
+//
+// Certificates are selected per checkout domain via SNI. If no checkout domain
+// certificate matches, the configured default certificate will be served.
+func (h *Handler) TLSConfig() (*tls.Config, error) {
+	cfg := h.ctx.Config()
+	tlsConfig := &tls.Config{}
+	if cfg.Web.TLS.CertFile != "" {
+		defaultCert, err := tls.LoadX509KeyPair(cfg.Web.TLS.CertFile, cfg.Web.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{defaultCert}
+	}
+	domainCerts := &domainCertificates{
+		certs: make(map[string]domainCertificate),
+	}
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := h.domainCertificate(domainCerts, hello.ServerName)
+		if err != nil {
+			if len(tlsConfig.Certificates) == 0 {
+				return nil, err
+			}
+			// default certificate
+			return nil, nil
+		}
+		return cert, nil
+	}
+	return tlsConfig, nil
+}
+
+func (h *Handler) domainCertificate(domainCerts *domainCertificates, serverName string) (*tls.Certificate, error) {
+	log := h.log.New(log15.Ctx{
+		"method":     "domainCertificate",
+		"serverName": serverName,
+	})
+	name, err := project.NormalizeDomain(serverName)
+	if err != nil {
+		return nil, ErrNoCertificate
+	}
+	dom, err := h.paymentService.VerifiedDomain(name)
+	if err != nil {
+		if err != project.ErrDomainNotFound {
+			log.Error("error retrieving domain", log15.Ctx{"err": err})
+		}
+		return nil, ErrNoCertificate
+	}
+	if !dom.HasTLS() {
+		return nil, ErrNoCertificate
+	}
+	domainCerts.m.RLock()
+	c, ok := domainCerts.certs[name]
+	domainCerts.m.RUnlock()
+	if ok && c.timestamp.Equal(dom.Timestamp) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(dom.TLSCertFile.String, dom.TLSKeyFile.String)
+	if err != nil {
+		log.Error("error loading domain certificate", log15.Ctx{"err": err})
+		return nil, ErrNoCertificate
+	}
+	domainCerts.m.Lock()
+	domainCerts.certs[name] = domainCertificate{
+		timestamp: dom.Timestamp,
+		cert:      &cert,
+	}
+	domainCerts.m.Unlock()
+	return &cert, nil
+}
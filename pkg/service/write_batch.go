@@ -0,0 +1,305 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	defaultWriteBatchMaxDelay = 5 * time.Millisecond
+	defaultWriteBatchMaxSize  = 50
+
+	writeBatchSavepoint = "write_batch_item"
+)
+
+// ErrWriteBatchAborted is returned if the batch transaction was rolled back
+// due to another write of the batch, e.g. on a deadlock
+//
+// The write was not committed and should be retried.
+var ErrWriteBatchAborted = errors.New("write batch aborted")
+
+type writeBatchItem struct {
+	shard string
+	f     func(tx *sql.Tx) error
+	done  chan error
+
+	claimed int32
+}
+
+// claim returns true if the caller may run the write
+//
+// A write is run once, either by a worker or, on shutdown, by the waiting
+// caller.
+func (it *writeBatchItem) claim() bool {
+	return atomic.CompareAndSwapInt32(&it.claimed, 0, 1)
+}
+
+// WriteBatcher groups writes of concurrent requests into shared transactions
+// of the payment database
+//
+// The writes of the projects of a shard are batched in transactions of the
+// shard.
+//
+// Each write runs within a savepoint of the batch transaction, so a failing
+// write is rolled back without affecting the other writes of the batch. The
+// batch is committed once it reached its maximum size or its first write
+// waited for the maximum delay. This trades a bounded latency increase for
+// a much higher throughput of inserts.
+//
+// If batching is disabled, each write runs in its own transaction.
+type WriteBatcher struct {
+	ctx *Context
+	log log15.Logger
+
+	enabled  bool
+	maxDelay time.Duration
+	maxSize  int
+	workers  int
+
+	started int32
+	items   chan *writeBatchItem
+}
+
+func writeBatcherFromConfig(ctx *Context, cfg config.Config) (*WriteBatcher, error) {
+	b := &WriteBatcher{
+		ctx: ctx,
+		log: ctx.Log().New(log15.Ctx{
+			"pkg":  "github.com/fritzpay/paymentd/pkg/service",
+			"type": "WriteBatcher",
+		}),
+		enabled:  cfg.Database.WriteBatch.Enabled,
+		maxDelay: defaultWriteBatchMaxDelay,
+		maxSize:  cfg.Database.WriteBatch.MaxSize,
+		workers:  cfg.Database.WriteBatch.Workers,
+	}
+	if !b.enabled {
+		return b, nil
+	}
+	var err error
+	if cfg.Database.WriteBatch.MaxDelay != "" {
+		if b.maxDelay, err = cfg.Database.WriteBatch.MaxDelay.Duration(); err != nil {
+			return nil, fmt.Errorf("invalid max delay: %v", err)
+		}
+	}
+	if b.maxDelay <= 0 {
+		return nil, fmt.Errorf("invalid max delay %s", b.maxDelay)
+	}
+	if b.maxSize <= 0 {
+		b.maxSize = defaultWriteBatchMaxSize
+	}
+	if b.workers <= 0 {
+		b.workers = 1
+	}
+	b.items = make(chan *writeBatchItem, b.maxSize*b.workers)
+	return b, nil
+}
+
+// Enabled returns true if writes are batched
+func (b *WriteBatcher) Enabled() bool {
+	return b != nil && b.enabled
+}
+
+// Start starts the workers committing the batches
+//
+// The workers stop when the service context is done. Writes which are still
+// queued then will be run in their own transactions. Writes are not batched
+// before the workers are started.
+func (b *WriteBatcher) Start() {
+	if !b.Enabled() || !atomic.CompareAndSwapInt32(&b.started, 0, 1) {
+		return
+	}
+	b.log.Info("batching payment writes", log15.Ctx{
+		"maxDelay": b.maxDelay,
+		"maxSize":  b.maxSize,
+		"workers":  b.workers,
+	})
+	for i := 0; i < b.workers; i++ {
+		go b.work()
+	}
+}
+
+func (b *WriteBatcher) running() bool {
+	return b.Enabled() && atomic.LoadInt32(&b.started) == 1
+}
+
+// Do runs f in a transaction of the payment database shard of the given
+// project and commits it
+//
+// The changes of f are rolled back if f returns an error, which will be
+// returned. Errors on begin and commit will be returned as well. f must not
+// commit or roll back the transaction.
+//
+// If batching is enabled, f runs in a transaction shared with other writes.
+// When the batch transaction is rolled back due to another write,
+// ErrWriteBatchAborted is returned.
+func (b *WriteBatcher) Do(projectID int64, f func(tx *sql.Tx) error) error {
+	shard := b.ctx.Shards().Shard(projectID)
+	if !b.running() {
+		return b.single(shard, f)
+	}
+	it := &writeBatchItem{shard: shard, f: f, done: make(chan error, 1)}
+	select {
+	case b.items <- it:
+	case <-b.ctx.Done():
+		return b.single(shard, f)
+	}
+	select {
+	case err := <-it.done:
+		return err
+	case <-b.ctx.Done():
+	}
+	// the workers might have stopped before picking up the write
+	if it.claim() {
+		return b.single(shard, f)
+	}
+	return <-it.done
+}
+
+func (b *WriteBatcher) single(shard string, f func(tx *sql.Tx) error) error {
+	tx, err := b.ctx.shardDB(shard).Begin()
+	if err != nil {
+		return err
+	}
+	err = runWriteBatchItem(tx, f)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (b *WriteBatcher) work() {
+	for {
+		var batch []*writeBatchItem
+		select {
+		case <-b.ctx.Done():
+			b.drain()
+			return
+		case it := <-b.items:
+			if it.claim() {
+				batch = append(batch, it)
+			}
+		}
+		timer := time.NewTimer(b.maxDelay)
+	collect:
+		for len(batch) < b.maxSize {
+			select {
+			case it := <-b.items:
+				if it.claim() {
+					batch = append(batch, it)
+				}
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		for _, shardBatch := range splitWriteBatch(batch) {
+			b.commit(shardBatch)
+		}
+	}
+}
+
+// drain runs the queued writes in their own transactions
+func (b *WriteBatcher) drain() {
+	for {
+		select {
+		case it := <-b.items:
+			if it.claim() {
+				it.done <- b.single(it.shard, it.f)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// splitWriteBatch splits the writes of a batch by their shards
+func splitWriteBatch(batch []*writeBatchItem) [][]*writeBatchItem {
+	var split [][]*writeBatchItem
+	idx := make(map[string]int)
+	for _, it := range batch {
+		i, ok := idx[it.shard]
+		if !ok {
+			i = len(split)
+			idx[it.shard] = i
+			split = append(split, nil)
+		}
+		split[i] = append(split[i], it)
+	}
+	return split
+}
+
+// commit runs the writes of the batch of a shard in a single transaction
+func (b *WriteBatcher) commit(batch []*writeBatchItem) {
+	abort := func(err error) {
+		for _, it := range batch {
+			it.done <- err
+		}
+	}
+	tx, err := b.ctx.shardDB(batch[0].shard).Begin()
+	if err != nil {
+		b.log.Error("error on begin", log15.Ctx{"err": err})
+		abort(err)
+		return
+	}
+	errs := make([]error, len(batch))
+	for i, it := range batch {
+		_, err = tx.Exec("SAVEPOINT " + writeBatchSavepoint)
+		if err != nil {
+			b.log.Error("error on savepoint", log15.Ctx{"err": err})
+			tx.Rollback()
+			abort(err)
+			return
+		}
+		errs[i] = runWriteBatchItem(tx, it.f)
+		if errs[i] == nil {
+			continue
+		}
+		_, err = tx.Exec("ROLLBACK TO SAVEPOINT " + writeBatchSavepoint)
+		if err != nil {
+			// the transaction was already rolled back, e.g. on a deadlock
+			b.log.Warn("write batch aborted", log15.Ctx{
+				"err":     err,
+				"itemErr": errs[i],
+				"size":    len(batch),
+			})
+			tx.Rollback()
+			for j, it := range batch {
+				if j == i {
+					it.done <- errs[i]
+				} else {
+					it.done <- ErrWriteBatchAborted
+				}
+			}
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		b.log.Error("error on commit", log15.Ctx{"err": err, "size": len(batch)})
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	for i, it := range batch {
+		it.done <- errs[i]
+	}
+}
+
+// runWriteBatchItem runs f, so a panicking write does not take down the batch
+func runWriteBatchItem(tx *sql.Tx, f func(tx *sql.Tx) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in write: %v", r)
+		}
+	}()
+	return f(tx)
+}
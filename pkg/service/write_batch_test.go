@@ -0,0 +1,254 @@
+package service
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/config"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// batchDriver records transactions. Savepoints cannot be rolled back after a
+// statement containing "DEADLOCK", like a transaction rolled back by MySQL
+type batchDriver struct {
+	m       sync.Mutex
+	begins  int
+	commits int
+	stmts   []string
+	aborted bool
+}
+
+func (d *batchDriver) reset() {
+	d.m.Lock()
+	d.begins, d.commits, d.stmts, d.aborted = 0, 0, nil, false
+	d.m.Unlock()
+}
+
+func (d *batchDriver) Open(name string) (driver.Conn, error) { return batchConn{d}, nil }
+
+type batchConn struct{ d *batchDriver }
+
+func (c batchConn) Prepare(query string) (driver.Stmt, error) { return batchStmt{c.d, query}, nil }
+func (c batchConn) Close() error                              { return nil }
+func (c batchConn) Begin() (driver.Tx, error) {
+	c.d.m.Lock()
+	c.d.begins++
+	c.d.aborted = false
+	c.d.m.Unlock()
+	return batchTx{c.d}, nil
+}
+
+type batchTx struct{ d *batchDriver }
+
+func (t batchTx) Commit() error {
+	t.d.m.Lock()
+	t.d.commits++
+	t.d.m.Unlock()
+	return nil
+}
+func (t batchTx) Rollback() error { return nil }
+
+type batchStmt struct {
+	d     *batchDriver
+	query string
+}
+
+func (s batchStmt) Close() error  { return nil }
+func (s batchStmt) NumInput() int { return -1 }
+func (s batchStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.m.Lock()
+	defer s.d.m.Unlock()
+	s.d.stmts = append(s.d.stmts, s.query)
+	if strings.Contains(s.query, "DEADLOCK") {
+		s.d.aborted = true
+		return nil, errors.New("deadlock")
+	}
+	if strings.HasPrefix(s.query, "ROLLBACK TO SAVEPOINT") && s.d.aborted {
+		return nil, errors.New("savepoint does not exist")
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s batchStmt) Query(args []driver.Value) (driver.Rows, error) { return batchRows{}, nil }
+
+type batchRows struct{}
+
+func (batchRows) Columns() []string              { return []string{"a"} }
+func (batchRows) Close() error                   { return nil }
+func (batchRows) Next(dest []driver.Value) error { return io.EOF }
+
+func execWrite(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+func TestWriteBatcher(t *testing.T) {
+	d := &batchDriver{}
+	sql.Register("write-batch-test", d)
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+
+	Convey("Given a write batch config", t, func() {
+		d.reset()
+		db, err := sql.Open("write-batch-test", "")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			db.Close()
+		})
+		cfg := config.DefaultConfig()
+		bgCtx, cancel := context.WithCancel(context.Background())
+		Reset(cancel)
+
+		Convey("When batching is disabled", func() {
+			ctx, err := NewContext(bgCtx, cfg, log)
+			So(err, ShouldBeNil)
+			ctx.SetPaymentDB(db, nil)
+			b := ctx.WriteBatcher()
+			b.Start()
+
+			Convey("Each write should be committed in its own transaction", func() {
+				So(b.Enabled(), ShouldBeFalse)
+				So(b.Do(1, execWrite("INSERT 1")), ShouldBeNil)
+				So(b.Do(1, execWrite("INSERT 2")), ShouldBeNil)
+				So(d.begins, ShouldEqual, 2)
+				So(d.commits, ShouldEqual, 2)
+				So(d.stmts, ShouldResemble, []string{"INSERT 1", "INSERT 2"})
+			})
+		})
+
+		Convey("When batching is enabled", func() {
+			cfg.Database.WriteBatch.Enabled = true
+			cfg.Database.WriteBatch.MaxDelay = config.Duration("1m")
+			cfg.Database.WriteBatch.MaxSize = 3
+			ctx, err := NewContext(bgCtx, cfg, log)
+			So(err, ShouldBeNil)
+			ctx.SetPaymentDB(db, nil)
+			b := ctx.WriteBatcher()
+			b.Start()
+			So(b.Enabled(), ShouldBeTrue)
+
+			do := func(writes ...func(tx *sql.Tx) error) []error {
+				errs := make([]error, len(writes))
+				var wg sync.WaitGroup
+				for i, f := range writes {
+					wg.Add(1)
+					go func(i int, f func(tx *sql.Tx) error) {
+						defer wg.Done()
+						errs[i] = b.Do(1, f)
+					}(i, f)
+				}
+				wg.Wait()
+				return errs
+			}
+
+			Convey("When concurrent writes fill a batch", func() {
+				errFailed := errors.New("failed")
+				errs := do(
+					execWrite("INSERT"),
+					func(tx *sql.Tx) error { return errFailed },
+					execWrite("INSERT"),
+				)
+
+				Convey("They should be committed in a single transaction", func() {
+					So(d.begins, ShouldEqual, 1)
+					So(d.commits, ShouldEqual, 1)
+				})
+				Convey("The failed write should be rolled back to its savepoint", func() {
+					So(errs, ShouldResemble, []error{nil, errFailed, nil})
+					So(d.stmts, ShouldContain, "ROLLBACK TO SAVEPOINT "+writeBatchSavepoint)
+				})
+			})
+
+			Convey("When a write panics", func() {
+				errs := do(
+					execWrite("INSERT"),
+					func(tx *sql.Tx) error { panic("write") },
+					execWrite("INSERT"),
+				)
+
+				Convey("Only the panicking write should fail", func() {
+					So(errs[1], ShouldNotBeNil)
+					So(errs[0], ShouldBeNil)
+					So(errs[2], ShouldBeNil)
+					So(d.commits, ShouldEqual, 1)
+				})
+			})
+
+			Convey("When a write rolls back the batch transaction", func() {
+				errs := do(
+					execWrite("INSERT"),
+					execWrite("DEADLOCK"),
+					execWrite("INSERT"),
+				)
+
+				Convey("The batch should not be committed", func() {
+					So(d.commits, ShouldEqual, 0)
+				})
+				Convey("The other writes should be aborted", func() {
+					var aborted, failed int
+					for _, err := range errs {
+						if err == ErrWriteBatchAborted {
+							aborted++
+						} else if err != nil {
+							failed++
+						}
+					}
+					So(aborted, ShouldEqual, 2)
+					So(failed, ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("When the context of a batcher is done", func() {
+			cfg.Database.WriteBatch.Enabled = true
+			cfg.Database.WriteBatch.MaxDelay = config.Duration("1m")
+			cfg.Database.WriteBatch.MaxSize = 3
+			ctx, err := NewContext(bgCtx, cfg, log)
+			So(err, ShouldBeNil)
+			ctx.SetPaymentDB(db, nil)
+			b := ctx.WriteBatcher()
+			b.Start()
+			cancel()
+
+			Convey("Writes should be run in their own transactions", func() {
+				for i := 0; i < 10; i++ {
+					So(b.Do(1, execWrite("INSERT")), ShouldBeNil)
+				}
+				So(d.commits, ShouldEqual, 10)
+			})
+		})
+
+		Convey("When a batch holds writes of several shards", func() {
+			batch := []*writeBatchItem{
+				{shard: DefaultShard},
+				{shard: "eu"},
+				{shard: DefaultShard},
+			}
+
+			Convey("It should be split into batches per shard", func() {
+				split := splitWriteBatch(batch)
+				So(len(split), ShouldEqual, 2)
+				So(split[0], ShouldResemble, []*writeBatchItem{batch[0], batch[2]})
+				So(split[1], ShouldResemble, []*writeBatchItem{batch[1]})
+			})
+		})
+
+		Convey("When batching is enabled with an invalid max delay", func() {
+			cfg.Database.WriteBatch.Enabled = true
+			cfg.Database.WriteBatch.MaxDelay = config.Duration("soon")
+			_, err := NewContext(bgCtx, cfg, log)
+
+			Convey("The context should not be created", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,132 @@
+package sqldialect
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect is an SQL dialect
+type Dialect interface {
+	// Name is the name of the database/sql driver of the dialect
+	Name() string
+	// Rebind rewrites a MySQL statement for the dialect
+	Rebind(query string) string
+	// Upsert returns the clause which turns an INSERT statement into an
+	// upsert. On a conflict of the key columns, the update columns will be set
+	// to the inserted values.
+	Upsert(keys, update []string) string
+	// DeadlockError returns a deadlock error of the dialect with the given
+	// message, e.g. to inject database faults
+	DeadlockError(msg string) error
+}
+
+var (
+	// MySQL is the MySQL dialect, in which the statements are written
+	MySQL Dialect = mysqlDialect{}
+	// PostgreSQL is the PostgreSQL dialect
+	PostgreSQL Dialect = postgresDialect{}
+)
+
+var current = MySQL
+
+// Default returns the dialect of the configured databases
+func Default() Dialect {
+	return current
+}
+
+// SetDefault sets the dialect of the configured databases
+//
+// It must be set before the databases are used.
+func SetDefault(d Dialect) {
+	current = d
+}
+
+// ByName returns the dialect of the given database/sql driver name, i.e. the
+// database type of the database config
+//
+// PostgreSQL is not accepted as a database type. There is no schema for it and
+// the inserts rely on the IDs of inserted rows, which PostgreSQL only returns
+// with a RETURNING clause.
+func ByName(name string) (Dialect, error) {
+	switch name {
+	case MySQL.Name():
+		return MySQL, nil
+	case PostgreSQL.Name():
+		return nil, fmt.Errorf("database type %s is not supported yet", name)
+	}
+	return nil, fmt.Errorf("unsupported database type %s", name)
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) Rebind(query string) string {
+	return query
+}
+
+func (mysqlDialect) Upsert(keys, update []string) string {
+	set := make([]string, len(update))
+	for i, col := range update {
+		set[i] = col + " = VALUES(" + col + ")"
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+}
+
+func (mysqlDialect) DeadlockError(msg string) error {
+	return mysqlDeadlock(msg)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+// Rebind replaces the ? placeholders with numbered placeholders and the
+// backtick quoted identifiers with double quoted identifiers
+func (postgresDialect) Rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	var quote byte
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			if c == '`' {
+				c = '"'
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			if c == '`' {
+				c = '"'
+			}
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func (postgresDialect) Upsert(keys, update []string) string {
+	set := make([]string, len(update))
+	for i, col := range update {
+		set[i] = col + " = EXCLUDED." + col
+	}
+	return "ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+func (postgresDialect) DeadlockError(msg string) error {
+	return &Error{State: stateDeadlock, Message: msg}
+}
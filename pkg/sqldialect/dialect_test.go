@@ -0,0 +1,182 @@
+package sqldialect
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRebind(t *testing.T) {
+	Convey("Given a MySQL statement", t, func() {
+		query := "SELECT `key`, '?' FROM t WHERE a = ? AND b = \"x?\" AND c IN (?, ?)"
+
+		Convey("MySQL should not rewrite it", func() {
+			So(MySQL.Rebind(query), ShouldEqual, query)
+		})
+		Convey("PostgreSQL should number the placeholders and quote the identifiers", func() {
+			So(PostgreSQL.Rebind(query), ShouldEqual, `SELECT "key", '?' FROM t WHERE a = $1 AND b = "x?" AND c IN ($2, $3)`)
+		})
+	})
+}
+
+func TestUpsert(t *testing.T) {
+	Convey("Given the columns of an upsert", t, func() {
+		keys := []string{"project_id", "payment_id"}
+		update := []string{"amount", "status"}
+
+		Convey("MySQL should update on duplicate keys", func() {
+			So(MySQL.Upsert(keys, update), ShouldEqual, "ON DUPLICATE KEY UPDATE amount = VALUES(amount), status = VALUES(status)")
+		})
+		Convey("PostgreSQL should update on conflict", func() {
+			So(PostgreSQL.Upsert(keys, update), ShouldEqual, "ON CONFLICT (project_id, payment_id) DO UPDATE SET amount = EXCLUDED.amount, status = EXCLUDED.status")
+		})
+	})
+}
+
+func TestByName(t *testing.T) {
+	Convey("The supported dialects should be found by their driver name", t, func() {
+		d, err := ByName("mysql")
+		So(err, ShouldBeNil)
+		So(d.Name(), ShouldEqual, "mysql")
+		_, err = ByName("postgres")
+		So(err, ShouldNotBeNil)
+		_, err = ByName("sqlite3")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestErrors(t *testing.T) {
+	Convey("Given MySQL errors", t, func() {
+		Convey("Deadlocks should be recognized", func() {
+			err := MySQL.DeadlockError("deadlock")
+			So(IsDeadlock(err), ShouldBeTrue)
+			So(IsDeadlock(fmt.Errorf("wrapped: %w", err)), ShouldBeTrue)
+			So(IsLockTimeout(err), ShouldBeFalse)
+		})
+		Convey("Lock timeouts should be recognized", func() {
+			So(IsLockTimeout(&mysql.MySQLError{Number: 1205}), ShouldBeTrue)
+		})
+		Convey("Duplicate keys should be recognized", func() {
+			So(IsDuplicate(&mysql.MySQLError{Number: 1062}), ShouldBeTrue)
+			So(IsDuplicate(&mysql.MySQLError{Number: 1213}), ShouldBeFalse)
+		})
+	})
+	Convey("Given PostgreSQL errors", t, func() {
+		Convey("Deadlocks and serialization failures should be recognized", func() {
+			So(IsDeadlock(PostgreSQL.DeadlockError("deadlock")), ShouldBeTrue)
+			So(IsDeadlock(&Error{State: "40001"}), ShouldBeTrue)
+		})
+		Convey("Lock timeouts should be recognized", func() {
+			So(IsLockTimeout(&Error{State: "55P03"}), ShouldBeTrue)
+		})
+		Convey("Duplicate keys should be recognized", func() {
+			So(IsDuplicate(&Error{State: "23505"}), ShouldBeTrue)
+			So(IsDuplicate(&Error{State: "40P01"}), ShouldBeFalse)
+		})
+	})
+	Convey("Other errors should not be recognized", t, func() {
+		err := errors.New("other")
+		So(IsDeadlock(err), ShouldBeFalse)
+		So(IsLockTimeout(err), ShouldBeFalse)
+		So(IsDuplicate(err), ShouldBeFalse)
+		So(IsDeadlock(nil), ShouldBeFalse)
+	})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return &fakeConn{}, nil }
+
+// fakeConn records the executed statements
+type fakeConn struct{}
+
+var executed []string
+
+func (*fakeConn) Prepare(query string) (driver.Stmt, error) {
+	executed = append(executed, query)
+	return fakeStmt{}, nil
+}
+func (*fakeConn) Close() error              { return nil }
+func (*fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                                    { return nil }
+func (fakeStmt) NumInput() int                                   { return -1 }
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestWrap(t *testing.T) {
+	sql.Register("sqldialect-test", Wrap(fakeDriver{}, PostgreSQL))
+
+	Convey("Given a driver wrapped for PostgreSQL", t, func() {
+		db, err := sql.Open("sqldialect-test", "")
+		So(err, ShouldBeNil)
+		defer db.Close()
+		executed = nil
+
+		Convey("Statements should be rewritten", func() {
+			_, err = db.Exec("UPDATE a SET b = ? WHERE c = ?", 1, 2)
+			So(err, ShouldBeNil)
+			So(executed, ShouldResemble, []string{"UPDATE a SET b = $1 WHERE c = $2"})
+		})
+	})
+	Convey("Given the MySQL dialect", t, func() {
+		Convey("The parent driver should be returned", func() {
+			So(Wrap(fakeDriver{}, MySQL), ShouldResemble, fakeDriver{})
+		})
+	})
+}
+
+type fakeContextDriver struct{}
+
+func (fakeContextDriver) Open(name string) (driver.Conn, error) { return &fakeContextConn{}, nil }
+
+type contextKey struct{}
+
+// fakeContextConn records the executed statements with the value of the
+// context
+type fakeContextConn struct {
+	fakeConn
+}
+
+func (*fakeContextConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	executed = append(executed, fmt.Sprintf("%v: %s", ctx.Value(contextKey{}), query))
+	return driver.RowsAffected(1), nil
+}
+
+func (*fakeContextConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	executed = append(executed, fmt.Sprintf("%v: %s", ctx.Value(contextKey{}), query))
+	return nil, errors.New("not supported")
+}
+
+func TestWrapContext(t *testing.T) {
+	sql.Register("sqldialect-context-test", Wrap(fakeContextDriver{}, PostgreSQL))
+
+	Convey("Given a context driver wrapped for PostgreSQL", t, func() {
+		db, err := sql.Open("sqldialect-context-test", "")
+		So(err, ShouldBeNil)
+		defer db.Close()
+		executed = nil
+		ctx := context.WithValue(context.Background(), contextKey{}, "ctx")
+
+		Convey("Statements should be executed with the context", func() {
+			_, err = db.ExecContext(ctx, "UPDATE a SET b = ?", 1)
+			So(err, ShouldBeNil)
+			_, err = db.QueryContext(ctx, "SELECT b FROM a WHERE c = ?", 2)
+			So(err, ShouldNotBeNil)
+			So(executed, ShouldResemble, []string{
+				"ctx: UPDATE a SET b = $1",
+				"ctx: SELECT b FROM a WHERE c = $1",
+			})
+		})
+	})
+}
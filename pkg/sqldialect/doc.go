@@ -0,0 +1,31 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package sqldialect abstracts the differences of the supported SQL databases
+
+The statements of paymentd are written for MySQL. A Dialect rewrites them for
+other databases, classifies database errors and builds the statements which
+cannot be rewritten, like upserts. The connections of the PostgreSQL dialect
+are opened through a driver which rewrites the placeholders and quoted
+identifiers of the statements. PostgreSQL cannot be configured as a database
+type yet, since paymentd has no schema for it and its inserts rely on the IDs of
+inserted rows.
+
+Deadlocks, lock timeouts and duplicate keys are recognized for all dialects,
+so callers do not have to inspect the errors of a specific driver.
+*/
+package sqldialect
@@ -0,0 +1,180 @@
+package sqldialect
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// Driver is a database/sql driver rewriting the statements for the dialect of
+// another driver
+type Driver struct {
+	parent  driver.Driver
+	dialect Dialect
+}
+
+// Wrap returns a driver rewriting the statements for the given dialect
+//
+// If the dialect does not need to rewrite statements, the parent driver will be
+// returned.
+func Wrap(parent driver.Driver, d Dialect) driver.Driver {
+	if d == MySQL {
+		return parent
+	}
+	return &Driver{
+		parent:  parent,
+		dialect: d,
+	}
+}
+
+// Open implements driver.Driver
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.parent.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, d: d}, nil
+}
+
+// conn rewrites the statements of a connection of the parent driver
+//
+// The context interfaces are passed through, so that the contexts of the
+// statements reach the parent driver and statements can be cancelled.
+type conn struct {
+	driver.Conn
+	d *Driver
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(c.d.dialect.Rebind(query))
+}
+
+// PrepareContext implements driver.ConnPrepareContext
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	query = c.d.dialect.Rebind(query)
+	if pr, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return pr.PrepareContext(ctx, query)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	return c.Conn.Prepare(query)
+}
+
+// BeginTx implements driver.ConnBeginTx
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, errors.New("sqldialect: driver does not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("sqldialect: driver does not support read-only transactions")
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	return c.Conn.Begin()
+}
+
+// Exec implements driver.Execer if the wrapped connection does
+func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	ex, ok := c.Conn.(driver.Execer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return ex.Exec(c.d.dialect.Rebind(query), args)
+}
+
+// ExecContext implements driver.ExecerContext if the wrapped connection does
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ex, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return ex.ExecContext(ctx, c.d.dialect.Rebind(query), args)
+}
+
+// Query implements driver.Queryer if the wrapped connection does
+func (c *conn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.Queryer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return q.Query(c.d.dialect.Rebind(query), args)
+}
+
+// QueryContext implements driver.QueryerContext if the wrapped connection does
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return q.QueryContext(ctx, c.d.dialect.Rebind(query), args)
+}
+
+// Ping implements driver.Pinger
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession implements driver.SessionResetter
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid implements driver.Validator
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue implements driver.NamedValueChecker
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+// Open opens a database of the given dialect
+//
+// The connections of dialects other than MySQL are opened through a driver
+// rewriting the statements.
+func Open(d Dialect, dsn string) (*sql.DB, error) {
+	if d == MySQL {
+		return sql.Open(d.Name(), dsn)
+	}
+	driverName := d.Name() + "-sqldialect"
+	registered := false
+	for _, name := range sql.Drivers() {
+		if name == driverName {
+			registered = true
+		}
+	}
+	if !registered {
+		// sql.Open does not connect, it is only used to look up the driver
+		parent, err := sql.Open(d.Name(), "")
+		if err != nil {
+			return nil, err
+		}
+		dr := parent.Driver()
+		parent.Close()
+		sql.Register(driverName, Wrap(dr, d))
+	}
+	return sql.Open(driverName, dsn)
+}
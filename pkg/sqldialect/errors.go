@@ -0,0 +1,109 @@
+package sqldialect
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL error numbers
+const (
+	mysqlErrLockTimeout = 1205
+	mysqlErrDeadlock    = 1213
+	mysqlErrDuplicate   = 1062
+)
+
+// PostgreSQL error codes (SQLSTATE)
+const (
+	stateDeadlock      = "40P01"
+	stateSerialization = "40001"
+	stateLockTimeout   = "55P03"
+	stateDuplicate     = "23505"
+)
+
+// Error is a database error identified by its SQLSTATE
+type Error struct {
+	State   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message + " (SQLSTATE " + e.State + ")"
+}
+
+// SQLState returns the SQLSTATE of the error
+func (e *Error) SQLState() string {
+	return e.State
+}
+
+// sqlStateError is implemented by the errors of PostgreSQL drivers
+type sqlStateError interface {
+	error
+	SQLState() string
+}
+
+func mysqlDeadlock(msg string) error {
+	return &mysql.MySQLError{
+		Number:  mysqlErrDeadlock,
+		Message: msg,
+	}
+}
+
+func mysqlNumber(err error) (uint16, bool) {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number, true
+	}
+	return 0, false
+}
+
+func sqlState(err error) (string, bool) {
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState(), true
+	}
+	return "", false
+}
+
+// IsDeadlock returns true if the transaction was aborted because of a deadlock
+// or a serialization failure and can be retried
+func IsDeadlock(err error) bool {
+	if err == nil {
+		return false
+	}
+	if n, ok := mysqlNumber(err); ok {
+		return n == mysqlErrDeadlock
+	}
+	if state, ok := sqlState(err); ok {
+		return state == stateDeadlock || state == stateSerialization
+	}
+	return false
+}
+
+// IsLockTimeout returns true if a lock could not be acquired in time
+func IsLockTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if n, ok := mysqlNumber(err); ok {
+		return n == mysqlErrLockTimeout
+	}
+	if state, ok := sqlState(err); ok {
+		return state == stateLockTimeout
+	}
+	return false
+}
+
+// IsDuplicate returns true if a unique key was violated
+func IsDuplicate(err error) bool {
+	if err == nil {
+		return false
+	}
+	if n, ok := mysqlNumber(err); ok {
+		return n == mysqlErrDuplicate
+	}
+	if state, ok := sqlState(err); ok {
+		return state == stateDuplicate
+	}
+	return false
+}
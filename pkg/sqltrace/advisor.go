@@ -0,0 +1,233 @@
+package sqltrace
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	// maximum number of distinct queries which will be explained
+	maxExplainedQueries = 1000
+)
+
+// Plan is a row of the query plan of a MySQL EXPLAIN statement
+type Plan struct {
+	Table        string
+	Type         string
+	PossibleKeys string
+	Key          string
+	Rows         int64
+	Extra        string
+}
+
+// MissingIndex returns true if no index could be used to access the table
+func (p Plan) MissingIndex() bool {
+	return p.Type == "ALL" && p.PossibleKeys == "" && p.Table != ""
+}
+
+// Filesort returns true if the rows of the table have to be sorted without an
+// index
+func (p Plan) Filesort() bool {
+	return strings.Contains(p.Extra, "Using filesort")
+}
+
+// advisor explains the SELECT statements of an instrumented driver once and
+// logs their query plans
+type advisor struct {
+	log log15.Logger
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// EnableAdvisor enables the index advisor of the driver
+//
+// The advisor is a development aid. Every distinct SELECT statement will be
+// explained on its connection before it is executed for the first time. The
+// query plan will be logged at debug level. Table scans without a usable index
+// and sorts without an index will be logged as warnings. Only MySQL is
+// supported.
+func (d *Driver) EnableAdvisor() {
+	d.advisor = &advisor{
+		log:  d.log.New(log15.Ctx{"type": "advisor"}),
+		seen: make(map[string]bool),
+	}
+}
+
+// first returns true if the query was not seen before
+func (a *advisor) first(query string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.seen[query] || len(a.seen) >= maxExplainedQueries {
+		return false
+	}
+	a.seen[query] = true
+	return true
+}
+
+// advise explains the query on the connection and logs its plan
+func (a *advisor) advise(c driver.Conn, query string, args []driver.Value) {
+	if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "SELECT") {
+		return
+	}
+	normalized := normalizeQuery(query)
+	if !a.first(normalized) {
+		return
+	}
+	plans, err := explain(c, query, args)
+	if err != nil {
+		a.log.Info("error explaining query", log15.Ctx{
+			"query": normalized,
+			"err":   err,
+		})
+		return
+	}
+	log := a.log.New(log15.Ctx{
+		"query":  normalized,
+		"caller": caller(),
+	})
+	for _, p := range plans {
+		log.Debug("query plan", log15.Ctx{
+			"table":        p.Table,
+			"type":         p.Type,
+			"possibleKeys": p.PossibleKeys,
+			"key":          p.Key,
+			"rows":         p.Rows,
+			"extra":        p.Extra,
+		})
+		if p.MissingIndex() {
+			log.Warn("missing index", log15.Ctx{
+				"table": p.Table,
+				"rows":  p.Rows,
+			})
+		}
+		if p.Filesort() {
+			log.Warn("sort without index", log15.Ctx{
+				"table": p.Table,
+				"key":   p.Key,
+			})
+		}
+	}
+}
+
+// explain runs EXPLAIN for the query with the given arguments on the
+// connection
+func explain(c driver.Conn, query string, args []driver.Value) ([]Plan, error) {
+	s, err := c.Prepare("EXPLAIN " + query)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	rows, err := s.Query(args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := rows.Columns()
+	dest := make([]driver.Value, len(cols))
+	var plans []Plan
+	for {
+		err = rows.Next(dest)
+		if err == io.EOF {
+			return plans, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var p Plan
+		for i, col := range cols {
+			v := planValue(dest[i])
+			switch strings.ToLower(col) {
+			case "table":
+				p.Table = v
+			case "type":
+				p.Type = v
+			case "possible_keys":
+				p.PossibleKeys = v
+			case "key":
+				p.Key = v
+			case "rows":
+				p.Rows, _ = strconv.ParseInt(v, 10, 64)
+			case "extra":
+				p.Extra = v
+			}
+		}
+		plans = append(plans, p)
+	}
+}
+
+func planValue(v driver.Value) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(t)
+	case string:
+		return t
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// Index is an index the queries rely on
+type Index struct {
+	Table   string
+	Name    string
+	Columns []string
+}
+
+func (i Index) String() string {
+	return i.Table + "." + i.Name + " (" + strings.Join(i.Columns, ", ") + ")"
+}
+
+const selectIndexColumns = `
+SELECT column_name
+FROM information_schema.statistics
+WHERE
+	table_schema = DATABASE()
+	AND
+	table_name = ?
+	AND
+	index_name = ?
+ORDER BY seq_in_index
+`
+
+// MissingIndexes returns the indexes which are not present in the live MySQL
+// schema of the database
+//
+// An index with the same name but different columns counts as missing.
+func MissingIndexes(db *sql.DB, indexes []Index) ([]Index, error) {
+	var missing []Index
+	for _, idx := range indexes {
+		rows, err := db.Query(selectIndexColumns, idx.Table, idx.Name)
+		if err != nil {
+			return nil, err
+		}
+		var cols []string
+		for rows.Next() {
+			var col string
+			err = rows.Scan(&col)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			cols = append(cols, col)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		if strings.Join(cols, ",") != strings.Join(idx.Columns, ",") {
+			missing = append(missing, idx)
+		}
+	}
+	return missing, nil
+}
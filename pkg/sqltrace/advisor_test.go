@@ -0,0 +1,116 @@
+package sqltrace
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// explainDriver answers EXPLAIN statements with a full table scan plan
+type explainDriver struct {
+	mu       sync.Mutex
+	explains int
+}
+
+func (d *explainDriver) Open(name string) (driver.Conn, error) { return explainConn{d}, nil }
+
+type explainConn struct{ d *explainDriver }
+
+func (c explainConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.HasPrefix(query, "EXPLAIN ") {
+		c.d.mu.Lock()
+		c.d.explains++
+		c.d.mu.Unlock()
+		return explainStmt{}, nil
+	}
+	return fakeStmt(query), nil
+}
+func (explainConn) Close() error              { return nil }
+func (explainConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type explainStmt struct{}
+
+func (explainStmt) Close() error  { return nil }
+func (explainStmt) NumInput() int { return -1 }
+func (explainStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+func (explainStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &explainRows{}, nil
+}
+
+type explainRows struct{ done bool }
+
+func (*explainRows) Columns() []string {
+	return []string{"id", "select_type", "table", "type", "possible_keys", "key", "key_len", "ref", "rows", "Extra"}
+}
+func (*explainRows) Close() error { return nil }
+func (r *explainRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, []driver.Value{int64(1), []byte("SIMPLE"), []byte("payment"), []byte("ALL"), nil, nil, nil, nil, []byte("120"), []byte("Using where; Using filesort")})
+	return nil
+}
+
+func TestAdvisor(t *testing.T) {
+	var mu sync.Mutex
+	var warnings []string
+	log := log15.New()
+	log.SetHandler(log15.FuncHandler(func(r *log15.Record) error {
+		if r.Lvl == log15.LvlWarn {
+			mu.Lock()
+			warnings = append(warnings, r.Msg)
+			mu.Unlock()
+		}
+		return nil
+	}))
+	d := &explainDriver{}
+	trace := Wrap(d, 0, log, NewStats())
+	trace.EnableAdvisor()
+	sql.Register("sqltrace-advisor-test", trace)
+
+	Convey("Given a driver with the index advisor", t, func() {
+		db, err := sql.Open("sqltrace-advisor-test", "")
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		Convey("When a query is executed twice", func() {
+			for i := 0; i < 2; i++ {
+				rows, err := db.Query("SELECT id FROM payment WHERE currency = ? ORDER BY created", "EUR")
+				So(err, ShouldBeNil)
+				rows.Close()
+			}
+			_, err = db.Exec("UPDATE payment SET currency = ?", "EUR")
+			So(err, ShouldBeNil)
+
+			Convey("It should be explained once", func() {
+				So(d.explains, ShouldEqual, 1)
+			})
+			Convey("The table scan and the sort should be reported", func() {
+				mu.Lock()
+				defer mu.Unlock()
+				So(warnings, ShouldResemble, []string{"missing index", "sort without index"})
+			})
+		})
+	})
+
+	Convey("Given query plans", t, func() {
+		Convey("A table scan with a usable index should not be reported", func() {
+			p := Plan{Table: "payment", Type: "ALL", PossibleKeys: "ident"}
+			So(p.MissingIndex(), ShouldBeFalse)
+		})
+		Convey("An index lookup should not be reported", func() {
+			p := Plan{Table: "payment", Type: "ref", PossibleKeys: "ident", Key: "ident"}
+			So(p.MissingIndex(), ShouldBeFalse)
+			So(p.Filesort(), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,28 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package sqltrace provides an instrumented database/sql driver
+
+The driver wraps another driver and counts the executed statements. Statements
+exceeding a threshold will be logged and kept in a list of recent slow queries
+along with their call site. Bound parameters are never recorded, only their
+number.
+
+In development, the index advisor of the driver explains the SELECT statements
+and logs their query plans and missing indexes.
+*/
+package sqltrace
@@ -0,0 +1,243 @@
+package sqltrace
+
+import (
+	"database/sql/driver"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const pkgPath = "github.com/fritzpay/paymentd/pkg/sqltrace"
+
+const (
+	// RecentSlowQueries is the number of slow queries which will be kept
+	RecentSlowQueries = 50
+	// maximum length of recorded query texts
+	maxQueryLength = 500
+)
+
+// SlowQuery is a recorded slow query
+type SlowQuery struct {
+	Time     time.Time
+	Duration string
+	// Query is the statement with collapsed whitespace
+	Query string
+	// Args is the number of bound parameters. Their values are redacted
+	Args int
+	// Caller is the call site outside of database/sql
+	Caller string
+}
+
+// Stats holds the statistics of instrumented drivers
+//
+// It is safe for concurrent use.
+type Stats struct {
+	mu      sync.Mutex
+	queries int64
+	slow    int64
+	errors  int64
+	recent  []SlowQuery
+}
+
+// NewStats creates a new stats container
+func NewStats() *Stats {
+	return &Stats{
+		recent: make([]SlowQuery, 0, RecentSlowQueries),
+	}
+}
+
+// Report is a snapshot of the statistics
+type Report struct {
+	Queries     int64
+	SlowQueries int64
+	Errors      int64
+	// Recent slow queries, the latest first
+	Recent []SlowQuery
+}
+
+// Report returns a snapshot of the statistics
+func (s *Stats) Report() Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := Report{
+		Queries:     s.queries,
+		SlowQueries: s.slow,
+		Errors:      s.errors,
+		Recent:      make([]SlowQuery, len(s.recent)),
+	}
+	for i, q := range s.recent {
+		r.Recent[len(s.recent)-1-i] = q
+	}
+	return r
+}
+
+func (s *Stats) record(err error, slow *SlowQuery) {
+	s.mu.Lock()
+	s.queries++
+	if err != nil && err != driver.ErrSkip {
+		s.errors++
+	}
+	if slow != nil {
+		s.slow++
+		if len(s.recent) == RecentSlowQueries {
+			copy(s.recent, s.recent[1:])
+			s.recent = s.recent[:len(s.recent)-1]
+		}
+		s.recent = append(s.recent, *slow)
+	}
+	s.mu.Unlock()
+}
+
+// Driver is an instrumented driver
+type Driver struct {
+	parent    driver.Driver
+	threshold time.Duration
+	log       log15.Logger
+	stats     *Stats
+	advisor   *advisor
+}
+
+// Wrap returns an instrumented driver wrapping the given driver
+//
+// Statements taking longer than the threshold will be logged and recorded in
+// the stats. A threshold <= 0 disables slow query logging.
+func Wrap(parent driver.Driver, threshold time.Duration, log log15.Logger, stats *Stats) *Driver {
+	return &Driver{
+		parent:    parent,
+		threshold: threshold,
+		log:       log.New(log15.Ctx{"pkg": pkgPath}),
+		stats:     stats,
+	}
+}
+
+// Open implements driver.Driver
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.parent.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, d: d}, nil
+}
+
+// observe records a finished statement
+func (d *Driver) observe(query string, args int, start time.Time, err error) {
+	if err == driver.ErrSkip {
+		return
+	}
+	dur := time.Since(start)
+	if d.threshold <= 0 || dur < d.threshold {
+		d.stats.record(err, nil)
+		return
+	}
+	slow := &SlowQuery{
+		Time:     start,
+		Duration: dur.String(),
+		Query:    normalizeQuery(query),
+		Args:     args,
+		Caller:   caller(),
+	}
+	d.stats.record(err, slow)
+	d.log.Warn("slow query", log15.Ctx{
+		"duration": dur,
+		"query":    slow.Query,
+		"args":     args,
+		"caller":   slow.Caller,
+	})
+}
+
+// normalizeQuery collapses whitespace and truncates the query
+func normalizeQuery(q string) string {
+	q = strings.Join(strings.Fields(q), " ")
+	if len(q) > maxQueryLength {
+		q = q[:maxQueryLength] + "..."
+	}
+	return q
+}
+
+// caller returns the first call site outside of database/sql and this package
+func caller() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		internal := strings.HasPrefix(f.Function, "database/sql") ||
+			strings.HasPrefix(f.Function, "runtime.") ||
+			(strings.HasPrefix(f.Function, pkgPath+".") && !strings.HasSuffix(f.File, "_test.go"))
+		if !internal {
+			return f.Function + " (" + filepath.Base(f.File) + ":" + strconv.Itoa(f.Line) + ")"
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+type conn struct {
+	driver.Conn
+	d *Driver
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, d: c.d, conn: c.Conn, query: query}, nil
+}
+
+// Exec implements driver.Execer if the wrapped connection does
+func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	ex, ok := c.Conn.(driver.Execer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := ex.Exec(query, args)
+	c.d.observe(query, len(args), start, err)
+	return res, err
+}
+
+// Query implements driver.Queryer if the wrapped connection does
+func (c *conn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.Queryer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if c.d.advisor != nil {
+		c.d.advisor.advise(c.Conn, query, args)
+	}
+	start := time.Now()
+	rows, err := q.Query(query, args)
+	c.d.observe(query, len(args), start, err)
+	return rows, err
+}
+
+type stmt struct {
+	driver.Stmt
+	d     *Driver
+	conn  driver.Conn
+	query string
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
+	res, err := s.Stmt.Exec(args)
+	s.d.observe(s.query, len(args), start, err)
+	return res, err
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.d.advisor != nil {
+		s.d.advisor.advise(s.conn, s.query, args)
+	}
+	start := time.Now()
+	rows, err := s.Stmt.Query(args)
+	s.d.observe(s.query, len(args), start, err)
+	return rows, err
+}
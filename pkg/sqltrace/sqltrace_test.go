@@ -0,0 +1,84 @@
+package sqltrace
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// fakeDriver sleeps on statements containing "SLEEP"
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt(query), nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt string
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.Contains(string(s), "SLEEP") {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) { return fakeRows{}, nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return []string{"a"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+func TestWrap(t *testing.T) {
+	log := log15.New()
+	log.SetHandler(log15.DiscardHandler())
+	stats := NewStats()
+	sql.Register("sqltrace-test", Wrap(fakeDriver{}, 10*time.Millisecond, log, stats))
+
+	Convey("Given an instrumented driver", t, func() {
+		before := stats.Report()
+		db, err := sql.Open("sqltrace-test", "")
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		Convey("When fast and slow statements are executed", func() {
+			_, err = db.Exec("UPDATE a SET b = ?", "secret")
+			So(err, ShouldBeNil)
+			_, err = db.Exec("SELECT\n\tSLEEP(1)\nFROM a WHERE b = ? AND c = ?", "secret", 1)
+			So(err, ShouldBeNil)
+			rows, err := db.Query("SELECT a FROM a")
+			So(err, ShouldBeNil)
+			rows.Close()
+
+			report := stats.Report()
+			Convey("All statements should be counted", func() {
+				So(report.Queries-before.Queries, ShouldEqual, 3)
+				So(report.SlowQueries-before.SlowQueries, ShouldEqual, 1)
+			})
+			Convey("The slow query should be recorded without its parameters", func() {
+				So(len(report.Recent), ShouldEqual, len(before.Recent)+1)
+				q := report.Recent[0]
+				So(q.Query, ShouldEqual, "SELECT SLEEP(1) FROM a WHERE b = ? AND c = ?")
+				So(q.Args, ShouldEqual, 2)
+				So(q.Caller, ShouldContainSubstring, "sqltrace_test.go")
+			})
+		})
+	})
+}
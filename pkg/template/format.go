@@ -0,0 +1,190 @@
+package template
+
+import (
+	"strings"
+
+	"code.google.com/p/godec/dec"
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	"github.com/fritzpay/paymentd/pkg/paymentd/currency"
+)
+
+// symbol placement of a number format
+const (
+	symbolBefore = iota
+	symbolBeforeSpace
+	symbolAfterSpace
+)
+
+type numberFormat struct {
+	decimal   string
+	group     string
+	placement int
+}
+
+// no-break space used between the number and the symbol and as a group
+// separator
+const nbsp = "\u00a0"
+
+var defaultNumberFormat = numberFormat{".", ",", symbolBefore}
+
+// number formats by locale or language
+var numberFormats = map[string]numberFormat{
+	"en":    {".", ",", symbolBefore},
+	"en_IE": {".", ",", symbolBefore},
+	"de":    {",", ".", symbolAfterSpace},
+	"de_AT": {",", nbsp, symbolBeforeSpace},
+	"de_CH": {".", "'", symbolBeforeSpace},
+	"fr":    {",", nbsp, symbolAfterSpace},
+	"fr_CH": {",", nbsp, symbolAfterSpace},
+	"it":    {",", ".", symbolAfterSpace},
+	"it_CH": {".", "'", symbolBeforeSpace},
+	"es":    {",", ".", symbolAfterSpace},
+	"es_MX": {".", ",", symbolBefore},
+	"pt":    {",", nbsp, symbolAfterSpace},
+	"pt_BR": {",", ".", symbolBeforeSpace},
+	"nl":    {",", ".", symbolBeforeSpace},
+	"pl":    {",", nbsp, symbolAfterSpace},
+	"cs":    {",", nbsp, symbolAfterSpace},
+	"sv":    {",", nbsp, symbolAfterSpace},
+	"da":    {",", ".", symbolAfterSpace},
+	"nb":    {",", nbsp, symbolAfterSpace},
+	"fi":    {",", nbsp, symbolAfterSpace},
+	"ru":    {",", nbsp, symbolAfterSpace},
+	"tr":    {",", ".", symbolBefore},
+	"ja":    {".", ",", symbolBefore},
+	"zh":    {".", ",", symbolBefore},
+	"ko":    {".", ",", symbolBefore},
+}
+
+// currency symbols by ISO 4217 code
+//
+// Currencies not listed will be displayed with their code.
+var currencySymbols = map[string]string{
+	"EUR": "€",
+	"USD": "$",
+	"GBP": "£",
+	"CHF": "CHF",
+	"JPY": "¥",
+	"CNY": "¥",
+	"KRW": "₩",
+	"INR": "₹",
+	"RUB": "₽",
+	"TRY": "₺",
+	"PLN": "zł",
+	"CZK": "Kč",
+	"HUF": "Ft",
+	"SEK": "kr",
+	"NOK": "kr",
+	"DKK": "kr.",
+	"BRL": "R$",
+	"AUD": "A$",
+	"CAD": "CA$",
+	"NZD": "NZ$",
+	"MXN": "MX$",
+	"ISK": "kr",
+	"BHD": "BHD",
+	"KWD": "KWD",
+}
+
+func localeNumberFormat(locale string) numberFormat {
+	locale = NormalizeLocale(locale)
+	if f, ok := numberFormats[locale]; ok {
+		return f
+	}
+	if f, ok := numberFormats[strings.Split(locale, "_")[0]]; ok {
+		return f
+	}
+	return defaultNumberFormat
+}
+
+// CurrencySymbol returns the display symbol of the given currency
+//
+// If no symbol is known, the currency code will be returned.
+func CurrencySymbol(code string) string {
+	code = strings.ToUpper(code)
+	if s, ok := currencySymbols[code]; ok {
+		return s
+	}
+	return code
+}
+
+// FormatNumber formats the decimal in the given locale, rounded to the given
+// number of decimal places
+func FormatNumber(d *decimal.Decimal, scale int32, locale string) string {
+	return formatNumber(d, scale, localeNumberFormat(locale))
+}
+
+func formatNumber(d *decimal.Decimal, scale int32, f numberFormat) string {
+	if d == nil {
+		return ""
+	}
+	r := &decimal.Decimal{}
+	r.Round(&d.Dec, dec.Scale(scale), dec.RoundHalfUp)
+	intPart, decPart := r.IntegerPart(), r.DecimalPart()
+	var sign string
+	if strings.HasPrefix(intPart, "-") {
+		sign, intPart = "-", intPart[1:]
+	}
+	groups := make([]string, 0, len(intPart)/3+1)
+	for len(intPart) > 3 {
+		groups = append([]string{intPart[len(intPart)-3:]}, groups...)
+		intPart = intPart[:len(intPart)-3]
+	}
+	groups = append([]string{intPart}, groups...)
+	s := sign + strings.Join(groups, f.group)
+	if decPart != "" {
+		s += f.decimal + decPart
+	}
+	return s
+}
+
+// FormatAmount formats the amount in the given currency for display in the
+// given locale
+//
+// The amount will be rounded to the ISO 4217 decimal places of the currency and the
+// currency symbol will be placed according to the locale, e.g. 1234.5 EUR will
+// be displayed as "€1,234.50" in en_US and as "1.234,50 €" in de_DE.
+func FormatAmount(d *decimal.Decimal, code, locale string) string {
+	if d == nil {
+		return ""
+	}
+	symbol := CurrencySymbol(code)
+	f := localeNumberFormat(locale)
+	num := formatNumber(d, currency.Exponent(code), f)
+	switch f.placement {
+	case symbolBeforeSpace:
+		return symbol + nbsp + num
+	case symbolAfterSpace:
+		return num + nbsp + symbol
+	default:
+		// keep the sign in front of the symbol
+		var sign string
+		if strings.HasPrefix(num, "-") {
+			sign, num = "-", num[1:]
+		}
+		// separate currency codes like "CHF"
+		if len(symbol) > 1 && symbol == strings.ToUpper(code) {
+			return sign + symbol + nbsp + num
+		}
+		return sign + symbol + num
+	}
+}
+
+// FormatFuncs returns the formatting template functions for the given locale
+//
+// The locale should be the locale of the shopper. The returned functions are:
+//
+//	formatAmount: {{formatAmount .amount .payment.Currency}}
+//	formatNumber: {{formatNumber .amount 2}}
+//	currencySymbol: {{currencySymbol .payment.Currency}}
+func FormatFuncs(locale string) map[string]interface{} {
+	return map[string]interface{}{
+		"formatAmount": func(d *decimal.Decimal, code string) string {
+			return FormatAmount(d, code, locale)
+		},
+		"formatNumber": func(d *decimal.Decimal, scale int) string {
+			return FormatNumber(d, int32(scale), locale)
+		},
+		"currencySymbol": CurrencySymbol,
+	}
+}
@@ -0,0 +1,43 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFormatAmount(t *testing.T) {
+	Convey("Given an amount", t, func() {
+		d := &decimal.Decimal{}
+		d.SetString("1234567.89")
+
+		Convey("It should be formatted according to the locale", func() {
+			So(FormatAmount(d, "EUR", "en_US"), ShouldEqual, "€1,234,567.89")
+			So(FormatAmount(d, "EUR", "de_DE"), ShouldEqual, "1.234.567,89 €")
+			So(FormatAmount(d, "CHF", "de_CH"), ShouldEqual, "CHF 1'234'567.89")
+			So(FormatAmount(d, "EUR", "fr-FR"), ShouldEqual, "1 234 567,89 €")
+			So(FormatAmount(d, "USD", "xx"), ShouldEqual, "$1,234,567.89")
+		})
+		Convey("It should be rounded to the currency decimal places", func() {
+			So(FormatAmount(d, "JPY", "ja_JP"), ShouldEqual, "¥1,234,568")
+			So(FormatAmount(d, "KWD", "en_US"), ShouldEqual, "KWD 1,234,567.890")
+		})
+		Convey("Unknown currencies should be displayed with their code", func() {
+			So(FormatAmount(d, "xyz", "en_US"), ShouldEqual, "XYZ 1,234,567.89")
+		})
+		Convey("Negative amounts should keep the sign in front", func() {
+			d.Neg(&d.Dec)
+			So(FormatAmount(d, "GBP", "en_GB"), ShouldEqual, "-£1,234,567.89")
+			So(FormatNumber(d, 1, "de_DE"), ShouldEqual, "-1.234.567,9")
+		})
+	})
+	Convey("Given small amounts", t, func() {
+		d := &decimal.Decimal{}
+		d.SetString("0.5")
+		Convey("They should not be grouped", func() {
+			So(FormatAmount(d, "EUR", "en_US"), ShouldEqual, "€0.50")
+			So(FormatNumber(d, 0, "en_US"), ShouldEqual, "1")
+		})
+	})
+}
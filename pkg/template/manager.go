@@ -0,0 +1,210 @@
+package template
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TemplateExt is the extension of the template files loaded by a Manager
+const TemplateExt = ".tmpl"
+
+// LoadError is returned when templates could not be parsed
+type LoadError map[string]error
+
+func (e LoadError) Error() string {
+	files := make([]string, 0, len(e))
+	for f := range e {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	msgs := make([]string, len(files))
+	for i, f := range files {
+		msgs[i] = fmt.Sprintf("%s: %v", f, e[f])
+	}
+	return "broken templates: " + strings.Join(msgs, "; ")
+}
+
+type cachedTemplate struct {
+	t       *template.Template
+	modTime time.Time
+}
+
+// Manager loads the templates of a template directory
+//
+// Parsed templates are cached. The templates can be validated at once with
+// Load, which should be called on startup so broken templates are detected
+// early. Templates changed on disk can be reloaded with Load.
+type Manager struct {
+	dir           string
+	defaultLocale string
+	funcs         map[string]interface{}
+	skip          []string
+
+	mu        sync.RWMutex
+	templates map[string]cachedTemplate
+}
+
+// NewManager creates a new template manager for the given directory
+//
+// The funcs will be available in all templates. The functions "locale" and the
+// format functions (see FormatFuncs) will be added for each template.
+func NewManager(dir, defaultLocale string, funcs map[string]interface{}) *Manager {
+	return &Manager{
+		dir:           dir,
+		defaultLocale: defaultLocale,
+		funcs:         funcs,
+		templates:     make(map[string]cachedTemplate),
+	}
+}
+
+// Dir returns the template directory
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Skip excludes the given directory from loading, e.g. the template directory
+// of another manager nested in the template directory
+func (m *Manager) Skip(dir string) {
+	m.skip = append(m.skip, filepath.Clean(dir))
+}
+
+func (m *Manager) parseFuncs() template.FuncMap {
+	funcs := template.FuncMap(FormatFuncs(m.defaultLocale))
+	funcs["locale"] = func() string { return m.defaultLocale }
+	for name, f := range m.funcs {
+		funcs[name] = f
+	}
+	return funcs
+}
+
+func (m *Manager) parse(file string) (*template.Template, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(path.Base(file)).Funcs(m.parseFuncs()).Parse(string(b))
+}
+
+// walk calls fn for every template file in the template directory
+func (m *Manager) walk(fn func(file string, info os.FileInfo)) error {
+	return filepath.Walk(m.dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if file == m.dir {
+				return err
+			}
+			// unreadable subdirectories cannot contain templates
+			return nil
+		}
+		if info.IsDir() {
+			for _, skip := range m.skip {
+				if filepath.Clean(file) == skip {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if strings.HasSuffix(file, TemplateExt) {
+			fn(file, info)
+		}
+		return nil
+	})
+}
+
+// Load parses all templates of the template directory
+//
+// If any template cannot be parsed, a LoadError listing the broken templates
+// will be returned and the previously loaded templates will be kept.
+func (m *Manager) Load() error {
+	loaded := make(map[string]cachedTemplate)
+	broken := make(LoadError)
+	err := m.walk(func(file string, info os.FileInfo) {
+		t, err := m.parse(file)
+		if err != nil {
+			broken[file] = err
+			return
+		}
+		loaded[file] = cachedTemplate{t: t, modTime: info.ModTime()}
+	})
+	if err != nil {
+		return err
+	}
+	if len(broken) > 0 {
+		return broken
+	}
+	m.mu.Lock()
+	m.templates = loaded
+	m.mu.Unlock()
+	return nil
+}
+
+// Changed returns true if templates were added, changed or removed since they
+// were loaded
+func (m *Manager) Changed() (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var changed bool
+	var count int
+	err := m.walk(func(file string, info os.FileInfo) {
+		count++
+		c, ok := m.templates[file]
+		if !ok || !c.modTime.Equal(info.ModTime()) {
+			changed = true
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	return changed || count != len(m.templates), nil
+}
+
+// Template returns the template with the given base name for the locale
+//
+// See TemplateFileName on how the template file is determined.
+func (m *Manager) Template(locale, baseName string) (*template.Template, error) {
+	return m.TemplateIn("", locale, baseName)
+}
+
+// TemplateIn returns the template with the given base name for the locale
+// from a subdirectory of the template directory
+func (m *Manager) TemplateIn(subDir, locale, baseName string) (*template.Template, error) {
+	file, err := TemplateFileName(path.Join(m.dir, subDir), locale, m.defaultLocale, baseName)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	c, ok := m.templates[file]
+	m.mu.RUnlock()
+	if !ok {
+		// not loaded yet
+		var t *template.Template
+		t, err = m.parse(file)
+		if err != nil {
+			return nil, err
+		}
+		c = cachedTemplate{t: t}
+		m.mu.Lock()
+		m.templates[file] = c
+		m.mu.Unlock()
+	}
+	// cached templates are never executed, so they can be cloned
+	t, err := c.t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tmplLocale := path.Base(path.Dir(file))
+	t.Funcs(template.FuncMap{
+		"locale": func() string {
+			return tmplLocale
+		},
+	})
+	t.Funcs(template.FuncMap(FormatFuncs(locale)))
+	return t, nil
+}
@@ -0,0 +1,119 @@
+package template
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/fritzpay/paymentd/pkg/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeTemplate(dir, locale, baseName, content string) {
+	err := os.MkdirAll(path.Join(dir, locale), 0755)
+	So(err, ShouldBeNil)
+	err = ioutil.WriteFile(path.Join(dir, locale, baseName), []byte(content), 0644)
+	So(err, ShouldBeNil)
+}
+
+func TestManager(t *testing.T) {
+	Convey("Given a template directory", t, func() {
+		dir, err := ioutil.TempDir("", "paymentd_tmpl")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		writeTemplate(dir, "en_US", "page.html.tmpl", `{{locale}} {{greeting}} {{formatAmount .Amount "EUR"}}`)
+		writeTemplate(dir, "de_DE", "page.html.tmpl", `{{locale}} {{formatAmount .Amount "EUR"}}`)
+
+		Convey("Given a manager", func() {
+			m := NewManager(dir, "en_US", map[string]interface{}{
+				"greeting": func() string { return "hello" },
+			})
+
+			Convey("When loading the templates", func() {
+				err = m.Load()
+
+				Convey("It should succeed", func() {
+					So(err, ShouldBeNil)
+				})
+
+				Convey("When executing a template", func() {
+					d := &decimal.Decimal{}
+					d.SetString("1234.5")
+					data := map[string]interface{}{"Amount": d}
+					buf := &bytes.Buffer{}
+					t, err := m.Template("de-DE", "page.html.tmpl")
+					So(err, ShouldBeNil)
+					err = t.Execute(buf, data)
+					So(err, ShouldBeNil)
+
+					Convey("It should use the locale of the template", func() {
+						So(buf.String(), ShouldEqual, "de_DE 1.234,50"+nbsp+"€")
+					})
+
+					Convey("The template should be executable again", func() {
+						buf.Reset()
+						t, err := m.Template("fr_FR", "page.html.tmpl")
+						So(err, ShouldBeNil)
+						err = t.Execute(buf, data)
+						So(err, ShouldBeNil)
+						So(buf.String(), ShouldEqual, "en_US hello 1"+nbsp+"234,50"+nbsp+"€")
+					})
+				})
+
+				Convey("When a template is broken", func() {
+					writeTemplate(dir, "en_US", "broken.html.tmpl", `{{if}}`)
+
+					Convey("The change should be detected", func() {
+						changed, err := m.Changed()
+						So(err, ShouldBeNil)
+						So(changed, ShouldBeTrue)
+					})
+
+					Convey("Reloading should fail with the broken template", func() {
+						err = m.Load()
+						So(err, ShouldNotBeNil)
+						loadErr, ok := err.(LoadError)
+						So(ok, ShouldBeTrue)
+						So(len(loadErr), ShouldEqual, 1)
+						_, ok = loadErr[path.Join(dir, "en_US", "broken.html.tmpl")]
+						So(ok, ShouldBeTrue)
+					})
+				})
+
+				Convey("When a template is changed", func() {
+					writeTemplate(dir, "en_US", "page.html.tmpl", `changed`)
+					future := time.Now().Add(time.Minute)
+					os.Chtimes(path.Join(dir, "en_US", "page.html.tmpl"), future, future)
+
+					Convey("It should be served after reloading", func() {
+						changed, err := m.Changed()
+						So(err, ShouldBeNil)
+						So(changed, ShouldBeTrue)
+						err = m.Load()
+						So(err, ShouldBeNil)
+						t, err := m.Template("en_US", "page.html.tmpl")
+						So(err, ShouldBeNil)
+						buf := &bytes.Buffer{}
+						err = t.Execute(buf, nil)
+						So(err, ShouldBeNil)
+						So(buf.String(), ShouldEqual, "changed")
+					})
+				})
+			})
+
+			Convey("When skipping a nested directory with broken templates", func() {
+				writeTemplate(path.Join(dir, "provider"), "en_US", "broken.html.tmpl", `{{undefinedFunc}}`)
+				m.Skip(path.Join(dir, "provider"))
+
+				Convey("Loading should succeed", func() {
+					So(m.Load(), ShouldBeNil)
+				})
+			})
+		})
+	})
+}
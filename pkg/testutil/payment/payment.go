@@ -9,6 +9,7 @@ import (
 	"github.com/fritzpay/paymentd/pkg/paymentd/payment_method"
 	"github.com/fritzpay/paymentd/pkg/paymentd/project"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
 )
 
 func WithPaymentInTx(tx *sql.Tx, f func(p *payment.Payment)) func() {
@@ -17,12 +18,12 @@ func WithPaymentInTx(tx *sql.Tx, f func(p *payment.Payment)) func() {
 			ID:          1,
 			PrincipalID: 1,
 		}
-		method, err := payment_method.PaymentMethodByIDTx(tx, 1)
+		method, err := payment_method.PaymentMethodByIDTx(context.Background(), tx, 1)
 		So(err, ShouldBeNil)
 		So(method.Active(), ShouldBeTrue)
 		So(method.ID, ShouldNotEqual, 0)
 
-		err = payment_method.InsertPaymentMethodStatusTx(tx, method)
+		err = payment_method.InsertPaymentMethodStatusTx(context.Background(), tx, method)
 		So(err, ShouldBeNil)
 
 		p := &payment.Payment{
@@ -38,12 +39,12 @@ func WithPaymentInTx(tx *sql.Tx, f func(p *payment.Payment)) func() {
 		p.Config.SetLocale("en-US")
 		p.Config.SetPaymentMethodID(method.ID)
 
-		err = payment.InsertPaymentTx(tx, p)
+		err = payment.InsertPaymentTx(context.Background(), tx, p)
 		So(err, ShouldBeNil)
-		err = payment.InsertPaymentConfigTx(tx, p)
+		err = payment.InsertPaymentConfigTx(context.Background(), tx, p)
 		So(err, ShouldBeNil)
 
-		p, err = payment.PaymentByIDTx(tx, p.PaymentID())
+		p, err = payment.PaymentByIDTx(context.Background(), tx, p.PaymentID())
 		So(err, ShouldBeNil)
 
 		f(p)
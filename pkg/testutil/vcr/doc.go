@@ -0,0 +1,29 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package vcr records and replays HTTP interactions with payment providers
+
+Provider driver tests use a Recorder as the transport of their HTTP clients.
+By default the interactions are replayed from cassettes kept in the testdata
+directory of the driver, so driver logic can be tested against captured
+provider responses without network access.
+
+To re-record the cassettes against the provider sandboxes, run the tests with
+the environment var PAYMENTD_VCR=record and the sandbox credentials the driver
+tests expect.
+*/
+package vcr
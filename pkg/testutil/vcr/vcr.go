@@ -0,0 +1,258 @@
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// EnvVarVCR is the environment var, which selects the mode of the recorders
+//
+// If set to "record", the interactions will be performed against the
+// provider and saved to the cassettes. Otherwise they will be replayed.
+const EnvVarVCR = "PAYMENTD_VCR"
+
+// Mode is the mode of a recorder
+type Mode int
+
+const (
+	// ModeReplay replays the interactions of the cassette
+	ModeReplay Mode = iota
+	// ModeRecord performs the requests and records the interactions
+	ModeRecord
+)
+
+// ModeFromEnv returns the mode selected by the environment
+func ModeFromEnv() Mode {
+	if os.Getenv(EnvVarVCR) == "record" {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+var (
+	// ErrInteractionNotFound is returned when replaying a request which was
+	// not recorded
+	ErrInteractionNotFound = errors.New("vcr: interaction not found")
+)
+
+// Request is a recorded HTTP request
+//
+// Headers are not recorded, since they carry the credentials of the
+// provider account.
+type Request struct {
+	Method string
+	URL    string
+	Body   string `json:",omitempty"`
+}
+
+// Response is a recorded HTTP response
+type Response struct {
+	StatusCode int
+	Header     http.Header `json:",omitempty"`
+	Body       string      `json:",omitempty"`
+}
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Request  Request
+	Response Response
+}
+
+// Cassette holds the recorded interactions
+type Cassette struct {
+	Interactions []*Interaction
+}
+
+// Recorder is a http.RoundTripper, which records or replays the interactions
+// with a provider
+//
+// Cassettes are JSON files, usually kept in the testdata directory of the
+// package. Replayed requests are matched by method, URL and body against the
+// interactions in recorded order, so the same request can be replayed with
+// different responses.
+type Recorder struct {
+	path string
+	mode Mode
+	tr   http.RoundTripper
+
+	m          sync.Mutex
+	cassette   *Cassette
+	played     []bool
+	redactions []string
+}
+
+// New creates a recorder for the cassette at the given path
+//
+// In replay mode, the cassette must exist. In record mode, requests will be
+// performed with the given transport, or with the http.DefaultTransport if it
+// is nil. The cassette will be written on Stop.
+func New(path string, mode Mode, tr http.RoundTripper) (*Recorder, error) {
+	r := &Recorder{
+		path:     path,
+		mode:     mode,
+		tr:       tr,
+		cassette: &Cassette{},
+	}
+	if r.tr == nil {
+		r.tr = http.DefaultTransport
+	}
+	if mode == ModeRecord {
+		return r, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, r.cassette)
+	if err != nil {
+		return nil, fmt.Errorf("vcr: error decoding cassette %s: %v", path, err)
+	}
+	r.played = make([]bool, len(r.cassette.Interactions))
+	return r, nil
+}
+
+// Mode returns the mode of the recorder
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Redact replaces the secret with the placeholder in recorded interactions
+//
+// Tests should use the placeholders as credentials in replay mode, so that
+// the replayed requests match the recorded ones.
+func (r *Recorder) Redact(secret, placeholder string) {
+	if secret == "" {
+		return
+	}
+	r.m.Lock()
+	r.redactions = append(r.redactions, secret, placeholder)
+	r.m.Unlock()
+}
+
+// RoundTrip implements the http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.mode == ModeRecord {
+		return r.record(req, body)
+	}
+	return r.replay(req, body)
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for i, in := range r.cassette.Interactions {
+		if r.played[i] {
+			continue
+		}
+		if in.Request.Method != req.Method || in.Request.URL != req.URL.String() || in.Request.Body != string(body) {
+			continue
+		}
+		r.played[i] = true
+		return in.Response.httpResponse(req), nil
+	}
+	return nil, fmt.Errorf("%v: %s %s", ErrInteractionNotFound, req.Method, req.URL.String())
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := r.tr.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	in := &Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Body:   string(body),
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     make(http.Header),
+			Body:       string(respBody),
+		},
+	}
+	for k, v := range resp.Header {
+		if k == "Set-Cookie" {
+			continue
+		}
+		in.Response.Header[k] = v
+	}
+	r.m.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, in)
+	r.m.Unlock()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// Stop finishes the recording
+//
+// In record mode, the recorded interactions will be written to the cassette.
+func (r *Recorder) Stop() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+	r.m.Lock()
+	b, err := json.MarshalIndent(r.cassette, "", "\t")
+	redactions := r.redactions
+	r.m.Unlock()
+	if err != nil {
+		return err
+	}
+	s := string(b)
+	for i := 0; i < len(redactions); i += 2 {
+		s = strings.Replace(s, jsonString(redactions[i]), jsonString(redactions[i+1]), -1)
+	}
+	err = os.MkdirAll(filepath.Dir(r.path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, []byte(s+"\n"), 0644)
+}
+
+// jsonString returns the string as it appears in a JSON string value
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+func (resp Response) httpResponse(req *http.Request) *http.Response {
+	header := make(http.Header, len(resp.Header))
+	for k, v := range resp.Header {
+		header[k] = v
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+		StatusCode:    resp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,111 @@
+package vcr_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fritzpay/paymentd/pkg/testutil/vcr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecorder(t *testing.T) {
+	Convey("Given a provider", t, func() {
+		var calls int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("Set-Cookie", "session=secret")
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("created " + string(body)))
+		}))
+		Reset(srv.Close)
+
+		dir, err := ioutil.TempDir("", "vcr")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+		path := filepath.Join(dir, "cassette.json")
+
+		Convey("When recording an interaction", func() {
+			rec, err := vcr.New(path, vcr.ModeRecord, nil)
+			So(err, ShouldBeNil)
+			rec.Redact("s3cr3t", "SECRET")
+			cl := &http.Client{Transport: rec}
+			resp, err := cl.Post(srv.URL+"/charge", "text/plain", strings.NewReader("key=s3cr3t"))
+			So(err, ShouldBeNil)
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			Convey("The response should be passed through", func() {
+				So(err, ShouldBeNil)
+				So(calls, ShouldEqual, 1)
+				So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+				So(string(body), ShouldEqual, "created key=s3cr3t")
+			})
+
+			Convey("When the recording is stopped", func() {
+				So(rec.Stop(), ShouldBeNil)
+				b, err := ioutil.ReadFile(path)
+				So(err, ShouldBeNil)
+
+				Convey("The cassette should not contain secrets", func() {
+					So(string(b), ShouldNotContainSubstring, "s3cr3t")
+					So(string(b), ShouldContainSubstring, "key=SECRET")
+					So(string(b), ShouldNotContainSubstring, "Set-Cookie")
+				})
+
+				Convey("When replaying the redacted request", func() {
+					replay, err := vcr.New(path, vcr.ModeReplay, nil)
+					So(err, ShouldBeNil)
+					cl := &http.Client{Transport: replay}
+					resp, err := cl.Post(srv.URL+"/charge", "text/plain", strings.NewReader("key=SECRET"))
+					So(err, ShouldBeNil)
+					body, err := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+
+					Convey("The recorded response should be returned without calling the provider", func() {
+						So(err, ShouldBeNil)
+						So(calls, ShouldEqual, 1)
+						So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+						So(resp.Header.Get("Content-Type"), ShouldEqual, "text/plain")
+						So(string(body), ShouldEqual, "created key=SECRET")
+					})
+
+					Convey("The interaction should only be replayed once", func() {
+						_, err := cl.Post(srv.URL+"/charge", "text/plain", strings.NewReader("key=SECRET"))
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldContainSubstring, vcr.ErrInteractionNotFound.Error())
+					})
+				})
+
+				Convey("When replaying another request", func() {
+					replay, err := vcr.New(path, vcr.ModeReplay, nil)
+					So(err, ShouldBeNil)
+					cl := &http.Client{Transport: replay}
+					_, err = cl.Post(srv.URL+"/refund", "text/plain", strings.NewReader("key=SECRET"))
+
+					Convey("It should fail", func() {
+						So(err, ShouldNotBeNil)
+						So(err.Error(), ShouldContainSubstring, vcr.ErrInteractionNotFound.Error())
+						So(calls, ShouldEqual, 1)
+					})
+				})
+			})
+		})
+
+		Convey("When replaying a missing cassette", func() {
+			_, err := vcr.New(path, vcr.ModeReplay, nil)
+
+			Convey("It should fail", func() {
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+	})
+}
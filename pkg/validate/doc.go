@@ -0,0 +1,33 @@
+/*
+   Copyright 2014 Fritz Payment GmbH
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+Package validate provides declarative validation of API requests
+
+Requests declare their validation as a list of rules, one for each field. Each
+rule consists of checks, which are evaluated in order. The first failing check
+of a rule is reported as a *FieldError addressing the field with an error code,
+e.g.
+
+	return validate.Validate(
+		validate.Field("Ident", validate.Required(r.Ident), validate.MaxLen(r.Ident, 64)),
+		validate.Field("Currency", validate.Required(r.Currency), validate.Len(r.Currency, 3)),
+	)
+
+The messages of the errors can be localized for the Accept-Language of a
+request.
+*/
+package validate
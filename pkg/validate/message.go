@@ -0,0 +1,59 @@
+package validate
+
+import (
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// messages are the message formats of the validation error codes by language
+//
+// The field name replaces all occurrences of {field}.
+var messages = map[language.Tag]map[string]string{
+	language.English: {
+		CodeMissing: "missing {field}",
+		CodeInvalid: "invalid {field}",
+		CodeFormat:  "invalid {field} format",
+		CodePast:    "invalid {field}. {field} is in the past",
+	},
+	language.German: {
+		CodeMissing: "{field} fehlt",
+		CodeInvalid: "{field} ist ungültig",
+		CodeFormat:  "{field} hat ein ungültiges Format",
+		CodePast:    "{field} ist ungültig. {field} liegt in der Vergangenheit",
+	},
+}
+
+// supported message languages, the first language is the fallback
+var supported = []language.Tag{
+	language.English,
+	language.German,
+}
+
+var matcher = language.NewMatcher(supported)
+
+// Message returns the message of the error in the given language
+//
+// Messages of unsupported languages are returned in english.
+func (e *FieldError) Message(tag language.Tag) string {
+	msgs, ok := messages[tag]
+	if !ok {
+		msgs = messages[language.English]
+	}
+	format, ok := msgs[e.Code]
+	if !ok {
+		format = messages[language.English][CodeInvalid]
+	}
+	return strings.Replace(format, "{field}", e.Field, -1)
+}
+
+// Language returns the supported message language best matching the given
+// Accept-Language header value
+func Language(acceptLanguage string) language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return language.English
+	}
+	_, i, _ := matcher.Match(tags...)
+	return supported[i]
+}
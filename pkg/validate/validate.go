@@ -0,0 +1,140 @@
+package validate
+
+import (
+	"encoding/hex"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+// Validation error codes
+const (
+	// CodeMissing is reported for required fields without a value
+	CodeMissing = "missing"
+	// CodeInvalid is reported for values which are not allowed
+	CodeInvalid = "invalid"
+	// CodeFormat is reported for values which cannot be decoded
+	CodeFormat = "format"
+	// CodePast is reported for points in time which must be in the future
+	CodePast = "past"
+)
+
+// FieldError is a validation error of a request field
+type FieldError struct {
+	Field string
+	// Code is one of the validation error codes
+	Code string
+}
+
+// Error returns the english message of the error
+func (e *FieldError) Error() string {
+	return e.Message(language.English)
+}
+
+// Errors are the validation errors of a request in the order of the rules
+type Errors []*FieldError
+
+// Error returns the message of the first error
+func (e Errors) Error() string {
+	if len(e) == 0 {
+		return "no validation errors"
+	}
+	return e[0].Error()
+}
+
+// Check is the result of a validation check
+//
+// It is the validation error code or empty if the check passed.
+type Check string
+
+// Rule is the validation rule of a field
+type Rule struct {
+	Field  string
+	Checks []Check
+}
+
+// Field returns the rule for the field with the given checks
+func Field(name string, checks ...Check) Rule {
+	return Rule{Field: name, Checks: checks}
+}
+
+// Validate validates the rules
+//
+// It returns Errors with the first failing check of each rule or nil if all
+// checks passed.
+func Validate(rules ...Rule) error {
+	var errs Errors
+	for _, r := range rules {
+		for _, c := range r.Checks {
+			if c != "" {
+				errs = append(errs, &FieldError{Field: r.Field, Code: string(c)})
+				break
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Assert fails with the given code if ok is false
+func Assert(ok bool, code string) Check {
+	if ok {
+		return ""
+	}
+	return Check(code)
+}
+
+// Required fails if the value is empty
+func Required(v string) Check {
+	return Assert(v != "", CodeMissing)
+}
+
+// Set fails if the value was not set, e.g. for required JSON members
+func Set(set bool) Check {
+	return Assert(set, CodeMissing)
+}
+
+// Len fails if the value is not empty and has not exactly n bytes
+func Len(v string, n int) Check {
+	return Assert(v == "" || len(v) == n, CodeInvalid)
+}
+
+// MaxLen fails if the value has more than n characters
+func MaxLen(v string, n int) Check {
+	return Assert(utf8.RuneCountInString(v) <= n, CodeInvalid)
+}
+
+// Min fails if the value is less than min
+func Min(v, min int64) Check {
+	return Assert(v >= min, CodeInvalid)
+}
+
+// Hex fails if the value is not hex encoded
+func Hex(v string) Check {
+	_, err := hex.DecodeString(v)
+	return Assert(err == nil, CodeFormat)
+}
+
+// Locale fails if the value is not empty and not a valid BCP 47 language tag
+func Locale(v string) Check {
+	if v == "" {
+		return ""
+	}
+	_, err := language.Parse(v)
+	return Assert(err == nil, CodeInvalid)
+}
+
+// URL fails if the value is not empty and cannot be parsed as an URL
+func URL(v string) Check {
+	_, err := url.Parse(v)
+	return Assert(err == nil, CodeInvalid)
+}
+
+// Future fails if the unix timestamp is set and not in the future
+func Future(unix int64) Check {
+	return Assert(unix == 0 || time.Unix(unix, 0).After(time.Now()), CodePast)
+}
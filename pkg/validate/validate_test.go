@@ -0,0 +1,86 @@
+package validate
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/text/language"
+)
+
+func TestValidate(t *testing.T) {
+	Convey("Given validation rules", t, func() {
+		var ident, currency, signature string
+		var expires int64
+
+		validateRules := func() error {
+			return Validate(
+				Field("Ident", Required(ident), MaxLen(ident, 5)),
+				Field("Currency", Required(currency), Len(currency, 3)),
+				Field("Signature", Hex(signature)),
+				Field("Expires", Future(expires)),
+			)
+		}
+
+		Convey("When all fields are valid", func() {
+			ident, currency, signature = "order", "EUR", "abcd"
+
+			Convey("It should pass", func() {
+				So(validateRules(), ShouldBeNil)
+			})
+		})
+		Convey("When fields are invalid", func() {
+			ident, currency, signature = "", "EURO", "xyz"
+			expires = time.Now().Add(-time.Hour).Unix()
+
+			err := validateRules()
+
+			Convey("It should report every field in the order of the rules", func() {
+				errs, ok := err.(Errors)
+				So(ok, ShouldBeTrue)
+				So(len(errs), ShouldEqual, 4)
+				So(*errs[0], ShouldResemble, FieldError{Field: "Ident", Code: CodeMissing})
+				So(*errs[1], ShouldResemble, FieldError{Field: "Currency", Code: CodeInvalid})
+				So(*errs[2], ShouldResemble, FieldError{Field: "Signature", Code: CodeFormat})
+				So(*errs[3], ShouldResemble, FieldError{Field: "Expires", Code: CodePast})
+			})
+			Convey("It should describe the first error", func() {
+				So(err.Error(), ShouldEqual, "missing Ident")
+			})
+		})
+		Convey("When an identifier is too long", func() {
+			ident, currency = "order-1", "EUR"
+
+			Convey("It should report the first failing check only", func() {
+				errs := validateRules().(Errors)
+				So(len(errs), ShouldEqual, 1)
+				So(errs[0].Code, ShouldEqual, CodeInvalid)
+			})
+		})
+	})
+}
+
+func TestMessage(t *testing.T) {
+	Convey("Given a field error", t, func() {
+		err := &FieldError{Field: "Expires", Code: CodePast}
+
+		Convey("It should have an english message", func() {
+			So(err.Message(language.English), ShouldEqual, "invalid Expires. Expires is in the past")
+		})
+		Convey("It should have a german message", func() {
+			So(err.Message(language.German), ShouldEqual, "Expires ist ungültig. Expires liegt in der Vergangenheit")
+		})
+		Convey("It should fall back to english for unsupported languages", func() {
+			So(err.Message(language.French), ShouldEqual, err.Error())
+		})
+	})
+	Convey("Given Accept-Language headers", t, func() {
+		Convey("It should match german", func() {
+			So(Language("de-DE,de;q=0.8,en-US;q=0.6,en;q=0.4").String(), ShouldEqual, language.German.String())
+		})
+		Convey("It should fall back to english", func() {
+			So(Language("fr-FR").String(), ShouldEqual, language.English.String())
+			So(Language("").String(), ShouldEqual, language.English.String())
+		})
+	})
+}
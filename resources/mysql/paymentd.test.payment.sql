@@ -125,6 +125,8 @@ CREATE TABLE IF NOT EXISTS `payment` (
   `currency` VARCHAR(3) NOT NULL,
   PRIMARY KEY (`id`),
   INDEX `created` (`created` ASC),
+  INDEX `project_created` (`project_id` ASC, `created` ASC),
+  INDEX `project_currency_created` (`project_id` ASC, `currency` ASC, `created` ASC),
   UNIQUE INDEX `ident` (`project_id` ASC, `ident` ASC),
   INDEX `fk_payment_currency_idx` (`currency` ASC),
   UNIQUE INDEX `payment_id` (`project_id` ASC, `id` ASC),
@@ -153,6 +155,7 @@ CREATE TABLE IF NOT EXISTS `payment_config` (
   `callback_project_key` VARCHAR(64) NULL,
   `return_url` TEXT NULL,
   `expires` DATETIME NULL,
+  `statement_descriptor` VARCHAR(22) NULL,
   PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
   INDEX `fk_payment_config_payment_method_id_idx` (`payment_method_id` ASC),
   INDEX `fk_payment_config_payment_id_idx` (`payment_id` ASC),
@@ -183,6 +186,7 @@ CREATE TABLE IF NOT EXISTS `payment_metadata` (
   PRIMARY KEY (`project_id`, `payment_id`, `name`, `timestamp`),
   INDEX `fk_payment_metadata_payment_id_idx` (`payment_id` ASC),
   INDEX `timestamp` (`project_id` ASC, `payment_id` ASC, `timestamp` ASC),
+  FULLTEXT INDEX `value_fulltext` (`value`),
   CONSTRAINT `fk_payment_metadata_payment_id`
     FOREIGN KEY (`payment_id`)
     REFERENCES `payment` (`id`)
@@ -227,10 +231,12 @@ CREATE TABLE IF NOT EXISTS `payment_transaction` (
   `currency` VARCHAR(3) NOT NULL,
   `status` VARCHAR(32) NOT NULL,
   `comment` TEXT NULL,
+  `authentication` VARCHAR(16) NULL,
   PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
   INDEX `status` (`status` ASC),
   INDEX `fk_payment_transaction_currency_idx` (`currency` ASC),
   INDEX `fk_payment_transaction_payment_id_idx` (`payment_id` ASC),
+  INDEX `timestamp` (`timestamp` ASC),
   CONSTRAINT `fk_payment_transaction_payment_id`
     FOREIGN KEY (`payment_id`)
     REFERENCES `payment` (`id`)
@@ -244,6 +250,31 @@ CREATE TABLE IF NOT EXISTS `payment_transaction` (
 ENGINE = InnoDB;
 
 
+-- -----------------------------------------------------
+-- Table `payment_transaction_current`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_transaction_current` ;
+
+CREATE TABLE IF NOT EXISTS `payment_transaction_current` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `amount` INT NOT NULL,
+  `subunits` TINYINT(4) UNSIGNED NOT NULL,
+  `currency` VARCHAR(3) NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `comment` TEXT NULL,
+  `authentication` VARCHAR(16) NULL,
+  PRIMARY KEY (`project_id`, `payment_id`),
+  INDEX `status` (`status` ASC),
+  CONSTRAINT `fk_payment_transaction_current_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
 -- -----------------------------------------------------
 -- Table `provider_fritzpay_payment`
 -- -----------------------------------------------------
@@ -350,6 +381,898 @@ CREATE TABLE IF NOT EXISTS `provider_paypal_authorization` (
     ON UPDATE CASCADE)
 ENGINE = InnoDB;
 
+-- -----------------------------------------------------
+-- Table `payment_change`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_change` ;
+
+CREATE TABLE IF NOT EXISTS `payment_change` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `type` VARCHAR(64) NOT NULL,
+  `data` TEXT NULL,
+  `sequence` BIGINT UNSIGNED NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `sequence_UNIQUE` (`sequence` ASC),
+  INDEX `project_sequence` (`project_id` ASC, `sequence` ASC),
+  INDEX `fk_payment_change_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_payment_change_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `feature_flag`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `feature_flag` ;
+
+CREATE TABLE IF NOT EXISTS `feature_flag` (
+  `name` VARCHAR(64) NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `enabled` TINYINT(1) NOT NULL,
+  `percentage` TINYINT UNSIGNED NOT NULL,
+  `projects` TEXT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`name`, `timestamp`))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `incoming_funds`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `incoming_funds` ;
+
+CREATE TABLE IF NOT EXISTS `incoming_funds` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `source` VARCHAR(64) NOT NULL,
+  `reference` TEXT NOT NULL,
+  `sender` VARCHAR(255) NULL,
+  `amount` INT NOT NULL,
+  `subunits` TINYINT(4) UNSIGNED NOT NULL,
+  `currency` VARCHAR(3) NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_incoming_funds_currency_idx` (`currency` ASC),
+  CONSTRAINT `fk_incoming_funds_currency`
+    FOREIGN KEY (`currency`)
+    REFERENCES `currency` (`code_iso_4217`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `incoming_funds_status`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `incoming_funds_status` ;
+
+CREATE TABLE IF NOT EXISTS `incoming_funds_status` (
+  `incoming_funds_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `project_id` INT UNSIGNED NULL,
+  `payment_id` BIGINT UNSIGNED NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `comment` TEXT NULL,
+  PRIMARY KEY (`incoming_funds_id`, `timestamp`),
+  INDEX `status` (`status` ASC),
+  INDEX `payment_id` (`payment_id` ASC),
+  CONSTRAINT `fk_incoming_funds_status_incoming_funds_id`
+    FOREIGN KEY (`incoming_funds_id`)
+    REFERENCES `incoming_funds` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_relation`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_relation` ;
+
+CREATE TABLE IF NOT EXISTS `payment_relation` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `parent_id` BIGINT UNSIGNED NOT NULL,
+  `relation` VARCHAR(32) NOT NULL,
+  `created` DATETIME NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`),
+  INDEX `parent` (`project_id` ASC, `parent_id` ASC),
+  INDEX `fk_payment_relation_payment_id_idx` (`payment_id` ASC),
+  INDEX `fk_payment_relation_parent_id_idx` (`parent_id` ASC),
+  CONSTRAINT `fk_payment_relation_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE,
+  CONSTRAINT `fk_payment_relation_parent_id`
+    FOREIGN KEY (`parent_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_authorization`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_authorization` ;
+
+CREATE TABLE IF NOT EXISTS `payment_authorization` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `amount` BIGINT NOT NULL,
+  `subunits` TINYINT UNSIGNED NOT NULL,
+  `currency` CHAR(3) NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `fk_payment_authorization_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_payment_authorization_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_review`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_review` ;
+
+CREATE TABLE IF NOT EXISTS `payment_review` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `due` BIGINT UNSIGNED NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `comment` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `fk_payment_review_payment_id_idx` (`payment_id` ASC),
+  INDEX `status` (`project_id` ASC, `status` ASC),
+  CONSTRAINT `fk_payment_review_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `job_run`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `job_run` ;
+
+CREATE TABLE IF NOT EXISTS `job_run` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `job` VARCHAR(64) NOT NULL,
+  `attempt` INT UNSIGNED NOT NULL,
+  `trigger_type` VARCHAR(16) NOT NULL,
+  `host` VARCHAR(255) NOT NULL,
+  `started` BIGINT UNSIGNED NOT NULL,
+  `finished` BIGINT UNSIGNED NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `error` TEXT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `job` (`job` ASC, `id` ASC),
+  INDEX `status` (`status` ASC, `host` ASC))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_batch`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_batch` ;
+
+CREATE TABLE IF NOT EXISTS `payment_batch` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `intent` VARCHAR(32) NOT NULL,
+  `comment` TEXT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `total` INT UNSIGNED NOT NULL,
+  `finished` BIGINT UNSIGNED NULL,
+  PRIMARY KEY (`id`),
+  INDEX `finished` (`finished` ASC, `id` ASC))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_batch_payment`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_batch_payment` ;
+
+CREATE TABLE IF NOT EXISTS `payment_batch_payment` (
+  `batch_id` BIGINT UNSIGNED NOT NULL,
+  `seq` INT UNSIGNED NOT NULL,
+  `project_id` BIGINT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `processed` BIGINT UNSIGNED NULL,
+  `status` VARCHAR(32) NULL,
+  `error` TEXT NULL,
+  PRIMARY KEY (`batch_id`, `seq`),
+  CONSTRAINT `fk_payment_batch_payment_batch_id`
+    FOREIGN KEY (`batch_id`)
+    REFERENCES `payment_batch` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE RESTRICT)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_event`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_event` ;
+
+CREATE TABLE IF NOT EXISTS `payment_event` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `amount` INT NOT NULL,
+  `subunits` TINYINT(4) UNSIGNED NOT NULL,
+  `currency` VARCHAR(3) NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `comment` TEXT NULL,
+  `prev_hash` CHAR(64) NOT NULL,
+  `hash` CHAR(64) NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `project_id` (`project_id` ASC, `id` ASC),
+  INDEX `payment` (`project_id` ASC, `payment_id` ASC, `timestamp` ASC),
+  INDEX `timestamp` (`timestamp` ASC))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `checkout_session`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `checkout_session` ;
+
+CREATE TABLE IF NOT EXISTS `checkout_session` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `project_id` INT UNSIGNED NOT NULL,
+  `token` VARCHAR(64) NOT NULL,
+  `created` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `token_UNIQUE` (`token` ASC),
+  INDEX `project_id` (`project_id` ASC))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `checkout_session_data`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `checkout_session_data` ;
+
+CREATE TABLE IF NOT EXISTS `checkout_session_data` (
+  `checkout_session_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `expires` BIGINT UNSIGNED NOT NULL,
+  `cart_reference` VARCHAR(175) NULL,
+  `amount` INT NOT NULL,
+  `subunits` TINYINT(4) UNSIGNED NOT NULL,
+  `currency` VARCHAR(3) NOT NULL,
+  `country` VARCHAR(2) NOT NULL,
+  `locale` VARCHAR(5) NULL,
+  `customer` TEXT NULL,
+  `metadata` TEXT NULL,
+  PRIMARY KEY (`checkout_session_id`, `timestamp`),
+  CONSTRAINT `fk_checkout_session_data_checkout_session_id`
+    FOREIGN KEY (`checkout_session_id`)
+    REFERENCES `checkout_session` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `checkout_session_payment`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `checkout_session_payment` ;
+
+CREATE TABLE IF NOT EXISTS `checkout_session_payment` (
+  `checkout_session_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`checkout_session_id`),
+  INDEX `payment` (`project_id` ASC, `payment_id` ASC),
+  CONSTRAINT `fk_checkout_session_payment_checkout_session_id`
+    FOREIGN KEY (`checkout_session_id`)
+    REFERENCES `checkout_session` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_method_display`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_method_display` ;
+
+CREATE TABLE IF NOT EXISTS `payment_method_display` (
+  `provider` VARCHAR(64) NOT NULL,
+  `method_key` VARCHAR(64) NOT NULL,
+  `locale` VARCHAR(35) NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `name` VARCHAR(128) NOT NULL,
+  `description` TEXT NULL,
+  PRIMARY KEY (`provider`, `method_key`, `locale`, `timestamp`),
+  CONSTRAINT `fk_payment_method_display_provider`
+    FOREIGN KEY (`provider`)
+    REFERENCES `provider` (`name`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_method_logo`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_method_logo` ;
+
+CREATE TABLE IF NOT EXISTS `payment_method_logo` (
+  `provider` VARCHAR(64) NOT NULL,
+  `method_key` VARCHAR(64) NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `content_type` VARCHAR(64) NOT NULL,
+  `data` MEDIUMBLOB NOT NULL,
+  PRIMARY KEY (`provider`, `method_key`, `timestamp`),
+  CONSTRAINT `fk_payment_method_logo_provider`
+    FOREIGN KEY (`provider`)
+    REFERENCES `provider` (`name`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `api_usage`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `api_usage` ;
+
+CREATE TABLE IF NOT EXISTS `api_usage` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `endpoint` VARCHAR(128) NOT NULL,
+  `hour` BIGINT UNSIGNED NOT NULL,
+  `host` VARCHAR(255) NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `requests` BIGINT UNSIGNED NOT NULL,
+  `client_errors` BIGINT UNSIGNED NOT NULL,
+  `server_errors` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`project_id`, `endpoint`, `hour`, `host`, `timestamp`),
+  INDEX `hour` (`hour` ASC))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `api_usage_month`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `api_usage_month` ;
+
+CREATE TABLE IF NOT EXISTS `api_usage_month` (
+  `month` BIGINT UNSIGNED NOT NULL,
+  `project_id` INT UNSIGNED NOT NULL,
+  `endpoint` VARCHAR(128) NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `requests` BIGINT UNSIGNED NOT NULL,
+  `client_errors` BIGINT UNSIGNED NOT NULL,
+  `server_errors` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`month`, `project_id`, `endpoint`))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `bin_dataset`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `bin_dataset` ;
+
+CREATE TABLE IF NOT EXISTS `bin_dataset` (
+  `created` BIGINT UNSIGNED NOT NULL,
+  `checksum` CHAR(64) NOT NULL,
+  `source` VARCHAR(255) NOT NULL,
+  `ranges` INT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`created`))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `bin_range`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `bin_range` ;
+
+CREATE TABLE IF NOT EXISTS `bin_range` (
+  `dataset` BIGINT UNSIGNED NOT NULL,
+  `prefix` VARCHAR(8) NOT NULL,
+  `country` CHAR(2) NOT NULL,
+  `brand` VARCHAR(32) NOT NULL,
+  `debit` TINYINT(1) NOT NULL,
+  `prepaid` TINYINT(1) NOT NULL,
+  PRIMARY KEY (`dataset`, `prefix`))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_card`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_card` ;
+
+CREATE TABLE IF NOT EXISTS `payment_card` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `bin` VARCHAR(8) NOT NULL,
+  `country` VARCHAR(2) NOT NULL,
+  `brand` VARCHAR(32) NOT NULL,
+  `debit` TINYINT(1) NOT NULL,
+  `prepaid` TINYINT(1) NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `fk_payment_card_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_payment_card_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `fee_schedule`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `fee_schedule` ;
+
+CREATE TABLE IF NOT EXISTS `fee_schedule` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `provider` VARCHAR(64) NOT NULL,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `rules` TEXT NOT NULL,
+  PRIMARY KEY (`project_id`, `provider`, `created`))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_cost`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_cost` ;
+
+CREATE TABLE IF NOT EXISTS `payment_cost` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `type` VARCHAR(16) NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `provider` VARCHAR(64) NOT NULL,
+  `brand` VARCHAR(32) NOT NULL,
+  `amount` BIGINT NOT NULL,
+  `subunits` TINYINT UNSIGNED NOT NULL,
+  `currency` CHAR(3) NOT NULL,
+  `source` VARCHAR(255) NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `type`, `timestamp`),
+  INDEX `payment_cost_timestamp_idx` (`project_id` ASC, `type` ASC, `timestamp` ASC),
+  INDEX `fk_payment_cost_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_payment_cost_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_note`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_note` ;
+
+CREATE TABLE IF NOT EXISTS `payment_note` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `note` TEXT NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `fk_payment_note_payment_id_idx` (`payment_id` ASC),
+  FULLTEXT INDEX `note_fulltext` (`note`),
+  CONSTRAINT `fk_payment_note_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_risk`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_risk` ;
+
+CREATE TABLE IF NOT EXISTS `payment_risk` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `score` TINYINT UNSIGNED NOT NULL,
+  `source` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `fk_payment_risk_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_payment_risk_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_billing`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_billing` ;
+
+CREATE TABLE IF NOT EXISTS `payment_billing` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `vat_id` VARCHAR(16) NULL,
+  `cpf` CHAR(11) NULL,
+  `street` VARCHAR(255) NULL,
+  `postal_code` VARCHAR(16) NULL,
+  `city` VARCHAR(128) NULL,
+  `country` CHAR(2) NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `fk_payment_billing_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_payment_billing_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `provider_webhook_dead_letter`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `provider_webhook_dead_letter` ;
+
+CREATE TABLE IF NOT EXISTS `provider_webhook_dead_letter` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `received` BIGINT UNSIGNED NOT NULL,
+  `provider` VARCHAR(64) NOT NULL,
+  `handler` VARCHAR(64) NOT NULL,
+  `reason` VARCHAR(255) NOT NULL,
+  `method` VARCHAR(16) NOT NULL,
+  `url` TEXT NOT NULL,
+  `header` TEXT NOT NULL,
+  `remote_addr` VARCHAR(64) NOT NULL,
+  `body` MEDIUMBLOB NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `provider` (`provider` ASC))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `provider_webhook_dead_letter_status`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `provider_webhook_dead_letter_status` ;
+
+CREATE TABLE IF NOT EXISTS `provider_webhook_dead_letter_status` (
+  `dead_letter_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `comment` TEXT NULL,
+  PRIMARY KEY (`dead_letter_id`, `timestamp`),
+  INDEX `status` (`status` ASC),
+  CONSTRAINT `fk_provider_webhook_dead_letter_status_dead_letter_id`
+    FOREIGN KEY (`dead_letter_id`)
+    REFERENCES `provider_webhook_dead_letter` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_chargeback`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_chargeback` ;
+
+CREATE TABLE IF NOT EXISTS `payment_chargeback` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `dispute_id` VARCHAR(64) NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `amount` INT NOT NULL,
+  `reason` TEXT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `dispute_id`, `timestamp`),
+  UNIQUE INDEX `dispute_status` (`project_id` ASC, `payment_id` ASC, `dispute_id` ASC, `status` ASC),
+  INDEX `fk_payment_chargeback_payment_id_idx` (`payment_id` ASC),
+  INDEX `status` (`project_id` ASC, `status` ASC),
+  CONSTRAINT `fk_payment_chargeback_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_overview`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_overview` ;
+
+CREATE TABLE IF NOT EXISTS `payment_overview` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `ident` VARCHAR(175) NOT NULL,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `amount` INT NOT NULL,
+  `subunits` TINYINT(4) UNSIGNED NOT NULL,
+  `currency` VARCHAR(3) NOT NULL,
+  `status` VARCHAR(32) NULL,
+  `status_timestamp` BIGINT UNSIGNED NOT NULL,
+  `balance` BIGINT NOT NULL,
+  `held` BIGINT NOT NULL DEFAULT 0,
+  `payment_method_id` BIGINT UNSIGNED NULL,
+  `provider` VARCHAR(64) NULL,
+  `country` CHAR(2) NULL,
+  `sequence` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`),
+  INDEX `project_status` (`project_id` ASC, `status` ASC, `payment_id` ASC),
+  INDEX `project_created` (`project_id` ASC, `created` ASC),
+  INDEX `sequence` (`sequence` ASC))
+ENGINE = InnoDB;
+-- -----------------------------------------------------
+-- Table `payment_intent_rejection`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_intent_rejection` ;
+
+CREATE TABLE IF NOT EXISTS `payment_intent_rejection` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `intent` VARCHAR(32) NOT NULL,
+  `status` VARCHAR(32) NULL,
+  `reason` VARCHAR(255) NOT NULL,
+  `created_by` VARCHAR(255) NOT NULL,
+  INDEX `payment` (`project_id` ASC, `payment_id` ASC, `timestamp` ASC),
+  INDEX `fk_payment_intent_rejection_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_payment_intent_rejection_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+-- -----------------------------------------------------
+-- Table `provider_paypal_event`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `provider_paypal_event` ;
+
+CREATE TABLE IF NOT EXISTS `provider_paypal_event` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `event_id` VARCHAR(128) NOT NULL,
+  `event_type` VARCHAR(64) NOT NULL,
+  `resource_id` VARCHAR(128) NOT NULL,
+  `data` TEXT NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  UNIQUE INDEX `event_id_UNIQUE` (`event_id` ASC),
+  INDEX `fk_provider_paypal_event_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_provider_paypal_event_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+-- -----------------------------------------------------
+-- Table `provider_stripe_transaction`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `provider_stripe_transaction` ;
+
+CREATE TABLE IF NOT EXISTS `provider_stripe_transaction` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `charge_id` VARCHAR(128) NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  UNIQUE INDEX `charge_id_UNIQUE` (`charge_id` ASC),
+  INDEX `fk_provider_stripe_transaction_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_provider_stripe_transaction_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+-- -----------------------------------------------------
+-- Table `payment_reference_sequence`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_reference_sequence` ;
+
+CREATE TABLE IF NOT EXISTS `payment_reference_sequence` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `sequence` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`project_id`))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_reference`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_reference` ;
+
+CREATE TABLE IF NOT EXISTS `payment_reference` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `reference` VARCHAR(32) NOT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`),
+  UNIQUE INDEX `project_reference_UNIQUE` (`project_id` ASC, `reference` ASC),
+  INDEX `reference_idx` (`reference` ASC),
+  INDEX `fk_payment_reference_payment_id_idx` (`payment_id` ASC),
+  CONSTRAINT `fk_payment_reference_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_escrow`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_escrow` ;
+
+CREATE TABLE IF NOT EXISTS `payment_escrow` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `payment_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `amount` BIGINT NOT NULL,
+  `release_after` BIGINT UNSIGNED NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `comment` TEXT NULL,
+  PRIMARY KEY (`project_id`, `payment_id`, `timestamp`),
+  INDEX `fk_payment_escrow_payment_id_idx` (`payment_id` ASC),
+  INDEX `status_release_after` (`status` ASC, `release_after` ASC),
+  CONSTRAINT `fk_payment_escrow_payment_id`
+    FOREIGN KEY (`payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `payment_method_maintenance`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `payment_method_maintenance` ;
+
+CREATE TABLE IF NOT EXISTS `payment_method_maintenance` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `payment_method_id` BIGINT UNSIGNED NOT NULL,
+  `starts` BIGINT UNSIGNED NOT NULL,
+  `ends` BIGINT UNSIGNED NOT NULL,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `reason` VARCHAR(255) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `payment_method_ends` (`payment_method_id` ASC, `ends` ASC),
+  CONSTRAINT `fk_payment_method_maintenance_payment_method_id`
+    FOREIGN KEY (`payment_method_id`)
+    REFERENCES `payment_method` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `notification_outbox`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `notification_outbox` ;
+
+CREATE TABLE IF NOT EXISTS `notification_outbox` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `project_id` INT UNSIGNED NOT NULL,
+  `event` VARCHAR(64) NOT NULL,
+  `payment_id` BIGINT UNSIGNED NULL,
+  `transaction_timestamp` BIGINT UNSIGNED NULL,
+  `data` TEXT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `project_id` (`project_id` ASC),
+  INDEX `payment_transaction` (`project_id` ASC, `payment_id` ASC, `transaction_timestamp` ASC))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `notification_outbox_status`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `notification_outbox_status` ;
+
+CREATE TABLE IF NOT EXISTS `notification_outbox_status` (
+  `notification_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `attempts` INT UNSIGNED NOT NULL,
+  `next_attempt` BIGINT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `error` VARCHAR(255) NULL,
+  PRIMARY KEY (`notification_id`, `timestamp`),
+  INDEX `status` (`status` ASC, `next_attempt` ASC),
+  CONSTRAINT `fk_notification_outbox_status_notification_id`
+    FOREIGN KEY (`notification_id`)
+    REFERENCES `notification_outbox` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `subscription`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `subscription` ;
+
+CREATE TABLE IF NOT EXISTS `subscription` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `project_id` INT UNSIGNED NOT NULL,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `initial_payment_id` BIGINT UNSIGNED NOT NULL,
+  `plan_name` VARCHAR(64) NOT NULL,
+  `amount` INT NOT NULL,
+  `subunits` TINYINT(4) UNSIGNED NOT NULL,
+  `currency` VARCHAR(3) NOT NULL,
+  `interval_unit` VARCHAR(8) NOT NULL,
+  `interval_count` SMALLINT UNSIGNED NOT NULL,
+  `start` BIGINT UNSIGNED NOT NULL,
+  `email` VARCHAR(254) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `project_id` (`project_id` ASC, `id` ASC),
+  INDEX `fk_subscription_initial_payment_id_idx` (`initial_payment_id` ASC),
+  CONSTRAINT `fk_subscription_initial_payment_id`
+    FOREIGN KEY (`initial_payment_id`)
+    REFERENCES `payment` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `subscription_status`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `subscription_status` ;
+
+CREATE TABLE IF NOT EXISTS `subscription_status` (
+  `subscription_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `cycle` INT UNSIGNED NOT NULL,
+  `next_charge` BIGINT UNSIGNED NOT NULL,
+  `failures` SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  `payment_id` BIGINT UNSIGNED NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `comment` TEXT NULL,
+  PRIMARY KEY (`subscription_id`, `timestamp`),
+  INDEX `status_next_charge` (`status` ASC, `next_charge` ASC),
+  CONSTRAINT `fk_subscription_status_subscription_id`
+    FOREIGN KEY (`subscription_id`)
+    REFERENCES `subscription` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
 SET SQL_MODE=@OLD_SQL_MODE;
 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
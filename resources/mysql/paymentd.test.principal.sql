@@ -100,6 +100,7 @@ CREATE TABLE IF NOT EXISTS `project_key` (
   `created_by` VARCHAR(64) NOT NULL,
   `secret` TEXT NOT NULL,
   `active` TINYINT(1) NOT NULL,
+  `signature_mode` VARCHAR(32) NULL,
   PRIMARY KEY (`key`, `timestamp`),
   INDEX `fk_project_key_project_id_idx` (`project_id` ASC),
   CONSTRAINT `fk_project_key_project_id`
@@ -123,6 +124,24 @@ CREATE TABLE IF NOT EXISTS `project_config` (
   `callback_api_version` VARCHAR(32) NULL,
   `callback_project_key` VARCHAR(64) NULL,
   `return_url` TEXT NULL,
+  `callback_events` TEXT NULL,
+  `callback_tls_cert_file` TEXT NULL,
+  `callback_tls_key_file` TEXT NULL,
+  `callback_headers` TEXT NULL,
+  `authorization_buffer` SMALLINT UNSIGNED NULL,
+  `metadata_schema` TEXT NULL,
+  `clock_skew` SMALLINT UNSIGNED NULL,
+  `statement_descriptor` VARCHAR(22) NULL,
+  `sca_policy` TEXT NULL,
+  `checkout_fields` TEXT NULL,
+  `reference_scheme` VARCHAR(128) NULL,
+  `escrow_hold` INT UNSIGNED NULL,
+  `callback_key_overlap` INT UNSIGNED NULL,
+  `callback_rate_limit` INT UNSIGNED NULL,
+  `callback_concurrency` INT UNSIGNED NULL,
+  `metadata_limits` VARCHAR(255) NULL,
+  `method_preferences` TEXT NULL,
+  `payment_expiry` INT UNSIGNED NULL,
   PRIMARY KEY (`project_id`, `timestamp`),
   INDEX `fk_project_config_project_key_idx` (`callback_project_key` ASC),
   CONSTRAINT `fk_project_config_callback_project_key`
@@ -138,6 +157,183 @@ CREATE TABLE IF NOT EXISTS `project_config` (
 ENGINE = InnoDB;
 
 
+-- -----------------------------------------------------
+-- Table `project_domain`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `project_domain` ;
+
+CREATE TABLE IF NOT EXISTS `project_domain` (
+  `project_id` INT UNSIGNED NOT NULL,
+  `domain` VARCHAR(175) NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `challenge` VARCHAR(64) NOT NULL,
+  `verified` TINYINT(1) NOT NULL,
+  `tls_cert_file` TEXT NULL,
+  `tls_key_file` TEXT NULL,
+  PRIMARY KEY (`project_id`, `domain`, `timestamp`),
+  INDEX `domain` (`domain` ASC, `verified` ASC),
+  CONSTRAINT `fk_project_domain_project_id`
+    FOREIGN KEY (`project_id`)
+    REFERENCES `project` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `admin_user`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `admin_user` ;
+
+CREATE TABLE IF NOT EXISTS `admin_user` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `name` VARCHAR(64) NOT NULL,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `name_UNIQUE` (`name` ASC))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `admin_user_config`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `admin_user_config` ;
+
+CREATE TABLE IF NOT EXISTS `admin_user_config` (
+  `user_id` INT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `password` VARCHAR(255) NULL,
+  `active` TINYINT(1) NOT NULL,
+  `roles` TEXT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`user_id`, `timestamp`),
+  CONSTRAINT `fk_admin_user_config_user_id`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `admin_user` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `admin_session`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `admin_session` ;
+
+CREATE TABLE IF NOT EXISTS `admin_session` (
+  `id` VARCHAR(64) NOT NULL,
+  `user_id` INT UNSIGNED NOT NULL,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `expires` BIGINT UNSIGNED NOT NULL,
+  `remote_addr` VARCHAR(64) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_admin_session_user_id_idx` (`user_id` ASC),
+  CONSTRAINT `fk_admin_session_user_id`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `admin_user` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `admin_api_key`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `admin_api_key` ;
+
+CREATE TABLE IF NOT EXISTS `admin_api_key` (
+  `id` VARCHAR(64) NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `user_id` INT UNSIGNED NOT NULL,
+  `name` VARCHAR(64) NOT NULL,
+  `secret` VARCHAR(64) NOT NULL,
+  `active` TINYINT(1) NOT NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  PRIMARY KEY (`id`, `timestamp`),
+  INDEX `fk_admin_api_key_user_id_idx` (`user_id` ASC),
+  CONSTRAINT `fk_admin_api_key_user_id`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `admin_user` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `admin_impersonation`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `admin_impersonation` ;
+
+CREATE TABLE IF NOT EXISTS `admin_impersonation` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `user_id` INT UNSIGNED NOT NULL,
+  `user_name` VARCHAR(64) NOT NULL,
+  `api_key_id` VARCHAR(64) NULL,
+  `project_id` INT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `method` VARCHAR(16) NOT NULL,
+  `path` VARCHAR(255) NOT NULL,
+  `remote_addr` VARCHAR(64) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_admin_impersonation_user_id_idx` (`user_id` ASC),
+  INDEX `admin_impersonation_project_timestamp` (`project_id` ASC, `timestamp` ASC),
+  CONSTRAINT `fk_admin_impersonation_user_id`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `admin_user` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE,
+  CONSTRAINT `fk_admin_impersonation_project_id`
+    FOREIGN KEY (`project_id`)
+    REFERENCES `project` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `onboarding_application`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `onboarding_application` ;
+
+CREATE TABLE IF NOT EXISTS `onboarding_application` (
+  `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `created` BIGINT UNSIGNED NOT NULL,
+  `principal_name` VARCHAR(64) NOT NULL,
+  `project_name` VARCHAR(64) NOT NULL,
+  `company` VARCHAR(128) NOT NULL,
+  `contact_name` VARCHAR(128) NOT NULL,
+  `email` VARCHAR(254) NOT NULL,
+  `web_url` TEXT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `onboarding_application_principal_name` (`principal_name` ASC))
+ENGINE = InnoDB;
+
+
+-- -----------------------------------------------------
+-- Table `onboarding_application_status`
+-- -----------------------------------------------------
+DROP TABLE IF EXISTS `onboarding_application_status` ;
+
+CREATE TABLE IF NOT EXISTS `onboarding_application_status` (
+  `onboarding_application_id` BIGINT UNSIGNED NOT NULL,
+  `timestamp` BIGINT UNSIGNED NOT NULL,
+  `status` VARCHAR(32) NOT NULL,
+  `principal_id` INT UNSIGNED NULL,
+  `project_id` INT UNSIGNED NULL,
+  `created_by` VARCHAR(64) NOT NULL,
+  `comment` TEXT NULL,
+  PRIMARY KEY (`onboarding_application_id`, `timestamp`),
+  INDEX `status` (`status` ASC),
+  CONSTRAINT `fk_onboarding_application_status_onboarding_application_id`
+    FOREIGN KEY (`onboarding_application_id`)
+    REFERENCES `onboarding_application` (`id`)
+    ON DELETE RESTRICT
+    ON UPDATE CASCADE)
+ENGINE = InnoDB;
+
+
 SET SQL_MODE=@OLD_SQL_MODE;
 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;